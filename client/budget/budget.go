@@ -0,0 +1,208 @@
+// Package budget 限制客户端转发占用的资源。路由器、树莓派等小型设备上连接数不受限制时
+// 客户端可能因内存耗尽被系统终止，超过预算时拒绝新的连接，已建立的连接不受影响，
+// 资源压力随批量上报发送到服务端
+package budget
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+)
+
+// 资源压力
+const (
+	PressureNormal   = "normal"   // 各项资源均低于预算的 80%
+	PressureHigh     = "high"     // 有资源超过预算的 80%
+	PressureCritical = "critical" // 有资源达到预算，新连接被拒绝
+)
+
+const (
+	// highWatermark 资源压力为 high 的占用比例
+	highWatermark = 0.8
+	// connOverhead 每个连接除缓冲区以外的估算内存，包括转发协程的栈和套接字
+	connOverhead = 16 * 1024
+	// sampleInterval 采样进程内存占用的间隔，读取内存统计需要暂停程序，不在每次接受连接时读取
+	sampleInterval = 5 * time.Second
+)
+
+// Limits 资源预算，为 0 的项不限制
+type Limits struct {
+	MaxConnections  int   // 所有应用同时转发的连接数
+	MaxGoroutines   int   // 进程的协程数
+	MaxBufferMemory int64 // 转发缓冲区的总大小，单位：字节
+	MaxMemory       int64 // 估算的进程内存占用，单位：字节
+}
+
+// Usage 资源占用和压力，随批量上报发送到服务端
+type Usage struct {
+	Connections    int    `json:"connections"`
+	Goroutines     int    `json:"goroutines"`
+	BufferMemory   int64  `json:"bufferMemory"`
+	MemoryEstimate int64  `json:"memoryEstimate"`
+	Rejected       uint64 `json:"rejected"` // 启动以来因超过预算拒绝的连接数
+	Pressure       string `json:"pressure"`
+}
+
+// Budget 按资源预算对新连接进行准入控制
+type Budget struct {
+	limits      Limits
+	connections int
+	buffers     int64
+	sampled     int64 // 最近一次采样的进程内存占用
+	rejected    uint64
+	rejecting   bool // 正在拒绝连接，恢复时记录日志
+	goroutines  func() int
+	mu          sync.Mutex
+	stopCh      chan struct{}
+	wg          sync.WaitGroup
+}
+
+// New 创建资源预算
+func New(limits Limits) *Budget {
+	return &Budget{
+		limits:     limits,
+		goroutines: runtime.NumGoroutine,
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// Start 定期采样进程的内存占用，未设置内存预算时不采样
+func (b *Budget) Start() {
+	if b.limits.MaxMemory <= 0 {
+		return
+	}
+	b.sample()
+	b.wg.Add(1)
+	go func() {
+		defer b.wg.Done()
+
+		ticker := time.NewTicker(sampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.stopCh:
+				return
+			case <-ticker.C:
+				b.sample()
+			}
+		}
+	}()
+}
+
+// Stop 停止采样
+func (b *Budget) Stop() {
+	close(b.stopCh)
+	b.wg.Wait()
+}
+
+// sample 采样进程的内存占用
+func (b *Budget) sample() {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	b.mu.Lock()
+	b.sampled = int64(stats.HeapInuse + stats.StackInuse)
+	b.mu.Unlock()
+}
+
+// Acquire 为新连接申请预算，buffer 为连接使用的缓冲区大小。超过预算时返回错误，
+// 否则返回连接结束时调用的释放函数
+func (b *Budget) Acquire(buffer int) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.admit(int64(buffer)); err != nil {
+		b.rejected++
+		if !b.rejecting {
+			b.rejecting = true
+			logger.Warn("资源不足，拒绝新的连接: %v", err)
+		}
+		return nil, err
+	}
+	if b.rejecting {
+		b.rejecting = false
+		logger.Info("资源占用已恢复，累计拒绝 %d 个连接", b.rejected)
+	}
+
+	b.connections++
+	b.buffers += int64(buffer)
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			b.mu.Lock()
+			b.connections--
+			b.buffers -= int64(buffer)
+			b.mu.Unlock()
+		})
+	}, nil
+}
+
+// admit 检查接受新连接后是否超过预算。调用方需持有锁
+func (b *Budget) admit(buffer int64) error {
+	if max := b.limits.MaxConnections; max > 0 && b.connections+1 > max {
+		return fmt.Errorf("连接数达到上限 %d", max)
+	}
+	if max := b.limits.MaxGoroutines; max > 0 && b.goroutines() >= max {
+		return fmt.Errorf("协程数达到上限 %d", max)
+	}
+	if max := b.limits.MaxBufferMemory; max > 0 && b.buffers+buffer > max {
+		return fmt.Errorf("缓冲区达到上限 %d 字节", max)
+	}
+	if max := b.limits.MaxMemory; max > 0 && b.estimate()+buffer+connOverhead > max {
+		return fmt.Errorf("估算内存达到上限 %d 字节", max)
+	}
+	return nil
+}
+
+// estimate 估算的进程内存占用：采样的内存占用与连接预留的内存中较大的一个。调用方需持有锁
+func (b *Budget) estimate() int64 {
+	reserved := b.buffers + int64(b.connections)*connOverhead
+	if b.sampled > reserved {
+		return b.sampled
+	}
+	return reserved
+}
+
+// Usage 获取资源占用和压力
+func (b *Budget) Usage() Usage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	usage := Usage{
+		Connections:    b.connections,
+		Goroutines:     b.goroutines(),
+		BufferMemory:   b.buffers,
+		MemoryEstimate: b.estimate(),
+		Rejected:       b.rejected,
+		Pressure:       PressureNormal,
+	}
+
+	ratio := 0.0
+	for _, r := range []float64{
+		fraction(int64(usage.Connections), int64(b.limits.MaxConnections)),
+		fraction(int64(usage.Goroutines), int64(b.limits.MaxGoroutines)),
+		fraction(usage.BufferMemory, b.limits.MaxBufferMemory),
+		fraction(usage.MemoryEstimate, b.limits.MaxMemory),
+	} {
+		if r > ratio {
+			ratio = r
+		}
+	}
+	switch {
+	case ratio >= 1 || b.rejecting:
+		usage.Pressure = PressureCritical
+	case ratio >= highWatermark:
+		usage.Pressure = PressureHigh
+	}
+	return usage
+}
+
+// fraction 占用与预算的比例，未设置预算时为 0
+func fraction(used, max int64) float64 {
+	if max <= 0 {
+		return 0
+	}
+	return float64(used) / float64(max)
+}
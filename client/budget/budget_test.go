@@ -0,0 +1,94 @@
+package budget
+
+import "testing"
+
+func TestBudgetConnections(t *testing.T) {
+	b := New(Limits{MaxConnections: 5})
+
+	var releases []func()
+	for i := 0; i < 5; i++ {
+		release, err := b.Acquire(1024)
+		if err != nil {
+			t.Fatalf("第 %d 个连接被拒绝: %v", i+1, err)
+		}
+		releases = append(releases, release)
+	}
+	if usage := b.Usage(); usage.Pressure != PressureCritical || usage.BufferMemory != 5*1024 {
+		t.Fatalf("资源占用为 %+v", usage)
+	}
+
+	if _, err := b.Acquire(1024); err == nil {
+		t.Fatal("超过连接数上限时应拒绝新连接")
+	}
+	if usage := b.Usage(); usage.Rejected != 1 {
+		t.Fatalf("拒绝的连接数为 %d", usage.Rejected)
+	}
+
+	// 释放函数重复调用只释放一次
+	releases[0]()
+	releases[0]()
+	if usage := b.Usage(); usage.Connections != 4 || usage.Pressure != PressureCritical {
+		t.Fatalf("释放后的资源占用为 %+v", usage)
+	}
+
+	if _, err := b.Acquire(1024); err != nil {
+		t.Fatalf("释放后应接受新连接: %v", err)
+	}
+	releases[1]()
+	if usage := b.Usage(); usage.Pressure != PressureHigh {
+		t.Fatalf("占用 80%% 时压力为 %s", usage.Pressure)
+	}
+}
+
+func TestBudgetMemory(t *testing.T) {
+	b := New(Limits{MaxBufferMemory: 8192, MaxMemory: 1 << 20})
+
+	release, err := b.Acquire(8192)
+	if err != nil {
+		t.Fatalf("申请缓冲区失败: %v", err)
+	}
+	if _, err := b.Acquire(1); err == nil {
+		t.Fatal("超过缓冲区上限时应拒绝新连接")
+	}
+	release()
+
+	// 采样的内存占用超过预算时拒绝新连接
+	b.sampled = 1 << 20
+	if _, err := b.Acquire(1); err == nil {
+		t.Fatal("超过内存上限时应拒绝新连接")
+	}
+	b.sampled = 0
+	if _, err := b.Acquire(1); err != nil {
+		t.Fatalf("内存占用恢复后应接受新连接: %v", err)
+	}
+}
+
+func TestBudgetGoroutines(t *testing.T) {
+	b := New(Limits{MaxGoroutines: 100})
+	b.goroutines = func() int { return 100 }
+	if _, err := b.Acquire(0); err == nil {
+		t.Fatal("协程数达到上限时应拒绝新连接")
+	}
+	b.goroutines = func() int { return 10 }
+	if usage := b.Usage(); usage.Pressure != PressureCritical {
+		t.Fatalf("拒绝连接后、再次接受连接前压力为 %s", usage.Pressure)
+	}
+	if _, err := b.Acquire(0); err != nil {
+		t.Fatalf("协程数恢复后应接受新连接: %v", err)
+	}
+	if usage := b.Usage(); usage.Pressure != PressureNormal {
+		t.Fatalf("压力为 %s", usage.Pressure)
+	}
+}
+
+func TestUnlimited(t *testing.T) {
+	b := New(Limits{})
+	for i := 0; i < 1000; i++ {
+		if _, err := b.Acquire(65536); err != nil {
+			t.Fatalf("未设置预算时不应拒绝连接: %v", err)
+		}
+	}
+	if usage := b.Usage(); usage.Pressure != PressureNormal {
+		t.Fatalf("压力为 %s", usage.Pressure)
+	}
+}
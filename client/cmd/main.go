@@ -1,91 +1,303 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/senma231/p3/client/budget"
 	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/control"
 	"github.com/senma231/p3/client/core"
+	"github.com/senma231/p3/client/endpoint"
+	"github.com/senma231/p3/client/firewall"
+	"github.com/senma231/p3/client/forward"
+	"github.com/senma231/p3/client/health"
+	"github.com/senma231/p3/client/identity"
+	"github.com/senma231/p3/client/inbound"
 	"github.com/senma231/p3/client/nat"
+	"github.com/senma231/p3/client/netem"
 	"github.com/senma231/p3/client/p2p"
+	"github.com/senma231/p3/client/privsep"
+	"github.com/senma231/p3/client/proxy"
 	"github.com/senma231/p3/client/service"
+	"github.com/senma231/p3/client/trace"
+	"github.com/senma231/p3/client/transport"
+	"github.com/senma231/p3/common/lifecycle"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/common/version"
 )
 
 func main() {
-	// 解析命令行参数
-	configPath := flag.String("config", "config.yaml", "配置文件路径")
-	node := flag.String("node", "", "节点名称")
-	token := flag.String("token", "", "认证令牌")
-	daemon := flag.Bool("d", false, "以守护进程模式运行")
-	install := flag.Bool("install", false, "安装为系统服务")
-	uninstall := flag.Bool("uninstall", false, "卸载系统服务")
-	shareBandwidth := flag.Int("sharebandwidth", 10, "共享带宽（Mbps），0表示不共享")
-	flag.Parse()
-
-	// 加载配置
-	cfg, err := config.LoadConfig(*configPath)
+	args := os.Args[1:]
+	command := "run"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "run":
+		run(args)
+	case "install":
+		err = install(args)
+	case "uninstall":
+		err = uninstall(args)
+	case "version":
+		fmt.Println(version.Get())
+	case "export":
+		err = exportRules(args)
+	case "import":
+		err = importRules(args)
+	case "pair":
+		err = pair(args)
+	case "simple":
+		err = runSimple(args)
+	case "nat-report":
+		err = natReport(args)
+	case "help":
+		usage()
+	default:
+		fmt.Fprintf(os.Stderr, "p3-client: 未知命令 %s\n", command)
+		usage()
+		os.Exit(2)
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", command, err)
+	}
+}
+
+// usage 打印用法
+func usage() {
+	fmt.Fprintf(os.Stderr, "用法: p3-client [命令] [参数]\n\n")
+	fmt.Fprintf(os.Stderr, "命令:\n")
+	fmt.Fprintf(os.Stderr, "  run        运行客户端（默认）\n")
+	fmt.Fprintf(os.Stderr, "  install    安装为系统服务\n")
+	fmt.Fprintf(os.Stderr, "  uninstall  卸载系统服务\n")
+	fmt.Fprintf(os.Stderr, "  version    显示版本信息\n")
+	fmt.Fprintf(os.Stderr, "  export     导出应用的转发规则\n")
+	fmt.Fprintf(os.Stderr, "  import     导入转发规则到配置文件\n")
+	fmt.Fprintf(os.Stderr, "  pair       生成或导入简易模式的配对码\n")
+	fmt.Fprintf(os.Stderr, "  simple     以简易模式运行，不连接服务端\n")
+	fmt.Fprintf(os.Stderr, "  nat-report 生成或导入 NAT 穿透测试报告\n\n")
+	fmt.Fprintf(os.Stderr, "使用 p3-client <命令> -h 查看命令的参数\n")
+}
+
+// configFlags 各命令共用的配置参数
+type configFlags struct {
+	path  *string
+	node  *string
+	token *string
+}
+
+// addConfigFlags 添加配置文件、节点名称和认证令牌参数
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	return &configFlags{
+		path:  fs.String("config", "config.yaml", "配置文件路径"),
+		node:  fs.String("node", "", "节点名称"),
+		token: fs.String("token", "", "认证令牌"),
+	}
+}
+
+// load 加载配置，命令行参数覆盖配置文件，requireNode 为 true 时节点名称和令牌不能为空
+func (f *configFlags) load(requireNode bool) *config.Config {
+	cfg, err := config.LoadConfig(*f.path)
 	if err != nil {
 		// 如果配置文件不存在，使用默认配置
 		cfg = config.DefaultConfig()
 	}
 
-	// 命令行参数覆盖配置文件
-	if *node != "" {
-		cfg.Node.ID = *node
+	// 配置文件中的明文令牌迁移到系统密钥环或加密文件
+	if err == nil {
+		if location, err := config.MigrateToken(cfg, *f.path); err != nil {
+			log.Printf("迁移节点令牌失败: %v", err)
+		} else if location != "" {
+			fmt.Printf("节点令牌已从配置文件迁移到%s\n", location)
+		}
 	}
-	if *token != "" {
-		cfg.Node.Token = *token
+
+	if *f.node != "" {
+		cfg.Node.ID = *f.node
 	}
-	if *shareBandwidth >= 0 {
-		// 保存共享带宽设置
-		cfg.Performance.BandwidthLimit.Upload = *shareBandwidth
+	if *f.token != "" {
+		cfg.Node.Token = *f.token
 	}
 
-	// 检查必要参数
-	if cfg.Node.ID == "" {
+	if requireNode && cfg.Node.ID == "" {
 		log.Fatal("节点名称不能为空，请使用 -node 参数指定")
 	}
-	if cfg.Node.Token == "" {
+	if requireNode && cfg.Node.Token == "" {
 		log.Fatal("认证令牌不能为空，请使用 -token 参数指定")
 	}
+	return cfg
+}
+
+// install 安装为系统服务
+func install(args []string) error {
+	fs := flag.NewFlagSet("install", flag.ExitOnError)
+	flags := addConfigFlags(fs)
+	fs.Parse(args)
+	return installService(flags.load(true))
+}
+
+// installService 按配置安装系统服务
+func installService(cfg *config.Config) error {
+	fmt.Println("正在安装系统服务...")
+	if err := service.Install(cfg); err != nil {
+		return fmt.Errorf("安装系统服务失败: %w", err)
+	}
+	fmt.Println("系统服务安装成功")
+	return nil
+}
+
+// uninstall 卸载系统服务
+func uninstall(args []string) error {
+	fs := flag.NewFlagSet("uninstall", flag.ExitOnError)
+	fs.Parse(args)
+
+	fmt.Println("正在卸载系统服务...")
+	if err := service.Uninstall(); err != nil {
+		return fmt.Errorf("卸载系统服务失败: %w", err)
+	}
+	fmt.Println("系统服务卸载成功")
+	return nil
+}
 
-	// 处理安装/卸载命令
-	if *install {
-		fmt.Println("正在安装系统服务...")
-		if err := service.Install(cfg); err != nil {
-			log.Fatalf("安装系统服务失败: %v", err)
+// run 运行客户端，直到收到中断信号或服务端要求重启
+func run(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	flags := addConfigFlags(fs)
+	daemon := fs.Bool("d", false, "以守护进程模式运行")
+	shareBandwidth := fs.Int("sharebandwidth", 10, "共享带宽（Mbps），0表示不共享")
+	privsepHelper := fs.Bool(privsep.HelperFlag, false, "以特权辅助进程模式运行（由客户端自动启动）")
+	emulate := fs.String("emulate", "", "开发模式：模拟网络条件，例如 nat=symmetric,loss=2%,latency=80ms")
+	// 兼容旧版本的参数，与同名命令相同
+	legacyInstall := fs.Bool("install", false, "安装为系统服务，同 install 命令")
+	legacyUninstall := fs.Bool("uninstall", false, "卸载系统服务，同 uninstall 命令")
+	legacyVersion := fs.Bool("version", false, "显示版本信息，同 version 命令")
+	fs.Parse(args)
+
+	if *legacyVersion {
+		fmt.Println(version.Get())
+		return
+	}
+	if *privsepHelper {
+		if err := privsep.Serve(); err != nil {
+			log.Fatalf("特权辅助进程退出: %v", err)
 		}
-		fmt.Println("系统服务安装成功")
 		return
 	}
-	if *uninstall {
-		fmt.Println("正在卸载系统服务...")
-		if err := service.Uninstall(); err != nil {
-			log.Fatalf("卸载系统服务失败: %v", err)
+	if *legacyUninstall {
+		if err := uninstall(nil); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	cfg := flags.load(true)
+	if *shareBandwidth >= 0 {
+		// 保存共享带宽设置
+		cfg.Performance.BandwidthLimit.Upload = *shareBandwidth
+	}
+
+	if *legacyInstall {
+		if err := installService(cfg); err != nil {
+			log.Fatal(err)
 		}
-		fmt.Println("系统服务卸载成功")
 		return
 	}
 
+	// 服务端要求重启时以非零状态退出，由 systemd 或 launchd 重新启动。
+	// 最先注册，其他清理函数执行完毕后才退出
+	exitCode := 0
+	defer func() {
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+	}()
+
+	// 组件按启动顺序记录，退出时按相反顺序停止：先停止上报和转发，最后释放监听器和特权辅助进程
+	runner := lifecycle.New(10 * time.Second)
+	fatalf := func(format string, args ...interface{}) {
+		runner.Stop()
+		log.Fatalf(format, args...)
+	}
+
+	// 设置日志级别，配置已校验过模块的日志级别
+	logger.SetLevel(logger.ParseLevel(cfg.Logging.Level))
+	moduleLevels, _ := logger.ParseModuleLevels(cfg.Logging.Modules)
+	logger.SetModuleLevels(moduleLevels)
+
 	// 打印启动信息
 	fmt.Println("P3 客户端启动中...")
+	fmt.Printf("版本: %s\n", version.Get())
 	fmt.Printf("节点 ID: %s\n", cfg.Node.ID)
 	fmt.Printf("服务器地址: %s\n", cfg.Server.Address)
 	fmt.Printf("共享带宽: %d Mbps\n", cfg.Performance.BandwidthLimit.Upload)
 
+	// 权限分离：由特权辅助进程绑定低端口，主进程降为普通用户运行
+	var helper *privsep.Client
+	if cfg.Privilege.Separate {
+		ports := cfg.Privilege.Ports
+		if len(ports) == 0 {
+			for _, app := range cfg.Apps {
+				ports = append(ports, app.SrcPort)
+			}
+		}
+		var err error
+		helper, err = privsep.Start(privsep.PrivilegedPorts(ports))
+		if err != nil {
+			log.Fatalf("启动特权辅助进程失败: %v", err)
+		}
+		runner.Start(lifecycle.Component{Name: "特权辅助进程", Stop: lifecycle.StopErrFunc(helper.Close)})
+		if err := privsep.DropPrivileges(cfg.Privilege.User); err != nil {
+			fatalf("降低权限失败: %v", err)
+		}
+		fmt.Printf("已降为用户 %s 运行\n", cfg.Privilege.User)
+	}
+
+	// UPnP 映射描述带上节点 ID，并限制外部端口范围
+	nat.SetMappingPolicy(nat.MappingPolicy{
+		NodeID:  cfg.Node.ID,
+		PortMin: cfg.Network.UPnPPortMin,
+		PortMax: cfg.Network.UPnPPortMax,
+	})
+	if cfg.Network.EnableUPnP {
+		// 清理上次运行异常退出时遗留的映射
+		removed, err := nat.NewUPnPClient(5 * time.Second).CleanupOrphanedMappings(cfg.Node.ID)
+		if err != nil {
+			log.Printf("清理遗留的 UPnP 端口映射失败: %v", err)
+		}
+		for _, m := range removed {
+			fmt.Printf("已删除遗留的 UPnP 端口映射: %s/%d (%s)\n", m.Protocol, m.ExternalPort, m.Description)
+		}
+	}
+
+	// 创建 STUN 服务器池，定期检查各服务器的可用性和延迟，连续失败的服务器暂停使用
+	stunPool := nat.NewSTUNPool(cfg.STUNServerList(), 5*time.Second, time.Duration(cfg.Network.STUNCooldown)*time.Second)
+	runner.Start(lifecycle.Component{
+		Name:  "STUN 服务器池",
+		Start: lifecycle.StartFunc(func() { stunPool.Start(time.Duration(cfg.Network.STUNCheckInterval) * time.Second) }),
+		Stop:  lifecycle.StopFunc(stunPool.Stop),
+	})
+
 	// 检测 NAT 类型
-	detector := nat.NewDetector(cfg.Network.STUNServers, 5*time.Second)
+	detector := nat.NewDetector(cfg.STUNServerList(), 5*time.Second)
+	detector.Pool = stunPool
 	natInfo, err := detector.Detect()
 	if err != nil {
 		log.Printf("NAT 类型检测失败: %v", err)
 		// 创建一个默认的 NAT 信息
 		natInfo = &nat.NATInfo{
-			Type:          nat.NATUnknown,
+			Type:          protocol.NATUnknown,
 			ExternalIP:    nil,
 			ExternalPort:  0,
 			UPnPAvailable: false,
@@ -97,10 +309,53 @@ func main() {
 		fmt.Printf("UPnP 可用: %t\n", natInfo.UPnPAvailable)
 	}
 
+	// 开发模式：对等连接经过网络模拟器，并以模拟的 NAT 类型向服务端报告
+	var emulator *netem.Emulator
+	if *emulate != "" {
+		emulateConfig, err := netem.Parse(*emulate)
+		if err != nil {
+			fatalf("网络模拟参数无效: %v", err)
+		}
+		emulator = netem.New(transport.NewSystem(cfg.Network.Interfaces.Policy()), emulateConfig)
+		if emulateConfig.NAT != protocol.NATUnknown {
+			natInfo.Type = emulateConfig.NAT
+			natInfo.UPnPAvailable = false
+		}
+		fmt.Printf("已启用网络模拟: %s，NAT 类型按 %s 报告\n", emulateConfig, natInfo.Type)
+	}
+
+	// 出站连接使用的代理，未配置时使用环境变量
+	outboundProxy, err := proxy.New(cfg.Network.Proxy, cfg.Network.NoProxy)
+	if err != nil {
+		fatalf("代理配置无效: %v", err)
+	}
+
+	// 创建服务器端点池，定期检查各服务器的健康状态和延迟
+	endpoints := endpoint.NewPool(cfg.ServerEndpoints(), 5*time.Second)
+	endpoints.SetProxy(outboundProxy)
+	runner.Start(lifecycle.Component{
+		Name:  "服务器端点池",
+		Start: lifecycle.StartFunc(func() { endpoints.Start(time.Duration(cfg.Server.HealthInterval) * time.Second) }),
+		Stop:  lifecycle.StopFunc(endpoints.Stop),
+	})
+
+	// 加载设备证书，持有证书时使用证书代替令牌认证
+	var deviceIdentity *identity.Identity
+	if cfg.Security.DeviceCertificate {
+		deviceIdentity, err = identity.Load(cfg.Security.CertFile, cfg.Security.KeyFile, cfg.Security.CAFile)
+		if err != nil {
+			log.Printf("加载设备证书失败，使用令牌认证: %v", err)
+		}
+	}
+
 	// 创建信令客户端
 	signalingClient := p2p.NewSignalingClient(cfg, natInfo)
+	signalingClient.SetEndpointPool(endpoints)
+	signalingClient.SetProxy(outboundProxy)
+	signalingClient.SetIdentity(deviceIdentity)
 
-	// 连接到信令服务器
+	// 连接到信令服务器，连接失败时之后仍可能重连，退出时总是断开
+	runner.Start(lifecycle.Component{Name: "信令客户端", Stop: lifecycle.StopErrFunc(signalingClient.Disconnect)})
 	if err := signalingClient.Connect(); err != nil {
 		log.Printf("连接到信令服务器失败: %v", err)
 	} else {
@@ -109,6 +364,16 @@ func main() {
 
 	// 创建 P2P 连接器
 	connector := p2p.NewConnector(cfg, natInfo, signalingClient)
+	connector.SetProxy(outboundProxy)
+	connector.SetIdentity(deviceIdentity)
+	if emulator != nil {
+		connector.SetTransport(emulator)
+	}
+	// 同一 NAT 之后的对端通过局域网地址连接本节点
+	if err := connector.ListenLAN(); err != nil {
+		log.Printf("%v，同一局域网内的节点将通过外部地址连接", err)
+	}
+	runner.Start(lifecycle.Component{Name: "P2P 连接器", Stop: lifecycle.StopErrFunc(connector.Close)})
 
 	// 创建引擎
 	engine := core.NewEngine(cfg)
@@ -117,8 +382,488 @@ func main() {
 	engine.SetConnector(connector)
 
 	// 启动引擎
-	if err := engine.Start(); err != nil {
-		log.Fatalf("启动引擎失败: %v", err)
+	if err := runner.Start(lifecycle.Component{
+		Name:  "P2P 引擎",
+		Start: engine.Start,
+		Stop:  lifecycle.StopErrFunc(engine.Stop),
+	}); err != nil {
+		fatalf("%v", err)
+	}
+
+	// 加载运行时状态，与服务端下发的应用配置合并后恢复转发
+	stateStore := forward.NewStateStore(cfg.StateFile)
+	if err := stateStore.Load(); err != nil {
+		log.Printf("加载运行时状态失败: %v", err)
+	}
+	// 所有转发停止后标记正常退出，手动启停状态保留到下次启动
+	runner.Start(lifecycle.Component{
+		Name: "运行时状态",
+		Stop: func(context.Context) error { return stateStore.SetRunning(false) },
+	})
+	forwarders := forward.NewForwarderManager()
+	forwarders.SetStateStore(stateStore)
+
+	// 由 systemd 套接字激活时，转发器直接使用传入的监听器
+	activated, err := service.Activate()
+	if err != nil {
+		log.Printf("获取套接字激活的监听器失败: %v", err)
+	}
+	forwarders.SetListenerProvider(activated.Take)
+	runner.Start(lifecycle.Component{Name: "套接字激活", Stop: lifecycle.StopFunc(activated.Close)})
+	if helper != nil {
+		forwarders.SetListenFunc(helper.Listen)
+	}
+	if cfg.Network.ManageFirewall {
+		if fw := firewall.New(); fw.Supported() {
+			forwarders.SetFirewall(fw)
+		} else {
+			log.Printf("当前平台不支持自动添加防火墙规则")
+		}
+	}
+
+	// 按应用的入站连接监控记录来源或时间不符合预期的连接，随状态上报发送到服务端
+	reputation, err := inbound.LoadReputation(cfg.Security.Reputation, cfg.Security.ReputationFile)
+	if err != nil {
+		log.Printf("加载来源地址信誉列表失败: %v", err)
+		reputation, _ = inbound.LoadReputation(cfg.Security.Reputation, "")
+	}
+	inboundMonitor := inbound.NewMonitor(reputation)
+	forwarders.SetInboundMonitor(inboundMonitor)
+
+	serverClient := core.NewServerClient(cfg, natInfo)
+	serverClient.SetEndpointPool(endpoints)
+	serverClient.SetProxy(outboundProxy)
+	serverClient.SetIdentity(deviceIdentity)
+
+	// 通过信令建立到对等节点的连接，返回连接和连接方式
+	dialPeer := func(peerID string) (net.Conn, protocol.ConnectionType, error) {
+		result, err := connector.Connect(peerID)
+		if err != nil {
+			return nil, protocol.ConnectionUnknown, err
+		}
+		if !result.Success {
+			return nil, result.ConnectionType, result.Error
+		}
+		return result.Conn, result.ConnectionType, nil
+	}
+
+	openPeer := func(peerID string) (net.Conn, error) {
+		conn, _, err := dialPeer(peerID)
+		return conn, err
+	}
+	viaPeer := func(nodeID string) core.DialFunc {
+		return core.DialViaPeer(openPeer, nodeID)
+	}
+
+	// 向服务端同步本节点通告的子网，接受路由时按心跳间隔更新可以访问的子网路由表
+	var routeTable *core.RouteTable
+	if cfg.Network.AcceptRoutes {
+		routeTable = core.NewRouteTable()
+	}
+	routeSync := core.NewRouteSync(serverClient.SyncRoutes, serverClient.GetRoutes, routeTable, time.Duration(cfg.Server.HeartbeatInterval)*time.Second)
+	routeSync.SetAdvertised(cfg.Network.AdvertiseRoutes)
+	runner.Start(lifecycle.Component{
+		Name:  "子网路由",
+		Start: lifecycle.StartFunc(routeSync.Start),
+		Stop:  lifecycle.StopFunc(routeSync.Stop),
+	})
+
+	// 为对端转发到本节点通告子网的 TCP 连接，作为出口节点时还为经服务端授权的对端转发其他目标
+	if err := serverClient.SetExitNodeAdvertised(cfg.ExitNode.Advertise); err != nil {
+		log.Printf("%v", err)
+	}
+	var exitClient func(string) error
+	if cfg.ExitNode.Advertise {
+		exitClient = serverClient.AuthorizeExitNodeClient
+	}
+	gateway := core.NewPeerGateway(core.GatewayAuthorizer(routeSync.Advertised, serverClient.GetRouteClient, exitClient), nil)
+	connector.OnIncoming(gateway.HandleConn)
+	runner.Start(lifecycle.Component{Name: "对端转发", Stop: lifecycle.StopFunc(gateway.Wait)})
+
+	// 应用连接目标在对端通告的子网中时经通告节点转发；使用出口节点时其他目标按出口节点路由转发，
+	// 出口节点不可用时按断网保护阻断或回落到直连
+	exitRouter, err := core.NewExitNodeRouter(cfg.ExitNode)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	dial := core.DialFunc((&net.Dialer{}).DialContext)
+	if exitRouter.Enabled() {
+		dial = exitRouter.Dialer(dial, viaPeer(exitRouter.NodeID()))
+		exitMonitor := core.NewExitNodeMonitor(exitRouter, serverClient.GetExitNodes, time.Duration(cfg.Server.HeartbeatInterval)*time.Second)
+		runner.Start(lifecycle.Component{
+			Name:  "出口节点状态",
+			Start: lifecycle.StartFunc(exitMonitor.Start),
+			Stop:  lifecycle.StopFunc(exitMonitor.Stop),
+		})
+	}
+	if routeTable != nil {
+		dial = routeTable.Dialer(dial, viaPeer)
+	}
+	if exitRouter.Enabled() || routeTable != nil {
+		forwarders.SetDialFunc(forward.DialFunc(dial))
+	}
+
+	// 申请和轮换设备证书，证书被吊销后重新申请
+	if deviceIdentity != nil {
+		certificates := core.NewCertificateManager(serverClient, deviceIdentity)
+		runner.Start(lifecycle.Component{
+			Name:  "设备证书",
+			Start: lifecycle.StartFunc(certificates.Start),
+			Stop:  lifecycle.StopFunc(certificates.Stop),
+		})
+	}
+
+	// 记录每次连接对等节点的尝试过程，供 p3ctl explain 查看，按配置上报到服务端
+	traceStore := trace.NewStore(cfg.Trace.File, cfg.Trace.PerPeer)
+	if err := traceStore.Load(); err != nil {
+		log.Printf("加载连接记录失败: %v", err)
+	}
+	engine.SetTraceStore(traceStore)
+	if cfg.Trace.Report {
+		engine.SetTraceReporter(func(t *trace.Trace) {
+			if err := serverClient.ReportTrace(t); err != nil {
+				log.Printf("上报连接记录失败: %v", err)
+			}
+		})
+	}
+
+	// 检查服务端版本，提示更新和兼容性问题
+	if serverVersion, err := serverClient.GetServerVersion(); err != nil {
+		log.Printf("获取服务端版本失败: %v", err)
+	} else {
+		for _, warning := range core.CheckServerVersion(serverVersion) {
+			log.Printf("版本检查: %s", warning)
+		}
+	}
+	// 应用启动后检查目标服务是否可用，状态变化时按顺序上报到服务端
+	healthResults := make(chan health.Result, 64)
+	healthMonitor := health.NewMonitor(func(r health.Result) {
+		select {
+		case healthResults <- r:
+		default:
+			log.Printf("应用健康状态上报队列已满，丢弃应用 %s 的状态 %s", r.App, r.Status)
+		}
+	})
+	go func() {
+		for r := range healthResults {
+			log.Printf("应用 %s 状态: %s %s", r.App, r.Status, r.Detail)
+			if err := serverClient.ReportAppHealth([]health.Result{r}); err != nil {
+				log.Printf("上报应用健康状态失败: %v", err)
+			}
+		}
+	}()
+	forwarders.SetHealthMonitor(healthMonitor)
+	runner.Start(lifecycle.Component{Name: "应用健康检查", Stop: lifecycle.StopFunc(healthMonitor.Stop)})
+
+	// 监听器异常退出的转发器按退避间隔自动重启
+	forwarders.SetRestartPolicy(forward.RestartPolicy{
+		InitialBackoff: time.Duration(cfg.Restart.InitialBackoff) * time.Second,
+		MaxBackoff:     time.Duration(cfg.Restart.MaxBackoff) * time.Second,
+		MaxRestarts:    cfg.Restart.MaxRestarts,
+	})
+	// 应用端口或目标地址变化时平滑更新，已建立的连接按旧规则排空
+	forwarders.SetDrainTimeout(time.Duration(cfg.Performance.DrainTimeout) * time.Second)
+	// 所有应用的新连接共用资源预算，超过时拒绝新连接，避免小型设备内存耗尽
+	resourceBudget := budget.New(cfg.Performance.Budget())
+	forwarders.SetBudget(resourceBudget)
+	runner.Start(lifecycle.Component{
+		Name:  "资源预算",
+		Start: lifecycle.StartFunc(resourceBudget.Start),
+		Stop:  lifecycle.StopFunc(resourceBudget.Stop),
+	})
+
+	// 应用配置缓存在本地，只向服务端获取上次同步之后的变化
+	appSync := core.NewAppSync(serverClient, cfg.Node.ID, cfg.AppsCacheFile)
+	if err := appSync.Load(); err != nil {
+		log.Printf("加载应用配置缓存失败: %v", err)
+	}
+	// 对端在线状态保存在本地，服务端不可用时按离线策略使用
+	offlineStore := core.NewOfflineStore(cfg.Node.ID, cfg.Offline.File)
+	if err := offlineStore.Load(); err != nil {
+		log.Printf("加载离线状态失败: %v", err)
+	}
+	apps, err := appSync.Sync()
+	offline := err != nil
+	if offline {
+		var detail string
+		apps, detail = core.OfflineApps(cfg, appSync, time.Now())
+		log.Printf("获取应用配置失败，%s: %v", detail, err)
+	} else {
+		apps = cfg.MergeAppSettings(apps)
+	}
+	// 以 Sidecar 运行时，Kubernetes Service 映射的应用与服务端下发的应用一起启动
+	apps = cfg.WithKubernetesApps(apps)
+	// 本地配置的应用可以用别名或设备名称指定对端，由服务端解析为节点 ID；离线时无法解析，按原值使用
+	peerResolver := core.NewPeerResolver(serverClient)
+	if !offline {
+		apps = peerResolver.ResolveApps(apps)
+	}
+
+	// 订阅应用对端节点的在线状态，配置了 startWhenPeerOnline 的应用随对端上线和离线启停。
+	// 尚未收到信令服务器的状态时，cached 策略使用最近一次的在线状态
+	forwarders.SetPeerPresence(func(nodeID string) (bool, bool) {
+		if online, known := signalingClient.PeerOnline(nodeID); known {
+			return online, known
+		}
+		if cfg.Offline.Policy == config.OfflineCached {
+			return offlineStore.PeerOnline(nodeID)
+		}
+		return false, false
+	})
+	signalingClient.OnPresence(func(nodeID string, online bool) {
+		if online {
+			log.Printf("对端节点 %s 已上线", nodeID)
+		} else {
+			log.Printf("对端节点 %s 已离线", nodeID)
+		}
+		offlineStore.SetPeerOnline(nodeID, online)
+		forwarders.PeerPresenceChanged(nodeID, online)
+	})
+	for _, app := range apps {
+		signalingClient.Subscribe(app.PeerNode)
+	}
+	runner.Start(lifecycle.Component{Name: "应用转发", Stop: lifecycle.StopErrFunc(forwarders.StopAll)})
+	if events := forwarders.Reconcile(apps, cfg.Performance.BufferSize); len(events) > 0 {
+		for _, event := range events {
+			log.Printf("恢复事件: %s %s %s", event.Type, event.App, event.Detail)
+		}
+		if err := serverClient.ReportRecoveryEvents(events); err != nil {
+			log.Printf("上报恢复事件失败: %v", err)
+		}
+	}
+
+	// applyApps 按服务端下发的应用配置对账，启停和更新应用并上报恢复事件
+	applyApps := func(apps []config.AppConfig) {
+		apps = peerResolver.ResolveApps(cfg.WithKubernetesApps(cfg.MergeAppSettings(apps)))
+		for _, app := range apps {
+			signalingClient.Subscribe(app.PeerNode)
+		}
+		if events := forwarders.Reconcile(apps, cfg.Performance.BufferSize); len(events) > 0 {
+			for _, event := range events {
+				log.Printf("恢复事件: %s %s %s", event.Type, event.App, event.Detail)
+			}
+			if err := serverClient.ReportRecoveryEvents(events); err != nil {
+				log.Printf("上报恢复事件失败: %v", err)
+			}
+		}
+	}
+
+	// 启动时无法获取应用配置则按间隔重试，恢复连接后与服务端对账
+	offlineSync := core.NewOfflineSync(appSync, time.Duration(cfg.Offline.RetryInterval)*time.Second, applyApps)
+	runner.Start(lifecycle.Component{
+		Name:  "离线同步",
+		Start: lifecycle.StartFunc(offlineSync.Start),
+		Stop:  lifecycle.StopFunc(offlineSync.Stop),
+	})
+	if offline {
+		offlineSync.MarkOffline()
+	}
+
+	// 按心跳间隔批量上报设备状态、应用流量统计和连接摘要
+	reporter := core.NewReporter(serverClient, engine, forwarders, time.Duration(cfg.Server.HeartbeatInterval)*time.Second)
+	reporter.SetInboundMonitor(inboundMonitor)
+	reporter.SetBudget(resourceBudget)
+	if cfg.Network.SelfTestInterval > 0 {
+		// 定期检查到服务端回显探测服务的出站连通性和延迟，结果随上报发送
+		selfTester := core.NewSelfTester(serverClient, stunPool.Servers, time.Duration(cfg.Network.SelfTestInterval)*time.Second)
+		reporter.SetSelfTester(selfTester)
+		runner.Start(lifecycle.Component{
+			Name:  "连通性自检",
+			Start: lifecycle.StartFunc(selfTester.Start),
+			Stop:  lifecycle.StopFunc(selfTester.Stop),
+		})
+	}
+	runner.Start(lifecycle.Component{
+		Name:  "状态上报",
+		Start: lifecycle.StartFunc(reporter.Start),
+		Stop:  lifecycle.StopFunc(reporter.Stop),
+	})
+
+	// 执行服务端通过信令下发的批量操作
+	restartCh := make(chan struct{}, 1)
+	fleetHandler := core.NewFleetHandler(serverClient)
+	fleetHandler.Handle(core.FleetSetLogLevel, func(params map[string]string) error {
+		if module := params["module"]; module != "" {
+			return logger.ApplyLevels("", map[string]string{module: params["level"]})
+		}
+		return logger.ApplyLevels(params["level"], nil)
+	})
+	fleetHandler.Handle(core.FleetNATDetect, func(map[string]string) error {
+		detected, err := detector.Detect()
+		if err != nil {
+			return err
+		}
+		*natInfo = *detected
+		log.Printf("NAT 类型: %s", natInfo.Type)
+		return nil
+	})
+	fleetHandler.Handle(core.FleetPushConfig, func(map[string]string) error {
+		// 重新读取配置文件中通告的子网，变化后同步到服务端
+		if reloaded, err := config.LoadConfig(*flags.path); err != nil {
+			log.Printf("重新读取配置文件失败: %v", err)
+		} else {
+			routeSync.SetAdvertised(reloaded.Network.AdvertiseRoutes)
+		}
+		apps, err := appSync.Sync()
+		if err != nil {
+			return err
+		}
+		applyApps(apps)
+		return nil
+	})
+	fleetHandler.Handle(core.FleetRestart, func(map[string]string) error {
+		select {
+		case restartCh <- struct{}{}:
+		default:
+		}
+		return nil
+	})
+	signalingClient.RegisterHandler(protocol.SignalFleetCommand, fleetHandler.HandleSignal)
+	// 服务端的欢迎消息下发默认的连接优先级
+	signalingClient.RegisterHandler(protocol.SignalPing, engine.HandleWelcome)
+	// 服务端的欢迎消息和 stun-servers 信令下发 STUN 服务器列表
+	signalingClient.RegisterHandler(protocol.SignalPing, stunPool.HandleSignal)
+	signalingClient.RegisterHandler(protocol.SignalSTUNServers, stunPool.HandleSignal)
+	// 退出时等待正在执行的批量操作回报结果
+	runner.Start(lifecycle.Component{Name: "批量操作", Stop: lifecycle.StopFunc(fleetHandler.Wait)})
+
+	// 处理控制台发起的端口扫描，只扫描本机或局域网中的地址
+	portScanner := core.NewPortScanner(serverClient)
+	signalingClient.RegisterHandler(protocol.SignalPortScan, portScanner.HandleSignal)
+	runner.Start(lifecycle.Component{Name: "端口扫描", Stop: lifecycle.StopFunc(portScanner.Wait)})
+
+	// 执行服务端计划的测速，发起方连接对端测量延迟和吞吐量并上报结果，接收方等待对端连接
+	speedTester := core.NewSpeedTester(serverClient, dialPeer,
+		func(peerID string) (net.Conn, error) {
+			result, err := connector.Accept(peerID, 30*time.Second)
+			if err != nil {
+				return nil, err
+			}
+			if !result.Success {
+				return nil, result.Error
+			}
+			return result.Conn, nil
+		})
+	signalingClient.RegisterHandler(protocol.SignalSpeedTest, speedTester.HandleSignal)
+	runner.Start(lifecycle.Component{Name: "测速", Stop: lifecycle.StopFunc(speedTester.Wait)})
+
+	// 本地控制接口，浏览器打开后查看诊断页面
+	if cfg.Control.Address != "" {
+		controlServer := control.NewServer(cfg.Control.Address, control.Source{
+			Status: func() control.Status {
+				status := control.Status{
+					NodeID:       cfg.Node.ID,
+					Version:      version.Get().Version,
+					NATType:      natInfo.Type.String(),
+					ExternalPort: natInfo.ExternalPort,
+					UPnP:         natInfo.UPnPAvailable,
+					Signaling:    signalingClient.IsConnected(),
+
+					SignalingQueues: signalingClient.QueueStats(),
+				}
+				if natInfo.ExternalIP != nil {
+					status.ExternalIP = natInfo.ExternalIP.String()
+				}
+				return status
+			},
+			Connections: func() interface{} { return engine.ConnectionSummaries() },
+			Apps:        func() interface{} { return forwarders.Stats() },
+			UPnPMappings: func() (interface{}, error) {
+				if !cfg.Network.EnableUPnP {
+					return nil, fmt.Errorf("未启用 UPnP")
+				}
+				return nat.NewUPnPClient(5 * time.Second).ListPortMappings(false)
+			},
+			Destinations: func(app string, limit int, sortBy string) (interface{}, error) {
+				return forwarders.TopDestinations(app, limit, sortBy)
+			},
+			ResolvePeer: func(name string) (interface{}, error) {
+				return peerResolver.Resolve(name)
+			},
+			UpdateApp: func(update control.AppUpdate) error {
+				forwarder, err := forwarders.GetForwarder(update.Name)
+				if err != nil {
+					return err
+				}
+				app := forwarder.Config()
+				if update.Bind != nil {
+					app.Bind = *update.Bind
+				}
+				if update.SrcPort != nil {
+					app.SrcPort = *update.SrcPort
+				}
+				if update.DstHost != nil {
+					app.DstHost = *update.DstHost
+				}
+				if update.DstPort != nil {
+					app.DstPort = *update.DstPort
+				}
+				if update.Description != nil {
+					app.Description = *update.Description
+				}
+				return forwarders.UpdateForwarder(&app)
+			},
+			Checks: []control.Check{
+				{Name: "NAT 类型检测", Run: func() (string, error) {
+					detected, err := detector.Detect()
+					if err != nil {
+						return "", err
+					}
+					*natInfo = *detected
+					return fmt.Sprintf("%s，外部地址 %s:%d", natInfo.Type, natInfo.ExternalIP, natInfo.ExternalPort), nil
+				}},
+				{Name: "服务器连接", Run: func() (string, error) {
+					info, err := serverClient.GetServerVersion()
+					if err != nil {
+						return "", err
+					}
+					return "服务端版本 " + info.Version, nil
+				}},
+				{Name: "信令服务器", Run: func() (string, error) {
+					if !signalingClient.IsConnected() {
+						return "", fmt.Errorf("未连接")
+					}
+					return "已连接", nil
+				}},
+				{Name: "中继服务器", Run: func() (string, error) {
+					relay, err := serverClient.GetRelayServer()
+					if err != nil {
+						return "", err
+					}
+					return relay, nil
+				}},
+			},
+		})
+		runner.Start(lifecycle.Component{
+			Name: "本地控制接口",
+			Start: func() error {
+				if err := controlServer.Start(); err != nil {
+					return err
+				}
+				fmt.Printf("诊断页面: http://%s\n", controlServer.Addr())
+				return nil
+			},
+			Stop:     lifecycle.StopErrFunc(controlServer.Stop),
+			Optional: true,
+		})
+	}
+
+	// 存活和就绪探针，信令服务器已连接且所有应用的隧道建立后才就绪
+	if cfg.Kubernetes.ProbeAddress != "" {
+		probeServer := control.NewProbeServer(cfg.Kubernetes.ProbeAddress, func() error {
+			if !signalingClient.IsConnected() {
+				return fmt.Errorf("信令服务器未连接")
+			}
+			if unready := forwarders.Unready(); len(unready) > 0 {
+				return fmt.Errorf("应用未就绪: %s", strings.Join(unready, ", "))
+			}
+			return nil
+		})
+		runner.Start(lifecycle.Component{
+			Name:  "探针",
+			Start: probeServer.Start,
+			Stop:  lifecycle.StopErrFunc(probeServer.Stop),
+		})
 	}
 
 	// 如果是守护进程模式，启动监控
@@ -127,22 +872,35 @@ func main() {
 		// TODO: 实现守护进程逻辑
 	}
 
+	// 通知 systemd 启动完成，并定期发送看门狗心跳
+	if _, err := service.Notify("READY=1"); err != nil {
+		log.Printf("发送 systemd 就绪通知失败: %v", err)
+	}
+	var stopWatchdog func()
+	runner.Start(lifecycle.Component{
+		Name:  "systemd 看门狗",
+		Start: lifecycle.StartFunc(func() { stopWatchdog = service.StartWatchdog(nil) }),
+		Stop: func(context.Context) error {
+			stopWatchdog()
+			_, err := service.Notify("STOPPING=1")
+			return err
+		},
+	})
+
 	// 等待中断信号
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	select {
+	case <-quit:
+	case <-restartCh:
+		fmt.Println("服务端要求重启客户端")
+		exitCode = 1
+	}
 
 	// 优雅关闭
 	fmt.Println("正在关闭客户端...")
-
-	// 断开与信令服务器的连接
-	if err := signalingClient.Disconnect(); err != nil {
-		log.Printf("断开与信令服务器的连接失败: %v", err)
-	}
-
-	// 关闭引擎
-	if err := engine.Stop(); err != nil {
-		log.Printf("关闭引擎失败: %v", err)
+	if err := runner.Stop(); err != nil {
+		log.Printf("关闭客户端时出错: %v", err)
 	}
 
 	fmt.Println("客户端已关闭")
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/senma231/p3/client/core"
+	"github.com/senma231/p3/client/nat"
+	"github.com/senma231/p3/client/proxy"
+	"github.com/senma231/p3/common/version"
+)
+
+// natReport 生成 NAT 穿透测试报告，或导入他人生成的报告并显示摘要。
+// 报告包含各 STUN 服务器的探测结果、映射和过滤行为、服务端回显探测和 UPnP 状态，供提交技术支持
+func natReport(args []string) error {
+	fs := flag.NewFlagSet("nat-report", flag.ExitOnError)
+	flags := addConfigFlags(fs)
+	output := fs.String("o", "-", "报告文件路径，- 表示输出到标准输出")
+	input := fs.String("i", "", "导入报告文件并显示摘要，不进行测试")
+	probe := fs.String("probe", "", "服务端回显探测服务的 UDP 地址（host:port），为空时向服务端查询")
+	tcpProbe := fs.String("tcp-probe", "", "服务端回显探测服务的 TCP 地址（host:port），为空时向服务端查询")
+	upnp := fs.Bool("upnp", true, "检测网关的 UPnP 支持")
+	timeout := fs.Duration("timeout", 3*time.Second, "每个探测的超时")
+	fs.Parse(args)
+
+	if *input != "" {
+		data, err := os.ReadFile(*input)
+		if err != nil {
+			return fmt.Errorf("读取报告失败: %w", err)
+		}
+		var report nat.Report
+		if err := json.Unmarshal(data, &report); err != nil {
+			return fmt.Errorf("解析报告失败: %w", err)
+		}
+		if report.Version > nat.ReportVersion {
+			fmt.Fprintf(os.Stderr, "报告格式版本 %d 比当前客户端支持的 %d 新，部分内容可能无法显示\n", report.Version, nat.ReportVersion)
+		}
+		fmt.Print(report.Summary())
+		return nil
+	}
+
+	cfg := flags.load(false)
+	if *probe == "" && *tcpProbe == "" && cfg.Server.Address != "" {
+		outboundProxy, err := proxy.New(cfg.Network.Proxy, cfg.Network.NoProxy)
+		if err != nil {
+			return fmt.Errorf("代理配置无效: %w", err)
+		}
+		serverClient := core.NewServerClient(cfg, nil)
+		serverClient.SetProxy(outboundProxy)
+		if *probe, *tcpProbe, err = serverClient.ProbeAddresses(); err != nil {
+			fmt.Fprintf(os.Stderr, "跳过回显探测: %v\n", err)
+		}
+	}
+
+	fmt.Fprintln(os.Stderr, "正在测试 NAT 穿透...")
+	report, err := nat.BuildReport(nat.ReportOptions{
+		STUNServers: cfg.STUNServerList(),
+		Timeout:     *timeout,
+		Probe:       *probe,
+		TCPProbe:    *tcpProbe,
+		UPnP:        *upnp,
+	})
+	if err != nil {
+		return err
+	}
+	info := version.Get()
+	report.NodeID = cfg.Node.ID
+	report.ClientVersion = info.Version
+	report.OS = info.OS
+	report.Arch = info.Arch
+
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	if *output == "-" {
+		fmt.Println(string(data))
+		return nil
+	}
+	if err := os.WriteFile(*output, data, 0644); err != nil {
+		return fmt.Errorf("保存报告失败: %w", err)
+	}
+	fmt.Print(report.Summary())
+	fmt.Printf("报告已保存到 %s\n", *output)
+	return nil
+}
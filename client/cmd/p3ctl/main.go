@@ -0,0 +1,376 @@
+// p3ctl 是 P3 客户端的本地诊断工具。
+//
+//	p3ctl [-config config.yaml] explain [-n 1] [-json] [peer]
+//	p3ctl [-config config.yaml] upnp [-all] [-clean] [-json]
+//	p3ctl [-config config.yaml] log-level [level] [module=level ...]
+//	p3ctl [-config config.yaml] resolve <peer>
+//	p3ctl [-config config.yaml] app-update [-port N] [-bind addr] [-dst host:port] [-description text] <app>
+//
+// explain 读取客户端保存的连接记录，说明与对等节点最近几次连接时尝试了哪些方式、各自的错误和耗时，
+// 以及最终为何使用了当前的连接路径（例如为何回退到中继）。不指定节点时列出有连接记录的节点。
+// 节点可以使用别名或设备名称，没有该名称的连接记录时通过运行中的客户端解析为节点 ID。
+//
+// upnp 列出网关上由 P3 客户端创建的端口映射，-clean 删除本节点遗留的映射（客户端运行时不要使用）
+//
+// log-level 通过本地控制接口查看或修改运行中客户端的日志级别，例如 log-level p2p/signaling=debug forward=warn，
+// module= 清除该模块的级别。修改只对当前进程生效
+//
+// resolve 通过运行中的客户端由服务端把别名、节点 ID 或设备名称解析为节点 ID
+//
+// app-update 通过本地控制接口平滑更新运行中应用的监听端口、监听地址、目标地址或描述：新端口监听成功后才关闭旧端口，
+// 已建立的连接按旧规则排空，统计信息保留。修改只对当前进程生效
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/control"
+	"github.com/senma231/p3/client/nat"
+	"github.com/senma231/p3/client/trace"
+)
+
+func main() {
+	configPath := flag.String("config", "config.yaml", "配置文件路径")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+
+	// 配置文件不存在时使用默认配置，连接记录文件路径仍可通过环境变量指定
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		cfg = config.DefaultConfig()
+	}
+
+	switch flag.Arg(0) {
+	case "explain":
+		if err := explain(cfg, flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "p3ctl: %v\n", err)
+			os.Exit(1)
+		}
+	case "upnp":
+		if err := upnp(cfg, flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "p3ctl: %v\n", err)
+			os.Exit(1)
+		}
+	case "log-level":
+		if err := logLevel(cfg, flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "p3ctl: %v\n", err)
+			os.Exit(1)
+		}
+	case "resolve":
+		if err := resolve(cfg, flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "p3ctl: %v\n", err)
+			os.Exit(1)
+		}
+	case "app-update":
+		if err := appUpdate(cfg, flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "p3ctl: %v\n", err)
+			os.Exit(1)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "p3ctl: 未知命令 %s\n", flag.Arg(0))
+		usage()
+		os.Exit(2)
+	}
+}
+
+// usage 打印用法
+func usage() {
+	fmt.Fprintf(os.Stderr, "用法: p3ctl [-config config.yaml] <命令> [参数]\n\n")
+	fmt.Fprintf(os.Stderr, "命令:\n")
+	fmt.Fprintf(os.Stderr, "  explain [-n 1] [-json] [peer]  说明与对等节点的连接过程\n")
+	fmt.Fprintf(os.Stderr, "  upnp [-all] [-clean] [-json]   列出或清理网关上的 UPnP 端口映射\n")
+	fmt.Fprintf(os.Stderr, "  log-level [level] [module=level ...]  查看或修改运行中客户端的日志级别\n")
+	fmt.Fprintf(os.Stderr, "  resolve <peer>                 把别名或设备名称解析为节点 ID\n")
+	fmt.Fprintf(os.Stderr, "  app-update [-port N] [-bind addr] [-dst host:port] [-description text] <app>  平滑更新运行中的应用\n\n")
+	flag.PrintDefaults()
+}
+
+// explain 打印对等节点最近的连接记录
+func explain(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("explain", flag.ExitOnError)
+	count := fs.Int("n", 1, "显示最近几次连接")
+	asJSON := fs.Bool("json", false, "以 JSON 格式输出")
+	fs.Parse(args)
+
+	if cfg.Trace.File == "" {
+		return fmt.Errorf("未配置连接记录文件 trace.file")
+	}
+	store := trace.NewStore(cfg.Trace.File, cfg.Trace.PerPeer)
+	if err := store.Load(); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		peers := store.Peers()
+		if len(peers) == 0 {
+			fmt.Println("暂无连接记录")
+			return nil
+		}
+		fmt.Println("有连接记录的节点:")
+		for _, peerID := range peers {
+			latest := store.Peer(peerID)[0]
+			fmt.Printf("  %-20s %s  %s\n", peerID, latest.StartedAt.Format("2006-01-02 15:04:05"), latest.Summary())
+		}
+		return nil
+	}
+
+	peerID := fs.Arg(0)
+	traces := store.Peer(peerID)
+	if len(traces) == 0 {
+		// 连接记录按节点 ID 保存，参数可能是别名或设备名称
+		if resolved, err := resolvePeer(cfg, peerID); err == nil && resolved.NodeID != peerID {
+			peerID = resolved.NodeID
+			traces = store.Peer(peerID)
+		}
+	}
+	if len(traces) == 0 {
+		return fmt.Errorf("没有与 %s 的连接记录", peerID)
+	}
+	if *count > 0 && len(traces) > *count {
+		traces = traces[:*count]
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(traces)
+	}
+	for i, t := range traces {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Print(t.Explain())
+	}
+	return nil
+}
+
+// upnp 列出网关上的 UPnP 端口映射，或删除本节点遗留的映射
+func upnp(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("upnp", flag.ExitOnError)
+	all := fs.Bool("all", false, "列出网关上的全部映射，而不只是 P3 客户端创建的映射")
+	clean := fs.Bool("clean", false, "删除本节点遗留的映射")
+	asJSON := fs.Bool("json", false, "以 JSON 格式输出")
+	fs.Parse(args)
+
+	client := nat.NewUPnPClient(10 * time.Second)
+	if *clean {
+		if cfg.Node.ID == "" {
+			return fmt.Errorf("未配置节点 ID node.id")
+		}
+		removed, err := client.CleanupOrphanedMappings(cfg.Node.ID)
+		for _, m := range removed {
+			fmt.Printf("已删除 %s/%d  %s\n", m.Protocol, m.ExternalPort, m.Description)
+		}
+		if err == nil && len(removed) == 0 {
+			fmt.Println("没有需要清理的映射")
+		}
+		return err
+	}
+
+	mappings, err := client.ListPortMappings(*all)
+	if err != nil {
+		return err
+	}
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(mappings)
+	}
+	if len(mappings) == 0 {
+		fmt.Println("网关上没有 P3 客户端创建的端口映射")
+		return nil
+	}
+	fmt.Printf("%-5s %-6s %-21s %-20s %-8s %s\n", "协议", "外部端口", "内部地址", "节点", "租期", "描述")
+	for _, m := range mappings {
+		lease := "永久"
+		if m.LeaseDuration > 0 {
+			lease = (time.Duration(m.LeaseDuration) * time.Second).String()
+		}
+		nodeID := m.NodeID
+		if nodeID == "" {
+			nodeID = "-"
+		}
+		fmt.Printf("%-5s %-6d %-21s %-20s %-8s %s\n", m.Protocol, m.ExternalPort,
+			fmt.Sprintf("%s:%d", m.InternalClient, m.InternalPort), nodeID, lease, m.Description)
+	}
+	return nil
+}
+
+// logLevel 通过本地控制接口查看或修改日志级别，不带参数时只查看
+func logLevel(cfg *config.Config, args []string) error {
+	if cfg.Control.Address == "" {
+		return fmt.Errorf("未启用本地控制接口 control.address")
+	}
+	url := "http://" + cfg.Control.Address + "/api/log-levels"
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	var req *http.Request
+	var err error
+	if len(args) == 0 {
+		req, err = http.NewRequest(http.MethodGet, url, nil)
+	} else {
+		levels := control.LogLevels{Modules: make(map[string]string)}
+		for _, arg := range args {
+			if module, level, ok := strings.Cut(arg, "="); ok {
+				levels.Modules[module] = level
+			} else {
+				levels.Level = arg
+			}
+		}
+		body, _ := json.Marshal(levels)
+		req, err = http.NewRequest(http.MethodPut, url, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+			req.Header.Set(control.CSRFHeader, "1")
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("连接本地控制接口失败，客户端是否正在运行: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("修改日志级别失败: %s", strings.TrimSpace(string(msg)))
+	}
+
+	var levels control.LogLevels
+	if err := json.NewDecoder(resp.Body).Decode(&levels); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+	fmt.Printf("全局: %s\n", levels.Level)
+	modules := make([]string, 0, len(levels.Modules))
+	for module := range levels.Modules {
+		modules = append(modules, module)
+	}
+	sort.Strings(modules)
+	for _, module := range modules {
+		fmt.Printf("%s: %s\n", module, levels.Modules[module])
+	}
+	return nil
+}
+
+// peerResolution 本地控制接口返回的对等节点解析结果
+type peerResolution struct {
+	Name   string `json:"name"`
+	NodeID string `json:"nodeId"`
+	Source string `json:"source"`
+}
+
+// resolve 打印对等节点名称解析出的节点 ID
+func resolve(cfg *config.Config, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("用法: p3ctl resolve <peer>")
+	}
+	resolved, err := resolvePeer(cfg, args[0])
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s  %s (%s)\n", resolved.NodeID, resolved.Name, resolved.Source)
+	return nil
+}
+
+// resolvePeer 通过本地控制接口解析对等节点名称
+func resolvePeer(cfg *config.Config, name string) (*peerResolution, error) {
+	if cfg.Control.Address == "" {
+		return nil, fmt.Errorf("未启用本地控制接口 control.address")
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Get("http://" + cfg.Control.Address + "/api/peers/resolve?name=" + url.QueryEscape(name))
+	if err != nil {
+		return nil, fmt.Errorf("连接本地控制接口失败，客户端是否正在运行: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("解析对等节点失败: %s", strings.TrimSpace(string(msg)))
+	}
+
+	var resolved peerResolution
+	if err := json.NewDecoder(resp.Body).Decode(&resolved); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	return &resolved, nil
+}
+
+// appUpdate 通过本地控制接口平滑更新应用，只发送命令行中指定的字段
+func appUpdate(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("app-update", flag.ExitOnError)
+	port := fs.Int("port", 0, "新的监听端口")
+	bind := fs.String("bind", "", "新的监听地址或网卡名称")
+	dst := fs.String("dst", "", "新的目标地址，格式为 host:port")
+	description := fs.String("description", "", "新的描述")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("用法: p3ctl app-update [-port N] [-bind addr] [-dst host:port] [-description text] <app>")
+	}
+	if cfg.Control.Address == "" {
+		return fmt.Errorf("未启用本地控制接口 control.address")
+	}
+
+	update := control.AppUpdate{Name: fs.Arg(0)}
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			update.SrcPort = port
+		case "bind":
+			update.Bind = bind
+		case "description":
+			update.Description = description
+		}
+	})
+	if *dst != "" {
+		host, p, err := net.SplitHostPort(*dst)
+		if err != nil {
+			return fmt.Errorf("无效的目标地址 %s: %w", *dst, err)
+		}
+		dstPort, err := strconv.Atoi(p)
+		if err != nil {
+			return fmt.Errorf("无效的目标端口 %s", p)
+		}
+		update.DstHost, update.DstPort = &host, &dstPort
+	}
+
+	body, _ := json.Marshal(update)
+	req, err := http.NewRequest(http.MethodPut, "http://"+cfg.Control.Address+"/api/apps", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(control.CSRFHeader, "1")
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("连接本地控制接口失败，客户端是否正在运行: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("更新应用失败: %s", strings.TrimSpace(string(msg)))
+	}
+	fmt.Printf("应用 %s 已更新，已建立的连接按旧规则排空\n", update.Name)
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/core"
+	"github.com/senma231/p3/client/forward"
+)
+
+// exportRules 导出本地配置和服务端下发的应用（使用本地缓存，不连接服务端）的转发规则
+func exportRules(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	flags := addConfigFlags(fs)
+	output := fs.String("o", "rules.json", "导出文件路径")
+	fs.Parse(args)
+	cfg := flags.load(false)
+
+	apps := cfg.Apps
+	if cfg.AppsCacheFile != "" && cfg.Node.ID != "" {
+		appSync := core.NewAppSync(nil, cfg.Node.ID, cfg.AppsCacheFile)
+		if err := appSync.Load(); err != nil {
+			return err
+		}
+		if cached := appSync.Apps(); len(cached) > 0 {
+			apps = cfg.MergeAppSettings(cached)
+		}
+	}
+
+	if err := forward.ExportRules(forward.RulesFromApps(apps), *output); err != nil {
+		return err
+	}
+	fmt.Printf("已导出 %d 条转发规则到 %s\n", len(apps), *output)
+	return nil
+}
+
+// importRules 将导出的转发规则加入配置文件的应用列表，同名应用只更新规则包含的字段
+func importRules(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	flags := addConfigFlags(fs)
+	input := fs.String("i", "rules.json", "导入文件路径")
+	fs.Parse(args)
+	cfg := flags.load(false)
+
+	rules, err := forward.ImportRules(*input)
+	if err != nil {
+		return err
+	}
+
+	index := make(map[string]int, len(cfg.Apps))
+	for i, app := range cfg.Apps {
+		index[app.Name] = i
+	}
+	for _, rule := range forward.SortedRules(rules) {
+		if i, ok := index[rule.ID]; ok {
+			rule.ApplyTo(&cfg.Apps[i])
+			continue
+		}
+		index[rule.ID] = len(cfg.Apps)
+		cfg.Apps = append(cfg.Apps, rule.AppConfig())
+	}
+
+	if _, err := config.OrderApps(cfg.Apps); err != nil {
+		return fmt.Errorf("导入后的应用配置无效: %w", err)
+	}
+	if err := config.SaveConfig(cfg, *flags.path); err != nil {
+		return err
+	}
+	fmt.Printf("已导入 %d 条转发规则到 %s\n", len(rules), *flags.path)
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/forward"
+	"github.com/senma231/p3/client/p2p"
+	"github.com/senma231/p3/client/simple"
+	"github.com/senma231/p3/common/lifecycle"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/version"
+)
+
+// pair 生成简易模式的配对码，或导入对端生成的配对码，并写入配置文件
+func pair(args []string) error {
+	fs := flag.NewFlagSet("pair", flag.ExitOnError)
+	flags := addConfigFlags(fs)
+	code := fs.String("import", "", "导入对端生成的配对码或配对链接，为空时生成新的配对码")
+	peer := fs.String("peer", "", "对端的节点名称，配对链接中包含时可以不指定")
+	fs.Parse(args)
+	cfg := flags.load(false)
+	if cfg.Node.ID == "" {
+		return errors.New("节点名称不能为空，请使用 -node 参数指定")
+	}
+
+	if *code == "" {
+		key, err := simple.GenerateKey()
+		if err != nil {
+			return err
+		}
+		cfg.Simple.Key = key.String()
+		fmt.Printf("配对码: %s\n", key)
+		fmt.Printf("配对链接（可生成二维码）: %s\n", simple.PairingURI(key, cfg.Node.ID))
+		fmt.Printf("在对端执行: p3-client pair -node <对端节点名称> -import '%s'\n", simple.PairingURI(key, cfg.Node.ID))
+	} else {
+		key, node, err := simple.ParsePairingURI(*code)
+		if err != nil {
+			if key, err = simple.ParseKey(*code); err != nil {
+				return err
+			}
+		}
+		if node != "" && *peer == "" {
+			*peer = node
+		}
+		cfg.Simple.Key = key.String()
+	}
+	if *peer != "" {
+		cfg.Simple.Peer = *peer
+	}
+	cfg.Simple.Enabled = true
+
+	if err := config.SaveConfig(cfg, *flags.path); err != nil {
+		return fmt.Errorf("保存配置失败: %w", err)
+	}
+	if cfg.Simple.Peer == "" {
+		fmt.Printf("已保存到 %s，请在配置文件中设置 simple.peer 为对端的节点名称\n", *flags.path)
+	} else {
+		fmt.Printf("已保存到 %s，对端节点: %s\n", *flags.path, cfg.Simple.Peer)
+	}
+	return nil
+}
+
+// runSimple 以简易模式运行：不连接服务端，通过预共享密钥与对端会合，
+// peerNode 为对端的应用在对端地址确认后直接转发到对端
+func runSimple(args []string) error {
+	fs := flag.NewFlagSet("simple", flag.ExitOnError)
+	flags := addConfigFlags(fs)
+	fs.Parse(args)
+	cfg := flags.load(false)
+
+	cfg.Simple.Enabled = true
+	if cfg.Node.ID == "" {
+		return errors.New("节点名称不能为空，请使用 -node 参数指定")
+	}
+	if err := cfg.Simple.Validate(); err != nil {
+		return err
+	}
+	if cfg.Simple.Peer == "" {
+		return errors.New("对端节点名称不能为空，请在配置文件中设置 simple.peer")
+	}
+	key, err := simple.ParseKey(cfg.Simple.Key)
+	if err != nil {
+		return err
+	}
+
+	logger.SetLevel(logger.ParseLevel(cfg.Logging.Level))
+	moduleLevels, _ := logger.ParseModuleLevels(cfg.Logging.Modules)
+	logger.SetModuleLevels(moduleLevels)
+
+	fmt.Println("P3 客户端以简易模式启动中...")
+	fmt.Printf("版本: %s\n", version.Get())
+	fmt.Printf("节点 ID: %s\n", cfg.Node.ID)
+	fmt.Printf("对端节点: %s\n", cfg.Simple.Peer)
+
+	runner := lifecycle.New(10 * time.Second)
+
+	rendezvous, err := simple.New(simple.Options{
+		NodeID:        cfg.Node.ID,
+		Peer:          cfg.Simple.Peer,
+		Key:           key,
+		ListenPort:    cfg.Simple.ListenPort,
+		PeerAddress:   cfg.Simple.PeerAddress,
+		RendezvousURL: cfg.Simple.RendezvousURL,
+		STUNServers:   cfg.Network.STUNServers,
+		LocalEndpoints: func(port int) []string {
+			return p2p.LocalCandidates(port, cfg.Network.Interfaces.Policy())
+		},
+		Interval: time.Duration(cfg.Simple.Interval) * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+
+	forwarders := forward.NewForwarderManager()
+	forwarders.SetRestartPolicy(forward.RestartPolicy{
+		InitialBackoff: time.Duration(cfg.Restart.InitialBackoff) * time.Second,
+		MaxBackoff:     time.Duration(cfg.Restart.MaxBackoff) * time.Second,
+		MaxRestarts:    cfg.Restart.MaxRestarts,
+	})
+	forwarders.SetDrainTimeout(time.Duration(cfg.Performance.DrainTimeout) * time.Second)
+	forwarders.SetPeerPresence(func(nodeID string) (bool, bool) {
+		if nodeID != cfg.Simple.Peer {
+			return false, false
+		}
+		_, online := rendezvous.Peer()
+		return online, true
+	})
+
+	// 对端地址变化时按新地址对账，对端的应用平滑更新目标地址
+	rendezvous.OnPeer(func(addr *net.UDPAddr, online bool) {
+		if online {
+			fmt.Printf("对端节点 %s 已上线: %s\n", cfg.Simple.Peer, addr.IP)
+			forwarders.Reconcile(simpleApps(cfg, addr.IP), cfg.Performance.BufferSize)
+		} else {
+			fmt.Printf("对端节点 %s 已离线\n", cfg.Simple.Peer)
+		}
+		forwarders.PeerPresenceChanged(cfg.Simple.Peer, online)
+	})
+
+	if err := runner.Start(lifecycle.Component{
+		Name:  "简易模式会合",
+		Start: rendezvous.Start,
+		Stop:  lifecycle.StopErrFunc(rendezvous.Close),
+	}); err != nil {
+		runner.Stop()
+		return err
+	}
+	fmt.Printf("会合端口: %d\n", rendezvous.LocalPort())
+
+	runner.Start(lifecycle.Component{Name: "应用转发", Stop: lifecycle.StopErrFunc(forwarders.StopAll)})
+	forwarders.Reconcile(simpleApps(cfg, nil), cfg.Performance.BufferSize)
+
+	// 等待中断信号
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	fmt.Println("正在关闭客户端...")
+	if err := runner.Stop(); err != nil {
+		log.Printf("关闭客户端时出错: %v", err)
+	}
+	fmt.Println("客户端已关闭")
+	return nil
+}
+
+// simpleApps 简易模式下的应用配置。peerNode 为对端的应用转发到对端确认的地址，
+// 对端地址确认前不启动；其他应用按本地配置转发
+func simpleApps(cfg *config.Config, peerIP net.IP) []config.AppConfig {
+	apps := make([]config.AppConfig, len(cfg.Apps))
+	copy(apps, cfg.Apps)
+	for i := range apps {
+		if apps[i].PeerNode != cfg.Simple.Peer {
+			continue
+		}
+		apps[i].StartWhenPeerOnline = true
+		if peerIP != nil {
+			apps[i].DstHost = peerIP.String()
+		}
+	}
+	return apps
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/senma231/p3/client/credential"
+	"gopkg.in/yaml.v3"
+)
+
+// credentialStore 创建节点令牌存储，加密文件的相对路径相对于配置文件所在目录
+func credentialStore(config *Config, path string) *credential.Store {
+	file := config.Node.CredentialFile
+	if file == "" {
+		file = "p3-credentials.enc"
+	}
+	if !filepath.IsAbs(file) {
+		file = filepath.Join(filepath.Dir(path), file)
+	}
+	return credential.New(file)
+}
+
+// MigrateToken 将配置文件中的明文令牌迁移到系统密钥环或加密文件，并从配置文件中删除。
+// 只修改配置文件中 node.token 这一行，其他内容和注释保持不变。返回令牌的保存位置，没有需要迁移的令牌时返回空字符串
+func MigrateToken(config *Config, path string) (string, error) {
+	if config.Node.TokenStore != TokenStoreKeyring || config.Node.ID == "" {
+		return "", nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", nil
+	}
+	var raw struct {
+		Node struct {
+			Token string `yaml:"token"`
+		} `yaml:"node"`
+	}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return "", fmt.Errorf("解析配置文件失败: %w", err)
+	}
+	// 令牌来自环境变量或命令行参数时不迁移
+	if raw.Node.Token == "" || raw.Node.Token != config.Node.Token {
+		return "", nil
+	}
+
+	updated, ok := removeToken(data)
+	if !ok {
+		return "", fmt.Errorf("配置文件中找不到 node.token，请手动删除明文令牌")
+	}
+	location, err := credentialStore(config, path).Set(config.Node.ID, config.Node.Token)
+	if err != nil {
+		return "", err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("读取配置文件失败: %w", err)
+	}
+	if err := os.WriteFile(path, updated, info.Mode().Perm()); err != nil {
+		return "", fmt.Errorf("写入配置文件失败: %w", err)
+	}
+	return location, nil
+}
+
+// removeToken 将配置文件中 node 下的 token 替换为空字符串，保留缩进和其他内容
+func removeToken(data []byte) ([]byte, bool) {
+	lines := strings.Split(string(data), "\n")
+	inNode := false
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		// 顶层键，进入或离开 node
+		if line[0] != ' ' && line[0] != '\t' {
+			inNode = strings.HasPrefix(trimmed, "node:")
+			continue
+		}
+		if inNode && strings.HasPrefix(trimmed, "token:") {
+			indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+			lines[i] = indent + `token: ""  # 已保存到系统密钥环或加密文件`
+			return []byte(strings.Join(lines, "\n")), true
+		}
+	}
+	return data, false
+}
@@ -0,0 +1,34 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestRemoveToken(t *testing.T) {
+	data := []byte(`# P3 客户端配置文件
+server:
+  token: keep-me
+node:
+  id: node-a
+  # 节点令牌
+  token: secret-token
+  region: cn
+`)
+	want := `# P3 客户端配置文件
+server:
+  token: keep-me
+node:
+  id: node-a
+  # 节点令牌
+  token: ""  # 已保存到系统密钥环或加密文件
+  region: cn
+`
+	updated, ok := removeToken(data)
+	if !ok || string(updated) != want {
+		t.Fatalf("删除令牌结果不正确:\n%s", updated)
+	}
+
+	if _, ok := removeToken([]byte("node:\n  id: node-a\n")); ok {
+		t.Fatalf("没有令牌时应返回 false")
+	}
+}
@@ -0,0 +1,148 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// 健康检查默认值
+const (
+	DefaultHealthInterval = 30 // 秒
+	DefaultHealthTimeout  = 5  // 秒
+	DefaultHealthRetries  = 3
+)
+
+// HealthCheckConfig 应用的健康检查。启动转发后总会连接目标地址 DstHost:DstPort，
+// 设置了 HTTP 或 Command 时还需要其检查通过，应用才会被标记为运行中
+type HealthCheckConfig struct {
+	HTTP     string `yaml:"http,omitempty"`    // 请求该地址，响应状态码小于 400 视为通过
+	Command  string `yaml:"command,omitempty"` // 执行该命令，退出码为 0 视为通过，命令按空格拆分，不经过 shell
+	Interval int    `yaml:"interval"`          // 检查间隔，单位：秒
+	Timeout  int    `yaml:"timeout"`           // 单次检查超时，单位：秒
+	Retries  int    `yaml:"retries"`           // 连续失败多少次后标记为失败，之前标记为降级
+}
+
+// WithDefaults 填充未设置字段的默认值，h 为 nil 时返回默认的健康检查
+func (h *HealthCheckConfig) WithDefaults() HealthCheckConfig {
+	var check HealthCheckConfig
+	if h != nil {
+		check = *h
+	}
+	if check.Interval == 0 {
+		check.Interval = DefaultHealthInterval
+	}
+	if check.Timeout == 0 {
+		check.Timeout = DefaultHealthTimeout
+	}
+	if check.Retries == 0 {
+		check.Retries = DefaultHealthRetries
+	}
+	return check
+}
+
+// Validate 验证健康检查，未设置的字段视为有效
+func (h *HealthCheckConfig) Validate() error {
+	if h.HTTP != "" {
+		u, err := url.Parse(h.HTTP)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("HTTP 检查地址无效: %s", h.HTTP)
+		}
+	}
+	if h.Interval < 0 || h.Interval > 3600 {
+		return errors.New("检查间隔必须在 1 到 3600 秒之间")
+	}
+	if h.Timeout < 0 || h.Timeout > 60 {
+		return errors.New("检查超时必须在 1 到 60 秒之间")
+	}
+	if h.Interval > 0 && h.Timeout > h.Interval {
+		return errors.New("检查超时不能大于检查间隔")
+	}
+	if h.Retries < 0 || h.Retries > 100 {
+		return errors.New("重试次数必须在 1 到 100 之间")
+	}
+	return nil
+}
+
+// OrderApps 按依赖关系排序应用，被依赖的应用排在前面，其余保持原有顺序。
+// 依赖不存在的应用或存在循环依赖时返回错误
+func OrderApps(apps []AppConfig) ([]AppConfig, error) {
+	index := make(map[string]int, len(apps))
+	for i, app := range apps {
+		index[app.Name] = i
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	marks := make([]int, len(apps))
+	ordered := make([]AppConfig, 0, len(apps))
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch marks[i] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("应用 %s 存在循环依赖", apps[i].Name)
+		}
+		marks[i] = visiting
+		for _, dep := range apps[i].DependsOn {
+			j, ok := index[dep]
+			if !ok {
+				return fmt.Errorf("应用 %s 依赖的应用 %s 不存在", apps[i].Name, dep)
+			}
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		marks[i] = visited
+		ordered = append(ordered, apps[i])
+		return nil
+	}
+
+	for i := range apps {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// MergeAppSettings 将本地配置中同名应用的连接策略、依赖、健康检查、启动条件、入站连接监控、监听地址和生命周期钩子合并到服务端下发的应用，
+// 这些设置只在客户端配置中维护
+func (c *Config) MergeAppSettings(apps []AppConfig) []AppConfig {
+	local := make(map[string]AppConfig, len(c.Apps))
+	for _, app := range c.Apps {
+		local[app.Name] = app
+	}
+
+	merged := make([]AppConfig, len(apps))
+	for i, app := range apps {
+		if l, ok := local[app.Name]; ok {
+			if app.Strategy == nil {
+				app.Strategy = l.Strategy
+			}
+			if len(app.DependsOn) == 0 {
+				app.DependsOn = l.DependsOn
+			}
+			if app.HealthCheck == nil {
+				app.HealthCheck = l.HealthCheck
+			}
+			app.StartWhenPeerOnline = app.StartWhenPeerOnline || l.StartWhenPeerOnline
+			if app.Inbound == nil {
+				app.Inbound = l.Inbound
+			}
+			app.DisableOffline = app.DisableOffline || l.DisableOffline
+			if app.Bind == "" {
+				app.Bind = l.Bind
+			}
+		}
+		// 钩子在本机执行命令，不使用服务端下发的设置
+		app.Hooks = local[app.Name].Hooks
+		merged[i] = app
+	}
+	return merged
+}
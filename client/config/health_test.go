@@ -0,0 +1,66 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestOrderApps(t *testing.T) {
+	apps := []AppConfig{
+		{Name: "web", DependsOn: []string{"api"}},
+		{Name: "api", DependsOn: []string{"db"}},
+		{Name: "ssh"},
+		{Name: "db"},
+	}
+
+	ordered, err := OrderApps(apps)
+	if err != nil {
+		t.Fatalf("排序失败: %v", err)
+	}
+	var names []string
+	for _, app := range ordered {
+		names = append(names, app.Name)
+	}
+	if got := strings.Join(names, ","); got != "db,api,web,ssh" {
+		t.Fatalf("排序错误: %s", got)
+	}
+
+	// 循环依赖和依赖不存在的应用
+	apps[3].DependsOn = []string{"web"}
+	if _, err := OrderApps(apps); err == nil || !strings.Contains(err.Error(), "循环依赖") {
+		t.Fatalf("应检测到循环依赖: %v", err)
+	}
+	if _, err := OrderApps([]AppConfig{{Name: "web", DependsOn: []string{"cache"}}}); err == nil {
+		t.Fatal("依赖不存在的应用应返回错误")
+	}
+}
+
+func TestHealthCheckValidate(t *testing.T) {
+	valid := []HealthCheckConfig{
+		{},
+		{HTTP: "http://127.0.0.1:8080/healthz", Interval: 10, Timeout: 2, Retries: 5},
+		{Command: "pg_isready -h localhost"},
+	}
+	for _, h := range valid {
+		if err := h.Validate(); err != nil {
+			t.Fatalf("健康检查 %+v 应有效: %v", h, err)
+		}
+	}
+
+	invalid := []HealthCheckConfig{
+		{HTTP: "ftp://127.0.0.1/"},
+		{HTTP: "http://"},
+		{Interval: -1},
+		{Interval: 5, Timeout: 10},
+		{Retries: 101},
+	}
+	for _, h := range invalid {
+		if err := h.Validate(); err == nil {
+			t.Fatalf("健康检查 %+v 应无效", h)
+		}
+	}
+
+	if defaults := (*HealthCheckConfig)(nil).WithDefaults(); defaults.Interval != DefaultHealthInterval || defaults.Retries != DefaultHealthRetries {
+		t.Fatalf("默认值错误: %+v", defaults)
+	}
+}
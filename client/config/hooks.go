@@ -0,0 +1,43 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// DefaultHookTimeout 钩子命令的默认超时，单位：秒
+const DefaultHookTimeout = 30
+
+// HooksConfig 应用的生命周期钩子。命令按空格拆分，不经过 shell，复杂的逻辑写成脚本；
+// 执行时的环境变量包含 P3_APP、P3_EVENT、P3_PEER、P3_PORT 等应用信息以及 Env 中的变量，
+// 输出写入客户端日志。钩子只在本地配置中维护，服务端下发的应用使用本地同名应用的钩子
+type HooksConfig struct {
+	OnStart       string            `yaml:"onStart,omitempty"`       // 转发器开始监听后执行
+	OnStop        string            `yaml:"onStop,omitempty"`        // 转发器停止后执行
+	OnPeerConnect string            `yaml:"onPeerConnect,omitempty"` // 应用运行期间对端节点上线时执行
+	Timeout       int               `yaml:"timeout,omitempty"`       // 单个命令的超时，单位：秒，超时后终止命令
+	Env           map[string]string `yaml:"env,omitempty"`           // 额外的环境变量
+}
+
+// TimeoutDuration 钩子命令的超时，未设置时使用默认值
+func (h *HooksConfig) TimeoutDuration() time.Duration {
+	if h.Timeout == 0 {
+		return DefaultHookTimeout * time.Second
+	}
+	return time.Duration(h.Timeout) * time.Second
+}
+
+// Validate 验证生命周期钩子
+func (h *HooksConfig) Validate() error {
+	if h.Timeout < 0 || h.Timeout > 3600 {
+		return errors.New("钩子超时必须在 1 到 3600 秒之间")
+	}
+	for name := range h.Env {
+		if name == "" || strings.ContainsAny(name, "= ") {
+			return fmt.Errorf("无效的环境变量名: %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package config
+
+import "testing"
+
+func TestHooksValidate(t *testing.T) {
+	if err := (&HooksConfig{Timeout: -1}).Validate(); err == nil {
+		t.Fatalf("负数超时应验证失败")
+	}
+	if err := (&HooksConfig{Env: map[string]string{"A=B": "c"}}).Validate(); err == nil {
+		t.Fatalf("包含等号的环境变量名应验证失败")
+	}
+	if timeout := (&HooksConfig{}).TimeoutDuration(); timeout.Seconds() != DefaultHookTimeout {
+		t.Fatalf("默认超时为 %s", timeout)
+	}
+}
+
+func TestMergeHooksLocalOnly(t *testing.T) {
+	cfg := DefaultConfig()
+	local := &HooksConfig{OnStart: "/usr/local/bin/mount-share"}
+	cfg.Apps = []AppConfig{{Name: "nas", Hooks: local}}
+
+	// 服务端下发的钩子被忽略，只使用本地配置中同名应用的钩子
+	merged := cfg.MergeAppSettings([]AppConfig{
+		{Name: "nas", Hooks: &HooksConfig{OnStart: "rm -rf /"}},
+		{Name: "web", Hooks: &HooksConfig{OnStart: "rm -rf /"}},
+	})
+	if merged[0].Hooks != local || merged[1].Hooks != nil {
+		t.Fatalf("合并后的钩子为 %+v, %+v", merged[0].Hooks, merged[1].Hooks)
+	}
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Weekdays 时间段中星期的写法，按 time.Weekday 的顺序
+var Weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// InboundConfig 应用监听端口的入站连接监控。来源不在允许列表或不在允许的时间段内的连接
+// 被记录并作为设备事件上报到服务端，可配合 unexpected_inbound 告警规则发现扫描和滥用
+type InboundConfig struct {
+	Allow    []string         `yaml:"allow,omitempty"`    // 允许的来源 IP 或 CIDR，为空时不检查来源
+	Schedule []ScheduleWindow `yaml:"schedule,omitempty"` // 允许连接的时间段，为空时不限制
+	Block    bool             `yaml:"block,omitempty"`    // 拒绝意外的连接，默认只记录和上报
+}
+
+// ScheduleWindow 允许连接的时间段，按本地时间计算
+type ScheduleWindow struct {
+	Days  []string `yaml:"days,omitempty"` // 适用的星期，如 mon、tue，为空时每天适用
+	Start string   `yaml:"start"`          // 开始时间，如 08:00
+	End   string   `yaml:"end"`            // 结束时间，不大于开始时间时跨越午夜
+}
+
+// ReputationEntry 来源地址的信誉标签
+type ReputationEntry struct {
+	CIDR string `yaml:"cidr"` // IP 或 CIDR
+	Tag  string `yaml:"tag"`
+}
+
+// Validate 验证入站连接监控
+func (c *InboundConfig) Validate() error {
+	for _, source := range c.Allow {
+		if _, err := ParseSource(source); err != nil {
+			return err
+		}
+	}
+	for _, w := range c.Schedule {
+		if err := w.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate 验证时间段
+func (w ScheduleWindow) Validate() error {
+	for _, day := range w.Days {
+		if WeekdayIndex(day) < 0 {
+			return fmt.Errorf("无效的星期: %s", day)
+		}
+	}
+	if _, err := ParseClock(w.Start); err != nil {
+		return err
+	}
+	if _, err := ParseClock(w.End); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ParseSource 解析 IP 或 CIDR，IP 视为单个地址的网段
+func ParseSource(source string) (*net.IPNet, error) {
+	if !strings.Contains(source, "/") {
+		ip := net.ParseIP(source)
+		if ip == nil {
+			return nil, fmt.Errorf("无效的来源地址: %s", source)
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+	}
+	_, ipNet, err := net.ParseCIDR(source)
+	if err != nil {
+		return nil, fmt.Errorf("无效的来源地址: %s", source)
+	}
+	return ipNet, nil
+}
+
+// ParseClock 解析 HH:MM 格式的时间，返回距午夜的时长
+func ParseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("无效的时间 %q，格式应为 HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// WeekdayIndex 返回星期对应的 time.Weekday，无效时返回 -1
+func WeekdayIndex(day string) int {
+	for i, d := range Weekdays {
+		if strings.EqualFold(day, d) {
+			return i
+		}
+	}
+	return -1
+}
+
+// validateReputation 验证来源地址信誉标签
+func validateReputation(entries []ReputationEntry) error {
+	for _, entry := range entries {
+		if _, err := ParseSource(entry.CIDR); err != nil {
+			return err
+		}
+		if entry.Tag == "" {
+			return errors.New("来源地址信誉标签不能为空")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package config
+
+import "testing"
+
+func TestInboundValidate(t *testing.T) {
+	valid := []InboundConfig{
+		{},
+		{Allow: []string{"192.168.1.0/24", "10.0.0.5", "fd00::/8"}, Block: true},
+		{Schedule: []ScheduleWindow{{Days: []string{"Mon", "fri"}, Start: "08:00", End: "18:30"}, {Start: "22:00", End: "02:00"}}},
+	}
+	for _, c := range valid {
+		if err := c.Validate(); err != nil {
+			t.Fatalf("入站连接监控 %+v 应有效: %v", c, err)
+		}
+	}
+
+	invalid := []InboundConfig{
+		{Allow: []string{"192.168.1.0/33"}},
+		{Allow: []string{"example.com"}},
+		{Schedule: []ScheduleWindow{{Start: "8:00pm", End: "18:00"}}},
+		{Schedule: []ScheduleWindow{{Start: "08:00", End: "24:00"}}},
+		{Schedule: []ScheduleWindow{{Days: []string{"monday"}, Start: "08:00", End: "18:00"}}},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("入站连接监控 %+v 应无效", c)
+		}
+	}
+
+	if err := validateReputation([]ReputationEntry{{CIDR: "198.51.100.0/24"}}); err == nil {
+		t.Fatal("信誉标签为空时应无效")
+	}
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// 以 JSON 提供配置的环境变量。YAML 兼容 JSON，字段名与配置文件相同，
+// 便于从 Kubernetes 的 ConfigMap 或自定义资源直接生成
+const (
+	// ConfigJSONEnv 完整的客户端配置，覆盖配置文件中的同名字段
+	ConfigJSONEnv = "P3_CONFIG_JSON"
+	// KubernetesServicesEnv 映射到对端的 Kubernetes Service 列表，覆盖配置中的 kubernetes.services
+	KubernetesServicesEnv = "P3_KUBERNETES_SERVICES"
+)
+
+// serviceAccountNamespaceFile Pod 内服务账号所在的命名空间
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// KubernetesConfig 以 Sidecar 运行在 Kubernetes 中的配置。列出的 Service 映射为转发到对端的应用，
+// 探针地址提供存活和就绪检查，所有应用的隧道建立后才报告就绪
+type KubernetesConfig struct {
+	Namespace     string `yaml:"namespace"`     // Service 默认所在的命名空间，为空时使用 Pod 所在的命名空间
+	ClusterDomain string `yaml:"clusterDomain"` // 集群域名
+	// 存活（/healthz）和就绪（/readyz）探针的监听地址，例如 :8081，为空时关闭。
+	// 探针由 kubelet 从 Pod 外部访问，因此不限于回环地址，只返回是否就绪
+	ProbeAddress string              `yaml:"probeAddress"`
+	Services     []KubernetesService `yaml:"services"`
+}
+
+// KubernetesService 映射到对端的 Kubernetes Service
+type KubernetesService struct {
+	Name       string `yaml:"name"`                 // Service 名称
+	Namespace  string `yaml:"namespace,omitempty"`  // 为空时使用 kubernetes.namespace
+	Port       int    `yaml:"port"`                 // Service 端口
+	Protocol   string `yaml:"protocol,omitempty"`   // tcp 或 udp，默认 tcp
+	ListenPort int    `yaml:"listenPort,omitempty"` // 本地监听端口，默认与 Service 端口相同
+	PeerNode   string `yaml:"peerNode"`
+	App        string `yaml:"app,omitempty"` // 应用名称，默认 k8s-<命名空间>-<Service>-<端口>
+}
+
+// loadJSONFromEnv 按环境变量中的 JSON 覆盖配置
+func loadJSONFromEnv(config *Config) error {
+	if data := os.Getenv(ConfigJSONEnv); data != "" {
+		if err := yaml.Unmarshal([]byte(data), config); err != nil {
+			return fmt.Errorf("解析环境变量 %s 失败: %w", ConfigJSONEnv, err)
+		}
+	}
+	if data := os.Getenv(KubernetesServicesEnv); data != "" {
+		var services []KubernetesService
+		if err := yaml.Unmarshal([]byte(data), &services); err != nil {
+			return fmt.Errorf("解析环境变量 %s 失败: %w", KubernetesServicesEnv, err)
+		}
+		config.Kubernetes.Services = services
+	}
+	return nil
+}
+
+// Validate 验证 Kubernetes 配置
+func (c KubernetesConfig) Validate() error {
+	if c.ProbeAddress != "" {
+		if _, _, err := net.SplitHostPort(c.ProbeAddress); err != nil {
+			return fmt.Errorf("探针监听地址无效: %w", err)
+		}
+	}
+	if len(c.Services) > 0 && c.ClusterDomain == "" {
+		return errors.New("集群域名不能为空")
+	}
+	names := make(map[string]bool, len(c.Services))
+	for _, service := range c.Services {
+		if service.Name == "" {
+			return errors.New("Kubernetes Service 名称不能为空")
+		}
+		if service.Port <= 0 || service.Port > 65535 {
+			return fmt.Errorf("Kubernetes Service %s 的端口无效: %d", service.Name, service.Port)
+		}
+		if service.ListenPort < 0 || service.ListenPort > 65535 {
+			return fmt.Errorf("Kubernetes Service %s 的监听端口无效: %d", service.Name, service.ListenPort)
+		}
+		switch service.Protocol {
+		case "", "tcp", "udp":
+		default:
+			return fmt.Errorf("Kubernetes Service %s 的协议无效: %s", service.Name, service.Protocol)
+		}
+		if service.PeerNode == "" {
+			return fmt.Errorf("Kubernetes Service %s 的对端节点不能为空", service.Name)
+		}
+		name := c.appName(service)
+		if names[name] {
+			return fmt.Errorf("Kubernetes Service 映射的应用重复: %s", name)
+		}
+		names[name] = true
+	}
+	return nil
+}
+
+// Apps Service 映射的应用，目标地址为 Service 的集群域名，随配置一起启动
+func (c KubernetesConfig) Apps() []AppConfig {
+	apps := make([]AppConfig, 0, len(c.Services))
+	for _, service := range c.Services {
+		protocol := service.Protocol
+		if protocol == "" {
+			protocol = "tcp"
+		}
+		listenPort := service.ListenPort
+		if listenPort == 0 {
+			listenPort = service.Port
+		}
+		apps = append(apps, AppConfig{
+			Name:        c.appName(service),
+			Protocol:    protocol,
+			SrcPort:     listenPort,
+			PeerNode:    service.PeerNode,
+			DstHost:     fmt.Sprintf("%s.%s.svc.%s", service.Name, c.namespace(service), c.ClusterDomain),
+			DstPort:     service.Port,
+			Description: "Kubernetes Service " + c.namespace(service) + "/" + service.Name,
+			AutoStart:   true,
+		})
+	}
+	return apps
+}
+
+// appName Service 映射的应用名称
+func (c KubernetesConfig) appName(service KubernetesService) string {
+	if service.App != "" {
+		return service.App
+	}
+	return fmt.Sprintf("k8s-%s-%s-%d", c.namespace(service), service.Name, service.Port)
+}
+
+// namespace Service 所在的命名空间，依次使用 Service 的设置、kubernetes.namespace、
+// 环境变量 POD_NAMESPACE 和服务账号的命名空间，都没有时为 default
+func (c KubernetesConfig) namespace(service KubernetesService) string {
+	if service.Namespace != "" {
+		return service.Namespace
+	}
+	if c.Namespace != "" {
+		return c.Namespace
+	}
+	if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+		return namespace
+	}
+	if data, err := os.ReadFile(serviceAccountNamespaceFile); err == nil {
+		if namespace := strings.TrimSpace(string(data)); namespace != "" {
+			return namespace
+		}
+	}
+	return "default"
+}
+
+// WithKubernetesApps 在应用列表中加入 Service 映射的应用，已有同名应用时以列表中的为准
+func (c *Config) WithKubernetesApps(apps []AppConfig) []AppConfig {
+	if len(c.Kubernetes.Services) == 0 {
+		return apps
+	}
+	existing := make(map[string]bool, len(apps))
+	for _, app := range apps {
+		existing[app.Name] = true
+	}
+	for _, app := range c.Kubernetes.Apps() {
+		if !existing[app.Name] {
+			apps = append(apps, app)
+		}
+	}
+	return apps
+}
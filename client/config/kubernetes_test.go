@@ -0,0 +1,86 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestKubernetesApps(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Kubernetes.Namespace = "prod"
+	cfg.Kubernetes.Services = []KubernetesService{
+		{Name: "web", Port: 80, ListenPort: 8080, PeerNode: "office"},
+		{Name: "dns", Namespace: "kube-system", Port: 53, Protocol: "udp", PeerNode: "office", App: "cluster-dns"},
+	}
+	if err := cfg.Kubernetes.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	apps := cfg.WithKubernetesApps([]AppConfig{{Name: "cluster-dns", DstHost: "10.0.0.10"}})
+	if len(apps) != 2 {
+		t.Fatalf("应用数 %d，期望 2: %+v", len(apps), apps)
+	}
+	if apps[0].DstHost != "10.0.0.10" {
+		t.Errorf("已有同名应用时应以应用列表为准: %+v", apps[0])
+	}
+	web := apps[1]
+	if web.Name != "k8s-prod-web-80" || web.DstHost != "web.prod.svc.cluster.local" || web.DstPort != 80 ||
+		web.SrcPort != 8080 || web.Protocol != "tcp" || web.PeerNode != "office" || !web.AutoStart {
+		t.Errorf("Service 映射的应用不正确: %+v", web)
+	}
+
+	dns := cfg.Kubernetes.Apps()[1]
+	if dns.DstHost != "dns.kube-system.svc.cluster.local" || dns.SrcPort != 53 || dns.Protocol != "udp" {
+		t.Errorf("Service 映射的应用不正确: %+v", dns)
+	}
+}
+
+func TestKubernetesValidate(t *testing.T) {
+	invalid := []KubernetesConfig{
+		{ClusterDomain: "cluster.local", ProbeAddress: "8081"},
+		{ClusterDomain: "cluster.local", Services: []KubernetesService{{Port: 80, PeerNode: "a"}}},
+		{ClusterDomain: "cluster.local", Services: []KubernetesService{{Name: "web", PeerNode: "a"}}},
+		{ClusterDomain: "cluster.local", Services: []KubernetesService{{Name: "web", Port: 80}}},
+		{ClusterDomain: "cluster.local", Services: []KubernetesService{{Name: "web", Port: 80, PeerNode: "a", Protocol: "sctp"}}},
+		{ClusterDomain: "cluster.local", Namespace: "a", Services: []KubernetesService{
+			{Name: "web", Port: 80, PeerNode: "a"}, {Name: "web", Port: 80, PeerNode: "b", ListenPort: 81},
+		}},
+		{Services: []KubernetesService{{Name: "web", Port: 80, PeerNode: "a"}}},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Errorf("Kubernetes 配置 %+v 应无效", c)
+		}
+	}
+}
+
+func TestLoadConfigFromEnvWithoutFile(t *testing.T) {
+	t.Setenv(ConfigJSONEnv, `{"node": {"id": "sidecar", "token": "t0ken", "tokenStore": "config"}, "kubernetes": {"probeAddress": ":8081"}}`)
+	t.Setenv(KubernetesServicesEnv, `[{"name": "web", "namespace": "prod", "port": 80, "peerNode": "office"}]`)
+	t.Setenv("P3_KUBERNETES_CLUSTER_DOMAIN", "cluster.example")
+
+	cfg, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Node.ID != "sidecar" || cfg.Node.Token != "t0ken" || cfg.Kubernetes.ProbeAddress != ":8081" {
+		t.Fatalf("应使用环境变量中的 JSON 配置: %+v", cfg.Node)
+	}
+	apps := cfg.Kubernetes.Apps()
+	if len(apps) != 1 || apps[0].DstHost != "web.prod.svc.cluster.example" {
+		t.Fatalf("应使用环境变量中的 Service 映射: %+v", apps)
+	}
+
+	t.Setenv(KubernetesServicesEnv, `[{"name": "web", "port": "eighty"}]`)
+	if _, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Fatal("无效的 Service 映射应返回错误")
+	}
+}
+
+func TestKubernetesNamespaceFromPod(t *testing.T) {
+	t.Setenv("POD_NAMESPACE", "team-a")
+	app := KubernetesConfig{ClusterDomain: "cluster.local", Services: []KubernetesService{{Name: "api", Port: 443, PeerNode: "x"}}}.Apps()[0]
+	if app.DstHost != "api.team-a.svc.cluster.local" {
+		t.Fatalf("应使用 Pod 所在的命名空间: %s", app.DstHost)
+	}
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 离线策略
+const (
+	// OfflineCached 服务端不可用时使用最近一次同步的应用配置和对端在线状态
+	OfflineCached = "cached"
+	// OfflineLocal 服务端不可用时只启动本地配置文件中的应用
+	OfflineLocal = "local"
+	// OfflineStop 服务端不可用时不启动任何应用，直到与服务端同步成功
+	OfflineStop = "stop"
+)
+
+// OfflineConfig 服务端不可用时的离线运行配置。应用配置、访问控制和对端在线状态在每次同步后保存到本地，
+// 离线时按策略使用，恢复连接后与服务端对账
+type OfflineConfig struct {
+	Policy        string `yaml:"policy"`        // cached、local 或 stop
+	MaxAge        int    `yaml:"maxAge"`        // 缓存超过该时间未与服务端同步时不再使用，单位：小时，0 表示不限制
+	RetryInterval int    `yaml:"retryInterval"` // 离线时重试与服务端同步的间隔，单位：秒
+	File          string `yaml:"file"`          // 保存对端在线状态的文件，为空时不保存
+}
+
+// MaxAgeDuration 缓存的有效期，0 表示不限制
+func (c OfflineConfig) MaxAgeDuration() time.Duration {
+	return time.Duration(c.MaxAge) * time.Hour
+}
+
+// Validate 验证离线运行配置
+func (c OfflineConfig) Validate() error {
+	switch c.Policy {
+	case OfflineCached, OfflineLocal, OfflineStop:
+	default:
+		return fmt.Errorf("不支持的离线策略: %s", c.Policy)
+	}
+	if c.MaxAge < 0 {
+		return errors.New("离线缓存有效期不能为负数")
+	}
+	if c.RetryInterval <= 0 {
+		return errors.New("离线重试间隔必须大于 0")
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package config
+
+import "testing"
+
+func TestOfflineValidate(t *testing.T) {
+	if err := DefaultConfig().Offline.Validate(); err != nil {
+		t.Fatalf("默认离线配置应有效: %v", err)
+	}
+
+	invalid := []OfflineConfig{
+		{Policy: "always", RetryInterval: 30},
+		{Policy: OfflineCached, MaxAge: -1, RetryInterval: 30},
+		{Policy: OfflineLocal},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("离线配置 %+v 应无效", c)
+		}
+	}
+}
+
+func TestMergeDisableOffline(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Apps = []AppConfig{{Name: "ssh", DisableOffline: true}}
+
+	merged := cfg.MergeAppSettings([]AppConfig{{Name: "ssh"}, {Name: "web"}})
+	if !merged[0].DisableOffline || merged[1].DisableOffline {
+		t.Fatalf("应使用本地配置中同名应用的离线设置: %+v", merged)
+	}
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// SimpleConfig 不使用服务端的简易模式配置。两台设备通过其他途径交换预共享密钥（配对码或二维码），
+// 各自向 STUN 服务器获取外部地址后，用密钥加密的会合消息互相通告地址，然后直接建立转发
+type SimpleConfig struct {
+	Enabled bool   `yaml:"enabled"` // 启用后不需要节点令牌，使用 p3-client simple 命令运行
+	Key     string `yaml:"key"`     // 预共享密钥，使用 p3-client pair 命令生成
+	Peer    string `yaml:"peer"`    // 对端的节点名称，peerNode 为该名称的应用转发到对端
+	// 会合使用的 UDP 端口，0 表示随机端口
+	ListenPort int `yaml:"listenPort"`
+	// 已知的对端地址（host:port），例如对端有公网地址或做了端口映射，为空时只使用 STUN 和会合信箱
+	PeerAddress string `yaml:"peerAddress,omitempty"`
+	// 会合信箱地址，双方通过 HTTP PUT 和 GET 交换加密的会合消息，UDP 无法直接送达时使用，为空时不使用
+	RendezvousURL string `yaml:"rendezvousURL,omitempty"`
+	// 发送会合消息的间隔，单位：秒
+	Interval int `yaml:"interval"`
+}
+
+// Validate 验证简易模式配置，未启用时不验证
+func (c SimpleConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+	if c.Key == "" {
+		return errors.New("简易模式的预共享密钥不能为空")
+	}
+	if c.ListenPort < 0 || c.ListenPort > 65535 {
+		return fmt.Errorf("简易模式的监听端口无效: %d", c.ListenPort)
+	}
+	if c.PeerAddress != "" {
+		if _, _, err := net.SplitHostPort(c.PeerAddress); err != nil {
+			return fmt.Errorf("简易模式的对端地址无效: %w", err)
+		}
+	}
+	if c.RendezvousURL != "" {
+		u, err := url.Parse(c.RendezvousURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("简易模式的会合信箱地址无效: %s", c.RendezvousURL)
+		}
+	}
+	if c.Interval <= 0 {
+		return errors.New("简易模式的会合间隔必须大于 0")
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package config
+
+import "testing"
+
+func TestSimpleValidate(t *testing.T) {
+	if err := DefaultConfig().Simple.Validate(); err != nil {
+		t.Fatalf("默认简易模式配置应有效: %v", err)
+	}
+
+	valid := SimpleConfig{Enabled: true, Key: "p3psk1:key", Peer: "home", PeerAddress: "203.0.113.5:40000", RendezvousURL: "https://example.com/box", Interval: 10}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("简易模式配置应有效: %v", err)
+	}
+
+	invalid := []SimpleConfig{
+		{Enabled: true, Interval: 10},
+		{Enabled: true, Key: "k", ListenPort: 70000, Interval: 10},
+		{Enabled: true, Key: "k", PeerAddress: "203.0.113.5", Interval: 10},
+		{Enabled: true, Key: "k", RendezvousURL: "ftp://example.com", Interval: 10},
+		{Enabled: true, Key: "k"},
+	}
+	for _, c := range invalid {
+		if err := c.Validate(); err == nil {
+			t.Fatalf("简易模式配置 %+v 应无效", c)
+		}
+	}
+}
+
+func TestSimpleModeWithoutToken(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Node.Token = ""
+	if err := validateConfig(cfg); err == nil {
+		t.Fatal("未启用简易模式时令牌不能为空")
+	}
+
+	cfg.Simple.Enabled = true
+	cfg.Simple.Key = "p3psk1:key"
+	if err := validateConfig(cfg); err != nil {
+		t.Fatalf("简易模式不需要令牌: %v", err)
+	}
+}
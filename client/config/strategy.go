@@ -0,0 +1,116 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+// 中继策略
+const (
+	// RelayAuto 其他连接方式都失败后使用中继
+	RelayAuto = "auto"
+	// RelayPrefer 优先使用中继，中继失败后再尝试其他方式
+	RelayPrefer = "prefer"
+	// RelayDisable 不使用中继，适用于禁止流量经过第三方的环境
+	RelayDisable = "disable"
+)
+
+// TCP 打洞策略
+const (
+	// TCPPunchAuto 按双方 NAT 类型决定是否尝试 TCP 打洞
+	TCPPunchAuto = "auto"
+	// TCPPunchDisable 不尝试 TCP 打洞，适用于会拦截异常 TCP 握手的防火墙
+	TCPPunchDisable = "disable"
+)
+
+// DefaultMaxParallel 默认依次尝试各连接方式
+const DefaultMaxParallel = 1
+
+// StrategyConfig 连接策略，控制连接对等节点时尝试哪些方式以及如何尝试。
+// 应用的策略中未设置的字段使用全局策略
+type StrategyConfig struct {
+	Relay            string `yaml:"relay"`            // auto、prefer 或 disable
+	TCPPunch         string `yaml:"tcpPunch"`         // auto 或 disable
+	CandidateTimeout int    `yaml:"candidateTimeout"` // 单个连接方式的超时，单位：秒，为 0 时使用各方式的默认超时
+	MaxParallel      int    `yaml:"maxParallel"`      // 同时进行的连接尝试数，中继不参与并行
+	// 连接优先级，按顺序尝试列出的连接方式（ipv6、direct、upnp、udp-punch、tcp-punch），未列出的方式不尝试。
+	// 为空时使用服务端下发的优先级，服务端未下发时使用 protocol.DefaultCandidatePriority
+	Priority []string `yaml:"priority"`
+}
+
+// Merge 返回以 override 中已设置的字段覆盖后的策略
+func (s StrategyConfig) Merge(override *StrategyConfig) StrategyConfig {
+	if override == nil {
+		return s
+	}
+	if override.Relay != "" {
+		s.Relay = override.Relay
+	}
+	if override.TCPPunch != "" {
+		s.TCPPunch = override.TCPPunch
+	}
+	if override.CandidateTimeout != 0 {
+		s.CandidateTimeout = override.CandidateTimeout
+	}
+	if override.MaxParallel != 0 {
+		s.MaxParallel = override.MaxParallel
+	}
+	if len(override.Priority) > 0 {
+		s.Priority = override.Priority
+	}
+	return s
+}
+
+// withDefaults 填充未设置字段的默认值
+func (s StrategyConfig) withDefaults() StrategyConfig {
+	if s.Relay == "" {
+		s.Relay = RelayAuto
+	}
+	if s.TCPPunch == "" {
+		s.TCPPunch = TCPPunchAuto
+	}
+	if s.MaxParallel == 0 {
+		s.MaxParallel = DefaultMaxParallel
+	}
+	return s
+}
+
+// Validate 验证连接策略，未设置的字段视为有效
+func (s StrategyConfig) Validate() error {
+	switch s.Relay {
+	case "", RelayAuto, RelayPrefer, RelayDisable:
+	default:
+		return fmt.Errorf("不支持的中继策略: %s", s.Relay)
+	}
+	switch s.TCPPunch {
+	case "", TCPPunchAuto, TCPPunchDisable:
+	default:
+		return fmt.Errorf("不支持的 TCP 打洞策略: %s", s.TCPPunch)
+	}
+	if s.CandidateTimeout < 0 || s.CandidateTimeout > 120 {
+		return errors.New("连接超时必须在 0 到 120 秒之间")
+	}
+	if s.MaxParallel < 0 || s.MaxParallel > 8 {
+		return errors.New("并行连接数必须在 1 到 8 之间")
+	}
+	if err := protocol.ValidateCandidatePriority(s.Priority); err != nil {
+		return fmt.Errorf("连接优先级无效: %w", err)
+	}
+	return nil
+}
+
+// StrategyFor 获取应用的连接策略，appName 为空或应用未设置策略时使用全局策略
+func (c *Config) StrategyFor(appName string) StrategyConfig {
+	strategy := c.Strategy
+	if appName != "" {
+		for _, app := range c.Apps {
+			if app.Name == appName {
+				strategy = strategy.Merge(app.Strategy)
+				break
+			}
+		}
+	}
+	return strategy.withDefaults()
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStrategyFor(t *testing.T) {
+	cfg := &Config{
+		Strategy: StrategyConfig{Relay: RelayDisable, CandidateTimeout: 3},
+		Apps: []AppConfig{
+			{Name: "rdp", Strategy: &StrategyConfig{Relay: RelayPrefer, MaxParallel: 3}},
+			{Name: "ssh"},
+			{Name: "web", Strategy: &StrategyConfig{Priority: []string{"udp-punch", "direct"}}},
+		},
+	}
+
+	global := cfg.StrategyFor("")
+	if global.Relay != RelayDisable || global.TCPPunch != TCPPunchAuto || global.CandidateTimeout != 3 || global.MaxParallel != DefaultMaxParallel {
+		t.Fatalf("全局策略错误: %+v", global)
+	}
+
+	// 应用的策略覆盖已设置的字段，其余字段使用全局策略
+	rdp := cfg.StrategyFor("rdp")
+	if rdp.Relay != RelayPrefer || rdp.MaxParallel != 3 || rdp.CandidateTimeout != 3 {
+		t.Fatalf("应用策略错误: %+v", rdp)
+	}
+	if ssh := cfg.StrategyFor("ssh"); !reflect.DeepEqual(ssh, global) {
+		t.Fatalf("未设置策略的应用应使用全局策略: %+v", ssh)
+	}
+	if global.Priority != nil {
+		t.Fatalf("未设置连接优先级时应留空，由服务端下发: %v", global.Priority)
+	}
+	if web := cfg.StrategyFor("web"); !reflect.DeepEqual(web.Priority, []string{"udp-punch", "direct"}) {
+		t.Fatalf("应用的连接优先级应覆盖全局设置: %v", web.Priority)
+	}
+}
+
+func TestStrategyValidate(t *testing.T) {
+	valid := []StrategyConfig{
+		{},
+		{Relay: RelayPrefer, TCPPunch: TCPPunchDisable, CandidateTimeout: 10, MaxParallel: 4},
+		{Priority: []string{"ipv6", "udp-punch", "tcp-punch"}},
+	}
+	for _, s := range valid {
+		if err := s.Validate(); err != nil {
+			t.Fatalf("策略 %+v 应有效: %v", s, err)
+		}
+	}
+
+	invalid := []StrategyConfig{
+		{Relay: "always"},
+		{TCPPunch: "force"},
+		{CandidateTimeout: -1},
+		{MaxParallel: 9},
+		{Priority: []string{"quic"}},
+		{Priority: []string{"direct", "direct"}},
+	}
+	for _, s := range invalid {
+		if err := s.Validate(); err == nil {
+			t.Fatalf("策略 %+v 应无效", s)
+		}
+	}
+}
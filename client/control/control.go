@@ -0,0 +1,447 @@
+// Package control 提供客户端的本地控制接口。
+//
+// 控制接口只监听回环地址，浏览器打开后显示诊断页面：NAT 类型、外部 IP、当前连接、
+// 各应用的流量曲线，以及一键运行的诊断项，便于非技术用户按要求检查节点。
+package control
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+)
+
+// CSRFHeader 修改状态的请求必须携带的请求头。跨域请求携带自定义请求头需要预检，控制接口不响应预检，
+// 因此其他网站无法在用户的浏览器中触发诊断
+const CSRFHeader = "X-P3-Control"
+
+// checkTimeout 单个诊断项的超时
+const checkTimeout = 15 * time.Second
+
+// 目标地址统计默认和最多返回的条数
+const (
+	defaultDestinationLimit = 10
+	maxDestinationLimit     = 100
+)
+
+//go:embed index.html
+var indexHTML []byte
+
+// Status 节点状态
+type Status struct {
+	NodeID       string `json:"nodeId"`
+	Version      string `json:"version"`
+	NATType      string `json:"natType"`
+	ExternalIP   string `json:"externalIp"`
+	ExternalPort int    `json:"externalPort"`
+	UPnP         bool   `json:"upnp"`
+	Signaling    bool   `json:"signaling"`
+
+	SignalingQueues []protocol.QueueStats `json:"signalingQueues,omitempty"` // 信令发送队列各优先级的统计
+}
+
+// Check 诊断项，Run 返回诊断结果的说明
+type Check struct {
+	Name string
+	Run  func() (string, error)
+}
+
+// CheckResult 诊断项的执行结果
+type CheckResult struct {
+	Name     string `json:"name"`
+	OK       bool   `json:"ok"`
+	Detail   string `json:"detail"`
+	Duration int64  `json:"durationMs"`
+}
+
+// Source 诊断页面的数据来源，Connections、Apps 和 UPnPMappings 返回可序列化为 JSON 的列表
+type Source struct {
+	Status       func() Status
+	Connections  func() interface{}
+	Apps         func() interface{}
+	UPnPMappings func() (interface{}, error) // 为空时不提供 UPnP 映射列表
+	// Destinations 返回应用按目标地址的流量统计，app 为空时包含所有应用；为空时不提供目标地址统计
+	Destinations func(app string, limit int, sortBy string) (interface{}, error)
+	// ResolvePeer 由服务端把对等节点的别名、节点 ID 或设备名称解析为节点 ID；为空时不提供解析
+	ResolvePeer func(name string) (interface{}, error)
+	// UpdateApp 平滑更新应用的监听端口、目标地址等，已建立的连接按旧规则排空；为空时不提供修改
+	UpdateApp func(update AppUpdate) error
+	Checks    []Check
+}
+
+// Server 本地控制接口
+type Server struct {
+	address  string
+	source   Source
+	server   *http.Server
+	listener net.Listener
+
+	// 同一时间只运行一次诊断
+	diagMu sync.Mutex
+}
+
+// NewServer 创建本地控制接口
+func NewServer(address string, source Source) *Server {
+	s := &Server{
+		address: address,
+		source:  source,
+	}
+	s.server = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// Start 开始监听
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return fmt.Errorf("监听本地控制接口失败: %w", err)
+	}
+	s.listener = listener
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("本地控制接口退出: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Addr 返回实际监听的地址
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return s.address
+	}
+	return s.listener.Addr().String()
+}
+
+// Stop 停止监听，等待正在处理的请求完成
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// Handler 返回控制接口的请求处理器
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleIndex)
+	mux.HandleFunc("/api/status", s.handleStatus)
+	mux.HandleFunc("/api/connections", s.handleConnections)
+	mux.HandleFunc("/api/apps", s.handleApps)
+	mux.HandleFunc("/api/apps/destinations", s.handleDestinations)
+	mux.HandleFunc("/api/upnp", s.handleUPnP)
+	mux.HandleFunc("/api/peers/resolve", s.handleResolvePeer)
+	mux.HandleFunc("/api/diagnostics", s.handleDiagnostics)
+	mux.HandleFunc("/api/log-levels", s.handleLogLevels)
+	return guard(mux)
+}
+
+// guard 只接受 Host 为回环地址的请求，防止 DNS 重绑定后其他网站读取控制接口
+func guard(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isLoopbackHost(r.Host) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isLoopbackHost 检查 Host 请求头是否为 localhost 或回环 IP
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// handleIndex 返回诊断页面
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Write(indexHTML)
+}
+
+// handleStatus 返回节点状态
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, s.source.Status())
+}
+
+// handleConnections 返回当前连接
+func (s *Server) handleConnections(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"connections": s.source.Connections(),
+	})
+}
+
+// AppUpdate 平滑更新应用的请求体，为空的字段保持不变
+type AppUpdate struct {
+	Name        string  `json:"name"`
+	Bind        *string `json:"bind,omitempty"`
+	SrcPort     *int    `json:"srcPort,omitempty"`
+	DstHost     *string `json:"dstHost,omitempty"`
+	DstPort     *int    `json:"dstPort,omitempty"`
+	Description *string `json:"description,omitempty"`
+}
+
+// validate 检查更新请求
+func (u *AppUpdate) validate() error {
+	if u.Name == "" {
+		return fmt.Errorf("缺少应用名称")
+	}
+	for _, port := range []*int{u.SrcPort, u.DstPort} {
+		if port != nil && (*port <= 0 || *port > 65535) {
+			return fmt.Errorf("端口 %d 无效", *port)
+		}
+	}
+	if u.DstHost != nil && *u.DstHost == "" {
+		return fmt.Errorf("目标地址不能为空")
+	}
+	return nil
+}
+
+// handleApps 返回各应用的累计流量，页面按两次请求之间的差值计算速率。
+// PUT 平滑更新应用，修改只对当前进程生效，服务端下发的配置变化时按服务端配置对账
+func (s *Server) handleApps(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if r.Header.Get(CSRFHeader) == "" {
+			http.Error(w, "missing "+CSRFHeader+" header", http.StatusForbidden)
+			return
+		}
+		if s.source.UpdateApp == nil {
+			http.Error(w, "不支持修改应用", http.StatusNotFound)
+			return
+		}
+		var req AppUpdate
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := req.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := s.source.UpdateApp(req); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		log.Printf("应用已通过本地控制接口更新: %s", req.Name)
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"time": time.Now().UnixMilli(),
+		"apps": s.source.Apps(),
+	})
+}
+
+// handleDestinations 返回流量最多的目标地址，支持 app、limit 和 sort 查询参数
+func (s *Server) handleDestinations(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if s.source.Destinations == nil {
+		http.Error(w, "未提供目标地址统计", http.StatusNotFound)
+		return
+	}
+
+	query := r.URL.Query()
+	limit := defaultDestinationLimit
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxDestinationLimit {
+			http.Error(w, fmt.Sprintf("limit 应为 1 到 %d 之间的整数", maxDestinationLimit), http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	destinations, err := s.source.Destinations(query.Get("app"), limit, query.Get("sort"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, map[string]interface{}{
+		"time":         time.Now().UnixMilli(),
+		"destinations": destinations,
+	})
+}
+
+// handleResolvePeer 解析 name 查询参数中的对等节点名称
+func (s *Server) handleResolvePeer(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if s.source.ResolvePeer == nil {
+		http.Error(w, "未连接服务端，无法解析对等节点", http.StatusNotFound)
+		return
+	}
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		http.Error(w, "缺少 name 参数", http.StatusBadRequest)
+		return
+	}
+	resolved, err := s.source.ResolvePeer(name)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, resolved)
+}
+
+// handleUPnP 返回网关上由客户端创建的 UPnP 端口映射
+func (s *Server) handleUPnP(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	if s.source.UPnPMappings == nil {
+		http.Error(w, "未启用 UPnP", http.StatusNotFound)
+		return
+	}
+	mappings, err := s.source.UPnPMappings()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, map[string]interface{}{"mappings": mappings})
+}
+
+// handleDiagnostics 依次运行所有诊断项
+func (s *Server) handleDiagnostics(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodPost) {
+		return
+	}
+	if r.Header.Get(CSRFHeader) == "" {
+		http.Error(w, "missing "+CSRFHeader+" header", http.StatusForbidden)
+		return
+	}
+	if !s.diagMu.TryLock() {
+		http.Error(w, "诊断正在运行", http.StatusConflict)
+		return
+	}
+	defer s.diagMu.Unlock()
+
+	results := make([]CheckResult, 0, len(s.source.Checks))
+	for _, check := range s.source.Checks {
+		results = append(results, runCheck(check))
+	}
+	writeJSON(w, map[string]interface{}{
+		"results": results,
+	})
+}
+
+// LogLevels 全局和各模块的日志级别，也是修改日志级别的请求体。修改时 Level 为空表示不修改全局级别，
+// 模块的级别为空表示清除该模块的级别
+type LogLevels struct {
+	Level   string            `json:"level"`
+	Modules map[string]string `json:"modules"`
+}
+
+// handleLogLevels 查看或修改日志级别，修改只对当前进程生效
+func (s *Server) handleLogLevels(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if r.Header.Get(CSRFHeader) == "" {
+			http.Error(w, "missing "+CSRFHeader+" header", http.StatusForbidden)
+			return
+		}
+		var req LogLevels
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			http.Error(w, "invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := logger.ApplyLevels(req.Level, req.Modules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("日志级别已修改: 全局 %s，模块 %v", logger.CurrentLevel().Name(), logger.ModuleLevelNames())
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, LogLevels{
+		Level:   logger.CurrentLevel().Name(),
+		Modules: logger.ModuleLevelNames(),
+	})
+}
+
+// runCheck 运行诊断项，超时后不再等待
+func runCheck(check Check) CheckResult {
+	type outcome struct {
+		detail string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	start := time.Now()
+	go func() {
+		detail, err := check.Run()
+		done <- outcome{detail, err}
+	}()
+
+	result := CheckResult{Name: check.Name}
+	select {
+	case o := <-done:
+		result.OK = o.err == nil
+		result.Detail = o.detail
+		if o.err != nil {
+			result.Detail = o.err.Error()
+		}
+	case <-time.After(checkTimeout):
+		result.Detail = "超时"
+	}
+	result.Duration = time.Since(start).Milliseconds()
+	return result
+}
+
+// allowMethod 检查请求方法
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+	return true
+}
+
+// writeJSON 返回 JSON 响应
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("写入本地控制接口响应失败: %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/senma231/p3/common/logger"
+)
+
+func newTestServer() *Server {
+	return NewServer("127.0.0.1:0", Source{
+		Status:      func() Status { return Status{NodeID: "node-a", NATType: "Full Cone"} },
+		Connections: func() interface{} { return []string{} },
+		Apps:        func() interface{} { return []string{} },
+		Checks: []Check{
+			{Name: "ok", Run: func() (string, error) { return "正常", nil }},
+			{Name: "fail", Run: func() (string, error) { return "", errors.New("连接失败") }},
+		},
+	})
+}
+
+func TestGuard(t *testing.T) {
+	handler := newTestServer().Handler()
+
+	tests := []struct {
+		host string
+		want int
+	}{
+		{"127.0.0.1:7071", http.StatusOK},
+		{"localhost:7071", http.StatusOK},
+		{"[::1]:7071", http.StatusOK},
+		{"evil.example.com:7071", http.StatusForbidden},
+		{"192.168.1.10:7071", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/api/status", nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("Host %s 应返回 %d，实际 %d", tt.host, tt.want, rec.Code)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	handler := newTestServer().Handler()
+
+	// 没有自定义请求头的请求可能来自其他网站
+	req := httptest.NewRequest(http.MethodPost, "/api/diagnostics", nil)
+	req.Host = "127.0.0.1:7071"
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("缺少 %s 请求头应返回 403，实际 %d", CSRFHeader, rec.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/api/diagnostics", nil)
+	req.Host = "127.0.0.1:7071"
+	req.Header.Set(CSRFHeader, "1")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("运行诊断失败: %d %s", rec.Code, rec.Body.String())
+	}
+
+	var resp struct {
+		Results []CheckResult `json:"results"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	if len(resp.Results) != 2 || !resp.Results[0].OK || resp.Results[1].OK || resp.Results[1].Detail != "连接失败" {
+		t.Fatalf("诊断结果不正确: %+v", resp.Results)
+	}
+}
+
+func TestDestinations(t *testing.T) {
+	server := newTestServer()
+	get := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Host = "127.0.0.1:7071"
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/api/apps/destinations"); rec.Code != http.StatusNotFound {
+		t.Fatalf("未提供目标地址统计时应返回 404，实际 %d", rec.Code)
+	}
+
+	var gotApp, gotSort string
+	var gotLimit int
+	server.source.Destinations = func(app string, limit int, sortBy string) (interface{}, error) {
+		if sortBy == "name" {
+			return nil, errors.New("不支持的排序方式")
+		}
+		gotApp, gotLimit, gotSort = app, limit, sortBy
+		return []string{"10.0.0.1:80"}, nil
+	}
+
+	if rec := get("/api/apps/destinations?app=web&limit=5&sort=connections"); rec.Code != http.StatusOK {
+		t.Fatalf("查询目标地址失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if gotApp != "web" || gotLimit != 5 || gotSort != "connections" {
+		t.Fatalf("查询参数错误: %s %d %s", gotApp, gotLimit, gotSort)
+	}
+	if get("/api/apps/destinations"); gotLimit != defaultDestinationLimit {
+		t.Fatalf("默认条数应为 %d，实际 %d", defaultDestinationLimit, gotLimit)
+	}
+	for _, url := range []string{"/api/apps/destinations?limit=0", "/api/apps/destinations?limit=1000", "/api/apps/destinations?sort=name"} {
+		if rec := get(url); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s 应返回 400，实际 %d", url, rec.Code)
+		}
+	}
+}
+
+func TestResolvePeer(t *testing.T) {
+	server := newTestServer()
+	get := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		req.Host = "127.0.0.1:7071"
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/api/peers/resolve?name=home-nas"); rec.Code != http.StatusNotFound {
+		t.Fatalf("未提供解析时应返回 404，实际 %d", rec.Code)
+	}
+
+	server.source.ResolvePeer = func(name string) (interface{}, error) {
+		if name != "home-nas" {
+			return nil, errors.New("对等节点不存在")
+		}
+		return map[string]string{"name": name, "nodeId": "0123456789abcdef0123456789abcdef", "source": "alias"}, nil
+	}
+	rec := get("/api/peers/resolve?name=home-nas")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "0123456789abcdef0123456789abcdef") {
+		t.Fatalf("解析别名失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := get("/api/peers/resolve"); rec.Code != http.StatusBadRequest {
+		t.Errorf("缺少名称时应返回 400，实际 %d", rec.Code)
+	}
+	if rec := get("/api/peers/resolve?name=ghost"); rec.Code != http.StatusBadGateway {
+		t.Errorf("解析失败时应返回 502，实际 %d", rec.Code)
+	}
+}
+
+func TestLogLevels(t *testing.T) {
+	handler := newTestServer().Handler()
+	t.Cleanup(func() {
+		logger.SetLevel(logger.InfoLevel)
+		logger.SetModuleLevels(nil)
+	})
+	put := func(body string, csrf bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/log-levels", strings.NewReader(body))
+		req.Host = "127.0.0.1:7071"
+		if csrf {
+			req.Header.Set(CSRFHeader, "1")
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := put(`{"modules":{"p2p/signaling":"debug"}}`, false); rec.Code != http.StatusForbidden {
+		t.Fatalf("缺少 %s 请求头应返回 403，实际 %d", CSRFHeader, rec.Code)
+	}
+	if rec := put(`{"modules":{"p2p/signaling":"verbose"}}`, true); rec.Code != http.StatusBadRequest {
+		t.Fatalf("无效级别应返回 400，实际 %d", rec.Code)
+	}
+
+	rec := put(`{"level":"warn","modules":{"p2p/signaling":"debug"}}`, true)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("修改日志级别失败: %d %s", rec.Code, rec.Body.String())
+	}
+	var resp LogLevels
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+	if resp.Level != "warn" || resp.Modules["p2p/signaling"] != "debug" {
+		t.Fatalf("日志级别不正确: %+v", resp)
+	}
+}
+
+func TestUpdateApp(t *testing.T) {
+	server := newTestServer()
+	put := func(body string, csrf bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/apps", strings.NewReader(body))
+		req.Host = "127.0.0.1:7071"
+		if csrf {
+			req.Header.Set(CSRFHeader, "1")
+		}
+		rec := httptest.NewRecorder()
+		server.Handler().ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := put(`{"name":"web","srcPort":8081}`, false); rec.Code != http.StatusForbidden {
+		t.Fatalf("缺少 %s 请求头应返回 403，实际 %d", CSRFHeader, rec.Code)
+	}
+	if rec := put(`{"name":"web","srcPort":8081}`, true); rec.Code != http.StatusNotFound {
+		t.Fatalf("不支持修改应用时应返回 404，实际 %d", rec.Code)
+	}
+
+	var got AppUpdate
+	server.source.UpdateApp = func(update AppUpdate) error {
+		if update.Name == "dns" {
+			return errors.New("协议变化，需要重启转发器")
+		}
+		got = update
+		return nil
+	}
+	for _, body := range []string{`{"srcPort":8081}`, `{"name":"web","srcPort":70000}`, `{"name":"web","dstHost":""}`} {
+		if rec := put(body, true); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s 应返回 400，实际 %d", body, rec.Code)
+		}
+	}
+	if rec := put(`{"name":"dns","srcPort":5353}`, true); rec.Code != http.StatusConflict {
+		t.Fatalf("无法平滑更新时应返回 409，实际 %d", rec.Code)
+	}
+
+	if rec := put(`{"name":"web","srcPort":8081,"description":"新端口"}`, true); rec.Code != http.StatusOK {
+		t.Fatalf("修改应用失败: %d %s", rec.Code, rec.Body.String())
+	}
+	if got.Name != "web" || got.SrcPort == nil || *got.SrcPort != 8081 || got.Description == nil || *got.Description != "新端口" ||
+		got.Bind != nil || got.DstHost != nil || got.DstPort != nil {
+		t.Fatalf("更新请求不正确: %+v", got)
+	}
+}
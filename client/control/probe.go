@@ -0,0 +1,82 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// ProbeServer 存活和就绪探针，供 Kubernetes 等编排系统检查以 Sidecar 运行的客户端。
+// 探针从 Pod 外部访问，只返回是否就绪和原因，不提供控制接口的其他内容
+type ProbeServer struct {
+	address  string
+	ready    func() error
+	server   *http.Server
+	listener net.Listener
+}
+
+// NewProbeServer 创建探针，ready 返回 nil 表示就绪
+func NewProbeServer(address string, ready func() error) *ProbeServer {
+	s := &ProbeServer{
+		address: address,
+		ready:   ready,
+	}
+	s.server = &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	return s
+}
+
+// Start 开始监听
+func (s *ProbeServer) Start() error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return fmt.Errorf("监听探针地址失败: %w", err)
+	}
+	s.listener = listener
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("探针退出: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Addr 返回实际监听的地址
+func (s *ProbeServer) Addr() string {
+	if s.listener == nil {
+		return s.address
+	}
+	return s.listener.Addr().String()
+}
+
+// Stop 停止监听
+func (s *ProbeServer) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// Handler 返回探针的请求处理器：/healthz 在进程运行时返回 200，/readyz 在就绪时返回 200，否则返回 503 和原因
+func (s *ProbeServer) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		if err := s.ready(); err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, err)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
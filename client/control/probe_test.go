@@ -0,0 +1,39 @@
+package control
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	var notReady error = errors.New("应用 web 未运行")
+	handler := NewProbeServer("127.0.0.1:0", func() error { return notReady }).Handler()
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		// 探针从 Pod 外部访问，不限制 Host
+		req.Host = "10.0.0.12:8081"
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := get("/healthz"); rec.Code != http.StatusOK {
+		t.Fatalf("存活探针状态码 %d", rec.Code)
+	}
+	rec := get("/readyz")
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), "web") {
+		t.Fatalf("未就绪时状态码 %d，响应 %q", rec.Code, rec.Body.String())
+	}
+
+	notReady = nil
+	if rec := get("/readyz"); rec.Code != http.StatusOK {
+		t.Fatalf("就绪时状态码 %d", rec.Code)
+	}
+	if rec := get("/api/status"); rec.Code != http.StatusNotFound {
+		t.Fatalf("探针不应提供控制接口，状态码 %d", rec.Code)
+	}
+}
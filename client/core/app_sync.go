@@ -0,0 +1,164 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/common/logger"
+)
+
+// appsCache 应用配置缓存文件的内容
+type appsCache struct {
+	NodeID  string             `json:"nodeId"`
+	Version uint               `json:"version"`
+	Apps    []config.AppConfig `json:"apps"`
+	// 最近一次与服务端同步成功的时间，离线时据此判断缓存是否过期
+	SyncedAt time.Time `json:"syncedAt,omitempty"`
+}
+
+// AppSync 增量同步服务端下发的应用配置。
+// 同步结果保存在缓存文件中，重启后只获取上次同步之后的变化，应用较多时可以减少流量
+type AppSync struct {
+	client   *ServerClient
+	nodeID   string
+	filePath string
+	cache    appsCache
+	mu       sync.Mutex
+}
+
+// NewAppSync 创建应用配置同步，filePath 为空时不保存缓存
+func NewAppSync(client *ServerClient, nodeID, filePath string) *AppSync {
+	return &AppSync{
+		client:   client,
+		nodeID:   nodeID,
+		filePath: filePath,
+		cache:    appsCache{NodeID: nodeID},
+	}
+}
+
+// Load 加载缓存文件，文件不存在或属于其他节点时从头同步
+func (s *AppSync) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.filePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取应用配置缓存失败: %w", err)
+	}
+
+	var cache appsCache
+	if err := json.Unmarshal(data, &cache); err != nil {
+		return fmt.Errorf("解析应用配置缓存失败: %w", err)
+	}
+	if cache.NodeID == s.nodeID {
+		s.cache = cache
+	}
+	return nil
+}
+
+// Apps 获取缓存的应用列表
+func (s *AppSync) Apps() []config.AppConfig {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]config.AppConfig(nil), s.cache.Apps...)
+}
+
+// SyncedAt 获取最近一次与服务端同步成功的时间，从未同步时为零值
+func (s *AppSync) SyncedAt() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cache.SyncedAt
+}
+
+// Sync 获取上次同步之后的变化并合并到缓存，返回完整的应用列表
+func (s *AppSync) Sync() ([]config.AppConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	changes, err := s.client.GetAppChanges(s.cache.Version)
+	if err != nil {
+		return nil, err
+	}
+	if changes.Full || changes.Version != s.cache.Version {
+		s.cache.Apps = mergeApps(s.cache.Apps, changes)
+		s.cache.Version = changes.Version
+	}
+	// 没有变化时也保存同步时间
+	s.cache.SyncedAt = time.Now()
+	if err := s.save(); err != nil {
+		logger.Warn("%v", err)
+	}
+	return append([]config.AppConfig(nil), s.cache.Apps...), nil
+}
+
+// mergeApps 将变化合并到应用列表，已有的应用保持原来的顺序
+func mergeApps(apps []config.AppConfig, changes *AppChanges) []config.AppConfig {
+	if changes.Full {
+		return changes.Apps
+	}
+
+	removed := make(map[string]bool, len(changes.Removed))
+	for _, name := range changes.Removed {
+		removed[name] = true
+	}
+	updated := make(map[string]config.AppConfig, len(changes.Apps))
+	for _, app := range changes.Apps {
+		updated[app.Name] = app
+	}
+
+	merged := make([]config.AppConfig, 0, len(apps)+len(changes.Apps))
+	for _, app := range apps {
+		if removed[app.Name] {
+			continue
+		}
+		if app, ok := updated[app.Name]; ok {
+			merged = append(merged, app)
+			delete(updated, app.Name)
+			continue
+		}
+		merged = append(merged, app)
+	}
+	for _, app := range changes.Apps {
+		if _, ok := updated[app.Name]; ok {
+			merged = append(merged, app)
+		}
+	}
+	return merged
+}
+
+// save 保存缓存文件，先写入临时文件再重命名，避免写入中断损坏缓存。调用方需持有锁
+func (s *AppSync) save() error {
+	if s.filePath == "" {
+		return nil
+	}
+
+	dir := filepath.Dir(s.filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s.cache, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化应用配置缓存失败: %w", err)
+	}
+
+	tmpPath := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("写入应用配置缓存失败: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		return fmt.Errorf("保存应用配置缓存失败: %w", err)
+	}
+	return nil
+}
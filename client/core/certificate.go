@@ -0,0 +1,209 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/client/identity"
+	"github.com/senma231/p3/common/logger"
+)
+
+// certificateCheckInterval 检查证书状态、轮换证书和更新吊销列表的间隔
+const certificateCheckInterval = time.Hour
+
+// ErrCertificateUnsupported 服务端版本较旧或未启用设备证书
+var ErrCertificateUnsupported = errors.New("服务端不支持设备证书")
+
+// certificateStatus 服务端返回的证书状态
+type certificateStatus struct {
+	Serial string `json:"serial"`
+	Status string `json:"status"`
+}
+
+// EnrollCertificate 生成新的私钥和证书签名请求，向服务端内置 CA 申请设备证书并保存。
+// 已持有证书时使用证书认证，用于证书到期前的轮换
+func (c *ServerClient) EnrollCertificate() error {
+	if c.identity == nil {
+		return identity.ErrNotEnrolled
+	}
+	csr, key, err := identity.NewCertificateRequest()
+	if err != nil {
+		return err
+	}
+
+	// 发送请求
+	resp, err := c.post("/api/v1/device/certificate", map[string]string{"csr": string(csr)})
+	if err != nil {
+		return fmt.Errorf("申请设备证书失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrCertificateUnsupported
+	}
+	if resp.StatusCode != http.StatusCreated {
+		var result map[string]interface{}
+		errMsg := "未知错误"
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+			if errObj, ok := result["error"]; ok {
+				errMsg = fmt.Sprintf("%v", errObj)
+			}
+		}
+		return fmt.Errorf("申请设备证书失败: %s", errMsg)
+	}
+
+	// 解析响应
+	var issued struct {
+		Certificate string `json:"certificate"`
+		CA          string `json:"ca"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&issued); err != nil {
+		return fmt.Errorf("解析响应失败: %w", err)
+	}
+
+	return c.identity.Install(key, []byte(issued.Certificate), []byte(issued.CA))
+}
+
+// CertificateRevoked 查询当前证书是否已被吊销
+func (c *ServerClient) CertificateRevoked() (bool, error) {
+	serial := c.identity.Serial()
+	if serial == "" {
+		return false, identity.ErrNotEnrolled
+	}
+
+	// 发送请求
+	resp, err := c.get("/api/v1/pki/status/" + serial)
+	if err != nil {
+		return false, fmt.Errorf("查询证书状态失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode == http.StatusNotFound {
+		return false, ErrCertificateUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("查询证书状态失败: HTTP %d", resp.StatusCode)
+	}
+
+	var status certificateStatus
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return false, fmt.Errorf("解析响应失败: %w", err)
+	}
+	return status.Status == "revoked", nil
+}
+
+// UpdateRevocationList 下载 CA 的证书吊销列表，用于验证对端节点的证书
+func (c *ServerClient) UpdateRevocationList() error {
+	// 发送请求
+	resp, err := c.get("/api/v1/pki/crl")
+	if err != nil {
+		return fmt.Errorf("下载证书吊销列表失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrCertificateUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("下载证书吊销列表失败: HTTP %d", resp.StatusCode)
+	}
+
+	der, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("读取证书吊销列表失败: %w", err)
+	}
+	return c.identity.SetRevocationList(der)
+}
+
+// CertificateManager 维护设备证书：首次启动时申请证书，到期前轮换，证书被吊销后删除证书
+// 并重新申请，同时定期更新吊销列表
+type CertificateManager struct {
+	client   *ServerClient
+	identity *identity.Identity
+	interval time.Duration
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewCertificateManager 创建设备证书维护
+func NewCertificateManager(client *ServerClient, id *identity.Identity) *CertificateManager {
+	return &CertificateManager{
+		client:   client,
+		identity: id,
+		interval: certificateCheckInterval,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start 立即检查一次，之后按间隔定期检查
+func (m *CertificateManager) Start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			m.check()
+			select {
+			case <-m.stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop 停止检查
+func (m *CertificateManager) Stop() {
+	close(m.stopCh)
+	m.wg.Wait()
+}
+
+// check 检查一次证书状态
+func (m *CertificateManager) check() {
+	if m.identity.Enrolled() {
+		revoked, err := m.client.CertificateRevoked()
+		if errors.Is(err, ErrCertificateUnsupported) {
+			return
+		}
+		if err != nil {
+			logger.Warn("查询设备证书状态失败: %v", err)
+		} else if revoked {
+			// 吊销后回到令牌认证，由下面重新申请证书
+			logger.Warn("设备证书 %s 已被吊销，重新申请证书", m.identity.Serial())
+			if err := m.identity.Reset(); err != nil {
+				logger.Error("删除设备证书失败: %v", err)
+				return
+			}
+		}
+	}
+
+	if m.identity.NeedsRenewal(time.Now()) {
+		err := m.client.EnrollCertificate()
+		switch {
+		case errors.Is(err, ErrCertificateUnsupported):
+			logger.Debug("服务端未启用设备证书，继续使用令牌认证")
+			return
+		case err != nil:
+			logger.Warn("申请设备证书失败: %v", err)
+		default:
+			logger.Info("已取得设备证书 %s", m.identity.Serial())
+		}
+	}
+
+	if m.identity.CanVerifyPeers() {
+		if err := m.client.UpdateRevocationList(); err != nil && !errors.Is(err, ErrCertificateUnsupported) {
+			logger.Warn("更新证书吊销列表失败: %v", err)
+		}
+	}
+}
@@ -3,60 +3,54 @@ package core
 import (
 	"context"
 	"fmt"
-	"math/rand"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/senma231/p3/client/config"
 	"github.com/senma231/p3/client/nat"
 	"github.com/senma231/p3/client/p2p"
+	"github.com/senma231/p3/client/pmtu"
+	"github.com/senma231/p3/client/trace"
+	"github.com/senma231/p3/common/protocol"
 )
 
-// ConnectionType 表示连接类型
-type ConnectionType int
-
-const (
-	ConnectionUnknown   ConnectionType = iota
-	ConnectionDirect                   // 直接连接
-	ConnectionUPnP                     // UPnP 连接
-	ConnectionHolePunch                // 打洞连接
-	ConnectionRelay                    // 中继连接
-)
-
-// String 返回连接类型的字符串表示
-func (t ConnectionType) String() string {
+// traceMethod 获取连接类型对应的连接记录中的连接方式
+func traceMethod(t protocol.ConnectionType) string {
 	switch t {
-	case ConnectionDirect:
-		return "Direct"
-	case ConnectionUPnP:
-		return "UPnP"
-	case ConnectionHolePunch:
-		return "Hole Punch"
-	case ConnectionRelay:
-		return "Relay"
+	case protocol.ConnectionDirect:
+		return trace.MethodDirect
+	case protocol.ConnectionUPnP:
+		return trace.MethodUPnP
+	case protocol.ConnectionHolePunch:
+		return trace.MethodHolePunch
+	case protocol.ConnectionRelay:
+		return trace.MethodRelay
 	default:
-		return "Unknown"
+		return ""
 	}
 }
 
 // PeerInfo 存储对等节点信息
 type PeerInfo struct {
 	NodeID       string
-	NATType      nat.NATType
+	NATType      protocol.NATType
 	ExternalIP   net.IP
 	ExternalPort int
+	IPv6         net.IP // 对端的全局 IPv6 地址，为空且外部地址是 IPv6 地址时使用外部地址
 	LastSeen     time.Time
 }
 
 // Connection 表示一个 P2P 连接
 type Connection struct {
 	PeerID      string
-	Type        ConnectionType
+	Type        protocol.ConnectionType
 	Established time.Time
 	LastActive  time.Time
 	BytesSent   uint64
 	BytesRecv   uint64
+	MTU         int // UDP 连接协商的路径 MTU，TCP 连接为 0
 	conn        net.Conn
 	mu          sync.Mutex
 }
@@ -120,9 +114,15 @@ type Engine struct {
 	peers       map[string]*PeerInfo
 	connections map[string]*Connection
 	connector   *p2p.Connector
+	traces      *trace.Store
+	reportTrace func(*trace.Trace)
+	punches     *punchStats // 等待上报的打洞结果
+	// 服务端欢迎消息中下发的连接优先级，本地未配置 strategy.priority 时使用
+	serverPriority []string
 	mu          sync.RWMutex
 	ctx         context.Context
 	cancel      context.CancelFunc
+	wg          sync.WaitGroup // 连接尝试及其启动的协程，Stop 时等待退出
 }
 
 // NewEngine 创建一个新的 P2P 引擎
@@ -132,6 +132,7 @@ func NewEngine(cfg *config.Config) *Engine {
 		config:      cfg,
 		peers:       make(map[string]*PeerInfo),
 		connections: make(map[string]*Connection),
+		punches:     newPunchStats(),
 		ctx:         ctx,
 		cancel:      cancel,
 	}
@@ -144,12 +145,56 @@ func (e *Engine) SetConnector(connector *p2p.Connector) {
 	e.connector = connector
 }
 
+// SetTraceStore 设置连接记录存储，设置后每次连接的尝试过程都会保存
+func (e *Engine) SetTraceStore(store *trace.Store) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.traces = store
+}
+
+// SetTraceReporter 设置连接记录的上报函数，在连接结束后异步调用
+func (e *Engine) SetTraceReporter(report func(*trace.Trace)) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.reportTrace = report
+}
+
+// recordTrace 保存并上报连接记录，统计其中的打洞结果
+func (e *Engine) recordTrace(t *trace.Trace) {
+	e.mu.RLock()
+	store, report := e.traces, e.reportTrace
+	e.mu.RUnlock()
+
+	e.punches.record(t)
+
+	if store != nil {
+		if err := store.Add(t); err != nil {
+			fmt.Printf("保存连接记录失败: %v\n", err)
+		}
+	}
+	if report != nil {
+		e.wg.Add(1)
+		go func() {
+			defer e.wg.Done()
+			report(t)
+		}()
+	}
+}
+
+// localNATType 获取本地 NAT 类型
+func (e *Engine) localNATType() string {
+	if e.natInfo == nil {
+		return ""
+	}
+	return e.natInfo.Type.String()
+}
+
 // Start 启动 P2P 引擎
 func (e *Engine) Start() error {
 	// 检查是否设置了连接器
 	if e.connector == nil {
 		// 如果没有设置连接器，则使用默认的 NAT 检测
-		detector := nat.NewDetector(e.config.Network.STUNServers, 5*time.Second)
+		detector := nat.NewDetector(e.config.STUNServerList(), 5*time.Second)
 		natInfo, err := detector.Detect()
 		if err != nil {
 			return fmt.Errorf("NAT 类型检测失败: %w", err)
@@ -169,26 +214,62 @@ func (e *Engine) Start() error {
 	return nil
 }
 
-// Stop 停止 P2P 引擎
+// Stop 停止 P2P 引擎，关闭所有连接并等待进行中的连接尝试结束
 func (e *Engine) Stop() error {
-	e.cancel()
-
 	// 关闭所有连接
 	e.mu.Lock()
-	defer e.mu.Unlock()
-
+	e.cancel()
 	for _, conn := range e.connections {
 		if err := conn.Close(); err != nil {
 			// 记录错误但继续关闭其他连接
 			fmt.Printf("关闭连接 %s 失败: %v\n", conn.PeerID, err)
 		}
 	}
+	e.mu.Unlock()
 
+	e.wg.Wait()
 	return nil
 }
 
-// Connect 连接到对等节点
+// enter 登记一次连接尝试，调用方结束时调用 e.wg.Done。引擎已停止时返回 false
+func (e *Engine) enter() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.ctx.Err() != nil {
+		return false
+	}
+	e.wg.Add(1)
+	return true
+}
+
+// connectCandidate 一种候选连接方式
+type connectCandidate struct {
+	method  string
+	address string // 尝试的地址，为空时使用对端的外部地址
+	dial    func() (net.Conn, protocol.ConnectionType, error)
+}
+
+// candidateResult 候选连接方式的尝试结果
+type candidateResult struct {
+	candidate connectCandidate
+	startedAt time.Time
+	conn      net.Conn
+	connType  protocol.ConnectionType
+	err       error
+}
+
+// Connect 使用全局连接策略连接到对等节点
 func (e *Engine) Connect(peerID string) (*Connection, error) {
+	return e.ConnectWithStrategy(peerID, e.config.StrategyFor(""))
+}
+
+// ConnectWithStrategy 按连接策略连接到对等节点，应用使用 config.StrategyFor 获取自身的策略
+func (e *Engine) ConnectWithStrategy(peerID string, strategy config.StrategyConfig) (*Connection, error) {
+	if !e.enter() {
+		return nil, fmt.Errorf("P2P 引擎已停止")
+	}
+	defer e.wg.Done()
+
 	e.mu.RLock()
 	peer, exists := e.peers[peerID]
 	e.mu.RUnlock()
@@ -206,45 +287,64 @@ func (e *Engine) Connect(peerID string) (*Connection, error) {
 		return conn, nil
 	}
 
-	// 尝试建立连接
-	var netConn net.Conn
-	var connType ConnectionType
-	var err error
-
-	// 1. 尝试直接连接
-	if peer.NATType == nat.NATNone || e.natInfo.Type == nat.NATNone {
-		// 如果对方或自己有公网 IP，可以直接连接
-		netConn, err = e.directConnect(peer)
-		if err == nil {
-			connType = ConnectionDirect
+	// 尝试建立连接，记录每次尝试的方式、地址、错误和耗时
+	tr := trace.New(peerID, e.localNATType(), peer.NATType.String())
+	address := net.JoinHostPort(peer.ExternalIP.String(), strconv.Itoa(peer.ExternalPort))
+	timeout := time.Duration(strategy.CandidateTimeout) * time.Second
+
+	// 按连接优先级生成候选，不满足条件或不在优先级中的方式记录跳过原因
+	priority, source := e.candidatePriority(strategy)
+	tr.SetPriority(priority, source)
+	var candidates []connectCandidate
+	for _, method := range priority {
+		if candidate, reason := e.candidate(method, peer, strategy, timeout); reason != "" {
+			tr.Skip(method, reason)
+		} else {
+			candidates = append(candidates, candidate)
 		}
 	}
-
-	// 2. 尝试 UPnP 连接
-	if netConn == nil && e.natInfo.UPnPAvailable {
-		netConn, err = e.upnpConnect(peer)
-		if err == nil {
-			connType = ConnectionUPnP
+	for _, method := range protocol.DefaultCandidatePriority {
+		if !containsMethod(priority, method) {
+			tr.Skip(method, "不在连接优先级中")
 		}
 	}
 
-	// 3. 尝试打洞连接
-	if netConn == nil {
-		netConn, connType, err = e.holePunchConnect(peer)
+	relay := connectCandidate{
+		method: trace.MethodRelay,
+		dial: func() (net.Conn, protocol.ConnectionType, error) {
+			conn, err := e.relayConnect(peer)
+			return conn, protocol.ConnectionRelay, err
+		},
 	}
 
-	// 4. 尝试中继连接
-	if netConn == nil {
-		netConn, err = e.relayConnect(peer)
-		if err == nil {
-			connType = ConnectionRelay
+	// 中继按策略优先使用、作为最后的回退或不使用，且不参与并行尝试
+	var netConn net.Conn
+	var connType protocol.ConnectionType
+	switch strategy.Relay {
+	case config.RelayPrefer:
+		netConn, connType = e.tryCandidates(tr, address, []connectCandidate{relay}, 1)
+		if netConn == nil {
+			netConn, connType = e.tryCandidates(tr, address, candidates, strategy.MaxParallel)
+		}
+	case config.RelayDisable:
+		tr.Skip(trace.MethodRelay, "连接策略禁用了中继")
+		netConn, connType = e.tryCandidates(tr, address, candidates, strategy.MaxParallel)
+	default:
+		netConn, connType = e.tryCandidates(tr, address, candidates, strategy.MaxParallel)
+		if netConn == nil {
+			netConn, connType = e.tryCandidates(tr, address, []connectCandidate{relay}, 1)
 		}
 	}
 
 	// 如果所有尝试都失败
 	if netConn == nil {
-		return nil, fmt.Errorf("无法连接到对等节点: %s, 所有尝试都失败", peerID)
+		err := fmt.Errorf("无法连接到对等节点: %s, 所有尝试都失败", peerID)
+		tr.Finish("", err)
+		e.recordTrace(tr)
+		return nil, err
 	}
+	tr.Finish(traceMethod(connType), nil)
+	e.recordTrace(tr)
 
 	// 创建连接对象
 	conn = &Connection{
@@ -254,21 +354,156 @@ func (e *Engine) Connect(peerID string) (*Connection, error) {
 		LastActive:  time.Now(),
 		conn:        netConn,
 	}
+	if pc, ok := netConn.(*pmtu.Conn); ok {
+		conn.MTU = pc.MTU()
+	}
 
 	e.mu.Lock()
+	if e.ctx.Err() != nil {
+		// 连接期间引擎已停止
+		e.mu.Unlock()
+		netConn.Close()
+		return nil, fmt.Errorf("P2P 引擎已停止")
+	}
 	e.connections[peerID] = conn
 	e.mu.Unlock()
 
 	return conn, nil
 }
 
-// directConnect 直接连接
-func (e *Engine) directConnect(peer *PeerInfo) (net.Conn, error) {
+// candidate 生成一种连接方式的候选，不满足条件时返回跳过原因
+func (e *Engine) candidate(method string, peer *PeerInfo, strategy config.StrategyConfig, timeout time.Duration) (connectCandidate, string) {
+	c := connectCandidate{method: method}
+	switch method {
+	case protocol.CandidateIPv6:
+		ip := peerIPv6(peer)
+		if ip == nil {
+			return c, "对端没有全局 IPv6 地址"
+		}
+		if !localIPv6Available() {
+			return c, "本地没有全局 IPv6 地址"
+		}
+		c.address = net.JoinHostPort(ip.String(), strconv.Itoa(peer.ExternalPort))
+		c.dial = func() (net.Conn, protocol.ConnectionType, error) {
+			conn, err := e.ipv6Connect(c.address, timeout)
+			return conn, protocol.ConnectionDirect, err
+		}
+	case protocol.CandidateDirect:
+		// 如果对方或自己有公网 IP，可以直接连接
+		if peer.NATType != protocol.NATNone && e.natInfo.Type != protocol.NATNone {
+			return c, "双方都在 NAT 之后"
+		}
+		c.dial = func() (net.Conn, protocol.ConnectionType, error) {
+			conn, err := e.directConnect(peer, timeout)
+			return conn, protocol.ConnectionDirect, err
+		}
+	case protocol.CandidateUPnP:
+		if !e.natInfo.UPnPAvailable {
+			return c, "本地 UPnP 不可用"
+		}
+		c.dial = func() (net.Conn, protocol.ConnectionType, error) {
+			conn, err := e.upnpConnect(peer, timeout)
+			return conn, protocol.ConnectionUPnP, err
+		}
+	case protocol.CandidateUDPPunch:
+		c.dial = func() (net.Conn, protocol.ConnectionType, error) {
+			return e.holePunchConnect(peer, PunchUDP, timeout)
+		}
+	case protocol.CandidateTCPPunch:
+		if strategy.TCPPunch == config.TCPPunchDisable {
+			return c, "连接策略禁用了 TCP 打洞"
+		}
+		c.dial = func() (net.Conn, protocol.ConnectionType, error) {
+			return e.holePunchConnect(peer, PunchTCP, timeout)
+		}
+	default:
+		return c, "不支持的连接方式"
+	}
+	return c, ""
+}
+
+// tryCandidates 每次同时尝试最多 parallel 个候选，返回最先成功的连接。
+// 同一批中其他尝试稍后成功的连接会被关闭，未完成的尝试在连接记录中标记为跳过
+func (e *Engine) tryCandidates(tr *trace.Trace, address string, candidates []connectCandidate, parallel int) (net.Conn, protocol.ConnectionType) {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	for start := 0; start < len(candidates); start += parallel {
+		end := start + parallel
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+		batch := candidates[start:end]
+
+		results := make(chan *candidateResult, len(batch))
+		for _, c := range batch {
+			e.wg.Add(1)
+			go func(c connectCandidate) {
+				defer e.wg.Done()
+				result := &candidateResult{candidate: c, startedAt: time.Now()}
+				result.conn, result.connType, result.err = c.dial()
+				results <- result
+			}(c)
+		}
+
+		pending := make(map[string]bool, len(batch))
+		for _, c := range batch {
+			pending[c.method] = true
+		}
+		for received := 0; received < len(batch); received++ {
+			result := <-results
+			delete(pending, result.candidate.method)
+			tr.Record(result.candidate.method, candidateAddress(result.candidate, address), result.startedAt, result.err)
+			if result.err != nil {
+				continue
+			}
+
+			// 关闭同一批中稍后成功的连接
+			remaining := len(batch) - received - 1
+			e.wg.Add(1)
+			go func() {
+				defer e.wg.Done()
+				for i := 0; i < remaining; i++ {
+					if late := <-results; late.err == nil && late.conn != nil {
+						late.conn.Close()
+					}
+				}
+			}()
+			for _, c := range batch {
+				if pending[c.method] {
+					tr.Skip(c.method, trace.MethodName(result.candidate.method)+"已先成功")
+				}
+			}
+			return result.conn, result.connType
+		}
+	}
+	return nil, protocol.ConnectionUnknown
+}
+
+// candidateAddress 获取连接记录中候选的地址，中继的地址由服务端分配
+func candidateAddress(c connectCandidate, address string) string {
+	if c.method == trace.MethodRelay {
+		return ""
+	}
+	if c.address != "" {
+		return c.address
+	}
+	return address
+}
+
+// directConnect 直接连接，timeout 为 0 时使用默认超时
+func (e *Engine) directConnect(peer *PeerInfo, timeout time.Duration) (net.Conn, error) {
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
 	// 创建目标地址
 	peerAddr := net.JoinHostPort(peer.ExternalIP.String(), fmt.Sprintf("%d", peer.ExternalPort))
 
-	// 尝试连接
-	conn, err := net.DialTimeout("tcp", peerAddr, 5*time.Second)
+	// 尝试连接，引擎停止时取消
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(e.ctx, "tcp", peerAddr)
 	if err != nil {
 		return nil, fmt.Errorf("直接连接失败: %w", err)
 	}
@@ -276,11 +511,29 @@ func (e *Engine) directConnect(peer *PeerInfo) (net.Conn, error) {
 	return conn, nil
 }
 
-// upnpConnect 使用 UPnP 连接
-func (e *Engine) upnpConnect(peer *PeerInfo) (net.Conn, error) {
+// ipv6Connect 通过对端的全局 IPv6 地址直接连接，timeout 为 0 时使用默认超时
+func (e *Engine) ipv6Connect(address string, timeout time.Duration) (net.Conn, error) {
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(e.ctx, "tcp6", address)
+	if err != nil {
+		return nil, fmt.Errorf("IPv6 连接失败: %w", err)
+	}
+	return conn, nil
+}
+
+// upnpConnect 使用 UPnP 连接，timeout 为 0 时使用默认超时
+func (e *Engine) upnpConnect(peer *PeerInfo, timeout time.Duration) (net.Conn, error) {
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
 	// 使用 UPnP 映射端口
-	port := 10000 + rand.Intn(10000) // 随机端口
-	success, err := nat.UPnPMapping(port, "TCP", "P3 Connection")
+	port := nat.MappingRandomPort() // 允许范围内的随机端口
+	success, err := nat.UPnPMapping(port, "TCP", "Connection")
 	if err != nil || !success {
 		return nil, fmt.Errorf("UPnP 映射失败: %w", err)
 	}
@@ -298,7 +551,7 @@ func (e *Engine) upnpConnect(peer *PeerInfo) (net.Conn, error) {
 	// TODO: 实现信令通道，通知对方连接
 
 	// 等待连接
-	listener.(*net.TCPListener).SetDeadline(time.Now().Add(10 * time.Second))
+	listener.(*net.TCPListener).SetDeadline(time.Now().Add(timeout))
 	conn, err := listener.Accept()
 	if err != nil {
 		// 删除端口映射
@@ -319,29 +572,34 @@ func (e *Engine) upnpConnect(peer *PeerInfo) (net.Conn, error) {
 	return conn, nil
 }
 
-// holePunchConnect 使用打洞连接
-func (e *Engine) holePunchConnect(peer *PeerInfo) (net.Conn, ConnectionType, error) {
-	// 创建打洞器
-	puncher := NewPuncher(e.config.Network.UDPPort1, e.natInfo, 10*time.Second, 5)
+// holePunchConnect 使用 UDP 或 TCP 打洞连接，timeout 为 0 时使用默认超时
+func (e *Engine) holePunchConnect(peer *PeerInfo, punchType PunchType, timeout time.Duration) (net.Conn, protocol.ConnectionType, error) {
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	// 创建打洞器，只尝试指定的打洞类型
+	puncher := NewPuncher(e.config.Network.UDPPort1, e.natInfo, timeout, 5)
+	puncher.SetUDPPunch(punchType == PunchUDP)
+	puncher.SetTCPPunch(punchType == PunchTCP)
 
 	// 尝试打洞
 	result := puncher.Punch(peer.ExternalIP, peer.ExternalPort, peer.NATType)
 	if !result.Success {
-		return nil, ConnectionUnknown, fmt.Errorf("打洞失败: %v", result.Error)
+		return nil, protocol.ConnectionUnknown, fmt.Errorf("打洞失败: %v", result.Error)
 	}
 
-	// 根据打洞类型返回连接类型
-	var connType ConnectionType
-	if result.Type == PunchUDP {
-		connType = ConnectionHolePunch
-	} else if result.Type == PunchTCP {
-		connType = ConnectionHolePunch
-	} else {
+	// 根据打洞类型返回连接
+	switch result.Type {
+	case PunchUDP:
+		// UDP 路径按协商的 MTU 分片收发
+		return pmtu.DiscoverConn(result.Conn, pmtu.DefaultTimeout), protocol.ConnectionHolePunch, nil
+	case PunchTCP:
+		return result.Conn, protocol.ConnectionHolePunch, nil
+	default:
 		result.Conn.Close()
-		return nil, ConnectionUnknown, fmt.Errorf("不支持的打洞类型: %s", result.Type)
+		return nil, protocol.ConnectionUnknown, fmt.Errorf("不支持的打洞类型: %s", result.Type)
 	}
-
-	return result.Conn, connType, nil
 }
 
 // relayConnect 使用中继连接
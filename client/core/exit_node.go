@@ -0,0 +1,295 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/common/logger"
+)
+
+var (
+	// ErrExitNodeDown 出口节点不可用且启用了断网保护
+	ErrExitNodeDown = errors.New("出口节点不可用，流量已被阻断")
+	// ErrExitNodeUDP 应经出口节点转发的 UDP 流量在启用断网保护时被阻断，出口节点只转发 TCP 连接
+	ErrExitNodeUDP = errors.New("出口节点只转发 TCP 连接，UDP 流量已被阻断")
+)
+
+// ExitNode 出口节点信息
+type ExitNode struct {
+	DeviceID uint   `json:"deviceId"`
+	NodeID   string `json:"nodeId"`
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+}
+
+// DialFunc 拨号函数
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// lanNetworks 局域网网段
+var lanNetworks = []string{
+	"10.0.0.0/8",
+	"172.16.0.0/12",
+	"192.168.0.0/16",
+	"169.254.0.0/16",
+	"fc00::/7",
+	"fe80::/10",
+}
+
+// ExitNodeRouter 出口节点路由器，决定流量是否经出口节点转发
+type ExitNodeRouter struct {
+	config    config.ExitNodeConfig
+	routes    []*net.IPNet
+	lan       []*net.IPNet
+	connected bool
+	mu        sync.RWMutex
+}
+
+// NewExitNodeRouter 创建出口节点路由器
+func NewExitNodeRouter(cfg config.ExitNodeConfig) (*ExitNodeRouter, error) {
+	r := &ExitNodeRouter{
+		config: cfg,
+	}
+
+	for _, cidr := range cfg.Routes {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("无效的出口节点路由 %s: %w", cidr, err)
+		}
+		r.routes = append(r.routes, ipNet)
+	}
+
+	for _, cidr := range lanNetworks {
+		_, ipNet, _ := net.ParseCIDR(cidr)
+		r.lan = append(r.lan, ipNet)
+	}
+
+	return r, nil
+}
+
+// Enabled 检查是否启用了出口节点
+func (r *ExitNodeRouter) Enabled() bool {
+	return r.config.Use != ""
+}
+
+// NodeID 获取出口节点 ID
+func (r *ExitNodeRouter) NodeID() string {
+	return r.config.Use
+}
+
+// SetConnected 设置出口节点连接状态
+func (r *ExitNodeRouter) SetConnected(connected bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.connected = connected
+}
+
+// Refresh 按服务端返回的可用出口节点更新连接状态，配置的出口节点在列表中且在线时视为已连接
+func (r *ExitNodeRouter) Refresh(nodes []ExitNode) {
+	connected := false
+	for _, node := range nodes {
+		if node.NodeID == r.config.Use && node.Status == "online" {
+			connected = true
+			break
+		}
+	}
+	if connected != r.IsConnected() {
+		if connected {
+			logger.Info("出口节点 %s 可用", r.config.Use)
+		} else if r.config.KillSwitch {
+			logger.Warn("出口节点 %s 不可用，断网保护已阻断经出口节点的流量", r.config.Use)
+		} else {
+			logger.Warn("出口节点 %s 不可用，流量回落到直连", r.config.Use)
+		}
+	}
+	r.SetConnected(connected)
+}
+
+// IsConnected 检查出口节点是否已连接
+func (r *ExitNodeRouter) IsConnected() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.connected
+}
+
+// ShouldRoute 检查目标地址是否应经出口节点转发
+func (r *ExitNodeRouter) ShouldRoute(ip net.IP) bool {
+	if !r.Enabled() || ip == nil {
+		return false
+	}
+
+	// 本地回环地址始终直连
+	if ip.IsLoopback() {
+		return false
+	}
+
+	// 局域网流量直连
+	if r.config.AllowLAN {
+		for _, ipNet := range r.lan {
+			if ipNet.Contains(ip) {
+				return false
+			}
+		}
+	}
+
+	// 未配置路由时转发全部流量
+	if len(r.routes) == 0 {
+		return true
+	}
+
+	for _, ipNet := range r.routes {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Decide 决定目标地址的出口，返回 true 表示经出口节点转发
+func (r *ExitNodeRouter) Decide(ip net.IP) (bool, error) {
+	if !r.ShouldRoute(ip) {
+		return false, nil
+	}
+
+	if r.IsConnected() {
+		return true, nil
+	}
+
+	// 出口节点断开时，启用断网保护则阻断，否则回落到直连
+	if r.config.KillSwitch {
+		return false, ErrExitNodeDown
+	}
+	return false, nil
+}
+
+// Resolver 创建经出口节点查询的 DNS 解析器
+func (r *ExitNodeRouter) Resolver(dial DialFunc) *net.Resolver {
+	if len(r.config.DNSServers) == 0 {
+		return net.DefaultResolver
+	}
+
+	servers := r.config.DNSServers
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var lastErr error
+			for _, server := range servers {
+				// 经出口节点的连接只转发 TCP，DNS 查询使用 TCP
+				conn, err := dial(ctx, "tcp", net.JoinHostPort(server, "53"))
+				if err == nil {
+					return conn, nil
+				}
+				lastErr = err
+			}
+			return nil, fmt.Errorf("连接 DNS 服务器失败: %w", lastErr)
+		},
+	}
+}
+
+// Dialer 按路由连接目标地址：应经出口节点转发且出口节点可用的 TCP 连接使用 viaExit，其他连接使用 direct。
+// 目标为域名时，出口节点可用且配置了 DNS 服务器则经出口节点解析，否则使用系统解析器。
+// 出口节点不可用且启用断网保护时返回 ErrExitNodeDown，应转发的 UDP 流量返回 ErrExitNodeUDP
+func (r *ExitNodeRouter) Dialer(direct, viaExit DialFunc) DialFunc {
+	resolver := r.Resolver(viaExit)
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if !r.Enabled() {
+			return direct(ctx, network, address)
+		}
+
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			lookup := net.DefaultResolver
+			if r.IsConnected() {
+				lookup = resolver
+			}
+			addrs, err := lookup.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, fmt.Errorf("解析 %s 失败: %w", host, err)
+			}
+			if len(addrs) == 0 {
+				return nil, fmt.Errorf("解析 %s 没有结果", host)
+			}
+			ip = addrs[0].IP
+			address = net.JoinHostPort(ip.String(), port)
+		}
+
+		route, err := r.Decide(ip)
+		if err != nil {
+			return nil, err
+		}
+		if !route {
+			return direct(ctx, network, address)
+		}
+		if !strings.HasPrefix(network, "tcp") {
+			if r.config.KillSwitch {
+				return nil, ErrExitNodeUDP
+			}
+			return direct(ctx, network, address)
+		}
+		return viaExit(ctx, network, address)
+	}
+}
+
+// ExitNodeMonitor 按间隔向服务端查询可用的出口节点，更新路由器的连接状态
+type ExitNodeMonitor struct {
+	router   *ExitNodeRouter
+	list     func() ([]ExitNode, error)
+	interval time.Duration
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewExitNodeMonitor 创建出口节点状态监控，list 获取本节点可以使用的出口节点
+func NewExitNodeMonitor(router *ExitNodeRouter, list func() ([]ExitNode, error), interval time.Duration) *ExitNodeMonitor {
+	return &ExitNodeMonitor{
+		router:   router,
+		list:     list,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start 在后台立即查询一次，之后按间隔定期查询
+func (m *ExitNodeMonitor) Start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+
+		for {
+			m.run()
+			select {
+			case <-m.stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop 停止监控
+func (m *ExitNodeMonitor) Stop() {
+	close(m.stopCh)
+	m.wg.Wait()
+}
+
+// run 查询一次。无法访问服务端时保持当前状态，已建立的判断不因服务端短暂不可用而改变
+func (m *ExitNodeMonitor) run() {
+	nodes, err := m.list()
+	if err != nil {
+		logger.Debug("获取出口节点失败: %v", err)
+		return
+	}
+	m.router.Refresh(nodes)
+}
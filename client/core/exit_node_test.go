@@ -0,0 +1,163 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/senma231/p3/client/config"
+)
+
+func TestExitNodeRouterDecide(t *testing.T) {
+	r, err := NewExitNodeRouter(config.ExitNodeConfig{Use: "exit", KillSwitch: true, AllowLAN: true})
+	if err != nil {
+		t.Fatalf("创建路由器失败: %v", err)
+	}
+
+	tests := []struct {
+		ip        string
+		connected bool
+		route     bool
+		err       error
+	}{
+		{ip: "8.8.8.8", connected: true, route: true},
+		{ip: "8.8.8.8", connected: false, err: ErrExitNodeDown},
+		{ip: "192.168.1.10", connected: false},
+		{ip: "127.0.0.1", connected: false},
+	}
+	for _, tt := range tests {
+		r.SetConnected(tt.connected)
+		route, err := r.Decide(net.ParseIP(tt.ip))
+		if route != tt.route || !errors.Is(err, tt.err) {
+			t.Errorf("Decide(%s, connected=%t) = %t, %v, 期望 %t, %v", tt.ip, tt.connected, route, err, tt.route, tt.err)
+		}
+	}
+
+	// 未启用断网保护时回落到直连
+	r, _ = NewExitNodeRouter(config.ExitNodeConfig{Use: "exit", Routes: []string{"10.8.0.0/16"}})
+	if route, err := r.Decide(net.ParseIP("10.8.1.1")); route || err != nil {
+		t.Fatalf("出口节点断开时应直连, 实际为 %t, %v", route, err)
+	}
+	r.SetConnected(true)
+	if route, _ := r.Decide(net.ParseIP("1.1.1.1")); route {
+		t.Fatal("不在路由中的地址不应经出口节点转发")
+	}
+
+	// 按服务端返回的出口节点更新状态
+	r.Refresh([]ExitNode{{NodeID: "exit", Status: "offline"}, {NodeID: "other", Status: "online"}})
+	if r.IsConnected() {
+		t.Fatal("出口节点离线时应视为断开")
+	}
+	r.Refresh([]ExitNode{{NodeID: "exit", Status: "online"}})
+	if !r.IsConnected() {
+		t.Fatal("出口节点在线时应视为已连接")
+	}
+}
+
+func TestExitNodeDialer(t *testing.T) {
+	r, _ := NewExitNodeRouter(config.ExitNodeConfig{Use: "exit", KillSwitch: true, AllowLAN: true})
+
+	var used string
+	fake := func(name string) DialFunc {
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			used = name + " " + network + " " + address
+			return nil, nil
+		}
+	}
+	dial := r.Dialer(fake("direct"), fake("exit"))
+
+	r.SetConnected(true)
+	dial(context.Background(), "tcp", "8.8.8.8:443")
+	if used != "exit tcp 8.8.8.8:443" {
+		t.Fatalf("应经出口节点连接, 实际为 %q", used)
+	}
+	dial(context.Background(), "tcp", "192.168.1.2:22")
+	if used != "direct tcp 192.168.1.2:22" {
+		t.Fatalf("局域网地址应直连, 实际为 %q", used)
+	}
+	if _, err := dial(context.Background(), "udp", "8.8.8.8:53"); !errors.Is(err, ErrExitNodeUDP) {
+		t.Fatalf("启用断网保护时应转发的 UDP 流量应被阻断, 实际为 %v", err)
+	}
+
+	// 出口节点断开时断网保护生效
+	r.SetConnected(false)
+	used = ""
+	if _, err := dial(context.Background(), "tcp", "8.8.8.8:443"); !errors.Is(err, ErrExitNodeDown) || used != "" {
+		t.Fatalf("出口节点断开时应阻断, 实际为 %v, %q", err, used)
+	}
+}
+
+// exitPeer 返回连接到转发服务的对等连接
+func exitPeer(server *PeerGateway, peerID string) PeerDialFunc {
+	return func(nodeID string) (net.Conn, error) {
+		local, remote := net.Pipe()
+		server.HandleConn(peerID, remote)
+		return local, nil
+	}
+}
+
+func TestExitNodeTunnel(t *testing.T) {
+	// 回显服务代表出口节点所在网络中的目标
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	var dialed string
+	server := NewPeerGateway(func(peerID, address string) error {
+		if peerID != "node-a" {
+			return errors.New("无权使用出口节点")
+		}
+		return nil
+	}, func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed = address
+		return (&net.Dialer{}).DialContext(ctx, network, echo.Addr().String())
+	})
+
+	dial := DialViaPeer(exitPeer(server, "node-a"), "exit")
+	conn, err := dial(context.Background(), "tcp", "203.0.113.5:80")
+	if err != nil {
+		t.Fatalf("经出口节点连接失败: %v", err)
+	}
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+	buf := make([]byte, 5)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "hello" {
+		t.Fatalf("接收 %q, %v", buf, err)
+	}
+	conn.Close()
+	if dialed != "203.0.113.5:80" {
+		t.Fatalf("出口节点应连接请求的目标, 实际为 %q", dialed)
+	}
+
+	// 未授权的对端和本机地址被拒绝
+	if _, err := DialViaPeer(exitPeer(server, "node-b"), "exit")(context.Background(), "tcp", "203.0.113.5:80"); err == nil || !strings.Contains(err.Error(), "无权") {
+		t.Fatalf("未授权的对端应被拒绝, 实际为 %v", err)
+	}
+	for _, address := range []string{"127.0.0.1:22", "169.254.169.254:80", "[::1]:22"} {
+		if _, err := dial(context.Background(), "tcp", address); err == nil {
+			t.Errorf("不应经出口节点访问 %s", address)
+		}
+	}
+	if _, err := dial(context.Background(), "udp", "203.0.113.5:53"); err == nil {
+		t.Error("出口节点不应转发 UDP")
+	}
+	server.Wait()
+}
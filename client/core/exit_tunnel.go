@@ -0,0 +1,181 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+)
+
+// 经出口节点或子网路由的通告节点转发的连接在对等连接上先发送一行请求：EXIT <network> <address>，
+// 对端连接目标后回复 OK，失败时回复 ERR <原因>，之后双向转发数据
+const (
+	exitRequestPrefix = "EXIT "
+	exitReplyOK       = "OK"
+	exitReplyError    = "ERR "
+	// exitMaxLine 请求和回复行的最大长度
+	exitMaxLine = 512
+	// exitHandshakeTimeout 等待请求或回复的超时时间
+	exitHandshakeTimeout = 10 * time.Second
+)
+
+// PeerDialFunc 建立到对等节点的连接
+type PeerDialFunc func(peerID string) (net.Conn, error)
+
+// DialViaPeer 创建经对等节点 nodeID 连接目标地址的拨号函数，每个连接使用一条对等连接
+func DialViaPeer(open PeerDialFunc, nodeID string) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := open(nodeID)
+		if err != nil {
+			return nil, fmt.Errorf("连接节点 %s 失败: %w", nodeID, err)
+		}
+
+		deadline := time.Now().Add(exitHandshakeTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		conn.SetDeadline(deadline)
+		if _, err := fmt.Fprintf(conn, "%s%s %s\n", exitRequestPrefix, network, address); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("发送转发请求失败: %w", err)
+		}
+		reply, err := readExitLine(conn)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("读取转发回复失败: %w", err)
+		}
+		if reply != exitReplyOK {
+			conn.Close()
+			return nil, fmt.Errorf("节点 %s 拒绝连接 %s: %s", nodeID, address, strings.TrimPrefix(reply, exitReplyError))
+		}
+		conn.SetDeadline(time.Time{})
+		return conn, nil
+	}
+}
+
+// PeerGateway 作为出口节点或子网路由的通告节点为对端转发连接。每个目标地址须经 authorize 检查，
+// 只转发 TCP 连接，不转发到本机回环、链路本地和未指定地址
+type PeerGateway struct {
+	authorize func(peerID, address string) error
+	dial      DialFunc
+	wg        sync.WaitGroup
+}
+
+// NewPeerGateway 创建转发服务，authorize 检查对端是否有权经本节点访问目标地址，dial 为 nil 时直接连接目标
+func NewPeerGateway(authorize func(peerID, address string) error, dial DialFunc) *PeerGateway {
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: exitHandshakeTimeout}).DialContext
+	}
+	return &PeerGateway{authorize: authorize, dial: dial}
+}
+
+// HandleConn 处理对端建立的对等连接，在后台转发，连接结束后关闭
+func (s *PeerGateway) HandleConn(peerID string, conn net.Conn) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer conn.Close()
+		if err := s.serve(peerID, conn); err != nil {
+			logger.Warn("为节点 %s 转发流量失败: %v", peerID, err)
+		}
+	}()
+}
+
+// Wait 等待正在转发的连接结束
+func (s *PeerGateway) Wait() {
+	s.wg.Wait()
+}
+
+// serve 读取请求、连接目标并双向转发
+func (s *PeerGateway) serve(peerID string, conn net.Conn) error {
+	conn.SetDeadline(time.Now().Add(exitHandshakeTimeout))
+	line, err := readExitLine(conn)
+	if err != nil {
+		return fmt.Errorf("读取请求失败: %w", err)
+	}
+	fields := strings.Fields(strings.TrimPrefix(line, exitRequestPrefix))
+	if !strings.HasPrefix(line, exitRequestPrefix) || len(fields) != 2 {
+		return errors.New("无效的转发请求")
+	}
+	network, address := fields[0], fields[1]
+
+	reject := func(err error) error {
+		fmt.Fprintf(conn, "%s%s\n", exitReplyError, err)
+		return err
+	}
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return reject(fmt.Errorf("不支持的网络类型: %s", network))
+	}
+	if err := checkExitTarget(address); err != nil {
+		return reject(err)
+	}
+	if err := s.authorize(peerID, address); err != nil {
+		return reject(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exitHandshakeTimeout)
+	target, err := s.dial(ctx, network, address)
+	cancel()
+	if err != nil {
+		return reject(fmt.Errorf("连接 %s 失败", address))
+	}
+	defer target.Close()
+
+	if _, err := fmt.Fprintf(conn, "%s\n", exitReplyOK); err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Time{})
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(target, conn)
+		target.Close()
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(conn, target)
+		conn.Close()
+		done <- struct{}{}
+	}()
+	<-done
+	<-done
+	return nil
+}
+
+// checkExitTarget 检查目标地址，不为对端访问本机和链路本地地址（如云服务器元数据服务）
+func checkExitTarget(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("无效的目标地址: %s", address)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("目标地址须为 IP 地址: %s", address)
+	}
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() {
+		return fmt.Errorf("不允许经本节点访问 %s", ip)
+	}
+	return nil
+}
+
+// readExitLine 逐字节读取一行，不多读行后的数据
+func readExitLine(conn net.Conn) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < exitMaxLine {
+		if _, err := io.ReadFull(conn, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("请求行过长")
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+)
+
+// 服务端下发的批量操作
+const (
+	FleetRestart     = "restart"       // 重启客户端服务
+	FleetPushConfig  = "push-config"   // 立即同步应用配置
+	FleetSetLogLevel = "set-log-level" // 修改日志级别，参数 level，指定 module 时只修改该模块
+	FleetNATDetect   = "nat-detect"    // 重新检测 NAT 类型
+)
+
+// FleetCommand 服务端下发的批量操作指令
+type FleetCommand struct {
+	JobID  string            `json:"jobId"`
+	Action string            `json:"action"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// FleetAction 执行批量操作，返回的错误作为执行结果回报到服务端
+type FleetAction func(params map[string]string) error
+
+// FleetHandler 执行服务端通过信令下发的批量操作，并将执行结果回报到服务端
+type FleetHandler struct {
+	client  *ServerClient
+	actions map[string]FleetAction
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+}
+
+// NewFleetHandler 创建批量操作处理器
+func NewFleetHandler(client *ServerClient) *FleetHandler {
+	return &FleetHandler{
+		client:  client,
+		actions: make(map[string]FleetAction),
+	}
+}
+
+// Handle 注册操作的执行函数
+func (h *FleetHandler) Handle(action string, fn FleetAction) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.actions[action] = fn
+}
+
+// HandleSignal 处理批量操作信令，操作在后台执行，不阻塞信令的接收
+func (h *FleetHandler) HandleSignal(signal *protocol.Signal) {
+	// 重新解析负载
+	data, err := json.Marshal(signal.Payload)
+	if err != nil {
+		logger.Error("解析批量操作指令失败: %v", err)
+		return
+	}
+	var cmd FleetCommand
+	if err := json.Unmarshal(data, &cmd); err != nil {
+		logger.Error("解析批量操作指令失败: %v", err)
+		return
+	}
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+		h.run(&cmd)
+	}()
+}
+
+// Wait 等待正在执行的操作完成并回报结果
+func (h *FleetHandler) Wait() {
+	h.wg.Wait()
+}
+
+// run 执行操作并回报结果
+func (h *FleetHandler) run(cmd *FleetCommand) {
+	h.mu.Lock()
+	fn, exists := h.actions[cmd.Action]
+	h.mu.Unlock()
+
+	var err error
+	if exists {
+		logger.Info("执行批量操作 %s: %s", cmd.JobID, cmd.Action)
+		err = fn(cmd.Params)
+	} else {
+		err = fmt.Errorf("不支持的操作: %s", cmd.Action)
+	}
+	if err != nil {
+		logger.Warn("批量操作 %s 执行失败: %v", cmd.JobID, err)
+	}
+
+	if err := h.client.ReportCommandResult(cmd.JobID, err); err != nil {
+		logger.Warn("%v", err)
+	}
+}
+
+// ReportCommandResult 回报批量操作的执行结果，cmdErr 为 nil 表示执行成功
+func (c *ServerClient) ReportCommandResult(jobID string, cmdErr error) error {
+	body := map[string]interface{}{
+		"success": cmdErr == nil,
+	}
+	if cmdErr != nil {
+		body["error"] = cmdErr.Error()
+	}
+
+	// 发送请求
+	resp, err := c.post("/api/v1/device/commands/"+jobID+"/result", body)
+	if err != nil {
+		return fmt.Errorf("回报批量操作结果失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusOK {
+		var result map[string]interface{}
+		errMsg := "未知错误"
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+			if errObj, ok := result["error"]; ok {
+				errMsg = fmt.Sprintf("%v", errObj)
+			}
+		}
+		return fmt.Errorf("回报批量操作结果失败: %s", errMsg)
+	}
+
+	return nil
+}
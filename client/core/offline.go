@@ -0,0 +1,223 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/common/logger"
+)
+
+// offlineState 离线状态文件的内容
+type offlineState struct {
+	NodeID string `json:"nodeId"`
+	// 对端节点最近一次收到的在线状态
+	Peers     map[string]bool `json:"peers"`
+	UpdatedAt time.Time       `json:"updatedAt,omitempty"`
+}
+
+// OfflineStore 保存对端节点最近一次的在线状态。服务端不可用时信令也无法连接，
+// 配置了 startWhenPeerOnline 的应用据此决定是否启动
+type OfflineStore struct {
+	nodeID   string
+	filePath string
+	state    offlineState
+	mu       sync.Mutex
+}
+
+// NewOfflineStore 创建离线状态存储，filePath 为空时不保存
+func NewOfflineStore(nodeID, filePath string) *OfflineStore {
+	return &OfflineStore{
+		nodeID:   nodeID,
+		filePath: filePath,
+		state:    offlineState{NodeID: nodeID, Peers: make(map[string]bool)},
+	}
+}
+
+// Load 加载状态文件，文件不存在或属于其他节点时忽略
+func (s *OfflineStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.filePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取离线状态失败: %w", err)
+	}
+
+	var state offlineState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("解析离线状态失败: %w", err)
+	}
+	if state.NodeID == s.nodeID {
+		if state.Peers == nil {
+			state.Peers = make(map[string]bool)
+		}
+		s.state = state
+	}
+	return nil
+}
+
+// SetPeerOnline 记录对端节点的在线状态，状态变化时保存
+func (s *OfflineStore) SetPeerOnline(nodeID string, online bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if previous, ok := s.state.Peers[nodeID]; ok && previous == online {
+		return
+	}
+	s.state.Peers[nodeID] = online
+	s.state.UpdatedAt = time.Now()
+	if err := s.save(); err != nil {
+		logger.Warn("%v", err)
+	}
+}
+
+// PeerOnline 获取对端节点最近一次的在线状态，从未收到时 known 为 false
+func (s *OfflineStore) PeerOnline(nodeID string) (online, known bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	online, known = s.state.Peers[nodeID]
+	return online, known
+}
+
+// save 保存状态文件，先写入临时文件再重命名。调用方需持有锁
+func (s *OfflineStore) save() error {
+	if s.filePath == "" {
+		return nil
+	}
+
+	dir := filepath.Dir(s.filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化离线状态失败: %w", err)
+	}
+
+	tmpPath := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("写入离线状态失败: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		return fmt.Errorf("保存离线状态失败: %w", err)
+	}
+	return nil
+}
+
+// OfflineApps 无法从服务端获取应用配置时按离线策略选择启动的应用，返回应用列表和说明。
+//
+// cached 策略使用缓存的应用配置（缓存为空或超过有效期时退回本地配置），并去掉禁止离线启动的应用；
+// local 策略只使用本地配置；stop 策略不启动任何应用
+func OfflineApps(cfg *config.Config, appSync *AppSync, now time.Time) ([]config.AppConfig, string) {
+	switch cfg.Offline.Policy {
+	case config.OfflineStop:
+		return nil, "离线策略为 stop，不启动应用"
+	case config.OfflineLocal:
+		return cfg.Apps, "使用本地配置"
+	}
+
+	cached := appSync.Apps()
+	if len(cached) == 0 {
+		return cfg.Apps, "没有缓存的配置，使用本地配置"
+	}
+	syncedAt := appSync.SyncedAt()
+	if maxAge := cfg.Offline.MaxAgeDuration(); maxAge > 0 && !syncedAt.IsZero() && now.Sub(syncedAt) > maxAge {
+		return cfg.Apps, fmt.Sprintf("缓存的配置已超过 %d 小时未同步，使用本地配置", cfg.Offline.MaxAge)
+	}
+
+	apps := make([]config.AppConfig, 0, len(cached))
+	for _, app := range cfg.MergeAppSettings(cached) {
+		if app.DisableOffline {
+			logger.Info("应用 %s 禁止离线启动", app.Name)
+			continue
+		}
+		apps = append(apps, app)
+	}
+	return apps, "使用缓存的配置"
+}
+
+// OfflineSync 服务端不可用时按间隔重试同步应用配置，成功后调用 apply 与服务端对账
+type OfflineSync struct {
+	appSync  *AppSync
+	interval time.Duration
+	apply    func([]config.AppConfig)
+	offline  chan struct{}
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewOfflineSync 创建离线重试同步
+func NewOfflineSync(appSync *AppSync, interval time.Duration, apply func([]config.AppConfig)) *OfflineSync {
+	return &OfflineSync{
+		appSync:  appSync,
+		interval: interval,
+		apply:    apply,
+		offline:  make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start 启动重试协程
+func (s *OfflineSync) Start() {
+	s.wg.Add(1)
+	go s.loop()
+}
+
+// Stop 停止重试
+func (s *OfflineSync) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+}
+
+// MarkOffline 同步失败后调用，开始按间隔重试
+func (s *OfflineSync) MarkOffline() {
+	select {
+	case s.offline <- struct{}{}:
+	default:
+	}
+}
+
+// loop 离线时按间隔重试，同步成功后等待下一次离线
+func (s *OfflineSync) loop() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case <-s.offline:
+		}
+
+		ticker := time.NewTicker(s.interval)
+		for synced := false; !synced; {
+			select {
+			case <-s.stopCh:
+				ticker.Stop()
+				return
+			case <-ticker.C:
+			}
+
+			apps, err := s.appSync.Sync()
+			if err != nil {
+				logger.Debug("重试同步应用配置失败: %v", err)
+				continue
+			}
+			logger.Info("已恢复与服务端的连接，按服务端配置对账")
+			s.apply(apps)
+			synced = true
+		}
+		ticker.Stop()
+	}
+}
@@ -0,0 +1,82 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/common/logger"
+)
+
+const (
+	// peerResolveTTL 对等节点解析结果的缓存时间，别名在服务端修改后最迟在该时间后生效
+	peerResolveTTL = 10 * time.Minute
+	// peerResolveRetry 解析失败后的重试间隔，避免服务端不可用时每次对账都等待请求超时
+	peerResolveRetry = time.Minute
+)
+
+// PeerResolver 把本地配置中的对等节点别名或设备名称解析为节点 ID，由服务端按本设备所属用户的别名解析。
+// 服务端下发的应用已保存节点 ID，解析结果不变
+type PeerResolver struct {
+	server *ServerClient
+	cache  map[string]resolvedPeer
+	mu     sync.Mutex
+}
+
+// resolvedPeer 缓存的解析结果，解析失败时 err 不为空
+type resolvedPeer struct {
+	resolution *PeerResolution
+	err        error
+	expiresAt  time.Time
+}
+
+// NewPeerResolver 创建对等节点解析器
+func NewPeerResolver(server *ServerClient) *PeerResolver {
+	return &PeerResolver{
+		server: server,
+		cache:  make(map[string]resolvedPeer),
+	}
+}
+
+// Resolve 解析对等节点名称，结果在缓存时间内复用
+func (r *PeerResolver) Resolve(name string) (*PeerResolution, error) {
+	r.mu.Lock()
+	cached, ok := r.cache[name]
+	r.mu.Unlock()
+	if ok && time.Now().Before(cached.expiresAt) {
+		return cached.resolution, cached.err
+	}
+
+	resolved, err := r.server.ResolvePeer(name)
+	entry := resolvedPeer{resolution: resolved, err: err, expiresAt: time.Now().Add(peerResolveTTL)}
+	if err != nil {
+		entry.expiresAt = time.Now().Add(peerResolveRetry)
+	}
+
+	r.mu.Lock()
+	r.cache[name] = entry
+	r.mu.Unlock()
+	return resolved, err
+}
+
+// ResolveApps 返回对等节点替换为节点 ID 的应用配置副本。无法解析时保留原值，
+// 可能是尚未注册的节点或服务端暂时不可用，连接时按节点 ID 处理
+func (r *PeerResolver) ResolveApps(apps []config.AppConfig) []config.AppConfig {
+	resolved := make([]config.AppConfig, len(apps))
+	copy(resolved, apps)
+	for i := range resolved {
+		if resolved[i].PeerNode == "" {
+			continue
+		}
+		peer, err := r.Resolve(resolved[i].PeerNode)
+		if err != nil {
+			logger.Warn("应用 %s 的对等节点 %s 无法解析，按节点 ID 使用: %v", resolved[i].Name, resolved[i].PeerNode, err)
+			continue
+		}
+		if peer.NodeID != resolved[i].PeerNode {
+			logger.Debug("应用 %s 的对等节点 %s 解析为 %s", resolved[i].Name, resolved[i].PeerNode, peer.NodeID)
+			resolved[i].PeerNode = peer.NodeID
+		}
+	}
+	return resolved
+}
@@ -6,15 +6,14 @@ import (
 	"sync"
 	"time"
 
-	"github.com/senma231/p3/client/nat"
-	"github.com/senma231/p3/client/p2p"
 	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
 )
 
 // PeerInfo 对等节点信息
 type PeerInfo struct {
 	NodeID       string
-	NATType      nat.NATType
+	NATType      protocol.NATType
 	ExternalIP   string
 	ExternalPort int
 	LastSeen     time.Time
@@ -23,11 +22,11 @@ type PeerInfo struct {
 // PeerStatus 对等节点状态
 type PeerStatus struct {
 	NodeID         string
-	NATType        nat.NATType
+	NATType        protocol.NATType
 	ExternalIP     string
 	ExternalPort   int
 	Connected      bool
-	ConnectionType p2p.ConnectionType
+	ConnectionType protocol.ConnectionType
 	BytesSent      uint64
 	BytesReceived  uint64
 	LastSeen       time.Time
@@ -44,7 +43,7 @@ type PeerManager struct {
 type peerConnection struct {
 	info          *PeerInfo
 	conn          net.Conn
-	connType      p2p.ConnectionType
+	connType      protocol.ConnectionType
 	connected     bool
 	bytesSent     uint64
 	bytesReceived uint64
@@ -60,7 +59,7 @@ func NewPeerManager() *PeerManager {
 }
 
 // AddPeer 添加对等节点
-func (m *PeerManager) AddPeer(nodeID string, info *PeerInfo, conn net.Conn, connType p2p.ConnectionType) {
+func (m *PeerManager) AddPeer(nodeID string, info *PeerInfo, conn net.Conn, connType protocol.ConnectionType) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -0,0 +1,202 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+)
+
+const (
+	// maxScanPorts 单次扫描的最大端口数，与服务端的限制一致
+	maxScanPorts = 1024
+	// scanWorkers 同时探测的端口数
+	scanWorkers = 16
+	// scanInterval 相邻两次探测的最小间隔，避免对目标造成压力
+	scanInterval = 5 * time.Millisecond
+	// scanDialTimeout 探测单个端口的超时时间
+	scanDialTimeout = time.Second
+)
+
+// PortScanTask 服务端下发的端口扫描任务
+type PortScanTask struct {
+	ScanID    string `json:"scanId"`
+	Host      string `json:"host"`
+	PortStart int    `json:"portStart"`
+	PortEnd   int    `json:"portEnd"`
+}
+
+// PortScanner 执行服务端通过信令下发的端口扫描，只扫描本机或局域网中的地址，
+// 同时只进行一次扫描，并将结果回报到服务端
+type PortScanner struct {
+	client  *ServerClient
+	mu      sync.Mutex
+	running bool
+	wg      sync.WaitGroup
+}
+
+// NewPortScanner 创建端口扫描器
+func NewPortScanner(client *ServerClient) *PortScanner {
+	return &PortScanner{client: client}
+}
+
+// HandleSignal 处理端口扫描信令，扫描在后台进行，不阻塞信令的接收
+func (s *PortScanner) HandleSignal(signal *protocol.Signal) {
+	// 重新解析负载
+	data, err := json.Marshal(signal.Payload)
+	if err != nil {
+		logger.Error("解析端口扫描任务失败: %v", err)
+		return
+	}
+	var task PortScanTask
+	if err := json.Unmarshal(data, &task); err != nil {
+		logger.Error("解析端口扫描任务失败: %v", err)
+		return
+	}
+
+	s.mu.Lock()
+	if s.running {
+		s.mu.Unlock()
+		s.report(task.ScanID, nil, 0, fmt.Errorf("正在进行其他端口扫描"))
+		return
+	}
+	s.running = true
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			s.mu.Lock()
+			s.running = false
+			s.mu.Unlock()
+		}()
+
+		logger.Info("开始扫描 %s:%d-%d", task.Host, task.PortStart, task.PortEnd)
+		open, scanned, err := ScanPorts(task.Host, task.PortStart, task.PortEnd)
+		if err != nil {
+			logger.Warn("端口扫描 %s 失败: %v", task.ScanID, err)
+		}
+		s.report(task.ScanID, open, scanned, err)
+	}()
+}
+
+// Wait 等待正在进行的扫描完成并回报结果
+func (s *PortScanner) Wait() {
+	s.wg.Wait()
+}
+
+// report 回报扫描结果
+func (s *PortScanner) report(scanID string, open []int, scanned int, scanErr error) {
+	if err := s.client.ReportPortScanResult(scanID, open, scanned, scanErr); err != nil {
+		logger.Warn("%v", err)
+	}
+}
+
+// ScanPorts 扫描 host 上 start 到 end 之间的 TCP 端口，返回监听中的端口和已探测的端口数。
+// host 为空或 localhost 时扫描本机，解析后不是回环地址或私有地址时拒绝扫描
+func ScanPorts(host string, start, end int) ([]int, int, error) {
+	ip, err := scanTarget(host)
+	if err != nil {
+		return nil, 0, err
+	}
+	if start < 1 || end > 65535 || end < start {
+		return nil, 0, fmt.Errorf("端口范围无效: %d-%d", start, end)
+	}
+	if end-start+1 > maxScanPorts {
+		return nil, 0, fmt.Errorf("单次最多扫描 %d 个端口", maxScanPorts)
+	}
+
+	ports := make(chan int)
+	var (
+		mu   sync.Mutex
+		open []int
+		wg   sync.WaitGroup
+	)
+	for i := 0; i < scanWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for port := range ports {
+				conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip.String(), strconv.Itoa(port)), scanDialTimeout)
+				if err != nil {
+					continue
+				}
+				conn.Close()
+				mu.Lock()
+				open = append(open, port)
+				mu.Unlock()
+			}
+		}()
+	}
+
+	// 按固定间隔分发端口，限制探测速率
+	ticker := time.NewTicker(scanInterval)
+	for port := start; port <= end; port++ {
+		<-ticker.C
+		ports <- port
+	}
+	ticker.Stop()
+	close(ports)
+	wg.Wait()
+
+	sort.Ints(open)
+	return open, end - start + 1, nil
+}
+
+// scanTarget 解析扫描目标，只允许回环地址和私有地址
+func scanTarget(host string) (net.IP, error) {
+	if host == "" || host == "localhost" {
+		return net.IPv4(127, 0, 0, 1), nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("扫描目标必须是 localhost 或 IP 地址: %s", host)
+	}
+	if !ip.IsLoopback() && !ip.IsPrivate() {
+		return nil, fmt.Errorf("只能扫描本机或局域网中的地址: %s", host)
+	}
+	return ip, nil
+}
+
+// ReportPortScanResult 回报端口扫描结果，scanErr 不为 nil 表示扫描失败
+func (c *ServerClient) ReportPortScanResult(scanID string, open []int, scanned int, scanErr error) error {
+	if open == nil {
+		open = []int{}
+	}
+	body := map[string]interface{}{
+		"open":    open,
+		"scanned": scanned,
+	}
+	if scanErr != nil {
+		body["error"] = scanErr.Error()
+	}
+
+	// 发送请求
+	resp, err := c.post("/api/v1/device/scans/"+scanID+"/result", body)
+	if err != nil {
+		return fmt.Errorf("回报端口扫描结果失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusOK {
+		var result map[string]interface{}
+		errMsg := "未知错误"
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+			if errObj, ok := result["error"]; ok {
+				errMsg = fmt.Sprintf("%v", errObj)
+			}
+		}
+		return fmt.Errorf("回报端口扫描结果失败: %s", errMsg)
+	}
+
+	return nil
+}
@@ -0,0 +1,102 @@
+package core
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/trace"
+	"github.com/senma231/p3/common/protocol"
+)
+
+// candidatePriority 获取连接时尝试各连接方式的顺序及其来源：
+// 优先使用本地配置的 strategy.priority，其次使用服务端下发的优先级，都没有时使用默认优先级
+func (e *Engine) candidatePriority(strategy config.StrategyConfig) ([]string, string) {
+	if len(strategy.Priority) > 0 {
+		return strategy.Priority, trace.PriorityConfig
+	}
+
+	e.mu.RLock()
+	server := e.serverPriority
+	e.mu.RUnlock()
+	if len(server) > 0 {
+		return server, trace.PriorityServer
+	}
+	return protocol.DefaultCandidatePriority, trace.PriorityDefault
+}
+
+// SetServerPriority 设置服务端下发的连接优先级，优先级无效时保留原来的设置
+func (e *Engine) SetServerPriority(priority []string) error {
+	if len(priority) == 0 {
+		return fmt.Errorf("连接优先级为空")
+	}
+	if err := protocol.ValidateCandidatePriority(priority); err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.serverPriority = priority
+	return nil
+}
+
+// HandleWelcome 处理服务端的欢迎消息，保存其中下发的连接优先级。
+// 旧版本的服务端不下发优先级，此时继续使用默认优先级
+func (e *Engine) HandleWelcome(signal *protocol.Signal) {
+	payload, ok := signal.Payload.(map[string]interface{})
+	if !ok {
+		return
+	}
+	values, ok := payload["candidatePriority"].([]interface{})
+	if !ok {
+		return
+	}
+
+	priority := make([]string, 0, len(values))
+	for _, v := range values {
+		method, ok := v.(string)
+		if !ok {
+			fmt.Printf("服务端下发的连接优先级无效: %v\n", values)
+			return
+		}
+		priority = append(priority, method)
+	}
+	if err := e.SetServerPriority(priority); err != nil {
+		fmt.Printf("服务端下发的连接优先级无效: %v\n", err)
+	}
+}
+
+// containsMethod 连接优先级中是否包含该连接方式
+func containsMethod(priority []string, method string) bool {
+	for _, m := range priority {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIPv6 获取对端的全局 IPv6 地址，没有时返回 nil
+func peerIPv6(peer *PeerInfo) net.IP {
+	for _, ip := range []net.IP{peer.IPv6, peer.ExternalIP} {
+		if ip != nil && ip.To4() == nil && ip.IsGlobalUnicast() && !ip.IsPrivate() {
+			return ip
+		}
+	}
+	return nil
+}
+
+// localIPv6Available 本机是否有全局 IPv6 地址
+func localIPv6Available() bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if ok && ipNet.IP.To4() == nil && ipNet.IP.IsGlobalUnicast() && !ipNet.IP.IsPrivate() {
+			return true
+		}
+	}
+	return false
+}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/senma231/p3/client/nat"
+	"github.com/senma231/p3/common/protocol"
 )
 
 // PunchType 打洞类型
@@ -44,6 +45,8 @@ type Puncher struct {
 	natInfo    *nat.NATInfo
 	timeout    time.Duration
 	maxRetries int
+	disableUDP bool
+	disableTCP bool
 }
 
 // NewPuncher 创建打洞器
@@ -63,11 +66,21 @@ func NewPuncher(localPort int, natInfo *nat.NATInfo, timeout time.Duration, maxR
 	}
 }
 
+// SetUDPPunch 设置是否尝试 UDP 打洞，默认按双方 NAT 类型决定
+func (p *Puncher) SetUDPPunch(enabled bool) {
+	p.disableUDP = !enabled
+}
+
+// SetTCPPunch 设置是否尝试 TCP 打洞，默认按双方 NAT 类型决定
+func (p *Puncher) SetTCPPunch(enabled bool) {
+	p.disableTCP = !enabled
+}
+
 // Punch 尝试打洞连接
-func (p *Puncher) Punch(peerIP net.IP, peerPort int, peerNATType nat.NATType) *PunchResult {
+func (p *Puncher) Punch(peerIP net.IP, peerPort int, peerNATType protocol.NATType) *PunchResult {
 	// 根据 NAT 类型选择打洞策略
-	canUDP := p.canUDPPunch(p.natInfo.Type, peerNATType)
-	canTCP := p.canTCPPunch(p.natInfo.Type, peerNATType)
+	canUDP := !p.disableUDP && p.canUDPPunch(p.natInfo.Type, peerNATType)
+	canTCP := !p.disableTCP && p.canTCPPunch(p.natInfo.Type, peerNATType)
 
 	if !canUDP && !canTCP {
 		return &PunchResult{
@@ -119,14 +132,14 @@ func (p *Puncher) Punch(peerIP net.IP, peerPort int, peerNATType nat.NATType) *P
 }
 
 // canUDPPunch 判断是否可以进行 UDP 打洞
-func (p *Puncher) canUDPPunch(localNATType, peerNATType nat.NATType) bool {
+func (p *Puncher) canUDPPunch(localNATType, peerNATType protocol.NATType) bool {
 	// 如果任一方是公网 IP，可以直接连接，不需要打洞
-	if localNATType == nat.NATNone || peerNATType == nat.NATNone {
+	if localNATType == protocol.NATNone || peerNATType == protocol.NATNone {
 		return true
 	}
 
 	// 如果双方都是对称型 NAT，无法进行 UDP 打洞
-	if localNATType == nat.NATSymmetric && peerNATType == nat.NATSymmetric {
+	if localNATType == protocol.NATSymmetric && peerNATType == protocol.NATSymmetric {
 		return false
 	}
 
@@ -135,16 +148,16 @@ func (p *Puncher) canUDPPunch(localNATType, peerNATType nat.NATType) bool {
 }
 
 // canTCPPunch 判断是否可以进行 TCP 打洞
-func (p *Puncher) canTCPPunch(localNATType, peerNATType nat.NATType) bool {
+func (p *Puncher) canTCPPunch(localNATType, peerNATType protocol.NATType) bool {
 	// TCP 打洞成功率较低，只在特定情况下尝试
 	// 如果任一方是公网 IP，可以直接连接，不需要打洞
-	if localNATType == nat.NATNone || peerNATType == nat.NATNone {
+	if localNATType == protocol.NATNone || peerNATType == protocol.NATNone {
 		return true
 	}
 
 	// 如果双方都是完全锥形或受限锥形 NAT，可以尝试 TCP 打洞
-	if (localNATType == nat.NATFull || localNATType == nat.NATRestricted) &&
-		(peerNATType == nat.NATFull || peerNATType == nat.NATRestricted) {
+	if (localNATType == protocol.NATFull || localNATType == protocol.NATRestricted) &&
+		(peerNATType == protocol.NATFull || peerNATType == protocol.NATRestricted) {
 		return true
 	}
 
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"sync"
+
+	"github.com/senma231/p3/client/trace"
+)
+
+// PunchOutcome 自上次上报以来一组 NAT 类型组合的打洞结果。
+// 只包含双方的 NAT 类型，不包含对等节点和地址，服务端据此汇总各 NAT 类型组合的打洞成功率
+type PunchOutcome struct {
+	LocalNAT  string `json:"localNat"`
+	PeerNAT   string `json:"peerNat"`
+	Attempts  uint64 `json:"attempts"`
+	Successes uint64 `json:"successes"`
+}
+
+// punchStats 按 NAT 类型组合累计的打洞结果，等待批量上报
+type punchStats struct {
+	outcomes map[[2]string]*PunchOutcome
+	mu       sync.Mutex
+}
+
+// newPunchStats 创建打洞结果统计
+func newPunchStats() *punchStats {
+	return &punchStats{outcomes: make(map[[2]string]*PunchOutcome)}
+}
+
+// record 统计连接记录中的打洞尝试。同一批中其他方式先成功而未完成的打洞标记为跳过，不计入
+func (p *punchStats) record(t *trace.Trace) {
+	if t.LocalNAT == "" || t.PeerNAT == "" {
+		return
+	}
+	attempted, succeeded := false, false
+	for _, attempt := range t.Attempts {
+		if !trace.IsHolePunch(attempt.Method) || attempt.Skipped {
+			continue
+		}
+		attempted = true
+		if attempt.Error == "" {
+			succeeded = true
+		}
+	}
+	if !attempted {
+		return
+	}
+
+	var successes uint64
+	if succeeded {
+		successes = 1
+	}
+	p.add([]PunchOutcome{{LocalNAT: t.LocalNAT, PeerNAT: t.PeerNAT, Attempts: 1, Successes: successes}})
+}
+
+// add 累加打洞结果
+func (p *punchStats) add(outcomes []PunchOutcome) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, o := range outcomes {
+		key := [2]string{o.LocalNAT, o.PeerNAT}
+		if existing, ok := p.outcomes[key]; ok {
+			existing.Attempts += o.Attempts
+			existing.Successes += o.Successes
+			continue
+		}
+		outcome := o
+		p.outcomes[key] = &outcome
+	}
+}
+
+// take 取出累计的打洞结果并清空
+func (p *punchStats) take() []PunchOutcome {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.outcomes) == 0 {
+		return nil
+	}
+	outcomes := make([]PunchOutcome, 0, len(p.outcomes))
+	for _, o := range p.outcomes {
+		outcomes = append(outcomes, *o)
+	}
+	p.outcomes = make(map[[2]string]*PunchOutcome)
+	return outcomes
+}
+
+// TakePunchOutcomes 取出自上次上报以来的打洞结果，上报失败时使用 RestorePunchOutcomes 放回
+func (e *Engine) TakePunchOutcomes() []PunchOutcome {
+	return e.punches.take()
+}
+
+// RestorePunchOutcomes 放回上报失败的打洞结果，等待下次上报
+func (e *Engine) RestorePunchOutcomes(outcomes []PunchOutcome) {
+	e.punches.add(outcomes)
+}
@@ -0,0 +1,238 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/client/budget"
+	"github.com/senma231/p3/client/forward"
+	"github.com/senma231/p3/client/health"
+	"github.com/senma231/p3/client/inbound"
+	"github.com/senma231/p3/client/nat"
+	"github.com/senma231/p3/common/logger"
+)
+
+// reportDestinations 每次上报的目标地址统计条数上限，其余目标的增量留到之后上报
+const reportDestinations = 100
+
+// reportInboundEvents 每次上报的入站连接事件数上限，与服务端单次上报的事件数上限相同
+const reportInboundEvents = 100
+
+// ErrReportUnsupported 服务端版本较旧，不支持批量上报
+var ErrReportUnsupported = errors.New("服务端不支持批量上报")
+
+// ConnectionSummary 与对等节点的连接摘要
+type ConnectionSummary struct {
+	PeerID        string    `json:"peerId"`
+	Type          string    `json:"type"`
+	Status        string    `json:"status"`
+	EstablishedAt time.Time `json:"establishedAt"`
+	LastActiveAt  time.Time `json:"lastActiveAt"`
+	BytesSent     uint64    `json:"bytesSent"`
+	BytesReceived uint64    `json:"bytesReceived"`
+	MTU           int       `json:"mtu,omitempty"` // UDP 连接协商的路径 MTU
+}
+
+// DeviceReport 批量上报的内容，一次请求包含心跳、应用流量统计、连接摘要和应用健康状态。
+// Destinations 为自上次成功上报以来按目标地址的流量增量，Resources 为资源占用和压力，
+// Punches 为自上次成功上报以来按 NAT 类型组合的打洞结果，SelfTest 为上次成功上报之后的连通性自检结果
+type DeviceReport struct {
+	Status       map[string]interface{}     `json:"status"`
+	Apps         []forward.AppStats         `json:"apps"`
+	Connections  []ConnectionSummary        `json:"connections"`
+	Health       []health.Result            `json:"health,omitempty"`
+	Destinations []forward.DestinationStats `json:"destinations,omitempty"`
+	Resources    *budget.Usage              `json:"resources,omitempty"`
+	Punches      []PunchOutcome             `json:"punches,omitempty"`
+	SelfTest     *nat.SelfTestResult        `json:"selfTest,omitempty"`
+}
+
+// ConnectionSummaries 获取所有连接的摘要
+func (e *Engine) ConnectionSummaries() []ConnectionSummary {
+	conns := e.GetConnections()
+	summaries := make([]ConnectionSummary, 0, len(conns))
+	for _, conn := range conns {
+		conn.mu.Lock()
+		summaries = append(summaries, ConnectionSummary{
+			PeerID:        conn.PeerID,
+			Type:          traceMethod(conn.Type),
+			Status:        "connected",
+			EstablishedAt: conn.Established,
+			LastActiveAt:  conn.LastActive,
+			BytesSent:     conn.BytesSent,
+			BytesReceived: conn.BytesRecv,
+			MTU:           conn.MTU,
+		})
+		conn.mu.Unlock()
+	}
+	return summaries
+}
+
+// Report 批量上报设备状态，服务端不支持时返回 ErrReportUnsupported
+func (c *ServerClient) Report(report *DeviceReport) error {
+	sentAt := time.Now()
+
+	// 发送签名请求，防止状态和 NAT 信息被伪造或重放
+	resp, err := c.signedPost("/api/v1/device/report", report)
+	if err != nil {
+		return fmt.Errorf("批量上报失败: %w", err)
+	}
+	defer resp.Body.Close()
+	receivedAt := time.Now()
+
+	// 检查响应状态
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrReportUnsupported
+	}
+	if resp.StatusCode != http.StatusOK {
+		var result map[string]interface{}
+		errMsg := "未知错误"
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+			if errObj, ok := result["error"]; ok {
+				errMsg = fmt.Sprintf("%v", errObj)
+			}
+		}
+		return fmt.Errorf("批量上报失败: %s", errMsg)
+	}
+
+	var result struct {
+		ServerTime time.Time `json:"serverTime"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && !result.ServerTime.IsZero() {
+		c.updateClockOffset(sentAt, receivedAt, result.ServerTime)
+	}
+
+	return nil
+}
+
+// Reporter 按心跳间隔批量上报设备状态、应用流量统计和连接摘要。
+// 服务端不支持批量上报时改为只发送心跳
+type Reporter struct {
+	client     *ServerClient
+	engine     *Engine
+	forwarders *forward.ForwarderManager
+	inbound    *inbound.Monitor
+	budget     *budget.Budget
+	selfTester *SelfTester
+	interval   time.Duration
+	legacy     bool
+	stopCh     chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewReporter 创建批量上报
+func NewReporter(client *ServerClient, engine *Engine, forwarders *forward.ForwarderManager, interval time.Duration) *Reporter {
+	return &Reporter{
+		client:     client,
+		engine:     engine,
+		forwarders: forwarders,
+		interval:   interval,
+		stopCh:     make(chan struct{}),
+	}
+}
+
+// SetInboundMonitor 设置入站连接监控，每次上报时一并上报记录的意外连接
+func (r *Reporter) SetInboundMonitor(monitor *inbound.Monitor) {
+	r.inbound = monitor
+}
+
+// SetBudget 设置资源预算，每次上报时一并上报资源占用和压力
+func (r *Reporter) SetBudget(b *budget.Budget) {
+	r.budget = b
+}
+
+// SetSelfTester 设置连通性自检，有新的自检结果时随上报发送
+func (r *Reporter) SetSelfTester(t *SelfTester) {
+	r.selfTester = t
+}
+
+// Start 立即上报一次，之后按间隔定期上报
+func (r *Reporter) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			r.report()
+			select {
+			case <-r.stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop 停止上报
+func (r *Reporter) Stop() {
+	close(r.stopCh)
+	r.wg.Wait()
+}
+
+// report 上报一次
+func (r *Reporter) report() {
+	r.reportInbound()
+
+	if r.legacy {
+		if err := r.client.Heartbeat(); err != nil {
+			logger.Warn("发送心跳失败: %v", err)
+		}
+		return
+	}
+
+	destinations, reported := r.forwarders.DestinationRollup(reportDestinations)
+	report := &DeviceReport{
+		Status:       r.client.heartbeatStatus(),
+		Apps:         r.forwarders.Stats(),
+		Connections:  r.engine.ConnectionSummaries(),
+		Destinations: destinations,
+		Punches:      r.engine.TakePunchOutcomes(),
+	}
+	if r.budget != nil {
+		usage := r.budget.Usage()
+		report.Resources = &usage
+	}
+	if r.selfTester != nil {
+		report.SelfTest = r.selfTester.Take()
+	}
+	err := r.client.Report(report)
+	if err != nil {
+		r.engine.RestorePunchOutcomes(report.Punches)
+		if r.selfTester != nil {
+			r.selfTester.Restore(report.SelfTest)
+		}
+	}
+	if errors.Is(err, ErrReportUnsupported) {
+		logger.Info("服务端不支持批量上报，改为只发送心跳")
+		r.legacy = true
+		r.report()
+		return
+	}
+	if err != nil {
+		logger.Warn("%v", err)
+		return
+	}
+	reported()
+}
+
+// reportInbound 上报记录的意外入站连接，失败时放回等待下次上报
+func (r *Reporter) reportInbound() {
+	if r.inbound == nil {
+		return
+	}
+	events := r.inbound.Take(reportInboundEvents)
+	if len(events) == 0 {
+		return
+	}
+	if err := r.client.ReportInboundEvents(events); err != nil {
+		logger.Warn("%v", err)
+		r.inbound.Restore(events)
+	}
+}
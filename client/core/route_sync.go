@@ -0,0 +1,176 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+)
+
+// RouteSync 向服务端同步本节点通告的子网路由，接受路由时按间隔获取可以访问的路由并更新路由表
+type RouteSync struct {
+	sync     func(cidrs []string) error
+	list     func() ([]Route, error)
+	table    *RouteTable
+	interval time.Duration
+
+	mu         sync.Mutex
+	advertised []string
+	synced     bool
+
+	trigger chan struct{}
+	stopCh  chan struct{}
+	wg      sync.WaitGroup
+}
+
+// NewRouteSync 创建子网路由同步，sync 同步通告的网段，list 获取可以访问的路由，table 为 nil 表示不接受路由
+func NewRouteSync(sync func(cidrs []string) error, list func() ([]Route, error), table *RouteTable, interval time.Duration) *RouteSync {
+	return &RouteSync{
+		sync:     sync,
+		list:     list,
+		table:    table,
+		interval: interval,
+		trigger:  make(chan struct{}, 1),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// SetAdvertised 设置本节点通告的网段，网段变化时立即同步到服务端，同步失败时按间隔重试
+func (s *RouteSync) SetAdvertised(cidrs []string) {
+	s.mu.Lock()
+	if s.synced && sameStrings(s.advertised, cidrs) {
+		s.mu.Unlock()
+		return
+	}
+	s.advertised = append([]string(nil), cidrs...)
+	s.synced = false
+	s.mu.Unlock()
+
+	select {
+	case s.trigger <- struct{}{}:
+	default:
+	}
+}
+
+// Advertised 获取本节点通告的网段
+func (s *RouteSync) Advertised() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.advertised...)
+}
+
+// Start 在后台立即同步一次，之后按间隔定期同步，通告的网段变化时立即同步
+func (s *RouteSync) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			s.run()
+			select {
+			case <-s.stopCh:
+				return
+			case <-ticker.C:
+			case <-s.trigger:
+			}
+		}
+	}()
+}
+
+// Stop 停止同步
+func (s *RouteSync) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+}
+
+// run 同步一次。通告的网段只在变化或上次失败后同步；无法访问服务端时保留当前路由表
+func (s *RouteSync) run() {
+	s.mu.Lock()
+	cidrs, synced := s.advertised, s.synced
+	s.mu.Unlock()
+
+	if !synced {
+		if err := s.sync(cidrs); err != nil {
+			logger.Warn("%v", err)
+		} else {
+			s.mu.Lock()
+			// 同步期间通告的网段可能再次变化，只有同步的是当前网段时才标记完成
+			if sameStrings(s.advertised, cidrs) {
+				s.synced = true
+			}
+			s.mu.Unlock()
+		}
+	}
+
+	if s.table == nil {
+		return
+	}
+	routes, err := s.list()
+	if err != nil {
+		logger.Debug("%v", err)
+		return
+	}
+	if err := s.table.Update(routes); err != nil {
+		logger.Warn("更新路由表失败: %v", err)
+	}
+}
+
+// GatewayAuthorizer 创建对端经本节点访问目标地址的授权检查：目标在本节点通告的网段中时，
+// 须在服务端返回的对端可访问网段内；否则须本节点作为出口节点且对端获得授权。
+// advertised 返回本节点通告的网段，routeClient 获取对端可访问的网段，exitClient 为 nil 表示本节点不作为出口节点
+func GatewayAuthorizer(advertised func() []string, routeClient func(peerID string) ([]string, error), exitClient func(peerID string) error) func(peerID, address string) error {
+	return func(peerID, address string) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return fmt.Errorf("无效的目标地址: %s", address)
+		}
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return fmt.Errorf("目标地址须为 IP 地址: %s", address)
+		}
+
+		if cidrsContain(advertised(), ip) {
+			allowed, err := routeClient(peerID)
+			if err != nil {
+				return err
+			}
+			if !cidrsContain(allowed, ip) {
+				return fmt.Errorf("节点 %s 无权访问 %s", peerID, ip)
+			}
+			return nil
+		}
+		if exitClient == nil {
+			return fmt.Errorf("本节点未通告 %s 所在的网段", ip)
+		}
+		return exitClient(peerID)
+	}
+}
+
+// cidrsContain 检查地址是否在任一网段中，忽略无效的网段
+func cidrsContain(cidrs []string, ip net.IP) bool {
+	for _, cidr := range cidrs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameStrings 检查两个列表是否相同
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRouteSync(t *testing.T) {
+	var mu sync.Mutex
+	var synced [][]string
+	syncedCh := make(chan struct{}, 10)
+	failFirst := true
+	syncFn := func(cidrs []string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if failFirst {
+			failFirst = false
+			return errors.New("服务端不可用")
+		}
+		synced = append(synced, cidrs)
+		syncedCh <- struct{}{}
+		return nil
+	}
+	listFn := func() ([]Route, error) {
+		return []Route{{RouteID: 1, CIDR: "10.1.0.0/16", NodeID: "node-b"}}, nil
+	}
+
+	table := NewRouteTable()
+	s := NewRouteSync(syncFn, listFn, table, 20*time.Millisecond)
+	s.SetAdvertised([]string{"192.168.1.0/24"})
+	s.Start()
+	defer s.Stop()
+
+	// 第一次同步失败后按间隔重试
+	waitSynced(t, syncedCh)
+	if route, ok := table.Lookup(net.ParseIP("10.1.2.3")); !ok || route.NodeID != "node-b" {
+		t.Fatalf("路由表应包含服务端返回的路由, 实际为 %v", table.Routes())
+	}
+
+	// 网段未变化时不重复同步，变化后立即同步
+	s.SetAdvertised([]string{"192.168.1.0/24"})
+	s.SetAdvertised([]string{"192.168.2.0/24"})
+	waitSynced(t, syncedCh)
+	time.Sleep(60 * time.Millisecond)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(synced) != 2 || synced[1][0] != "192.168.2.0/24" {
+		t.Fatalf("同步的网段为 %v", synced)
+	}
+	if got := s.Advertised(); len(got) != 1 || got[0] != "192.168.2.0/24" {
+		t.Fatalf("通告的网段为 %v", got)
+	}
+}
+
+// waitSynced 等待一次同步成功
+func waitSynced(t *testing.T, ch chan struct{}) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(2 * time.Second):
+		t.Fatal("等待同步超时")
+	}
+}
+
+func TestRouteTableDialer(t *testing.T) {
+	table := NewRouteTable()
+	if err := table.Update([]Route{
+		{RouteID: 1, CIDR: "10.0.0.0/8", NodeID: "node-a"},
+		{RouteID: 2, CIDR: "10.1.0.0/16", NodeID: "node-b"},
+	}); err != nil {
+		t.Fatalf("更新路由表失败: %v", err)
+	}
+
+	var used string
+	next := func(ctx context.Context, network, address string) (net.Conn, error) {
+		used = "next"
+		return nil, nil
+	}
+	dial := table.Dialer(next, func(nodeID string) DialFunc {
+		return func(ctx context.Context, network, address string) (net.Conn, error) {
+			used = nodeID
+			return nil, nil
+		}
+	})
+
+	tests := []struct {
+		network string
+		address string
+		want    string
+	}{
+		{network: "tcp", address: "10.1.2.3:80", want: "node-b"},
+		{network: "tcp", address: "10.2.0.1:80", want: "node-a"},
+		{network: "tcp", address: "192.168.1.1:80", want: "next"},
+		{network: "udp", address: "10.1.2.3:53", want: "next"},
+		{network: "tcp", address: "example.com:80", want: "next"},
+	}
+	for _, tt := range tests {
+		used = ""
+		dial(context.Background(), tt.network, tt.address)
+		if used != tt.want {
+			t.Errorf("%s %s 应使用 %s, 实际为 %s", tt.network, tt.address, tt.want, used)
+		}
+	}
+}
+
+func TestGatewayAuthorizer(t *testing.T) {
+	advertised := func() []string { return []string{"192.168.1.0/24", "192.168.2.0/24"} }
+	routeClient := func(peerID string) ([]string, error) {
+		if peerID == "node-a" {
+			return []string{"192.168.1.0/24"}, nil
+		}
+		return nil, errors.New("无权访问该设备通告的路由")
+	}
+	exitClient := func(peerID string) error {
+		if peerID != "node-a" {
+			return errors.New("无权使用该出口节点")
+		}
+		return nil
+	}
+
+	authorize := GatewayAuthorizer(advertised, routeClient, nil)
+	tests := []struct {
+		peer    string
+		address string
+		err     string
+	}{
+		{peer: "node-a", address: "192.168.1.10:22"},
+		{peer: "node-a", address: "192.168.2.10:22", err: "无权访问"},
+		{peer: "node-b", address: "192.168.1.10:22", err: "无权访问"},
+		{peer: "node-a", address: "203.0.113.5:80", err: "未通告"},
+		{peer: "node-a", address: "example.com:80", err: "IP 地址"},
+	}
+	for _, tt := range tests {
+		err := authorize(tt.peer, tt.address)
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("%s 访问 %s 返回 %v, 期望 %q", tt.peer, tt.address, err, tt.err)
+		}
+	}
+
+	// 作为出口节点时，通告网段之外的目标按出口节点授权检查
+	authorize = GatewayAuthorizer(advertised, routeClient, exitClient)
+	if err := authorize("node-a", "203.0.113.5:80"); err != nil {
+		t.Errorf("授权的对端应可以使用出口节点: %v", err)
+	}
+	if err := authorize("node-b", "203.0.113.5:80"); err == nil {
+		t.Error("未授权的对端不应使用出口节点")
+	}
+	if err := authorize("node-b", "192.168.2.10:22"); err == nil {
+		t.Error("出口节点授权不应放行通告网段中未授权的目标")
+	}
+}
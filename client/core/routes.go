@@ -0,0 +1,105 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Route 子网路由
+type Route struct {
+	RouteID uint   `json:"routeId"`
+	CIDR    string `json:"cidr"`
+	NodeID  string `json:"nodeId"`
+}
+
+// routeEntry 路由表项
+type routeEntry struct {
+	route  Route
+	ipNet  *net.IPNet
+	prefix int
+}
+
+// RouteTable 子网路由表，按最长前缀匹配
+type RouteTable struct {
+	entries []routeEntry
+	mu      sync.RWMutex
+}
+
+// NewRouteTable 创建子网路由表
+func NewRouteTable() *RouteTable {
+	return &RouteTable{}
+}
+
+// Update 替换路由表中的所有路由
+func (t *RouteTable) Update(routes []Route) error {
+	entries := make([]routeEntry, 0, len(routes))
+	for _, r := range routes {
+		_, ipNet, err := net.ParseCIDR(r.CIDR)
+		if err != nil {
+			return fmt.Errorf("无效的路由 %s: %w", r.CIDR, err)
+		}
+		prefix, _ := ipNet.Mask.Size()
+		entries = append(entries, routeEntry{
+			route:  r,
+			ipNet:  ipNet,
+			prefix: prefix,
+		})
+	}
+
+	// 前缀越长优先级越高
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].prefix > entries[j].prefix
+	})
+
+	t.mu.Lock()
+	t.entries = entries
+	t.mu.Unlock()
+	return nil
+}
+
+// Lookup 查找目标地址对应的路由
+func (t *RouteTable) Lookup(ip net.IP) (*Route, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, entry := range t.entries {
+		if entry.ipNet.Contains(ip) {
+			r := entry.route
+			return &r, true
+		}
+	}
+	return nil, false
+}
+
+// Routes 获取路由表中的所有路由
+func (t *RouteTable) Routes() []Route {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	routes := make([]Route, 0, len(t.entries))
+	for _, entry := range t.entries {
+		routes = append(routes, entry.route)
+	}
+	return routes
+}
+
+// Dialer 按路由表连接目标地址：目标为 IP 地址且在对等节点通告的子网中的 TCP 连接经 viaPeer
+// 返回的拨号函数连接通告节点转发，其他连接使用 next
+func (t *RouteTable) Dialer(next DialFunc, viaPeer func(nodeID string) DialFunc) DialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		if strings.HasPrefix(network, "tcp") {
+			if host, _, err := net.SplitHostPort(address); err == nil {
+				if ip := net.ParseIP(host); ip != nil {
+					if route, ok := t.Lookup(ip); ok {
+						return viaPeer(route.NodeID)(ctx, network, address)
+					}
+				}
+			}
+		}
+		return next(ctx, network, address)
+	}
+}
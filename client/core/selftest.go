@@ -0,0 +1,129 @@
+package core
+
+import (
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/client/nat"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+)
+
+// selfTestTimeout 连通性自检中每个探测的超时
+const selfTestTimeout = 3 * time.Second
+
+// SelfTester 按间隔进行连通性自检：向服务端的回显探测服务检查 UDP 和 TCP 出站连通性并测量往返延迟，
+// 同时验证 NAT 类型检测的结果。最近一次的结果随下一次批量上报发送
+type SelfTester struct {
+	client   *ServerClient
+	servers  func() []string // 用于比较映射地址的 STUN 服务器
+	interval time.Duration
+	latest   *nat.SelfTestResult
+	pending  bool // latest 尚未上报
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+}
+
+// NewSelfTester 创建连通性自检，servers 返回当前按优先级排列的 STUN 服务器
+func NewSelfTester(client *ServerClient, servers func() []string, interval time.Duration) *SelfTester {
+	return &SelfTester{
+		client:   client,
+		servers:  servers,
+		interval: interval,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start 在后台立即自检一次，之后按间隔定期自检
+func (t *SelfTester) Start() {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+
+		for {
+			t.run()
+			select {
+			case <-t.stopCh:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop 停止自检
+func (t *SelfTester) Stop() {
+	close(t.stopCh)
+	t.wg.Wait()
+}
+
+// run 自检一次。服务端没有回显探测服务（旧版本或端口未能监听）时跳过
+func (t *SelfTester) run() {
+	udp, tcp, err := t.client.ProbeAddresses()
+	if err != nil {
+		logger.Debug("跳过连通性自检: %v", err)
+		return
+	}
+	result, err := nat.SelfTest(nat.SelfTestOptions{
+		STUNServers: t.servers(),
+		UDP:         udp,
+		TCP:         tcp,
+		Timeout:     selfTestTimeout,
+	})
+	if err != nil {
+		logger.Warn("连通性自检失败: %v", err)
+		return
+	}
+
+	if udp != "" && !result.UDPReachable {
+		logger.Warn("UDP 出站连通性自检失败，打洞可能无法成功: %s", result.UDPError)
+	}
+	if tcp != "" && !result.TCPReachable {
+		logger.Warn("TCP 出站连通性自检失败: %s", result.TCPError)
+	}
+	natType := protocol.NATUnknown
+	if t.client.natInfo != nil {
+		natType = t.client.natInfo.Type
+	}
+	if result.NATMismatch = !result.MatchesNATType(natType); result.NATMismatch {
+		logger.Warn("连通性自检观察到的映射行为 %s 与检测到的 NAT 类型 %s 不一致", result.Mapping, natType)
+	}
+	logger.Debug("连通性自检: UDP %t (%d ms)，TCP %t (%d ms)，映射 %s",
+		result.UDPReachable, result.UDPLatency, result.TCPReachable, result.TCPLatency, result.Mapping)
+
+	t.mu.Lock()
+	t.latest = result
+	t.pending = true
+	t.mu.Unlock()
+}
+
+// Latest 最近一次自检的结果，尚未自检时返回 nil
+func (t *SelfTester) Latest() *nat.SelfTestResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.latest
+}
+
+// Take 取出尚未上报的自检结果，没有新结果时返回 nil
+func (t *SelfTester) Take() *nat.SelfTestResult {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.pending {
+		return nil
+	}
+	t.pending = false
+	return t.latest
+}
+
+// Restore 上报失败时放回自检结果，期间已有新结果时丢弃
+func (t *SelfTester) Restore(result *nat.SelfTestResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if result != nil && t.latest == result {
+		t.pending = true
+	}
+}
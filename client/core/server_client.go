@@ -5,21 +5,43 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"runtime"
+	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/endpoint"
+	"github.com/senma231/p3/client/forward"
+	"github.com/senma231/p3/client/health"
+	"github.com/senma231/p3/client/identity"
+	"github.com/senma231/p3/client/inbound"
 	"github.com/senma231/p3/client/nat"
+	"github.com/senma231/p3/client/proxy"
+	"github.com/senma231/p3/client/trace"
 	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/common/signing"
+	"github.com/senma231/p3/common/version"
 )
 
 // ServerClient 服务器客户端
 type ServerClient struct {
-	config  *config.Config
-	natInfo *nat.NATInfo
-	client  *http.Client
+	config    *config.Config
+	natInfo   *nat.NATInfo
+	client    *http.Client
+	endpoints *endpoint.Pool
+	identity  *identity.Identity
+	// clockOffset 最近一次心跳测得的本机时钟与服务端时钟之差（纳秒），正数表示本机偏快
+	clockOffset int64
 }
 
+// clockSkewWarn 本机时钟与服务端相差超过该值时告警，令牌和 TOTP 验证可能因此失败
+const clockSkewWarn = 30 * time.Second
+
 // NewServerClient 创建服务器客户端
 func NewServerClient(cfg *config.Config, natInfo *nat.NATInfo) *ServerClient {
 	return &ServerClient{
@@ -28,9 +50,25 @@ func NewServerClient(cfg *config.Config, natInfo *nat.NATInfo) *ServerClient {
 		client: &http.Client{
 			Timeout: 10 * time.Second,
 		},
+		endpoints: endpoint.NewPool(cfg.ServerEndpoints(), 5*time.Second),
 	}
 }
 
+// SetEndpointPool 设置服务器端点池，与信令客户端共享健康检查结果
+func (c *ServerClient) SetEndpointPool(pool *endpoint.Pool) {
+	c.endpoints = pool
+}
+
+// SetIdentity 设置设备身份，持有证书后使用证书签名代替令牌认证
+func (c *ServerClient) SetIdentity(id *identity.Identity) {
+	c.identity = id
+}
+
+// SetProxy 设置访问服务器使用的代理
+func (c *ServerClient) SetProxy(px *proxy.Proxy) {
+	c.client.Transport = px.Transport()
+}
+
 // Register 注册设备
 func (c *ServerClient) Register() error {
 	// 如果已有节点 ID 和令牌，则不需要注册
@@ -88,25 +126,34 @@ func (c *ServerClient) Register() error {
 	return nil
 }
 
-// Heartbeat 发送心跳
-func (c *ServerClient) Heartbeat() error {
-	// 创建心跳请求
-	reqBody := map[string]interface{}{
+// heartbeatStatus 心跳上报的设备状态
+func (c *ServerClient) heartbeatStatus() map[string]interface{} {
+	return map[string]interface{}{
 		"status":     "online",
 		"natType":    c.natInfo.Type.String(),
 		"externalIP": c.natInfo.ExternalIP.String(),
 		"localIP":    c.natInfo.LocalIP.String(),
-		"version":    "1.0.0",
+		"version":    version.Version,
 		"os":         getOS(),
 		"arch":       getArch(),
+		"region":     c.config.Node.Region,
+		"clientTime": time.Now(),
 	}
+}
 
-	// 发送请求
-	resp, err := c.post("/api/v1/device/status", reqBody)
+// Heartbeat 发送心跳
+func (c *ServerClient) Heartbeat() error {
+	// 创建心跳请求
+	reqBody := c.heartbeatStatus()
+	sentAt := time.Now()
+
+	// 发送签名请求，防止状态和 NAT 信息被伪造或重放
+	resp, err := c.signedPost("/api/v1/device/status", reqBody)
 	if err != nil {
 		return fmt.Errorf("发送心跳失败: %w", err)
 	}
 	defer resp.Body.Close()
+	receivedAt := time.Now()
 
 	// 检查响应状态
 	if resp.StatusCode != http.StatusOK {
@@ -122,9 +169,40 @@ func (c *ServerClient) Heartbeat() error {
 		return fmt.Errorf("发送心跳失败: %s", errMsg)
 	}
 
+	var result struct {
+		ServerTime time.Time `json:"serverTime"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err == nil && !result.ServerTime.IsZero() {
+		c.updateClockOffset(sentAt, receivedAt, result.ServerTime)
+	}
+
 	return nil
 }
 
+// updateClockOffset 根据心跳响应中的服务端时间估算本机时钟偏差。
+// 假设往返延迟对称，服务端时间对应请求发出和收到响应的中点
+func (c *ServerClient) updateClockOffset(sentAt, receivedAt, serverTime time.Time) {
+	local := sentAt.Add(receivedAt.Sub(sentAt) / 2)
+	offset := local.Sub(serverTime)
+	previous := time.Duration(atomic.SwapInt64(&c.clockOffset, int64(offset)))
+
+	if exceedsSkew(offset) && !exceedsSkew(previous) {
+		logger.Warn("本机时钟与服务端相差 %s，请校准系统时间，否则令牌和 TOTP 验证可能失败", offset.Round(time.Millisecond))
+	} else if !exceedsSkew(offset) && exceedsSkew(previous) {
+		logger.Info("本机时钟与服务端的偏差已恢复到 %s", offset.Round(time.Millisecond))
+	}
+}
+
+// ClockOffset 最近一次心跳测得的本机时钟与服务端时钟之差，正数表示本机偏快
+func (c *ServerClient) ClockOffset() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.clockOffset))
+}
+
+// exceedsSkew 时钟偏差是否超过告警阈值
+func exceedsSkew(offset time.Duration) bool {
+	return offset > clockSkewWarn || offset < -clockSkewWarn
+}
+
 // GetPeerInfo 获取对等节点信息
 func (c *ServerClient) GetPeerInfo(peerNodeID string) (*PeerInfo, error) {
 	// 发送请求
@@ -175,27 +253,10 @@ func (c *ServerClient) GetPeerInfo(peerNodeID string) (*PeerInfo, error) {
 		return nil, fmt.Errorf("对等节点不在线")
 	}
 
-	// 解析 NAT 类型
-	var natType nat.NATType
-	switch natTypeStr {
-	case "No NAT (Public IP)":
-		natType = nat.NATNone
-	case "Full Cone NAT":
-		natType = nat.NATFull
-	case "Restricted Cone NAT":
-		natType = nat.NATRestricted
-	case "Port Restricted Cone NAT":
-		natType = nat.NATPortRestricted
-	case "Symmetric NAT":
-		natType = nat.NATSymmetric
-	default:
-		natType = nat.NATUnknown
-	}
-
 	// 创建对等节点信息
 	peerInfo := &PeerInfo{
 		NodeID:       nodeID,
-		NATType:      natType,
+		NATType:      protocol.ParseNATType(natTypeStr),
 		ExternalIP:   externalIP,
 		ExternalPort: 27182, // 默认端口
 		LastSeen:     time.Now(),
@@ -204,6 +265,69 @@ func (c *ServerClient) GetPeerInfo(peerNodeID string) (*PeerInfo, error) {
 	return peerInfo, nil
 }
 
+// PeerResolution 对等节点名称的解析结果，source 为 alias、nodeId 或 deviceName
+type PeerResolution struct {
+	Name   string `json:"name"`
+	NodeID string `json:"nodeId"`
+	Source string `json:"source"`
+}
+
+// ResolvePeer 由服务端把别名、节点 ID 或设备名称解析为节点 ID，按本设备所属用户的别名解析
+func (c *ServerClient) ResolvePeer(name string) (*PeerResolution, error) {
+	resp, err := c.get("/api/v1/peers/resolve?name=" + url.QueryEscape(name))
+	if err != nil {
+		return nil, fmt.Errorf("解析对等节点失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var result struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&result)
+		if result.Error == "" {
+			result.Error = fmt.Sprintf("状态码 %d", resp.StatusCode)
+		}
+		return nil, fmt.Errorf("解析对等节点 %s 失败: %s", name, result.Error)
+	}
+
+	var resolved PeerResolution
+	if err := json.NewDecoder(resp.Body).Decode(&resolved); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+	return &resolved, nil
+}
+
+// ProbeAddresses 获取服务端回显探测服务的 UDP 和 TCP 地址，未监听的协议返回空字符串，都未监听时返回错误
+func (c *ServerClient) ProbeAddresses() (udp, tcp string, err error) {
+	resp, err := c.get("/api/v1/probe")
+	if err != nil {
+		return "", "", fmt.Errorf("获取回显探测服务失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("获取回显探测服务失败，状态码: %d", resp.StatusCode)
+	}
+
+	var info protocol.ProbeInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return "", "", fmt.Errorf("解析响应失败: %w", err)
+	}
+	// 探测服务与响应请求的服务器地址相同
+	host := resp.Request.URL.Hostname()
+	if info.UDPPort > 0 {
+		udp = net.JoinHostPort(host, strconv.Itoa(info.UDPPort))
+	}
+	if info.TCPPort > 0 {
+		tcp = net.JoinHostPort(host, strconv.Itoa(info.TCPPort))
+	}
+	if udp == "" && tcp == "" {
+		return "", "", fmt.Errorf("服务端未启动回显探测服务")
+	}
+	return udp, tcp, nil
+}
+
 // GetRelayServer 获取中继服务器
 func (c *ServerClient) GetRelayServer() (string, error) {
 	// 发送请求
@@ -237,15 +361,76 @@ func (c *ServerClient) GetRelayServer() (string, error) {
 	return server, nil
 }
 
-// GetApps 获取应用列表
+// TURNCredentials 服务端签发的短期 TURN 凭据，用于 WebRTC 传输的 ICE 配置
+type TURNCredentials struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	TTL      int      `json:"ttl"` // 单位：秒
+	URIs     []string `json:"uris"`
+}
+
+// GetTURNCredentials 获取本节点的短期 TURN 凭据，凭据过期前需重新获取
+func (c *ServerClient) GetTURNCredentials() (*TURNCredentials, error) {
+	// 发送请求
+	resp, err := c.get("/api/v1/turn/credentials")
+	if err != nil {
+		return nil, fmt.Errorf("获取 TURN 凭据失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 解析响应
+	var result struct {
+		TURNCredentials
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取 TURN 凭据失败: %s", result.Error)
+	}
+
+	return &result.TURNCredentials, nil
+}
+
+// AppChanges 服务端下发的应用配置变化
+type AppChanges struct {
+	Version uint
+	Full    bool               // 为 true 时 Apps 是完整的应用列表
+	Apps    []config.AppConfig // 新增或更新的应用
+	Removed []string           // 已删除的应用名称
+}
+
+// GetApps 获取完整的应用列表
 func (c *ServerClient) GetApps() ([]config.AppConfig, error) {
+	changes, err := c.GetAppChanges(0)
+	if err != nil {
+		return nil, err
+	}
+	return changes.Apps, nil
+}
+
+// GetAppChanges 获取版本号 since 之后的应用配置变化，since 为 0 时获取完整的应用列表。
+// 旧版服务端不支持增量同步，总是返回完整的应用列表
+func (c *ServerClient) GetAppChanges(since uint) (*AppChanges, error) {
 	// 发送请求
-	resp, err := c.get("/api/v1/device/apps")
+	path := "/api/v1/device/apps"
+	if since > 0 {
+		path = fmt.Sprintf("%s?since=%d", path, since)
+	}
+	resp, err := c.get(path)
 	if err != nil {
 		return nil, fmt.Errorf("获取应用列表失败: %w", err)
 	}
 	defer resp.Body.Close()
 
+	// 没有变化
+	if resp.StatusCode == http.StatusNotModified {
+		return &AppChanges{Version: since}, nil
+	}
+
 	// 解析响应
 	var result map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
@@ -267,7 +452,26 @@ func (c *ServerClient) GetApps() ([]config.AppConfig, error) {
 		return nil, fmt.Errorf("响应中缺少应用列表")
 	}
 
-	// 解析应用列表
+	changes := &AppChanges{
+		Version: uint(getInt(result, "version", 0)),
+		Apps:    parseApps(appsData),
+	}
+	// 旧版服务端的响应中没有版本号
+	full, ok := result["full"].(bool)
+	changes.Full = full || !ok
+	if removed, ok := result["removed"].([]interface{}); ok {
+		for _, name := range removed {
+			if name, ok := name.(string); ok {
+				changes.Removed = append(changes.Removed, name)
+			}
+		}
+	}
+
+	return changes, nil
+}
+
+// parseApps 解析服务端返回的应用列表
+func parseApps(appsData []interface{}) []config.AppConfig {
 	apps := make([]config.AppConfig, 0, len(appsData))
 	for _, appData := range appsData {
 		appMap, ok := appData.(map[string]interface{})
@@ -283,29 +487,327 @@ func (c *ServerClient) GetApps() ([]config.AppConfig, error) {
 			DstPort:     getInt(appMap, "dstPort", 0),
 			DstHost:     getString(appMap, "dstHost", ""),
 			Description: getString(appMap, "description", ""),
-			AutoStart:   getBool(appMap, "status", "running"),
+			// 启动后状态为 starting，之后由健康检查更新，只有 stopped 表示不需要运行
+			AutoStart: getString(appMap, "status", "stopped") != "stopped",
 		}
 
 		apps = append(apps, app)
 	}
 
-	return apps, nil
+	return apps
 }
 
-// get 发送 GET 请求
-func (c *ServerClient) get(path string) (*http.Response, error) {
-	// 创建请求
-	req, err := http.NewRequest(http.MethodGet, c.config.Server.Address+path, nil)
+// SyncRoutes 向服务器同步本节点通告的子网路由
+func (c *ServerClient) SyncRoutes(cidrs []string) error {
+	// 发送请求
+	resp, err := c.put("/api/v1/device/routes", map[string]interface{}{
+		"routes": cidrs,
+	})
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("同步路由失败: %w", err)
 	}
+	defer resp.Body.Close()
 
-	// 添加认证头
-	req.Header.Set("X-Node-ID", c.config.Node.ID)
-	req.Header.Set("X-Node-Token", c.config.Node.Token)
+	// 检查响应状态
+	if resp.StatusCode != http.StatusOK {
+		var result map[string]interface{}
+		errMsg := "未知错误"
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+			if errObj, ok := result["error"]; ok {
+				errMsg = fmt.Sprintf("%v", errObj)
+			}
+		}
+		return fmt.Errorf("同步路由失败: %s", errMsg)
+	}
 
+	return nil
+}
+
+// GetRoutes 获取本节点可访问的子网路由
+func (c *ServerClient) GetRoutes() ([]Route, error) {
 	// 发送请求
-	return c.client.Do(req)
+	resp, err := c.get("/api/v1/device/routes")
+	if err != nil {
+		return nil, fmt.Errorf("获取路由失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 解析响应
+	var result struct {
+		Routes []Route `json:"routes"`
+		Error  string  `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取路由失败: %s", result.Error)
+	}
+
+	return result.Routes, nil
+}
+
+// GetRouteClient 获取对等节点可以经本节点访问的网段
+func (c *ServerClient) GetRouteClient(peerNodeID string) ([]string, error) {
+	// 发送请求
+	resp, err := c.get("/api/v1/device/routes/clients/" + peerNodeID)
+	if err != nil {
+		return nil, fmt.Errorf("检查路由授权失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 解析响应
+	var result struct {
+		Routes []string `json:"routes"`
+		Error  string   `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("检查路由授权失败: %s", result.Error)
+	}
+
+	return result.Routes, nil
+}
+
+// SetExitNodeAdvertised 向服务器通告本节点是否作为出口节点
+func (c *ServerClient) SetExitNodeAdvertised(advertise bool) error {
+	// 发送请求
+	resp, err := c.put("/api/v1/device/exit-node", map[string]interface{}{
+		"advertise": advertise,
+	})
+	if err != nil {
+		return fmt.Errorf("通告出口节点失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusOK {
+		var result map[string]interface{}
+		errMsg := "未知错误"
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+			if errObj, ok := result["error"]; ok {
+				errMsg = fmt.Sprintf("%v", errObj)
+			}
+		}
+		return fmt.Errorf("通告出口节点失败: %s", errMsg)
+	}
+
+	return nil
+}
+
+// GetExitNodes 获取本节点可以使用的出口节点
+func (c *ServerClient) GetExitNodes() ([]ExitNode, error) {
+	// 发送请求
+	resp, err := c.get("/api/v1/device/exit-nodes")
+	if err != nil {
+		return nil, fmt.Errorf("获取出口节点失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 解析响应
+	var result struct {
+		ExitNodes []ExitNode `json:"exitNodes"`
+		Error     string     `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取出口节点失败: %s", result.Error)
+	}
+
+	return result.ExitNodes, nil
+}
+
+// AuthorizeExitNodeClient 检查对等节点是否可以使用本节点作为出口
+func (c *ServerClient) AuthorizeExitNodeClient(peerNodeID string) error {
+	// 发送请求
+	resp, err := c.get("/api/v1/device/exit-node/clients/" + peerNodeID)
+	if err != nil {
+		return fmt.Errorf("检查出口节点授权失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("节点 %s 无权使用本节点作为出口", peerNodeID)
+	}
+
+	return nil
+}
+
+// ReportSpeedTest 上报测速结果
+func (c *ServerClient) ReportSpeedTest(result *SpeedTestResult) error {
+	// 发送请求
+	resp, err := c.post("/api/v1/device/speedtests/results", result)
+	if err != nil {
+		return fmt.Errorf("上报测速结果失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusCreated {
+		var result map[string]interface{}
+		errMsg := "未知错误"
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+			if errObj, ok := result["error"]; ok {
+				errMsg = fmt.Sprintf("%v", errObj)
+			}
+		}
+		return fmt.Errorf("上报测速结果失败: %s", errMsg)
+	}
+
+	return nil
+}
+
+// ReportTrace 上报与对等节点的连接记录
+func (c *ServerClient) ReportTrace(t *trace.Trace) error {
+	// 发送请求
+	resp, err := c.post("/api/v1/device/traces", t)
+	if err != nil {
+		return fmt.Errorf("上报连接记录失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusCreated {
+		var result map[string]interface{}
+		errMsg := "未知错误"
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+			if errObj, ok := result["error"]; ok {
+				errMsg = fmt.Sprintf("%v", errObj)
+			}
+		}
+		return fmt.Errorf("上报连接记录失败: %s", errMsg)
+	}
+
+	return nil
+}
+
+// GetServerVersion 获取服务端版本和构建信息
+func (c *ServerClient) GetServerVersion() (*version.Info, error) {
+	// 发送请求
+	resp, err := c.get("/api/v1/version")
+	if err != nil {
+		return nil, fmt.Errorf("获取服务端版本失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("获取服务端版本失败: 状态码 %d", resp.StatusCode)
+	}
+
+	// 解析响应
+	var info version.Info
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("解析响应失败: %w", err)
+	}
+
+	return &info, nil
+}
+
+// ReportRecoveryEvents 上报崩溃恢复事件
+func (c *ServerClient) ReportRecoveryEvents(events []forward.RecoveryEvent) error {
+	// 发送请求
+	resp, err := c.post("/api/v1/device/events", map[string]interface{}{
+		"events": events,
+	})
+	if err != nil {
+		return fmt.Errorf("上报恢复事件失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusCreated {
+		var result map[string]interface{}
+		errMsg := "未知错误"
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+			if errObj, ok := result["error"]; ok {
+				errMsg = fmt.Sprintf("%v", errObj)
+			}
+		}
+		return fmt.Errorf("上报恢复事件失败: %s", errMsg)
+	}
+
+	return nil
+}
+
+// EventUnexpectedInbound 应用收到意外的入站连接时上报的设备事件类型
+const EventUnexpectedInbound = "unexpected-inbound"
+
+// ReportInboundEvents 上报应用收到的意外入站连接
+func (c *ServerClient) ReportInboundEvents(events []inbound.Event) error {
+	records := make([]forward.RecoveryEvent, 0, len(events))
+	for _, event := range events {
+		records = append(records, forward.RecoveryEvent{
+			Type:       EventUnexpectedInbound,
+			App:        event.App,
+			Detail:     event.Detail(),
+			OccurredAt: event.FirstSeen,
+		})
+	}
+
+	// 发送请求
+	resp, err := c.post("/api/v1/device/events", map[string]interface{}{
+		"events": records,
+	})
+	if err != nil {
+		return fmt.Errorf("上报入站连接事件失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusCreated {
+		var result map[string]interface{}
+		errMsg := "未知错误"
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+			if errObj, ok := result["error"]; ok {
+				errMsg = fmt.Sprintf("%v", errObj)
+			}
+		}
+		return fmt.Errorf("上报入站连接事件失败: %s", errMsg)
+	}
+
+	return nil
+}
+
+// ReportAppHealth 上报应用的健康状态
+func (c *ServerClient) ReportAppHealth(results []health.Result) error {
+	// 发送请求
+	resp, err := c.post("/api/v1/device/apps/health", map[string]interface{}{
+		"apps": results,
+	})
+	if err != nil {
+		return fmt.Errorf("上报应用健康状态失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// 检查响应状态
+	if resp.StatusCode != http.StatusOK {
+		var result map[string]interface{}
+		errMsg := "未知错误"
+		if err := json.NewDecoder(resp.Body).Decode(&result); err == nil {
+			if errObj, ok := result["error"]; ok {
+				errMsg = fmt.Sprintf("%v", errObj)
+			}
+		}
+		return fmt.Errorf("上报应用健康状态失败: %s", errMsg)
+	}
+
+	return nil
+}
+
+// get 发送 GET 请求
+func (c *ServerClient) get(path string) (*http.Response, error) {
+	return c.do(http.MethodGet, path, nil, false)
 }
 
 // post 发送 POST 请求
@@ -316,19 +818,18 @@ func (c *ServerClient) post(path string, body interface{}) (*http.Response, erro
 		return nil, err
 	}
 
-	// 创建请求
-	req, err := http.NewRequest(http.MethodPost, c.config.Server.Address+path, bytes.NewBuffer(bodyData))
+	return c.do(http.MethodPost, path, bodyData, false)
+}
+
+// signedPost 发送使用设备令牌签名的 POST 请求
+func (c *ServerClient) signedPost(path string, body interface{}) (*http.Response, error) {
+	// 序列化请求体
+	bodyData, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
 
-	// 添加认证头
-	req.Header.Set("X-Node-ID", c.config.Node.ID)
-	req.Header.Set("X-Node-Token", c.config.Node.Token)
-	req.Header.Set("Content-Type", "application/json")
-
-	// 发送请求
-	return c.client.Do(req)
+	return c.do(http.MethodPost, path, bodyData, true)
 }
 
 // put 发送 PUT 请求
@@ -339,35 +840,62 @@ func (c *ServerClient) put(path string, body interface{}) (*http.Response, error
 		return nil, err
 	}
 
-	// 创建请求
-	req, err := http.NewRequest(http.MethodPut, c.config.Server.Address+path, bytes.NewBuffer(bodyData))
-	if err != nil {
-		return nil, err
-	}
-
-	// 添加认证头
-	req.Header.Set("X-Node-ID", c.config.Node.ID)
-	req.Header.Set("X-Node-Token", c.config.Node.Token)
-	req.Header.Set("Content-Type", "application/json")
-
-	// 发送请求
-	return c.client.Do(req)
+	return c.do(http.MethodPut, path, bodyData, false)
 }
 
 // delete 发送 DELETE 请求
 func (c *ServerClient) delete(path string) (*http.Response, error) {
-	// 创建请求
-	req, err := http.NewRequest(http.MethodDelete, c.config.Server.Address+path, nil)
-	if err != nil {
-		return nil, err
-	}
+	return c.do(http.MethodDelete, path, nil, false)
+}
+
+// do 按优先级依次尝试各服务器端点发送请求，连接失败时自动切换到下一个端点
+func (c *ServerClient) do(method, path string, body []byte, sign bool) (*http.Response, error) {
+	var lastErr error
+	for _, address := range c.endpoints.Addresses() {
+		// 创建请求
+		var reader io.Reader
+		if body != nil {
+			reader = bytes.NewReader(body)
+		}
+		req, err := http.NewRequest(method, address+path, reader)
+		if err != nil {
+			return nil, err
+		}
 
-	// 添加认证头
-	req.Header.Set("X-Node-ID", c.config.Node.ID)
-	req.Header.Set("X-Node-Token", c.config.Node.Token)
+		// 添加认证头
+		req.Header.Set("X-Node-ID", c.config.Node.ID)
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		if c.identity.Enrolled() {
+			// 持有证书时每个请求都使用证书私钥签名，不再发送令牌
+			if err := c.identity.SignRequest(req, body); err != nil {
+				return nil, err
+			}
+		} else {
+			req.Header.Set("X-Node-Token", c.config.Node.Token)
+			if sign {
+				if err := signing.SignRequest(req, c.config.Node.Token, body); err != nil {
+					return nil, err
+				}
+			}
+		}
 
-	// 发送请求
-	return c.client.Do(req)
+		// 发送请求
+		resp, err := c.client.Do(req)
+		if err != nil {
+			logger.Warn("服务器 %s 请求失败，尝试下一个地址: %v", address, err)
+			c.endpoints.MarkFailed(address)
+			lastErr = err
+			continue
+		}
+		return resp, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("没有可用的服务器地址")
+	}
+	return nil, lastErr
 }
 
 // getString 从 map 中获取字符串
@@ -386,20 +914,12 @@ func getInt(m map[string]interface{}, key string, defaultValue int) int {
 	return defaultValue
 }
 
-// getBool 从 map 中获取布尔值
-func getBool(m map[string]interface{}, key, trueValue string) bool {
-	if val, ok := m[key].(string); ok {
-		return val == trueValue
-	}
-	return false
-}
-
 // getOS 获取操作系统
 func getOS() string {
-	return "unknown"
+	return runtime.GOOS
 }
 
 // getArch 获取架构
 func getArch() string {
-	return "unknown"
+	return runtime.GOARCH
 }
@@ -0,0 +1,265 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+)
+
+// 测速协议命令
+const (
+	speedTestPing = 'P' // 延迟探测，服务端原样返回
+	speedTestData = 'D' // 吞吐量数据块
+	speedTestEnd  = 'E' // 数据发送结束，服务端返回接收字节数
+	speedTestQuit = 'Q' // 结束测速
+)
+
+const (
+	speedTestChunkSize  = 32 * 1024
+	speedTestPingCount  = 10
+	speedTestMaxPayload = 64 * 1024
+)
+
+// SpeedTestTask 服务器下发的测速任务
+type SpeedTestTask struct {
+	ScheduleID uint   `json:"scheduleId"`
+	Role       string `json:"role"` // client 或 server
+	PeerNodeID string `json:"peerNodeId"`
+	Duration   int    `json:"duration"` // 单位：秒
+}
+
+// SpeedTestResult 测速结果
+type SpeedTestResult struct {
+	ScheduleID     uint    `json:"scheduleId"`
+	ConnectionType string  `json:"connectionType"`
+	Throughput     float64 `json:"throughput"` // 单位：Mbps
+	Latency        float64 `json:"latency"`    // 单位：毫秒
+	Jitter         float64 `json:"jitter"`     // 单位：毫秒
+	Error          string  `json:"error,omitempty"`
+}
+
+// SpeedTestDialFunc 建立到对等节点的测速连接，返回当前最佳路径的连接
+type SpeedTestDialFunc func(peerID string) (net.Conn, protocol.ConnectionType, error)
+
+// SpeedTestAcceptFunc 接受来自对等节点的测速连接
+type SpeedTestAcceptFunc func(peerID string) (net.Conn, error)
+
+// SpeedTester 测速执行器
+type SpeedTester struct {
+	serverClient *ServerClient
+	dial         SpeedTestDialFunc
+	accept       SpeedTestAcceptFunc
+	wg           sync.WaitGroup
+}
+
+// NewSpeedTester 创建测速执行器
+func NewSpeedTester(serverClient *ServerClient, dial SpeedTestDialFunc, accept SpeedTestAcceptFunc) *SpeedTester {
+	return &SpeedTester{
+		serverClient: serverClient,
+		dial:         dial,
+		accept:       accept,
+	}
+}
+
+// HandleSignal 处理测速信令，测速在后台进行，不阻塞信令的接收
+func (t *SpeedTester) HandleSignal(signal *protocol.Signal) {
+	// 重新解析负载
+	data, err := json.Marshal(signal.Payload)
+	if err != nil {
+		logger.Error("解析测速任务失败: %v", err)
+		return
+	}
+	var task SpeedTestTask
+	if err := json.Unmarshal(data, &task); err != nil {
+		logger.Error("解析测速任务失败: %v", err)
+		return
+	}
+
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		t.runTask(&task)
+	}()
+}
+
+// Wait 等待正在进行的测速结束
+func (t *SpeedTester) Wait() {
+	t.wg.Wait()
+}
+
+// runTask 执行测速任务
+func (t *SpeedTester) runTask(task *SpeedTestTask) {
+	duration := time.Duration(task.Duration) * time.Second
+
+	switch task.Role {
+	case "server":
+		conn, err := t.accept(task.PeerNodeID)
+		if err != nil {
+			logger.Warn("接受测速连接失败: %v", err)
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(duration + 30*time.Second))
+		if err := ServeSpeedTest(conn); err != nil {
+			logger.Warn("测速服务端失败: %v", err)
+		}
+
+	case "client":
+		result := &SpeedTestResult{ScheduleID: task.ScheduleID}
+		conn, connType, err := t.dial(task.PeerNodeID)
+		if err != nil {
+			result.Error = err.Error()
+		} else {
+			defer conn.Close()
+			conn.SetDeadline(time.Now().Add(duration + 30*time.Second))
+			result, err = RunSpeedTest(conn, duration)
+			if err != nil {
+				result = &SpeedTestResult{Error: err.Error()}
+			}
+			result.ScheduleID = task.ScheduleID
+			result.ConnectionType = connType.String()
+		}
+
+		if err := t.serverClient.ReportSpeedTest(result); err != nil {
+			logger.Warn("上报测速结果失败: %v", err)
+		}
+
+	default:
+		logger.Warn("未知的测速角色: %s", task.Role)
+	}
+}
+
+// RunSpeedTest 作为客户端执行测速
+func RunSpeedTest(conn net.Conn, duration time.Duration) (*SpeedTestResult, error) {
+	result := &SpeedTestResult{}
+
+	// 测量延迟
+	var rtts []float64
+	buf := make([]byte, 9)
+	for i := 0; i < speedTestPingCount; i++ {
+		buf[0] = speedTestPing
+		binary.BigEndian.PutUint64(buf[1:], uint64(time.Now().UnixNano()))
+		start := time.Now()
+		if _, err := conn.Write(buf); err != nil {
+			return nil, fmt.Errorf("发送延迟探测失败: %w", err)
+		}
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			return nil, fmt.Errorf("接收延迟探测失败: %w", err)
+		}
+		rtts = append(rtts, float64(time.Since(start).Microseconds())/1000)
+	}
+	result.Latency, result.Jitter = latencyStats(rtts)
+
+	// 测量吞吐量
+	chunk := make([]byte, 5+speedTestChunkSize)
+	chunk[0] = speedTestData
+	binary.BigEndian.PutUint32(chunk[1:5], speedTestChunkSize)
+	start := time.Now()
+	for time.Since(start) < duration {
+		if _, err := conn.Write(chunk); err != nil {
+			return nil, fmt.Errorf("发送测速数据失败: %w", err)
+		}
+	}
+	if _, err := conn.Write([]byte{speedTestEnd}); err != nil {
+		return nil, fmt.Errorf("发送测速结束标记失败: %w", err)
+	}
+
+	// 以服务端实际接收的字节数计算吞吐量
+	countBuf := make([]byte, 8)
+	if _, err := io.ReadFull(conn, countBuf); err != nil {
+		return nil, fmt.Errorf("接收测速统计失败: %w", err)
+	}
+	elapsed := time.Since(start).Seconds()
+	received := binary.BigEndian.Uint64(countBuf)
+	if elapsed > 0 {
+		result.Throughput = float64(received) * 8 / elapsed / 1e6
+	}
+
+	conn.Write([]byte{speedTestQuit})
+	return result, nil
+}
+
+// ServeSpeedTest 作为服务端响应测速
+func ServeSpeedTest(conn net.Conn) error {
+	var received uint64
+	cmd := make([]byte, 1)
+	header := make([]byte, 8)
+	payload := make([]byte, speedTestMaxPayload)
+
+	for {
+		if _, err := io.ReadFull(conn, cmd); err != nil {
+			return fmt.Errorf("读取测速命令失败: %w", err)
+		}
+
+		switch cmd[0] {
+		case speedTestPing:
+			if _, err := io.ReadFull(conn, header); err != nil {
+				return fmt.Errorf("读取延迟探测失败: %w", err)
+			}
+			if _, err := conn.Write(append([]byte{speedTestPing}, header...)); err != nil {
+				return fmt.Errorf("回复延迟探测失败: %w", err)
+			}
+
+		case speedTestData:
+			if _, err := io.ReadFull(conn, header[:4]); err != nil {
+				return fmt.Errorf("读取数据长度失败: %w", err)
+			}
+			n := binary.BigEndian.Uint32(header[:4])
+			if n > speedTestMaxPayload {
+				return fmt.Errorf("测速数据块过大: %d", n)
+			}
+			if _, err := io.ReadFull(conn, payload[:n]); err != nil {
+				return fmt.Errorf("读取测速数据失败: %w", err)
+			}
+			received += uint64(n)
+
+		case speedTestEnd:
+			binary.BigEndian.PutUint64(header, received)
+			if _, err := conn.Write(header); err != nil {
+				return fmt.Errorf("发送测速统计失败: %w", err)
+			}
+			received = 0
+
+		case speedTestQuit:
+			return nil
+
+		default:
+			return fmt.Errorf("未知的测速命令: %c", cmd[0])
+		}
+	}
+}
+
+// latencyStats 计算平均延迟和抖动
+func latencyStats(rtts []float64) (avg, jitter float64) {
+	if len(rtts) == 0 {
+		return 0, 0
+	}
+
+	var sum float64
+	for _, rtt := range rtts {
+		sum += rtt
+	}
+	avg = sum / float64(len(rtts))
+
+	// 抖动为相邻延迟差值的平均值
+	if len(rtts) > 1 {
+		var diff float64
+		for i := 1; i < len(rtts); i++ {
+			d := rtts[i] - rtts[i-1]
+			if d < 0 {
+				d = -d
+			}
+			diff += d
+		}
+		jitter = diff / float64(len(rtts)-1)
+	}
+
+	return avg, jitter
+}
@@ -0,0 +1,135 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/nat"
+	"github.com/senma231/p3/common/protocol"
+)
+
+// speedTestServer 记录上报的测速结果的服务端
+func speedTestServer(t *testing.T) (*ServerClient, func() []SpeedTestResult) {
+	t.Helper()
+
+	var mu sync.Mutex
+	var results []SpeedTestResult
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/api/v1/device/speedtests/results" {
+			http.NotFound(w, r)
+			return
+		}
+		var result SpeedTestResult
+		if err := json.NewDecoder(r.Body).Decode(&result); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		results = append(results, result)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	cfg := &config.Config{}
+	cfg.Server.Address = srv.URL
+	client := NewServerClient(cfg, &nat.NATInfo{})
+	return client, func() []SpeedTestResult {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]SpeedTestResult(nil), results...)
+	}
+}
+
+func speedTestSignal(role string) *protocol.Signal {
+	return &protocol.Signal{
+		Type:     protocol.SignalSpeedTest,
+		SenderID: "server",
+		Payload: map[string]interface{}{
+			"scheduleId": 7,
+			"role":       role,
+			"peerNodeId": "node-b",
+			"duration":   0,
+		},
+	}
+}
+
+func TestSpeedTesterClient(t *testing.T) {
+	client, results := speedTestServer(t)
+
+	var dialed string
+	tester := NewSpeedTester(client, func(peerID string) (net.Conn, protocol.ConnectionType, error) {
+		dialed = peerID
+		local, remote := net.Pipe()
+		go func() {
+			defer remote.Close()
+			ServeSpeedTest(remote)
+		}()
+		return local, protocol.ConnectionHolePunch, nil
+	}, nil)
+
+	tester.HandleSignal(speedTestSignal("client"))
+	tester.Wait()
+
+	got := results()
+	if dialed != "node-b" {
+		t.Fatalf("应连接任务中的对端, 实际为 %q", dialed)
+	}
+	if len(got) != 1 {
+		t.Fatalf("应上报一次测速结果, 实际为 %d 次", len(got))
+	}
+	if got[0].ScheduleID != 7 || got[0].Error != "" || got[0].ConnectionType != protocol.ConnectionHolePunch.String() {
+		t.Fatalf("测速结果 = %+v", got[0])
+	}
+}
+
+func TestSpeedTesterClientDialError(t *testing.T) {
+	client, results := speedTestServer(t)
+
+	tester := NewSpeedTester(client, func(string) (net.Conn, protocol.ConnectionType, error) {
+		return nil, protocol.ConnectionUnknown, errors.New("无法连接")
+	}, nil)
+
+	tester.HandleSignal(speedTestSignal("client"))
+	tester.Wait()
+
+	if got := results(); len(got) != 1 || got[0].ScheduleID != 7 || got[0].Error != "无法连接" {
+		t.Fatalf("连接失败时应上报错误, 结果 = %+v", got)
+	}
+}
+
+func TestSpeedTesterServer(t *testing.T) {
+	client, results := speedTestServer(t)
+
+	done := make(chan *SpeedTestResult, 1)
+	tester := NewSpeedTester(client, nil, func(peerID string) (net.Conn, error) {
+		local, remote := net.Pipe()
+		go func() {
+			defer remote.Close()
+			result, err := RunSpeedTest(remote, 10*time.Millisecond)
+			if err != nil {
+				t.Errorf("测速失败: %v", err)
+			}
+			done <- result
+		}()
+		return local, nil
+	})
+
+	tester.HandleSignal(speedTestSignal("server"))
+	tester.Wait()
+
+	if result := <-done; result == nil {
+		t.Fatalf("接收方应响应测速, 结果 = %+v", result)
+	}
+	if got := results(); len(got) != 0 {
+		t.Fatalf("接收方不应上报结果, 实际上报 %+v", got)
+	}
+}
@@ -0,0 +1,27 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/senma231/p3/common/version"
+)
+
+// CheckServerVersion 比较本地与服务端的版本，返回更新提示和兼容性警告
+func CheckServerVersion(server *version.Info) []string {
+	var warnings []string
+	local := version.Version
+
+	localMajor, serverMajor := version.Major(local), version.Major(server.Version)
+	if localMajor >= 0 && serverMajor >= 0 && localMajor != serverMajor {
+		warnings = append(warnings, fmt.Sprintf("客户端版本 %s 与服务端版本 %s 的主版本号不同，可能不兼容", local, server.Version))
+	}
+
+	switch version.Compare(local, server.Version) {
+	case -1:
+		warnings = append(warnings, fmt.Sprintf("发现新版本 %s，当前版本 %s，建议升级客户端", server.Version, local))
+	case 1:
+		warnings = append(warnings, fmt.Sprintf("客户端版本 %s 高于服务端版本 %s，部分功能可能不可用", local, server.Version))
+	}
+
+	return warnings
+}
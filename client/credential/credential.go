@@ -0,0 +1,236 @@
+// Package credential 保存节点令牌，避免令牌以明文形式写在配置文件中。
+//
+// 优先使用系统密钥环：Windows 凭据管理器、macOS 钥匙串、Linux 上通过 secret-tool 访问 libsecret。
+// 密钥环不可用时（例如没有桌面会话的服务器），令牌保存到使用本机绑定密钥加密的文件中，
+// 复制到其他机器后无法解密
+package credential
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// service 令牌在系统密钥环中的服务名，账户名为节点 ID
+const service = "p3-client"
+
+// 令牌的保存位置
+const (
+	LocationKeyring = "系统密钥环"
+	LocationFile    = "加密文件"
+)
+
+// ErrNotFound 没有保存节点的令牌
+var ErrNotFound = errors.New("未找到保存的令牌")
+
+// keyring 系统密钥环
+type keyring interface {
+	Get(account string) (string, error)
+	Set(account, secret string) error
+	Delete(account string) error
+}
+
+// fileData 加密文件的内容，令牌按节点 ID 分别加密
+type fileData struct {
+	Tokens map[string]string `json:"tokens"`
+}
+
+// Store 节点令牌存储
+type Store struct {
+	keyring   keyring
+	file      string
+	machineID func() (string, error)
+}
+
+// New 创建节点令牌存储，file 为密钥环不可用时使用的加密文件
+func New(file string) *Store {
+	return &Store{
+		keyring:   systemKeyring(),
+		file:      file,
+		machineID: machineID,
+	}
+}
+
+// Get 获取节点令牌，先查找系统密钥环，再查找加密文件
+func (s *Store) Get(nodeID string) (string, error) {
+	if token, err := s.keyring.Get(nodeID); err == nil && token != "" {
+		return token, nil
+	}
+	return s.getFile(nodeID)
+}
+
+// Set 保存节点令牌，返回保存位置。保存到系统密钥环后删除加密文件中的旧令牌
+func (s *Store) Set(nodeID, token string) (string, error) {
+	keyringErr := s.keyring.Set(nodeID, token)
+	if keyringErr == nil {
+		if err := s.deleteFile(nodeID); err != nil {
+			return LocationKeyring, fmt.Errorf("删除加密文件中的旧令牌失败: %w", err)
+		}
+		return LocationKeyring, nil
+	}
+
+	if err := s.setFile(nodeID, token); err != nil {
+		return "", fmt.Errorf("系统密钥环不可用（%v），写入加密文件失败: %w", keyringErr, err)
+	}
+	return LocationFile, nil
+}
+
+// Delete 删除节点令牌
+func (s *Store) Delete(nodeID string) error {
+	s.keyring.Delete(nodeID)
+	return s.deleteFile(nodeID)
+}
+
+// key 使用本机标识派生加密文件的密钥
+func (s *Store) key() ([]byte, error) {
+	id, err := s.machineID()
+	if err != nil {
+		return nil, fmt.Errorf("获取本机标识失败: %w", err)
+	}
+	sum := sha256.Sum256([]byte(service + "\x00" + id))
+	return sum[:], nil
+}
+
+// encrypt 使用 AES-GCM 加密令牌，节点 ID 作为附加数据，密文不能用于其他节点
+func encrypt(plaintext, key []byte, nodeID string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, []byte(nodeID)), nil
+}
+
+// decrypt 解密 encrypt 加密的令牌
+func decrypt(ciphertext, key []byte, nodeID string) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("密文太短")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, []byte(nodeID))
+}
+
+// newGCM 创建 AES-GCM
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// getFile 从加密文件读取令牌
+func (s *Store) getFile(nodeID string) (string, error) {
+	data, err := s.readFile()
+	if err != nil {
+		return "", err
+	}
+	encoded, ok := data.Tokens[nodeID]
+	if !ok {
+		return "", ErrNotFound
+	}
+
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("解析加密文件失败: %w", err)
+	}
+	key, err := s.key()
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := decrypt(ciphertext, key, nodeID)
+	if err != nil {
+		return "", fmt.Errorf("解密令牌失败，加密文件可能来自其他机器: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// setFile 加密令牌并写入文件
+func (s *Store) setFile(nodeID, token string) error {
+	key, err := s.key()
+	if err != nil {
+		return err
+	}
+	ciphertext, err := encrypt([]byte(token), key, nodeID)
+	if err != nil {
+		return fmt.Errorf("加密令牌失败: %w", err)
+	}
+
+	data, err := s.readFile()
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return err
+	}
+	data.Tokens[nodeID] = base64.StdEncoding.EncodeToString(ciphertext)
+	return s.writeFile(data)
+}
+
+// deleteFile 删除加密文件中的令牌，文件中没有其他令牌时删除文件
+func (s *Store) deleteFile(nodeID string) error {
+	data, err := s.readFile()
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, ok := data.Tokens[nodeID]; !ok {
+		return nil
+	}
+
+	delete(data.Tokens, nodeID)
+	if len(data.Tokens) == 0 {
+		return os.Remove(s.file)
+	}
+	return s.writeFile(data)
+}
+
+// readFile 读取加密文件，文件不存在时返回 ErrNotFound 和空内容
+func (s *Store) readFile() (*fileData, error) {
+	data := &fileData{Tokens: make(map[string]string)}
+	raw, err := os.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return data, ErrNotFound
+	}
+	if err != nil {
+		return data, fmt.Errorf("读取加密文件失败: %w", err)
+	}
+	if err := json.Unmarshal(raw, data); err != nil {
+		return data, fmt.Errorf("解析加密文件失败: %w", err)
+	}
+	if data.Tokens == nil {
+		data.Tokens = make(map[string]string)
+	}
+	return data, nil
+}
+
+// writeFile 写入加密文件，只允许当前用户读写
+func (s *Store) writeFile(data *fileData) error {
+	raw, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化加密文件失败: %w", err)
+	}
+
+	tmp := s.file + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0600); err != nil {
+		return fmt.Errorf("写入加密文件失败: %w", err)
+	}
+	if err := os.Rename(tmp, s.file); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("写入加密文件失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package credential
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeKeyring 测试用的密钥环，available 为 false 时模拟密钥环不可用
+type fakeKeyring struct {
+	available bool
+	secrets   map[string]string
+}
+
+func (k *fakeKeyring) Get(account string) (string, error) {
+	if !k.available {
+		return "", errors.New("不可用")
+	}
+	secret, ok := k.secrets[account]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return secret, nil
+}
+
+func (k *fakeKeyring) Set(account, secret string) error {
+	if !k.available {
+		return errors.New("不可用")
+	}
+	k.secrets[account] = secret
+	return nil
+}
+
+func (k *fakeKeyring) Delete(account string) error {
+	delete(k.secrets, account)
+	return nil
+}
+
+func TestStoreFallback(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "credentials.enc")
+	keyring := &fakeKeyring{secrets: make(map[string]string)}
+	store := &Store{keyring: keyring, file: file, machineID: func() (string, error) { return "machine-a", nil }}
+
+	// 密钥环不可用时写入加密文件，文件中不包含明文令牌
+	location, err := store.Set("node-a", "secret-token")
+	if err != nil || location != LocationFile {
+		t.Fatalf("保存令牌失败: %s %v", location, err)
+	}
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatalf("读取加密文件失败: %v", err)
+	}
+	if string(raw) == "" || bytes.Contains(raw, []byte("secret-token")) {
+		t.Fatalf("加密文件中不应包含明文令牌: %s", raw)
+	}
+	if token, err := store.Get("node-a"); err != nil || token != "secret-token" {
+		t.Fatalf("读取令牌失败: %q %v", token, err)
+	}
+
+	// 其他机器无法解密
+	other := &Store{keyring: keyring, file: file, machineID: func() (string, error) { return "machine-b", nil }}
+	if _, err := other.Get("node-a"); err == nil {
+		t.Fatalf("其他机器不应能解密令牌")
+	}
+
+	// 密钥环可用后保存到密钥环，并删除加密文件中的旧令牌
+	keyring.available = true
+	location, err = store.Set("node-a", "new-token")
+	if err != nil || location != LocationKeyring {
+		t.Fatalf("保存令牌失败: %s %v", location, err)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("加密文件应被删除: %v", err)
+	}
+	if token, err := store.Get("node-a"); err != nil || token != "new-token" {
+		t.Fatalf("读取令牌失败: %q %v", token, err)
+	}
+	if _, err := store.Get("node-b"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("未保存的节点应返回 ErrNotFound: %v", err)
+	}
+}
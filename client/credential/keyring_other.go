@@ -0,0 +1,89 @@
+//go:build !windows
+
+package credential
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// commandTimeout 密钥环命令的超时。没有桌面会话时 secret-tool 可能等待 D-Bus 响应
+const commandTimeout = 5 * time.Second
+
+// commandKeyring 通过命令行工具访问系统密钥环：macOS 使用 security，其他平台使用 secret-tool
+type commandKeyring struct {
+	goos string
+	// 执行命令，stdin 不为空时作为标准输入，返回标准输出
+	run func(stdin string, name string, args ...string) (string, error)
+}
+
+// systemKeyring 创建当前平台的系统密钥环
+func systemKeyring() keyring {
+	return &commandKeyring{goos: runtime.GOOS, run: run}
+}
+
+// Get 获取令牌
+func (k *commandKeyring) Get(account string) (string, error) {
+	var out string
+	var err error
+	if k.goos == "darwin" {
+		out, err = k.run("", "security", "find-generic-password", "-s", service, "-a", account, "-w")
+	} else {
+		out, err = k.run("", "secret-tool", "lookup", "service", service, "account", account)
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(out, "\r\n"), nil
+}
+
+// Set 保存令牌，令牌通过标准输入传递，不出现在进程参数中
+func (k *commandKeyring) Set(account, secret string) error {
+	if k.goos == "darwin" {
+		// security -i 从标准输入读取命令，参数按空白拆分，令牌中不能有引号和空白
+		if strings.ContainsAny(secret, "\"\\ \t\r\n") || strings.ContainsAny(account, "\"\\ \t\r\n") {
+			return fmt.Errorf("令牌或节点 ID 包含钥匙串不支持的字符")
+		}
+		_, err := k.run(fmt.Sprintf("add-generic-password -U -s %s -a \"%s\" -w \"%s\"\n", service, account, secret), "security", "-i")
+		return err
+	}
+	_, err := k.run(secret, "secret-tool", "store", "--label=P3 节点令牌 "+account, "service", service, "account", account)
+	return err
+}
+
+// Delete 删除令牌
+func (k *commandKeyring) Delete(account string) error {
+	var err error
+	if k.goos == "darwin" {
+		_, err = k.run("", "security", "delete-generic-password", "-s", service, "-a", account)
+	} else {
+		_, err = k.run("", "secret-tool", "clear", "service", service, "account", account)
+	}
+	return err
+}
+
+// run 执行命令，失败时返回命令的错误输出
+func run(stdin string, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), commandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, name, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if detail := strings.TrimSpace(stderr.String()); detail != "" {
+			return "", fmt.Errorf("%s 执行失败: %w: %s", name, err, detail)
+		}
+		return "", fmt.Errorf("%s 执行失败: %w", name, err)
+	}
+	return stdout.String(), nil
+}
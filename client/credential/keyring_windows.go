@@ -0,0 +1,116 @@
+//go:build windows
+
+package credential
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	advapi32        = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW   = advapi32.NewProc("CredReadW")
+	procCredWriteW  = advapi32.NewProc("CredWriteW")
+	procCredDeleteW = advapi32.NewProc("CredDeleteW")
+	procCredFree    = advapi32.NewProc("CredFree")
+)
+
+const (
+	credTypeGeneric         = 1
+	credPersistLocalMachine = 2
+	errorNotFound           = syscall.Errno(1168)
+)
+
+// winCredential 对应 Windows 的 CREDENTIALW 结构
+type winCredential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// credKeyring Windows 凭据管理器
+type credKeyring struct{}
+
+// systemKeyring 创建当前平台的系统密钥环
+func systemKeyring() keyring {
+	return credKeyring{}
+}
+
+// targetName 凭据名称
+func targetName(account string) (*uint16, error) {
+	return syscall.UTF16PtrFromString(service + "/" + account)
+}
+
+// Get 获取令牌
+func (credKeyring) Get(account string) (string, error) {
+	target, err := targetName(account)
+	if err != nil {
+		return "", err
+	}
+
+	var cred *winCredential
+	r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred)))
+	if r == 0 {
+		if err == errorNotFound {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("读取凭据失败: %w", err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+
+	if cred.CredentialBlobSize == 0 {
+		return "", nil
+	}
+	return string(unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)), nil
+}
+
+// Set 保存令牌
+func (credKeyring) Set(account, secret string) error {
+	target, err := targetName(account)
+	if err != nil {
+		return err
+	}
+	user, err := syscall.UTF16PtrFromString(account)
+	if err != nil {
+		return err
+	}
+	if secret == "" {
+		return fmt.Errorf("令牌不能为空")
+	}
+
+	blob := []byte(secret)
+	cred := winCredential{
+		Type:               credTypeGeneric,
+		TargetName:         target,
+		CredentialBlobSize: uint32(len(blob)),
+		CredentialBlob:     &blob[0],
+		Persist:            credPersistLocalMachine,
+		UserName:           user,
+	}
+	if r, _, err := procCredWriteW.Call(uintptr(unsafe.Pointer(&cred)), 0); r == 0 {
+		return fmt.Errorf("写入凭据失败: %w", err)
+	}
+	return nil
+}
+
+// Delete 删除令牌
+func (credKeyring) Delete(account string) error {
+	target, err := targetName(account)
+	if err != nil {
+		return err
+	}
+	if r, _, err := procCredDeleteW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0); r == 0 && err != errorNotFound {
+		return fmt.Errorf("删除凭据失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package credential
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// darwinUUID 从 ioreg 输出中提取硬件 UUID
+var darwinUUID = regexp.MustCompile(`"IOPlatformUUID" = "([0-9A-Fa-f-]+)"`)
+
+// windowsGUID 从 reg query 输出中提取 MachineGuid
+var windowsGUID = regexp.MustCompile(`MachineGuid\s+REG_SZ\s+([0-9A-Fa-f-]+)`)
+
+// machineID 获取本机的唯一标识：Linux 的 machine-id、macOS 的硬件 UUID、Windows 的 MachineGuid
+func machineID() (string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+		if err != nil {
+			return "", err
+		}
+		if m := darwinUUID.FindSubmatch(out); m != nil {
+			return string(m[1]), nil
+		}
+	case "windows":
+		out, err := exec.Command("reg", "query", `HKLM\SOFTWARE\Microsoft\Cryptography`, "/v", "MachineGuid").Output()
+		if err != nil {
+			return "", err
+		}
+		if m := windowsGUID.FindSubmatch(out); m != nil {
+			return string(m[1]), nil
+		}
+	default:
+		for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+			if data, err := os.ReadFile(path); err == nil {
+				if id := strings.TrimSpace(string(data)); id != "" {
+					return id, nil
+				}
+			}
+		}
+	}
+	return "", errors.New("当前系统没有可用的本机标识")
+}
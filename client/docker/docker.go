@@ -0,0 +1,209 @@
+// Package docker 将 docker:容器名 形式的目标地址解析为容器的 IP 地址。
+//
+// 通过本机的 Docker 套接字查询容器，解析结果缓存很短的时间，连接失败时立即作废，
+// 容器重启后 IP 地址变化也能在下一次连接时使用新地址，不需要固定容器 IP 或发布主机端口。
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Prefix 目标地址的前缀，例如 docker:web 或 docker:web/backend（使用容器在 backend 网络中的地址）
+	Prefix = "docker:"
+	// DefaultSocket Docker 套接字的默认路径，可以通过环境变量 DOCKER_HOST 指定 unix:// 地址
+	DefaultSocket = "/var/run/docker.sock"
+	// cacheTTL 解析结果的缓存时间
+	cacheTTL = 5 * time.Second
+	// requestTimeout 查询 Docker 的超时
+	requestTimeout = 5 * time.Second
+)
+
+// DefaultResolver 使用默认 Docker 套接字的解析器
+var DefaultResolver = NewResolver("")
+
+// IsTarget 检查目标地址是否为 docker:容器名 的形式
+func IsTarget(host string) bool {
+	return strings.HasPrefix(host, Prefix)
+}
+
+// ParseTarget 解析 docker:容器名[/网络名] 形式的目标地址
+func ParseTarget(host string) (container, network string, err error) {
+	if !IsTarget(host) {
+		return "", "", fmt.Errorf("不是 Docker 目标地址: %s", host)
+	}
+	container, network, _ = strings.Cut(strings.TrimPrefix(host, Prefix), "/")
+	if container == "" {
+		return "", "", fmt.Errorf("Docker 目标地址缺少容器名: %s", host)
+	}
+	if strings.ContainsAny(container, "?#% ") || strings.ContainsAny(network, "/?#% ") {
+		return "", "", fmt.Errorf("Docker 目标地址无效: %s", host)
+	}
+	return container, network, nil
+}
+
+// inspectResult 容器详情中用到的字段
+type inspectResult struct {
+	State struct {
+		Running bool `json:"Running"`
+	} `json:"State"`
+	HostConfig struct {
+		NetworkMode string `json:"NetworkMode"`
+	} `json:"HostConfig"`
+	NetworkSettings struct {
+		IPAddress string `json:"IPAddress"`
+		Networks  map[string]struct {
+			IPAddress         string `json:"IPAddress"`
+			GlobalIPv6Address string `json:"GlobalIPv6Address"`
+		} `json:"Networks"`
+	} `json:"NetworkSettings"`
+}
+
+// cached 缓存的解析结果
+type cached struct {
+	ip      string
+	expires time.Time
+}
+
+// Resolver 通过 Docker 套接字解析容器的 IP 地址
+type Resolver struct {
+	client *http.Client
+	mu     sync.Mutex
+	cache  map[string]cached
+	now    func() time.Time
+}
+
+// NewResolver 创建解析器，socket 为 Docker 套接字的路径，为空时使用环境变量 DOCKER_HOST 或默认路径
+func NewResolver(socket string) *Resolver {
+	if socket == "" {
+		socket = DefaultSocket
+		if host := os.Getenv("DOCKER_HOST"); strings.HasPrefix(host, "unix://") {
+			socket = strings.TrimPrefix(host, "unix://")
+		}
+	}
+	transport := &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", socket)
+		},
+	}
+	return &Resolver{
+		client: &http.Client{Transport: transport, Timeout: requestTimeout},
+		cache:  make(map[string]cached),
+		now:    time.Now,
+	}
+}
+
+// Resolve 解析 docker:容器名[/网络名] 为容器的 IP 地址。容器使用主机网络时返回 127.0.0.1
+func (r *Resolver) Resolve(ctx context.Context, host string) (string, error) {
+	container, network, err := ParseTarget(host)
+	if err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	entry, ok := r.cache[host]
+	r.mu.Unlock()
+	if ok && r.now().Before(entry.expires) {
+		return entry.ip, nil
+	}
+
+	ip, err := r.inspect(ctx, container, network)
+	if err != nil {
+		r.Invalidate(host)
+		return "", err
+	}
+
+	r.mu.Lock()
+	r.cache[host] = cached{ip: ip, expires: r.now().Add(cacheTTL)}
+	r.mu.Unlock()
+	return ip, nil
+}
+
+// Invalidate 作废目标地址的解析结果，连接失败时调用，下一次连接重新查询容器
+func (r *Resolver) Invalidate(host string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.cache, host)
+}
+
+// inspect 查询容器的 IP 地址
+func (r *Resolver) inspect(ctx context.Context, container, network string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://docker/containers/"+url.PathEscape(container)+"/json", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("查询 Docker 容器 %s 失败: %w", container, err)
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return "", fmt.Errorf("Docker 容器 %s 不存在", container)
+	default:
+		return "", fmt.Errorf("查询 Docker 容器 %s 失败: 状态码 %d", container, resp.StatusCode)
+	}
+
+	var result inspectResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("解析 Docker 容器 %s 的详情失败: %w", container, err)
+	}
+	if !result.State.Running {
+		return "", fmt.Errorf("Docker 容器 %s 未运行", container)
+	}
+	return containerIP(&result, container, network)
+}
+
+// containerIP 从容器详情中选择 IP 地址：指定网络时使用该网络中的地址，
+// 否则依次使用默认网桥的地址和按名称排序的第一个有地址的网络
+func containerIP(result *inspectResult, container, network string) (string, error) {
+	networks := result.NetworkSettings.Networks
+	if network != "" {
+		n, ok := networks[network]
+		if !ok {
+			return "", fmt.Errorf("Docker 容器 %s 未连接网络 %s", container, network)
+		}
+		if n.IPAddress != "" {
+			return n.IPAddress, nil
+		}
+		if n.GlobalIPv6Address != "" {
+			return n.GlobalIPv6Address, nil
+		}
+		return "", fmt.Errorf("Docker 容器 %s 在网络 %s 中没有地址", container, network)
+	}
+
+	if result.HostConfig.NetworkMode == "host" {
+		return "127.0.0.1", nil
+	}
+	if result.NetworkSettings.IPAddress != "" {
+		return result.NetworkSettings.IPAddress, nil
+	}
+	names := make([]string, 0, len(networks))
+	for name := range networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if ip := networks[name].IPAddress; ip != "" {
+			return ip, nil
+		}
+	}
+	for _, name := range names {
+		if ip := networks[name].GlobalIPv6Address; ip != "" {
+			return ip, nil
+		}
+	}
+	return "", fmt.Errorf("Docker 容器 %s 没有可用的 IP 地址", container)
+}
@@ -0,0 +1,132 @@
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDocker 在 Unix 套接字上模拟 Docker 的容器详情接口
+type fakeDocker struct {
+	mu         sync.Mutex
+	containers map[string]inspectResult
+	requests   int
+}
+
+func (d *fakeDocker) set(name, ip string, running bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var result inspectResult
+	result.State.Running = running
+	result.NetworkSettings.IPAddress = ip
+	d.containers[name] = result
+}
+
+func (d *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests++
+	name := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/containers/"), "/json")
+	result, ok := d.containers[name]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(result)
+}
+
+func startFakeDocker(t *testing.T) (*fakeDocker, *Resolver) {
+	t.Helper()
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skipf("不支持 Unix 套接字: %v", err)
+	}
+	d := &fakeDocker{containers: make(map[string]inspectResult)}
+	server := &http.Server{Handler: d}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return d, NewResolver(socket)
+}
+
+func TestResolveFollowsContainerRestart(t *testing.T) {
+	d, r := startFakeDocker(t)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	d.set("web", "172.17.0.2", true)
+
+	ip, err := r.Resolve(context.Background(), "docker:web")
+	if err != nil || ip != "172.17.0.2" {
+		t.Fatalf("解析结果 %s, %v", ip, err)
+	}
+
+	// 容器重启后地址变化，缓存期间仍使用旧地址，过期后使用新地址
+	d.set("web", "172.17.0.5", true)
+	if ip, _ := r.Resolve(context.Background(), "docker:web"); ip != "172.17.0.2" {
+		t.Fatalf("缓存期间应使用缓存的地址: %s", ip)
+	}
+	now = now.Add(cacheTTL + time.Second)
+	if ip, _ := r.Resolve(context.Background(), "docker:web"); ip != "172.17.0.5" {
+		t.Fatalf("缓存过期后应重新解析: %s", ip)
+	}
+
+	// 连接失败后作废缓存，立即重新解析
+	d.set("web", "172.17.0.9", true)
+	r.Invalidate("docker:web")
+	if ip, _ := r.Resolve(context.Background(), "docker:web"); ip != "172.17.0.9" {
+		t.Fatalf("作废后应重新解析: %s", ip)
+	}
+
+	d.set("web", "172.17.0.9", false)
+	now = now.Add(cacheTTL + time.Second)
+	if _, err := r.Resolve(context.Background(), "docker:web"); err == nil || !strings.Contains(err.Error(), "未运行") {
+		t.Fatalf("容器未运行时应返回错误: %v", err)
+	}
+	if _, err := r.Resolve(context.Background(), "docker:db"); err == nil || !strings.Contains(err.Error(), "不存在") {
+		t.Fatalf("容器不存在时应返回错误: %v", err)
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	container, network, err := ParseTarget("docker:web/backend")
+	if err != nil || container != "web" || network != "backend" {
+		t.Fatalf("解析结果 %s %s %v", container, network, err)
+	}
+	for _, invalid := range []string{"web", "docker:", "docker:/net", "docker:a b", "docker:web/a/b"} {
+		if _, _, err := ParseTarget(invalid); err == nil {
+			t.Errorf("目标地址 %q 应无效", invalid)
+		}
+	}
+}
+
+func TestContainerIP(t *testing.T) {
+	var result inspectResult
+	result.NetworkSettings.Networks = map[string]struct {
+		IPAddress         string `json:"IPAddress"`
+		GlobalIPv6Address string `json:"GlobalIPv6Address"`
+	}{
+		"frontend": {IPAddress: "10.1.0.3"},
+		"backend":  {IPAddress: "10.2.0.3"},
+	}
+
+	if ip, _ := containerIP(&result, "web", ""); ip != "10.2.0.3" {
+		t.Errorf("未指定网络时应使用按名称排序的第一个网络: %s", ip)
+	}
+	if ip, _ := containerIP(&result, "web", "frontend"); ip != "10.1.0.3" {
+		t.Errorf("应使用指定网络中的地址: %s", ip)
+	}
+	if _, err := containerIP(&result, "web", "other"); err == nil {
+		t.Error("未连接指定网络时应返回错误")
+	}
+	result.HostConfig.NetworkMode = "host"
+	result.NetworkSettings.Networks = nil
+	if ip, _ := containerIP(&result, "web", ""); ip != "127.0.0.1" {
+		t.Errorf("主机网络的容器应使用回环地址: %s", ip)
+	}
+}
@@ -0,0 +1,202 @@
+package endpoint
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/client/proxy"
+)
+
+// healthPath 健康检查路径
+const healthPath = "/health"
+
+// Endpoint 服务器端点
+type Endpoint struct {
+	Address   string
+	Healthy   bool
+	Latency   time.Duration
+	CheckedAt time.Time
+}
+
+// Pool 服务器端点池，定期检查各端点的健康状态和延迟，优先使用延迟最低的健康端点
+type Pool struct {
+	endpoints []*Endpoint
+	client    *http.Client
+	stopCh    chan struct{}
+	mu        sync.RWMutex
+}
+
+// NewPool 创建服务器端点池，addresses 的顺序作为首次检查前的优先级
+func NewPool(addresses []string, timeout time.Duration) *Pool {
+	if timeout == 0 {
+		timeout = 5 * time.Second
+	}
+
+	endpoints := make([]*Endpoint, 0, len(addresses))
+	for _, address := range addresses {
+		endpoints = append(endpoints, &Endpoint{
+			Address: strings.TrimRight(address, "/"),
+			Healthy: true,
+		})
+	}
+
+	return &Pool{
+		endpoints: endpoints,
+		client:    &http.Client{Timeout: timeout},
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// SetProxy 设置健康检查使用的代理，需在 Start 之前调用
+func (p *Pool) SetProxy(px *proxy.Proxy) {
+	p.client.Transport = px.Transport()
+}
+
+// Start 启动定期健康检查
+func (p *Pool) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	p.Probe()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stopCh:
+				return
+			case <-ticker.C:
+				p.Probe()
+			}
+		}
+	}()
+}
+
+// Stop 停止健康检查
+func (p *Pool) Stop() {
+	close(p.stopCh)
+}
+
+// Probe 检查所有端点的健康状态和延迟
+func (p *Pool) Probe() {
+	p.mu.RLock()
+	endpoints := make([]*Endpoint, len(p.endpoints))
+	copy(endpoints, p.endpoints)
+	p.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, ep := range endpoints {
+		wg.Add(1)
+		go func(ep *Endpoint) {
+			defer wg.Done()
+			latency, err := p.check(ep.Address)
+
+			p.mu.Lock()
+			ep.Healthy = err == nil
+			ep.Latency = latency
+			ep.CheckedAt = time.Now()
+			p.mu.Unlock()
+		}(ep)
+	}
+	wg.Wait()
+}
+
+// check 检查单个端点
+func (p *Pool) check(address string) (time.Duration, error) {
+	start := time.Now()
+	resp, err := p.client.Get(address + healthPath)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("健康检查返回状态码 %d", resp.StatusCode)
+	}
+	return time.Since(start), nil
+}
+
+// Current 获取当前首选端点
+func (p *Pool) Current() string {
+	addresses := p.Addresses()
+	if len(addresses) == 0 {
+		return ""
+	}
+	return addresses[0]
+}
+
+// Addresses 按优先级返回所有端点：健康端点在前并按延迟升序，不健康端点作为最后的备选
+func (p *Pool) Addresses() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	endpoints := make([]*Endpoint, len(p.endpoints))
+	copy(endpoints, p.endpoints)
+
+	sort.SliceStable(endpoints, func(i, j int) bool {
+		if endpoints[i].Healthy != endpoints[j].Healthy {
+			return endpoints[i].Healthy
+		}
+		// 首次检查前延迟均为 0，保持配置顺序
+		return endpoints[i].Latency < endpoints[j].Latency
+	})
+
+	addresses := make([]string, len(endpoints))
+	for i, ep := range endpoints {
+		addresses[i] = ep.Address
+	}
+	return addresses
+}
+
+// MarkFailed 标记端点请求失败，在下次健康检查前不再优先使用
+func (p *Pool) MarkFailed(address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, ep := range p.endpoints {
+		if ep.Address == address {
+			ep.Healthy = false
+			return
+		}
+	}
+}
+
+// Endpoints 获取所有端点状态的副本
+func (p *Pool) Endpoints() []Endpoint {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	result := make([]Endpoint, len(p.endpoints))
+	for i, ep := range p.endpoints {
+		result[i] = *ep
+	}
+	return result
+}
+
+// Discover 通过 DNS SRV 记录发现服务器端点，scheme 为 http 或 https
+func Discover(name, scheme string) ([]string, error) {
+	_, records, err := net.LookupSRV("", "", name)
+	if err != nil {
+		return nil, fmt.Errorf("查询 SRV 记录失败: %w", err)
+	}
+
+	// SRV 记录已按优先级和权重排序
+	addresses := make([]string, 0, len(records))
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		u := url.URL{
+			Scheme: scheme,
+			Host:   net.JoinHostPort(host, fmt.Sprint(record.Port)),
+		}
+		addresses = append(addresses, u.String())
+	}
+	return addresses, nil
+}
@@ -0,0 +1,39 @@
+package endpoint
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestPoolFailover(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer healthy.Close()
+
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+
+	pool := NewPool([]string{unhealthy.URL, healthy.URL}, time.Second)
+	if pool.Current() != unhealthy.URL {
+		t.Fatalf("检查前应使用配置顺序，实际 %s", pool.Current())
+	}
+
+	pool.Probe()
+	if pool.Current() != healthy.URL {
+		t.Fatalf("应优先使用健康端点，实际 %s", pool.Current())
+	}
+
+	pool.MarkFailed(healthy.URL)
+	addresses := pool.Addresses()
+	if len(addresses) != 2 {
+		t.Fatalf("端点数量错误: %d", len(addresses))
+	}
+	if addresses[0] != unhealthy.URL {
+		t.Errorf("全部失败时应保持配置顺序，实际 %v", addresses)
+	}
+}
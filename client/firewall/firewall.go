@@ -0,0 +1,110 @@
+// Package firewall 在转发器开始监听时添加放行入站连接的防火墙规则，停止时移除。
+// Windows 使用 netsh advfirewall，macOS 在默认 pf 配置引用的 com.apple 锚点下加载规则；
+// 其他平台不做处理
+package firewall
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// rulePrefix 规则名称前缀，便于识别客户端添加的规则
+const rulePrefix = "P3 "
+
+// pfAnchor macOS 默认 pf.conf 中引用的锚点，其下的子锚点无需修改 pf.conf 即可生效
+const pfAnchor = "com.apple/p3."
+
+// Rule 放行入站连接的规则
+type Rule struct {
+	Name     string // 应用名称
+	Protocol string // tcp 或 udp
+	Port     int
+}
+
+// Firewall 管理客户端添加的防火墙规则
+type Firewall struct {
+	goos string
+	// 执行命令，stdin 不为空时作为标准输入
+	run func(stdin string, name string, args ...string) error
+}
+
+// New 创建当前平台的防火墙规则管理
+func New() *Firewall {
+	return &Firewall{goos: runtime.GOOS, run: run}
+}
+
+// Supported 当前平台是否支持自动添加防火墙规则
+func (f *Firewall) Supported() bool {
+	return f.goos == "windows" || f.goos == "darwin"
+}
+
+// Allow 添加放行规则，已存在同名规则时先移除
+func (f *Firewall) Allow(rule Rule) error {
+	switch f.goos {
+	case "windows":
+		// netsh 允许重名规则，先删除避免重复添加
+		f.run("", "netsh", windowsDeleteArgs(rule)...)
+		return f.run("", "netsh", "advfirewall", "firewall", "add", "rule",
+			"name="+ruleName(rule), "dir=in", "action=allow",
+			"protocol="+strings.ToUpper(rule.Protocol), fmt.Sprintf("localport=%d", rule.Port))
+	case "darwin":
+		// 加载锚点规则会替换锚点中已有的规则
+		return f.run(fmt.Sprintf("pass in quick proto %s from any to any port %d\n", rule.Protocol, rule.Port),
+			"pfctl", "-a", anchorName(rule), "-f", "-")
+	default:
+		return nil
+	}
+}
+
+// Remove 移除放行规则
+func (f *Firewall) Remove(rule Rule) error {
+	switch f.goos {
+	case "windows":
+		return f.run("", "netsh", windowsDeleteArgs(rule)...)
+	case "darwin":
+		return f.run("", "pfctl", "-a", anchorName(rule), "-F", "rules")
+	default:
+		return nil
+	}
+}
+
+// windowsDeleteArgs 删除 Windows 防火墙规则的参数
+func windowsDeleteArgs(rule Rule) []string {
+	return []string{"advfirewall", "firewall", "delete", "rule",
+		"name=" + ruleName(rule), "protocol=" + strings.ToUpper(rule.Protocol), fmt.Sprintf("localport=%d", rule.Port)}
+}
+
+// ruleName Windows 防火墙规则名称
+func ruleName(rule Rule) string {
+	return rulePrefix + rule.Name
+}
+
+// anchorName pf 锚点名称，只保留字母、数字、- 和 _
+func anchorName(rule Rule) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, rule.Name)
+	return fmt.Sprintf("%s%s-%s-%d", pfAnchor, name, rule.Protocol, rule.Port)
+}
+
+// run 执行命令，失败时返回命令输出
+func run(stdin string, name string, args ...string) error {
+	cmd := exec.Command(name, args...)
+	if stdin != "" {
+		cmd.Stdin = strings.NewReader(stdin)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		if detail := string(bytes.TrimSpace(out)); detail != "" {
+			return fmt.Errorf("%s 执行失败: %w: %s", name, err, detail)
+		}
+		return fmt.Errorf("%s 执行失败: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package firewall
+
+import (
+	"strings"
+	"testing"
+)
+
+// recorder 记录执行的命令
+type recorder struct {
+	commands []string
+	stdin    []string
+}
+
+func (r *recorder) run(stdin string, name string, args ...string) error {
+	r.commands = append(r.commands, name+" "+strings.Join(args, " "))
+	r.stdin = append(r.stdin, stdin)
+	return nil
+}
+
+func TestWindowsRules(t *testing.T) {
+	r := &recorder{}
+	f := &Firewall{goos: "windows", run: r.run}
+	rule := Rule{Name: "web", Protocol: "tcp", Port: 18080}
+
+	if err := f.Allow(rule); err != nil {
+		t.Fatalf("添加规则失败: %v", err)
+	}
+	want := []string{
+		"netsh advfirewall firewall delete rule name=P3 web protocol=TCP localport=18080",
+		"netsh advfirewall firewall add rule name=P3 web dir=in action=allow protocol=TCP localport=18080",
+	}
+	if strings.Join(r.commands, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("添加规则的命令错误:\n%s", strings.Join(r.commands, "\n"))
+	}
+
+	r.commands = nil
+	f.Remove(rule)
+	if len(r.commands) != 1 || r.commands[0] != want[0] {
+		t.Fatalf("移除规则的命令错误: %v", r.commands)
+	}
+}
+
+func TestDarwinRules(t *testing.T) {
+	r := &recorder{}
+	f := &Firewall{goos: "darwin", run: r.run}
+	rule := Rule{Name: "my app;rm", Protocol: "udp", Port: 5353}
+
+	f.Allow(rule)
+	f.Remove(rule)
+	want := []string{
+		"pfctl -a com.apple/p3.my_app_rm-udp-5353 -f -",
+		"pfctl -a com.apple/p3.my_app_rm-udp-5353 -F rules",
+	}
+	if strings.Join(r.commands, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("pf 命令错误:\n%s", strings.Join(r.commands, "\n"))
+	}
+	if r.stdin[0] != "pass in quick proto udp from any to any port 5353\n" {
+		t.Fatalf("pf 规则错误: %q", r.stdin[0])
+	}
+
+	// 其他平台不执行命令
+	r.commands = nil
+	f = &Firewall{goos: "linux", run: r.run}
+	if f.Supported() || f.Allow(rule) != nil || len(r.commands) != 0 {
+		t.Fatal("其他平台不应执行命令")
+	}
+}
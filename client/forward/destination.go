@@ -0,0 +1,192 @@
+package forward
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/senma231/p3/common/stats"
+)
+
+// maxDestinations 每个应用保留的目标地址统计数上限，超过时淘汰最久未活动的目标
+const maxDestinations = 256
+
+// 目标地址统计的排序方式
+const (
+	SortByBytes       = "bytes"
+	SortByConnections = "connections"
+	SortByRecent      = "recent"
+)
+
+// DestinationStats 应用到单个目标地址的流量统计。规则的目标主机为域名时，
+// 解析出的每个地址分别统计，Host 为规则中的主机名，Address 为实际连接的 IP 和端口
+type DestinationStats struct {
+	App           string    `json:"app"`
+	Protocol      string    `json:"protocol"`
+	Host          string    `json:"host"`
+	Address       string    `json:"address"`
+	BytesSent     uint64    `json:"bytesSent"`
+	BytesReceived uint64    `json:"bytesReceived"`
+	Connections   uint64    `json:"connections"`
+	LastActive    time.Time `json:"lastActive"`
+}
+
+// destination 目标地址的累计统计及已上报到服务端的部分
+type destination struct {
+	DestinationStats
+	reported DestinationStats
+}
+
+// connected 记录一个到目标地址的连接，返回该目标的统计，转发结束后用于累计流量
+func (s *Stats) connected(protocol, host string, addr net.Addr) *destination {
+	address := host
+	if addr != nil {
+		address = addr.String()
+	}
+	key := protocol + "/" + address
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.destinations == nil {
+		s.destinations = make(map[string]*destination)
+	}
+	d := s.destinations[key]
+	if d == nil {
+		if len(s.destinations) >= maxDestinations {
+			s.evictDestination()
+		}
+		d = &destination{DestinationStats: DestinationStats{Protocol: protocol, Host: host, Address: address}}
+		s.destinations[key] = d
+	}
+	d.Connections++
+	d.LastActive = time.Now()
+	return d
+}
+
+// transferred 累计到目标地址的流量。目标已被淘汰时只丢弃该目标的统计，应用的累计流量不受影响
+func (s *Stats) transferred(d *destination, sent, received uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	d.BytesSent = stats.Add(d.BytesSent, sent)
+	d.BytesReceived = stats.Add(d.BytesReceived, received)
+	d.LastActive = time.Now()
+}
+
+// evictDestination 淘汰最久未活动的目标地址，调用方需持有 s.mu
+func (s *Stats) evictDestination() {
+	var oldest string
+	for key, d := range s.destinations {
+		if oldest == "" || d.LastActive.Before(s.destinations[oldest].LastActive) {
+			oldest = key
+		}
+	}
+	delete(s.destinations, oldest)
+}
+
+// Destinations 获取各目标地址的累计统计
+func (s *Stats) Destinations(app string) []DestinationStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]DestinationStats, 0, len(s.destinations))
+	for _, d := range s.destinations {
+		stats := d.DestinationStats
+		stats.App = app
+		list = append(list, stats)
+	}
+	return list
+}
+
+// TopDestinations 按排序方式返回流量最多的目标地址，app 为空时包含所有应用，limit 不大于 0 时不限制数量
+func (m *ForwarderManager) TopDestinations(app string, limit int, sortBy string) ([]DestinationStats, error) {
+	less, err := destinationOrder(sortBy)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []DestinationStats
+	if app != "" {
+		f, err := m.GetForwarder(app)
+		if err != nil {
+			return nil, err
+		}
+		list = f.GetStats().Destinations(app)
+	} else {
+		for name, f := range m.GetAllForwarders() {
+			list = append(list, f.GetStats().Destinations(name)...)
+		}
+	}
+
+	sort.Slice(list, func(i, j int) bool { return less(&list[i], &list[j]) })
+	if limit > 0 && len(list) > limit {
+		list = list[:limit]
+	}
+	return list, nil
+}
+
+// destinationOrder 返回排序方式对应的比较函数，默认按总流量排序
+func destinationOrder(sortBy string) (func(a, b *DestinationStats) bool, error) {
+	switch sortBy {
+	case "", SortByBytes:
+		return func(a, b *DestinationStats) bool {
+			return stats.Add(a.BytesSent, a.BytesReceived) > stats.Add(b.BytesSent, b.BytesReceived)
+		}, nil
+	case SortByConnections:
+		return func(a, b *DestinationStats) bool { return a.Connections > b.Connections }, nil
+	case SortByRecent:
+		return func(a, b *DestinationStats) bool { return a.LastActive.After(b.LastActive) }, nil
+	}
+	return nil, fmt.Errorf("不支持的排序方式: %s", sortBy)
+}
+
+// DestinationRollup 汇总各应用自上次上报以来按目标地址的流量增量，按流量取前 limit 个。
+// 上报成功后调用返回的函数标记这些增量已上报，上报失败时不调用，增量计入下一次汇总
+func (m *ForwarderManager) DestinationRollup(limit int) ([]DestinationStats, func()) {
+	type pending struct {
+		stats *Stats
+		d     *destination
+		at    DestinationStats // 汇总时的累计值
+		delta DestinationStats
+	}
+
+	var all []pending
+	for name, f := range m.GetAllForwarders() {
+		appStats := f.GetStats()
+		appStats.mu.Lock()
+		for _, d := range appStats.destinations {
+			delta := d.DestinationStats
+			delta.App = name
+			delta.BytesSent = stats.Delta(d.reported.BytesSent, d.BytesSent)
+			delta.BytesReceived = stats.Delta(d.reported.BytesReceived, d.BytesReceived)
+			delta.Connections = stats.Delta(d.reported.Connections, d.Connections)
+			if delta.BytesSent == 0 && delta.BytesReceived == 0 && delta.Connections == 0 {
+				continue
+			}
+			all = append(all, pending{stats: appStats, d: d, at: d.DestinationStats, delta: delta})
+		}
+		appStats.mu.Unlock()
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		a, b := &all[i].delta, &all[j].delta
+		return stats.Add(a.BytesSent, a.BytesReceived) > stats.Add(b.BytesSent, b.BytesReceived)
+	})
+	if limit > 0 && len(all) > limit {
+		all = all[:limit]
+	}
+
+	rollup := make([]DestinationStats, len(all))
+	for i := range all {
+		rollup[i] = all[i].delta
+	}
+	return rollup, func() {
+		for _, p := range all {
+			p.stats.mu.Lock()
+			p.d.reported = p.at
+			p.stats.mu.Unlock()
+		}
+	}
+}
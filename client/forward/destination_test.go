@@ -0,0 +1,69 @@
+package forward
+
+import (
+	"net"
+	"testing"
+
+	"github.com/senma231/p3/client/config"
+)
+
+func TestDestinationStats(t *testing.T) {
+	m := NewForwarderManager()
+	f := NewForwarder(&config.AppConfig{Name: "web", Protocol: "tcp", DstHost: "web.internal", DstPort: 80}, 0)
+	m.forwarders["web"] = f
+
+	// 域名解析出的两个地址分别统计
+	a := f.stats.connected("tcp", "web.internal", &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 80})
+	b := f.stats.connected("tcp", "web.internal", &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 80})
+	f.stats.connected("tcp", "web.internal", &net.TCPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 80})
+	f.stats.transferred(a, 100, 1000)
+	f.stats.transferred(b, 10, 10)
+
+	top, err := m.TopDestinations("web", 1, SortByBytes)
+	if err != nil || len(top) != 1 || top[0].Address != "10.0.0.1:80" || top[0].Host != "web.internal" {
+		t.Fatalf("按流量排序错误: %+v %v", top, err)
+	}
+	top, _ = m.TopDestinations("", 0, SortByConnections)
+	if len(top) != 2 || top[0].Address != "10.0.0.2:80" || top[0].Connections != 2 || top[0].App != "web" {
+		t.Fatalf("按连接数排序错误: %+v", top)
+	}
+	if _, err := m.TopDestinations("web", 0, "name"); err == nil {
+		t.Fatal("不支持的排序方式应返回错误")
+	}
+	if _, err := m.TopDestinations("missing", 0, ""); err == nil {
+		t.Fatal("不存在的应用应返回错误")
+	}
+
+	// 未标记上报时增量计入下一次汇总
+	rollup, _ := m.DestinationRollup(1)
+	if len(rollup) != 1 || rollup[0].BytesReceived != 1000 {
+		t.Fatalf("汇总错误: %+v", rollup)
+	}
+	rollup, reported := m.DestinationRollup(0)
+	if len(rollup) != 2 {
+		t.Fatalf("汇总错误: %+v", rollup)
+	}
+	// 汇总后产生的流量不会被标记为已上报
+	f.stats.transferred(a, 1, 0)
+	reported()
+
+	rollup, _ = m.DestinationRollup(0)
+	if len(rollup) != 1 || rollup[0].BytesSent != 1 || rollup[0].Connections != 0 {
+		t.Fatalf("上报后的增量错误: %+v", rollup)
+	}
+}
+
+func TestDestinationEviction(t *testing.T) {
+	s := &Stats{}
+	first := s.connected("tcp", "db", &net.TCPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5432})
+	for i := 0; i < maxDestinations; i++ {
+		d := s.connected("tcp", "db", &net.TCPAddr{IP: net.IPv4(10, 1, byte(i>>8), byte(i)), Port: 5432})
+		d.LastActive = first.LastActive.Add(1)
+	}
+	if len(s.destinations) != maxDestinations {
+		t.Fatalf("目标地址数为 %d", len(s.destinations))
+	}
+	if _, ok := s.destinations["tcp/10.0.0.1:5432"]; ok {
+		t.Fatal("应淘汰最久未活动的目标地址")
+	}
+}
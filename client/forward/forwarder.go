@@ -4,24 +4,56 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"reflect"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/senma231/p3/client/budget"
 	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/firewall"
+	"github.com/senma231/p3/client/health"
+	"github.com/senma231/p3/client/inbound"
+	"github.com/senma231/p3/client/transport"
 	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/stats"
 )
 
-// Forwarder 转发器
+// Forwarder 应用的转发器，TCP 应用按连接转发，UDP 应用按客户端地址建立会话转发
 type Forwarder struct {
 	config     *config.AppConfig
 	listener   net.Listener
+	packetConn *net.UDPConn // UDP 应用的监听套接字
+	preset     net.Listener
 	conn       net.Conn
 	stopCh     chan struct{}
 	wg         sync.WaitGroup
 	stats      *Stats
 	bufferSize int
 	running    bool
-	mu         sync.Mutex
+	// 监听器异常退出时调用，转发器仍处于运行状态，需要调用方停止
+	onExit func(err error)
+	// 创建监听器，为 nil 时使用 net.Listen
+	listen ListenFunc
+	// 不为 nil 时监听期间添加放行入站连接的防火墙规则
+	firewall *firewall.Firewall
+	// 不为 nil 时按应用的入站连接监控检查连接来源和时间
+	inbound *inbound.Monitor
+	// 按顺序执行应用的生命周期钩子
+	hooks hookQueue
+	// 不为 nil 时超过资源预算的新连接被拒绝。接受连接时读取，不持有 mu，避免与停止转发器相互等待
+	budget atomic.Pointer[budget.Budget]
+	// 不为 nil 时使用其连接目标，例如按出口节点路由。连接目标时读取，不持有 mu
+	dial atomic.Pointer[DialFunc]
+	mu       sync.Mutex
+
+	// 正在转发的连接及接受连接时的规则代数。平滑替换规则后代数加一，
+	// 旧代的连接继续按旧规则转发，直到结束或排空超时后被关闭
+	sessions   map[net.Conn]int
+	generation int
+	sessionMu  sync.Mutex
 }
 
 // Stats 统计信息
@@ -31,7 +63,9 @@ type Stats struct {
 	Connections     uint64
 	ConnectionTime  uint64
 	LastActiveTime  time.Time
-	mu              sync.Mutex
+	// 按协议和目标地址的统计，键为协议和实际连接的地址
+	destinations map[string]*destination
+	mu           sync.Mutex
 }
 
 // NewForwarder 创建转发器
@@ -45,6 +79,7 @@ func NewForwarder(cfg *config.AppConfig, bufferSize int) *Forwarder {
 		stopCh:     make(chan struct{}),
 		stats:      &Stats{LastActiveTime: time.Now()},
 		bufferSize: bufferSize,
+		sessions:   make(map[net.Conn]int),
 	}
 }
 
@@ -57,21 +92,54 @@ func (f *Forwarder) Start() error {
 		return fmt.Errorf("转发器已在运行")
 	}
 
-	// 创建监听器
-	var err error
-	listenAddr := fmt.Sprintf(":%d", f.config.SrcPort)
-	f.listener, err = net.Listen(f.config.Protocol, listenAddr)
+	// 停止后重新启动时需要新的停止信号
+	f.stopCh = make(chan struct{})
+
+	// 创建监听器，优先使用预先提供的监听器（如 systemd 套接字激活）
+	listenAddr, err := listenAddress(f.config)
 	if err != nil {
-		return fmt.Errorf("创建监听器失败: %w", err)
+		return err
+	}
+	if f.config.Protocol == "udp" {
+		conn, err := listenUDP(listenAddr)
+		if err != nil {
+			return err
+		}
+		f.sessionMu.Lock()
+		f.packetConn = conn
+		f.sessionMu.Unlock()
+	} else if f.preset != nil {
+		f.listener = f.preset
+		f.preset = nil
+	} else {
+		listen := f.listen
+		if listen == nil {
+			listen = net.Listen
+		}
+		f.listener, err = listen(f.config.Protocol, listenAddr)
+		if err != nil {
+			return fmt.Errorf("创建监听器失败: %w", err)
+		}
+	}
+
+	if f.firewall != nil {
+		if err := f.firewall.Allow(firewallRule(f.config)); err != nil {
+			logger.Warn("添加应用 %s 的防火墙规则失败: %v", f.config.Name, err)
+		}
 	}
 
 	f.running = true
 	f.wg.Add(1)
 
 	// 启动接收协程
-	go f.acceptLoop()
+	if f.config.Protocol == "udp" {
+		go f.udpLoop(f.packetConn)
+	} else {
+		go f.acceptLoop(f.listener)
+	}
 
 	logger.Info("转发器已启动: %s -> %s:%d", listenAddr, f.config.DstHost, f.config.DstPort)
+	f.runHook(f.config, HookStart)
 	return nil
 }
 
@@ -84,27 +152,216 @@ func (f *Forwarder) Stop() error {
 		return nil
 	}
 
+	// 先发送停止信号，接收协程不会把关闭监听器当作异常退出
+	close(f.stopCh)
+
 	// 关闭监听器
 	if f.listener != nil {
 		f.listener.Close()
 	}
+	if f.packetConn != nil {
+		f.packetConn.Close()
+	}
+	if f.firewall != nil {
+		if err := f.firewall.Remove(firewallRule(f.config)); err != nil {
+			logger.Warn("移除应用 %s 的防火墙规则失败: %v", f.config.Name, err)
+		}
+	}
 
 	// 关闭连接
 	if f.conn != nil {
 		f.conn.Close()
 	}
-
-	// 发送停止信号
-	close(f.stopCh)
+	f.closeSessions(func(int) bool { return true })
 
 	// 等待所有协程退出
 	f.wg.Wait()
 
 	f.running = false
 	logger.Info("转发器已停止: %s", f.config.Name)
+	f.runHook(f.config, HookStop)
 	return nil
 }
 
+// SetListener 设置下次启动时使用的监听器，监听器在停止转发器时关闭
+func (f *Forwarder) SetListener(listener net.Listener) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.preset = listener
+}
+
+// Replace 平滑替换转发规则。监听端口或地址变化时先在新地址上监听，成功后再关闭旧的监听器；
+// 已建立的连接继续按旧规则转发，超过 drainTimeout 仍未结束的连接被关闭，为 0 时立即关闭。
+// 统计信息保留。转发器未运行时只更新配置，协议不能变化
+func (f *Forwarder) Replace(cfg *config.AppConfig, drainTimeout time.Duration) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if cfg.Protocol != f.config.Protocol {
+		return fmt.Errorf("协议变化时需要重新创建转发器")
+	}
+	if !f.running {
+		f.config = cfg
+		return nil
+	}
+	if cfg.Protocol == "udp" {
+		return f.replaceUDP(cfg, drainTimeout)
+	}
+
+	old := f.config
+	oldListener := f.listener
+	listener := oldListener
+	listenAddr, err := listenAddress(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.SrcPort != old.SrcPort || cfg.Bind != old.Bind {
+		listen := f.listen
+		if listen == nil {
+			listen = net.Listen
+		}
+		listener, err = listen(cfg.Protocol, listenAddr)
+		if err != nil {
+			return fmt.Errorf("创建新的监听器失败: %w", err)
+		}
+		if f.firewall != nil {
+			if err := f.firewall.Allow(firewallRule(cfg)); err != nil {
+				logger.Warn("添加应用 %s 的防火墙规则失败: %v", cfg.Name, err)
+			}
+		}
+	}
+
+	// 新接受的连接按新规则转发
+	f.sessionMu.Lock()
+	f.config = cfg
+	f.listener = listener
+	draining := f.generation
+	f.generation++
+	f.sessionMu.Unlock()
+
+	if listener != oldListener {
+		f.wg.Add(1)
+		go f.acceptLoop(listener)
+
+		oldListener.Close()
+		if f.firewall != nil {
+			if err := f.firewall.Remove(firewallRule(old)); err != nil {
+				logger.Warn("移除应用 %s 的防火墙规则失败: %v", old.Name, err)
+			}
+		}
+	}
+
+	f.drain(cfg, draining, drainTimeout)
+	logger.Info("转发器已更新: %s -> %s:%d", listenAddr, cfg.DstHost, cfg.DstPort)
+	return nil
+}
+
+// drain 排空超时后关闭规则代数不超过 draining、仍按旧规则转发的连接
+func (f *Forwarder) drain(cfg *config.AppConfig, draining int, drainTimeout time.Duration) {
+	closeDrained := func() {
+		if n := f.closeSessions(func(generation int) bool { return generation <= draining }); n > 0 {
+			logger.Info("转发器 %s 排空超时，关闭 %d 个旧连接", cfg.Name, n)
+		}
+	}
+	if drainTimeout <= 0 {
+		closeDrained()
+	} else {
+		time.AfterFunc(drainTimeout, closeDrained)
+	}
+}
+
+// Draining 获取按旧规则转发、尚未结束的连接数
+func (f *Forwarder) Draining() int {
+	f.sessionMu.Lock()
+	defer f.sessionMu.Unlock()
+
+	count := 0
+	for _, generation := range f.sessions {
+		if generation < f.generation {
+			count++
+		}
+	}
+	return count
+}
+
+// track 记录新接受的连接，返回转发使用的规则。转发器已停止时返回 nil
+func (f *Forwarder) track(conn net.Conn) *config.AppConfig {
+	f.sessionMu.Lock()
+	defer f.sessionMu.Unlock()
+
+	select {
+	case <-f.stopCh:
+		return nil
+	default:
+	}
+	f.sessions[conn] = f.generation
+	return f.config
+}
+
+// currentConfig 获取新连接使用的规则
+func (f *Forwarder) currentConfig() *config.AppConfig {
+	f.sessionMu.Lock()
+	defer f.sessionMu.Unlock()
+	return f.config
+}
+
+// Config 获取转发器当前规则的副本
+func (f *Forwarder) Config() config.AppConfig {
+	return *f.currentConfig()
+}
+
+// untrack 移除已结束的连接
+func (f *Forwarder) untrack(conn net.Conn) {
+	f.sessionMu.Lock()
+	defer f.sessionMu.Unlock()
+	delete(f.sessions, conn)
+}
+
+// closeSessions 关闭规则代数满足条件的连接，返回关闭的连接数
+func (f *Forwarder) closeSessions(match func(generation int) bool) int {
+	f.sessionMu.Lock()
+	defer f.sessionMu.Unlock()
+
+	count := 0
+	for conn, generation := range f.sessions {
+		if match(generation) {
+			conn.Close()
+			count++
+		}
+	}
+	return count
+}
+
+// listenAddress 应用的监听地址。未设置 Bind 时监听所有地址，Bind 为网卡名称时使用网卡当前的地址，
+// 每次启动时重新解析，网卡地址变化后重启转发器即可
+func listenAddress(cfg *config.AppConfig) (string, error) {
+	host := ""
+	if cfg.Bind != "" {
+		ip, err := transport.ResolveBind(cfg.Bind)
+		if err != nil {
+			return "", fmt.Errorf("解析应用 %s 的监听地址失败: %w", cfg.Name, err)
+		}
+		host = ip.String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(cfg.SrcPort)), nil
+}
+
+// replaced 检查监听器是否已被平滑替换
+func (f *Forwarder) replaced(listener net.Listener) bool {
+	f.sessionMu.Lock()
+	defer f.sessionMu.Unlock()
+	return f.listener != listener
+}
+
+// firewallRule 监听端口对应的防火墙规则
+func firewallRule(cfg *config.AppConfig) firewall.Rule {
+	return firewall.Rule{
+		Name:     cfg.Name,
+		Protocol: cfg.Protocol,
+		Port:     cfg.SrcPort,
+	}
+}
+
 // IsRunning 检查转发器是否正在运行
 func (f *Forwarder) IsRunning() bool {
 	f.mu.Lock()
@@ -118,7 +375,7 @@ func (f *Forwarder) GetStats() *Stats {
 }
 
 // acceptLoop 接受连接循环
-func (f *Forwarder) acceptLoop() {
+func (f *Forwarder) acceptLoop(listener net.Listener) {
 	defer f.wg.Done()
 
 	for {
@@ -127,28 +384,95 @@ func (f *Forwarder) acceptLoop() {
 			return
 		default:
 			// 接受连接
-			conn, err := f.listener.Accept()
+			conn, err := listener.Accept()
 			if err != nil {
 				select {
 				case <-f.stopCh:
 					return
 				default:
+				}
+				// 规则已平滑替换，旧的监听器被关闭
+				if f.replaced(listener) {
+					return
+				}
+				if ne, ok := err.(net.Error); ok && ne.Timeout() {
 					logger.Error("接受连接失败: %v", err)
 					time.Sleep(time.Second)
 					continue
 				}
+
+				// 监听器已不可用，交由调用方停止并重启
+				logger.Error("转发器 %s 的监听器异常退出: %v", f.config.Name, err)
+				if f.onExit != nil {
+					go f.onExit(err)
+				}
+				return
 			}
 
 			// 处理连接
+			cfg := f.track(conn)
+			if cfg == nil {
+				conn.Close()
+				return
+			}
+			if f.unexpected(cfg, conn.RemoteAddr()) {
+				f.untrack(conn)
+				conn.Close()
+				continue
+			}
+			// 每个连接的两个转发方向各使用一个缓冲区
+			release, ok := f.admit(cfg, 2*f.bufferSize)
+			if !ok {
+				f.untrack(conn)
+				conn.Close()
+				continue
+			}
 			f.wg.Add(1)
-			go f.handleConnection(conn)
+			go f.handleConnection(conn, cfg, release)
 		}
 	}
 }
 
-// handleConnection 处理连接
-func (f *Forwarder) handleConnection(clientConn net.Conn) {
+// unexpected 按应用的入站连接监控检查连接，返回是否应拒绝该连接
+func (f *Forwarder) unexpected(cfg *config.AppConfig, addr net.Addr) bool {
+	if cfg.Inbound == nil {
+		return false
+	}
+	f.mu.Lock()
+	monitor := f.inbound
+	f.mu.Unlock()
+	return monitor.Check(cfg.Name, cfg.Inbound, addr)
+}
+
+// admit 按资源预算检查是否接受新连接，buffer 为连接使用的缓冲区大小。
+// 接受时返回连接结束时调用的释放函数
+func (f *Forwarder) admit(cfg *config.AppConfig, buffer int) (func(), bool) {
+	b := f.budget.Load()
+	if b == nil {
+		return func() {}, true
+	}
+	release, err := b.Acquire(buffer)
+	if err != nil {
+		logger.Debug("应用 %s 拒绝新连接: %v", cfg.Name, err)
+		return nil, false
+	}
+	return release, true
+}
+
+// dialTarget 使用设置的拨号函数连接应用的目标地址
+func (f *Forwarder) dialTarget(network string, cfg *config.AppConfig) (net.Conn, error) {
+	var dial DialFunc
+	if p := f.dial.Load(); p != nil {
+		dial = *p
+	}
+	return dialTarget(dial, network, cfg)
+}
+
+// handleConnection 按接受连接时的规则处理连接，结束时释放连接占用的资源预算
+func (f *Forwarder) handleConnection(clientConn net.Conn, cfg *config.AppConfig, release func()) {
 	defer f.wg.Done()
+	defer release()
+	defer f.untrack(clientConn)
 	defer clientConn.Close()
 
 	// 更新统计信息
@@ -156,15 +480,16 @@ func (f *Forwarder) handleConnection(clientConn net.Conn) {
 	f.stats.Connections++
 	f.stats.LastActiveTime = time.Now()
 	f.stats.mu.Unlock()
+	started := stats.Start()
 
 	// 连接目标
-	targetAddr := fmt.Sprintf("%s:%d", f.config.DstHost, f.config.DstPort)
-	targetConn, err := net.Dial(f.config.Protocol, targetAddr)
+	targetConn, err := f.dialTarget(cfg.Protocol, cfg)
 	if err != nil {
 		logger.Error("连接目标失败: %v", err)
 		return
 	}
 	defer targetConn.Close()
+	dst := f.stats.connected(cfg.Protocol, cfg.DstHost, targetConn.RemoteAddr())
 
 	// 创建同步组
 	var wg sync.WaitGroup
@@ -176,13 +501,19 @@ func (f *Forwarder) handleConnection(clientConn net.Conn) {
 		n, err := f.copyData(targetConn, clientConn)
 		if err != nil && err != io.EOF {
 			logger.Error("转发数据失败 (客户端 -> 目标): %v", err)
+			// 客户端连接已关闭（如排空超时），同时关闭目标连接以结束另一方向的转发
+			targetConn.Close()
+		} else if cw, ok := targetConn.(interface{ CloseWrite() error }); ok {
+			// 客户端不再发送数据，通知目标以便其结束响应
+			cw.CloseWrite()
 		}
 
 		// 更新统计信息
 		f.stats.mu.Lock()
-		f.stats.BytesSent += uint64(n)
+		f.stats.BytesSent = stats.Add(f.stats.BytesSent, uint64(n))
 		f.stats.LastActiveTime = time.Now()
 		f.stats.mu.Unlock()
+		f.stats.transferred(dst, uint64(n), 0)
 	}()
 
 	// 目标 -> 客户端
@@ -195,17 +526,18 @@ func (f *Forwarder) handleConnection(clientConn net.Conn) {
 
 		// 更新统计信息
 		f.stats.mu.Lock()
-		f.stats.BytesReceived += uint64(n)
+		f.stats.BytesReceived = stats.Add(f.stats.BytesReceived, uint64(n))
 		f.stats.LastActiveTime = time.Now()
 		f.stats.mu.Unlock()
+		f.stats.transferred(dst, 0, uint64(n))
 	}()
 
 	// 等待两个方向的数据传输完成
 	wg.Wait()
 
-	// 更新连接时间
+	// 更新连接时间，按单调时钟计算连接建立以来的时长
 	f.stats.mu.Lock()
-	f.stats.ConnectionTime += uint64(time.Since(f.stats.LastActiveTime).Seconds())
+	f.stats.ConnectionTime = stats.Add(f.stats.ConnectionTime, stats.Seconds(started.Elapsed()))
 	f.stats.mu.Unlock()
 }
 
@@ -239,16 +571,145 @@ func (f *Forwarder) copyData(dst io.Writer, src io.Reader) (int64, error) {
 // ForwarderManager 转发器管理器
 type ForwarderManager struct {
 	forwarders map[string]*Forwarder
+	state      *StateStore
+	listenerFn ListenerProvider
+	health     *health.Monitor
+	policy     RestartPolicy
+	restarts   map[string]*restartState
+	presence   PeerPresence
+	waiting    map[string]bool // 等待对端节点上线后启动的应用
+	listen     ListenFunc
+	firewall   *firewall.Firewall
+	inbound    *inbound.Monitor
+	budget     *budget.Budget
+	dial       DialFunc
+	reconciled bool          // 已恢复过一次，再次同步配置时不再报告异常退出
+	drain      time.Duration // 平滑更新规则时旧连接的排空时间
 	mu         sync.Mutex
 }
 
+// ListenerProvider 根据应用名称和端口提供已打开的监听器，没有时返回 nil
+type ListenerProvider func(name string, port int) net.Listener
+
+// ListenFunc 创建监听器，与 net.Listen 相同
+type ListenFunc func(network, address string) (net.Listener, error)
+
 // NewForwarderManager 创建转发器管理器
 func NewForwarderManager() *ForwarderManager {
 	return &ForwarderManager{
 		forwarders: make(map[string]*Forwarder),
+		policy:     DefaultRestartPolicy(),
+		restarts:   make(map[string]*restartState),
+		waiting:    make(map[string]bool),
+		drain:      30 * time.Second,
+	}
+}
+
+// SetStateStore 设置运行时状态存储，设置后手动启停应用会被持久化
+func (m *ForwarderManager) SetStateStore(store *StateStore) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = store
+}
+
+// SetListenerProvider 设置监听器来源，新建转发器时优先使用其提供的监听器
+func (m *ForwarderManager) SetListenerProvider(provider ListenerProvider) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listenerFn = provider
+}
+
+// SetListenFunc 设置转发器创建监听器的方式，如由特权辅助进程绑定低端口
+func (m *ForwarderManager) SetListenFunc(listen ListenFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listen = listen
+	for _, forwarder := range m.forwarders {
+		forwarder.mu.Lock()
+		forwarder.listen = listen
+		forwarder.mu.Unlock()
+	}
+}
+
+// SetFirewall 设置防火墙规则管理，设置后转发器监听期间放行对应端口的入站连接
+func (m *ForwarderManager) SetFirewall(fw *firewall.Firewall) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.firewall = fw
+	for _, forwarder := range m.forwarders {
+		forwarder.mu.Lock()
+		forwarder.firewall = fw
+		forwarder.mu.Unlock()
+	}
+}
+
+// SetInboundMonitor 设置入站连接监控，设置后按应用的配置检查连接来源和时间
+func (m *ForwarderManager) SetInboundMonitor(monitor *inbound.Monitor) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inbound = monitor
+	for _, forwarder := range m.forwarders {
+		forwarder.mu.Lock()
+		forwarder.inbound = monitor
+		forwarder.mu.Unlock()
 	}
 }
 
+// SetBudget 设置资源预算，设置后所有应用的新连接共用预算，超过时被拒绝
+func (m *ForwarderManager) SetBudget(b *budget.Budget) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.budget = b
+	for _, forwarder := range m.forwarders {
+		forwarder.budget.Store(b)
+	}
+}
+
+// SetDialFunc 设置转发器连接目标的方式，例如经出口节点转发，为 nil 时直接连接
+func (m *ForwarderManager) SetDialFunc(dial DialFunc) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dial = dial
+	for _, forwarder := range m.forwarders {
+		forwarder.setDialFunc(dial)
+	}
+}
+
+// setDialFunc 设置连接目标的拨号函数
+func (f *Forwarder) setDialFunc(dial DialFunc) {
+	if dial == nil {
+		f.dial.Store(nil)
+		return
+	}
+	f.dial.Store(&dial)
+}
+
+// SetDrainTimeout 设置平滑更新规则时已建立的连接按旧规则继续转发的最长时间
+func (m *ForwarderManager) SetDrainTimeout(timeout time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.drain = timeout
+}
+
+// newForwarder 创建转发器并关联预先打开的监听器。调用方需持有锁
+func (m *ForwarderManager) newForwarder(cfg *config.AppConfig, bufferSize int) *Forwarder {
+	forwarder := NewForwarder(cfg, bufferSize)
+	forwarder.listen = m.listen
+	forwarder.firewall = m.firewall
+	forwarder.inbound = m.inbound
+	forwarder.budget.Store(m.budget)
+	forwarder.setDialFunc(m.dial)
+	forwarder.onExit = func(err error) {
+		m.listenerFailed(cfg.Name, forwarder, err)
+	}
+	if m.listenerFn != nil {
+		if listener := m.listenerFn(cfg.Name, cfg.SrcPort); listener != nil {
+			forwarder.SetListener(listener)
+		}
+	}
+	return forwarder
+}
+
 // AddForwarder 添加转发器
 func (m *ForwarderManager) AddForwarder(cfg *config.AppConfig, bufferSize int) (*Forwarder, error) {
 	m.mu.Lock()
@@ -260,15 +721,22 @@ func (m *ForwarderManager) AddForwarder(cfg *config.AppConfig, bufferSize int) (
 	}
 
 	// 创建转发器
-	forwarder := NewForwarder(cfg, bufferSize)
+	forwarder := m.newForwarder(cfg, bufferSize)
 	m.forwarders[cfg.Name] = forwarder
 
-	// 如果配置为自动启动，则启动转发器
-	if cfg.AutoStart {
+	// 如果配置为自动启动，则在依赖的应用都运行后启动转发器
+	if cfg.AutoStart && m.waitingForPeer(cfg) {
+		m.deferStart(cfg)
+	} else if cfg.AutoStart {
+		if dep := m.unstartedDependency(cfg); dep != "" {
+			delete(m.forwarders, cfg.Name)
+			return nil, fmt.Errorf("启动转发器失败: %w", dependencyError(dep))
+		}
 		if err := forwarder.Start(); err != nil {
 			delete(m.forwarders, cfg.Name)
 			return nil, fmt.Errorf("启动转发器失败: %w", err)
 		}
+		m.watchHealth(cfg)
 	}
 
 	return forwarder, nil
@@ -301,12 +769,248 @@ func (m *ForwarderManager) RemoveForwarder(name string) error {
 	if err := forwarder.Stop(); err != nil {
 		return fmt.Errorf("停止转发器失败: %w", err)
 	}
+	m.unwatchHealth(name)
+	m.cancelRestart(name)
 
 	// 移除转发器
 	delete(m.forwarders, name)
 	return nil
 }
 
+// UpdateForwarder 平滑更新应用的监听地址、端口、目标地址或描述：新端口监听成功后才关闭旧端口，
+// 已建立的连接按旧规则排空，统计信息保留。其他配置变化需要停止后重新创建转发器
+func (m *ForwarderManager) UpdateForwarder(cfg *config.AppConfig) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	forwarder, exists := m.forwarders[cfg.Name]
+	if !exists {
+		return fmt.Errorf("转发器不存在: %s", cfg.Name)
+	}
+	if !liveUpdatable(*forwarder.config, *cfg) {
+		return fmt.Errorf("只能平滑更新监听地址、端口、目标地址和描述: %s", cfg.Name)
+	}
+	return m.updateForwarder(forwarder, cfg)
+}
+
+// updateForwarder 平滑替换转发器的规则，并按新的目标地址进行健康检查。调用方需持有锁
+func (m *ForwarderManager) updateForwarder(forwarder *Forwarder, cfg *config.AppConfig) error {
+	if err := forwarder.Replace(cfg, m.drain); err != nil {
+		return fmt.Errorf("更新转发器失败: %w", err)
+	}
+	if forwarder.IsRunning() {
+		m.unwatchHealth(cfg.Name)
+		m.watchHealth(cfg)
+	}
+	return nil
+}
+
+// liveUpdatable 检查两个应用配置是否只有监听地址、端口、目标地址、描述和入站连接监控不同
+func liveUpdatable(old, updated config.AppConfig) bool {
+	old.SrcPort, old.DstHost, old.DstPort, old.Description = updated.SrcPort, updated.DstHost, updated.DstPort, updated.Description
+	old.Bind, old.Inbound = updated.Bind, updated.Inbound
+	return reflect.DeepEqual(old, updated)
+}
+
+// StartForwarder 手动启动转发器，并记录运行状态
+func (m *ForwarderManager) StartForwarder(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	forwarder, exists := m.forwarders[name]
+	if !exists {
+		return fmt.Errorf("转发器不存在: %s", name)
+	}
+
+	if !forwarder.IsRunning() && m.waitingForPeer(forwarder.config) {
+		m.deferStart(forwarder.config)
+	} else if !forwarder.IsRunning() {
+		if dep := m.unstartedDependency(forwarder.config); dep != "" {
+			return fmt.Errorf("启动转发器失败: %w", dependencyError(dep))
+		}
+		// 手动启动时放弃等待中的自动重启
+		m.cancelRestart(name)
+		if err := forwarder.Start(); err != nil {
+			return fmt.Errorf("启动转发器失败: %w", err)
+		}
+		m.watchHealth(forwarder.config)
+	}
+
+	m.saveAppState(name, true)
+	return nil
+}
+
+// StopForwarder 手动停止转发器，并记录运行状态
+func (m *ForwarderManager) StopForwarder(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	forwarder, exists := m.forwarders[name]
+	if !exists {
+		return fmt.Errorf("转发器不存在: %s", name)
+	}
+
+	if err := forwarder.Stop(); err != nil {
+		return fmt.Errorf("停止转发器失败: %w", err)
+	}
+	m.unwatchHealth(name)
+	m.cancelRestart(name)
+
+	m.saveAppState(name, false)
+	return nil
+}
+
+// saveAppState 持久化应用运行状态，失败时只记录日志。调用方需持有锁
+func (m *ForwarderManager) saveAppState(name string, running bool) {
+	if m.state == nil {
+		return
+	}
+	if err := m.state.SetApp(name, running); err != nil {
+		logger.Error("保存应用 %s 的运行状态失败: %v", name, err)
+	}
+}
+
+// Reconcile 根据服务端下发的应用配置和本地运行时状态恢复转发器。
+// 有本地记录的应用按上次的手动启停状态恢复，否则按 AutoStart 启动；
+// 应用按依赖关系依次启动，依赖的应用未运行时不启动；
+// 只有监听端口、目标地址或描述变化的应用平滑更新，其他配置变化的应用按新配置重新创建转发器，
+// 因此可以在运行期间重复调用以应用新下发的配置；
+// 服务端已不再下发的应用会被停止并丢弃本地状态。返回恢复过程中产生的事件
+func (m *ForwarderManager) Reconcile(apps []config.AppConfig, bufferSize int) []RecoveryEvent {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snapshot := newRuntimeState()
+	if m.state != nil {
+		snapshot = m.state.Snapshot()
+	}
+
+	now := time.Now()
+	var events []RecoveryEvent
+	if snapshot.Running && !m.reconciled {
+		events = append(events, RecoveryEvent{
+			Type:       EventCrashRecovered,
+			Detail:     fmt.Sprintf("上次状态更新于 %s", snapshot.UpdatedAt.Format(time.RFC3339)),
+			OccurredAt: now,
+		})
+	}
+
+	ordered, err := config.OrderApps(apps)
+	if err != nil {
+		logger.Error("应用依赖关系无效，按下发顺序启动: %v", err)
+	} else {
+		apps = ordered
+	}
+
+	declared := make(map[string]bool, len(apps))
+	for i := range apps {
+		app := apps[i]
+		declared[app.Name] = true
+
+		forwarder, exists := m.forwarders[app.Name]
+		if exists && !reflect.DeepEqual(*forwarder.config, app) && liveUpdatable(*forwarder.config, app) {
+			// 只有端口或目标地址变化时平滑更新，不中断已建立的连接
+			if err := m.updateForwarder(forwarder, &app); err != nil {
+				logger.Warn("平滑更新转发器 %s 失败，重新创建: %v", app.Name, err)
+			}
+		}
+		if exists && !reflect.DeepEqual(*forwarder.config, app) {
+			// 配置已变化，停止旧的转发器后按新配置重新创建
+			if err := forwarder.Stop(); err != nil {
+				logger.Error("停止转发器 %s 失败: %v", app.Name, err)
+			}
+			m.unwatchHealth(app.Name)
+			m.cancelRestart(app.Name)
+			exists = false
+		}
+		if !exists {
+			forwarder = m.newForwarder(&app, bufferSize)
+			m.forwarders[app.Name] = forwarder
+		}
+
+		running := app.AutoStart
+		recorded, hasState := snapshot.Apps[app.Name]
+		if hasState {
+			running = recorded.Running
+		}
+
+		if running && !forwarder.IsRunning() && m.waitingForPeer(&app) {
+			m.deferStart(&app)
+		} else if running && !forwarder.IsRunning() {
+			var err error
+			if dep := m.unstartedDependency(&app); dep != "" {
+				err = dependencyError(dep)
+			} else {
+				err = forwarder.Start()
+			}
+			if err != nil {
+				events = append(events, RecoveryEvent{
+					Type:       EventAppFailed,
+					App:        app.Name,
+					Detail:     err.Error(),
+					OccurredAt: now,
+				})
+				// 端口被占用或依赖的应用未运行时稍后自动重试
+				m.scheduleRestart(app.Name, err)
+				continue
+			}
+			m.watchHealth(&app)
+		} else if !running && (forwarder.IsRunning() || m.waiting[app.Name]) {
+			if err := forwarder.Stop(); err != nil {
+				logger.Error("停止转发器 %s 失败: %v", app.Name, err)
+			}
+			m.unwatchHealth(app.Name)
+			m.cancelRestart(app.Name)
+		}
+
+		if hasState && recorded.Running != app.AutoStart {
+			events = append(events, RecoveryEvent{
+				Type:       EventAppRestored,
+				App:        app.Name,
+				Detail:     fmt.Sprintf("running=%t", running),
+				OccurredAt: now,
+			})
+		}
+	}
+
+	// 停止服务端已不再下发的应用
+	for name, forwarder := range m.forwarders {
+		if declared[name] {
+			continue
+		}
+		if err := forwarder.Stop(); err != nil {
+			logger.Error("停止转发器 %s 失败: %v", name, err)
+		}
+		m.unwatchHealth(name)
+		m.cancelRestart(name)
+		delete(m.forwarders, name)
+	}
+
+	// 丢弃已不存在的应用的本地状态
+	for name := range snapshot.Apps {
+		if declared[name] {
+			continue
+		}
+		if err := m.state.RemoveApp(name); err != nil {
+			logger.Error("移除应用 %s 的运行状态失败: %v", name, err)
+		}
+		events = append(events, RecoveryEvent{
+			Type:       EventAppRemoved,
+			App:        name,
+			OccurredAt: now,
+		})
+	}
+
+	if m.state != nil {
+		if err := m.state.SetRunning(true); err != nil {
+			logger.Error("保存运行时状态失败: %v", err)
+		}
+	}
+	m.reconciled = true
+
+	return events
+}
+
 // GetAllForwarders 获取所有转发器
 func (m *ForwarderManager) GetAllForwarders() map[string]*Forwarder {
 	m.mu.Lock()
@@ -321,34 +1025,63 @@ func (m *ForwarderManager) GetAllForwarders() map[string]*Forwarder {
 	return result
 }
 
-// StartAll 启动所有转发器
+// StartAll 按依赖关系依次启动所有转发器
 func (m *ForwarderManager) StartAll() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	for name, forwarder := range m.forwarders {
-		if !forwarder.IsRunning() {
+	apps := m.orderedApps()
+	for i := range apps {
+		forwarder := m.forwarders[apps[i].Name]
+		if !forwarder.IsRunning() && m.waitingForPeer(forwarder.config) {
+			m.deferStart(forwarder.config)
+		} else if !forwarder.IsRunning() {
+			if dep := m.unstartedDependency(forwarder.config); dep != "" {
+				return fmt.Errorf("启动转发器 %s 失败: %w", apps[i].Name, dependencyError(dep))
+			}
 			if err := forwarder.Start(); err != nil {
-				return fmt.Errorf("启动转发器 %s 失败: %w", name, err)
+				return fmt.Errorf("启动转发器 %s 失败: %w", apps[i].Name, err)
 			}
+			m.watchHealth(forwarder.config)
 		}
 	}
 
 	return nil
 }
 
-// StopAll 停止所有转发器
+// StopAll 停止所有转发器，依赖其他应用的转发器先停止
 func (m *ForwarderManager) StopAll() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	for name, forwarder := range m.forwarders {
+	apps := m.orderedApps()
+	for i := len(apps) - 1; i >= 0; i-- {
+		forwarder := m.forwarders[apps[i].Name]
 		if forwarder.IsRunning() {
 			if err := forwarder.Stop(); err != nil {
-				return fmt.Errorf("停止转发器 %s 失败: %w", name, err)
+				return fmt.Errorf("停止转发器 %s 失败: %w", apps[i].Name, err)
 			}
 		}
+		m.unwatchHealth(apps[i].Name)
+		m.cancelRestart(apps[i].Name)
 	}
 
 	return nil
 }
+
+// orderedApps 按依赖关系排序所有转发器的应用配置。调用方需持有锁
+func (m *ForwarderManager) orderedApps() []config.AppConfig {
+	apps := make([]config.AppConfig, 0, len(m.forwarders))
+	for _, forwarder := range m.forwarders {
+		apps = append(apps, *forwarder.config)
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].Name < apps[j].Name })
+
+	// 依赖服务端未下发的应用时无法排序，此时按名称顺序处理
+	ordered, err := config.OrderApps(apps)
+	if err != nil {
+		logger.Error("应用依赖关系无效，按名称顺序处理: %v", err)
+		return apps
+	}
+	return ordered
+}
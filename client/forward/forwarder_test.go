@@ -0,0 +1,153 @@
+package forward
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+)
+
+// tcpEcho 启动 TCP 回显服务
+func tcpEcho(t *testing.T) net.Listener {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+// freeTCPPort 获取一个空闲的 TCP 端口
+func freeTCPPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+// echoOnce 经连接发送数据并读取回显
+func echoOnce(t *testing.T, conn net.Conn, payload string) {
+	t.Helper()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte(payload)); err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+	buf := make([]byte, len(payload))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != payload {
+		t.Fatalf("接收 %q, %v", buf, err)
+	}
+	conn.SetDeadline(time.Time{})
+}
+
+// waitFor 等待条件满足
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("等待%s超时", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUpdateForwarderDrain(t *testing.T) {
+	echo := tcpEcho(t)
+	drain := 500 * time.Millisecond
+	m := NewForwarderManager()
+	m.SetDrainTimeout(drain)
+
+	cfg := &config.AppConfig{
+		Name:      "web",
+		Protocol:  "tcp",
+		SrcPort:   freeTCPPort(t),
+		DstHost:   "127.0.0.1",
+		DstPort:   echo.Addr().(*net.TCPAddr).Port,
+		AutoStart: true,
+	}
+	f, err := m.AddForwarder(cfg, 0)
+	if err != nil {
+		t.Fatalf("创建转发器失败: %v", err)
+	}
+	defer m.RemoveForwarder(cfg.Name)
+
+	oldAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(cfg.SrcPort))
+	held, err := net.Dial("tcp", oldAddr)
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	defer held.Close()
+	echoOnce(t, held, "one")
+
+	// 只能平滑更新端口、地址和描述
+	changed := *cfg
+	changed.Protocol = "udp"
+	if err := m.UpdateForwarder(&changed); err == nil {
+		t.Fatal("协议变化时不应平滑更新")
+	}
+
+	// 更换监听端口：新端口立即可用，旧端口关闭，已建立的连接按旧规则继续转发
+	updated := *cfg
+	updated.SrcPort = freeTCPPort(t)
+	updatedAt := time.Now()
+	if err := m.UpdateForwarder(&updated); err != nil {
+		t.Fatalf("更新转发器失败: %v", err)
+	}
+	if got := f.Config().SrcPort; got != updated.SrcPort {
+		t.Fatalf("更新后的监听端口为 %d", got)
+	}
+	if conn, err := net.DialTimeout("tcp", oldAddr, time.Second); err == nil {
+		conn.Close()
+		t.Fatal("旧端口应已关闭")
+	}
+	fresh, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(updated.SrcPort)))
+	if err != nil {
+		t.Fatalf("连接新端口失败: %v", err)
+	}
+	defer fresh.Close()
+	echoOnce(t, fresh, "fresh")
+
+	echoOnce(t, held, "two")
+	if n := f.Draining(); n != 1 {
+		t.Fatalf("排空中的连接数为 %d", n)
+	}
+
+	// 排空超时后关闭旧连接，不早于排空时间
+	held.SetReadDeadline(time.Now().Add(3 * time.Second))
+	if _, err := held.Read(make([]byte, 1)); err == nil {
+		t.Fatal("排空超时后旧连接应被关闭")
+	}
+	if elapsed := time.Since(updatedAt); elapsed < drain {
+		t.Fatalf("旧连接在 %v 后被关闭，早于排空时间 %v", elapsed, drain)
+	}
+	waitFor(t, "旧连接结束", func() bool { return f.Draining() == 0 })
+	echoOnce(t, fresh, "still")
+
+	// 统计信息在更新前后累计，包含旧连接转发的数据
+	waitFor(t, "统计更新", func() bool { return f.GetStats().Snapshot(cfg.Name).BytesSent >= 6 })
+	stats := f.GetStats().Snapshot(cfg.Name)
+	if stats.Connections != 2 || stats.BytesSent != 6 || stats.BytesReceived != 6 {
+		t.Fatalf("统计信息为 %+v", stats)
+	}
+	fresh.Close()
+	waitFor(t, "新连接统计", func() bool { return f.GetStats().Snapshot(cfg.Name).BytesSent == 6+10 })
+}
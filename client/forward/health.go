@@ -0,0 +1,90 @@
+package forward
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/docker"
+	"github.com/senma231/p3/client/health"
+)
+
+// SetHealthMonitor 设置健康检查器，设置后应用启动时开始检查，停止时停止检查
+func (m *ForwarderManager) SetHealthMonitor(monitor *health.Monitor) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.health = monitor
+}
+
+// watchHealth 开始检查应用的健康状态。调用方需持有锁
+func (m *ForwarderManager) watchHealth(app *config.AppConfig) {
+	if m.health != nil {
+		m.health.Watch(app.Name, healthCheck(app), app.DependsOn)
+	}
+}
+
+// unwatchHealth 停止检查应用的健康状态。调用方需持有锁
+func (m *ForwarderManager) unwatchHealth(name string) {
+	if m.health != nil {
+		m.health.Unwatch(name)
+	}
+}
+
+// unstartedDependency 返回应用依赖的第一个未运行的应用，都在运行时返回空字符串。调用方需持有锁
+func (m *ForwarderManager) unstartedDependency(app *config.AppConfig) string {
+	for _, dep := range app.DependsOn {
+		forwarder, exists := m.forwarders[dep]
+		if !exists || !forwarder.IsRunning() {
+			return dep
+		}
+	}
+	return ""
+}
+
+// Unready 配置为自动启动但尚未运行的应用，包括等待对端上线、等待依赖的应用和正在重启的应用，
+// 以及健康检查失败的应用。返回空列表表示所有应用的隧道都已建立
+func (m *ForwarderManager) Unready() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var unready []string
+	for _, app := range m.orderedApps() {
+		if !app.AutoStart {
+			continue
+		}
+		forwarder := m.forwarders[app.Name]
+		if !forwarder.IsRunning() || (m.health != nil && m.health.Status(app.Name) == health.StatusFailed) {
+			unready = append(unready, app.Name)
+		}
+	}
+	return unready
+}
+
+// healthCheck 根据应用配置生成健康检查，未配置时使用默认值
+func healthCheck(app *config.AppConfig) health.Check {
+	cfg := app.HealthCheck.WithDefaults()
+	check := health.Check{
+		Network:  app.Protocol,
+		Address:  net.JoinHostPort(app.DstHost, strconv.Itoa(app.DstPort)),
+		HTTP:     cfg.HTTP,
+		Command:  cfg.Command,
+		Interval: time.Duration(cfg.Interval) * time.Second,
+		Timeout:  time.Duration(cfg.Timeout) * time.Second,
+		Retries:  cfg.Retries,
+	}
+	// Docker 容器重启后地址可能变化，每次检查前重新解析
+	if docker.IsTarget(app.DstHost) {
+		check.Resolve = func(ctx context.Context) (string, error) {
+			return targetAddress(ctx, app)
+		}
+	}
+	return check
+}
+
+// dependencyError 依赖的应用未运行时的错误
+func dependencyError(dep string) error {
+	return fmt.Errorf("依赖的应用 %s 未运行", dep)
+}
@@ -0,0 +1,143 @@
+package forward
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/common/logger"
+)
+
+// 生命周期钩子的事件，执行时通过 P3_EVENT 传给命令
+const (
+	HookStart       = "start"
+	HookStop        = "stop"
+	HookPeerConnect = "peer-connect"
+)
+
+// maxHookOutput 写入日志的钩子输出的最大长度
+const maxHookOutput = 4096
+
+// hookQueue 按顺序执行同一应用的钩子，保证停止钩子在启动钩子结束后执行
+type hookQueue struct {
+	mu      sync.Mutex
+	pending []func()
+	running bool
+}
+
+// push 加入待执行的钩子，没有正在执行的钩子时启动执行协程
+func (q *hookQueue) push(fn func()) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.pending = append(q.pending, fn)
+	if !q.running {
+		q.running = true
+		go q.drain()
+	}
+}
+
+// drain 依次执行待执行的钩子，执行完后退出
+func (q *hookQueue) drain() {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		fn := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		fn()
+	}
+}
+
+// runHook 异步执行应用在事件 event 上配置的钩子，未配置时不做处理
+func (f *Forwarder) runHook(cfg *config.AppConfig, event string) {
+	hooks := cfg.Hooks
+	if hooks == nil {
+		return
+	}
+	var command string
+	switch event {
+	case HookStart:
+		command = hooks.OnStart
+	case HookStop:
+		command = hooks.OnStop
+	case HookPeerConnect:
+		command = hooks.OnPeerConnect
+	}
+	if strings.TrimSpace(command) == "" {
+		return
+	}
+
+	env := hookEnv(cfg, event)
+	f.hooks.push(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), hooks.TimeoutDuration())
+		defer cancel()
+
+		output, err := execHook(ctx, command, env)
+		for _, line := range output {
+			logger.Info("应用 %s 的 %s 钩子: %s", cfg.Name, event, line)
+		}
+		switch {
+		case errors.Is(ctx.Err(), context.DeadlineExceeded):
+			logger.Warn("应用 %s 的 %s 钩子超时 (%s)，已终止", cfg.Name, event, hooks.TimeoutDuration())
+		case err != nil:
+			logger.Warn("应用 %s 的 %s 钩子执行失败: %v", cfg.Name, event, err)
+		default:
+			logger.Debug("应用 %s 的 %s 钩子执行完成", cfg.Name, event)
+		}
+	})
+}
+
+// hookEnv 钩子命令的环境变量：当前进程的环境变量、应用信息以及钩子配置中的变量
+func hookEnv(cfg *config.AppConfig, event string) []string {
+	env := append(os.Environ(),
+		"P3_APP="+cfg.Name,
+		"P3_EVENT="+event,
+		"P3_PEER="+cfg.PeerNode,
+		"P3_PROTOCOL="+cfg.Protocol,
+		"P3_PORT="+strconv.Itoa(cfg.SrcPort),
+		"P3_BIND="+cfg.Bind,
+		"P3_DST_HOST="+cfg.DstHost,
+		"P3_DST_PORT="+strconv.Itoa(cfg.DstPort),
+	)
+	for name, value := range cfg.Hooks.Env {
+		env = append(env, name+"="+value)
+	}
+	return env
+}
+
+// execHook 执行钩子命令，返回按行拆分的输出。命令按空格拆分，不经过 shell
+func execHook(ctx context.Context, command string, env []string) ([]string, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("钩子命令为空")
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if len(out) > maxHookOutput {
+		out = append(out[:maxHookOutput], "..."...)
+	}
+
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, err
+}
@@ -0,0 +1,84 @@
+package forward
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+)
+
+// hookScript 写入记录钩子事件的脚本，返回脚本和记录文件的路径
+func hookScript(t *testing.T) (string, string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("钩子测试使用 shell 脚本")
+	}
+	dir := t.TempDir()
+	record := filepath.Join(dir, "events")
+	script := filepath.Join(dir, "hook.sh")
+	content := "#!/bin/sh\necho \"$P3_EVENT $P3_APP $P3_PEER $P3_PORT $SHARE\" >> " + record + "\necho done\n"
+	if err := os.WriteFile(script, []byte(content), 0755); err != nil {
+		t.Fatalf("写入脚本失败: %v", err)
+	}
+	return script, record
+}
+
+func TestExecHook(t *testing.T) {
+	script, record := hookScript(t)
+	cfg := &config.AppConfig{Name: "nas", PeerNode: "office", SrcPort: 1445, Hooks: &config.HooksConfig{Env: map[string]string{"SHARE": "/mnt/nas"}}}
+
+	lines, err := execHook(context.Background(), script, hookEnv(cfg, HookStart))
+	if err != nil || len(lines) != 1 || lines[0] != "done" {
+		t.Fatalf("执行结果为 %v: %v", lines, err)
+	}
+	data, _ := os.ReadFile(record)
+	if got := strings.TrimSpace(string(data)); got != "start nas office 1445 /mnt/nas" {
+		t.Fatalf("钩子收到的环境变量为 %q", got)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, err := execHook(ctx, "sleep 5", nil); err == nil {
+		t.Fatalf("超时的命令应返回错误")
+	}
+}
+
+func TestForwarderHooks(t *testing.T) {
+	script, record := hookScript(t)
+	cfg := &config.AppConfig{
+		Name:     "nas",
+		Protocol: "udp",
+		SrcPort:  freeUDPPort(t),
+		PeerNode: "office",
+		DstHost:  "127.0.0.1",
+		DstPort:  445,
+		Hooks:    &config.HooksConfig{OnStart: script, OnStop: script},
+	}
+	f := NewForwarder(cfg, 0)
+	if err := f.Start(); err != nil {
+		t.Fatalf("启动失败: %v", err)
+	}
+	f.Stop()
+
+	// 停止钩子在启动钩子结束后执行
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(record)
+		events := strings.Fields(strings.ReplaceAll(string(data), "\n", " "))
+		if len(events) >= 8 {
+			if events[0] != HookStart || events[4] != HookStop {
+				t.Fatalf("钩子执行顺序为 %q", data)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("钩子未执行，记录为 %q", data)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
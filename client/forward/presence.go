@@ -0,0 +1,96 @@
+package forward
+
+import (
+	"fmt"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/health"
+	"github.com/senma231/p3/common/logger"
+)
+
+// PeerPresence 查询对端节点是否在线，尚未收到状态时 known 为 false
+type PeerPresence func(nodeID string) (online, known bool)
+
+// SetPeerPresence 设置对端节点在线状态的来源。设置后配置了 startWhenPeerOnline 的应用
+// 只在对端在线时启动，状态变化时需调用 PeerPresenceChanged
+func (m *ForwarderManager) SetPeerPresence(presence PeerPresence) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.presence = presence
+}
+
+// PeerPresenceChanged 对端节点上线时启动等待中的应用并执行应用的对端上线钩子，离线时停止配置为对端在线时才启动的应用
+func (m *ForwarderManager) PeerPresenceChanged(nodeID string, online bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	apps := m.orderedApps()
+	if !online {
+		// 依赖其他应用的应用先停止
+		for i, j := 0, len(apps)-1; i < j; i, j = i+1, j-1 {
+			apps[i], apps[j] = apps[j], apps[i]
+		}
+	}
+
+	for i := range apps {
+		app := &apps[i]
+		if app.PeerNode != nodeID || !app.StartWhenPeerOnline {
+			continue
+		}
+		forwarder := m.forwarders[app.Name]
+
+		if !online {
+			if !forwarder.IsRunning() && m.restarts[app.Name] == nil {
+				continue
+			}
+			if err := forwarder.Stop(); err != nil {
+				logger.Error("停止转发器 %s 失败: %v", app.Name, err)
+			}
+			logger.Info("对端节点 %s 已离线，停止应用 %s", nodeID, app.Name)
+			m.deferStart(forwarder.config)
+			continue
+		}
+
+		if !m.waiting[app.Name] {
+			continue
+		}
+		delete(m.waiting, app.Name)
+		if dep := m.unstartedDependency(forwarder.config); dep != "" {
+			m.scheduleRestart(app.Name, dependencyError(dep))
+			continue
+		}
+		if err := forwarder.Start(); err != nil {
+			m.scheduleRestart(app.Name, err)
+			continue
+		}
+		logger.Info("对端节点 %s 已上线，启动应用 %s", nodeID, app.Name)
+		m.watchHealth(forwarder.config)
+	}
+
+	// 对端上线时执行运行中的应用的钩子
+	if online {
+		for i := range apps {
+			if forwarder := m.forwarders[apps[i].Name]; apps[i].PeerNode == nodeID && forwarder.IsRunning() {
+				forwarder.runHook(forwarder.config, HookPeerConnect)
+			}
+		}
+	}
+}
+
+// waitingForPeer 应用配置为对端在线时才启动，且对端不在线时返回 true。调用方需持有锁
+func (m *ForwarderManager) waitingForPeer(app *config.AppConfig) bool {
+	if !app.StartWhenPeerOnline || m.presence == nil {
+		return false
+	}
+	online, _ := m.presence(app.PeerNode)
+	return !online
+}
+
+// deferStart 推迟启动应用直到对端节点上线，并将应用状态上报为等待中。调用方需持有锁
+func (m *ForwarderManager) deferStart(app *config.AppConfig) {
+	m.cancelRestart(app.Name)
+	m.waiting[app.Name] = true
+	if m.health != nil {
+		m.health.Report(app.Name, health.StatusWaiting, fmt.Sprintf("等待对端节点 %s 上线", app.PeerNode))
+	}
+}
@@ -0,0 +1,81 @@
+package forward
+
+import (
+	"sort"
+
+	"github.com/senma231/p3/client/config"
+)
+
+// ForwardRule 端口转发规则，用于导出和导入应用的转发配置，规则 ID 即应用名称
+type ForwardRule struct {
+	ID          string
+	Protocol    string // tcp, udp
+	SrcPort     int
+	PeerNode    string
+	DstHost     string
+	DstPort     int
+	Description string
+	Enabled     bool
+	Bind        string `json:",omitempty"` // 监听地址，IP 地址或网卡名称，为空时监听所有地址
+}
+
+// NewForwardRule 根据应用配置创建转发规则
+func NewForwardRule(app *config.AppConfig, enabled bool) *ForwardRule {
+	return &ForwardRule{
+		ID:          app.Name,
+		Protocol:    app.Protocol,
+		SrcPort:     app.SrcPort,
+		PeerNode:    app.PeerNode,
+		DstHost:     app.DstHost,
+		DstPort:     app.DstPort,
+		Description: app.Description,
+		Enabled:     enabled,
+		Bind:        app.Bind,
+	}
+}
+
+// AppConfig 转换为应用配置，启用的规则自动启动
+func (r *ForwardRule) AppConfig() config.AppConfig {
+	var app config.AppConfig
+	r.ApplyTo(&app)
+	return app
+}
+
+// ApplyTo 将规则写入应用配置，只修改规则包含的字段，依赖、健康检查等其他设置保留
+func (r *ForwardRule) ApplyTo(app *config.AppConfig) {
+	protocol := r.Protocol
+	if protocol == "" {
+		protocol = "tcp"
+	}
+	app.Name = r.ID
+	app.Protocol = protocol
+	app.SrcPort = r.SrcPort
+	app.PeerNode = r.PeerNode
+	app.DstHost = r.DstHost
+	app.DstPort = r.DstPort
+	app.Description = r.Description
+	app.AutoStart = r.Enabled
+	app.Bind = r.Bind
+}
+
+// RulesFromApps 根据应用配置创建转发规则，按 AutoStart 设置是否启用
+func RulesFromApps(apps []config.AppConfig) map[string]*ForwardRule {
+	rules := make(map[string]*ForwardRule, len(apps))
+	for i := range apps {
+		rules[apps[i].Name] = NewForwardRule(&apps[i], apps[i].AutoStart)
+	}
+	return rules
+}
+
+// SortedRules 按规则 ID 排序转发规则
+func SortedRules(rules map[string]*ForwardRule) []*ForwardRule {
+	sorted := make([]*ForwardRule, 0, len(rules))
+	for id, rule := range rules {
+		if rule.ID == "" {
+			rule.ID = id
+		}
+		sorted = append(sorted, rule)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	return sorted
+}
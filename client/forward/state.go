@@ -0,0 +1,168 @@
+package forward
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 恢复事件类型
+const (
+	// EventCrashRecovered 上次未正常退出，已从状态文件恢复
+	EventCrashRecovered = "crash-recovered"
+	// EventAppRestored 应用按上次的手动启停状态恢复
+	EventAppRestored = "app-restored"
+	// EventAppRemoved 服务端已不再下发该应用，丢弃其本地状态
+	EventAppRemoved = "app-removed"
+	// EventAppFailed 恢复应用失败
+	EventAppFailed = "app-failed"
+)
+
+// AppState 应用运行状态
+type AppState struct {
+	Running   bool      `json:"running"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// RuntimeState 客户端运行时状态，记录手动启停的应用，用于崩溃后恢复
+type RuntimeState struct {
+	Apps map[string]AppState `json:"apps"`
+	// 旧版按规则 ID 记录的暂停状态，加载时转换为应用的停止状态
+	PausedRules map[string]bool `json:"pausedRules,omitempty"`
+	// 客户端运行期间为 true，正常退出时置为 false；启动时为 true 说明上次异常退出
+	Running   bool      `json:"running"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// RecoveryEvent 恢复事件
+type RecoveryEvent struct {
+	Type       string    `json:"type"`
+	App        string    `json:"app,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// StateStore 运行时状态存储
+type StateStore struct {
+	filePath string
+	state    RuntimeState
+	mu       sync.Mutex
+}
+
+// NewStateStore 创建运行时状态存储
+func NewStateStore(filePath string) *StateStore {
+	return &StateStore{
+		filePath: filePath,
+		state:    newRuntimeState(),
+	}
+}
+
+// newRuntimeState 创建空的运行时状态
+func newRuntimeState() RuntimeState {
+	return RuntimeState{
+		Apps: make(map[string]AppState),
+	}
+}
+
+// Load 从状态文件加载运行时状态，文件不存在时使用空状态
+func (s *StateStore) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.filePath)
+	if os.IsNotExist(err) {
+		s.state = newRuntimeState()
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取状态文件失败: %w", err)
+	}
+
+	state := newRuntimeState()
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("解析状态文件失败: %w", err)
+	}
+	if state.Apps == nil {
+		state.Apps = make(map[string]AppState)
+	}
+	// 规则 ID 即应用名称，没有应用记录时按暂停状态停止
+	for id, paused := range state.PausedRules {
+		if _, ok := state.Apps[id]; !ok && paused {
+			state.Apps[id] = AppState{Running: false, UpdatedAt: state.UpdatedAt}
+		}
+	}
+	state.PausedRules = nil
+	s.state = state
+	return nil
+}
+
+// Snapshot 获取运行时状态的副本
+func (s *StateStore) Snapshot() RuntimeState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := newRuntimeState()
+	for name, app := range s.state.Apps {
+		snapshot.Apps[name] = app
+	}
+	snapshot.Running = s.state.Running
+	snapshot.UpdatedAt = s.state.UpdatedAt
+	return snapshot
+}
+
+// SetApp 记录应用的运行状态
+func (s *StateStore) SetApp(name string, running bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state.Apps[name] = AppState{Running: running, UpdatedAt: time.Now()}
+	return s.save()
+}
+
+// RemoveApp 移除应用的运行状态
+func (s *StateStore) RemoveApp(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.state.Apps[name]; !ok {
+		return nil
+	}
+	delete(s.state.Apps, name)
+	return s.save()
+}
+
+// SetRunning 记录客户端是否正在运行，正常退出前应置为 false
+func (s *StateStore) SetRunning(running bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state.Running = running
+	return s.save()
+}
+
+// save 保存运行时状态，先写临时文件再重命名，避免崩溃时留下不完整的文件。调用方需持有锁
+func (s *StateStore) save() error {
+	s.state.UpdatedAt = time.Now()
+
+	dir := filepath.Dir(s.filePath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化状态失败: %w", err)
+	}
+
+	tmpPath := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("写入状态文件失败: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		return fmt.Errorf("替换状态文件失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package forward
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/senma231/p3/client/config"
+)
+
+func TestStateStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "state.json")
+
+	// 状态文件不存在时使用空状态
+	s := NewStateStore(path)
+	if err := s.Load(); err != nil {
+		t.Fatalf("状态文件不存在时不应失败: %v", err)
+	}
+	if snapshot := s.Snapshot(); len(snapshot.Apps) != 0 || snapshot.Running {
+		t.Fatalf("应为空状态: %+v", snapshot)
+	}
+
+	if err := s.SetApp("web", false); err != nil {
+		t.Fatalf("保存应用状态失败: %v", err)
+	}
+	if err := s.SetApp("ssh", true); err != nil {
+		t.Fatalf("保存应用状态失败: %v", err)
+	}
+	if err := s.SetRunning(true); err != nil {
+		t.Fatalf("保存运行状态失败: %v", err)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Fatalf("保存后不应留下临时文件: %v", err)
+	}
+
+	loaded := NewStateStore(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("加载状态失败: %v", err)
+	}
+	snapshot := loaded.Snapshot()
+	if !snapshot.Running || len(snapshot.Apps) != 2 || snapshot.Apps["web"].Running || !snapshot.Apps["ssh"].Running {
+		t.Fatalf("加载的状态不正确: %+v", snapshot)
+	}
+	if snapshot.UpdatedAt.IsZero() || snapshot.Apps["ssh"].UpdatedAt.IsZero() {
+		t.Fatalf("应记录更新时间: %+v", snapshot)
+	}
+
+	// 快照是副本，修改不影响存储
+	snapshot.Apps["web"] = AppState{Running: true}
+	if loaded.Snapshot().Apps["web"].Running {
+		t.Fatal("修改快照不应影响存储的状态")
+	}
+
+	if err := loaded.RemoveApp("ssh"); err != nil {
+		t.Fatalf("移除应用状态失败: %v", err)
+	}
+	if err := loaded.RemoveApp("missing"); err != nil {
+		t.Fatalf("移除不存在的应用不应失败: %v", err)
+	}
+	if err := s.Load(); err != nil {
+		t.Fatalf("加载状态失败: %v", err)
+	}
+	if apps := s.Snapshot().Apps; len(apps) != 1 || apps["web"].Running {
+		t.Fatalf("移除后的状态不正确: %+v", apps)
+	}
+}
+
+func TestStateStoreInvalidFile(t *testing.T) {
+	dir := t.TempDir()
+
+	// 文件损坏时返回错误并使用空状态，下次保存覆盖损坏的文件
+	path := filepath.Join(dir, "state.json")
+	if err := os.WriteFile(path, []byte(`{"apps": {"web": `), 0600); err != nil {
+		t.Fatalf("写入状态文件失败: %v", err)
+	}
+	s := NewStateStore(path)
+	if err := s.Load(); err == nil {
+		t.Fatal("状态文件损坏时应返回错误")
+	}
+	if snapshot := s.Snapshot(); len(snapshot.Apps) != 0 || snapshot.Running {
+		t.Fatalf("状态文件损坏时应使用空状态: %+v", snapshot)
+	}
+	if err := s.SetApp("web", true); err != nil {
+		t.Fatalf("保存应用状态失败: %v", err)
+	}
+	if err := NewStateStore(path).Load(); err != nil {
+		t.Fatalf("重新保存后应可以加载: %v", err)
+	}
+
+	// 状态文件路径是目录时无法读取
+	if err := NewStateStore(dir).Load(); err == nil {
+		t.Fatal("无法读取状态文件时应返回错误")
+	}
+
+	// 旧版按规则 ID 记录的暂停状态转换为应用的停止状态，已有的应用记录优先
+	legacy := filepath.Join(dir, "legacy.json")
+	data := `{"apps": {"dns": {"running": true}, "ssh": {"running": true}}, "pausedRules": {"web": true, "ssh": true, "nas": false}, "running": true}`
+	if err := os.WriteFile(legacy, []byte(data), 0600); err != nil {
+		t.Fatalf("写入状态文件失败: %v", err)
+	}
+	s = NewStateStore(legacy)
+	if err := s.Load(); err != nil {
+		t.Fatalf("加载旧版状态失败: %v", err)
+	}
+	snapshot := s.Snapshot()
+	if len(snapshot.Apps) != 3 || snapshot.Apps["web"].Running || !snapshot.Apps["ssh"].Running || !snapshot.Apps["dns"].Running {
+		t.Fatalf("旧版暂停状态转换错误: %+v", snapshot.Apps)
+	}
+	if _, ok := snapshot.Apps["nas"]; ok {
+		t.Fatal("未暂停的规则不应记录状态")
+	}
+}
+
+func TestReconcile(t *testing.T) {
+	echo := tcpEcho(t)
+	dstPort := echo.Addr().(*net.TCPAddr).Port
+	app := func(name string, autoStart bool) config.AppConfig {
+		return config.AppConfig{
+			Name:      name,
+			Protocol:  "tcp",
+			SrcPort:   freeTCPPort(t),
+			DstHost:   "127.0.0.1",
+			DstPort:   dstPort,
+			AutoStart: autoStart,
+		}
+	}
+
+	// 上次异常退出：web 被手动停止，old 已不再由服务端下发
+	path := filepath.Join(t.TempDir(), "state.json")
+	previous := NewStateStore(path)
+	previous.SetApp("web", false)
+	previous.SetApp("old", true)
+	previous.SetRunning(true)
+
+	s := NewStateStore(path)
+	if err := s.Load(); err != nil {
+		t.Fatalf("加载状态失败: %v", err)
+	}
+	m := NewForwarderManager()
+	m.SetStateStore(s)
+	m.SetDrainTimeout(0)
+	defer m.StopAll()
+
+	web, ssh := app("web", true), app("ssh", true)
+	events := m.Reconcile([]config.AppConfig{web, ssh}, 0)
+	if !hasEvent(events, EventCrashRecovered, "") || !hasEvent(events, EventAppRestored, "web") ||
+		!hasEvent(events, EventAppRemoved, "old") || len(events) != 3 {
+		t.Fatalf("恢复事件不正确: %+v", events)
+	}
+	if running(m, "web") || !running(m, "ssh") {
+		t.Fatal("web 应按本地记录保持停止，ssh 应按 AutoStart 启动")
+	}
+	if snapshot := s.Snapshot(); !snapshot.Running || len(snapshot.Apps) != 1 {
+		t.Fatalf("应丢弃已删除应用的状态: %+v", snapshot)
+	}
+
+	// 服务端删除了 web，修改了 ssh 的监听端口，新增了 dns；再次对账不再报告异常退出
+	forwarder, _ := m.GetForwarder("ssh")
+	ssh.SrcPort = freeTCPPort(t)
+	dns := app("dns", false)
+	events = m.Reconcile([]config.AppConfig{ssh, dns}, 0)
+	if !hasEvent(events, EventAppRemoved, "web") || len(events) != 1 {
+		t.Fatalf("恢复事件不正确: %+v", events)
+	}
+	if _, err := m.GetForwarder("web"); err == nil {
+		t.Fatal("服务端删除的应用应被移除")
+	}
+	if updated, _ := m.GetForwarder("ssh"); updated != forwarder || updated.Config().SrcPort != ssh.SrcPort || !updated.IsRunning() {
+		t.Fatal("只有监听端口变化时应平滑更新原有的转发器")
+	}
+	conn, err := net.Dial("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(ssh.SrcPort)))
+	if err != nil {
+		t.Fatalf("连接新端口失败: %v", err)
+	}
+	echoOnce(t, conn, "ssh")
+	conn.Close()
+	if running(m, "dns") {
+		t.Fatal("dns 不应自动启动")
+	}
+
+	// 协议变化时按新配置重新创建转发器
+	ssh.Protocol = "udp"
+	m.Reconcile([]config.AppConfig{ssh, dns}, 0)
+	if recreated, _ := m.GetForwarder("ssh"); recreated == forwarder || recreated.Config().Protocol != "udp" || !recreated.IsRunning() {
+		t.Fatal("协议变化时应重新创建转发器")
+	}
+	if snapshot := s.Snapshot(); len(snapshot.Apps) != 0 {
+		t.Fatalf("不应再有应用的本地状态: %+v", snapshot.Apps)
+	}
+}
+
+// hasEvent 检查是否产生了指定的恢复事件
+func hasEvent(events []RecoveryEvent, eventType, app string) bool {
+	for _, event := range events {
+		if event.Type == eventType && event.App == app {
+			return true
+		}
+	}
+	return false
+}
+
+// running 检查转发器是否正在运行
+func running(m *ForwarderManager, name string) bool {
+	forwarder, err := m.GetForwarder(name)
+	return err == nil && forwarder.IsRunning()
+}
@@ -0,0 +1,37 @@
+package forward
+
+// AppStats 应用自启动以来的累计流量统计
+type AppStats struct {
+	Name           string `json:"name"`
+	BytesSent      uint64 `json:"bytesSent"`
+	BytesReceived  uint64 `json:"bytesReceived"`
+	Connections    uint64 `json:"connections"`
+	ConnectionTime uint64 `json:"connectionTime"`
+	Draining       int    `json:"draining,omitempty"` // 规则平滑更新后仍按旧规则转发的连接数
+}
+
+// Snapshot 获取统计信息的快照
+func (s *Stats) Snapshot(name string) AppStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return AppStats{
+		Name:           name,
+		BytesSent:      s.BytesSent,
+		BytesReceived:  s.BytesReceived,
+		Connections:    s.Connections,
+		ConnectionTime: s.ConnectionTime,
+	}
+}
+
+// Stats 获取所有转发器的流量统计
+func (m *ForwarderManager) Stats() []AppStats {
+	forwarders := m.GetAllForwarders()
+	stats := make([]AppStats, 0, len(forwarders))
+	for name, f := range forwarders {
+		snapshot := f.GetStats().Snapshot(name)
+		snapshot.Draining = f.Draining()
+		stats = append(stats, snapshot)
+	}
+	return stats
+}
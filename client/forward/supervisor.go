@@ -0,0 +1,145 @@
+package forward
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/senma231/p3/client/health"
+	"github.com/senma231/p3/common/logger"
+)
+
+// RestartPolicy 转发器异常退出后的自动重启策略
+type RestartPolicy struct {
+	InitialBackoff time.Duration // 第一次重启前的等待时间，之后每次翻倍
+	MaxBackoff     time.Duration // 重启等待时间的上限
+	MaxRestarts    int           // 连续重启失败多少次后将应用标记为失败，之后按上限间隔继续尝试
+}
+
+// DefaultRestartPolicy 默认的自动重启策略
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Minute,
+		MaxRestarts:    5,
+	}
+}
+
+// backoff 第 failures 次失败后的重启等待时间
+func (p RestartPolicy) backoff(failures int) time.Duration {
+	delay := p.InitialBackoff << uint(failures-1)
+	if delay <= 0 || delay > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return delay
+}
+
+// restartState 应用的自动重启状态
+type restartState struct {
+	failures  int         // 连续失败次数
+	timer     *time.Timer // 等待中的重启，没有时为 nil
+	startedAt time.Time   // 最近一次重启成功的时间
+}
+
+// SetRestartPolicy 设置自动重启策略
+func (m *ForwarderManager) SetRestartPolicy(policy RestartPolicy) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.policy = policy
+}
+
+// listenerFailed 转发器的监听器异常退出后停止转发器，并安排重启
+func (m *ForwarderManager) listenerFailed(name string, forwarder *Forwarder, cause error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 转发器已被移除或替换
+	if m.forwarders[name] != forwarder {
+		return
+	}
+	if err := forwarder.Stop(); err != nil {
+		logger.Error("停止转发器 %s 失败: %v", name, err)
+	}
+	m.scheduleRestart(name, cause)
+}
+
+// scheduleRestart 按退避间隔安排重启应用，连续失败超过最大重启次数时将应用标记为失败。调用方需持有锁
+func (m *ForwarderManager) scheduleRestart(name string, cause error) {
+	state, exists := m.restarts[name]
+	if !exists {
+		state = &restartState{}
+		m.restarts[name] = state
+	}
+	if state.timer != nil {
+		state.timer.Stop()
+	}
+
+	// 上次重启后稳定运行超过等待时间上限时重新计数，避免反复退出的应用一直停留在降级状态
+	if !state.startedAt.IsZero() && time.Since(state.startedAt) >= m.policy.MaxBackoff {
+		state.failures = 0
+	}
+	state.failures++
+
+	delay := m.policy.backoff(state.failures)
+	status := health.StatusDegraded
+	if state.failures > m.policy.MaxRestarts {
+		status = health.StatusFailed
+	}
+	detail := fmt.Sprintf("%v，%s 后第 %d 次重启", cause, delay, state.failures)
+	logger.Error("转发器 %s 未运行: %s", name, detail)
+	if m.health != nil {
+		m.health.Report(name, status, detail)
+	}
+
+	state.timer = time.AfterFunc(delay, func() {
+		m.restart(name, state)
+	})
+}
+
+// restart 重启应用，失败时重新安排
+func (m *ForwarderManager) restart(name string, state *restartState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// 重启已取消
+	if m.restarts[name] != state {
+		return
+	}
+	state.timer = nil
+
+	forwarder, exists := m.forwarders[name]
+	if !exists {
+		delete(m.restarts, name)
+		return
+	}
+	if forwarder.IsRunning() {
+		return
+	}
+	if m.waitingForPeer(forwarder.config) {
+		m.deferStart(forwarder.config)
+		return
+	}
+
+	if dep := m.unstartedDependency(forwarder.config); dep != "" {
+		m.scheduleRestart(name, dependencyError(dep))
+		return
+	}
+	if err := forwarder.Start(); err != nil {
+		m.scheduleRestart(name, err)
+		return
+	}
+
+	state.startedAt = time.Now()
+	logger.Info("转发器 %s 已在第 %d 次重启后恢复", name, state.failures)
+	m.watchHealth(forwarder.config)
+}
+
+// cancelRestart 取消等待中的重启和等待对端上线的启动，并丢弃重启状态。调用方需持有锁
+func (m *ForwarderManager) cancelRestart(name string) {
+	delete(m.waiting, name)
+	if state, exists := m.restarts[name]; exists {
+		if state.timer != nil {
+			state.timer.Stop()
+		}
+		delete(m.restarts, name)
+	}
+}
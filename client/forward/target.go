@@ -0,0 +1,43 @@
+package forward
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/docker"
+)
+
+// targetAddress 应用的目标地址。目标主机为 docker:容器名 时解析为容器当前的 IP 地址
+func targetAddress(ctx context.Context, cfg *config.AppConfig) (string, error) {
+	host := cfg.DstHost
+	if docker.IsTarget(host) {
+		ip, err := docker.DefaultResolver.Resolve(ctx, host)
+		if err != nil {
+			return "", err
+		}
+		host = ip
+	}
+	return net.JoinHostPort(host, strconv.Itoa(cfg.DstPort)), nil
+}
+
+// DialFunc 连接目标地址，与 net.Dialer 的 DialContext 相同
+type DialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+// dialTarget 连接应用的目标地址，dial 为 nil 时直接连接。Docker 容器连接失败时作废解析结果，容器重启后下一次连接使用新地址
+func dialTarget(dial DialFunc, network string, cfg *config.AppConfig) (net.Conn, error) {
+	ctx := context.Background()
+	address, err := targetAddress(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	conn, err := dial(ctx, network, address)
+	if err != nil && docker.IsTarget(cfg.DstHost) {
+		docker.DefaultResolver.Invalidate(cfg.DstHost)
+	}
+	return conn, err
+}
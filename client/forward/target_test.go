@@ -0,0 +1,73 @@
+package forward
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+)
+
+func TestForwarderDialFunc(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	cfg := &config.AppConfig{
+		Name:     "web",
+		Protocol: "tcp",
+		SrcPort:  port,
+		DstHost:  "203.0.113.9",
+		DstPort:  80,
+	}
+	dialed := make(chan string, 1)
+	f := NewForwarder(cfg, 0)
+	f.setDialFunc(func(ctx context.Context, network, address string) (net.Conn, error) {
+		dialed <- address
+		return (&net.Dialer{}).DialContext(ctx, network, echo.Addr().String())
+	})
+	if err := f.Start(); err != nil {
+		t.Fatalf("启动失败: %v", err)
+	}
+	defer f.Stop()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("接收 %q, %v", buf, err)
+	}
+	if address := <-dialed; address != "203.0.113.9:80" {
+		t.Fatalf("应使用设置的拨号函数连接目标, 实际地址 %q", address)
+	}
+}
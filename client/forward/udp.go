@@ -0,0 +1,274 @@
+package forward
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/stats"
+)
+
+const (
+	// udpSessionTimeout UDP 会话的空闲超时，超时后关闭到目标的连接
+	udpSessionTimeout = 60 * time.Second
+	// maxUDPPacket UDP 数据包的最大长度
+	maxUDPPacket = 65507
+)
+
+// udpSession 一个客户端地址到目标的 UDP 会话
+type udpSession struct {
+	target  net.Conn
+	dst     *destination
+	started stats.Stopwatch
+	active  atomic.Int64 // 最后活动时距会话建立的时长，按单调时钟计算，不受系统时间修改影响
+	release func()       // 释放会话占用的资源预算
+}
+
+// touch 更新会话的最后活动时间
+func (s *udpSession) touch() {
+	s.active.Store(int64(s.started.Elapsed()))
+}
+
+// idle 检查会话是否已空闲超时
+func (s *udpSession) idle() bool {
+	return s.started.Elapsed()-time.Duration(s.active.Load()) > udpSessionTimeout
+}
+
+// listenUDP 监听本地 UDP 地址。套接字激活和特权辅助进程只提供 TCP 监听器，UDP 应用总是自己监听
+func listenUDP(address string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, fmt.Errorf("UDP 监听地址 %s 无效: %w", address, err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("监听 UDP 地址 %s 失败: %w", address, err)
+	}
+	return conn, nil
+}
+
+// udpLoop 接收客户端的数据包，按客户端地址转发到各自的会话
+func (f *Forwarder) udpLoop(conn *net.UDPConn) {
+	defer f.wg.Done()
+
+	var mu sync.Mutex
+	sessions := make(map[string]*udpSession)
+	buffer := make([]byte, maxUDPPacket)
+
+	// start 为客户端地址建立新的会话，替换已结束的会话
+	start := func(clientAddr *net.UDPAddr) *udpSession {
+		// 被拒绝的客户端不建立会话，之后的每个数据包都会重新检查
+		if f.unexpected(f.currentConfig(), clientAddr) {
+			return nil
+		}
+		session := f.openUDPSession()
+		if session == nil {
+			return nil
+		}
+		key := clientAddr.String()
+		mu.Lock()
+		sessions[key] = session
+		mu.Unlock()
+
+		f.wg.Add(1)
+		go func() {
+			f.udpReplies(conn, clientAddr, session)
+			mu.Lock()
+			if sessions[key] == session {
+				delete(sessions, key)
+			}
+			mu.Unlock()
+		}()
+		return session
+	}
+
+	for {
+		n, clientAddr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			select {
+			case <-f.stopCh:
+				return
+			default:
+			}
+			// 规则已平滑替换，旧的套接字被关闭
+			if f.packetReplaced(conn) {
+				return
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+
+			// 套接字已不可用，交由调用方停止并重启
+			logger.Error("转发器 %s 的 UDP 套接字异常退出: %v", f.currentConfig().Name, err)
+			if f.onExit != nil {
+				go f.onExit(err)
+			}
+			return
+		}
+
+		key := clientAddr.String()
+		mu.Lock()
+		session := sessions[key]
+		mu.Unlock()
+		if session == nil {
+			if session = start(clientAddr); session == nil {
+				continue
+			}
+		}
+
+		session.touch()
+		_, err = session.target.Write(buffer[:n])
+		if errors.Is(err, net.ErrClosed) {
+			// 会话已在排空时关闭，按当前规则重新建立
+			if session = start(clientAddr); session == nil {
+				continue
+			}
+			_, err = session.target.Write(buffer[:n])
+		}
+		if err != nil {
+			logger.Error("转发数据失败 (客户端 -> 目标): %v", err)
+			continue
+		}
+
+		// 更新统计信息
+		f.stats.mu.Lock()
+		f.stats.BytesSent = stats.Add(f.stats.BytesSent, uint64(n))
+		f.stats.LastActiveTime = time.Now()
+		f.stats.mu.Unlock()
+		f.stats.transferred(session.dst, uint64(n), 0)
+	}
+}
+
+// openUDPSession 按当前规则连接目标并记录会话，转发器已停止、超过资源预算或连接失败时返回 nil
+func (f *Forwarder) openUDPSession() *udpSession {
+	// 每个会话使用一个接收目标响应的缓冲区
+	release, ok := f.admit(f.currentConfig(), maxUDPPacket)
+	if !ok {
+		return nil
+	}
+	for {
+		cfg := f.currentConfig()
+		target, err := f.dialTarget("udp", cfg)
+		if err != nil {
+			logger.Error("连接目标失败: %v", err)
+			release()
+			return nil
+		}
+
+		tracked := f.track(target)
+		if tracked == nil {
+			target.Close()
+			release()
+			return nil
+		}
+		if tracked != cfg {
+			// 连接目标期间规则被替换，按新规则重新连接
+			f.untrack(target)
+			target.Close()
+			continue
+		}
+
+		f.stats.mu.Lock()
+		f.stats.Connections++
+		f.stats.LastActiveTime = time.Now()
+		f.stats.mu.Unlock()
+
+		session := &udpSession{target: target, dst: f.stats.connected("udp", cfg.DstHost, target.RemoteAddr()), release: release, started: stats.Start()}
+		session.touch()
+		return session
+	}
+}
+
+// udpReplies 将目标的响应发回客户端，会话空闲超时、目标连接被关闭或出错时结束
+func (f *Forwarder) udpReplies(conn *net.UDPConn, clientAddr *net.UDPAddr, session *udpSession) {
+	defer f.wg.Done()
+	defer session.release()
+	defer f.untrack(session.target)
+	defer session.target.Close()
+
+	buffer := make([]byte, maxUDPPacket)
+	for {
+		session.target.SetReadDeadline(time.Now().Add(udpSessionTimeout))
+		n, err := session.target.Read(buffer)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && !session.idle() {
+				continue
+			}
+			return
+		}
+
+		session.touch()
+		if _, err := conn.WriteToUDP(buffer[:n], clientAddr); err != nil {
+			logger.Error("转发数据失败 (目标 -> 客户端): %v", err)
+			return
+		}
+
+		// 更新统计信息
+		f.stats.mu.Lock()
+		f.stats.BytesReceived = stats.Add(f.stats.BytesReceived, uint64(n))
+		f.stats.LastActiveTime = time.Now()
+		f.stats.mu.Unlock()
+		f.stats.transferred(session.dst, 0, uint64(n))
+	}
+}
+
+// packetReplaced 检查 UDP 套接字是否已被平滑替换
+func (f *Forwarder) packetReplaced(conn *net.UDPConn) bool {
+	f.sessionMu.Lock()
+	defer f.sessionMu.Unlock()
+	return f.packetConn != conn
+}
+
+// replaceUDP 平滑替换 UDP 应用的转发规则。UDP 会话的响应只能从原来的端口发回客户端，
+// 监听端口或地址变化时已有的会话随旧的套接字结束；只有目标地址变化时会话按旧规则排空。调用方需持有锁
+func (f *Forwarder) replaceUDP(cfg *config.AppConfig, drainTimeout time.Duration) error {
+	old := f.config
+	oldConn := f.packetConn
+	conn := oldConn
+	listenAddr, err := listenAddress(cfg)
+	if err != nil {
+		return err
+	}
+	if cfg.SrcPort != old.SrcPort || cfg.Bind != old.Bind {
+		conn, err = listenUDP(listenAddr)
+		if err != nil {
+			return fmt.Errorf("创建新的监听器失败: %w", err)
+		}
+		if f.firewall != nil {
+			if err := f.firewall.Allow(firewallRule(cfg)); err != nil {
+				logger.Warn("添加应用 %s 的防火墙规则失败: %v", cfg.Name, err)
+			}
+		}
+	}
+
+	// 新的会话按新规则转发
+	f.sessionMu.Lock()
+	f.config = cfg
+	f.packetConn = conn
+	draining := f.generation
+	f.generation++
+	f.sessionMu.Unlock()
+
+	if conn != oldConn {
+		f.wg.Add(1)
+		go f.udpLoop(conn)
+
+		oldConn.Close()
+		f.closeSessions(func(generation int) bool { return generation <= draining })
+		if f.firewall != nil {
+			if err := f.firewall.Remove(firewallRule(old)); err != nil {
+				logger.Warn("移除应用 %s 的防火墙规则失败: %v", old.Name, err)
+			}
+		}
+	} else {
+		f.drain(cfg, draining, drainTimeout)
+	}
+
+	logger.Info("转发器已更新: %s -> %s:%d", listenAddr, cfg.DstHost, cfg.DstPort)
+	return nil
+}
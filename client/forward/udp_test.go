@@ -0,0 +1,186 @@
+package forward
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/client/budget"
+	"github.com/senma231/p3/client/config"
+)
+
+// udpEcho 启动 UDP 回显服务，响应带上前缀以区分目标
+func udpEcho(t *testing.T, prefix string) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(append([]byte(prefix), buf[:n]...), addr)
+		}
+	}()
+	return conn
+}
+
+// freeUDPPort 获取一个空闲的 UDP 端口
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+func TestUDPForwarder(t *testing.T) {
+	first, second := udpEcho(t, "a:"), udpEcho(t, "b:")
+	cfg := &config.AppConfig{
+		Name:     "dns",
+		Protocol: "udp",
+		SrcPort:  freeUDPPort(t),
+		DstHost:  "127.0.0.1",
+		DstPort:  first.LocalAddr().(*net.UDPAddr).Port,
+	}
+	f := NewForwarder(cfg, 0)
+	if err := f.Start(); err != nil {
+		t.Fatalf("启动失败: %v", err)
+	}
+	defer f.Stop()
+
+	client, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: cfg.SrcPort})
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	defer client.Close()
+
+	exchange := func(payload string) string {
+		t.Helper()
+		if _, err := client.Write([]byte(payload)); err != nil {
+			t.Fatalf("发送失败: %v", err)
+		}
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 1500)
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatalf("接收失败: %v", err)
+		}
+		return string(buf[:n])
+	}
+
+	// 同一客户端地址的数据包使用同一个会话
+	if got := exchange("ping"); got != "a:ping" {
+		t.Fatalf("响应为 %q", got)
+	}
+	if got := exchange("pong"); got != "a:pong" {
+		t.Fatalf("响应为 %q", got)
+	}
+	if stats := f.GetStats().Snapshot(cfg.Name); stats.Connections != 1 || stats.BytesSent != 8 || stats.BytesReceived != 12 {
+		t.Fatalf("统计信息为 %+v", stats)
+	}
+
+	// 目标地址变化后立即排空旧会话，之后的数据包按新规则转发
+	updated := *cfg
+	updated.DstPort = second.LocalAddr().(*net.UDPAddr).Port
+	if err := f.Replace(&updated, 0); err != nil {
+		t.Fatalf("更新失败: %v", err)
+	}
+	if got := exchange("ping"); got != "b:ping" {
+		t.Fatalf("更新后的响应为 %q", got)
+	}
+}
+
+func TestForwarderBind(t *testing.T) {
+	// 网卡名称解析为网卡上的地址
+	var loopback string
+	ifaces, _ := net.Interfaces()
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			loopback = iface.Name
+			break
+		}
+	}
+
+	for _, bind := range []string{"127.0.0.1", loopback} {
+		if bind == "" {
+			continue
+		}
+		cfg := &config.AppConfig{Name: "dns", Protocol: "udp", SrcPort: freeUDPPort(t), Bind: bind, DstHost: "127.0.0.1", DstPort: 53}
+		f := NewForwarder(cfg, 0)
+		if err := f.Start(); err != nil {
+			t.Fatalf("监听 %s 失败: %v", bind, err)
+		}
+		addr := f.packetConn.LocalAddr().(*net.UDPAddr)
+		f.Stop()
+		if !addr.IP.IsLoopback() || addr.Port != cfg.SrcPort {
+			t.Fatalf("绑定 %s 时监听地址为 %s", bind, addr)
+		}
+	}
+
+	cfg := &config.AppConfig{Name: "dns", Protocol: "udp", SrcPort: freeUDPPort(t), Bind: "p3-missing0"}
+	if err := NewForwarder(cfg, 0).Start(); err == nil {
+		t.Fatalf("网卡不存在时应启动失败")
+	}
+}
+
+func TestUDPForwarderBudget(t *testing.T) {
+	echo := udpEcho(t, "")
+	cfg := &config.AppConfig{
+		Name:     "dns",
+		Protocol: "udp",
+		SrcPort:  freeUDPPort(t),
+		DstHost:  "127.0.0.1",
+		DstPort:  echo.LocalAddr().(*net.UDPAddr).Port,
+	}
+	b := budget.New(budget.Limits{MaxConnections: 1})
+	f := NewForwarder(cfg, 0)
+	f.budget.Store(b)
+	if err := f.Start(); err != nil {
+		t.Fatalf("启动失败: %v", err)
+	}
+	defer f.Stop()
+
+	exchange := func(client *net.UDPConn) error {
+		t.Helper()
+		if _, err := client.Write([]byte("ping")); err != nil {
+			t.Fatalf("发送失败: %v", err)
+		}
+		client.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+		_, err := client.Read(make([]byte, 1500))
+		return err
+	}
+	dial := func() *net.UDPConn {
+		t.Helper()
+		client, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: cfg.SrcPort})
+		if err != nil {
+			t.Fatalf("连接失败: %v", err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+
+	// 第一个客户端占用唯一的会话，第二个客户端的数据包被丢弃
+	if err := exchange(dial()); err != nil {
+		t.Fatalf("第一个会话接收失败: %v", err)
+	}
+	if err := exchange(dial()); err == nil {
+		t.Fatal("超过资源预算的会话不应转发")
+	}
+	if usage := b.Usage(); usage.Connections != 1 || usage.Rejected == 0 || usage.Pressure != budget.PressureCritical {
+		t.Fatalf("资源占用为 %+v", usage)
+	}
+
+	// 停止后会话结束，释放预算
+	f.Stop()
+	if usage := b.Usage(); usage.Connections != 0 {
+		t.Fatalf("停止后仍占用 %d 个连接", usage.Connections)
+	}
+}
@@ -4,6 +4,7 @@ go 1.20
 
 require (
 	github.com/huin/goupnp v1.3.0
+	go.uber.org/goleak v1.3.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -0,0 +1,267 @@
+// Package health 定期检查应用的目标服务是否可用，连续失败时按退避间隔重试，
+// 并在应用状态变为运行中、降级或失败时通知调用方
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 应用状态
+const (
+	StatusStarting = "starting"
+	StatusRunning  = "running"
+	StatusDegraded = "degraded"
+	StatusFailed   = "failed"
+	StatusWaiting  = "waiting" // 等待对端节点上线后启动
+)
+
+// minRetryDelay 检查失败后第一次重试的间隔，之后每次翻倍，不超过检查间隔
+const minRetryDelay = time.Second
+
+// Check 一项应用的健康检查
+type Check struct {
+	Network  string        // 目标地址的协议，tcp 或 udp
+	Address  string        // 目标地址，总会检查能否连接
+	HTTP     string        // 不为空时请求该地址，响应状态码小于 400 视为通过
+	Command  string        // 不为空时执行该命令，退出码为 0 视为通过
+	Interval time.Duration // 检查间隔
+	Timeout  time.Duration // 单次检查超时
+	Retries  int           // 连续失败多少次后标记为失败
+	// 不为空时每次检查前调用获取目标地址，用于地址会变化的目标，如 Docker 容器
+	Resolve func(ctx context.Context) (string, error)
+}
+
+// Run 执行一次检查
+func (c Check) Run(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	network := c.Network
+	if network == "" {
+		network = "tcp"
+	}
+	address := c.Address
+	if c.Resolve != nil {
+		resolved, err := c.Resolve(ctx)
+		if err != nil {
+			return fmt.Errorf("解析目标地址失败: %w", err)
+		}
+		address = resolved
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return fmt.Errorf("连接目标地址失败: %w", err)
+	}
+	conn.Close()
+
+	if c.HTTP != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.HTTP, nil)
+		if err != nil {
+			return fmt.Errorf("创建 HTTP 检查请求失败: %w", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("HTTP 检查失败: %w", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusBadRequest {
+			return fmt.Errorf("HTTP 检查返回状态码 %d", resp.StatusCode)
+		}
+	}
+
+	if c.Command != "" {
+		args := strings.Fields(c.Command)
+		if out, err := exec.CommandContext(ctx, args[0], args[1:]...).CombinedOutput(); err != nil {
+			detail := strings.TrimSpace(string(out))
+			if len(detail) > 200 {
+				detail = detail[:200]
+			}
+			if detail != "" {
+				return fmt.Errorf("检查命令失败: %w: %s", err, detail)
+			}
+			return fmt.Errorf("检查命令失败: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Result 应用的健康状态
+type Result struct {
+	App       string    `json:"name"`
+	Status    string    `json:"status"`
+	Detail    string    `json:"detail,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// watch 正在检查的应用
+type watch struct {
+	check     Check
+	dependsOn []string
+	status    string
+	failures  int
+	cancel    context.CancelFunc
+}
+
+// Monitor 检查多个应用的健康状态，应用的状态变化时调用 onChange
+type Monitor struct {
+	watches  map[string]*watch
+	onChange func(Result)
+	mu       sync.Mutex
+}
+
+// NewMonitor 创建健康检查器，onChange 在应用状态变化时调用，可以为 nil
+func NewMonitor(onChange func(Result)) *Monitor {
+	return &Monitor{
+		watches:  make(map[string]*watch),
+		onChange: onChange,
+	}
+}
+
+// Watch 开始检查应用，dependsOn 中的应用未处于运行中时本应用最多标记为降级。
+// 已在检查的应用会按新的检查重新开始
+func (m *Monitor) Watch(app string, check Check, dependsOn []string) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	m.mu.Lock()
+	if w, ok := m.watches[app]; ok {
+		w.cancel()
+	}
+	w := &watch{
+		check:     check,
+		dependsOn: dependsOn,
+		status:    StatusStarting,
+		cancel:    cancel,
+	}
+	m.watches[app] = w
+	m.mu.Unlock()
+
+	go m.loop(ctx, app, w)
+}
+
+// Unwatch 停止检查应用
+func (m *Monitor) Unwatch(app string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if w, ok := m.watches[app]; ok {
+		w.cancel()
+		delete(m.watches, app)
+	}
+}
+
+// Report 停止检查应用并直接设置其状态，如转发器异常退出、等待重启时。
+// 状态变化时同样调用 onChange，之后调用 Watch 重新以检查结果为准
+func (m *Monitor) Report(app, status, detail string) {
+	m.mu.Lock()
+	w, ok := m.watches[app]
+	if ok {
+		w.cancel()
+	}
+	changed := !ok || w.status != status
+	m.watches[app] = &watch{status: status, cancel: func() {}}
+	m.mu.Unlock()
+
+	if changed && m.onChange != nil {
+		m.onChange(Result{App: app, Status: status, Detail: detail, CheckedAt: time.Now()})
+	}
+}
+
+// Status 获取应用的健康状态，未在检查的应用返回空字符串
+func (m *Monitor) Status(app string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if w, ok := m.watches[app]; ok {
+		return w.status
+	}
+	return ""
+}
+
+// Stop 停止检查所有应用
+func (m *Monitor) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for app, w := range m.watches {
+		w.cancel()
+		delete(m.watches, app)
+	}
+}
+
+// loop 按间隔检查应用，失败后以退避间隔重试
+func (m *Monitor) loop(ctx context.Context, app string, w *watch) {
+	for {
+		err := w.check.Run(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		delay := m.update(app, w, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// update 根据检查结果更新应用状态，返回下次检查前的等待时间
+func (m *Monitor) update(app string, w *watch, err error) time.Duration {
+	m.mu.Lock()
+	if m.watches[app] != w {
+		m.mu.Unlock()
+		return w.check.Interval
+	}
+
+	result := Result{App: app, CheckedAt: time.Now()}
+	delay := w.check.Interval
+	if err != nil {
+		w.failures++
+		result.Detail = err.Error()
+		if w.failures >= w.check.Retries {
+			result.Status = StatusFailed
+		} else {
+			result.Status = StatusDegraded
+			delay = retryDelay(w.failures, w.check.Interval)
+		}
+	} else {
+		w.failures = 0
+		result.Status = StatusRunning
+		for _, dep := range w.dependsOn {
+			if d, ok := m.watches[dep]; !ok || d.status != StatusRunning {
+				result.Status = StatusDegraded
+				result.Detail = fmt.Sprintf("依赖的应用 %s 未运行", dep)
+				break
+			}
+		}
+	}
+
+	changed := result.Status != w.status
+	w.status = result.Status
+	m.mu.Unlock()
+
+	if changed && m.onChange != nil {
+		m.onChange(result)
+	}
+	return delay
+}
+
+// retryDelay 第 failures 次失败后的重试间隔
+func retryDelay(failures int, interval time.Duration) time.Duration {
+	delay := minRetryDelay << uint(failures-1)
+	if delay <= 0 || delay > interval {
+		return interval
+	}
+	return delay
+}
@@ -0,0 +1,79 @@
+package health
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("创建监听器失败: %v", err)
+	}
+
+	results := make(chan Result, 10)
+	monitor := NewMonitor(func(r Result) { results <- r })
+	defer monitor.Stop()
+
+	check := Check{
+		Address:  listener.Addr().String(),
+		Interval: 20 * time.Millisecond,
+		Timeout:  20 * time.Millisecond,
+		Retries:  2,
+	}
+	monitor.Watch("db", check, nil)
+	monitor.Watch("web", check, []string{"db", "cache"})
+
+	// 各应用已通知的状态，按应用分别保留顺序
+	seen := make(map[string][]string)
+	expect := func(app, status string) {
+		t.Helper()
+		deadline := time.After(2 * time.Second)
+		for {
+			for len(seen[app]) > 0 {
+				next := seen[app][0]
+				seen[app] = seen[app][1:]
+				if next == status {
+					return
+				}
+			}
+			select {
+			case r := <-results:
+				seen[r.App] = append(seen[r.App], r.Status)
+			case <-deadline:
+				t.Fatalf("应用 %s 未变为 %s", app, status)
+			}
+		}
+	}
+
+	expect("db", StatusRunning)
+	// 依赖的应用 cache 未在检查，目标可用时也只标记为降级
+	expect("web", StatusDegraded)
+
+	// 目标不可用时先降级，连续失败达到重试次数后标记为失败
+	listener.Close()
+	expect("db", StatusDegraded)
+	expect("db", StatusFailed)
+
+	// 直接设置的状态停止检查，重新检查后以检查结果为准
+	monitor.Report("db", StatusDegraded, "转发器异常退出")
+	expect("db", StatusDegraded)
+	if status := monitor.Status("db"); status != StatusDegraded {
+		t.Fatalf("状态应为 degraded，实际为 %s", status)
+	}
+
+	monitor.Unwatch("db")
+	if status := monitor.Status("db"); status != "" {
+		t.Fatalf("停止检查后状态应为空，实际为 %s", status)
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	interval := 10 * time.Second
+	for failures, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: interval, 80: interval} {
+		if got := retryDelay(failures, interval); got != want {
+			t.Fatalf("第 %d 次失败后的重试间隔应为 %s，实际为 %s", failures, want, got)
+		}
+	}
+}
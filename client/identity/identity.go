@@ -0,0 +1,403 @@
+// Package identity 管理服务端内置 CA 签发的设备证书。设备使用令牌申请证书后，
+// 信令、中继和 P2P 握手都使用证书私钥签名认证，中继会话内的端到端加密以证书双向认证；
+// 证书在有效期剩余三分之一时轮换，对端证书按 CA 和服务端发布的吊销列表验证
+package identity
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/signing"
+)
+
+// CredentialFields 握手消息中证书凭据的字段数：证书、时间戳、随机数和签名
+const CredentialFields = 4
+
+var (
+	// ErrNotEnrolled 设备尚未取得证书
+	ErrNotEnrolled = errors.New("设备尚未取得证书")
+	// ErrRevoked 证书已被吊销
+	ErrRevoked = errors.New("证书已被吊销")
+)
+
+// Identity 设备证书、私钥和用于验证对端的 CA 证书
+type Identity struct {
+	certFile string
+	keyFile  string
+	caFile   string
+
+	cert     *x509.Certificate
+	key      *ecdsa.PrivateKey
+	ca       *x509.Certificate
+	roots    *x509.CertPool
+	revoked  map[string]bool // 吊销列表中的序列号
+	verifier *signing.Verifier
+	mu       sync.RWMutex
+}
+
+// Load 加载设备证书、私钥和 CA 证书，证书不存在时返回尚未取得证书的身份
+func Load(certFile, keyFile, caFile string) (*Identity, error) {
+	id := &Identity{
+		certFile: certFile,
+		keyFile:  keyFile,
+		caFile:   caFile,
+		revoked:  make(map[string]bool),
+		verifier: signing.NewVerifier(signing.DefaultWindow),
+	}
+
+	caPEM, err := os.ReadFile(caFile)
+	if os.IsNotExist(err) {
+		return id, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取 CA 证书失败: %w", err)
+	}
+	certPEM, err := os.ReadFile(certFile)
+	if os.IsNotExist(err) {
+		// 证书被吊销后只保留 CA 证书，仍用于验证对端
+		if id.ca, err = parseCertificate(caPEM); err != nil {
+			return nil, fmt.Errorf("CA 证书: %w", err)
+		}
+		id.roots = x509.NewCertPool()
+		id.roots.AddCert(id.ca)
+		return id, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取设备证书失败: %w", err)
+	}
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("读取设备私钥失败: %w", err)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, errors.New("无效的设备私钥")
+	}
+	key, err := x509.ParseECPrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析设备私钥失败: %w", err)
+	}
+	if id.cert, id.ca, id.roots, err = validate(key, certPEM, caPEM); err != nil {
+		return nil, err
+	}
+	id.key = key
+	return id, nil
+}
+
+// NewCertificateRequest 生成新的设备私钥和 PEM 编码的证书签名请求。
+// 证书的通用名由服务端按节点 ID 设置
+func NewCertificateRequest() ([]byte, *ecdsa.PrivateKey, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("生成设备私钥失败: %w", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("创建证书签名请求失败: %w", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), key, nil
+}
+
+// Install 验证并保存服务端签发的证书，替换当前的证书和私钥
+func (id *Identity) Install(key *ecdsa.PrivateKey, certPEM, caPEM []byte) error {
+	cert, ca, roots, err := validate(key, certPEM, caPEM)
+	if err != nil {
+		return err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("编码设备私钥失败: %w", err)
+	}
+
+	id.mu.Lock()
+	defer id.mu.Unlock()
+
+	// 最后写入证书，证书存在时私钥和 CA 证书一定与之匹配
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := writeFile(id.keyFile, keyPEM, 0o600); err != nil {
+		return err
+	}
+	if err := writeFile(id.caFile, caPEM, 0o644); err != nil {
+		return err
+	}
+	if err := writeFile(id.certFile, certPEM, 0o644); err != nil {
+		return err
+	}
+
+	id.cert, id.key, id.ca, id.roots = cert, key, ca, roots
+	return nil
+}
+
+// validate 验证证书由 CA 签发且与私钥匹配
+func validate(key *ecdsa.PrivateKey, certPEM, caPEM []byte) (*x509.Certificate, *x509.Certificate, *x509.CertPool, error) {
+	ca, err := parseCertificate(caPEM)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("CA 证书: %w", err)
+	}
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("设备证书: %w", err)
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		return nil, nil, nil, errors.New("设备证书与私钥不匹配")
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil, nil, nil, fmt.Errorf("设备证书无效: %w", err)
+	}
+	return cert, ca, roots, nil
+}
+
+// Reset 删除证书和私钥，证书被吊销后设备重新使用令牌申请证书。保留 CA 证书用于验证对端
+func (id *Identity) Reset() error {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+
+	id.cert, id.key = nil, nil
+	for _, path := range []string{id.certFile, id.keyFile} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("删除设备证书失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// Enrolled 检查设备是否持有证书
+func (id *Identity) Enrolled() bool {
+	if id == nil {
+		return false
+	}
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	return id.cert != nil
+}
+
+// Serial 当前证书的十六进制序列号，未取得证书时为空
+func (id *Identity) Serial() string {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	if id.cert == nil {
+		return ""
+	}
+	return serialString(id.cert)
+}
+
+// NeedsRenewal 检查是否需要申请证书：尚未取得证书，或有效期剩余不足三分之一
+func (id *Identity) NeedsRenewal(now time.Time) bool {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	if id.cert == nil {
+		return true
+	}
+	lifetime := id.cert.NotAfter.Sub(id.cert.NotBefore)
+	return id.cert.NotAfter.Sub(now) < lifetime/3
+}
+
+// SignRequest 为发往服务端的请求添加设备证书和证书私钥的签名
+func (id *Identity) SignRequest(req *http.Request, body []byte) error {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	if id.cert == nil {
+		return ErrNotEnrolled
+	}
+	return signing.SignRequestWithCertificate(req, id.cert.Raw, id.key, body)
+}
+
+// Credentials 返回握手消息中以空格分隔的证书凭据：Base64 编码的证书、时间戳、随机数和
+// 证书私钥对 method 与 path 的签名
+func (id *Identity) Credentials(method, path string) (string, error) {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	if id.cert == nil {
+		return "", ErrNotEnrolled
+	}
+
+	nonce, err := signing.NewNonce()
+	if err != nil {
+		return "", err
+	}
+	timestamp := time.Now().Unix()
+	signature, err := signing.SignWithKey(id.key, method, path, timestamp, nonce, nil)
+	if err != nil {
+		return "", fmt.Errorf("签名失败: %w", err)
+	}
+	return strings.Join([]string{
+		base64.StdEncoding.EncodeToString(id.cert.Raw),
+		strconv.FormatInt(timestamp, 10),
+		nonce,
+		signature,
+	}, " "), nil
+}
+
+// VerifyPeer 验证对端在握手消息中发送的证书凭据：证书由同一 CA 签发、未吊销、通用名为
+// nodeID，且签名有效、未被重放
+func (id *Identity) VerifyPeer(fields []string, method, path, nodeID string) error {
+	if len(fields) != CredentialFields {
+		return errors.New("缺少证书凭据")
+	}
+	der, err := base64.StdEncoding.DecodeString(fields[0])
+	if err != nil {
+		return errors.New("证书编码无效")
+	}
+	timestamp, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return errors.New("时间戳无效")
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return fmt.Errorf("解析证书失败: %w", err)
+	}
+	if err := id.verifyCertificate(cert, nodeID); err != nil {
+		return err
+	}
+	if err := signing.VerifyWithCertificate(cert, method, path, timestamp, fields[2], nil, fields[3]); err != nil {
+		return err
+	}
+	return id.verifier.CheckReplay(nodeID, fields[2], timestamp)
+}
+
+// TLSConfig 返回与节点 peerID 建立双向认证 TLS 连接的配置，双方都出示设备证书。
+// 设备证书不包含主机名，对端证书不做标准的主机名验证，改为检查由同一 CA 签发、未吊销且通用名为 peerID
+func (id *Identity) TLSConfig(peerID string) (*tls.Config, error) {
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	if id.cert == nil {
+		return nil, ErrNotEnrolled
+	}
+	if id.roots == nil {
+		return nil, errors.New("没有用于验证对端的 CA 证书")
+	}
+
+	return &tls.Config{
+		MinVersion: tls.VersionTLS13,
+		Certificates: []tls.Certificate{{
+			Certificate: [][]byte{id.cert.Raw},
+			PrivateKey:  id.key,
+			Leaf:        id.cert,
+		}},
+		ClientAuth:         tls.RequireAnyClientCert,
+		InsecureSkipVerify: true, // 由 VerifyPeerCertificate 验证对端证书
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("对端未提供证书")
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return fmt.Errorf("解析证书失败: %w", err)
+			}
+			return id.verifyCertificate(cert, peerID)
+		},
+	}, nil
+}
+
+// verifyCertificate 检查对端证书由同一 CA 签发、未吊销且通用名为 nodeID
+func (id *Identity) verifyCertificate(cert *x509.Certificate, nodeID string) error {
+	id.mu.RLock()
+	roots := id.roots
+	revoked := id.revoked[serialString(cert)]
+	id.mu.RUnlock()
+	if roots == nil {
+		return errors.New("没有用于验证对端的 CA 证书")
+	}
+
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return fmt.Errorf("证书无效: %w", err)
+	}
+	if revoked {
+		return ErrRevoked
+	}
+	if cert.Subject.CommonName != nodeID {
+		return fmt.Errorf("证书属于节点 %s，不是 %s", cert.Subject.CommonName, nodeID)
+	}
+	return nil
+}
+
+// CanVerifyPeers 检查是否有 CA 证书，有时对端必须提供证书凭据
+func (id *Identity) CanVerifyPeers() bool {
+	if id == nil {
+		return false
+	}
+	id.mu.RLock()
+	defer id.mu.RUnlock()
+	return id.roots != nil
+}
+
+// SetRevocationList 设置服务端发布的 DER 编码的证书吊销列表，吊销列表须由 CA 签名
+func (id *Identity) SetRevocationList(der []byte) error {
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil {
+		return fmt.Errorf("解析证书吊销列表失败: %w", err)
+	}
+
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	if id.ca == nil {
+		return errors.New("没有用于验证吊销列表的 CA 证书")
+	}
+	if err := crl.CheckSignatureFrom(id.ca); err != nil {
+		return fmt.Errorf("证书吊销列表的签名无效: %w", err)
+	}
+
+	revoked := make(map[string]bool, len(crl.RevokedCertificates))
+	for _, entry := range crl.RevokedCertificates {
+		revoked[hex.EncodeToString(entry.SerialNumber.Bytes())] = true
+	}
+	id.revoked = revoked
+	return nil
+}
+
+// parseCertificate 解析 PEM 编码的证书
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(bytes.TrimSpace(data))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("无效的 PEM 证书")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// serialString 返回证书序列号的十六进制表示，与服务端的表示相同
+func serialString(cert *x509.Certificate) string {
+	return hex.EncodeToString(cert.SerialNumber.Bytes())
+}
+
+// writeFile 先写入临时文件再替换，避免写入中断时留下不完整的文件
+func writeFile(path string, data []byte, perm os.FileMode) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("创建目录失败: %w", err)
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return fmt.Errorf("写入 %s 失败: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("写入 %s 失败: %w", path, err)
+	}
+	return nil
+}
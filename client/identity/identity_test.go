@@ -0,0 +1,216 @@
+package identity
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA 模拟服务端内置 CA
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("创建 CA 失败: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue 按证书签名请求签发证书
+func (ca *testCA) issue(t *testing.T, csrPEM []byte, nodeID string, serial int64, validity time.Duration) []byte {
+	block, _ := pem.Decode(csrPEM)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatalf("解析证书签名请求失败: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: nodeID},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(validity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, csr.PublicKey, ca.key)
+	if err != nil {
+		t.Fatalf("签发证书失败: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func enroll(t *testing.T, ca *testCA, nodeID string, serial int64) *Identity {
+	dir := t.TempDir()
+	id, err := Load(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem"))
+	if err != nil || id.Enrolled() || !id.NeedsRenewal(time.Now()) {
+		t.Fatalf("未申请证书时的身份错误: %v", err)
+	}
+	csr, key, err := NewCertificateRequest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := id.Install(key, ca.issue(t, csr, nodeID, serial, 90*24*time.Hour), ca.pem); err != nil {
+		t.Fatalf("保存证书失败: %v", err)
+	}
+	return id
+}
+
+func TestInstallAndLoad(t *testing.T) {
+	ca := newTestCA(t)
+	id := enroll(t, ca, "node-a", 10)
+	if !id.Enrolled() || id.NeedsRenewal(time.Now()) || id.Serial() != "0a" {
+		t.Fatalf("保存后的身份错误: %s", id.Serial())
+	}
+	if !id.NeedsRenewal(time.Now().Add(70 * 24 * time.Hour)) {
+		t.Fatal("有效期剩余不足三分之一时应轮换")
+	}
+
+	loaded, err := Load(id.certFile, id.keyFile, id.caFile)
+	if err != nil || loaded.Serial() != "0a" {
+		t.Fatalf("重新加载失败: %v", err)
+	}
+
+	// 其他 CA 签发的证书
+	csr, key, _ := NewCertificateRequest()
+	if err := id.Install(key, newTestCA(t).issue(t, csr, "node-a", 11, time.Hour), ca.pem); err == nil {
+		t.Fatal("不应保存其他 CA 签发的证书")
+	}
+
+	if err := id.Reset(); err != nil || id.Enrolled() {
+		t.Fatalf("删除证书失败: %v", err)
+	}
+	loaded, err = Load(id.certFile, id.keyFile, id.caFile)
+	if err != nil || loaded.Enrolled() || !loaded.CanVerifyPeers() {
+		t.Fatalf("删除证书后应保留 CA 证书: %v", err)
+	}
+}
+
+func TestVerifyPeer(t *testing.T) {
+	ca := newTestCA(t)
+	a := enroll(t, ca, "node-a", 1)
+	b := enroll(t, ca, "node-b", 2)
+
+	creds, err := a.Credentials("P3-LAN-PING", "node-b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := strings.Fields(creds)
+	if err := b.VerifyPeer(fields, "P3-LAN-PING", "node-b", "node-a"); err != nil {
+		t.Fatalf("验证对端失败: %v", err)
+	}
+	if err := b.VerifyPeer(fields, "P3-LAN-PING", "node-b", "node-a"); err == nil {
+		t.Fatal("重放的凭据不应通过验证")
+	}
+
+	creds, _ = a.Credentials("P3-LAN-PING", "node-b")
+	if err := b.VerifyPeer(strings.Fields(creds), "P3-LAN-PING", "node-c", "node-a"); err == nil {
+		t.Fatal("发给其他节点的凭据不应通过验证")
+	}
+	creds, _ = a.Credentials("P3-LAN-PING", "node-b")
+	if err := b.VerifyPeer(strings.Fields(creds), "P3-LAN-PING", "node-b", "node-x"); err == nil {
+		t.Fatal("冒充其他节点不应通过验证")
+	}
+
+	// 吊销列表中的证书
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:              big.NewInt(1),
+		ThisUpdate:          time.Now(),
+		NextUpdate:          time.Now().Add(time.Hour),
+		RevokedCertificates: []pkix.RevokedCertificate{{SerialNumber: big.NewInt(1), RevocationTime: time.Now()}},
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.SetRevocationList(crl); err != nil {
+		t.Fatalf("设置吊销列表失败: %v", err)
+	}
+	creds, _ = a.Credentials("P3-LAN-PING", "node-b")
+	if err := b.VerifyPeer(strings.Fields(creds), "P3-LAN-PING", "node-b", "node-a"); err != ErrRevoked {
+		t.Fatalf("期望 ErrRevoked，实际 %v", err)
+	}
+}
+
+// handshake 在内存连接上以 client 和 server 的配置完成 TLS 握手，返回双方的握手错误
+func handshake(client, server *tls.Config) (error, error) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	serverErr := make(chan error, 1)
+	go func() {
+		conn := tls.Server(s, server)
+		err := conn.Handshake()
+		if err != nil {
+			// 出错时关闭连接，避免客户端等待
+			s.Close()
+		}
+		serverErr <- err
+	}()
+	clientErr := tls.Client(c, client).Handshake()
+	if clientErr != nil {
+		c.Close()
+	}
+	return clientErr, <-serverErr
+}
+
+func TestTLSConfig(t *testing.T) {
+	ca := newTestCA(t)
+	a := enroll(t, ca, "node-a", 1)
+	b := enroll(t, ca, "node-b", 2)
+
+	config := func(id *Identity, peerID string) *tls.Config {
+		cfg, err := id.TLSConfig(peerID)
+		if err != nil {
+			t.Fatalf("创建 TLS 配置失败: %v", err)
+		}
+		return cfg
+	}
+
+	if clientErr, serverErr := handshake(config(a, "node-b"), config(b, "node-a")); clientErr != nil || serverErr != nil {
+		t.Fatalf("双方证书有效时握手应成功: %v %v", clientErr, serverErr)
+	}
+
+	// 对端证书的通用名与期望的节点不符
+	if clientErr, _ := handshake(config(a, "node-c"), config(b, "node-a")); clientErr == nil {
+		t.Fatal("冒充其他节点的证书不应通过验证")
+	}
+
+	// 其他 CA 签发的证书
+	other := enroll(t, newTestCA(t), "node-a", 1)
+	if _, serverErr := handshake(config(other, "node-b"), config(b, "node-a")); serverErr == nil {
+		t.Fatal("其他 CA 签发的证书不应通过验证")
+	}
+
+	dir := t.TempDir()
+	unenrolled, err := Load(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unenrolled.TLSConfig("node-b"); err != ErrNotEnrolled {
+		t.Fatalf("期望 ErrNotEnrolled，实际 %v", err)
+	}
+}
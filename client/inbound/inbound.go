@@ -0,0 +1,352 @@
+// Package inbound 检查转发监听器收到的入站连接。来源不在允许列表或不在允许的时间段内的连接
+// 被记录为事件，按来源地址打上标签后批量上报到服务端，用于发现对暴露端口的扫描和滥用
+package inbound
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/common/logger"
+)
+
+// 意外连接的原因
+const (
+	ReasonSource   = "source"   // 来源不在允许列表
+	ReasonSchedule = "schedule" // 不在允许的时间段内
+)
+
+// 来源地址的类别标签
+const (
+	TagLoopback  = "loopback"
+	TagLinkLocal = "link-local"
+	TagPrivate   = "private"
+	TagCGNAT     = "cgnat"
+	TagPublic    = "public"
+)
+
+// defaultReputationTag 信誉列表文件中未指定标签的地址使用的标签
+const defaultReputationTag = "blocklist"
+
+// maxPending 等待上报的事件数上限，超过时丢弃新来源的事件
+const maxPending = 1000
+
+// cgnat 运营商级 NAT 的共享地址段
+var cgnat = &net.IPNet{IP: net.IPv4(100, 64, 0, 0).To4(), Mask: net.CIDRMask(10, 32)}
+
+// Event 一个来源在上报周期内到应用的意外连接
+type Event struct {
+	App       string    `json:"app"`
+	Source    string    `json:"source"`
+	Reason    string    `json:"reason"`
+	Tags      []string  `json:"tags"`
+	Blocked   bool      `json:"blocked"`
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// Detail 事件的说明
+func (e Event) Detail() string {
+	reason := "不在允许列表"
+	if e.Reason == ReasonSchedule {
+		reason = "不在允许的时间段内"
+	}
+	detail := fmt.Sprintf("来源 %s %s，标签 %s，连接 %d 次", e.Source, reason, strings.Join(e.Tags, ","), e.Count)
+	if e.Blocked {
+		detail += "，已拒绝"
+	}
+	return detail
+}
+
+// Unexpected 检查来源和时间是否符合入站连接监控，符合时返回空字符串，否则返回原因
+func Unexpected(cfg *config.InboundConfig, ip net.IP, now time.Time) string {
+	if len(cfg.Allow) > 0 && !allowed(cfg.Allow, ip) {
+		return ReasonSource
+	}
+	if len(cfg.Schedule) > 0 && !inSchedule(cfg.Schedule, now) {
+		return ReasonSchedule
+	}
+	return ""
+}
+
+// allowed 检查来源是否在允许列表中，配置已验证，无效的项被忽略
+func allowed(sources []string, ip net.IP) bool {
+	for _, source := range sources {
+		if ipNet, err := config.ParseSource(source); err == nil && ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// inSchedule 检查时间是否在任一时间段内
+func inSchedule(windows []config.ScheduleWindow, now time.Time) bool {
+	clock := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	today := int(now.Weekday())
+	yesterday := (today + 6) % 7
+
+	for _, w := range windows {
+		start, err1 := config.ParseClock(w.Start)
+		end, err2 := config.ParseClock(w.End)
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		if start < end {
+			if appliesOn(w.Days, today) && clock >= start && clock < end {
+				return true
+			}
+			continue
+		}
+		// 跨越午夜的时间段，午夜之后的部分属于前一天的时间段
+		if appliesOn(w.Days, today) && clock >= start {
+			return true
+		}
+		if appliesOn(w.Days, yesterday) && clock < end {
+			return true
+		}
+	}
+	return false
+}
+
+// appliesOn 检查时间段是否适用于某一天
+func appliesOn(days []string, weekday int) bool {
+	if len(days) == 0 {
+		return true
+	}
+	for _, day := range days {
+		if config.WeekdayIndex(day) == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// Reputation 来源地址的信誉标签
+type Reputation struct {
+	nets []*net.IPNet
+	tags []string
+}
+
+// LoadReputation 加载配置中的信誉标签和信誉列表文件，file 为空时只使用配置
+func LoadReputation(entries []config.ReputationEntry, file string) (*Reputation, error) {
+	r := &Reputation{}
+	for _, entry := range entries {
+		if err := r.add(entry.CIDR, entry.Tag); err != nil {
+			return nil, err
+		}
+	}
+	if file == "" {
+		return r, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("打开来源地址信誉列表失败: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		tag := defaultReputationTag
+		if len(fields) > 1 && !strings.HasPrefix(fields[1], "#") {
+			tag = fields[1]
+		}
+		if err := r.add(fields[0], tag); err != nil {
+			return nil, fmt.Errorf("来源地址信誉列表第 %d 行: %w", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取来源地址信誉列表失败: %w", err)
+	}
+	return r, nil
+}
+
+// add 添加一个网段的标签
+func (r *Reputation) add(source, tag string) error {
+	ipNet, err := config.ParseSource(source)
+	if err != nil {
+		return err
+	}
+	r.nets = append(r.nets, ipNet)
+	r.tags = append(r.tags, tag)
+	return nil
+}
+
+// Tags 返回来源地址的类别标签和信誉列表中匹配的标签
+func (r *Reputation) Tags(ip net.IP) []string {
+	tags := []string{classify(ip)}
+	if r == nil {
+		return tags
+	}
+	for i, ipNet := range r.nets {
+		if ipNet.Contains(ip) && !contains(tags, r.tags[i]) {
+			tags = append(tags, r.tags[i])
+		}
+	}
+	return tags
+}
+
+// classify 按地址段区分来源的类别
+func classify(ip net.IP) string {
+	switch {
+	case ip.IsLoopback():
+		return TagLoopback
+	case ip.IsLinkLocalUnicast():
+		return TagLinkLocal
+	case ip.IsPrivate():
+		return TagPrivate
+	case cgnat.Contains(ip):
+		return TagCGNAT
+	}
+	return TagPublic
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// eventKey 同一应用、来源和原因的连接在上报前合并为一个事件
+type eventKey struct {
+	app, source, reason string
+}
+
+// Monitor 检查各应用的入站连接，记录意外的连接等待上报
+type Monitor struct {
+	reputation *Reputation
+	pending    map[eventKey]*Event
+	dropped    int
+	now        func() time.Time
+	mu         sync.Mutex
+}
+
+// NewMonitor 创建入站连接监控，reputation 为 nil 时只按地址段打标签
+func NewMonitor(reputation *Reputation) *Monitor {
+	return &Monitor{
+		reputation: reputation,
+		pending:    make(map[eventKey]*Event),
+		now:        time.Now,
+	}
+}
+
+// Check 检查应用收到的入站连接，意外的连接被记录，返回是否应拒绝该连接。
+// 同一来源在上报前的连接合并为一个事件，只在第一次时写入日志
+func (m *Monitor) Check(app string, cfg *config.InboundConfig, addr net.Addr) bool {
+	if m == nil || cfg == nil {
+		return false
+	}
+	ip := addrIP(addr)
+	if ip == nil {
+		return false
+	}
+	now := m.now()
+	reason := Unexpected(cfg, ip, now)
+	if reason == "" {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := eventKey{app, ip.String(), reason}
+	if event := m.pending[key]; event != nil {
+		event.Count++
+		event.LastSeen = now
+		return cfg.Block
+	}
+	if len(m.pending) >= maxPending {
+		m.dropped++
+		return cfg.Block
+	}
+
+	event := &Event{
+		App:       app,
+		Source:    key.source,
+		Reason:    reason,
+		Tags:      m.reputation.Tags(ip),
+		Blocked:   cfg.Block,
+		Count:     1,
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+	m.pending[key] = event
+	logger.Warn("应用 %s 收到意外的入站连接: %s", app, event.Detail())
+	return cfg.Block
+}
+
+// Take 按首次出现的时间取出最多 limit 个待上报的事件
+func (m *Monitor) Take(limit int) []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.dropped > 0 {
+		logger.Warn("等待上报的入站连接事件过多，丢弃了 %d 次连接的事件", m.dropped)
+		m.dropped = 0
+	}
+
+	events := make([]Event, 0, len(m.pending))
+	for _, event := range m.pending {
+		events = append(events, *event)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].FirstSeen.Before(events[j].FirstSeen) })
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	for _, event := range events {
+		delete(m.pending, eventKey{event.App, event.Source, event.Reason})
+	}
+	return events
+}
+
+// Restore 上报失败时放回事件，与之后记录的同一来源的事件合并
+func (m *Monitor) Restore(events []Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, event := range events {
+		key := eventKey{event.App, event.Source, event.Reason}
+		if pending := m.pending[key]; pending != nil {
+			pending.Count += event.Count
+			pending.FirstSeen = event.FirstSeen
+			continue
+		}
+		if len(m.pending) >= maxPending {
+			m.dropped += event.Count
+			continue
+		}
+		restored := event
+		m.pending[key] = &restored
+	}
+}
+
+// addrIP 返回地址中的 IP
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
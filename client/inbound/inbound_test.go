@@ -0,0 +1,130 @@
+package inbound
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+)
+
+func TestUnexpected(t *testing.T) {
+	cfg := &config.InboundConfig{
+		Allow: []string{"192.168.1.0/24", "10.0.0.5"},
+		Schedule: []config.ScheduleWindow{
+			{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "08:00", End: "18:00"},
+			{Days: []string{"sat"}, Start: "22:00", End: "02:00"},
+		},
+	}
+	// 2024-01-01 为星期一
+	monday := func(hour, minute int) time.Time { return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local) }
+
+	tests := []struct {
+		ip   string
+		now  time.Time
+		want string
+	}{
+		{"192.168.1.20", monday(9, 0), ""},
+		{"10.0.0.5", monday(17, 59), ""},
+		{"10.0.0.6", monday(9, 0), ReasonSource},
+		{"192.168.1.20", monday(18, 0), ReasonSchedule},
+		{"192.168.1.20", monday(7, 59), ReasonSchedule},
+		// 星期六晚上开始的时间段延续到星期日凌晨
+		{"192.168.1.20", monday(1, 0).AddDate(0, 0, 5).Add(22 * time.Hour), ""},
+		{"192.168.1.20", monday(1, 0).AddDate(0, 0, 6), ""},
+		{"192.168.1.20", monday(3, 0).AddDate(0, 0, 6), ReasonSchedule},
+		{"192.168.1.20", monday(1, 0).AddDate(0, 0, 5), ReasonSchedule},
+	}
+	for _, tt := range tests {
+		if got := Unexpected(cfg, net.ParseIP(tt.ip), tt.now); got != tt.want {
+			t.Errorf("%s %s: 应为 %q，实际 %q", tt.ip, tt.now.Format("Mon 15:04"), tt.want, got)
+		}
+	}
+
+	if got := Unexpected(&config.InboundConfig{}, net.ParseIP("203.0.113.1"), monday(3, 0)); got != "" {
+		t.Fatalf("未设置允许列表和时间段时不应限制: %q", got)
+	}
+}
+
+func TestReputation(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "blocklist.txt")
+	content := "# 已知扫描器\n198.51.100.0/24 scanner\n203.0.113.7\n"
+	if err := os.WriteFile(file, []byte(content), 0o600); err != nil {
+		t.Fatalf("写入信誉列表失败: %v", err)
+	}
+	r, err := LoadReputation([]config.ReputationEntry{{CIDR: "203.0.113.0/24", Tag: "partner"}}, file)
+	if err != nil {
+		t.Fatalf("加载信誉列表失败: %v", err)
+	}
+
+	tests := []struct {
+		ip   string
+		want []string
+	}{
+		{"127.0.0.1", []string{TagLoopback}},
+		{"192.168.1.2", []string{TagPrivate}},
+		{"100.64.1.2", []string{TagCGNAT}},
+		{"fe80::1", []string{TagLinkLocal}},
+		{"198.51.100.9", []string{TagPublic, "scanner"}},
+		{"203.0.113.7", []string{TagPublic, "partner", defaultReputationTag}},
+	}
+	for _, tt := range tests {
+		if got := r.Tags(net.ParseIP(tt.ip)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s 的标签应为 %v，实际 %v", tt.ip, tt.want, got)
+		}
+	}
+
+	if err := os.WriteFile(file, []byte("not-an-ip\n"), 0o600); err != nil {
+		t.Fatalf("写入信誉列表失败: %v", err)
+	}
+	if _, err := LoadReputation(nil, file); err == nil {
+		t.Fatal("无效的地址应返回错误")
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	m := NewMonitor(nil)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+	m.now = func() time.Time { return now }
+
+	watch := &config.InboundConfig{Allow: []string{"192.168.1.0/24"}}
+	block := &config.InboundConfig{Allow: []string{"192.168.1.0/24"}, Block: true}
+	scanner := &net.TCPAddr{IP: net.ParseIP("198.51.100.9"), Port: 40000}
+
+	if m.Check("rdp", watch, &net.TCPAddr{IP: net.ParseIP("192.168.1.5"), Port: 50000}) {
+		t.Fatal("允许的来源不应被拒绝")
+	}
+	if m.Check("rdp", nil, scanner) {
+		t.Fatal("未设置入站连接监控时不应拒绝")
+	}
+	for i := 0; i < 3; i++ {
+		if m.Check("rdp", watch, scanner) {
+			t.Fatal("未开启拒绝时只记录")
+		}
+		now = now.Add(time.Second)
+	}
+	if !m.Check("ssh", block, &net.UDPAddr{IP: net.ParseIP("198.51.100.9"), Port: 40000}) {
+		t.Fatal("开启拒绝时应拒绝意外的连接")
+	}
+
+	// 同一来源的连接合并为一个事件
+	events := m.Take(1)
+	if len(events) != 1 || events[0].App != "rdp" || events[0].Count != 3 || events[0].Blocked ||
+		!reflect.DeepEqual(events[0].Tags, []string{TagPublic}) || !events[0].LastSeen.After(events[0].FirstSeen) {
+		t.Fatalf("事件错误: %+v", events)
+	}
+
+	// 上报失败时放回，与之后的连接合并
+	m.Restore(events)
+	m.Check("rdp", watch, scanner)
+	events = m.Take(0)
+	if len(events) != 2 || events[0].App != "rdp" || events[0].Count != 4 || events[1].App != "ssh" || !events[1].Blocked {
+		t.Fatalf("放回后的事件错误: %+v", events)
+	}
+	if events := m.Take(0); len(events) != 0 {
+		t.Fatalf("取出后不应保留事件: %+v", events)
+	}
+}
@@ -0,0 +1,239 @@
+package nat
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/huin/goupnp/dcps/internetgateway1"
+	"github.com/huin/goupnp/dcps/internetgateway2"
+)
+
+// MappingPrefix 客户端创建的 UPnP 端口映射的描述前缀，描述格式为 "P3 <节点 ID> <用途>"
+const MappingPrefix = "P3 "
+
+// maxMappingEntries 列出网关端口映射时读取的最大条数，防止网关返回异常时无限读取
+const maxMappingEntries = 1024
+
+// legacyDescriptions 旧版本客户端创建的映射描述，不含节点 ID
+var legacyDescriptions = map[string]bool{
+	"P3 NAT Test":   true,
+	"P3 Connection": true,
+}
+
+// MappingPolicy 客户端创建 UPnP 端口映射的约束
+type MappingPolicy struct {
+	NodeID  string // 写入映射描述，区分同一网关下不同节点创建的映射
+	PortMin int    // 允许映射的外部端口范围
+	PortMax int
+}
+
+var (
+	policyMu      sync.RWMutex
+	mappingPolicy = MappingPolicy{PortMin: 10000, PortMax: 19999}
+)
+
+// SetMappingPolicy 设置创建 UPnP 端口映射的约束
+func SetMappingPolicy(policy MappingPolicy) {
+	policyMu.Lock()
+	defer policyMu.Unlock()
+	mappingPolicy = policy
+}
+
+// currentPolicy 获取当前的映射约束
+func currentPolicy() MappingPolicy {
+	policyMu.RLock()
+	defer policyMu.RUnlock()
+	return mappingPolicy
+}
+
+// Allowed 检查外部端口是否在允许的范围内
+func (p MappingPolicy) Allowed(port int) bool {
+	return port >= p.PortMin && port <= p.PortMax
+}
+
+// RandomPort 在允许的范围内随机选择一个端口
+func (p MappingPolicy) RandomPort() int {
+	return p.PortMin + rand.Intn(p.PortMax-p.PortMin+1)
+}
+
+// MappingRandomPort 在当前允许的范围内随机选择一个外部端口
+func MappingRandomPort() int {
+	return currentPolicy().RandomPort()
+}
+
+// description 生成带节点 ID 的映射描述
+func (p MappingPolicy) description(purpose string) string {
+	if p.NodeID == "" {
+		return MappingPrefix + purpose
+	}
+	return MappingPrefix + p.NodeID + " " + purpose
+}
+
+// PortMapping 网关上的端口映射
+type PortMapping struct {
+	ExternalPort   int    `json:"externalPort"`
+	InternalPort   int    `json:"internalPort"`
+	Protocol       string `json:"protocol"`
+	InternalClient string `json:"internalClient"`
+	Description    string `json:"description"`
+	LeaseDuration  int    `json:"leaseDuration"` // 剩余租期（秒），0 表示永久
+	// 从描述中解析的节点 ID 和用途，旧版本客户端创建的映射没有节点 ID
+	NodeID  string `json:"nodeId,omitempty"`
+	Purpose string `json:"purpose,omitempty"`
+}
+
+// IsP3 是否由客户端创建
+func (m *PortMapping) IsP3() bool {
+	return strings.HasPrefix(m.Description, MappingPrefix)
+}
+
+// parseDescription 从描述中解析节点 ID 和用途
+func (m *PortMapping) parseDescription() {
+	if !m.IsP3() {
+		return
+	}
+	if legacyDescriptions[m.Description] {
+		m.Purpose = strings.TrimPrefix(m.Description, MappingPrefix)
+		return
+	}
+	rest := strings.TrimPrefix(m.Description, MappingPrefix)
+	m.NodeID, m.Purpose, _ = strings.Cut(rest, " ")
+}
+
+// orphanedBy 是否为节点之前运行时遗留的映射：描述中的节点 ID 为本节点，
+// 或者是旧版本客户端创建、指向本机地址的映射
+func (m *PortMapping) orphanedBy(nodeID string, localIP net.IP) bool {
+	if !m.IsP3() {
+		return false
+	}
+	if m.NodeID != "" {
+		return m.NodeID == nodeID
+	}
+	return localIP != nil && net.ParseIP(m.InternalClient).Equal(localIP)
+}
+
+// gatewayClient 各版本 IGD 服务共有的端口映射操作
+type gatewayClient interface {
+	GetGenericPortMappingEntryCtx(ctx context.Context, index uint16) (string, uint16, string, uint16, string, bool, string, uint32, error)
+	DeletePortMappingCtx(ctx context.Context, remoteHost string, externalPort uint16, protocol string) error
+}
+
+// gatewayClients 发现网关上所有支持端口映射的服务
+func gatewayClients(ctx context.Context) []gatewayClient {
+	var clients []gatewayClient
+	if found, _, err := internetgateway2.NewWANIPConnection2ClientsCtx(ctx); err == nil {
+		for _, c := range found {
+			clients = append(clients, c)
+		}
+	}
+	if found, _, err := internetgateway2.NewWANIPConnection1ClientsCtx(ctx); err == nil {
+		for _, c := range found {
+			clients = append(clients, c)
+		}
+	}
+	if found, _, err := internetgateway2.NewWANPPPConnection1ClientsCtx(ctx); err == nil {
+		for _, c := range found {
+			clients = append(clients, c)
+		}
+	}
+	if len(clients) > 0 {
+		return clients
+	}
+
+	if found, _, err := internetgateway1.NewWANIPConnection1ClientsCtx(ctx); err == nil {
+		for _, c := range found {
+			clients = append(clients, c)
+		}
+	}
+	if found, _, err := internetgateway1.NewWANPPPConnection1ClientsCtx(ctx); err == nil {
+		for _, c := range found {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// listMappings 按序号读取服务上的所有端口映射，读到不存在的序号时结束
+func listMappings(ctx context.Context, client gatewayClient) []PortMapping {
+	var mappings []PortMapping
+	for i := 0; i < maxMappingEntries; i++ {
+		_, externalPort, protocol, internalPort, internalClient, _, description, lease, err := client.GetGenericPortMappingEntryCtx(ctx, uint16(i))
+		if err != nil {
+			break
+		}
+		m := PortMapping{
+			ExternalPort:   int(externalPort),
+			InternalPort:   int(internalPort),
+			Protocol:       protocol,
+			InternalClient: internalClient,
+			Description:    description,
+			LeaseDuration:  int(lease),
+		}
+		m.parseDescription()
+		mappings = append(mappings, m)
+	}
+	return mappings
+}
+
+// ListPortMappings 列出网关上的端口映射，all 为 false 时只列出客户端创建的映射
+func (c *UPnPClient) ListPortMappings(all bool) ([]PortMapping, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	clients := gatewayClients(ctx)
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("没有找到支持 UPnP 的网关")
+	}
+	return collectMappings(ctx, clients, all), nil
+}
+
+// collectMappings 列出各服务上的端口映射，all 为 false 时只列出客户端创建的映射
+func collectMappings(ctx context.Context, clients []gatewayClient, all bool) []PortMapping {
+	var mappings []PortMapping
+	for _, client := range clients {
+		for _, m := range listMappings(ctx, client) {
+			if all || m.IsP3() {
+				mappings = append(mappings, m)
+			}
+		}
+	}
+	return mappings
+}
+
+// CleanupOrphanedMappings 删除本节点之前运行时遗留的端口映射，返回删除的映射。
+// 应在启动时、创建新映射之前调用，此时本节点的映射都不再使用；其他节点创建的映射不受影响
+func (c *UPnPClient) CleanupOrphanedMappings(nodeID string) ([]PortMapping, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	clients := gatewayClients(ctx)
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("没有找到支持 UPnP 的网关")
+	}
+	localIP, _ := getLocalIP()
+	return cleanupMappings(ctx, clients, nodeID, localIP)
+}
+
+// cleanupMappings 删除各服务上属于本节点的映射，localIP 用于识别旧版本客户端创建的映射
+func cleanupMappings(ctx context.Context, clients []gatewayClient, nodeID string, localIP net.IP) ([]PortMapping, error) {
+	var removed []PortMapping
+	var lastErr error
+	for _, client := range clients {
+		// 先读取全部映射再删除，删除会改变其余映射的序号
+		for _, m := range listMappings(ctx, client) {
+			if !m.orphanedBy(nodeID, localIP) {
+				continue
+			}
+			if err := client.DeletePortMappingCtx(ctx, "", uint16(m.ExternalPort), m.Protocol); err != nil {
+				lastErr = fmt.Errorf("删除端口映射 %s/%d 失败: %w", m.Protocol, m.ExternalPort, err)
+				continue
+			}
+			removed = append(removed, m)
+		}
+	}
+	return removed, lastErr
+}
@@ -0,0 +1,183 @@
+package nat
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// fakeIGD 模拟网关的端口映射表，删除映射后其余映射的序号前移
+type fakeIGD struct {
+	mappings   []PortMapping
+	failDelete map[int]bool
+	endless    bool // 任意序号都返回映射，模拟异常的网关
+}
+
+func (g *fakeIGD) GetGenericPortMappingEntryCtx(ctx context.Context, index uint16) (string, uint16, string, uint16, string, bool, string, uint32, error) {
+	if g.endless {
+		return "", 10000, "UDP", 10000, "192.168.1.10", true, "P3 node-a test", 0, nil
+	}
+	if int(index) >= len(g.mappings) {
+		return "", 0, "", 0, "", false, "", 0, errors.New("SpecifiedArrayIndexInvalid")
+	}
+	m := g.mappings[index]
+	return "", uint16(m.ExternalPort), m.Protocol, uint16(m.InternalPort), m.InternalClient, true, m.Description, uint32(m.LeaseDuration), nil
+}
+
+func (g *fakeIGD) DeletePortMappingCtx(ctx context.Context, remoteHost string, externalPort uint16, protocol string) error {
+	if g.failDelete[int(externalPort)] {
+		return errors.New("ActionFailed")
+	}
+	for i, m := range g.mappings {
+		if m.ExternalPort == int(externalPort) && m.Protocol == protocol {
+			g.mappings = append(g.mappings[:i], g.mappings[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("NoSuchEntryInArray")
+}
+
+// ports 返回剩余映射的外部端口
+func (g *fakeIGD) ports() []int {
+	ports := make([]int, 0, len(g.mappings))
+	for _, m := range g.mappings {
+		ports = append(ports, m.ExternalPort)
+	}
+	return ports
+}
+
+// newFakeIGD 创建包含本节点、其他节点、旧版本客户端和其他程序映射的网关
+func newFakeIGD() *fakeIGD {
+	return &fakeIGD{mappings: []PortMapping{
+		{ExternalPort: 10001, Protocol: "UDP", InternalClient: "192.168.1.10", Description: "P3 node-a NAT Test"},
+		{ExternalPort: 10002, Protocol: "UDP", InternalClient: "192.168.1.11", Description: "P3 node-b NAT Test"},
+		{ExternalPort: 10003, Protocol: "TCP", InternalClient: "192.168.1.10", Description: "P3 node-a Connection"},
+		{ExternalPort: 10004, Protocol: "UDP", InternalClient: "192.168.1.10", Description: "P3 Connection"},
+		{ExternalPort: 10005, Protocol: "UDP", InternalClient: "192.168.1.11", Description: "P3 NAT Test"},
+		{ExternalPort: 3074, Protocol: "UDP", InternalClient: "192.168.1.10", Description: "Xbox"},
+	}}
+}
+
+func TestMappingDescription(t *testing.T) {
+	policy := MappingPolicy{NodeID: "node-a"}
+	if got := policy.description("NAT Test"); got != "P3 node-a NAT Test" {
+		t.Fatalf("映射描述为 %q", got)
+	}
+	if got := (MappingPolicy{}).description("NAT Test"); got != "P3 NAT Test" {
+		t.Fatalf("没有节点 ID 时的映射描述为 %q", got)
+	}
+
+	tests := []struct {
+		description string
+		p3          bool
+		nodeID      string
+		purpose     string
+	}{
+		{"P3 node-a NAT Test", true, "node-a", "NAT Test"},
+		{"P3 node-a Connection", true, "node-a", "Connection"},
+		{"P3 node-a", true, "node-a", ""},
+		{"P3 NAT Test", true, "", "NAT Test"},
+		{"P3 Connection", true, "", "Connection"},
+		{"Xbox", false, "", ""},
+		{"P3P", false, "", ""},
+	}
+	for _, tt := range tests {
+		m := PortMapping{Description: tt.description}
+		m.parseDescription()
+		if m.IsP3() != tt.p3 || m.NodeID != tt.nodeID || m.Purpose != tt.purpose {
+			t.Errorf("解析 %q 得到 P3=%t 节点=%q 用途=%q", tt.description, m.IsP3(), m.NodeID, m.Purpose)
+		}
+	}
+}
+
+func TestCollectMappings(t *testing.T) {
+	ctx := context.Background()
+	igd := newFakeIGD()
+
+	mappings := collectMappings(ctx, []gatewayClient{igd}, false)
+	if len(mappings) != 5 {
+		t.Fatalf("应只列出客户端创建的 5 个映射: %+v", mappings)
+	}
+	if mappings[1].NodeID != "node-b" || mappings[1].Purpose != "NAT Test" || mappings[1].InternalClient != "192.168.1.11" {
+		t.Fatalf("映射信息不正确: %+v", mappings[1])
+	}
+	if mappings := collectMappings(ctx, []gatewayClient{igd}, true); len(mappings) != 6 {
+		t.Fatalf("应列出全部 6 个映射: %d", len(mappings))
+	}
+
+	// 网关异常时最多读取 maxMappingEntries 条
+	if mappings := collectMappings(ctx, []gatewayClient{&fakeIGD{endless: true}}, true); len(mappings) != maxMappingEntries {
+		t.Fatalf("应最多读取 %d 个映射: %d", maxMappingEntries, len(mappings))
+	}
+}
+
+func TestCleanupMappings(t *testing.T) {
+	ctx := context.Background()
+	localIP := net.ParseIP("192.168.1.10")
+
+	// 只删除本节点的映射和旧版本客户端指向本机的映射，其他节点和其他程序的映射不受影响
+	igd := newFakeIGD()
+	other := newFakeIGD()
+	removed, err := cleanupMappings(ctx, []gatewayClient{igd, other}, "node-a", localIP)
+	if err != nil {
+		t.Fatalf("清理映射失败: %v", err)
+	}
+	if len(removed) != 6 {
+		t.Fatalf("应删除两个网关上各 3 个映射: %+v", removed)
+	}
+	for _, g := range []*fakeIGD{igd, other} {
+		if got := g.ports(); len(got) != 3 || got[0] != 10002 || got[1] != 10005 || got[2] != 3074 {
+			t.Fatalf("剩余的映射为 %v", got)
+		}
+	}
+
+	// 再次清理不会删除其他节点的映射
+	if removed, err := cleanupMappings(ctx, []gatewayClient{igd}, "node-a", localIP); err != nil || len(removed) != 0 {
+		t.Fatalf("不应再删除映射: %+v %v", removed, err)
+	}
+
+	// 本机地址未知时不删除旧版本客户端的映射
+	igd = newFakeIGD()
+	if removed, _ := cleanupMappings(ctx, []gatewayClient{igd}, "node-a", nil); len(removed) != 2 {
+		t.Fatalf("本机地址未知时应只删除带节点 ID 的映射: %+v", removed)
+	}
+
+	// 删除失败时返回错误，继续删除其他映射
+	igd = newFakeIGD()
+	igd.failDelete = map[int]bool{10001: true}
+	removed, err = cleanupMappings(ctx, []gatewayClient{igd}, "node-a", localIP)
+	if err == nil || !strings.Contains(err.Error(), "UDP/10001") {
+		t.Fatalf("删除失败时应返回错误: %v", err)
+	}
+	if len(removed) != 2 || removed[0].ExternalPort != 10003 || removed[1].ExternalPort != 10004 {
+		t.Fatalf("应继续删除其他映射: %+v", removed)
+	}
+}
+
+func TestMappingPolicyRange(t *testing.T) {
+	defer SetMappingPolicy(currentPolicy())
+
+	policy := MappingPolicy{NodeID: "node-a", PortMin: 20000, PortMax: 20009}
+	for port, want := range map[int]bool{19999: false, 20000: true, 20009: true, 20010: false} {
+		if policy.Allowed(port) != want {
+			t.Errorf("端口 %d 是否允许映射应为 %t", port, want)
+		}
+	}
+
+	SetMappingPolicy(policy)
+	for i := 0; i < 1000; i++ {
+		if port := MappingRandomPort(); !policy.Allowed(port) {
+			t.Fatalf("随机端口 %d 超出允许的范围", port)
+		}
+	}
+	if port := (MappingPolicy{PortMin: 30000, PortMax: 30000}).RandomPort(); port != 30000 {
+		t.Fatalf("范围只有一个端口时应返回该端口: %d", port)
+	}
+
+	// 范围外的端口在访问网关前即被拒绝
+	if ok, err := UPnPMapping(19999, "UDP", "NAT Test"); ok || err == nil || !strings.Contains(err.Error(), "20000-20009") {
+		t.Fatalf("范围外的端口应被拒绝: %t %v", ok, err)
+	}
+}
@@ -4,41 +4,13 @@ import (
 	"fmt"
 	"net"
 	"time"
-)
-
-// NATType 表示 NAT 类型
-type NATType int
 
-const (
-	NATUnknown        NATType = iota
-	NATNone                   // 无 NAT（公网 IP）
-	NATFull                   // 完全锥形 NAT（Full Cone）
-	NATRestricted             // 受限锥形 NAT（Restricted Cone）
-	NATPortRestricted         // 端口受限锥形 NAT（Port Restricted Cone）
-	NATSymmetric              // 对称型 NAT（Symmetric）
+	"github.com/senma231/p3/common/protocol"
 )
 
-// String 返回 NAT 类型的字符串表示
-func (t NATType) String() string {
-	switch t {
-	case NATNone:
-		return "No NAT (Public IP)"
-	case NATFull:
-		return "Full Cone NAT"
-	case NATRestricted:
-		return "Restricted Cone NAT"
-	case NATPortRestricted:
-		return "Port Restricted Cone NAT"
-	case NATSymmetric:
-		return "Symmetric NAT"
-	default:
-		return "Unknown NAT Type"
-	}
-}
-
 // NATInfo 存储 NAT 相关信息
 type NATInfo struct {
-	Type          NATType
+	Type          protocol.NATType
 	ExternalIP    net.IP
 	ExternalPort  int
 	LocalIP       net.IP
@@ -50,6 +22,8 @@ type NATInfo struct {
 type Detector struct {
 	STUNServers []string
 	Timeout     time.Duration
+	// Pool 不为空时按 STUN 服务器池的健康状态选择服务器，忽略 STUNServers
+	Pool *STUNPool
 }
 
 // NewDetector 创建一个新的 NAT 类型检测器
@@ -79,6 +53,7 @@ func NewDetector(stunServers []string, timeout time.Duration) *Detector {
 func (d *Detector) Detect() (*NATInfo, error) {
 	// 创建 STUN 客户端
 	stunClient := NewSTUNClient(d.STUNServers, d.Timeout)
+	stunClient.pool = d.Pool
 
 	// 检测 NAT 类型
 	natType, err := stunClient.DetectNATType()
@@ -100,13 +75,14 @@ func (d *Detector) Detect() (*NATInfo, error) {
 
 	// 检测是否支持 UPnP
 	upnpAvailable := false
-	if natType != NATNone {
-		// 尝试映射一个测试端口
-		available, _ := UPnPMapping(12345, "UDP", "P3 NAT Test")
+	if natType != protocol.NATNone {
+		// 在允许的范围内映射一个测试端口
+		testPort := MappingRandomPort()
+		available, _ := UPnPMapping(testPort, "UDP", "NAT Test")
 		upnpAvailable = available
 		// 如果成功映射，删除映射
 		if upnpAvailable {
-			_ = UPnPRemoveMapping(12345, "UDP")
+			_ = UPnPRemoveMapping(testPort, "UDP")
 		}
 	}
 
@@ -120,8 +96,14 @@ func (d *Detector) Detect() (*NATInfo, error) {
 	}, nil
 }
 
-// UPnPMapping 尝试通过 UPnP 映射端口
-func UPnPMapping(port int, protocol string, description string) (bool, error) {
+// UPnPMapping 尝试通过 UPnP 映射端口，purpose 为映射用途，
+// 映射描述中会带上节点 ID，端口必须在 SetMappingPolicy 设置的范围内
+func UPnPMapping(port int, protocol string, purpose string) (bool, error) {
+	policy := currentPolicy()
+	if !policy.Allowed(port) {
+		return false, fmt.Errorf("端口 %d 不在允许映射的范围 %d-%d 内", port, policy.PortMin, policy.PortMax)
+	}
+
 	// 创建 UPnP 客户端
 	upnpClient := NewUPnPClient(5 * time.Second)
 
@@ -131,7 +113,7 @@ func UPnPMapping(port int, protocol string, description string) (bool, error) {
 	}
 
 	// 添加端口映射
-	success, _, err := upnpClient.AddPortMapping(port, port, protocol, description)
+	success, _, err := upnpClient.AddPortMapping(port, port, protocol, policy.description(purpose))
 	if err != nil {
 		return false, fmt.Errorf("添加端口映射失败: %w", err)
 	}
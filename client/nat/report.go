@@ -0,0 +1,395 @@
+package nat
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+// ReportVersion NAT 测试报告的格式版本，字段不兼容地变化时递增
+const ReportVersion = 1
+
+// NAT 的映射行为
+const (
+	MappingNone                = "none"                 // 没有 NAT，映射地址就是本机地址
+	MappingEndpointIndependent = "endpoint-independent" // 发往不同目标时映射地址相同
+	MappingEndpointDependent   = "endpoint-dependent"   // 发往不同目标时映射地址不同，即对称型 NAT
+	MappingUnknown             = "unknown"              // 成功的探测不足两个，无法判断
+)
+
+// NAT 的过滤行为
+const (
+	FilteringPortIndependent = "port-independent" // 收到了服务端从备用端口发送的回复
+	FilteringPortDependent   = "port-dependent"   // 只收到主端口的回复，入站数据按端口过滤
+	FilteringUnknown         = "unknown"          // 没有进行回显探测或主端口也没有回复
+)
+
+// Report NAT 穿透测试报告，由 p3-client nat-report 生成，统一技术支持收集的排查数据
+type Report struct {
+	Version       int          `json:"version"`
+	GeneratedAt   time.Time    `json:"generatedAt"`
+	NodeID        string       `json:"nodeId,omitempty"`
+	ClientVersion string       `json:"clientVersion,omitempty"`
+	OS            string       `json:"os,omitempty"`
+	Arch          string       `json:"arch,omitempty"`
+	LocalAddress  string       `json:"localAddress"` // 所有探测共用的本地套接字地址
+	NATType       string       `json:"natType"`
+	Mapping       string       `json:"mapping"`
+	Filtering     string       `json:"filtering"`
+	STUN          []STUNResult `json:"stun"`
+	Probe         *ProbeResult `json:"probe,omitempty"`
+	TCPProbe      *ProbeResult `json:"tcpProbe,omitempty"`
+	UPnP          *UPnPResult  `json:"upnp,omitempty"`
+}
+
+// STUNResult 单个 STUN 服务器的探测结果
+type STUNResult struct {
+	Server  string `json:"server"`
+	Address string `json:"address,omitempty"` // 服务器看到的映射地址
+	RTT     int64  `json:"rttMs,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ProbeResult 服务端回显探测的结果
+type ProbeResult struct {
+	Server   string `json:"server"`
+	Address  string `json:"address,omitempty"` // 服务端看到的映射地址
+	RTT      int64  `json:"rttMs,omitempty"`
+	AltReply bool   `json:"altReply"` // 是否收到备用端口的回复，TCP 探测始终为 false
+	Error    string `json:"error,omitempty"`
+}
+
+// UPnPResult UPnP 的检测结果
+type UPnPResult struct {
+	Available  bool     `json:"available"`
+	Gateways   []string `json:"gateways,omitempty"`
+	ExternalIP string   `json:"externalIp,omitempty"`
+	Error      string   `json:"error,omitempty"`
+}
+
+// ReportOptions 生成报告的参数
+type ReportOptions struct {
+	STUNServers []string
+	Timeout     time.Duration // 每个探测的超时
+	Probe       string        // 服务端回显探测服务的地址，为空时不进行回显探测
+	TCPProbe    string        // 服务端 TCP 回显探测服务的地址，为空时不检查 TCP 出站连通性
+	UPnP        bool          // 是否检测 UPnP
+}
+
+// BuildReport 使用同一个本地套接字依次探测所有 STUN 服务器和服务端回显探测服务，
+// 比较各目标看到的映射地址判断映射行为，按能否收到备用端口的回复判断过滤行为
+func BuildReport(opts ReportOptions) (*Report, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 3 * time.Second
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, fmt.Errorf("创建 UDP 套接字失败: %w", err)
+	}
+	defer conn.Close()
+
+	report := &Report{
+		Version:      ReportVersion,
+		GeneratedAt:  time.Now().UTC(),
+		LocalAddress: conn.LocalAddr().String(),
+		STUN:         []STUNResult{},
+	}
+
+	var mapped []string
+	for _, server := range NewSTUNClient(opts.STUNServers, opts.Timeout).Servers {
+		result := STUNResult{Server: server}
+		start := time.Now()
+		if address, err := stunBinding(conn, server, opts.Timeout); err != nil {
+			result.Error = err.Error()
+		} else {
+			result.Address = address
+			result.RTT = time.Since(start).Milliseconds()
+			mapped = append(mapped, address)
+		}
+		report.STUN = append(report.STUN, result)
+	}
+
+	report.Filtering = FilteringUnknown
+	if opts.Probe != "" {
+		report.Probe = echoProbe(conn, opts.Probe, opts.Timeout)
+		if report.Probe.Error == "" {
+			mapped = append(mapped, report.Probe.Address)
+			report.Filtering = FilteringPortDependent
+			if report.Probe.AltReply {
+				report.Filtering = FilteringPortIndependent
+			}
+		}
+	}
+
+	if opts.TCPProbe != "" {
+		report.TCPProbe = tcpProbe(opts.TCPProbe, opts.Timeout)
+	}
+
+	report.Mapping = classifyMapping(mapped, localIPs())
+	report.NATType = reportNATType(report.Mapping, report.Filtering).String()
+
+	if opts.UPnP {
+		report.UPnP = detectUPnP(opts.Timeout)
+	}
+	return report, nil
+}
+
+// stunBinding 通过已有的套接字向 STUN 服务器发送绑定请求，返回映射地址
+func stunBinding(conn *net.UDPConn, server string, timeout time.Duration) (string, error) {
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return "", fmt.Errorf("解析 STUN 服务器地址失败: %w", err)
+	}
+	req, err := NewSTUNRequest()
+	if err != nil {
+		return "", fmt.Errorf("创建 STUN 请求失败: %w", err)
+	}
+	data, err := req.Marshal()
+	if err != nil {
+		return "", fmt.Errorf("序列化 STUN 请求失败: %w", err)
+	}
+	if _, err := conn.WriteToUDP(data, serverAddr); err != nil {
+		return "", fmt.Errorf("发送 STUN 请求失败: %w", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	buffer := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			return "", fmt.Errorf("接收 STUN 响应失败: %w", err)
+		}
+		// 忽略之前超时的探测迟到的响应
+		resp := &STUNMessage{}
+		if resp.Unmarshal(buffer[:n]) != nil || resp.Type != stunBindingResponse || !bytes.Equal(resp.TransID[:], req.TransID[:]) {
+			continue
+		}
+		ip, port, err := resp.GetXorMappedAddress()
+		if err != nil {
+			return "", fmt.Errorf("获取外部地址失败: %w", err)
+		}
+		return net.JoinHostPort(ip.String(), fmt.Sprint(port)), nil
+	}
+}
+
+// echoProbe 向服务端回显探测服务发送要求备用端口回复的请求，在超时前收集主端口和备用端口的回复
+func echoProbe(conn *net.UDPConn, server string, timeout time.Duration) *ProbeResult {
+	result := &ProbeResult{Server: server}
+	serverAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		result.Error = fmt.Sprintf("解析回显探测服务地址失败: %v", err)
+		return result
+	}
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	req := &protocol.ProbeRequest{ID: hex.EncodeToString(id), Alt: true}
+	data, err := protocol.MarshalProbeRequest(req)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	start := time.Now()
+	if _, err := conn.WriteToUDP(data, serverAddr); err != nil {
+		result.Error = fmt.Sprintf("发送探测请求失败: %v", err)
+		return result
+	}
+
+	// 收到主端口的回复后再等待备用端口的回复，最多等到超时
+	conn.SetReadDeadline(start.Add(timeout))
+	defer conn.SetReadDeadline(time.Time{})
+	buffer := make([]byte, 1500)
+	for result.Address == "" || !result.AltReply {
+		n, from, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			var netErr net.Error
+			if result.Address == "" || !errors.As(err, &netErr) || !netErr.Timeout() {
+				result.Error = fmt.Sprintf("接收探测响应失败: %v", err)
+			}
+			break
+		}
+		var resp protocol.ProbeResponse
+		if json.Unmarshal(buffer[:n], &resp) != nil || resp.ID != req.ID || !from.IP.Equal(serverAddr.IP) {
+			continue
+		}
+		if from.Port == serverAddr.Port {
+			result.Address = resp.Address
+			result.RTT = time.Since(start).Milliseconds()
+		} else {
+			result.AltReply = true
+		}
+	}
+	if result.Error != "" {
+		result.Address = ""
+		result.RTT = 0
+	}
+	return result
+}
+
+// tcpProbe 连接服务端的 TCP 回显探测服务，检查 TCP 出站连通性并测量往返延迟
+func tcpProbe(server string, timeout time.Duration) *ProbeResult {
+	result := &ProbeResult{Server: server}
+	conn, err := net.DialTimeout("tcp", server, timeout)
+	if err != nil {
+		result.Error = fmt.Sprintf("连接回显探测服务失败: %v", err)
+		return result
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	id := make([]byte, 8)
+	rand.Read(id)
+	data, _ := json.Marshal(&protocol.ProbeRequest{ID: hex.EncodeToString(id)})
+	start := time.Now()
+	if _, err := conn.Write(append(data, '\n')); err != nil {
+		result.Error = fmt.Sprintf("发送探测请求失败: %v", err)
+		return result
+	}
+	var resp protocol.ProbeResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		result.Error = fmt.Sprintf("接收探测响应失败: %v", err)
+		return result
+	}
+	if resp.ID != hex.EncodeToString(id) {
+		result.Error = "探测响应的 ID 不匹配"
+		return result
+	}
+	result.Address = resp.Address
+	result.RTT = time.Since(start).Milliseconds()
+	return result
+}
+
+// classifyMapping 按各目标看到的映射地址判断映射行为，local 为本机的 IP 地址
+func classifyMapping(mapped []string, local map[string]bool) string {
+	if len(mapped) == 0 {
+		return MappingUnknown
+	}
+	if host, _, err := net.SplitHostPort(mapped[0]); err == nil && local[host] {
+		return MappingNone
+	}
+	if len(mapped) < 2 {
+		return MappingUnknown
+	}
+	for _, address := range mapped[1:] {
+		if address != mapped[0] {
+			return MappingEndpointDependent
+		}
+	}
+	return MappingEndpointIndependent
+}
+
+// reportNATType 按映射和过滤行为推断 NAT 类型。只有一个服务端 IP 时无法区分完全锥形和受限锥形，
+// 收到备用端口回复时按受限锥形处理；过滤行为未知时与 DetectNATType 一样按端口受限锥形处理
+func reportNATType(mapping, filtering string) protocol.NATType {
+	switch mapping {
+	case MappingNone:
+		return protocol.NATNone
+	case MappingEndpointDependent:
+		return protocol.NATSymmetric
+	case MappingEndpointIndependent:
+		if filtering == FilteringPortIndependent {
+			return protocol.NATRestricted
+		}
+		return protocol.NATPortRestricted
+	default:
+		return protocol.NATUnknown
+	}
+}
+
+// localIPs 返回本机网卡上的所有 IP 地址
+func localIPs() map[string]bool {
+	ips := make(map[string]bool)
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return ips
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			ips[ipNet.IP.String()] = true
+		}
+	}
+	return ips
+}
+
+// detectUPnP 检测网关是否支持 UPnP，只查询不添加映射
+func detectUPnP(timeout time.Duration) *UPnPResult {
+	client := NewUPnPClient(timeout)
+	result := &UPnPResult{}
+	gateways, err := client.DiscoverGateways()
+	if err != nil {
+		result.Error = fmt.Sprintf("发现网关失败: %v", err)
+	}
+	result.Gateways = gateways
+
+	if result.Available = client.IsUPnPAvailable(); result.Available {
+		externalIP, err := client.GetExternalIP()
+		if err != nil {
+			result.Error = err.Error()
+		}
+		result.ExternalIP = externalIP
+	}
+	return result
+}
+
+// Summary 返回报告的可读摘要，导入报告时显示
+func (r *Report) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "生成时间: %s\n", r.GeneratedAt.Local().Format("2006-01-02 15:04:05"))
+	if r.NodeID != "" {
+		fmt.Fprintf(&b, "节点: %s\n", r.NodeID)
+	}
+	if r.ClientVersion != "" {
+		fmt.Fprintf(&b, "客户端: %s (%s/%s)\n", r.ClientVersion, r.OS, r.Arch)
+	}
+	fmt.Fprintf(&b, "本地地址: %s\n", r.LocalAddress)
+	fmt.Fprintf(&b, "NAT 类型: %s，映射: %s，过滤: %s\n", r.NATType, r.Mapping, r.Filtering)
+
+	b.WriteString("STUN:\n")
+	for _, result := range r.STUN {
+		if result.Error != "" {
+			fmt.Fprintf(&b, "  %-32s 失败: %s\n", result.Server, result.Error)
+			continue
+		}
+		fmt.Fprintf(&b, "  %-32s %s (%d ms)\n", result.Server, result.Address, result.RTT)
+	}
+
+	if p := r.Probe; p != nil {
+		if p.Error != "" {
+			fmt.Fprintf(&b, "回显探测: %s 失败: %s\n", p.Server, p.Error)
+		} else {
+			fmt.Fprintf(&b, "回显探测: %s %s (%d ms)，备用端口回复: %t\n", p.Server, p.Address, p.RTT, p.AltReply)
+		}
+	}
+
+	if p := r.TCPProbe; p != nil {
+		if p.Error != "" {
+			fmt.Fprintf(&b, "TCP 回显探测: %s 失败: %s\n", p.Server, p.Error)
+		} else {
+			fmt.Fprintf(&b, "TCP 回显探测: %s %s (%d ms)\n", p.Server, p.Address, p.RTT)
+		}
+	}
+
+	if u := r.UPnP; u != nil {
+		fmt.Fprintf(&b, "UPnP: 可用 %t", u.Available)
+		if u.ExternalIP != "" {
+			fmt.Fprintf(&b, "，外部 IP %s", u.ExternalIP)
+		}
+		if len(u.Gateways) > 0 {
+			fmt.Fprintf(&b, "，网关 %s", strings.Join(u.Gateways, ", "))
+		}
+		if u.Error != "" {
+			fmt.Fprintf(&b, "，%s", u.Error)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,129 @@
+package nat
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+// startEchoServer 启动回显探测服务，alt 为 true 时同时从备用端口回复
+func startEchoServer(t *testing.T, alt bool) string {
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatalf("监听 UDP 失败: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	conn, altConn := listen(), listen()
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			var req protocol.ProbeRequest
+			if n < protocol.ProbeMinRequestSize || json.Unmarshal(buf[:n], &req) != nil {
+				continue
+			}
+			data, _ := json.Marshal(&protocol.ProbeResponse{ID: req.ID, Address: addr.String()})
+			conn.WriteToUDP(data, addr)
+			if alt && req.Alt {
+				altConn.WriteToUDP(data, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+func TestBuildReport(t *testing.T) {
+	servers := []string{startSTUNServer(t), deadSTUNServer(t), startSTUNServer(t)}
+	report, err := BuildReport(ReportOptions{
+		STUNServers: servers,
+		Timeout:     300 * time.Millisecond,
+		Probe:       startEchoServer(t, true),
+		TCPProbe:    startTCPEchoServer(t),
+	})
+	if err != nil {
+		t.Fatalf("生成报告失败: %v", err)
+	}
+
+	if len(report.STUN) != 3 || report.STUN[0].Address == "" || report.STUN[1].Error == "" || report.STUN[2].Address == "" {
+		t.Fatalf("STUN 结果 = %+v", report.STUN)
+	}
+	// 同一个套接字发往不同目标，映射地址相同
+	if report.STUN[0].Address != report.STUN[2].Address || report.Probe.Address != report.STUN[0].Address {
+		t.Fatalf("映射地址不一致: %+v, %+v", report.STUN, report.Probe)
+	}
+	if !report.Probe.AltReply || report.Filtering != FilteringPortIndependent {
+		t.Fatalf("回显探测结果 = %+v，过滤行为 %s", report.Probe, report.Filtering)
+	}
+	// 本机回环地址没有经过 NAT
+	if report.Mapping != MappingNone || report.NATType != protocol.NATNone.String() {
+		t.Fatalf("映射行为 = %s，NAT 类型 %s", report.Mapping, report.NATType)
+	}
+	if report.TCPProbe == nil || report.TCPProbe.Error != "" || report.TCPProbe.Address == "" {
+		t.Fatalf("TCP 回显探测结果 = %+v", report.TCPProbe)
+	}
+	if report.UPnP != nil {
+		t.Fatal("未要求时不应检测 UPnP")
+	}
+
+	// 报告导出再导入后摘要相同
+	data, err := json.Marshal(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var loaded Report
+	if err := json.Unmarshal(data, &loaded); err != nil {
+		t.Fatalf("导入报告失败: %v", err)
+	}
+	if loaded.Summary() != report.Summary() || !strings.Contains(report.Summary(), "备用端口回复: true") {
+		t.Fatalf("报告摘要不一致:\n%s\n%s", report.Summary(), loaded.Summary())
+	}
+
+	report, err = BuildReport(ReportOptions{
+		STUNServers: servers[:1],
+		Timeout:     300 * time.Millisecond,
+		Probe:       startEchoServer(t, false),
+	})
+	if err != nil {
+		t.Fatalf("生成报告失败: %v", err)
+	}
+	if report.Probe.Error != "" || report.Probe.AltReply || report.Filtering != FilteringPortDependent {
+		t.Fatalf("没有备用端口回复时 = %+v，过滤行为 %s", report.Probe, report.Filtering)
+	}
+}
+
+func TestClassifyMapping(t *testing.T) {
+	local := map[string]bool{"192.168.1.2": true}
+	tests := []struct {
+		mapped []string
+		want   string
+	}{
+		{nil, MappingUnknown},
+		{[]string{"203.0.113.1:4000"}, MappingUnknown},
+		{[]string{"192.168.1.2:4000"}, MappingNone},
+		{[]string{"203.0.113.1:4000", "203.0.113.1:4000"}, MappingEndpointIndependent},
+		{[]string{"203.0.113.1:4000", "203.0.113.1:4001"}, MappingEndpointDependent},
+	}
+	for _, tt := range tests {
+		if got := classifyMapping(tt.mapped, local); got != tt.want {
+			t.Errorf("classifyMapping(%v) = %s，期望 %s", tt.mapped, got, tt.want)
+		}
+	}
+
+	if got := reportNATType(MappingEndpointDependent, FilteringPortIndependent); got != protocol.NATSymmetric {
+		t.Errorf("映射与目标相关时应为对称型 NAT，得到 %s", got)
+	}
+	if got := reportNATType(MappingEndpointIndependent, FilteringUnknown); got != protocol.NATPortRestricted {
+		t.Errorf("过滤行为未知时应为端口受限锥形 NAT，得到 %s", got)
+	}
+}
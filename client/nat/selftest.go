@@ -0,0 +1,93 @@
+package nat
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+// SelfTestResult 连通性自检的结果，随心跳上报
+type SelfTestResult struct {
+	TestedAt     time.Time `json:"testedAt"`
+	UDPReachable bool      `json:"udpReachable"`
+	TCPReachable bool      `json:"tcpReachable"`
+	UDPLatency   int64     `json:"udpLatency"` // 到服务端的 UDP 往返延迟，单位：毫秒
+	TCPLatency   int64     `json:"tcpLatency"` // 到服务端的 TCP 往返延迟，单位：毫秒
+	// 比较 STUN 服务器和回显探测服务看到的映射地址得出的映射行为
+	Mapping string `json:"mapping"`
+	// 映射行为与客户端检测到的 NAT 类型不一致，由调用方按 MatchesNATType 设置
+	NATMismatch bool   `json:"natMismatch"`
+	UDPError    string `json:"-"`
+	TCPError    string `json:"-"`
+}
+
+// SelfTestOptions 连通性自检的参数
+type SelfTestOptions struct {
+	STUNServers []string      // 按顺序尝试，只需要一个服务器的映射地址
+	UDP         string        // 服务端 UDP 回显探测服务的地址，为空时不检查
+	TCP         string        // 服务端 TCP 回显探测服务的地址，为空时不检查
+	Timeout     time.Duration // 每个探测的超时
+}
+
+// SelfTest 检查到服务端回显探测服务的 UDP 和 TCP 出站连通性并测量往返延迟。
+// 同一个套接字再向 STUN 服务器发送绑定请求，两者看到的映射地址不同时 NAT 为对称型，用于验证 NAT 类型检测的结果
+func SelfTest(opts SelfTestOptions) (*SelfTestResult, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 3 * time.Second
+	}
+	result := &SelfTestResult{TestedAt: time.Now().UTC(), Mapping: MappingUnknown}
+
+	if opts.UDP != "" {
+		conn, err := net.ListenUDP("udp", nil)
+		if err != nil {
+			return nil, fmt.Errorf("创建 UDP 套接字失败: %w", err)
+		}
+		defer conn.Close()
+
+		probe := echoProbe(conn, opts.UDP, opts.Timeout)
+		if probe.Error != "" {
+			result.UDPError = probe.Error
+		} else {
+			result.UDPReachable = true
+			result.UDPLatency = probe.RTT
+			mapped := []string{probe.Address}
+			for _, server := range opts.STUNServers {
+				if address, err := stunBinding(conn, server, opts.Timeout); err == nil {
+					mapped = append(mapped, address)
+					break
+				}
+			}
+			result.Mapping = classifyMapping(mapped, localIPs())
+		}
+	}
+
+	if opts.TCP != "" {
+		probe := tcpProbe(opts.TCP, opts.Timeout)
+		if probe.Error != "" {
+			result.TCPError = probe.Error
+		} else {
+			result.TCPReachable = true
+			result.TCPLatency = probe.RTT
+		}
+	}
+	return result, nil
+}
+
+// MatchesNATType 自检观察到的映射行为是否与检测到的 NAT 类型一致，映射行为或 NAT 类型未知时视为一致
+func (r *SelfTestResult) MatchesNATType(natType protocol.NATType) bool {
+	if natType == protocol.NATUnknown {
+		return true
+	}
+	switch r.Mapping {
+	case MappingEndpointDependent:
+		return natType == protocol.NATSymmetric
+	case MappingEndpointIndependent:
+		return natType != protocol.NATSymmetric && natType != protocol.NATNone
+	case MappingNone:
+		return natType == protocol.NATNone
+	default:
+		return true
+	}
+}
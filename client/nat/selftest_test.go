@@ -0,0 +1,82 @@
+package nat
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+// startTCPEchoServer 启动 TCP 回显探测服务
+func startTCPEchoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听 TCP 失败: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var req protocol.ProbeRequest
+			line, err := bufio.NewReader(conn).ReadBytes('\n')
+			if err == nil && json.Unmarshal(line, &req) == nil {
+				data, _ := json.Marshal(&protocol.ProbeResponse{ID: req.ID, Address: conn.RemoteAddr().String()})
+				conn.Write(append(data, '\n'))
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestSelfTest(t *testing.T) {
+	result, err := SelfTest(SelfTestOptions{
+		STUNServers: []string{deadSTUNServer(t), startSTUNServer(t)},
+		UDP:         startEchoServer(t, false),
+		TCP:         startTCPEchoServer(t),
+		Timeout:     300 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("自检失败: %v", err)
+	}
+	if !result.UDPReachable || !result.TCPReachable || result.UDPError != "" || result.TCPError != "" {
+		t.Fatalf("自检结果 = %+v", result)
+	}
+	// 回环地址没有经过 NAT
+	if result.Mapping != MappingNone || !result.MatchesNATType(protocol.NATNone) || result.MatchesNATType(protocol.NATSymmetric) {
+		t.Fatalf("映射行为 = %s", result.Mapping)
+	}
+
+	result, err = SelfTest(SelfTestOptions{
+		UDP:     deadSTUNServer(t),
+		TCP:     deadSTUNServer(t),
+		Timeout: 200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("自检失败: %v", err)
+	}
+	if result.UDPReachable || result.TCPReachable || result.UDPError == "" || result.TCPError == "" || result.Mapping != MappingUnknown {
+		t.Fatalf("探测服务不可达时的自检结果 = %+v", result)
+	}
+}
+
+func TestMatchesNATType(t *testing.T) {
+	symmetric := &SelfTestResult{Mapping: MappingEndpointDependent}
+	if symmetric.MatchesNATType(protocol.NATPortRestricted) || !symmetric.MatchesNATType(protocol.NATSymmetric) {
+		t.Error("映射与目标相关时只与对称型 NAT 一致")
+	}
+	cone := &SelfTestResult{Mapping: MappingEndpointIndependent}
+	if cone.MatchesNATType(protocol.NATSymmetric) || !cone.MatchesNATType(protocol.NATRestricted) {
+		t.Error("映射与目标无关时与对称型 NAT 不一致")
+	}
+	if !symmetric.MatchesNATType(protocol.NATUnknown) || !(&SelfTestResult{Mapping: MappingUnknown}).MatchesNATType(protocol.NATSymmetric) {
+		t.Error("NAT 类型或映射行为未知时视为一致")
+	}
+}
@@ -8,10 +8,15 @@ import (
 	"fmt"
 	"net"
 	"time"
+
+	"github.com/senma231/p3/common/protocol"
 )
 
 const (
 	stunMagicCookie = 0x2112A442
+	stunHeaderSize  = 20
+	// stunMaxBodyLength 消息头中的长度字段为 16 位，且必须是 4 的倍数
+	stunMaxBodyLength = 0xFFFC
 )
 
 // STUN 消息类型
@@ -73,12 +78,19 @@ func (m *STUNMessage) Marshal() ([]byte, error) {
 	// 计算属性长度
 	attrLen := 0
 	for _, attr := range m.Attributes {
+		if len(attr.Value) > 0xFFFF {
+			return nil, fmt.Errorf("STUN 属性 0x%04x 的长度 %d 超出上限", attr.Type, len(attr.Value))
+		}
 		attrLen += 4 + len(attr.Value) // 4 字节头 + 值长度
 		// 填充到 4 字节边界
 		padding := (4 - (len(attr.Value) % 4)) % 4
 		attrLen += padding
 	}
 
+	if attrLen > stunMaxBodyLength {
+		return nil, fmt.Errorf("STUN 属性总长度 %d 超出上限", attrLen)
+	}
+
 	// 写入消息长度
 	if err := binary.Write(buf, binary.BigEndian, uint16(attrLen)); err != nil {
 		return nil, err
@@ -118,9 +130,9 @@ func (m *STUNMessage) Marshal() ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
-// Unmarshal 从字节数组解析 STUN 消息
+// Unmarshal 从字节数组解析 STUN 消息，只解析消息头长度字段范围内的属性
 func (m *STUNMessage) Unmarshal(data []byte) error {
-	if len(data) < 20 {
+	if len(data) < stunHeaderSize {
 		return errors.New("STUN 消息太短")
 	}
 
@@ -130,17 +142,31 @@ func (m *STUNMessage) Unmarshal(data []byte) error {
 	m.MagicCookie = binary.BigEndian.Uint32(data[4:8])
 	copy(m.TransID[:], data[8:20])
 
+	// 消息类型的最高两位必须为 0
+	if m.Type&0xC000 != 0 {
+		return errors.New("无效的 STUN 消息类型")
+	}
+
 	// 检查魔术字
 	if m.MagicCookie != stunMagicCookie {
 		return errors.New("无效的 STUN 魔术字")
 	}
 
+	// 属性按 4 字节对齐，消息长度必须是 4 的倍数且不超过实际数据
+	if m.Length%4 != 0 {
+		return errors.New("STUN 消息长度不是 4 的倍数")
+	}
+	end := stunHeaderSize + int(m.Length)
+	if end > len(data) {
+		return errors.New("STUN 消息长度超出数据长度")
+	}
+
 	// 解析属性
 	m.Attributes = nil
-	offset := 20
+	offset := stunHeaderSize
 
-	for offset < len(data) {
-		if offset+4 > len(data) {
+	for offset < end {
+		if offset+4 > end {
 			return errors.New("无效的 STUN 属性头")
 		}
 
@@ -148,17 +174,15 @@ func (m *STUNMessage) Unmarshal(data []byte) error {
 		attrLen := binary.BigEndian.Uint16(data[offset+2 : offset+4])
 		offset += 4
 
-		if offset+int(attrLen) > len(data) {
+		// 属性值和填充字节都必须在消息范围内
+		padding := (4 - (int(attrLen) % 4)) % 4
+		if offset+int(attrLen)+padding > end {
 			return errors.New("无效的 STUN 属性长度")
 		}
 
 		attrValue := make([]byte, attrLen)
 		copy(attrValue, data[offset:offset+int(attrLen)])
-		offset += int(attrLen)
-
-		// 跳过填充字节
-		padding := (4 - (int(attrLen) % 4)) % 4
-		offset += padding
+		offset += int(attrLen) + padding
 
 		m.Attributes = append(m.Attributes, STUNAttribute{
 			Type:   attrType,
@@ -199,10 +223,8 @@ func (m *STUNMessage) GetXorMappedAddress() (net.IP, int, error) {
 				}
 				ip = make(net.IP, 16)
 				copy(ip, attr.Value[4:20])
-				// 异或 IP 与魔术字和事务 ID
-				for i := 0; i < 4; i++ {
-					binary.BigEndian.PutUint32(ip[i*4:], binary.BigEndian.Uint32(ip[i*4:])^stunMagicCookie)
-				}
+				// 前 4 字节异或魔术字，后 12 字节异或事务 ID
+				binary.BigEndian.PutUint32(ip, binary.BigEndian.Uint32(ip)^stunMagicCookie)
 				for i := 0; i < 12; i++ {
 					ip[i+4] ^= m.TransID[i]
 				}
@@ -252,6 +274,8 @@ func (m *STUNMessage) GetXorMappedAddress() (net.IP, int, error) {
 type STUNClient struct {
 	Servers []string
 	Timeout time.Duration
+	// pool 不为空时按服务器池的优先级尝试，并把结果记录到服务器池
+	pool *STUNPool
 }
 
 // NewSTUNClient 创建 STUN 客户端
@@ -279,13 +303,25 @@ func NewSTUNClient(servers []string, timeout time.Duration) *STUNClient {
 
 // Discover 发现外部 IP 和端口
 func (c *STUNClient) Discover() (net.IP, int, error) {
-	// 尝试所有 STUN 服务器
+	servers := c.Servers
+	if c.pool != nil {
+		servers = c.pool.Servers()
+	}
+
+	// 按优先级尝试所有 STUN 服务器
 	var lastErr error
-	for _, server := range c.Servers {
+	for _, server := range servers {
+		start := time.Now()
 		ip, port, err := c.discoverWithServer(server)
 		if err == nil {
+			if c.pool != nil {
+				c.pool.MarkSucceeded(server, time.Since(start))
+			}
 			return ip, port, nil
 		}
+		if c.pool != nil {
+			c.pool.MarkFailed(server)
+		}
 		lastErr = err
 	}
 
@@ -363,25 +399,25 @@ func (c *STUNClient) discoverWithServer(server string) (net.IP, int, error) {
 }
 
 // DetectNATType 检测 NAT 类型
-func (c *STUNClient) DetectNATType() (NATType, error) {
+func (c *STUNClient) DetectNATType() (protocol.NATType, error) {
 	// 实现 NAT 类型检测算法
 	// 这里使用简化版的算法，完整算法参考 RFC 5780
 
 	// 第一次测试：检查是否有公网 IP
 	ip, _, err := c.Discover()
 	if err != nil {
-		return NATUnknown, fmt.Errorf("第一次 STUN 测试失败: %w", err)
+		return protocol.NATUnknown, fmt.Errorf("第一次 STUN 测试失败: %w", err)
 	}
 
 	// 获取本地 IP
 	localIP, err := getLocalIP()
 	if err != nil {
-		return NATUnknown, fmt.Errorf("获取本地 IP 失败: %w", err)
+		return protocol.NATUnknown, fmt.Errorf("获取本地 IP 失败: %w", err)
 	}
 
 	// 如果外部 IP 与本地 IP 相同，则没有 NAT
 	if ip.Equal(localIP) {
-		return NATNone, nil
+		return protocol.NATNone, nil
 	}
 
 	// TODO: 实现完整的 NAT 类型检测算法
@@ -391,7 +427,7 @@ func (c *STUNClient) DetectNATType() (NATType, error) {
 	// 3. 检查端口映射行为
 
 	// 默认返回端口受限锥形 NAT
-	return NATPortRestricted, nil
+	return protocol.NATPortRestricted, nil
 }
 
 // getLocalIP 获取本地 IP
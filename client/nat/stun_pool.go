@@ -0,0 +1,272 @@
+package nat
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+// stunFailureThreshold STUN 服务器连续失败达到该次数后暂停使用
+const stunFailureThreshold = 2
+
+// STUNServer STUN 服务器的健康状态
+type STUNServer struct {
+	Address       string
+	Healthy       bool
+	Latency       time.Duration
+	CheckedAt     time.Time
+	Failures      int       // 连续失败次数
+	CooldownUntil time.Time // 暂停使用的截止时间
+}
+
+// coolingDown 服务器是否处于暂停使用期间
+func (s *STUNServer) coolingDown(now time.Time) bool {
+	return now.Before(s.CooldownUntil)
+}
+
+// STUNPool STUN 服务器池，定期发送绑定请求检查各服务器的可用性和延迟，优先使用延迟最低的可用服务器。
+// 连续失败的服务器暂停使用一段时间，服务端下发的列表替换本地配置的列表
+type STUNPool struct {
+	local    []string // 本地配置的服务器，服务端下发空列表时恢复使用
+	servers  []*STUNServer
+	client   *STUNClient
+	cooldown time.Duration
+	stopCh   chan struct{}
+	mu       sync.RWMutex
+}
+
+// NewSTUNPool 创建 STUN 服务器池，servers 的顺序作为首次检查前的优先级
+func NewSTUNPool(servers []string, timeout, cooldown time.Duration) *STUNPool {
+	client := NewSTUNClient(servers, timeout)
+	p := &STUNPool{
+		local:    client.Servers,
+		client:   client,
+		cooldown: cooldown,
+		stopCh:   make(chan struct{}),
+	}
+	p.servers = p.newServers(client.Servers)
+	return p
+}
+
+// newServers 按地址创建服务器列表，保留已有服务器的健康状态
+func (p *STUNPool) newServers(addresses []string) []*STUNServer {
+	known := make(map[string]*STUNServer, len(p.servers))
+	for _, server := range p.servers {
+		known[server.Address] = server
+	}
+
+	servers := make([]*STUNServer, 0, len(addresses))
+	for _, address := range addresses {
+		if server, ok := known[address]; ok {
+			servers = append(servers, server)
+			continue
+		}
+		servers = append(servers, &STUNServer{Address: address, Healthy: true})
+	}
+	return servers
+}
+
+// Start 启动定期健康检查
+func (p *STUNPool) Start(interval time.Duration) {
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+	p.Probe()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-p.stopCh:
+				return
+			case <-ticker.C:
+				p.Probe()
+			}
+		}
+	}()
+}
+
+// Stop 停止健康检查
+func (p *STUNPool) Stop() {
+	close(p.stopCh)
+}
+
+// Probe 检查所有未暂停使用的服务器的可用性和延迟
+func (p *STUNPool) Probe() {
+	now := time.Now()
+	p.mu.RLock()
+	servers := make([]*STUNServer, 0, len(p.servers))
+	for _, server := range p.servers {
+		if !server.coolingDown(now) {
+			servers = append(servers, server)
+		}
+	}
+	p.mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Add(1)
+		go func(server *STUNServer) {
+			defer wg.Done()
+			start := time.Now()
+			_, _, err := p.client.discoverWithServer(server.Address)
+
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			server.CheckedAt = time.Now()
+			if err != nil {
+				p.fail(server)
+				return
+			}
+			p.succeed(server, time.Since(start))
+		}(server)
+	}
+	wg.Wait()
+}
+
+// Servers 按优先级返回所有服务器：可用的服务器在前并按延迟升序，其次是最近失败的服务器，
+// 暂停使用的服务器作为最后的备选
+func (p *STUNPool) Servers() []string {
+	now := time.Now()
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	servers := make([]*STUNServer, len(p.servers))
+	copy(servers, p.servers)
+
+	rank := func(s *STUNServer) int {
+		switch {
+		case s.coolingDown(now):
+			return 2
+		case !s.Healthy:
+			return 1
+		default:
+			return 0
+		}
+	}
+	sort.SliceStable(servers, func(i, j int) bool {
+		if ri, rj := rank(servers[i]), rank(servers[j]); ri != rj {
+			return ri < rj
+		}
+		// 首次检查前延迟均为 0，保持配置顺序
+		return servers[i].Latency < servers[j].Latency
+	})
+
+	addresses := make([]string, len(servers))
+	for i, server := range servers {
+		addresses[i] = server.Address
+	}
+	return addresses
+}
+
+// MarkFailed 标记服务器请求失败，连续失败的服务器暂停使用
+func (p *STUNPool) MarkFailed(address string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if server := p.find(address); server != nil {
+		p.fail(server)
+	}
+}
+
+// MarkSucceeded 标记服务器请求成功并记录延迟
+func (p *STUNPool) MarkSucceeded(address string, latency time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if server := p.find(address); server != nil {
+		p.succeed(server, latency)
+	}
+}
+
+// find 按地址查找服务器，调用方需持有锁
+func (p *STUNPool) find(address string) *STUNServer {
+	for _, server := range p.servers {
+		if server.Address == address {
+			return server
+		}
+	}
+	return nil
+}
+
+// fail 记录一次失败，调用方需持有写锁
+func (p *STUNPool) fail(server *STUNServer) {
+	server.Healthy = false
+	server.Failures++
+	if server.Failures >= stunFailureThreshold && p.cooldown > 0 {
+		server.CooldownUntil = time.Now().Add(p.cooldown)
+	}
+}
+
+// succeed 记录一次成功，调用方需持有写锁
+func (p *STUNPool) succeed(server *STUNServer, latency time.Duration) {
+	server.Healthy = true
+	server.Latency = latency
+	server.Failures = 0
+	server.CooldownUntil = time.Time{}
+}
+
+// SetServers 使用服务端下发的服务器列表替换当前列表，已知服务器保留健康状态。
+// 列表为空时恢复使用本地配置的服务器
+func (p *STUNPool) SetServers(servers []string) error {
+	if err := protocol.ValidateSTUNServers(servers); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(servers) == 0 {
+		servers = p.local
+	}
+	p.servers = p.newServers(servers)
+	return nil
+}
+
+// Snapshot 获取所有服务器状态的副本
+func (p *STUNPool) Snapshot() []STUNServer {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	result := make([]STUNServer, len(p.servers))
+	for i, server := range p.servers {
+		result[i] = *server
+	}
+	return result
+}
+
+// HandleSignal 处理服务端下发的 STUN 服务器列表，来自欢迎消息（ping）的 stunServers 字段
+// 或 stun-servers 信令。欢迎消息中没有列表时恢复使用本地配置，旧版本的服务端不下发列表
+func (p *STUNPool) HandleSignal(signal *protocol.Signal) {
+	payload, ok := signal.Payload.(map[string]interface{})
+	if !ok {
+		return
+	}
+	key := "servers"
+	if signal.Type == protocol.SignalPing {
+		key = "stunServers"
+	}
+
+	values, _ := payload[key].([]interface{})
+	servers := make([]string, 0, len(values))
+	for _, v := range values {
+		server, ok := v.(string)
+		if !ok {
+			fmt.Printf("服务端下发的 STUN 服务器列表无效: %v\n", values)
+			return
+		}
+		servers = append(servers, server)
+	}
+	if err := p.SetServers(servers); err != nil {
+		fmt.Printf("服务端下发的 STUN 服务器列表无效: %v\n", err)
+		return
+	}
+	if len(servers) > 0 {
+		fmt.Printf("使用服务端下发的 STUN 服务器: %v\n", servers)
+	}
+}
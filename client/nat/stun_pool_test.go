@@ -0,0 +1,119 @@
+package nat
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+// startSTUNServer 启动回复绑定请求的本地 STUN 服务器
+func startSTUNServer(t *testing.T) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("监听 UDP 失败: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			req := &STUNMessage{}
+			if err := req.Unmarshal(buf[:n]); err != nil {
+				continue
+			}
+			resp := &STUNMessage{Type: stunBindingResponse, MagicCookie: stunMagicCookie, TransID: req.TransID,
+				Attributes: []STUNAttribute{xorMappedAddress(addr.IP, addr.Port, req.TransID)}}
+			data, _ := resp.Marshal()
+			conn.WriteToUDP(data, addr)
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// deadSTUNServer 返回没有服务监听的本地地址
+func deadSTUNServer(t *testing.T) string {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("监听 UDP 失败: %v", err)
+	}
+	address := conn.LocalAddr().String()
+	conn.Close()
+	return address
+}
+
+func TestSTUNPoolRotation(t *testing.T) {
+	dead := deadSTUNServer(t)
+	alive := startSTUNServer(t)
+
+	pool := NewSTUNPool([]string{dead, alive}, 200*time.Millisecond, time.Minute)
+	if servers := pool.Servers(); servers[0] != dead {
+		t.Fatalf("检查前应使用配置顺序，实际 %v", servers)
+	}
+
+	pool.Probe()
+	if servers := pool.Servers(); servers[0] != alive {
+		t.Fatalf("应优先使用可用的服务器，实际 %v", servers)
+	}
+
+	// 连续失败后暂停使用，检查时跳过
+	pool.Probe()
+	for _, server := range pool.Snapshot() {
+		if server.Address == dead && server.CooldownUntil.IsZero() {
+			t.Fatalf("连续失败的服务器应暂停使用: %+v", server)
+		}
+	}
+	checked := pool.Snapshot()[0].CheckedAt
+	pool.Probe()
+	if pool.Snapshot()[0].CheckedAt != checked {
+		t.Fatal("暂停使用期间不应检查服务器")
+	}
+
+	// 探测通过服务器池时失败的服务器排在后面
+	client := NewSTUNClient(nil, 200*time.Millisecond)
+	client.pool = pool
+	if _, _, err := client.Discover(); err != nil {
+		t.Fatalf("应通过可用的服务器发现外部地址: %v", err)
+	}
+
+	pool.MarkFailed(alive)
+	if servers := pool.Servers(); servers[0] != alive || servers[1] != dead {
+		t.Fatalf("暂停使用的服务器应排在最后，实际 %v", servers)
+	}
+}
+
+func TestSTUNPoolServerPush(t *testing.T) {
+	pool := NewSTUNPool([]string{"stun.example.com:3478"}, time.Second, time.Minute)
+	pool.MarkFailed("stun.example.com:3478")
+
+	pool.HandleSignal(&protocol.Signal{
+		Type:    protocol.SignalSTUNServers,
+		Payload: map[string]interface{}{"servers": []interface{}{"stun1.example.com:3478", "stun.example.com:3478"}},
+	})
+	if servers := pool.Servers(); len(servers) != 2 || servers[0] != "stun1.example.com:3478" {
+		t.Fatalf("应使用服务端下发的列表并保留已知服务器的状态，实际 %v", servers)
+	}
+
+	// 无效的列表不替换当前列表
+	pool.HandleSignal(&protocol.Signal{
+		Type:    protocol.SignalSTUNServers,
+		Payload: map[string]interface{}{"servers": []interface{}{"stun.example.com"}},
+	})
+	if servers := pool.Servers(); len(servers) != 2 {
+		t.Fatalf("无效的列表不应替换当前列表，实际 %v", servers)
+	}
+
+	// 欢迎消息中没有列表时恢复本地配置
+	pool.HandleSignal(&protocol.Signal{
+		Type:    protocol.SignalPing,
+		Payload: map[string]interface{}{"candidatePriority": []interface{}{"ipv6"}},
+	})
+	if servers := pool.Servers(); len(servers) != 1 || servers[0] != "stun.example.com:3478" {
+		t.Fatalf("应恢复使用本地配置的列表，实际 %v", servers)
+	}
+}
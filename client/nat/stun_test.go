@@ -0,0 +1,138 @@
+package nat
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"testing"
+	"testing/quick"
+)
+
+// xorMappedAddress 按 RFC 5389 构造 XOR-MAPPED-ADDRESS 属性
+func xorMappedAddress(ip net.IP, port int, transID [12]byte) STUNAttribute {
+	family := byte(0x01)
+	addr := ip.To4()
+	if addr == nil {
+		family = 0x02
+		addr = ip.To16()
+	}
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint32(key, stunMagicCookie)
+	copy(key[4:], transID[:])
+
+	value := make([]byte, 4+len(addr))
+	value[1] = family
+	binary.BigEndian.PutUint16(value[2:4], uint16(port)^uint16(stunMagicCookie>>16))
+	for i := range addr {
+		value[4+i] = addr[i] ^ key[i]
+	}
+	return STUNAttribute{Type: stunAttrXorMappedAddress, Length: uint16(len(value)), Value: value}
+}
+
+func TestXorMappedAddress(t *testing.T) {
+	transID := [12]byte{0xb7, 0xe7, 0xa7, 0x01, 0xbc, 0x34, 0xd6, 0x86, 0xfa, 0x87, 0xdf, 0xae}
+	for _, want := range []string{"192.0.2.1", "2001:db8:1234:5678:11:2233:4455:6677"} {
+		msg := &STUNMessage{Type: stunBindingResponse, MagicCookie: stunMagicCookie, TransID: transID}
+		msg.Attributes = []STUNAttribute{xorMappedAddress(net.ParseIP(want), 32853, transID)}
+
+		ip, port, err := msg.GetXorMappedAddress()
+		if err != nil || !ip.Equal(net.ParseIP(want)) || port != 32853 {
+			t.Fatalf("解析 %s 得到 %s:%d %v", want, ip, port, err)
+		}
+	}
+}
+
+// TestSTUNRoundTrip 任意属性序列化后再解析应得到相同的消息
+func TestSTUNRoundTrip(t *testing.T) {
+	roundTrip := func(msgType uint16, transID [12]byte, types []uint16, values [][]byte) bool {
+		msg := &STUNMessage{Type: msgType & 0x3FFF, MagicCookie: stunMagicCookie, TransID: transID}
+		for i, value := range values {
+			attr := STUNAttribute{Value: value, Length: uint16(len(value))}
+			if i < len(types) {
+				attr.Type = types[i]
+			}
+			if attr.Value == nil {
+				attr.Value = []byte{}
+			}
+			msg.Attributes = append(msg.Attributes, attr)
+		}
+
+		data, err := msg.Marshal()
+		if err != nil {
+			return false
+		}
+		parsed := &STUNMessage{}
+		if err := parsed.Unmarshal(data); err != nil {
+			return false
+		}
+		msg.Length = uint16(len(data) - stunHeaderSize)
+		return reflect.DeepEqual(msg, parsed)
+	}
+	if err := quick.Check(roundTrip, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSTUNMalformed(t *testing.T) {
+	valid := &STUNMessage{Type: stunBindingResponse, MagicCookie: stunMagicCookie,
+		Attributes: []STUNAttribute{{Type: stunAttrSoftware, Value: []byte("p3")}}}
+	data, err := valid.Marshal()
+	if err != nil {
+		t.Fatalf("序列化失败: %v", err)
+	}
+
+	tests := map[string][]byte{
+		"截断的属性":    data[:len(data)-4],
+		"长度不是4的倍数": append(append([]byte(nil), data[:2]...), append([]byte{0, 7}, data[4:]...)...),
+		"属性超出消息":   append(append([]byte(nil), data[:22]...), append([]byte{0xff, 0xff}, data[24:]...)...),
+		"类型最高位非零":  append([]byte{0xc1, 0x01}, data[2:]...),
+	}
+	for name, data := range tests {
+		if err := (&STUNMessage{}).Unmarshal(data); err == nil {
+			t.Errorf("%s: 应解析失败", name)
+		}
+	}
+
+	// 消息长度之后的多余数据被忽略
+	parsed := &STUNMessage{}
+	if err := parsed.Unmarshal(append(data, 0, 0, 0, 0)); err != nil || len(parsed.Attributes) != 1 {
+		t.Fatalf("多余数据应被忽略: %v %d", err, len(parsed.Attributes))
+	}
+
+	// 超长属性不能序列化
+	if _, err := (&STUNMessage{Attributes: []STUNAttribute{{Value: make([]byte, 0x10000)}}}).Marshal(); err == nil {
+		t.Fatalf("超长属性应序列化失败")
+	}
+}
+
+func FuzzSTUNUnmarshal(f *testing.F) {
+	req, _ := NewSTUNRequest()
+	data, _ := req.Marshal()
+	f.Add(data)
+	resp := &STUNMessage{Type: stunBindingResponse, MagicCookie: stunMagicCookie, TransID: req.TransID}
+	resp.Attributes = []STUNAttribute{xorMappedAddress(net.ParseIP("2001:db8::1"), 3478, req.TransID)}
+	data, _ = resp.Marshal()
+	f.Add(data)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msg := &STUNMessage{}
+		if err := msg.Unmarshal(data); err != nil {
+			return
+		}
+		msg.GetXorMappedAddress()
+
+		// 解析成功的消息重新序列化后应得到相同的内容
+		encoded, err := msg.Marshal()
+		if err != nil {
+			t.Fatalf("重新序列化失败: %v", err)
+		}
+		if !bytes.Equal(encoded[:stunHeaderSize], data[:stunHeaderSize]) {
+			t.Fatalf("消息头不一致: %x %x", encoded[:stunHeaderSize], data[:stunHeaderSize])
+		}
+		again := &STUNMessage{}
+		if err := again.Unmarshal(encoded); err != nil || !reflect.DeepEqual(msg, again) {
+			t.Fatalf("往返解析结果不一致: %v", err)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package netem
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+// Config 模拟的网络条件
+type Config struct {
+	NAT       protocol.NATType // 模拟的 NAT 类型，NATUnknown 表示不模拟 NAT
+	Latency   time.Duration    // 发送方向的固定延迟
+	Jitter    time.Duration    // 延迟在 ±Jitter 范围内随机变化
+	Loss      float64          // 丢包率，0 到 1
+	Bandwidth int64            // 发送带宽（字节/秒），0 表示不限制
+	Seed      int64            // 随机数种子，相同的种子得到相同的丢包和抖动序列
+}
+
+// natNames 可模拟的 NAT 类型
+var natNames = map[string]protocol.NATType{
+	"none":            protocol.NATNone,
+	"full":            protocol.NATFull,
+	"restricted":      protocol.NATRestricted,
+	"port-restricted": protocol.NATPortRestricted,
+	"symmetric":       protocol.NATSymmetric,
+}
+
+// Parse 解析逗号分隔的网络条件，例如 "nat=symmetric,loss=2%,latency=80ms,jitter=20ms,bandwidth=2mbit,seed=1"
+func Parse(spec string) (Config, error) {
+	cfg := Config{Seed: 1}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			return cfg, fmt.Errorf("网络条件 %q 格式应为 key=value", item)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		var err error
+		switch key {
+		case "nat":
+			t, known := natNames[strings.ToLower(value)]
+			if !known {
+				return cfg, fmt.Errorf("不支持模拟的 NAT 类型 %q，可选 none、full、restricted、port-restricted、symmetric", value)
+			}
+			cfg.NAT = t
+		case "latency", "delay":
+			cfg.Latency, err = time.ParseDuration(value)
+		case "jitter":
+			cfg.Jitter, err = time.ParseDuration(value)
+		case "loss":
+			cfg.Loss, err = parseLoss(value)
+		case "bandwidth", "rate":
+			cfg.Bandwidth, err = parseBandwidth(value)
+		case "seed":
+			cfg.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return cfg, fmt.Errorf("未知的网络条件 %q", key)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("网络条件 %s 无效: %w", key, err)
+		}
+	}
+	if cfg.Latency < 0 || cfg.Jitter < 0 {
+		return cfg, fmt.Errorf("延迟和抖动不能为负数")
+	}
+	return cfg, nil
+}
+
+// parseLoss 解析丢包率，支持 2% 和 0.02 两种写法
+func parseLoss(value string) (float64, error) {
+	percent := strings.HasSuffix(value, "%")
+	loss, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+	if err != nil {
+		return 0, err
+	}
+	if percent {
+		loss /= 100
+	}
+	if loss < 0 || loss > 1 {
+		return 0, fmt.Errorf("丢包率应在 0 到 100%% 之间")
+	}
+	return loss, nil
+}
+
+// parseBandwidth 解析带宽，单位为 bit/s，支持 kbit、mbit、gbit 后缀，返回字节/秒
+func parseBandwidth(value string) (int64, error) {
+	lower := strings.ToLower(value)
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix string
+		scale  int64
+	}{{"gbit", 1000 * 1000 * 1000}, {"mbit", 1000 * 1000}, {"kbit", 1000}, {"bit", 1}} {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSuffix(lower, unit.suffix)
+			multiplier = unit.scale
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(lower, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("带宽不能为负数")
+	}
+	return int64(n * float64(multiplier) / 8), nil
+}
+
+// String 返回网络条件的描述
+func (c Config) String() string {
+	var parts []string
+	for name, t := range natNames {
+		if c.NAT == t && c.NAT != protocol.NATUnknown {
+			parts = append(parts, "nat="+name)
+		}
+	}
+	if c.Latency > 0 {
+		parts = append(parts, "latency="+c.Latency.String())
+	}
+	if c.Jitter > 0 {
+		parts = append(parts, "jitter="+c.Jitter.String())
+	}
+	if c.Loss > 0 {
+		parts = append(parts, "loss="+strconv.FormatFloat(c.Loss*100, 'f', -1, 64)+"%")
+	}
+	if c.Bandwidth > 0 {
+		parts = append(parts, "bandwidth="+strconv.FormatInt(c.Bandwidth*8/1000, 10)+"kbit")
+	}
+	parts = append(parts, "seed="+strconv.FormatInt(c.Seed, 10))
+	return strings.Join(parts, ",")
+}
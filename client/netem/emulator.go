@@ -0,0 +1,458 @@
+// Package netem 在 transport.Transport 之上模拟网络条件，用于开发和测试时稳定复现用户报告的网络环境。
+//
+// 延迟、抖动、丢包和带宽限制作用于发送方向，需要模拟双向链路时两端都应启用模拟器。
+// 流式连接不会真正丢失数据，丢包表现为重传带来的额外延迟。
+//
+// NAT 模拟作用于本机收到的数据，规则与真实 NAT 的过滤行为一致：
+// 完全锥形接受所有来源；受限锥形只接受发送过数据的 IP；端口受限锥形只接受发送过数据的 IP 和端口；
+// 对称型为每个目的地址使用单独的套接字，对端看到的源端口随目的地址变化，每个套接字只接受对应目的地址的数据。
+// 除无 NAT 和完全锥形外，流式监听拒绝未主动连接过的来源，受限锥形接受主动连接过的 IP
+package netem
+
+import (
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/client/transport"
+	"github.com/senma231/p3/common/protocol"
+)
+
+// streamQueue 流式连接等待发出的数据段上限，超过时写入阻塞
+const streamQueue = 64
+
+// closeTimeout 关闭流式连接时等待排队数据发出的最长时间
+const closeTimeout = 10 * time.Second
+
+// Emulator 网络模拟器
+type Emulator struct {
+	base transport.Transport
+	cfg  Config
+	link *link
+
+	mu        sync.Mutex
+	contacted map[string]bool // 主动建立过流式连接的远端 IP
+}
+
+// New 创建网络模拟器，base 为实际使用的网络
+func New(base transport.Transport, cfg Config) *Emulator {
+	return &Emulator{
+		base:      base,
+		cfg:       cfg,
+		link:      newLink(cfg),
+		contacted: make(map[string]bool),
+	}
+}
+
+// Config 返回模拟的网络条件
+func (e *Emulator) Config() Config {
+	return e.cfg
+}
+
+// DialTimeout 连接到 address，发送的数据按配置延迟或丢弃
+func (e *Emulator) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := e.base.DialTimeout(network, address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if ip := addrIP(conn.RemoteAddr()); ip != "" {
+		e.mu.Lock()
+		e.contacted[ip] = true
+		e.mu.Unlock()
+	}
+	_, isUDP := conn.(*net.UDPConn)
+	return e.wrapConn(conn, isUDP), nil
+}
+
+// Listen 监听流式连接，按模拟的 NAT 类型拒绝入站连接
+func (e *Emulator) Listen(network, address string) (net.Listener, error) {
+	listener, err := e.base.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &emulatedListener{Listener: listener, e: e}, nil
+}
+
+// ListenPacket 监听数据报，按模拟的 NAT 类型过滤收到的数据
+func (e *Emulator) ListenPacket(network, address string) (net.PacketConn, error) {
+	pc, err := e.base.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	if e.cfg.NAT == protocol.NATUnknown && !e.link.impaired() {
+		return pc, nil
+	}
+	return newPacketConn(e, network, pc), nil
+}
+
+// acceptInbound 是否接受来自 addr 的入站流式连接
+func (e *Emulator) acceptInbound(addr net.Addr) bool {
+	switch e.cfg.NAT {
+	case protocol.NATUnknown, protocol.NATNone, protocol.NATFull:
+		return true
+	case protocol.NATRestricted:
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return e.contacted[addrIP(addr)]
+	default:
+		return false
+	}
+}
+
+// wrapConn 为连接加上发送方向的延迟和丢包，datagram 为 true 时丢包直接丢弃数据
+func (e *Emulator) wrapConn(conn net.Conn, datagram bool) net.Conn {
+	if !e.link.impaired() {
+		return conn
+	}
+	c := &emulatedConn{
+		Conn:     conn,
+		link:     e.link,
+		datagram: datagram,
+		queue:    make(chan segment, streamQueue),
+		done:     make(chan struct{}),
+	}
+	go c.writeLoop()
+	return c
+}
+
+// addrIP 返回地址中的 IP
+func addrIP(addr net.Addr) string {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String()
+	case *net.UDPAddr:
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return ""
+	}
+	return host
+}
+
+// emulatedListener 按模拟的 NAT 类型过滤入站连接
+type emulatedListener struct {
+	net.Listener
+	e *Emulator
+}
+
+// Accept 等待下一个被 NAT 放行的入站连接，被拒绝的连接直接关闭
+func (l *emulatedListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if !l.e.acceptInbound(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+		return l.e.wrapConn(conn, false), nil
+	}
+}
+
+// segment 等待发出的数据
+type segment struct {
+	data []byte
+	at   time.Time
+}
+
+// emulatedConn 按链路配置延迟发出数据的连接，数据按写入顺序发出
+type emulatedConn struct {
+	net.Conn
+	link     *link
+	datagram bool
+
+	mu     sync.Mutex
+	queue  chan segment
+	done   chan struct{}
+	closed bool
+	last   time.Time // 上一段数据的发出时间
+
+	errMu sync.Mutex
+	err   error // 发出数据时的错误，之后的写入直接返回
+}
+
+// Write 将数据放入发送队列
+func (c *emulatedConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	if err := c.writeErr(); err != nil {
+		return 0, err
+	}
+
+	lost, delay := c.link.schedule(len(b))
+	if lost {
+		if c.datagram {
+			return len(b), nil
+		}
+		delay += c.link.retransmitDelay()
+	}
+	at := time.Now().Add(delay)
+	if at.Before(c.last) {
+		at = c.last
+	}
+	c.last = at
+
+	seg := segment{data: append([]byte(nil), b...), at: at}
+	if c.datagram {
+		// 数据报队列已满时丢弃，与真实链路的尾部丢弃一致
+		select {
+		case c.queue <- seg:
+		default:
+		}
+		return len(b), nil
+	}
+	c.queue <- seg
+	return len(b), nil
+}
+
+// writeLoop 按时间发出队列中的数据
+func (c *emulatedConn) writeLoop() {
+	defer close(c.done)
+	for seg := range c.queue {
+		if wait := time.Until(seg.at); wait > 0 {
+			time.Sleep(wait)
+		}
+		if _, err := c.Conn.Write(seg.data); err != nil {
+			c.errMu.Lock()
+			c.err = err
+			c.errMu.Unlock()
+		}
+	}
+}
+
+// writeErr 返回发出数据时的错误
+func (c *emulatedConn) writeErr() error {
+	c.errMu.Lock()
+	defer c.errMu.Unlock()
+	return c.err
+}
+
+// Close 等待排队的数据发出后关闭连接
+func (c *emulatedConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.queue)
+	c.mu.Unlock()
+
+	select {
+	case <-c.done:
+	case <-time.After(closeTimeout):
+	}
+	return c.Conn.Close()
+}
+
+// packet 收到的数据报
+type packet struct {
+	data []byte
+	from net.Addr
+}
+
+// packetConn 模拟 NAT 过滤和对称型映射的数据报套接字
+type packetConn struct {
+	net.PacketConn // 绑定的套接字
+	e              *Emulator
+	network        string
+
+	packets   chan packet
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	mu       sync.Mutex
+	sent     map[string]bool           // 发送过数据的目的地址
+	sentIP   map[string]bool           // 发送过数据的目的 IP
+	mappings map[string]net.PacketConn // 对称型 NAT 下每个目的地址使用的套接字
+	deadline time.Time                 // 读取截止时间
+	notify   chan struct{}             // 截止时间变化时关闭
+}
+
+// newPacketConn 创建数据报套接字并开始接收
+func newPacketConn(e *Emulator, network string, pc net.PacketConn) *packetConn {
+	c := &packetConn{
+		PacketConn: pc,
+		e:          e,
+		network:    network,
+		packets:    make(chan packet, streamQueue),
+		closed:     make(chan struct{}),
+		sent:       make(map[string]bool),
+		sentIP:     make(map[string]bool),
+		mappings:   make(map[string]net.PacketConn),
+		notify:     make(chan struct{}),
+	}
+	go c.readLoop(pc, nil)
+	return c
+}
+
+// readLoop 从套接字接收数据，peer 不为空时该套接字是对称型 NAT 下某个目的地址的映射，只接受来自该地址的数据
+func (c *packetConn) readLoop(pc net.PacketConn, peer net.Addr) {
+	buffer := make([]byte, 65536)
+	for {
+		n, from, err := pc.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		if !c.accept(from, peer) {
+			continue
+		}
+		select {
+		case c.packets <- packet{data: append([]byte(nil), buffer[:n]...), from: from}:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+// accept 是否接受来自 from 的数据
+func (c *packetConn) accept(from, peer net.Addr) bool {
+	if peer != nil {
+		return from.String() == peer.String()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.e.cfg.NAT {
+	case protocol.NATUnknown, protocol.NATNone, protocol.NATFull:
+		return true
+	case protocol.NATRestricted:
+		return c.sentIP[addrIP(from)]
+	case protocol.NATPortRestricted:
+		return c.sent[from.String()]
+	default:
+		// 对称型 NAT 下绑定的套接字没有对应任何对端的映射
+		return false
+	}
+}
+
+// ReadFrom 读取被 NAT 放行的数据
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		deadline, notify := c.deadline, c.notify
+		c.mu.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return 0, nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case p := <-c.packets:
+			stopTimer(timer)
+			return copy(b, p.data), p.from, nil
+		case <-c.closed:
+			stopTimer(timer)
+			return 0, nil, net.ErrClosed
+		case <-timeout:
+			return 0, nil, os.ErrDeadlineExceeded
+		case <-notify:
+			// 截止时间已变化，重新计算
+			stopTimer(timer)
+		}
+	}
+}
+
+// stopTimer 停止可能为空的定时器
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// WriteTo 按链路配置延迟或丢弃数据，对称型 NAT 下从目的地址对应的套接字发出
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	pc, err := c.socketFor(addr)
+	if err != nil {
+		return 0, err
+	}
+	lost, delay := c.e.link.schedule(len(b))
+	if lost {
+		return len(b), nil
+	}
+	if delay == 0 {
+		return pc.WriteTo(b, addr)
+	}
+	data := append([]byte(nil), b...)
+	time.AfterFunc(delay, func() {
+		pc.WriteTo(data, addr)
+	})
+	return len(b), nil
+}
+
+// socketFor 记录目的地址并返回发送使用的套接字
+func (c *packetConn) socketFor(addr net.Addr) (net.PacketConn, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sent[addr.String()] = true
+	c.sentIP[addrIP(addr)] = true
+
+	if c.e.cfg.NAT != protocol.NATSymmetric {
+		return c.PacketConn, nil
+	}
+	if pc, ok := c.mappings[addr.String()]; ok {
+		return pc, nil
+	}
+	// 新的目的地址使用新的套接字，对端看到不同的源端口
+	local := addrIP(c.PacketConn.LocalAddr())
+	pc, err := c.e.base.ListenPacket(c.network, net.JoinHostPort(local, "0"))
+	if err != nil {
+		return nil, err
+	}
+	c.mappings[addr.String()] = pc
+	go c.readLoop(pc, addr)
+	return pc, nil
+}
+
+// SetDeadline 设置读取截止时间，发送不会阻塞
+func (c *packetConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+// SetReadDeadline 设置读取截止时间
+func (c *packetConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	close(c.notify)
+	c.notify = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline 发送不会阻塞，忽略写入截止时间
+func (c *packetConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// Close 关闭绑定的套接字和所有映射
+func (c *packetConn) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.mu.Lock()
+		for _, pc := range c.mappings {
+			pc.Close()
+		}
+		c.mu.Unlock()
+		err = c.PacketConn.Close()
+	})
+	return err
+}
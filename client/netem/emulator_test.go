@@ -0,0 +1,125 @@
+package netem
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/client/transport"
+	"github.com/senma231/p3/common/protocol"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse("nat=symmetric, loss=2%, latency=80ms, jitter=20ms, bandwidth=2mbit, seed=7")
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	want := Config{NAT: protocol.NATSymmetric, Latency: 80 * time.Millisecond, Jitter: 20 * time.Millisecond,
+		Loss: 0.02, Bandwidth: 250000, Seed: 7}
+	if cfg != want {
+		t.Fatalf("解析结果为 %+v，期望 %+v", cfg, want)
+	}
+
+	for _, spec := range []string{"nat=carrier", "loss=120%", "latency", "mtu=1400"} {
+		if _, err := Parse(spec); err == nil {
+			t.Fatalf("%q 应解析失败", spec)
+		}
+	}
+}
+
+// listen 在回环地址上监听数据报
+func listen(t *testing.T, tr transport.Transport) net.PacketConn {
+	pc, err := tr.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	return pc
+}
+
+// receive 读取一个数据报，超时返回空
+func receive(pc net.PacketConn) (string, net.Addr) {
+	pc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	buffer := make([]byte, 64)
+	n, from, err := pc.ReadFrom(buffer)
+	if err != nil {
+		return "", nil
+	}
+	return string(buffer[:n]), from
+}
+
+func TestPortRestrictedFilter(t *testing.T) {
+	local := listen(t, New(transport.System, Config{NAT: protocol.NATPortRestricted}))
+	peer := listen(t, transport.System)
+	other := listen(t, transport.System)
+
+	// 未发送过数据的来源被丢弃
+	peer.WriteTo([]byte("early"), local.LocalAddr())
+	if data, _ := receive(local); data != "" {
+		t.Fatalf("未打洞时不应收到数据: %s", data)
+	}
+
+	// 发送后只接受该地址，同一 IP 的其他端口仍被丢弃
+	local.WriteTo([]byte("punch"), peer.LocalAddr())
+	if data, _ := receive(peer); data != "punch" {
+		t.Fatalf("对端应收到打洞数据: %q", data)
+	}
+	other.WriteTo([]byte("other"), local.LocalAddr())
+	peer.WriteTo([]byte("reply"), local.LocalAddr())
+	if data, _ := receive(local); data != "reply" {
+		t.Fatalf("应只收到对端的回复: %q", data)
+	}
+}
+
+func TestSymmetricMapping(t *testing.T) {
+	local := listen(t, New(transport.System, Config{NAT: protocol.NATSymmetric}))
+	a := listen(t, transport.System)
+	b := listen(t, transport.System)
+
+	// 不同目的地址看到不同的源端口
+	local.WriteTo([]byte("a"), a.LocalAddr())
+	local.WriteTo([]byte("b"), b.LocalAddr())
+	_, fromA := receive(a)
+	_, fromB := receive(b)
+	if fromA == nil || fromB == nil {
+		t.Fatalf("对端应收到数据")
+	}
+	if fromA.String() == fromB.String() || fromA.String() == local.LocalAddr().String() {
+		t.Fatalf("对称型 NAT 下源端口应随目的地址变化: %s %s", fromA, fromB)
+	}
+
+	// 发往其他端口的数据被丢弃，发往映射端口的回复被接受
+	a.WriteTo([]byte("wrong"), local.LocalAddr())
+	a.WriteTo([]byte("wrong"), fromB)
+	if data, _ := receive(local); data != "" {
+		t.Fatalf("发往其他映射的数据应被丢弃: %s", data)
+	}
+	a.WriteTo([]byte("reply"), fromA)
+	if data, _ := receive(local); data != "reply" {
+		t.Fatalf("应收到发往映射端口的回复: %q", data)
+	}
+}
+
+func TestLossDeterministic(t *testing.T) {
+	drops := func() []bool {
+		l := newLink(Config{Loss: 0.3, Seed: 42})
+		result := make([]bool, 50)
+		for i := range result {
+			result[i], _ = l.schedule(100)
+		}
+		return result
+	}
+	first, second := drops(), drops()
+	lost := 0
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("相同种子的丢包序列应相同")
+		}
+		if first[i] {
+			lost++
+		}
+	}
+	if lost == 0 || lost == len(first) {
+		t.Fatalf("丢包率 30%% 时丢包数不合理: %d", lost)
+	}
+}
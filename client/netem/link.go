@@ -0,0 +1,66 @@
+package netem
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// retransmitBase 流式连接丢包后重传的最小等待时间，与 Linux TCP 的最小 RTO 相同
+const retransmitBase = 200 * time.Millisecond
+
+// link 发送方向的链路，按配置决定每段数据是否丢弃以及延迟多久发出
+type link struct {
+	cfg Config
+
+	mu        sync.Mutex
+	rand      *rand.Rand
+	busyUntil time.Time // 带宽限制下链路空闲的时间
+}
+
+// newLink 创建链路
+func newLink(cfg Config) *link {
+	return &link{
+		cfg:  cfg,
+		rand: rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+// impaired 是否需要模拟延迟、丢包或带宽限制
+func (l *link) impaired() bool {
+	return l.cfg.Latency > 0 || l.cfg.Jitter > 0 || l.cfg.Loss > 0 || l.cfg.Bandwidth > 0
+}
+
+// schedule 决定 size 字节的数据是否丢弃，未丢弃时返回从现在起多久后发出
+func (l *link) schedule(size int) (lost bool, delay time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.cfg.Loss > 0 && l.rand.Float64() < l.cfg.Loss {
+		return true, 0
+	}
+
+	delay = l.cfg.Latency
+	if l.cfg.Jitter > 0 {
+		delay += time.Duration((l.rand.Float64()*2 - 1) * float64(l.cfg.Jitter))
+		if delay < 0 {
+			delay = 0
+		}
+	}
+
+	// 带宽限制：数据在链路上排队，前面的数据发完后才能发出
+	if l.cfg.Bandwidth > 0 {
+		now := time.Now()
+		if l.busyUntil.Before(now) {
+			l.busyUntil = now
+		}
+		l.busyUntil = l.busyUntil.Add(time.Duration(int64(size) * int64(time.Second) / l.cfg.Bandwidth))
+		delay += l.busyUntil.Sub(now)
+	}
+	return false, delay
+}
+
+// retransmitDelay 流式连接丢包后重传带来的额外延迟
+func (l *link) retransmitDelay() time.Duration {
+	return retransmitBase + 2*l.cfg.Latency
+}
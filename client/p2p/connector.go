@@ -1,53 +1,39 @@
 package p2p
 
 import (
+	"errors"
 	"fmt"
 	"net"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/identity"
 	"github.com/senma231/p3/client/nat"
+	"github.com/senma231/p3/client/proxy"
+	"github.com/senma231/p3/client/transport"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/common/resume"
 )
 
-// ConnectionType 连接类型
-type ConnectionType int
-
-const (
-	ConnectionTypeUnknown ConnectionType = iota
-	ConnectionTypeDirect               // 直接连接
-	ConnectionTypeHolePunch            // 打洞连接
-	ConnectionTypeRelay                // 中继连接
-)
-
-// String 返回连接类型的字符串表示
-func (t ConnectionType) String() string {
-	switch t {
-	case ConnectionTypeDirect:
-		return "Direct"
-	case ConnectionTypeHolePunch:
-		return "HolePunch"
-	case ConnectionTypeRelay:
-		return "Relay"
-	default:
-		return "Unknown"
-	}
-}
-
 // ConnectionResult 连接结果
 type ConnectionResult struct {
 	Success        bool
 	Conn           net.Conn
-	ConnectionType ConnectionType
+	ConnectionType protocol.ConnectionType
+	MTU            int // UDP 连接协商的路径 MTU，TCP 连接为 0
 	Error          error
 }
 
 // PeerInfo 对等节点信息
 type PeerInfo struct {
 	NodeID       string
-	NATType      nat.NATType
+	NATType      protocol.NATType
 	ExternalIP   string
 	ExternalPort int
+	// 双方在同一 NAT 之后时对端通告的局域网候选地址
+	LocalCandidates []string
 }
 
 // Connector P2P 连接器
@@ -57,6 +43,11 @@ type Connector struct {
 	signalingClient *SignalingClient
 	puncher        *Puncher
 	connectResults map[string]chan *ConnectionResult
+	relayConns     map[string]*relayConn // 按目标节点记录中继连接，中继维护或负载过高时迁移
+	lanListener    net.Listener
+	transport      transport.Transport // 建立对等连接使用的网络，开发模式下为网络模拟器
+	identity       *identity.Identity  // 设备证书，用于中继握手和验证局域网对端
+	incoming       func(peerID string, conn net.Conn) // 处理对端发起且本节点没有等待的连接
 	mu             sync.RWMutex
 }
 
@@ -68,18 +59,46 @@ func NewConnector(cfg *config.Config, natInfo *nat.NATInfo, signalingClient *Sig
 		signalingClient: signalingClient,
 		puncher:        NewPuncher(cfg.Network.UDPPort1, natInfo, 10*time.Second, 5),
 		connectResults: make(map[string]chan *ConnectionResult),
+		relayConns:     make(map[string]*relayConn),
+		transport:      transport.NewSystem(cfg.Network.Interfaces.Policy()),
 	}
+	connector.puncher.transport = connector.transport
 
 	// 注册信令处理函数
-	signalingClient.RegisterHandler(SignalConnect, connector.handleConnectSignal)
-	signalingClient.RegisterHandler(SignalOffer, connector.handleOfferSignal)
-	signalingClient.RegisterHandler(SignalAnswer, connector.handleAnswerSignal)
-	signalingClient.RegisterHandler(SignalICECandidate, connector.handleICECandidateSignal)
-	signalingClient.RegisterHandler(SignalRelayResponse, connector.handleRelayResponseSignal)
+	signalingClient.RegisterHandler(protocol.SignalConnect, connector.handleConnectSignal)
+	signalingClient.RegisterHandler(protocol.SignalOffer, connector.handleOfferSignal)
+	signalingClient.RegisterHandler(protocol.SignalAnswer, connector.handleAnswerSignal)
+	signalingClient.RegisterHandler(protocol.SignalICECandidate, connector.handleICECandidateSignal)
+	signalingClient.RegisterHandler(protocol.SignalRelayResponse, connector.handleRelayResponseSignal)
+	signalingClient.RegisterHandler(protocol.SignalRelayMigrate, connector.handleRelayMigrateSignal)
 
 	return connector
 }
 
+// SetProxy 设置连接中继服务器使用的代理
+func (c *Connector) SetProxy(px *proxy.Proxy) {
+	c.puncher.proxy = px
+}
+
+// SetIdentity 设置设备身份，持有证书时中继握手使用证书认证，局域网握手交换证书凭据
+func (c *Connector) SetIdentity(id *identity.Identity) {
+	c.identity = id
+}
+
+// SetTransport 设置建立对等连接使用的网络，需在 ListenLAN 之前调用
+func (c *Connector) SetTransport(t transport.Transport) {
+	c.transport = t
+	c.puncher.transport = t
+}
+
+// OnIncoming 设置对端发起且本节点没有等待的连接的处理函数，例如作为出口节点转发对端的流量。
+// 未设置时这类连接被关闭
+func (c *Connector) OnIncoming(handler func(peerID string, conn net.Conn)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.incoming = handler
+}
+
 // Connect 连接到对等节点
 func (c *Connector) Connect(peerID string) (*ConnectionResult, error) {
 	// 创建结果通道
@@ -91,7 +110,7 @@ func (c *Connector) Connect(peerID string) (*ConnectionResult, error) {
 	c.mu.Unlock()
 
 	// 发送连接请求
-	if err := c.signalingClient.RequestConnect(peerID); err != nil {
+	if err := c.signalingClient.RequestConnect(peerID, c.lanCandidates()); err != nil {
 		c.mu.Lock()
 		delete(c.connectResults, peerID)
 		c.mu.Unlock()
@@ -110,8 +129,30 @@ func (c *Connector) Connect(peerID string) (*ConnectionResult, error) {
 	}
 }
 
+// Accept 等待对等节点发起的连接，用于对端按服务端的安排主动连接本节点的场景，例如测速。
+// 对端的连接请求到达后按 tryConnect 的顺序建立连接，超时未建立时返回错误
+func (c *Connector) Accept(peerID string, timeout time.Duration) (*ConnectionResult, error) {
+	resultCh := make(chan *ConnectionResult, 1)
+
+	c.mu.Lock()
+	c.connectResults[peerID] = resultCh
+	c.mu.Unlock()
+
+	select {
+	case result := <-resultCh:
+		return result, nil
+	case <-time.After(timeout):
+		c.mu.Lock()
+		if c.connectResults[peerID] == resultCh {
+			delete(c.connectResults, peerID)
+		}
+		c.mu.Unlock()
+		return nil, fmt.Errorf("等待对等节点 %s 连接超时", peerID)
+	}
+}
+
 // handleConnectSignal 处理连接信令
-func (c *Connector) handleConnectSignal(signal *Signal) {
+func (c *Connector) handleConnectSignal(signal *protocol.Signal) {
 	// 提取对等节点信息
 	payload, ok := signal.Payload.(map[string]interface{})
 	if !ok {
@@ -127,33 +168,10 @@ func (c *Connector) handleConnectSignal(signal *Signal) {
 	}
 
 	// 处理对等节点连接请求
-	natTypeStr, _ := payload["natType"].(string)
-	externalIP, _ := payload["externalIP"].(string)
-	externalPort, _ := payload["externalPort"].(float64)
-
-	// 解析 NAT 类型
-	var natType nat.NATType
-	switch natTypeStr {
-	case "No NAT (Public IP)":
-		natType = nat.NATNone
-	case "Full Cone NAT":
-		natType = nat.NATFull
-	case "Restricted Cone NAT":
-		natType = nat.NATRestricted
-	case "Port Restricted Cone NAT":
-		natType = nat.NATPortRestricted
-	case "Symmetric NAT":
-		natType = nat.NATSymmetric
-	default:
-		natType = nat.NATUnknown
-	}
-
-	// 创建对等节点信息
-	peerInfo := &PeerInfo{
-		NodeID:       signal.SenderID,
-		NATType:      natType,
-		ExternalIP:   externalIP,
-		ExternalPort: int(externalPort),
+	peerInfo, err := parsePeerInfo(signal.SenderID, payload)
+	if err != nil {
+		fmt.Printf("无效的连接信令: %v\n", err)
+		return
 	}
 
 	// 尝试连接
@@ -161,7 +179,7 @@ func (c *Connector) handleConnectSignal(signal *Signal) {
 }
 
 // handleServerConnectResponse 处理服务器连接响应
-func (c *Connector) handleServerConnectResponse(signal *Signal) {
+func (c *Connector) handleServerConnectResponse(signal *protocol.Signal) {
 	payload, ok := signal.Payload.(map[string]interface{})
 	if !ok {
 		fmt.Printf("无效的服务器连接响应负载: %v\n", signal.Payload)
@@ -183,25 +201,15 @@ func (c *Connector) handleServerConnectResponse(signal *Signal) {
 	}
 
 	// 解析连接类型
-	var connectionType ConnectionType
-	switch connectionTypeStr {
-	case "Direct":
-		connectionType = ConnectionTypeDirect
-	case "HolePunch":
-		connectionType = ConnectionTypeHolePunch
-	case "Relay":
-		connectionType = ConnectionTypeRelay
-	default:
-		connectionType = ConnectionTypeUnknown
-	}
+	connectionType := protocol.ParseConnectionType(connectionTypeStr)
 
 	// 如果是中继连接，则发送中继请求
-	if connectionType == ConnectionTypeRelay {
+	if connectionType == protocol.ConnectionRelay {
 		if err := c.signalingClient.RequestRelay(targetID); err != nil {
 			fmt.Printf("发送中继请求失败: %v\n", err)
 			c.sendConnectResult(targetID, &ConnectionResult{
 				Success:        false,
-				ConnectionType: ConnectionTypeUnknown,
+				ConnectionType: protocol.ConnectionUnknown,
 				Error:          fmt.Errorf("发送中继请求失败: %w", err),
 			})
 		}
@@ -210,6 +218,20 @@ func (c *Connector) handleServerConnectResponse(signal *Signal) {
 
 // tryConnect 尝试连接到对等节点
 func (c *Connector) tryConnect(peer *PeerInfo) {
+	// 双方在同一 NAT 之后时优先连接局域网地址，经外部地址连接需要 NAT 支持回环，通常会失败或绕行
+	if len(peer.LocalCandidates) > 0 {
+		conn, err := c.lanConnect(peer.NodeID, peer.LocalCandidates)
+		if err == nil {
+			c.sendConnectResult(peer.NodeID, &ConnectionResult{
+				Success:        true,
+				Conn:           conn,
+				ConnectionType: protocol.ConnectionLAN,
+			})
+			return
+		}
+		fmt.Printf("局域网连接失败: %v\n", err)
+	}
+
 	// 尝试直接连接
 	if c.canDirectConnect(peer.NATType) {
 		conn, err := c.directConnect(peer.ExternalIP, peer.ExternalPort)
@@ -217,7 +239,7 @@ func (c *Connector) tryConnect(peer *PeerInfo) {
 			c.sendConnectResult(peer.NodeID, &ConnectionResult{
 				Success:        true,
 				Conn:           conn,
-				ConnectionType: ConnectionTypeDirect,
+				ConnectionType: protocol.ConnectionDirect,
 			})
 			return
 		}
@@ -230,7 +252,8 @@ func (c *Connector) tryConnect(peer *PeerInfo) {
 		c.sendConnectResult(peer.NodeID, &ConnectionResult{
 			Success:        true,
 			Conn:           result.Conn,
-			ConnectionType: ConnectionTypeHolePunch,
+			ConnectionType: protocol.ConnectionHolePunch,
+			MTU:            result.MTU,
 		})
 		return
 	}
@@ -241,19 +264,19 @@ func (c *Connector) tryConnect(peer *PeerInfo) {
 }
 
 // canDirectConnect 检查是否可以直接连接
-func (c *Connector) canDirectConnect(peerNATType nat.NATType) bool {
+func (c *Connector) canDirectConnect(peerNATType protocol.NATType) bool {
 	// 如果对方没有 NAT，可以直接连接
-	if peerNATType == nat.NATNone {
+	if peerNATType == protocol.NATNone {
 		return true
 	}
 
 	// 如果对方是完全锥形 NAT，可以直接连接
-	if peerNATType == nat.NATFull {
+	if peerNATType == protocol.NATFull {
 		return true
 	}
 
 	// 如果本地没有 NAT，可以直接连接
-	if c.natInfo.Type == nat.NATNone {
+	if c.natInfo.Type == protocol.NATNone {
 		return true
 	}
 
@@ -263,7 +286,7 @@ func (c *Connector) canDirectConnect(peerNATType nat.NATType) bool {
 // directConnect 直接连接
 func (c *Connector) directConnect(peerIP string, peerPort int) (net.Conn, error) {
 	// 创建 TCP 连接
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", peerIP, peerPort), 5*time.Second)
+	conn, err := c.transport.DialTimeout("tcp", fmt.Sprintf("%s:%d", peerIP, peerPort), 5*time.Second)
 	if err != nil {
 		return nil, fmt.Errorf("直接连接失败: %w", err)
 	}
@@ -271,25 +294,25 @@ func (c *Connector) directConnect(peerIP string, peerPort int) (net.Conn, error)
 }
 
 // handleOfferSignal 处理 Offer 信令
-func (c *Connector) handleOfferSignal(signal *Signal) {
+func (c *Connector) handleOfferSignal(signal *protocol.Signal) {
 	// 暂时不处理 WebRTC 信令
 	fmt.Printf("收到 Offer 信令: %v\n", signal)
 }
 
 // handleAnswerSignal 处理 Answer 信令
-func (c *Connector) handleAnswerSignal(signal *Signal) {
+func (c *Connector) handleAnswerSignal(signal *protocol.Signal) {
 	// 暂时不处理 WebRTC 信令
 	fmt.Printf("收到 Answer 信令: %v\n", signal)
 }
 
 // handleICECandidateSignal 处理 ICE 候选信令
-func (c *Connector) handleICECandidateSignal(signal *Signal) {
+func (c *Connector) handleICECandidateSignal(signal *protocol.Signal) {
 	// 暂时不处理 WebRTC 信令
 	fmt.Printf("收到 ICE 候选信令: %v\n", signal)
 }
 
 // handleRelayResponseSignal 处理中继响应信令
-func (c *Connector) handleRelayResponseSignal(signal *Signal) {
+func (c *Connector) handleRelayResponseSignal(signal *protocol.Signal) {
 	payload, ok := signal.Payload.(map[string]interface{})
 	if !ok {
 		fmt.Printf("无效的中继响应负载: %v\n", signal.Payload)
@@ -299,7 +322,8 @@ func (c *Connector) handleRelayResponseSignal(signal *Signal) {
 	// 获取中继信息
 	relayID, _ := payload["relayId"].(string)
 	relayHost, _ := payload["relayHost"].(string)
-	relayPort, _ := payload["relayPort"].(float64)
+	relayPort, _ := parsePort(payload["relayPort"])
+	relayTicket, _ := payload["relayTicket"].(string)
 
 	// 获取目标节点 ID
 	var targetID string
@@ -313,74 +337,132 @@ func (c *Connector) handleRelayResponseSignal(signal *Signal) {
 		fmt.Printf("中继响应中缺少中继地址或端口\n")
 		c.sendConnectResult(targetID, &ConnectionResult{
 			Success:        false,
-			ConnectionType: ConnectionTypeUnknown,
+			ConnectionType: protocol.ConnectionUnknown,
 			Error:          fmt.Errorf("中继响应中缺少中继地址或端口"),
 		})
 		return
 	}
 
-	// 连接到中继服务器
-	relayAddr := fmt.Sprintf("%s:%d", relayHost, int(relayPort))
-	conn, err := net.DialTimeout("tcp", relayAddr, 10*time.Second)
-	if err != nil {
-		fmt.Printf("连接中继服务器失败: %v\n", err)
+	// 连接到中继服务器，凭信令服务器签发的一次性票据或设备证书认证，
+	// 请求可恢复的会话，与中继服务器的连接中断后自动重连
+	relayAddr := net.JoinHostPort(relayHost, strconv.Itoa(relayPort))
+	auth := c.relayAuth(relayTicket)
+	if c.config.Security.RelayE2E && !auth.E2E {
+		fmt.Printf("没有设备证书，到 %s 的中继会话不使用端到端加密\n", targetID)
+	}
+	result := c.puncher.PunchWithRelay(relayAddr, targetID, auth)
+	if !result.Success {
+		fmt.Printf("中继连接失败: %v\n", result.Error)
 		c.sendConnectResult(targetID, &ConnectionResult{
 			Success:        false,
-			ConnectionType: ConnectionTypeUnknown,
-			Error:          fmt.Errorf("连接中继服务器失败: %w", err),
+			ConnectionType: protocol.ConnectionUnknown,
+			Error:          result.Error,
 		})
 		return
 	}
+	dial := func() (net.Conn, error) {
+		return c.puncher.dialRelay(relayAddr)
+	}
 
-	// 发送中继请求
-	relayRequest := fmt.Sprintf("RELAY %s", targetID)
-	_, err = conn.Write([]byte(relayRequest))
-	if err != nil {
-		conn.Close()
-		fmt.Printf("发送中继请求失败: %v\n", err)
-		c.sendConnectResult(targetID, &ConnectionResult{
-			Success:        false,
-			ConnectionType: ConnectionTypeUnknown,
-			Error:          fmt.Errorf("发送中继请求失败: %w", err),
-		})
+	// 中继连接成功，记录连接以便迁移到其他中继
+	rc := newRelayConn(result.Conn, relayID, result.RelayTicket, dial)
+	rc.e2e = auth.E2E
+	c.mu.Lock()
+	c.relayConns[targetID] = rc
+	c.mu.Unlock()
+
+	// 握手中声明了端到端加密时，在中继会话内与对端建立以设备证书认证的 TLS 连接
+	var conn net.Conn = rc
+	if auth.E2E {
+		secured, err := secureRelay(rc, c.identity, c.config.Node.ID, targetID, relayE2ETimeout)
+		if err != nil {
+			fmt.Printf("中继端到端加密失败: %v\n", err)
+			c.mu.Lock()
+			if c.relayConns[targetID] == rc {
+				delete(c.relayConns, targetID)
+			}
+			c.mu.Unlock()
+			rc.Close()
+			c.sendConnectResult(targetID, &ConnectionResult{
+				Success:        false,
+				ConnectionType: protocol.ConnectionUnknown,
+				Error:          err,
+			})
+			return
+		}
+		conn = secured
+	}
+
+	c.sendConnectResult(targetID, &ConnectionResult{
+		Success:        true,
+		Conn:           conn,
+		ConnectionType: protocol.ConnectionRelay,
+	})
+}
+
+// handleRelayMigrateSignal 处理中继会话迁移信令：与新中继建立会话后结束旧中继上的会话，
+// 切换期间写入的数据暂存在缓冲区，中继转发的连接保持不断
+func (c *Connector) handleRelayMigrateSignal(signal *protocol.Signal) {
+	payload, ok := signal.Payload.(map[string]interface{})
+	if !ok {
+		fmt.Printf("无效的中继迁移负载: %v\n", signal.Payload)
 		return
 	}
 
-	// 读取中继响应
-	buffer := make([]byte, 1024)
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	n, err := conn.Read(buffer)
-	if err != nil {
-		conn.Close()
-		fmt.Printf("读取中继响应失败: %v\n", err)
-		c.sendConnectResult(targetID, &ConnectionResult{
-			Success:        false,
-			ConnectionType: ConnectionTypeUnknown,
-			Error:          fmt.Errorf("读取中继响应失败: %w", err),
-		})
+	fromRelayID, _ := payload["fromRelayId"].(string)
+	relayID, _ := payload["relayId"].(string)
+	relayHost, _ := payload["relayHost"].(string)
+	relayPort, _ := parsePort(payload["relayPort"])
+	relayTicket, _ := payload["relayTicket"].(string)
+	targetID, _ := payload["targetId"].(string)
+	if relayHost == "" || relayPort == 0 {
+		fmt.Printf("中继迁移信令中缺少中继地址或端口\n")
 		return
 	}
 
-	// 检查响应
-	response := string(buffer[:n])
-	if response != "OK" {
-		conn.Close()
-		fmt.Printf("中继服务器拒绝请求: %s\n", response)
-		c.sendConnectResult(targetID, &ConnectionResult{
-			Success:        false,
-			ConnectionType: ConnectionTypeUnknown,
-			Error:          fmt.Errorf("中继服务器拒绝请求: %s", response),
-		})
+	c.mu.RLock()
+	rc := c.relayConns[targetID]
+	c.mu.RUnlock()
+	if rc == nil || rc.RelayID() != fromRelayID {
+		fmt.Printf("没有经过中继 %s 到 %s 的连接，忽略迁移\n", fromRelayID, targetID)
 		return
 	}
 
-	// 中继连接成功
-	conn.SetReadDeadline(time.Time{})
-	c.sendConnectResult(targetID, &ConnectionResult{
-		Success:        true,
-		Conn:           conn,
-		ConnectionType: ConnectionTypeRelay,
+	relayAddr := net.JoinHostPort(relayHost, strconv.Itoa(relayPort))
+	err := rc.Migrate(relayID, func() (net.Conn, string, func() (net.Conn, error), error) {
+		dial := func() (net.Conn, error) {
+			return c.puncher.dialRelay(relayAddr)
+		}
+		// 会话内的端到端加密在迁移后继续使用，新中继上的会话按原会话声明
+		auth := c.relayAuth(relayTicket)
+		auth.E2E = rc.e2e
+		result := c.puncher.PunchWithRelay(relayAddr, targetID, auth)
+		if !result.Success {
+			return nil, "", nil, result.Error
+		}
+		return result.Conn, result.RelayTicket, dial, nil
 	})
+	if err != nil {
+		if errors.Is(err, resume.ErrClosed) {
+			c.mu.Lock()
+			if c.relayConns[targetID] == rc {
+				delete(c.relayConns, targetID)
+			}
+			c.mu.Unlock()
+		}
+		fmt.Printf("迁移中继会话失败: %v\n", err)
+	}
+}
+
+// relayAuth 构造中继握手认证信息，请求可恢复的会话。
+// 只有启用了 relayE2E 且持有设备证书、能在会话内建立端到端加密时才声明 E2E
+func (c *Connector) relayAuth(ticket string) *RelayAuth {
+	return &RelayAuth{
+		Ticket:    ticket,
+		Identity:  c.identity,
+		Resumable: true,
+		E2E:       c.config.Security.RelayE2E && relayE2EAvailable(c.identity),
+	}
 }
 
 // sendConnectResult 发送连接结果
@@ -390,9 +472,13 @@ func (c *Connector) sendConnectResult(peerID string, result *ConnectionResult) {
 
 	resultCh, exists := c.connectResults[peerID]
 	if !exists {
-		// 如果没有注册结果通道，则关闭连接
+		// 如果没有注册结果通道，则交给对端发起连接的处理函数，没有时关闭连接
 		if result.Success && result.Conn != nil {
-			result.Conn.Close()
+			if c.incoming != nil {
+				c.incoming(peerID, result.Conn)
+			} else {
+				result.Conn.Close()
+			}
 		}
 		return
 	}
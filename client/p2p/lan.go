@@ -0,0 +1,274 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/senma231/p3/client/transport"
+	"github.com/senma231/p3/common/protocol"
+)
+
+const (
+	// lanPingTimeout 局域网候选地址的连接和验证超时
+	lanPingTimeout = 2 * time.Second
+	// maxLANCandidates 通告的局域网候选地址数上限
+	maxLANCandidates = 8
+	// lanPingPrefix 和 lanPongPrefix 验证局域网连接的握手消息前缀。
+	// 同一网段中可能有其他主机使用相同的地址（例如 Docker 网桥、VPN），握手确认连接到的是目标节点
+	lanPingPrefix = "P3-LAN-PING"
+	lanPongPrefix = "P3-LAN-PONG"
+	// maxHandshakeLen 握手消息的最大长度，包含证书凭据
+	maxHandshakeLen = 2048
+)
+
+// LocalCandidates 获取本机的局域网候选地址，只包含已启用网卡上的私有地址，
+// 按网卡选择去掉被排除网卡上的地址，优先网卡上的地址排在前面
+func LocalCandidates(port int, policy transport.InterfacePolicy) []string {
+	var candidates []string
+	for _, addr := range policy.Addresses() {
+		if !addr.IP.IsPrivate() {
+			continue
+		}
+		candidates = append(candidates, net.JoinHostPort(addr.IP.String(), strconv.Itoa(port)))
+		if len(candidates) == maxLANCandidates {
+			break
+		}
+	}
+	return candidates
+}
+
+// ListenLAN 监听局域网连接。同一 NAT 之后的对端收到本节点的连接请求后，会连接本节点通告的局域网候选地址
+func (c *Connector) ListenLAN() error {
+	listener, err := c.transport.Listen("tcp", fmt.Sprintf(":%d", c.config.Network.TCPPort))
+	if err != nil {
+		return fmt.Errorf("监听局域网连接失败: %w", err)
+	}
+
+	c.mu.Lock()
+	c.lanListener = listener
+	c.mu.Unlock()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go c.acceptLAN(conn)
+		}
+	}()
+	return nil
+}
+
+// Close 停止监听局域网连接
+func (c *Connector) Close() error {
+	c.mu.Lock()
+	listener := c.lanListener
+	c.lanListener = nil
+	c.mu.Unlock()
+
+	if listener == nil {
+		return nil
+	}
+	return listener.Close()
+}
+
+// lanCandidates 获取连接请求中通告的局域网候选地址，未监听局域网连接时不通告
+func (c *Connector) lanCandidates() []string {
+	c.mu.RLock()
+	listening := c.lanListener != nil
+	c.mu.RUnlock()
+
+	if !listening {
+		return nil
+	}
+	return LocalCandidates(c.config.Network.TCPPort, c.config.Network.Interfaces.Policy())
+}
+
+// acceptLAN 验证对端的局域网连接。只接受正在等待连接结果的对端，验证通过后作为连接结果返回
+func (c *Connector) acceptLAN(conn net.Conn) {
+	conn.SetDeadline(time.Now().Add(lanPingTimeout))
+	line, err := readLine(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 3 || fields[0] != lanPingPrefix || fields[2] != c.config.Node.ID {
+		conn.Close()
+		return
+	}
+	peerID := fields[1]
+	if err := c.verifyLANPeer(fields[3:], lanPingPrefix, c.config.Node.ID, peerID); err != nil {
+		fmt.Printf("局域网连接 %s 验证节点 %s 的证书失败: %v\n", conn.RemoteAddr(), peerID, err)
+		conn.Close()
+		return
+	}
+
+	c.mu.RLock()
+	_, pending := c.connectResults[peerID]
+	c.mu.RUnlock()
+	if !pending {
+		conn.Close()
+		return
+	}
+
+	pong := lanPongPrefix + " " + c.config.Node.ID + c.lanCredentials(lanPongPrefix, peerID)
+	if _, err := fmt.Fprintf(conn, "%s\n", pong); err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetDeadline(time.Time{})
+
+	c.sendConnectResult(peerID, &ConnectionResult{
+		Success:        true,
+		Conn:           conn,
+		ConnectionType: protocol.ConnectionLAN,
+	})
+}
+
+// lanConnect 同时连接对端的所有局域网候选地址，返回最先通过验证的连接
+func (c *Connector) lanConnect(peerID string, candidates []string) (net.Conn, error) {
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("没有局域网候选地址")
+	}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	results := make(chan result, len(candidates))
+	for _, addr := range candidates {
+		go func(addr string) {
+			conn, err := c.pingLAN(peerID, addr)
+			results <- result{conn, err}
+		}(addr)
+	}
+
+	var lastErr error
+	for i := range candidates {
+		r := <-results
+		if r.err != nil {
+			lastErr = r.err
+			continue
+		}
+
+		// 关闭稍后通过验证的连接
+		remaining := len(candidates) - i - 1
+		go func() {
+			for j := 0; j < remaining; j++ {
+				if late := <-results; late.err == nil {
+					late.conn.Close()
+				}
+			}
+		}()
+		return r.conn, nil
+	}
+	return nil, fmt.Errorf("局域网连接失败: %w", lastErr)
+}
+
+// pingLAN 连接局域网候选地址，并确认对端是目标节点
+func (c *Connector) pingLAN(peerID, addr string) (net.Conn, error) {
+	conn, err := c.transport.DialTimeout("tcp", addr, lanPingTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(lanPingTimeout))
+
+	ping := fmt.Sprintf("%s %s %s", lanPingPrefix, c.config.Node.ID, peerID) + c.lanCredentials(lanPingPrefix, peerID)
+	if _, err := fmt.Fprintf(conn, "%s\n", ping); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reply, err := readLine(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s 未响应验证: %w", addr, err)
+	}
+	fields := strings.Fields(reply)
+	if len(fields) < 2 || fields[0] != lanPongPrefix || fields[1] != peerID {
+		conn.Close()
+		return nil, fmt.Errorf("%s 不是节点 %s", addr, peerID)
+	}
+	if err := c.verifyLANPeer(fields[2:], lanPongPrefix, c.config.Node.ID, peerID); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s 验证节点 %s 的证书失败: %w", addr, peerID, err)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// lanCredentials 握手消息末尾附加的证书凭据，签名覆盖消息前缀和接收方节点 ID，未持有证书时为空
+func (c *Connector) lanCredentials(prefix, receiverID string) string {
+	if !c.identity.Enrolled() {
+		return ""
+	}
+	credentials, err := c.identity.Credentials(prefix, receiverID)
+	if err != nil {
+		return ""
+	}
+	return " " + credentials
+}
+
+// verifyLANPeer 验证握手消息中对端的证书凭据。本机有 CA 证书时要求对端出示同一 CA 签发且未吊销的证书，
+// 否则兼容不带证书的旧版本握手
+func (c *Connector) verifyLANPeer(credentials []string, prefix, receiverID, peerID string) error {
+	if !c.identity.CanVerifyPeers() {
+		return nil
+	}
+	if len(credentials) == 0 {
+		return fmt.Errorf("对端未出示证书")
+	}
+	return c.identity.VerifyPeer(credentials, prefix, receiverID, peerID)
+}
+
+// readLine 逐字节读取一行握手消息，不多读之后的数据
+func readLine(conn net.Conn) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for len(line) < maxHandshakeLen {
+		if _, err := conn.Read(buf); err != nil {
+			return "", err
+		}
+		if buf[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, buf[0])
+	}
+	return "", fmt.Errorf("握手消息过长")
+}
+
+// parseCandidates 解析信令中的候选地址列表，只保留有效的 IP:端口，最多 maxLANCandidates 个
+func parseCandidates(v interface{}) []string {
+	values, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	candidates := make([]string, 0, maxLANCandidates)
+	for _, value := range values {
+		addr, ok := value.(string)
+		if !ok || !validCandidate(addr) {
+			continue
+		}
+		candidates = append(candidates, addr)
+		if len(candidates) == maxLANCandidates {
+			break
+		}
+	}
+	return candidates
+}
+
+// validCandidate 检查候选地址是否为 IP:端口
+func validCandidate(addr string) bool {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) == nil {
+		return false
+	}
+	port, err := strconv.Atoi(portStr)
+	return err == nil && port > 0 && port <= 65535
+}
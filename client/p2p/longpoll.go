@@ -0,0 +1,188 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/common/protocol"
+)
+
+const (
+	// pollWait 每次长轮询的等待时间，低于常见代理的空闲超时
+	pollWait = 25 * time.Second
+	// wsRetryInterval 使用长轮询时重新尝试 WebSocket 的间隔
+	wsRetryInterval = 5 * time.Minute
+)
+
+// pollTransport 基于 HTTPS 长轮询的信令传输，用于 WebSocket 被代理或防火墙拦截的网络
+type pollTransport struct {
+	address string // 服务器地址
+	base    string // 长轮询接口前缀
+	// auth 为每个请求添加认证请求头，持有证书时每个请求单独签名
+	auth    func(req *http.Request, body []byte) error
+	client  *http.Client
+	ctx     context.Context
+	cancel  context.CancelFunc
+	pending []protocol.Signal // 注册时收到的信令，由 run 处理
+}
+
+// openPoll 以长轮询方式连接指定服务器，第一次轮询不等待，用于注册到信令服务器
+func (c *SignalingClient) openPoll(serverURL string) (*pollTransport, string, error) {
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		return nil, "", fmt.Errorf("解析服务器地址失败: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t := &pollTransport{
+		address: serverURL,
+		base:    u.Scheme + "://" + u.Host + "/api/v1/signal",
+		auth:    c.authenticate,
+		client:  &http.Client{Transport: c.proxy.Transport(), Timeout: pollWait + 10*time.Second},
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+
+	signals, err := t.poll(0)
+	if err != nil {
+		cancel()
+		return nil, "", err
+	}
+	t.pending = signals
+	return t, t.base + "/poll", nil
+}
+
+// poll 等待发往本节点的信令，最多等待 wait
+func (t *pollTransport) poll(wait time.Duration) ([]protocol.Signal, error) {
+	target := fmt.Sprintf("%s/poll?wait=%d", t.base, int(wait/time.Second))
+	resp, err := t.do(t.ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Signals []protocol.Signal `json:"signals"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("解析长轮询响应失败: %w", err)
+	}
+	return body.Signals, nil
+}
+
+// do 发送带认证信息的请求，响应状态码不为 200 时返回错误
+func (t *pollTransport) do(ctx context.Context, method, target string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, target, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if err := t.auth(req, body); err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp, nil
+	case http.StatusUpgradeRequired:
+		defer resp.Body.Close()
+		return nil, upgradeRequiredError(resp)
+	default:
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("长轮询请求失败，状态码 %d: %s", resp.StatusCode, bytes.TrimSpace(data))
+	}
+}
+
+// Send 发送信令消息
+func (t *pollTransport) Send(data []byte) error {
+	body, err := json.Marshal(map[string][]json.RawMessage{
+		"signals": {data},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := t.do(t.ctx, http.MethodPost, t.base+"/send", body)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Close 停止轮询并通知服务器断开，通知失败时由服务器超时清理
+func (t *pollTransport) Close() {
+	t.cancel()
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if resp, err := t.do(ctx, http.MethodDelete, t.base+"/poll", nil); err == nil {
+			resp.Body.Close()
+		}
+	}()
+}
+
+// Name 传输方式名称
+func (t *pollTransport) Name() string {
+	return config.SignalingPoll
+}
+
+// run 持续轮询并处理收到的信令，直到传输通道停止
+func (t *pollTransport) run(c *SignalingClient, done chan struct{}) {
+	for i := range t.pending {
+		c.handleSignal(&t.pending[i])
+	}
+	t.pending = nil
+
+	for {
+		signals, err := t.poll(pollWait)
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if err != nil {
+			fmt.Printf("信令长轮询失败: %v\n", err)
+			c.handleDisconnect(t)
+			return
+		}
+
+		for i := range signals {
+			c.handleSignal(&signals[i])
+		}
+	}
+}
+
+// upgradeLoop 使用长轮询期间定期尝试 WebSocket，连接成功后切换
+func (c *SignalingClient) upgradeLoop(t *pollTransport, done chan struct{}) {
+	ticker := time.NewTicker(wsRetryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			conn, wsURL, err := c.dial(t.address)
+			if err != nil {
+				continue
+			}
+			c.switchTransport(t, newWSTransport(conn), wsURL)
+			return
+		}
+	}
+}
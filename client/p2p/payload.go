@@ -0,0 +1,44 @@
+package p2p
+
+import (
+	"fmt"
+	"math"
+	"net"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+// parsePort 解析负载中的端口，JSON 数字解码为 float64，必须是 0 到 65535 之间的整数
+func parsePort(v interface{}) (int, bool) {
+	f, ok := v.(float64)
+	if !ok || f != math.Trunc(f) || f < 0 || f > 65535 {
+		return 0, false
+	}
+	return int(f), true
+}
+
+// parsePeerInfo 从对等节点的连接请求中解析对端信息，外部地址和端口必须有效
+func parsePeerInfo(senderID string, payload map[string]interface{}) (*PeerInfo, error) {
+	natTypeStr, _ := payload["natType"].(string)
+	externalIP, _ := payload["externalIP"].(string)
+	if externalIP != "" && net.ParseIP(externalIP) == nil {
+		return nil, fmt.Errorf("无效的外部地址: %q", externalIP)
+	}
+
+	peerInfo := &PeerInfo{
+		NodeID:     senderID,
+		NATType:    protocol.ParseNATType(natTypeStr),
+		ExternalIP: externalIP,
+	}
+	if v, ok := payload["externalPort"]; ok {
+		port, valid := parsePort(v)
+		if !valid {
+			return nil, fmt.Errorf("无效的外部端口: %v", v)
+		}
+		peerInfo.ExternalPort = port
+	}
+	if connectionType, _ := payload["connectionType"].(string); connectionType == "LAN" {
+		peerInfo.LocalCandidates = parseCandidates(payload["localCandidates"])
+	}
+	return peerInfo, nil
+}
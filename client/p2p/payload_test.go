@@ -0,0 +1,77 @@
+package p2p
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+func TestParsePeerInfo(t *testing.T) {
+	payload := map[string]interface{}{
+		"connectionType":  "LAN",
+		"natType":         "Symmetric NAT",
+		"externalIP":      "203.0.113.7",
+		"externalPort":    float64(40000),
+		"localCandidates": []interface{}{"192.168.1.5:27184", "not-an-address", 42, "[fd00::1]:27184", "10.0.0.1:0"},
+	}
+	peer, err := parsePeerInfo("node-b", payload)
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	want := &PeerInfo{NodeID: "node-b", NATType: protocol.NATSymmetric, ExternalIP: "203.0.113.7", ExternalPort: 40000,
+		LocalCandidates: []string{"192.168.1.5:27184", "[fd00::1]:27184"}}
+	if !reflect.DeepEqual(peer, want) {
+		t.Fatalf("解析结果为 %+v", peer)
+	}
+
+	for _, port := range []interface{}{float64(-1), float64(70000), 1.5, 1e300, "80"} {
+		if _, err := parsePeerInfo("node-b", map[string]interface{}{"externalPort": port}); err == nil {
+			t.Errorf("端口 %v 应解析失败", port)
+		}
+	}
+	if _, err := parsePeerInfo("node-b", map[string]interface{}{"externalIP": "example.com"}); err == nil {
+		t.Errorf("非 IP 的外部地址应解析失败")
+	}
+}
+
+func FuzzParseSignal(f *testing.F) {
+	f.Add([]byte(`{"type":"connect","senderId":"node-a","payload":{"connectionType":"LAN","externalIP":"203.0.113.7","externalPort":40000,"localCandidates":["192.168.1.5:27184"]},"timestamp":"2024-01-01T00:00:00Z"}`))
+	f.Add([]byte(`{"type":"relay-response","senderId":"server","payload":{"relayHost":"relay.example.com","relayPort":27185,"targetId":"node-b"}}`))
+	f.Add([]byte(`{"type":"presence","payload":{"nodeId":"node-b","online":true}}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		signal, err := protocol.ParseSignal(data)
+		if err != nil {
+			return
+		}
+
+		if payload, ok := signal.Payload.(map[string]interface{}); ok {
+			if peer, err := parsePeerInfo(signal.SenderID, payload); err == nil {
+				if peer.ExternalPort < 0 || peer.ExternalPort > 65535 || len(peer.LocalCandidates) > maxLANCandidates {
+					t.Fatalf("解析出无效的对端信息: %+v", peer)
+				}
+				for _, addr := range peer.LocalCandidates {
+					if !validCandidate(addr) {
+						t.Fatalf("无效的候选地址: %q", addr)
+					}
+				}
+			}
+		}
+
+		// 解析成功的信令重新编码后应解析出相同的内容
+		encoded, err := json.Marshal(signal)
+		if err != nil {
+			t.Fatalf("重新编码失败: %v", err)
+		}
+		again, err := protocol.ParseSignal(encoded)
+		if err != nil {
+			t.Fatalf("重新解析失败: %v", err)
+		}
+		if again.Type != signal.Type || again.SenderID != signal.SenderID || again.ReceiverID != signal.ReceiverID ||
+			!again.Timestamp.Equal(signal.Timestamp) || !reflect.DeepEqual(again.Payload, signal.Payload) {
+			t.Fatalf("往返解析结果不一致: %+v %+v", signal, again)
+		}
+	})
+}
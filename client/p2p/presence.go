@@ -0,0 +1,115 @@
+package p2p
+
+import (
+	"sort"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+// PresenceHandler 节点在线状态变化的处理函数
+type PresenceHandler func(nodeID string, online bool)
+
+// Subscribe 订阅节点的在线状态。订阅在重连后自动恢复，
+// 服务端在订阅后推送各节点的当前状态，之后在节点上线或离线时推送
+func (c *SignalingClient) Subscribe(nodeIDs ...string) {
+	c.mu.Lock()
+	added := make([]string, 0, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		if nodeID != "" && !c.subscribed[nodeID] {
+			c.subscribed[nodeID] = true
+			added = append(added, nodeID)
+		}
+	}
+	connected := c.connected
+	c.mu.Unlock()
+
+	if connected && len(added) > 0 {
+		c.sendPresenceRequest(protocol.SignalSubscribe, added)
+	}
+}
+
+// Unsubscribe 取消订阅节点的在线状态
+func (c *SignalingClient) Unsubscribe(nodeIDs ...string) {
+	c.mu.Lock()
+	removed := make([]string, 0, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		if c.subscribed[nodeID] {
+			delete(c.subscribed, nodeID)
+			delete(c.presence, nodeID)
+			removed = append(removed, nodeID)
+		}
+	}
+	connected := c.connected
+	c.mu.Unlock()
+
+	if connected && len(removed) > 0 {
+		c.sendPresenceRequest(protocol.SignalUnsubscribe, removed)
+	}
+}
+
+// OnPresence 注册节点在线状态变化的处理函数，在状态变化和重连后第一次收到状态时调用
+func (c *SignalingClient) OnPresence(handler PresenceHandler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.presenceHandlers = append(c.presenceHandlers, handler)
+}
+
+// PeerOnline 获取订阅节点的在线状态，尚未收到状态或未连接信令服务器时 known 为 false
+func (c *SignalingClient) PeerOnline(nodeID string) (online, known bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	online, known = c.presence[nodeID]
+	return online, known
+}
+
+// resubscribe 连接后恢复全部订阅
+func (c *SignalingClient) resubscribe() {
+	c.mu.RLock()
+	nodeIDs := make([]string, 0, len(c.subscribed))
+	for nodeID := range c.subscribed {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	c.mu.RUnlock()
+
+	if len(nodeIDs) > 0 {
+		sort.Strings(nodeIDs)
+		c.sendPresenceRequest(protocol.SignalSubscribe, nodeIDs)
+	}
+}
+
+// sendPresenceRequest 发送订阅或取消订阅请求
+func (c *SignalingClient) sendPresenceRequest(signalType protocol.SignalType, nodeIDs []string) {
+	c.Send(&protocol.Signal{
+		Type: signalType,
+		Payload: map[string]interface{}{
+			"nodeIds": nodeIDs,
+		},
+	})
+}
+
+// handlePresence 记录节点的在线状态，状态变化时调用处理函数
+func (c *SignalingClient) handlePresence(signal *protocol.Signal) {
+	payload, ok := signal.Payload.(map[string]interface{})
+	if !ok {
+		return
+	}
+	nodeID, _ := payload["nodeId"].(string)
+	online, _ := payload["online"].(bool)
+
+	c.mu.Lock()
+	if !c.subscribed[nodeID] {
+		c.mu.Unlock()
+		return
+	}
+	previous, known := c.presence[nodeID]
+	c.presence[nodeID] = online
+	handlers := c.presenceHandlers
+	c.mu.Unlock()
+
+	if known && previous == online {
+		return
+	}
+	for _, handler := range handlers {
+		handler(nodeID, online)
+	}
+}
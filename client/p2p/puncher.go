@@ -1,19 +1,28 @@
 package p2p
 
 import (
+	"context"
 	"fmt"
 	"net"
+	"net/http"
+	"net/url"
 	"sync"
 	"time"
 
 	"github.com/senma231/p3/client/nat"
+	"github.com/senma231/p3/client/pmtu"
+	"github.com/senma231/p3/client/proxy"
+	"github.com/senma231/p3/client/transport"
+	"github.com/senma231/p3/common/protocol"
 )
 
 // PunchResult 打洞结果
 type PunchResult struct {
 	Success        bool
 	Conn           net.Conn
-	ConnectionType ConnectionType
+	ConnectionType protocol.ConnectionType
+	MTU            int    // UDP 连接协商的路径 MTU，TCP 连接为 0
+	RelayTicket    string // 可恢复中继会话的票据，迁移会话时凭票据结束旧会话
 	Error          error
 }
 
@@ -23,6 +32,8 @@ type Puncher struct {
 	natInfo    *nat.NATInfo
 	timeout    time.Duration
 	maxRetries int
+	proxy      *proxy.Proxy // 连接中继服务器使用的代理
+	transport  transport.Transport
 }
 
 // NewPuncher 创建打洞器
@@ -38,11 +49,13 @@ func NewPuncher(localPort int, natInfo *nat.NATInfo, timeout time.Duration, maxR
 		natInfo:    natInfo,
 		timeout:    timeout,
 		maxRetries: maxRetries,
+		proxy:      proxy.FromEnvironment(),
+		transport:  transport.System,
 	}
 }
 
 // Punch 尝试打洞连接
-func (p *Puncher) Punch(peerIP string, peerPort int, peerNATType nat.NATType) *PunchResult {
+func (p *Puncher) Punch(peerIP string, peerPort int, peerNATType protocol.NATType) *PunchResult {
 	// 检查是否可以直接连接
 	if p.canDirectConnect(peerNATType) {
 		conn, err := p.directConnect(peerIP, peerPort)
@@ -50,43 +63,45 @@ func (p *Puncher) Punch(peerIP string, peerPort int, peerNATType nat.NATType) *P
 			return &PunchResult{
 				Success:        true,
 				Conn:           conn,
-				ConnectionType: ConnectionTypeDirect,
+				ConnectionType: protocol.ConnectionDirect,
 			}
 		}
 	}
 
-	// 尝试打洞
+	// 尝试打洞，UDP 路径按协商的 MTU 分片收发
 	conn, err := p.holePunch(peerIP, peerPort, peerNATType)
 	if err == nil {
+		pc := pmtu.DiscoverConn(conn, pmtu.DefaultTimeout)
 		return &PunchResult{
 			Success:        true,
-			Conn:           conn,
-			ConnectionType: ConnectionTypeHolePunch,
+			Conn:           pc,
+			ConnectionType: protocol.ConnectionHolePunch,
+			MTU:            pc.MTU(),
 		}
 	}
 
 	// 打洞失败
 	return &PunchResult{
 		Success:        false,
-		ConnectionType: ConnectionTypeUnknown,
+		ConnectionType: protocol.ConnectionUnknown,
 		Error:          err,
 	}
 }
 
 // canDirectConnect 检查是否可以直接连接
-func (p *Puncher) canDirectConnect(peerNATType nat.NATType) bool {
+func (p *Puncher) canDirectConnect(peerNATType protocol.NATType) bool {
 	// 如果对方没有 NAT，可以直接连接
-	if peerNATType == nat.NATNone {
+	if peerNATType == protocol.NATNone {
 		return true
 	}
 
 	// 如果对方是完全锥形 NAT，可以直接连接
-	if peerNATType == nat.NATFull {
+	if peerNATType == protocol.NATFull {
 		return true
 	}
 
 	// 如果本地没有 NAT，可以直接连接
-	if p.natInfo.Type == nat.NATNone {
+	if p.natInfo.Type == protocol.NATNone {
 		return true
 	}
 
@@ -96,7 +111,7 @@ func (p *Puncher) canDirectConnect(peerNATType nat.NATType) bool {
 // directConnect 直接连接
 func (p *Puncher) directConnect(peerIP string, peerPort int) (net.Conn, error) {
 	// 创建 TCP 连接
-	conn, err := net.DialTimeout("tcp", fmt.Sprintf("%s:%d", peerIP, peerPort), p.timeout)
+	conn, err := p.transport.DialTimeout("tcp", fmt.Sprintf("%s:%d", peerIP, peerPort), p.timeout)
 	if err != nil {
 		return nil, fmt.Errorf("直接连接失败: %w", err)
 	}
@@ -104,13 +119,13 @@ func (p *Puncher) directConnect(peerIP string, peerPort int) (net.Conn, error) {
 }
 
 // holePunch 打洞连接
-func (p *Puncher) holePunch(peerIP string, peerPort int, peerNATType nat.NATType) (net.Conn, error) {
+func (p *Puncher) holePunch(peerIP string, peerPort int, peerNATType protocol.NATType) (net.Conn, error) {
 	// 创建 UDP 监听器
 	localAddr := &net.UDPAddr{
 		IP:   p.natInfo.LocalIP,
 		Port: p.localPort,
 	}
-	conn, err := net.ListenUDP("udp", localAddr)
+	conn, err := p.transport.ListenPacket("udp", localAddr.String())
 	if err != nil {
 		return nil, fmt.Errorf("创建 UDP 监听器失败: %w", err)
 	}
@@ -129,7 +144,7 @@ func (p *Puncher) holePunch(peerIP string, peerPort int, peerNATType nat.NATType
 	punchMsg := []byte("PUNCH")
 
 	// 创建接收通道
-	receiveCh := make(chan *net.UDPAddr, 1)
+	receiveCh := make(chan net.Addr, 1)
 	errorCh := make(chan error, 1)
 	stopCh := make(chan struct{})
 	var wg sync.WaitGroup
@@ -146,7 +161,7 @@ func (p *Puncher) holePunch(peerIP string, peerPort int, peerNATType nat.NATType
 			default:
 				// 接收数据
 				conn.SetReadDeadline(time.Now().Add(time.Second))
-				n, addr, err := conn.ReadFromUDP(buffer)
+				n, addr, err := conn.ReadFrom(buffer)
 				if err != nil {
 					if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 						continue
@@ -166,7 +181,7 @@ func (p *Puncher) holePunch(peerIP string, peerPort int, peerNATType nat.NATType
 
 	// 发送打洞消息
 	for i := 0; i < p.maxRetries; i++ {
-		_, err := conn.WriteToUDP(punchMsg, peerAddr)
+		_, err := conn.WriteTo(punchMsg, peerAddr)
 		if err != nil {
 			close(stopCh)
 			wg.Wait()
@@ -183,7 +198,7 @@ func (p *Puncher) holePunch(peerIP string, peerPort int, peerNATType nat.NATType
 		// 创建新的 UDP 连接
 		close(stopCh)
 		wg.Wait()
-		newConn, err := net.DialUDP("udp", nil, addr)
+		newConn, err := p.transport.DialTimeout("udp", addr.String(), p.timeout)
 		if err != nil {
 			return nil, fmt.Errorf("创建 UDP 连接失败: %w", err)
 		}
@@ -199,53 +214,38 @@ func (p *Puncher) holePunch(peerIP string, peerPort int, peerNATType nat.NATType
 	}
 }
 
-// PunchWithRelay 使用中继服务器打洞
-func (p *Puncher) PunchWithRelay(relayServer string, peerID string) *PunchResult {
-	// 连接中继服务器
-	conn, err := net.DialTimeout("tcp", relayServer, p.timeout)
+// PunchWithRelay 经中继服务器连接对端，auth 为中继握手认证信息。
+// 请求可恢复的会话时，与中继服务器的连接中断后自动重连
+func (p *Puncher) PunchWithRelay(relayServer string, peerID string, auth *RelayAuth) *PunchResult {
+	request, err := auth.Request(peerID)
 	if err != nil {
 		return &PunchResult{
 			Success:        false,
-			ConnectionType: ConnectionTypeUnknown,
-			Error:          fmt.Errorf("连接中继服务器失败: %w", err),
+			ConnectionType: protocol.ConnectionUnknown,
+			Error:          err,
 		}
 	}
 
-	// 发送中继请求
-	relayRequest := fmt.Sprintf("RELAY %s", peerID)
-	_, err = conn.Write([]byte(relayRequest))
-	if err != nil {
-		conn.Close()
-		return &PunchResult{
-			Success:        false,
-			ConnectionType: ConnectionTypeUnknown,
-			Error:          fmt.Errorf("发送中继请求失败: %w", err),
-		}
+	// 连接中继服务器
+	dial := func() (net.Conn, error) {
+		return p.dialRelay(relayServer)
 	}
-
-	// 设置超时
-	conn.SetDeadline(time.Now().Add(p.timeout))
-
-	// 接收中继响应
-	buffer := make([]byte, 1024)
-	n, err := conn.Read(buffer)
+	conn, err := dial()
 	if err != nil {
-		conn.Close()
 		return &PunchResult{
 			Success:        false,
-			ConnectionType: ConnectionTypeUnknown,
-			Error:          fmt.Errorf("接收中继响应失败: %w", err),
+			ConnectionType: protocol.ConnectionUnknown,
+			Error:          fmt.Errorf("连接中继服务器失败: %w", err),
 		}
 	}
 
-	// 检查响应
-	response := string(buffer[:n])
-	if response != "OK" {
-		conn.Close()
+	// 发送中继请求
+	conn, ticket, err := openRelaySession(conn, request, p.timeout, dial)
+	if err != nil {
 		return &PunchResult{
 			Success:        false,
-			ConnectionType: ConnectionTypeUnknown,
-			Error:          fmt.Errorf("中继服务器拒绝请求: %s", response),
+			ConnectionType: protocol.ConnectionUnknown,
+			Error:          err,
 		}
 	}
 
@@ -253,6 +253,23 @@ func (p *Puncher) PunchWithRelay(relayServer string, peerID string) *PunchResult
 	return &PunchResult{
 		Success:        true,
 		Conn:           conn,
-		ConnectionType: ConnectionTypeRelay,
+		ConnectionType: protocol.ConnectionRelay,
+		RelayTicket:    ticket,
+	}
+}
+
+// dialRelay 连接中继服务器，需要代理时经代理连接，否则使用打洞器的网络
+func (p *Puncher) dialRelay(relayServer string) (net.Conn, error) {
+	if p.proxy != nil {
+		proxyURL, err := p.proxy.ForRequest(&http.Request{URL: &url.URL{Scheme: "https", Host: relayServer}})
+		if err != nil {
+			return nil, err
+		}
+		if proxyURL != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+			defer cancel()
+			return p.proxy.DialContext(ctx, relayServer)
+		}
 	}
+	return p.transport.DialTimeout("tcp", relayServer, p.timeout)
 }
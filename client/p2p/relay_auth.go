@@ -0,0 +1,46 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/senma231/p3/client/identity"
+)
+
+// relayCertificateMethod 中继握手中证书签名覆盖的方法名，与服务端一致
+const relayCertificateMethod = "RELAY"
+
+// ErrRelayCredentials 没有可用于中继握手的票据或设备证书。设备令牌不在中继握手中明文发送
+var ErrRelayCredentials = errors.New("缺少中继票据或设备证书")
+
+// RelayAuth 中继握手认证信息
+type RelayAuth struct {
+	Ticket    string             // 信令服务器签发的一次性票据，优先使用
+	Identity  *identity.Identity // 没有票据时使用设备证书认证
+	Resumable bool               // 请求可恢复的会话，连接中断后可以重连恢复
+	E2E       bool               // 声明会话内与对端建立了端到端加密，中继只转发密文。中继无法验证，只能检查数据是否为 TLS 记录
+}
+
+// Request 构造中继握手请求，没有票据和可用的设备证书时返回 ErrRelayCredentials
+func (a *RelayAuth) Request(targetID string) (string, error) {
+	var request string
+	if a.Ticket != "" {
+		request = fmt.Sprintf("RELAY %s TICKET %s", targetID, a.Ticket)
+	} else if a.Identity.Enrolled() {
+		// 证书私钥签名覆盖目标节点，握手请求不能用于连接其他节点
+		credentials, err := a.Identity.Credentials(relayCertificateMethod, targetID)
+		if err != nil {
+			return "", fmt.Errorf("生成中继握手凭据失败: %w", err)
+		}
+		request = fmt.Sprintf("RELAY %s CERT %s", targetID, credentials)
+	} else {
+		return "", ErrRelayCredentials
+	}
+	if a.E2E {
+		request += " E2E"
+	}
+	if a.Resumable {
+		request += " RESUMABLE"
+	}
+	return request, nil
+}
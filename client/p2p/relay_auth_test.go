@@ -0,0 +1,80 @@
+package p2p
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRelayAuthRequest(t *testing.T) {
+	auth := &RelayAuth{Ticket: "t1", Resumable: true, E2E: true}
+	request, err := auth.Request("node-b")
+	if err != nil || request != "RELAY node-b TICKET t1 E2E RESUMABLE" {
+		t.Fatalf("握手请求为 %q, %v", request, err)
+	}
+
+	// 没有票据和设备证书时不构造请求，设备令牌不在握手中发送
+	if _, err := (&RelayAuth{Resumable: true}).Request("node-b"); !errors.Is(err, ErrRelayCredentials) {
+		t.Fatalf("缺少凭据时应返回 ErrRelayCredentials, 实际为 %v", err)
+	}
+}
+
+func TestPunchWithRelay(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer listener.Close()
+
+	requests := make(chan string, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				buf := make([]byte, 256)
+				n, err := conn.Read(buf)
+				if err != nil {
+					return
+				}
+				requests <- string(buf[:n])
+				conn.Write([]byte("OK\n"))
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	p := NewPuncher(0, nil, time.Second, 1)
+	p.proxy = nil
+
+	result := p.PunchWithRelay(listener.Addr().String(), "node-b", &RelayAuth{Ticket: "t1"})
+	if !result.Success {
+		t.Fatalf("中继连接失败: %v", result.Error)
+	}
+	defer result.Conn.Close()
+	if request := <-requests; request != "RELAY node-b TICKET t1" {
+		t.Fatalf("中继收到的握手请求为 %q", request)
+	}
+
+	result.Conn.Write([]byte("ping"))
+	buf := make([]byte, 4)
+	result.Conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := io.ReadFull(result.Conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("经中继收到 %q, %v", buf, err)
+	}
+
+	// 没有凭据时不连接中继
+	if result := p.PunchWithRelay(listener.Addr().String(), "node-b", &RelayAuth{}); result.Success || !errors.Is(result.Error, ErrRelayCredentials) {
+		t.Fatalf("缺少凭据时应失败, 实际为 %+v", result)
+	}
+	select {
+	case request := <-requests:
+		t.Fatalf("缺少凭据时不应发送握手请求: %q", request)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,49 @@
+package p2p
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/senma231/p3/client/identity"
+)
+
+// relayE2ETimeout 中继会话内端到端加密握手的超时
+const relayE2ETimeout = 10 * time.Second
+
+// ErrRelayE2EUnavailable 没有设备证书或 CA 证书，无法在中继会话内建立端到端加密
+var ErrRelayE2EUnavailable = errors.New("缺少设备证书，无法建立中继端到端加密")
+
+// relayE2EAvailable 设备是否持有可用于中继端到端加密的证书和 CA 证书
+func relayE2EAvailable(id *identity.Identity) bool {
+	return id.Enrolled() && id.CanVerifyPeers()
+}
+
+// secureRelay 在中继会话内与对端建立以设备证书双向认证的 TLS 连接，中继只能看到 TLS 记录。
+// 节点 ID 较小的一方作为 TLS 客户端；对端证书须由同一 CA 签发、未吊销且通用名为 peerID。
+// TLS 建立在可迁移的中继连接之上，迁移到其他中继时不需要重新握手
+func secureRelay(conn net.Conn, id *identity.Identity, localID, peerID string, timeout time.Duration) (net.Conn, error) {
+	if !relayE2EAvailable(id) {
+		return nil, ErrRelayE2EUnavailable
+	}
+	config, err := id.TLSConfig(peerID)
+	if err != nil {
+		return nil, err
+	}
+
+	var tlsConn *tls.Conn
+	if localID < peerID {
+		tlsConn = tls.Client(conn, config)
+	} else {
+		tlsConn = tls.Server(conn, config)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, fmt.Errorf("中继端到端加密握手失败: %w", err)
+	}
+	return tlsConn, nil
+}
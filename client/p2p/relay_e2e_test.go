@@ -0,0 +1,159 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/identity"
+)
+
+// enrollTestIdentities 模拟服务端内置 CA，为每个节点签发设备证书
+func enrollTestIdentities(t *testing.T, nodeIDs ...string) []*identity.Identity {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, _ := x509.ParseCertificate(caDER)
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+
+	ids := make([]*identity.Identity, 0, len(nodeIDs))
+	for i, nodeID := range nodeIDs {
+		dir := t.TempDir()
+		id, err := identity.Load(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), filepath.Join(dir, "ca.pem"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+			SerialNumber: big.NewInt(int64(i + 2)),
+			Subject:      pkix.Name{CommonName: nodeID},
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, caCert, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := id.Install(key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), caPEM); err != nil {
+			t.Fatalf("保存证书失败: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// recordingConn 记录经过中继转发的原始数据
+type recordingConn struct {
+	net.Conn
+	mu      sync.Mutex
+	written bytes.Buffer
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.written.Write(p)
+	c.mu.Unlock()
+	return c.Conn.Write(p)
+}
+
+func TestSecureRelay(t *testing.T) {
+	ids := enrollTestIdentities(t, "node-a", "node-b")
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	relayed := &recordingConn{Conn: a}
+
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	peer := make(chan result, 1)
+	go func() {
+		conn, err := secureRelay(b, ids[1], "node-b", "node-a", time.Second)
+		peer <- result{conn, err}
+	}()
+	conn, err := secureRelay(relayed, ids[0], "node-a", "node-b", time.Second)
+	if err != nil {
+		t.Fatalf("建立中继端到端加密失败: %v", err)
+	}
+	r := <-peer
+	if r.err != nil {
+		t.Fatalf("对端建立中继端到端加密失败: %v", r.err)
+	}
+
+	secret := []byte("relay plaintext")
+	go conn.Write(secret)
+	got := make([]byte, len(secret))
+	if _, err := io.ReadFull(r.conn, got); err != nil || !bytes.Equal(got, secret) {
+		t.Fatalf("对端收到的数据错误: %q %v", got, err)
+	}
+
+	// 中继只能看到 TLS 记录，看不到明文
+	relayed.mu.Lock()
+	raw := relayed.written.Bytes()
+	relayed.mu.Unlock()
+	if len(raw) < 2 || raw[0] != 22 || raw[1] != 3 || bytes.Contains(raw, secret) {
+		t.Fatalf("中继转发的数据不是 TLS 记录: %x", raw)
+	}
+}
+
+func TestSecureRelayWrongPeer(t *testing.T) {
+	ids := enrollTestIdentities(t, "node-a", "node-b", "node-c")
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+
+	// node-c 冒充 node-b
+	go secureRelay(b, ids[2], "node-c", "node-a", time.Second)
+	if _, err := secureRelay(a, ids[0], "node-a", "node-b", time.Second); err == nil {
+		t.Fatal("对端证书不属于目标节点时不应建立加密")
+	}
+}
+
+func TestRelayAuthE2E(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Security.RelayE2E = true
+	c := &Connector{config: cfg}
+
+	// 没有设备证书时不能在会话内加密，不声明 E2E
+	if c.relayAuth("t").E2E {
+		t.Fatal("没有设备证书时不应声明端到端加密")
+	}
+	if _, err := secureRelay(nil, nil, "node-a", "node-b", time.Second); !errors.Is(err, ErrRelayE2EUnavailable) {
+		t.Fatalf("期望 ErrRelayE2EUnavailable，实际 %v", err)
+	}
+
+	c.identity = enrollTestIdentities(t, "node-a")[0]
+	if !c.relayAuth("t").E2E {
+		t.Fatal("持有设备证书时应声明端到端加密")
+	}
+	cfg.Security.RelayE2E = false
+	if c.relayAuth("t").E2E {
+		t.Fatal("未启用 relayE2E 时不应声明端到端加密")
+	}
+}
@@ -0,0 +1,281 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/resume"
+)
+
+const (
+	// relayMigrationBuffer 迁移期间写入的数据先缓冲，旧中继的数据读完后再写入新中继，缓冲满时写入阻塞
+	relayMigrationBuffer = 1 << 20
+	// relayMigrationTimeout 等待旧中继发送完剩余数据的最长时间，超时后直接切换到新中继
+	relayMigrationTimeout = 10 * time.Second
+)
+
+// relayConn 可以迁移到其他中继的中继连接。
+//
+// 迁移时先与新中继建立会话，之后写入的数据暂存在缓冲区；通知旧中继结束会话后，
+// 旧中继把已收到的数据转发给目标节点，并发送完已从目标节点读取的数据后关闭会话，
+// 读完旧会话后改从新中继读写，再把缓冲的数据写入新中继，上层读写不受影响
+type relayConn struct {
+	relayID string
+	ticket  string // 旧中继上的会话票据，会话不可恢复时为空，不支持迁移
+	dial    func() (net.Conn, error)
+	e2e     bool // 会话内建立了端到端加密，迁移到新中继时同样声明
+
+	writeMu sync.Mutex // 保证迁移开始时统计的已写入字节数不包含正在进行的写入
+	mu      sync.Mutex
+	cond    *sync.Cond
+	conn    net.Conn // 当前读写的连接
+	next    net.Conn // 迁移中的新连接，旧连接读完后切换
+	pending []byte   // 迁移期间写入的数据
+
+	nextRelayID string
+	nextTicket  string
+	nextDial    func() (net.Conn, error)
+
+	closed bool
+	// migrating 迁移进行中，写入的数据进入缓冲区
+	migrating bool
+}
+
+// newRelayConn 包装中继会话，dial 用于连接当前的中继服务器
+func newRelayConn(conn net.Conn, relayID, ticket string, dial func() (net.Conn, error)) *relayConn {
+	c := &relayConn{relayID: relayID, ticket: ticket, dial: dial, conn: conn}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// RelayID 当前使用的中继
+func (c *relayConn) RelayID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.relayID
+}
+
+// Migrate 迁移到新的中继。open 与新中继完成握手，返回新的会话、会话票据和连接新中继的函数。
+// 通知旧中继失败时放弃迁移，继续使用旧中继
+func (c *relayConn) Migrate(relayID string, open func() (net.Conn, string, func() (net.Conn, error), error)) error {
+	c.mu.Lock()
+	switch {
+	case c.closed:
+		c.mu.Unlock()
+		return resume.ErrClosed
+	case c.migrating:
+		c.mu.Unlock()
+		return fmt.Errorf("中继会话正在迁移")
+	case c.ticket == "":
+		c.mu.Unlock()
+		return fmt.Errorf("中继会话不可恢复，不支持迁移")
+	}
+	c.mu.Unlock()
+
+	next, ticket, dial, err := open()
+	if err != nil {
+		return fmt.Errorf("连接新中继失败: %w", err)
+	}
+
+	// 等待正在进行的写入完成，之后的写入进入缓冲区。旧中继随时可能结束会话，需先登记新连接
+	c.writeMu.Lock()
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		c.writeMu.Unlock()
+		next.Close()
+		return resume.ErrClosed
+	}
+	old, oldDial, oldTicket := c.conn, c.dial, c.ticket
+	c.migrating = true
+	c.next = next
+	c.nextRelayID, c.nextTicket, c.nextDial = relayID, ticket, dial
+	c.mu.Unlock()
+	var sent uint64
+	if session, ok := old.(*resume.Session); ok {
+		sent = session.Sent()
+	}
+	c.writeMu.Unlock()
+
+	if err := finishRelayMigration(oldDial, oldTicket, sent); err != nil {
+		c.abortMigration(next)
+		return fmt.Errorf("结束旧中继上的会话失败: %w", err)
+	}
+
+	// 旧中继未按时结束会话时直接切换
+	time.AfterFunc(relayMigrationTimeout, func() { c.switchConn(old) })
+	return nil
+}
+
+// abortMigration 放弃迁移，把缓冲的数据写入旧连接。旧连接已读完并切换到新连接时不做处理
+func (c *relayConn) abortMigration(next net.Conn) {
+	c.mu.Lock()
+	if c.next != next {
+		c.mu.Unlock()
+		return
+	}
+	c.next = nil
+	if len(c.pending) > 0 {
+		c.conn.Write(c.pending)
+		c.pending = nil
+	}
+	c.migrating = false
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	next.Close()
+}
+
+// switchConn 旧连接 old 读完后切换到新连接，把缓冲的数据写入新连接。已切换时不做处理
+func (c *relayConn) switchConn(old net.Conn) {
+	c.mu.Lock()
+	if c.conn != old || c.next == nil {
+		c.mu.Unlock()
+		return
+	}
+	c.conn, c.next = c.next, nil
+	c.relayID, c.ticket, c.dial = c.nextRelayID, c.nextTicket, c.nextDial
+	relayID := c.relayID
+	var err error
+	if len(c.pending) > 0 {
+		_, err = c.conn.Write(c.pending)
+		c.pending = nil
+	}
+	c.migrating = false
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	old.Close()
+	if err != nil {
+		fmt.Printf("写入新中继失败: %v\n", err)
+		return
+	}
+	fmt.Printf("中继会话已迁移到 %s\n", relayID)
+}
+
+// Read 读取数据，迁移时读完旧连接后改从新连接读取
+func (c *relayConn) Read(p []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		conn := c.conn
+		c.mu.Unlock()
+
+		n, err := conn.Read(p)
+		if n > 0 || err == nil {
+			return n, err
+		}
+
+		c.mu.Lock()
+		switched := c.conn != conn
+		migrated := c.next != nil
+		c.mu.Unlock()
+		switch {
+		case switched:
+			continue
+		case migrated:
+			c.switchConn(conn)
+			continue
+		}
+		return n, err
+	}
+}
+
+// Write 写入数据，迁移期间写入缓冲区，缓冲区已满时阻塞直到迁移完成
+func (c *relayConn) Write(p []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	c.mu.Lock()
+	for c.migrating && len(c.pending) > 0 && len(c.pending)+len(p) > relayMigrationBuffer {
+		c.cond.Wait()
+	}
+	if c.closed {
+		c.mu.Unlock()
+		return 0, resume.ErrClosed
+	}
+	if c.migrating {
+		c.pending = append(c.pending, p...)
+		c.mu.Unlock()
+		return len(p), nil
+	}
+	conn := c.conn
+	c.mu.Unlock()
+
+	return conn.Write(p)
+}
+
+// Close 关闭连接，迁移中的新连接一并关闭
+func (c *relayConn) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	conn, next := c.conn, c.next
+	c.next = nil
+	c.migrating = false
+	c.cond.Broadcast()
+	c.mu.Unlock()
+
+	if next != nil {
+		next.Close()
+	}
+	return conn.Close()
+}
+
+// current 获取当前读写的连接
+func (c *relayConn) current() net.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+// LocalAddr 当前连接的本地地址
+func (c *relayConn) LocalAddr() net.Addr {
+	return c.current().LocalAddr()
+}
+
+// RemoteAddr 当前连接的远程地址
+func (c *relayConn) RemoteAddr() net.Addr {
+	return c.current().RemoteAddr()
+}
+
+// SetDeadline 设置当前连接的超时
+func (c *relayConn) SetDeadline(t time.Time) error {
+	return c.current().SetDeadline(t)
+}
+
+// SetReadDeadline 设置当前连接的读取超时
+func (c *relayConn) SetReadDeadline(t time.Time) error {
+	return c.current().SetReadDeadline(t)
+}
+
+// SetWriteDeadline 设置当前连接的写入超时
+func (c *relayConn) SetWriteDeadline(t time.Time) error {
+	return c.current().SetWriteDeadline(t)
+}
+
+// finishRelayMigration 连接旧中继，告知已通过旧会话写入的字节数，旧中继转发完这些数据后结束会话
+func finishRelayMigration(dial func() (net.Conn, error), ticket string, sent uint64) error {
+	conn, err := dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := fmt.Fprintf(conn, "MIGRATED %s %d\n", ticket, sent); err != nil {
+		return err
+	}
+	response, err := readRelayResponse(conn)
+	if err != nil {
+		return err
+	}
+	if response != "OK" {
+		return fmt.Errorf("中继服务器拒绝请求: %s", response)
+	}
+	return nil
+}
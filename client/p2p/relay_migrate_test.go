@@ -0,0 +1,95 @@
+package p2p
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRelayConnMigrate(t *testing.T) {
+	oldClient, oldRelay := net.Pipe()
+	newClient, newRelay := net.Pipe()
+	requests := make(chan string, 1)
+	dialOld := func() (net.Conn, error) {
+		client, server := net.Pipe()
+		go func() {
+			defer server.Close()
+			line, _ := bufio.NewReader(server).ReadString('\n')
+			requests <- strings.TrimSpace(line)
+			server.Write([]byte("OK\n"))
+		}()
+		return client, nil
+	}
+	conn := newRelayConn(oldClient, "relay-a", "t", dialOld)
+	defer conn.Close()
+
+	// 迁移前写入旧中继
+	go conn.Write([]byte("before"))
+	buf := make([]byte, 16)
+	if n, _ := oldRelay.Read(buf); string(buf[:n]) != "before" {
+		t.Fatalf("旧中继收到 %q", buf[:n])
+	}
+
+	err := conn.Migrate("relay-b", func() (net.Conn, string, func() (net.Conn, error), error) {
+		return newClient, "t2", nil, nil
+	})
+	if err != nil {
+		t.Fatalf("迁移失败: %v", err)
+	}
+	if request := <-requests; request != "MIGRATED t 0" {
+		t.Fatalf("结束旧会话的请求错误: %q", request)
+	}
+
+	// 迁移期间的写入进入缓冲区，管道没有读取方时写入仍立即返回
+	if _, err := conn.Write([]byte("during")); err != nil {
+		t.Fatalf("迁移期间写入失败: %v", err)
+	}
+
+	// 旧中继发送完剩余数据后结束会话，之后改从新中继读取，缓冲的数据写入新中继
+	received := make(chan string, 1)
+	go func() {
+		n, _ := io.ReadFull(newRelay, buf[:len("during")])
+		received <- string(buf[:n])
+		newRelay.Write([]byte("after"))
+	}()
+	go func() {
+		oldRelay.Write([]byte("tail"))
+		oldRelay.Close()
+	}()
+
+	reader := bufio.NewReader(conn)
+	data := make([]byte, len("tailafter"))
+	if _, err := io.ReadFull(reader, data); err != nil || string(data) != "tailafter" {
+		t.Fatalf("迁移后读取到 %q: %v", data, err)
+	}
+	select {
+	case got := <-received:
+		if got != "during" {
+			t.Fatalf("新中继收到 %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("缓冲的数据未写入新中继")
+	}
+	if relayID := conn.RelayID(); relayID != "relay-b" {
+		t.Fatalf("迁移后的中继为 %s", relayID)
+	}
+}
+
+func TestRelayConnMigrateRequiresTicket(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := newRelayConn(client, "relay-a", "", nil)
+	defer conn.Close()
+
+	opened := false
+	err := conn.Migrate("relay-b", func() (net.Conn, string, func() (net.Conn, error), error) {
+		opened = true
+		return nil, "", nil, nil
+	})
+	if err == nil || opened {
+		t.Fatalf("不可恢复的会话不应迁移")
+	}
+}
@@ -0,0 +1,159 @@
+package p2p
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/senma231/p3/common/resume"
+)
+
+const (
+	// relayResumeBackoff 和 relayResumeMaxBackoff 重连中继服务器恢复会话的重试间隔
+	relayResumeBackoff    = 500 * time.Millisecond
+	relayResumeMaxBackoff = 5 * time.Second
+)
+
+// errRelaySessionGone 中继服务器上的会话已不存在，无法恢复
+var errRelaySessionGone = errors.New("中继会话已不存在")
+
+// openRelay 在已连接的中继服务器上完成握手。服务器签发会话票据时返回可恢复的会话，
+// 与中继服务器的连接中断后通过 dial 重连并恢复会话，对上层透明
+func openRelay(conn net.Conn, request string, timeout time.Duration, dial func() (net.Conn, error)) (net.Conn, error) {
+	conn, _, err := openRelaySession(conn, request, timeout, dial)
+	return conn, err
+}
+
+// openRelaySession 与 openRelay 相同，同时返回会话票据，会话不可恢复时票据为空。
+// 迁移会话时凭票据结束旧中继上的会话
+func openRelaySession(conn net.Conn, request string, timeout time.Duration, dial func() (net.Conn, error)) (net.Conn, string, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write([]byte(request)); err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("发送中继请求失败: %w", err)
+	}
+	response, err := readRelayResponse(conn)
+	if err != nil {
+		conn.Close()
+		return nil, "", fmt.Errorf("读取中继响应失败: %w", err)
+	}
+	ticket, grace, err := parseRelayResponse(response)
+	if err != nil {
+		conn.Close()
+		return nil, "", err
+	}
+	conn.SetDeadline(time.Time{})
+
+	if ticket == "" {
+		return conn, "", nil
+	}
+	var session *resume.Session
+	session = resume.New(resume.Config{
+		Grace: grace,
+		OnDisconnect: func(err error) {
+			fmt.Printf("中继连接中断，尝试恢复会话: %v\n", err)
+			reconnectRelay(session, ticket, grace, dial)
+		},
+	})
+	session.Attach(conn, 0)
+	return session, ticket, nil
+}
+
+// parseRelayResponse 解析中继握手响应，服务器签发会话票据时返回票据和等待恢复的时间
+func parseRelayResponse(response string) (string, time.Duration, error) {
+	fields := strings.Fields(response)
+	switch {
+	case len(fields) == 1 && fields[0] == "OK":
+		return "", 0, nil
+	case len(fields) == 4 && fields[0] == "OK" && fields[1] == "RESUMABLE":
+		seconds, err := strconv.Atoi(fields[3])
+		if err != nil || seconds <= 0 {
+			return "", 0, fmt.Errorf("无效的会话恢复时间: %s", fields[3])
+		}
+		return fields[2], time.Duration(seconds) * time.Second, nil
+	}
+	return "", 0, fmt.Errorf("中继服务器拒绝请求: %s", response)
+}
+
+// readRelayResponse 逐字节读取中继响应直到换行，不多读之后的数据。
+// 服务器拒绝请求时响应不带换行并随即断开连接
+func readRelayResponse(conn net.Conn) (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for len(line) < maxHandshakeLen {
+		if _, err := conn.Read(buf); err != nil {
+			if len(line) > 0 {
+				return string(line), nil
+			}
+			return "", err
+		}
+		if buf[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, buf[0])
+	}
+	return "", fmt.Errorf("中继响应过长")
+}
+
+// reconnectRelay 在等待恢复的时间内重连中继服务器，失败时关闭会话
+func reconnectRelay(session *resume.Session, ticket string, grace time.Duration, dial func() (net.Conn, error)) {
+	deadline := time.Now().Add(grace)
+	backoff := relayResumeBackoff
+	for time.Now().Before(deadline) {
+		err := resumeRelay(session, ticket, dial)
+		if err == nil {
+			fmt.Printf("中继会话已恢复\n")
+			return
+		}
+		if errors.Is(err, errRelaySessionGone) {
+			break
+		}
+		fmt.Printf("恢复中继会话失败: %v\n", err)
+
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > relayResumeMaxBackoff {
+			backoff = relayResumeMaxBackoff
+		}
+	}
+	fmt.Printf("中继会话无法恢复\n")
+	session.Close()
+}
+
+// resumeRelay 重连中继服务器，交换双方已接收的字节数后恢复会话
+func resumeRelay(session *resume.Session, ticket string, dial func() (net.Conn, error)) error {
+	conn, err := dial()
+	if err != nil {
+		return err
+	}
+
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	if _, err := fmt.Fprintf(conn, "RESUME %s %d\n", ticket, session.Received()); err != nil {
+		conn.Close()
+		return err
+	}
+	response, err := readRelayResponse(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	fields := strings.Fields(response)
+	if len(fields) != 2 || fields[0] != "OK" {
+		conn.Close()
+		return fmt.Errorf("%w: %s", errRelaySessionGone, response)
+	}
+	received, err := strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("%w: 无效的接收位置 %s", errRelaySessionGone, fields[1])
+	}
+	conn.SetDeadline(time.Time{})
+
+	if err := session.Attach(conn, received); err != nil {
+		conn.Close()
+		return fmt.Errorf("%w: %v", errRelaySessionGone, err)
+	}
+	return nil
+}
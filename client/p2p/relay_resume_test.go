@@ -0,0 +1,101 @@
+package p2p
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/common/resume"
+)
+
+// fakeRelay 签发会话票据并回显数据的中继服务器，kill 断开当前连接
+type fakeRelay struct {
+	listener net.Listener
+	session  *resume.Session
+	conns    chan net.Conn
+}
+
+func newFakeRelay(t *testing.T) *fakeRelay {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	r := &fakeRelay{listener: listener, session: resume.New(resume.Config{}), conns: make(chan net.Conn, 4)}
+	go io.Copy(r.session, r.session)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			line, _ := bufio.NewReader(io.LimitReader(conn, 128)).ReadString('\n')
+			if !strings.HasPrefix(line, "RESUME t ") {
+				// 首次握手
+				fmt.Fprintf(conn, "OK RESUMABLE t 5\n")
+				r.session.Attach(conn, 0)
+			} else {
+				var received uint64
+				fmt.Sscanf(line, "RESUME t %d", &received)
+				fmt.Fprintf(conn, "OK %d\n", r.session.Received())
+				r.session.Attach(conn, received)
+			}
+			r.conns <- conn
+		}
+	}()
+	return r
+}
+
+func TestOpenRelayResume(t *testing.T) {
+	relay := newFakeRelay(t)
+	defer relay.listener.Close()
+	dial := func() (net.Conn, error) { return net.Dial("tcp", relay.listener.Addr().String()) }
+
+	raw, err := dial()
+	if err != nil {
+		t.Fatalf("连接中继失败: %v", err)
+	}
+	// 握手请求以换行结尾，方便测试服务器按行读取
+	conn, err := openRelay(raw, "RELAY node-b TICKET x RESUMABLE\n", time.Second, dial)
+	if err != nil {
+		t.Fatalf("中继握手失败: %v", err)
+	}
+	defer conn.Close()
+	first := <-relay.conns
+
+	reader := bufio.NewReader(conn)
+	for i := 0; i < 3; i++ {
+		fmt.Fprintf(conn, "line %d\n", i)
+		if i == 1 {
+			// 模拟网络切换，客户端应自动重连并恢复会话
+			first.Close()
+			select {
+			case <-relay.conns:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("客户端未重连")
+			}
+		}
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := reader.ReadString('\n')
+		if err != nil || line != fmt.Sprintf("line %d\n", i) {
+			t.Fatalf("第 %d 行回显为 %q, %v", i, line, err)
+		}
+	}
+}
+
+func TestParseRelayResponse(t *testing.T) {
+	if ticket, grace, err := parseRelayResponse("OK"); err != nil || ticket != "" || grace != 0 {
+		t.Errorf("OK 解析结果为 %q %v %v", ticket, grace, err)
+	}
+	if ticket, grace, err := parseRelayResponse("OK RESUMABLE abcd 30"); err != nil || ticket != "abcd" || grace != 30*time.Second {
+		t.Errorf("可恢复响应解析结果为 %q %v %v", ticket, grace, err)
+	}
+	for _, response := range []string{"ERROR: Authentication failed", "OK RESUMABLE abcd 0", "OK RESUMABLE abcd"} {
+		if _, _, err := parseRelayResponse(response); err == nil {
+			t.Errorf("%q: 应解析失败", response)
+		}
+	}
+}
@@ -1,75 +1,156 @@
 package p2p
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/endpoint"
+	"github.com/senma231/p3/client/identity"
 	"github.com/senma231/p3/client/nat"
+	"github.com/senma231/p3/client/proxy"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/common/version"
 )
 
-// SignalType 信令类型
-type SignalType string
-
-const (
-	SignalOffer           SignalType = "offer"
-	SignalAnswer          SignalType = "answer"
-	SignalICECandidate    SignalType = "ice-candidate"
-	SignalConnect         SignalType = "connect"
-	SignalDisconnect      SignalType = "disconnect"
-	SignalPing            SignalType = "ping"
-	SignalPong            SignalType = "pong"
-	SignalRelayRequest    SignalType = "relay-request"
-	SignalRelayResponse   SignalType = "relay-response"
-	SignalError           SignalType = "error"
-)
-
-// Signal 信令消息
-type Signal struct {
-	Type      SignalType  `json:"type"`
-	SenderID  string      `json:"senderId"`
-	ReceiverID string     `json:"receiverId,omitempty"`
-	Payload   interface{} `json:"payload,omitempty"`
-	Timestamp time.Time   `json:"timestamp"`
-}
+// ErrUpgradeRequired 客户端版本低于服务端要求的最低版本，需要升级后才能连接
+var ErrUpgradeRequired = errors.New("客户端版本过低，需要升级")
 
 // SignalHandler 信令处理函数
-type SignalHandler func(signal *Signal)
+type SignalHandler func(signal *protocol.Signal)
 
 // SignalingClient 信令客户端
 type SignalingClient struct {
-	config      *config.Config
-	natInfo     *nat.NATInfo
-	conn        *websocket.Conn
-	handlers    map[SignalType][]SignalHandler
-	sendCh      chan *Signal
-	stopCh      chan struct{}
-	connected   bool
-	reconnect   bool
-	mu          sync.RWMutex
-	pingTicker  *time.Ticker
-	pongWait    time.Duration
-	pingPeriod  time.Duration
+	config     *config.Config
+	natInfo    *nat.NATInfo
+	transport  signalTransport // 当前的信令传输通道，未连接时为 nil
+	done       chan struct{}   // 当前传输通道的协程停止信号
+	handlers   map[protocol.SignalType][]SignalHandler
+	sendQueue  *protocol.SendQueue // 按优先级发送的信令队列，断开期间的信令在重连后发送
+	connected  bool
+	reconnect  bool
+	mu         sync.RWMutex
+	pongWait   time.Duration
+	pingPeriod time.Duration
+	endpoints  *endpoint.Pool
+	proxy      *proxy.Proxy
+	identity   *identity.Identity // 持有证书时使用证书认证，代替令牌
+	instance   string             // 本进程的实例 ID，服务端据此区分切换传输方式和其他使用相同节点 ID 的设备
+
+	subscribed       map[string]bool // 订阅在线状态的节点
+	presence         map[string]bool // 已收到的节点在线状态，断开连接时清空
+	presenceHandlers []PresenceHandler
+
+	// ctx 在 Disconnect 时取消，wg 等待收发、重连等协程全部退出
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// sendQueueCapacity 信令发送队列每个优先级最多缓存的信令数
+const sendQueueCapacity = 100
+
+// signalTransport 信令传输通道，WebSocket 或 HTTPS 长轮询
+type signalTransport interface {
+	// Send 发送一条已序列化的信令消息
+	Send(data []byte) error
+	// Close 关闭传输通道
+	Close()
+	// Name 传输方式名称
+	Name() string
+}
+
+// wsTransport 基于 WebSocket 的信令传输
+type wsTransport struct {
+	conn   *websocket.Conn
+	binary bool       // 协商了 MessagePack 子协议，信令以二进制帧发送
+	mu     sync.Mutex // WebSocket 同一时间只允许一个写入者
+}
+
+// newWSTransport 根据握手协商的子协议创建 WebSocket 传输
+func newWSTransport(conn *websocket.Conn) *wsTransport {
+	return &wsTransport{conn: conn, binary: conn.Subprotocol() == protocol.SubprotocolMsgpack}
+}
+
+// Send 发送信令消息，协商了压缩时只压缩超过阈值的消息
+func (t *wsTransport) Send(data []byte) error {
+	frameType := websocket.TextMessage
+	if t.binary {
+		encoded, err := protocol.EncodeMsgpack(data)
+		if err != nil {
+			return fmt.Errorf("编码信令消息失败: %w", err)
+		}
+		frameType, data = websocket.BinaryMessage, encoded
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.conn.EnableWriteCompression(len(data) >= protocol.CompressionThreshold)
+	return t.conn.WriteMessage(frameType, data)
+}
+
+// ping 发送 Ping 消息
+func (t *wsTransport) ping() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+// Close 发送关闭消息后关闭连接
+func (t *wsTransport) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	t.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	t.conn.Close()
+}
+
+// Name 传输方式名称
+func (t *wsTransport) Name() string {
+	return config.SignalingWebSocket
 }
 
 // NewSignalingClient 创建信令客户端
 func NewSignalingClient(cfg *config.Config, natInfo *nat.NATInfo) *SignalingClient {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &SignalingClient{
 		config:     cfg,
 		natInfo:    natInfo,
-		handlers:   make(map[SignalType][]SignalHandler),
-		sendCh:     make(chan *Signal, 100),
-		stopCh:     make(chan struct{}),
+		handlers:   make(map[protocol.SignalType][]SignalHandler),
+		sendQueue:  protocol.NewSendQueue(sendQueueCapacity),
 		reconnect:  true,
 		pongWait:   60 * time.Second,
 		pingPeriod: 30 * time.Second,
+		endpoints:  endpoint.NewPool(cfg.ServerEndpoints(), 5*time.Second),
+		proxy:      proxy.FromEnvironment(),
+		instance:   newInstanceID(),
+		subscribed: make(map[string]bool),
+		presence:   make(map[string]bool),
+		ctx:        ctx,
+		cancel:     cancel,
 	}
 }
 
+// SetEndpointPool 设置服务器端点池，与 REST 客户端共享健康检查结果
+func (c *SignalingClient) SetEndpointPool(pool *endpoint.Pool) {
+	c.endpoints = pool
+}
+
+// SetProxy 设置连接信令服务器使用的代理，需在 Connect 之前调用
+func (c *SignalingClient) SetProxy(px *proxy.Proxy) {
+	c.proxy = px
+}
+
 // Connect 连接到信令服务器
 func (c *SignalingClient) Connect() error {
 	c.mu.Lock()
@@ -78,17 +159,158 @@ func (c *SignalingClient) Connect() error {
 	if c.connected {
 		return nil
 	}
+	if c.ctx.Err() != nil {
+		return fmt.Errorf("信令客户端已断开")
+	}
 
-	// 解析服务器地址
-	serverURL := c.config.Server.Address
-	if serverURL == "" {
+	// 按延迟和健康状态依次尝试各服务器地址
+	addresses := c.endpoints.Addresses()
+	if len(addresses) == 0 {
 		return fmt.Errorf("服务器地址为空")
 	}
 
+	var t signalTransport
+	var target string
+	var lastErr error
+	for _, address := range addresses {
+		t, target, lastErr = c.open(address)
+		if lastErr == nil || errors.Is(lastErr, ErrUpgradeRequired) {
+			break
+		}
+		fmt.Printf("连接信令服务器 %s 失败: %v\n", address, lastErr)
+		c.endpoints.MarkFailed(address)
+	}
+	if lastErr != nil {
+		return fmt.Errorf("连接到信令服务器失败: %w", lastErr)
+	}
+
+	c.start(t)
+	fmt.Printf("已连接到信令服务器: %s (%s)\n", target, t.Name())
+	return nil
+}
+
+// open 按配置的传输方式连接指定服务器。auto 模式下 WebSocket 连接失败时改用 HTTPS 长轮询
+func (c *SignalingClient) open(serverURL string) (signalTransport, string, error) {
+	mode := c.config.Server.SignalingTransport
+	if mode != config.SignalingPoll {
+		conn, wsURL, err := c.dial(serverURL)
+		if err == nil {
+			return newWSTransport(conn), wsURL, nil
+		}
+		if mode == config.SignalingWebSocket || errors.Is(err, ErrUpgradeRequired) {
+			return nil, "", err
+		}
+		fmt.Printf("WebSocket 连接 %s 失败，改用 HTTPS 长轮询: %v\n", serverURL, err)
+	}
+	return c.openPoll(serverURL)
+}
+
+// start 开始使用传输通道收发信令。调用方需持有锁
+func (c *SignalingClient) start(t signalTransport) {
+	c.transport = t
+	done := make(chan struct{})
+	c.done = done
+	c.connected = true
+
+	c.goTracked(func() { c.writePump(t, done) })
+	switch t := t.(type) {
+	case *wsTransport:
+		// 设置 Pong 处理函数
+		t.conn.SetPongHandler(func(string) error {
+			t.conn.SetReadDeadline(time.Now().Add(c.pongWait))
+			return nil
+		})
+		c.goTracked(func() { c.readPump(t) })
+		c.goTracked(func() { c.pingLoop(t, done) })
+	case *pollTransport:
+		c.goTracked(func() { t.run(c, done) })
+		// WebSocket 恢复可用后切换回去
+		if c.config.Server.SignalingTransport != config.SignalingPoll {
+			c.goTracked(func() { c.upgradeLoop(t, done) })
+		}
+	}
+
+	// 恢复在线状态订阅
+	c.goTracked(c.resubscribe)
+}
+
+// goTracked 启动协程，Disconnect 等待其退出
+func (c *SignalingClient) goTracked(f func()) {
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		f()
+	}()
+}
+
+// stop 停止当前的传输通道。调用方需持有锁
+func (c *SignalingClient) stop() {
+	close(c.done)
+	c.transport.Close()
+	c.transport = nil
+	c.connected = false
+	c.presence = make(map[string]bool)
+}
+
+// switchTransport 将仍在使用的传输通道 old 替换为 next，old 已不再使用时关闭 next
+func (c *SignalingClient) switchTransport(old, next signalTransport, target string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.connected || c.transport != old {
+		next.Close()
+		return
+	}
+	c.stop()
+	c.start(next)
+	fmt.Printf("信令传输已切换为 %s: %s\n", next.Name(), target)
+}
+
+// SetIdentity 设置设备身份，持有证书后连接信令服务器时使用证书认证
+func (c *SignalingClient) SetIdentity(id *identity.Identity) {
+	c.identity = id
+}
+
+// header 连接信令服务器时的认证请求头，持有证书时不包含令牌，由 authenticate 添加证书签名
+func (c *SignalingClient) header() http.Header {
+	header := make(http.Header)
+	header["X-Node-ID"] = []string{c.config.Node.ID}
+	if !c.identity.Enrolled() {
+		header["X-Node-Token"] = []string{c.config.Node.Token}
+	}
+	if c.config.Node.Region != "" {
+		header["X-Node-Region"] = []string{c.config.Node.Region}
+	}
+
+	header["X-Node-Version"] = []string{version.Version}
+	header["X-Node-Instance"] = []string{c.instance}
+	return header
+}
+
+// newInstanceID 生成随机的实例 ID
+func newInstanceID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// authenticate 为发往信令服务器的请求添加认证请求头，持有证书时使用证书私钥签名
+func (c *SignalingClient) authenticate(req *http.Request, body []byte) error {
+	for key, values := range c.header() {
+		req.Header[key] = values
+	}
+	if c.identity.Enrolled() {
+		return c.identity.SignRequest(req, body)
+	}
+	return nil
+}
+
+// dial 连接指定服务器的 WebSocket 信令接口
+func (c *SignalingClient) dial(serverURL string) (*websocket.Conn, string, error) {
 	// 将 HTTP 地址转换为 WebSocket 地址
 	u, err := url.Parse(serverURL)
 	if err != nil {
-		return fmt.Errorf("解析服务器地址失败: %w", err)
+		return nil, "", fmt.Errorf("解析服务器地址失败: %w", err)
 	}
 
 	var wsURL string
@@ -98,169 +320,162 @@ func (c *SignalingClient) Connect() error {
 		wsURL = "ws://" + u.Host + "/api/v1/ws"
 	}
 
-	// 设置请求头
-	header := make(map[string][]string)
-	header["X-Node-ID"] = []string{c.config.Node.ID}
-	header["X-Node-Token"] = []string{c.config.Node.Token}
+	// 签名覆盖握手请求的方法和路径
+	req, err := http.NewRequest(http.MethodGet, wsURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := c.authenticate(req, nil); err != nil {
+		return nil, "", err
+	}
 
 	// 连接到 WebSocket 服务器
-	conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+	dialer := *websocket.DefaultDialer
+	dialer.Proxy = c.proxy.ForRequest
+	dialer.EnableCompression = c.config.Server.SignalingCompression
+	dialer.Subprotocols = signalingSubprotocols(c.config.Server.SignalingEncoding)
+	conn, resp, err := dialer.Dial(wsURL, req.Header)
 	if err != nil {
-		return fmt.Errorf("连接到信令服务器失败: %w", err)
+		if resp != nil && resp.StatusCode == http.StatusUpgradeRequired {
+			return nil, "", upgradeRequiredError(resp)
+		}
+		return nil, "", err
 	}
+	return conn, wsURL, nil
+}
 
-	c.conn = conn
-	c.connected = true
-
-	// 设置 Pong 处理函数
-	c.conn.SetPongHandler(func(string) error {
-		c.conn.SetReadDeadline(time.Now().Add(c.pongWait))
-		return nil
-	})
-
-	// 启动读写协程
-	go c.readPump()
-	go c.writePump()
-
-	// 启动 Ping 定时器
-	c.pingTicker = time.NewTicker(c.pingPeriod)
-	go c.pingLoop()
+// signalingSubprotocols 按配置的信令编码返回握手时提供的子协议。不支持子协议的旧版本服务端不选择子协议，使用 JSON
+func signalingSubprotocols(encoding string) []string {
+	if encoding == config.SignalingEncodingJSON {
+		return []string{protocol.SubprotocolJSON}
+	}
+	return []string{protocol.SubprotocolMsgpack, protocol.SubprotocolJSON}
+}
 
-	fmt.Printf("已连接到信令服务器: %s\n", wsURL)
-	return nil
+// upgradeRequiredError 根据服务端返回的 426 响应生成需要升级的错误
+func upgradeRequiredError(resp *http.Response) error {
+	var body struct {
+		MinVersion string `json:"minVersion"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	return fmt.Errorf("%w: 当前版本 %s，最低版本 %s", ErrUpgradeRequired, version.Version, body.MinVersion)
 }
 
-// Disconnect 断开与信令服务器的连接
+// Disconnect 断开与信令服务器的连接，等待收发和重连协程退出。断开后不能再次连接
 func (c *SignalingClient) Disconnect() error {
 	c.mu.Lock()
-	defer c.mu.Unlock()
 
-	if !c.connected {
-		return nil
-	}
-
-	// 停止重连
+	// 停止重连，取消等待中的重连和发送
 	c.reconnect = false
+	c.cancel()
 
 	// 关闭连接
-	if c.conn != nil {
-		c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
-		c.conn.Close()
-		c.conn = nil
+	connected := c.connected
+	if connected {
+		c.stop()
 	}
+	c.mu.Unlock()
 
-	// 停止 Ping 定时器
-	if c.pingTicker != nil {
-		c.pingTicker.Stop()
-		c.pingTicker = nil
+	// 协程退出时需要获取锁，等待前先释放
+	c.wg.Wait()
+	if connected {
+		fmt.Println("已断开与信令服务器的连接")
 	}
-
-	// 发送停止信号
-	close(c.stopCh)
-
-	c.connected = false
-	fmt.Println("已断开与信令服务器的连接")
 	return nil
 }
 
 // readPump 从 WebSocket 读取数据
-func (c *SignalingClient) readPump() {
+func (c *SignalingClient) readPump(t *wsTransport) {
 	defer func() {
-		c.handleDisconnect()
+		c.handleDisconnect(t)
 	}()
 
 	for {
-		_, message, err := c.conn.ReadMessage()
+		messageType, message, err := t.conn.ReadMessage()
 		if err != nil {
 			fmt.Printf("读取信令消息失败: %v\n", err)
 			break
 		}
 
-		// 解析信令消息
-		var signal Signal
-		if err := json.Unmarshal(message, &signal); err != nil {
-			fmt.Printf("解析信令消息失败: %v\n", err)
-			continue
+		// 服务端可能将排队的多条信令合并发送，文本帧以换行分隔，二进制帧首尾相接
+		lines := bytes.Split(message, []byte{'\n'})
+		if messageType == websocket.BinaryMessage {
+			if lines, err = protocol.DecodeMsgpack(message); err != nil {
+				fmt.Printf("解析信令消息失败: %v\n", err)
+				continue
+			}
 		}
-
-		// 处理信令消息
-		c.handleSignal(&signal)
-	}
-}
-
-// writePump 向 WebSocket 写入数据
-func (c *SignalingClient) writePump() {
-	for {
-		select {
-		case <-c.stopCh:
-			return
-		case signal := <-c.sendCh:
-			c.mu.RLock()
-			if !c.connected || c.conn == nil {
-				c.mu.RUnlock()
+		for _, line := range lines {
+			if len(bytes.TrimSpace(line)) == 0 {
 				continue
 			}
 
-			// 序列化信令消息
-			data, err := json.Marshal(signal)
+			// 解析信令消息
+			signal, err := protocol.ParseSignal(line)
 			if err != nil {
-				fmt.Printf("序列化信令消息失败: %v\n", err)
-				c.mu.RUnlock()
+				fmt.Printf("解析信令消息失败: %v\n", err)
 				continue
 			}
 
-			// 发送信令消息
-			if err := c.conn.WriteMessage(websocket.TextMessage, data); err != nil {
-				fmt.Printf("发送信令消息失败: %v\n", err)
-				c.mu.RUnlock()
-				c.handleDisconnect()
-				return
-			}
-			c.mu.RUnlock()
+			// 处理信令消息
+			c.handleSignal(signal)
 		}
 	}
 }
 
-// pingLoop 发送 Ping 消息
-func (c *SignalingClient) pingLoop() {
+// writePump 通过传输通道发送信令消息，直到通道停止
+func (c *SignalingClient) writePump(t signalTransport, done chan struct{}) {
 	for {
 		select {
-		case <-c.stopCh:
+		case <-done:
 			return
-		case <-c.pingTicker.C:
-			c.mu.RLock()
-			if !c.connected || c.conn == nil {
-				c.mu.RUnlock()
-				continue
+		case <-c.sendQueue.Ready():
+			// 按优先级发送队列中的信令消息
+			for {
+				data, ok := c.sendQueue.Pop()
+				if !ok {
+					break
+				}
+				if err := t.Send(data); err != nil {
+					fmt.Printf("发送信令消息失败: %v\n", err)
+					c.handleDisconnect(t)
+					return
+				}
 			}
+		}
+	}
+}
+
+// pingLoop 发送 Ping 消息
+func (c *SignalingClient) pingLoop(t *wsTransport, done chan struct{}) {
+	ticker := time.NewTicker(c.pingPeriod)
+	defer ticker.Stop()
 
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
 			// 发送 Ping 消息
-			if err := c.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := t.ping(); err != nil {
 				fmt.Printf("发送 Ping 消息失败: %v\n", err)
-				c.mu.RUnlock()
-				c.handleDisconnect()
+				c.handleDisconnect(t)
 				return
 			}
-			c.mu.RUnlock()
 		}
 	}
 }
 
-// handleDisconnect 处理断开连接
-func (c *SignalingClient) handleDisconnect() {
+// handleDisconnect 处理传输通道 t 断开，t 已被替换时忽略
+func (c *SignalingClient) handleDisconnect(t signalTransport) {
 	c.mu.Lock()
-	if !c.connected {
+	if !c.connected || c.transport != t {
 		c.mu.Unlock()
 		return
 	}
 
 	// 关闭连接
-	if c.conn != nil {
-		c.conn.Close()
-		c.conn = nil
-	}
-
-	c.connected = false
+	c.stop()
 	reconnect := c.reconnect
 	c.mu.Unlock()
 
@@ -268,7 +483,7 @@ func (c *SignalingClient) handleDisconnect() {
 
 	// 如果需要重连，则尝试重连
 	if reconnect {
-		go c.reconnectLoop()
+		c.goTracked(c.reconnectLoop)
 	}
 }
 
@@ -286,8 +501,12 @@ func (c *SignalingClient) reconnectLoop() {
 		}
 		c.mu.RUnlock()
 
-		// 等待一段时间后重连
-		time.Sleep(backoff)
+		// 等待一段时间后重连，断开连接时立即退出
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
 
 		// 尝试重连
 		fmt.Printf("尝试重新连接到信令服务器...\n")
@@ -296,6 +515,10 @@ func (c *SignalingClient) reconnectLoop() {
 			fmt.Println("重新连接成功")
 			return
 		}
+		if errors.Is(err, ErrUpgradeRequired) {
+			fmt.Printf("停止重连: %v\n", err)
+			return
+		}
 
 		fmt.Printf("重新连接失败: %v\n", err)
 
@@ -308,21 +531,42 @@ func (c *SignalingClient) reconnectLoop() {
 }
 
 // handleSignal 处理信令消息
-func (c *SignalingClient) handleSignal(signal *Signal) {
+func (c *SignalingClient) handleSignal(signal *protocol.Signal) {
 	// 处理特殊信令类型
 	switch signal.Type {
-	case SignalPing:
-		// 回复 Pong
-		c.Send(&Signal{
-			Type:      SignalPong,
+	case protocol.SignalPing:
+		// 回复 Pong，仍交给注册的处理函数，如服务端欢迎消息中下发的连接优先级
+		c.Send(&protocol.Signal{
+			Type:      protocol.SignalPong,
 			SenderID:  c.config.Node.ID,
 			ReceiverID: signal.SenderID,
 			Timestamp: time.Now(),
 		})
-		return
-	case SignalPong:
+	case protocol.SignalPong:
 		// 收到 Pong，不需要特殊处理
 		return
+	case protocol.SignalUpgradeRecommended:
+		// 提示升级，仍交给注册的处理函数
+		if payload, ok := signal.Payload.(map[string]interface{}); ok {
+			fmt.Printf("服务端推荐升级客户端: 当前版本 %v，推荐版本 %v\n", payload["currentVersion"], payload["recommendedVersion"])
+		}
+	case protocol.SignalPresence:
+		// 节点在线状态，仍交给注册的处理函数
+		c.handlePresence(signal)
+	case protocol.SignalSuperseded:
+		// 连接被替换，仍交给注册的处理函数
+		c.handleSuperseded(signal)
+	case protocol.SignalRelayThrottled:
+		// 中继被限速，仍交给注册的处理函数
+		if payload, ok := signal.Payload.(map[string]interface{}); ok {
+			fmt.Printf("中继流量超出带宽限制 (%v %v Mbps)，%v 毫秒后恢复: 目标节点 %v\n",
+				payload["direction"], payload["limitMbps"], payload["retryAfter"], payload["targetId"])
+		}
+	case protocol.SignalRelayLimited:
+		// 中继会话超出限制，仍交给注册的处理函数
+		if payload, ok := signal.Payload.(map[string]interface{}); ok {
+			fmt.Printf("中继会话超出限制 (%v %v): 目标节点 %v\n", payload["limit"], payload["value"], payload["targetId"])
+		}
 	}
 
 	// 调用注册的处理函数
@@ -337,8 +581,26 @@ func (c *SignalingClient) handleSignal(signal *Signal) {
 	}
 }
 
+// handleSuperseded 使用相同节点 ID 的另一个连接接管了信令，断开当前连接且不再重连，
+// 避免两台使用相同节点 ID 的设备反复互相替换
+func (c *SignalingClient) handleSuperseded(signal *protocol.Signal) {
+	c.mu.Lock()
+	c.reconnect = false
+	if c.connected {
+		c.stop()
+	}
+	c.mu.Unlock()
+
+	transport := ""
+	if payload, ok := signal.Payload.(map[string]interface{}); ok {
+		transport, _ = payload["transport"].(string)
+	}
+	fmt.Printf("信令连接已被使用相同节点 ID 的新连接 (%s) 接管，停止重连，请检查是否有其他设备使用了节点 ID %s\n",
+		transport, c.config.Node.ID)
+}
+
 // Send 发送信令消息
-func (c *SignalingClient) Send(signal *Signal) {
+func (c *SignalingClient) Send(signal *protocol.Signal) {
 	// 设置发送者 ID
 	if signal.SenderID == "" {
 		signal.SenderID = c.config.Node.ID
@@ -349,12 +611,31 @@ func (c *SignalingClient) Send(signal *Signal) {
 		signal.Timestamp = time.Now()
 	}
 
-	// 发送信令消息
-	c.sendCh <- signal
+	// 断开连接后不再有协程发送，直接丢弃
+	if c.ctx.Err() != nil {
+		return
+	}
+
+	// 序列化信令消息
+	data, err := json.Marshal(signal)
+	if err != nil {
+		fmt.Printf("序列化信令消息失败: %v\n", err)
+		return
+	}
+
+	// 按信令类型的优先级排队，队列已满时丢弃
+	if !c.sendQueue.Push(protocol.SignalPriority(signal.Type), data) {
+		fmt.Printf("信令发送队列已满，丢弃 %s 信令\n", signal.Type)
+	}
+}
+
+// QueueStats 获取信令发送队列各优先级的统计
+func (c *SignalingClient) QueueStats() []protocol.QueueStats {
+	return c.sendQueue.Stats()
 }
 
 // RegisterHandler 注册信令处理函数
-func (c *SignalingClient) RegisterHandler(signalType SignalType, handler SignalHandler) {
+func (c *SignalingClient) RegisterHandler(signalType protocol.SignalType, handler SignalHandler) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -369,19 +650,21 @@ func (c *SignalingClient) IsConnected() bool {
 }
 
 // RequestConnect 请求连接到对等节点
-func (c *SignalingClient) RequestConnect(peerID string) error {
+// localCandidates 为本节点的局域网候选地址，服务端判断双方在同一 NAT 之后时转发给对端
+func (c *SignalingClient) RequestConnect(peerID string, localCandidates []string) error {
 	if !c.IsConnected() {
 		return fmt.Errorf("未连接到信令服务器")
 	}
 
 	// 发送连接请求
-	c.Send(&Signal{
-		Type:      SignalConnect,
+	c.Send(&protocol.Signal{
+		Type:      protocol.SignalConnect,
 		ReceiverID: peerID,
 		Payload:   map[string]interface{}{
 			"natType":     c.natInfo.Type.String(),
 			"externalIP":  c.natInfo.ExternalIP.String(),
 			"externalPort": c.natInfo.ExternalPort,
+			"localCandidates": localCandidates,
 		},
 	})
 
@@ -395,8 +678,8 @@ func (c *SignalingClient) RequestRelay(peerID string) error {
 	}
 
 	// 发送中继请求
-	c.Send(&Signal{
-		Type:      SignalRelayRequest,
+	c.Send(&protocol.Signal{
+		Type:      protocol.SignalRelayRequest,
 		ReceiverID: peerID,
 	})
 
@@ -410,8 +693,8 @@ func (c *SignalingClient) SendOffer(peerID string, offer interface{}) error {
 	}
 
 	// 发送 Offer
-	c.Send(&Signal{
-		Type:      SignalOffer,
+	c.Send(&protocol.Signal{
+		Type:      protocol.SignalOffer,
 		ReceiverID: peerID,
 		Payload:   offer,
 	})
@@ -426,8 +709,8 @@ func (c *SignalingClient) SendAnswer(peerID string, answer interface{}) error {
 	}
 
 	// 发送 Answer
-	c.Send(&Signal{
-		Type:      SignalAnswer,
+	c.Send(&protocol.Signal{
+		Type:      protocol.SignalAnswer,
 		ReceiverID: peerID,
 		Payload:   answer,
 	})
@@ -442,8 +725,8 @@ func (c *SignalingClient) SendICECandidate(peerID string, candidate interface{})
 	}
 
 	// 发送 ICE 候选
-	c.Send(&Signal{
-		Type:      SignalICECandidate,
+	c.Send(&protocol.Signal{
+		Type:      protocol.SignalICECandidate,
 		ReceiverID: peerID,
 		Payload:   candidate,
 	})
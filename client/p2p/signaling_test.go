@@ -0,0 +1,143 @@
+package p2p
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/senma231/p3/client/config"
+	"github.com/senma231/p3/client/nat"
+	"github.com/senma231/p3/common/protocol"
+	"go.uber.org/goleak"
+)
+
+func TestSignalingClientDisconnectLeak(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	// 服务器接受第一个连接后立即断开，之后拒绝连接，客户端进入重连等待
+	connected := make(chan struct{}, 1)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case connected <- struct{}{}:
+		default:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.Close()
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Server.Address = server.URL
+	cfg.Server.SignalingTransport = config.SignalingWebSocket
+	c := NewSignalingClient(cfg, &nat.NATInfo{})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("连接信令服务器失败: %v", err)
+	}
+
+	// 等待连接断开
+	deadline := time.Now().Add(3 * time.Second)
+	for c.IsConnected() {
+		if time.Now().After(deadline) {
+			t.Fatal("服务器断开后客户端应检测到断开")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		c.Disconnect()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("断开连接时应立即停止重连等待")
+	}
+
+	// 断开后发送不会阻塞
+	for i := 0; i < sendQueueCapacity+1; i++ {
+		c.Send(&protocol.Signal{Type: protocol.SignalPing})
+	}
+	if err := c.Connect(); err == nil {
+		t.Fatal("断开后不应再次连接")
+	}
+}
+
+func TestSignalingMsgpackEncoding(t *testing.T) {
+	// 服务器协商 MessagePack 和压缩，收到客户端的信令后以一帧返回两条信令
+	upgrader := websocket.Upgrader{
+		Subprotocols:      []string{protocol.SubprotocolMsgpack, protocol.SubprotocolJSON},
+		EnableCompression: true,
+	}
+	received := make(chan *protocol.Signal, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		messageType, message, err := conn.ReadMessage()
+		if err != nil || messageType != websocket.BinaryMessage {
+			return
+		}
+		messages, err := protocol.DecodeMsgpack(message)
+		if err != nil || len(messages) != 1 {
+			return
+		}
+		signal, err := protocol.ParseSignal(messages[0])
+		if err != nil {
+			return
+		}
+		received <- signal
+
+		reply, _ := json.Marshal(&protocol.Signal{Type: protocol.SignalAnswer, SenderID: "peer", Payload: map[string]interface{}{"port": 5000}})
+		encoded, _ := protocol.EncodeMsgpack(reply)
+		conn.WriteMessage(websocket.BinaryMessage, append(encoded, encoded...))
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Server.Address = server.URL
+	cfg.Server.SignalingTransport = config.SignalingWebSocket
+	c := NewSignalingClient(cfg, &nat.NATInfo{})
+	answers := make(chan *protocol.Signal, 2)
+	c.RegisterHandler(protocol.SignalAnswer, func(signal *protocol.Signal) {
+		answers <- signal
+	})
+	if err := c.Connect(); err != nil {
+		t.Fatalf("连接信令服务器失败: %v", err)
+	}
+	defer c.Disconnect()
+
+	c.Send(&protocol.Signal{Type: protocol.SignalOffer, ReceiverID: "peer", Payload: map[string]interface{}{"sdp": "offer"}})
+	select {
+	case signal := <-received:
+		if signal.Type != protocol.SignalOffer || signal.ReceiverID != "peer" {
+			t.Fatalf("服务器收到的信令为 %+v", signal)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("服务器未收到二进制信令")
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case signal := <-answers:
+			if payload, ok := signal.Payload.(map[string]interface{}); !ok || payload["port"] != float64(5000) {
+				t.Fatalf("收到的信令为 %+v", signal)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatalf("收到 %d 条信令，期望 2 条", i)
+		}
+	}
+}
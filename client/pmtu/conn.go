@@ -0,0 +1,190 @@
+package pmtu
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// maxFragments 单个数据报的最大分片数
+	maxFragments = 255
+	// reassemblyTimeout 分片重组的超时，超时未收齐的数据报被丢弃
+	reassemblyTimeout = 5 * time.Second
+	// maxPending 同时重组的数据报数上限
+	maxPending = 64
+)
+
+// Conn 按路径 MTU 收发数据报的 UDP 连接。超过单个数据报可携带长度的写入被分片发送，
+// 读取时重组；读取时自动确认对端的 MTU 探测包。连接两端都需要使用 Conn
+type Conn struct {
+	net.Conn
+	mtu     int
+	payload int // 单个数据报可携带的数据长度，不含头部
+
+	writeMu sync.Mutex
+	nextID  uint16
+
+	readMu  sync.Mutex
+	readBuf [MaxMTU]byte
+	pending map[uint16]*reassembly
+}
+
+// reassembly 正在重组的数据报
+type reassembly struct {
+	parts    [][]byte
+	received int
+	started  time.Time
+}
+
+// NewConn 使用指定的 MTU 包装已连接的 UDP 连接，mtu 为 0 时使用 MinMTU
+func NewConn(conn net.Conn, mtu int) *Conn {
+	if mtu <= 0 {
+		mtu = MinMTU
+	}
+	return &Conn{
+		Conn:    conn,
+		mtu:     mtu,
+		payload: PayloadSize(conn, mtu),
+		pending: make(map[uint16]*reassembly),
+	}
+}
+
+// DiscoverConn 探测路径 MTU 后包装连接
+func DiscoverConn(conn net.Conn, timeout time.Duration) *Conn {
+	return NewConn(conn, Discover(conn, timeout))
+}
+
+// MTU 协商的路径 MTU
+func (c *Conn) MTU() int {
+	return c.mtu
+}
+
+// MaxDatagram 单次写入的最大长度
+func (c *Conn) MaxDatagram() int {
+	return (c.payload - fragmentHeader) * maxFragments
+}
+
+// Write 发送一个数据报，超过路径 MTU 时分片发送
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if len(b)+dataHeader <= c.payload {
+		packet := make([]byte, dataHeader+len(b))
+		packet[0] = kindData
+		copy(packet[dataHeader:], b)
+		if _, err := c.Conn.Write(packet); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	size := c.payload - fragmentHeader
+	count := (len(b) + size - 1) / size
+	if count > maxFragments {
+		return 0, ErrTooLarge
+	}
+
+	c.nextID++
+	packet := make([]byte, c.payload)
+	for i := 0; i < count; i++ {
+		part := b[i*size:]
+		if len(part) > size {
+			part = part[:size]
+		}
+		packet[0] = kindFragment
+		binary.BigEndian.PutUint16(packet[1:3], c.nextID)
+		packet[3] = byte(i)
+		packet[4] = byte(count)
+		n := copy(packet[fragmentHeader:], part)
+		if _, err := c.Conn.Write(packet[:fragmentHeader+n]); err != nil {
+			return i * size, err
+		}
+	}
+	return len(b), nil
+}
+
+// Read 读取一个数据报，分片的数据报重组后返回。b 小于数据报时多余的部分被丢弃
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	for {
+		n, err := c.Conn.Read(c.readBuf[:])
+		if err != nil {
+			return 0, err
+		}
+		packet := c.readBuf[:n]
+		if n == 0 {
+			continue
+		}
+
+		switch packet[0] {
+		case kindData:
+			return copy(b, packet[dataHeader:]), nil
+		case kindFragment:
+			if data := c.reassemble(packet); data != nil {
+				return copy(b, data), nil
+			}
+		case kindProbe:
+			if _, seq, size := parseProbe(packet); size > 0 {
+				ack(c.Conn, seq, size)
+			}
+		}
+		// 探测确认和无法识别的数据报被忽略
+	}
+}
+
+// reassemble 记录一个分片，数据报收齐后返回完整的数据
+func (c *Conn) reassemble(packet []byte) []byte {
+	if len(packet) < fragmentHeader {
+		return nil
+	}
+	id := binary.BigEndian.Uint16(packet[1:3])
+	index, count := int(packet[3]), int(packet[4])
+	if count == 0 || index >= count {
+		return nil
+	}
+
+	now := time.Now()
+	r := c.pending[id]
+	if r != nil && (len(r.parts) != count || now.Sub(r.started) > reassemblyTimeout) {
+		// 报文 ID 已被重用或重组超时
+		delete(c.pending, id)
+		r = nil
+	}
+	if r == nil {
+		c.expire(now)
+		if len(c.pending) >= maxPending {
+			return nil
+		}
+		r = &reassembly{parts: make([][]byte, count), started: now}
+		c.pending[id] = r
+	}
+
+	if r.parts[index] == nil {
+		r.parts[index] = append([]byte(nil), packet[fragmentHeader:]...)
+		r.received++
+	}
+	if r.received < count {
+		return nil
+	}
+
+	delete(c.pending, id)
+	var data []byte
+	for _, part := range r.parts {
+		data = append(data, part...)
+	}
+	return data
+}
+
+// expire 丢弃重组超时的数据报
+func (c *Conn) expire(now time.Time) {
+	for id, r := range c.pending {
+		if now.Sub(r.started) > reassemblyTimeout {
+			delete(c.pending, id)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+//go:build linux
+
+package pmtu
+
+import (
+	"net"
+	"syscall"
+)
+
+// setDontFragment 设置禁止分片，超过路径 MTU 的数据报被丢弃或发送失败
+func setDontFragment(conn net.Conn) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	level, opt, value := syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO
+	if overhead(conn) > 20+8 {
+		level, opt, value = syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO
+	}
+	raw.Control(func(fd uintptr) {
+		syscall.SetsockoptInt(int(fd), level, opt, value)
+	})
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package pmtu
+
+import "net"
+
+// setDontFragment 当前平台不设置禁止分片。超过路径 MTU 的探测包可能被 IP 分片后送达，
+// 此时探测结果偏大，只在路径丢弃分片时才能探测到
+func setDontFragment(conn net.Conn) {}
@@ -0,0 +1,218 @@
+// Package pmtu 探测点对点 UDP 路径的 MTU，并按探测结果对超出的数据报分片。
+// 打洞建立的 UDP 路径经过家用路由器、隧道和运营商 NAT，超过路径 MTU 的数据报
+// 会被分片甚至直接丢弃，不能按 UDP 理论上的最大长度发送
+package pmtu
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	// MinMTU 探测失败时使用的 MTU，IPv6 要求所有链路都支持
+	MinMTU = 1280
+	// MaxMTU 探测的上限，以太网的 MTU
+	MaxMTU = 1500
+	// DefaultTimeout 探测的默认超时
+	DefaultTimeout = 3 * time.Second
+
+	// probeAttempts 每个大小的探测次数，全部丢失才认为该大小不可达
+	probeAttempts = 3
+	// probeGranularity 二分查找的精度
+	probeGranularity = 8
+	// minProbeWait、maxProbeWait 等待单个探测包确认的时间范围，按首个探测包的往返时间计算
+	minProbeWait = 100 * time.Millisecond
+	maxProbeWait = time.Second
+)
+
+// 数据报类型，每个数据报的第一个字节
+const (
+	kindData     byte = 0x01 // 未分片的数据
+	kindFragment byte = 0x02 // 分片的数据
+	kindProbe    byte = 0x03 // MTU 探测
+	kindProbeAck byte = 0x04 // MTU 探测的确认
+	kindDone     byte = 0x05 // 探测结束
+)
+
+const (
+	// dataHeader 未分片数据报的头部长度：类型
+	dataHeader = 1
+	// fragmentHeader 分片的头部长度：类型、报文 ID、分片序号、分片数
+	fragmentHeader = 5
+	// probeHeader 探测和确认的头部长度：类型、序号、探测的大小
+	probeHeader = 7
+)
+
+// ErrTooLarge 数据报分片后仍超过分片数上限
+var ErrTooLarge = errors.New("数据报过大")
+
+// overhead 按连接的地址族计算 IP 和 UDP 头部的长度
+func overhead(conn net.Conn) int {
+	if addr, ok := conn.RemoteAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		return 40 + 8
+	}
+	return 20 + 8
+}
+
+// PayloadSize 按 MTU 计算单个 UDP 数据报可携带的数据长度
+func PayloadSize(conn net.Conn, mtu int) int {
+	return mtu - overhead(conn)
+}
+
+// Discover 在已连接的 UDP 连接上探测路径 MTU，对端需同时调用 Discover 以便确认探测包。
+// 先确认最小 MTU 可达，再二分查找最大的可达大小，探测失败或超时时返回 MinMTU。
+// 两个方向的路径 MTU 可能不同，各自探测自己的发送方向；先完成的一端继续确认对端的探测包，
+// 直到对端也完成或超时。支持的平台上会设置禁止分片标志，超过路径 MTU 的探测包被丢弃而不是分片后送达
+func Discover(conn net.Conn, timeout time.Duration) int {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	setDontFragment(conn)
+
+	p := &prober{conn: conn, deadline: time.Now().Add(timeout), wait: maxProbeWait}
+	defer conn.SetReadDeadline(time.Time{})
+
+	// 对端至少需要确认最小的探测包，否则不再继续探测。
+	// 之后的探测包按往返时间等待确认，丢失的探测包不会耗尽整个超时
+	start := time.Now()
+	if !p.probe(MinMTU) {
+		return MinMTU
+	}
+	p.wait = 3 * time.Since(start)
+	if p.wait < minProbeWait {
+		p.wait = minProbeWait
+	} else if p.wait > maxProbeWait {
+		p.wait = maxProbeWait
+	}
+
+	mtu := p.search()
+	p.finish()
+	return mtu
+}
+
+// prober 发送探测包并等待确认，等待期间确认对端的探测包
+type prober struct {
+	conn     net.Conn
+	deadline time.Time
+	wait     time.Duration // 等待单个探测包确认的时间
+	seq      uint32
+	peerDone bool // 对端已完成探测
+	buf      [MaxMTU]byte
+}
+
+// search 二分查找最大的可达 MTU，MinMTU 已确认可达
+func (p *prober) search() int {
+	lo, hi := MinMTU, MaxMTU
+	if p.probe(hi) {
+		return hi
+	}
+	for hi-lo > probeGranularity {
+		mid := (lo + hi) / 2
+		if p.probe(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+		if time.Now().After(p.deadline) {
+			break
+		}
+	}
+	return lo
+}
+
+// finish 通知对端探测结束，并在对端完成前继续确认它的探测包
+func (p *prober) finish() {
+	for {
+		p.send(kindDone, 0, probeHeader)
+		if p.peerDone {
+			return
+		}
+		wait := time.Until(p.deadline)
+		if wait <= 0 {
+			return
+		}
+		if wait > 500*time.Millisecond {
+			wait = 500 * time.Millisecond
+		}
+		// 等待期间收到对端的结束通知时 peerDone 被设置，超时后重发结束通知
+		p.waitAck(0, time.Now().Add(wait))
+	}
+}
+
+// probe 探测指定 MTU 是否可达
+func (p *prober) probe(mtu int) bool {
+	size := PayloadSize(p.conn, mtu)
+	for attempt := 0; attempt < probeAttempts; attempt++ {
+		wait := time.Until(p.deadline)
+		if wait <= 0 {
+			return false
+		}
+		if wait > p.wait {
+			wait = p.wait
+		}
+
+		p.seq++
+		if err := p.send(kindProbe, p.seq, size); err != nil {
+			// 超过本地接口 MTU 时发送直接失败
+			return false
+		}
+		if p.waitAck(p.seq, time.Now().Add(wait)) {
+			return true
+		}
+	}
+	return false
+}
+
+// send 发送长度为 size 的探测相关数据报
+func (p *prober) send(kind byte, seq uint32, size int) error {
+	packet := make([]byte, size)
+	packet[0] = kind
+	binary.BigEndian.PutUint32(packet[1:5], seq)
+	binary.BigEndian.PutUint16(packet[5:7], uint16(size))
+	_, err := p.conn.Write(packet)
+	return err
+}
+
+// waitAck 等待指定序号的确认，期间确认对端的探测包并记录对端是否已完成，忽略其他数据报
+func (p *prober) waitAck(seq uint32, deadline time.Time) bool {
+	for {
+		p.conn.SetReadDeadline(deadline)
+		n, err := p.conn.Read(p.buf[:])
+		if err != nil {
+			return false
+		}
+		switch kind, s, size := parseProbe(p.buf[:n]); kind {
+		case kindProbe:
+			ack(p.conn, s, size)
+		case kindProbeAck:
+			if seq != 0 && s == seq {
+				return true
+			}
+		case kindDone:
+			p.peerDone = true
+		}
+	}
+}
+
+// parseProbe 解析探测相关的数据报，其他数据报的 kind 为 0
+func parseProbe(packet []byte) (kind byte, seq uint32, size uint16) {
+	if len(packet) < probeHeader {
+		return 0, 0, 0
+	}
+	switch packet[0] {
+	case kindProbe, kindProbeAck, kindDone:
+		return packet[0], binary.BigEndian.Uint32(packet[1:5]), binary.BigEndian.Uint16(packet[5:7])
+	}
+	return 0, 0, 0
+}
+
+// ack 确认对端的探测包
+func ack(conn net.Conn, seq uint32, size uint16) {
+	var packet [probeHeader]byte
+	packet[0] = kindProbeAck
+	binary.BigEndian.PutUint32(packet[1:5], seq)
+	binary.BigEndian.PutUint16(packet[5:7], size)
+	conn.Write(packet[:])
+}
@@ -0,0 +1,97 @@
+package pmtu
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lossyConn 丢弃超过 mtu 的数据报，模拟路径 MTU 较小且禁止分片的路径
+type lossyConn struct {
+	net.Conn
+	mtu int
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	if len(b)+overhead(c.Conn) > c.mtu {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+// udpPair 创建两个互相连接的 UDP 连接
+func udpPair(t *testing.T) (net.Conn, net.Conn) {
+	a, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	b, err := net.DialUDP("udp", nil, a.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	a.Close()
+	a, err = net.DialUDP("udp", a.LocalAddr().(*net.UDPAddr), b.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
+func TestDiscover(t *testing.T) {
+	a, b := udpPair(t)
+	pathMTU := 1400
+
+	// 两端同时探测，等待期间互相确认
+	var wg sync.WaitGroup
+	results := make([]int, 2)
+	for i, conn := range []net.Conn{&lossyConn{Conn: a, mtu: pathMTU}, &lossyConn{Conn: b, mtu: pathMTU}} {
+		wg.Add(1)
+		go func(i int, conn net.Conn) {
+			defer wg.Done()
+			results[i] = Discover(conn, 3*time.Second)
+		}(i, conn)
+	}
+	wg.Wait()
+
+	for _, mtu := range results {
+		if mtu > pathMTU || mtu <= pathMTU-probeGranularity {
+			t.Fatalf("探测的 MTU 为 %d，路径 MTU 为 %d", mtu, pathMTU)
+		}
+	}
+
+	// 对端不响应时使用最小 MTU
+	c, _ := udpPair(t)
+	if mtu := Discover(c, 300*time.Millisecond); mtu != MinMTU {
+		t.Fatalf("探测失败时应返回 MinMTU，实际为 %d", mtu)
+	}
+}
+
+func TestConnFragment(t *testing.T) {
+	a, b := udpPair(t)
+	ca := NewConn(&lossyConn{Conn: a, mtu: 1300}, 1300)
+	cb := NewConn(b, 1300)
+
+	small := []byte("hello")
+	large := bytes.Repeat([]byte("0123456789"), 1000)
+	for _, data := range [][]byte{small, large} {
+		if _, err := ca.Write(data); err != nil {
+			t.Fatalf("写入失败: %v", err)
+		}
+		buf := make([]byte, 65536)
+		cb.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := cb.Read(buf)
+		if err != nil || !bytes.Equal(buf[:n], data) {
+			t.Fatalf("读取的数据不一致: %d 字节 %v", n, err)
+		}
+	}
+
+	if _, err := ca.Write(make([]byte, ca.MaxDatagram()+1)); err != ErrTooLarge {
+		t.Fatalf("超过分片上限时应返回 ErrTooLarge，实际为 %v", err)
+	}
+}
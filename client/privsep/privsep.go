@@ -0,0 +1,76 @@
+// Package privsep 将需要特权的操作交给单独的辅助进程，主进程启动辅助进程后降为普通用户运行。
+// 辅助进程只接受绑定启动时指定的端口，并通过 Unix 套接字将监听器的文件描述符传回主进程。
+// UPnP 和 NAT-PMP 映射只需要普通的网络访问，仍在主进程中完成
+package privsep
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// HelperFlag 以辅助进程模式启动客户端的命令行参数
+const HelperFlag = "privsep-helper"
+
+// portsEnv 传递允许绑定的端口的环境变量
+const portsEnv = "P3_PRIVSEP_PORTS"
+
+// ErrUnsupported 当前平台不支持权限分离
+var ErrUnsupported = errors.New("当前平台不支持权限分离")
+
+// request 主进程发给辅助进程的请求
+type request struct {
+	Op      string `json:"op"` // 目前只有 listen
+	Network string `json:"network"`
+	Address string `json:"address"`
+}
+
+// response 辅助进程的响应，成功时附带监听器的文件描述符
+type response struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Privileged 判断绑定该地址是否需要特权
+func Privileged(address string) bool {
+	_, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.Atoi(portStr)
+	return err == nil && port > 0 && port < 1024
+}
+
+// PrivilegedPorts 返回需要特权的端口，去除重复
+func PrivilegedPorts(ports []int) []int {
+	seen := make(map[int]bool)
+	var result []int
+	for _, port := range ports {
+		if port > 0 && port < 1024 && !seen[port] {
+			seen[port] = true
+			result = append(result, port)
+		}
+	}
+	return result
+}
+
+// allowed 检查辅助进程是否允许绑定该地址
+func allowed(ports []int, network, address string) error {
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return fmt.Errorf("不支持的协议: %s", network)
+	}
+	_, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("无效的地址: %s", address)
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("无效的端口: %s", portStr)
+	}
+	for _, p := range ports {
+		if p == port {
+			return nil
+		}
+	}
+	return fmt.Errorf("端口 %d 不在允许绑定的端口中", port)
+}
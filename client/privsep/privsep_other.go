@@ -0,0 +1,33 @@
+//go:build !unix
+
+package privsep
+
+import "net"
+
+// Client 主进程与辅助进程的连接
+type Client struct{}
+
+// Start 当前平台不支持权限分离
+func Start(ports []int) (*Client, error) {
+	return nil, ErrUnsupported
+}
+
+// Listen 直接创建监听器
+func (c *Client) Listen(network, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
+
+// Close 关闭连接
+func (c *Client) Close() error {
+	return nil
+}
+
+// Serve 当前平台不支持权限分离
+func Serve() error {
+	return ErrUnsupported
+}
+
+// DropPrivileges 当前平台不支持权限分离
+func DropPrivileges(username string) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,70 @@
+//go:build unix
+
+package privsep
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+// unixPair 创建一对相连的 Unix 套接字
+func unixPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatalf("创建套接字失败: %v", err)
+	}
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		file := os.NewFile(uintptr(fd), "pair")
+		conn, err := net.FileConn(file)
+		file.Close()
+		if err != nil {
+			t.Fatalf("创建连接失败: %v", err)
+		}
+		conns[i] = conn.(*net.UnixConn)
+	}
+	return conns[0], conns[1]
+}
+
+func TestHelperListen(t *testing.T) {
+	// 找一个空闲端口作为允许绑定的端口
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	port := probe.Addr().(*net.TCPAddr).Port
+	probe.Close()
+
+	parent, child := unixPair(t)
+	go serve(child, []int{port})
+	client := &Client{conn: parent}
+	defer parent.Close()
+
+	listener, err := client.listen("tcp", "127.0.0.1:"+strconv.Itoa(port))
+	if err != nil {
+		t.Fatalf("辅助进程绑定允许的端口失败: %v", err)
+	}
+	defer listener.Close()
+
+	// 主进程可以使用传回的监听器接受连接
+	go func() {
+		if conn, err := net.Dial("tcp", listener.Addr().String()); err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("接受连接失败: %v", err)
+	}
+	conn.Close()
+
+	if _, err := client.listen("tcp", "127.0.0.1:"+strconv.Itoa(port+1)); err == nil {
+		t.Fatal("不允许的端口应返回错误")
+	}
+	if _, err := client.listen("udp", "127.0.0.1:"+strconv.Itoa(port)); err == nil {
+		t.Fatal("不支持的协议应返回错误")
+	}
+}
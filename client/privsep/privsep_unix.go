@@ -0,0 +1,224 @@
+//go:build unix
+
+package privsep
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Client 主进程与辅助进程的连接
+type Client struct {
+	conn *net.UnixConn
+	cmd  *exec.Cmd
+	mu   sync.Mutex // 同一时间只处理一个请求
+}
+
+// Start 启动辅助进程，辅助进程只允许绑定 ports 中的端口。需要以 root 运行
+func Start(ports []int) (*Client, error) {
+	if os.Geteuid() != 0 {
+		return nil, fmt.Errorf("启动特权辅助进程需要 root 权限")
+	}
+
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, fmt.Errorf("创建套接字失败: %w", err)
+	}
+	parent := os.NewFile(uintptr(fds[0]), "privsep-parent")
+	child := os.NewFile(uintptr(fds[1]), "privsep-child")
+	defer child.Close()
+	defer parent.Close()
+
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("获取可执行文件路径失败: %w", err)
+	}
+
+	portList := make([]string, 0, len(ports))
+	for _, port := range ports {
+		portList = append(portList, strconv.Itoa(port))
+	}
+	cmd := exec.Command(exe, "-"+HelperFlag)
+	cmd.Env = []string{portsEnv + "=" + strings.Join(portList, ",")}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{child} // 辅助进程中的文件描述符 3
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("启动特权辅助进程失败: %w", err)
+	}
+
+	conn, err := net.FileConn(parent)
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, fmt.Errorf("连接特权辅助进程失败: %w", err)
+	}
+	return &Client{conn: conn.(*net.UnixConn), cmd: cmd}, nil
+}
+
+// Listen 创建监听器，需要特权的端口由辅助进程绑定，其他端口直接绑定
+func (c *Client) Listen(network, address string) (net.Listener, error) {
+	if !Privileged(address) {
+		return net.Listen(network, address)
+	}
+	return c.listen(network, address)
+}
+
+// listen 请求辅助进程绑定地址
+func (c *Client) listen(network, address string) (net.Listener, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	data, _ := json.Marshal(request{Op: "listen", Network: network, Address: address})
+	if _, err := c.conn.Write(append(data, '\n')); err != nil {
+		return nil, fmt.Errorf("发送请求到特权辅助进程失败: %w", err)
+	}
+
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(4))
+	n, oobn, _, _, err := c.conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		return nil, fmt.Errorf("读取特权辅助进程响应失败: %w", err)
+	}
+
+	var resp response
+	if err := json.Unmarshal(buf[:n], &resp); err != nil {
+		return nil, fmt.Errorf("解析特权辅助进程响应失败: %w", err)
+	}
+	if resp.Error != "" {
+		return nil, fmt.Errorf("特权辅助进程绑定 %s 失败: %s", address, resp.Error)
+	}
+
+	fd, err := parseRights(oob[:oobn])
+	if err != nil {
+		return nil, err
+	}
+	file := os.NewFile(uintptr(fd), address)
+	defer file.Close()
+	return net.FileListener(file)
+}
+
+// Close 关闭连接，辅助进程随之退出
+func (c *Client) Close() error {
+	err := c.conn.Close()
+	c.cmd.Wait()
+	return err
+}
+
+// parseRights 取出控制消息中的文件描述符
+func parseRights(oob []byte) (int, error) {
+	messages, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return -1, fmt.Errorf("解析控制消息失败: %w", err)
+	}
+	for _, msg := range messages {
+		fds, err := syscall.ParseUnixRights(&msg)
+		if err == nil && len(fds) > 0 {
+			for _, extra := range fds[1:] {
+				syscall.Close(extra)
+			}
+			return fds[0], nil
+		}
+	}
+	return -1, fmt.Errorf("特权辅助进程未返回文件描述符")
+}
+
+// Serve 以辅助进程模式运行，处理主进程的请求，主进程退出后返回
+func Serve() error {
+	var ports []int
+	for _, s := range strings.Split(os.Getenv(portsEnv), ",") {
+		if port, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+			ports = append(ports, port)
+		}
+	}
+
+	conn, err := net.FileConn(os.NewFile(3, "privsep-child"))
+	if err != nil {
+		return fmt.Errorf("连接主进程失败: %w", err)
+	}
+	defer conn.Close()
+	return serve(conn.(*net.UnixConn), ports)
+}
+
+// serve 处理主进程的请求，只绑定 ports 中的端口
+func serve(unixConn *net.UnixConn, ports []int) error {
+	scanner := bufio.NewScanner(unixConn)
+	for scanner.Scan() {
+		var req request
+		if err := json.Unmarshal(scanner.Bytes(), &req); err != nil || req.Op != "listen" {
+			reply(unixConn, response{Error: "无效的请求"}, -1)
+			continue
+		}
+
+		file, err := listenFile(ports, req.Network, req.Address)
+		if err != nil {
+			reply(unixConn, response{Error: err.Error()}, -1)
+			continue
+		}
+		reply(unixConn, response{}, int(file.Fd()))
+		file.Close()
+	}
+	return scanner.Err()
+}
+
+// listenFile 绑定允许的地址并返回监听器的文件
+func listenFile(ports []int, network, address string) (*os.File, error) {
+	if err := allowed(ports, network, address); err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	return listener.(*net.TCPListener).File()
+}
+
+// reply 发送响应，fd 不小于 0 时一并传递文件描述符
+func reply(conn *net.UnixConn, resp response, fd int) {
+	data, _ := json.Marshal(resp)
+	var oob []byte
+	if fd >= 0 {
+		oob = syscall.UnixRights(fd)
+	}
+	conn.WriteMsgUnix(data, oob, nil)
+}
+
+// DropPrivileges 将当前进程切换为指定的普通用户
+func DropPrivileges(username string) error {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return fmt.Errorf("查找用户 %s 失败: %w", username, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("无效的用户 ID: %s", u.Uid)
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return fmt.Errorf("无效的组 ID: %s", u.Gid)
+	}
+
+	// 先放弃附加组和组 ID，放弃用户 ID 后无法再修改
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("设置附加组失败: %w", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("设置组 ID 失败: %w", err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("设置用户 ID 失败: %w", err)
+	}
+	if os.Geteuid() == 0 {
+		return fmt.Errorf("降低权限后仍为 root")
+	}
+	return nil
+}
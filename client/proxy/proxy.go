@@ -0,0 +1,223 @@
+// Package proxy 为客户端的出站连接选择 HTTP 代理。未配置代理时使用
+// HTTP_PROXY、HTTPS_PROXY 和 NO_PROXY 环境变量，代理认证信息写在代理地址中
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Proxy 出站连接使用的代理设置
+type Proxy struct {
+	url     *url.URL // 配置的代理地址，为 nil 时使用环境变量
+	noProxy []string // 不使用代理的主机、域名后缀、IP 或网段
+
+	transport     *http.Transport
+	transportOnce sync.Once
+}
+
+// FromEnvironment 使用环境变量中的代理设置
+func FromEnvironment() *Proxy {
+	return &Proxy{}
+}
+
+// New 创建代理设置。rawURL 为空时使用环境变量；
+// noProxy 为逗号分隔的不使用代理的地址，为空时使用 NO_PROXY 环境变量
+func New(rawURL, noProxy string) (*Proxy, error) {
+	if rawURL == "" {
+		return FromEnvironment(), nil
+	}
+
+	u, err := Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if noProxy == "" {
+		noProxy = getEnv("NO_PROXY", "no_proxy")
+	}
+
+	p := &Proxy{url: u}
+	for _, entry := range strings.Split(noProxy, ",") {
+		if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" {
+			p.noProxy = append(p.noProxy, entry)
+		}
+	}
+	return p, nil
+}
+
+// Parse 解析代理地址，只支持 http 和 https 代理
+func Parse(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("解析代理地址失败: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("不支持的代理协议: %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("代理地址缺少主机: %s", rawURL)
+	}
+	return u, nil
+}
+
+// ForRequest 返回请求使用的代理地址，不使用代理时返回 nil，可用作 http.Transport.Proxy
+func (p *Proxy) ForRequest(req *http.Request) (*url.URL, error) {
+	if p.url == nil {
+		return http.ProxyFromEnvironment(req)
+	}
+	if p.bypass(req.URL.Hostname()) {
+		return nil, nil
+	}
+	return p.url, nil
+}
+
+// Transport 使用代理的 HTTP 传输，多次调用返回同一个实例以复用连接
+func (p *Proxy) Transport() *http.Transport {
+	p.transportOnce.Do(func() {
+		p.transport = http.DefaultTransport.(*http.Transport).Clone()
+		p.transport.Proxy = p.ForRequest
+	})
+	return p.transport
+}
+
+// DialContext 建立到 address 的 TCP 连接，需要代理时通过 HTTP CONNECT 隧道连接
+func (p *Proxy) DialContext(ctx context.Context, address string) (net.Conn, error) {
+	proxyURL, err := p.ForRequest(&http.Request{URL: &url.URL{Scheme: "https", Host: address}})
+	if err != nil {
+		return nil, err
+	}
+
+	var dialer net.Dialer
+	if proxyURL == nil {
+		return dialer.DialContext(ctx, "tcp", address)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", hostPort(proxyURL))
+	if err != nil {
+		return nil, fmt.Errorf("连接代理服务器失败: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("与代理服务器握手失败: %w", err)
+		}
+		conn = tlsConn
+	}
+
+	reader, err := connect(conn, proxyURL, address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	// 代理在响应后已转发的数据留在缓冲区中
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// connect 请求代理建立到 address 的隧道
+func connect(conn net.Conn, proxyURL *url.URL, address string) (*bufio.Reader, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if user := proxyURL.User; user != nil {
+		password, _ := user.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(user.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, fmt.Errorf("发送代理请求失败: %w", err)
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, fmt.Errorf("读取代理响应失败: %w", err)
+	}
+	resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return reader, nil
+	case http.StatusProxyAuthRequired:
+		return nil, fmt.Errorf("代理认证失败: %s", resp.Status)
+	default:
+		return nil, fmt.Errorf("代理拒绝连接 %s: %s", address, resp.Status)
+	}
+}
+
+// bypass 判断主机是否不使用代理
+func (p *Proxy) bypass(host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range p.noProxy {
+		if entry == "*" {
+			return true
+		}
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && network.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		domain := strings.TrimPrefix(entry, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// hostPort 代理服务器地址，未指定端口时使用协议的默认端口
+func hostPort(u *url.URL) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+	if u.Scheme == "https" {
+		return net.JoinHostPort(u.Hostname(), "443")
+	}
+	return net.JoinHostPort(u.Hostname(), "80")
+}
+
+// getEnv 返回第一个不为空的环境变量
+func getEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// bufferedConn 先读取缓冲区中剩余数据的连接
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,114 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBypass(t *testing.T) {
+	p, err := New("http://proxy.corp:3128", "localhost, .internal.example.com,10.0.0.0/8")
+	if err != nil {
+		t.Fatalf("创建代理设置失败: %v", err)
+	}
+
+	for host, want := range map[string]bool{
+		"localhost":                true,
+		"api.internal.example.com": true,
+		"internal.example.com":     true,
+		"10.1.2.3":                 true,
+		"p3.example.com":           false,
+		"192.168.1.1":              false,
+	} {
+		if got := p.bypass(host); got != want {
+			t.Errorf("%s 是否绕过代理应为 %t", host, want)
+		}
+	}
+
+	if _, err := New("socks5://proxy.corp:1080", ""); err == nil {
+		t.Fatal("不支持的代理协议应返回错误")
+	}
+}
+
+func TestDialThroughProxy(t *testing.T) {
+	// 目标服务回显收到的数据
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer target.Close()
+	go func() {
+		for {
+			conn, err := target.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	// 要求认证的 CONNECT 代理
+	proxyListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer proxyListener.Close()
+	go func() {
+		for {
+			conn, err := proxyListener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				if req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpzZWNyZXQ=" {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer upstream.Close()
+				io.WriteString(conn, "HTTP/1.1 200 Connection Established\r\n\r\n")
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	p, _ := New("http://user:wrong@"+proxyListener.Addr().String(), "none")
+	if _, err := p.DialContext(ctx, target.Addr().String()); err == nil {
+		t.Fatal("代理认证失败时应返回错误")
+	}
+
+	p, _ = New("http://user:secret@"+proxyListener.Addr().String(), "none")
+	conn, err := p.DialContext(ctx, target.Addr().String())
+	if err != nil {
+		t.Fatalf("通过代理连接失败: %v", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatalf("发送失败: %v", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("应收到回显: %q %v", buf, err)
+	}
+}
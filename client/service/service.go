@@ -131,10 +131,12 @@ Description=P3 Client
 After=network.target
 
 [Service]
-Type=simple
+Type=notify
+NotifyAccess=main
 ExecStart=%s -config %s
 Restart=always
 RestartSec=10
+WatchdogSec=30
 
 [Install]
 WantedBy=multi-user.target
@@ -0,0 +1,189 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// listenFDsStart systemd 传递的第一个文件描述符
+const listenFDsStart = 3
+
+// ActivatedListeners systemd 套接字激活传入的监听器
+type ActivatedListeners struct {
+	listeners []activatedListener
+	mu        sync.Mutex
+}
+
+// activatedListener 单个激活的监听器
+type activatedListener struct {
+	name     string
+	listener net.Listener
+}
+
+// Activate 获取 systemd 通过 LISTEN_FDS 传入的监听器，未使用套接字激活时返回空集合
+func Activate() (*ActivatedListeners, error) {
+	return activate(listenFDsStart)
+}
+
+// activate 按 LISTEN_* 环境变量从文件描述符 start 开始创建监听器
+func activate(start int) (*ActivatedListeners, error) {
+	activated := &ActivatedListeners{}
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return activated, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return activated, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	// 避免子进程重复使用
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+
+		file := os.NewFile(uintptr(start+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			activated.Close()
+			return nil, fmt.Errorf("使用套接字 %d 创建监听器失败: %w", start+i, err)
+		}
+
+		activated.listeners = append(activated.listeners, activatedListener{
+			name:     name,
+			listener: listener,
+		})
+	}
+
+	return activated, nil
+}
+
+// Take 取出与名称或端口匹配的监听器，名称对应 socket 单元的 FileDescriptorName。
+// 每个监听器只能取出一次，没有匹配时返回 nil
+func (a *ActivatedListeners) Take(name string, port int) net.Listener {
+	if a == nil {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for i, l := range a.listeners {
+		if (name != "" && l.name == name) || (port > 0 && listenerPort(l.listener) == port) {
+			a.listeners = append(a.listeners[:i], a.listeners[i+1:]...)
+			return l.listener
+		}
+	}
+	return nil
+}
+
+// Close 关闭未被取出的监听器
+func (a *ActivatedListeners) Close() {
+	if a == nil {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, l := range a.listeners {
+		l.listener.Close()
+	}
+	a.listeners = nil
+}
+
+// listenerPort 获取监听器的端口
+func listenerPort(listener net.Listener) int {
+	if addr, ok := listener.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return -1
+}
+
+// Notify 通过 NOTIFY_SOCKET 向 systemd 发送状态，例如 READY=1。
+// 未由 systemd 以 Type=notify 启动时不做任何操作，返回 false
+func Notify(state string) (bool, error) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return false, nil
+	}
+
+	// 以 @ 开头表示抽象命名空间套接字
+	if socketPath[0] == '@' {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("连接 systemd 通知套接字失败: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, fmt.Errorf("发送 systemd 通知失败: %w", err)
+	}
+	return true, nil
+}
+
+// WatchdogInterval 获取 systemd 看门狗超时时间，未启用看门狗时返回 0
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	if pidStr := os.Getenv("WATCHDOG_PID"); pidStr != "" {
+		pid, err := strconv.Atoi(pidStr)
+		if err != nil || pid != os.Getpid() {
+			return 0
+		}
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// StartWatchdog 按看门狗超时时间的一半定期发送 WATCHDOG=1，healthy 返回 false 时跳过本次发送，
+// 使 systemd 在客户端失去响应时重启服务。未启用看门狗时不做任何操作。返回的函数用于停止发送
+func StartWatchdog(healthy func() bool) func() {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return func() {}
+	}
+
+	stopCh := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stopCh:
+				return
+			case <-ticker.C:
+				if healthy != nil && !healthy() {
+					continue
+				}
+				Notify("WATCHDOG=1")
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(stopCh) })
+	}
+}
@@ -0,0 +1,226 @@
+//go:build linux
+
+package service
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testFDsStart 测试中放置激活套接字的文件描述符，避开进程已使用的描述符
+const testFDsStart = 200
+
+// passListeners 模拟 systemd 把监听套接字依次放到从 testFDsStart 开始的文件描述符上
+func passListeners(t *testing.T, count int) []int {
+	t.Helper()
+	ports := make([]int, 0, count)
+	for i := 0; i < count; i++ {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("监听失败: %v", err)
+		}
+		file, err := listener.(*net.TCPListener).File()
+		if err != nil {
+			t.Fatalf("获取套接字失败: %v", err)
+		}
+		if err := syscall.Dup3(int(file.Fd()), testFDsStart+i, syscall.O_CLOEXEC); err != nil {
+			t.Fatalf("复制套接字失败: %v", err)
+		}
+		ports = append(ports, listener.Addr().(*net.TCPAddr).Port)
+		file.Close()
+		listener.Close()
+	}
+	return ports
+}
+
+func TestActivatePIDMismatch(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+
+	// 环境变量是传给其他进程的，不使用也不清除
+	activated, err := activate(testFDsStart)
+	if err != nil || len(activated.listeners) != 0 {
+		t.Fatalf("PID 不匹配时不应使用套接字: %+v %v", activated, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "1" {
+		t.Fatal("PID 不匹配时不应清除环境变量")
+	}
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	for _, fds := range []string{"", "0", "abc"} {
+		t.Setenv("LISTEN_FDS", fds)
+		if activated, err := activate(testFDsStart); err != nil || len(activated.listeners) != 0 {
+			t.Fatalf("LISTEN_FDS=%q 时不应使用套接字: %+v %v", fds, activated, err)
+		}
+	}
+}
+
+func TestActivateNamedFDs(t *testing.T) {
+	ports := passListeners(t, 3)
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "3")
+	t.Setenv("LISTEN_FDNAMES", "web:ssh")
+
+	activated, err := activate(testFDsStart)
+	if err != nil {
+		t.Fatalf("获取激活的监听器失败: %v", err)
+	}
+	defer activated.Close()
+	for _, key := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		if _, ok := os.LookupEnv(key); ok {
+			t.Fatalf("使用后应清除 %s，避免子进程重复使用", key)
+		}
+	}
+
+	// 按名称或端口取出，每个监听器只能取出一次
+	ssh := activated.Take("ssh", 0)
+	if ssh == nil || listenerPort(ssh) != ports[1] {
+		t.Fatal("应按名称取出监听器")
+	}
+	defer ssh.Close()
+	if activated.Take("ssh", 0) != nil {
+		t.Fatal("监听器只能取出一次")
+	}
+	unnamed := activated.Take("", ports[2])
+	if unnamed == nil {
+		t.Fatal("没有名称的监听器应按端口取出")
+	}
+	defer unnamed.Close()
+	if activated.Take("dns", 53) != nil {
+		t.Fatal("没有匹配时应返回 nil")
+	}
+
+	// 取出的监听器可以接受连接
+	conn, err := net.Dial("tcp", ssh.Addr().String())
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	conn.Close()
+
+	// 关闭未被取出的监听器
+	activated.Close()
+	if _, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(ports[0])), time.Second); err == nil {
+		t.Fatal("未被取出的监听器应被关闭")
+	}
+}
+
+// notifySocket 创建 systemd 通知套接字并设置 NOTIFY_SOCKET
+func notifySocket(t *testing.T) *net.UnixConn {
+	t.Helper()
+	// 套接字路径长度有限，不使用较长的测试临时目录
+	dir, err := os.MkdirTemp("", "sd")
+	if err != nil {
+		t.Fatalf("创建目录失败: %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("创建通知套接字失败: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// readNotify 读取一条通知，超时返回空字符串
+func readNotify(conn *net.UnixConn, timeout time.Duration) string {
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return ""
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify("READY=1"); sent || err != nil {
+		t.Fatalf("未设置 NOTIFY_SOCKET 时不应发送: %t %v", sent, err)
+	}
+
+	conn := notifySocket(t)
+	if sent, err := Notify("READY=1"); !sent || err != nil {
+		t.Fatalf("发送通知失败: %t %v", sent, err)
+	}
+	if got := readNotify(conn, time.Second); got != "READY=1" {
+		t.Fatalf("收到的通知为 %q", got)
+	}
+
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(os.TempDir(), "p3-missing-notify"))
+	if sent, err := Notify("READY=1"); sent || err == nil {
+		t.Fatal("通知套接字不存在时应返回错误")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		usec string
+		pid  string
+		want time.Duration
+	}{
+		{usec: "", want: 0},
+		{usec: "abc", want: 0},
+		{usec: "-1", want: 0},
+		{usec: "30000000", want: 30 * time.Second},
+		{usec: "30000000", pid: pid, want: 30 * time.Second},
+		{usec: "30000000", pid: strconv.Itoa(os.Getpid() + 1), want: 0},
+		{usec: "30000000", pid: "abc", want: 0},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := WatchdogInterval(); got != tt.want {
+			t.Errorf("WATCHDOG_USEC=%q WATCHDOG_PID=%q 时看门狗超时为 %v, 期望 %v", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
+
+func TestStartWatchdog(t *testing.T) {
+	conn := notifySocket(t)
+	t.Setenv("WATCHDOG_PID", "")
+
+	// 未启用看门狗时不发送
+	t.Setenv("WATCHDOG_USEC", "")
+	StartWatchdog(nil)()
+
+	// 按超时时间的一半发送 WATCHDOG=1
+	t.Setenv("WATCHDOG_USEC", "100000")
+	healthy := make(chan bool, 1)
+	healthy <- true
+	stop := StartWatchdog(func() bool {
+		ok := <-healthy
+		healthy <- ok
+		return ok
+	})
+	defer stop()
+	if got := readNotify(conn, time.Second); got != "WATCHDOG=1" {
+		t.Fatalf("收到的通知为 %q", got)
+	}
+
+	// 不健康时跳过发送，使 systemd 重启服务
+	<-healthy
+	healthy <- false
+	readNotify(conn, 60*time.Millisecond)
+	if got := readNotify(conn, 200*time.Millisecond); got != "" {
+		t.Fatalf("不健康时不应发送通知: %q", got)
+	}
+
+	// 停止后不再发送，重复停止不会出错
+	<-healthy
+	healthy <- true
+	stop()
+	stop()
+	readNotify(conn, 60*time.Millisecond)
+	if got := readNotify(conn, 200*time.Millisecond); got != "" {
+		t.Fatalf("停止后不应发送通知: %q", got)
+	}
+}
@@ -0,0 +1,95 @@
+package simple
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+const (
+	// KeySize 预共享密钥的字节数
+	KeySize = 32
+	// keyPrefix 配对码的前缀，包含格式版本
+	keyPrefix = "p3psk1:"
+	// pairingScheme 配对链接的协议，配对链接可以生成二维码在设备之间传递
+	pairingScheme = "p3"
+)
+
+// Key 预共享密钥。会合消息的加密密钥和会合信箱的名称都从预共享密钥派生，
+// 不知道密钥的第三方（包括 STUN 服务器和会合信箱）无法读取或伪造会合消息
+type Key [KeySize]byte
+
+// GenerateKey 生成随机的预共享密钥
+func GenerateKey() (Key, error) {
+	var key Key
+	if _, err := rand.Read(key[:]); err != nil {
+		return key, fmt.Errorf("生成预共享密钥失败: %w", err)
+	}
+	return key, nil
+}
+
+// ParseKey 解析配对码，也接受配对链接
+func ParseKey(s string) (Key, error) {
+	var key Key
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, pairingScheme+"://") {
+		parsed, _, err := ParsePairingURI(s)
+		return parsed, err
+	}
+	if !strings.HasPrefix(s, keyPrefix) {
+		return key, errors.New("配对码格式无效")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, keyPrefix))
+	if err != nil || len(data) != KeySize {
+		return key, errors.New("配对码格式无效")
+	}
+	copy(key[:], data)
+	return key, nil
+}
+
+// String 配对码，例如 p3psk1:Xb3...
+func (k Key) String() string {
+	return keyPrefix + base64.RawURLEncoding.EncodeToString(k[:])
+}
+
+// PairingURI 配对链接，包含配对码和生成配对码的节点名称，对端导入后以该节点为对端
+func PairingURI(key Key, node string) string {
+	query := url.Values{}
+	query.Set("key", key.String())
+	if node != "" {
+		query.Set("node", node)
+	}
+	return pairingScheme + "://pair?" + query.Encode()
+}
+
+// ParsePairingURI 解析配对链接，返回配对码和生成配对码的节点名称
+func ParsePairingURI(uri string) (Key, string, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != pairingScheme || u.Host != "pair" {
+		return Key{}, "", errors.New("配对链接格式无效")
+	}
+	query := u.Query()
+	key, err := ParseKey(query.Get("key"))
+	if err != nil {
+		return Key{}, "", err
+	}
+	return key, query.Get("node"), nil
+}
+
+// derive 派生指定用途的子密钥
+func (k Key) derive(label string) []byte {
+	mac := hmac.New(sha256.New, k[:])
+	mac.Write([]byte("p3-simple|" + label))
+	return mac.Sum(nil)
+}
+
+// mailbox 节点在会合信箱中的名称，只有知道密钥的一方能算出
+func (k Key) mailbox(node string) string {
+	return hex.EncodeToString(k.derive("mailbox|" + node)[:16])
+}
@@ -0,0 +1,41 @@
+package simple
+
+import "testing"
+
+func TestParseKey(t *testing.T) {
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := ParseKey(key.String())
+	if err != nil || parsed != key {
+		t.Fatalf("解析配对码失败: %v", err)
+	}
+
+	uri := PairingURI(key, "home-pc")
+	parsed, node, err := ParsePairingURI(uri)
+	if err != nil || parsed != key || node != "home-pc" {
+		t.Fatalf("解析配对链接失败: %v, %s", err, node)
+	}
+	if parsed, err := ParseKey(uri); err != nil || parsed != key {
+		t.Fatalf("配对链接应可作为配对码解析: %v", err)
+	}
+
+	for _, invalid := range []string{"", "p3psk1:", "p3psk1:abc", "psk:" + key.String()[len(keyPrefix):], "p3://other?key=" + key.String()} {
+		if _, err := ParseKey(invalid); err == nil {
+			t.Errorf("无效的配对码 %q 应解析失败", invalid)
+		}
+	}
+}
+
+func TestMailboxDependsOnKeyAndNode(t *testing.T) {
+	a, _ := GenerateKey()
+	b, _ := GenerateKey()
+	if a.mailbox("n1") == a.mailbox("n2") {
+		t.Error("不同节点的信箱名称应不同")
+	}
+	if a.mailbox("n1") == b.mailbox("n1") {
+		t.Error("不同密钥的信箱名称应不同")
+	}
+}
@@ -0,0 +1,507 @@
+package simple
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/client/nat"
+	"github.com/senma231/p3/common/logger"
+)
+
+const (
+	// packetMagic 会合消息的前缀，包含格式版本
+	packetMagic = "P3S1"
+	// maxClockSkew 会合消息的时间与本机时间的最大偏差，超出时视为重放
+	maxClockSkew = 2 * time.Minute
+	// maxEndpoints 会合消息中通告的地址数上限
+	maxEndpoints = 8
+	// maxPacketSize 会合消息的最大长度
+	maxPacketSize = 2048
+	// offlineIntervals 超过该数量的会合间隔未收到对端的消息时视为对端离线
+	offlineIntervals = 3
+	// mailboxTimeout 访问会合信箱的超时
+	mailboxTimeout = 10 * time.Second
+	// stunMagicCookie STUN 消息头中的魔术字，用于区分会合端口收到的 STUN 响应和会合消息
+	stunMagicCookie = 0x2112A442
+)
+
+var errReplay = errors.New("重复的会合消息")
+
+// Options 会合参数
+type Options struct {
+	NodeID string // 本节点名称
+	Peer   string // 对端节点名称
+	Key    Key
+	// 会合使用的 UDP 端口，0 表示随机端口
+	ListenPort int
+	// 已知的对端地址（host:port），为空时只使用对端通告的地址
+	PeerAddress string
+	// 会合信箱地址，为空时不使用
+	RendezvousURL string
+	// 获取外部地址的 STUN 服务器，与会合使用同一个 UDP 端口，获取到的地址即对端可以直接送达的地址
+	STUNServers []string
+	// 本机的局域网地址，参数为会合使用的端口
+	LocalEndpoints func(port int) []string
+	// 发送会合消息的间隔
+	Interval time.Duration
+}
+
+// announcement 会合消息。挑战每个会合间隔更换一次，对端在回复中带回本节点的挑战，
+// 收到带有本节点挑战的消息才确认对端的地址，重放旧消息不能改变对端地址
+type announcement struct {
+	Node      string   `json:"node"`      // 发送方
+	Peer      string   `json:"peer"`      // 接收方，双方使用同一个密钥，避免把自己发出的消息当作对端的消息
+	Endpoints []string `json:"endpoints"` // 发送方可以送达的地址
+	Challenge string   `json:"challenge"` // 发送方当前的挑战
+	Echo      string   `json:"echo,omitempty"`
+	Time      int64    `json:"time"` // 发送时间，Unix 秒
+}
+
+// PeerHandler 对端地址确认或对端离线时调用
+type PeerHandler func(addr *net.UDPAddr, online bool)
+
+// Rendezvous 不使用服务端的会合。双方定期向已知的对端地址、对端通告的地址和会合信箱发送加密的会合消息，
+// 同时在同一个 UDP 端口上打洞，收到对端带回挑战的消息后确认对端的地址
+type Rendezvous struct {
+	opts   Options
+	aead   cipher.AEAD
+	client *http.Client
+
+	conn    *net.UDPConn
+	onPeer  PeerHandler
+	done    chan struct{}
+	closing sync.Once
+
+	mu            sync.Mutex
+	public        string               // STUN 获取的外部地址
+	stunRequests  map[[12]byte]bool    // 等待响应的 STUN 请求
+	challenge     string               // 本节点当前的挑战
+	prevChallenge string               // 上一个挑战，对端的回复可能在更换挑战后到达
+	peerChallenge string               // 最近收到的对端挑战
+	peerEndpoints []string             // 对端通告的地址
+	peerAddr      *net.UDPAddr         // 确认的对端地址
+	lastSeen      time.Time            // 最近一次确认对端地址的时间
+	online        bool                 // 对端是否在线
+	nonces        map[string]time.Time // 最近收到的消息的随机数，用于识别重放
+}
+
+// New 创建会合
+func New(opts Options) (*Rendezvous, error) {
+	if opts.NodeID == "" || opts.Peer == "" {
+		return nil, errors.New("节点名称和对端节点名称不能为空")
+	}
+	if opts.NodeID == opts.Peer {
+		return nil, errors.New("对端节点名称不能与本节点相同")
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = 10 * time.Second
+	}
+	block, err := aes.NewCipher(opts.Key.derive("announce"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Rendezvous{
+		opts:         opts,
+		aead:         aead,
+		client:       &http.Client{Timeout: mailboxTimeout},
+		done:         make(chan struct{}),
+		stunRequests: make(map[[12]byte]bool),
+		nonces:       make(map[string]time.Time),
+	}, nil
+}
+
+// OnPeer 设置对端地址确认或对端离线时的回调，需在 Start 之前调用
+func (r *Rendezvous) OnPeer(handler PeerHandler) {
+	r.onPeer = handler
+}
+
+// Start 监听会合端口并开始会合
+func (r *Rendezvous) Start() error {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: r.opts.ListenPort})
+	if err != nil {
+		return fmt.Errorf("监听会合端口失败: %w", err)
+	}
+	r.conn = conn
+
+	go r.readLoop()
+	go r.run()
+	return nil
+}
+
+// Close 停止会合
+func (r *Rendezvous) Close() error {
+	var err error
+	r.closing.Do(func() {
+		close(r.done)
+		if r.conn != nil {
+			err = r.conn.Close()
+		}
+	})
+	return err
+}
+
+// LocalPort 会合使用的 UDP 端口
+func (r *Rendezvous) LocalPort() int {
+	if r.conn == nil {
+		return 0
+	}
+	return r.conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// PublicAddress STUN 获取的外部地址，尚未获取时为空
+func (r *Rendezvous) PublicAddress() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.public
+}
+
+// Peer 确认的对端地址，对端不在线时 online 为 false
+func (r *Rendezvous) Peer() (addr *net.UDPAddr, online bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.peerAddr, r.online
+}
+
+// run 每个会合间隔更换挑战、获取外部地址、发送会合消息并检查对端是否离线
+func (r *Rendezvous) run() {
+	ticker := time.NewTicker(r.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.rotateChallenge()
+		r.discover()
+		r.announce()
+		r.exchangeMailbox()
+		r.checkOffline()
+
+		select {
+		case <-r.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rotateChallenge 更换挑战
+func (r *Rendezvous) rotateChallenge() {
+	challenge := make([]byte, 12)
+	rand.Read(challenge)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.prevChallenge, r.challenge = r.challenge, base64.RawURLEncoding.EncodeToString(challenge)
+}
+
+// discover 从会合端口向 STUN 服务器发送绑定请求，响应由 readLoop 处理
+func (r *Rendezvous) discover() {
+	r.mu.Lock()
+	r.stunRequests = make(map[[12]byte]bool)
+	r.mu.Unlock()
+
+	for _, server := range r.opts.STUNServers {
+		addr, err := net.ResolveUDPAddr("udp", server)
+		if err != nil {
+			logger.Debug("解析 STUN 服务器地址 %s 失败: %v", server, err)
+			continue
+		}
+		req, err := nat.NewSTUNRequest()
+		if err != nil {
+			continue
+		}
+		data, err := req.Marshal()
+		if err != nil {
+			continue
+		}
+		r.mu.Lock()
+		r.stunRequests[req.TransID] = true
+		r.mu.Unlock()
+		r.conn.WriteToUDP(data, addr)
+	}
+}
+
+// announce 向已知的对端地址发送会合消息，同时为对端打开 NAT 映射
+func (r *Rendezvous) announce() {
+	packet, err := r.seal()
+	if err != nil {
+		logger.Warn("生成会合消息失败: %v", err)
+		return
+	}
+	for _, addr := range r.targets() {
+		r.conn.WriteToUDP(packet, addr)
+	}
+}
+
+// targets 会合消息的目标地址：配置的对端地址、确认的对端地址和对端通告的地址
+func (r *Rendezvous) targets() []*net.UDPAddr {
+	r.mu.Lock()
+	candidates := append([]string(nil), r.peerEndpoints...)
+	if r.peerAddr != nil {
+		candidates = append([]string{r.peerAddr.String()}, candidates...)
+	}
+	r.mu.Unlock()
+	if r.opts.PeerAddress != "" {
+		candidates = append([]string{r.opts.PeerAddress}, candidates...)
+	}
+
+	seen := make(map[string]bool, len(candidates))
+	var targets []*net.UDPAddr
+	for _, candidate := range candidates {
+		addr, err := net.ResolveUDPAddr("udp", candidate)
+		if err != nil || seen[addr.String()] {
+			continue
+		}
+		seen[addr.String()] = true
+		targets = append(targets, addr)
+	}
+	return targets
+}
+
+// endpoints 本节点可以送达的地址：STUN 获取的外部地址和局域网地址
+func (r *Rendezvous) endpoints() []string {
+	var endpoints []string
+	if public := r.PublicAddress(); public != "" {
+		endpoints = append(endpoints, public)
+	}
+	if r.opts.LocalEndpoints != nil {
+		endpoints = append(endpoints, r.opts.LocalEndpoints(r.LocalPort())...)
+	}
+	if len(endpoints) > maxEndpoints {
+		endpoints = endpoints[:maxEndpoints]
+	}
+	return endpoints
+}
+
+// seal 生成加密的会合消息
+func (r *Rendezvous) seal() ([]byte, error) {
+	r.mu.Lock()
+	msg := announcement{
+		Node:      r.opts.NodeID,
+		Peer:      r.opts.Peer,
+		Challenge: r.challenge,
+		Echo:      r.peerChallenge,
+		Time:      time.Now().Unix(),
+	}
+	r.mu.Unlock()
+	msg.Endpoints = r.endpoints()
+
+	plaintext, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, r.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	packet := append([]byte(packetMagic), nonce...)
+	return r.aead.Seal(packet, nonce, plaintext, []byte(packetMagic)), nil
+}
+
+// open 解密并验证会合消息
+func (r *Rendezvous) open(packet []byte) (*announcement, error) {
+	nonceSize := r.aead.NonceSize()
+	if len(packet) < len(packetMagic)+nonceSize || string(packet[:len(packetMagic)]) != packetMagic {
+		return nil, errors.New("不是会合消息")
+	}
+	nonce := packet[len(packetMagic) : len(packetMagic)+nonceSize]
+	plaintext, err := r.aead.Open(nil, nonce, packet[len(packetMagic)+nonceSize:], []byte(packetMagic))
+	if err != nil {
+		return nil, errors.New("会合消息解密失败，双方的预共享密钥可能不一致")
+	}
+
+	var msg announcement
+	if err := json.Unmarshal(plaintext, &msg); err != nil {
+		return nil, fmt.Errorf("解析会合消息失败: %w", err)
+	}
+	if msg.Node != r.opts.Peer || msg.Peer != r.opts.NodeID {
+		return nil, fmt.Errorf("会合消息的节点不匹配: %s -> %s", msg.Node, msg.Peer)
+	}
+	sent := time.Unix(msg.Time, 0)
+	if skew := time.Since(sent); skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, fmt.Errorf("会合消息的时间与本机相差 %s", skew.Round(time.Second))
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for seen, at := range r.nonces {
+		if now.Sub(at) > 2*maxClockSkew {
+			delete(r.nonces, seen)
+		}
+	}
+	if _, ok := r.nonces[string(nonce)]; ok {
+		return nil, errReplay
+	}
+	r.nonces[string(nonce)] = now
+	return &msg, nil
+}
+
+// readLoop 处理会合端口收到的 STUN 响应和会合消息
+func (r *Rendezvous) readLoop() {
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, from, err := r.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-r.done:
+				return
+			default:
+			}
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			logger.Warn("读取会合端口失败: %v", err)
+			return
+		}
+		data := append([]byte(nil), buf[:n]...)
+
+		if r.handleSTUN(data) {
+			continue
+		}
+		r.handle(data, from)
+	}
+}
+
+// handleSTUN 处理 STUN 响应，不是本节点发出的请求的响应时返回 false
+func (r *Rendezvous) handleSTUN(data []byte) bool {
+	if len(data) < 20 || binary.BigEndian.Uint32(data[4:8]) != stunMagicCookie {
+		return false
+	}
+	resp := &nat.STUNMessage{}
+	if err := resp.Unmarshal(data); err != nil {
+		return false
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.stunRequests[resp.TransID] {
+		return false
+	}
+	delete(r.stunRequests, resp.TransID)
+	ip, port, err := resp.GetXorMappedAddress()
+	if err != nil {
+		return true
+	}
+	public := net.JoinHostPort(ip.String(), fmt.Sprint(port))
+	if public != r.public {
+		logger.Info("简易模式的外部地址: %s", public)
+		r.public = public
+	}
+	return true
+}
+
+// handle 处理会合消息。from 为空表示消息来自会合信箱，只记录对端通告的地址，不确认对端地址
+func (r *Rendezvous) handle(packet []byte, from *net.UDPAddr) {
+	msg, err := r.open(packet)
+	if err != nil {
+		if err != errReplay {
+			logger.Debug("忽略会合消息 %v: %v", from, err)
+		}
+		return
+	}
+
+	r.mu.Lock()
+	endpoints := msg.Endpoints
+	if len(endpoints) > maxEndpoints {
+		endpoints = endpoints[:maxEndpoints]
+	}
+	r.peerEndpoints = endpoints
+	// 收到对端新的挑战时立即回复，对端不必等到下一个会合间隔
+	reply := from != nil && msg.Challenge != r.peerChallenge
+	r.peerChallenge = msg.Challenge
+
+	var changed *net.UDPAddr
+	if from != nil && msg.Echo != "" && (msg.Echo == r.challenge || msg.Echo == r.prevChallenge) {
+		r.lastSeen = time.Now()
+		if !r.online || r.peerAddr.String() != from.String() {
+			changed = from
+		}
+		r.peerAddr, r.online = from, true
+	}
+	r.mu.Unlock()
+
+	if reply {
+		if packet, err := r.seal(); err == nil {
+			r.conn.WriteToUDP(packet, from)
+		}
+	}
+	if changed != nil {
+		logger.Info("简易模式的对端 %s 地址: %s", r.opts.Peer, changed)
+		r.notify(changed, true)
+	}
+}
+
+// checkOffline 超过一定时间未收到对端带回挑战的消息时视为对端离线
+func (r *Rendezvous) checkOffline() {
+	r.mu.Lock()
+	offline := r.online && time.Since(r.lastSeen) > offlineIntervals*r.opts.Interval
+	if offline {
+		r.online = false
+	}
+	addr := r.peerAddr
+	r.mu.Unlock()
+
+	if offline {
+		logger.Info("简易模式的对端 %s 已离线", r.opts.Peer)
+		r.notify(addr, false)
+	}
+}
+
+// notify 调用对端状态回调
+func (r *Rendezvous) notify(addr *net.UDPAddr, online bool) {
+	if r.onPeer != nil {
+		r.onPeer(addr, online)
+	}
+}
+
+// exchangeMailbox 将会合消息放入本节点的信箱，并读取对端信箱中的会合消息。
+// 双方的 UDP 消息都无法直接送达时（例如都不知道对方的地址），通过信箱交换 STUN 获取的外部地址后即可打洞
+func (r *Rendezvous) exchangeMailbox() {
+	if r.opts.RendezvousURL == "" {
+		return
+	}
+	base := strings.TrimRight(r.opts.RendezvousURL, "/")
+
+	if packet, err := r.seal(); err == nil {
+		req, err := http.NewRequest(http.MethodPut, base+"/"+r.opts.Key.mailbox(r.opts.NodeID), bytes.NewReader(packet))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/octet-stream")
+			if resp, err := r.client.Do(req); err != nil {
+				logger.Debug("写入会合信箱失败: %v", err)
+			} else {
+				resp.Body.Close()
+			}
+		}
+	}
+
+	resp, err := r.client.Get(base + "/" + r.opts.Key.mailbox(r.opts.Peer))
+	if err != nil {
+		logger.Debug("读取会合信箱失败: %v", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	packet, err := io.ReadAll(io.LimitReader(resp.Body, maxPacketSize))
+	if err != nil {
+		return
+	}
+	r.handle(packet, nil)
+}
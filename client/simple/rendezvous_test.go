@@ -0,0 +1,151 @@
+package simple
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testInterval = 50 * time.Millisecond
+
+// startRendezvous 在回环地址上启动会合，返回对端地址确认时写入的通道
+func startRendezvous(t *testing.T, opts Options) (*Rendezvous, chan *net.UDPAddr) {
+	t.Helper()
+	opts.Interval = testInterval
+	opts.LocalEndpoints = func(port int) []string {
+		return []string{net.JoinHostPort("127.0.0.1", strconv.Itoa(port))}
+	}
+	r, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmed := make(chan *net.UDPAddr, 16)
+	r.OnPeer(func(addr *net.UDPAddr, online bool) {
+		if online {
+			confirmed <- addr
+		}
+	})
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r, confirmed
+}
+
+// waitPeer 等待对端地址确认
+func waitPeer(t *testing.T, confirmed chan *net.UDPAddr, port int) {
+	t.Helper()
+	select {
+	case addr := <-confirmed:
+		if addr.Port != port {
+			t.Fatalf("确认的对端端口为 %d，期望 %d", addr.Port, port)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("等待对端地址确认超时")
+	}
+}
+
+func TestRendezvousWithPeerAddress(t *testing.T) {
+	key, _ := GenerateKey()
+
+	b, bConfirmed := startRendezvous(t, Options{NodeID: "b", Peer: "a", Key: key})
+	a, aConfirmed := startRendezvous(t, Options{
+		NodeID: "a", Peer: "b", Key: key,
+		PeerAddress: net.JoinHostPort("127.0.0.1", strconv.Itoa(b.LocalPort())),
+	})
+
+	// 只有 a 知道 b 的地址，b 从会合消息确认 a 的地址
+	waitPeer(t, aConfirmed, b.LocalPort())
+	waitPeer(t, bConfirmed, a.LocalPort())
+	if _, online := a.Peer(); !online {
+		t.Error("a 的对端应在线")
+	}
+}
+
+func TestRendezvousThroughMailbox(t *testing.T) {
+	var mu sync.Mutex
+	mailboxes := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			mailboxes[r.URL.Path] = data
+		case http.MethodGet:
+			data, ok := mailboxes[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(data)
+		}
+	}))
+	defer server.Close()
+
+	key, _ := GenerateKey()
+	a, aConfirmed := startRendezvous(t, Options{NodeID: "a", Peer: "b", Key: key, RendezvousURL: server.URL})
+	b, bConfirmed := startRendezvous(t, Options{NodeID: "b", Peer: "a", Key: key, RendezvousURL: server.URL + "/"})
+
+	// 双方都不知道对方的地址，通过信箱交换地址后直接确认
+	waitPeer(t, aConfirmed, b.LocalPort())
+	waitPeer(t, bConfirmed, a.LocalPort())
+
+	mu.Lock()
+	defer mu.Unlock()
+	for path, data := range mailboxes {
+		if strings.Contains(string(data), "endpoints") {
+			t.Errorf("信箱 %s 中的会合消息应加密", path)
+		}
+	}
+}
+
+func TestRendezvousRejectsOtherKey(t *testing.T) {
+	key, _ := GenerateKey()
+	other, _ := GenerateKey()
+
+	b, bConfirmed := startRendezvous(t, Options{NodeID: "b", Peer: "a", Key: other})
+	startRendezvous(t, Options{
+		NodeID: "a", Peer: "b", Key: key,
+		PeerAddress: net.JoinHostPort("127.0.0.1", strconv.Itoa(b.LocalPort())),
+	})
+
+	select {
+	case <-bConfirmed:
+		t.Fatal("密钥不同时不应确认对端地址")
+	case <-time.After(5 * testInterval):
+	}
+}
+
+func TestOpenRejectsReplayedAndForgedMessages(t *testing.T) {
+	key, _ := GenerateKey()
+	a, _ := New(Options{NodeID: "a", Peer: "b", Key: key})
+	b, _ := New(Options{NodeID: "b", Peer: "a", Key: key})
+
+	packet, err := a.seal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.open(packet); err != nil {
+		t.Fatalf("解析会合消息失败: %v", err)
+	}
+	if _, err := b.open(packet); err != errReplay {
+		t.Errorf("重放的会合消息应被拒绝: %v", err)
+	}
+
+	// 自己发出的消息被反射回来时不应被接受
+	if _, err := a.open(packet); err == nil {
+		t.Error("自己发出的会合消息应被拒绝")
+	}
+
+	packet[len(packet)-1] ^= 0xff
+	if _, err := b.open(packet); err == nil {
+		t.Error("被篡改的会合消息应被拒绝")
+	}
+}
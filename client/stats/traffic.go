@@ -3,6 +3,8 @@ package stats
 import (
 	"sync"
 	"time"
+
+	counter "github.com/senma231/p3/common/stats"
 )
 
 // TrafficStats 流量统计
@@ -22,14 +24,10 @@ type TrafficStats struct {
 	// 最后更新时间
 	LastUpdated time.Time
 	
-	// 窗口大小
-	windowSize time.Duration
-	// 窗口内发送字节数
-	sentInWindow int64
-	// 窗口内接收字节数
-	receivedInWindow int64
-	// 窗口开始时间
-	windowStart time.Time
+	// 发送速率，按单调时钟划分窗口
+	sent *counter.Meter
+	// 接收速率
+	received *counter.Meter
 	
 	mu sync.Mutex
 }
@@ -38,8 +36,8 @@ type TrafficStats struct {
 func NewTrafficStats() *TrafficStats {
 	now := time.Now()
 	return &TrafficStats{
-		windowSize:  time.Second,
-		windowStart: now,
+		sent:        counter.NewMeter(time.Second),
+		received:    counter.NewMeter(time.Second),
 		LastUpdated: now,
 	}
 }
@@ -49,24 +47,15 @@ func (s *TrafficStats) AddSent(bytes int64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
-	now := time.Now()
-	s.TotalSent += bytes
-	
-	// 更新窗口
-	if now.Sub(s.windowStart) >= s.windowSize {
-		// 计算每秒字节数
-		duration := now.Sub(s.windowStart).Seconds()
-		if duration > 0 {
-			s.BytesSentPerSecond = int64(float64(s.sentInWindow) / duration)
-		}
-		
-		// 重置窗口
-		s.sentInWindow = bytes
-		s.windowStart = now
-	} else {
-		// 累加窗口内字节数
-		s.sentInWindow += bytes
+	if bytes <= 0 {
+		return
 	}
+	now := time.Now()
+	s.TotalSent = counter.AddInt64(s.TotalSent, bytes)
+
+	// 更新速率
+	s.sent.Add(uint64(bytes))
+	s.BytesSentPerSecond = int64(s.sent.Rate())
 	
 	s.LastUpdated = now
 }
@@ -76,24 +65,15 @@ func (s *TrafficStats) AddReceived(bytes int64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
-	now := time.Now()
-	s.TotalReceived += bytes
-	
-	// 更新窗口
-	if now.Sub(s.windowStart) >= s.windowSize {
-		// 计算每秒字节数
-		duration := now.Sub(s.windowStart).Seconds()
-		if duration > 0 {
-			s.BytesReceivedPerSecond = int64(float64(s.receivedInWindow) / duration)
-		}
-		
-		// 重置窗口
-		s.receivedInWindow = bytes
-		s.windowStart = now
-	} else {
-		// 累加窗口内字节数
-		s.receivedInWindow += bytes
+	if bytes <= 0 {
+		return
 	}
+	now := time.Now()
+	s.TotalReceived = counter.AddInt64(s.TotalReceived, bytes)
+
+	// 更新速率
+	s.received.Add(uint64(bytes))
+	s.BytesReceivedPerSecond = int64(s.received.Rate())
 	
 	s.LastUpdated = now
 }
@@ -132,6 +112,9 @@ func (s *TrafficStats) GetStats() map[string]interface{} {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	
+	// 空闲时速率随窗口归零
+	s.BytesSentPerSecond = int64(s.sent.Rate())
+	s.BytesReceivedPerSecond = int64(s.received.Rate())
 	return map[string]interface{}{
 		"totalSent":             s.TotalSent,
 		"totalReceived":         s.TotalReceived,
@@ -155,8 +138,7 @@ func (s *TrafficStats) Reset() {
 	s.BytesReceivedPerSecond = 0
 	s.Connections = 0
 	s.ConnectionTime = 0
-	s.sentInWindow = 0
-	s.receivedInWindow = 0
-	s.windowStart = now
+	s.sent = counter.NewMeter(time.Second)
+	s.received = counter.NewMeter(time.Second)
 	s.LastUpdated = now
 }
@@ -0,0 +1,122 @@
+package trace
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// DefaultPerPeer 每个对等节点默认保留的连接记录数
+const DefaultPerPeer = 10
+
+// Store 按对等节点保存最近的连接记录。设置了文件路径时每次添加记录后写入文件，
+// 供 p3ctl explain 在客户端进程之外读取
+type Store struct {
+	filePath string
+	perPeer  int
+	traces   map[string][]*Trace
+	mu       sync.Mutex
+}
+
+// NewStore 创建连接记录存储，filePath 为空时只保存在内存中
+func NewStore(filePath string, perPeer int) *Store {
+	if perPeer <= 0 {
+		perPeer = DefaultPerPeer
+	}
+	return &Store{
+		filePath: filePath,
+		perPeer:  perPeer,
+		traces:   make(map[string][]*Trace),
+	}
+}
+
+// Load 从文件加载连接记录，文件不存在时为空
+func (s *Store) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.filePath == "" {
+		return nil
+	}
+	data, err := os.ReadFile(s.filePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取连接记录文件失败: %w", err)
+	}
+
+	traces := make(map[string][]*Trace)
+	if err := json.Unmarshal(data, &traces); err != nil {
+		return fmt.Errorf("解析连接记录文件失败: %w", err)
+	}
+	s.traces = traces
+	return nil
+}
+
+// Add 添加连接记录，超过每个节点的保留数时丢弃最早的记录
+func (s *Store) Add(t *Trace) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	traces := append(s.traces[t.PeerID], t)
+	if len(traces) > s.perPeer {
+		traces = traces[len(traces)-s.perPeer:]
+	}
+	s.traces[t.PeerID] = traces
+	return s.save()
+}
+
+// Peer 获取对等节点的连接记录，最新的在前
+func (s *Store) Peer(peerID string) []*Trace {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	traces := s.traces[peerID]
+	result := make([]*Trace, 0, len(traces))
+	for i := len(traces) - 1; i >= 0; i-- {
+		result = append(result, traces[i])
+	}
+	return result
+}
+
+// Peers 获取有连接记录的对等节点
+func (s *Store) Peers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peers := make([]string, 0, len(s.traces))
+	for peerID := range s.traces {
+		peers = append(peers, peerID)
+	}
+	sort.Strings(peers)
+	return peers
+}
+
+// save 保存连接记录，先写临时文件再重命名。调用方需持有锁
+func (s *Store) save() error {
+	if s.filePath == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.filePath), 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %w", err)
+	}
+
+	data, err := json.MarshalIndent(s.traces, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化连接记录失败: %w", err)
+	}
+
+	tmpPath := s.filePath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return fmt.Errorf("写入连接记录文件失败: %w", err)
+	}
+	if err := os.Rename(tmpPath, s.filePath); err != nil {
+		return fmt.Errorf("替换连接记录文件失败: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+// Package trace 记录与对等节点建立连接的每次尝试，包括尝试的方式和地址、错误、耗时和最终使用的路径，
+// 用于回答“为什么连接慢”“为什么回退到了中继”之类的问题。
+package trace
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// 连接方式。尝试按 protocol 中的候选方式记录，最终使用的路径按连接类型记录，
+// 如 IPv6 连接的路径为 direct，UDP 和 TCP 打洞的路径为 holepunch
+const (
+	MethodIPv6      = "ipv6"
+	MethodDirect    = "direct"
+	MethodUPnP      = "upnp"
+	MethodHolePunch = "holepunch"
+	MethodUDPPunch  = "udp-punch"
+	MethodTCPPunch  = "tcp-punch"
+	MethodRelay     = "relay"
+)
+
+// methodNames 连接方式的显示名称
+var methodNames = map[string]string{
+	MethodIPv6:      "IPv6",
+	MethodDirect:    "直接连接",
+	MethodUPnP:      "UPnP",
+	MethodHolePunch: "打洞",
+	MethodUDPPunch:  "UDP 打洞",
+	MethodTCPPunch:  "TCP 打洞",
+	MethodRelay:     "中继",
+}
+
+// IsHolePunch 是否为打洞尝试
+func IsHolePunch(method string) bool {
+	return method == MethodHolePunch || method == MethodUDPPunch || method == MethodTCPPunch
+}
+
+// 连接优先级的来源
+const (
+	PriorityConfig  = "config"  // 本地配置的 strategy.priority
+	PriorityServer  = "server"  // 服务端下发
+	PriorityDefault = "default" // 客户端内置的默认优先级
+)
+
+// priorityNames 连接优先级来源的显示名称
+var priorityNames = map[string]string{
+	PriorityConfig:  "本地配置",
+	PriorityServer:  "服务端下发",
+	PriorityDefault: "默认",
+}
+
+// Attempt 一次连接尝试
+type Attempt struct {
+	Method     string    `json:"method"`
+	Candidate  string    `json:"candidate,omitempty"` // 尝试的地址
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs int64     `json:"durationMs"`
+	Skipped    bool      `json:"skipped,omitempty"`
+	SkipReason string    `json:"skipReason,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Trace 与对等节点的一次连接过程
+type Trace struct {
+	PeerID     string    `json:"peerId"`
+	LocalNAT   string    `json:"localNat,omitempty"`
+	PeerNAT    string    `json:"peerNat,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs int64     `json:"durationMs"`
+	Path       string    `json:"path,omitempty"` // 最终使用的连接方式，连接失败时为空
+	Error      string    `json:"error,omitempty"`
+	Attempts   []Attempt `json:"attempts"`
+
+	// 按顺序尝试的连接方式及其来源，见 PriorityConfig、PriorityServer 和 PriorityDefault
+	Priority       []string `json:"priority,omitempty"`
+	PrioritySource string   `json:"prioritySource,omitempty"`
+}
+
+// New 开始记录与对等节点的连接过程
+func New(peerID, localNAT, peerNAT string) *Trace {
+	return &Trace{
+		PeerID:    peerID,
+		LocalNAT:  localNAT,
+		PeerNAT:   peerNAT,
+		StartedAt: time.Now(),
+		Attempts:  []Attempt{},
+	}
+}
+
+// SetPriority 记录本次连接使用的连接优先级及其来源
+func (t *Trace) SetPriority(priority []string, source string) {
+	t.Priority = priority
+	t.PrioritySource = source
+}
+
+// Skip 记录未尝试的连接方式及原因
+func (t *Trace) Skip(method, reason string) {
+	t.Attempts = append(t.Attempts, Attempt{
+		Method:     method,
+		StartedAt:  time.Now(),
+		Skipped:    true,
+		SkipReason: reason,
+	})
+}
+
+// Begin 开始一次连接尝试，返回的函数在尝试结束时调用，err 为 nil 表示成功
+func (t *Trace) Begin(method, candidate string) func(err error) {
+	startedAt := time.Now()
+	return func(err error) {
+		t.Record(method, candidate, startedAt, err)
+	}
+}
+
+// Record 记录已结束的连接尝试，用于并行尝试时由调用方统一记录结果
+func (t *Trace) Record(method, candidate string, startedAt time.Time, err error) {
+	attempt := Attempt{
+		Method:     method,
+		Candidate:  candidate,
+		StartedAt:  startedAt,
+		DurationMs: time.Since(startedAt).Milliseconds(),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+	t.Attempts = append(t.Attempts, attempt)
+}
+
+// Finish 结束连接过程，path 为最终使用的连接方式，失败时为空并记录 err
+func (t *Trace) Finish(path string, err error) {
+	t.Path = path
+	if err != nil {
+		t.Error = err.Error()
+	}
+	t.DurationMs = time.Since(t.StartedAt).Milliseconds()
+}
+
+// Explain 生成连接过程的文字说明，依次列出每次尝试并总结最终结果的原因
+func (t *Trace) Explain() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "对等节点 %s，%s 开始，耗时 %s\n",
+		t.PeerID, t.StartedAt.Format("2006-01-02 15:04:05"), formatDuration(t.DurationMs))
+	if t.LocalNAT != "" || t.PeerNAT != "" {
+		fmt.Fprintf(&b, "本地 NAT: %s，对端 NAT: %s\n", orUnknown(t.LocalNAT), orUnknown(t.PeerNAT))
+	}
+	if len(t.Priority) > 0 {
+		names := make([]string, len(t.Priority))
+		for i, method := range t.Priority {
+			names[i] = MethodName(method)
+		}
+		fmt.Fprintf(&b, "连接优先级: %s（%s）\n", strings.Join(names, " > "), PrioritySourceName(t.PrioritySource))
+	}
+
+	for _, a := range t.Attempts {
+		switch {
+		case a.Skipped:
+			fmt.Fprintf(&b, "  - %-8s 跳过: %s\n", MethodName(a.Method), a.SkipReason)
+		case a.Error != "":
+			fmt.Fprintf(&b, "  ✗ %-8s %s %s: %s\n", MethodName(a.Method), a.Candidate, formatDuration(a.DurationMs), a.Error)
+		default:
+			fmt.Fprintf(&b, "  ✓ %-8s %s %s\n", MethodName(a.Method), a.Candidate, formatDuration(a.DurationMs))
+		}
+	}
+
+	b.WriteString(t.Summary())
+	b.WriteString("\n")
+	return b.String()
+}
+
+// Summary 总结最终结果的原因，例如回退到中继前其他方式为何没有成功
+func (t *Trace) Summary() string {
+	if t.Path == "" {
+		if t.Error != "" {
+			return "结果: 连接失败，" + t.Error
+		}
+		return "结果: 连接失败"
+	}
+
+	// 按成功的尝试说明，如 IPv6 连接的路径为 direct
+	used := t.Path
+	for _, a := range t.Attempts {
+		if !a.Skipped && a.Error == "" {
+			used = a.Method
+		}
+	}
+
+	var reasons []string
+	for _, a := range t.Attempts {
+		if a.Method == used {
+			continue
+		}
+		switch {
+		case a.Skipped:
+			reasons = append(reasons, MethodName(a.Method)+"跳过（"+a.SkipReason+"）")
+		case a.Error != "":
+			reasons = append(reasons, MethodName(a.Method)+"失败（"+a.Error+"）")
+		}
+	}
+	result := "结果: 使用" + MethodName(used)
+	if len(reasons) > 0 {
+		result += "，" + strings.Join(reasons, "，")
+	}
+	return result
+}
+
+// MethodName 获取连接方式的显示名称
+func MethodName(method string) string {
+	if name, ok := methodNames[method]; ok {
+		return name
+	}
+	return method
+}
+
+// PrioritySourceName 获取连接优先级来源的显示名称
+func PrioritySourceName(source string) string {
+	if name, ok := priorityNames[source]; ok {
+		return name
+	}
+	return orUnknown(source)
+}
+
+// orUnknown 空字符串显示为未知
+func orUnknown(s string) string {
+	if s == "" {
+		return "未知"
+	}
+	return s
+}
+
+// formatDuration 格式化毫秒数
+func formatDuration(ms int64) string {
+	return (time.Duration(ms) * time.Millisecond).String()
+}
@@ -0,0 +1,74 @@
+package trace
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTraceSummary(t *testing.T) {
+	tr := New("node-b", "Symmetric NAT", "Port Restricted Cone NAT")
+	tr.SetPriority([]string{MethodIPv6, MethodDirect, MethodUDPPunch}, PriorityServer)
+	tr.Skip(MethodIPv6, "对端没有 IPv6 地址")
+	tr.Skip(MethodDirect, "双方都在 NAT 之后")
+	tr.Begin(MethodUDPPunch, "203.0.113.10:40000")(errors.New("打洞超时"))
+	tr.Begin(MethodRelay, "")(nil)
+	tr.Finish(MethodRelay, nil)
+
+	summary := tr.Summary()
+	if !strings.Contains(summary, "使用中继") ||
+		!strings.Contains(summary, "直接连接跳过（双方都在 NAT 之后）") ||
+		!strings.Contains(summary, "UDP 打洞失败（打洞超时）") {
+		t.Fatalf("总结错误: %s", summary)
+	}
+
+	explain := tr.Explain()
+	if !strings.Contains(explain, "连接优先级: IPv6 > 直接连接 > UDP 打洞（服务端下发）") ||
+		!strings.Contains(explain, "✗ UDP 打洞") || !strings.Contains(explain, "203.0.113.10:40000") || !strings.Contains(explain, "✓ 中继") {
+		t.Fatalf("说明错误:\n%s", explain)
+	}
+
+	// IPv6 连接的路径为 direct，按成功的尝试说明
+	ipv6 := New("node-d", "", "")
+	ipv6.Begin(MethodIPv6, "[2001:db8::1]:27184")(nil)
+	ipv6.Skip(MethodDirect, "IPv6已先成功")
+	ipv6.Finish(MethodDirect, nil)
+	if summary := ipv6.Summary(); !strings.HasPrefix(summary, "结果: 使用IPv6，直接连接跳过") {
+		t.Fatalf("IPv6 连接的总结错误: %s", summary)
+	}
+
+	failed := New("node-c", "", "")
+	failed.Finish("", errors.New("所有尝试都失败"))
+	if failed.Summary() != "结果: 连接失败，所有尝试都失败" {
+		t.Fatalf("失败的总结错误: %s", failed.Summary())
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	store := NewStore(path, 2)
+
+	for _, peerID := range []string{"node-b", "node-b", "node-b", "node-c"} {
+		tr := New(peerID, "", "")
+		tr.Finish(MethodDirect, nil)
+		if err := store.Add(tr); err != nil {
+			t.Fatalf("添加记录失败: %v", err)
+		}
+	}
+
+	// 从文件重新加载，超过保留数的旧记录已丢弃
+	loaded := NewStore(path, 2)
+	if err := loaded.Load(); err != nil {
+		t.Fatalf("加载记录失败: %v", err)
+	}
+	if peers := loaded.Peers(); len(peers) != 2 || peers[0] != "node-b" || peers[1] != "node-c" {
+		t.Fatalf("节点列表错误: %v", peers)
+	}
+	if traces := loaded.Peer("node-b"); len(traces) != 2 {
+		t.Fatalf("应保留 2 条记录，实际为 %d", len(traces))
+	}
+	if traces := loaded.Peer("node-d"); len(traces) != 0 {
+		t.Fatalf("没有记录的节点应返回空列表")
+	}
+}
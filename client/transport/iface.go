@@ -0,0 +1,247 @@
+package transport
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"sort"
+	"time"
+)
+
+// InterfacePolicy 按网卡名称选择建立对等连接使用的网卡，名称支持通配符，例如 wlan*、en*、wwan*。
+// Prefer 按顺序优先使用，Exclude 中的网卡不用于对等连接，也不作为局域网候选地址通告
+type InterfacePolicy struct {
+	Prefer  []string
+	Exclude []string
+}
+
+// Address 网卡上的地址
+type Address struct {
+	Interface string
+	IP        net.IP
+}
+
+// Empty 检查是否未配置网卡选择
+func (p InterfacePolicy) Empty() bool {
+	return len(p.Prefer) == 0 && len(p.Exclude) == 0
+}
+
+// Validate 检查网卡名称的通配符是否有效
+func (p InterfacePolicy) Validate() error {
+	for _, pattern := range append(append([]string(nil), p.Prefer...), p.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("网卡名称 %s 无效: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Excluded 检查网卡是否被排除
+func (p InterfacePolicy) Excluded(name string) bool {
+	return matchAny(p.Exclude, name) >= 0
+}
+
+// rank 网卡的优先级，数值越小越优先，不在 Prefer 中的网卡排在最后
+func (p InterfacePolicy) rank(name string) int {
+	if i := matchAny(p.Prefer, name); i >= 0 {
+		return i
+	}
+	return len(p.Prefer)
+}
+
+// Select 去掉被排除网卡上的地址，其余按优先级排序，同一优先级保持原来的顺序
+func (p InterfacePolicy) Select(addrs []Address) []Address {
+	selected := make([]Address, 0, len(addrs))
+	for _, addr := range addrs {
+		if !p.Excluded(addr.Interface) {
+			selected = append(selected, addr)
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		return p.rank(selected[i].Interface) < p.rank(selected[j].Interface)
+	})
+	return selected
+}
+
+// Addresses 获取已启用的非回环网卡上的地址，按网卡选择过滤和排序
+func (p InterfacePolicy) Addresses() []Address {
+	addrs, err := interfaceAddresses()
+	if err != nil {
+		return nil
+	}
+	return p.Select(addrs)
+}
+
+// LocalIP 选择建立对等连接使用的本地地址，返回 nil 时由系统路由决定。
+// 优先网卡已启用时使用其上的 IPv4 地址；否则系统默认路由所在的网卡被排除时，改用其他网卡上的地址
+func (p InterfacePolicy) LocalIP() net.IP {
+	if p.Empty() {
+		return nil
+	}
+	addrs := p.Addresses()
+	routed := defaultRouteInterface()
+	for _, addr := range addrs {
+		if addr.IP.To4() == nil || !addr.IP.IsGlobalUnicast() {
+			continue
+		}
+		if p.rank(addr.Interface) == len(p.Prefer) && routed != "" && !p.Excluded(routed) {
+			return nil
+		}
+		return addr.IP
+	}
+	return nil
+}
+
+// matchAny 返回第一个匹配网卡名称的通配符的序号，没有匹配时返回 -1
+func matchAny(patterns []string, name string) int {
+	for i, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return i
+		}
+	}
+	return -1
+}
+
+// interfaceAddresses 获取已启用的非回环网卡上的地址
+func interfaceAddresses() ([]Address, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	var addrs []Address
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		ifaceAddrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range ifaceAddrs {
+			if ipNet, ok := addr.(*net.IPNet); ok {
+				addrs = append(addrs, Address{Interface: iface.Name, IP: ipNet.IP})
+			}
+		}
+	}
+	return addrs, nil
+}
+
+// defaultRouteInterface 获取系统默认路由所在的网卡，无法确定时返回空。UDP 连接只选择路由，不发送数据
+func defaultRouteInterface() string {
+	conn, err := net.Dial("udp4", "8.8.8.8:80")
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+	local := conn.LocalAddr().(*net.UDPAddr).IP
+
+	addrs, err := interfaceAddresses()
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		if addr.IP.Equal(local) {
+			return addr.Interface
+		}
+	}
+	return ""
+}
+
+// ResolveBind 解析监听地址：IP 地址直接使用，否则作为网卡名称，使用网卡上的第一个 IPv4 地址，
+// 没有 IPv4 地址时使用第一个 IPv6 地址
+func ResolveBind(bind string) (net.IP, error) {
+	if ip := net.ParseIP(bind); ip != nil {
+		return ip, nil
+	}
+
+	iface, err := net.InterfaceByName(bind)
+	if err != nil {
+		return nil, fmt.Errorf("网卡 %s 不存在: %w", bind, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("网卡 %s 未启用", bind)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("获取网卡 %s 的地址失败: %w", bind, err)
+	}
+
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			return ipNet.IP, nil
+		}
+		if ipv6 == nil && !ipNet.IP.IsLinkLocalUnicast() {
+			ipv6 = ipNet.IP
+		}
+	}
+	if ipv6 == nil {
+		return nil, fmt.Errorf("网卡 %s 没有可用的地址", bind)
+	}
+	return ipv6, nil
+}
+
+// NewSystem 创建按网卡选择建立连接的系统网络，未配置网卡选择时返回 System。
+// 每次连接时重新选择本地地址，网卡切换（例如从 Wi-Fi 切换到蜂窝网络）后自动使用新的地址
+func NewSystem(policy InterfacePolicy) Transport {
+	if policy.Empty() {
+		return System
+	}
+	return &boundSystem{policy: policy}
+}
+
+// boundSystem 出站连接和数据报绑定到选择的本地地址
+type boundSystem struct {
+	policy InterfacePolicy
+}
+
+func (s *boundSystem) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	dialer := net.Dialer{Timeout: timeout}
+	if ip := s.localIP(address); ip != nil {
+		switch network {
+		case "udp", "udp4", "udp6":
+			dialer.LocalAddr = &net.UDPAddr{IP: ip}
+		default:
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+	}
+	return dialer.Dial(network, address)
+}
+
+// Listen 流式连接由对端主动连接，仍监听所有网卡，被排除的网卡不作为候选地址通告
+func (s *boundSystem) Listen(network, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
+
+// ListenPacket 绑定选择的本地地址，打洞数据从该网卡发出。address 中的主机通常是默认路由的地址，
+// 选择了其他网卡时替换为该网卡的地址，回环地址保持不变
+func (s *boundSystem) ListenPacket(network, address string) (net.PacketConn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err == nil && !net.ParseIP(host).IsLoopback() {
+		if ip := s.policy.LocalIP(); ip != nil {
+			address = net.JoinHostPort(ip.String(), port)
+		}
+	}
+	return net.ListenPacket(network, address)
+}
+
+// localIP 连接 address 使用的本地地址，地址族与目标 IP 不同时不绑定
+func (s *boundSystem) localIP(address string) net.IP {
+	ip := s.policy.LocalIP()
+	if ip == nil {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	if target := net.ParseIP(host); target != nil && (target.To4() == nil) != (ip.To4() == nil) {
+		return nil
+	}
+	return ip
+}
@@ -0,0 +1,52 @@
+package transport
+
+import (
+	"net"
+	"testing"
+)
+
+func TestInterfacePolicySelect(t *testing.T) {
+	addrs := []Address{
+		{Interface: "docker0", IP: net.ParseIP("172.17.0.1")},
+		{Interface: "wlan0", IP: net.ParseIP("192.168.1.5")},
+		{Interface: "eth0", IP: net.ParseIP("10.0.0.5")},
+		{Interface: "wwan0", IP: net.ParseIP("100.64.0.5")},
+	}
+	policy := InterfacePolicy{Prefer: []string{"eth*", "wlan*"}, Exclude: []string{"docker*"}}
+
+	var names []string
+	for _, addr := range policy.Select(addrs) {
+		names = append(names, addr.Interface)
+	}
+	want := []string{"eth0", "wlan0", "wwan0"}
+	if len(names) != len(want) {
+		t.Fatalf("选择的网卡为 %v", names)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("选择的网卡为 %v，应为 %v", names, want)
+		}
+	}
+
+	if (InterfacePolicy{}).Select(addrs)[0].Interface != "docker0" {
+		t.Fatalf("未配置网卡选择时应保持原来的顺序")
+	}
+}
+
+func TestInterfacePolicyValidate(t *testing.T) {
+	if err := (InterfacePolicy{Prefer: []string{"eth[0"}}).Validate(); err == nil {
+		t.Fatalf("无效的通配符应验证失败")
+	}
+	if err := (InterfacePolicy{Prefer: []string{"en*"}, Exclude: []string{"utun?"}}).Validate(); err != nil {
+		t.Fatalf("验证失败: %v", err)
+	}
+}
+
+func TestResolveBind(t *testing.T) {
+	if ip, err := ResolveBind("::1"); err != nil || !ip.IsLoopback() {
+		t.Fatalf("解析 IP 地址失败: %v %v", ip, err)
+	}
+	if _, err := ResolveBind("p3-missing0"); err == nil {
+		t.Fatalf("网卡不存在时应解析失败")
+	}
+}
@@ -0,0 +1,36 @@
+// Package transport 定义客户端建立点对点连接时使用的网络操作。
+// 默认直接使用系统网络，开发和测试时可替换为 netem 包中的网络模拟器
+package transport
+
+import (
+	"net"
+	"time"
+)
+
+// Transport 建立连接和监听端口的网络操作
+type Transport interface {
+	// DialTimeout 连接到 address，network 为 tcp 或 udp
+	DialTimeout(network, address string, timeout time.Duration) (net.Conn, error)
+	// Listen 监听流式连接
+	Listen(network, address string) (net.Listener, error)
+	// ListenPacket 监听数据报
+	ListenPacket(network, address string) (net.PacketConn, error)
+}
+
+// System 使用系统网络
+var System Transport = system{}
+
+// system 直接调用 net 包
+type system struct{}
+
+func (system) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(network, address, timeout)
+}
+
+func (system) Listen(network, address string) (net.Listener, error) {
+	return net.Listen(network, address)
+}
+
+func (system) ListenPacket(network, address string) (net.PacketConn, error) {
+	return net.ListenPacket(network, address)
+}
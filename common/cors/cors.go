@@ -0,0 +1,145 @@
+// Package cors 提供服务端 API 和 Web 后端共用的跨域处理，
+// 只允许配置中列出的来源，并按需允许携带 Cookie 等凭据
+package cors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// 未配置时使用的默认值
+var (
+	DefaultMethods = []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions}
+	DefaultHeaders = []string{"Content-Type", "Content-Length", "Accept-Encoding", "X-CSRF-Token", "Authorization", "Accept", "Origin", "Cache-Control", "X-Requested-With"}
+)
+
+// Config 跨域配置
+type Config struct {
+	AllowedOrigins   []string `yaml:"allowedOrigins"`   // 允许跨域访问的来源，例如 https://console.example.com，为空时不允许跨域
+	AllowedMethods   []string `yaml:"allowedMethods"`   // 允许的请求方法，为空时使用 DefaultMethods
+	AllowedHeaders   []string `yaml:"allowedHeaders"`   // 允许的请求头，为空时使用 DefaultHeaders
+	MaxAge           int      `yaml:"maxAge"`           // 预检结果缓存时间，单位：秒，0 表示不缓存
+	AllowCredentials bool     `yaml:"allowCredentials"` // 允许携带 Cookie 等凭据
+}
+
+// Validate 验证跨域配置，允许凭据时不能使用通配符来源
+func (c Config) Validate() error {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			if c.AllowCredentials {
+				return errors.New("允许携带凭据时跨域来源不能使用通配符 *，请列出允许的来源")
+			}
+			continue
+		}
+		if !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			return fmt.Errorf("跨域来源无效: %s", origin)
+		}
+	}
+	if c.MaxAge < 0 {
+		return errors.New("预检缓存时间无效")
+	}
+	return nil
+}
+
+// CORS 跨域处理
+type CORS struct {
+	origins     map[string]bool
+	anyOrigin   bool
+	methods     string
+	headers     string
+	maxAge      string
+	credentials bool
+}
+
+// New 创建跨域处理
+func New(cfg Config) (*CORS, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := &CORS{
+		origins:     make(map[string]bool, len(cfg.AllowedOrigins)),
+		methods:     strings.Join(orDefault(cfg.AllowedMethods, DefaultMethods), ", "),
+		headers:     strings.Join(orDefault(cfg.AllowedHeaders, DefaultHeaders), ", "),
+		credentials: cfg.AllowCredentials,
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			c.anyOrigin = true
+			continue
+		}
+		c.origins[strings.TrimRight(origin, "/")] = true
+	}
+	if cfg.MaxAge > 0 {
+		c.maxAge = strconv.Itoa(cfg.MaxAge)
+	}
+	return c, nil
+}
+
+// orDefault 值为空时返回默认值
+func orDefault(values, defaults []string) []string {
+	if len(values) == 0 {
+		return defaults
+	}
+	return values
+}
+
+// Handle 设置跨域响应头，返回 true 表示请求为预检请求且已写入响应，调用方不应继续处理
+func (c *CORS) Handle(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false
+	}
+
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+	header := w.Header()
+
+	// 响应随 Origin 变化，避免缓存返回其他来源的跨域头
+	header.Add("Vary", "Origin")
+	if preflight {
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+	}
+
+	if !c.anyOrigin && !c.origins[origin] {
+		if preflight {
+			w.WriteHeader(http.StatusForbidden)
+			return true
+		}
+		return false
+	}
+
+	if c.anyOrigin && !c.credentials {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+	if c.credentials {
+		header.Set("Access-Control-Allow-Credentials", "true")
+	}
+
+	if !preflight {
+		return false
+	}
+
+	header.Set("Access-Control-Allow-Methods", c.methods)
+	header.Set("Access-Control-Allow-Headers", c.headers)
+	if c.maxAge != "" {
+		header.Set("Access-Control-Max-Age", c.maxAge)
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// Wrap 包装 HTTP 处理器，预检请求直接返回
+func (c *CORS) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c.Handle(w, r) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,95 @@
+package cors
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newRequest(method, origin string, preflight bool) *http.Request {
+	req := httptest.NewRequest(method, "http://api.example.com/api/v1/devices", nil)
+	if origin != "" {
+		req.Header.Set("Origin", origin)
+	}
+	if preflight {
+		req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+	}
+	return req
+}
+
+func TestHandle(t *testing.T) {
+	c, err := New(Config{
+		AllowedOrigins:   []string{"https://console.example.com/"},
+		MaxAge:           600,
+		AllowCredentials: true,
+	})
+	if err != nil {
+		t.Fatalf("创建跨域处理失败: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		req         *http.Request
+		wantHandled bool
+		wantStatus  int
+		wantOrigin  string
+	}{
+		{name: "同源请求", req: newRequest(http.MethodGet, "", false), wantStatus: http.StatusOK},
+		{name: "允许的来源", req: newRequest(http.MethodGet, "https://console.example.com", false), wantStatus: http.StatusOK, wantOrigin: "https://console.example.com"},
+		{name: "不允许的来源", req: newRequest(http.MethodGet, "https://evil.example.com", false), wantStatus: http.StatusOK},
+		{name: "允许的预检请求", req: newRequest(http.MethodOptions, "https://console.example.com", true), wantHandled: true, wantStatus: http.StatusNoContent, wantOrigin: "https://console.example.com"},
+		{name: "不允许的预检请求", req: newRequest(http.MethodOptions, "https://evil.example.com", true), wantHandled: true, wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			if handled := c.Handle(w, tt.req); handled != tt.wantHandled {
+				t.Fatalf("期望 handled=%v，实际 %v", tt.wantHandled, handled)
+			}
+			if w.Code != tt.wantStatus {
+				t.Errorf("期望状态码 %d，实际 %d", tt.wantStatus, w.Code)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("期望 Access-Control-Allow-Origin 为 %q，实际 %q", tt.wantOrigin, got)
+			}
+			if tt.wantOrigin != "" && w.Header().Get("Access-Control-Allow-Credentials") != "true" {
+				t.Error("允许凭据时应设置 Access-Control-Allow-Credentials")
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	c.Handle(w, newRequest(http.MethodOptions, "https://console.example.com", true))
+	if w.Header().Get("Access-Control-Max-Age") != "600" {
+		t.Errorf("预检缓存时间错误: %q", w.Header().Get("Access-Control-Max-Age"))
+	}
+	if w.Header().Get("Access-Control-Allow-Methods") == "" || w.Header().Get("Access-Control-Allow-Headers") == "" {
+		t.Error("预检响应应包含允许的方法和请求头")
+	}
+}
+
+func TestWildcardOrigin(t *testing.T) {
+	if _, err := New(Config{AllowedOrigins: []string{"*"}, AllowCredentials: true}); err == nil {
+		t.Fatal("允许凭据时不应接受通配符来源")
+	}
+
+	c, err := New(Config{AllowedOrigins: []string{"*"}})
+	if err != nil {
+		t.Fatalf("创建跨域处理失败: %v", err)
+	}
+	w := httptest.NewRecorder()
+	c.Handle(w, newRequest(http.MethodGet, "https://any.example.com", false))
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("期望 Access-Control-Allow-Origin 为 *，实际 %q", got)
+	}
+	if w.Header().Get("Access-Control-Allow-Credentials") != "" {
+		t.Error("未允许凭据时不应设置 Access-Control-Allow-Credentials")
+	}
+}
+
+func TestInvalidOrigin(t *testing.T) {
+	if _, err := New(Config{AllowedOrigins: []string{"console.example.com"}}); err == nil {
+		t.Error("缺少协议的来源应该返回错误")
+	}
+}
@@ -3,6 +3,8 @@ package errors
 import (
 	"fmt"
 	"net/http"
+
+	"github.com/senma231/p3/common/i18n"
 )
 
 // ErrorCode 错误码
@@ -99,6 +101,8 @@ const (
 	ErrAuthenticationFailed
 	// ErrAuthorizationFailed 授权失败
 	ErrAuthorizationFailed
+	// ErrVersionConflict 版本冲突，资源已被其他请求修改
+	ErrVersionConflict
 )
 
 // Error 错误
@@ -121,6 +125,23 @@ func (e *Error) Unwrap() error {
 	return e.Cause
 }
 
+// Localize 返回指定语言的错误信息。默认语言返回原始的错误信息，
+// 其他语言返回错误码对应的翻译，没有翻译时返回原始的错误信息。
+// 未知错误的信息即为被包装错误的信息，不再重复附加原因
+func (e *Error) Localize(lang string) string {
+	if e.Code == ErrUnknown {
+		return e.Message
+	}
+	if lang == i18n.DefaultLang {
+		return e.Error()
+	}
+	key := i18n.ErrorKey(int(e.Code))
+	if !i18n.Has(lang, key) {
+		return e.Error()
+	}
+	return i18n.T(lang, key)
+}
+
 // StatusCode 返回 HTTP 状态码
 func (e *Error) StatusCode() int {
 	switch e.Code {
@@ -132,7 +153,7 @@ func (e *Error) StatusCode() int {
 		return http.StatusForbidden
 	case ErrNotFound, ErrUserNotFound, ErrDeviceNotFound, ErrAppNotFound, ErrForwardNotFound, ErrPeerNotFound:
 		return http.StatusNotFound
-	case ErrConflict, ErrUserAlreadyExists, ErrDeviceAlreadyExists, ErrAppAlreadyExists, ErrForwardAlreadyExists, ErrPortInUse:
+	case ErrConflict, ErrUserAlreadyExists, ErrDeviceAlreadyExists, ErrAppAlreadyExists, ErrForwardAlreadyExists, ErrPortInUse, ErrVersionConflict:
 		return http.StatusConflict
 	case ErrTooManyRequests:
 		return http.StatusTooManyRequests
@@ -213,6 +234,11 @@ func Conflict(message string) *Error {
 	return New(ErrConflict, message)
 }
 
+// VersionConflict 创建版本冲突错误
+func VersionConflict(message string) *Error {
+	return New(ErrVersionConflict, message)
+}
+
 // Internal 创建内部错误
 func Internal(message string) *Error {
 	return New(ErrInternal, message)
@@ -80,6 +80,7 @@ func TestErrorStatusCode(t *testing.T) {
 		{ErrAppAlreadyExists, http.StatusConflict},
 		{ErrForwardAlreadyExists, http.StatusConflict},
 		{ErrPortInUse, http.StatusConflict},
+		{ErrVersionConflict, http.StatusConflict},
 		{ErrTooManyRequests, http.StatusTooManyRequests},
 		{ErrNotImplemented, http.StatusNotImplemented},
 		{ErrServiceUnavailable, http.StatusServiceUnavailable},
@@ -198,3 +199,17 @@ func TestErrorHelperFunctions(t *testing.T) {
 		t.Errorf("Network 函数错误原因错误，期望 %v，实际 %v", cause, netErr.Cause)
 	}
 }
+
+func TestErrorLocalize(t *testing.T) {
+	err := NotFound("设备 3 不存在")
+	if got := err.Localize("zh"); got != "设备 3 不存在" {
+		t.Errorf("默认语言应返回原始信息: %s", got)
+	}
+	if got := err.Localize("en"); got != "Not found" {
+		t.Errorf("英文信息不正确: %s", got)
+	}
+	// 未知错误的信息无法按错误码翻译
+	if got := AsError(errors.New("原始错误")).Localize("en"); got != "原始错误" {
+		t.Errorf("未知错误应返回原始信息: %s", got)
+	}
+}
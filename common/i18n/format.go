@@ -0,0 +1,113 @@
+package i18n
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// byteUnits 流量单位，按 1024 进位
+var byteUnits = []string{"B", "KB", "MB", "GB", "TB", "PB"}
+
+// FormatBytes 将字节数格式化为便于阅读的形式，例如 3.2 GB
+func FormatBytes(n uint64) string {
+	if n < 1024 {
+		return strconv.FormatUint(n, 10) + " B"
+	}
+	value := float64(n)
+	unit := 0
+	for value >= 1024 && unit < len(byteUnits)-1 {
+		value /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(value, 'f', 1, 64) + " " + byteUnits[unit]
+}
+
+// FormatCount 按语言格式化计数：中文以万、亿为单位，例如 123.5万；其他语言使用千位分隔符，例如 1,234,567
+func FormatCount(lang string, n uint64) string {
+	if lang == LangZH {
+		switch {
+		case n >= 100000000:
+			return strconv.FormatFloat(float64(n)/100000000, 'f', 1, 64) + "亿"
+		case n >= 10000:
+			return strconv.FormatFloat(float64(n)/10000, 'f', 1, 64) + "万"
+		}
+		return strconv.FormatUint(n, 10)
+	}
+
+	digits := strconv.FormatUint(n, 10)
+	var b strings.Builder
+	for i, c := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteByte(',')
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// FormatDuration 按语言格式化时长，只保留最大的两个单位，例如 2 小时 5 分钟、2h 5m，不足 1 秒时为 0 秒
+func FormatDuration(lang string, d time.Duration) string {
+	units := []struct {
+		key  string
+		size time.Duration
+	}{
+		{"duration.day", 24 * time.Hour},
+		{"duration.hour", time.Hour},
+		{"duration.minute", time.Minute},
+		{"duration.second", time.Second},
+	}
+
+	var parts []string
+	for _, unit := range units {
+		count := d / unit.size
+		d -= count * unit.size
+		if count == 0 {
+			// 较大的单位之后的零值单位不再显示，例如 2 天 0 小时显示为 2 天
+			if len(parts) > 0 {
+				break
+			}
+			continue
+		}
+		parts = append(parts, T(lang, unit.key, int64(count)))
+		if len(parts) == 2 {
+			break
+		}
+	}
+	if len(parts) == 0 {
+		return T(lang, "duration.second", 0)
+	}
+	return strings.Join(parts, " ")
+}
+
+// ISODuration 将时长格式化为 ISO 8601 时长，例如 P1DT2H3M4S，精确到秒，与语言无关
+func ISODuration(d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+	seconds := int64(d / time.Second)
+	days := seconds / 86400
+	hours := seconds % 86400 / 3600
+	minutes := seconds % 3600 / 60
+	seconds %= 60
+
+	var b strings.Builder
+	b.WriteString("P")
+	if days > 0 {
+		fmt.Fprintf(&b, "%dD", days)
+	}
+	if hours > 0 || minutes > 0 || seconds > 0 || days == 0 {
+		b.WriteString("T")
+		if hours > 0 {
+			fmt.Fprintf(&b, "%dH", hours)
+		}
+		if minutes > 0 {
+			fmt.Fprintf(&b, "%dM", minutes)
+		}
+		if seconds > 0 || (days == 0 && hours == 0 && minutes == 0) {
+			fmt.Fprintf(&b, "%dS", seconds)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,79 @@
+package i18n
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFormatBytes(t *testing.T) {
+	tests := []struct {
+		n    uint64
+		want string
+	}{
+		{0, "0 B"},
+		{1023, "1023 B"},
+		{1536, "1.5 KB"},
+		{3435973837, "3.2 GB"},
+	}
+	for _, tt := range tests {
+		if got := FormatBytes(tt.n); got != tt.want {
+			t.Errorf("FormatBytes(%d) = %s，期望 %s", tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCount(t *testing.T) {
+	tests := []struct {
+		lang string
+		n    uint64
+		want string
+	}{
+		{LangEN, 999, "999"},
+		{LangEN, 1234567, "1,234,567"},
+		{LangZH, 9999, "9999"},
+		{LangZH, 1235000, "123.5万"},
+		{LangZH, 320000000, "3.2亿"},
+	}
+	for _, tt := range tests {
+		if got := FormatCount(tt.lang, tt.n); got != tt.want {
+			t.Errorf("FormatCount(%s, %d) = %s，期望 %s", tt.lang, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	tests := []struct {
+		lang string
+		d    time.Duration
+		want string
+	}{
+		{LangZH, 0, "0 秒"},
+		{LangZH, 2*time.Hour + 5*time.Minute + 30*time.Second, "2 小时 5 分钟"},
+		{LangEN, 2*time.Hour + 5*time.Minute + 30*time.Second, "2h 5m"},
+		{LangEN, 48*time.Hour + 30*time.Minute, "2d"},
+		{LangEN, 45 * time.Second, "45s"},
+	}
+	for _, tt := range tests {
+		if got := FormatDuration(tt.lang, tt.d); got != tt.want {
+			t.Errorf("FormatDuration(%s, %s) = %s，期望 %s", tt.lang, tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestISODuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "PT0S"},
+		{90 * time.Second, "PT1M30S"},
+		{2 * time.Hour, "PT2H"},
+		{24 * time.Hour, "P1D"},
+		{26*time.Hour + 3*time.Minute + 4*time.Second, "P1DT2H3M4S"},
+	}
+	for _, tt := range tests {
+		if got := ISODuration(tt.d); got != tt.want {
+			t.Errorf("ISODuration(%s) = %s，期望 %s", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+// Package i18n 提供 API 错误消息和日志的多语言支持。
+//
+// 消息按键查找，例如错误码 1004 对应的键为 error.1004；日志以原始的中文格式字符串为键，
+// 未翻译的日志保持原样。翻译保存在 locales 目录下的 JSON 文件中，
+// 缺少翻译时依次回退到默认语言和键本身，机器可读的错误码不受语言影响
+package i18n
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 支持的语言
+const (
+	LangZH = "zh"
+	LangEN = "en"
+)
+
+// DefaultLang 默认语言，代码中的原始消息均为中文
+const DefaultLang = LangZH
+
+//go:embed locales/*.json
+var localeFS embed.FS
+
+// catalog 一种语言的翻译
+type catalog struct {
+	Messages map[string]string `json:"messages"`
+	Logs     map[string]string `json:"logs"`
+}
+
+// catalogs 按语言保存的翻译
+var catalogs = mustLoadCatalogs()
+
+// mustLoadCatalogs 加载内置的翻译文件，文件名为语言代码
+func mustLoadCatalogs() map[string]*catalog {
+	entries, err := localeFS.ReadDir("locales")
+	if err != nil {
+		panic(fmt.Sprintf("i18n: 读取翻译目录失败: %v", err))
+	}
+
+	result := make(map[string]*catalog, len(entries))
+	for _, entry := range entries {
+		data, err := localeFS.ReadFile(path.Join("locales", entry.Name()))
+		if err != nil {
+			panic(fmt.Sprintf("i18n: 读取翻译文件 %s 失败: %v", entry.Name(), err))
+		}
+		var c catalog
+		if err := json.Unmarshal(data, &c); err != nil {
+			panic(fmt.Sprintf("i18n: 解析翻译文件 %s 失败: %v", entry.Name(), err))
+		}
+		result[strings.TrimSuffix(entry.Name(), ".json")] = &c
+	}
+	return result
+}
+
+// Supported 获取支持的语言
+func Supported() []string {
+	langs := make([]string, 0, len(catalogs))
+	for lang := range catalogs {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// Normalize 将语言标签转换为支持的语言，例如 en-US 转换为 en，不支持时返回空字符串
+func Normalize(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if _, ok := catalogs[tag]; ok {
+		return tag
+	}
+	return ""
+}
+
+// Match 按 Accept-Language 请求头选择语言，按 q 值从高到低选择第一个支持的语言，没有时返回默认语言
+func Match(acceptLanguage string) string {
+	type candidate struct {
+		lang string
+		q    float64
+	}
+
+	var candidates []candidate
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		fields := strings.Split(part, ";")
+		lang := Normalize(fields[0])
+		if lang == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			candidates = append(candidates, candidate{lang: lang, q: q})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return DefaultLang
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].q > candidates[j].q })
+	return candidates[0].lang
+}
+
+// T 获取键对应的消息，args 不为空时按格式字符串格式化
+func T(lang, key string, args ...interface{}) string {
+	msg, ok := lookup(lang, key)
+	if !ok {
+		msg, ok = lookup(DefaultLang, key)
+	}
+	if !ok {
+		msg = key
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(msg, args...)
+	}
+	return msg
+}
+
+// Has 检查语言是否有键对应的消息，不回退到默认语言
+func Has(lang, key string) bool {
+	_, ok := lookup(lang, key)
+	return ok
+}
+
+// lookup 查找消息
+func lookup(lang, key string) (string, bool) {
+	c, ok := catalogs[lang]
+	if !ok {
+		return "", false
+	}
+	msg, ok := c.Messages[key]
+	return msg, ok
+}
+
+// ErrorKey 获取错误码对应的消息键
+func ErrorKey(code int) string {
+	return "error." + strconv.Itoa(code)
+}
+
+// LogTranslator 返回将日志格式字符串翻译为指定语言的函数，用于 logger.SetTranslator。
+// 语言为默认语言或不支持时返回 nil，日志保持原样
+func LogTranslator(lang string) func(format string) string {
+	c, ok := catalogs[lang]
+	if !ok || lang == DefaultLang {
+		return nil
+	}
+	return func(format string) string {
+		if translated, ok := c.Logs[format]; ok {
+			return translated
+		}
+		return format
+	}
+}
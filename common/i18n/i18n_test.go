@@ -0,0 +1,86 @@
+package i18n
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", LangZH},
+		{"en-US,en;q=0.9", LangEN},
+		{"zh-CN,zh;q=0.9,en;q=0.8", LangZH},
+		{"fr-FR,en;q=0.5,zh;q=0.3", LangEN},
+		{"zh;q=0.2,en;q=0.8", LangEN},
+		{"en;q=0,zh-TW", LangZH},
+		{"fr, de", LangZH},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.header); got != tt.want {
+			t.Errorf("Match(%q) = %s，期望 %s", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestT(t *testing.T) {
+	if got := T(LangEN, ErrorKey(1004)); got != "Not found" {
+		t.Errorf("英文消息不正确: %s", got)
+	}
+	if got := T(LangZH, ErrorKey(1004)); got != "未找到" {
+		t.Errorf("中文消息不正确: %s", got)
+	}
+	// 不支持的语言回退到默认语言，未知的键返回键本身
+	if got := T("fr", ErrorKey(1004)); got != "未找到" {
+		t.Errorf("期望回退到默认语言，实际 %s", got)
+	}
+	if got := T(LangEN, "no.such.key"); got != "no.such.key" {
+		t.Errorf("期望返回键本身，实际 %s", got)
+	}
+}
+
+// formatVerbs 匹配格式字符串中的占位符
+var formatVerbs = regexp.MustCompile(`%[-+# 0-9.]*[a-zA-Z%]`)
+
+func TestCatalogsConsistent(t *testing.T) {
+	defaults := catalogs[DefaultLang]
+	for lang, c := range catalogs {
+		// 每种语言都应翻译默认语言中的所有消息
+		for key := range defaults.Messages {
+			if _, ok := c.Messages[key]; !ok {
+				t.Errorf("%s 缺少消息 %s", lang, key)
+			}
+		}
+		// 日志翻译的占位符必须与原文一致
+		for format, translated := range c.Logs {
+			want := formatVerbs.FindAllString(format, -1)
+			got := formatVerbs.FindAllString(translated, -1)
+			if len(want) != len(got) {
+				t.Errorf("%s 日志翻译的占位符不一致: %q -> %q", lang, format, translated)
+				continue
+			}
+			for i := range want {
+				if want[i] != got[i] {
+					t.Errorf("%s 日志翻译的占位符不一致: %q -> %q", lang, format, translated)
+					break
+				}
+			}
+		}
+	}
+}
+
+func TestLogTranslator(t *testing.T) {
+	if LogTranslator(LangZH) != nil {
+		t.Error("默认语言不需要翻译日志")
+	}
+	translate := LogTranslator(LangEN)
+	if got := translate("服务器已启动，监听地址: %s"); got != "Server started, listening on %s" {
+		t.Errorf("日志翻译不正确: %s", got)
+	}
+	if got := translate("未翻译的日志"); got != "未翻译的日志" {
+		t.Errorf("未翻译的日志应保持原样: %s", got)
+	}
+}
@@ -0,0 +1,162 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+)
+
+// DefaultStopTimeout 组件未指定停止超时时使用的默认值
+const DefaultStopTimeout = 10 * time.Second
+
+// ErrStopTimeout 组件未在超时时间内停止
+var ErrStopTimeout = errors.New("停止超时")
+
+// Component 受管理的组件。Start 和 Stop 都可以为空，例如只需要在退出时关闭的资源
+type Component struct {
+	Name     string
+	Start    func() error
+	Stop     func(ctx context.Context) error
+	Timeout  time.Duration // 停止超时，0 表示使用管理器的默认值
+	Optional bool          // 启动失败时只记录日志，不中止启动
+}
+
+// Manager 按启动顺序启动组件，按相反顺序停止组件。
+// 组件在 Start 返回后才会被记录，停止时只停止已经启动成功的组件
+type Manager struct {
+	timeout  time.Duration
+	started  []Component
+	stopped  bool
+	failedCh chan error
+	mu       sync.Mutex
+}
+
+// New 创建生命周期管理器，timeout 为组件默认的停止超时，0 表示使用 DefaultStopTimeout
+func New(timeout time.Duration) *Manager {
+	if timeout <= 0 {
+		timeout = DefaultStopTimeout
+	}
+	return &Manager{
+		timeout:  timeout,
+		failedCh: make(chan error, 1),
+	}
+}
+
+// Start 立即启动组件，成功后记录下来在 Stop 时停止。可选组件启动失败时返回 nil
+func (m *Manager) Start(c Component) error {
+	m.mu.Lock()
+	stopped := m.stopped
+	m.mu.Unlock()
+	if stopped {
+		return fmt.Errorf("%s: 管理器已停止", c.Name)
+	}
+
+	if c.Start != nil {
+		if err := c.Start(); err != nil {
+			if c.Optional {
+				logger.Warn("启动 %s 失败: %v", c.Name, err)
+				return nil
+			}
+			return fmt.Errorf("启动 %s 失败: %w", c.Name, err)
+		}
+	}
+
+	m.mu.Lock()
+	m.started = append(m.started, c)
+	m.mu.Unlock()
+	logger.Debug("%s 已启动", c.Name)
+	return nil
+}
+
+// Fail 报告组件在后台运行时失败，例如 HTTP 服务器停止监听。
+// 只保留第一个错误，通过 Failed 通知调用方开始关闭
+func (m *Manager) Fail(name string, err error) {
+	logger.Error("%s 运行失败: %v", name, err)
+	select {
+	case m.failedCh <- fmt.Errorf("%s: %w", name, err):
+	default:
+	}
+}
+
+// Failed 返回组件运行失败的通知
+func (m *Manager) Failed() <-chan error {
+	return m.failedCh
+}
+
+// Stop 按启动的相反顺序停止所有组件，每个组件最多等待其停止超时，
+// 超时或出错后继续停止其余组件，返回所有错误的汇总。重复调用时直接返回
+func (m *Manager) Stop() error {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return nil
+	}
+	m.stopped = true
+	components := m.started
+	m.started = nil
+	m.mu.Unlock()
+
+	var errs []error
+	for i := len(components) - 1; i >= 0; i-- {
+		c := components[i]
+		if c.Stop == nil {
+			continue
+		}
+		if err := m.stop(c); err != nil {
+			logger.Error("停止 %s 失败: %v", c.Name, err)
+			errs = append(errs, fmt.Errorf("停止 %s 失败: %w", c.Name, err))
+			continue
+		}
+		logger.Debug("%s 已停止", c.Name)
+	}
+	return errors.Join(errs...)
+}
+
+// stop 在超时时间内停止组件，超时后不再等待
+func (m *Manager) stop(c Component) error {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = m.timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Stop(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%w (%s)", ErrStopTimeout, timeout)
+	}
+}
+
+// StartFunc 将没有返回值的启动函数转换为组件的 Start
+func StartFunc(start func()) func() error {
+	return func() error {
+		start()
+		return nil
+	}
+}
+
+// StopFunc 将没有返回值的停止函数转换为组件的 Stop
+func StopFunc(stop func()) func(ctx context.Context) error {
+	return func(context.Context) error {
+		stop()
+		return nil
+	}
+}
+
+// StopErrFunc 将返回错误的停止函数转换为组件的 Stop
+func StopErrFunc(stop func() error) func(ctx context.Context) error {
+	return func(context.Context) error {
+		return stop()
+	}
+}
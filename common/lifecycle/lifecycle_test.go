@@ -0,0 +1,106 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestManagerOrder(t *testing.T) {
+	m := New(time.Second)
+	var events []string
+	component := func(name string, startErr, stopErr error) Component {
+		return Component{
+			Name: name,
+			Start: func() error {
+				events = append(events, "start "+name)
+				return startErr
+			},
+			Stop: func(context.Context) error {
+				events = append(events, "stop "+name)
+				return stopErr
+			},
+		}
+	}
+
+	if err := m.Start(component("db", nil, nil)); err != nil {
+		t.Fatalf("启动失败: %v", err)
+	}
+	if err := m.Start(component("relay", nil, errors.New("boom"))); err != nil {
+		t.Fatalf("启动失败: %v", err)
+	}
+	optional := component("turn", errors.New("端口被占用"), nil)
+	optional.Optional = true
+	if err := m.Start(optional); err != nil {
+		t.Fatalf("可选组件启动失败不应返回错误: %v", err)
+	}
+	if err := m.Start(component("http", nil, nil)); err != nil {
+		t.Fatalf("启动失败: %v", err)
+	}
+	if err := m.Start(component("signaling", errors.New("失败"), nil)); err == nil {
+		t.Fatal("必需组件启动失败应返回错误")
+	}
+
+	// 出错后继续停止其余组件，启动失败的组件不会被停止
+	err := m.Stop()
+	if err == nil || !strings.Contains(err.Error(), "停止 relay 失败: boom") {
+		t.Fatalf("停止错误为 %v", err)
+	}
+	want := []string{"start db", "start relay", "start turn", "start http", "start signaling", "stop http", "stop relay", "stop db"}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("执行顺序为 %v", events)
+	}
+
+	if err := m.Stop(); err != nil {
+		t.Fatalf("重复停止返回 %v", err)
+	}
+	if err := m.Start(component("late", nil, nil)); err == nil {
+		t.Fatal("停止后不应再启动组件")
+	}
+}
+
+func TestManagerStopTimeout(t *testing.T) {
+	m := New(time.Second)
+	released := make(chan struct{})
+	defer close(released)
+
+	stoppedFast := false
+	m.Start(Component{Name: "fast", Stop: StopFunc(func() { stoppedFast = true })})
+	m.Start(Component{
+		Name:    "slow",
+		Timeout: 50 * time.Millisecond,
+		Stop: func(ctx context.Context) error {
+			<-released
+			return nil
+		},
+	})
+
+	started := time.Now()
+	if err := m.Stop(); !errors.Is(err, ErrStopTimeout) {
+		t.Fatalf("期望 ErrStopTimeout，实际 %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 500*time.Millisecond {
+		t.Errorf("等待了 %s，应在组件超时后继续", elapsed)
+	}
+	if !stoppedFast {
+		t.Error("超时后未继续停止其余组件")
+	}
+}
+
+func TestManagerFail(t *testing.T) {
+	m := New(0)
+	m.Fail("http", errors.New("地址已被占用"))
+	m.Fail("relay", errors.New("第二个错误"))
+
+	select {
+	case err := <-m.Failed():
+		if err.Error() != "http: 地址已被占用" {
+			t.Errorf("失败原因为 %v", err)
+		}
+	default:
+		t.Fatal("未收到失败通知")
+	}
+}
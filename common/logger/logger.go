@@ -5,9 +5,11 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -45,6 +47,11 @@ func (l Level) String() string {
 	}
 }
 
+// Name 返回日志级别的配置名称，例如 debug
+func (l Level) Name() string {
+	return strings.ToLower(l.String())
+}
+
 // ParseLevel 解析日志级别
 func ParseLevel(level string) Level {
 	switch strings.ToLower(level) {
@@ -63,34 +70,230 @@ func ParseLevel(level string) Level {
 	}
 }
 
+// LookupLevel 解析日志级别，不支持的级别返回 false
+func LookupLevel(level string) (Level, bool) {
+	switch strings.ToLower(level) {
+	case "debug", "info", "warn", "warning", "error", "fatal":
+		return ParseLevel(level), true
+	default:
+		return InfoLevel, false
+	}
+}
+
+// modulePattern 模块名：包名（如 p2p）或包名/文件名（如 p2p/signaling）
+var modulePattern = regexp.MustCompile(`^[a-z0-9_]+(/[a-z0-9_]+)?$`)
+
+// ParseModuleLevels 解析各模块的日志级别，模块名或级别无效时返回错误
+func ParseModuleLevels(levels map[string]string) (map[string]Level, error) {
+	parsed := make(map[string]Level, len(levels))
+	for module, name := range levels {
+		if !modulePattern.MatchString(module) {
+			return nil, fmt.Errorf("无效的日志模块: %s，应为包名或包名/文件名", module)
+		}
+		level, ok := LookupLevel(name)
+		if !ok {
+			return nil, fmt.Errorf("模块 %s 的日志级别无效: %s", module, name)
+		}
+		parsed[module] = level
+	}
+	return parsed, nil
+}
+
+// ParseModuleSpec 解析 "p2p/signaling=debug,forward=warn" 形式的模块日志级别，用于环境变量和命令行
+func ParseModuleSpec(spec string) (map[string]string, error) {
+	levels := make(map[string]string)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		module, level, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("无效的模块日志级别: %s，应为 模块=级别", item)
+		}
+		levels[strings.TrimSpace(module)] = strings.TrimSpace(level)
+	}
+	return levels, nil
+}
+
 // Logger 日志记录器
 type Logger struct {
-	level     Level
+	level Level
+	// modules 各模块的日志级别，未设置的模块使用 level
+	modules map[string]Level
+	// threshold 全局和各模块级别中的最低级别，低于它的日志不需要查找调用位置即可丢弃
+	threshold atomic.Int32
 	output    io.Writer
 	mu        sync.Mutex
 	prefix    string
 	callDepth int
+	// translate 翻译日志格式字符串，为空时不翻译
+	translate func(format string) string
 }
 
 var (
 	// DefaultLogger 默认日志记录器
 	DefaultLogger = NewLogger(InfoLevel, os.Stdout)
+
+	// loggerFile 本文件的路径，查找调用位置时跳过本文件中的包装函数
+	loggerFile string
 )
 
+func init() {
+	_, loggerFile, _, _ = runtime.Caller(0)
+}
+
 // NewLogger 创建日志记录器
 func NewLogger(level Level, output io.Writer) *Logger {
-	return &Logger{
+	l := &Logger{
 		level:     level,
 		output:    output,
 		callDepth: 2,
 	}
+	l.threshold.Store(int32(level))
+	return l
 }
 
-// SetLevel 设置日志级别
+// SetLevel 设置日志级别，设置了级别的模块不受影响
 func (l *Logger) SetLevel(level Level) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.level = level
+	l.updateThreshold()
+}
+
+// Level 返回日志级别
+func (l *Logger) Level() Level {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level
+}
+
+// SetModuleLevel 设置模块的日志级别。模块为包名（如 p2p）或包名/文件名（如 p2p/signaling），
+// 后者优先于前者
+func (l *Logger) SetModuleLevel(module string, level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.modules == nil {
+		l.modules = make(map[string]Level)
+	}
+	l.modules[module] = level
+	l.updateThreshold()
+}
+
+// ClearModuleLevel 清除模块的日志级别，模块恢复使用全局级别
+func (l *Logger) ClearModuleLevel(module string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.modules, module)
+	l.updateThreshold()
+}
+
+// SetModuleLevels 替换全部模块的日志级别
+func (l *Logger) SetModuleLevels(levels map[string]Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.modules = make(map[string]Level, len(levels))
+	for module, level := range levels {
+		l.modules[module] = level
+	}
+	l.updateThreshold()
+}
+
+// ModuleLevels 返回各模块的日志级别
+func (l *Logger) ModuleLevels() map[string]Level {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	levels := make(map[string]Level, len(l.modules))
+	for module, level := range l.modules {
+		levels[module] = level
+	}
+	return levels
+}
+
+// ApplyLevels 修改全局和部分模块的日志级别，用于运行时控制接口。level 为空时不修改全局级别，
+// 模块的级别为空时清除该模块的级别，未提及的模块保持不变。任一级别无效时不做任何修改
+func (l *Logger) ApplyLevels(level string, modules map[string]string) error {
+	global := InfoLevel
+	if level != "" {
+		var ok bool
+		if global, ok = LookupLevel(level); !ok {
+			return fmt.Errorf("无效的日志级别: %s", level)
+		}
+	}
+	set := make(map[string]string)
+	var clear []string
+	for module, name := range modules {
+		if name == "" {
+			clear = append(clear, module)
+		} else {
+			set[module] = name
+		}
+	}
+	parsed, err := ParseModuleLevels(set)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level != "" {
+		l.level = global
+	}
+	if l.modules == nil {
+		l.modules = make(map[string]Level)
+	}
+	for module, level := range parsed {
+		l.modules[module] = level
+	}
+	for _, module := range clear {
+		delete(l.modules, module)
+	}
+	l.updateThreshold()
+	return nil
+}
+
+// updateThreshold 重新计算最低级别，调用方需持有锁
+func (l *Logger) updateThreshold() {
+	threshold := l.level
+	for _, level := range l.modules {
+		if level < threshold {
+			threshold = level
+		}
+	}
+	l.threshold.Store(int32(threshold))
+}
+
+// moduleLevel 按调用位置所在的文件查找日志级别，调用方需持有锁
+func (l *Logger) moduleLevel(file string) Level {
+	if len(l.modules) == 0 {
+		return l.level
+	}
+	pkg := filepath.Base(filepath.Dir(file))
+	if level, ok := l.modules[pkg+"/"+strings.TrimSuffix(filepath.Base(file), ".go")]; ok {
+		return level
+	}
+	if level, ok := l.modules[pkg]; ok {
+		return level
+	}
+	return l.level
+}
+
+// caller 查找调用位置，跳过本文件中的包装函数，例如包级别的 Info
+func (l *Logger) caller() (string, int, bool) {
+	var pcs [8]uintptr
+	// 跳过 runtime.Callers、caller 和 log
+	n := runtime.Callers(l.callDepth+2, pcs[:])
+	if n == 0 {
+		return "", 0, false
+	}
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if frame.File != loggerFile || !more {
+			return frame.File, frame.Line, true
+		}
+	}
 }
 
 // SetOutput 设置输出
@@ -107,6 +310,13 @@ func (l *Logger) SetPrefix(prefix string) {
 	l.prefix = prefix
 }
 
+// SetTranslator 设置日志格式字符串的翻译函数，例如 i18n.LogTranslator，为 nil 时不翻译
+func (l *Logger) SetTranslator(translate func(format string) string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.translate = translate
+}
+
 // SetCallDepth 设置调用深度
 func (l *Logger) SetCallDepth(depth int) {
 	l.mu.Lock()
@@ -116,19 +326,19 @@ func (l *Logger) SetCallDepth(depth int) {
 
 // log 记录日志
 func (l *Logger) log(level Level, format string, args ...interface{}) {
-	if level < l.level {
+	if int32(level) < l.threshold.Load() {
 		return
 	}
 
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	now := time.Now().Format("2006-01-02 15:04:05.000")
-	var file string
-	var line int
-	var ok bool
+	file, line, ok := l.caller()
+	if level < l.moduleLevel(file) {
+		return
+	}
 
-	_, file, line, ok = runtime.Caller(l.callDepth)
+	now := time.Now().Format("2006-01-02 15:04:05.000")
 	if !ok {
 		file = "???"
 		line = 0
@@ -136,6 +346,10 @@ func (l *Logger) log(level Level, format string, args ...interface{}) {
 		file = filepath.Base(file)
 	}
 
+	if l.translate != nil {
+		format = l.translate(format)
+	}
+
 	var msg string
 	if len(args) > 0 {
 		msg = fmt.Sprintf(format, args...)
@@ -202,6 +416,46 @@ func SetLevel(level Level) {
 	DefaultLogger.SetLevel(level)
 }
 
+// CurrentLevel 返回默认日志记录器的日志级别
+func CurrentLevel() Level {
+	return DefaultLogger.Level()
+}
+
+// SetModuleLevel 设置默认日志记录器中模块的日志级别
+func SetModuleLevel(module string, level Level) {
+	DefaultLogger.SetModuleLevel(module, level)
+}
+
+// ClearModuleLevel 清除默认日志记录器中模块的日志级别
+func ClearModuleLevel(module string) {
+	DefaultLogger.ClearModuleLevel(module)
+}
+
+// SetModuleLevels 替换默认日志记录器中全部模块的日志级别
+func SetModuleLevels(levels map[string]Level) {
+	DefaultLogger.SetModuleLevels(levels)
+}
+
+// ModuleLevels 返回默认日志记录器中各模块的日志级别
+func ModuleLevels() map[string]Level {
+	return DefaultLogger.ModuleLevels()
+}
+
+// ApplyLevels 修改默认日志记录器的全局和部分模块的日志级别
+func ApplyLevels(level string, modules map[string]string) error {
+	return DefaultLogger.ApplyLevels(level, modules)
+}
+
+// ModuleLevelNames 返回默认日志记录器中各模块的日志级别名称，用于配置接口的响应
+func ModuleLevelNames() map[string]string {
+	levels := ModuleLevels()
+	names := make(map[string]string, len(levels))
+	for module, level := range levels {
+		names[module] = level.Name()
+	}
+	return names
+}
+
 // SetOutput 设置默认日志记录器的输出
 func SetOutput(output io.Writer) {
 	DefaultLogger.SetOutput(output)
@@ -212,6 +466,11 @@ func SetPrefix(prefix string) {
 	DefaultLogger.SetPrefix(prefix)
 }
 
+// SetTranslator 设置默认日志记录器的翻译函数
+func SetTranslator(translate func(format string) string) {
+	DefaultLogger.SetTranslator(translate)
+}
+
 // InitLogger 初始化日志记录器
 func InitLogger(level, output, file string) error {
 	// 设置日志级别
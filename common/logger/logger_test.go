@@ -159,3 +159,115 @@ func TestParseLevel(t *testing.T) {
 		}
 	}
 }
+
+func TestLogTranslator(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(InfoLevel, &buf)
+	logger.SetTranslator(func(format string) string {
+		if format == "设备 %s 已上线" {
+			return "device %s is online"
+		}
+		return format
+	})
+
+	logger.Info("设备 %s 已上线", "node-1")
+	logger.Info("未翻译的日志")
+
+	output := buf.String()
+	if !strings.Contains(output, "device node-1 is online") {
+		t.Errorf("日志未翻译: %s", output)
+	}
+	if !strings.Contains(output, "未翻译的日志") {
+		t.Errorf("未翻译的日志应保持原样: %s", output)
+	}
+}
+
+func TestModuleLevels(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewLogger(WarnLevel, &buf)
+
+	// 本文件的模块为 logger（包名）和 logger/logger_test（包名/文件名）
+	logger.SetModuleLevel("logger", DebugLevel)
+	logger.Debug("模块调试日志")
+	if !strings.Contains(buf.String(), "模块调试日志") {
+		t.Fatalf("模块级别未生效: %s", buf.String())
+	}
+	buf.Reset()
+
+	// 包名/文件名优先于包名
+	logger.SetModuleLevel("logger/logger_test", ErrorLevel)
+	logger.Warn("被文件级别过滤的日志")
+	if buf.Len() > 0 {
+		t.Fatalf("文件级别未优先: %s", buf.String())
+	}
+
+	// 其他模块的级别不影响本文件
+	logger.SetModuleLevels(map[string]Level{"p2p": DebugLevel})
+	logger.Debug("使用全局级别过滤的日志")
+	if buf.Len() > 0 {
+		t.Fatalf("其他模块的级别影响了本文件: %s", buf.String())
+	}
+
+	logger.ClearModuleLevel("p2p")
+	if len(logger.ModuleLevels()) != 0 {
+		t.Fatalf("清除模块级别失败: %v", logger.ModuleLevels())
+	}
+}
+
+func TestPackageLevelCaller(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stdout)
+
+	Info("包级别函数的日志")
+	if !strings.Contains(buf.String(), "logger_test.go") {
+		t.Errorf("调用位置应为调用方的文件: %s", buf.String())
+	}
+}
+
+func TestParseModuleLevels(t *testing.T) {
+	spec, err := ParseModuleSpec("p2p/signaling=debug, forward=warn")
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	levels, err := ParseModuleLevels(spec)
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if levels["p2p/signaling"] != DebugLevel || levels["forward"] != WarnLevel {
+		t.Fatalf("解析结果错误: %v", levels)
+	}
+
+	for _, invalid := range []map[string]string{
+		{"forward": "verbose"},
+		{"P2P": "debug"},
+		{"a/b/c": "debug"},
+	} {
+		if _, err := ParseModuleLevels(invalid); err == nil {
+			t.Errorf("期望 %v 解析失败", invalid)
+		}
+	}
+	if _, err := ParseModuleSpec("forward"); err == nil {
+		t.Error("缺少级别时应解析失败")
+	}
+}
+
+func TestApplyLevels(t *testing.T) {
+	logger := NewLogger(InfoLevel, &bytes.Buffer{})
+	logger.SetModuleLevel("relay", WarnLevel)
+
+	if err := logger.ApplyLevels("debug", map[string]string{"p2p/signaling": "verbose"}); err == nil {
+		t.Fatal("期望无效级别返回错误")
+	}
+	if logger.Level() != InfoLevel {
+		t.Fatal("部分级别无效时不应修改全局级别")
+	}
+
+	if err := logger.ApplyLevels("", map[string]string{"p2p/signaling": "debug", "relay": ""}); err != nil {
+		t.Fatalf("修改日志级别失败: %v", err)
+	}
+	levels := logger.ModuleLevels()
+	if len(levels) != 1 || levels["p2p/signaling"] != DebugLevel || logger.Level() != InfoLevel {
+		t.Fatalf("修改结果错误: %v，全局 %s", levels, logger.Level())
+	}
+}
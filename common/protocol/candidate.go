@@ -0,0 +1,46 @@
+package protocol
+
+import "fmt"
+
+// 连接对等节点的候选方式，连接时按连接优先级依次尝试。中继由中继策略单独控制，不在优先级中
+const (
+	CandidateIPv6     = "ipv6"      // 通过双方的全局 IPv6 地址直接连接，不经过 NAT
+	CandidateDirect   = "direct"    // 一方有公网 IPv4 地址时直接连接
+	CandidateUPnP     = "upnp"      // 通过 UPnP 映射的端口连接
+	CandidateUDPPunch = "udp-punch" // UDP 打洞
+	CandidateTCPPunch = "tcp-punch" // TCP 打洞，成功率低于 UDP 打洞
+)
+
+// DefaultCandidatePriority 默认的连接优先级：优先 IPv6，其次直接连接和 UPnP，然后 UDP 打洞，最后 TCP 打洞
+var DefaultCandidatePriority = []string{
+	CandidateIPv6,
+	CandidateDirect,
+	CandidateUPnP,
+	CandidateUDPPunch,
+	CandidateTCPPunch,
+}
+
+// IsCandidate 是否为可识别的候选方式
+func IsCandidate(method string) bool {
+	for _, candidate := range DefaultCandidatePriority {
+		if method == candidate {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateCandidatePriority 检查连接优先级中的候选方式都可识别且不重复。未列出的方式不会被尝试
+func ValidateCandidatePriority(priority []string) error {
+	seen := make(map[string]bool, len(priority))
+	for _, method := range priority {
+		if !IsCandidate(method) {
+			return fmt.Errorf("不支持的连接方式: %s", method)
+		}
+		if seen[method] {
+			return fmt.Errorf("连接方式重复: %s", method)
+		}
+		seen[method] = true
+	}
+	return nil
+}
@@ -0,0 +1,294 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// WebSocket 信令的子协议。客户端在握手时按优先顺序提供，服务端选择第一个支持的子协议；
+// 未协商子协议时（旧版本的客户端或服务端）使用 JSON 文本帧
+const (
+	// SubprotocolJSON 文本帧，一帧包含一条或多条以换行分隔的 JSON 信令
+	SubprotocolJSON = "p3.json"
+	// SubprotocolMsgpack 二进制帧，一帧包含一条或多条首尾相接的 MessagePack 信令
+	SubprotocolMsgpack = "p3.msgpack"
+)
+
+// CompressionThreshold 启用 permessage-deflate 压缩的最小帧长度，更短的帧压缩后节省有限，直接发送
+const CompressionThreshold = 256
+
+// maxMsgpackDepth MessagePack 信令的最大嵌套层数，信令来自网络，内容不可信
+const maxMsgpackDepth = 32
+
+// EncodeMsgpack 将一条 JSON 信令转换为 MessagePack 编码。信令在两端仍按 JSON 构造和解析，
+// 编码只在 WebSocket 传输时转换，两种编码的信令内容完全一致
+func EncodeMsgpack(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeMsgpack(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeMsgpack 将一帧 MessagePack 信令转换为 JSON，一帧可以包含多条首尾相接的信令
+func DecodeMsgpack(data []byte) ([][]byte, error) {
+	var messages [][]byte
+	r := &msgpackReader{data: data}
+	for r.pos < len(r.data) {
+		value, err := r.read(0)
+		if err != nil {
+			return nil, fmt.Errorf("解析 MessagePack 信令失败: %w", err)
+		}
+		message, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+// writeMsgpack 编码 JSON 解析出的值
+func writeMsgpack(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if v {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			writeMsgpackInt(buf, i)
+			return nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return err
+		}
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+	case string:
+		writeMsgpackHeader(buf, len(v), 0xa0, 31, 0xd9, 0xda, 0xdb)
+		buf.WriteString(v)
+	case []interface{}:
+		writeMsgpackHeader(buf, len(v), 0x90, 15, 0, 0xdc, 0xdd)
+		for _, item := range v {
+			if err := writeMsgpack(buf, item); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		// 按键排序，相同的信令编码结果相同
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		writeMsgpackHeader(buf, len(v), 0x80, 15, 0, 0xde, 0xdf)
+		for _, key := range keys {
+			writeMsgpack(buf, key)
+			if err := writeMsgpack(buf, v[key]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("不支持的 MessagePack 类型: %T", value)
+	}
+	return nil
+}
+
+// writeMsgpackInt 按最短的格式编码整数
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i <= 0x7f:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(i))
+	case i >= math.MinInt8 && i <= math.MaxInt8:
+		buf.Write([]byte{0xd0, byte(i)})
+	case i >= math.MinInt16 && i <= math.MaxInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(i))
+	case i >= math.MinInt32 && i <= math.MaxInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// writeMsgpackHeader 编码字符串、数组或映射的类型和长度。fix 为短格式的类型前缀，fixMax 为短格式的最大长度，
+// f8、f16、f32 为 8、16、32 位长度的类型，为 0 表示不支持该长度
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fix byte, fixMax int, f8, f16, f32 byte) {
+	switch {
+	case n <= fixMax:
+		buf.WriteByte(fix | byte(n))
+	case f8 != 0 && n <= math.MaxUint8:
+		buf.Write([]byte{f8, byte(n)})
+	case n <= math.MaxUint16:
+		buf.WriteByte(f16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(f32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+// msgpackReader 解码 MessagePack，只支持 JSON 能表示的类型
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+var errMsgpackShort = errors.New("数据不完整")
+
+// next 读取 n 个字节
+func (r *msgpackReader) next(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, errMsgpackShort
+	}
+	b := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// uint 读取 n 个字节的大端无符号整数
+func (r *msgpackReader) uint(n int) (uint64, error) {
+	b, err := r.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// read 读取一个值
+func (r *msgpackReader) read(depth int) (interface{}, error) {
+	if depth > maxMsgpackDepth {
+		return nil, errors.New("嵌套层数过多")
+	}
+	b, err := r.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xe0 == 0xa0:
+		return r.str(int(c & 0x1f))
+	case c&0xf0 == 0x90:
+		return r.array(int(c&0x0f), depth)
+	case c&0xf0 == 0x80:
+		return r.object(int(c&0x0f), depth)
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xca:
+		v, err := r.uint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := r.uint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		return r.uint(1 << (c - 0xcc))
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		v, err := r.uint(size)
+		// 符号扩展
+		shift := 64 - 8*size
+		return int64(v<<shift) >> shift, err
+	case 0xd9, 0xda, 0xdb:
+		n, err := r.uint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return r.str(int(n))
+	case 0xdc, 0xdd:
+		n, err := r.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.array(int(n), depth)
+	case 0xde, 0xdf:
+		n, err := r.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.object(int(n), depth)
+	}
+	return nil, fmt.Errorf("不支持的类型 0x%02x", c)
+}
+
+// str 读取长度为 n 的字符串
+func (r *msgpackReader) str(n int) (string, error) {
+	b, err := r.next(n)
+	return string(b), err
+}
+
+// array 读取 n 个元素的数组。每个元素至少占一个字节，长度超过剩余数据时直接返回错误，避免按伪造的长度分配内存
+func (r *msgpackReader) array(n, depth int) ([]interface{}, error) {
+	if n > len(r.data)-r.pos {
+		return nil, errMsgpackShort
+	}
+	items := make([]interface{}, n)
+	for i := range items {
+		item, err := r.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		items[i] = item
+	}
+	return items, nil
+}
+
+// object 读取 n 个键值对的映射，键必须是字符串
+func (r *msgpackReader) object(n, depth int) (map[string]interface{}, error) {
+	if 2*n > len(r.data)-r.pos {
+		return nil, errMsgpackShort
+	}
+	object := make(map[string]interface{}, n)
+	for i := 0; i < n; i++ {
+		key, err := r.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		k, ok := key.(string)
+		if !ok {
+			return nil, errors.New("映射的键不是字符串")
+		}
+		value, err := r.read(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		object[k] = value
+	}
+	return object, nil
+}
@@ -0,0 +1,79 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMsgpackRoundTrip(t *testing.T) {
+	signal := Signal{
+		Type:       SignalOffer,
+		SenderID:   "node-a",
+		ReceiverID: "node-b",
+		Payload: map[string]interface{}{
+			"port":       27182,
+			"negative":   -40000,
+			"large":      int64(1) << 40,
+			"ratio":      0.75,
+			"candidates": []string{"192.168.1.2:5000", strings.Repeat("x", 300)},
+			"nested":     map[string]interface{}{"ok": true, "empty": nil},
+		},
+		Timestamp: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	data, err := json.Marshal(signal)
+	if err != nil {
+		t.Fatalf("编码 JSON 失败: %v", err)
+	}
+
+	encoded, err := EncodeMsgpack(data)
+	if err != nil {
+		t.Fatalf("编码 MessagePack 失败: %v", err)
+	}
+	if len(encoded) >= len(data) {
+		t.Fatalf("MessagePack 编码 %d 字节，JSON %d 字节", len(encoded), len(data))
+	}
+
+	// 一帧包含两条信令
+	messages, err := DecodeMsgpack(append(encoded, encoded...))
+	if err != nil {
+		t.Fatalf("解码 MessagePack 失败: %v", err)
+	}
+	if len(messages) != 2 {
+		t.Fatalf("解码出 %d 条信令", len(messages))
+	}
+	var want, got interface{}
+	json.Unmarshal(data, &want)
+	json.Unmarshal(messages[1], &got)
+	wantJSON, _ := json.Marshal(want)
+	gotJSON, _ := json.Marshal(got)
+	if !bytes.Equal(wantJSON, gotJSON) {
+		t.Fatalf("解码结果为 %s，期望 %s", gotJSON, wantJSON)
+	}
+
+	parsed, err := ParseSignal(messages[0])
+	if err != nil || parsed.Type != SignalOffer || !parsed.Timestamp.Equal(signal.Timestamp) {
+		t.Fatalf("解析信令失败: %+v, %v", parsed, err)
+	}
+}
+
+func TestDecodeMsgpackInvalid(t *testing.T) {
+	tests := map[string][]byte{
+		"数据不完整":   {0xa5, 'a', 'b'},
+		"伪造的数组长度": {0xdd, 0xff, 0xff, 0xff, 0xff},
+		"键不是字符串":  {0x81, 0x01, 0x02},
+		"不支持的类型":  {0xc4, 0x01, 0x00},
+	}
+	for name, data := range tests {
+		if _, err := DecodeMsgpack(data); err == nil {
+			t.Errorf("%s: 应返回错误", name)
+		}
+	}
+
+	deep := bytes.Repeat([]byte{0x91}, maxMsgpackDepth+2)
+	if _, err := DecodeMsgpack(append(deep, 0xc0)); err == nil {
+		t.Error("嵌套层数过多时应返回错误")
+	}
+}
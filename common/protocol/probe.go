@@ -0,0 +1,54 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	// ProbeMinRequestSize 回显探测请求的最小长度。服务端对每个请求最多回复两个比请求短得多的响应，
+	// 不足该长度的请求不回复，避免回显服务被用于反射放大攻击
+	ProbeMinRequestSize = 512
+	// ProbeMaxIDLength 探测请求 ID 的最大长度
+	ProbeMaxIDLength = 64
+)
+
+// ProbeRequest 回显探测请求，客户端以 UDP 发送到服务端的探测端口。
+// TCP 探测在连接上发送一行请求，三次握手已确认来源地址，不需要填充
+type ProbeRequest struct {
+	ID string `json:"id"`
+	// Alt 为 true 时服务端同时从备用端口回复，客户端据此判断 NAT 是否按端口过滤入站数据
+	Alt bool `json:"alt,omitempty"`
+	// Padding 填充请求到 ProbeMinRequestSize，内容无意义
+	Padding string `json:"padding,omitempty"`
+}
+
+// ProbeResponse 回显探测响应
+type ProbeResponse struct {
+	ID      string `json:"id"`
+	Address string `json:"address"` // 服务端看到的请求来源地址，即 NAT 映射后的地址
+	Port    int    `json:"port"`    // 发送响应的服务端端口
+}
+
+// ProbeInfo 服务端回显探测服务的信息
+type ProbeInfo struct {
+	Address    string `json:"address"`    // 服务端看到的 HTTP 请求来源地址
+	UDPPort    int    `json:"udpPort"`    // 接收探测请求的端口，为 0 时服务未启动
+	UDPAltPort int    `json:"udpAltPort"` // 发送备用响应的端口，为 0 时不从备用端口回复
+	TCPPort    int    `json:"tcpPort"`    // 接收 TCP 探测请求的端口，为 0 时服务未启动
+}
+
+// MarshalProbeRequest 序列化探测请求，不足 ProbeMinRequestSize 时填充到该长度
+func MarshalProbeRequest(req *ProbeRequest) ([]byte, error) {
+	padded := *req
+	padded.Padding = ""
+	data, err := json.Marshal(&padded)
+	if err != nil {
+		return nil, err
+	}
+	if missing := ProbeMinRequestSize - len(data) - len(`,"padding":""`); missing > 0 {
+		padded.Padding = strings.Repeat("0", missing)
+		return json.Marshal(&padded)
+	}
+	return data, nil
+}
@@ -0,0 +1,110 @@
+package protocol
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestParseTypes(t *testing.T) {
+	for nt := NATUnknown; nt <= NATSymmetric; nt++ {
+		if got := ParseNATType(nt.String()); got != nt {
+			t.Errorf("ParseNATType(%q) = %v", nt.String(), got)
+		}
+	}
+	for ct := ConnectionUnknown; ct <= ConnectionLAN; ct++ {
+		if got := ParseConnectionType(ct.String()); got != ct {
+			t.Errorf("ParseConnectionType(%q) = %v", ct.String(), got)
+		}
+	}
+	// 服务端下发的打洞连接类型带空格，旧客户端按 "HolePunch" 解析会得到未知类型
+	if got := ParseConnectionType("Hole Punch"); got != ConnectionHolePunch {
+		t.Errorf("打洞连接解析为 %v", got)
+	}
+	if got := ParseNATType("Carrier Grade NAT"); got != NATUnknown {
+		t.Errorf("未知的 NAT 类型解析为 %v", got)
+	}
+}
+
+func TestTypesJSON(t *testing.T) {
+	type peer struct {
+		NATType        NATType        `json:"natType"`
+		ConnectionType ConnectionType `json:"connectionType"`
+	}
+	data, err := json.Marshal(peer{NATType: NATSymmetric, ConnectionType: ConnectionHolePunch})
+	if err != nil {
+		t.Fatalf("编码失败: %v", err)
+	}
+	if string(data) != `{"natType":"Symmetric NAT","connectionType":"Hole Punch"}` {
+		t.Fatalf("编码结果为 %s", data)
+	}
+	var decoded peer
+	if err := json.Unmarshal(data, &decoded); err != nil || decoded.NATType != NATSymmetric || decoded.ConnectionType != ConnectionHolePunch {
+		t.Fatalf("解码结果为 %+v, %v", decoded, err)
+	}
+	if err := json.Unmarshal([]byte(`{"connectionType":"Carrier Pigeon"}`), &decoded); err == nil {
+		t.Error("未知的连接类型应解码失败")
+	}
+}
+
+func TestParseSignal(t *testing.T) {
+	signal, err := ParseSignal([]byte(`{"type":"relay-limited","senderId":"server","payload":{"limit":"sessions"}}`))
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if signal.Type != SignalRelayLimited {
+		t.Errorf("信令类型为 %q", signal.Type)
+	}
+	if fields, ok := signal.Payload.(map[string]interface{}); !ok || fields["limit"] != "sessions" {
+		t.Errorf("负载为 %v", signal.Payload)
+	}
+
+	for _, data := range []string{`{"senderId":"server"}`, `not json`, `[]`} {
+		if _, err := ParseSignal([]byte(data)); err == nil {
+			t.Errorf("%s 应解析失败", data)
+		}
+	}
+}
+
+func TestValidateCandidatePriority(t *testing.T) {
+	if err := ValidateCandidatePriority(DefaultCandidatePriority); err != nil {
+		t.Fatalf("默认连接优先级无效: %v", err)
+	}
+	if err := ValidateCandidatePriority([]string{CandidateIPv6, CandidateUDPPunch}); err != nil {
+		t.Fatalf("可以只列出部分连接方式: %v", err)
+	}
+	if ValidateCandidatePriority([]string{CandidateIPv6, "relay"}) == nil {
+		t.Fatal("中继不应出现在连接优先级中")
+	}
+	if ValidateCandidatePriority([]string{CandidateTCPPunch, CandidateTCPPunch}) == nil {
+		t.Fatal("连接方式不应重复")
+	}
+}
+
+func TestValidateSTUNServers(t *testing.T) {
+	if err := ValidateSTUNServers([]string{"stun.example.com:3478", "[2001:db8::1]:3478"}); err != nil {
+		t.Fatalf("有效的 STUN 服务器列表: %v", err)
+	}
+	if ValidateSTUNServers([]string{"stun.example.com"}) == nil {
+		t.Fatal("缺少端口的地址应无效")
+	}
+	if ValidateSTUNServers([]string{"stun.example.com:0"}) == nil {
+		t.Fatal("端口 0 应无效")
+	}
+	if ValidateSTUNServers([]string{"stun.example.com:3478", "stun.example.com:3478"}) == nil {
+		t.Fatal("STUN 服务器不应重复")
+	}
+}
+
+func TestMarshalProbeRequest(t *testing.T) {
+	data, err := MarshalProbeRequest(&ProbeRequest{ID: "probe-1", Alt: true})
+	if err != nil {
+		t.Fatalf("序列化探测请求失败: %v", err)
+	}
+	if len(data) != ProbeMinRequestSize {
+		t.Fatalf("探测请求长度 = %d，应填充到 %d", len(data), ProbeMinRequestSize)
+	}
+	var req ProbeRequest
+	if err := json.Unmarshal(data, &req); err != nil || req.ID != "probe-1" || !req.Alt {
+		t.Fatalf("探测请求无法解析: %+v, %v", req, err)
+	}
+}
@@ -0,0 +1,300 @@
+package protocol
+
+import (
+	"sync"
+	"time"
+)
+
+// Priority 信令的发送优先级，值越小越优先
+type Priority int
+
+const (
+	// PriorityControl 建立和维护连接的信令，如 offer、answer、ICE 候选和中继协商，延迟直接影响连接建立
+	PriorityControl Priority = iota
+	// PriorityNormal 其他业务信令
+	PriorityNormal
+	// PriorityBulk 可延迟的批量信令，如在线状态推送、订阅和心跳
+	PriorityBulk
+
+	numPriorities = 3
+)
+
+// String 优先级名称
+func (p Priority) String() string {
+	switch p {
+	case PriorityControl:
+		return "control"
+	case PriorityNormal:
+		return "normal"
+	default:
+		return "bulk"
+	}
+}
+
+// SignalPriority 信令类型的发送优先级
+func SignalPriority(t SignalType) Priority {
+	switch t {
+	case SignalOffer, SignalAnswer, SignalICECandidate, SignalConnect, SignalDisconnect,
+		SignalRelayRequest, SignalRelayResponse, SignalRelayMigrate, SignalSuperseded, SignalError:
+		return PriorityControl
+	case SignalPing, SignalPong, SignalSubscribe, SignalUnsubscribe, SignalPresence, SignalUpgradeRecommended,
+		SignalSTUNServers:
+		return PriorityBulk
+	default:
+		return PriorityNormal
+	}
+}
+
+const (
+	// DefaultQueueCapacity 发送队列每个优先级默认缓存的信令数
+	DefaultQueueCapacity = 256
+	// starvationLimit 低优先级的信令等待期间连续发送的高优先级信令数上限，
+	// 达到上限后先发送一条等待中的低优先级信令，避免持续的高优先级信令使其永远得不到发送
+	starvationLimit = 8
+)
+
+// QueueStats 发送队列中一个优先级的统计
+type QueueStats struct {
+	Priority  string  `json:"priority"`
+	Pending   int     `json:"pending"`   // 等待发送的信令数
+	Sent      uint64  `json:"sent"`      // 已取出发送的信令数
+	Dropped   uint64  `json:"dropped"`   // 队列已满或已关闭时丢弃的信令数
+	AvgWaitMs float64 `json:"avgWaitMs"` // 已发送信令在队列中的平均等待时间
+	MaxWaitMs float64 `json:"maxWaitMs"` // 已发送信令在队列中的最长等待时间
+}
+
+// queuedSignal 排队中的信令
+type queuedSignal struct {
+	data     []byte
+	queuedAt time.Time
+}
+
+// queueClass 一个优先级的队列和统计
+type queueClass struct {
+	items   []queuedSignal
+	skipped int // 本优先级有信令等待时连续发送的更高优先级信令数
+	sent    uint64
+	dropped uint64
+	waited  time.Duration
+	maxWait time.Duration
+}
+
+// SendQueue 按优先级发送信令的队列，可被多个协程同时写入，由一个发送协程读取。
+// 高优先级的信令先发送，同一优先级按写入顺序发送，低优先级的信令不会被无限期推迟
+type SendQueue struct {
+	capacity int
+	classes  [numPriorities]queueClass
+	ready    chan struct{} // 有信令可取时可读
+	done     chan struct{} // 关闭后可读
+	closed   bool
+	mu       sync.Mutex
+}
+
+// NewSendQueue 创建发送队列，capacity 为每个优先级最多缓存的信令数，不为正时使用 DefaultQueueCapacity
+func NewSendQueue(capacity int) *SendQueue {
+	if capacity <= 0 {
+		capacity = DefaultQueueCapacity
+	}
+	return &SendQueue{
+		capacity: capacity,
+		ready:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+}
+
+// Push 按优先级写入一条信令。队列已满或已关闭时丢弃并返回 false
+func (q *SendQueue) Push(priority Priority, data []byte) bool {
+	if priority < 0 || priority >= numPriorities {
+		priority = PriorityNormal
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	class := &q.classes[priority]
+	if q.closed || len(class.items) >= q.capacity {
+		class.dropped++
+		return false
+	}
+	class.items = append(class.items, queuedSignal{data: data, queuedAt: time.Now()})
+	q.notify()
+	return true
+}
+
+// Pop 取出下一条要发送的信令，队列为空时返回 false
+func (q *SendQueue) Pop() ([]byte, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	next := -1
+	for p := range q.classes {
+		if len(q.classes[p].items) == 0 {
+			continue
+		}
+		if next < 0 {
+			next = p
+		} else if q.classes[p].skipped >= starvationLimit {
+			// 优先发送被推迟太久的低优先级信令
+			next = p
+			break
+		}
+	}
+	if next < 0 {
+		return nil, false
+	}
+
+	class := &q.classes[next]
+	item := class.items[0]
+	class.items[0] = queuedSignal{}
+	class.items = class.items[1:]
+	class.skipped = 0
+	class.sent++
+	wait := time.Since(item.queuedAt)
+	class.waited += wait
+	if wait > class.maxWait {
+		class.maxWait = wait
+	}
+
+	remaining := 0
+	for p := range q.classes {
+		if p != next && len(q.classes[p].items) > 0 {
+			q.classes[p].skipped++
+		}
+		remaining += len(q.classes[p].items)
+	}
+	if remaining > 0 {
+		q.notify()
+	}
+	return item.data, true
+}
+
+// notify 通知发送协程有信令可取，调用方需持有锁
+func (q *SendQueue) notify() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// Ready 有信令可取时可读的通道，读到后应调用 Pop 直到队列为空
+func (q *SendQueue) Ready() <-chan struct{} {
+	return q.ready
+}
+
+// Done 队列关闭后可读的通道
+func (q *SendQueue) Done() <-chan struct{} {
+	return q.done
+}
+
+// Close 关闭队列，之后写入的信令被丢弃。可以重复调用
+func (q *SendQueue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.done)
+	}
+}
+
+// MoveTo 将等待发送的信令按原优先级和写入时间移入 dst，用于同一节点的新连接接管旧连接时转移未发送的信令。
+// dst 已满或已关闭时多出的信令计入 dst 的丢弃数。返回移入的信令数
+func (q *SendQueue) MoveTo(dst *SendQueue) int {
+	if q == dst {
+		return 0
+	}
+
+	q.mu.Lock()
+	var pending [numPriorities][]queuedSignal
+	for p := range q.classes {
+		pending[p] = q.classes[p].items
+		q.classes[p].items = nil
+		q.classes[p].skipped = 0
+	}
+	q.mu.Unlock()
+
+	dst.mu.Lock()
+	defer dst.mu.Unlock()
+
+	moved := 0
+	for p := range pending {
+		class := &dst.classes[p]
+		for _, item := range pending[p] {
+			if dst.closed || len(class.items) >= dst.capacity {
+				class.dropped++
+				continue
+			}
+			class.items = append(class.items, item)
+			moved++
+		}
+	}
+	if moved > 0 {
+		dst.notify()
+	}
+	return moved
+}
+
+// Len 等待发送的信令总数
+func (q *SendQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := 0
+	for p := range q.classes {
+		n += len(q.classes[p].items)
+	}
+	return n
+}
+
+// Stats 各优先级的统计
+func (q *SendQueue) Stats() []QueueStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := make([]QueueStats, numPriorities)
+	for p := range q.classes {
+		class := &q.classes[p]
+		stats[p] = QueueStats{
+			Priority:  Priority(p).String(),
+			Pending:   len(class.items),
+			Sent:      class.sent,
+			Dropped:   class.dropped,
+			MaxWaitMs: durationMs(class.maxWait),
+		}
+		if class.sent > 0 {
+			stats[p].AvgWaitMs = durationMs(class.waited) / float64(class.sent)
+		}
+	}
+	return stats
+}
+
+// MergeQueueStats 合并多个队列的统计，用于汇总所有连接的发送队列
+func MergeQueueStats(total, stats []QueueStats) []QueueStats {
+	if total == nil {
+		total = make([]QueueStats, numPriorities)
+		for p := range total {
+			total[p].Priority = Priority(p).String()
+		}
+	}
+	for p := range stats {
+		if p >= len(total) {
+			break
+		}
+		t, s := &total[p], stats[p]
+		if sent := t.Sent + s.Sent; sent > 0 {
+			t.AvgWaitMs = (t.AvgWaitMs*float64(t.Sent) + s.AvgWaitMs*float64(s.Sent)) / float64(sent)
+		}
+		t.Pending += s.Pending
+		t.Sent += s.Sent
+		t.Dropped += s.Dropped
+		if s.MaxWaitMs > t.MaxWaitMs {
+			t.MaxWaitMs = s.MaxWaitMs
+		}
+	}
+	return total
+}
+
+// durationMs 时长的毫秒数
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,127 @@
+package protocol
+
+import "testing"
+
+func TestSendQueuePriority(t *testing.T) {
+	q := NewSendQueue(4)
+	q.Push(PriorityBulk, []byte("presence"))
+	q.Push(PriorityNormal, []byte("fleet"))
+	q.Push(PriorityControl, []byte("offer"))
+
+	select {
+	case <-q.Ready():
+	default:
+		t.Fatal("写入信令后应通知发送协程")
+	}
+
+	var got []string
+	for {
+		data, ok := q.Pop()
+		if !ok {
+			break
+		}
+		got = append(got, string(data))
+	}
+	if len(got) != 3 || got[0] != "offer" || got[1] != "fleet" || got[2] != "presence" {
+		t.Fatalf("发送顺序为 %v", got)
+	}
+
+	for i := 0; i < 4; i++ {
+		q.Push(PriorityBulk, []byte("presence"))
+	}
+	if q.Push(PriorityBulk, []byte("presence")) {
+		t.Fatal("队列已满时应丢弃信令")
+	}
+	if !q.Push(PriorityControl, []byte("offer")) {
+		t.Fatal("一个优先级已满不应影响其他优先级")
+	}
+
+	stats := q.Stats()
+	if stats[PriorityBulk].Sent != 1 || stats[PriorityBulk].Dropped != 1 || stats[PriorityBulk].Pending != 4 {
+		t.Fatalf("统计为 %+v", stats[PriorityBulk])
+	}
+	if stats[PriorityControl].Priority != "control" || stats[PriorityControl].Pending != 1 {
+		t.Fatalf("统计为 %+v", stats[PriorityControl])
+	}
+
+	q.Close()
+	q.Close()
+	select {
+	case <-q.Done():
+	default:
+		t.Fatal("关闭后 Done 应可读")
+	}
+	if q.Push(PriorityControl, []byte("offer")) {
+		t.Fatal("关闭后应丢弃信令")
+	}
+}
+
+func TestSendQueueStarvation(t *testing.T) {
+	q := NewSendQueue(100)
+	q.Push(PriorityBulk, []byte("bulk"))
+	for i := 0; i < 20; i++ {
+		q.Push(PriorityControl, []byte("control"))
+	}
+
+	for i := 0; i < starvationLimit; i++ {
+		if data, _ := q.Pop(); string(data) != "control" {
+			t.Fatalf("第 %d 条信令为 %s", i+1, data)
+		}
+	}
+	if data, _ := q.Pop(); string(data) != "bulk" {
+		t.Fatalf("连续发送 %d 条高优先级信令后应发送低优先级信令，实际为 %s", starvationLimit, data)
+	}
+	if data, _ := q.Pop(); string(data) != "control" {
+		t.Fatalf("低优先级信令发送后应恢复优先级，实际为 %s", data)
+	}
+}
+
+func TestSendQueueMoveTo(t *testing.T) {
+	old := NewSendQueue(4)
+	old.Push(PriorityBulk, []byte("presence"))
+	old.Push(PriorityControl, []byte("offer"))
+	old.Push(PriorityControl, []byte("answer"))
+
+	q := NewSendQueue(1)
+	q.Push(PriorityNormal, []byte("fleet"))
+	if moved := old.MoveTo(q); moved != 2 {
+		t.Fatalf("应移入 2 条信令，实际为 %d", moved)
+	}
+	if old.Len() != 0 {
+		t.Fatal("移出后原队列应为空")
+	}
+
+	var got []string
+	for {
+		data, ok := q.Pop()
+		if !ok {
+			break
+		}
+		got = append(got, string(data))
+	}
+	if len(got) != 3 || got[0] != "offer" || got[1] != "fleet" || got[2] != "presence" {
+		t.Fatalf("移入的信令应保持原优先级，发送顺序为 %v", got)
+	}
+	if stats := q.Stats(); stats[PriorityControl].Dropped != 1 {
+		t.Fatalf("目标队列已满时应丢弃多出的信令: %+v", stats[PriorityControl])
+	}
+}
+
+func TestMergeQueueStats(t *testing.T) {
+	a := []QueueStats{{Sent: 1, AvgWaitMs: 10, MaxWaitMs: 10}, {}, {Dropped: 2}}
+	b := []QueueStats{{Sent: 3, AvgWaitMs: 2, MaxWaitMs: 4, Pending: 1}, {}, {Dropped: 1}}
+	total := MergeQueueStats(MergeQueueStats(nil, a), b)
+	if total[0].Sent != 4 || total[0].AvgWaitMs != 4 || total[0].MaxWaitMs != 10 || total[0].Pending != 1 {
+		t.Fatalf("合并结果为 %+v", total[0])
+	}
+	if total[2].Priority != "bulk" || total[2].Dropped != 3 {
+		t.Fatalf("合并结果为 %+v", total[2])
+	}
+}
+
+func TestSignalPriority(t *testing.T) {
+	if SignalPriority(SignalOffer) != PriorityControl || SignalPriority(SignalPresence) != PriorityBulk ||
+		SignalPriority(SignalFleetCommand) != PriorityNormal {
+		t.Fatal("信令优先级分类错误")
+	}
+}
@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// SignalType 信令类型
+type SignalType string
+
+const (
+	SignalOffer         SignalType = "offer"
+	SignalAnswer        SignalType = "answer"
+	SignalICECandidate  SignalType = "ice-candidate"
+	SignalConnect       SignalType = "connect"
+	SignalDisconnect    SignalType = "disconnect"
+	SignalPing          SignalType = "ping"
+	SignalPong          SignalType = "pong"
+	SignalRelayRequest  SignalType = "relay-request"
+	SignalRelayResponse SignalType = "relay-response"
+	SignalError         SignalType = "error"
+	SignalSpeedTest     SignalType = "speed-test"
+
+	// SignalSubscribe 订阅节点的在线状态，payload 为 {"nodeIds": [...]}
+	SignalSubscribe SignalType = "subscribe"
+	// SignalUnsubscribe 取消订阅节点的在线状态，payload 同上
+	SignalUnsubscribe SignalType = "unsubscribe"
+	// SignalPresence 服务端推送的节点在线状态，payload 为 {"nodeId": "...", "online": true}
+	SignalPresence SignalType = "presence"
+
+	// SignalUpgradeRecommended 客户端版本低于服务端推荐版本，提示升级
+	SignalUpgradeRecommended SignalType = "upgrade-recommended"
+	// SignalRelayThrottled 用户的中继流量超出带宽限制，数据转发被延迟
+	SignalRelayThrottled SignalType = "relay-throttled"
+	// SignalRelayLimited 设备的中继会话超出并发数、新建速率或会话带宽限制
+	SignalRelayLimited SignalType = "relay-limited"
+	// SignalFleetCommand 批量操作指令，设备执行后通过 HTTP 回报结果
+	SignalFleetCommand SignalType = "fleet-command"
+	// SignalRelayMigrate 中继维护或过载，要求源节点把中继会话迁移到新的中继
+	SignalRelayMigrate SignalType = "relay-migrate"
+	// SignalPortScan 扫描设备本机或局域网目标的端口，设备扫描后通过 HTTP 回报结果
+	SignalPortScan SignalType = "port-scan"
+	// SignalSuperseded 同一节点建立了新的信令连接，旧连接即将关闭，收到后不应自动重连，
+	// payload 为 {"transport": "websocket"}，即新连接的传输方式
+	SignalSuperseded SignalType = "superseded"
+	// SignalSTUNServers 服务端下发的 STUN 服务器列表，payload 为 {"servers": [...]}，
+	// 列表为空表示恢复使用客户端本地配置的 STUN 服务器
+	SignalSTUNServers SignalType = "stun-servers"
+)
+
+// Signal 信令消息
+type Signal struct {
+	Type       SignalType  `json:"type"`
+	SenderID   string      `json:"senderId"`
+	ReceiverID string      `json:"receiverId,omitempty"`
+	Payload    interface{} `json:"payload,omitempty"`
+	Timestamp  time.Time   `json:"timestamp"`
+}
+
+// ParseSignal 解析一条信令消息，信令来自网络，内容不可信
+func ParseSignal(data []byte) (*Signal, error) {
+	var signal Signal
+	if err := json.Unmarshal(data, &signal); err != nil {
+		return nil, err
+	}
+	if signal.Type == "" {
+		return nil, errors.New("信令缺少类型")
+	}
+	return &signal, nil
+}
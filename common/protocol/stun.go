@@ -0,0 +1,26 @@
+package protocol
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// ValidateSTUNServers 检查 STUN 服务器列表中的地址都是 host:port 格式且不重复
+func ValidateSTUNServers(servers []string) error {
+	seen := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		host, port, err := net.SplitHostPort(server)
+		if err != nil || host == "" {
+			return fmt.Errorf("无效的 STUN 服务器地址: %s", server)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p <= 0 || p > 65535 {
+			return fmt.Errorf("无效的 STUN 服务器端口: %s", server)
+		}
+		if seen[server] {
+			return fmt.Errorf("STUN 服务器重复: %s", server)
+		}
+		seen[server] = true
+	}
+	return nil
+}
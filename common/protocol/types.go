@@ -0,0 +1,107 @@
+package protocol
+
+import "fmt"
+
+// NATType NAT 类型，在信令和 API 中以字符串表示
+type NATType int
+
+const (
+	NATUnknown        NATType = iota
+	NATNone                   // 无 NAT（公网 IP）
+	NATFull                   // 完全锥形 NAT（Full Cone）
+	NATRestricted             // 受限锥形 NAT（Restricted Cone）
+	NATPortRestricted         // 端口受限锥形 NAT（Port Restricted Cone）
+	NATSymmetric              // 对称型 NAT（Symmetric）
+)
+
+// natTypeNames NAT 类型的字符串表示
+var natTypeNames = map[NATType]string{
+	NATNone:           "No NAT (Public IP)",
+	NATFull:           "Full Cone NAT",
+	NATRestricted:     "Restricted Cone NAT",
+	NATPortRestricted: "Port Restricted Cone NAT",
+	NATSymmetric:      "Symmetric NAT",
+}
+
+// String 返回 NAT 类型的字符串表示
+func (t NATType) String() string {
+	if name, ok := natTypeNames[t]; ok {
+		return name
+	}
+	return "Unknown NAT Type"
+}
+
+// ParseNATType 解析 NAT 类型的字符串表示，无法识别时返回 NATUnknown
+func ParseNATType(s string) NATType {
+	for t, name := range natTypeNames {
+		if s == name {
+			return t
+		}
+	}
+	return NATUnknown
+}
+
+// MarshalText 以字符串表示编码 NAT 类型
+func (t NATType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText 解析 NAT 类型，无法识别的值解析为 NATUnknown
+func (t *NATType) UnmarshalText(text []byte) error {
+	*t = ParseNATType(string(text))
+	return nil
+}
+
+// ConnectionType 连接类型，在信令、连接记录和 API 中以字符串表示
+type ConnectionType int
+
+const (
+	ConnectionUnknown   ConnectionType = iota
+	ConnectionDirect                   // 直接连接
+	ConnectionUPnP                     // UPnP 连接
+	ConnectionHolePunch                // 打洞连接
+	ConnectionRelay                    // 中继连接
+	ConnectionLAN                      // 局域网连接，双方在同一 NAT 之后
+)
+
+// connectionTypeNames 连接类型的字符串表示
+var connectionTypeNames = map[ConnectionType]string{
+	ConnectionDirect:    "Direct",
+	ConnectionUPnP:      "UPnP",
+	ConnectionHolePunch: "Hole Punch",
+	ConnectionRelay:     "Relay",
+	ConnectionLAN:       "LAN",
+}
+
+// String 返回连接类型的字符串表示
+func (t ConnectionType) String() string {
+	if name, ok := connectionTypeNames[t]; ok {
+		return name
+	}
+	return "Unknown"
+}
+
+// ParseConnectionType 解析连接类型的字符串表示，无法识别时返回 ConnectionUnknown
+func ParseConnectionType(s string) ConnectionType {
+	for t, name := range connectionTypeNames {
+		if s == name {
+			return t
+		}
+	}
+	return ConnectionUnknown
+}
+
+// MarshalText 以字符串表示编码连接类型
+func (t ConnectionType) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText 解析连接类型，只接受已知的类型
+func (t *ConnectionType) UnmarshalText(text []byte) error {
+	parsed := ParseConnectionType(string(text))
+	if parsed == ConnectionUnknown && string(text) != "Unknown" {
+		return fmt.Errorf("未知的连接类型: %q", text)
+	}
+	*t = parsed
+	return nil
+}
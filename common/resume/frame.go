@@ -0,0 +1,71 @@
+package resume
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// 帧类型
+const (
+	frameData  byte = 1 // 数据，偏移为数据第一个字节在整个流中的位置
+	frameAck   byte = 2 // 确认，偏移为对方已读取的字节数，同时用作保活
+	frameClose byte = 3 // 关闭，偏移为发送方写入的总字节数
+)
+
+const (
+	// frameHeaderSize 帧头长度：类型 1 字节、偏移 8 字节、数据长度 4 字节
+	frameHeaderSize = 13
+	// maxFramePayload 单个数据帧的最大长度
+	maxFramePayload = 32 << 10
+)
+
+// frame 可恢复会话在底层连接上传输的帧
+type frame struct {
+	typ     byte
+	offset  uint64
+	payload []byte
+}
+
+// writeFrame 写入一帧
+func writeFrame(w io.Writer, f frame) error {
+	var header [frameHeaderSize]byte
+	header[0] = f.typ
+	binary.BigEndian.PutUint64(header[1:9], f.offset)
+	binary.BigEndian.PutUint32(header[9:13], uint32(len(f.payload)))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if len(f.payload) == 0 {
+		return nil
+	}
+	_, err := w.Write(f.payload)
+	return err
+}
+
+// readFrame 读取一帧，帧来自网络，类型和长度都需要检查
+func readFrame(r io.Reader) (frame, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{typ: header[0], offset: binary.BigEndian.Uint64(header[1:9])}
+	length := binary.BigEndian.Uint32(header[9:13])
+	switch {
+	case f.typ != frameData && f.typ != frameAck && f.typ != frameClose:
+		return frame{}, fmt.Errorf("%w: 未知的帧类型 %d", ErrProtocol, f.typ)
+	case f.typ != frameData && length != 0:
+		return frame{}, fmt.Errorf("%w: 控制帧不能携带数据", ErrProtocol)
+	case length > maxFramePayload:
+		return frame{}, fmt.Errorf("%w: 数据帧过长 (%d 字节)", ErrProtocol, length)
+	}
+
+	if length > 0 {
+		f.payload = make([]byte, length)
+		if _, err := io.ReadFull(r, f.payload); err != nil {
+			return frame{}, err
+		}
+	}
+	return f, nil
+}
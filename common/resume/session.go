@@ -0,0 +1,555 @@
+// Package resume 实现可恢复的流式会话。
+//
+// 数据在底层连接上按帧传输，每帧带有数据在整个流中的偏移。发送方保留对方尚未确认的数据（重放窗口），
+// 底层连接中断后会话在宽限期内保持，一方重新建立连接并交换双方已接收的字节数后，
+// 从对方已接收的位置重传，上层读写不受影响。
+package resume
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultWindow 默认重放窗口
+	DefaultWindow = 256 << 10
+	// DefaultGrace 默认的等待恢复时间
+	DefaultGrace = 30 * time.Second
+
+	// minWindow 和 maxWindow 重放窗口的范围。接收方读取超过 ackThreshold 字节后才确认，窗口不能小于它
+	minWindow = 64 << 10
+	maxWindow = 4 << 20
+	// maxReceiveBuffer 接收后尚未被读取的数据上限，对方不遵守重放窗口时终止会话
+	maxReceiveBuffer = maxWindow
+	// ackThreshold 读取多少字节后确认
+	ackThreshold = 16 << 10
+
+	// keepAliveInterval 空闲时发送确认帧的间隔，keepAliveTimeout 内收不到任何帧视为连接中断
+	keepAliveInterval = 10 * time.Second
+	keepAliveTimeout  = 30 * time.Second
+	// drainTimeout 写入失败或发送关闭帧后继续读取已到达数据的时间
+	drainTimeout = time.Second
+)
+
+var (
+	// ErrClosed 会话已关闭
+	ErrClosed = errors.New("会话已关闭")
+	// ErrExpired 连接中断后未在宽限期内恢复
+	ErrExpired = errors.New("会话恢复超时")
+	// ErrOffset 对方已接收的位置不在重放窗口内，无法恢复
+	ErrOffset = errors.New("对方的接收位置超出重放窗口")
+	// ErrProtocol 对方发送了无效的帧
+	ErrProtocol = errors.New("无效的会话帧")
+)
+
+// Config 可恢复会话配置
+type Config struct {
+	Window int           // 重放窗口，已发送但对方尚未确认的数据上限，单位：字节，0 表示 DefaultWindow
+	Grace  time.Duration // 底层连接中断后等待恢复的时间，0 表示 DefaultGrace
+	// OnDisconnect 底层连接中断、开始等待恢复时在独立协程中调用，发起连接的一方在此重连
+	OnDisconnect func(err error)
+}
+
+// Session 可恢复的会话，实现 net.Conn
+type Session struct {
+	cfg  Config
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	conn  net.Conn // 当前的底层连接，等待恢复期间为 nil
+	gen   int      // 底层连接每次变化时加一，旧连接的读写协程据此退出
+	grace *time.Timer
+	local net.Addr
+	peer  net.Addr
+
+	// 发送方向：replay 保存 acked 到 sent 之间的数据，其中 flushed 之前的部分已写入当前连接
+	sent    uint64
+	acked   uint64
+	flushed uint64
+	replay  []byte
+
+	// 接收方向：received 为已接收的字节数，consumed 为上层已读取的字节数
+	received uint64
+	consumed uint64
+	ackSent  uint64
+	ackDue   bool
+	readBuf  []byte
+
+	closing      bool // 本端已关闭，等待发送完剩余数据和关闭帧
+	closeSent    bool
+	remoteClosed bool
+	remoteEnd    uint64
+	err          error // 会话终止的原因
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+}
+
+// New 创建可恢复会话，需要调用 Attach 关联底层连接后才能收发数据
+func New(cfg Config) *Session {
+	if cfg.Window <= 0 {
+		cfg.Window = DefaultWindow
+	}
+	if cfg.Window < minWindow {
+		cfg.Window = minWindow
+	}
+	if cfg.Window > maxWindow {
+		cfg.Window = maxWindow
+	}
+	if cfg.Grace <= 0 {
+		cfg.Grace = DefaultGrace
+	}
+
+	s := &Session{cfg: cfg}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// Attach 关联新的底层连接。peerReceived 为对方已接收的字节数，由恢复握手交换，
+// 之后的数据从该位置重传。已有的底层连接会被关闭
+func (s *Session) Attach(conn net.Conn, peerReceived uint64) error {
+	s.mu.Lock()
+	if s.err != nil {
+		err := s.err
+		s.mu.Unlock()
+		return err
+	}
+	if peerReceived > s.sent {
+		s.mu.Unlock()
+		return ErrOffset
+	}
+	// 对方在告知接收位置之后又通过旧连接确认了更多数据
+	if peerReceived < s.acked {
+		peerReceived = s.acked
+	}
+
+	s.replay = s.replay[peerReceived-s.acked:]
+	s.acked = peerReceived
+	s.flushed = peerReceived
+	s.ackDue = true
+
+	old := s.conn
+	s.conn = conn
+	s.gen++
+	gen := s.gen
+	s.local, s.peer = conn.LocalAddr(), conn.RemoteAddr()
+	if s.grace != nil {
+		s.grace.Stop()
+		s.grace = nil
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	if old != nil {
+		old.Close()
+	}
+	// 写入失败时对方可能已发送了关闭帧，由读取协程读完已到达的数据后再判断连接中断
+	broken := make(chan struct{})
+	go s.readLoop(conn, gen, broken)
+	go s.writeLoop(conn, gen, broken)
+	go s.keepAlive(gen)
+	return nil
+}
+
+// Received 获取已接收的字节数，恢复握手时告知对方
+func (s *Session) Received() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.received
+}
+
+// Sent 获取上层已写入的字节数，包括尚未发送或尚未被对方确认的数据
+func (s *Session) Sent() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sent
+}
+
+// Connected 检查当前是否有可用的底层连接
+func (s *Session) Connected() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conn != nil
+}
+
+// Read 读取数据，底层连接中断期间阻塞直到恢复或超过宽限期
+func (s *Session) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.readBuf) == 0 {
+		switch {
+		case s.remoteClosed && s.received >= s.remoteEnd:
+			return 0, io.EOF
+		case s.err != nil:
+			return 0, s.err
+		case s.closing:
+			return 0, ErrClosed
+		}
+		if err := s.wait(s.readDeadline); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, s.readBuf)
+	s.readBuf = s.readBuf[n:]
+	s.consumed += uint64(n)
+	if s.consumed-s.ackSent >= ackThreshold {
+		s.ackDue = true
+		s.cond.Broadcast()
+	}
+	return n, nil
+}
+
+// Write 写入数据。数据先进入重放窗口，窗口已满时阻塞直到对方确认，底层连接中断期间也可以写入
+func (s *Session) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total := 0
+	for len(p) > 0 {
+		switch {
+		case s.err != nil:
+			return total, s.err
+		case s.closing || s.remoteClosed:
+			return total, ErrClosed
+		}
+
+		space := s.cfg.Window - len(s.replay)
+		if space <= 0 {
+			if err := s.wait(s.writeDeadline); err != nil {
+				return total, err
+			}
+			continue
+		}
+		if space > len(p) {
+			space = len(p)
+		}
+		s.replay = append(s.replay, p[:space]...)
+		s.sent += uint64(space)
+		p = p[space:]
+		total += space
+		s.cond.Broadcast()
+	}
+	return total, nil
+}
+
+// Close 关闭会话。剩余数据和关闭帧在后台发送，底层连接中断时恢复后继续发送，
+// 超过宽限期仍未恢复时放弃
+func (s *Session) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil || s.closing {
+		return nil
+	}
+	// 从未关联过连接，或对方已关闭
+	if s.gen == 0 || s.remoteClosed {
+		s.terminateLocked(ErrClosed)
+		return nil
+	}
+
+	s.closing = true
+	s.cond.Broadcast()
+	return nil
+}
+
+// LocalAddr 当前底层连接的本地地址
+func (s *Session) LocalAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.local
+}
+
+// RemoteAddr 当前底层连接的对端地址
+func (s *Session) RemoteAddr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.peer
+}
+
+// SetDeadline 设置读写截止时间
+func (s *Session) SetDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readDeadline, s.writeDeadline = t, t
+	s.cond.Broadcast()
+	return nil
+}
+
+// SetReadDeadline 设置读取截止时间
+func (s *Session) SetReadDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readDeadline = t
+	s.cond.Broadcast()
+	return nil
+}
+
+// SetWriteDeadline 设置写入截止时间
+func (s *Session) SetWriteDeadline(t time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeDeadline = t
+	s.cond.Broadcast()
+	return nil
+}
+
+// wait 等待会话状态变化，deadline 不为零时最多等到截止时间。调用方需持有锁
+func (s *Session) wait(deadline time.Time) error {
+	if !deadline.IsZero() {
+		d := time.Until(deadline)
+		if d <= 0 {
+			return os.ErrDeadlineExceeded
+		}
+		timer := time.AfterFunc(d, func() {
+			s.mu.Lock()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		})
+		defer timer.Stop()
+	}
+	s.cond.Wait()
+	return nil
+}
+
+// readLoop 从底层连接读取帧，直到连接中断或被替换
+func (s *Session) readLoop(conn net.Conn, gen int, broken chan struct{}) {
+	r := bufio.NewReader(conn)
+	for {
+		select {
+		case <-broken:
+		default:
+			conn.SetReadDeadline(time.Now().Add(keepAliveTimeout))
+			// 与 drain 同时发生时保留较短的截止时间
+			select {
+			case <-broken:
+				conn.SetReadDeadline(time.Now().Add(drainTimeout))
+			default:
+			}
+		}
+		f, err := readFrame(r)
+		if err != nil {
+			if errors.Is(err, ErrProtocol) {
+				s.terminate(gen, err)
+			} else {
+				s.detach(gen, err)
+			}
+			return
+		}
+		if err := s.handle(f, gen); err != nil {
+			s.terminate(gen, err)
+			return
+		}
+	}
+}
+
+// handle 处理收到的帧，旧连接上的帧被忽略
+func (s *Session) handle(f frame, gen int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.gen != gen || s.err != nil {
+		return nil
+	}
+
+	switch f.typ {
+	case frameData:
+		end := f.offset + uint64(len(f.payload))
+		if f.offset > s.received || end < f.offset {
+			return ErrProtocol
+		}
+		// 重传时已接收的部分跳过
+		if end > s.received {
+			s.readBuf = append(s.readBuf, f.payload[s.received-f.offset:]...)
+			s.received = end
+			if len(s.readBuf) > maxReceiveBuffer {
+				return ErrProtocol
+			}
+			s.cond.Broadcast()
+		}
+	case frameAck:
+		if f.offset < s.acked {
+			return nil
+		}
+		if f.offset > s.sent {
+			return ErrProtocol
+		}
+		s.replay = s.replay[f.offset-s.acked:]
+		s.acked = f.offset
+		// 恢复后对方确认了尚未重传的数据，不需要再发送
+		if s.flushed < f.offset {
+			s.flushed = f.offset
+		}
+		s.cond.Broadcast()
+	case frameClose:
+		if f.offset < s.received {
+			return ErrProtocol
+		}
+		s.remoteClosed = true
+		s.remoteEnd = f.offset
+		s.cond.Broadcast()
+	}
+	return nil
+}
+
+// writeLoop 将重放窗口中未发送的数据、确认和关闭帧写入底层连接，直到连接中断或被替换
+func (s *Session) writeLoop(conn net.Conn, gen int, broken chan struct{}) {
+	w := bufio.NewWriter(conn)
+	for {
+		s.mu.Lock()
+		for s.gen == gen && s.err == nil && s.flushed == s.sent && !s.ackDue && !s.closing {
+			s.cond.Wait()
+		}
+		if s.gen != gen || s.err != nil || s.closeSent {
+			s.mu.Unlock()
+			return
+		}
+
+		var frames []frame
+		if s.flushed < s.sent {
+			start := int(s.flushed - s.acked)
+			end := start + maxFramePayload
+			if end > len(s.replay) {
+				end = len(s.replay)
+			}
+			payload := append([]byte(nil), s.replay[start:end]...)
+			frames = append(frames, frame{typ: frameData, offset: s.flushed, payload: payload})
+			s.flushed += uint64(len(payload))
+		}
+		if s.ackDue {
+			frames = append(frames, frame{typ: frameAck, offset: s.consumed})
+			s.ackSent = s.consumed
+			s.ackDue = false
+		}
+		closing := s.closing && s.flushed == s.sent
+		if closing {
+			frames = append(frames, frame{typ: frameClose, offset: s.sent})
+		}
+		s.mu.Unlock()
+
+		conn.SetWriteDeadline(time.Now().Add(keepAliveTimeout))
+		for _, f := range frames {
+			if err := writeFrame(w, f); err != nil {
+				drain(conn, broken)
+				return
+			}
+		}
+		if err := w.Flush(); err != nil {
+			drain(conn, broken)
+			return
+		}
+		if closing {
+			s.closed(conn, gen)
+			return
+		}
+	}
+}
+
+// closed 关闭帧已发送。支持半关闭的连接等对方读完数据后断开，避免未读取的数据被丢弃
+func (s *Session) closed(conn net.Conn, gen int) {
+	s.mu.Lock()
+	if s.gen == gen {
+		s.closeSent = true
+	}
+	s.mu.Unlock()
+
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok && cw.CloseWrite() == nil {
+		time.AfterFunc(keepAliveTimeout, func() { s.terminate(gen, ErrClosed) })
+		return
+	}
+	s.terminate(gen, ErrClosed)
+}
+
+// drain 底层连接写入失败，读取协程读完已到达的数据后判断连接中断
+func drain(conn net.Conn, broken chan struct{}) {
+	close(broken)
+	conn.SetReadDeadline(time.Now().Add(drainTimeout))
+}
+
+// keepAlive 定期发送确认帧，对方据此判断连接是否仍然可用
+func (s *Session) keepAlive(gen int) {
+	ticker := time.NewTicker(keepAliveInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		if s.gen != gen || s.err != nil {
+			s.mu.Unlock()
+			return
+		}
+		s.ackDue = true
+		s.cond.Broadcast()
+		s.mu.Unlock()
+	}
+}
+
+// detach 底层连接中断，开始等待恢复。会话已关闭时直接终止
+func (s *Session) detach(gen int, cause error) {
+	s.mu.Lock()
+	if s.gen != gen || s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	if s.remoteClosed {
+		s.terminateLocked(io.EOF)
+		s.mu.Unlock()
+		return
+	}
+	if s.closeSent {
+		s.terminateLocked(ErrClosed)
+		s.mu.Unlock()
+		return
+	}
+
+	conn := s.conn
+	s.conn = nil
+	s.gen++
+	waiting := s.gen
+	s.grace = time.AfterFunc(s.cfg.Grace, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.gen == waiting {
+			s.terminateLocked(ErrExpired)
+		}
+	})
+	s.cond.Broadcast()
+	s.mu.Unlock()
+
+	conn.Close()
+	if s.cfg.OnDisconnect != nil {
+		go s.cfg.OnDisconnect(cause)
+	}
+}
+
+// terminate 终止会话，底层连接已被替换时忽略
+func (s *Session) terminate(gen int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.gen == gen {
+		s.terminateLocked(err)
+	}
+}
+
+// terminateLocked 终止会话并关闭底层连接。调用方需持有锁
+func (s *Session) terminateLocked(err error) {
+	if s.err != nil {
+		return
+	}
+	s.err = err
+	s.gen++
+	if s.conn != nil {
+		s.conn.Close()
+		s.conn = nil
+	}
+	if s.grace != nil {
+		s.grace.Stop()
+		s.grace = nil
+	}
+	s.cond.Broadcast()
+}
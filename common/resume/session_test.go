@@ -0,0 +1,145 @@
+package resume
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+)
+
+// tcpPipe 创建一对本地 TCP 连接，与 net.Pipe 不同，支持半关闭
+func tcpPipe(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	defer listener.Close()
+
+	ca, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("连接失败: %v", err)
+	}
+	cb, err := listener.Accept()
+	if err != nil {
+		t.Fatalf("接受连接失败: %v", err)
+	}
+	return ca, cb
+}
+
+// link 用新的底层连接连接两个会话，相当于一次恢复握手
+func link(t *testing.T, a, b *Session, pipe func(*testing.T) (net.Conn, net.Conn)) (net.Conn, net.Conn) {
+	t.Helper()
+	ca, cb := pipe(t)
+	aReceived, bReceived := a.Received(), b.Received()
+	if err := a.Attach(ca, bReceived); err != nil {
+		t.Fatalf("关联连接失败: %v", err)
+	}
+	if err := b.Attach(cb, aReceived); err != nil {
+		t.Fatalf("关联连接失败: %v", err)
+	}
+	return ca, cb
+}
+
+func TestResumeTransfer(t *testing.T) {
+	netPipe := func(*testing.T) (net.Conn, net.Conn) { return net.Pipe() }
+	t.Run("pipe", func(t *testing.T) { testResumeTransfer(t, netPipe) })
+	t.Run("tcp", func(t *testing.T) { testResumeTransfer(t, tcpPipe) })
+}
+
+func testResumeTransfer(t *testing.T, pipe func(*testing.T) (net.Conn, net.Conn)) {
+	a, b := New(Config{}), New(Config{})
+	ca, _ := link(t, a, b, pipe)
+
+	data := make([]byte, 2<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+	// 前一半分块写入，与连接中断交错；全部恢复后再写入剩余数据并关闭
+	relinked := make(chan struct{})
+	go func() {
+		half := len(data) / 2
+		for off := 0; off < half; off += 32 << 10 {
+			a.Write(data[off : off+32<<10])
+			time.Sleep(2 * time.Millisecond)
+		}
+		<-relinked
+		a.Write(data[half:])
+		a.Close()
+	}()
+
+	// 传输过程中多次中断底层连接后恢复
+	done := make(chan []byte)
+	go func() {
+		got, _ := io.ReadAll(b)
+		done <- got
+	}()
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		ca.Close()
+		ca, _ = link(t, a, b, pipe)
+	}
+	close(relinked)
+
+	select {
+	case got := <-done:
+		if !bytes.Equal(got, data) {
+			t.Fatalf("恢复后的数据不一致: 收到 %d 字节，期望 %d 字节", len(got), len(data))
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("传输超时")
+	}
+}
+
+func TestResumeExpired(t *testing.T) {
+	disconnected := make(chan struct{}, 1)
+	a := New(Config{Grace: 50 * time.Millisecond, OnDisconnect: func(error) { disconnected <- struct{}{} }})
+	b := New(Config{Grace: time.Minute})
+	ca, _ := link(t, a, b, tcpPipe)
+
+	ca.Close()
+	select {
+	case <-disconnected:
+	case <-time.After(time.Second):
+		t.Fatalf("连接中断后未通知")
+	}
+
+	// 等待恢复期间可以继续写入
+	if _, err := a.Write([]byte("pending")); err != nil {
+		t.Fatalf("等待恢复期间写入失败: %v", err)
+	}
+	if _, err := a.Read(make([]byte, 1)); !errors.Is(err, ErrExpired) {
+		t.Fatalf("期望 ErrExpired，实际 %v", err)
+	}
+	if err := a.Attach(ca, 0); !errors.Is(err, ErrExpired) {
+		t.Fatalf("超时后不能恢复: %v", err)
+	}
+}
+
+func TestResumeDeadline(t *testing.T) {
+	a, b := New(Config{}), New(Config{})
+	link(t, a, b, tcpPipe)
+	defer a.Close()
+
+	b.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	_, err := b.Read(make([]byte, 1))
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("期望超时错误，实际 %v", err)
+	}
+}
+
+func TestReadFrameInvalid(t *testing.T) {
+	tests := map[string]frame{
+		"未知类型":    {typ: 9},
+		"确认帧携带数据": {typ: frameAck, payload: []byte("x")},
+		"数据帧过长":   {typ: frameData, payload: make([]byte, maxFramePayload+1)},
+	}
+	for name, f := range tests {
+		var buf bytes.Buffer
+		writeFrame(&buf, f)
+		if _, err := readFrame(&buf); !errors.Is(err, ErrProtocol) {
+			t.Errorf("%s: 期望 ErrProtocol，实际 %v", name, err)
+		}
+	}
+}
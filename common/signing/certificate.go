@@ -0,0 +1,104 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// HeaderCertificate 设备证书请求头，值为 DER 编码证书的 Base64
+const HeaderCertificate = "X-Node-Certificate"
+
+// ErrMissingCertificate 缺少设备证书
+var ErrMissingCertificate = errors.New("缺少设备证书")
+
+// SignWithKey 使用设备证书的 ECDSA 私钥计算签名，签名内容与 Sign 相同，返回 Base64 编码的签名
+func SignWithKey(key crypto.Signer, method, path string, timestamp int64, nonce string, body []byte) (string, error) {
+	digest := sha256.Sum256(canonical(method, path, timestamp, nonce, body))
+	signature, err := key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// VerifyWithCertificate 使用证书的公钥验证 SignWithKey 计算的签名
+func VerifyWithCertificate(cert *x509.Certificate, method, path string, timestamp int64, nonce string, body []byte, signature string) error {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	pub, ok := cert.PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return ErrInvalidSignature
+	}
+	digest := sha256.Sum256(canonical(method, path, timestamp, nonce, body))
+	if !ecdsa.VerifyASN1(pub, digest[:], sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// SignRequestWithCertificate 为请求添加设备证书、时间戳、随机数和使用证书私钥计算的签名请求头
+func SignRequestWithCertificate(req *http.Request, certDER []byte, key crypto.Signer, body []byte) error {
+	nonce, err := NewNonce()
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+
+	signature, err := SignWithKey(key, req.Method, req.URL.Path, timestamp, nonce, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(HeaderCertificate, base64.StdEncoding.EncodeToString(certDER))
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, signature)
+	return nil
+}
+
+// RequestCertificate 返回请求头中的设备证书，证书的签发者和吊销状态由调用方验证
+func RequestCertificate(req *http.Request) ([]byte, error) {
+	value := req.Header.Get(HeaderCertificate)
+	if value == "" {
+		return nil, ErrMissingCertificate
+	}
+	der, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("设备证书编码无效")
+	}
+	return der, nil
+}
+
+// VerifyCertificateRequest 使用已验证的设备证书验证请求签名，body 为已读取的请求体
+func (v *Verifier) VerifyCertificateRequest(req *http.Request, cert *x509.Certificate, body []byte) error {
+	timestampHeader := req.Header.Get(HeaderTimestamp)
+	nonce := req.Header.Get(HeaderNonce)
+	signature := req.Header.Get(HeaderSignature)
+	if timestampHeader == "" || nonce == "" || signature == "" {
+		return ErrMissingSignature
+	}
+
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if err := VerifyWithCertificate(cert, req.Method, req.URL.Path, timestamp, nonce, body, signature); err != nil {
+		return err
+	}
+
+	return v.checkReplay(cert.Subject.CommonName, nonce, time.Unix(timestamp, 0))
+}
+
+// CheckReplay 检查时间戳是否在重放窗口内且随机数未被节点使用，用于 HTTP 以外的签名
+func (v *Verifier) CheckReplay(nodeID, nonce string, timestamp int64) error {
+	return v.checkReplay(nodeID, nonce, time.Unix(timestamp, 0))
+}
@@ -0,0 +1,69 @@
+package signing
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func newCertificate(t *testing.T, nodeID string) ([]byte, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: nodeID},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("创建证书失败: %v", err)
+	}
+	return der, key
+}
+
+func TestVerifyCertificateRequest(t *testing.T) {
+	der, key := newCertificate(t, "node")
+	_, otherKey := newCertificate(t, "other")
+	body := []byte(`{"status":"online"}`)
+	v := NewVerifier(time.Minute)
+
+	sign := func(key *ecdsa.PrivateKey) *http.Request {
+		req, _ := http.NewRequest(http.MethodPost, "http://localhost/api/v1/device/status", bytes.NewReader(body))
+		if err := SignRequestWithCertificate(req, der, key, body); err != nil {
+			t.Fatalf("签名请求失败: %v", err)
+		}
+		return req
+	}
+
+	req := sign(key)
+	certDER, err := RequestCertificate(req)
+	if err != nil || !bytes.Equal(certDER, der) {
+		t.Fatalf("读取证书失败: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(certDER)
+	if err := v.VerifyCertificateRequest(req, cert, body); err != nil {
+		t.Fatalf("验证签名失败: %v", err)
+	}
+	if err := v.VerifyCertificateRequest(req, cert, body); err != ErrReplayed {
+		t.Errorf("期望 ErrReplayed，实际 %v", err)
+	}
+
+	// 私钥与证书不匹配
+	if err := v.VerifyCertificateRequest(sign(otherKey), cert, body); err != ErrInvalidSignature {
+		t.Errorf("期望 ErrInvalidSignature，实际 %v", err)
+	}
+	// 篡改请求体
+	if err := v.VerifyCertificateRequest(sign(key), cert, []byte(`{}`)); err != ErrInvalidSignature {
+		t.Errorf("期望 ErrInvalidSignature，实际 %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package signing
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 签名请求头
+const (
+	HeaderTimestamp = "X-Node-Timestamp"
+	HeaderNonce     = "X-Node-Nonce"
+	HeaderSignature = "X-Node-Signature"
+)
+
+// DefaultWindow 默认重放窗口
+const DefaultWindow = 5 * time.Minute
+
+var (
+	// ErrMissingSignature 缺少签名
+	ErrMissingSignature = errors.New("缺少请求签名")
+	// ErrInvalidSignature 签名无效
+	ErrInvalidSignature = errors.New("请求签名无效")
+	// ErrExpired 时间戳超出重放窗口
+	ErrExpired = errors.New("请求时间戳已过期")
+	// ErrReplayed 随机数已被使用
+	ErrReplayed = errors.New("检测到重放请求")
+)
+
+// Sign 使用设备令牌计算请求签名
+//
+// 签名内容为 method、path、时间戳、随机数和请求体 SHA-256 摘要，以换行分隔。
+func Sign(token, method, path string, timestamp int64, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write(canonical(method, path, timestamp, nonce, body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// canonical 返回签名内容
+func canonical(method, path string, timestamp int64, nonce string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	return []byte(fmt.Sprintf("%s\n%s\n%d\n%s\n%s", method, path, timestamp, nonce, hex.EncodeToString(bodyHash[:])))
+}
+
+// SignRequest 为请求添加时间戳、随机数和签名请求头
+func SignRequest(req *http.Request, token string, body []byte) error {
+	nonce, err := NewNonce()
+	if err != nil {
+		return err
+	}
+	timestamp := time.Now().Unix()
+
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderNonce, nonce)
+	req.Header.Set(HeaderSignature, Sign(token, req.Method, req.URL.Path, timestamp, nonce, body))
+	return nil
+}
+
+// NewNonce 生成随机数
+func NewNonce() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成随机数失败: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Verifier 签名验证器，在重放窗口内拒绝重复的随机数
+type Verifier struct {
+	window time.Duration
+	nonces map[string]time.Time
+	mu     sync.Mutex
+}
+
+// NewVerifier 创建签名验证器
+func NewVerifier(window time.Duration) *Verifier {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	return &Verifier{
+		window: window,
+		nonces: make(map[string]time.Time),
+	}
+}
+
+// VerifyRequest 验证请求签名，body 为已读取的请求体
+func (v *Verifier) VerifyRequest(req *http.Request, nodeID, token string, body []byte) error {
+	timestampHeader := req.Header.Get(HeaderTimestamp)
+	nonce := req.Header.Get(HeaderNonce)
+	signature := req.Header.Get(HeaderSignature)
+	if timestampHeader == "" || nonce == "" || signature == "" {
+		return ErrMissingSignature
+	}
+
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	expected := Sign(token, req.Method, req.URL.Path, timestamp, nonce, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrInvalidSignature
+	}
+
+	return v.checkReplay(nodeID, nonce, time.Unix(timestamp, 0))
+}
+
+// checkReplay 检查时间戳是否在窗口内且随机数未被使用
+func (v *Verifier) checkReplay(nodeID, nonce string, timestamp time.Time) error {
+	now := time.Now()
+	if timestamp.Before(now.Add(-v.window)) || timestamp.After(now.Add(v.window)) {
+		return ErrExpired
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// 清理已超出窗口的随机数
+	for key, expiresAt := range v.nonces {
+		if now.After(expiresAt) {
+			delete(v.nonces, key)
+		}
+	}
+
+	key := nodeID + ":" + nonce
+	if _, exists := v.nonces[key]; exists {
+		return ErrReplayed
+	}
+	// 时间戳最晚在 timestamp+window 之前有效，随机数保留到此时即可
+	v.nonces[key] = timestamp.Add(v.window)
+
+	return nil
+}
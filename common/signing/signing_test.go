@@ -0,0 +1,68 @@
+package signing
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newSignedRequest(t *testing.T, token string, body []byte) *http.Request {
+	req, err := http.NewRequest(http.MethodPost, "http://localhost/api/v1/device/status", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("创建请求失败: %v", err)
+	}
+	if err := SignRequest(req, token, body); err != nil {
+		t.Fatalf("签名请求失败: %v", err)
+	}
+	return req
+}
+
+func TestVerifyRequest(t *testing.T) {
+	body := []byte(`{"status":"online","natType":"Full Cone NAT"}`)
+	v := NewVerifier(time.Minute)
+
+	req := newSignedRequest(t, "token", body)
+	if err := v.VerifyRequest(req, "node", "token", body); err != nil {
+		t.Fatalf("验证签名失败: %v", err)
+	}
+
+	// 重放同一请求
+	if err := v.VerifyRequest(req, "node", "token", body); err != ErrReplayed {
+		t.Errorf("期望 ErrReplayed，实际 %v", err)
+	}
+
+	// 篡改请求体
+	req = newSignedRequest(t, "token", body)
+	if err := v.VerifyRequest(req, "node", "token", []byte(`{"status":"online","natType":"No NAT (Public IP)"}`)); err != ErrInvalidSignature {
+		t.Errorf("期望 ErrInvalidSignature，实际 %v", err)
+	}
+
+	// 错误的令牌
+	req = newSignedRequest(t, "other", body)
+	if err := v.VerifyRequest(req, "node", "token", body); err != ErrInvalidSignature {
+		t.Errorf("期望 ErrInvalidSignature，实际 %v", err)
+	}
+
+	// 缺少签名
+	req, _ = http.NewRequest(http.MethodPost, "http://localhost/api/v1/device/status", nil)
+	if err := v.VerifyRequest(req, "node", "token", nil); err != ErrMissingSignature {
+		t.Errorf("期望 ErrMissingSignature，实际 %v", err)
+	}
+}
+
+func TestVerifyRequestExpired(t *testing.T) {
+	body := []byte(`{}`)
+	v := NewVerifier(time.Minute)
+
+	req, _ := http.NewRequest(http.MethodPost, "http://localhost/api/v1/device/status", bytes.NewReader(body))
+	timestamp := time.Now().Add(-2 * time.Minute).Unix()
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(HeaderNonce, "nonce")
+	req.Header.Set(HeaderSignature, Sign("token", req.Method, req.URL.Path, timestamp, "nonce", body))
+
+	if err := v.VerifyRequest(req, "node", "token", body); err != ErrExpired {
+		t.Errorf("期望 ErrExpired，实际 %v", err)
+	}
+}
@@ -0,0 +1,138 @@
+// Package stats 提供客户端和服务端共用的流量计数与速率计算工具。
+//
+// 时长一律通过单调时钟测量，系统时间被 NTP 校正或手动修改时不会出现负数或跳变；
+// 计数器累加在溢出时饱和，由两次采样的累计值计算增量时能识别计数器回绕和重置。
+package stats
+
+import (
+	"math"
+	"time"
+)
+
+// Add 计数器的饱和加法，结果溢出时返回 math.MaxUint64
+func Add(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
+
+// AddInt64 非负 int64 计数器的饱和加法，溢出时返回 math.MaxInt64，负数增量按 0 处理
+func AddInt64(a, b int64) int64 {
+	if b <= 0 {
+		return a
+	}
+	if a > math.MaxInt64-b {
+		return math.MaxInt64
+	}
+	return a + b
+}
+
+// Delta 计数器从 prev 到 cur 的增量。cur 小于 prev 说明计数器已被重置（如对端重启），
+// 此时重置后的累计值 cur 即为增量
+func Delta(prev, cur uint64) uint64 {
+	if cur < prev {
+		return cur
+	}
+	return cur - prev
+}
+
+// DeltaWrap 宽度为 bits 位的回绕计数器从 prev 到 cur 的增量，如 32 位的网卡计数器。
+// cur 小于 prev 时按回绕一次计算
+func DeltaWrap(prev, cur uint64, bits uint) uint64 {
+	if bits == 0 || bits >= 64 {
+		return cur - prev
+	}
+	mask := uint64(1)<<bits - 1
+	return (cur - prev) & mask
+}
+
+// Rate 计数器在 elapsed 内的平均速率（每秒），增量按 Delta 计算，elapsed 不为正时返回 0
+func Rate(prev, cur uint64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(Delta(prev, cur)) / elapsed.Seconds()
+}
+
+// Since 从 t 到现在经过的时长，不为负。t 由 time.Now 得到时按单调时钟计算；
+// 从数据库或 JSON 读出的时间不带单调时钟读数，时钟回拨导致结果为负时返回 0
+func Since(t time.Time) time.Duration {
+	if d := time.Since(t); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Seconds 时长的整秒数，负数按 0 处理
+func Seconds(d time.Duration) uint64 {
+	if d <= 0 {
+		return 0
+	}
+	return uint64(d / time.Second)
+}
+
+// Stopwatch 基于单调时钟的计时器，零值表示未开始
+type Stopwatch struct {
+	start time.Time
+}
+
+// Start 开始计时
+func Start() Stopwatch {
+	return Stopwatch{start: time.Now()}
+}
+
+// Elapsed 开始计时以来经过的时长，未开始时返回 0
+func (s Stopwatch) Elapsed() time.Duration {
+	if s.start.IsZero() {
+		return 0
+	}
+	return Since(s.start)
+}
+
+// Meter 按固定窗口计算速率。Meter 不是并发安全的，调用方需自行加锁
+type Meter struct {
+	window time.Duration
+	start  time.Time
+	count  uint64  // 当前窗口内的累计值
+	rate   float64 // 上一个窗口的速率（每秒）
+	now    func() time.Time
+}
+
+// NewMeter 创建窗口为 window 的速率计，window 不为正时使用 1 秒
+func NewMeter(window time.Duration) *Meter {
+	if window <= 0 {
+		window = time.Second
+	}
+	return &Meter{window: window, start: time.Now(), now: time.Now}
+}
+
+// Add 累计 n，当前窗口结束时按窗口的实际时长计算速率并开始新的窗口
+func (m *Meter) Add(n uint64) {
+	m.roll()
+	m.count = Add(m.count, n)
+}
+
+// Rate 当前速率（每秒）：上一个窗口的速率与当前窗口已累计部分折算速率中较大的一个，
+// 空闲超过一个窗口后为 0
+func (m *Meter) Rate() float64 {
+	m.roll()
+	current := float64(m.count) / m.window.Seconds()
+	if current > m.rate {
+		return current
+	}
+	return m.rate
+}
+
+// roll 当前窗口已结束时计算其速率并开始新的窗口
+func (m *Meter) roll() {
+	now := m.now()
+	elapsed := now.Sub(m.start)
+	if elapsed < m.window {
+		return
+	}
+	// 空闲超过一个窗口时累计值为 0，速率随之归零
+	m.rate = float64(m.count) / elapsed.Seconds()
+	m.count = 0
+	m.start = now
+}
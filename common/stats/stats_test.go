@@ -0,0 +1,108 @@
+package stats
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAdd(t *testing.T) {
+	if got := Add(1, 2); got != 3 {
+		t.Fatalf("Add(1, 2) = %d", got)
+	}
+	if got := Add(math.MaxUint64-1, 10); got != math.MaxUint64 {
+		t.Fatalf("溢出时应饱和，实际为 %d", got)
+	}
+	if got := AddInt64(math.MaxInt64-1, 10); got != math.MaxInt64 {
+		t.Fatalf("溢出时应饱和，实际为 %d", got)
+	}
+	if got := AddInt64(5, -3); got != 5 {
+		t.Fatalf("负数增量应忽略，实际为 %d", got)
+	}
+}
+
+func TestDelta(t *testing.T) {
+	tests := []struct {
+		prev, cur uint64
+		bits      uint
+		want      uint64
+	}{
+		{100, 150, 0, 50},
+		{100, 30, 0, 30}, // 计数器重置
+		{math.MaxUint32 - 9, 20, 32, 30},
+		{10, 20, 32, 10},
+		{math.MaxUint64 - 4, 5, 64, 10},
+	}
+	for _, tt := range tests {
+		var got uint64
+		if tt.bits == 0 {
+			got = Delta(tt.prev, tt.cur)
+		} else {
+			got = DeltaWrap(tt.prev, tt.cur, tt.bits)
+		}
+		if got != tt.want {
+			t.Errorf("从 %d 到 %d（%d 位）的增量为 %d，期望 %d", tt.prev, tt.cur, tt.bits, got, tt.want)
+		}
+	}
+}
+
+func TestRate(t *testing.T) {
+	if got := Rate(1000, 3000, 2*time.Second); got != 1000 {
+		t.Fatalf("速率为 %f", got)
+	}
+	if got := Rate(1000, 3000, 0); got != 0 {
+		t.Fatalf("时长为 0 时速率为 %f", got)
+	}
+	if got := Rate(1000, 3000, -time.Second); got != 0 {
+		t.Fatalf("时长为负时速率为 %f", got)
+	}
+}
+
+func TestSince(t *testing.T) {
+	// 不带单调时钟读数的未来时间，模拟时钟回拨
+	if got := Since(time.Now().Add(time.Hour).Round(0)); got != 0 {
+		t.Fatalf("时钟回拨时时长为 %s", got)
+	}
+	if got := Seconds(-time.Second); got != 0 {
+		t.Fatalf("负数时长的秒数为 %d", got)
+	}
+	if got := (Stopwatch{}).Elapsed(); got != 0 {
+		t.Fatalf("未开始计时的时长为 %s", got)
+	}
+	if got := Start().Elapsed(); got < 0 {
+		t.Fatalf("时长为 %s", got)
+	}
+}
+
+func TestMeter(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m := NewMeter(time.Second)
+	m.start = now
+	m.now = func() time.Time { return now }
+
+	m.Add(500)
+	if got := m.Rate(); got != 500 {
+		t.Fatalf("窗口内的速率为 %f", got)
+	}
+
+	now = now.Add(2 * time.Second)
+	m.Add(100)
+	if got := m.Rate(); got != 250 {
+		t.Fatalf("上一个窗口的速率为 %f", got)
+	}
+
+	// 时钟回拨不会产生负的时长
+	now = now.Add(-time.Hour)
+	if got := m.Rate(); got != 250 {
+		t.Fatalf("时钟回拨后的速率为 %f", got)
+	}
+
+	now = now.Add(time.Hour + 5*time.Second)
+	if got := m.Rate(); got != 20 {
+		t.Fatalf("空闲后的速率为 %f", got)
+	}
+	now = now.Add(5 * time.Second)
+	if got := m.Rate(); got != 0 {
+		t.Fatalf("空闲一个窗口后的速率为 %f", got)
+	}
+}
@@ -0,0 +1,128 @@
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// 构建信息，通过 ldflags 注入，例如：
+//
+//	go build -ldflags "-X github.com/senma231/p3/common/version.Version=1.2.0 -X github.com/senma231/p3/common/version.Commit=abc1234"
+var (
+	// Version 版本号
+	Version = "dev"
+	// Commit 提交哈希
+	Commit = "unknown"
+	// BuildTime 构建时间
+	BuildTime = "unknown"
+)
+
+// Info 构建信息
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"buildTime"`
+	GoVersion string `json:"goVersion"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// Get 获取当前程序的构建信息
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildTime: BuildTime,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
+
+// String 返回构建信息的单行描述
+func (i Info) String() string {
+	return fmt.Sprintf("%s (commit %s, built %s, %s, %s/%s)", i.Version, i.Commit, i.BuildTime, i.GoVersion, i.OS, i.Arch)
+}
+
+// Compare 比较两个语义化版本号，a < b 返回 -1，a == b 返回 0，a > b 返回 1。
+// 允许 v 前缀，预发布版本低于对应的正式版本；无法解析的版本（如 dev）视为最低
+func Compare(a, b string) int {
+	pa, oka := parse(a)
+	pb, okb := parse(b)
+	switch {
+	case !oka && !okb:
+		return 0
+	case !oka:
+		return -1
+	case !okb:
+		return 1
+	}
+
+	for i := 0; i < 3; i++ {
+		if pa.core[i] != pb.core[i] {
+			if pa.core[i] < pb.core[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case pa.pre == pb.pre:
+		return 0
+	case pa.pre == "":
+		return 1
+	case pb.pre == "":
+		return -1
+	case pa.pre < pb.pre:
+		return -1
+	default:
+		return 1
+	}
+}
+
+// Major 获取主版本号，无法解析时返回 -1
+func Major(v string) int {
+	p, ok := parse(v)
+	if !ok {
+		return -1
+	}
+	return p.core[0]
+}
+
+// semver 解析后的版本号
+type semver struct {
+	core [3]int
+	pre  string
+}
+
+// parse 解析形如 v1.2.3-rc.1+build 的版本号，缺省的次版本号和修订号视为 0
+func parse(v string) (semver, bool) {
+	var s semver
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexByte(v, '+'); i >= 0 {
+		v = v[:i]
+	}
+	if i := strings.IndexByte(v, '-'); i >= 0 {
+		s.pre = v[i+1:]
+		v = v[:i]
+	}
+	if v == "" {
+		return s, false
+	}
+
+	parts := strings.Split(v, ".")
+	if len(parts) > 3 {
+		return s, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return s, false
+		}
+		s.core[i] = n
+	}
+	return s, true
+}
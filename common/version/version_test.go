@@ -0,0 +1,35 @@
+package version
+
+import "testing"
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"1.2.3", "1.2.3", 0},
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2.3", "1.10.0", -1},
+		{"2.0.0", "1.9.9", 1},
+		{"1.2", "1.2.0", 0},
+		{"1.2.0-rc.1", "1.2.0", -1},
+		{"1.2.0+abc", "1.2.0", 0},
+		{"dev", "0.1.0", -1},
+		{"dev", "unknown", 0},
+	}
+
+	for _, tt := range tests {
+		if got := Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%q, %q) = %d，期望 %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestMajor(t *testing.T) {
+	if Major("v2.1.0") != 2 {
+		t.Errorf("主版本号错误: %d", Major("v2.1.0"))
+	}
+	if Major("dev") != -1 {
+		t.Errorf("无法解析的版本应返回 -1")
+	}
+}
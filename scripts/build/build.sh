@@ -2,11 +2,12 @@
 
 # 设置版本号
 VERSION=$(git describe --tags --always --dirty 2>/dev/null || echo "0.1.0")
-BUILD_TIME=$(date -u '+%Y-%m-%d %H:%M:%S')
+BUILD_TIME=$(date -u '+%Y-%m-%dT%H:%M:%SZ')
 COMMIT_HASH=$(git rev-parse --short HEAD 2>/dev/null || echo "unknown")
+VERSION_PKG="github.com/senma231/p3/common/version"
 
 # 构建标志
-LDFLAGS="-X 'main.Version=$VERSION' -X 'main.BuildTime=$BUILD_TIME' -X 'main.CommitHash=$COMMIT_HASH' -s -w"
+LDFLAGS="-X '$VERSION_PKG.Version=$VERSION' -X '$VERSION_PKG.BuildTime=$BUILD_TIME' -X '$VERSION_PKG.Commit=$COMMIT_HASH' -s -w"
 
 # 构建服务端
 echo "构建服务端..."
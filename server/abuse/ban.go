@@ -0,0 +1,239 @@
+// Package abuse 管理中继和信令的封禁以及滥用举报
+package abuse
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// banEntry 生效中的封禁及其解析后的匹配条件
+type banEntry struct {
+	ban     db.RelayBan
+	network *net.IPNet // BanIP 的网段，单个地址按 /32 或 /128 保存
+	port    int        // BanPort 的端口
+}
+
+// BanList 生效中的封禁。信令服务器在设备连接时、中继在建立会话时检查，
+// 新增封禁后通知处理函数断开已有的连接。封禁保存在仓库中，启动时加载，过期的封禁在检查时忽略
+type BanList struct {
+	repo     store.BanRepo
+	entries  []banEntry
+	handlers []func(ban *db.RelayBan)
+	mu       sync.RWMutex
+}
+
+// NewBanList 创建封禁列表，需要调用 Load 加载已有的封禁
+func NewBanList(repo store.BanRepo) *BanList {
+	return &BanList{repo: repo}
+}
+
+// Load 从仓库加载生效中的封禁
+func (l *BanList) Load() error {
+	bans, err := l.repo.List(time.Now())
+	if err != nil {
+		return err
+	}
+
+	entries := make([]banEntry, 0, len(bans))
+	for _, ban := range bans {
+		entry, err := newBanEntry(ban)
+		if err != nil {
+			logger.Warn("忽略无效的封禁 %d (%s %s): %v", ban.ID, ban.Kind, ban.Value, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	l.mu.Lock()
+	l.entries = entries
+	l.mu.Unlock()
+	logger.Info("已加载 %d 条中继封禁", len(entries))
+	return nil
+}
+
+// OnBan 添加新增封禁时的处理函数，通常用于断开被封禁对象已有的信令连接和中继会话
+func (l *BanList) OnBan(handler func(ban *db.RelayBan)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.handlers = append(l.handlers, handler)
+}
+
+// NormalizeBan 检查封禁对象并转换为规范的形式：IP 地址和网段按标准格式保存，端口去掉前导零
+func NormalizeBan(kind, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch kind {
+	case db.BanNode:
+		if value == "" || len(value) > 50 {
+			return "", errors.InvalidParam("无效的节点 ID")
+		}
+		return value, nil
+	case db.BanIP:
+		if ip := net.ParseIP(value); ip != nil {
+			return ip.String(), nil
+		}
+		if _, network, err := net.ParseCIDR(value); err == nil {
+			return network.String(), nil
+		}
+		return "", errors.InvalidParam("无效的 IP 地址或网段")
+	case db.BanPort:
+		port, err := strconv.Atoi(value)
+		if err != nil || port <= 0 || port > 65535 {
+			return "", errors.InvalidParam("无效的端口")
+		}
+		return strconv.Itoa(port), nil
+	default:
+		return "", errors.InvalidParam("不支持的封禁类型")
+	}
+}
+
+// newBanEntry 解析封禁的匹配条件
+func newBanEntry(ban db.RelayBan) (banEntry, error) {
+	value, err := NormalizeBan(ban.Kind, ban.Value)
+	if err != nil {
+		return banEntry{}, err
+	}
+
+	entry := banEntry{ban: ban}
+	switch ban.Kind {
+	case db.BanIP:
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			entry.network = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		} else {
+			_, entry.network, _ = net.ParseCIDR(value)
+		}
+	case db.BanPort:
+		entry.port, _ = strconv.Atoi(value)
+	}
+	entry.ban.Value = value
+	return entry, nil
+}
+
+// matches 检查封禁是否命中节点、地址或端口，为空的条件不参与匹配
+func (e *banEntry) matches(nodeID string, ip net.IP, port int) bool {
+	switch e.ban.Kind {
+	case db.BanNode:
+		return nodeID != "" && nodeID == e.ban.Value
+	case db.BanIP:
+		return ip != nil && e.network.Contains(ip)
+	case db.BanPort:
+		return port > 0 && port == e.port
+	}
+	return false
+}
+
+// Add 保存并启用封禁，Value 转换为规范的形式后通知处理函数
+func (l *BanList) Add(ban *db.RelayBan) error {
+	entry, err := newBanEntry(*ban)
+	if err != nil {
+		return err
+	}
+	ban.Value = entry.ban.Value
+	if err := l.repo.Create(ban); err != nil {
+		return errors.Database("保存封禁失败", err)
+	}
+	entry.ban = *ban
+
+	l.mu.Lock()
+	l.prune(time.Now())
+	l.entries = append(l.entries, entry)
+	handlers := append([]func(ban *db.RelayBan){}, l.handlers...)
+	l.mu.Unlock()
+
+	logger.Warn("已封禁 %s %s (%s): %s", ban.Kind, ban.Value, ban.Source, ban.Reason)
+	for _, handler := range handlers {
+		handler(ban)
+	}
+	return nil
+}
+
+// Remove 解除封禁
+func (l *BanList) Remove(id uint) error {
+	ban, err := l.repo.GetByID(id)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return errors.NotFound("封禁不存在")
+		}
+		return errors.Database("查询封禁失败", err)
+	}
+	if err := l.repo.Delete(id); err != nil {
+		return errors.Database("解除封禁失败", err)
+	}
+
+	l.mu.Lock()
+	for i := range l.entries {
+		if l.entries[i].ban.ID == id {
+			l.entries = append(l.entries[:i], l.entries[i+1:]...)
+			break
+		}
+	}
+	l.mu.Unlock()
+
+	logger.Info("已解除封禁 %s %s", ban.Kind, ban.Value)
+	return nil
+}
+
+// List 按创建时间倒序获取封禁，all 为 false 时只返回生效中的封禁
+func (l *BanList) List(all bool) ([]db.RelayBan, error) {
+	var activeAt time.Time
+	if !all {
+		activeAt = time.Now()
+	}
+	bans, err := l.repo.List(activeAt)
+	if err != nil {
+		return nil, errors.Database("查询封禁失败", err)
+	}
+	return bans, nil
+}
+
+// Match 返回命中节点、地址或端口的生效中的封禁，没有命中时返回 nil。为空的条件不参与匹配；
+// l 为 nil 时不封禁任何对象，独立中继没有封禁列表，由主服务器在分配中继时检查
+func (l *BanList) Match(nodeID string, ip net.IP, port int) *db.RelayBan {
+	if l == nil {
+		return nil
+	}
+	now := time.Now()
+
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	for i := range l.entries {
+		entry := &l.entries[i]
+		if entry.ban.Active(now) && entry.matches(nodeID, ip, port) {
+			ban := entry.ban
+			return &ban
+		}
+	}
+	return nil
+}
+
+// MatchAddress 按 host:port 形式的地址检查 IP 和端口的封禁
+func (l *BanList) MatchAddress(address string) *db.RelayBan {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil
+	}
+	port, _ := strconv.Atoi(portStr)
+	return l.Match("", net.ParseIP(host), port)
+}
+
+// prune 移除已过期的封禁，调用方需持有锁
+func (l *BanList) prune(now time.Time) {
+	active := l.entries[:0]
+	for _, entry := range l.entries {
+		if entry.ban.Active(now) {
+			active = append(active, entry)
+		}
+	}
+	l.entries = active
+}
@@ -0,0 +1,134 @@
+package abuse
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+func TestBanList(t *testing.T) {
+	st := store.NewMemoryStore()
+	bans := NewBanList(st.Bans)
+
+	var notified []string
+	bans.OnBan(func(ban *db.RelayBan) { notified = append(notified, ban.Value) })
+
+	for _, ban := range []*db.RelayBan{
+		{Kind: db.BanNode, Value: "node-a", Source: db.BanSourceAdmin},
+		{Kind: db.BanIP, Value: "198.51.100.0/24", Source: db.BanSourceAdmin},
+		{Kind: db.BanIP, Value: " 203.0.113.7 ", Source: db.BanSourceAdmin},
+		{Kind: db.BanPort, Value: "0025", Source: db.BanSourceAdmin, ExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		if err := bans.Add(ban); err != nil {
+			t.Fatalf("添加封禁失败: %v", err)
+		}
+	}
+	if len(notified) != 4 || notified[2] != "203.0.113.7" || notified[3] != "25" {
+		t.Fatalf("新增封禁的通知 = %v", notified)
+	}
+
+	tests := []struct {
+		nodeID string
+		ip     string
+		port   int
+		banned bool
+	}{
+		{"node-a", "", 0, true},
+		{"node-b", "192.0.2.1", 443, false},
+		{"", "198.51.100.200", 0, true},
+		{"", "203.0.113.7", 0, true},
+		{"", "203.0.113.8", 0, false},
+		{"", "", 25, true},
+	}
+	for _, tt := range tests {
+		if got := bans.Match(tt.nodeID, net.ParseIP(tt.ip), tt.port) != nil; got != tt.banned {
+			t.Errorf("Match(%q, %q, %d) = %t，期望 %t", tt.nodeID, tt.ip, tt.port, got, tt.banned)
+		}
+	}
+	if bans.MatchAddress("192.0.2.1:25") == nil || bans.MatchAddress("192.0.2.1:26") != nil {
+		t.Error("按地址检查端口封禁错误")
+	}
+
+	if err := bans.Add(&db.RelayBan{Kind: db.BanIP, Value: "not-an-ip"}); err == nil {
+		t.Error("无效的 IP 不应封禁")
+	}
+	if err := bans.Add(&db.RelayBan{Kind: db.BanPort, Value: "70000"}); err == nil {
+		t.Error("无效的端口不应封禁")
+	}
+
+	// 过期的封禁不再生效，重新加载时不加载
+	expired := &db.RelayBan{Kind: db.BanNode, Value: "node-c", Source: db.BanSourceAlert, ExpiresAt: time.Now().Add(-time.Second)}
+	if err := bans.Add(expired); err != nil {
+		t.Fatal(err)
+	}
+	if bans.Match("node-c", nil, 0) != nil {
+		t.Error("过期的封禁不应生效")
+	}
+	reloaded := NewBanList(st.Bans)
+	if err := reloaded.Load(); err != nil {
+		t.Fatalf("加载封禁失败: %v", err)
+	}
+	if reloaded.Match("node-a", nil, 0) == nil || reloaded.Match("node-c", nil, 0) != nil {
+		t.Error("重新加载的封禁错误")
+	}
+	if all, _ := bans.List(true); len(all) != 5 {
+		t.Errorf("全部封禁 %d 条，期望 5 条", len(all))
+	}
+	if active, _ := bans.List(false); len(active) != 4 {
+		t.Errorf("生效中的封禁 %d 条，期望 4 条", len(active))
+	}
+
+	ban := bans.Match("node-a", nil, 0)
+	if err := bans.Remove(ban.ID); err != nil {
+		t.Fatalf("解除封禁失败: %v", err)
+	}
+	if bans.Match("node-a", nil, 0) != nil {
+		t.Error("解除后封禁不应生效")
+	}
+	if err := bans.Remove(ban.ID); err == nil {
+		t.Error("解除不存在的封禁应返回错误")
+	}
+
+	var nilList *BanList
+	if nilList.Match("node-a", nil, 0) != nil {
+		t.Error("没有封禁列表时不应封禁")
+	}
+}
+
+func TestResolveReport(t *testing.T) {
+	st := store.NewMemoryStore()
+	service := NewService(st.Reports, NewBanList(st.Bans))
+
+	if _, err := service.SubmitReport(1, &ReportRequest{Category: "spam", Description: "垃圾邮件"}); err == nil {
+		t.Fatal("没有被举报对象时应返回错误")
+	}
+	report, err := service.SubmitReport(1, &ReportRequest{NodeID: "node-a", Category: "scan", Description: "通过中继扫描端口"})
+	if err != nil {
+		t.Fatalf("提交滥用举报失败: %v", err)
+	}
+
+	resolved, err := service.ResolveReport(9, report.ID, &ResolveRequest{
+		Status: db.ReportResolved,
+		Ban:    &BanRequest{Kind: db.BanNode, Value: "node-a", Reason: "端口扫描", Duration: 60},
+	})
+	if err != nil {
+		t.Fatalf("处理滥用举报失败: %v", err)
+	}
+	if resolved.Status != db.ReportResolved || resolved.ResolvedBy != 9 || resolved.BanID == 0 {
+		t.Fatalf("处理后的举报 = %+v", resolved)
+	}
+	ban := service.Bans().Match("node-a", nil, 0)
+	if ban == nil || ban.Source != db.BanSourceReport || ban.ReportID != report.ID || ban.ExpiresAt.IsZero() {
+		t.Fatalf("处理举报时的封禁 = %+v", ban)
+	}
+
+	if _, err := service.ResolveReport(9, report.ID, &ResolveRequest{Status: db.ReportDismissed}); err == nil {
+		t.Error("已处理的举报不应重复处理")
+	}
+	if open, _ := service.ListReports(db.ReportOpen, 0); len(open) != 0 {
+		t.Errorf("待处理的举报 = %v", open)
+	}
+}
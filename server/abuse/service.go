@@ -0,0 +1,169 @@
+package abuse
+
+import (
+	"net"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// maxBanDuration 封禁的最长时间，单位：分钟，更长的封禁应设置为永久
+const maxBanDuration = 365 * 24 * 60
+
+// BanRequest 封禁请求
+type BanRequest struct {
+	Kind   string `json:"kind" binding:"required,oneof=node ip port"`
+	Value  string `json:"value" binding:"required,max=64"`
+	Reason string `json:"reason" binding:"required,max=200,safetext" sanitize:"text"`
+	// 封禁时长，单位：分钟，0 表示永久封禁
+	Duration int `json:"duration" binding:"min=0"`
+}
+
+// ReportRequest 滥用举报请求，节点 ID 和 IP 至少填写一项
+type ReportRequest struct {
+	NodeID      string `json:"nodeId" binding:"max=50,safetext" sanitize:"text"`
+	IP          string `json:"ip" binding:"omitempty,ip"`
+	Port        int    `json:"port" binding:"min=0,max=65535"`
+	Category    string `json:"category" binding:"required,oneof=spam scan malware phishing copyright other"`
+	Description string `json:"description" binding:"required,max=1000,safetext" sanitize:"text"`
+}
+
+// ResolveRequest 处理滥用举报请求，Ban 不为空时同时设置封禁
+type ResolveRequest struct {
+	Status     string      `json:"status" binding:"required,oneof=resolved dismissed"`
+	Resolution string      `json:"resolution" binding:"max=200,safetext" sanitize:"text"`
+	Ban        *BanRequest `json:"ban"`
+}
+
+// Service 封禁和滥用举报服务
+type Service struct {
+	reports store.AbuseReportRepo
+	bans    *BanList
+}
+
+// NewService 创建封禁和滥用举报服务
+func NewService(reports store.AbuseReportRepo, bans *BanList) *Service {
+	return &Service{
+		reports: reports,
+		bans:    bans,
+	}
+}
+
+// Bans 返回封禁列表
+func (s *Service) Bans() *BanList {
+	return s.bans
+}
+
+// newBan 根据封禁请求创建封禁记录
+func newBan(req *BanRequest, source string, adminID uint) (*db.RelayBan, error) {
+	if req.Duration > maxBanDuration {
+		return nil, errors.InvalidParam("封禁时长过长，请设置为永久封禁")
+	}
+	ban := &db.RelayBan{
+		Kind:      req.Kind,
+		Value:     req.Value,
+		Reason:    req.Reason,
+		Source:    source,
+		CreatedBy: adminID,
+	}
+	if req.Duration > 0 {
+		ban.ExpiresAt = time.Now().Add(time.Duration(req.Duration) * time.Minute)
+	}
+	return ban, nil
+}
+
+// CreateBan 管理员设置封禁，立即断开被封禁对象已有的信令连接和中继会话
+func (s *Service) CreateBan(adminID uint, req *BanRequest) (*db.RelayBan, error) {
+	ban, err := newBan(req, db.BanSourceAdmin, adminID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.bans.Add(ban); err != nil {
+		return nil, err
+	}
+	return ban, nil
+}
+
+// ListBans 获取封禁，all 为 false 时只返回生效中的封禁
+func (s *Service) ListBans(all bool) ([]db.RelayBan, error) {
+	return s.bans.List(all)
+}
+
+// DeleteBan 解除封禁
+func (s *Service) DeleteBan(id uint) error {
+	return s.bans.Remove(id)
+}
+
+// SubmitReport 提交滥用举报
+func (s *Service) SubmitReport(userID uint, req *ReportRequest) (*db.AbuseReport, error) {
+	if req.NodeID == "" && req.IP == "" {
+		return nil, errors.InvalidParam("请填写被举报的节点 ID 或 IP 地址")
+	}
+
+	report := &db.AbuseReport{
+		ReporterID:  userID,
+		NodeID:      req.NodeID,
+		Port:        req.Port,
+		Category:    req.Category,
+		Description: req.Description,
+		Status:      db.ReportOpen,
+	}
+	if ip := net.ParseIP(req.IP); ip != nil {
+		report.IP = ip.String()
+	}
+	if err := s.reports.Create(report); err != nil {
+		return nil, errors.Database("保存滥用举报失败", err)
+	}
+
+	logger.Warn("收到滥用举报 %d (%s): 节点 %s，地址 %s，端口 %d", report.ID, report.Category, report.NodeID, report.IP, report.Port)
+	return report, nil
+}
+
+// ListReports 获取滥用举报，status 为空时返回所有状态
+func (s *Service) ListReports(status string, limit int) ([]db.AbuseReport, error) {
+	reports, err := s.reports.List(status, limit)
+	if err != nil {
+		return nil, errors.Database("查询滥用举报失败", err)
+	}
+	return reports, nil
+}
+
+// ResolveReport 处理滥用举报，只能处理待处理的举报。同时设置封禁时封禁失败则不更新举报
+func (s *Service) ResolveReport(adminID, reportID uint, req *ResolveRequest) (*db.AbuseReport, error) {
+	report, err := s.reports.GetByID(reportID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("滥用举报不存在")
+		}
+		return nil, errors.Database("查询滥用举报失败", err)
+	}
+	if report.Status != db.ReportOpen {
+		return nil, errors.Conflict("滥用举报已处理")
+	}
+
+	updates := map[string]interface{}{
+		"status":      req.Status,
+		"resolution":  req.Resolution,
+		"resolved_by": adminID,
+		"resolved_at": time.Now(),
+	}
+	if req.Ban != nil {
+		ban, err := newBan(req.Ban, db.BanSourceReport, adminID)
+		if err != nil {
+			return nil, err
+		}
+		ban.ReportID = report.ID
+		if err := s.bans.Add(ban); err != nil {
+			return nil, err
+		}
+		updates["ban_id"] = ban.ID
+	}
+
+	if err := s.reports.UpdateFields(report, updates); err != nil {
+		return nil, errors.Database("更新滥用举报失败", err)
+	}
+	return report, nil
+}
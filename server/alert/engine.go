@@ -0,0 +1,465 @@
+package alert
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/abuse"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/notify"
+	"github.com/senma231/p3/server/store"
+)
+
+// minPunchSamples 计算打洞成功率所需的最少连接数
+const minPunchSamples = 5
+
+// Finding 规则评估发现的告警
+type Finding struct {
+	Fingerprint string
+	Message     string
+	Value       float64
+	NodeID      string // 规则设置了自动封禁时封禁的节点，为空时不封禁
+}
+
+// Evaluator 告警规则评估函数
+type Evaluator func(rule *db.AlertRule, now time.Time) ([]Finding, error)
+
+// Engine 告警规则引擎
+type Engine struct {
+	notifier    *notify.Manager
+	alerts      store.AlertRepo
+	devices     store.DeviceRepo
+	connections store.ConnectionRepo
+	reports     store.AbuseReportRepo
+	evaluators  map[string]Evaluator
+	bans        *abuse.BanList
+	interval    time.Duration
+	stopCh      chan struct{}
+}
+
+// NewEngine 创建告警规则引擎，规则、设备及其运行状态和统计数据都通过 st 中的仓库读取
+func NewEngine(notifier *notify.Manager, st *store.Store, interval time.Duration) *Engine {
+	e := &Engine{
+		notifier:    notifier,
+		alerts:      st.Alerts,
+		devices:     st.Devices,
+		connections: st.Connections,
+		reports:     st.Reports,
+		interval:    interval,
+		stopCh:      make(chan struct{}),
+	}
+	e.evaluators = map[string]Evaluator{
+		RuleDeviceOffline:     e.evaluateDeviceOffline,
+		RuleRelayUsage:        e.evaluateRelayUsage,
+		RulePunchSuccessRate:  e.evaluatePunchSuccessRate,
+		RuleRelayLimited:      e.evaluateRelayLimited,
+		RuleUnexpectedInbound: e.evaluateUnexpectedInbound,
+		RuleAbuseReports:      e.evaluateAbuseReports,
+	}
+	return e
+}
+
+// SetBanList 设置封禁列表，规则设置了自动封禁时，告警首次触发时临时封禁滥用的设备
+func (e *Engine) SetBanList(bans *abuse.BanList) {
+	e.bans = bans
+}
+
+// Start 启动告警规则引擎
+func (e *Engine) Start() {
+	go e.loop()
+	logger.Info("告警规则引擎已启动")
+}
+
+// Stop 停止告警规则引擎
+func (e *Engine) Stop() {
+	close(e.stopCh)
+	logger.Info("告警规则引擎已停止")
+}
+
+// loop 评估循环
+func (e *Engine) loop() {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.stopCh:
+			return
+		case now := <-ticker.C:
+			e.Evaluate(now)
+		}
+	}
+}
+
+// Evaluate 评估所有启用的告警规则
+func (e *Engine) Evaluate(now time.Time) {
+	rules, err := e.alerts.ListEnabledRules()
+	if err != nil {
+		logger.Error("查询告警规则失败: %v", err)
+		return
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		evaluator, exists := e.evaluators[rule.Type]
+		if !exists {
+			continue
+		}
+
+		findings, err := evaluator(rule, now)
+		if err != nil {
+			logger.Error("评估告警规则 %d 失败: %v", rule.ID, err)
+			continue
+		}
+
+		if err := e.reconcile(rule, findings, now); err != nil {
+			logger.Error("处理告警规则 %d 失败: %v", rule.ID, err)
+		}
+	}
+}
+
+// reconcile 根据评估结果更新告警事件，相同指纹的告警只通知一次
+func (e *Engine) reconcile(rule *db.AlertRule, findings []Finding, now time.Time) error {
+	open, err := e.alerts.ListFiringEvents(rule.ID)
+	if err != nil {
+		return err
+	}
+
+	openByFingerprint := make(map[string]*db.AlertEvent, len(open))
+	for i := range open {
+		openByFingerprint[open[i].Fingerprint] = &open[i]
+	}
+
+	for _, finding := range findings {
+		event, exists := openByFingerprint[finding.Fingerprint]
+		if exists {
+			delete(openByFingerprint, finding.Fingerprint)
+		} else {
+			event = &db.AlertEvent{
+				RuleID:      rule.ID,
+				UserID:      rule.UserID,
+				Fingerprint: finding.Fingerprint,
+				Status:      StatusFiring,
+				FiredAt:     now,
+			}
+			e.autoBan(rule, &finding, now)
+		}
+		event.Message = finding.Message
+		event.Value = finding.Value
+
+		if shouldNotify(rule, event, now) {
+			if err := e.send(rule, event, false); err != nil {
+				logger.Warn("发送告警通知失败: %v", err)
+			} else {
+				event.LastNotifiedAt = now
+			}
+		}
+
+		if err := e.alerts.SaveEvent(event); err != nil {
+			return err
+		}
+	}
+
+	// 条件不再满足的告警标记为已恢复
+	for _, event := range openByFingerprint {
+		event.Status = StatusResolved
+		event.ResolvedAt = now
+
+		// 只有通知过的告警才发送恢复通知
+		if !event.LastNotifiedAt.IsZero() && !isSilenced(rule, now) {
+			if err := e.send(rule, event, true); err != nil {
+				logger.Warn("发送恢复通知失败: %v", err)
+			}
+		}
+
+		if err := e.alerts.SaveEvent(event); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// autoBan 按规则的自动封禁时长临时封禁告警指出的设备，失败时只记录日志
+func (e *Engine) autoBan(rule *db.AlertRule, finding *Finding, now time.Time) {
+	if e.bans == nil || rule.BanDuration <= 0 || finding.NodeID == "" {
+		return
+	}
+	reason := finding.Message
+	if runes := []rune(reason); len(runes) > 200 {
+		reason = string(runes[:200])
+	}
+	if err := e.bans.Add(&db.RelayBan{
+		Kind:      db.BanNode,
+		Value:     finding.NodeID,
+		Reason:    reason,
+		Source:    db.BanSourceAlert,
+		RuleID:    rule.ID,
+		ExpiresAt: now.Add(time.Duration(rule.BanDuration) * time.Minute),
+	}); err != nil {
+		logger.Warn("告警规则 %d 自动封禁设备 %s 失败: %v", rule.ID, finding.NodeID, err)
+	}
+}
+
+// send 发送告警通知
+func (e *Engine) send(rule *db.AlertRule, event *db.AlertEvent, resolved bool) error {
+	subject := fmt.Sprintf("[P3 告警] %s", rule.Name)
+	if resolved {
+		subject = fmt.Sprintf("[P3 恢复] %s", rule.Name)
+	}
+
+	return e.notifier.Send(rule.Channel, rule.Target, &notify.Message{
+		Subject: subject,
+		Body:    event.Message,
+		Data: map[string]interface{}{
+			"ruleId":      rule.ID,
+			"ruleType":    rule.Type,
+			"fingerprint": event.Fingerprint,
+			"status":      event.Status,
+			"value":       event.Value,
+			"threshold":   rule.Threshold,
+			"firedAt":     event.FiredAt,
+		},
+	})
+}
+
+// isSilenced 检查规则是否处于静默期
+func isSilenced(rule *db.AlertRule, now time.Time) bool {
+	return now.Before(rule.SilencedUntil)
+}
+
+// shouldNotify 检查告警事件是否需要发送通知
+func shouldNotify(rule *db.AlertRule, event *db.AlertEvent, now time.Time) bool {
+	if isSilenced(rule, now) {
+		return false
+	}
+
+	// 首次触发
+	if event.LastNotifiedAt.IsZero() {
+		return true
+	}
+
+	// 按重复间隔再次通知
+	if rule.RepeatInterval > 0 {
+		return now.Sub(event.LastNotifiedAt) >= time.Duration(rule.RepeatInterval)*time.Minute
+	}
+
+	return false
+}
+
+// ruleDevices 获取规则适用的设备。运行状态保存在 device_statuses 中，
+// 通过设备仓库读取才能得到最新的状态和最后在线时间
+func (e *Engine) ruleDevices(rule *db.AlertRule) ([]db.Device, error) {
+	if rule.DeviceID == 0 {
+		return e.devices.ListByUser(rule.UserID)
+	}
+
+	device, err := e.devices.GetByID(rule.DeviceID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if device.UserID != rule.UserID {
+		return nil, nil
+	}
+	return []db.Device{*device}, nil
+}
+
+// evaluateDeviceOffline 评估设备离线规则，阈值单位为分钟
+func (e *Engine) evaluateDeviceOffline(rule *db.AlertRule, now time.Time) ([]Finding, error) {
+	devices, err := e.ruleDevices(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := time.Duration(rule.Threshold * float64(time.Minute))
+
+	var findings []Finding
+	for _, device := range devices {
+		if device.Status == "online" {
+			continue
+		}
+		offline := now.Sub(device.LastSeenAt)
+		if offline < threshold {
+			continue
+		}
+		findings = append(findings, Finding{
+			Fingerprint: fmt.Sprintf("device:%d", device.ID),
+			Message:     fmt.Sprintf("设备 %s 已离线 %.0f 分钟", device.Name, offline.Minutes()),
+			Value:       offline.Minutes(),
+		})
+	}
+
+	return findings, nil
+}
+
+// evaluateRelayUsage 评估中继流量规则，阈值单位为 GB
+func (e *Engine) evaluateRelayUsage(rule *db.AlertRule, now time.Time) ([]Finding, error) {
+	deviceIDs, err := e.ruleDeviceIDs(rule)
+	if err != nil || len(deviceIDs) == 0 {
+		return nil, err
+	}
+
+	since := now.Add(-time.Duration(rule.Window) * time.Minute)
+
+	bytes, err := e.connections.TrafficByType("Relay", deviceIDs, since)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := float64(bytes) / (1 << 30)
+	if usage <= rule.Threshold {
+		return nil, nil
+	}
+
+	return []Finding{{
+		Fingerprint: "relay_usage",
+		Message:     fmt.Sprintf("最近 %d 分钟中继流量 %.2f GB，超过阈值 %.2f GB", rule.Window, usage, rule.Threshold),
+		Value:       usage,
+	}}, nil
+}
+
+// evaluatePunchSuccessRate 评估打洞成功率规则，阈值单位为百分比
+// 中继连接视为打洞失败后的回落，成功率 = 打洞连接数 / (打洞连接数 + 中继连接数)
+func (e *Engine) evaluatePunchSuccessRate(rule *db.AlertRule, now time.Time) ([]Finding, error) {
+	deviceIDs, err := e.ruleDeviceIDs(rule)
+	if err != nil || len(deviceIDs) == 0 {
+		return nil, err
+	}
+
+	since := now.Add(-time.Duration(rule.Window) * time.Minute)
+
+	punched, err := e.connections.CountByType("Hole Punch", deviceIDs, since)
+	if err != nil {
+		return nil, err
+	}
+	relayed, err := e.connections.CountByType("Relay", deviceIDs, since)
+	if err != nil {
+		return nil, err
+	}
+
+	rate, ok := successRate(punched, relayed)
+	if !ok || rate >= rule.Threshold {
+		return nil, nil
+	}
+
+	return []Finding{{
+		Fingerprint: "punch_success_rate",
+		Message:     fmt.Sprintf("最近 %d 分钟打洞成功率 %.1f%%，低于阈值 %.1f%%", rule.Window, rate, rule.Threshold),
+		Value:       rate,
+	}}, nil
+}
+
+// evaluateRelayLimited 评估中继会话限制规则，阈值为次数。
+// 中继服务器对同一设备同一类限制每分钟最多记录一次事件
+func (e *Engine) evaluateRelayLimited(rule *db.AlertRule, now time.Time) ([]Finding, error) {
+	devices, err := e.ruleDevices(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	since := now.Add(-time.Duration(rule.Window) * time.Minute)
+
+	var findings []Finding
+	for _, device := range devices {
+		count, err := e.devices.CountEvents(device.ID, db.EventRelayLimited, since)
+		if err != nil {
+			return nil, err
+		}
+		if float64(count) < rule.Threshold {
+			continue
+		}
+		findings = append(findings, Finding{
+			Fingerprint: fmt.Sprintf("relay_limited:%d", device.ID),
+			Message:     fmt.Sprintf("设备 %s 最近 %d 分钟中继会话超出限制 %d 次", device.Name, rule.Window, count),
+			Value:       float64(count),
+			NodeID:      device.NodeID,
+		})
+	}
+
+	return findings, nil
+}
+
+// evaluateUnexpectedInbound 评估意外入站连接规则，阈值为事件数。
+// 客户端将同一来源在一个上报周期内的连接合并为一个事件
+func (e *Engine) evaluateUnexpectedInbound(rule *db.AlertRule, now time.Time) ([]Finding, error) {
+	devices, err := e.ruleDevices(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	since := now.Add(-time.Duration(rule.Window) * time.Minute)
+
+	var findings []Finding
+	for _, device := range devices {
+		count, err := e.devices.CountEvents(device.ID, db.EventUnexpectedInbound, since)
+		if err != nil {
+			return nil, err
+		}
+		if float64(count) < rule.Threshold {
+			continue
+		}
+		findings = append(findings, Finding{
+			Fingerprint: fmt.Sprintf("unexpected_inbound:%d", device.ID),
+			Message:     fmt.Sprintf("设备 %s 最近 %d 分钟有 %d 个意外的入站连接事件", device.Name, rule.Window, count),
+			Value:       float64(count),
+		})
+	}
+
+	return findings, nil
+}
+
+// evaluateAbuseReports 评估滥用举报规则，阈值为举报数，已驳回的举报不计入
+func (e *Engine) evaluateAbuseReports(rule *db.AlertRule, now time.Time) ([]Finding, error) {
+	devices, err := e.ruleDevices(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	since := now.Add(-time.Duration(rule.Window) * time.Minute)
+
+	var findings []Finding
+	for _, device := range devices {
+		count, err := e.reports.CountByNode(device.NodeID, since)
+		if err != nil {
+			return nil, err
+		}
+		if float64(count) < rule.Threshold {
+			continue
+		}
+		findings = append(findings, Finding{
+			Fingerprint: fmt.Sprintf("abuse_reports:%d", device.ID),
+			Message:     fmt.Sprintf("设备 %s 最近 %d 分钟被举报滥用 %d 次", device.Name, rule.Window, count),
+			Value:       float64(count),
+			NodeID:      device.NodeID,
+		})
+	}
+
+	return findings, nil
+}
+
+// ruleDeviceIDs 获取规则适用的设备 ID
+func (e *Engine) ruleDeviceIDs(rule *db.AlertRule) ([]uint, error) {
+	devices, err := e.ruleDevices(rule)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]uint, 0, len(devices))
+	for _, device := range devices {
+		ids = append(ids, device.ID)
+	}
+	return ids, nil
+}
+
+// successRate 计算打洞成功率，样本不足时返回 false
+func successRate(punched, relayed int64) (float64, bool) {
+	total := punched + relayed
+	if total < minPunchSamples {
+		return 0, false
+	}
+	return float64(punched) / float64(total) * 100, true
+}
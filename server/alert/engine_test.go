@@ -0,0 +1,121 @@
+package alert
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/abuse"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+func TestShouldNotify(t *testing.T) {
+	now := time.Now()
+
+	// 首次触发需要通知
+	rule := &db.AlertRule{}
+	event := &db.AlertEvent{}
+	if !shouldNotify(rule, event, now) {
+		t.Error("首次触发的告警应该通知")
+	}
+
+	// 已通知且未设置重复间隔时不再通知
+	event.LastNotifiedAt = now.Add(-time.Hour)
+	if shouldNotify(rule, event, now) {
+		t.Error("已通知的告警不应重复通知")
+	}
+
+	// 超过重复间隔时再次通知
+	rule.RepeatInterval = 30
+	if !shouldNotify(rule, event, now) {
+		t.Error("超过重复间隔的告警应该再次通知")
+	}
+
+	// 静默期间不通知
+	rule.SilencedUntil = now.Add(time.Hour)
+	if shouldNotify(rule, &db.AlertEvent{}, now) {
+		t.Error("静默期间不应发送通知")
+	}
+}
+
+func TestSuccessRate(t *testing.T) {
+	if _, ok := successRate(1, 1); ok {
+		t.Error("样本不足时不应计算成功率")
+	}
+
+	rate, ok := successRate(3, 7)
+	if !ok {
+		t.Fatal("样本充足时应计算成功率")
+	}
+	if rate != 30 {
+		t.Errorf("成功率错误，期望 30，实际 %.1f", rate)
+	}
+}
+
+func TestAutoBan(t *testing.T) {
+	now := time.Now()
+	bans := abuse.NewBanList(store.NewMemoryStore().Bans)
+	engine := NewEngine(nil, store.NewMemoryStore(), time.Minute)
+	engine.SetBanList(bans)
+
+	// 未设置自动封禁时不封禁
+	rule := &db.AlertRule{Type: RuleRelayLimited}
+	rule.ID = 3
+	finding := &Finding{Message: "设备 a 最近 10 分钟中继会话超出限制 5 次", NodeID: "node-a"}
+	engine.autoBan(rule, finding, now)
+	if bans.Match("node-a", nil, 0) != nil {
+		t.Fatal("未设置自动封禁时不应封禁")
+	}
+
+	rule.BanDuration = 30
+	engine.autoBan(rule, finding, now)
+	ban := bans.Match("node-a", nil, 0)
+	if ban == nil || ban.Source != db.BanSourceAlert || ban.RuleID != 3 || !ban.ExpiresAt.Equal(now.Add(30*time.Minute)) {
+		t.Fatalf("自动封禁 = %+v", ban)
+	}
+
+	if err := validateRule(&db.AlertRule{Type: RuleDeviceOffline, Threshold: 1, Window: 10, BanDuration: 30}); err == nil {
+		t.Error("设备离线规则不应支持自动封禁")
+	}
+}
+
+func TestDeviceOfflineRule(t *testing.T) {
+	now := time.Now()
+	st := store.NewMemoryStore()
+	engine := NewEngine(nil, st, time.Minute)
+
+	device := &db.Device{UserID: 1, Name: "nas", NodeID: "node-a"}
+	if err := st.Devices.Create(device); err != nil {
+		t.Fatalf("创建设备失败: %v", err)
+	}
+	if err := st.Devices.UpdateFields(device, map[string]interface{}{"status": "online", "last_seen_at": now}); err != nil {
+		t.Fatalf("更新设备状态失败: %v", err)
+	}
+
+	rule := &db.AlertRule{UserID: 1, Type: RuleDeviceOffline, Threshold: 10}
+	evaluate := engine.evaluators[RuleDeviceOffline]
+	if findings, err := evaluate(rule, now); err != nil || len(findings) != 0 {
+		t.Fatalf("在线的设备不应告警: %v %v", findings, err)
+	}
+
+	// 通过仓库更新的运行状态在下次评估时生效
+	if err := st.Devices.UpdateFields(device, map[string]interface{}{"status": "offline", "last_seen_at": now.Add(-20 * time.Minute)}); err != nil {
+		t.Fatalf("更新设备状态失败: %v", err)
+	}
+	findings, err := evaluate(rule, now)
+	if err != nil || len(findings) != 1 || findings[0].Fingerprint != fmt.Sprintf("device:%d", device.ID) {
+		t.Fatalf("离线超过阈值的设备应告警: %v %v", findings, err)
+	}
+
+	// 离线未超过阈值、其他用户的设备和按设备 ID 指定的规则
+	if findings, _ := evaluate(&db.AlertRule{UserID: 1, Type: RuleDeviceOffline, Threshold: 30}, now); len(findings) != 0 {
+		t.Fatalf("离线未超过阈值时不应告警: %v", findings)
+	}
+	if findings, _ := evaluate(&db.AlertRule{UserID: 2, DeviceID: device.ID, Type: RuleDeviceOffline, Threshold: 10}, now); len(findings) != 0 {
+		t.Fatalf("其他用户的设备不应告警: %v", findings)
+	}
+	if findings, _ := evaluate(&db.AlertRule{UserID: 1, DeviceID: device.ID, Type: RuleDeviceOffline, Threshold: 10}, now); len(findings) != 1 {
+		t.Fatalf("指定设备的规则应告警: %v", findings)
+	}
+}
@@ -0,0 +1,299 @@
+package alert
+
+import (
+	"context"
+	"net/mail"
+	"net/url"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/notify"
+	"github.com/senma231/p3/server/store"
+)
+
+// 告警规则类型
+const (
+	RuleDeviceOffline     = "device_offline"     // 设备离线超过 N 分钟
+	RuleRelayUsage        = "relay_usage"        // 窗口内中继流量超过 X GB
+	RulePunchSuccessRate  = "punch_success_rate" // 窗口内打洞成功率低于 Y%
+	RuleRelayLimited      = "relay_limited"      // 窗口内中继会话超出限制的次数达到 N 次
+	RuleUnexpectedInbound = "unexpected_inbound" // 窗口内应用收到意外入站连接的事件达到 N 个
+	RuleAbuseReports      = "abuse_reports"      // 窗口内针对设备的滥用举报达到 N 个
+)
+
+// maxBanDuration 自动封禁的最长时间，单位：分钟
+const maxBanDuration = 7 * 24 * 60
+
+// 告警事件状态
+const (
+	StatusFiring   = db.AlertFiring
+	StatusResolved = db.AlertResolved
+)
+
+// DefaultWindow 默认统计窗口，单位：分钟
+const DefaultWindow = 24 * 60
+
+// Service 告警服务
+type Service struct {
+	notifier *notify.Manager
+	st       *store.Store
+	alerts   store.AlertRepo
+	devices  store.DeviceRepo
+}
+
+// NewService 创建告警服务，notifier 用于检查规则的通知渠道是否可用
+func NewService(notifier *notify.Manager, st *store.Store) *Service {
+	return &Service{
+		notifier: notifier,
+		st:       st,
+		alerts:   st.Alerts,
+		devices:  st.Devices,
+	}
+}
+
+// ForTenant 返回只能访问 ctx 中租户数据的告警服务，其他租户的告警规则、事件和设备视为不存在
+func (s *Service) ForTenant(ctx context.Context) (*Service, error) {
+	scoped, err := s.st.ForContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	svc := *s
+	svc.alerts = scoped.Alerts
+	svc.devices = scoped.Devices
+	return &svc, nil
+}
+
+// RuleRequest 告警规则请求
+type RuleRequest struct {
+	Name           string  `json:"name" binding:"required,max=100,safetext" sanitize:"text"`
+	Type           string  `json:"type" binding:"required"`
+	DeviceID       uint    `json:"deviceId"`
+	Threshold      float64 `json:"threshold" binding:"required"`
+	Window         int     `json:"window"`
+	Channel        string  `json:"channel" binding:"required"`
+	Target         string  `json:"target" binding:"required,max=255,safetext" sanitize:"text"`
+	RepeatInterval int     `json:"repeatInterval"`
+	BanDuration    int     `json:"banDuration"`
+}
+
+// RuleUpdateRequest 告警规则更新请求
+type RuleUpdateRequest struct {
+	Name           string   `json:"name" binding:"omitempty,max=100,safetext" sanitize:"text"`
+	Threshold      *float64 `json:"threshold"`
+	Window         int      `json:"window"`
+	Channel        string   `json:"channel"`
+	Target         string   `json:"target" binding:"omitempty,max=255,safetext" sanitize:"text"`
+	RepeatInterval *int     `json:"repeatInterval"`
+	Enabled        *bool    `json:"enabled"`
+	BanDuration    *int     `json:"banDuration"`
+}
+
+// GetRules 获取用户的所有告警规则
+func (s *Service) GetRules(userID uint) ([]db.AlertRule, error) {
+	rules, err := s.alerts.ListRules(userID)
+	if err != nil {
+		return nil, errors.Database("查询告警规则失败", err)
+	}
+	return rules, nil
+}
+
+// GetRule 获取告警规则详情
+func (s *Service) GetRule(userID uint, ruleID uint) (*db.AlertRule, error) {
+	rule, err := s.alerts.GetRule(ruleID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("告警规则不存在")
+		}
+		return nil, errors.Database("查询告警规则失败", err)
+	}
+	if rule.UserID != userID {
+		return nil, errors.NotFound("告警规则不存在")
+	}
+	return rule, nil
+}
+
+// CreateRule 创建告警规则
+func (s *Service) CreateRule(userID uint, req *RuleRequest) (*db.AlertRule, error) {
+	rule := &db.AlertRule{
+		UserID:         userID,
+		Name:           req.Name,
+		Type:           req.Type,
+		DeviceID:       req.DeviceID,
+		Threshold:      req.Threshold,
+		Window:         req.Window,
+		Channel:        req.Channel,
+		Target:         req.Target,
+		RepeatInterval: req.RepeatInterval,
+		Enabled:        true,
+		BanDuration:    req.BanDuration,
+	}
+	if rule.Window == 0 {
+		rule.Window = DefaultWindow
+	}
+
+	if err := validateRule(rule); err != nil {
+		return nil, err
+	}
+	if err := s.notifier.CheckTarget(rule.Channel, rule.Target); err != nil {
+		return nil, errors.InvalidParam(err.Error())
+	}
+
+	// 检查设备是否属于当前用户
+	if rule.DeviceID != 0 {
+		if err := s.checkDevice(userID, rule.DeviceID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.alerts.CreateRule(rule); err != nil {
+		return nil, errors.Database("创建告警规则失败", err)
+	}
+
+	return rule, nil
+}
+
+// UpdateRule 更新告警规则
+func (s *Service) UpdateRule(userID uint, ruleID uint, req *RuleUpdateRequest) (*db.AlertRule, error) {
+	rule, err := s.GetRule(userID, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Name != "" {
+		rule.Name = req.Name
+	}
+	if req.Threshold != nil {
+		rule.Threshold = *req.Threshold
+	}
+	if req.Window != 0 {
+		rule.Window = req.Window
+	}
+	if req.Channel != "" {
+		rule.Channel = req.Channel
+	}
+	if req.Target != "" {
+		rule.Target = req.Target
+	}
+	if req.RepeatInterval != nil {
+		rule.RepeatInterval = *req.RepeatInterval
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if req.BanDuration != nil {
+		rule.BanDuration = *req.BanDuration
+	}
+
+	if err := validateRule(rule); err != nil {
+		return nil, err
+	}
+	if err := s.notifier.CheckTarget(rule.Channel, rule.Target); err != nil {
+		return nil, errors.InvalidParam(err.Error())
+	}
+
+	if err := s.alerts.SaveRule(rule); err != nil {
+		return nil, errors.Database("更新告警规则失败", err)
+	}
+
+	return rule, nil
+}
+
+// DeleteRule 删除告警规则
+func (s *Service) DeleteRule(userID uint, ruleID uint) error {
+	rule, err := s.GetRule(userID, ruleID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.alerts.DeleteRule(rule.ID); err != nil {
+		return errors.Database("删除告警规则失败", err)
+	}
+
+	return nil
+}
+
+// SilenceRule 在指定时间内静默告警规则，静默期间仍记录事件但不发送通知
+func (s *Service) SilenceRule(userID uint, ruleID uint, until time.Time) (*db.AlertRule, error) {
+	rule, err := s.GetRule(userID, ruleID)
+	if err != nil {
+		return nil, err
+	}
+
+	rule.SilencedUntil = until
+	if err := s.alerts.SaveRule(rule); err != nil {
+		return nil, errors.Database("更新告警规则失败", err)
+	}
+
+	return rule, nil
+}
+
+// GetEvents 获取用户的告警事件，status 为空时返回所有状态
+func (s *Service) GetEvents(userID uint, status string, limit int) ([]db.AlertEvent, error) {
+	events, err := s.alerts.ListEvents(userID, status, limit)
+	if err != nil {
+		return nil, errors.Database("查询告警事件失败", err)
+	}
+	return events, nil
+}
+
+// checkDevice 检查设备是否属于用户
+func (s *Service) checkDevice(userID uint, deviceID uint) error {
+	device, err := s.devices.GetByID(deviceID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return errors.NotFound("设备不存在")
+		}
+		return errors.Database("查询设备失败", err)
+	}
+	if device.UserID != userID {
+		return errors.NotFound("设备不存在")
+	}
+	return nil
+}
+
+// validateRule 验证告警规则
+func validateRule(rule *db.AlertRule) error {
+	switch rule.Type {
+	case RuleDeviceOffline, RuleRelayUsage, RuleRelayLimited, RuleUnexpectedInbound, RuleAbuseReports:
+		if rule.Threshold <= 0 {
+			return errors.InvalidParam("告警阈值必须大于 0")
+		}
+	case RulePunchSuccessRate:
+		if rule.Threshold <= 0 || rule.Threshold > 100 {
+			return errors.InvalidParam("打洞成功率阈值必须在 0 到 100 之间")
+		}
+	default:
+		return errors.InvalidParam("不支持的告警规则类型")
+	}
+
+	if rule.Window <= 0 {
+		return errors.InvalidParam("统计窗口必须大于 0")
+	}
+	if rule.RepeatInterval < 0 {
+		return errors.InvalidParam("重复通知间隔不能为负数")
+	}
+	// 只有指明滥用设备的规则才能自动封禁
+	if rule.BanDuration < 0 || rule.BanDuration > maxBanDuration {
+		return errors.InvalidParam("自动封禁时长必须在 0 到 7 天之间")
+	}
+	if rule.BanDuration > 0 && rule.Type != RuleRelayLimited && rule.Type != RuleAbuseReports {
+		return errors.InvalidParam("该类型的告警规则不支持自动封禁")
+	}
+
+	switch rule.Channel {
+	case notify.ChannelWebhook:
+		u, err := url.Parse(rule.Target)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return errors.InvalidParam("无效的 Webhook 地址")
+		}
+	case notify.ChannelEmail:
+		if _, err := mail.ParseAddress(rule.Target); err != nil {
+			return errors.InvalidParam("无效的邮箱地址")
+		}
+	default:
+		return errors.InvalidParam("不支持的通知渠道")
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/abuse"
+	"github.com/senma231/p3/server/auth"
+)
+
+// AbuseController 封禁和滥用举报控制器
+type AbuseController struct {
+	abuseService *abuse.Service
+}
+
+// NewAbuseController 创建封禁和滥用举报控制器
+func NewAbuseController(abuseService *abuse.Service) *AbuseController {
+	return &AbuseController{
+		abuseService: abuseService,
+	}
+}
+
+// GetBans 获取封禁，all=true 时包括已过期的封禁
+func (c *AbuseController) GetBans(ctx *gin.Context) {
+	bans, err := c.abuseService.ListBans(ctx.Query("all") == "true")
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"bans": bans,
+	})
+}
+
+// CreateBan 封禁节点、IP 地址或网段、目标端口
+func (c *AbuseController) CreateBan(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	var req abuse.BanRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	ban, err := c.abuseService.CreateBan(userID, &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, ban)
+}
+
+// DeleteBan 解除封禁
+func (c *AbuseController) DeleteBan(ctx *gin.Context) {
+	banID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "ban.invalidID"),
+		})
+		return
+	}
+
+	if err := c.abuseService.DeleteBan(uint(banID)); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "封禁已解除",
+	})
+}
+
+// SubmitReport 提交滥用举报
+func (c *AbuseController) SubmitReport(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	var req abuse.ReportRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	report, err := c.abuseService.SubmitReport(userID, &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, report)
+}
+
+// GetReports 获取滥用举报，支持 status 和 limit 查询参数
+func (c *AbuseController) GetReports(ctx *gin.Context) {
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalidLimit"),
+		})
+		return
+	}
+
+	reports, err := c.abuseService.ListReports(ctx.Query("status"), limit)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"reports": reports,
+	})
+}
+
+// ResolveReport 处理滥用举报，可同时封禁被举报的对象
+func (c *AbuseController) ResolveReport(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	reportID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "report.invalidID"),
+		})
+		return
+	}
+
+	var req abuse.ResolveRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	report, err := c.abuseService.ResolveReport(userID, uint(reportID), &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
+// RegisterAbuseRoutes 注册封禁和滥用举报路由，登录用户可以提交举报，封禁和处理举报需要中继管理权限
+func RegisterAbuseRoutes(router *gin.Engine, authService *auth.Service, abuseService *abuse.Service) {
+	abuseController := NewAbuseController(abuseService)
+
+	relay := router.Group("/api/v1/relay")
+	relay.Use(AuthMiddleware(authService))
+	{
+		relay.GET("/bans", RequireScopes(auth.ScopeRelayAdmin), abuseController.GetBans)
+		relay.POST("/bans", RequireScopes(auth.ScopeRelayAdmin), abuseController.CreateBan)
+		relay.DELETE("/bans/:id", RequireScopes(auth.ScopeRelayAdmin), abuseController.DeleteBan)
+	}
+
+	reports := router.Group("/api/v1/abuse/reports")
+	reports.Use(AuthMiddleware(authService))
+	{
+		reports.POST("", abuseController.SubmitReport)
+		reports.GET("", RequireScopes(auth.ScopeRelayAdmin), abuseController.GetReports)
+		reports.PUT("/:id", RequireScopes(auth.ScopeRelayAdmin), abuseController.ResolveReport)
+	}
+}
@@ -0,0 +1,252 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/alert"
+)
+
+// AlertController 告警控制器
+type AlertController struct {
+	alertService *alert.Service
+}
+
+// NewAlertController 创建告警控制器
+func NewAlertController(alertService *alert.Service) *AlertController {
+	return &AlertController{
+		alertService: alertService,
+	}
+}
+
+// tenantService 返回限定为当前请求租户的告警服务，其他租户的数据对其不可见
+func (c *AlertController) tenantService(ctx *gin.Context) (*alert.Service, bool) {
+	svc, err := c.alertService.ForTenant(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return nil, false
+	}
+	return svc, true
+}
+
+// GetRules 获取告警规则列表
+func (c *AlertController) GetRules(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	rules, err := svc.GetRules(userID)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"rules": rules,
+	})
+}
+
+// GetRule 获取告警规则详情
+func (c *AlertController) GetRule(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	ruleID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "alert.invalidID"),
+		})
+		return
+	}
+
+	rule, err := svc.GetRule(userID, uint(ruleID))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rule)
+}
+
+// CreateRule 创建告警规则
+func (c *AlertController) CreateRule(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	var req alert.RuleRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	rule, err := svc.CreateRule(userID, &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, rule)
+}
+
+// UpdateRule 更新告警规则
+func (c *AlertController) UpdateRule(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	ruleID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "alert.invalidID"),
+		})
+		return
+	}
+
+	var req alert.RuleUpdateRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	rule, err := svc.UpdateRule(userID, uint(ruleID), &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rule)
+}
+
+// DeleteRule 删除告警规则
+func (c *AlertController) DeleteRule(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	ruleID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "alert.invalidID"),
+		})
+		return
+	}
+
+	if err := svc.DeleteRule(userID, uint(ruleID)); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "告警规则已删除",
+	})
+}
+
+// SilenceRule 静默告警规则
+func (c *AlertController) SilenceRule(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	ruleID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "alert.invalidID"),
+		})
+		return
+	}
+
+	var req struct {
+		Minutes int `json:"minutes" binding:"required,min=1"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	until := time.Now().Add(time.Duration(req.Minutes) * time.Minute)
+	rule, err := svc.SilenceRule(userID, uint(ruleID), until)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rule)
+}
+
+// UnsilenceRule 取消告警规则静默
+func (c *AlertController) UnsilenceRule(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	ruleID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "alert.invalidID"),
+		})
+		return
+	}
+
+	rule, err := svc.SilenceRule(userID, uint(ruleID), time.Time{})
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rule)
+}
+
+// GetEvents 获取告警事件
+func (c *AlertController) GetEvents(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalidLimit"),
+		})
+		return
+	}
+
+	events, err := svc.GetEvents(userID, ctx.Query("status"), limit)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"events": events,
+	})
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/api/middleware"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/device"
+)
+
+// AliasController 对等节点别名控制器
+type AliasController struct {
+	deviceService *device.Service
+}
+
+// NewAliasController 创建对等节点别名控制器
+func NewAliasController(deviceService *device.Service) *AliasController {
+	return &AliasController{
+		deviceService: deviceService,
+	}
+}
+
+// GetAliases 获取别名列表
+func (c *AliasController) GetAliases(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	aliases, err := c.deviceService.GetAliases(userID)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"aliases": aliases,
+	})
+}
+
+// CreateAlias 为对等节点设置别名
+func (c *AliasController) CreateAlias(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	var req device.AliasRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	alias, err := c.deviceService.CreateAlias(userID, &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, alias)
+}
+
+// DeleteAlias 删除别名
+func (c *AliasController) DeleteAlias(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	if err := c.deviceService.DeleteAlias(userID, ctx.Param("name")); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "别名已删除",
+	})
+}
+
+// Resolve 把别名、节点 ID 或设备名称解析为节点 ID。用户和设备都可以调用，设备按其所属用户的别名解析
+func (c *AliasController) Resolve(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	resolved, err := c.deviceService.ResolvePeer(userID, ctx.Query("name"))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, resolved)
+}
+
+// RegisterAliasRoutes 注册对等节点别名路由。客户端使用设备认证调用 /api/v1/peers/resolve 解析本地配置中的别名
+func RegisterAliasRoutes(router *gin.Engine, authService *auth.Service, deviceService *device.Service) {
+	aliasController := NewAliasController(deviceService)
+
+	aliases := router.Group("/api/v1/aliases")
+	aliases.Use(AuthMiddleware(authService))
+	{
+		aliases.GET("", RequireScopes(auth.ScopeDevicesRead), aliasController.GetAliases)
+		aliases.POST("", RequireScopes(auth.ScopeDevicesWrite), aliasController.CreateAlias)
+		aliases.GET("/resolve", RequireScopes(auth.ScopeDevicesRead), aliasController.Resolve)
+		aliases.DELETE("/:name", RequireScopes(auth.ScopeDevicesWrite), aliasController.DeleteAlias)
+	}
+
+	peers := router.Group("/api/v1/peers")
+	peers.Use(middleware.DeviceAuth(deviceService))
+	{
+		peers.GET("/resolve", aliasController.Resolve)
+	}
+}
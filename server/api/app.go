@@ -1,47 +1,81 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/senma231/p3/server/app"
+	"github.com/senma231/p3/server/device"
+	"github.com/senma231/p3/server/store"
 )
 
 // AppController 应用控制器
 type AppController struct {
-	appService *app.Service
+	appService    *app.Service
+	deviceService *device.Service
 }
 
 // NewAppController 创建应用控制器
-func NewAppController(appService *app.Service) *AppController {
+func NewAppController(appService *app.Service, deviceService *device.Service) *AppController {
 	return &AppController{
-		appService: appService,
+		appService:    appService,
+		deviceService: deviceService,
 	}
 }
 
+// tenantService 返回限定为当前请求租户的应用服务，其他租户的数据对其不可见
+func (c *AppController) tenantService(ctx *gin.Context) (*app.Service, bool) {
+	svc, err := c.appService.ForTenant(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return nil, false
+	}
+	return svc, true
+}
+
+// resolvePeer 把请求中的对等节点（别名、节点 ID 或设备名称）解析为节点 ID，应用中始终保存节点 ID。
+// 解析失败时写入错误响应
+func (c *AppController) resolvePeer(ctx *gin.Context, userID uint, peer string) (string, bool) {
+	resolved, err := c.deviceService.ResolvePeer(userID, peer)
+	if err != nil {
+		respondError(ctx, err)
+		return "", false
+	}
+	return resolved.NodeID, true
+}
+
 // GetApps 获取应用列表
 func (c *AppController) GetApps(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
 
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
 	deviceID := ctx.Query("deviceId")
 	if deviceID != "" {
 		// 获取指定设备的应用
 		id, err := strconv.ParseUint(deviceID, 10, 64)
 		if err != nil {
 			ctx.JSON(http.StatusBadRequest, gin.H{
-				"error": "无效的设备 ID",
+				"error": tr(ctx, "device.invalidID"),
 			})
 			return
 		}
 
-		apps, err := c.appService.GetAppsByDeviceID(uint(id))
+		apps, err := svc.GetAppsByDeviceID(uint(id))
 		if err != nil {
 			ctx.JSON(http.StatusInternalServerError, gin.H{
 				"error": err.Error(),
@@ -56,7 +90,7 @@ func (c *AppController) GetApps(ctx *gin.Context) {
 	}
 
 	// 获取用户的所有应用
-	apps, err := c.appService.GetAppsByUserID(userID.(uint))
+	apps, err := svc.GetAppsByUserID(userID.(uint))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -74,20 +108,25 @@ func (c *AppController) GetApp(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
 
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
 	appID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的应用 ID",
+			"error": tr(ctx, "app.invalidID"),
 		})
 		return
 	}
 
-	app, err := c.appService.GetAppByID(uint(appID))
+	app, err := svc.GetAppByID(uint(appID))
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
@@ -98,11 +137,12 @@ func (c *AppController) GetApp(ctx *gin.Context) {
 	// 检查应用是否属于当前用户
 	if app.UserID != userID.(uint) {
 		ctx.JSON(http.StatusForbidden, gin.H{
-			"error": "无权访问该应用",
+			"error": tr(ctx, "app.noAccess"),
 		})
 		return
 	}
 
+	setETag(ctx, app.Revision)
 	ctx.JSON(http.StatusOK, app)
 }
 
@@ -111,48 +151,60 @@ func (c *AppController) CreateApp(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
 
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
 	var req struct {
 		DeviceID    uint   `json:"deviceId" binding:"required"`
-		Name        string `json:"name" binding:"required"`
+		Name        string `json:"name" binding:"required,max=50,safetext" sanitize:"text"`
 		Protocol    string `json:"protocol" binding:"required"`
 		SrcPort     int    `json:"srcPort" binding:"required"`
-		PeerNode    string `json:"peerNode" binding:"required"`
+		PeerNode    string `json:"peerNode" binding:"required,max=50,safetext" sanitize:"text"`
 		DstPort     int    `json:"dstPort" binding:"required"`
-		DstHost     string `json:"dstHost" binding:"required"`
-		Description string `json:"description"`
+		DstHost     string `json:"dstHost" binding:"required,max=50,safetext" sanitize:"text"`
+		Description string `json:"description" binding:"max=200,safemultiline" sanitize:"multiline"`
 	}
 
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
-		})
+	if !bindJSON(ctx, &req) {
 		return
 	}
 
-	app, err := c.appService.CreateApp(
+	peerNode, ok := c.resolvePeer(ctx, userID.(uint), req.PeerNode)
+	if !ok {
+		return
+	}
+
+	createdApp, err := svc.CreateApp(
 		userID.(uint),
 		req.DeviceID,
 		req.Name,
 		req.Protocol,
 		req.SrcPort,
-		req.PeerNode,
+		peerNode,
 		req.DstPort,
 		req.DstHost,
 		req.Description,
 	)
 	if err != nil {
+		// 端口冲突按错误码返回 409
+		if errors.Is(err, app.ErrPortInUse) {
+			respondError(ctx, err)
+			return
+		}
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
-	ctx.JSON(http.StatusCreated, app)
+	ctx.JSON(http.StatusCreated, createdApp)
 }
 
 // UpdateApp 更新应用
@@ -160,20 +212,25 @@ func (c *AppController) UpdateApp(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
 
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
 	appID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的应用 ID",
+			"error": tr(ctx, "app.invalidID"),
 		})
 		return
 	}
 
-	app, err := c.appService.GetAppByID(uint(appID))
+	existingApp, err := svc.GetAppByID(uint(appID))
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
@@ -182,26 +239,32 @@ func (c *AppController) UpdateApp(ctx *gin.Context) {
 	}
 
 	// 检查应用是否属于当前用户
-	if app.UserID != userID.(uint) {
+	if existingApp.UserID != userID.(uint) {
 		ctx.JSON(http.StatusForbidden, gin.H{
-			"error": "无权修改该应用",
+			"error": tr(ctx, "app.noUpdate"),
 		})
 		return
 	}
 
 	var req struct {
-		Name        string `json:"name"`
+		Name        string `json:"name" binding:"omitempty,max=50,safetext" sanitize:"text"`
 		Protocol    string `json:"protocol"`
 		SrcPort     int    `json:"srcPort"`
-		PeerNode    string `json:"peerNode"`
+		PeerNode    string `json:"peerNode" binding:"omitempty,max=50,safetext" sanitize:"text"`
 		DstPort     int    `json:"dstPort"`
-		DstHost     string `json:"dstHost"`
-		Description string `json:"description"`
+		DstHost     string `json:"dstHost" binding:"omitempty,max=50,safetext" sanitize:"text"`
+		Description string `json:"description" binding:"max=200,safemultiline" sanitize:"multiline"`
+		Revision    uint   `json:"revision"`
 	}
 
-	if err := ctx.ShouldBindJSON(&req); err != nil {
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	revision, ok := requestRevision(ctx, req.Revision)
+	if !ok {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
+			"error": tr(ctx, "request.invalidIfMatch"),
 		})
 		return
 	}
@@ -217,7 +280,11 @@ func (c *AppController) UpdateApp(ctx *gin.Context) {
 		updates["src_port"] = req.SrcPort
 	}
 	if req.PeerNode != "" {
-		updates["peer_node"] = req.PeerNode
+		peerNode, ok := c.resolvePeer(ctx, userID.(uint), req.PeerNode)
+		if !ok {
+			return
+		}
+		updates["peer_node"] = peerNode
 	}
 	if req.DstPort != 0 {
 		updates["dst_port"] = req.DstPort
@@ -229,14 +296,25 @@ func (c *AppController) UpdateApp(ctx *gin.Context) {
 		updates["description"] = req.Description
 	}
 
-	updatedApp, err := c.appService.UpdateApp(uint(appID), updates)
+	updatedApp, err := svc.UpdateApp(uint(appID), revision, updates)
 	if err != nil {
+		if isRevisionConflict(err) {
+			if current, err := svc.GetAppByID(uint(appID)); err == nil {
+				respondRevisionConflict(ctx, current, current.Revision)
+				return
+			}
+		}
+		if errors.Is(err, app.ErrPortInUse) {
+			respondError(ctx, err)
+			return
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
+	setETag(ctx, updatedApp.Revision)
 	ctx.JSON(http.StatusOK, updatedApp)
 }
 
@@ -245,20 +323,25 @@ func (c *AppController) DeleteApp(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
 
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
 	appID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的应用 ID",
+			"error": tr(ctx, "app.invalidID"),
 		})
 		return
 	}
 
-	app, err := c.appService.GetAppByID(uint(appID))
+	app, err := svc.GetAppByID(uint(appID))
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
@@ -269,12 +352,12 @@ func (c *AppController) DeleteApp(ctx *gin.Context) {
 	// 检查应用是否属于当前用户
 	if app.UserID != userID.(uint) {
 		ctx.JSON(http.StatusForbidden, gin.H{
-			"error": "无权删除该应用",
+			"error": tr(ctx, "app.noDelete"),
 		})
 		return
 	}
 
-	if err := c.appService.DeleteApp(uint(appID)); err != nil {
+	if err := svc.DeleteApp(uint(appID)); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
@@ -291,20 +374,25 @@ func (c *AppController) StartApp(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
 
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
 	appID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的应用 ID",
+			"error": tr(ctx, "app.invalidID"),
 		})
 		return
 	}
 
-	app, err := c.appService.GetAppByID(uint(appID))
+	app, err := svc.GetAppByID(uint(appID))
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
@@ -315,12 +403,12 @@ func (c *AppController) StartApp(ctx *gin.Context) {
 	// 检查应用是否属于当前用户
 	if app.UserID != userID.(uint) {
 		ctx.JSON(http.StatusForbidden, gin.H{
-			"error": "无权操作该应用",
+			"error": tr(ctx, "app.noOperate"),
 		})
 		return
 	}
 
-	app, err = c.appService.StartApp(uint(appID))
+	app, err = svc.StartApp(uint(appID))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -340,20 +428,25 @@ func (c *AppController) StopApp(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
 
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
 	appID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的应用 ID",
+			"error": tr(ctx, "app.invalidID"),
 		})
 		return
 	}
 
-	app, err := c.appService.GetAppByID(uint(appID))
+	app, err := svc.GetAppByID(uint(appID))
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
@@ -364,12 +457,12 @@ func (c *AppController) StopApp(ctx *gin.Context) {
 	// 检查应用是否属于当前用户
 	if app.UserID != userID.(uint) {
 		ctx.JSON(http.StatusForbidden, gin.H{
-			"error": "无权操作该应用",
+			"error": tr(ctx, "app.noOperate"),
 		})
 		return
 	}
 
-	app, err = c.appService.StopApp(uint(appID))
+	app, err = svc.StopApp(uint(appID))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -389,20 +482,25 @@ func (c *AppController) GetAppStats(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
 
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
 	appID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的应用 ID",
+			"error": tr(ctx, "app.invalidID"),
 		})
 		return
 	}
 
-	app, err := c.appService.GetAppByID(uint(appID))
+	app, err := svc.GetAppByID(uint(appID))
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
@@ -413,12 +511,12 @@ func (c *AppController) GetAppStats(ctx *gin.Context) {
 	// 检查应用是否属于当前用户
 	if app.UserID != userID.(uint) {
 		ctx.JSON(http.StatusForbidden, gin.H{
-			"error": "无权访问该应用",
+			"error": tr(ctx, "app.noAccess"),
 		})
 		return
 	}
 
-	stats, err := c.appService.GetAppStats(uint(appID))
+	stats, err := svc.GetAppStats(uint(appID))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -426,5 +524,142 @@ func (c *AppController) GetAppStats(ctx *gin.Context) {
 		return
 	}
 
+	localizeStats(ctx, stats)
 	ctx.JSON(http.StatusOK, stats)
 }
+
+// 应用目标地址统计的默认和最大查询范围
+const (
+	defaultDestinationRange = 24 * time.Hour
+	maxDestinationRange     = 30 * 24 * time.Hour
+)
+
+// GetAppDestinations 获取应用在一段时间内流量最多的目标地址，支持 range、limit 和 sort 查询参数
+func (c *AppController) GetAppDestinations(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	appID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "app.invalidID"),
+		})
+		return
+	}
+
+	period := defaultDestinationRange
+	if v := ctx.Query("range"); v != "" {
+		period, err = time.ParseDuration(v)
+		if err != nil || period <= 0 || period > maxDestinationRange {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": tr(ctx, "request.invalidTimeRange"),
+			})
+			return
+		}
+	}
+
+	limit, err := strconv.Atoi(ctx.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 || limit > 100 {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalidLimit"),
+		})
+		return
+	}
+
+	orderBy := ctx.DefaultQuery("sort", store.OrderByBytes)
+	if orderBy != store.OrderByBytes && orderBy != store.OrderByConnections {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalidOrder"),
+		})
+		return
+	}
+
+	app, err := svc.GetAppByID(uint(appID))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if app.UserID != userID {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": tr(ctx, "app.noAccess"),
+		})
+		return
+	}
+
+	since := time.Now().Add(-period)
+	destinations, err := svc.GetAppDestinations(app.ID, since, limit, orderBy)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	for i := range destinations {
+		destinations[i].LastReported = destinations[i].LastReported.UTC()
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"since":        since.UTC(),
+		"timezone":     "UTC",
+		"destinations": destinations,
+	})
+}
+
+// ReportHealth 设备上报应用的健康检查结果
+func (c *AppController) ReportHealth(ctx *gin.Context) {
+	deviceID := ctx.MustGet("deviceID").(uint)
+
+	var req struct {
+		Apps []app.HealthReport `json:"apps" binding:"required,dive"`
+	}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	apps, err := c.appService.ReportHealth(deviceID, req.Apps)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"apps": apps,
+	})
+}
+
+// SyncDeviceApps 设备同步应用配置，since 为上次同步的版本号，只返回之后的变化。
+// 没有变化时返回 304，版本号无效或未提供时返回完整的应用列表
+func (c *AppController) SyncDeviceApps(ctx *gin.Context) {
+	deviceID := ctx.MustGet("deviceID").(uint)
+
+	var since uint64
+	if value := ctx.Query("since"); value != "" {
+		var err error
+		if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": tr(ctx, "request.invalid"),
+			})
+			return
+		}
+	}
+
+	changes, err := c.appService.GetChanges(deviceID, uint(since))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	setETag(ctx, changes.Version)
+	if !changes.Full && len(changes.Apps) == 0 && len(changes.Removed) == 0 {
+		ctx.Status(http.StatusNotModified)
+		return
+	}
+	ctx.JSON(http.StatusOK, changes)
+}
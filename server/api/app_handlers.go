@@ -5,7 +5,6 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/senma231/p3/common/errors"
 	"github.com/senma231/p3/server/app"
 )
 
@@ -20,10 +19,7 @@ func GetApps(c *gin.Context) {
 	// 获取应用列表
 	apps, err := appService.GetApps(userID)
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -52,10 +48,7 @@ func GetApp(c *gin.Context) {
 	// 获取应用详情
 	app, err := appService.GetApp(userID, uint(appID))
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -67,7 +60,7 @@ func CreateApp(c *gin.Context) {
 	var req app.AppRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
+			"error": tr(c, "request.invalid"),
 		})
 		return
 	}
@@ -90,10 +83,7 @@ func CreateApp(c *gin.Context) {
 	// 创建应用
 	app, err := appService.CreateApp(userID, uint(deviceID), &req)
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -105,7 +95,7 @@ func UpdateApp(c *gin.Context) {
 	var req app.AppUpdateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
+			"error": tr(c, "request.invalid"),
 		})
 		return
 	}
@@ -128,10 +118,7 @@ func UpdateApp(c *gin.Context) {
 	// 更新应用
 	app, err := appService.UpdateApp(userID, uint(appID), &req)
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -157,10 +144,7 @@ func DeleteApp(c *gin.Context) {
 
 	// 删除应用
 	if err := appService.DeleteApp(userID, uint(appID)); err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -189,10 +173,7 @@ func StartApp(c *gin.Context) {
 	// 启动应用
 	app, err := appService.StartApp(userID, uint(appID))
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -219,10 +200,7 @@ func StopApp(c *gin.Context) {
 	// 停止应用
 	app, err := appService.StopApp(userID, uint(appID))
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
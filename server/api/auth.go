@@ -1,10 +1,15 @@
 package api
 
 import (
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/db"
 )
 
 // AuthController 认证控制器
@@ -22,41 +27,45 @@ func NewAuthController(authService *auth.Service) *AuthController {
 // Register 注册用户
 func (c *AuthController) Register(ctx *gin.Context) {
 	var req struct {
-		Username string `json:"username" binding:"required"`
-		Password string `json:"password" binding:"required"`
-		Email    string `json:"email"`
+		Username   string `json:"username" binding:"required,max=50,safetext" sanitize:"text"`
+		Password   string `json:"password" binding:"required"`
+		Email      string `json:"email" binding:"omitempty,max=100,email"`
+		InviteCode string `json:"inviteCode"`
 	}
 
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
-		})
+	if !bindJSON(ctx, &req) {
 		return
 	}
 
-	user, err := c.authService.Register(req.Username, req.Password, req.Email)
+	user, err := c.authService.RegisterWithInvitation(req.Username, req.Password, req.Email, req.InviteCode)
 	if err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": err.Error(),
+		if respondPasswordPolicy(ctx, err) {
+			return
+		}
+		status := http.StatusBadRequest
+		if errors.Is(err, auth.ErrRegistrationClosed) ||
+			errors.Is(err, auth.ErrInvitationRequired) ||
+			errors.Is(err, auth.ErrInvitationInvalid) {
+			status = http.StatusForbidden
+		}
+		ctx.JSON(status, gin.H{
+			"error":        err.Error(),
+			"registration": c.authService.RegistrationMode(),
 		})
 		return
 	}
 
-	token, err := c.authService.GenerateToken(user.ID, user.Username)
+	token, err := c.authService.GenerateToken(user.ID, user.Username, auth.UserScopes(user))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
-			"error": "生成 Token 失败",
+			"error": tr(ctx, "auth.tokenFailed"),
 		})
 		return
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"token": token,
-		"user": gin.H{
-			"id":       user.ID,
-			"username": user.Username,
-			"email":    user.Email,
-		},
+		"user":  userResponse(user),
 	})
 }
 
@@ -65,18 +74,35 @@ func (c *AuthController) Login(ctx *gin.Context) {
 	var req struct {
 		Username string `json:"username" binding:"required"`
 		Password string `json:"password" binding:"required"`
+		TOTPCode string `json:"totpCode"`
 	}
 
 	if err := ctx.ShouldBindJSON(&req); err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
+			"error": tr(ctx, "request.invalid"),
 		})
 		return
 	}
 
-	user, token, err := c.authService.Login(req.Username, req.Password)
+	user, token, err := c.authService.LoginFrom(req.Username, req.Password, req.TOTPCode, clientContext(ctx))
 	if err != nil {
-		ctx.JSON(http.StatusUnauthorized, gin.H{
+		status := http.StatusUnauthorized
+		switch {
+		case errors.Is(err, auth.ErrAccountLocked):
+			status = http.StatusTooManyRequests
+		case errors.Is(err, auth.ErrGroupNotAllowed):
+			status = http.StatusForbidden
+		case errors.Is(err, auth.ErrProviderUnavailable):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, auth.ErrTOTPRequired):
+			// 客户端据此提示用户输入双因素认证代码
+			ctx.JSON(status, gin.H{
+				"error":        err.Error(),
+				"totpRequired": true,
+			})
+			return
+		}
+		ctx.JSON(status, gin.H{
 			"error": err.Error(),
 		})
 		return
@@ -84,11 +110,42 @@ func (c *AuthController) Login(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusOK, gin.H{
 		"token": token,
-		"user": gin.H{
-			"id":       user.ID,
-			"username": user.Username,
-			"email":    user.Email,
-		},
+		"user":  userResponse(user),
+	})
+}
+
+// RefreshToken 使用未过期的令牌换取新的令牌
+func (c *AuthController) RefreshToken(ctx *gin.Context) {
+	var req struct {
+		RefreshToken string `json:"refresh_token" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	token, err := c.authService.RefreshTokenFrom(req.RefreshToken, clientContext(ctx))
+	if err != nil {
+		if errors.Is(err, auth.ErrReauthRequired) {
+			// 客户端据此清除本地令牌并跳转到登录页
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"error":          err.Error(),
+				"reauthRequired": true,
+			})
+			return
+		}
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"access_token": token,
+		"token_type":   "Bearer",
 	})
 }
 
@@ -106,7 +163,7 @@ func (c *AuthController) GetCurrentUser(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
@@ -120,10 +177,420 @@ func (c *AuthController) GetCurrentUser(ctx *gin.Context) {
 	}
 
 	ctx.JSON(http.StatusOK, gin.H{
-		"user": gin.H{
-			"id":       user.ID,
-			"username": user.Username,
-			"email":    user.Email,
-		},
+		"user": userResponse(user),
+	})
+}
+
+// UpdateUser 修改当前用户的个人资料和偏好，只修改请求中提供的字段
+func (c *AuthController) UpdateUser(ctx *gin.Context) {
+	userID, exists := ctx.Get("userID")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return
+	}
+
+	var req struct {
+		DisplayName *string `json:"displayName" binding:"omitempty,max=50,safetext" sanitize:"text"`
+		Timezone    *string `json:"timezone" binding:"omitempty,max=64"`
+		Preferences *struct {
+			Theme         *string         `json:"theme"`
+			PageSize      *int            `json:"pageSize"`
+			Notifications map[string]bool `json:"notifications"`
+		} `json:"preferences"`
+	}
+
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	update := auth.ProfileUpdate{
+		DisplayName: req.DisplayName,
+		Timezone:    req.Timezone,
+	}
+	if req.Preferences != nil {
+		update.Theme = req.Preferences.Theme
+		update.PageSize = req.Preferences.PageSize
+		update.Notifications = req.Preferences.Notifications
+	}
+
+	user, err := c.authService.UpdateProfile(userID.(uint), update)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, auth.ErrUserNotFound) {
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"user": userResponse(user),
+	})
+}
+
+// ChangePassword 修改当前用户的密码
+func (c *AuthController) ChangePassword(ctx *gin.Context) {
+	userID, exists := ctx.Get("userID")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return
+	}
+
+	var req struct {
+		OldPassword string `json:"oldPassword" binding:"required"`
+		NewPassword string `json:"newPassword" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	if err := c.authService.ChangePassword(userID.(uint), req.OldPassword, req.NewPassword); err != nil {
+		if respondPasswordPolicy(ctx, err) {
+			return
+		}
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrWrongPassword) || errors.Is(err, auth.ErrExternalAccount) {
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "密码已修改",
+	})
+}
+
+// VerifyEmail 使用验证邮件中的令牌验证邮箱
+func (c *AuthController) VerifyEmail(ctx *gin.Context) {
+	var req struct {
+		Token string `json:"token" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	user, err := c.authService.VerifyEmail(req.Token)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrVerificationTokenInvalid) {
+			status = http.StatusBadRequest
+		}
+		ctx.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "邮箱已验证",
+		"user":    userResponse(user),
+	})
+}
+
+// ResendVerification 重新发送当前用户的验证邮件
+func (c *AuthController) ResendVerification(ctx *gin.Context) {
+	userID, exists := ctx.Get("userID")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return
+	}
+
+	if err := c.authService.ResendVerification(userID.(uint)); err != nil {
+		var tooSoon *auth.ResendTooSoonError
+		switch {
+		case errors.As(err, &tooSoon):
+			retryAfter := int(math.Ceil(tooSoon.RetryAfter.Seconds()))
+			ctx.Header("Retry-After", strconv.Itoa(retryAfter))
+			ctx.JSON(http.StatusTooManyRequests, gin.H{
+				"error":      err.Error(),
+				"retryAfter": retryAfter,
+			})
+		case errors.Is(err, auth.ErrEmailAlreadyVerified):
+			ctx.JSON(http.StatusConflict, gin.H{
+				"error": err.Error(),
+			})
+		case errors.Is(err, auth.ErrVerificationUnavailable):
+			ctx.JSON(http.StatusServiceUnavailable, gin.H{
+				"error": err.Error(),
+			})
+		default:
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+		}
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "验证邮件已发送",
+	})
+}
+
+// SetEmailVerified 管理员设置用户的邮箱验证状态
+func (c *AuthController) SetEmailVerified(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "user.invalidID"),
+		})
+		return
+	}
+
+	var req struct {
+		Verified *bool `json:"verified" binding:"required"`
+	}
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	user, err := c.authService.SetEmailVerified(uint(id), *req.Verified)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrUserNotFound) {
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"user": userResponse(user),
+	})
+}
+
+// GetRegistrationMode 获取注册模式，供注册页面决定是否显示邀请码输入框
+func (c *AuthController) GetRegistrationMode(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"registration": c.authService.RegistrationMode(),
+	})
+}
+
+// CreateInvitation 管理员创建注册邀请
+func (c *AuthController) CreateInvitation(ctx *gin.Context) {
+	userID, exists := ctx.Get("userID")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return
+	}
+
+	var req struct {
+		Role      string `json:"role" binding:"omitempty,oneof=user admin"`
+		ExpiresIn int    `json:"expiresIn" binding:"min=0"` // 单位：小时
+		MaxUses   int    `json:"maxUses" binding:"min=0"`
+		Note      string `json:"note" binding:"max=200,safemultiline" sanitize:"multiline"`
+	}
+
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	inv, code, err := c.authService.CreateInvitation(userID.(uint), auth.InvitationOptions{
+		Role:    auth.Role(req.Role),
+		TTL:     time.Duration(req.ExpiresIn) * time.Hour,
+		MaxUses: req.MaxUses,
+		Note:    req.Note,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"invitation": inv,
+		"code":       code,
+		"link":       c.authService.InvitationLink(code),
+	})
+}
+
+// ListInvitations 管理员获取所有注册邀请
+func (c *AuthController) ListInvitations(ctx *gin.Context) {
+	invitations, err := c.authService.ListInvitations()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"invitations": invitations,
+	})
+}
+
+// DeleteInvitation 管理员撤销注册邀请
+func (c *AuthController) DeleteInvitation(ctx *gin.Context) {
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "invitation.invalidID"),
+		})
+		return
+	}
+
+	if err := c.authService.DeleteInvitation(uint(id)); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "邀请已撤销",
+	})
+}
+
+// CreateAPIKey 为当前用户创建 API 密钥，密钥只在创建时返回
+func (c *AuthController) CreateAPIKey(ctx *gin.Context) {
+	if _, usingKey := ctx.Get("apiKeyID"); usingKey {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": tr(ctx, "apiKey.notAllowed"),
+		})
+		return
+	}
+	userID := ctx.MustGet("userID").(uint)
+
+	var req struct {
+		Name      string   `json:"name" binding:"required,max=100,safetext" sanitize:"text"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn int      `json:"expiresIn" binding:"min=0"` // 单位：天，为 0 时不过期
+	}
+
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	key, secret, err := c.authService.CreateAPIKey(userID, auth.APIKeyOptions{
+		Name:   req.Name,
+		Scopes: req.Scopes,
+		TTL:    time.Duration(req.ExpiresIn) * 24 * time.Hour,
+	})
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, auth.ErrScopeNotAllowed) {
+			status = http.StatusForbidden
+		}
+		ctx.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"apiKey": key,
+		"key":    secret,
+	})
+}
+
+// ListAPIKeys 获取当前用户的 API 密钥
+func (c *AuthController) ListAPIKeys(ctx *gin.Context) {
+	keys, err := c.authService.ListAPIKeys(ctx.MustGet("userID").(uint))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"apiKeys": keys,
+	})
+}
+
+// DeleteAPIKey 撤销当前用户的 API 密钥
+func (c *AuthController) DeleteAPIKey(ctx *gin.Context) {
+	if _, usingKey := ctx.Get("apiKeyID"); usingKey {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": tr(ctx, "apiKey.notAllowed"),
+		})
+		return
+	}
+
+	id, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "apiKey.invalidID"),
+		})
+		return
+	}
+
+	if err := c.authService.DeleteAPIKey(ctx.MustGet("userID").(uint), uint(id)); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, auth.ErrAPIKeyNotFound) {
+			status = http.StatusNotFound
+		}
+		ctx.JSON(status, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "API 密钥已撤销",
+	})
+}
+
+// userResponse 用户信息响应，偏好中未设置的项填充默认值
+func userResponse(user *db.User) gin.H {
+	return gin.H{
+		"id":            user.ID,
+		"username":      user.Username,
+		"email":         user.Email,
+		"emailVerified": user.EmailVerified,
+		"isAdmin":       user.IsAdmin,
+		"displayName":   user.DisplayName,
+		"timezone":      user.Timezone,
+		"preferences":   auth.EffectivePreferences(user.Preferences),
+		"authSource":    user.AuthSource,
+	}
+}
+
+// clientContext 请求的客户端环境，用于将令牌绑定到登录环境。客户端地址只采信受信任代理设置的
+// X-Forwarded-For，见 NewEngine
+func clientContext(ctx *gin.Context) auth.ClientContext {
+	return auth.ClientContext{
+		UserAgent: ctx.Request.UserAgent(),
+		IP:        ctx.ClientIP(),
+	}
+}
+
+// respondPasswordPolicy 密码不符合强度策略时返回 400 并列出未通过的规则
+func respondPasswordPolicy(ctx *gin.Context, err error) bool {
+	var policyErr *auth.PasswordPolicyError
+	if !errors.As(err, &policyErr) {
+		return false
+	}
+	ctx.JSON(http.StatusBadRequest, gin.H{
+		"error":      policyErr.Error(),
+		"violations": policyErr.Violations,
 	})
+	return true
 }
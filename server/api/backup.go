@@ -0,0 +1,132 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/backup"
+	"github.com/senma231/p3/server/db"
+	"gorm.io/gorm"
+)
+
+// BackupController 备份和恢复控制器
+type BackupController struct {
+	db         *gorm.DB
+	configPath string
+}
+
+// NewBackupController 创建备份和恢复控制器，configPath 为服务端使用的配置文件
+func NewBackupController(gdb *gorm.DB, configPath string) *BackupController {
+	return &BackupController{
+		db:         gdb,
+		configPath: configPath,
+	}
+}
+
+// CreateBackupRequest 创建备份请求
+type CreateBackupRequest struct {
+	Passphrase    string `json:"passphrase" binding:"required"` // 加密备份的口令
+	IncludeConfig bool   `json:"includeConfig"`                 // 是否包含配置文件
+}
+
+// CreateBackup 读取数据库的一致快照并加密，作为附件返回
+func (c *BackupController) CreateBackup(ctx *gin.Context) {
+	var req CreateBackupRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	if len(req.Passphrase) < backup.MinPassphraseLength {
+		respondError(ctx, errors.InvalidParam(fmt.Sprintf("口令至少需要 %d 个字符", backup.MinPassphraseLength)))
+		return
+	}
+
+	snapshot, err := backup.Dump(ctx.Request.Context(), c.db)
+	if err != nil {
+		respondError(ctx, errors.Internal(fmt.Sprintf("读取数据失败: %v", err)))
+		return
+	}
+	if req.IncludeConfig {
+		if snapshot.Config, err = os.ReadFile(c.configPath); err != nil {
+			respondError(ctx, errors.Internal(fmt.Sprintf("读取配置文件失败: %v", err)))
+			return
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := backup.Encode(&buf, snapshot, req.Passphrase); err != nil {
+		respondError(ctx, errors.Internal(fmt.Sprintf("加密备份失败: %v", err)))
+		return
+	}
+	logger.Info("用户 %d 创建了备份: %s", ctx.GetUint("userID"), snapshot.Counts())
+
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", backup.FileName(snapshot.CreatedAt)))
+	ctx.Data(http.StatusOK, "application/octet-stream", buf.Bytes())
+}
+
+// RestoreBackup 从请求体中的备份文件恢复数据库。users 查询参数指定只恢复部分用户，
+// dryRun=true 时只返回将要恢复的资源数量。配置文件不通过接口恢复
+func (c *BackupController) RestoreBackup(ctx *gin.Context) {
+	passphrase := ctx.GetHeader("X-Backup-Passphrase")
+	if passphrase == "" {
+		respondError(ctx, errors.InvalidParam("缺少 X-Backup-Passphrase 请求头"))
+		return
+	}
+
+	body := http.MaxBytesReader(ctx.Writer, ctx.Request.Body, backup.MaxArchiveSize)
+	snapshot, err := backup.Decode(body, passphrase)
+	if err != nil {
+		respondError(ctx, errors.InvalidParam(err.Error()))
+		return
+	}
+
+	if users := ctx.Query("users"); users != "" {
+		if snapshot, err = snapshot.Select(strings.Split(users, ",")); err != nil {
+			respondError(ctx, errors.InvalidParam(err.Error()))
+			return
+		}
+	}
+	if ctx.Query("dryRun") == "true" {
+		ctx.JSON(http.StatusOK, gin.H{
+			"createdAt": snapshot.CreatedAt,
+			"dryRun":    true,
+			"restored":  snapshot.Counts(),
+		})
+		return
+	}
+
+	counts, err := backup.Restore(ctx.Request.Context(), c.db, snapshot)
+	if err != nil {
+		if db.IsDuplicateKey(err) {
+			respondError(ctx, errors.Conflict(err.Error()))
+			return
+		}
+		respondError(ctx, errors.Internal(err.Error()))
+		return
+	}
+	logger.Warn("用户 %d 从 %s 的备份恢复了数据: %s", ctx.GetUint("userID"), snapshot.CreatedAt.Format("2006-01-02 15:04:05"), counts)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"createdAt": snapshot.CreatedAt,
+		"dryRun":    false,
+		"restored":  counts,
+	})
+}
+
+// RegisterBackupRoutes 注册备份和恢复路由
+func RegisterBackupRoutes(router *gin.Engine, authService *auth.Service, gdb *gorm.DB, configPath string) {
+	backupController := NewBackupController(gdb, configPath)
+
+	backups := router.Group("/api/v1/backups")
+	backups.Use(AuthMiddleware(authService))
+	{
+		backups.POST("", RequireScopes(auth.ScopeUsersAdmin), backupController.CreateBackup)
+		backups.POST("/restore", RequireScopes(auth.ScopeUsersAdmin), backupController.RestoreBackup)
+	}
+}
@@ -4,194 +4,207 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/app"
 	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/device"
+	"github.com/senma231/p3/server/forward"
 )
 
-// BatchHandler 批量操作处理器
-type BatchHandler struct {
-	db *db.Database
+// BatchController 批量操作控制器
+type BatchController struct {
+	deviceService  *device.Service
+	appService     *app.Service
+	forwardService *forward.Service
 }
 
-// NewBatchHandler 创建批量操作处理器
-func NewBatchHandler(db *db.Database) *BatchHandler {
-	return &BatchHandler{
-		db: db,
+// NewBatchController 创建批量操作控制器
+func NewBatchController(deviceService *device.Service, appService *app.Service, forwardService *forward.Service) *BatchController {
+	return &BatchController{
+		deviceService:  deviceService,
+		appService:     appService,
+		forwardService: forwardService,
 	}
 }
 
 // BatchDeviceOperation 批量设备操作
-func (h *BatchHandler) BatchDeviceOperation(c *gin.Context) {
+func (c *BatchController) BatchDeviceOperation(ctx *gin.Context) {
 	var req struct {
 		DeviceIDs []uint `json:"deviceIds" binding:"required"`
 		Operation string `json:"operation" binding:"required"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": tr(ctx, "request.invalid")})
 		return
 	}
 
-	// 获取当前用户 ID
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+	// 限定为当前请求租户的设备服务，其他租户的设备返回不存在
+	svc, err := c.deviceService.ForTenant(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": tr(ctx, "auth.unauthorized")})
 		return
 	}
 
-	// 检查设备权限
+	// 先检查所有设备，避免只执行了部分操作
 	for _, deviceID := range req.DeviceIDs {
-		var device db.Device
-		if err := h.db.DB.Where("id = ? AND user_id = ?", deviceID, userID).First(&device).Error; err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "没有权限操作某些设备"})
+		if _, err := svc.GetDeviceByID(deviceID); err != nil {
+			ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
 			return
 		}
 	}
 
 	// 执行批量操作
+	var apply func(deviceID uint) error
 	switch req.Operation {
 	case "restart":
-		// 重启设备
-		for _, deviceID := range req.DeviceIDs {
-			// 这里应该调用实际的重启设备的逻辑
-			// 为了简化，这里只是更新设备状态
-			h.db.DB.Model(&db.Device{}).Where("id = ?", deviceID).Update("status", "restarting")
+		// 只更新设备状态，由设备下次上报确认
+		apply = func(deviceID uint) error {
+			_, err := svc.UpdateDevice(deviceID, 0, map[string]interface{}{"status": "restarting"})
+			return err
 		}
 	case "shutdown":
-		// 关闭设备
-		for _, deviceID := range req.DeviceIDs {
-			// 这里应该调用实际的关闭设备的逻辑
-			// 为了简化，这里只是更新设备状态
-			h.db.DB.Model(&db.Device{}).Where("id = ?", deviceID).Update("status", "offline")
+		apply = func(deviceID uint) error {
+			_, err := svc.UpdateDevice(deviceID, 0, map[string]interface{}{"status": "offline"})
+			return err
 		}
 	case "delete":
-		// 删除设备
-		for _, deviceID := range req.DeviceIDs {
-			h.db.DB.Delete(&db.Device{}, deviceID)
-		}
+		apply = svc.DeleteDevice
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的操作"})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": tr(ctx, "batch.unsupportedOperation")})
 		return
 	}
+	for _, deviceID := range req.DeviceIDs {
+		if err := apply(deviceID); err != nil {
+			respondError(ctx, err)
+			return
+		}
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "批量操作成功"})
+	ctx.JSON(http.StatusOK, gin.H{"message": "批量操作成功"})
 }
 
 // BatchAppOperation 批量应用操作
-func (h *BatchHandler) BatchAppOperation(c *gin.Context) {
+func (c *BatchController) BatchAppOperation(ctx *gin.Context) {
 	var req struct {
 		AppIDs    []uint `json:"appIds" binding:"required"`
 		Operation string `json:"operation" binding:"required"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": tr(ctx, "request.invalid")})
 		return
 	}
 
-	// 获取当前用户 ID
-	userID, exists := c.Get("userID")
-	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+	// 限定为当前请求租户的应用服务，其他租户的应用返回不存在
+	svc, err := c.appService.ForTenant(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": tr(ctx, "auth.unauthorized")})
 		return
 	}
 
-	// 检查应用权限
+	// 先检查所有应用，避免只执行了部分操作
 	for _, appID := range req.AppIDs {
-		var app db.App
-		if err := h.db.DB.Where("id = ?", appID).First(&app).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "未找到某些应用"})
-			return
-		}
-
-		// 检查设备权限
-		var device db.Device
-		if err := h.db.DB.Where("id = ? AND user_id = ?", app.DeviceID, userID).First(&device).Error; err != nil {
-			c.JSON(http.StatusForbidden, gin.H{"error": "没有权限操作某些应用"})
+		if _, err := svc.GetAppByID(appID); err != nil {
+			respondError(ctx, err)
 			return
 		}
 	}
 
-	// 执行批量操作
+	// 执行批量操作，应用状态由设备上报的健康检查结果确认
+	var apply func(appID uint) error
 	switch req.Operation {
 	case "start":
-		// 启动应用
-		for _, appID := range req.AppIDs {
-			// 这里应该调用实际的启动应用的逻辑
-			// 为了简化，这里只是更新应用状态
-			h.db.DB.Model(&db.App{}).Where("id = ?", appID).Update("status", "running")
+		apply = func(appID uint) error {
+			_, err := svc.UpdateApp(appID, 0, map[string]interface{}{"status": "starting"})
+			return err
 		}
 	case "stop":
-		// 停止应用
-		for _, appID := range req.AppIDs {
-			// 这里应该调用实际的停止应用的逻辑
-			// 为了简化，这里只是更新应用状态
-			h.db.DB.Model(&db.App{}).Where("id = ?", appID).Update("status", "stopped")
+		apply = func(appID uint) error {
+			_, err := svc.UpdateApp(appID, 0, map[string]interface{}{"status": "stopped"})
+			return err
 		}
 	case "delete":
-		// 删除应用
-		for _, appID := range req.AppIDs {
-			h.db.DB.Delete(&db.App{}, appID)
-		}
+		apply = svc.DeleteApp
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的操作"})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": tr(ctx, "batch.unsupportedOperation")})
 		return
 	}
+	for _, appID := range req.AppIDs {
+		if err := apply(appID); err != nil {
+			respondError(ctx, err)
+			return
+		}
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "批量操作成功"})
+	ctx.JSON(http.StatusOK, gin.H{"message": "批量操作成功"})
 }
 
 // BatchForwardOperation 批量转发规则操作
-func (h *BatchHandler) BatchForwardOperation(c *gin.Context) {
+func (c *BatchController) BatchForwardOperation(ctx *gin.Context) {
 	var req struct {
 		ForwardIDs []uint `json:"forwardIds" binding:"required"`
 		Operation  string `json:"operation" binding:"required"`
 	}
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求数据"})
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": tr(ctx, "request.invalid")})
 		return
 	}
 
-	// 获取当前用户 ID
-	userID, exists := c.Get("userID")
+	userID, exists := ctx.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": tr(ctx, "auth.unauthorized")})
 		return
 	}
 
-	// 检查转发规则权限
-	for _, forwardID := range req.ForwardIDs {
-		var forward db.Forward
-		if err := h.db.DB.Where("id = ?", forwardID).First(&forward).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "未找到某些转发规则"})
-			return
-		}
+	// 限定为当前请求租户的转发服务，其他租户的转发规则返回不存在
+	svc, err := c.forwardService.ForTenant(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{"error": tr(ctx, "auth.unauthorized")})
+		return
+	}
 
-		// 检查用户权限
-		if forward.UserID != userID.(uint) {
-			c.JSON(http.StatusForbidden, gin.H{"error": "没有权限操作某些转发规则"})
+	// 先检查所有转发规则，避免只执行了部分操作
+	forwards := make([]*db.Forward, 0, len(req.ForwardIDs))
+	for _, forwardID := range req.ForwardIDs {
+		f, err := svc.GetForward(userID.(uint), forwardID)
+		if err != nil {
+			respondError(ctx, err)
 			return
 		}
+		forwards = append(forwards, f)
 	}
 
-	// 执行批量操作
+	// 执行批量操作，已处于目标状态的转发规则保持不变
+	var apply func(f *db.Forward) error
 	switch req.Operation {
 	case "enable":
-		// 启用转发规则
-		for _, forwardID := range req.ForwardIDs {
-			h.db.DB.Model(&db.Forward{}).Where("id = ?", forwardID).Update("enabled", true)
+		apply = func(f *db.Forward) error {
+			if f.Enabled {
+				return nil
+			}
+			_, err := svc.EnableForward(f.UserID, f.ID)
+			return err
 		}
 	case "disable":
-		// 禁用转发规则
-		for _, forwardID := range req.ForwardIDs {
-			h.db.DB.Model(&db.Forward{}).Where("id = ?", forwardID).Update("enabled", false)
+		apply = func(f *db.Forward) error {
+			if !f.Enabled {
+				return nil
+			}
+			_, err := svc.DisableForward(f.UserID, f.ID)
+			return err
 		}
 	case "delete":
-		// 删除转发规则
-		for _, forwardID := range req.ForwardIDs {
-			h.db.DB.Delete(&db.Forward{}, forwardID)
+		apply = func(f *db.Forward) error {
+			return svc.DeleteForward(f.UserID, f.ID)
 		}
 	default:
-		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持的操作"})
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": tr(ctx, "batch.unsupportedOperation")})
 		return
 	}
+	for _, f := range forwards {
+		if err := apply(f); err != nil {
+			respondError(ctx, err)
+			return
+		}
+	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "批量操作成功"})
+	ctx.JSON(http.StatusOK, gin.H{"message": "批量操作成功"})
 }
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/sanitize"
+)
+
+// bindJSON 绑定请求体并按 sanitize 标签清理字符串字段，失败时返回 400
+func bindJSON(ctx *gin.Context, req interface{}) bool {
+	if err := ctx.ShouldBindJSON(req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return false
+	}
+
+	if err := sanitize.Struct(req); err != nil {
+		var fieldErr *sanitize.FieldError
+		if !errors.As(err, &fieldErr) {
+			ctx.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error(),
+			})
+			return false
+		}
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": fieldErr.Error(),
+			"field": fieldErr.Field,
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/pem"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/api/middleware"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/device"
+	"github.com/senma231/p3/server/pki"
+)
+
+// CertificateController 设备证书控制器
+type CertificateController struct {
+	deviceService *device.Service
+	certs         *pki.Service
+}
+
+// NewCertificateController 创建设备证书控制器
+func NewCertificateController(deviceService *device.Service, certs *pki.Service) *CertificateController {
+	return &CertificateController{
+		deviceService: deviceService,
+		certs:         certs,
+	}
+}
+
+// EnrollRequest 设备申请证书请求
+type EnrollRequest struct {
+	CSR string `json:"csr" binding:"required,max=4096"` // PEM 编码的证书签名请求
+}
+
+// Enroll 按设备提交的证书签名请求签发证书。首次申请使用令牌认证，轮换时使用当前证书认证，
+// 新证书签发后旧证书被吊销
+func (c *CertificateController) Enroll(ctx *gin.Context) {
+	dev := ctx.MustGet("device").(*db.Device)
+
+	var req EnrollRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+	block, _ := pem.Decode([]byte(req.CSR))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		respondError(ctx, errors.InvalidParam("无效的证书签名请求"))
+		return
+	}
+
+	issued, err := c.certs.Enroll(dev, block.Bytes)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusCreated, issued)
+}
+
+// GetCA 获取 PEM 编码的 CA 证书
+func (c *CertificateController) GetCA(ctx *gin.Context) {
+	ctx.Data(http.StatusOK, "application/x-pem-file", c.certs.Authority().CertificatePEM())
+}
+
+// GetCRL 获取 DER 编码的证书吊销列表
+func (c *CertificateController) GetCRL(ctx *gin.Context) {
+	crl, err := c.certs.RevocationList()
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+	ctx.Data(http.StatusOK, "application/pkix-crl", crl)
+}
+
+// GetStatus 查询证书的吊销状态
+func (c *CertificateController) GetStatus(ctx *gin.Context) {
+	status, err := c.certs.Status(ctx.Param("serial"))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, status)
+}
+
+// ListCertificates 获取设备的证书
+func (c *CertificateController) ListCertificates(ctx *gin.Context) {
+	dev, ok := c.ownedDevice(ctx)
+	if !ok {
+		return
+	}
+
+	certs, err := c.certs.List(dev.ID)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"certificates": certs,
+	})
+}
+
+// RevokeCertificates 吊销设备所有的证书，用于设备丢失或私钥泄露。吊销后设备需要使用令牌重新申请证书
+func (c *CertificateController) RevokeCertificates(ctx *gin.Context) {
+	dev, ok := c.ownedDevice(ctx)
+	if !ok {
+		return
+	}
+
+	revoked, err := c.certs.Revoke(dev.ID)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"revoked": revoked,
+	})
+}
+
+// ownedDevice 获取路径中属于当前用户的设备，失败时已写入响应
+func (c *CertificateController) ownedDevice(ctx *gin.Context) (*db.Device, bool) {
+	deviceID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		respondError(ctx, errors.InvalidParam("无效的设备 ID"))
+		return nil, false
+	}
+
+	dev, err := c.deviceService.GetDeviceByID(uint(deviceID))
+	if err != nil {
+		respondError(ctx, errors.NotFound(err.Error()))
+		return nil, false
+	}
+	if dev.UserID != ctx.MustGet("userID").(uint) {
+		respondError(ctx, errors.Forbidden("无权访问该设备"))
+		return nil, false
+	}
+	return dev, true
+}
+
+// RegisterCertificateRoutes 注册设备证书路由。CA 证书、吊销列表和吊销状态不需要认证，
+// 供设备验证对端证书时查询
+func RegisterCertificateRoutes(router *gin.Engine, authService *auth.Service, deviceService *device.Service, certs *pki.Service) {
+	certificateController := NewCertificateController(deviceService, certs)
+
+	public := router.Group("/api/v1/pki")
+	{
+		public.GET("/ca", certificateController.GetCA)
+		public.GET("/crl", certificateController.GetCRL)
+		public.GET("/status/:serial", certificateController.GetStatus)
+	}
+
+	deviceAPI := router.Group("/api/v1/device")
+	deviceAPI.Use(middleware.DeviceAuth(deviceService))
+	{
+		deviceAPI.POST("/certificate", certificateController.Enroll)
+	}
+
+	devices := router.Group("/api/v1/devices")
+	devices.Use(AuthMiddleware(authService))
+	{
+		devices.GET("/:id/certificates", RequireScopes(auth.ScopeDevicesRead), certificateController.ListCertificates)
+		devices.POST("/:id/certificates/revoke", RequireScopes(auth.ScopeDevicesWrite), certificateController.RevokeCertificates)
+	}
+}
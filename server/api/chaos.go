@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/chaos"
+)
+
+// ChaosController 故障注入控制器，只在使用 chaos 构建标签编译的服务端中注册
+type ChaosController struct{}
+
+// NewChaosController 创建故障注入控制器
+func NewChaosController() *ChaosController {
+	return &ChaosController{}
+}
+
+// GetChaos 获取当前的故障注入配置和各类故障的注入次数
+func (c *ChaosController) GetChaos(ctx *gin.Context) {
+	faults, stats := chaos.Get()
+	ctx.JSON(http.StatusOK, gin.H{
+		"faults": faults,
+		"stats":  stats,
+	})
+}
+
+// UpdateChaos 替换故障注入配置，未提及的故障被关闭
+func (c *ChaosController) UpdateChaos(ctx *gin.Context) {
+	var faults chaos.Faults
+	if !bindJSON(ctx, &faults) {
+		return
+	}
+
+	if err := chaos.Set(faults); err != nil {
+		respondError(ctx, errors.InvalidParam(err.Error()))
+		return
+	}
+	logger.Warn("用户 %d 修改了故障注入配置: %+v", ctx.GetUint("userID"), faults)
+
+	c.GetChaos(ctx)
+}
+
+// ResetChaos 关闭所有故障并清零注入次数
+func (c *ChaosController) ResetChaos(ctx *gin.Context) {
+	chaos.Reset()
+	logger.Warn("用户 %d 关闭了故障注入", ctx.GetUint("userID"))
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "故障注入已关闭",
+	})
+}
+
+// RegisterChaosRoutes 注册故障注入路由，未使用 chaos 构建标签编译时不注册
+func RegisterChaosRoutes(router *gin.Engine, authService *auth.Service) {
+	if !chaos.Enabled {
+		return
+	}
+	chaosController := NewChaosController()
+
+	faults := router.Group(chaos.AdminPath)
+	faults.Use(AuthMiddleware(authService))
+	{
+		faults.GET("", RequireScopes(auth.ScopeUsersAdmin), chaosController.GetChaos)
+		faults.PUT("", RequireScopes(auth.ScopeUsersAdmin), chaosController.UpdateChaos)
+		faults.DELETE("", RequireScopes(auth.ScopeUsersAdmin), chaosController.ResetChaos)
+	}
+}
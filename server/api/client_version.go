@@ -0,0 +1,46 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/device"
+)
+
+// ClientVersionController 客户端版本控制器
+type ClientVersionController struct {
+	deviceService *device.Service
+	policy        *config.ClientVersionConfig
+}
+
+// NewClientVersionController 创建客户端版本控制器
+func NewClientVersionController(deviceService *device.Service, policy *config.ClientVersionConfig) *ClientVersionController {
+	return &ClientVersionController{
+		deviceService: deviceService,
+		policy:        policy,
+	}
+}
+
+// GetSummary 获取客户端版本分布和过旧客户端数量
+func (c *ClientVersionController) GetSummary(ctx *gin.Context) {
+	summary, err := c.deviceService.GetClientVersionSummary(c.policy)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, summary)
+}
+
+// RegisterClientVersionRoutes 注册客户端版本管理路由
+func RegisterClientVersionRoutes(router *gin.Engine, authService *auth.Service, deviceService *device.Service, policy *config.ClientVersionConfig) {
+	clientVersionController := NewClientVersionController(deviceService, policy)
+
+	clients := router.Group("/api/v1/clients")
+	clients.Use(AuthMiddleware(authService))
+	{
+		clients.GET("/versions", RequireScopes(auth.ScopeUsersAdmin), clientVersionController.GetSummary)
+	}
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/dashboard"
+)
+
+// 运营看板的默认和最大查询范围
+const (
+	defaultDashboardRange = 24 * time.Hour
+	maxDashboardRange     = 90 * 24 * time.Hour
+)
+
+// DashboardController 运营看板控制器
+type DashboardController struct {
+	dashboardService *dashboard.Service
+}
+
+// NewDashboardController 创建运营看板控制器
+func NewDashboardController(dashboardService *dashboard.Service) *DashboardController {
+	return &DashboardController{
+		dashboardService: dashboardService,
+	}
+}
+
+// GetOverview 获取全局统计：用户数、在线和离线设备数、流量、中继流量、连接成功率和流量最多的设备，
+// 支持 range 和 top 查询参数
+func (c *DashboardController) GetOverview(ctx *gin.Context) {
+	since, ok := dashboardSince(ctx)
+	if !ok {
+		return
+	}
+	top, err := strconv.Atoi(ctx.DefaultQuery("top", "10"))
+	if err != nil || top <= 0 || top > 100 {
+		respondError(ctx, errors.InvalidParam("无效的数量限制"))
+		return
+	}
+
+	overview, err := c.dashboardService.Overview(since, top)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+	overview.Since = overview.Since.UTC()
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"overview": overview,
+		"timezone": "UTC",
+	})
+}
+
+// GetTenants 获取每个用户的设备数、在线设备数、应用数和时间范围内的流量，支持 range 查询参数
+func (c *DashboardController) GetTenants(ctx *gin.Context) {
+	since, ok := dashboardSince(ctx)
+	if !ok {
+		return
+	}
+
+	tenants, err := c.dashboardService.Tenants(since)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"since":    since.UTC(),
+		"timezone": "UTC",
+		"tenants":  tenants,
+	})
+}
+
+// dashboardSince 按 range 查询参数计算统计的起始时间，参数无效时返回错误响应
+func dashboardSince(ctx *gin.Context) (time.Time, bool) {
+	period := defaultDashboardRange
+	if v := ctx.Query("range"); v != "" {
+		var err error
+		period, err = time.ParseDuration(v)
+		if err != nil || period <= 0 || period > maxDashboardRange {
+			respondError(ctx, errors.InvalidParam("无效的时间范围"))
+			return time.Time{}, false
+		}
+	}
+	return time.Now().Add(-period), true
+}
+
+// RegisterDashboardRoutes 注册运营看板路由，只有管理员可以访问
+func RegisterDashboardRoutes(router *gin.Engine, authService *auth.Service, dashboardService *dashboard.Service) {
+	dashboardController := NewDashboardController(dashboardService)
+
+	admin := router.Group("/api/v1/admin/dashboard")
+	admin.Use(AuthMiddleware(authService))
+	{
+		admin.GET("/overview", RequireScopes(auth.ScopeUsersAdmin), dashboardController.GetOverview)
+		admin.GET("/tenants", RequireScopes(auth.ScopeUsersAdmin), dashboardController.GetTenants)
+	}
+}
@@ -3,8 +3,10 @@ package api
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/db"
 	"github.com/senma231/p3/server/device"
 )
 
@@ -20,17 +22,65 @@ func NewDeviceController(deviceService *device.Service) *DeviceController {
 	}
 }
 
+// tenantService 返回限定为当前请求租户的设备服务，其他租户的数据对其不可见
+func (c *DeviceController) tenantService(ctx *gin.Context) (*device.Service, bool) {
+	svc, err := c.deviceService.ForTenant(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return nil, false
+	}
+	return svc, true
+}
+
 // GetDevices 获取设备列表
 func (c *DeviceController) GetDevices(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return
+	}
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	// 按筛选条件或保存的筛选条件过滤
+	if expr := ctx.Query("filter"); expr != "" {
+		devices, err := svc.SelectDevices(userID.(uint), expr)
+		if err != nil {
+			respondError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"devices": devices,
+		})
+		return
+	}
+	if filterID := ctx.Query("filterId"); filterID != "" {
+		id, err := strconv.ParseUint(filterID, 10, 64)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": tr(ctx, "filter.invalidID"),
+			})
+			return
+		}
+		devices, err := svc.SelectDevicesByFilter(userID.(uint), uint(id))
+		if err != nil {
+			respondError(ctx, err)
+			return
+		}
+		ctx.JSON(http.StatusOK, gin.H{
+			"devices": devices,
 		})
 		return
 	}
 
-	devices, err := c.deviceService.GetDevicesByUserID(userID.(uint))
+	devices, err := svc.GetDevicesByUserID(userID.(uint))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -43,25 +93,52 @@ func (c *DeviceController) GetDevices(ctx *gin.Context) {
 	})
 }
 
+// GetDeviceStatuses 获取设备的状态快照，支持 status 查询参数，用于频繁刷新设备在线状态的页面
+func (c *DeviceController) GetDeviceStatuses(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	statuses, err := svc.GetDeviceStatuses(userID, ctx.Query("status"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"statuses": statuses,
+	})
+}
+
 // GetDevice 获取设备详情
 func (c *DeviceController) GetDevice(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
 
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
 	deviceID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的设备 ID",
+			"error": tr(ctx, "device.invalidID"),
 		})
 		return
 	}
 
-	device, err := c.deviceService.GetDeviceByID(uint(deviceID))
+	device, err := svc.GetDeviceByID(uint(deviceID))
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
@@ -72,11 +149,12 @@ func (c *DeviceController) GetDevice(ctx *gin.Context) {
 	// 检查设备是否属于当前用户
 	if device.UserID != userID.(uint) {
 		ctx.JSON(http.StatusForbidden, gin.H{
-			"error": "无权访问该设备",
+			"error": tr(ctx, "device.noAccess"),
 		})
 		return
 	}
 
+	setETag(ctx, device.Revision)
 	ctx.JSON(http.StatusOK, device)
 }
 
@@ -85,25 +163,27 @@ func (c *DeviceController) CreateDevice(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
 
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
 	var req struct {
-		Name   string `json:"name" binding:"required"`
-		NodeID string `json:"nodeId" binding:"required"`
+		Name   string `json:"name" binding:"required,max=50,safetext" sanitize:"text"`
+		NodeID string `json:"nodeId" binding:"required,max=50,safetext" sanitize:"text"`
 		Token  string `json:"token" binding:"required"`
 	}
 
-	if err := ctx.ShouldBindJSON(&req); err != nil {
-		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
-		})
+	if !bindJSON(ctx, &req) {
 		return
 	}
 
-	device, err := c.deviceService.CreateDevice(userID.(uint), req.Name, req.NodeID, req.Token)
+	device, err := svc.CreateDevice(userID.(uint), req.Name, req.NodeID, req.Token)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error(),
@@ -119,20 +199,25 @@ func (c *DeviceController) UpdateDevice(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
 
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
 	deviceID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的设备 ID",
+			"error": tr(ctx, "device.invalidID"),
 		})
 		return
 	}
 
-	device, err := c.deviceService.GetDeviceByID(uint(deviceID))
+	existing, err := svc.GetDeviceByID(uint(deviceID))
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
@@ -141,20 +226,27 @@ func (c *DeviceController) UpdateDevice(ctx *gin.Context) {
 	}
 
 	// 检查设备是否属于当前用户
-	if device.UserID != userID.(uint) {
+	if existing.UserID != userID.(uint) {
 		ctx.JSON(http.StatusForbidden, gin.H{
-			"error": "无权修改该设备",
+			"error": tr(ctx, "device.noUpdate"),
 		})
 		return
 	}
 
 	var req struct {
-		Name string `json:"name"`
+		Name     string            `json:"name" binding:"omitempty,max=50,safetext" sanitize:"text"`
+		Labels   map[string]string `json:"labels"`
+		Revision uint              `json:"revision"`
 	}
 
-	if err := ctx.ShouldBindJSON(&req); err != nil {
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	revision, ok := requestRevision(ctx, req.Revision)
+	if !ok {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
+			"error": tr(ctx, "request.invalidIfMatch"),
 		})
 		return
 	}
@@ -163,15 +255,30 @@ func (c *DeviceController) UpdateDevice(ctx *gin.Context) {
 	if req.Name != "" {
 		updates["name"] = req.Name
 	}
+	// 传入标签时整体替换，空对象清除所有标签
+	if req.Labels != nil {
+		if err := device.ValidateLabels(req.Labels); err != nil {
+			respondError(ctx, err)
+			return
+		}
+		updates["labels"] = db.Labels(req.Labels)
+	}
 
-	updatedDevice, err := c.deviceService.UpdateDevice(uint(deviceID), updates)
+	updatedDevice, err := svc.UpdateDevice(uint(deviceID), revision, updates)
 	if err != nil {
+		if isRevisionConflict(err) {
+			if current, err := svc.GetDeviceByID(uint(deviceID)); err == nil {
+				respondRevisionConflict(ctx, current, current.Revision)
+				return
+			}
+		}
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
 		return
 	}
 
+	setETag(ctx, updatedDevice.Revision)
 	ctx.JSON(http.StatusOK, updatedDevice)
 }
 
@@ -180,20 +287,25 @@ func (c *DeviceController) DeleteDevice(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
 
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
 	deviceID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的设备 ID",
+			"error": tr(ctx, "device.invalidID"),
 		})
 		return
 	}
 
-	device, err := c.deviceService.GetDeviceByID(uint(deviceID))
+	device, err := svc.GetDeviceByID(uint(deviceID))
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
@@ -204,12 +316,12 @@ func (c *DeviceController) DeleteDevice(ctx *gin.Context) {
 	// 检查设备是否属于当前用户
 	if device.UserID != userID.(uint) {
 		ctx.JSON(http.StatusForbidden, gin.H{
-			"error": "无权删除该设备",
+			"error": tr(ctx, "device.noDelete"),
 		})
 		return
 	}
 
-	if err := c.deviceService.DeleteDevice(uint(deviceID)); err != nil {
+	if err := svc.DeleteDevice(uint(deviceID)); err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
 		})
@@ -226,20 +338,25 @@ func (c *DeviceController) GetDeviceStats(ctx *gin.Context) {
 	userID, exists := ctx.Get("userID")
 	if !exists {
 		ctx.JSON(http.StatusUnauthorized, gin.H{
-			"error": "未授权",
+			"error": tr(ctx, "auth.unauthorized"),
 		})
 		return
 	}
 
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
 	deviceID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的设备 ID",
+			"error": tr(ctx, "device.invalidID"),
 		})
 		return
 	}
 
-	device, err := c.deviceService.GetDeviceByID(uint(deviceID))
+	device, err := svc.GetDeviceByID(uint(deviceID))
 	if err != nil {
 		ctx.JSON(http.StatusNotFound, gin.H{
 			"error": err.Error(),
@@ -250,12 +367,12 @@ func (c *DeviceController) GetDeviceStats(ctx *gin.Context) {
 	// 检查设备是否属于当前用户
 	if device.UserID != userID.(uint) {
 		ctx.JSON(http.StatusForbidden, gin.H{
-			"error": "无权访问该设备",
+			"error": tr(ctx, "device.noAccess"),
 		})
 		return
 	}
 
-	stats, err := c.deviceService.GetDeviceStats(uint(deviceID))
+	stats, err := svc.GetDeviceStats(uint(deviceID))
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error(),
@@ -263,5 +380,181 @@ func (c *DeviceController) GetDeviceStats(ctx *gin.Context) {
 		return
 	}
 
+	localizeStats(ctx, stats)
 	ctx.JSON(http.StatusOK, stats)
 }
+
+// GetDeviceEvents 获取设备上报的事件
+func (c *DeviceController) GetDeviceEvents(ctx *gin.Context) {
+	userID, exists := ctx.Get("userID")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return
+	}
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	deviceID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "device.invalidID"),
+		})
+		return
+	}
+
+	device, err := svc.GetDeviceByID(uint(deviceID))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 检查设备是否属于当前用户
+	if device.UserID != userID.(uint) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": tr(ctx, "device.noAccess"),
+		})
+		return
+	}
+
+	limit, _ := strconv.Atoi(ctx.Query("limit"))
+	events, err := svc.GetEvents(uint(deviceID), limit)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"events": events,
+	})
+}
+
+// ReportEvents 设备上报事件，如崩溃后恢复运行状态
+func (c *DeviceController) ReportEvents(ctx *gin.Context) {
+	deviceID := ctx.MustGet("deviceID").(uint)
+
+	var req struct {
+		Events []device.EventRequest `json:"events" binding:"required,dive"`
+	}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	events, err := c.deviceService.RecordEvents(deviceID, req.Events)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"events": events,
+	})
+}
+
+// ReportTrace 设备上报与对等节点的连接过程
+func (c *DeviceController) ReportTrace(ctx *gin.Context) {
+	deviceID := ctx.MustGet("deviceID").(uint)
+
+	var req device.TraceRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	trace, err := c.deviceService.RecordTrace(deviceID, &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, trace)
+}
+
+// GetDeviceTraces 获取设备上报的连接记录，可按对等节点过滤
+func (c *DeviceController) GetDeviceTraces(ctx *gin.Context) {
+	userID, exists := ctx.Get("userID")
+	if !exists {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return
+	}
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	deviceID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "device.invalidID"),
+		})
+		return
+	}
+
+	device, err := svc.GetDeviceByID(uint(deviceID))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	// 检查设备是否属于当前用户
+	if device.UserID != userID.(uint) {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": tr(ctx, "device.noAccess"),
+		})
+		return
+	}
+
+	limit, _ := strconv.Atoi(ctx.Query("limit"))
+	traces, err := svc.GetTraces(uint(deviceID), ctx.Query("peer"), limit)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"traces": traces,
+	})
+}
+
+// heartbeatResponse 心跳响应，在设备信息之外返回服务端时间，设备据此检查和修正本机时钟偏差
+type heartbeatResponse struct {
+	*db.Device
+	ServerTime time.Time `json:"serverTime"`
+}
+
+// UpdateStatus 设备上报心跳和状态，请求须经过签名验证
+func (c *DeviceController) UpdateStatus(ctx *gin.Context) {
+	receivedAt := time.Now()
+	var req device.DeviceStatusRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	current := ctx.MustGet("device").(*db.Device)
+	skew, _ := device.ClockSkew(req.ClientTime, receivedAt)
+
+	updated, err := c.deviceService.UpdateDeviceStatus(
+		current.NodeID, req.Status, req.NATType, req.ExternalIP, req.LocalIP, req.Version, req.OS, req.Arch, req.Region, skew,
+	)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, heartbeatResponse{Device: updated, ServerTime: time.Now().UTC()})
+}
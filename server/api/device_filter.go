@@ -0,0 +1,146 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/device"
+)
+
+// DeviceFilterController 保存的设备筛选条件控制器
+type DeviceFilterController struct {
+	deviceService *device.Service
+}
+
+// NewDeviceFilterController 创建保存的设备筛选条件控制器
+func NewDeviceFilterController(deviceService *device.Service) *DeviceFilterController {
+	return &DeviceFilterController{
+		deviceService: deviceService,
+	}
+}
+
+// tenantService 返回限定为当前请求租户的设备服务，其他租户的数据对其不可见
+func (c *DeviceFilterController) tenantService(ctx *gin.Context) (*device.Service, bool) {
+	svc, err := c.deviceService.ForTenant(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return nil, false
+	}
+	return svc, true
+}
+
+// GetFilters 获取保存的筛选条件列表
+func (c *DeviceFilterController) GetFilters(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	filters, err := svc.GetFilters(userID)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"filters": filters,
+	})
+}
+
+// CreateFilter 保存筛选条件
+func (c *DeviceFilterController) CreateFilter(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	var req device.FilterRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	filter, err := svc.CreateFilter(userID, &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, filter)
+}
+
+// GetFilterDevices 获取满足保存的筛选条件的设备
+func (c *DeviceFilterController) GetFilterDevices(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	filterID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "filter.invalidID"),
+		})
+		return
+	}
+
+	devices, err := svc.SelectDevicesByFilter(userID, uint(filterID))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"devices": devices,
+	})
+}
+
+// DeleteFilter 删除保存的筛选条件
+func (c *DeviceFilterController) DeleteFilter(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	filterID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "filter.invalidID"),
+		})
+		return
+	}
+
+	if err := svc.DeleteFilter(userID, uint(filterID)); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "筛选条件已删除",
+	})
+}
+
+// RegisterDeviceFilterRoutes 注册保存的设备筛选条件路由
+func RegisterDeviceFilterRoutes(router *gin.Engine, authService *auth.Service, deviceService *device.Service) {
+	deviceFilterController := NewDeviceFilterController(deviceService)
+
+	filters := router.Group("/api/v1/device-filters")
+	filters.Use(AuthMiddleware(authService))
+	{
+		filters.GET("", RequireScopes(auth.ScopeDevicesRead), deviceFilterController.GetFilters)
+		filters.POST("", RequireScopes(auth.ScopeDevicesWrite), deviceFilterController.CreateFilter)
+		filters.GET("/:id/devices", RequireScopes(auth.ScopeDevicesRead), deviceFilterController.GetFilterDevices)
+		filters.DELETE("/:id", RequireScopes(auth.ScopeDevicesWrite), deviceFilterController.DeleteFilter)
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/senma231/p3/common/errors"
 	"github.com/senma231/p3/server/device"
 )
 
@@ -20,10 +19,7 @@ func GetDevices(c *gin.Context) {
 	// 获取设备列表
 	devices, err := deviceService.GetDevices(userID)
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -52,10 +48,7 @@ func GetDevice(c *gin.Context) {
 	// 获取设备详情
 	device, err := deviceService.GetDevice(userID, uint(deviceID))
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -67,7 +60,7 @@ func CreateDevice(c *gin.Context) {
 	var req device.DeviceRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
+			"error": tr(c, "request.invalid"),
 		})
 		return
 	}
@@ -81,10 +74,7 @@ func CreateDevice(c *gin.Context) {
 	// 创建设备
 	device, err := deviceService.CreateDevice(userID, &req)
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -96,7 +86,7 @@ func UpdateDevice(c *gin.Context) {
 	var req device.DeviceUpdateRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
+			"error": tr(c, "request.invalid"),
 		})
 		return
 	}
@@ -119,10 +109,7 @@ func UpdateDevice(c *gin.Context) {
 	// 更新设备
 	device, err := deviceService.UpdateDevice(userID, uint(deviceID), &req)
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -148,10 +135,7 @@ func DeleteDevice(c *gin.Context) {
 
 	// 删除设备
 	if err := deviceService.DeleteDevice(userID, uint(deviceID)); err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -180,10 +164,7 @@ func RegenerateDeviceToken(c *gin.Context) {
 	// 重新生成设备令牌
 	token, err := deviceService.RegenerateToken(userID, uint(deviceID))
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -197,7 +178,7 @@ func UpdateDeviceStatus(c *gin.Context) {
 	var req device.DeviceStatusRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
+			"error": tr(c, "request.invalid"),
 		})
 		return
 	}
@@ -211,10 +192,7 @@ func UpdateDeviceStatus(c *gin.Context) {
 	// 更新设备状态
 	device, err := deviceService.UpdateDeviceStatus(deviceID, &req)
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -232,10 +210,7 @@ func GetDeviceApps(c *gin.Context) {
 	// 获取设备应用列表
 	apps, err := appService.GetAppsByDevice(deviceID)
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
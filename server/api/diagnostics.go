@@ -0,0 +1,116 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/api/middleware"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/device"
+	"github.com/senma231/p3/server/diagnostics"
+)
+
+// DiagnosticsController 设备诊断包控制器
+type DiagnosticsController struct {
+	deviceService *device.Service
+	diagnostics   *diagnostics.Service
+}
+
+// NewDiagnosticsController 创建设备诊断包控制器
+func NewDiagnosticsController(deviceService *device.Service, diagnosticsService *diagnostics.Service) *DiagnosticsController {
+	return &DiagnosticsController{
+		deviceService: deviceService,
+		diagnostics:   diagnosticsService,
+	}
+}
+
+// Upload 设备上传诊断包，请求体为 tar.gz 文件
+func (c *DiagnosticsController) Upload(ctx *gin.Context) {
+	dev := ctx.MustGet("device").(*db.Device)
+
+	bundle, err := c.diagnostics.Upload(ctx.Request.Context(), dev.ID, ctx.Request.Body, ctx.Request.ContentLength)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, bundle)
+}
+
+// ListBundles 列出设备的诊断包
+func (c *DiagnosticsController) ListBundles(ctx *gin.Context) {
+	dev, ok := c.ownedDevice(ctx)
+	if !ok {
+		return
+	}
+
+	bundles, err := c.diagnostics.List(ctx.Request.Context(), dev.ID)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"bundles": bundles,
+	})
+}
+
+// DownloadBundle 重定向到诊断包的签名下载链接
+func (c *DiagnosticsController) DownloadBundle(ctx *gin.Context) {
+	dev, ok := c.ownedDevice(ctx)
+	if !ok {
+		return
+	}
+
+	url, err := c.diagnostics.DownloadURL(ctx.Request.Context(), dev.ID, dev.NodeID, ctx.Param("name"))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.Redirect(http.StatusFound, url)
+}
+
+// ownedDevice 获取路径中属于当前请求租户的设备，其他租户的设备视为不存在，失败时已写入响应
+func (c *DiagnosticsController) ownedDevice(ctx *gin.Context) (*db.Device, bool) {
+	deviceID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		respondError(ctx, errors.InvalidParam("无效的设备 ID"))
+		return nil, false
+	}
+
+	svc, err := c.deviceService.ForTenant(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return nil, false
+	}
+	dev, err := svc.GetDeviceByID(uint(deviceID))
+	if err != nil {
+		respondError(ctx, errors.NotFound(err.Error()))
+		return nil, false
+	}
+	return dev, true
+}
+
+// RegisterDiagnosticsRoutes 注册设备诊断包路由
+func RegisterDiagnosticsRoutes(router *gin.Engine, authService *auth.Service, deviceService *device.Service, diagnosticsService *diagnostics.Service) {
+	diagnosticsController := NewDiagnosticsController(deviceService, diagnosticsService)
+
+	deviceAPI := router.Group("/api/v1/device")
+	deviceAPI.Use(middleware.DeviceAuth(deviceService))
+	{
+		deviceAPI.POST("/diagnostics", diagnosticsController.Upload)
+	}
+
+	devices := router.Group("/api/v1/devices")
+	devices.Use(AuthMiddleware(authService))
+	{
+		devices.GET("/:id/diagnostics", RequireScopes(auth.ScopeDevicesRead), diagnosticsController.ListBundles)
+		devices.GET("/:id/diagnostics/:name", RequireScopes(auth.ScopeDevicesRead), diagnosticsController.DownloadBundle)
+	}
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/config"
+)
+
+// NewEngine 创建 Gin 引擎。只有来自 server.trustedProxies 中代理的请求才使用 X-Forwarded-For
+// 和 X-Real-IP 确定客户端地址，其他请求使用连接的对端地址，避免伪造请求头绕过封禁和会话绑定检查
+func NewEngine(cfg *config.Config) *gin.Engine {
+	r := gin.Default()
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		// 配置加载时已校验，解析失败时 Gin 不信任任何代理
+		logger.Error("受信任的代理无效，不信任任何代理: %v", err)
+	}
+	return r
+}
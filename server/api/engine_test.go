@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/config"
+)
+
+func TestClientContextForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	request := func(cfg *config.Config, remoteAddr, forwardedFor string) auth.ClientContext {
+		var client auth.ClientContext
+		engine := NewEngine(cfg)
+		engine.GET("/login", func(ctx *gin.Context) {
+			client = clientContext(ctx)
+		})
+		req := httptest.NewRequest(http.MethodGet, "/login", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("User-Agent", "Mozilla/5.0 Firefox/120.0")
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+			req.Header.Set("X-Real-IP", forwardedFor)
+		}
+		engine.ServeHTTP(httptest.NewRecorder(), req)
+		return client
+	}
+
+	// 默认不信任任何代理，伪造的请求头不改变会话绑定使用的客户端环境
+	cfg := config.DefaultConfig()
+	direct := request(cfg, "203.0.113.5:40000", "")
+	forged := request(cfg, "203.0.113.5:40000", "198.51.100.7")
+	if direct.IP != "203.0.113.5" || forged != direct {
+		t.Fatalf("伪造 X-Forwarded-For 不应改变客户端环境: %+v %+v", direct, forged)
+	}
+
+	// 来自受信任代理的请求使用代理转发的客户端地址
+	cfg.Server.TrustedProxies = []string{"10.0.0.0/8"}
+	if got := request(cfg, "10.0.0.2:40000", "198.51.100.7"); got.IP != "198.51.100.7" {
+		t.Fatalf("应使用受信任代理转发的客户端地址: %+v", got)
+	}
+	if got := request(cfg, "203.0.113.5:40000", "198.51.100.7"); got.IP != "203.0.113.5" {
+		t.Fatalf("不应使用其他来源的 X-Forwarded-For: %+v", got)
+	}
+}
@@ -0,0 +1,189 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/export"
+	"github.com/senma231/p3/server/store"
+)
+
+// ExportController 数据导出控制器
+type ExportController struct {
+	st         *store.Store
+	jobManager *export.JobManager
+}
+
+// NewExportController 创建数据导出控制器
+func NewExportController(st *store.Store, jobManager *export.JobManager) *ExportController {
+	return &ExportController{
+		st:         st,
+		jobManager: jobManager,
+	}
+}
+
+// tenantExports 返回限定为当前请求租户的导出数据仓库，其他租户的数据对其不可见
+func (c *ExportController) tenantExports(ctx *gin.Context) (store.ExportRepo, bool) {
+	scoped, err := c.st.ForContext(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return nil, false
+	}
+	return scoped.Exports, true
+}
+
+// ExportJobRequest 异步导出任务请求
+type ExportJobRequest struct {
+	Resource string    `json:"resource" binding:"required"`
+	Format   string    `json:"format"`
+	Columns  []string  `json:"columns"`
+	Since    time.Time `json:"since"`
+}
+
+// Export 流式导出数据
+func (c *ExportController) Export(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+	exports, ok := c.tenantExports(ctx)
+	if !ok {
+		return
+	}
+
+	opts := &export.Options{
+		Resource: ctx.Param("resource"),
+		Format:   ctx.DefaultQuery("format", export.FormatCSV),
+	}
+	if columns := ctx.Query("columns"); columns != "" {
+		opts.Columns = strings.Split(columns, ",")
+	}
+	if since := ctx.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": tr(ctx, "export.invalidSince"),
+			})
+			return
+		}
+		opts.Since = t
+	}
+
+	if err := opts.Validate(); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", opts.Resource, time.Now().Format("20060102150405"), opts.Format)
+	ctx.Header("Content-Type", export.ContentType(opts.Format))
+	ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	ctx.Status(http.StatusOK)
+
+	// 响应头已发送，出错时只能中断响应
+	if err := export.Export(ctx.Writer, exports, userID, opts); err != nil {
+		logger.Error("导出数据失败: %v", err)
+		ctx.Abort()
+	}
+}
+
+// CreateJob 创建异步导出任务
+func (c *ExportController) CreateJob(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	var req ExportJobRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+	if req.Format == "" {
+		req.Format = export.FormatCSV
+	}
+
+	exports, ok := c.tenantExports(ctx)
+	if !ok {
+		return
+	}
+
+	job, err := c.jobManager.Submit(exports, userID, &export.Options{
+		Resource: req.Resource,
+		Format:   req.Format,
+		Columns:  req.Columns,
+		Since:    req.Since,
+	})
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// GetJob 获取异步导出任务状态
+func (c *ExportController) GetJob(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	job, exists := c.jobManager.GetJob(userID, ctx.Param("id"))
+	if !exists {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": tr(ctx, "export.jobNotFound"),
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, job)
+}
+
+// DownloadJob 下载异步导出任务结果
+func (c *ExportController) DownloadJob(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	job, exists := c.jobManager.GetJob(userID, ctx.Param("id"))
+	if !exists {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": tr(ctx, "export.jobNotFound"),
+		})
+		return
+	}
+
+	if job.Status != export.JobCompleted {
+		ctx.JSON(http.StatusConflict, gin.H{
+			"error":  tr(ctx, "export.jobPending"),
+			"status": job.Status,
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("%s-%s.%s", job.Resource, job.CreatedAt.Format("20060102150405"), job.Format)
+	url, err := c.jobManager.DownloadURL(job, filename)
+	if err != nil {
+		logger.Error("生成导出文件 %s 的下载链接失败: %v", job.ID, err)
+		respondError(ctx, errors.Internal("生成下载链接失败"))
+		return
+	}
+	ctx.Redirect(http.StatusFound, url)
+}
+
+// RegisterExportRoutes 注册数据导出路由
+func RegisterExportRoutes(router *gin.Engine, authService *auth.Service, st *store.Store, jobManager *export.JobManager) {
+	exportController := NewExportController(st, jobManager)
+
+	exports := router.Group("/api/v1/export")
+	exports.Use(AuthMiddleware(authService))
+	{
+		exports.POST("/jobs", RequireScopes(auth.ScopeExportRead), exportController.CreateJob)
+		exports.GET("/jobs/:id", RequireScopes(auth.ScopeExportRead), exportController.GetJob)
+		exports.GET("/jobs/:id/download", RequireScopes(auth.ScopeExportRead), exportController.DownloadJob)
+		exports.GET("/:resource", RequireScopes(auth.ScopeExportRead), exportController.Export)
+	}
+}
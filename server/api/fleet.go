@@ -0,0 +1,302 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/api/middleware"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/device"
+	"github.com/senma231/p3/server/fleet"
+)
+
+// FleetController 设备批量操作控制器
+type FleetController struct {
+	deviceService *device.Service
+	manager       *fleet.Manager
+	rollouts      *fleet.RolloutManager
+}
+
+// NewFleetController 创建设备批量操作控制器
+func NewFleetController(deviceService *device.Service, manager *fleet.Manager, rollouts *fleet.RolloutManager) *FleetController {
+	return &FleetController{
+		deviceService: deviceService,
+		manager:       manager,
+		rollouts:      rollouts,
+	}
+}
+
+// tenantService 返回限定为当前请求租户的设备服务，其他租户的数据对其不可见
+func (c *FleetController) tenantService(ctx *gin.Context) (*device.Service, bool) {
+	svc, err := c.deviceService.ForTenant(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return nil, false
+	}
+	return svc, true
+}
+
+// FleetTargets 批量操作的目标设备，可以同时指定设备 ID、筛选条件和保存的筛选条件，取并集
+type FleetTargets struct {
+	DeviceIDs []uint `json:"deviceIds"`
+	Selector  string `json:"selector" binding:"max=500"`
+	FilterID  uint   `json:"filterId"`
+}
+
+// FleetJobRequest 批量操作请求
+type FleetJobRequest struct {
+	FleetTargets
+	Action string            `json:"action" binding:"required,max=50"`
+	Params map[string]string `json:"params"`
+}
+
+// RolloutRequest 灰度发布请求
+type RolloutRequest struct {
+	FleetTargets
+	fleet.RolloutSpec
+}
+
+// CommandResultRequest 设备回报指令执行结果
+type CommandResultRequest struct {
+	Success bool   `json:"success"`
+	Error   string `json:"error" binding:"max=500" sanitize:"text"`
+}
+
+// CreateJob 对多台设备执行批量操作，指令异步下发，通过任务 ID 查询各设备的执行结果
+func (c *FleetController) CreateJob(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	var req FleetJobRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	devices, err := c.targetDevices(svc, userID, &req.FleetTargets)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	job, err := c.manager.Submit(userID, req.Action, req.Params, devices)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, job)
+}
+
+// ListJobs 获取批量操作任务列表
+func (c *FleetController) ListJobs(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"jobs": c.manager.ListJobs(userID),
+	})
+}
+
+// GetJob 获取批量操作任务及各设备的执行结果
+func (c *FleetController) GetJob(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	job, exists := c.manager.GetJob(userID, ctx.Param("id"))
+	if !exists {
+		respondError(ctx, errors.NotFound("任务不存在"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, job)
+}
+
+// CreateRollout 创建灰度发布，按阶段依次对设备执行操作
+func (c *FleetController) CreateRollout(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	var req RolloutRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	devices, err := c.targetDevices(svc, userID, &req.FleetTargets)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	rollout, err := c.rollouts.Create(userID, &req.RolloutSpec, devices)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, rollout)
+}
+
+// ListRollouts 获取灰度发布列表
+func (c *FleetController) ListRollouts(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"rollouts": c.rollouts.ListRollouts(userID),
+	})
+}
+
+// GetRollout 获取灰度发布及各阶段的执行情况
+func (c *FleetController) GetRollout(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	rollout, exists := c.rollouts.GetRollout(userID, ctx.Param("id"))
+	if !exists {
+		respondError(ctx, errors.NotFound("灰度发布不存在"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rollout)
+}
+
+// HaltRollout 暂停灰度发布
+func (c *FleetController) HaltRollout(ctx *gin.Context) {
+	c.changeRollout(ctx, c.rollouts.Halt)
+}
+
+// ResumeRollout 恢复暂停的灰度发布
+func (c *FleetController) ResumeRollout(ctx *gin.Context) {
+	c.changeRollout(ctx, c.rollouts.Resume)
+}
+
+// RollbackRollout 回滚灰度发布已执行的设备
+func (c *FleetController) RollbackRollout(ctx *gin.Context) {
+	c.changeRollout(ctx, c.rollouts.StartRollback)
+}
+
+// changeRollout 修改灰度发布的状态
+func (c *FleetController) changeRollout(ctx *gin.Context, change func(userID uint, id string) (*fleet.Rollout, error)) {
+	userID := ctx.MustGet("userID").(uint)
+
+	rollout, err := change(userID, ctx.Param("id"))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, rollout)
+}
+
+// targetDevices 通过限定为当前请求租户的设备服务 svc 获取批量操作的目标设备，
+// 重复的设备只保留一个，任一设备 ID 不属于用户时返回错误
+func (c *FleetController) targetDevices(svc *device.Service, userID uint, targets *FleetTargets) ([]db.Device, error) {
+	if len(targets.DeviceIDs) == 0 && targets.Selector == "" && targets.FilterID == 0 {
+		return nil, errors.InvalidParam("请选择设备")
+	}
+
+	owned, err := svc.GetDevicesByUserID(userID)
+	if err != nil {
+		return nil, errors.Database("查询设备失败", err)
+	}
+	byID := make(map[uint]db.Device, len(owned))
+	for _, device := range owned {
+		byID[device.ID] = device
+	}
+
+	devices := make([]db.Device, 0, len(targets.DeviceIDs))
+	seen := make(map[uint]bool, len(targets.DeviceIDs))
+	add := func(device db.Device) {
+		if !seen[device.ID] {
+			seen[device.ID] = true
+			devices = append(devices, device)
+		}
+	}
+	for _, id := range targets.DeviceIDs {
+		device, ok := byID[id]
+		if !ok {
+			return nil, errors.NotFound("设备不存在")
+		}
+		add(device)
+	}
+	if targets.Selector != "" {
+		selected, err := svc.SelectDevices(userID, targets.Selector)
+		if err != nil {
+			return nil, err
+		}
+		for _, device := range selected {
+			add(device)
+		}
+	}
+	if targets.FilterID != 0 {
+		selected, err := svc.SelectDevicesByFilter(userID, targets.FilterID)
+		if err != nil {
+			return nil, err
+		}
+		for _, device := range selected {
+			add(device)
+		}
+	}
+
+	if len(devices) == 0 {
+		return nil, errors.InvalidParam("没有符合条件的设备")
+	}
+	return devices, nil
+}
+
+// ReportResult 设备回报指令执行结果
+func (c *FleetController) ReportResult(ctx *gin.Context) {
+	deviceID := ctx.MustGet("deviceID").(uint)
+
+	var req CommandResultRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	if err := c.manager.ReportResult(deviceID, ctx.Param("id"), req.Success, req.Error); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// RegisterFleetRoutes 注册设备批量操作和灰度发布路由
+func RegisterFleetRoutes(router *gin.Engine, authService *auth.Service, deviceService *device.Service, manager *fleet.Manager, rolloutManager *fleet.RolloutManager) {
+	fleetController := NewFleetController(deviceService, manager, rolloutManager)
+
+	jobs := router.Group("/api/v1/fleet/jobs")
+	jobs.Use(AuthMiddleware(authService))
+	{
+		jobs.GET("", RequireScopes(auth.ScopeDevicesRead), fleetController.ListJobs)
+		jobs.POST("", RequireScopes(auth.ScopeDevicesWrite), fleetController.CreateJob)
+		jobs.GET("/:id", RequireScopes(auth.ScopeDevicesRead), fleetController.GetJob)
+	}
+
+	rollouts := router.Group("/api/v1/fleet/rollouts")
+	rollouts.Use(AuthMiddleware(authService))
+	{
+		rollouts.GET("", RequireScopes(auth.ScopeDevicesRead), fleetController.ListRollouts)
+		rollouts.POST("", RequireScopes(auth.ScopeDevicesWrite), fleetController.CreateRollout)
+		rollouts.GET("/:id", RequireScopes(auth.ScopeDevicesRead), fleetController.GetRollout)
+		rollouts.POST("/:id/halt", RequireScopes(auth.ScopeDevicesWrite), fleetController.HaltRollout)
+		rollouts.POST("/:id/resume", RequireScopes(auth.ScopeDevicesWrite), fleetController.ResumeRollout)
+		rollouts.POST("/:id/rollback", RequireScopes(auth.ScopeDevicesWrite), fleetController.RollbackRollout)
+	}
+
+	commands := router.Group("/api/v1/device/commands")
+	commands.Use(middleware.DeviceAuth(deviceService))
+	{
+		commands.POST("/:id/result", fleetController.ReportResult)
+	}
+}
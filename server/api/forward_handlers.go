@@ -5,14 +5,28 @@ import (
 	"strconv"
 
 	"github.com/gin-gonic/gin"
-	"github.com/senma231/p3/common/errors"
 	"github.com/senma231/p3/server/forward"
 )
 
+// tenantForwardService 返回限定为当前请求租户的转发服务，其他租户的转发规则对其不可见
+func tenantForwardService(c *gin.Context) (*forward.Service, bool) {
+	forwardService, err := c.MustGet("forwardService").(*forward.Service).ForTenant(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(c, "auth.unauthorized"),
+		})
+		return nil, false
+	}
+	return forwardService, true
+}
+
 // GetForwards 获取转发规则列表
 func GetForwards(c *gin.Context) {
-	// 获取转发服务
-	forwardService := c.MustGet("forwardService").(*forward.Service)
+	// 获取限定为当前请求租户的转发服务
+	forwardService, ok := tenantForwardService(c)
+	if !ok {
+		return
+	}
 
 	// 从上下文中获取用户 ID
 	userID := c.MustGet("userID").(uint)
@@ -20,10 +34,7 @@ func GetForwards(c *gin.Context) {
 	// 获取转发规则列表
 	forwards, err := forwardService.GetForwards(userID)
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -34,8 +45,11 @@ func GetForwards(c *gin.Context) {
 
 // GetForward 获取转发规则详情
 func GetForward(c *gin.Context) {
-	// 获取转发服务
-	forwardService := c.MustGet("forwardService").(*forward.Service)
+	// 获取限定为当前请求租户的转发服务
+	forwardService, ok := tenantForwardService(c)
+	if !ok {
+		return
+	}
 
 	// 从上下文中获取用户 ID
 	userID := c.MustGet("userID").(uint)
@@ -44,7 +58,7 @@ func GetForward(c *gin.Context) {
 	forwardID, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的转发规则 ID",
+			"error": tr(c, "forward.invalidID"),
 		})
 		return
 	}
@@ -52,28 +66,26 @@ func GetForward(c *gin.Context) {
 	// 获取转发规则详情
 	forward, err := forwardService.GetForward(userID, uint(forwardID))
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
+	setETag(c, forward.Revision)
 	c.JSON(http.StatusOK, forward)
 }
 
 // CreateForward 创建转发规则
 func CreateForward(c *gin.Context) {
 	var req forward.ForwardRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
-		})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	// 获取转发服务
-	forwardService := c.MustGet("forwardService").(*forward.Service)
+	// 获取限定为当前请求租户的转发服务
+	forwardService, ok := tenantForwardService(c)
+	if !ok {
+		return
+	}
 
 	// 从上下文中获取用户 ID
 	userID := c.MustGet("userID").(uint)
@@ -81,10 +93,7 @@ func CreateForward(c *gin.Context) {
 	// 创建转发规则
 	forward, err := forwardService.CreateForward(userID, &req)
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -94,15 +103,15 @@ func CreateForward(c *gin.Context) {
 // UpdateForward 更新转发规则
 func UpdateForward(c *gin.Context) {
 	var req forward.ForwardUpdateRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的请求参数",
-		})
+	if !bindJSON(c, &req) {
 		return
 	}
 
-	// 获取转发服务
-	forwardService := c.MustGet("forwardService").(*forward.Service)
+	// 获取限定为当前请求租户的转发服务
+	forwardService, ok := tenantForwardService(c)
+	if !ok {
+		return
+	}
 
 	// 从上下文中获取用户 ID
 	userID := c.MustGet("userID").(uint)
@@ -111,28 +120,45 @@ func UpdateForward(c *gin.Context) {
 	forwardID, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的转发规则 ID",
+			"error": tr(c, "forward.invalidID"),
 		})
 		return
 	}
 
+	// If-Match 头优先于请求体中的修订号
+	revision, ok := requestRevision(c, req.Revision)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(c, "request.invalidIfMatch"),
+		})
+		return
+	}
+	req.Revision = revision
+
 	// 更新转发规则
 	forward, err := forwardService.UpdateForward(userID, uint(forwardID), &req)
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		if isRevisionConflict(err) {
+			if current, err := forwardService.GetForward(userID, uint(forwardID)); err == nil {
+				respondRevisionConflict(c, current, current.Revision)
+				return
+			}
+		}
+		respondError(c, err)
 		return
 	}
 
+	setETag(c, forward.Revision)
 	c.JSON(http.StatusOK, forward)
 }
 
 // DeleteForward 删除转发规则
 func DeleteForward(c *gin.Context) {
-	// 获取转发服务
-	forwardService := c.MustGet("forwardService").(*forward.Service)
+	// 获取限定为当前请求租户的转发服务
+	forwardService, ok := tenantForwardService(c)
+	if !ok {
+		return
+	}
 
 	// 从上下文中获取用户 ID
 	userID := c.MustGet("userID").(uint)
@@ -141,17 +167,14 @@ func DeleteForward(c *gin.Context) {
 	forwardID, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的转发规则 ID",
+			"error": tr(c, "forward.invalidID"),
 		})
 		return
 	}
 
 	// 删除转发规则
 	if err := forwardService.DeleteForward(userID, uint(forwardID)); err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -162,8 +185,11 @@ func DeleteForward(c *gin.Context) {
 
 // EnableForward 启用转发规则
 func EnableForward(c *gin.Context) {
-	// 获取转发服务
-	forwardService := c.MustGet("forwardService").(*forward.Service)
+	// 获取限定为当前请求租户的转发服务
+	forwardService, ok := tenantForwardService(c)
+	if !ok {
+		return
+	}
 
 	// 从上下文中获取用户 ID
 	userID := c.MustGet("userID").(uint)
@@ -172,7 +198,7 @@ func EnableForward(c *gin.Context) {
 	forwardID, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的转发规则 ID",
+			"error": tr(c, "forward.invalidID"),
 		})
 		return
 	}
@@ -180,10 +206,7 @@ func EnableForward(c *gin.Context) {
 	// 启用转发规则
 	forward, err := forwardService.EnableForward(userID, uint(forwardID))
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -192,8 +215,11 @@ func EnableForward(c *gin.Context) {
 
 // DisableForward 禁用转发规则
 func DisableForward(c *gin.Context) {
-	// 获取转发服务
-	forwardService := c.MustGet("forwardService").(*forward.Service)
+	// 获取限定为当前请求租户的转发服务
+	forwardService, ok := tenantForwardService(c)
+	if !ok {
+		return
+	}
 
 	// 从上下文中获取用户 ID
 	userID := c.MustGet("userID").(uint)
@@ -202,7 +228,7 @@ func DisableForward(c *gin.Context) {
 	forwardID, err := strconv.ParseUint(c.Param("id"), 10, 64)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "无效的转发规则 ID",
+			"error": tr(c, "forward.invalidID"),
 		})
 		return
 	}
@@ -210,10 +236,7 @@ func DisableForward(c *gin.Context) {
 	// 禁用转发规则
 	forward, err := forwardService.DisableForward(userID, uint(forwardID))
 	if err != nil {
-		errObj := errors.AsError(err)
-		c.JSON(errObj.StatusCode(), gin.H{
-			"error": errObj.Error(),
-		})
+		respondError(c, err)
 		return
 	}
 
@@ -31,7 +31,7 @@ func (h *GroupHandler) CreateGroup(c *gin.Context) {
 	// 获取当前用户 ID
 	userID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": tr(c, "auth.unauthorized")})
 		return
 	}
 	group.UserID = userID.(uint)
@@ -50,7 +50,7 @@ func (h *GroupHandler) GetGroups(c *gin.Context) {
 	// 获取当前用户 ID
 	userID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": tr(c, "auth.unauthorized")})
 		return
 	}
 
@@ -0,0 +1,108 @@
+package api
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/i18n"
+	"github.com/senma231/p3/server/db"
+	"gorm.io/gorm"
+)
+
+// LocaleMiddleware 按 Accept-Language 请求头选择错误消息的语言
+func LocaleMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		lang := i18n.Match(ctx.GetHeader("Accept-Language"))
+		ctx.Set("lang", lang)
+		ctx.Header("Content-Language", lang)
+		ctx.Next()
+	}
+}
+
+// requestLang 获取请求的语言，未经过 LocaleMiddleware 时为默认语言
+func requestLang(ctx *gin.Context) string {
+	if lang := ctx.GetString("lang"); lang != "" {
+		return lang
+	}
+	return i18n.DefaultLang
+}
+
+// tr 获取请求语言的消息
+func tr(ctx *gin.Context, key string) string {
+	return i18n.T(requestLang(ctx), key)
+}
+
+// respondError 按错误码返回 HTTP 状态码和请求语言的错误信息，code 字段不受语言影响
+func respondError(ctx *gin.Context, err error) {
+	errObj := errors.AsError(err)
+	ctx.JSON(errObj.StatusCode(), gin.H{
+		"error": errObj.Localize(requestLang(ctx)),
+		"code":  errObj.Code,
+	})
+}
+
+// localizeStats 在统计信息中加入按请求语言格式化的字段，原始数值保持不变：
+// display 为便于阅读的流量、计数和连接时长，connectionTimeIso 为 ISO 8601 时长。
+// 时间统一转换为 UTC，并通过 timezone 字段提示客户端按本地时区显示
+func localizeStats(ctx *gin.Context, stats map[string]interface{}) {
+	lang := requestLang(ctx)
+	display := gin.H{}
+	for key, value := range stats {
+		n, ok := statsCounter(value)
+		if !ok {
+			continue
+		}
+		switch key {
+		case "bytesSent", "bytesReceived":
+			display[key] = i18n.FormatBytes(n)
+		case "connectionTime":
+			d := time.Duration(n) * time.Second
+			display[key] = i18n.FormatDuration(lang, d)
+			stats["connectionTimeIso"] = i18n.ISODuration(d)
+		default:
+			display[key] = i18n.FormatCount(lang, n)
+		}
+	}
+
+	switch model := stats["device"].(type) {
+	case *db.Device:
+		utcModel(&model.Model)
+		model.LastSeenAt = model.LastSeenAt.UTC()
+	}
+	switch model := stats["app"].(type) {
+	case *db.App:
+		utcModel(&model.Model)
+		if model.HealthCheckedAt != nil {
+			checkedAt := model.HealthCheckedAt.UTC()
+			model.HealthCheckedAt = &checkedAt
+		}
+	}
+
+	stats["display"] = display
+	stats["generatedAt"] = time.Now().UTC()
+	stats["timezone"] = "UTC"
+}
+
+// statsCounter 将统计信息中的计数转换为 uint64，不是计数时返回 false
+func statsCounter(value interface{}) (uint64, bool) {
+	switch n := value.(type) {
+	case uint64:
+		return n, true
+	case int64:
+		if n >= 0 {
+			return uint64(n), true
+		}
+	case int:
+		if n >= 0 {
+			return uint64(n), true
+		}
+	}
+	return 0, false
+}
+
+// utcModel 将模型的创建和更新时间转换为 UTC
+func utcModel(model *gorm.Model) {
+	model.CreatedAt = model.CreatedAt.UTC()
+	model.UpdatedAt = model.UpdatedAt.UTC()
+}
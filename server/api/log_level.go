@@ -0,0 +1,60 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/auth"
+)
+
+// LogLevelController 运行时日志级别控制器，修改只对当前进程生效，重启后恢复配置文件中的级别
+type LogLevelController struct{}
+
+// NewLogLevelController 创建运行时日志级别控制器
+func NewLogLevelController() *LogLevelController {
+	return &LogLevelController{}
+}
+
+// UpdateLogLevelsRequest 修改日志级别请求
+type UpdateLogLevelsRequest struct {
+	Level   string            `json:"level"`   // 全局日志级别，为空时不修改
+	Modules map[string]string `json:"modules"` // 要修改的模块，级别为空时清除该模块的级别
+}
+
+// GetLogLevels 获取全局和各模块的日志级别
+func (c *LogLevelController) GetLogLevels(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"level":   logger.CurrentLevel().Name(),
+		"modules": logger.ModuleLevelNames(),
+	})
+}
+
+// UpdateLogLevels 修改全局或部分模块的日志级别，未提及的模块保持不变
+func (c *LogLevelController) UpdateLogLevels(ctx *gin.Context) {
+	var req UpdateLogLevelsRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	if err := logger.ApplyLevels(req.Level, req.Modules); err != nil {
+		respondError(ctx, errors.InvalidParam(err.Error()))
+		return
+	}
+	logger.Info("用户 %d 修改了日志级别: 全局 %s，模块 %v", ctx.GetUint("userID"), logger.CurrentLevel().Name(), logger.ModuleLevelNames())
+
+	c.GetLogLevels(ctx)
+}
+
+// RegisterLogLevelRoutes 注册运行时日志级别路由
+func RegisterLogLevelRoutes(router *gin.Engine, authService *auth.Service) {
+	logLevelController := NewLogLevelController()
+
+	logLevels := router.Group("/api/v1/log-levels")
+	logLevels.Use(AuthMiddleware(authService))
+	{
+		logLevels.GET("", RequireScopes(auth.ScopeUsersAdmin), logLevelController.GetLogLevels)
+		logLevels.PUT("", RequireScopes(auth.ScopeUsersAdmin), logLevelController.UpdateLogLevels)
+	}
+}
@@ -1,11 +1,17 @@
 package api
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/cors"
+	"github.com/senma231/p3/common/logger"
 	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/store"
 )
 
 // AuthMiddleware 认证中间件
@@ -15,7 +21,7 @@ func AuthMiddleware(authService *auth.Service) gin.HandlerFunc {
 		authHeader := ctx.GetHeader("Authorization")
 		if authHeader == "" {
 			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "未提供认证信息",
+				"error": tr(ctx, "auth.missing"),
 			})
 			ctx.Abort()
 			return
@@ -25,17 +31,50 @@ func AuthMiddleware(authService *auth.Service) gin.HandlerFunc {
 		parts := strings.SplitN(authHeader, " ", 2)
 		if !(len(parts) == 2 && parts[0] == "Bearer") {
 			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "认证格式错误",
+				"error": tr(ctx, "auth.malformed"),
 			})
 			ctx.Abort()
 			return
 		}
 
+		// API 密钥按密钥的授权范围认证
+		if strings.HasPrefix(parts[1], auth.APIKeyPrefix) {
+			key, user, scopes, err := authService.AuthenticateAPIKey(parts[1])
+			if err != nil {
+				if !errors.Is(err, auth.ErrAPIKeyInvalid) {
+					logger.Error("验证 API 密钥失败: %v", err)
+				}
+				ctx.JSON(http.StatusUnauthorized, gin.H{
+					"error": tr(ctx, "auth.invalidToken"),
+				})
+				ctx.Abort()
+				return
+			}
+
+			ctx.Set("userID", user.ID)
+			ctx.Set("username", user.Username)
+			ctx.Set("apiKeyID", key.ID)
+			ctx.Set("scopes", scopes)
+			ctx.Request = ctx.Request.WithContext(store.WithTenant(ctx.Request.Context(), user.ID))
+			ctx.Next()
+			return
+		}
+
 		// 解析 Token
 		claims, err := authService.ParseToken(parts[1])
 		if err != nil {
 			ctx.JSON(http.StatusUnauthorized, gin.H{
-				"error": "无效的 Token",
+				"error": tr(ctx, "auth.invalidToken"),
+			})
+			ctx.Abort()
+			return
+		}
+
+		// 旧版令牌没有授权范围，无法确定签发时的角色，需要刷新后按用户当前的角色重新签发
+		if len(claims.Scopes) == 0 {
+			ctx.JSON(http.StatusUnauthorized, gin.H{
+				"error":           tr(ctx, "auth.refreshRequired"),
+				"refreshRequired": true,
 			})
 			ctx.Abort()
 			return
@@ -44,23 +83,103 @@ func AuthMiddleware(authService *auth.Service) gin.HandlerFunc {
 		// 将用户信息存储到上下文
 		ctx.Set("userID", claims.UserID)
 		ctx.Set("username", claims.Username)
+		ctx.Set("scopes", claims.Scopes)
+		// 仓库按请求 context 中的租户过滤数据
+		ctx.Request = ctx.Request.WithContext(store.WithTenant(ctx.Request.Context(), claims.UserID))
 
 		ctx.Next()
 	}
 }
 
-// CORSMiddleware 跨域中间件
-func CORSMiddleware() gin.HandlerFunc {
+// RequireScopes 授权范围检查中间件，缺少任一授权范围时返回 403
+func RequireScopes(required ...auth.Scope) gin.HandlerFunc {
 	return func(ctx *gin.Context) {
-		ctx.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		ctx.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		ctx.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		ctx.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		var granted []string
+		if scopes, exists := ctx.Get("scopes"); exists {
+			granted, _ = scopes.([]string)
+		}
+
+		if missing := auth.MissingScopes(granted, required...); len(missing) > 0 {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"error":         tr(ctx, "auth.forbidden"),
+				"missingScopes": missing,
+			})
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// RequireVerifiedEmail 邮箱验证检查中间件，启用邮箱验证且当前用户尚未验证时返回 403
+func RequireVerifiedEmail(authService *auth.Service) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		userID, _ := ctx.Get("userID")
+		id, _ := userID.(uint)
+
+		if err := authService.CheckEmailVerified(id); err != nil {
+			status := http.StatusInternalServerError
+			body := gin.H{"error": err.Error()}
+			switch {
+			case errors.Is(err, auth.ErrEmailNotVerified):
+				status = http.StatusForbidden
+				body["emailVerificationRequired"] = true
+			case errors.Is(err, auth.ErrUserNotFound):
+				status = http.StatusUnauthorized
+			}
+			ctx.JSON(status, body)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// CORSMiddleware 跨域中间件，仅允许配置中列出的来源，其他来源的预检请求返回 403。
+// 配置无效时不允许任何跨域请求
+func CORSMiddleware(cfg config.CORSConfig) gin.HandlerFunc {
+	c, err := cors.New(cfg)
+	if err != nil {
+		logger.Error("跨域配置无效，已禁止跨域请求: %v", err)
+		c, _ = cors.New(cors.Config{})
+	}
 
-		if ctx.Request.Method == "OPTIONS" {
-			ctx.AbortWithStatus(204)
+	return func(ctx *gin.Context) {
+		if c.Handle(ctx.Writer, ctx.Request) {
+			ctx.Abort()
 			return
 		}
+		ctx.Next()
+	}
+}
+
+// SecurityHeadersMiddleware 安全响应头中间件，HSTS 仅在 HTTPS 请求（包括反向代理转发的 HTTPS 请求）中设置
+func SecurityHeadersMiddleware(cfg config.SecurityHeadersConfig) gin.HandlerFunc {
+	hsts := ""
+	if cfg.HSTSMaxAge > 0 {
+		hsts = "max-age=" + strconv.Itoa(cfg.HSTSMaxAge)
+		if cfg.HSTSIncludeSubdomains {
+			hsts += "; includeSubDomains"
+		}
+	}
+
+	return func(ctx *gin.Context) {
+		header := ctx.Writer.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		if cfg.ContentSecurityPolicy != "" {
+			header.Set("Content-Security-Policy", cfg.ContentSecurityPolicy)
+		}
+		if cfg.FrameOptions != "" {
+			header.Set("X-Frame-Options", cfg.FrameOptions)
+		}
+		if cfg.ReferrerPolicy != "" {
+			header.Set("Referrer-Policy", cfg.ReferrerPolicy)
+		}
+		if hsts != "" && (ctx.Request.TLS != nil || ctx.GetHeader("X-Forwarded-Proto") == "https") {
+			header.Set("Strict-Transport-Security", hsts)
+		}
 
 		ctx.Next()
 	}
@@ -1,14 +1,20 @@
 package middleware
 
 import (
+	"bytes"
+	"io"
 	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/cors"
 	"github.com/senma231/p3/common/errors"
 	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/signing"
 	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/db"
 	"github.com/senma231/p3/server/device"
+	"github.com/senma231/p3/server/store"
 )
 
 // Auth 认证中间件
@@ -50,14 +56,42 @@ func Auth(authService *auth.Service) gin.HandlerFunc {
 		// 将用户信息存储在上下文中
 		c.Set("user", user)
 		c.Set("userID", user.ID)
+		// 仓库按请求 context 中的租户过滤数据
+		c.Request = c.Request.WithContext(store.WithTenant(c.Request.Context(), user.ID))
 
 		c.Next()
 	}
 }
 
 // DeviceAuth 设备认证中间件
+//
+// 请求携带设备证书时使用证书认证并验证证书私钥的请求签名，否则使用节点 ID 和令牌认证；
+// 持有有效证书的设备不再接受令牌认证。
 func DeviceAuth(deviceService *device.Service) gin.HandlerFunc {
+	certVerifier := signing.NewVerifier(signing.DefaultWindow)
+
 	return func(c *gin.Context) {
+		if device.UsesCertificate(c.Request) {
+			dev, err := deviceService.AuthenticateCertificate(c.Request, certVerifier)
+			if err != nil {
+				errObj := errors.AsError(err)
+				c.JSON(errObj.StatusCode(), gin.H{
+					"error": errObj.Error(),
+				})
+				c.Abort()
+				return
+			}
+
+			c.Set("device", dev)
+			c.Set("deviceID", dev.ID)
+			c.Set("userID", dev.UserID)
+			c.Request = c.Request.WithContext(store.WithTenant(c.Request.Context(), dev.UserID))
+			c.Set("deviceCertificate", true)
+
+			c.Next()
+			return
+		}
+
 		// 从请求头获取节点 ID 和令牌
 		nodeID := c.GetHeader("X-Node-ID")
 		token := c.GetHeader("X-Node-Token")
@@ -72,6 +106,9 @@ func DeviceAuth(deviceService *device.Service) gin.HandlerFunc {
 
 		// 认证设备
 		device, err := deviceService.AuthenticateDevice(nodeID, token)
+		if err == nil {
+			err = deviceService.CheckTokenAllowed(device)
+		}
 		if err != nil {
 			errObj := errors.AsError(err)
 			c.JSON(errObj.StatusCode(), gin.H{
@@ -85,21 +122,40 @@ func DeviceAuth(deviceService *device.Service) gin.HandlerFunc {
 		c.Set("device", device)
 		c.Set("deviceID", device.ID)
 		c.Set("userID", device.UserID)
+		c.Request = c.Request.WithContext(store.WithTenant(c.Request.Context(), device.UserID))
 
 		c.Next()
 	}
 }
 
-// CORS CORS 中间件
-func CORS() gin.HandlerFunc {
+// DeviceSignature 设备请求签名验证中间件，需在 DeviceAuth 之后使用
+//
+// 请求体须使用设备令牌进行 HMAC 签名，并携带时间戳和随机数以防止重放。
+// 使用证书认证的请求已在 DeviceAuth 中验证过证书私钥的签名。
+func DeviceSignature(verifier *signing.Verifier) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		if c.GetBool("deviceCertificate") {
+			c.Next()
+			return
+		}
+		device := c.MustGet("device").(*db.Device)
+
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "读取请求体失败",
+			})
+			c.Abort()
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		if err := verifier.VerifyRequest(c.Request, device.NodeID, device.Token, body); err != nil {
+			logger.Warn("设备 %s 请求签名验证失败: %v", device.NodeID, err)
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": err.Error(),
+			})
+			c.Abort()
 			return
 		}
 
@@ -107,6 +163,23 @@ func CORS() gin.HandlerFunc {
 	}
 }
 
+// CORS CORS 中间件，只允许配置中列出的来源
+func CORS(cfg cors.Config) gin.HandlerFunc {
+	c, err := cors.New(cfg)
+	if err != nil {
+		logger.Error("跨域配置无效，已禁止跨域请求: %v", err)
+		c, _ = cors.New(cors.Config{})
+	}
+
+	return func(ctx *gin.Context) {
+		if c.Handle(ctx.Writer, ctx.Request) {
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
 // Logger 日志中间件
 func Logger() gin.HandlerFunc {
 	return func(c *gin.Context) {
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/objstore"
+)
+
+// ObjectController 本地对象存储的签名下载链接，链接本身即授权，不需要登录
+type ObjectController struct {
+	store *objstore.LocalStore
+}
+
+// NewObjectController 创建本地对象下载控制器
+func NewObjectController(store *objstore.LocalStore) *ObjectController {
+	return &ObjectController{
+		store: store,
+	}
+}
+
+// Download 验证签名后返回对象内容
+func (c *ObjectController) Download(ctx *gin.Context) {
+	key := strings.TrimPrefix(ctx.Param("key"), "/")
+	filename := ctx.Query("filename")
+
+	err := c.store.Verify(key, ctx.Query("expires"), filename, ctx.Query("signature"), time.Now())
+	if errors.Is(err, objstore.ErrURLExpired) {
+		ctx.JSON(http.StatusGone, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusForbidden, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+
+	r, obj, err := c.store.Open(ctx.Request.Context(), key)
+	if errors.Is(err, objstore.ErrNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"error": err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logger.Error("读取对象 %s 失败: %v", key, err)
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"error": tr(ctx, "object.readFailed"),
+		})
+		return
+	}
+	defer r.Close()
+
+	if filename != "" {
+		ctx.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	}
+	// 本地存储返回 *os.File，支持断点续传
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		http.ServeContent(ctx.Writer, ctx.Request, filename, obj.ModTime, seeker)
+		return
+	}
+	ctx.DataFromReader(http.StatusOK, obj.Size, "application/octet-stream", r, nil)
+}
+
+// RegisterObjectRoutes 注册本地对象存储的下载路由，使用 S3 时下载链接直接指向 S3
+func RegisterObjectRoutes(router *gin.Engine, store *objstore.LocalStore) {
+	objectController := NewObjectController(store)
+
+	router.GET(objstore.LocalRoute+"*key", objectController.Download)
+}
@@ -0,0 +1,230 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/device"
+	"github.com/senma231/p3/server/store"
+)
+
+// ObserverController 设备观察链接控制器
+type ObserverController struct {
+	deviceService *device.Service
+	interval      time.Duration
+}
+
+// NewObserverController 创建设备观察链接控制器
+func NewObserverController(deviceService *device.Service, cfg *config.ObserverConfig) *ObserverController {
+	return &ObserverController{
+		deviceService: deviceService,
+		interval:      time.Duration(cfg.Interval) * time.Second,
+	}
+}
+
+// tenantService 返回限定为当前请求租户的设备服务，其他租户的数据对其不可见
+func (c *ObserverController) tenantService(ctx *gin.Context) (*device.Service, bool) {
+	svc, err := c.deviceService.ForTenant(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return nil, false
+	}
+	return svc, true
+}
+
+// CreateLink 为设备创建只读观察链接，令牌只在创建时返回
+func (c *ObserverController) CreateLink(ctx *gin.Context) {
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	deviceID, ok := observerDeviceID(ctx)
+	if !ok {
+		return
+	}
+
+	var req device.ObserverLinkRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	link, token, err := svc.CreateObserverLink(ctx.MustGet("userID").(uint), deviceID, &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{
+		"observer": link,
+		"token":    token,
+		"link":     svc.ObserverLinkURL(token),
+	})
+}
+
+// GetLinks 获取设备的观察链接
+func (c *ObserverController) GetLinks(ctx *gin.Context) {
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	deviceID, ok := observerDeviceID(ctx)
+	if !ok {
+		return
+	}
+
+	links, err := svc.GetObserverLinks(ctx.MustGet("userID").(uint), deviceID)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"observers": links,
+	})
+}
+
+// DeleteLink 撤销设备的观察链接
+func (c *ObserverController) DeleteLink(ctx *gin.Context) {
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	deviceID, ok := observerDeviceID(ctx)
+	if !ok {
+		return
+	}
+	linkID, err := strconv.ParseUint(ctx.Param("linkId"), 10, 64)
+	if err != nil {
+		respondError(ctx, errors.InvalidParam("无效的观察链接 ID"))
+		return
+	}
+
+	if err := svc.DeleteObserverLink(ctx.MustGet("userID").(uint), deviceID, uint(linkID)); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "观察链接已撤销",
+	})
+}
+
+// Observe 获取观察链接对应设备当前的状态和统计
+func (c *ObserverController) Observe(ctx *gin.Context) {
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	view, err := svc.ObserveDevice(ctx.MustGet("observerLink").(*db.ObserverLink))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, view)
+}
+
+// Stream 以 Server-Sent Events 推送设备的实时状态，每隔 auth.observer.interval 秒发送一次 status 事件。
+// 链接过期或被撤销时发送 expired 事件后结束
+func (c *ObserverController) Stream(ctx *gin.Context) {
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	link := ctx.MustGet("observerLink").(*db.ObserverLink)
+	token := ctx.Param("token")
+
+	ctx.Header("Content-Type", "text/event-stream")
+	ctx.Header("Cache-Control", "no-store")
+	ctx.Header("Connection", "keep-alive")
+	ctx.Header("X-Accel-Buffering", "no")
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		view, err := svc.ObserveDevice(link)
+		if err != nil {
+			ctx.SSEvent("error", gin.H{"error": errors.AsError(err).Localize(requestLang(ctx))})
+			ctx.Writer.Flush()
+			return
+		}
+		ctx.SSEvent("status", view)
+		ctx.Writer.Flush()
+
+		select {
+		case <-ctx.Request.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		// 每次推送前重新验证令牌，链接被撤销或过期后立即结束
+		if link, err = svc.AuthenticateObserver(token); err != nil {
+			ctx.SSEvent("expired", gin.H{"error": errors.AsError(err).Localize(requestLang(ctx))})
+			ctx.Writer.Flush()
+			return
+		}
+	}
+}
+
+// ObserverAuth 观察链接认证中间件。令牌有效时只授予 devices:observe 授权范围，不代表任何用户，
+// 数据访问限定为创建链接的用户
+func ObserverAuth(deviceService *device.Service) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		link, err := deviceService.AuthenticateObserver(ctx.Param("token"))
+		if err != nil {
+			respondError(ctx, err)
+			ctx.Abort()
+			return
+		}
+
+		ctx.Set("observerLink", link)
+		ctx.Set("scopes", []string{string(auth.ScopeDevicesObserve)})
+		// 观察接口只能读取创建链接的用户的数据
+		ctx.Request = ctx.Request.WithContext(store.WithTenant(ctx.Request.Context(), link.UserID))
+		ctx.Next()
+	}
+}
+
+// observerDeviceID 解析路径中的设备 ID，失败时已写入响应
+func observerDeviceID(ctx *gin.Context) (uint, bool) {
+	deviceID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		respondError(ctx, errors.InvalidParam("无效的设备 ID"))
+		return 0, false
+	}
+	return uint(deviceID), true
+}
+
+// RegisterObserverRoutes 注册设备观察链接路由。观察接口使用链接中的令牌认证，不需要登录
+func RegisterObserverRoutes(router *gin.Engine, authService *auth.Service, deviceService *device.Service, cfg *config.ObserverConfig) {
+	observerController := NewObserverController(deviceService, cfg)
+
+	devices := router.Group("/api/v1/devices")
+	devices.Use(AuthMiddleware(authService))
+	{
+		devices.GET("/:id/observers", RequireScopes(auth.ScopeDevicesRead), observerController.GetLinks)
+		devices.POST("/:id/observers", RequireScopes(auth.ScopeDevicesWrite), observerController.CreateLink)
+		devices.DELETE("/:id/observers/:linkId", RequireScopes(auth.ScopeDevicesWrite), observerController.DeleteLink)
+	}
+
+	observe := router.Group("/api/v1/observe/:token")
+	observe.Use(ObserverAuth(deviceService))
+	{
+		observe.GET("", RequireScopes(auth.ScopeDevicesObserve), observerController.Observe)
+		observe.GET("/stream", RequireScopes(auth.ScopeDevicesObserve), observerController.Stream)
+	}
+}
@@ -37,7 +37,7 @@ func (h *PermissionHandler) GetUserRole(c *gin.Context) {
 	// 获取当前用户 ID
 	currentUserID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": tr(c, "auth.unauthorized")})
 		return
 	}
 
@@ -76,7 +76,7 @@ func (h *PermissionHandler) SetUserRole(c *gin.Context) {
 	// 获取当前用户 ID
 	currentUserID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": tr(c, "auth.unauthorized")})
 		return
 	}
 
@@ -130,7 +130,7 @@ func (h *PermissionHandler) GetUserPermissions(c *gin.Context) {
 	// 获取当前用户 ID
 	currentUserID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": tr(c, "auth.unauthorized")})
 		return
 	}
 
@@ -171,7 +171,7 @@ func (h *PermissionHandler) CheckPermission(c *gin.Context) {
 	// 获取当前用户 ID
 	userID, exists := c.Get("userID")
 	if !exists {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "未授权"})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": tr(c, "auth.unauthorized")})
 		return
 	}
 
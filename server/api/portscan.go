@@ -0,0 +1,138 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/api/middleware"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/device"
+	"github.com/senma231/p3/server/portscan"
+)
+
+// PortScanController 设备端口扫描控制器
+type PortScanController struct {
+	deviceService *device.Service
+	manager       *portscan.Manager
+}
+
+// NewPortScanController 创建设备端口扫描控制器
+func NewPortScanController(deviceService *device.Service, manager *portscan.Manager) *PortScanController {
+	return &PortScanController{
+		deviceService: deviceService,
+		manager:       manager,
+	}
+}
+
+// CreateScan 让设备扫描本机或局域网中目标的端口，扫描异步进行，通过扫描 ID 查询结果
+func (c *PortScanController) CreateScan(ctx *gin.Context) {
+	dev, ok := c.ownedDevice(ctx)
+	if !ok {
+		return
+	}
+
+	var req portscan.Request
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	scan, err := c.manager.Submit(dev.UserID, dev, &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusAccepted, scan)
+}
+
+// ListScans 获取设备的端口扫描
+func (c *PortScanController) ListScans(ctx *gin.Context) {
+	dev, ok := c.ownedDevice(ctx)
+	if !ok {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"scans": c.manager.List(dev.UserID, dev.ID),
+	})
+}
+
+// GetScan 获取端口扫描结果
+func (c *PortScanController) GetScan(ctx *gin.Context) {
+	dev, ok := c.ownedDevice(ctx)
+	if !ok {
+		return
+	}
+
+	scan, err := c.manager.Get(dev.UserID, ctx.Param("scanId"))
+	if err != nil || scan.DeviceID != dev.ID {
+		respondError(ctx, errors.NotFound("扫描不存在"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, scan)
+}
+
+// ReportResult 设备回报端口扫描结果
+func (c *PortScanController) ReportResult(ctx *gin.Context) {
+	deviceID := ctx.MustGet("deviceID").(uint)
+
+	var req portscan.Result
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	if err := c.manager.ReportResult(deviceID, ctx.Param("id"), &req); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// ownedDevice 获取路径中属于当前请求租户的设备，其他租户的设备视为不存在，失败时已写入响应
+func (c *PortScanController) ownedDevice(ctx *gin.Context) (*db.Device, bool) {
+	deviceID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		respondError(ctx, errors.InvalidParam("无效的设备 ID"))
+		return nil, false
+	}
+
+	svc, err := c.deviceService.ForTenant(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return nil, false
+	}
+	dev, err := svc.GetDeviceByID(uint(deviceID))
+	if err != nil {
+		respondError(ctx, errors.NotFound(err.Error()))
+		return nil, false
+	}
+	return dev, true
+}
+
+// RegisterPortScanRoutes 注册设备端口扫描路由
+func RegisterPortScanRoutes(router *gin.Engine, authService *auth.Service, deviceService *device.Service, manager *portscan.Manager) {
+	portScanController := NewPortScanController(deviceService, manager)
+
+	devices := router.Group("/api/v1/devices")
+	devices.Use(AuthMiddleware(authService))
+	{
+		devices.GET("/:id/scans", RequireScopes(auth.ScopeDevicesRead), portScanController.ListScans)
+		devices.POST("/:id/scans", RequireScopes(auth.ScopeDevicesWrite), portScanController.CreateScan)
+		devices.GET("/:id/scans/:scanId", RequireScopes(auth.ScopeDevicesRead), portScanController.GetScan)
+	}
+
+	scans := router.Group("/api/v1/device/scans")
+	scans.Use(middleware.DeviceAuth(deviceService))
+	{
+		scans.POST("/:id/result", portScanController.ReportResult)
+	}
+}
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/probe"
+)
+
+// ProbeController 回显探测控制器
+type ProbeController struct {
+	probeServer *probe.Server
+}
+
+// NewProbeController 创建回显探测控制器
+func NewProbeController(probeServer *probe.Server) *ProbeController {
+	return &ProbeController{
+		probeServer: probeServer,
+	}
+}
+
+// GetProbe 返回回显探测服务的端口和服务端看到的请求来源地址，供 p3-client nat-report 使用
+func (c *ProbeController) GetProbe(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, c.probeServer.Info(ctx.ClientIP()))
+}
+
+// RegisterProbeRoutes 注册回显探测路由，不需要认证，尚未注册的节点也可以排查网络
+func RegisterProbeRoutes(router *gin.Engine, probeServer *probe.Server) {
+	probeController := NewProbeController(probeServer)
+
+	router.GET("/api/v1/probe", probeController.GetProbe)
+}
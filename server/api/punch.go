@@ -0,0 +1,52 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/p2p"
+)
+
+// PunchStatsController 打洞统计控制器
+type PunchStatsController struct {
+	config *config.Config
+	matrix *p2p.PunchMatrix
+}
+
+// NewPunchStatsController 创建打洞统计控制器
+func NewPunchStatsController(cfg *config.Config, matrix *p2p.PunchMatrix) *PunchStatsController {
+	return &PunchStatsController{
+		config: cfg,
+		matrix: matrix,
+	}
+}
+
+// GetMatrix 获取按 NAT 类型组合汇总的打洞结果和当前的打洞判断
+func (c *PunchStatsController) GetMatrix(ctx *gin.Context) {
+	cells, err := c.matrix.Matrix()
+	if err != nil {
+		respondError(ctx, errors.Database("查询打洞统计失败", err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"adaptive":       c.config.P2P.PunchStats.Adaptive,
+		"minSamples":     c.config.P2P.PunchStats.MinSamples,
+		"minSuccessRate": c.config.P2P.PunchStats.MinSuccessRate,
+		"cells":          cells,
+	})
+}
+
+// RegisterPunchStatsRoutes 注册打洞统计路由，只有管理员可以访问
+func RegisterPunchStatsRoutes(router *gin.Engine, authService *auth.Service, cfg *config.Config, matrix *p2p.PunchMatrix) {
+	punchController := NewPunchStatsController(cfg, matrix)
+
+	admin := router.Group("/api/v1/admin")
+	admin.Use(AuthMiddleware(authService))
+	{
+		admin.GET("/nat-matrix", RequireScopes(auth.ScopeUsersAdmin), punchController.GetMatrix)
+	}
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/p2p"
+)
+
+// RelayController 中继控制器
+type RelayController struct {
+	coordinator *p2p.Coordinator
+	relayServer *p2p.RelayServer
+}
+
+// NewRelayController 创建中继控制器
+func NewRelayController(coordinator *p2p.Coordinator, relayServer *p2p.RelayServer) *RelayController {
+	return &RelayController{
+		coordinator: coordinator,
+		relayServer: relayServer,
+	}
+}
+
+// GetPools 获取各区域中继池的容量和负载
+func (c *RelayController) GetPools(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"pools": c.coordinator.RelayPoolStats(),
+	})
+}
+
+// GetLimits 获取中继会话的默认限制和各设备的限制
+func (c *RelayController) GetLimits(ctx *gin.Context) {
+	defaults, overrides := c.relayServer.GetLimits()
+	ctx.JSON(http.StatusOK, gin.H{
+		"defaults":  defaults,
+		"overrides": overrides,
+	})
+}
+
+// SetLimitOverride 设置指定设备的中继会话限制
+func (c *RelayController) SetLimitOverride(ctx *gin.Context) {
+	var req config.RelayLimits
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	nodeID := ctx.Param("nodeId")
+	if err := c.relayServer.SetLimitOverride(nodeID, req); err != nil {
+		respondError(ctx, errors.InvalidParam(err.Error()))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &p2p.RelayLimitOverride{NodeID: nodeID, Limits: req})
+}
+
+// DeleteLimitOverride 删除指定设备的中继会话限制，恢复使用默认限制
+func (c *RelayController) DeleteLimitOverride(ctx *gin.Context) {
+	if !c.relayServer.DeleteLimitOverride(ctx.Param("nodeId")) {
+		respondError(ctx, errors.NotFound("设备未设置中继会话限制"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "已恢复默认中继会话限制",
+	})
+}
+
+// GetSessions 获取内置中继当前的会话，e2e 表示会话声明了端到端加密
+func (c *RelayController) GetSessions(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"sessions": c.relayServer.SessionStats(),
+	})
+}
+
+// GetEncryption 获取中继加密策略和当前会话的加密情况，e2eSessions 按客户端在握手中的声明统计
+func (c *RelayController) GetEncryption(ctx *gin.Context) {
+	total := c.relayServer.GetSessionCount()
+	e2e := c.relayServer.GetE2ESessionCount()
+	ctx.JSON(http.StatusOK, gin.H{
+		"policy":            c.relayServer.Encryption().Config(),
+		"e2eSessions":       e2e,
+		"plaintextSessions": total - e2e,
+	})
+}
+
+// SetEncryption 切换是否拒绝未声明端到端加密的中继会话，已建立的会话不受影响
+func (c *RelayController) SetEncryption(ctx *gin.Context) {
+	var req struct {
+		RequireE2E *bool `json:"requireE2E" binding:"required"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	c.relayServer.Encryption().SetRequireE2E(*req.RequireE2E)
+	ctx.JSON(http.StatusOK, gin.H{
+		"policy": c.relayServer.Encryption().Config(),
+	})
+}
+
+// DrainRelay 开始维护独立中继，其上的会话迁移到其他中继
+func (c *RelayController) DrainRelay(ctx *gin.Context) {
+	migrated, err := c.coordinator.DrainRelay(ctx.Param("relayId"))
+	if err != nil {
+		respondError(ctx, errors.NotFound("中继未注册"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":  "中继已开始维护",
+		"migrated": migrated,
+	})
+}
+
+// UndrainRelay 结束维护独立中继，中继重新参与中继选择
+func (c *RelayController) UndrainRelay(ctx *gin.Context) {
+	if err := c.coordinator.UndrainRelay(ctx.Param("relayId")); err != nil {
+		respondError(ctx, errors.NotFound("中继未注册"))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "中继已结束维护",
+	})
+}
+
+// RegisterRelayRoutes 注册中继管理路由
+func RegisterRelayRoutes(router *gin.Engine, authService *auth.Service, coordinator *p2p.Coordinator, relayServer *p2p.RelayServer) {
+	relayController := NewRelayController(coordinator, relayServer)
+
+	relay := router.Group("/api/v1/relay")
+	relay.Use(AuthMiddleware(authService))
+	{
+		relay.GET("/pools", RequireScopes(auth.ScopeRelayAdmin), relayController.GetPools)
+		relay.GET("/limits", RequireScopes(auth.ScopeRelayAdmin), relayController.GetLimits)
+		relay.PUT("/limits/:nodeId", RequireScopes(auth.ScopeRelayAdmin), relayController.SetLimitOverride)
+		relay.DELETE("/limits/:nodeId", RequireScopes(auth.ScopeRelayAdmin), relayController.DeleteLimitOverride)
+		relay.GET("/sessions", RequireScopes(auth.ScopeRelayAdmin), relayController.GetSessions)
+		relay.GET("/encryption", RequireScopes(auth.ScopeRelayAdmin), relayController.GetEncryption)
+		relay.PUT("/encryption", RequireScopes(auth.ScopeRelayAdmin), relayController.SetEncryption)
+		relay.POST("/relays/:relayId/drain", RequireScopes(auth.ScopeRelayAdmin), relayController.DrainRelay)
+		relay.DELETE("/relays/:relayId/drain", RequireScopes(auth.ScopeRelayAdmin), relayController.UndrainRelay)
+	}
+}
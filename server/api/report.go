@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/app"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/device"
+)
+
+// ReportController 设备批量上报控制器
+type ReportController struct {
+	deviceService *device.Service
+	appService    *app.Service
+}
+
+// NewReportController 创建设备批量上报控制器
+func NewReportController(deviceService *device.Service, appService *app.Service) *ReportController {
+	return &ReportController{
+		deviceService: deviceService,
+		appService:    appService,
+	}
+}
+
+// Report 设备一次上报心跳、应用流量统计、连接摘要和应用健康状态，请求须经过签名验证。
+// 心跳、统计和连接在同一事务中写入，成功后再更新应用健康状态
+func (c *ReportController) Report(ctx *gin.Context) {
+	var req struct {
+		device.ReportRequest
+		Health []app.HealthReport `json:"health" binding:"dive"`
+	}
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	current := ctx.MustGet("device").(*db.Device)
+
+	updated, err := c.deviceService.Report(current, &req.ReportRequest)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	changed := []db.App{}
+	if len(req.Health) > 0 {
+		if changed, err = c.appService.ReportHealth(current.ID, req.Health); err != nil {
+			respondError(ctx, err)
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"device":     updated,
+		"apps":       changed,
+		"serverTime": time.Now().UTC(),
+	})
+}
@@ -0,0 +1,47 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+)
+
+// requestRevision 获取请求期望的修订号，If-Match 头优先于请求体中的 revision
+func requestRevision(ctx *gin.Context, bodyRevision uint) (uint, bool) {
+	ifMatch := strings.TrimSpace(ctx.GetHeader("If-Match"))
+	if ifMatch == "" || ifMatch == "*" {
+		return bodyRevision, true
+	}
+
+	ifMatch = strings.Trim(strings.TrimPrefix(ifMatch, "W/"), `"`)
+	revision, err := strconv.ParseUint(ifMatch, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return uint(revision), true
+}
+
+// setETag 设置资源修订号对应的 ETag 响应头
+func setETag(ctx *gin.Context, revision uint) {
+	ctx.Header("ETag", fmt.Sprintf(`"%d"`, revision))
+}
+
+// isRevisionConflict 检查错误是否为修订号冲突
+func isRevisionConflict(err error) bool {
+	return db.IsRevisionConflict(err) || errors.Is(err, errors.ErrVersionConflict)
+}
+
+// respondRevisionConflict 返回 409 及资源的当前状态，便于客户端合并后重试
+func respondRevisionConflict(ctx *gin.Context, current interface{}, revision uint) {
+	setETag(ctx, revision)
+	ctx.JSON(http.StatusConflict, gin.H{
+		"error":   tr(ctx, "revision.conflict"),
+		"code":    errors.ErrVersionConflict,
+		"current": current,
+	})
+}
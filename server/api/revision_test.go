@@ -0,0 +1,50 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+)
+
+func TestRespondRevisionConflict(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	r.Use(LocaleMiddleware())
+	r.PUT("/devices/1", func(ctx *gin.Context) {
+		respondRevisionConflict(ctx, gin.H{"name": "nas"}, 3)
+	})
+
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"zh-CN", "资源已被其他用户修改，请刷新后重试"},
+		{"en-US,en;q=0.9", "The resource was modified by another user, reload and try again"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPut, "/devices/1", nil)
+		req.Header.Set("Accept-Language", tt.lang)
+		r.ServeHTTP(w, req)
+
+		var body struct {
+			Error   string            `json:"error"`
+			Code    errors.ErrorCode  `json:"code"`
+			Current map[string]string `json:"current"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("解析响应失败: %v", err)
+		}
+		if w.Code != http.StatusConflict || w.Header().Get("ETag") != `"3"` {
+			t.Fatalf("应返回 409 和当前修订号，实际为 %d %s", w.Code, w.Header().Get("ETag"))
+		}
+		// 错误信息按请求语言返回，错误码和资源的当前状态不受语言影响
+		if body.Error != tt.want || body.Code != errors.ErrVersionConflict || body.Current["name"] != "nas" {
+			t.Errorf("Accept-Language %s 的响应为 %+v", tt.lang, body)
+		}
+	}
+}
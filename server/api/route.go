@@ -0,0 +1,371 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/route"
+)
+
+// RouteController 子网路由控制器
+type RouteController struct {
+	routeService *route.Service
+}
+
+// NewRouteController 创建子网路由控制器
+func NewRouteController(routeService *route.Service) *RouteController {
+	return &RouteController{
+		routeService: routeService,
+	}
+}
+
+// tenantService 返回限定为当前请求租户的子网路由服务，其他租户的数据对其不可见
+func (c *RouteController) tenantService(ctx *gin.Context) (*route.Service, bool) {
+	svc, err := c.routeService.ForTenant(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return nil, false
+	}
+	return svc, true
+}
+
+// GetRoutes 获取路由列表
+func (c *RouteController) GetRoutes(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	routes, err := svc.GetRoutes(userID)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"routes": routes,
+	})
+}
+
+// GetRoute 获取路由详情
+func (c *RouteController) GetRoute(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	routeID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "route.invalidID"),
+		})
+		return
+	}
+
+	r, err := svc.GetRoute(userID, uint(routeID))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, r)
+}
+
+// CreateRoute 通告路由
+func (c *RouteController) CreateRoute(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	var req route.RouteRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	r, err := svc.AdvertiseRoute(userID, &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, r)
+}
+
+// UpdateRoute 更新路由
+func (c *RouteController) UpdateRoute(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	routeID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "route.invalidID"),
+		})
+		return
+	}
+
+	var req route.RouteUpdateRequest
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	r, err := svc.UpdateRoute(userID, uint(routeID), &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, r)
+}
+
+// DeleteRoute 删除路由
+func (c *RouteController) DeleteRoute(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	routeID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "route.invalidID"),
+		})
+		return
+	}
+
+	if err := svc.DeleteRoute(userID, uint(routeID)); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "路由已删除",
+	})
+}
+
+// SetRouteACL 设置路由访问控制
+func (c *RouteController) SetRouteACL(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	routeID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "route.invalidID"),
+		})
+		return
+	}
+
+	var req route.RouteACLRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	r, err := svc.SetRouteACL(userID, uint(routeID), &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, r)
+}
+
+// GetDeviceRoutes 获取设备可访问的路由
+func (c *RouteController) GetDeviceRoutes(ctx *gin.Context) {
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	userID := ctx.MustGet("userID").(uint)
+	deviceID := ctx.MustGet("deviceID").(uint)
+
+	routes, err := svc.GetRoutesForDevice(userID, deviceID)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"routes": routes,
+	})
+}
+
+// SyncDeviceRoutes 同步设备通告的路由
+func (c *RouteController) SyncDeviceRoutes(ctx *gin.Context) {
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	userID := ctx.MustGet("userID").(uint)
+	deviceID := ctx.MustGet("deviceID").(uint)
+
+	var req struct {
+		Routes []string `json:"routes"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	routes, err := svc.SyncDeviceRoutes(userID, deviceID, req.Routes)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"routes": routes,
+	})
+}
+
+// GetRouteClient 获取对等节点可以经本节点访问的网段
+func (c *RouteController) GetRouteClient(ctx *gin.Context) {
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	deviceID := ctx.MustGet("deviceID").(uint)
+
+	cidrs, err := svc.GetRoutesForClient(deviceID, ctx.Param("nodeId"))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"routes": cidrs,
+	})
+}
+
+// SetExitNodeAllowed 设置设备是否允许作为出口节点
+func (c *RouteController) SetExitNodeAllowed(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	deviceID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "device.invalidID"),
+		})
+		return
+	}
+
+	var req struct {
+		Allowed bool `json:"allowed"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	device, err := svc.SetExitNodeAllowed(userID, uint(deviceID), req.Allowed)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, device)
+}
+
+// AdvertiseExitNode 设置设备是否通告为出口节点
+func (c *RouteController) AdvertiseExitNode(ctx *gin.Context) {
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	deviceID := ctx.MustGet("deviceID").(uint)
+
+	var req struct {
+		Advertise bool `json:"advertise"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	if err := svc.SetExitNodeAdvertised(deviceID, req.Advertise); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"advertise": req.Advertise,
+	})
+}
+
+// GetExitNodes 获取设备可以使用的出口节点
+func (c *RouteController) GetExitNodes(ctx *gin.Context) {
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	userID := ctx.MustGet("userID").(uint)
+	deviceID := ctx.MustGet("deviceID").(uint)
+
+	nodes, err := svc.GetExitNodes(userID, deviceID)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"exitNodes": nodes,
+	})
+}
+
+// AuthorizeExitNodeClient 检查对等节点是否可以使用本节点作为出口
+func (c *RouteController) AuthorizeExitNodeClient(ctx *gin.Context) {
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	deviceID := ctx.MustGet("deviceID").(uint)
+
+	if err := svc.AuthorizeExitNodeClient(deviceID, ctx.Param("nodeId")); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"authorized": true,
+	})
+}
@@ -4,7 +4,9 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/cors"
 	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/signing"
 	"github.com/senma231/p3/server/api/middleware"
 	"github.com/senma231/p3/server/app"
 	"github.com/senma231/p3/server/auth"
@@ -46,7 +48,7 @@ func SetupRouter(
 	// 使用中间件
 	router.Use(gin.Recovery())
 	router.Use(middleware.Logger())
-	router.Use(middleware.CORS())
+	router.Use(middleware.CORS(cors.Config{}))
 
 	// 健康检查
 	router.GET("/health", func(c *gin.Context) {
@@ -121,18 +123,10 @@ func SetupRouter(
 	deviceAPI := v1.Group("/device")
 	deviceAPI.Use(middleware.DeviceAuth(deviceService))
 	{
-		deviceAPI.POST("/status", UpdateDeviceStatus)
+		deviceAPI.POST("/status", middleware.DeviceSignature(signing.NewVerifier(signing.DefaultWindow)), UpdateDeviceStatus)
 		deviceAPI.GET("/apps", GetDeviceApps)
 	}
 
-	// 统计路由
-	stats := v1.Group("/stats")
-	stats.Use(middleware.Auth(authService))
-	{
-		stats.GET("/system", GetSystemStats)
-		stats.GET("/user", GetUserStats)
-	}
-
 	logger.Info("API 路由设置完成")
 	return router
 }
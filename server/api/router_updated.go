@@ -1,34 +1,69 @@
 package api
 
 import (
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/signing"
+	"github.com/senma231/p3/server/alert"
+	"github.com/senma231/p3/server/api/middleware"
 	"github.com/senma231/p3/server/app"
 	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/config"
 	"github.com/senma231/p3/server/device"
 	"github.com/senma231/p3/server/forward"
 	"github.com/senma231/p3/server/monitor"
+	"github.com/senma231/p3/server/notify"
+	"github.com/senma231/p3/server/route"
+	"github.com/senma231/p3/server/sanitize"
+	"github.com/senma231/p3/server/speedtest"
+	"github.com/senma231/p3/server/status"
+	"github.com/senma231/p3/server/store"
 )
 
 // SetupRouter 设置路由
 func SetupRouter(
+	cfg *config.Config,
+	st *store.Store,
 	authService *auth.Service,
 	deviceService *device.Service,
 	appService *app.Service,
 	forwardService *forward.Service,
+	statusService *status.Service,
 ) *gin.Engine {
+	// 注册输入校验标签，设置名称、描述等字段的 HTML 处理方式
+	htmlPolicy, err := sanitize.ParseHTMLPolicy(cfg.Security.HTMLPolicy)
+	if err != nil {
+		logger.Error("HTML 策略无效，使用 %s: %v", sanitize.HTMLReject, err)
+		htmlPolicy = sanitize.HTMLReject
+	}
+	sanitize.SetHTMLPolicy(htmlPolicy)
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		if err := sanitize.RegisterValidators(v); err != nil {
+			logger.Error("注册输入校验标签失败: %v", err)
+		}
+	}
+
 	// 创建 Gin 引擎
-	r := gin.Default()
+	r := NewEngine(cfg)
 
 	// 使用中间件
-	r.Use(CORSMiddleware())
+	r.Use(LocaleMiddleware())
+	r.Use(SecurityHeadersMiddleware(cfg.Security.Headers))
+	r.Use(CORSMiddleware(cfg.CORS))
 	r.Use(LoggerMiddleware())
 	r.Use(RecoveryMiddleware())
+	r.Use(MaintenanceMiddleware(statusService))
 
 	// 创建控制器
 	authController := NewAuthController(authService)
 	deviceController := NewDeviceController(deviceService)
-	appController := NewAppController(appService)
+	appController := NewAppController(appService, deviceService)
 	forwardController := NewForwardController(forwardService)
+	reportController := NewReportController(deviceService, appService)
 	
 	// 创建监控器
 	monitorInstance := monitor.NewMonitor()
@@ -45,15 +80,40 @@ func SetupRouter(
 	// 创建批量操作控制器
 	batchController := NewBatchController(deviceService, appService, forwardService)
 
+	// 创建子网路由控制器
+	routeController := NewRouteController(route.NewService(st))
+
+	// 创建测速控制器
+	speedTestController := NewSpeedTestController(speedtest.NewService(st))
+
+	// 创建设备请求签名验证器
+	statusVerifier := signing.NewVerifier(signing.DefaultWindow)
+
+	// 创建告警控制器
+	alertController := NewAlertController(alert.NewService(notify.NewManager(&cfg.Notify), st))
+
+	// 健康检查，供客户端选择服务器端点
+	r.GET("/health", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+		})
+	})
+
 	// API 版本
 	v1 := r.Group("/api/v1")
 
+	// 版本信息
+	v1.GET("/version", GetVersion)
+
 	// 认证路由
 	authGroup := v1.Group("/auth")
 	{
+		authGroup.GET("/registration", authController.GetRegistrationMode)
 		authGroup.POST("/register", authController.Register)
 		authGroup.POST("/login", authController.Login)
+		authGroup.POST("/refresh", authController.RefreshToken)
 		authGroup.POST("/logout", authController.Logout)
+		authGroup.POST("/verify-email", authController.VerifyEmail)
 	}
 
 	// 需要认证的路由
@@ -63,79 +123,161 @@ func SetupRouter(
 		// 当前用户
 		authorized.GET("/user", authController.GetCurrentUser)
 		authorized.PUT("/user", authController.UpdateUser)
+		authorized.GET("/users/me", authController.GetCurrentUser)
+		authorized.PUT("/users/me", authController.UpdateUser)
+		authorized.PUT("/user/password", authController.ChangePassword)
+		authorized.POST("/user/verify-email/resend", authController.ResendVerification)
+
+		// 用户管理
+		authorized.PUT("/users/:id/email-verified", RequireScopes(auth.ScopeUsersAdmin), authController.SetEmailVerified)
+
+		// 注册邀请
+		invitations := authorized.Group("/invitations")
+		{
+			invitations.GET("/", RequireScopes(auth.ScopeUsersAdmin), authController.ListInvitations)
+			invitations.POST("/", RequireScopes(auth.ScopeUsersAdmin), authController.CreateInvitation)
+			invitations.DELETE("/:id", RequireScopes(auth.ScopeUsersAdmin), authController.DeleteInvitation)
+		}
+
+		// API 密钥，只能使用登录令牌管理
+		apiKeys := authorized.Group("/api-keys")
+		{
+			apiKeys.GET("/", authController.ListAPIKeys)
+			apiKeys.POST("/", authController.CreateAPIKey)
+			apiKeys.DELETE("/:id", authController.DeleteAPIKey)
+		}
 
 		// 设备管理
 		devices := authorized.Group("/devices")
 		{
-			devices.GET("/", deviceController.GetDevices)
-			devices.GET("/:id", deviceController.GetDevice)
-			devices.POST("/", deviceController.CreateDevice)
-			devices.PUT("/:id", deviceController.UpdateDevice)
-			devices.DELETE("/:id", deviceController.DeleteDevice)
-			devices.GET("/:id/stats", deviceController.GetDeviceStats)
+			devices.GET("/", RequireScopes(auth.ScopeDevicesRead), deviceController.GetDevices)
+			devices.GET("/status", RequireScopes(auth.ScopeDevicesRead), deviceController.GetDeviceStatuses)
+			devices.GET("/:id", RequireScopes(auth.ScopeDevicesRead), deviceController.GetDevice)
+			devices.POST("/", RequireScopes(auth.ScopeDevicesWrite), RequireVerifiedEmail(authService), deviceController.CreateDevice)
+			devices.PUT("/:id", RequireScopes(auth.ScopeDevicesWrite), deviceController.UpdateDevice)
+			devices.DELETE("/:id", RequireScopes(auth.ScopeDevicesWrite), deviceController.DeleteDevice)
+			devices.GET("/:id/stats", RequireScopes(auth.ScopeDevicesRead), deviceController.GetDeviceStats)
+			devices.GET("/:id/events", RequireScopes(auth.ScopeDevicesRead), deviceController.GetDeviceEvents)
+			devices.GET("/:id/traces", RequireScopes(auth.ScopeDevicesRead), deviceController.GetDeviceTraces)
+			devices.PUT("/:id/exit-node", RequireScopes(auth.ScopeDevicesWrite, auth.ScopeRoutesWrite), routeController.SetExitNodeAllowed)
 		}
 
 		// 应用管理
 		apps := authorized.Group("/apps")
 		{
-			apps.GET("/", appController.GetApps)
-			apps.GET("/:id", appController.GetApp)
-			apps.POST("/", appController.CreateApp)
-			apps.PUT("/:id", appController.UpdateApp)
-			apps.DELETE("/:id", appController.DeleteApp)
-			apps.POST("/:id/start", appController.StartApp)
-			apps.POST("/:id/stop", appController.StopApp)
-			apps.GET("/:id/stats", appController.GetAppStats)
+			apps.GET("/", RequireScopes(auth.ScopeAppsRead), appController.GetApps)
+			apps.GET("/:id", RequireScopes(auth.ScopeAppsRead), appController.GetApp)
+			apps.POST("/", RequireScopes(auth.ScopeAppsWrite), appController.CreateApp)
+			apps.PUT("/:id", RequireScopes(auth.ScopeAppsWrite), appController.UpdateApp)
+			apps.DELETE("/:id", RequireScopes(auth.ScopeAppsWrite), appController.DeleteApp)
+			apps.POST("/:id/start", RequireScopes(auth.ScopeAppsWrite), appController.StartApp)
+			apps.POST("/:id/stop", RequireScopes(auth.ScopeAppsWrite), appController.StopApp)
+			apps.GET("/:id/stats", RequireScopes(auth.ScopeAppsRead), appController.GetAppStats)
+			apps.GET("/:id/destinations", RequireScopes(auth.ScopeAppsRead), appController.GetAppDestinations)
 		}
 		
 		// 转发规则管理
 		forwards := authorized.Group("/forwards")
 		{
-			forwards.GET("/", forwardController.GetForwards)
-			forwards.GET("/:id", forwardController.GetForward)
-			forwards.POST("/", forwardController.CreateForward)
-			forwards.PUT("/:id", forwardController.UpdateForward)
-			forwards.DELETE("/:id", forwardController.DeleteForward)
-			forwards.POST("/:id/enable", forwardController.EnableForward)
-			forwards.POST("/:id/disable", forwardController.DisableForward)
-			forwards.GET("/:id/stats", forwardController.GetForwardStats)
+			forwards.GET("/", RequireScopes(auth.ScopeForwardsRead), forwardController.GetForwards)
+			forwards.GET("/:id", RequireScopes(auth.ScopeForwardsRead), forwardController.GetForward)
+			forwards.POST("/", RequireScopes(auth.ScopeForwardsWrite), forwardController.CreateForward)
+			forwards.PUT("/:id", RequireScopes(auth.ScopeForwardsWrite), forwardController.UpdateForward)
+			forwards.DELETE("/:id", RequireScopes(auth.ScopeForwardsWrite), forwardController.DeleteForward)
+			forwards.POST("/:id/enable", RequireScopes(auth.ScopeForwardsWrite), forwardController.EnableForward)
+			forwards.POST("/:id/disable", RequireScopes(auth.ScopeForwardsWrite), forwardController.DisableForward)
+			forwards.GET("/:id/stats", RequireScopes(auth.ScopeForwardsRead), forwardController.GetForwardStats)
 		}
 		
 		// 系统状态
-		authorized.GET("/status", deviceController.GetSystemStatus)
+		authorized.GET("/status", RequireScopes(auth.ScopeMonitorRead), deviceController.GetSystemStatus)
 		
 		// WebSocket
-		authorized.GET("/ws", wsHandler.HandleWS)
+		authorized.GET("/ws", RequireScopes(auth.ScopeMonitorRead), wsHandler.HandleWS)
 		
 		// 权限管理
 		permissions := authorized.Group("/permissions")
 		{
-			permissions.GET("/users/:id/role", permController.GetUserRole)
-			permissions.PUT("/users/:id/role", permController.SetUserRole)
-			permissions.GET("/users/:id/permissions", permController.GetUserPermissions)
-			permissions.POST("/check", permController.CheckPermission)
+			permissions.GET("/users/:id/role", RequireScopes(auth.ScopeUsersAdmin), permController.GetUserRole)
+			permissions.PUT("/users/:id/role", RequireScopes(auth.ScopeUsersAdmin), permController.SetUserRole)
+			permissions.GET("/users/:id/permissions", RequireScopes(auth.ScopeUsersAdmin), permController.GetUserPermissions)
+			permissions.POST("/check", RequireScopes(auth.ScopeDevicesRead), permController.CheckPermission)
 		}
 		
 		// 分组管理
 		groups := authorized.Group("/groups")
 		{
-			groups.GET("/", groupController.GetGroups)
-			groups.POST("/", groupController.CreateGroup)
-			groups.GET("/:id", groupController.GetGroup)
-			groups.PUT("/:id", groupController.UpdateGroup)
-			groups.DELETE("/:id", groupController.DeleteGroup)
-			groups.POST("/:id/devices/:deviceId", groupController.AddDeviceToGroup)
-			groups.DELETE("/:id/devices/:deviceId", groupController.RemoveDeviceFromGroup)
-			groups.GET("/:id/devices", groupController.GetDevicesInGroup)
+			groups.GET("/", RequireScopes(auth.ScopeDevicesRead), groupController.GetGroups)
+			groups.POST("/", RequireScopes(auth.ScopeDevicesWrite), groupController.CreateGroup)
+			groups.GET("/:id", RequireScopes(auth.ScopeDevicesRead), groupController.GetGroup)
+			groups.PUT("/:id", RequireScopes(auth.ScopeDevicesWrite), groupController.UpdateGroup)
+			groups.DELETE("/:id", RequireScopes(auth.ScopeDevicesWrite), groupController.DeleteGroup)
+			groups.POST("/:id/devices/:deviceId", RequireScopes(auth.ScopeDevicesWrite), groupController.AddDeviceToGroup)
+			groups.DELETE("/:id/devices/:deviceId", RequireScopes(auth.ScopeDevicesWrite), groupController.RemoveDeviceFromGroup)
+			groups.GET("/:id/devices", RequireScopes(auth.ScopeDevicesRead), groupController.GetDevicesInGroup)
 		}
 		
 		// 批量操作
 		batch := authorized.Group("/batch")
 		{
-			batch.POST("/devices", batchController.BatchDeviceOperation)
-			batch.POST("/apps", batchController.BatchAppOperation)
-			batch.POST("/forwards", batchController.BatchForwardOperation)
+			batch.POST("/devices", RequireScopes(auth.ScopeDevicesWrite), batchController.BatchDeviceOperation)
+			batch.POST("/apps", RequireScopes(auth.ScopeAppsWrite), batchController.BatchAppOperation)
+			batch.POST("/forwards", RequireScopes(auth.ScopeForwardsWrite), batchController.BatchForwardOperation)
+		}
+
+		// 子网路由管理
+		routes := authorized.Group("/routes")
+		{
+			routes.GET("/", RequireScopes(auth.ScopeRoutesRead), routeController.GetRoutes)
+			routes.POST("/", RequireScopes(auth.ScopeRoutesWrite), routeController.CreateRoute)
+			routes.GET("/:id", RequireScopes(auth.ScopeRoutesRead), routeController.GetRoute)
+			routes.PUT("/:id", RequireScopes(auth.ScopeRoutesWrite), routeController.UpdateRoute)
+			routes.DELETE("/:id", RequireScopes(auth.ScopeRoutesWrite), routeController.DeleteRoute)
+			routes.PUT("/:id/acl", RequireScopes(auth.ScopeRoutesWrite), routeController.SetRouteACL)
 		}
+
+		// 测速计划
+		speedTests := authorized.Group("/speedtests")
+		{
+			speedTests.GET("/", RequireScopes(auth.ScopeMonitorRead), speedTestController.GetSchedules)
+			speedTests.POST("/", RequireScopes(auth.ScopeMonitorWrite), speedTestController.CreateSchedule)
+			speedTests.GET("/:id", RequireScopes(auth.ScopeMonitorRead), speedTestController.GetSchedule)
+			speedTests.PUT("/:id", RequireScopes(auth.ScopeMonitorWrite), speedTestController.UpdateSchedule)
+			speedTests.DELETE("/:id", RequireScopes(auth.ScopeMonitorWrite), speedTestController.DeleteSchedule)
+			speedTests.GET("/:id/results", RequireScopes(auth.ScopeMonitorRead), speedTestController.GetResults)
+		}
+
+		// 告警
+		alerts := authorized.Group("/alerts")
+		{
+			alerts.GET("/rules", RequireScopes(auth.ScopeMonitorRead), alertController.GetRules)
+			alerts.POST("/rules", RequireScopes(auth.ScopeMonitorWrite), alertController.CreateRule)
+			alerts.GET("/rules/:id", RequireScopes(auth.ScopeMonitorRead), alertController.GetRule)
+			alerts.PUT("/rules/:id", RequireScopes(auth.ScopeMonitorWrite), alertController.UpdateRule)
+			alerts.DELETE("/rules/:id", RequireScopes(auth.ScopeMonitorWrite), alertController.DeleteRule)
+			alerts.POST("/rules/:id/silence", RequireScopes(auth.ScopeMonitorWrite), alertController.SilenceRule)
+			alerts.DELETE("/rules/:id/silence", RequireScopes(auth.ScopeMonitorWrite), alertController.UnsilenceRule)
+			alerts.GET("/events", RequireScopes(auth.ScopeMonitorRead), alertController.GetEvents)
+		}
+	}
+
+	// 设备 API
+	deviceAPI := v1.Group("/device")
+	deviceAPI.Use(middleware.DeviceAuth(deviceService))
+	{
+		deviceAPI.POST("/status", middleware.DeviceSignature(statusVerifier), deviceController.UpdateStatus)
+		deviceAPI.POST("/report", middleware.DeviceSignature(statusVerifier), reportController.Report)
+		deviceAPI.POST("/events", deviceController.ReportEvents)
+		deviceAPI.POST("/traces", deviceController.ReportTrace)
+		deviceAPI.GET("/apps", appController.SyncDeviceApps)
+		deviceAPI.POST("/apps/health", appController.ReportHealth)
+		deviceAPI.GET("/routes", routeController.GetDeviceRoutes)
+		deviceAPI.PUT("/routes", routeController.SyncDeviceRoutes)
+		deviceAPI.GET("/routes/clients/:nodeId", routeController.GetRouteClient)
+		deviceAPI.PUT("/exit-node", routeController.AdvertiseExitNode)
+		deviceAPI.GET("/exit-node/clients/:nodeId", routeController.AuthorizeExitNodeClient)
+		deviceAPI.GET("/exit-nodes", routeController.GetExitNodes)
+		deviceAPI.POST("/speedtests/results", speedTestController.ReportResult)
 	}
 
 	return r
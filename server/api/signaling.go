@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/p2p"
+)
+
+// SignalingController 信令服务控制器
+type SignalingController struct {
+	signalingServer *p2p.SignalingServer
+}
+
+// NewSignalingController 创建信令服务控制器
+func NewSignalingController(signalingServer *p2p.SignalingServer) *SignalingController {
+	return &SignalingController{
+		signalingServer: signalingServer,
+	}
+}
+
+// GetQueues 获取当前所有连接的信令发送队列按优先级汇总的统计
+func (c *SignalingController) GetQueues(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"clients": c.signalingServer.GetClientCount(),
+		"queues":  c.signalingServer.QueueStats(),
+	})
+}
+
+// GetLimits 获取信令速率限制和因超出限制被丢弃的信令统计
+func (c *SignalingController) GetLimits(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, c.signalingServer.SignalLimitStats())
+}
+
+// GetSTUNServers 获取下发给客户端的 STUN 服务器列表
+func (c *SignalingController) GetSTUNServers(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"servers": c.signalingServer.STUNServers(),
+	})
+}
+
+// SetSTUNServers 更新下发给客户端的 STUN 服务器列表并推送给在线节点
+func (c *SignalingController) SetSTUNServers(ctx *gin.Context) {
+	var req struct {
+		Servers []string `json:"servers"`
+	}
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	notified, err := c.signalingServer.SetSTUNServers(req.Servers)
+	if err != nil {
+		respondError(ctx, errors.InvalidParam(err.Error()))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"servers":  c.signalingServer.STUNServers(),
+		"notified": notified,
+	})
+}
+
+// RegisterSignalingRoutes 注册信令服务管理路由
+func RegisterSignalingRoutes(router *gin.Engine, authService *auth.Service, signalingServer *p2p.SignalingServer) {
+	signalingController := NewSignalingController(signalingServer)
+
+	signaling := router.Group("/api/v1/signaling")
+	signaling.Use(AuthMiddleware(authService))
+	{
+		signaling.GET("/queues", RequireScopes(auth.ScopeRelayAdmin), signalingController.GetQueues)
+		signaling.GET("/limits", RequireScopes(auth.ScopeRelayAdmin), signalingController.GetLimits)
+		signaling.GET("/stun-servers", RequireScopes(auth.ScopeRelayAdmin), signalingController.GetSTUNServers)
+		signaling.PUT("/stun-servers", RequireScopes(auth.ScopeRelayAdmin), signalingController.SetSTUNServers)
+	}
+}
@@ -0,0 +1,233 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/speedtest"
+)
+
+// SpeedTestController 测速控制器
+type SpeedTestController struct {
+	speedTestService *speedtest.Service
+}
+
+// NewSpeedTestController 创建测速控制器
+func NewSpeedTestController(speedTestService *speedtest.Service) *SpeedTestController {
+	return &SpeedTestController{
+		speedTestService: speedTestService,
+	}
+}
+
+// tenantService 返回限定为当前请求租户的测速服务，其他租户的数据对其不可见
+func (c *SpeedTestController) tenantService(ctx *gin.Context) (*speedtest.Service, bool) {
+	svc, err := c.speedTestService.ForTenant(ctx.Request.Context())
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"error": tr(ctx, "auth.unauthorized"),
+		})
+		return nil, false
+	}
+	return svc, true
+}
+
+// GetSchedules 获取测速计划列表
+func (c *SpeedTestController) GetSchedules(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	schedules, err := svc.GetSchedules(userID)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"schedules": schedules,
+	})
+}
+
+// GetSchedule 获取测速计划详情
+func (c *SpeedTestController) GetSchedule(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	scheduleID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "speedtest.invalidID"),
+		})
+		return
+	}
+
+	schedule, err := svc.GetSchedule(userID, uint(scheduleID))
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schedule)
+}
+
+// CreateSchedule 创建测速计划
+func (c *SpeedTestController) CreateSchedule(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	var req speedtest.ScheduleRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	schedule, err := svc.CreateSchedule(userID, &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, schedule)
+}
+
+// UpdateSchedule 更新测速计划
+func (c *SpeedTestController) UpdateSchedule(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	scheduleID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "speedtest.invalidID"),
+		})
+		return
+	}
+
+	var req speedtest.ScheduleUpdateRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	schedule, err := svc.UpdateSchedule(userID, uint(scheduleID), &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, schedule)
+}
+
+// DeleteSchedule 删除测速计划
+func (c *SpeedTestController) DeleteSchedule(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	scheduleID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "speedtest.invalidID"),
+		})
+		return
+	}
+
+	if err := svc.DeleteSchedule(userID, uint(scheduleID)); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "测速计划已删除",
+	})
+}
+
+// GetResults 获取测速结果，since 参数为 RFC3339 时间，默认返回最近 24 小时
+func (c *SpeedTestController) GetResults(ctx *gin.Context) {
+	userID := ctx.MustGet("userID").(uint)
+
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	scheduleID, err := strconv.ParseUint(ctx.Param("id"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "speedtest.invalidID"),
+		})
+		return
+	}
+
+	since := time.Now().Add(-24 * time.Hour)
+	if s := ctx.Query("since"); s != "" {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{
+				"error": tr(ctx, "request.invalidTime"),
+			})
+			return
+		}
+		since = t
+	}
+
+	results, err := svc.GetResults(userID, uint(scheduleID), since)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"results": results,
+	})
+}
+
+// ReportResult 设备上报测速结果
+func (c *SpeedTestController) ReportResult(ctx *gin.Context) {
+	svc, ok := c.tenantService(ctx)
+	if !ok {
+		return
+	}
+
+	deviceID := ctx.MustGet("deviceID").(uint)
+
+	var req speedtest.ResultRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"error": tr(ctx, "request.invalid"),
+		})
+		return
+	}
+
+	result, err := svc.RecordResult(deviceID, &req)
+	if err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, result)
+}
@@ -0,0 +1,128 @@
+package api
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/status"
+)
+
+// maintenanceExempt 维护期间仍然可用的路径前缀：健康检查、状态页、信令、中继、TURN、回显探测、
+// 设备上报，以及管理员登录和取消维护需要的接口
+var maintenanceExempt = []string{
+	"/health",
+	"/status",
+	"/api/v1/version",
+	"/api/v1/ws",
+	"/api/v1/signal/",
+	"/api/v1/relay/agent/",
+	"/api/v1/turn/",
+	"/api/v1/probe",
+	"/api/v1/device/",
+	"/api/v1/auth/login",
+	"/api/v1/auth/refresh",
+	"/api/v1/maintenance",
+}
+
+// MaintenanceMiddleware 维护窗口生效期间，非必要的接口返回 503 和 Retry-After
+func MaintenanceMiddleware(statusService *status.Service) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		window, active := statusService.InMaintenance()
+		if !active || maintenanceExempted(ctx.Request.URL.Path) {
+			ctx.Next()
+			return
+		}
+
+		retryAfter := int(math.Ceil(time.Until(window.End).Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		ctx.Header("Retry-After", strconv.Itoa(retryAfter))
+		ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+			"error":       tr(ctx, "maintenance.active"),
+			"code":        errors.ErrServiceUnavailable,
+			"retryAfter":  retryAfter,
+			"maintenance": window,
+		})
+	}
+}
+
+// maintenanceExempted 判断路径在维护期间是否仍然可用
+func maintenanceExempted(path string) bool {
+	for _, prefix := range maintenanceExempt {
+		if path == strings.TrimSuffix(prefix, "/") || strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// StatusController 服务状态控制器
+type StatusController struct {
+	statusService *status.Service
+}
+
+// NewStatusController 创建服务状态控制器
+func NewStatusController(statusService *status.Service) *StatusController {
+	return &StatusController{
+		statusService: statusService,
+	}
+}
+
+// GetStatus 获取各组件的健康状态和计划维护，无需认证，供状态看板使用
+func (c *StatusController) GetStatus(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, c.statusService.Summary())
+}
+
+// GetMaintenance 获取进行中或计划中的维护窗口
+func (c *StatusController) GetMaintenance(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"maintenance": c.statusService.Maintenance(),
+	})
+}
+
+// ScheduleMaintenance 设置维护窗口
+func (c *StatusController) ScheduleMaintenance(ctx *gin.Context) {
+	var req status.Window
+	if !bindJSON(ctx, &req) {
+		return
+	}
+
+	if err := c.statusService.ScheduleMaintenance(req); err != nil {
+		respondError(ctx, err)
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"maintenance": c.statusService.Maintenance(),
+	})
+}
+
+// CancelMaintenance 取消维护窗口
+func (c *StatusController) CancelMaintenance(ctx *gin.Context) {
+	c.statusService.CancelMaintenance()
+	ctx.JSON(http.StatusOK, gin.H{
+		"success": true,
+	})
+}
+
+// RegisterStatusRoutes 注册服务状态和维护管理路由
+func RegisterStatusRoutes(router *gin.Engine, authService *auth.Service, statusService *status.Service) {
+	statusController := NewStatusController(statusService)
+
+	router.GET("/status", statusController.GetStatus)
+
+	maintenance := router.Group("/api/v1/maintenance")
+	maintenance.Use(AuthMiddleware(authService))
+	{
+		maintenance.GET("", RequireScopes(auth.ScopeUsersAdmin), statusController.GetMaintenance)
+		maintenance.PUT("", RequireScopes(auth.ScopeUsersAdmin), statusController.ScheduleMaintenance)
+		maintenance.DELETE("", RequireScopes(auth.ScopeUsersAdmin), statusController.CancelMaintenance)
+	}
+}
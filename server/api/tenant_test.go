@@ -0,0 +1,196 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/senma231/p3/server/alert"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/device"
+	"github.com/senma231/p3/server/export"
+	"github.com/senma231/p3/server/fleet"
+	"github.com/senma231/p3/server/forward"
+	"github.com/senma231/p3/server/portscan"
+	"github.com/senma231/p3/server/route"
+	"github.com/senma231/p3/server/sanitize"
+	"github.com/senma231/p3/server/speedtest"
+	"github.com/senma231/p3/server/store"
+)
+
+const (
+	tenantA uint = 1
+	tenantB uint = 2
+)
+
+// tenantEngine 注册被测处理函数，请求头 X-Test-User 指定当前用户，
+// 与认证中间件一样把用户作为租户写入请求上下文
+func tenantEngine(st *store.Store) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		sanitize.RegisterValidators(v)
+	}
+	cfg := config.DefaultConfig()
+	deviceService := device.NewService(cfg, st)
+	fleetManager := fleet.NewManager(nil)
+
+	engine := gin.New()
+	engine.Use(func(ctx *gin.Context) {
+		userID, _ := strconv.ParseUint(ctx.GetHeader("X-Test-User"), 10, 64)
+		ctx.Set("userID", uint(userID))
+		ctx.Set("forwardService", forward.NewService(st))
+		ctx.Request = ctx.Request.WithContext(store.WithTenant(ctx.Request.Context(), uint(userID)))
+		ctx.Next()
+	})
+
+	routes := NewRouteController(route.NewService(st))
+	engine.GET("/routes/:id", routes.GetRoute)
+	engine.PUT("/routes/:id", routes.UpdateRoute)
+	engine.DELETE("/routes/:id", routes.DeleteRoute)
+
+	speedTests := NewSpeedTestController(speedtest.NewService(st))
+	engine.GET("/speedtests/:id", speedTests.GetSchedule)
+	engine.PUT("/speedtests/:id", speedTests.UpdateSchedule)
+	engine.DELETE("/speedtests/:id", speedTests.DeleteSchedule)
+
+	alerts := NewAlertController(alert.NewService(nil, st))
+	engine.GET("/alerts/:id", alerts.GetRule)
+	engine.PUT("/alerts/:id", alerts.UpdateRule)
+	engine.DELETE("/alerts/:id", alerts.DeleteRule)
+
+	engine.GET("/forwards/:id", GetForward)
+	engine.PUT("/forwards/:id", UpdateForward)
+	engine.DELETE("/forwards/:id", DeleteForward)
+	engine.POST("/forwards/:id/enable", EnableForward)
+
+	filters := NewDeviceFilterController(deviceService)
+	engine.GET("/filters/:id/devices", filters.GetFilterDevices)
+	engine.DELETE("/filters/:id", filters.DeleteFilter)
+
+	observers := NewObserverController(deviceService, &cfg.Auth.Observer)
+	engine.GET("/devices/:id/observers", observers.GetLinks)
+	engine.POST("/devices/:id/observers", observers.CreateLink)
+	engine.DELETE("/devices/:id/observers/:linkId", observers.DeleteLink)
+
+	scans := NewPortScanController(deviceService, portscan.NewManager(nil))
+	engine.GET("/devices/:id/scans", scans.ListScans)
+	engine.POST("/devices/:id/scans", scans.CreateScan)
+
+	fleetController := NewFleetController(deviceService, fleetManager, fleet.NewRolloutManager(fleetManager))
+	engine.POST("/fleet/jobs", fleetController.CreateJob)
+
+	exports := NewExportController(st, export.NewJobManager(nil, 0))
+	engine.GET("/export/:resource", exports.Export)
+	return engine
+}
+
+func serveAs(engine *gin.Engine, userID uint, method, path, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Test-User", strconv.FormatUint(uint64(userID), 10))
+	w := httptest.NewRecorder()
+	engine.ServeHTTP(w, req)
+	return w
+}
+
+func TestHandlersHideOtherTenants(t *testing.T) {
+	st := store.NewMemoryStore()
+
+	// 租户 A 的设备和各类资源
+	source := &db.Device{UserID: tenantA, Name: "tenant-a-source", NodeID: "node-a1", Token: "token-a1", Status: "online"}
+	target := &db.Device{UserID: tenantA, Name: "tenant-a-target", NodeID: "node-a2", Token: "token-a2", Status: "online"}
+	for _, d := range []*db.Device{source, target} {
+		if err := st.Devices.Create(d); err != nil {
+			t.Fatalf("创建设备失败: %v", err)
+		}
+	}
+	r := &db.Route{UserID: tenantA, DeviceID: source.ID, CIDR: "10.1.0.0/24", Enabled: true}
+	if err := st.Routes.Create(r); err != nil {
+		t.Fatalf("创建路由失败: %v", err)
+	}
+	schedule := &db.SpeedTestSchedule{UserID: tenantA, SourceDeviceID: source.ID, TargetDeviceID: target.ID, Interval: 60, Duration: 10, Enabled: true}
+	if err := st.SpeedTests.CreateSchedule(schedule); err != nil {
+		t.Fatalf("创建测速计划失败: %v", err)
+	}
+	rule := &db.AlertRule{UserID: tenantA, Name: "offline", Type: "device_offline", Channel: "webhook", Target: "https://example.com/hook", Enabled: true}
+	if err := st.Alerts.CreateRule(rule); err != nil {
+		t.Fatalf("创建告警规则失败: %v", err)
+	}
+	fwd := &db.Forward{UserID: tenantA, Protocol: "tcp", SrcPort: 8080, DstHost: "127.0.0.1", DstPort: 80}
+	if err := st.Forwards.Create(fwd); err != nil {
+		t.Fatalf("创建转发规则失败: %v", err)
+	}
+	filter := &db.DeviceFilter{UserID: tenantA, Name: "online", Expression: "status=online"}
+	if err := st.Filters.Create(filter); err != nil {
+		t.Fatalf("创建筛选条件失败: %v", err)
+	}
+	link := &db.ObserverLink{UserID: tenantA, DeviceID: source.ID, TokenHash: "hash-a"}
+	if err := st.Observers.Create(link); err != nil {
+		t.Fatalf("创建观察链接失败: %v", err)
+	}
+
+	engine := tenantEngine(st)
+	id := func(v uint) string { return strconv.FormatUint(uint64(v), 10) }
+	dev := id(source.ID)
+
+	// 租户 A 可以访问自己的数据
+	for _, path := range []string{"/routes/" + id(r.ID), "/speedtests/" + id(schedule.ID), "/alerts/" + id(rule.ID), "/forwards/" + id(fwd.ID), "/devices/" + dev + "/observers"} {
+		if w := serveAs(engine, tenantA, http.MethodGet, path, ""); w.Code != http.StatusOK {
+			t.Fatalf("租户 A 访问 %s 应成功: %d %s", path, w.Code, w.Body.String())
+		}
+	}
+
+	// 租户 B 读写租户 A 的数据时视为不存在
+	requests := []struct {
+		method, path, body string
+	}{
+		{http.MethodGet, "/routes/" + id(r.ID), ""},
+		{http.MethodPut, "/routes/" + id(r.ID), `{"description":"b"}`},
+		{http.MethodDelete, "/routes/" + id(r.ID), ""},
+		{http.MethodGet, "/speedtests/" + id(schedule.ID), ""},
+		{http.MethodPut, "/speedtests/" + id(schedule.ID), `{"interval":30}`},
+		{http.MethodDelete, "/speedtests/" + id(schedule.ID), ""},
+		{http.MethodGet, "/alerts/" + id(rule.ID), ""},
+		{http.MethodPut, "/alerts/" + id(rule.ID), `{"name":"b"}`},
+		{http.MethodDelete, "/alerts/" + id(rule.ID), ""},
+		{http.MethodGet, "/forwards/" + id(fwd.ID), ""},
+		{http.MethodPut, "/forwards/" + id(fwd.ID), `{"dstPort":81}`},
+		{http.MethodDelete, "/forwards/" + id(fwd.ID), ""},
+		{http.MethodPost, "/forwards/" + id(fwd.ID) + "/enable", ""},
+		{http.MethodGet, "/filters/" + id(filter.ID) + "/devices", ""},
+		{http.MethodDelete, "/filters/" + id(filter.ID), ""},
+		{http.MethodGet, "/devices/" + dev + "/observers", ""},
+		{http.MethodPost, "/devices/" + dev + "/observers", `{}`},
+		{http.MethodDelete, "/devices/" + dev + "/observers/" + id(link.ID), ""},
+		{http.MethodGet, "/devices/" + dev + "/scans", ""},
+		{http.MethodPost, "/devices/" + dev + "/scans", `{"portStart":22}`},
+		{http.MethodPost, "/fleet/jobs", `{"action":"set-log-level","params":{"level":"debug"},"deviceIds":[` + dev + `]}`},
+	}
+	for _, req := range requests {
+		if w := serveAs(engine, tenantB, req.method, req.path, req.body); w.Code != http.StatusNotFound {
+			t.Errorf("租户 B %s %s 应返回 404: %d %s", req.method, req.path, w.Code, w.Body.String())
+		}
+	}
+
+	// 租户 B 导出的数据不包含租户 A 的设备
+	w := serveAs(engine, tenantB, http.MethodGet, "/export/devices", "")
+	if w.Code != http.StatusOK || strings.Contains(w.Body.String(), source.Name) {
+		t.Fatalf("租户 B 不应导出租户 A 的设备: %d %s", w.Code, w.Body.String())
+	}
+
+	// 租户 B 的请求没有修改租户 A 的数据
+	for _, path := range []string{"/routes/" + id(r.ID), "/speedtests/" + id(schedule.ID), "/alerts/" + id(rule.ID), "/forwards/" + id(fwd.ID)} {
+		if w := serveAs(engine, tenantA, http.MethodGet, path, ""); w.Code != http.StatusOK {
+			t.Fatalf("租户 A 的数据不应被租户 B 删除 %s: %d", path, w.Code)
+		}
+	}
+	if got, err := st.Routes.GetByID(r.ID); err != nil || got.Description != "" {
+		t.Fatalf("租户 A 的路由不应被租户 B 修改: %+v %v", got, err)
+	}
+}
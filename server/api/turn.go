@@ -0,0 +1,54 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/api/middleware"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/device"
+	"github.com/senma231/p3/server/relay"
+)
+
+// TURNController TURN 凭据控制器
+type TURNController struct {
+	config *config.TURNConfig
+}
+
+// NewTURNController 创建 TURN 凭据控制器
+func NewTURNController(cfg *config.TURNConfig) *TURNController {
+	return &TURNController{
+		config: cfg,
+	}
+}
+
+// GetCredentials 为请求的设备签发短期 TURN 凭据，用于 WebRTC 传输的 ICE 配置
+func (c *TURNController) GetCredentials(ctx *gin.Context) {
+	dev := ctx.MustGet("device").(*db.Device)
+
+	ttl := time.Duration(c.config.CredentialTTL) * time.Second
+	username, password := relay.MintTURNCredentials(c.config.AuthSecret, dev.NodeID, time.Now(), ttl)
+
+	// 凭据只在有效期内可用，不允许中间代理缓存
+	ctx.Header("Cache-Control", "no-store")
+	ctx.JSON(http.StatusOK, &relay.TURNCredentials{
+		Username: username,
+		Password: password,
+		TTL:      c.config.CredentialTTL,
+		URIs:     relay.TURNURIs(c.config.URIs, c.config.Address, c.config.Realm),
+	})
+}
+
+// RegisterTURNRoutes 注册 TURN 凭据路由，使用设备认证
+func RegisterTURNRoutes(router *gin.Engine, deviceService *device.Service, cfg *config.TURNConfig) {
+	turnController := NewTURNController(cfg)
+
+	turn := router.Group("/api/v1/turn")
+	turn.Use(middleware.DeviceAuth(deviceService))
+	{
+		turn.GET("/credentials", turnController.GetCredentials)
+		turn.POST("/credentials", turnController.GetCredentials)
+	}
+}
@@ -0,0 +1,13 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/version"
+)
+
+// GetVersion 获取服务端版本和构建信息，客户端据此检查更新和兼容性
+func GetVersion(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, version.Get())
+}
@@ -1,51 +1,68 @@
 package app
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"time"
 
+	"github.com/senma231/p3/common/errors"
 	"github.com/senma231/p3/server/config"
 	"github.com/senma231/p3/server/db"
-	"gorm.io/gorm"
+	"github.com/senma231/p3/server/store"
 )
 
 // Service 应用服务
 type Service struct {
-	config *config.Config
+	config  *config.Config
+	st      *store.Store
+	apps    store.AppRepo
+	devices store.DeviceRepo
+	stats   store.StatsRepo
 }
 
 // NewService 创建应用服务
-func NewService(cfg *config.Config) *Service {
+func NewService(cfg *config.Config, st *store.Store) *Service {
 	return &Service{
-		config: cfg,
+		config:  cfg,
+		st:      st,
+		apps:    st.Apps,
+		devices: st.Devices,
+		stats:   st.Stats,
 	}
 }
 
-// CreateApp 创建应用
+// ForTenant 返回只能访问 ctx 中租户数据的应用服务，其他租户的应用和设备视为不存在
+func (s *Service) ForTenant(ctx context.Context) (*Service, error) {
+	scoped, err := s.st.ForContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	svc := *s
+	svc.apps = scoped.Apps
+	svc.devices = scoped.Devices
+	svc.stats = scoped.Stats
+	return &svc, nil
+}
+
+// ErrPortInUse 源端口已被同一设备上的其他应用占用
+var ErrPortInUse = errors.New(errors.ErrPortInUse, "源端口已被使用")
+
+// CreateApp 创建应用，端口检查和创建由仓库原子完成
 func (s *Service) CreateApp(userID, deviceID uint, name, protocol string, srcPort int, peerNode string, dstPort int, dstHost, description string) (*db.App, error) {
 	// 检查设备是否存在
-	var device db.Device
-	if err := db.DB.First(&device, deviceID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("设备不存在")
+	device, err := s.devices.GetByID(deviceID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("设备不存在")
 		}
 		return nil, fmt.Errorf("查询设备失败: %w", err)
 	}
 
 	// 检查设备是否属于用户
 	if device.UserID != userID {
-		return nil, errors.New("设备不属于该用户")
-	}
-
-	// 检查源端口是否已被使用
-	var existingApp db.App
-	if err := db.DB.Where("device_id = ? AND src_port = ?", deviceID, srcPort).First(&existingApp).Error; err == nil {
-		return nil, errors.New("源端口已被使用")
-	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
-		return nil, fmt.Errorf("查询应用失败: %w", err)
+		return nil, errors.Forbidden("设备不属于该用户")
 	}
 
-	// 创建应用
 	app := &db.App{
 		UserID:      userID,
 		DeviceID:    deviceID,
@@ -55,11 +72,15 @@ func (s *Service) CreateApp(userID, deviceID uint, name, protocol string, srcPor
 		PeerNode:    peerNode,
 		DstPort:     dstPort,
 		DstHost:     dstHost,
-		Status:      "stopped",
+		Status:      StatusStopped,
 		Description: description,
 	}
 
-	if err := db.DB.Create(app).Error; err != nil {
+	// 创建应用
+	if err := s.apps.Create(app); err != nil {
+		if store.IsDuplicate(err) {
+			return nil, ErrPortInUse
+		}
 		return nil, fmt.Errorf("创建应用失败: %w", err)
 	}
 
@@ -68,20 +89,20 @@ func (s *Service) CreateApp(userID, deviceID uint, name, protocol string, srcPor
 
 // GetAppByID 根据 ID 获取应用
 func (s *Service) GetAppByID(appID uint) (*db.App, error) {
-	var app db.App
-	if err := db.DB.First(&app, appID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("应用不存在")
+	app, err := s.apps.GetByID(appID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("应用不存在")
 		}
 		return nil, fmt.Errorf("查询应用失败: %w", err)
 	}
-	return &app, nil
+	return app, nil
 }
 
 // GetAppsByUserID 获取用户的所有应用
 func (s *Service) GetAppsByUserID(userID uint) ([]db.App, error) {
-	var apps []db.App
-	if err := db.DB.Where("user_id = ?", userID).Find(&apps).Error; err != nil {
+	apps, err := s.apps.ListByUser(userID)
+	if err != nil {
 		return nil, fmt.Errorf("查询应用失败: %w", err)
 	}
 	return apps, nil
@@ -89,21 +110,24 @@ func (s *Service) GetAppsByUserID(userID uint) ([]db.App, error) {
 
 // GetAppsByDeviceID 获取设备的所有应用
 func (s *Service) GetAppsByDeviceID(deviceID uint) ([]db.App, error) {
-	var apps []db.App
-	if err := db.DB.Where("device_id = ?", deviceID).Find(&apps).Error; err != nil {
+	apps, err := s.apps.ListByDevice(deviceID)
+	if err != nil {
 		return nil, fmt.Errorf("查询应用失败: %w", err)
 	}
 	return apps, nil
 }
 
-// UpdateApp 更新应用信息
-func (s *Service) UpdateApp(appID uint, updates map[string]interface{}) (*db.App, error) {
+// UpdateApp 更新应用信息，revision 不为 0 时仅在修订号匹配时更新
+func (s *Service) UpdateApp(appID uint, revision uint, updates map[string]interface{}) (*db.App, error) {
 	app, err := s.GetAppByID(appID)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := db.DB.Model(app).Updates(updates).Error; err != nil {
+	if err := s.apps.Update(app, revision, updates); err != nil {
+		if store.IsDuplicate(err) {
+			return nil, ErrPortInUse
+		}
 		return nil, fmt.Errorf("更新应用失败: %w", err)
 	}
 
@@ -112,24 +136,24 @@ func (s *Service) UpdateApp(appID uint, updates map[string]interface{}) (*db.App
 
 // DeleteApp 删除应用
 func (s *Service) DeleteApp(appID uint) error {
-	if err := db.DB.Delete(&db.App{}, appID).Error; err != nil {
+	if err := s.apps.Delete(appID); err != nil {
 		return fmt.Errorf("删除应用失败: %w", err)
 	}
 	return nil
 }
 
-// StartApp 启动应用
+// StartApp 启动应用。应用先进入 starting 状态，由设备上报的健康检查结果确认是否运行
 func (s *Service) StartApp(appID uint) (*db.App, error) {
 	app, err := s.GetAppByID(appID)
 	if err != nil {
 		return nil, err
 	}
 
-	if app.Status == "running" {
+	if app.Status != StatusStopped {
 		return app, nil
 	}
 
-	if err := db.DB.Model(app).Update("status", "running").Error; err != nil {
+	if err := s.apps.UpdateFields(app, map[string]interface{}{"status": StatusStarting}); err != nil {
 		return nil, fmt.Errorf("更新应用状态失败: %w", err)
 	}
 
@@ -143,11 +167,11 @@ func (s *Service) StopApp(appID uint) (*db.App, error) {
 		return nil, err
 	}
 
-	if app.Status == "stopped" {
+	if app.Status == StatusStopped {
 		return app, nil
 	}
 
-	if err := db.DB.Model(app).Update("status", "stopped").Error; err != nil {
+	if err := s.apps.UpdateFields(app, map[string]interface{}{"status": StatusStopped}); err != nil {
 		return nil, fmt.Errorf("更新应用状态失败: %w", err)
 	}
 
@@ -163,13 +187,13 @@ func (s *Service) GetAppStats(appID uint) (map[string]interface{}, error) {
 	}
 
 	// 获取应用的统计信息
-	var stats db.Stats
-	if err := db.DB.Where("app_id = ?", appID).Order("created_at DESC").First(&stats).Error; err != nil {
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
+	stats, err := s.stats.LatestByApp(appID)
+	if err != nil {
+		if !store.IsNotFound(err) {
 			return nil, fmt.Errorf("查询统计信息失败: %w", err)
 		}
 		// 如果没有统计信息，使用默认值
-		stats = db.Stats{
+		stats = &db.Stats{
 			AppID:          appID,
 			BytesSent:      0,
 			BytesReceived:  0,
@@ -187,3 +211,12 @@ func (s *Service) GetAppStats(appID uint) (map[string]interface{}, error) {
 		"connectionTime": stats.ConnectionTime,
 	}, nil
 }
+
+// GetAppDestinations 合计应用自 since 以来到各目标地址的流量，按 orderBy 降序返回前 limit 个
+func (s *Service) GetAppDestinations(appID uint, since time.Time, limit int, orderBy string) ([]db.DestinationTotal, error) {
+	totals, err := s.stats.TopDestinations(appID, since, limit, orderBy)
+	if err != nil {
+		return nil, fmt.Errorf("查询目标地址统计失败: %w", err)
+	}
+	return totals, nil
+}
@@ -0,0 +1,87 @@
+package app
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+func TestCreateAppPortConflict(t *testing.T) {
+	st := store.NewMemoryStore()
+	s := NewService(config.DefaultConfig(), st)
+
+	device := &db.Device{UserID: 1, Name: "nas", NodeID: "node-a"}
+	if err := st.Devices.Create(device); err != nil {
+		t.Fatalf("创建设备失败: %v", err)
+	}
+	web, err := s.CreateApp(1, device.ID, "web", "tcp", 8080, "node-b", 80, "127.0.0.1", "")
+	if err != nil {
+		t.Fatalf("创建应用失败: %v", err)
+	}
+
+	// 端口冲突返回 ErrPortInUse，接口按错误码返回 409
+	_, err = s.CreateApp(1, device.ID, "web2", "tcp", 8080, "node-b", 81, "127.0.0.1", "")
+	if !errors.Is(err, errors.ErrPortInUse) || errors.AsError(err).StatusCode() != http.StatusConflict {
+		t.Fatalf("端口冲突应返回 409: %v", err)
+	}
+	if _, err := s.CreateApp(1, device.ID, "dns", "udp", 8080, "node-b", 53, "127.0.0.1", ""); err != nil {
+		t.Fatalf("不同协议不应冲突: %v", err)
+	}
+
+	ssh, err := s.CreateApp(1, device.ID, "ssh", "tcp", 2222, "node-b", 22, "127.0.0.1", "")
+	if err != nil {
+		t.Fatalf("创建应用失败: %v", err)
+	}
+	_, err = s.UpdateApp(ssh.ID, 0, map[string]interface{}{"src_port": web.SrcPort})
+	if errors.AsError(err).StatusCode() != http.StatusConflict {
+		t.Fatalf("更新为已占用的端口应返回 409: %v", err)
+	}
+
+	if _, err := s.CreateApp(2, device.ID, "web", "tcp", 9090, "node-b", 80, "127.0.0.1", ""); errors.AsError(err).StatusCode() != http.StatusForbidden {
+		t.Fatalf("设备不属于用户时应返回 403: %v", err)
+	}
+}
+
+func TestCreateAppConcurrent(t *testing.T) {
+	st := store.NewMemoryStore()
+	s := NewService(config.DefaultConfig(), st)
+
+	device := &db.Device{UserID: 1, Name: "nas", NodeID: "node-a"}
+	if err := st.Devices.Create(device); err != nil {
+		t.Fatalf("创建设备失败: %v", err)
+	}
+
+	// 并发创建占用同一端口的应用，只有一个成功，其余返回端口冲突
+	const n = 16
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = s.CreateApp(1, device.ID, "web", "tcp", 8080, "node-b", 80, "127.0.0.1", "")
+		}(i)
+	}
+	wg.Wait()
+
+	created := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			created++
+		case errors.AsError(err).StatusCode() != http.StatusConflict:
+			t.Errorf("并发创建应返回 409: %v", err)
+		}
+	}
+	if created != 1 {
+		t.Fatalf("并发创建成功 %d 个应用", created)
+	}
+	if apps, _ := s.GetAppsByDeviceID(device.ID); len(apps) != 1 {
+		t.Fatalf("设备上有 %d 个应用", len(apps))
+	}
+}
@@ -0,0 +1,112 @@
+package app
+
+import (
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+)
+
+// 应用状态
+const (
+	StatusStopped  = "stopped"
+	StatusStarting = "starting" // 已启动，等待设备上报健康检查结果
+	StatusRunning  = "running"
+	StatusDegraded = "degraded" // 健康检查失败，正在重试，或依赖的应用未运行
+	StatusFailed   = "failed"   // 健康检查连续失败达到重试次数
+	StatusWaiting  = "waiting"  // 对端节点离线，等待其上线后启动
+)
+
+// 应用健康状态变化时记录的设备事件类型
+const (
+	EventAppDegraded  = "app-degraded"
+	EventAppUnhealthy = "app-unhealthy"
+	EventAppHealthy   = "app-healthy"
+)
+
+// maxHealthReports 单次上报的最大应用数
+const maxHealthReports = 100
+
+// HealthReport 设备上报的应用健康状态
+type HealthReport struct {
+	Name      string    `json:"name" binding:"required,max=50,safetext" sanitize:"text"`
+	Status    string    `json:"status" binding:"required,oneof=running degraded failed waiting"`
+	Detail    string    `json:"detail" binding:"max=500,safemultiline" sanitize:"multiline"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// ReportHealth 根据设备上报的健康检查结果更新应用状态，返回状态有变化的应用。
+// 已停止或不存在的应用会被忽略；状态变为降级、失败或从中恢复时记录设备事件
+func (s *Service) ReportHealth(deviceID uint, reports []HealthReport) ([]db.App, error) {
+	if len(reports) > maxHealthReports {
+		return nil, errors.InvalidParam("单次上报的应用过多")
+	}
+
+	apps, err := s.apps.ListByDevice(deviceID)
+	if err != nil {
+		return nil, errors.Database("查询应用失败", err)
+	}
+	byName := make(map[string]*db.App, len(apps))
+	for i := range apps {
+		byName[apps[i].Name] = &apps[i]
+	}
+
+	now := time.Now()
+	changed := []db.App{}
+	var events []db.DeviceEvent
+	for _, report := range reports {
+		app, ok := byName[report.Name]
+		if !ok || app.Status == StatusStopped {
+			continue
+		}
+
+		checkedAt := report.CheckedAt
+		if checkedAt.IsZero() || checkedAt.After(now) {
+			checkedAt = now
+		}
+		previous := app.Status
+		if err := s.apps.UpdateFields(app, map[string]interface{}{
+			"status":            report.Status,
+			"health_detail":     report.Detail,
+			"health_checked_at": &checkedAt,
+		}); err != nil {
+			return nil, errors.Database("更新应用状态失败", err)
+		}
+		if previous == report.Status {
+			continue
+		}
+		changed = append(changed, *app)
+
+		if eventType := healthEvent(previous, report.Status); eventType != "" {
+			events = append(events, db.DeviceEvent{
+				DeviceID:   deviceID,
+				Type:       eventType,
+				App:        app.Name,
+				Detail:     report.Detail,
+				OccurredAt: checkedAt,
+			})
+		}
+	}
+
+	if len(events) > 0 {
+		if err := s.devices.CreateEvents(events); err != nil {
+			return nil, errors.Database("保存设备事件失败", err)
+		}
+	}
+	return changed, nil
+}
+
+// healthEvent 应用状态变化对应的事件类型，不需要记录时返回空字符串
+func healthEvent(previous, current string) string {
+	switch current {
+	case StatusDegraded:
+		return EventAppDegraded
+	case StatusFailed:
+		return EventAppUnhealthy
+	case StatusRunning:
+		if previous == StatusDegraded || previous == StatusFailed {
+			return EventAppHealthy
+		}
+	}
+	return ""
+}
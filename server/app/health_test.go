@@ -0,0 +1,57 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+func TestReportHealth(t *testing.T) {
+	st := store.NewMemoryStore()
+	s := NewService(config.DefaultConfig(), st)
+
+	device := &db.Device{UserID: 1, Name: "nas", NodeID: "node-a"}
+	if err := st.Devices.Create(device); err != nil {
+		t.Fatalf("创建设备失败: %v", err)
+	}
+	web, err := s.CreateApp(1, device.ID, "web", "tcp", 8080, "node-b", 80, "127.0.0.1", "")
+	if err != nil {
+		t.Fatalf("创建应用失败: %v", err)
+	}
+	if _, err := s.CreateApp(1, device.ID, "ssh", "tcp", 2222, "node-b", 22, "127.0.0.1", ""); err != nil {
+		t.Fatalf("创建应用失败: %v", err)
+	}
+
+	// 启动后等待健康检查确认
+	if web, err = s.StartApp(web.ID); err != nil || web.Status != StatusStarting {
+		t.Fatalf("启动后状态应为 starting: %+v %v", web, err)
+	}
+
+	// 已停止的应用和不存在的应用被忽略
+	changed, err := s.ReportHealth(device.ID, []HealthReport{
+		{Name: "web", Status: StatusRunning},
+		{Name: "ssh", Status: StatusRunning},
+		{Name: "ftp", Status: StatusFailed},
+	})
+	if err != nil || len(changed) != 1 || changed[0].Status != StatusRunning {
+		t.Fatalf("上报结果错误: %+v %v", changed, err)
+	}
+
+	// 失败后恢复各记录一次事件，状态不变时不重复记录
+	for _, status := range []string{StatusFailed, StatusFailed, StatusRunning} {
+		if _, err := s.ReportHealth(device.ID, []HealthReport{{Name: "web", Status: status, Detail: "connection refused"}}); err != nil {
+			t.Fatalf("上报失败: %v", err)
+		}
+	}
+	events, err := st.Devices.ListEvents(device.ID, 10)
+	if err != nil || len(events) != 2 {
+		t.Fatalf("应记录 2 个事件: %+v %v", events, err)
+	}
+
+	web, _ = s.GetAppByID(web.ID)
+	if web.Status != StatusRunning || web.HealthCheckedAt == nil {
+		t.Fatalf("应用状态错误: %+v", web)
+	}
+}
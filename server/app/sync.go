@@ -0,0 +1,50 @@
+package app
+
+import (
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+)
+
+// Changes 设备应用配置的增量
+type Changes struct {
+	Version uint     `json:"version"`
+	Full    bool     `json:"full"`    // 为 true 时 Apps 是完整的应用列表，客户端应替换本地列表
+	Apps    []db.App `json:"apps"`    // 新增或更新的应用
+	Removed []string `json:"removed"` // 已删除的应用名称
+}
+
+// GetChanges 获取客户端已同步的版本号 since 之后设备应用配置的变化。
+// since 为 0 或大于当前版本号（例如数据库已恢复到较早的备份）时返回完整的应用列表
+func (s *Service) GetChanges(deviceID, since uint) (*Changes, error) {
+	version, changed, removed, err := s.apps.ListChanges(deviceID, since)
+	if err != nil {
+		return nil, errors.Database("查询应用变化失败", err)
+	}
+
+	if since == 0 || since > version {
+		apps, err := s.apps.ListByDevice(deviceID)
+		if err != nil {
+			return nil, errors.Database("查询应用失败", err)
+		}
+		return &Changes{Version: version, Full: true, Apps: apps, Removed: []string{}}, nil
+	}
+
+	changes := &Changes{Version: version, Apps: changed, Removed: make([]string, 0, len(removed))}
+	for _, app := range removed {
+		// 删除后又创建了同名应用时只返回新的应用
+		if !containsApp(changed, app.Name) {
+			changes.Removed = append(changes.Removed, app.Name)
+		}
+	}
+	return changes, nil
+}
+
+// containsApp 检查应用列表中是否有指定名称的应用
+func containsApp(apps []db.App, name string) bool {
+	for _, app := range apps {
+		if app.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+func TestGetChanges(t *testing.T) {
+	st := store.NewMemoryStore()
+	s := NewService(config.DefaultConfig(), st)
+
+	device := &db.Device{UserID: 1, Name: "nas", NodeID: "node-a"}
+	if err := st.Devices.Create(device); err != nil {
+		t.Fatalf("创建设备失败: %v", err)
+	}
+	web, err := s.CreateApp(1, device.ID, "web", "tcp", 8080, "node-b", 80, "127.0.0.1", "")
+	if err != nil {
+		t.Fatalf("创建应用失败: %v", err)
+	}
+	ssh, err := s.CreateApp(1, device.ID, "ssh", "tcp", 2222, "node-b", 22, "127.0.0.1", "")
+	if err != nil {
+		t.Fatalf("创建应用失败: %v", err)
+	}
+
+	// 首次同步返回完整列表
+	full, err := s.GetChanges(device.ID, 0)
+	if err != nil || !full.Full || len(full.Apps) != 2 || full.Version != 2 {
+		t.Fatalf("首次同步应返回完整列表: %+v %v", full, err)
+	}
+
+	// 没有变化时返回空的增量
+	if changes, _ := s.GetChanges(device.ID, full.Version); changes.Full || len(changes.Apps) != 0 || len(changes.Removed) != 0 {
+		t.Fatalf("没有变化时不应返回应用: %+v", changes)
+	}
+
+	// 只返回更新和删除的应用
+	if _, err := s.UpdateApp(web.ID, 0, map[string]interface{}{"dst_port": 8000}); err != nil {
+		t.Fatalf("更新应用失败: %v", err)
+	}
+	if err := s.DeleteApp(ssh.ID); err != nil {
+		t.Fatalf("删除应用失败: %v", err)
+	}
+	changes, err := s.GetChanges(device.ID, full.Version)
+	if err != nil || changes.Full || changes.Version != 4 {
+		t.Fatalf("增量同步失败: %+v %v", changes, err)
+	}
+	if len(changes.Apps) != 1 || changes.Apps[0].DstPort != 8000 {
+		t.Fatalf("应返回更新的应用: %+v", changes.Apps)
+	}
+	if len(changes.Removed) != 1 || changes.Removed[0] != "ssh" {
+		t.Fatalf("应返回删除的应用: %+v", changes.Removed)
+	}
+
+	// 客户端的版本号比服务端新时完整同步
+	if changes, _ := s.GetChanges(device.ID, 100); !changes.Full || len(changes.Apps) != 1 {
+		t.Fatalf("版本号无效时应完整同步: %+v", changes)
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// APIKeyPrefix API 密钥的前缀，认证中间件据此区分 API 密钥和访问令牌
+const APIKeyPrefix = "p3k_"
+
+// apiKeySize API 密钥的随机字节数
+const apiKeySize = 32
+
+// apiKeyDisplayPrefix 保存用于辨认密钥的前缀长度，包括 APIKeyPrefix
+const apiKeyDisplayPrefix = 12
+
+// maxAPIKeysPerUser 每个用户的 API 密钥数
+const maxAPIKeysPerUser = 50
+
+var (
+	// ErrAPIKeyInvalid API 密钥不存在、已撤销或已过期
+	ErrAPIKeyInvalid = errors.New("API 密钥无效或已过期")
+	// ErrAPIKeyNotFound API 密钥不存在或不属于当前用户
+	ErrAPIKeyNotFound = errors.New("API 密钥不存在")
+	// ErrScopeNotAllowed 请求的授权范围超出用户角色的授权范围
+	ErrScopeNotAllowed = errors.New("授权范围超出用户角色的授权范围")
+)
+
+// APIKeyOptions 创建 API 密钥的参数
+type APIKeyOptions struct {
+	Name   string
+	Scopes []string      // 密钥的授权范围，为空时为用户角色的全部授权范围
+	TTL    time.Duration // 有效期，为 0 时不过期
+}
+
+// CreateAPIKey 为用户创建 API 密钥，返回密钥记录和密钥。密钥只在创建时返回，服务端仅保存其哈希。
+// 授权范围必须是用户角色授权范围的子集，否则返回包装了 ErrScopeNotAllowed 的错误
+func (s *Service) CreateAPIKey(userID uint, opts APIKeyOptions) (*db.APIKey, string, error) {
+	if opts.TTL < 0 {
+		return nil, "", errors.New("API 密钥有效期无效")
+	}
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	allowed := UserScopes(user)
+	scopes := allowed
+	if len(opts.Scopes) > 0 {
+		scopes = uniqueScopes(opts.Scopes)
+		required := make([]Scope, len(scopes))
+		for i, scope := range scopes {
+			required[i] = Scope(scope)
+		}
+		if missing := MissingScopes(allowed, required...); len(missing) > 0 {
+			return nil, "", fmt.Errorf("%w: %s", ErrScopeNotAllowed, strings.Join(missing, ", "))
+		}
+	}
+
+	keys, err := s.apiKeys.ListByUser(userID)
+	if err != nil {
+		return nil, "", fmt.Errorf("查询 API 密钥失败: %w", err)
+	}
+	if len(keys) >= maxAPIKeysPerUser {
+		return nil, "", errors.New("API 密钥过多")
+	}
+
+	buf := make([]byte, apiKeySize)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", fmt.Errorf("生成 API 密钥失败: %w", err)
+	}
+	secret := APIKeyPrefix + base64.RawURLEncoding.EncodeToString(buf)
+
+	key := &db.APIKey{
+		UserID:  userID,
+		Name:    opts.Name,
+		Prefix:  secret[:apiKeyDisplayPrefix],
+		KeyHash: hashAPIKey(secret),
+		Scopes:  scopes,
+	}
+	if opts.TTL > 0 {
+		expiresAt := s.now().Add(opts.TTL)
+		key.ExpiresAt = &expiresAt
+	}
+	if err := s.apiKeys.Create(key); err != nil {
+		return nil, "", fmt.Errorf("创建 API 密钥失败: %w", err)
+	}
+	return key, secret, nil
+}
+
+// ListAPIKeys 获取用户的 API 密钥，包含已过期但未删除的密钥
+func (s *Service) ListAPIKeys(userID uint) ([]db.APIKey, error) {
+	keys, err := s.apiKeys.ListByUser(userID)
+	if err != nil {
+		return nil, fmt.Errorf("查询 API 密钥失败: %w", err)
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey 撤销用户的 API 密钥，其他用户的密钥返回 ErrAPIKeyNotFound
+func (s *Service) DeleteAPIKey(userID, keyID uint) error {
+	key, err := s.apiKeys.GetByID(keyID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return ErrAPIKeyNotFound
+		}
+		return fmt.Errorf("查询 API 密钥失败: %w", err)
+	}
+	if key.UserID != userID {
+		return ErrAPIKeyNotFound
+	}
+	if err := s.apiKeys.Delete(keyID); err != nil {
+		return fmt.Errorf("删除 API 密钥失败: %w", err)
+	}
+	return nil
+}
+
+// AuthenticateAPIKey 验证 API 密钥，返回密钥所属的用户和生效的授权范围。
+// 生效的授权范围为密钥的授权范围与用户当前角色授权范围的交集，用户被降级后密钥随之失去相应权限
+func (s *Service) AuthenticateAPIKey(secret string) (*db.APIKey, *db.User, []string, error) {
+	if !strings.HasPrefix(secret, APIKeyPrefix) {
+		return nil, nil, nil, ErrAPIKeyInvalid
+	}
+	key, err := s.apiKeys.GetByKeyHash(hashAPIKey(secret))
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, nil, nil, ErrAPIKeyInvalid
+		}
+		return nil, nil, nil, fmt.Errorf("查询 API 密钥失败: %w", err)
+	}
+	if !key.Active(s.now()) {
+		return nil, nil, nil, ErrAPIKeyInvalid
+	}
+
+	user, err := s.GetUserByID(key.UserID)
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, nil, nil, ErrAPIKeyInvalid
+		}
+		return nil, nil, nil, err
+	}
+
+	allowed := make(map[string]bool)
+	for _, scope := range UserScopes(user) {
+		allowed[scope] = true
+	}
+	scopes := make([]string, 0, len(key.Scopes))
+	for _, scope := range key.Scopes {
+		if allowed[scope] {
+			scopes = append(scopes, scope)
+		}
+	}
+	return key, user, scopes, nil
+}
+
+// uniqueScopes 去掉重复的授权范围，保持原有顺序
+func uniqueScopes(scopes []string) []string {
+	seen := make(map[string]bool, len(scopes))
+	result := make([]string, 0, len(scopes))
+	for _, scope := range scopes {
+		if !seen[scope] {
+			seen[scope] = true
+			result = append(result, scope)
+		}
+	}
+	return result
+}
+
+// hashAPIKey 计算 API 密钥的哈希
+func hashAPIKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
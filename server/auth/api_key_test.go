@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+)
+
+func TestAPIKey(t *testing.T) {
+	s, st, now := newTestService(t)
+	user := &db.User{Username: "alice", IsAdmin: true}
+	if err := st.Users.Create(user); err != nil {
+		t.Fatalf("创建用户失败: %v", err)
+	}
+
+	key, secret, err := s.CreateAPIKey(user.ID, APIKeyOptions{
+		Name:   "ci",
+		Scopes: []string{string(ScopeDevicesRead), string(ScopeRelayAdmin), string(ScopeDevicesRead)},
+		TTL:    time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("创建 API 密钥失败: %v", err)
+	}
+	if !strings.HasPrefix(secret, APIKeyPrefix) || key.KeyHash == secret || !strings.HasPrefix(secret, key.Prefix) {
+		t.Fatalf("密钥 %q 或记录 %+v 不正确", secret, key)
+	}
+	if len(key.Scopes) != 2 {
+		t.Fatalf("重复的授权范围应合并: %v", key.Scopes)
+	}
+
+	_, authed, scopes, err := s.AuthenticateAPIKey(secret)
+	if err != nil || authed.ID != user.ID {
+		t.Fatalf("验证 API 密钥失败: %v", err)
+	}
+	if len(scopes) != 2 {
+		t.Fatalf("生效的授权范围 = %v", scopes)
+	}
+
+	// 用户被降级后失去超出角色的授权范围
+	if err := st.Users.UpdateFields(user, map[string]interface{}{"is_admin": false}); err != nil {
+		t.Fatalf("更新用户失败: %v", err)
+	}
+	if _, _, scopes, _ := s.AuthenticateAPIKey(secret); len(scopes) != 1 || scopes[0] != string(ScopeDevicesRead) {
+		t.Fatalf("降级后生效的授权范围 = %v", scopes)
+	}
+
+	// 普通用户不能创建超出角色授权范围的密钥
+	if _, _, err := s.CreateAPIKey(user.ID, APIKeyOptions{Name: "admin", Scopes: []string{string(ScopeUsersAdmin)}}); !errors.Is(err, ErrScopeNotAllowed) {
+		t.Fatalf("超出授权范围时应返回 ErrScopeNotAllowed，实际为 %v", err)
+	}
+	if _, _, err := s.CreateAPIKey(user.ID, APIKeyOptions{Name: "observe", Scopes: []string{string(ScopeDevicesObserve)}}); !errors.Is(err, ErrScopeNotAllowed) {
+		t.Fatalf("观察链接的授权范围不能授予 API 密钥，实际为 %v", err)
+	}
+
+	// 未指定授权范围时为角色的全部授权范围
+	full, _, err := s.CreateAPIKey(user.ID, APIKeyOptions{Name: "full"})
+	if err != nil || len(full.Scopes) != len(ScopesForRole(RoleUser)) {
+		t.Fatalf("默认授权范围 = %v, err = %v", full.Scopes, err)
+	}
+
+	for _, bad := range []string{"", "p3k_unknown", "not-a-key"} {
+		if _, _, _, err := s.AuthenticateAPIKey(bad); !errors.Is(err, ErrAPIKeyInvalid) {
+			t.Errorf("密钥 %q 应无效，实际为 %v", bad, err)
+		}
+	}
+
+	// 过期
+	*now = now.Add(2 * time.Hour)
+	if _, _, _, err := s.AuthenticateAPIKey(secret); !errors.Is(err, ErrAPIKeyInvalid) {
+		t.Fatalf("过期的密钥应无效，实际为 %v", err)
+	}
+
+	// 只能撤销自己的密钥
+	if err := s.DeleteAPIKey(user.ID+1, key.ID); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Fatalf("撤销其他用户的密钥应返回 ErrAPIKeyNotFound，实际为 %v", err)
+	}
+	if err := s.DeleteAPIKey(user.ID, full.ID); err != nil {
+		t.Fatalf("撤销密钥失败: %v", err)
+	}
+	if keys, err := s.ListAPIKeys(user.ID); err != nil || len(keys) != 1 || keys[0].ID != key.ID {
+		t.Fatalf("撤销后的密钥列表 = %+v, err = %v", keys, err)
+	}
+}
@@ -3,116 +3,287 @@ package auth
 import (
 	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/senma231/p3/common/logger"
 	"github.com/senma231/p3/server/config"
 	"github.com/senma231/p3/server/db"
-	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
+	"github.com/senma231/p3/server/notify"
+	"github.com/senma231/p3/server/store"
+)
+
+var (
+	// ErrUserNotFound 用户不存在
+	ErrUserNotFound = errors.New("用户不存在")
+	// ErrWrongPassword 密码错误
+	ErrWrongPassword = errors.New("密码错误")
+	// ErrAccountLocked 登录失败次数过多，账户暂时锁定
+	ErrAccountLocked = errors.New("登录失败次数过多，请稍后再试")
+	// ErrTOTPRequired 已启用双因素认证，需要提供验证码
+	ErrTOTPRequired = errors.New("需要双因素认证代码")
+	// ErrTOTPInvalid 双因素认证验证码无效
+	ErrTOTPInvalid = errors.New("双因素认证代码无效")
 )
 
 // Claims JWT 声明
 type Claims struct {
-	UserID uint   `json:"userId"`
-	Username string `json:"username"`
+	UserID   uint     `json:"userId"`
+	Username string   `json:"username"`
+	Scopes   []string `json:"scopes,omitempty"`
+	// Binding 登录环境，刷新令牌时与请求环境比较
+	Binding *SessionBinding `json:"bnd,omitempty"`
 	jwt.StandardClaims
 }
 
 // Service 认证服务
 type Service struct {
-	config *config.Config
+	config      *config.Config
+	users       store.UserRepo
+	totps       store.TOTPRepo
+	invitations store.InvitationRepo
+	apiKeys     store.APIKeyRepo
+	hasher      PasswordHasher
+	policy      *PasswordPolicy
+	lockout     *loginLockout
+	providers   []Provider // 外部认证源，按添加顺序尝试
+	// mailer 发送验证邮件，throttle 限制重新发送的频率
+	mailer   notify.Notifier
+	throttle *verificationThrottle
+	// anomalyNotices 限制会话异常通知的频率
+	anomalyNotices *verificationThrottle
+	// now 获取当前时间，测试时可替换
+	now func() time.Time
 }
 
-// NewService 创建认证服务
-func NewService(cfg *config.Config) *Service {
+// NewService 创建认证服务，hasher 为空时使用默认参数的 Argon2id
+func NewService(cfg *config.Config, st *store.Store, hasher PasswordHasher) *Service {
+	if hasher == nil {
+		hasher = Argon2Hasher{Params: DefaultArgon2Params}
+	}
 	return &Service{
-		config: cfg,
+		config:         cfg,
+		users:          st.Users,
+		totps:          st.TOTPs,
+		invitations:    st.Invitations,
+		apiKeys:        st.APIKeys,
+		hasher:         hasher,
+		policy:         NewPasswordPolicy(cfg.Security.PasswordPolicy),
+		lockout:        newLoginLockout(),
+		throttle:       newVerificationThrottle(),
+		anomalyNotices: newVerificationThrottle(),
+		now:            time.Now,
 	}
 }
 
-// Register 注册用户
+// Register 注册用户，仅限邀请注册时需使用 RegisterWithInvitation
 func (s *Service) Register(username, password, email string) (*db.User, error) {
+	return s.RegisterWithInvitation(username, password, email, "")
+}
+
+// RegisterWithInvitation 按注册模式注册用户，开放注册时忽略邀请码，仅限邀请注册时用户的角色取自邀请。
+// 密码不符合强度策略时返回 *PasswordPolicyError。
+// 启用邮箱验证时必须提供邮箱，新用户在验证邮箱前不能创建设备
+func (s *Service) RegisterWithInvitation(username, password, email, inviteCode string) (*db.User, error) {
+	var invitation *db.Invitation
+	switch s.RegistrationMode() {
+	case RegistrationClosed:
+		return nil, ErrRegistrationClosed
+	case RegistrationInvite:
+		inv, err := s.findInvitation(inviteCode)
+		if err != nil {
+			return nil, err
+		}
+		invitation = inv
+	}
+
+	if err := s.policy.Check(username, password); err != nil {
+		return nil, err
+	}
+	if s.VerificationEnabled() && email == "" {
+		return nil, errors.New("需要提供邮箱")
+	}
+
 	// 检查用户名是否已存在
-	var existingUser db.User
-	if err := db.DB.Where("username = ?", username).First(&existingUser).Error; err == nil {
+	if _, err := s.users.GetByUsername(username); err == nil {
 		return nil, errors.New("用户名已存在")
-	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+	} else if !store.IsNotFound(err) {
 		return nil, fmt.Errorf("查询用户失败: %w", err)
 	}
 
 	// 检查邮箱是否已存在
 	if email != "" {
-		if err := db.DB.Where("email = ?", email).First(&existingUser).Error; err == nil {
+		if _, err := s.users.GetByEmail(email); err == nil {
 			return nil, errors.New("邮箱已存在")
-		} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		} else if !store.IsNotFound(err) {
 			return nil, fmt.Errorf("查询用户失败: %w", err)
 		}
 	}
 
 	// 加密密码
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedPassword, err := s.hasher.Hash(password)
 	if err != nil {
 		return nil, fmt.Errorf("加密密码失败: %w", err)
 	}
 
 	// 创建用户
 	user := &db.User{
-		Username: username,
-		Password: string(hashedPassword),
-		Email:    email,
+		Username:      username,
+		Password:      hashedPassword,
+		Email:         email,
+		EmailVerified: !s.VerificationEnabled(),
+	}
+
+	// 在创建用户前占用邀请的使用次数，避免并发注册超出次数
+	if invitation != nil {
+		if err := s.redeemInvitation(invitation); err != nil {
+			return nil, err
+		}
+		user.IsAdmin = Role(invitation.Role) == RoleAdmin
 	}
 
-	if err := db.DB.Create(user).Error; err != nil {
+	if err := s.users.Create(user); err != nil {
+		if store.IsDuplicate(err) {
+			return nil, errors.New("用户名或邮箱已存在")
+		}
 		return nil, fmt.Errorf("创建用户失败: %w", err)
 	}
 
+	// 发送失败不影响注册，用户可以稍后重新发送
+	if !user.EmailVerified {
+		if err := s.sendVerification(user); err != nil {
+			logger.Warn("向用户 %s 发送验证邮件失败: %v", username, err)
+		}
+	}
+
 	return user, nil
 }
 
-// Login 用户登录
-func (s *Service) Login(username, password string) (*db.User, string, error) {
-	// 查询用户
-	var user db.User
-	if err := db.DB.Where("username = ?", username).First(&user).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, "", errors.New("用户不存在")
-		}
-		return nil, "", fmt.Errorf("查询用户失败: %w", err)
+// Login 用户登录，已启用双因素认证的用户需要提供 totpCode。
+// 同一用户名连续失败过多时暂时锁定，锁定期间直接返回 ErrAccountLocked
+func (s *Service) Login(username, password, totpCode string) (*db.User, string, error) {
+	return s.LoginFrom(username, password, totpCode, ClientContext{})
+}
+
+// LoginFrom 用户登录，签发的令牌绑定到 client 描述的登录环境
+func (s *Service) LoginFrom(username, password, totpCode string, client ClientContext) (*db.User, string, error) {
+	now := s.now()
+	if !s.lockout.lockedUntil(username, now).IsZero() {
+		return nil, "", ErrAccountLocked
 	}
 
-	// 验证密码
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(password)); err != nil {
-		return nil, "", errors.New("密码错误")
+	user, err := s.authenticate(username, password, totpCode)
+	if err != nil {
+		// 缺少验证码不计入失败次数，客户端通常先提交密码再提示输入验证码
+		if !errors.Is(err, ErrTOTPRequired) && isCredentialError(err) {
+			if s.lockout.fail(username, now) {
+				logger.Warn("用户 %s 登录失败次数过多，已锁定 %v", username, loginLockDuration)
+			}
+		}
+		return nil, "", err
 	}
+	s.lockout.succeed(username)
 
-	// 更新最后登录时间
-	user.LastLoginAt = time.Now()
-	if err := db.DB.Save(&user).Error; err != nil {
+	// 更新最后登录时间，旧算法或旧参数的密码哈希借此机会按当前设置重新计算
+	updates := map[string]interface{}{"last_login_at": now}
+	if user.AuthSource == "" && s.hasher.NeedsRehash(user.Password) {
+		if hashed, err := s.hasher.Hash(password); err != nil {
+			logger.Warn("重新计算用户 %s 的密码哈希失败: %v", username, err)
+		} else {
+			updates["password"] = hashed
+		}
+	}
+	if err := s.users.UpdateFields(user, updates); err != nil {
 		return nil, "", fmt.Errorf("更新用户失败: %w", err)
 	}
 
 	// 生成 JWT Token
-	token, err := s.GenerateToken(user.ID, user.Username)
+	token, err := s.generateToken(user.ID, user.Username, UserScopes(user), s.sessionBinding(client))
 	if err != nil {
 		return nil, "", fmt.Errorf("生成 Token 失败: %w", err)
 	}
 
-	return &user, token, nil
+	return user, token, nil
+}
+
+// authenticate 验证用户名、密码和双因素认证验证码。本地账户验证本地密码，
+// 没有本地账户或来自外部认证源的用户由外部认证源验证
+func (s *Service) authenticate(username, password, totpCode string) (*db.User, error) {
+	user, err := s.users.GetByUsername(username)
+	if err != nil && !store.IsNotFound(err) {
+		return nil, fmt.Errorf("查询用户失败: %w", err)
+	}
+
+	if err == nil && user.AuthSource == "" {
+		// 验证密码
+		valid, err := verifyAnyHash(s.hasher, password, user.Password)
+		if err != nil {
+			logger.Error("验证用户 %s 的密码失败: %v", username, err)
+			return nil, ErrWrongPassword
+		}
+		if !valid {
+			return nil, ErrWrongPassword
+		}
+	} else {
+		var source string
+		if err == nil {
+			source = user.AuthSource
+		}
+		if user, err = s.authenticateExternal(username, password, source); err != nil {
+			return nil, err
+		}
+	}
+
+	// 检查是否启用了双因素认证
+	totp, err := s.totps.GetByUser(user.ID)
+	if err != nil && !store.IsNotFound(err) {
+		return nil, fmt.Errorf("查询双因素认证失败: %w", err)
+	}
+	if err == nil && totp.Enabled {
+		if totpCode == "" {
+			return nil, ErrTOTPRequired
+		}
+		if err := s.verifyTOTP(totp, totpCode); err != nil {
+			return nil, err
+		}
+		if err := s.totps.UpdateFields(totp, map[string]interface{}{"last_used_at": s.now()}); err != nil {
+			logger.Warn("更新双因素认证使用时间失败: %v", err)
+		}
+	}
+
+	return user, nil
+}
+
+// isCredentialError 检查错误是否由错误的凭据引起，这类错误计入登录失败次数
+func isCredentialError(err error) bool {
+	return errors.Is(err, ErrUserNotFound) ||
+		errors.Is(err, ErrWrongPassword) ||
+		errors.Is(err, ErrTOTPInvalid)
+}
+
+// GenerateToken 生成 JWT Token，scopes 为令牌的授权范围
+func (s *Service) GenerateToken(userID uint, username string, scopes []string) (string, error) {
+	return s.generateToken(userID, username, scopes, nil)
 }
 
-// GenerateToken 生成 JWT Token
-func (s *Service) GenerateToken(userID uint, username string) (string, error) {
+// generateToken 生成 JWT Token，binding 不为空时令牌绑定到登录环境
+func (s *Service) generateToken(userID uint, username string, scopes []string, binding *SessionBinding) (string, error) {
+	now := s.now()
+
 	// 设置过期时间
-	expireTime := time.Now().Add(time.Duration(s.config.JWT.ExpireTime) * time.Hour)
+	expireTime := now.Add(time.Duration(s.config.JWT.ExpireTime) * time.Hour)
 
 	// 创建声明
 	claims := &Claims{
 		UserID:   userID,
 		Username: username,
+		Scopes:   scopes,
+		Binding:  binding,
 		StandardClaims: jwt.StandardClaims{
 			ExpiresAt: expireTime.Unix(),
-			IssuedAt:  time.Now().Unix(),
+			IssuedAt:  now.Unix(),
 			Issuer:    "p3-server",
 		},
 	}
@@ -129,10 +300,15 @@ func (s *Service) GenerateToken(userID uint, username string) (string, error) {
 	return tokenString, nil
 }
 
-// ParseToken 解析 JWT Token
+// ParseToken 解析 JWT Token。验证过期、生效和签发时间时容忍 security.clockSkew.tokenLeeway 的时钟偏差，
+// 多个服务端节点的时钟不完全一致时，刚签发或刚过期的 Token 不会被误判
 func (s *Service) ParseToken(tokenString string) (*Claims, error) {
-	// 解析 Token
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+	// 解析 Token，时间由 validateTokenTimes 按容忍的偏差验证
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("不支持的签名算法: %v", token.Header["alg"])
+		}
 		return []byte(s.config.JWT.Secret), nil
 	})
 	if err != nil {
@@ -140,35 +316,224 @@ func (s *Service) ParseToken(tokenString string) (*Claims, error) {
 	}
 
 	// 验证 Token
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid {
+		return nil, errors.New("无效的 Token")
+	}
+	if err := s.validateTokenTimes(&claims.StandardClaims); err != nil {
+		return nil, err
 	}
+	return claims, nil
+}
 
-	return nil, errors.New("无效的 Token")
+// validateTokenTimes 按容忍的时钟偏差验证 Token 的过期、生效和签发时间
+func (s *Service) validateTokenTimes(claims *jwt.StandardClaims) error {
+	now := s.now().Unix()
+	leeway := int64(s.config.Security.ClockSkew.TokenLeeway)
+	if !claims.VerifyExpiresAt(now-leeway, false) {
+		return jwt.NewValidationError("Token 已过期", jwt.ValidationErrorExpired)
+	}
+	if !claims.VerifyIssuedAt(now+leeway, false) {
+		return jwt.NewValidationError("Token 签发时间晚于当前时间", jwt.ValidationErrorIssuedAt)
+	}
+	if !claims.VerifyNotBefore(now+leeway, false) {
+		return jwt.NewValidationError("Token 尚未生效", jwt.ValidationErrorNotValidYet)
+	}
+	return nil
 }
 
-// VerifyPassword 验证密码
-func (s *Service) VerifyPassword(hashedPassword, password string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+// RefreshToken 使用未过期的 Token 换取新的 Token，授权范围按用户当前的角色重新计算
+func (s *Service) RefreshToken(tokenString string) (string, error) {
+	return s.RefreshTokenFrom(tokenString, ClientContext{})
 }
 
-// HashPassword 加密密码
-func (s *Service) HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// RefreshTokenFrom 在 client 描述的环境中刷新 Token。与登录环境差异过大时按会话绑定配置
+// 返回 ErrReauthRequired 并通知用户，新 Token 保留登录时的环境
+func (s *Service) RefreshTokenFrom(tokenString string, client ClientContext) (string, error) {
+	claims, err := s.ParseToken(tokenString)
+	if err != nil {
+		return "", fmt.Errorf("无效的 Token: %w", err)
+	}
+
+	user, err := s.GetUserByID(claims.UserID)
 	if err != nil {
 		return "", err
 	}
-	return string(hashedPassword), nil
+	if err := s.checkSessionBinding(user, claims.Binding, client); err != nil {
+		return "", err
+	}
+
+	return s.generateToken(user.ID, user.Username, UserScopes(user), claims.Binding)
+}
+
+// GetUserFromRequest 从请求的 Authorization 头中解析用户
+func (s *Service) GetUserFromRequest(r *http.Request) (*db.User, error) {
+	authHeader := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return nil, errors.New("未提供认证令牌")
+	}
+
+	claims, err := s.ParseToken(strings.TrimPrefix(authHeader, "Bearer "))
+	if err != nil {
+		return nil, fmt.Errorf("无效的认证令牌: %w", err)
+	}
+
+	return s.GetUserByID(claims.UserID)
 }
 
 // GetUserByID 根据 ID 获取用户
 func (s *Service) GetUserByID(userID uint) (*db.User, error) {
-	var user db.User
-	if err := db.DB.First(&user, userID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, errors.New("用户不存在")
+	user, err := s.users.GetByID(userID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, ErrUserNotFound
 		}
 		return nil, fmt.Errorf("查询用户失败: %w", err)
 	}
-	return &user, nil
+	return user, nil
+}
+
+// ChangePassword 修改密码，新密码不符合强度策略时返回 *PasswordPolicyError
+func (s *Service) ChangePassword(userID uint, oldPassword, newPassword string) error {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.AuthSource != "" {
+		return ErrExternalAccount
+	}
+
+	// 验证旧密码
+	valid, err := verifyAnyHash(s.hasher, oldPassword, user.Password)
+	if err != nil || !valid {
+		return ErrWrongPassword
+	}
+
+	if err := s.policy.Check(user.Username, newPassword); err != nil {
+		return err
+	}
+
+	hashedPassword, err := s.hasher.Hash(newPassword)
+	if err != nil {
+		return fmt.Errorf("加密密码失败: %w", err)
+	}
+
+	if err := s.users.UpdateFields(user, map[string]interface{}{"password": hashedPassword}); err != nil {
+		return fmt.Errorf("更新密码失败: %w", err)
+	}
+	return nil
+}
+
+// EnableTOTP 为用户生成双因素认证密钥，需调用 VerifyAndEnableTOTP 验证后才生效。
+// 返回密钥和用于生成二维码的 URI
+func (s *Service) EnableTOTP(userID uint) (string, string, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return "", "", err
+	}
+
+	// 检查是否已启用
+	existing, err := s.totps.GetByUser(userID)
+	if err == nil {
+		if existing.Enabled {
+			return "", "", errors.New("双因素认证已启用")
+		}
+		// 存在但未启用，重新生成
+		if err := s.totps.Delete(existing.ID); err != nil {
+			return "", "", fmt.Errorf("删除双因素认证记录失败: %w", err)
+		}
+	} else if !store.IsNotFound(err) {
+		return "", "", fmt.Errorf("查询双因素认证失败: %w", err)
+	}
+
+	secret, uri, err := GenerateTOTPSecret(user.Username, DefaultTOTPConfig)
+	if err != nil {
+		return "", "", err
+	}
+
+	if err := s.totps.Create(&db.TOTP{UserID: userID, Secret: secret}); err != nil {
+		return "", "", fmt.Errorf("创建双因素认证记录失败: %w", err)
+	}
+
+	return secret, uri, nil
+}
+
+// VerifyAndEnableTOTP 验证验证码并启用双因素认证
+func (s *Service) VerifyAndEnableTOTP(userID uint, code string) error {
+	totp, err := s.totps.GetByUser(userID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return errors.New("未找到双因素认证记录")
+		}
+		return fmt.Errorf("查询双因素认证失败: %w", err)
+	}
+
+	if err := s.verifyTOTP(totp, code); err != nil {
+		return err
+	}
+
+	return s.totps.UpdateFields(totp, map[string]interface{}{
+		"enabled":      true,
+		"verified":     true,
+		"last_used_at": s.now(),
+	})
+}
+
+// verifyTOTP 验证用户的 TOTP 验证码。先在以终端历史时钟偏差为中心的标准窗口（前后各一个周期）内验证，
+// 失败时放宽到服务端时间前后 security.clockSkew.totpMaxSteps 个周期。在服务端时间的标准窗口之外接受的
+// 验证码记录审计日志和次数，匹配的偏差作为之后验证的窗口中心
+func (s *Service) verifyTOTP(totp *db.TOTP, code string) error {
+	maxSteps := s.config.Security.ClockSkew.TOTPMaxSteps
+	if maxSteps < 1 {
+		maxSteps = 1
+	}
+	center := totp.Drift
+	if center > maxSteps-1 {
+		center = maxSteps - 1
+	} else if center < 1-maxSteps {
+		center = 1 - maxSteps
+	}
+
+	now := s.now()
+	step, ok, err := MatchTOTP(totp.Secret, code, DefaultTOTPConfig, now, center, 1)
+	if err == nil && !ok && maxSteps > 1 {
+		step, ok, err = MatchTOTP(totp.Secret, code, DefaultTOTPConfig, now, 0, maxSteps)
+	}
+	if err != nil || !ok {
+		return ErrTOTPInvalid
+	}
+
+	updates := make(map[string]interface{})
+	if step != totp.Drift {
+		updates["drift"] = step
+	}
+	if step < -1 || step > 1 {
+		skew := time.Duration(step) * time.Duration(DefaultTOTPConfig.Period) * time.Second
+		logger.Warn("审计：用户 %d 的 TOTP 验证码在标准窗口之外通过验证，终端时钟偏差约 %s", totp.UserID, skew)
+		updates["drift_acceptances"] = totp.DriftAcceptances + 1
+		updates["last_drift_at"] = now
+	}
+	if len(updates) > 0 {
+		if err := s.totps.UpdateFields(totp, updates); err != nil {
+			logger.Warn("记录用户 %d 的 TOTP 时钟偏差失败: %v", totp.UserID, err)
+		}
+	}
+	return nil
+}
+
+// DisableTOTP 验证验证码并禁用双因素认证
+func (s *Service) DisableTOTP(userID uint, code string) error {
+	totp, err := s.totps.GetByUser(userID)
+	if err != nil || !totp.Enabled {
+		if err == nil || store.IsNotFound(err) {
+			return errors.New("未启用双因素认证")
+		}
+		return fmt.Errorf("查询双因素认证失败: %w", err)
+	}
+
+	if err := s.verifyTOTP(totp, code); err != nil {
+		return err
+	}
+
+	return s.totps.Delete(totp.ID)
 }
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/senma231/p3/server/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// 密码哈希算法
+const (
+	HasherBcrypt   = "bcrypt"
+	HasherArgon2id = "argon2id"
+)
+
+// PasswordHasher 密码哈希算法
+type PasswordHasher interface {
+	// Hash 计算密码哈希
+	Hash(password string) (string, error)
+	// Verify 验证密码是否与哈希匹配，哈希格式无效时返回错误
+	Verify(password, encodedHash string) (bool, error)
+	// NeedsRehash 检查哈希是否由其他算法或参数生成，需要按当前设置重新计算
+	NeedsRehash(encodedHash string) bool
+}
+
+// NewPasswordHasher 根据配置创建密码哈希算法，算法为空时使用 Argon2id
+func NewPasswordHasher(cfg config.PasswordHashConfig) (PasswordHasher, error) {
+	switch cfg.Algorithm {
+	case "", HasherArgon2id:
+		params := DefaultArgon2Params
+		if cfg.Memory > 0 {
+			params.Memory = cfg.Memory
+		}
+		if cfg.Iterations > 0 {
+			params.Iterations = cfg.Iterations
+		}
+		if cfg.Parallelism > 0 {
+			params.Parallelism = cfg.Parallelism
+		}
+		return Argon2Hasher{Params: params}, nil
+	case HasherBcrypt:
+		cost := cfg.BcryptCost
+		if cost == 0 {
+			cost = bcrypt.DefaultCost
+		}
+		if cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt 成本无效: %d", cost)
+		}
+		return BcryptHasher{Cost: cost}, nil
+	default:
+		return nil, fmt.Errorf("不支持的密码哈希算法: %s", cfg.Algorithm)
+	}
+}
+
+// BcryptHasher bcrypt 密码哈希
+type BcryptHasher struct {
+	Cost int
+}
+
+// Hash 计算密码哈希
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// Verify 验证密码是否与哈希匹配
+func (h BcryptHasher) Verify(password, encodedHash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// NeedsRehash 检查哈希是否为其他算法或成本不同
+func (h BcryptHasher) NeedsRehash(encodedHash string) bool {
+	cost, err := bcrypt.Cost([]byte(encodedHash))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2Hasher Argon2id 密码哈希，哈希格式见 password.go
+type Argon2Hasher struct {
+	Params Argon2Params
+}
+
+// Hash 计算密码哈希
+func (h Argon2Hasher) Hash(password string) (string, error) {
+	return hashArgon2(password, h.Params)
+}
+
+// Verify 验证密码是否与哈希匹配，使用哈希中记录的参数
+func (Argon2Hasher) Verify(password, encodedHash string) (bool, error) {
+	return VerifyPassword(password, encodedHash)
+}
+
+// NeedsRehash 检查哈希是否为其他算法或参数不同
+func (h Argon2Hasher) NeedsRehash(encodedHash string) bool {
+	needs, err := needsArgon2Rehash(encodedHash, h.Params)
+	return err != nil || needs
+}
+
+// verifyAnyHash 按哈希前缀选择算法验证密码，切换算法后仍可验证旧的密码哈希
+func verifyAnyHash(hasher PasswordHasher, password, encodedHash string) (bool, error) {
+	switch {
+	case strings.HasPrefix(encodedHash, "$argon2id$"):
+		return Argon2Hasher{}.Verify(password, encodedHash)
+	case strings.HasPrefix(encodedHash, "$2"):
+		return BcryptHasher{}.Verify(password, encodedHash)
+	default:
+		return hasher.Verify(password, encodedHash)
+	}
+}
@@ -0,0 +1,158 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// 注册模式
+const (
+	// RegistrationOpen 任何人都可以注册
+	RegistrationOpen = "open"
+	// RegistrationInvite 需要管理员生成的邀请码才能注册
+	RegistrationInvite = "invite"
+	// RegistrationClosed 关闭注册
+	RegistrationClosed = "closed"
+)
+
+var (
+	// ErrRegistrationClosed 已关闭注册
+	ErrRegistrationClosed = errors.New("已关闭注册")
+	// ErrInvitationRequired 仅限邀请注册，需要提供邀请码
+	ErrInvitationRequired = errors.New("需要邀请码才能注册")
+	// ErrInvitationInvalid 邀请码无效、已过期或已用完
+	ErrInvitationInvalid = errors.New("邀请码无效或已过期")
+)
+
+// invitationCodeSize 邀请码的随机字节数
+const invitationCodeSize = 18
+
+// InvitationOptions 创建邀请的参数
+type InvitationOptions struct {
+	Role    Role          // 通过邀请注册的用户的角色，为空时为普通用户
+	TTL     time.Duration // 有效期，为 0 时使用配置的默认有效期
+	MaxUses int           // 可使用次数，为 0 时只能使用一次
+	Note    string
+}
+
+// RegistrationMode 获取当前的注册模式
+func (s *Service) RegistrationMode() string {
+	if s.config.Auth.Registration == "" {
+		return RegistrationOpen
+	}
+	return s.config.Auth.Registration
+}
+
+// CreateInvitation 创建注册邀请，返回邀请记录和邀请码。邀请码只在创建时返回，服务端仅保存其哈希
+func (s *Service) CreateInvitation(createdBy uint, opts InvitationOptions) (*db.Invitation, string, error) {
+	role := opts.Role
+	if role == "" {
+		role = RoleUser
+	}
+	if role != RoleUser && role != RoleAdmin {
+		return nil, "", fmt.Errorf("邀请不支持角色: %s", role)
+	}
+	maxUses := opts.MaxUses
+	if maxUses == 0 {
+		maxUses = 1
+	}
+	if maxUses < 0 {
+		return nil, "", errors.New("邀请可使用次数无效")
+	}
+	ttl := opts.TTL
+	if ttl == 0 {
+		ttl = time.Duration(s.config.Auth.InviteTTL) * time.Hour
+	}
+	if ttl <= 0 {
+		return nil, "", errors.New("邀请有效期无效")
+	}
+
+	buf := make([]byte, invitationCodeSize)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", fmt.Errorf("生成邀请码失败: %w", err)
+	}
+	code := base64.RawURLEncoding.EncodeToString(buf)
+
+	inv := &db.Invitation{
+		CodeHash:  hashInvitationCode(code),
+		Role:      string(role),
+		MaxUses:   maxUses,
+		ExpiresAt: s.now().Add(ttl),
+		CreatedBy: createdBy,
+		Note:      opts.Note,
+	}
+	if err := s.invitations.Create(inv); err != nil {
+		return nil, "", fmt.Errorf("创建邀请失败: %w", err)
+	}
+	return inv, code, nil
+}
+
+// InvitationLink 生成邀请链接，未配置邀请链接地址时返回空字符串
+func (s *Service) InvitationLink(code string) string {
+	if s.config.Auth.InviteBaseURL == "" {
+		return ""
+	}
+	return strings.TrimRight(s.config.Auth.InviteBaseURL, "/") + "/register?invite=" + url.QueryEscape(code)
+}
+
+// ListInvitations 获取所有邀请
+func (s *Service) ListInvitations() ([]db.Invitation, error) {
+	invitations, err := s.invitations.List()
+	if err != nil {
+		return nil, fmt.Errorf("查询邀请失败: %w", err)
+	}
+	return invitations, nil
+}
+
+// DeleteInvitation 撤销邀请，已通过邀请注册的用户不受影响
+func (s *Service) DeleteInvitation(id uint) error {
+	if err := s.invitations.Delete(id); err != nil {
+		return fmt.Errorf("删除邀请失败: %w", err)
+	}
+	return nil
+}
+
+// findInvitation 查找未过期且未用完的邀请
+func (s *Service) findInvitation(code string) (*db.Invitation, error) {
+	if code == "" {
+		return nil, ErrInvitationRequired
+	}
+	inv, err := s.invitations.GetByCodeHash(hashInvitationCode(code))
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, ErrInvitationInvalid
+		}
+		return nil, fmt.Errorf("查询邀请失败: %w", err)
+	}
+	if !s.now().Before(inv.ExpiresAt) || inv.UsedCount >= inv.MaxUses {
+		return nil, ErrInvitationInvalid
+	}
+	return inv, nil
+}
+
+// redeemInvitation 占用一次邀请的使用次数，并发请求用完邀请时返回 ErrInvitationInvalid
+func (s *Service) redeemInvitation(inv *db.Invitation) error {
+	if err := s.invitations.Redeem(inv); err != nil {
+		if store.IsNotFound(err) {
+			return ErrInvitationInvalid
+		}
+		return fmt.Errorf("使用邀请失败: %w", err)
+	}
+	return nil
+}
+
+// hashInvitationCode 计算邀请码的哈希
+func hashInvitationCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
@@ -32,6 +32,7 @@ type CustomClaims struct {
 	UserID uint      `json:"user_id"`
 	Role   string    `json:"role"`
 	Type   TokenType `json:"type"`
+	Scopes []string  `json:"scopes,omitempty"`
 	jwt.RegisteredClaims
 }
 
@@ -75,6 +76,7 @@ func (s *JWTService) generateToken(userID uint, role string, tokenType TokenType
 		UserID: userID,
 		Role:   role,
 		Type:   tokenType,
+		Scopes: ScopesForRole(Role(role)),
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiry)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/senma231/p3/server/config"
+)
+
+// LDAPSource LDAP 认证源的名称
+const LDAPSource = "ldap"
+
+// ldapConn LDAP 连接，由 *ldap.Conn 实现，测试时可替换
+type ldapConn interface {
+	Bind(username, password string) error
+	UnauthenticatedBind(username string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	IsClosing() bool
+	Close()
+}
+
+// LDAPProvider LDAP / Active Directory 认证源。使用服务账户查找用户，再以用户的 DN 和密码绑定验证密码，
+// 按用户所属的组映射角色。连接在多次登录间复用
+type LDAPProvider struct {
+	cfg   config.LDAPConfig
+	roles map[string]Role // 小写的组 DN 到角色
+	pool  chan ldapConn
+	dial  func() (ldapConn, error)
+}
+
+// NewLDAPProvider 创建 LDAP 认证源，不会立即连接目录服务器
+func NewLDAPProvider(cfg config.LDAPConfig) (*LDAPProvider, error) {
+	tlsConfig, err := ldapTLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[string]Role, len(cfg.GroupRoles))
+	for group, role := range cfg.GroupRoles {
+		roles[strings.ToLower(group)] = Role(role)
+	}
+
+	timeout := time.Duration(cfg.Timeout) * time.Second
+	p := &LDAPProvider{
+		cfg:   cfg,
+		roles: roles,
+		pool:  make(chan ldapConn, cfg.PoolSize),
+	}
+	p.dial = func() (ldapConn, error) {
+		conn, err := ldap.DialURL(cfg.URL,
+			ldap.DialWithDialer(&net.Dialer{Timeout: timeout}),
+			ldap.DialWithTLSConfig(tlsConfig))
+		if err != nil {
+			return nil, err
+		}
+		conn.SetTimeout(timeout)
+		if cfg.StartTLS {
+			if err := conn.StartTLS(tlsConfig); err != nil {
+				conn.Close()
+				return nil, fmt.Errorf("StartTLS 失败: %w", err)
+			}
+		}
+		return conn, nil
+	}
+	return p, nil
+}
+
+// ldapTLSConfig 连接目录服务器使用的 TLS 配置
+func ldapTLSConfig(cfg config.LDAPConfig) (*tls.Config, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 LDAP 地址: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         u.Hostname(),
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CAFile != "" {
+		data, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取 LDAP CA 证书失败: %w", err)
+		}
+		roots := x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, errors.New("LDAP CA 证书中没有有效的证书")
+		}
+		tlsConfig.RootCAs = roots
+	}
+	return tlsConfig, nil
+}
+
+// Name 认证源名称
+func (p *LDAPProvider) Name() string {
+	return LDAPSource
+}
+
+// Authenticate 在目录中查找用户并验证密码
+func (p *LDAPProvider) Authenticate(username, password string) (*ExternalUser, error) {
+	// 空密码的简单绑定会被目录服务器当作匿名绑定并返回成功
+	if password == "" {
+		return nil, ErrWrongPassword
+	}
+
+	conn, err := p.get()
+	if err != nil {
+		return nil, fmt.Errorf("连接目录服务器失败: %w", err)
+	}
+	user, err := p.authenticate(conn, username, password)
+	if err != nil && !isCredentialError(err) && !errors.Is(err, ErrGroupNotAllowed) {
+		// 连接可能已损坏，不再复用
+		conn.Close()
+		return nil, err
+	}
+	p.put(conn)
+	return user, err
+}
+
+// authenticate 在已建立的连接上查找用户、验证密码并映射角色
+func (p *LDAPProvider) authenticate(conn ldapConn, username, password string) (*ExternalUser, error) {
+	// 连接可能保留着上一个用户的绑定，查找前重新绑定
+	if p.cfg.BindDN != "" {
+		if err := conn.Bind(p.cfg.BindDN, p.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("服务账户绑定失败: %w", err)
+		}
+	} else if err := conn.UnauthenticatedBind(""); err != nil {
+		return nil, fmt.Errorf("匿名绑定失败: %w", err)
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		p.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, p.cfg.Timeout, false,
+		fmt.Sprintf(p.cfg.UserFilter, ldap.EscapeFilter(username)),
+		[]string{p.cfg.UsernameAttribute, p.cfg.EmailAttribute, p.cfg.GroupAttribute},
+		nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("查找用户失败: %w", err)
+	}
+	if result == nil || len(result.Entries) == 0 {
+		return nil, ErrUserNotFound
+	}
+	if len(result.Entries) > 1 {
+		// 过滤器匹配多个条目时无法确定用户，按用户不存在处理
+		return nil, ErrUserNotFound
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrWrongPassword
+		}
+		return nil, fmt.Errorf("用户绑定失败: %w", err)
+	}
+
+	role, ok := p.role(entry.GetAttributeValues(p.cfg.GroupAttribute))
+	if !ok {
+		return nil, ErrGroupNotAllowed
+	}
+	name := entry.GetAttributeValue(p.cfg.UsernameAttribute)
+	if name == "" {
+		name = username
+	}
+	return &ExternalUser{
+		Username: name,
+		Email:    entry.GetAttributeValue(p.cfg.EmailAttribute),
+		Role:     role,
+	}, nil
+}
+
+// role 按所属组映射角色，属于多个组时取权限最高的角色，不属于任何映射的组时使用默认角色
+func (p *LDAPProvider) role(groups []string) (Role, bool) {
+	var role Role
+	for _, group := range groups {
+		switch p.roles[strings.ToLower(group)] {
+		case RoleAdmin:
+			return RoleAdmin, true
+		case RoleUser:
+			role = RoleUser
+		}
+	}
+	if role == "" {
+		role = Role(p.cfg.DefaultRole)
+	}
+	return role, role != ""
+}
+
+// get 取出一个空闲连接，没有时建立新连接
+func (p *LDAPProvider) get() (ldapConn, error) {
+	for {
+		select {
+		case conn := <-p.pool:
+			if conn.IsClosing() {
+				continue
+			}
+			return conn, nil
+		default:
+			return p.dial()
+		}
+	}
+}
+
+// put 归还连接，连接池已满时关闭
+func (p *LDAPProvider) put(conn ldapConn) {
+	select {
+	case p.pool <- conn:
+	default:
+		conn.Close()
+	}
+}
+
+// Close 关闭所有空闲连接
+func (p *LDAPProvider) Close() {
+	for {
+		select {
+		case conn := <-p.pool:
+			conn.Close()
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/go-ldap/ldap/v3"
+	"github.com/senma231/p3/server/config"
+)
+
+// fakeDirectory 模拟目录服务器的连接
+type fakeDirectory struct {
+	entries   map[string]*ldap.Entry // 用户名到条目
+	passwords map[string]string      // DN 到密码
+	closed    bool
+}
+
+func (d *fakeDirectory) Bind(username, password string) error {
+	if username == "cn=svc,dc=example,dc=com" && password == "svc-secret" {
+		return nil
+	}
+	if expected, ok := d.passwords[username]; ok && expected == password {
+		return nil
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+func (d *fakeDirectory) UnauthenticatedBind(string) error { return nil }
+
+func (d *fakeDirectory) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	result := &ldap.SearchResult{}
+	for name, entry := range d.entries {
+		if req.Filter == "(uid="+ldap.EscapeFilter(name)+")" {
+			result.Entries = append(result.Entries, entry)
+		}
+	}
+	return result, nil
+}
+
+func (d *fakeDirectory) IsClosing() bool { return d.closed }
+func (d *fakeDirectory) Close()          { d.closed = true }
+
+func newTestLDAP(t *testing.T) *LDAPProvider {
+	t.Helper()
+
+	cfg := config.DefaultConfig().Auth.LDAP
+	cfg.URL = "ldap://ldap.example.com"
+	cfg.BaseDN = "dc=example,dc=com"
+	cfg.BindDN = "cn=svc,dc=example,dc=com"
+	cfg.BindPassword = "svc-secret"
+	cfg.GroupRoles = map[string]string{
+		"cn=P3 Admins,ou=groups,dc=example,dc=com": "admin",
+		"cn=p3 users,ou=groups,dc=example,dc=com":  "user",
+	}
+
+	p, err := NewLDAPProvider(cfg)
+	if err != nil {
+		t.Fatalf("创建 LDAP 认证源失败: %v", err)
+	}
+	directory := &fakeDirectory{
+		entries: map[string]*ldap.Entry{
+			"carol": ldap.NewEntry("uid=carol,dc=example,dc=com", map[string][]string{
+				"uid":      {"carol"},
+				"mail":     {"carol@example.com"},
+				"memberOf": {"cn=p3 admins,ou=groups,dc=example,dc=com", "cn=p3 users,ou=groups,dc=example,dc=com"},
+			}),
+			"dave": ldap.NewEntry("uid=dave,dc=example,dc=com", map[string][]string{
+				"uid":      {"dave"},
+				"memberOf": {"cn=P3 Users,ou=groups,dc=example,dc=com"},
+			}),
+			"erin": ldap.NewEntry("uid=erin,dc=example,dc=com", map[string][]string{
+				"uid": {"erin"},
+			}),
+			"alice": ldap.NewEntry("uid=alice,dc=example,dc=com", map[string][]string{
+				"uid":      {"alice"},
+				"memberOf": {"cn=p3 admins,ou=groups,dc=example,dc=com"},
+			}),
+		},
+		passwords: map[string]string{
+			"uid=carol,dc=example,dc=com": "carol-pass",
+			"uid=dave,dc=example,dc=com":  "dave-pass",
+			"uid=erin,dc=example,dc=com":  "erin-pass",
+			"uid=alice,dc=example,dc=com": "directory-pass",
+		},
+	}
+	p.dial = func() (ldapConn, error) { return directory, nil }
+	return p
+}
+
+func TestLDAPAuthenticate(t *testing.T) {
+	p := newTestLDAP(t)
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		wantRole Role
+		wantErr  error
+	}{
+		{name: "管理员组", username: "carol", password: "carol-pass", wantRole: RoleAdmin},
+		{name: "用户组", username: "dave", password: "dave-pass", wantRole: RoleUser},
+		{name: "不属于映射的组", username: "erin", password: "erin-pass", wantErr: ErrGroupNotAllowed},
+		{name: "密码错误", username: "carol", password: "wrong", wantErr: ErrWrongPassword},
+		{name: "空密码", username: "carol", password: "", wantErr: ErrWrongPassword},
+		{name: "用户不存在", username: "frank", password: "x", wantErr: ErrUserNotFound},
+		{name: "过滤器注入", username: "*", password: "x", wantErr: ErrUserNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := p.Authenticate(tt.username, tt.password)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			if err == nil && user.Role != tt.wantRole {
+				t.Fatalf("期望角色 %s，实际 %s", tt.wantRole, user.Role)
+			}
+		})
+	}
+}
+
+func TestLoginWithLDAP(t *testing.T) {
+	s, st, _ := newTestService(t)
+	s.AddProvider(newTestLDAP(t))
+
+	// 首次登录创建账户，角色按组映射
+	user, _, err := s.Login("carol", "carol-pass", "")
+	if err != nil {
+		t.Fatalf("LDAP 用户登录失败: %v", err)
+	}
+	if user.AuthSource != LDAPSource || !user.IsAdmin || user.Email != "carol@example.com" {
+		t.Fatalf("创建的用户错误: %+v", user)
+	}
+	if err := s.ChangePassword(user.ID, "carol-pass", "N3w-Passw0rd!"); !errors.Is(err, ErrExternalAccount) {
+		t.Fatalf("期望 ErrExternalAccount，实际 %v", err)
+	}
+	if _, _, err := s.Login("carol", "wrong", ""); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("期望 ErrWrongPassword，实际 %v", err)
+	}
+
+	// 本地账户优先，不使用目录中的同名用户
+	if _, err := s.Register("alice", "secret", "alice@example.com"); err != nil {
+		t.Fatalf("注册失败: %v", err)
+	}
+	if _, _, err := s.Login("alice", "directory-pass", ""); !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("期望 ErrWrongPassword，实际 %v", err)
+	}
+	if _, _, err := s.Login("alice", "secret", ""); err != nil {
+		t.Fatalf("本地用户登录失败: %v", err)
+	}
+
+	// 外部账户不能使用本地密码字段登录
+	stored, err := st.Users.GetByUsername("carol")
+	if err != nil || stored.Password != externalPassword {
+		t.Fatalf("外部账户的密码字段错误: %v", err)
+	}
+}
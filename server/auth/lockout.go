@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"sync"
+	"time"
+)
+
+// 登录失败锁定参数
+const (
+	// maxLoginFailures 窗口内允许的最大失败次数
+	maxLoginFailures = 5
+	// loginFailureWindow 统计失败次数的时间窗口
+	loginFailureWindow = 15 * time.Minute
+	// loginLockDuration 达到失败次数后锁定的时间
+	loginLockDuration = 15 * time.Minute
+)
+
+// loginLockout 按用户名记录登录失败次数，连续失败过多时暂时锁定
+type loginLockout struct {
+	attempts map[string]*loginAttempts
+	mu       sync.Mutex
+}
+
+// loginAttempts 登录失败记录
+type loginAttempts struct {
+	failures    int
+	firstFailAt time.Time
+	lockedUntil time.Time
+}
+
+// newLoginLockout 创建登录锁定记录
+func newLoginLockout() *loginLockout {
+	return &loginLockout{
+		attempts: make(map[string]*loginAttempts),
+	}
+}
+
+// lockedUntil 获取用户名的锁定截止时间，未锁定时返回零值
+func (l *loginLockout) lockedUntil(username string, now time.Time) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[username]
+	if !ok || !now.Before(a.lockedUntil) {
+		return time.Time{}
+	}
+	return a.lockedUntil
+}
+
+// fail 记录一次失败，返回是否因此被锁定
+func (l *loginLockout) fail(username string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	a, ok := l.attempts[username]
+	if !ok || now.Sub(a.firstFailAt) > loginFailureWindow {
+		a = &loginAttempts{firstFailAt: now}
+		l.attempts[username] = a
+	}
+
+	a.failures++
+	if a.failures >= maxLoginFailures {
+		a.lockedUntil = now.Add(loginLockDuration)
+		a.failures = 0
+		a.firstFailAt = now
+		return true
+	}
+	return false
+}
+
+// succeed 登录成功后清除失败记录
+func (l *loginLockout) succeed(username string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.attempts, username)
+}
@@ -21,8 +21,27 @@ const (
 	argon2Threads uint8 = 4
 	// 密钥长度
 	argon2KeyLen uint32 = 32
+	// 盐值长度
+	argon2SaltLen = 16
 )
 
+// Argon2Params Argon2id 成本参数
+type Argon2Params struct {
+	// Memory 内存成本，单位：KiB
+	Memory uint32
+	// Iterations 迭代次数
+	Iterations uint32
+	// Parallelism 并行度
+	Parallelism uint8
+}
+
+// DefaultArgon2Params 默认的 Argon2id 参数
+var DefaultArgon2Params = Argon2Params{
+	Memory:      argon2Memory,
+	Iterations:  argon2Time,
+	Parallelism: argon2Threads,
+}
+
 var (
 	// ErrInvalidHash 表示提供的哈希格式无效
 	ErrInvalidHash = errors.New("提供的密码哈希格式无效")
@@ -30,16 +49,21 @@ var (
 	ErrIncompatibleVersion = errors.New("不兼容的 Argon2 版本")
 )
 
-// HashPassword 使用 Argon2id 算法对密码进行哈希处理
+// HashPassword 使用默认参数的 Argon2id 算法对密码进行哈希处理
 func HashPassword(password string) (string, error) {
+	return hashArgon2(password, DefaultArgon2Params)
+}
+
+// hashArgon2 使用指定参数的 Argon2id 算法对密码进行哈希处理
+func hashArgon2(password string, p Argon2Params) (string, error) {
 	// 生成随机盐值
-	salt := make([]byte, 16)
+	salt := make([]byte, argon2SaltLen)
 	if _, err := rand.Read(salt); err != nil {
 		return "", err
 	}
 
 	// 使用 Argon2id 算法计算哈希
-	hash := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	hash := argon2.IDKey([]byte(password), salt, p.Iterations, p.Memory, p.Parallelism, argon2KeyLen)
 
 	// 编码为 Base64
 	b64Salt := base64.RawStdEncoding.EncodeToString(salt)
@@ -48,7 +72,7 @@ func HashPassword(password string) (string, error) {
 	// 格式化哈希字符串
 	// 格式: $argon2id$v=19$m=65536,t=1,p=4$<salt>$<hash>
 	encodedHash := fmt.Sprintf("$argon2id$v=19$m=%d,t=%d,p=%d$%s$%s",
-		argon2Memory, argon2Time, argon2Threads, b64Salt, b64Hash)
+		p.Memory, p.Iterations, p.Parallelism, b64Salt, b64Hash)
 
 	return encodedHash, nil
 }
@@ -128,14 +152,19 @@ func decodeHash(encodedHash string) (params *argon2Params, salt, hash []byte, er
 // NeedsRehash 检查密码哈希是否需要重新计算
 // 当哈希参数变更时，可以使用此函数来确定是否需要更新哈希
 func NeedsRehash(encodedHash string) (bool, error) {
+	return needsArgon2Rehash(encodedHash, DefaultArgon2Params)
+}
+
+// needsArgon2Rehash 检查 Argon2id 哈希的参数是否与指定参数一致
+func needsArgon2Rehash(encodedHash string, p Argon2Params) (bool, error) {
 	params, _, _, err := decodeHash(encodedHash)
 	if err != nil {
 		return false, err
 	}
 
 	// 检查参数是否匹配当前设置
-	return params.memory != argon2Memory ||
-		params.time != argon2Time ||
-		params.threads != argon2Threads ||
+	return params.memory != p.Memory ||
+		params.time != p.Iterations ||
+		params.threads != p.Parallelism ||
 		params.keyLen != argon2KeyLen, nil
 }
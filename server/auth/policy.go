@@ -0,0 +1,171 @@
+package auth
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/senma231/p3/server/config"
+)
+
+// 密码强度规则
+const (
+	PolicyRuleMinLength = "minLength"
+	PolicyRuleCharClass = "charClasses"
+	PolicyRuleCommon    = "common"
+	PolicyRuleUsername  = "username"
+)
+
+// minUsernameMatchSize 检查密码是否包含用户名时，用户名的最小长度
+const minUsernameMatchSize = 3
+
+//go:embed common_passwords.txt
+var commonPasswordList string
+
+// commonPasswords 常见弱密码集合，键为小写
+var commonPasswords = parseCommonPasswords(commonPasswordList)
+
+// parseCommonPasswords 解析弱密码列表，忽略空行和 # 开头的注释
+func parseCommonPasswords(list string) map[string]struct{} {
+	passwords := make(map[string]struct{})
+	for _, line := range strings.Split(list, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = struct{}{}
+	}
+	return passwords
+}
+
+// PolicyViolation 未通过的密码强度规则
+type PolicyViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// PasswordPolicyError 密码不符合强度策略，Violations 列出所有未通过的规则
+type PasswordPolicyError struct {
+	Violations []PolicyViolation
+}
+
+// Error 返回错误信息
+func (e *PasswordPolicyError) Error() string {
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return "密码强度不足: " + strings.Join(messages, "；")
+}
+
+// PasswordPolicy 密码强度策略
+type PasswordPolicy struct {
+	config config.PasswordPolicyConfig
+}
+
+// NewPasswordPolicy 创建密码强度策略
+func NewPasswordPolicy(cfg config.PasswordPolicyConfig) *PasswordPolicy {
+	return &PasswordPolicy{config: cfg}
+}
+
+// Check 检查密码是否符合策略，不符合时返回 *PasswordPolicyError
+func (p *PasswordPolicy) Check(username, password string) error {
+	var violations []PolicyViolation
+
+	if p.config.MinLength > 0 && utf8.RuneCountInString(password) < p.config.MinLength {
+		violations = append(violations, PolicyViolation{
+			Rule:    PolicyRuleMinLength,
+			Message: fmt.Sprintf("密码长度不能少于 %d 个字符", p.config.MinLength),
+		})
+	}
+
+	if p.config.MinCharClasses > 0 && charClasses(password) < p.config.MinCharClasses {
+		violations = append(violations, PolicyViolation{
+			Rule:    PolicyRuleCharClass,
+			Message: fmt.Sprintf("密码至少需要包含大写字母、小写字母、数字、符号中的 %d 类", p.config.MinCharClasses),
+		})
+	}
+
+	if p.config.RejectCommon && isCommonPassword(password) {
+		violations = append(violations, PolicyViolation{
+			Rule:    PolicyRuleCommon,
+			Message: "密码过于常见",
+		})
+	}
+
+	if p.config.RejectUsername && similarToUsername(username, password) {
+		violations = append(violations, PolicyViolation{
+			Rule:    PolicyRuleUsername,
+			Message: "密码不能包含用户名",
+		})
+	}
+
+	if len(violations) > 0 {
+		return &PasswordPolicyError{Violations: violations}
+	}
+	return nil
+}
+
+// charClasses 统计密码包含的字符类别数
+func charClasses(password string) int {
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	count := 0
+	for _, ok := range []bool{upper, lower, digit, symbol} {
+		if ok {
+			count++
+		}
+	}
+	return count
+}
+
+// isCommonPassword 检查密码是否在常见弱密码列表中，
+// 同时检查去掉末尾数字和符号后的形式，例如 Password123!
+func isCommonPassword(password string) bool {
+	lower := strings.ToLower(password)
+	if _, ok := commonPasswords[lower]; ok {
+		return true
+	}
+	trimmed := strings.TrimRightFunc(lower, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	if trimmed == "" || trimmed == lower {
+		return false
+	}
+	_, ok := commonPasswords[trimmed]
+	return ok
+}
+
+// similarToUsername 检查密码是否包含用户名或其倒序，过短的用户名不检查
+func similarToUsername(username, password string) bool {
+	name := strings.ToLower(username)
+	if utf8.RuneCountInString(name) < minUsernameMatchSize {
+		return false
+	}
+
+	lower := strings.ToLower(password)
+	return strings.Contains(lower, name) || strings.Contains(lower, reverse(name))
+}
+
+// reverse 倒序字符串
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
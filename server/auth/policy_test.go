@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/senma231/p3/server/config"
+)
+
+func TestPasswordPolicyCheck(t *testing.T) {
+	policy := NewPasswordPolicy(config.PasswordPolicyConfig{
+		MinLength:      8,
+		MinCharClasses: 2,
+		RejectCommon:   true,
+		RejectUsername: true,
+	})
+
+	tests := []struct {
+		name      string
+		username  string
+		password  string
+		wantRules []string
+	}{
+		{name: "强密码", username: "alice", password: "Tr0ub4dor&3"},
+		{name: "过短", username: "alice", password: "aB3$", wantRules: []string{PolicyRuleMinLength}},
+		{name: "字符类别不足", username: "alice", password: "correcthorsebattery", wantRules: []string{PolicyRuleCharClass}},
+		{name: "常见密码", username: "alice", password: "123456", wantRules: []string{PolicyRuleMinLength, PolicyRuleCharClass, PolicyRuleCommon}},
+		{name: "常见密码加后缀", username: "alice", password: "Password123!", wantRules: []string{PolicyRuleCommon}},
+		{name: "包含用户名", username: "alice", password: "Alice2024!", wantRules: []string{PolicyRuleUsername}},
+		{name: "包含倒序用户名", username: "alice", password: "ecila#2024", wantRules: []string{PolicyRuleUsername}},
+		{name: "用户名过短不检查", username: "al", password: "xAl2024!x"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(tt.username, tt.password)
+			if len(tt.wantRules) == 0 {
+				if err != nil {
+					t.Fatalf("期望通过，实际 %v", err)
+				}
+				return
+			}
+
+			var policyErr *PasswordPolicyError
+			if !errors.As(err, &policyErr) {
+				t.Fatalf("期望 PasswordPolicyError，实际 %v", err)
+			}
+			var rules []string
+			for _, v := range policyErr.Violations {
+				rules = append(rules, v.Rule)
+			}
+			if !reflect.DeepEqual(rules, tt.wantRules) {
+				t.Errorf("未通过的规则不正确，期望 %v，实际 %v", tt.wantRules, rules)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyDisabled(t *testing.T) {
+	if err := NewPasswordPolicy(config.PasswordPolicyConfig{}).Check("alice", "alice"); err != nil {
+		t.Errorf("未配置策略时不应检查密码，实际 %v", err)
+	}
+}
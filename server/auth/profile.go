@@ -0,0 +1,143 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+	// 内置时区数据，容器镜像中没有系统时区数据时也能校验时区
+	_ "time/tzdata"
+
+	"github.com/senma231/p3/server/db"
+)
+
+// 界面主题
+const (
+	ThemeLight  = "light"
+	ThemeDark   = "dark"
+	ThemeSystem = "system"
+)
+
+// 通知类型
+const (
+	// NotificationAlerts 告警规则触发
+	NotificationAlerts = "alerts"
+	// NotificationDeviceOffline 设备离线
+	NotificationDeviceOffline = "deviceOffline"
+	// NotificationSecurity 登录令牌在陌生环境中使用等账户安全事件
+	NotificationSecurity = "security"
+)
+
+// 列表每页条数
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 200
+)
+
+// notificationTypes 支持的通知类型
+var notificationTypes = []string{NotificationAlerts, NotificationDeviceOffline, NotificationSecurity}
+
+// ProfileUpdate 个人资料和偏好的修改，字段为 nil 时保持不变
+type ProfileUpdate struct {
+	DisplayName   *string
+	Timezone      *string
+	Theme         *string
+	PageSize      *int
+	Notifications map[string]bool // 只修改其中列出的通知类型
+}
+
+// EffectivePreferences 填充未设置的偏好的默认值
+func EffectivePreferences(p db.UserPreferences) db.UserPreferences {
+	if p.Theme == "" {
+		p.Theme = ThemeSystem
+	}
+	if p.PageSize == 0 {
+		p.PageSize = DefaultPageSize
+	}
+	notifications := make(map[string]bool, len(notificationTypes))
+	for _, kind := range notificationTypes {
+		enabled, ok := p.Notifications[kind]
+		notifications[kind] = !ok || enabled
+	}
+	p.Notifications = notifications
+	return p
+}
+
+// NotificationEnabled 检查用户是否开启了指定类型的通知
+func NotificationEnabled(user *db.User, kind string) bool {
+	return EffectivePreferences(user.Preferences).Notifications[kind]
+}
+
+// UpdateProfile 修改当前用户的个人资料和偏好
+func (s *Service) UpdateProfile(userID uint, update ProfileUpdate) (*db.User, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := make(map[string]interface{})
+	if update.DisplayName != nil {
+		updates["display_name"] = *update.DisplayName
+	}
+	if update.Timezone != nil {
+		// 空字符串表示使用浏览器或设备的时区
+		if *update.Timezone != "" {
+			if _, err := time.LoadLocation(*update.Timezone); err != nil {
+				return nil, fmt.Errorf("无效的时区: %s", *update.Timezone)
+			}
+		}
+		updates["timezone"] = *update.Timezone
+	}
+
+	prefs := user.Preferences
+	prefsChanged := false
+	if update.Theme != nil {
+		switch *update.Theme {
+		case ThemeLight, ThemeDark, ThemeSystem:
+		default:
+			return nil, fmt.Errorf("不支持的主题: %s", *update.Theme)
+		}
+		prefs.Theme = *update.Theme
+		prefsChanged = true
+	}
+	if update.PageSize != nil {
+		if *update.PageSize < 1 || *update.PageSize > MaxPageSize {
+			return nil, fmt.Errorf("每页条数必须在 1 到 %d 之间", MaxPageSize)
+		}
+		prefs.PageSize = *update.PageSize
+		prefsChanged = true
+	}
+	if len(update.Notifications) > 0 {
+		merged := make(map[string]bool, len(prefs.Notifications)+len(update.Notifications))
+		for kind, enabled := range prefs.Notifications {
+			merged[kind] = enabled
+		}
+		for kind, enabled := range update.Notifications {
+			if !isNotificationType(kind) {
+				return nil, fmt.Errorf("不支持的通知类型: %s", kind)
+			}
+			merged[kind] = enabled
+		}
+		prefs.Notifications = merged
+		prefsChanged = true
+	}
+	if prefsChanged {
+		updates["preferences"] = prefs
+	}
+
+	if len(updates) == 0 {
+		return user, nil
+	}
+	if err := s.users.UpdateFields(user, updates); err != nil {
+		return nil, fmt.Errorf("更新用户失败: %w", err)
+	}
+	return user, nil
+}
+
+// isNotificationType 检查是否为支持的通知类型
+func isNotificationType(kind string) bool {
+	for _, t := range notificationTypes {
+		if t == kind {
+			return true
+		}
+	}
+	return false
+}
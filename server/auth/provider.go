@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+var (
+	// ErrProviderUnavailable 外部认证源暂时不可用
+	ErrProviderUnavailable = errors.New("认证服务暂时不可用，请稍后再试")
+	// ErrGroupNotAllowed 外部认证源中的用户不属于允许登录的组
+	ErrGroupNotAllowed = errors.New("用户不属于允许登录的组")
+	// ErrExternalAccount 账户由外部认证源管理，不能在 P3 中修改密码
+	ErrExternalAccount = errors.New("账户由外部认证源管理，请在目录服务中修改密码")
+)
+
+// externalPassword 外部账户的密码字段，不是任何算法的有效哈希，无法通过本地密码验证
+const externalPassword = "!external"
+
+// ExternalUser 外部认证源验证通过的用户
+type ExternalUser struct {
+	Username string // 外部认证源中的规范用户名，作为 P3 用户名
+	Email    string
+	Role     Role // 按所属组映射的角色
+}
+
+// Provider 外部认证源，例如 LDAP / Active Directory。本地账户的密码由 Service 直接验证
+type Provider interface {
+	// Name 认证源名称，记录在用户的 AuthSource 中
+	Name() string
+	// Authenticate 验证用户名和密码。用户不存在时返回 ErrUserNotFound，密码错误时返回 ErrWrongPassword，
+	// 不属于允许登录的组时返回 ErrGroupNotAllowed，其他错误表示认证源不可用
+	Authenticate(username, password string) (*ExternalUser, error)
+}
+
+// AddProvider 添加外部认证源，没有本地账户的用户依次尝试各认证源
+func (s *Service) AddProvider(p Provider) {
+	s.providers = append(s.providers, p)
+}
+
+// authenticateExternal 使用外部认证源验证用户，source 不为空时只使用该认证源。
+// 验证通过后创建或更新对应的本地用户
+func (s *Service) authenticateExternal(username, password, source string) (*db.User, error) {
+	for _, p := range s.providers {
+		if source != "" && p.Name() != source {
+			continue
+		}
+
+		external, err := p.Authenticate(username, password)
+		switch {
+		case errors.Is(err, ErrUserNotFound):
+			continue
+		case errors.Is(err, ErrWrongPassword), errors.Is(err, ErrGroupNotAllowed):
+			return nil, err
+		case err != nil:
+			logger.Error("认证源 %s 验证用户 %s 失败: %v", p.Name(), username, err)
+			return nil, ErrProviderUnavailable
+		}
+		return s.syncExternalUser(p.Name(), external)
+	}
+	return nil, ErrUserNotFound
+}
+
+// syncExternalUser 首次登录时创建外部认证源的用户，之后每次登录同步邮箱和角色
+func (s *Service) syncExternalUser(source string, external *ExternalUser) (*db.User, error) {
+	isAdmin := external.Role == RoleAdmin
+
+	user, err := s.users.GetByUsername(external.Username)
+	if err != nil && !store.IsNotFound(err) {
+		return nil, fmt.Errorf("查询用户失败: %w", err)
+	}
+	if err == nil {
+		if user.AuthSource != source {
+			// 同名的本地账户或其他认证源的账户，不能被外部认证源接管
+			logger.Warn("认证源 %s 的用户 %s 与已有账户重名", source, external.Username)
+			return nil, ErrWrongPassword
+		}
+		updates := map[string]interface{}{}
+		if user.IsAdmin != isAdmin {
+			updates["is_admin"] = isAdmin
+		}
+		if external.Email != "" && user.Email != external.Email && s.emailAvailable(external.Email) {
+			updates["email"] = external.Email
+		}
+		if len(updates) > 0 {
+			if err := s.users.UpdateFields(user, updates); err != nil {
+				return nil, fmt.Errorf("更新用户失败: %w", err)
+			}
+		}
+		return user, nil
+	}
+
+	user = &db.User{
+		Username:      external.Username,
+		Password:      externalPassword,
+		EmailVerified: true,
+		IsAdmin:       isAdmin,
+		AuthSource:    source,
+	}
+	if external.Email != "" && s.emailAvailable(external.Email) {
+		user.Email = external.Email
+	}
+	if err := s.users.Create(user); err != nil {
+		return nil, fmt.Errorf("创建用户失败: %w", err)
+	}
+	logger.Info("已创建认证源 %s 的用户 %s", source, user.Username)
+	return user, nil
+}
+
+// emailAvailable 检查邮箱是否未被其他账户使用，被占用时外部账户不保存邮箱
+func (s *Service) emailAvailable(email string) bool {
+	_, err := s.users.GetByEmail(email)
+	return store.IsNotFound(err)
+}
@@ -0,0 +1,104 @@
+package auth
+
+import "github.com/senma231/p3/server/db"
+
+// Scope 令牌授权范围
+type Scope string
+
+const (
+	// ScopeDevicesRead 读取设备
+	ScopeDevicesRead Scope = "devices:read"
+	// ScopeDevicesWrite 管理设备
+	ScopeDevicesWrite Scope = "devices:write"
+	// ScopeAppsRead 读取应用
+	ScopeAppsRead Scope = "apps:read"
+	// ScopeAppsWrite 管理应用
+	ScopeAppsWrite Scope = "apps:write"
+	// ScopeForwardsRead 读取转发规则
+	ScopeForwardsRead Scope = "forwards:read"
+	// ScopeForwardsWrite 管理转发规则
+	ScopeForwardsWrite Scope = "forwards:write"
+	// ScopeRoutesRead 读取子网路由
+	ScopeRoutesRead Scope = "routes:read"
+	// ScopeRoutesWrite 管理子网路由
+	ScopeRoutesWrite Scope = "routes:write"
+	// ScopeMonitorRead 读取监控数据、测速结果和告警
+	ScopeMonitorRead Scope = "monitor:read"
+	// ScopeMonitorWrite 管理测速计划和告警规则
+	ScopeMonitorWrite Scope = "monitor:write"
+	// ScopeExportRead 导出数据
+	ScopeExportRead Scope = "export:read"
+	// ScopeUsersAdmin 管理用户角色
+	ScopeUsersAdmin Scope = "users:admin"
+	// ScopeRelayAdmin 管理中继服务
+	ScopeRelayAdmin Scope = "relay:admin"
+	// ScopeDevicesObserve 只读查看单台设备的实时状态，只授予设备观察链接，不属于任何角色
+	ScopeDevicesObserve Scope = "devices:observe"
+)
+
+// RoleScopes 角色授权范围映射
+var RoleScopes = map[Role][]Scope{
+	RoleAdmin: {
+		ScopeDevicesRead, ScopeDevicesWrite,
+		ScopeAppsRead, ScopeAppsWrite,
+		ScopeForwardsRead, ScopeForwardsWrite,
+		ScopeRoutesRead, ScopeRoutesWrite,
+		ScopeMonitorRead, ScopeMonitorWrite,
+		ScopeExportRead,
+		ScopeUsersAdmin, ScopeRelayAdmin,
+	},
+	RoleUser: {
+		ScopeDevicesRead, ScopeDevicesWrite,
+		ScopeAppsRead, ScopeAppsWrite,
+		ScopeForwardsRead, ScopeForwardsWrite,
+		ScopeRoutesRead, ScopeRoutesWrite,
+		ScopeMonitorRead, ScopeMonitorWrite,
+		ScopeExportRead,
+	},
+	RoleGuest: {
+		ScopeDevicesRead,
+		ScopeAppsRead,
+		ScopeForwardsRead,
+		ScopeRoutesRead,
+		ScopeMonitorRead,
+	},
+}
+
+// UserRole 获取用户角色
+func UserRole(user *db.User) Role {
+	if user.IsAdmin {
+		return RoleAdmin
+	}
+	return RoleUser
+}
+
+// ScopesForRole 获取角色的授权范围
+func ScopesForRole(role Role) []string {
+	scopes := RoleScopes[role]
+	result := make([]string, len(scopes))
+	for i, scope := range scopes {
+		result[i] = string(scope)
+	}
+	return result
+}
+
+// UserScopes 获取用户的授权范围
+func UserScopes(user *db.User) []string {
+	return ScopesForRole(UserRole(user))
+}
+
+// MissingScopes 获取未被授予的授权范围
+func MissingScopes(granted []string, required ...Scope) []string {
+	grantedSet := make(map[string]bool, len(granted))
+	for _, scope := range granted {
+		grantedSet[scope] = true
+	}
+
+	var missing []string
+	for _, scope := range required {
+		if !grantedSet[string(scope)] {
+			missing = append(missing, string(scope))
+		}
+	}
+	return missing
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/senma231/p3/server/db"
+)
+
+func TestMissingScopes(t *testing.T) {
+	granted := ScopesForRole(RoleGuest)
+
+	if missing := MissingScopes(granted, ScopeDevicesRead); len(missing) != 0 {
+		t.Errorf("访客应具有读取设备的授权范围，缺少: %v", missing)
+	}
+
+	missing := MissingScopes(granted, ScopeDevicesRead, ScopeDevicesWrite, ScopeRelayAdmin)
+	if len(missing) != 2 || missing[0] != string(ScopeDevicesWrite) || missing[1] != string(ScopeRelayAdmin) {
+		t.Errorf("缺少的授权范围不正确: %v", missing)
+	}
+}
+
+func TestUserScopes(t *testing.T) {
+	if missing := MissingScopes(UserScopes(&db.User{IsAdmin: true}), ScopeUsersAdmin, ScopeRelayAdmin); len(missing) != 0 {
+		t.Errorf("管理员应具有所有授权范围，缺少: %v", missing)
+	}
+	if missing := MissingScopes(UserScopes(&db.User{}), ScopeUsersAdmin); len(missing) != 1 {
+		t.Errorf("普通用户不应具有管理用户的授权范围")
+	}
+}
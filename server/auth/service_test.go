@@ -0,0 +1,549 @@
+package auth
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/notify"
+	"github.com/senma231/p3/server/store"
+)
+
+// newTestService 创建使用内存存储和固定时钟的认证服务
+func newTestService(t *testing.T) (*Service, *store.Store, *time.Time) {
+	t.Helper()
+
+	cfg := &config.Config{}
+	cfg.JWT.Secret = "test-secret"
+	cfg.JWT.ExpireTime = 24
+
+	st := store.NewMemoryStore()
+	s := NewService(cfg, st, BcryptHasher{Cost: 4})
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	return s, st, &now
+}
+
+// enableTestTOTP 为用户启用双因素认证，返回密钥
+func enableTestTOTP(t *testing.T, s *Service, userID uint) string {
+	t.Helper()
+
+	secret, _, err := s.EnableTOTP(userID)
+	if err != nil {
+		t.Fatalf("生成双因素认证密钥失败: %v", err)
+	}
+	code, err := GenerateTOTP(secret, DefaultTOTPConfig)
+	if err != nil {
+		t.Fatalf("生成验证码失败: %v", err)
+	}
+	if err := s.VerifyAndEnableTOTP(userID, code); err != nil {
+		t.Fatalf("启用双因素认证失败: %v", err)
+	}
+	return secret
+}
+
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		withTOTP bool
+		totpCode func(secret string) string
+		wantErr  error
+	}{
+		{name: "成功", username: "alice", password: "secret"},
+		{name: "用户不存在", username: "bob", password: "secret", wantErr: ErrUserNotFound},
+		{name: "密码错误", username: "alice", password: "wrong", wantErr: ErrWrongPassword},
+		{name: "缺少验证码", username: "alice", password: "secret", withTOTP: true, wantErr: ErrTOTPRequired},
+		{
+			name: "验证码错误", username: "alice", password: "secret", withTOTP: true,
+			totpCode: func(string) string { return "000000" },
+			wantErr:  ErrTOTPInvalid,
+		},
+		{
+			name: "验证码正确", username: "alice", password: "secret", withTOTP: true,
+			totpCode: func(secret string) string {
+				code, _ := GenerateTOTP(secret, DefaultTOTPConfig)
+				return code
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newTestService(t)
+			user, err := s.Register("alice", "secret", "alice@example.com")
+			if err != nil {
+				t.Fatalf("注册失败: %v", err)
+			}
+
+			var secret string
+			if tt.withTOTP {
+				secret = enableTestTOTP(t, s, user.ID)
+			}
+			var code string
+			if tt.totpCode != nil {
+				code = tt.totpCode(secret)
+			}
+
+			_, token, err := s.Login(tt.username, tt.password, code)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("登录失败: %v", err)
+			}
+
+			claims, err := s.ParseToken(token)
+			if err != nil {
+				t.Fatalf("解析 Token 失败: %v", err)
+			}
+			if claims.UserID != user.ID || claims.Username != "alice" {
+				t.Errorf("Token 声明不正确: %+v", claims)
+			}
+		})
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	s, _, now := newTestService(t)
+	if _, err := s.Register("alice", "secret", ""); err != nil {
+		t.Fatalf("注册失败: %v", err)
+	}
+
+	for i := 0; i < maxLoginFailures; i++ {
+		if _, _, err := s.Login("alice", "wrong", ""); !errors.Is(err, ErrWrongPassword) {
+			t.Fatalf("第 %d 次登录期望密码错误，实际 %v", i+1, err)
+		}
+	}
+
+	// 锁定期间正确的密码也无法登录
+	if _, _, err := s.Login("alice", "secret", ""); !errors.Is(err, ErrAccountLocked) {
+		t.Fatalf("期望账户锁定，实际 %v", err)
+	}
+
+	// 锁定结束后恢复
+	*now = now.Add(loginLockDuration)
+	if _, _, err := s.Login("alice", "secret", ""); err != nil {
+		t.Fatalf("锁定结束后登录失败: %v", err)
+	}
+}
+
+func TestTOTPClockDrift(t *testing.T) {
+	s, st, now := newTestService(t)
+	s.config.Security.ClockSkew.TOTPMaxSteps = 10
+	user, err := s.Register("alice", "secret", "")
+	if err != nil {
+		t.Fatalf("注册失败: %v", err)
+	}
+	secret := enableTestTOTP(t, s, user.ID)
+	period := time.Duration(DefaultTOTPConfig.Period) * time.Second
+	codeAt := func(steps int) string {
+		code, err := GenerateTOTPAt(secret, DefaultTOTPConfig, now.Add(time.Duration(steps)*period))
+		if err != nil {
+			t.Fatalf("生成验证码失败: %v", err)
+		}
+		return code
+	}
+
+	// 终端时钟快 4 个周期，在放宽的窗口内接受并记录偏差
+	if _, _, err := s.Login("alice", "secret", codeAt(4)); err != nil {
+		t.Fatalf("放宽窗口内的验证码应通过: %v", err)
+	}
+	totp, err := st.TOTPs.GetByUser(user.ID)
+	if err != nil {
+		t.Fatalf("查询双因素认证失败: %v", err)
+	}
+	if totp.Drift != 4 || totp.DriftAcceptances != 1 || !totp.LastDriftAt.Equal(*now) {
+		t.Fatalf("应记录时钟偏差和审计次数: %+v", totp)
+	}
+
+	// 之后以偏差为中心验证，标准窗口之外的验证码仍然计数
+	*now = now.Add(period)
+	if _, _, err := s.Login("alice", "secret", codeAt(5)); err != nil {
+		t.Fatalf("以偏差为中心的验证码应通过: %v", err)
+	}
+	if totp, _ = st.TOTPs.GetByUser(user.ID); totp.Drift != 5 || totp.DriftAcceptances != 2 {
+		t.Fatalf("偏差应更新为 5: %+v", totp)
+	}
+
+	// 超出最大周期数的验证码无效
+	if _, _, err := s.Login("alice", "secret", codeAt(11)); !errors.Is(err, ErrTOTPInvalid) {
+		t.Fatalf("超出最大周期数的验证码期望无效，实际 %v", err)
+	}
+
+	// 时钟恢复后偏差回到标准窗口，不再计数
+	if _, _, err := s.Login("alice", "secret", codeAt(0)); err != nil {
+		t.Fatalf("标准窗口内的验证码应通过: %v", err)
+	}
+	if totp, _ = st.TOTPs.GetByUser(user.ID); totp.Drift != 0 || totp.DriftAcceptances != 2 {
+		t.Fatalf("偏差应恢复为 0: %+v", totp)
+	}
+
+	// 不放宽窗口时只接受标准窗口内的验证码
+	s.config.Security.ClockSkew.TOTPMaxSteps = 1
+	if _, _, err := s.Login("alice", "secret", codeAt(3)); !errors.Is(err, ErrTOTPInvalid) {
+		t.Fatalf("不放宽窗口时期望无效，实际 %v", err)
+	}
+}
+
+func TestTokenLeeway(t *testing.T) {
+	s, _, now := newTestService(t)
+	if _, err := s.Register("alice", "secret", ""); err != nil {
+		t.Fatalf("注册失败: %v", err)
+	}
+	_, token, err := s.Login("alice", "secret", "")
+	if err != nil {
+		t.Fatalf("登录失败: %v", err)
+	}
+
+	// 其他节点的时钟慢 30 秒时签发时间在未来
+	*now = now.Add(-30 * time.Second)
+	if _, err := s.ParseToken(token); err == nil {
+		t.Fatal("不容忍偏差时签发时间在未来的 Token 应无效")
+	}
+	s.config.Security.ClockSkew.TokenLeeway = 60
+	if _, err := s.ParseToken(token); err != nil {
+		t.Fatalf("容忍范围内的 Token 应有效: %v", err)
+	}
+
+	// 过期后在容忍范围内仍然有效
+	*now = now.Add(24*time.Hour + 60*time.Second)
+	if _, err := s.ParseToken(token); err != nil {
+		t.Fatalf("刚过期的 Token 在容忍范围内应有效: %v", err)
+	}
+	*now = now.Add(time.Minute)
+	if _, err := s.ParseToken(token); err == nil {
+		t.Fatal("超出容忍范围的过期 Token 应无效")
+	}
+}
+
+func TestLoginMissingTOTPNotCounted(t *testing.T) {
+	s, _, _ := newTestService(t)
+	user, err := s.Register("alice", "secret", "")
+	if err != nil {
+		t.Fatalf("注册失败: %v", err)
+	}
+	enableTestTOTP(t, s, user.ID)
+
+	for i := 0; i < maxLoginFailures+1; i++ {
+		if _, _, err := s.Login("alice", "secret", ""); !errors.Is(err, ErrTOTPRequired) {
+			t.Fatalf("第 %d 次登录期望需要验证码，实际 %v", i+1, err)
+		}
+	}
+}
+
+func TestLoginRehash(t *testing.T) {
+	cheapArgon2 := Argon2Hasher{Params: Argon2Params{Memory: 1024, Iterations: 1, Parallelism: 1}}
+
+	tests := []struct {
+		name       string
+		stored     PasswordHasher
+		current    PasswordHasher
+		wantRehash bool
+	}{
+		{name: "bcrypt 迁移到 Argon2id", stored: BcryptHasher{Cost: 4}, current: cheapArgon2, wantRehash: true},
+		{name: "Argon2id 参数调整", stored: Argon2Hasher{Params: Argon2Params{Memory: 2048, Iterations: 1, Parallelism: 1}}, current: cheapArgon2, wantRehash: true},
+		{name: "bcrypt 成本调整", stored: BcryptHasher{Cost: 4}, current: BcryptHasher{Cost: 5}, wantRehash: true},
+		{name: "参数未变", stored: cheapArgon2, current: cheapArgon2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, st, _ := newTestService(t)
+			s.hasher = tt.current
+
+			hashed, err := tt.stored.Hash("secret")
+			if err != nil {
+				t.Fatalf("计算密码哈希失败: %v", err)
+			}
+			if err := st.Users.Create(&db.User{Username: "alice", Password: hashed}); err != nil {
+				t.Fatalf("创建用户失败: %v", err)
+			}
+
+			user, _, err := s.Login("alice", "secret", "")
+			if err != nil {
+				t.Fatalf("登录失败: %v", err)
+			}
+			if rehashed := user.Password != hashed; rehashed != tt.wantRehash {
+				t.Fatalf("期望重新哈希 %v，实际 %v", tt.wantRehash, rehashed)
+			}
+			if tt.current.NeedsRehash(user.Password) {
+				t.Error("登录后的密码哈希应与当前设置一致")
+			}
+
+			// 重新哈希后仍可使用原密码登录
+			if _, _, err := s.Login("alice", "secret", ""); err != nil {
+				t.Fatalf("重新哈希后登录失败: %v", err)
+			}
+		})
+	}
+}
+
+func TestRefreshToken(t *testing.T) {
+	s, st, _ := newTestService(t)
+	user, err := s.Register("alice", "secret", "")
+	if err != nil {
+		t.Fatalf("注册失败: %v", err)
+	}
+	_, token, err := s.Login("alice", "secret", "")
+	if err != nil {
+		t.Fatalf("登录失败: %v", err)
+	}
+
+	// 刷新后授权范围按当前角色重新计算
+	if err := st.Users.UpdateFields(user, map[string]interface{}{"is_admin": true}); err != nil {
+		t.Fatalf("更新用户失败: %v", err)
+	}
+	refreshed, err := s.RefreshToken(token)
+	if err != nil {
+		t.Fatalf("刷新 Token 失败: %v", err)
+	}
+	claims, err := s.ParseToken(refreshed)
+	if err != nil {
+		t.Fatalf("解析 Token 失败: %v", err)
+	}
+	if missing := MissingScopes(claims.Scopes, ScopeUsersAdmin); len(missing) != 0 {
+		t.Errorf("刷新后的 Token 缺少授权范围: %v", missing)
+	}
+
+	if _, err := s.RefreshToken("invalid"); err == nil {
+		t.Error("无效的 Token 不应刷新成功")
+	}
+
+	// 用户删除后无法刷新
+	if err := st.Users.Delete(user.ID); err != nil {
+		t.Fatalf("删除用户失败: %v", err)
+	}
+	if _, err := s.RefreshToken(token); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("期望用户不存在，实际 %v", err)
+	}
+}
+
+func TestPasswordPolicyApplied(t *testing.T) {
+	s, _, _ := newTestService(t)
+	s.policy = NewPasswordPolicy(config.DefaultConfig().Security.PasswordPolicy)
+
+	var policyErr *PasswordPolicyError
+	if _, err := s.Register("alice", "letmein", ""); !errors.As(err, &policyErr) {
+		t.Fatalf("注册时期望密码强度错误，实际 %v", err)
+	}
+
+	user, err := s.Register("alice", "Tr0ub4dor&3", "")
+	if err != nil {
+		t.Fatalf("注册失败: %v", err)
+	}
+	if err := s.ChangePassword(user.ID, "Tr0ub4dor&3", "password1"); !errors.As(err, &policyErr) {
+		t.Fatalf("修改密码时期望密码强度错误，实际 %v", err)
+	}
+	if err := s.ChangePassword(user.ID, "Tr0ub4dor&3", "c0rrect-Horse"); err != nil {
+		t.Fatalf("修改密码失败: %v", err)
+	}
+}
+
+// captureMailer 记录发送的邮件
+type captureMailer struct {
+	sent []*notify.Message
+}
+
+func (m *captureMailer) Send(target string, msg *notify.Message) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+// verificationToken 从验证邮件中取出令牌
+func verificationToken(t *testing.T, msg *notify.Message) string {
+	t.Helper()
+
+	_, rest, ok := strings.Cut(msg.Body, "token=")
+	if !ok {
+		t.Fatalf("验证邮件中没有验证链接: %q", msg.Body)
+	}
+	token, err := url.QueryUnescape(strings.Fields(rest)[0])
+	if err != nil {
+		t.Fatalf("解析验证令牌失败: %v", err)
+	}
+	return token
+}
+
+func TestEmailVerification(t *testing.T) {
+	s, _, now := newTestService(t)
+	s.config.Security.EmailVerification = config.EmailVerificationConfig{
+		Enabled:        true,
+		LinkBaseURL:    "https://p3.example.com",
+		TokenTTL:       24,
+		ResendInterval: 60,
+	}
+	mailer := &captureMailer{}
+	s.SetMailer(mailer)
+
+	if _, err := s.Register("alice", "secret", ""); err == nil {
+		t.Fatal("启用邮箱验证时注册应要求邮箱")
+	}
+	user, err := s.Register("alice", "secret", "alice@example.com")
+	if err != nil {
+		t.Fatalf("注册失败: %v", err)
+	}
+	if user.EmailVerified {
+		t.Fatal("新用户的邮箱不应为已验证")
+	}
+	if err := s.CheckEmailVerified(user.ID); !errors.Is(err, ErrEmailNotVerified) {
+		t.Fatalf("期望邮箱未验证，实际 %v", err)
+	}
+	if len(mailer.sent) != 1 {
+		t.Fatalf("期望发送 1 封验证邮件，实际 %d", len(mailer.sent))
+	}
+
+	// 注册时已发送，立即重新发送被限制
+	var tooSoon *ResendTooSoonError
+	if err := s.ResendVerification(user.ID); !errors.As(err, &tooSoon) {
+		t.Fatalf("期望发送过于频繁，实际 %v", err)
+	}
+	*now = now.Add(time.Minute)
+	if err := s.ResendVerification(user.ID); err != nil {
+		t.Fatalf("重新发送失败: %v", err)
+	}
+
+	token := verificationToken(t, mailer.sent[1])
+	if _, err := s.VerifyEmail(token + "x"); !errors.Is(err, ErrVerificationTokenInvalid) {
+		t.Fatalf("篡改的令牌期望无效，实际 %v", err)
+	}
+	if _, err := s.VerifyEmail(token); err != nil {
+		t.Fatalf("验证邮箱失败: %v", err)
+	}
+	if err := s.CheckEmailVerified(user.ID); err != nil {
+		t.Fatalf("验证后仍未通过检查: %v", err)
+	}
+	if err := s.ResendVerification(user.ID); !errors.Is(err, ErrEmailAlreadyVerified) {
+		t.Fatalf("期望邮箱已验证，实际 %v", err)
+	}
+
+	// 过期的令牌无效
+	*now = now.Add(25 * time.Hour)
+	if _, err := s.VerifyEmail(token); !errors.Is(err, ErrVerificationTokenInvalid) {
+		t.Fatalf("过期的令牌期望无效，实际 %v", err)
+	}
+}
+
+func TestVerificationTokenBoundToEmail(t *testing.T) {
+	s, st, now := newTestService(t)
+	user, err := s.Register("alice", "secret", "alice@example.com")
+	if err != nil {
+		t.Fatalf("注册失败: %v", err)
+	}
+	token := s.VerificationToken(user, now.Add(time.Hour))
+
+	// 修改邮箱后之前的令牌失效
+	if err := st.Users.UpdateFields(user, map[string]interface{}{"email": "bob@example.com"}); err != nil {
+		t.Fatalf("更新用户失败: %v", err)
+	}
+	if _, err := s.VerifyEmail(token); !errors.Is(err, ErrVerificationTokenInvalid) {
+		t.Fatalf("期望令牌无效，实际 %v", err)
+	}
+}
+
+func TestRegistrationModes(t *testing.T) {
+	s, _, now := newTestService(t)
+
+	s.config.Auth.Registration = RegistrationClosed
+	if _, err := s.Register("alice", "secret", ""); !errors.Is(err, ErrRegistrationClosed) {
+		t.Fatalf("期望已关闭注册，实际 %v", err)
+	}
+
+	s.config.Auth.Registration = RegistrationInvite
+	s.config.Auth.InviteTTL = 24
+	if _, err := s.Register("alice", "secret", ""); !errors.Is(err, ErrInvitationRequired) {
+		t.Fatalf("期望需要邀请码，实际 %v", err)
+	}
+	if _, err := s.RegisterWithInvitation("alice", "secret", "", "bogus"); !errors.Is(err, ErrInvitationInvalid) {
+		t.Fatalf("期望邀请码无效，实际 %v", err)
+	}
+
+	_, code, err := s.CreateInvitation(1, InvitationOptions{Role: RoleAdmin})
+	if err != nil {
+		t.Fatalf("创建邀请失败: %v", err)
+	}
+	user, err := s.RegisterWithInvitation("alice", "secret", "", code)
+	if err != nil {
+		t.Fatalf("使用邀请注册失败: %v", err)
+	}
+	if UserRole(user) != RoleAdmin {
+		t.Errorf("期望角色 %s，实际 %s", RoleAdmin, UserRole(user))
+	}
+
+	// 邀请默认只能使用一次
+	if _, err := s.RegisterWithInvitation("bob", "secret", "", code); !errors.Is(err, ErrInvitationInvalid) {
+		t.Fatalf("用完的邀请期望无效，实际 %v", err)
+	}
+
+	// 过期的邀请无效
+	_, code, err = s.CreateInvitation(1, InvitationOptions{TTL: time.Hour, MaxUses: 5})
+	if err != nil {
+		t.Fatalf("创建邀请失败: %v", err)
+	}
+	*now = now.Add(time.Hour)
+	if _, err := s.RegisterWithInvitation("bob", "secret", "", code); !errors.Is(err, ErrInvitationInvalid) {
+		t.Fatalf("过期的邀请期望无效，实际 %v", err)
+	}
+}
+
+func TestUpdateProfile(t *testing.T) {
+	s, st, _ := newTestService(t)
+	user, err := s.Register("alice", "secret", "")
+	if err != nil {
+		t.Fatalf("注册失败: %v", err)
+	}
+
+	prefs := EffectivePreferences(user.Preferences)
+	if prefs.Theme != ThemeSystem || prefs.PageSize != DefaultPageSize || !prefs.Notifications[NotificationAlerts] {
+		t.Fatalf("默认偏好不正确: %+v", prefs)
+	}
+
+	name, tz, theme, pageSize := "Alice", "Asia/Shanghai", ThemeDark, 50
+	if _, err := s.UpdateProfile(user.ID, ProfileUpdate{
+		DisplayName:   &name,
+		Timezone:      &tz,
+		Theme:         &theme,
+		PageSize:      &pageSize,
+		Notifications: map[string]bool{NotificationDeviceOffline: false},
+	}); err != nil {
+		t.Fatalf("修改个人资料失败: %v", err)
+	}
+
+	// 只修改提供的字段，其他偏好保持不变
+	light := ThemeLight
+	if _, err := s.UpdateProfile(user.ID, ProfileUpdate{Theme: &light}); err != nil {
+		t.Fatalf("修改主题失败: %v", err)
+	}
+
+	saved, err := st.Users.GetByID(user.ID)
+	if err != nil {
+		t.Fatalf("查询用户失败: %v", err)
+	}
+	prefs = EffectivePreferences(saved.Preferences)
+	if saved.DisplayName != name || saved.Timezone != tz || prefs.Theme != ThemeLight || prefs.PageSize != pageSize {
+		t.Errorf("个人资料未保存: %+v %+v", saved, prefs)
+	}
+	if NotificationEnabled(saved, NotificationDeviceOffline) || !NotificationEnabled(saved, NotificationAlerts) {
+		t.Errorf("通知偏好不正确: %v", prefs.Notifications)
+	}
+
+	invalid := []ProfileUpdate{
+		{Timezone: func() *string { v := "Mars/Olympus"; return &v }()},
+		{Theme: func() *string { v := "neon"; return &v }()},
+		{PageSize: func() *int { v := MaxPageSize + 1; return &v }()},
+		{Notifications: map[string]bool{"unknown": true}},
+	}
+	for i, update := range invalid {
+		if _, err := s.UpdateProfile(user.ID, update); err == nil {
+			t.Errorf("第 %d 个无效修改应返回错误", i+1)
+		}
+	}
+}
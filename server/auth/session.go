@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/notify"
+)
+
+// 会话绑定的严格程度
+const (
+	SessionBindingOff      = "off"
+	SessionBindingNotify   = "notify"   // 客户端和网络都变化时只通知用户
+	SessionBindingBalanced = "balanced" // 客户端和网络都变化时要求重新登录并通知用户
+	SessionBindingStrict   = "strict"   // 客户端或网络任一变化时要求重新登录并通知用户
+)
+
+// ErrReauthRequired 刷新令牌的环境与登录时差异过大，需要重新登录
+var ErrReauthRequired = errors.New("登录环境发生变化，请重新登录")
+
+// anomalyNoticeInterval 同一用户两次会话异常通知的最小间隔
+const anomalyNoticeInterval = time.Hour
+
+// versionPattern 匹配 User-Agent 中的版本号，浏览器升级后指纹不变
+var versionPattern = regexp.MustCompile(`[0-9][0-9._]*`)
+
+// ClientContext 登录或刷新令牌的请求环境
+type ClientContext struct {
+	UserAgent string
+	IP        string
+}
+
+// SessionBinding 令牌绑定的登录环境，只保存摘要
+type SessionBinding struct {
+	Agent   string `json:"ua"`  // 去掉版本号的 User-Agent 摘要
+	Network string `json:"net"` // 客户端 IP 所在网络的摘要
+}
+
+// sessionBinding 计算请求环境的绑定信息，未启用会话绑定或缺少 IP 时返回 nil
+func (s *Service) sessionBinding(client ClientContext) *SessionBinding {
+	cfg := s.config.Security.SessionBinding
+	if cfg.Mode == "" || cfg.Mode == SessionBindingOff {
+		return nil
+	}
+	network := clientNetwork(client.IP, cfg.IPv4Prefix, cfg.IPv6Prefix)
+	if network == "" {
+		return nil
+	}
+	agent := versionPattern.ReplaceAllString(strings.ToLower(client.UserAgent), "")
+	return &SessionBinding{
+		Agent:   bindingDigest(agent),
+		Network: bindingDigest(network),
+	}
+}
+
+// clientNetwork 客户端 IP 按前缀长度截取的网络，IP 无效时返回空
+func clientNetwork(ip string, ipv4Prefix, ipv6Prefix int) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	if v4 := parsed.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(ipv4Prefix, 32)), Mask: net.CIDRMask(ipv4Prefix, 32)}).String()
+	}
+	return (&net.IPNet{IP: parsed.Mask(net.CIDRMask(ipv6Prefix, 128)), Mask: net.CIDRMask(ipv6Prefix, 128)}).String()
+}
+
+// bindingDigest 绑定信息的摘要，令牌中不直接暴露 User-Agent 和网络
+func bindingDigest(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkSessionBinding 比较登录时与本次刷新的环境。按配置的严格程度决定是否要求重新登录，
+// 判定为异常时通知用户
+func (s *Service) checkSessionBinding(user *db.User, bound *SessionBinding, client ClientContext) error {
+	current := s.sessionBinding(client)
+	if bound == nil || current == nil {
+		return nil
+	}
+	agentChanged := bound.Agent != current.Agent
+	networkChanged := bound.Network != current.Network
+	if !agentChanged && !networkChanged {
+		return nil
+	}
+
+	mode := s.config.Security.SessionBinding.Mode
+	anomalous := agentChanged && networkChanged
+	if mode == SessionBindingStrict {
+		anomalous = true
+	}
+	if !anomalous {
+		return nil
+	}
+
+	logger.Warn("用户 %s 的令牌在不同的环境中刷新（客户端变化: %t，网络变化: %t，IP: %s）",
+		user.Username, agentChanged, networkChanged, client.IP)
+	s.notifySessionAnomaly(user, client)
+
+	if mode == SessionBindingNotify {
+		return nil
+	}
+	return ErrReauthRequired
+}
+
+// notifySessionAnomaly 通过邮件通知用户令牌在陌生环境中被使用，同一用户每小时最多通知一次
+func (s *Service) notifySessionAnomaly(user *db.User, client ClientContext) {
+	if s.mailer == nil || user.Email == "" || !NotificationEnabled(user, NotificationSecurity) {
+		return
+	}
+	now := s.now()
+	if s.anomalyNotices.reserve(user.ID, now, anomalyNoticeInterval) > 0 {
+		return
+	}
+
+	err := s.mailer.Send(user.Email, &notify.Message{
+		Subject: "P3 账户在陌生环境中使用",
+		Body: fmt.Sprintf("%s，您好：\n\n您的登录令牌于 %s 在与登录时不同的设备和网络中使用：\n\nIP 地址：%s\n客户端：%s\n\n"+
+			"如果这不是您本人的操作，请立即修改密码。\n",
+			user.Username, now.Format(time.RFC3339), client.IP, client.UserAgent),
+		Data: map[string]interface{}{
+			"userId": user.ID,
+			"ip":     client.IP,
+		},
+		Timestamp: now,
+	})
+	if err != nil {
+		logger.Warn("向用户 %s 发送会话异常通知失败: %v", user.Username, err)
+	}
+}
@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRefreshTokenSessionBinding(t *testing.T) {
+	const (
+		firefox = "Mozilla/5.0 (X11; Linux x86_64; rv:126.0) Gecko/20100101 Firefox/126.0"
+		curl    = "curl/8.5.0"
+	)
+	home := ClientContext{UserAgent: firefox, IP: "203.0.113.10"}
+
+	tests := []struct {
+		name       string
+		mode       string
+		client     ClientContext
+		wantErr    error
+		wantNotice bool
+	}{
+		{name: "相同环境", mode: SessionBindingBalanced, client: home},
+		{name: "浏览器升级", mode: SessionBindingStrict,
+			client: ClientContext{UserAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:127.0) Gecko/20100101 Firefox/127.0", IP: "203.0.7.1"}},
+		{name: "只有网络变化", mode: SessionBindingBalanced, client: ClientContext{UserAgent: firefox, IP: "198.51.100.7"}},
+		{name: "客户端和网络都变化", mode: SessionBindingBalanced,
+			client: ClientContext{UserAgent: curl, IP: "198.51.100.7"}, wantErr: ErrReauthRequired, wantNotice: true},
+		{name: "只通知", mode: SessionBindingNotify,
+			client: ClientContext{UserAgent: curl, IP: "198.51.100.7"}, wantNotice: true},
+		{name: "严格模式网络变化", mode: SessionBindingStrict,
+			client: ClientContext{UserAgent: firefox, IP: "198.51.100.7"}, wantErr: ErrReauthRequired, wantNotice: true},
+		{name: "关闭", mode: SessionBindingOff, client: ClientContext{UserAgent: curl, IP: "198.51.100.7"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newTestService(t)
+			s.config.Security.SessionBinding.Mode = tt.mode
+			s.config.Security.SessionBinding.IPv4Prefix = 16
+			s.config.Security.SessionBinding.IPv6Prefix = 48
+			mailer := &captureMailer{}
+			s.SetMailer(mailer)
+
+			if _, err := s.Register("alice", "secret", "alice@example.com"); err != nil {
+				t.Fatalf("注册失败: %v", err)
+			}
+			_, token, err := s.LoginFrom("alice", "secret", "", home)
+			if err != nil {
+				t.Fatalf("登录失败: %v", err)
+			}
+
+			refreshed, err := s.RefreshTokenFrom(token, tt.client)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			if noticed := len(mailer.sent) > 0; noticed != tt.wantNotice {
+				t.Fatalf("期望通知 %t，实际 %t", tt.wantNotice, noticed)
+			}
+			if err != nil {
+				return
+			}
+
+			// 刷新后的令牌保留登录时的环境
+			original, _ := s.ParseToken(token)
+			claims, err := s.ParseToken(refreshed)
+			if err != nil {
+				t.Fatalf("解析刷新后的令牌失败: %v", err)
+			}
+			if (original.Binding == nil) != (claims.Binding == nil) ||
+				(claims.Binding != nil && *claims.Binding != *original.Binding) {
+				t.Fatalf("刷新后的令牌绑定环境改变: %+v -> %+v", original.Binding, claims.Binding)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base32"
 	"fmt"
 	"net/url"
@@ -94,8 +95,38 @@ func VerifyTOTP(secret string, passcode string, config TOTPConfig) (bool, error)
 	return valid, nil
 }
 
+// MatchTOTP 在 center 前后 radius 个周期内查找与验证码匹配的周期，返回该周期相对 at 所在周期的偏移。
+// 离 center 越近的周期越先比较，用于按终端的时钟偏差放宽验证窗口
+func MatchTOTP(secret string, passcode string, config TOTPConfig, at time.Time, center, radius int) (int, bool, error) {
+	if len(passcode) != config.Digits.Length() {
+		return 0, false, nil
+	}
+
+	period := time.Duration(config.Period) * time.Second
+	for distance := 0; distance <= radius; distance++ {
+		for _, step := range []int{center - distance, center + distance} {
+			code, err := GenerateTOTPAt(secret, config, at.Add(time.Duration(step)*period))
+			if err != nil {
+				return 0, false, err
+			}
+			if subtle.ConstantTimeCompare([]byte(code), []byte(passcode)) == 1 {
+				return step, true, nil
+			}
+			if distance == 0 {
+				break
+			}
+		}
+	}
+	return 0, false, nil
+}
+
 // GenerateTOTP 生成 TOTP 代码
 func GenerateTOTP(secret string, config TOTPConfig) (string, error) {
+	return GenerateTOTPAt(secret, config, time.Now())
+}
+
+// GenerateTOTPAt 生成指定时间的 TOTP 代码
+func GenerateTOTPAt(secret string, config TOTPConfig, at time.Time) (string, error) {
 	// 添加填充字符
 	paddingCount := len(secret) % 8
 	if paddingCount > 0 {
@@ -105,7 +136,7 @@ func GenerateTOTP(secret string, config TOTPConfig) (string, error) {
 	// 生成 TOTP 代码
 	passcode, err := totp.GenerateCodeCustom(
 		secret,
-		time.Now(),
+		at,
 		totp.ValidateOpts{
 			Period:    config.Period,
 			Skew:      1,
@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/notify"
+)
+
+var (
+	// ErrEmailNotVerified 邮箱未验证
+	ErrEmailNotVerified = errors.New("邮箱未验证")
+	// ErrEmailAlreadyVerified 邮箱已验证
+	ErrEmailAlreadyVerified = errors.New("邮箱已验证")
+	// ErrVerificationTokenInvalid 验证链接无效或已过期
+	ErrVerificationTokenInvalid = errors.New("验证链接无效或已过期")
+	// ErrVerificationUnavailable 未启用邮箱验证或没有可用的邮件服务
+	ErrVerificationUnavailable = errors.New("邮箱验证不可用")
+)
+
+// ResendTooSoonError 重新发送验证邮件过于频繁
+type ResendTooSoonError struct {
+	RetryAfter time.Duration
+}
+
+// Error 返回错误信息
+func (e *ResendTooSoonError) Error() string {
+	return fmt.Sprintf("发送过于频繁，请在 %d 秒后重试", int(e.RetryAfter.Seconds()+0.5))
+}
+
+// verificationKeyContext 派生验证链接签名密钥时使用的上下文，避免与 JWT 签名共用同一密钥
+const verificationKeyContext = "p3-email-verification"
+
+// verificationThrottle 按用户记录最近一次发送验证邮件的时间
+type verificationThrottle struct {
+	lastSent map[uint]time.Time
+	mu       sync.Mutex
+}
+
+// newVerificationThrottle 创建验证邮件发送记录
+func newVerificationThrottle() *verificationThrottle {
+	return &verificationThrottle{
+		lastSent: make(map[uint]time.Time),
+	}
+}
+
+// reserve 检查距离上次发送是否已超过 interval，是则记录本次发送并返回 0，否则返回需要等待的时间
+func (t *verificationThrottle) reserve(userID uint, now time.Time, interval time.Duration) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.lastSent[userID]; ok {
+		if wait := last.Add(interval).Sub(now); wait > 0 {
+			return wait
+		}
+	}
+	t.lastSent[userID] = now
+	return 0
+}
+
+// SetMailer 设置发送验证邮件的通知发送器，未设置时无法发送验证邮件
+func (s *Service) SetMailer(mailer notify.Notifier) {
+	s.mailer = mailer
+}
+
+// VerificationEnabled 检查是否启用了邮箱验证
+func (s *Service) VerificationEnabled() bool {
+	return s.config.Security.EmailVerification.Enabled
+}
+
+// CheckEmailVerified 检查用户是否可以使用需要已验证邮箱的功能，未启用邮箱验证时总是通过
+func (s *Service) CheckEmailVerified(userID uint) error {
+	if !s.VerificationEnabled() {
+		return nil
+	}
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if !user.EmailVerified {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// ResendVerification 重新发送验证邮件，同一用户两次发送的间隔不能小于配置的最小间隔，
+// 过于频繁时返回 *ResendTooSoonError
+func (s *Service) ResendVerification(userID uint) error {
+	if !s.VerificationEnabled() {
+		return ErrVerificationUnavailable
+	}
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+	return s.sendVerification(user)
+}
+
+// sendVerification 生成验证链接并发送到用户的邮箱
+func (s *Service) sendVerification(user *db.User) error {
+	if s.mailer == nil {
+		return ErrVerificationUnavailable
+	}
+
+	cfg := s.config.Security.EmailVerification
+	now := s.now()
+	if wait := s.throttle.reserve(user.ID, now, time.Duration(cfg.ResendInterval)*time.Second); wait > 0 {
+		return &ResendTooSoonError{RetryAfter: wait}
+	}
+
+	token := s.VerificationToken(user, now.Add(time.Duration(cfg.TokenTTL)*time.Hour))
+	link := strings.TrimRight(cfg.LinkBaseURL, "/") + "/verify-email?token=" + url.QueryEscape(token)
+
+	err := s.mailer.Send(user.Email, &notify.Message{
+		Subject: "验证您的 P3 账户邮箱",
+		Body: fmt.Sprintf("%s，您好：\n\n请在 %d 小时内打开以下链接完成邮箱验证：\n\n%s\n\n如果您没有注册 P3 账户，请忽略此邮件。\n",
+			user.Username, cfg.TokenTTL, link),
+		Data: map[string]interface{}{
+			"userId": user.ID,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("发送验证邮件失败: %w", err)
+	}
+	return nil
+}
+
+// VerificationToken 生成邮箱验证令牌，格式为 base64(用户 ID:过期时间).base64(签名)。
+// 签名覆盖用户当前的邮箱，修改邮箱后之前的验证链接自动失效
+func (s *Service) VerificationToken(user *db.User, expiresAt time.Time) string {
+	payload := fmt.Sprintf("%d:%d", user.ID, expiresAt.Unix())
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(s.signVerification(payload, user.Email))
+}
+
+// signVerification 计算验证令牌的签名
+func (s *Service) signVerification(payload, email string) []byte {
+	key := sha256.Sum256([]byte(verificationKeyContext + ":" + s.config.JWT.Secret))
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(payload + ":" + strings.ToLower(email)))
+	return mac.Sum(nil)
+}
+
+// VerifyEmail 校验验证令牌并将用户的邮箱标记为已验证
+func (s *Service) VerifyEmail(token string) (*db.User, error) {
+	encodedPayload, encodedSig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, ErrVerificationTokenInvalid
+	}
+	payloadBytes, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, ErrVerificationTokenInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return nil, ErrVerificationTokenInvalid
+	}
+
+	payload := string(payloadBytes)
+	idPart, expPart, ok := strings.Cut(payload, ":")
+	if !ok {
+		return nil, ErrVerificationTokenInvalid
+	}
+	userID, err := strconv.ParseUint(idPart, 10, 64)
+	if err != nil {
+		return nil, ErrVerificationTokenInvalid
+	}
+	expiresAt, err := strconv.ParseInt(expPart, 10, 64)
+	if err != nil || !s.now().Before(time.Unix(expiresAt, 0)) {
+		return nil, ErrVerificationTokenInvalid
+	}
+
+	user, err := s.GetUserByID(uint(userID))
+	if err != nil {
+		if errors.Is(err, ErrUserNotFound) {
+			return nil, ErrVerificationTokenInvalid
+		}
+		return nil, err
+	}
+	if !hmac.Equal(sig, s.signVerification(payload, user.Email)) {
+		return nil, ErrVerificationTokenInvalid
+	}
+
+	if user.EmailVerified {
+		return user, nil
+	}
+	if err := s.users.UpdateFields(user, map[string]interface{}{"email_verified": true}); err != nil {
+		return nil, fmt.Errorf("更新用户失败: %w", err)
+	}
+	logger.Info("用户 %s 已验证邮箱", user.Username)
+	return user, nil
+}
+
+// SetEmailVerified 由管理员直接设置用户的邮箱验证状态，例如用户无法收到验证邮件时
+func (s *Service) SetEmailVerified(userID uint, verified bool) (*db.User, error) {
+	user, err := s.GetUserByID(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.users.UpdateFields(user, map[string]interface{}{"email_verified": verified}); err != nil {
+		return nil, fmt.Errorf("更新用户失败: %w", err)
+	}
+	return user, nil
+}
@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// 备份文件格式：魔数 | 格式版本 | 盐 | 随机数 | AES-256-GCM 加密的 gzip 压缩 JSON。
+// 魔数、格式版本、盐和随机数作为附加数据参与认证
+const (
+	saltSize = 16
+	keySize  = 32
+	// MinPassphraseLength 口令的最短长度
+	MinPassphraseLength = 8
+	// MaxArchiveSize 备份文件的最大大小
+	MaxArchiveSize = 512 << 20
+)
+
+// magic 备份文件的魔数
+var magic = []byte("P3BACKUP")
+
+var (
+	// ErrNotBackup 不是备份文件
+	ErrNotBackup = errors.New("不是 P3 备份文件")
+	// ErrBadPassphrase 口令错误或备份文件已损坏
+	ErrBadPassphrase = errors.New("口令错误或备份文件已损坏")
+)
+
+// deriveKey 使用 Argon2id 从口令派生加密密钥
+func deriveKey(passphrase string, salt []byte) []byte {
+	return argon2.IDKey([]byte(passphrase), salt, 3, 64*1024, 4, keySize)
+}
+
+// newGCM 创建 AES-256-GCM
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Encode 压缩快照并使用口令加密后写入 w
+func Encode(w io.Writer, snapshot *Snapshot, passphrase string) error {
+	if len(passphrase) < MinPassphraseLength {
+		return fmt.Errorf("口令至少需要 %d 个字符", MinPassphraseLength)
+	}
+
+	var plain bytes.Buffer
+	gz := gzip.NewWriter(&plain)
+	if err := json.NewEncoder(gz).Encode(snapshot); err != nil {
+		return fmt.Errorf("序列化备份失败: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("压缩备份失败: %w", err)
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	gcm, err := newGCM(deriveKey(passphrase, salt))
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	header := make([]byte, 0, len(magic)+1+saltSize+len(nonce))
+	header = append(header, magic...)
+	header = append(header, FormatVersion)
+	header = append(header, salt...)
+	header = append(header, nonce...)
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(gcm.Seal(nil, nonce, plain.Bytes(), header))
+	return err
+}
+
+// Decode 读取备份文件，使用口令解密并解析快照
+func Decode(r io.Reader, passphrase string) (*Snapshot, error) {
+	data, err := io.ReadAll(io.LimitReader(r, MaxArchiveSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("读取备份文件失败: %w", err)
+	}
+	if len(data) > MaxArchiveSize {
+		return nil, fmt.Errorf("备份文件超过 %d MB", MaxArchiveSize>>20)
+	}
+	if len(data) < len(magic)+1+saltSize || !bytes.Equal(data[:len(magic)], magic) {
+		return nil, ErrNotBackup
+	}
+	if version := data[len(magic)]; version != FormatVersion {
+		return nil, fmt.Errorf("不支持的备份格式版本: %d", version)
+	}
+
+	salt := data[len(magic)+1 : len(magic)+1+saltSize]
+	gcm, err := newGCM(deriveKey(passphrase, salt))
+	if err != nil {
+		return nil, err
+	}
+	headerSize := len(magic) + 1 + saltSize + gcm.NonceSize()
+	if len(data) < headerSize+gcm.Overhead() {
+		return nil, ErrNotBackup
+	}
+	header := data[:headerSize]
+	plain, err := gcm.Open(nil, header[len(header)-gcm.NonceSize():], data[headerSize:], header)
+	if err != nil {
+		return nil, ErrBadPassphrase
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(plain))
+	if err != nil {
+		return nil, fmt.Errorf("解压备份失败: %w", err)
+	}
+	var snapshot Snapshot
+	if err := json.NewDecoder(gz).Decode(&snapshot); err != nil {
+		return nil, fmt.Errorf("解析备份失败: %w", err)
+	}
+	if snapshot.Version != FormatVersion {
+		return nil, fmt.Errorf("不支持的快照版本: %d", snapshot.Version)
+	}
+	return &snapshot, nil
+}
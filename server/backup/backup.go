@@ -0,0 +1,167 @@
+// Package backup 服务端状态的备份和恢复。备份是数据库中用户、设备、应用、转发规则、
+// 子网路由及其访问控制等资源的一致快照，可以附带配置文件，整体使用口令加密。
+// 恢复时可以只恢复部分用户及其资源
+package backup
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+)
+
+// FormatVersion 快照格式版本，格式不兼容时递增
+const FormatVersion = 1
+
+// Snapshot 备份的内容
+type Snapshot struct {
+	Version       int               `json:"version"`
+	CreatedAt     time.Time         `json:"createdAt"`
+	ServerVersion string            `json:"serverVersion"`
+	Config        []byte            `json:"config,omitempty"` // 配置文件原文
+	Users         []User            `json:"users"`
+	TOTPs         []TOTP            `json:"totps"`
+	Devices       []Device          `json:"devices"`
+	DeviceFilters []db.DeviceFilter `json:"deviceFilters"`
+	Apps          []db.App          `json:"apps"`
+	Forwards      []db.Forward      `json:"forwards"`
+	Routes        []db.Route        `json:"routes"`
+	RouteACLs     []db.RouteACL     `json:"routeAcls"`
+	AlertRules    []db.AlertRule    `json:"alertRules"`
+}
+
+// User 用户，包含 API 中不返回的密码哈希
+type User struct {
+	db.User
+	Password string `json:"password"`
+}
+
+// TOTP 双因素认证，包含 API 中不返回的密钥和备用码
+type TOTP struct {
+	db.TOTP
+	Secret      string   `json:"secret"`
+	BackupCodes []string `json:"backupCodes"`
+}
+
+// Device 设备，包含 API 中不返回的设备令牌
+type Device struct {
+	db.Device
+	Token string `json:"token"`
+}
+
+// Counts 各类资源的数量，键为资源类型
+type Counts map[string]int
+
+// resourceNames 资源类型及其名称，按恢复顺序排列
+var resourceNames = []struct{ key, name string }{
+	{"users", "用户"},
+	{"totps", "双因素认证"},
+	{"devices", "设备"},
+	{"deviceFilters", "设备筛选条件"},
+	{"apps", "应用"},
+	{"forwards", "转发规则"},
+	{"routes", "子网路由"},
+	{"routeAcls", "路由访问控制"},
+	{"alertRules", "告警规则"},
+}
+
+// String 按恢复顺序列出各类资源的数量
+func (c Counts) String() string {
+	parts := make([]string, 0, len(resourceNames))
+	for _, r := range resourceNames {
+		parts = append(parts, fmt.Sprintf("%s %d", r.name, c[r.key]))
+	}
+	return strings.Join(parts, "，")
+}
+
+// FileName 备份文件的默认文件名
+func FileName(createdAt time.Time) string {
+	return "p3-backup-" + createdAt.UTC().Format("20060102T150405Z") + ".p3bak"
+}
+
+// Counts 统计快照中各类资源的数量
+func (s *Snapshot) Counts() Counts {
+	return Counts{
+		"users":         len(s.Users),
+		"totps":         len(s.TOTPs),
+		"devices":       len(s.Devices),
+		"deviceFilters": len(s.DeviceFilters),
+		"apps":          len(s.Apps),
+		"forwards":      len(s.Forwards),
+		"routes":        len(s.Routes),
+		"routeAcls":     len(s.RouteACLs),
+		"alertRules":    len(s.AlertRules),
+	}
+}
+
+// Select 返回只包含指定用户及其资源的快照，不包含配置文件。用户名不在快照中时返回错误
+func (s *Snapshot) Select(usernames []string) (*Snapshot, error) {
+	userIDs := make(map[uint]bool, len(usernames))
+	selected := &Snapshot{
+		Version:       s.Version,
+		CreatedAt:     s.CreatedAt,
+		ServerVersion: s.ServerVersion,
+	}
+	for _, username := range usernames {
+		found := false
+		for _, user := range s.Users {
+			if user.Username == username {
+				if !userIDs[user.ID] {
+					selected.Users = append(selected.Users, user)
+				}
+				userIDs[user.ID] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("备份中没有用户 %s", username)
+		}
+	}
+
+	for _, totp := range s.TOTPs {
+		if userIDs[totp.UserID] {
+			selected.TOTPs = append(selected.TOTPs, totp)
+		}
+	}
+	for _, device := range s.Devices {
+		if userIDs[device.UserID] {
+			selected.Devices = append(selected.Devices, device)
+		}
+	}
+	for _, filter := range s.DeviceFilters {
+		if userIDs[filter.UserID] {
+			selected.DeviceFilters = append(selected.DeviceFilters, filter)
+		}
+	}
+	for _, app := range s.Apps {
+		if userIDs[app.UserID] {
+			selected.Apps = append(selected.Apps, app)
+		}
+	}
+	for _, forward := range s.Forwards {
+		if userIDs[forward.UserID] {
+			selected.Forwards = append(selected.Forwards, forward)
+		}
+	}
+	routeIDs := make(map[uint]bool)
+	for _, route := range s.Routes {
+		if userIDs[route.UserID] {
+			selected.Routes = append(selected.Routes, route)
+			routeIDs[route.ID] = true
+		}
+	}
+	// 访问控制跟随路由，允许访问的对端设备可以属于其他用户
+	for _, acl := range s.RouteACLs {
+		if routeIDs[acl.RouteID] {
+			selected.RouteACLs = append(selected.RouteACLs, acl)
+		}
+	}
+	for _, rule := range s.AlertRules {
+		if userIDs[rule.UserID] {
+			selected.AlertRules = append(selected.AlertRules, rule)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func testSnapshot() *Snapshot {
+	alice := User{User: db.User{Username: "alice"}, Password: "$argon2id$alice"}
+	alice.ID = 1
+	bob := User{User: db.User{Username: "bob"}, Password: "$argon2id$bob"}
+	bob.ID = 2
+
+	aliceDevice := Device{Device: db.Device{UserID: 1, NodeID: "alice-laptop"}, Token: "token-a"}
+	aliceDevice.ID = 10
+	bobDevice := Device{Device: db.Device{UserID: 2, NodeID: "bob-nas"}, Token: "token-b"}
+	bobDevice.ID = 11
+
+	aliceRoute := db.Route{UserID: 1, DeviceID: 10, CIDR: "10.0.0.0/24"}
+	aliceRoute.ID = 20
+	bobRoute := db.Route{UserID: 2, DeviceID: 11, CIDR: "10.1.0.0/24"}
+	bobRoute.ID = 21
+
+	return &Snapshot{
+		Version:   FormatVersion,
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Config:    []byte("server:\n  port: 8080\n"),
+		Users:     []User{alice, bob},
+		TOTPs:     []TOTP{{TOTP: db.TOTP{UserID: 1, Enabled: true}, Secret: "JBSWY3DPEHPK3PXP", BackupCodes: []string{"code-1"}}},
+		Devices:   []Device{aliceDevice, bobDevice},
+		Forwards:  []db.Forward{{UserID: 2, Protocol: "tcp", SrcPort: 8080}},
+		Routes:    []db.Route{aliceRoute, bobRoute},
+		// bob 的路由允许 alice 的设备访问，访问控制跟随路由
+		RouteACLs: []db.RouteACL{{RouteID: 20, PeerDeviceID: 11}, {RouteID: 21, PeerDeviceID: 10}},
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, testSnapshot(), "short"); err == nil {
+		t.Fatal("口令过短时应返回错误")
+	}
+	if err := Encode(&buf, testSnapshot(), "correct horse"); err != nil {
+		t.Fatalf("加密备份失败: %v", err)
+	}
+	if bytes.Contains(buf.Bytes(), []byte("alice")) {
+		t.Fatal("备份内容未加密")
+	}
+
+	if _, err := Decode(bytes.NewReader(buf.Bytes()), "wrong passphrase"); !errors.Is(err, ErrBadPassphrase) {
+		t.Fatalf("口令错误时应返回 ErrBadPassphrase，实际: %v", err)
+	}
+	tampered := append([]byte(nil), buf.Bytes()...)
+	tampered[len(tampered)-1] ^= 1
+	if _, err := Decode(bytes.NewReader(tampered), "correct horse"); !errors.Is(err, ErrBadPassphrase) {
+		t.Fatalf("内容被修改时应返回 ErrBadPassphrase，实际: %v", err)
+	}
+	if _, err := Decode(strings.NewReader("not a backup"), "correct horse"); !errors.Is(err, ErrNotBackup) {
+		t.Fatalf("非备份文件应返回 ErrNotBackup，实际: %v", err)
+	}
+
+	snapshot, err := Decode(bytes.NewReader(buf.Bytes()), "correct horse")
+	if err != nil {
+		t.Fatalf("解密备份失败: %v", err)
+	}
+	// API 中不返回的字段也要保存在备份中
+	if snapshot.Users[0].Password != "$argon2id$alice" || snapshot.Devices[1].Token != "token-b" {
+		t.Errorf("密码哈希或设备令牌丢失: %+v %+v", snapshot.Users[0], snapshot.Devices[1])
+	}
+	if snapshot.TOTPs[0].Secret != "JBSWY3DPEHPK3PXP" || len(snapshot.TOTPs[0].BackupCodes) != 1 {
+		t.Errorf("双因素认证密钥丢失: %+v", snapshot.TOTPs[0])
+	}
+	if snapshot.Users[1].ID != 2 || string(snapshot.Config) != "server:\n  port: 8080\n" {
+		t.Errorf("恢复的快照不一致: %+v", snapshot)
+	}
+}
+
+func TestSelect(t *testing.T) {
+	snapshot := testSnapshot()
+	if _, err := snapshot.Select([]string{"carol"}); err == nil {
+		t.Fatal("用户不在备份中时应返回错误")
+	}
+
+	selected, err := snapshot.Select([]string{"bob"})
+	if err != nil {
+		t.Fatalf("选择用户失败: %v", err)
+	}
+	if selected.Config != nil {
+		t.Error("按用户恢复时不应包含配置文件")
+	}
+	counts := selected.Counts()
+	want := Counts{"users": 1, "totps": 0, "devices": 1, "deviceFilters": 0, "apps": 0, "forwards": 1, "routes": 1, "routeAcls": 1, "alertRules": 0}
+	for name, n := range want {
+		if counts[name] != n {
+			t.Errorf("%s 数量为 %d，期望 %d", name, counts[name], n)
+		}
+	}
+	if selected.Devices[0].NodeID != "bob-nas" || selected.RouteACLs[0].RouteID != 21 {
+		t.Errorf("选择了错误的资源: %+v", selected)
+	}
+}
+
+func TestUpsertKeepsZeroValues(t *testing.T) {
+	gdb, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	var sql string
+	var vars []interface{}
+	if err := gdb.Callback().Create().After("gorm:create").Register("test:capture", func(tx *gorm.DB) {
+		sql, vars = tx.Statement.SQL.String(), tx.Statement.Vars
+	}); err != nil {
+		t.Fatalf("注册回调失败: %v", err)
+	}
+
+	route := db.Route{UserID: 1, DeviceID: 10, CIDR: "10.0.0.0/24", Enabled: false}
+	route.ID = 20
+	if err := upsert(gdb, []db.Route{route}); err != nil {
+		t.Fatalf("生成 SQL 失败: %v", err)
+	}
+
+	if !strings.Contains(sql, "ON CONFLICT") || !strings.Contains(sql, "`enabled`") {
+		t.Fatalf("生成的 SQL 不正确: %s", sql)
+	}
+	// enabled 列的默认值为 true，恢复时必须写入 false
+	for _, v := range vars {
+		if v == true {
+			t.Fatalf("零值被替换为默认值: %v", vars)
+		}
+	}
+	if len(vars) == 0 || !containsValue(vars, uint(20)) {
+		t.Fatalf("未写入记录的 ID: %v", vars)
+	}
+}
+
+func containsValue(vars []interface{}, value interface{}) bool {
+	for _, v := range vars {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
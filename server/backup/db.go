@@ -0,0 +1,185 @@
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/senma231/p3/common/version"
+	"github.com/senma231/p3/server/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// restoreBatchSize 恢复时每条 INSERT 语句写入的记录数
+const restoreBatchSize = 100
+
+// Dump 在只读的可重复读事务中读取各类资源，得到一致的快照。已删除的记录不包含在快照中
+func Dump(ctx context.Context, gdb *gorm.DB) (*Snapshot, error) {
+	snapshot := &Snapshot{
+		Version:       FormatVersion,
+		CreatedAt:     time.Now().UTC(),
+		ServerVersion: version.Version,
+	}
+
+	err := gdb.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var users []db.User
+		if err := tx.Order("id").Find(&users).Error; err != nil {
+			return fmt.Errorf("读取用户失败: %w", err)
+		}
+		for _, user := range users {
+			snapshot.Users = append(snapshot.Users, User{User: user, Password: user.Password})
+		}
+
+		var totps []db.TOTP
+		if err := tx.Order("id").Find(&totps).Error; err != nil {
+			return fmt.Errorf("读取双因素认证失败: %w", err)
+		}
+		for _, totp := range totps {
+			snapshot.TOTPs = append(snapshot.TOTPs, TOTP{TOTP: totp, Secret: totp.Secret, BackupCodes: totp.BackupCodes})
+		}
+
+		var devices []db.Device
+		if err := tx.Scopes(db.WithStatus).Order("devices.id").Find(&devices).Error; err != nil {
+			return fmt.Errorf("读取设备失败: %w", err)
+		}
+		for _, device := range devices {
+			snapshot.Devices = append(snapshot.Devices, Device{Device: device, Token: device.Token})
+		}
+
+		for _, table := range []struct {
+			name string
+			dest interface{}
+		}{
+			{"设备筛选条件", &snapshot.DeviceFilters},
+			{"应用", &snapshot.Apps},
+			{"转发规则", &snapshot.Forwards},
+			{"子网路由", &snapshot.Routes},
+			{"路由访问控制", &snapshot.RouteACLs},
+			{"告警规则", &snapshot.AlertRules},
+		} {
+			if err := tx.Order("id").Find(table.dest).Error; err != nil {
+				return fmt.Errorf("读取%s失败: %w", table.name, err)
+			}
+		}
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot, nil
+}
+
+// Restore 在一个事务中按 ID 写回快照中的资源：已存在的记录被覆盖，已删除的记录被恢复，
+// 快照之后新建的记录保持不变。用户名、节点 ID 等唯一字段与其他记录冲突时整体回滚
+func Restore(ctx context.Context, gdb *gorm.DB, snapshot *Snapshot) (Counts, error) {
+	users := make([]db.User, len(snapshot.Users))
+	for i, user := range snapshot.Users {
+		users[i] = user.User
+		users[i].Password = user.Password
+	}
+	totps := make([]db.TOTP, len(snapshot.TOTPs))
+	for i, totp := range snapshot.TOTPs {
+		totps[i] = totp.TOTP
+		totps[i].Secret = totp.Secret
+		totps[i].BackupCodes = totp.BackupCodes
+	}
+	devices := make([]db.Device, len(snapshot.Devices))
+	for i, device := range snapshot.Devices {
+		devices[i] = device.Device
+		devices[i].Token = device.Token
+	}
+
+	// 按外键依赖的顺序写入
+	tables := []struct {
+		name    string
+		records interface{}
+	}{
+		{"用户", users},
+		{"双因素认证", totps},
+		{"设备", devices},
+		{"设备筛选条件", snapshot.DeviceFilters},
+		{"应用", snapshot.Apps},
+		{"转发规则", snapshot.Forwards},
+		{"子网路由", snapshot.Routes},
+		{"路由访问控制", snapshot.RouteACLs},
+		{"告警规则", snapshot.AlertRules},
+	}
+	err := gdb.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, table := range tables {
+			if err := upsert(tx, table.records); err != nil {
+				if db.IsDuplicateKey(err) {
+					return fmt.Errorf("恢复%s失败，与现有记录的唯一字段冲突: %w", table.name, err)
+				}
+				return fmt.Errorf("恢复%s失败: %w", table.name, err)
+			}
+		}
+		// 设备的运行状态保存在以设备 ID 为主键的状态快照中
+		if len(devices) == 0 {
+			return nil
+		}
+		statuses := make([]db.DeviceStatus, len(devices))
+		for i := range devices {
+			statuses[i] = db.StatusOf(&devices[i])
+		}
+		if err := tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "device_id"}},
+			UpdateAll: true,
+		}).CreateInBatches(statuses, restoreBatchSize).Error; err != nil {
+			return fmt.Errorf("恢复设备状态失败: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return snapshot.Counts(), nil
+}
+
+// upsert 按主键插入或覆盖记录。按列写入而不直接创建模型，
+// 否则 gorm 会把 false、0 等零值替换为列的默认值
+func upsert(tx *gorm.DB, records interface{}) error {
+	rv := reflect.ValueOf(records)
+	if rv.Len() == 0 {
+		return nil
+	}
+	stmt := &gorm.Statement{DB: tx}
+	if err := stmt.Parse(records); err != nil {
+		return err
+	}
+
+	var columns []string
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName != "" && !field.PrimaryKey {
+			columns = append(columns, field.DBName)
+		}
+	}
+	rows := make([]map[string]interface{}, rv.Len())
+	for i := range rows {
+		item := rv.Index(i)
+		row := make(map[string]interface{}, len(columns)+1)
+		for _, field := range stmt.Schema.Fields {
+			if field.DBName != "" {
+				row[field.DBName], _ = field.ValueOf(tx.Statement.Context, item)
+			}
+		}
+		rows[i] = row
+	}
+
+	table := stmt.Schema.Table
+	if err := tx.Table(table).Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).CreateInBatches(rows, restoreBatchSize).Error; err != nil {
+		return err
+	}
+
+	// 显式写入 ID 不会推进自增序列，恢复到新数据库后需要调整，否则之后新建的记录会与恢复的记录冲突
+	if tx.Dialector.Name() == "postgres" {
+		return tx.Exec("SELECT setval(pg_get_serial_sequence(?, 'id'), (SELECT COALESCE(MAX(id), 0) + 1 FROM "+
+			tx.Statement.Quote(table)+"), false)", table).Error
+	}
+	return nil
+}
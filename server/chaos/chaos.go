@@ -0,0 +1,112 @@
+// Package chaos 故障注入，在负载下按比例丢弃信令、延迟中继写入、随机断开会话和让接口返回 500，
+// 用于验证重连、故障切换和会话恢复等容错功能。
+//
+// 只有使用 chaos 构建标签编译（go build -tags chaos）时才生效，正式构建中所有注入点都是空操作
+package chaos
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/senma231/p3/common/errors"
+)
+
+// AdminPath 故障注入管理接口的路径，该路径下的请求不会被注入错误，保证随时可以关闭故障
+const AdminPath = "/api/v1/chaos"
+
+// 配置的上限
+const (
+	maxRelayWriteDelay         = 60000 // 毫秒
+	defaultSessionKillInterval = 10    // 秒
+)
+
+// Faults 故障注入配置，比例为 0-100 的百分比，为 0 时不注入该故障
+type Faults struct {
+	SignalDropPercent   float64  `json:"signalDropPercent"`   // 丢弃服务端发出的信令的比例
+	RelayWriteDelay     int      `json:"relayWriteDelay"`     // 中继每次写入前的延迟，单位：毫秒
+	RelayWriteJitter    int      `json:"relayWriteJitter"`    // 在延迟上增加的随机抖动上限，单位：毫秒
+	SessionKillPercent  float64  `json:"sessionKillPercent"`  // 每轮随机断开的中继会话和信令连接的比例
+	SessionKillInterval int      `json:"sessionKillInterval"` // 随机断开会话的间隔，单位：秒，为 0 时使用 10 秒
+	HTTPErrorPercent    float64  `json:"httpErrorPercent"`    // 返回 500 的请求比例
+	HTTPErrorPaths      []string `json:"httpErrorPaths"`      // 返回 500 的接口路径前缀，为空时对所有接口生效
+}
+
+// Validate 检查故障注入配置
+func (f *Faults) Validate() error {
+	for name, percent := range map[string]float64{
+		"signalDropPercent":  f.SignalDropPercent,
+		"sessionKillPercent": f.SessionKillPercent,
+		"httpErrorPercent":   f.HTTPErrorPercent,
+	} {
+		if percent < 0 || percent > 100 {
+			return fmt.Errorf("%s 必须在 0-100 之间", name)
+		}
+	}
+	if f.RelayWriteDelay < 0 || f.RelayWriteDelay > maxRelayWriteDelay {
+		return fmt.Errorf("relayWriteDelay 必须在 0-%d 毫秒之间", maxRelayWriteDelay)
+	}
+	if f.RelayWriteJitter < 0 || f.RelayWriteJitter > maxRelayWriteDelay {
+		return fmt.Errorf("relayWriteJitter 必须在 0-%d 毫秒之间", maxRelayWriteDelay)
+	}
+	if f.SessionKillInterval < 0 {
+		return fmt.Errorf("sessionKillInterval 不能为负数")
+	}
+	for _, path := range f.HTTPErrorPaths {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("接口路径必须以 / 开头: %s", path)
+		}
+	}
+	return nil
+}
+
+// matchPath 请求路径是否在注入错误的范围内
+func (f *Faults) matchPath(path string) bool {
+	if strings.HasPrefix(path, AdminPath) {
+		return false
+	}
+	if len(f.HTTPErrorPaths) == 0 {
+		return true
+	}
+	for _, prefix := range f.HTTPErrorPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats 启用故障注入以来各类故障的注入次数
+type Stats struct {
+	SignalsDropped     uint64 `json:"signalsDropped"`
+	RelayWritesDelayed uint64 `json:"relayWritesDelayed"`
+	SessionsKilled     uint64 `json:"sessionsKilled"`
+	HTTPErrors         uint64 `json:"httpErrors"`
+}
+
+// SessionKiller 可以被随机断开的会话集合，如中继服务器和信令服务器
+type SessionKiller interface {
+	// KillSessions 对每个会话调用 pick，返回 true 时断开该会话，返回断开的会话数
+	KillSessions(pick func() bool) int
+}
+
+// Handler 按配置让部分请求直接返回 500。未启用故障注入时直接返回 next
+func Handler(next http.Handler) http.Handler {
+	if !Enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !FailRequest(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		errObj := errors.Internal("故障注入: 模拟的服务器错误")
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error": errObj.Message,
+			"code":  errObj.Code,
+		})
+	})
+}
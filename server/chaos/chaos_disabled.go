@@ -0,0 +1,51 @@
+//go:build !chaos
+
+package chaos
+
+import (
+	"context"
+	"errors"
+)
+
+// Enabled 是否编译了故障注入
+const Enabled = false
+
+// ErrDisabled 未使用 chaos 构建标签编译
+var ErrDisabled = errors.New("故障注入未启用，需要使用 -tags chaos 编译服务端")
+
+// Get 获取当前的故障注入配置和注入次数
+func Get() (Faults, Stats) {
+	return Faults{}, Stats{}
+}
+
+// Set 未启用故障注入时不能修改配置
+func Set(faults Faults) error {
+	return ErrDisabled
+}
+
+// Reset 关闭所有故障
+func Reset() {}
+
+// Register 未启用故障注入时不记录会话集合
+func Register(name string, killer SessionKiller) {}
+
+// Start 未启用故障注入时不启动断开会话的协程
+func Start() {}
+
+// Stop 停止断开会话的协程
+func Stop() {}
+
+// DropSignal 不丢弃信令
+func DropSignal() bool {
+	return false
+}
+
+// DelayRelayWrite 不延迟中继写入
+func DelayRelayWrite(ctx context.Context) bool {
+	return true
+}
+
+// FailRequest 不注入接口错误
+func FailRequest(path string) bool {
+	return false
+}
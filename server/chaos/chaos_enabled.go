@@ -0,0 +1,207 @@
+//go:build chaos
+
+package chaos
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+)
+
+// Enabled 是否编译了故障注入
+const Enabled = true
+
+// injector 全局的故障注入状态，注入点分散在信令、中继和 HTTP 服务器中
+var injector = struct {
+	mu       sync.RWMutex
+	faults   Faults
+	killers  map[string]SessionKiller
+	lastKill time.Time
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
+
+	signalsDropped     atomic.Uint64
+	relayWritesDelayed atomic.Uint64
+	sessionsKilled     atomic.Uint64
+	httpErrors         atomic.Uint64
+}{
+	killers: make(map[string]SessionKiller),
+}
+
+// hit 按百分比随机决定是否注入故障
+func hit(percent float64) bool {
+	return percent > 0 && rand.Float64()*100 < percent
+}
+
+// Get 获取当前的故障注入配置和注入次数
+func Get() (Faults, Stats) {
+	injector.mu.RLock()
+	faults := injector.faults
+	faults.HTTPErrorPaths = append([]string(nil), faults.HTTPErrorPaths...)
+	injector.mu.RUnlock()
+
+	return faults, Stats{
+		SignalsDropped:     injector.signalsDropped.Load(),
+		RelayWritesDelayed: injector.relayWritesDelayed.Load(),
+		SessionsKilled:     injector.sessionsKilled.Load(),
+		HTTPErrors:         injector.httpErrors.Load(),
+	}
+}
+
+// Set 替换故障注入配置，立即生效
+func Set(faults Faults) error {
+	if err := faults.Validate(); err != nil {
+		return err
+	}
+	faults.HTTPErrorPaths = append([]string(nil), faults.HTTPErrorPaths...)
+
+	injector.mu.Lock()
+	injector.faults = faults
+	injector.mu.Unlock()
+	return nil
+}
+
+// Reset 关闭所有故障并清零注入次数
+func Reset() {
+	injector.mu.Lock()
+	injector.faults = Faults{}
+	injector.lastKill = time.Time{}
+	injector.mu.Unlock()
+
+	injector.signalsDropped.Store(0)
+	injector.relayWritesDelayed.Store(0)
+	injector.sessionsKilled.Store(0)
+	injector.httpErrors.Store(0)
+}
+
+// Register 登记可以被随机断开的会话集合，同名的会话集合被替换
+func Register(name string, killer SessionKiller) {
+	injector.mu.Lock()
+	injector.killers[name] = killer
+	injector.mu.Unlock()
+}
+
+// Start 启动随机断开会话的协程
+func Start() {
+	injector.mu.Lock()
+	injector.stopCh = make(chan struct{})
+	injector.mu.Unlock()
+
+	injector.wg.Add(1)
+	go killLoop(injector.stopCh)
+	logger.Warn("服务端使用 chaos 构建标签编译，故障注入可以通过 %s 开启，不要用于生产环境", AdminPath)
+}
+
+// Stop 停止随机断开会话的协程
+func Stop() {
+	injector.mu.Lock()
+	stopCh := injector.stopCh
+	injector.stopCh = nil
+	injector.mu.Unlock()
+
+	if stopCh != nil {
+		close(stopCh)
+		injector.wg.Wait()
+	}
+}
+
+// killLoop 每秒检查一次，到达配置的间隔后随机断开会话。间隔修改后立即生效
+func killLoop(stopCh chan struct{}) {
+	defer injector.wg.Done()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case now := <-ticker.C:
+			killSessions(now)
+		}
+	}
+}
+
+// killSessions 到达间隔时按比例断开各会话集合中的会话
+func killSessions(now time.Time) {
+	injector.mu.Lock()
+	percent := injector.faults.SessionKillPercent
+	interval := time.Duration(injector.faults.SessionKillInterval) * time.Second
+	if interval == 0 {
+		interval = defaultSessionKillInterval * time.Second
+	}
+	if percent <= 0 || now.Sub(injector.lastKill) < interval {
+		injector.mu.Unlock()
+		return
+	}
+	injector.lastKill = now
+	killers := make(map[string]SessionKiller, len(injector.killers))
+	for name, killer := range injector.killers {
+		killers[name] = killer
+	}
+	injector.mu.Unlock()
+
+	pick := func() bool { return hit(percent) }
+	for name, killer := range killers {
+		if killed := killer.KillSessions(pick); killed > 0 {
+			injector.sessionsKilled.Add(uint64(killed))
+			logger.Warn("故障注入: 断开了 %d 个%s会话", killed, name)
+		}
+	}
+}
+
+// DropSignal 按比例决定是否丢弃一条信令
+func DropSignal() bool {
+	injector.mu.RLock()
+	percent := injector.faults.SignalDropPercent
+	injector.mu.RUnlock()
+
+	if !hit(percent) {
+		return false
+	}
+	injector.signalsDropped.Add(1)
+	return true
+}
+
+// DelayRelayWrite 在中继写入前等待配置的延迟，上下文取消时返回 false
+func DelayRelayWrite(ctx context.Context) bool {
+	injector.mu.RLock()
+	delay := time.Duration(injector.faults.RelayWriteDelay) * time.Millisecond
+	jitter := injector.faults.RelayWriteJitter
+	injector.mu.RUnlock()
+
+	if jitter > 0 {
+		delay += time.Duration(rand.Intn(jitter+1)) * time.Millisecond
+	}
+	if delay <= 0 {
+		return true
+	}
+	injector.relayWritesDelayed.Add(1)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// FailRequest 按比例决定是否让请求返回 500，管理接口本身不受影响
+func FailRequest(path string) bool {
+	injector.mu.RLock()
+	percent := injector.faults.HTTPErrorPercent
+	match := percent > 0 && injector.faults.matchPath(path)
+	injector.mu.RUnlock()
+
+	if !match || !hit(percent) {
+		return false
+	}
+	injector.httpErrors.Add(1)
+	return true
+}
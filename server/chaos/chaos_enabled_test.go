@@ -0,0 +1,111 @@
+//go:build chaos
+
+package chaos
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeKiller struct {
+	sessions int
+	calls    int
+}
+
+func (k *fakeKiller) KillSessions(pick func() bool) int {
+	k.calls++
+	killed := 0
+	for i := 0; i < k.sessions; i++ {
+		if pick() {
+			killed++
+		}
+	}
+	k.sessions -= killed
+	return killed
+}
+
+func TestInjection(t *testing.T) {
+	defer Reset()
+
+	if err := Set(Faults{SignalDropPercent: 101}); err == nil {
+		t.Fatal("无效配置应返回错误")
+	}
+	if DropSignal() || FailRequest("/api/v1/devices") {
+		t.Fatal("未配置故障时不应注入")
+	}
+
+	if err := Set(Faults{SignalDropPercent: 100, RelayWriteDelay: 20, HTTPErrorPercent: 100, HTTPErrorPaths: []string{"/api/v1/devices"}}); err != nil {
+		t.Fatalf("设置故障失败: %v", err)
+	}
+	if !DropSignal() {
+		t.Error("比例为 100 时应丢弃信令")
+	}
+
+	start := time.Now()
+	if !DelayRelayWrite(context.Background()) || time.Since(start) < 20*time.Millisecond {
+		t.Error("中继写入应被延迟")
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if DelayRelayWrite(ctx) {
+		t.Error("上下文取消后应返回 false")
+	}
+
+	handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for path, want := range map[string]int{
+		"/api/v1/devices/1": http.StatusInternalServerError,
+		"/api/v1/apps":      http.StatusNoContent,
+		AdminPath:           http.StatusNoContent,
+	} {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("%s 返回 %d，期望 %d", path, rec.Code, want)
+		}
+	}
+
+	_, stats := Get()
+	if stats.SignalsDropped != 1 || stats.RelayWritesDelayed != 2 || stats.HTTPErrors != 1 {
+		t.Errorf("注入次数错误: %+v", stats)
+	}
+}
+
+func TestKillSessions(t *testing.T) {
+	defer Reset()
+	killer := &fakeKiller{sessions: 5}
+	Register("测试", killer)
+	defer func() {
+		injector.mu.Lock()
+		delete(injector.killers, "测试")
+		injector.mu.Unlock()
+	}()
+
+	now := time.Now()
+	killSessions(now)
+	if killer.calls != 0 {
+		t.Fatal("未配置故障时不应断开会话")
+	}
+
+	Set(Faults{SessionKillPercent: 100, SessionKillInterval: 30})
+	killSessions(now)
+	if killer.sessions != 0 {
+		t.Fatalf("比例为 100 时应断开所有会话，剩余 %d", killer.sessions)
+	}
+	killSessions(now.Add(10 * time.Second))
+	if killer.calls != 1 {
+		t.Fatal("未到间隔时不应再次断开会话")
+	}
+	killSessions(now.Add(30 * time.Second))
+	if killer.calls != 2 {
+		t.Fatal("到达间隔后应再次断开会话")
+	}
+
+	if _, stats := Get(); stats.SessionsKilled != 5 {
+		t.Errorf("断开的会话数错误: %d", stats.SessionsKilled)
+	}
+}
@@ -0,0 +1,38 @@
+package chaos
+
+import "testing"
+
+func TestFaultsValidate(t *testing.T) {
+	valid := Faults{SignalDropPercent: 20, RelayWriteDelay: 200, RelayWriteJitter: 50, SessionKillPercent: 5, HTTPErrorPercent: 100, HTTPErrorPaths: []string{"/api/v1/devices"}}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("有效配置校验失败: %v", err)
+	}
+
+	for _, faults := range []Faults{
+		{SignalDropPercent: 101},
+		{HTTPErrorPercent: -1},
+		{RelayWriteDelay: maxRelayWriteDelay + 1},
+		{RelayWriteJitter: -5},
+		{SessionKillInterval: -1},
+		{HTTPErrorPercent: 10, HTTPErrorPaths: []string{"api/v1/devices"}},
+	} {
+		if err := faults.Validate(); err == nil {
+			t.Errorf("无效配置应校验失败: %+v", faults)
+		}
+	}
+}
+
+func TestFaultsMatchPath(t *testing.T) {
+	all := Faults{HTTPErrorPercent: 100}
+	if !all.matchPath("/api/v1/devices") {
+		t.Error("未指定路径时应对所有接口生效")
+	}
+	if all.matchPath(AdminPath) {
+		t.Error("管理接口不应被注入错误")
+	}
+
+	selected := Faults{HTTPErrorPercent: 100, HTTPErrorPaths: []string{"/api/v1/auth/refresh", "/api/v1/relays"}}
+	if !selected.matchPath("/api/v1/relays/1") || selected.matchPath("/api/v1/devices") {
+		t.Error("只应对指定的路径前缀生效")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/senma231/p3/server/backup"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"gorm.io/gorm"
+)
+
+// passphraseEnv 未指定口令文件时读取口令的环境变量
+const passphraseEnv = "P3_BACKUP_PASSPHRASE"
+
+// readPassphrase 从文件或环境变量读取备份口令，不通过命令行参数传递，避免出现在进程列表中
+func readPassphrase(path string) (string, error) {
+	if path == "" {
+		passphrase := os.Getenv(passphraseEnv)
+		if passphrase == "" {
+			return "", fmt.Errorf("需要通过 -passphrase-file 或环境变量 %s 提供备份口令", passphraseEnv)
+		}
+		return passphrase, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("读取口令文件失败: %w", err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// openDB 加载配置并连接数据库。命令行工具不输出每条 SQL，避免密码哈希等内容出现在终端中
+func openDB(configPath string) (*gorm.DB, error) {
+	cfg, err := config.LoadConfig(configPath)
+	if err != nil {
+		return nil, fmt.Errorf("加载配置失败: %w", err)
+	}
+	cfg.Log.Level = "warn"
+	return db.Open(cfg)
+}
+
+// runBackup 执行 backup 子命令：读取数据库的一致快照，连同配置文件加密后写入备份文件
+func runBackup(args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "配置文件路径")
+	output := fs.String("o", "", "备份文件路径，默认为当前目录下的 p3-backup-<时间>.p3bak")
+	passphraseFile := fs.String("passphrase-file", "", "保存备份口令的文件，未指定时使用环境变量 "+passphraseEnv)
+	noConfig := fs.Bool("no-config", false, "备份中不包含配置文件")
+	fs.Parse(args)
+
+	passphrase, err := readPassphrase(*passphraseFile)
+	if err != nil {
+		return err
+	}
+	if len(passphrase) < backup.MinPassphraseLength {
+		return fmt.Errorf("口令至少需要 %d 个字符", backup.MinPassphraseLength)
+	}
+
+	gdb, err := openDB(*configPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close(gdb)
+
+	snapshot, err := backup.Dump(context.Background(), gdb)
+	if err != nil {
+		return err
+	}
+	if !*noConfig {
+		if snapshot.Config, err = os.ReadFile(*configPath); err != nil {
+			return fmt.Errorf("读取配置文件失败: %w", err)
+		}
+	}
+
+	path := *output
+	if path == "" {
+		path = backup.FileName(snapshot.CreatedAt)
+	}
+	// 先写入临时文件，加密失败时不会留下不完整的备份
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".p3bak-*")
+	if err != nil {
+		return fmt.Errorf("创建备份文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	err = backup.Encode(tmp, snapshot, passphrase)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("写入备份文件失败: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("保存备份文件失败: %w", err)
+	}
+
+	fmt.Printf("备份已保存到 %s\n%s\n", path, snapshot.Counts())
+	return nil
+}
+
+// runRestore 执行 restore 子命令：解密备份文件，将全部或指定用户的资源写回数据库，可选地恢复配置文件
+func runRestore(args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	configPath := fs.String("config", "config.yaml", "配置文件路径，使用其中的数据库连接")
+	passphraseFile := fs.String("passphrase-file", "", "保存备份口令的文件，未指定时使用环境变量 "+passphraseEnv)
+	users := fs.String("users", "", "只恢复这些用户（用户名，逗号分隔）及其设备、应用、转发规则等资源")
+	restoreConfig := fs.String("restore-config", "", "将备份中的配置文件写入该路径，文件已存在时不覆盖")
+	dryRun := fs.Bool("dry-run", false, "只列出将要恢复的资源，不修改数据库")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "用法: p3-server restore [参数] <备份文件>\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	passphrase, err := readPassphrase(*passphraseFile)
+	if err != nil {
+		return err
+	}
+	file, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("打开备份文件失败: %w", err)
+	}
+	snapshot, err := backup.Decode(file, passphrase)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("备份创建于 %s，服务端版本 %s\n", snapshot.CreatedAt.Local().Format("2006-01-02 15:04:05"), snapshot.ServerVersion)
+
+	configData := snapshot.Config
+	if *users != "" {
+		if snapshot, err = snapshot.Select(strings.Split(*users, ",")); err != nil {
+			return err
+		}
+	}
+	if *dryRun {
+		fmt.Printf("将恢复: %s\n", snapshot.Counts())
+		return nil
+	}
+
+	if *restoreConfig != "" {
+		if len(configData) == 0 {
+			return fmt.Errorf("备份中不包含配置文件")
+		}
+		f, err := os.OpenFile(*restoreConfig, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if err != nil {
+			return fmt.Errorf("写入配置文件失败: %w", err)
+		}
+		_, err = f.Write(configData)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("写入配置文件失败: %w", err)
+		}
+		fmt.Printf("配置文件已写入 %s\n", *restoreConfig)
+	}
+
+	gdb, err := openDB(*configPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close(gdb)
+
+	counts, err := backup.Restore(context.Background(), gdb, snapshot)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已恢复: %s\n", counts)
+	return nil
+}
@@ -1,32 +1,73 @@
 ackage main
 
 import (
-	"context"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/senma231/p3/common/i18n"
+	"github.com/senma231/p3/common/lifecycle"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/common/version"
+	"github.com/senma231/p3/server/abuse"
+	"github.com/senma231/p3/server/alert"
 	"github.com/senma231/p3/server/api"
 	"github.com/senma231/p3/server/app"
 	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/chaos"
 	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/dashboard"
 	"github.com/senma231/p3/server/db"
 	"github.com/senma231/p3/server/device"
+	"github.com/senma231/p3/server/diagnostics"
+	"github.com/senma231/p3/server/export"
+	"github.com/senma231/p3/server/fleet"
 	"github.com/senma231/p3/server/forward"
+	"github.com/senma231/p3/server/notify"
+	"github.com/senma231/p3/server/objstore"
 	"github.com/senma231/p3/server/p2p"
+	"github.com/senma231/p3/server/pki"
+	"github.com/senma231/p3/server/portscan"
+	"github.com/senma231/p3/server/probe"
+	"github.com/senma231/p3/server/relay"
+	"github.com/senma231/p3/server/speedtest"
+	"github.com/senma231/p3/server/status"
+	"github.com/senma231/p3/server/store"
+	"gorm.io/gorm"
 )
 
 func main() {
+	// backup 和 restore 子命令只访问数据库，不启动服务
+	if len(os.Args) > 1 && (os.Args[1] == "backup" || os.Args[1] == "restore") {
+		run := runBackup
+		if os.Args[1] == "restore" {
+			run = runRestore
+		}
+		if err := run(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "p3-server %s: %v\n", os.Args[1], err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 解析命令行参数
 	configPath := flag.String("config", "config.yaml", "配置文件路径")
 	logLevel := flag.String("log-level", "info", "日志级别 (debug, info, warn, error)")
+	showVersion := flag.Bool("version", false, "显示版本信息")
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println(version.Get())
+		return
+	}
+
 	// 设置日志级别
 	switch *logLevel {
 	case "debug":
@@ -49,78 +90,392 @@ func main() {
 		log.Fatalf("加载配置失败: %v", err)
 	}
 
+	// 设置日志级别和语言，配置已校验过模块的日志级别
+	logger.SetLevel(logger.ParseLevel(cfg.Log.Level))
+	moduleLevels, _ := logger.ParseModuleLevels(cfg.Log.Modules)
+	logger.SetModuleLevels(moduleLevels)
+	logger.SetTranslator(i18n.LogTranslator(cfg.Log.Language))
+
 	// 打印启动信息
 	log.Println("P3 服务端启动中...")
-	log.Printf("版本: %s", cfg.Version)
+	log.Printf("版本: %s", version.Get())
 	log.Printf("监听端口: %d", cfg.Server.Port)
 
+	// 组件按启动顺序记录，退出时按相反顺序停止：先停止对外服务，最后关闭数据库
+	runner := lifecycle.New(10 * time.Second)
+	mustStart := func(c lifecycle.Component) {
+		if err := runner.Start(c); err != nil {
+			runner.Stop()
+			log.Fatalf("%v", err)
+		}
+	}
+
 	// 初始化数据库连接
-	if err := db.InitDB(cfg); err != nil {
-		log.Fatalf("初始化数据库失败: %v", err)
+	var gdb *gorm.DB
+	mustStart(lifecycle.Component{
+		Name: "数据库",
+		Start: func() error {
+			var err error
+			gdb, err = db.Open(cfg)
+			return err
+		},
+		Stop: lifecycle.StopErrFunc(func() error { return db.Close(gdb) }),
+	})
+
+	// 初始化存储，服务通过仓库接口访问数据
+	st := store.NewGormStore(gdb)
+	if cfg.Database.CacheTTL > 0 {
+		st.Devices = store.NewCachedDeviceRepo(st.Devices, time.Duration(cfg.Database.CacheTTL)*time.Second)
 	}
-	defer db.CloseDB()
 
 	// 初始化服务
-	authService := auth.NewService(cfg)
-	deviceService := device.NewService(cfg)
-	appService := app.NewService(cfg)
-	forwardService := forward.NewService()
+	hasher, err := auth.NewPasswordHasher(cfg.Security.PasswordHash)
+	if err != nil {
+		runner.Stop()
+		log.Fatalf("初始化密码哈希失败: %v", err)
+	}
+	authService := auth.NewService(cfg, st, hasher)
+	if cfg.Notify.SMTP.Host != "" {
+		authService.SetMailer(notify.NewEmailNotifier(&cfg.Notify.SMTP))
+	}
+	// 企业部署可以使用 LDAP / Active Directory 账户登录控制台
+	if cfg.Auth.LDAP.Enabled {
+		ldapProvider, err := auth.NewLDAPProvider(cfg.Auth.LDAP)
+		if err != nil {
+			runner.Stop()
+			log.Fatalf("初始化 LDAP 认证失败: %v", err)
+		}
+		authService.AddProvider(ldapProvider)
+		mustStart(lifecycle.Component{Name: "LDAP 连接池", Stop: lifecycle.StopFunc(ldapProvider.Close)})
+	}
+	deviceService := device.NewService(cfg, st)
+
+	// 内置 CA 在首次启动时生成，为设备签发证书
+	var certService *pki.Service
+	if certCfg := cfg.Security.DeviceCertificates; certCfg.Enabled {
+		ca, err := pki.LoadOrCreateAuthority(certCfg.CAFile, certCfg.CAKeyFile)
+		if err != nil {
+			runner.Stop()
+			log.Fatalf("初始化设备证书 CA 失败: %v", err)
+		}
+		certService = pki.NewService(ca, st, time.Duration(certCfg.Validity)*24*time.Hour)
+		deviceService.SetCertificateAuthenticator(certService)
+	}
+	appService := app.NewService(cfg, st)
+	forwardService := forward.NewService(st)
 
 	// 初始化 P2P 协调器
-	coordinator := p2p.NewCoordinator(cfg, deviceService)
+	coordinator := p2p.NewCoordinator(cfg, deviceService, st.Connections)
+	punchMatrix := p2p.NewPunchMatrix(cfg.P2P.PunchStats, st.Punches)
+	coordinator.SetPunchMatrix(punchMatrix)
+
+	// 加载中继封禁，信令服务器在设备连接时、中继在建立会话时检查
+	bans := abuse.NewBanList(st.Bans)
+	if err := bans.Load(); err != nil {
+		runner.Stop()
+		log.Fatalf("加载中继封禁失败: %v", err)
+	}
+	abuseService := abuse.NewService(st.Reports, bans)
 
 	// 初始化中继服务器
+	// 中继和 TURN 启动失败时其他功能仍可使用，由服务状态反映
 	relayServer := p2p.NewRelayServer(cfg, coordinator)
-	if err := relayServer.Start(); err != nil {
-		log.Printf("启动中继服务器失败: %v", err)
-	}
+	// 分配到独立中继的会话同样按内置中继的加密策略检查
+	coordinator.SetRelayEncryption(relayServer.Encryption())
+	relayServer.SetBanList(bans)
+	mustStart(lifecycle.Component{
+		Name:     "中继服务器",
+		Start:    relayServer.Start,
+		Stop:     lifecycle.StopErrFunc(relayServer.Stop),
+		Optional: true,
+	})
+
+	// 初始化 TURN 服务器，同一端口同时提供内置 STUN 服务
+	turnServer := relay.NewTURNServer(cfg.TURN.Address, cfg.TURN.Realm, cfg.TURN.AuthSecret)
+	mustStart(lifecycle.Component{
+		Name:     "TURN 服务器",
+		Start:    turnServer.Start,
+		Stop:     lifecycle.StopErrFunc(turnServer.Stop),
+		Optional: true,
+	})
+
+	// 初始化回显探测服务，客户端的 nat-report 命令据此检查 NAT 的映射和过滤行为
+	probeServer := probe.NewServer(cfg.Server.Host, cfg.P2P.UDPPort1, cfg.P2P.UDPPort2, cfg.P2P.TCPPort)
+	mustStart(lifecycle.Component{
+		Name:     "回显探测服务",
+		Start:    probeServer.Start,
+		Stop:     lifecycle.StopFunc(probeServer.Stop),
+		Optional: true,
+	})
 
 	// 初始化信令服务器
 	signalingServer := p2p.NewSignalingServer(cfg, coordinator, authService, deviceService)
-	signalingServer.Start()
+	signalingServer.SetBanList(bans)
+	mustStart(lifecycle.Component{
+		Name:  "信令服务器",
+		Start: lifecycle.StartFunc(signalingServer.Start),
+		Stop:  lifecycle.StopFunc(signalingServer.Stop),
+	})
+
+	// 使用 chaos 构建标签编译时启动故障注入，可以随机断开中继会话和信令连接
+	if chaos.Enabled {
+		chaos.Register("中继", relayServer)
+		chaos.Register("信令", signalingServer)
+		mustStart(lifecycle.Component{
+			Name:  "故障注入",
+			Start: lifecycle.StartFunc(chaos.Start),
+			Stop:  lifecycle.StopFunc(chaos.Stop),
+		})
+	}
+
+	// 独立中继维护或负载过高时通过信令通知源节点迁移会话
+	coordinator.SetRelayMigrator(signalingServer.MigrateRelaySessions)
+
+	// 新增封禁时立即断开被封禁对象已有的信令连接和中继会话
+	bans.OnBan(func(ban *db.RelayBan) {
+		disconnected := signalingServer.DisconnectBanned()
+		closed := relayServer.CloseBanned()
+		if disconnected > 0 || closed > 0 {
+			log.Printf("封禁 %s %s 后断开 %d 个信令连接，关闭 %d 个中继会话", ban.Kind, ban.Value, disconnected, closed)
+		}
+	})
+
+	// 中继限速时通过信令通知源节点
+	relayServer.SetThrottleNotifier(func(nodeID string, notice *p2p.RelayThrottleNotice) {
+		if err := signalingServer.SendToNode(nodeID, &protocol.Signal{
+			Type:    protocol.SignalRelayThrottled,
+			Payload: notice,
+		}); err != nil {
+			log.Printf("发送中继限速通知失败: %v", err)
+		}
+	})
+
+	// 中继会话超出限制时通知源节点，并记录为设备事件供告警规则统计
+	relayServer.SetLimitHandler(func(event *p2p.RelayLimitEvent) {
+		if err := signalingServer.SendToNode(event.NodeID, &protocol.Signal{
+			Type:    protocol.SignalRelayLimited,
+			Payload: event,
+		}); err != nil {
+			log.Printf("发送中继会话限制通知失败: %v", err)
+		}
+		go func() {
+			detail := fmt.Sprintf("%s 超出限制 %d，目标节点 %s", event.Limit, event.Value, event.TargetID)
+			if _, err := deviceService.RecordEvents(event.DeviceID, []device.EventRequest{{
+				Type:       db.EventRelayLimited,
+				Detail:     detail,
+				OccurredAt: event.OccurredAt,
+			}}); err != nil {
+				log.Printf("记录中继会话限制事件失败: %v", err)
+			}
+		}()
+	})
+
+	// 初始化测速调度器
+	speedTestScheduler := speedtest.NewScheduler(speedtest.NewService(st), func(nodeID string, task *speedtest.Task) error {
+		return signalingServer.SendToNode(nodeID, &protocol.Signal{
+			Type:    protocol.SignalSpeedTest,
+			Payload: task,
+		})
+	})
+	mustStart(lifecycle.Component{
+		Name:  "测速调度器",
+		Start: lifecycle.StartFunc(speedTestScheduler.Start),
+		Stop:  lifecycle.StopFunc(speedTestScheduler.Stop),
+	})
+
+	// 初始化设备批量操作，指令通过信令下发
+	fleetManager := fleet.NewManager(func(nodeID string, cmd *fleet.Command) error {
+		return signalingServer.SendToNode(nodeID, &protocol.Signal{
+			Type:    protocol.SignalFleetCommand,
+			Payload: cmd,
+		})
+	})
+	mustStart(lifecycle.Component{
+		Name:  "设备批量操作",
+		Start: lifecycle.StartFunc(fleetManager.Start),
+		Stop:  lifecycle.StopFunc(fleetManager.Stop),
+	})
+
+	// 初始化灰度发布控制器，按阶段通过批量操作下发
+	rolloutManager := fleet.NewRolloutManager(fleetManager)
+	mustStart(lifecycle.Component{
+		Name:  "灰度发布",
+		Start: lifecycle.StartFunc(rolloutManager.Start),
+		Stop:  lifecycle.StopFunc(rolloutManager.Stop),
+	})
+
+	// 初始化设备端口扫描，扫描任务通过信令下发
+	portScanManager := portscan.NewManager(func(nodeID string, task *portscan.Task) error {
+		return signalingServer.SendToNode(nodeID, &protocol.Signal{
+			Type:    protocol.SignalPortScan,
+			Payload: task,
+		})
+	})
+	mustStart(lifecycle.Component{
+		Name:  "设备端口扫描",
+		Start: lifecycle.StartFunc(portScanManager.Start),
+		Stop:  lifecycle.StopFunc(portScanManager.Stop),
+	})
+
+	// 初始化告警规则引擎
+	notifier := notify.NewManager(&cfg.Notify)
+	alertEngine := alert.NewEngine(notifier, st, time.Duration(cfg.Alert.EvaluateInterval)*time.Second)
+	alertEngine.SetBanList(bans)
+	mustStart(lifecycle.Component{
+		Name:  "告警规则引擎",
+		Start: lifecycle.StartFunc(alertEngine.Start),
+		Stop:  lifecycle.StopFunc(alertEngine.Stop),
+	})
+
+	// 初始化对象存储，保存导出文件和设备诊断包，按保留时间清理过期对象
+	objects, err := objstore.New(cfg.Storage, cfg.JWT.Secret)
+	if err != nil {
+		runner.Stop()
+		log.Fatalf("初始化对象存储失败: %v", err)
+	}
+	urlExpiry := time.Duration(cfg.Storage.URLExpiry) * time.Second
+	janitor := objstore.NewJanitor(objects, []objstore.Rule{
+		{Prefix: export.KeyPrefix, MaxAge: time.Duration(cfg.Storage.Retention.Exports) * time.Hour},
+		{Prefix: diagnostics.KeyPrefix, MaxAge: time.Duration(cfg.Storage.Retention.Diagnostics) * time.Hour},
+	})
+	mustStart(lifecycle.Component{
+		Name:  "过期对象清理",
+		Start: lifecycle.StartFunc(janitor.Start),
+		Stop:  lifecycle.StopFunc(janitor.Stop),
+	})
+	exportJobs := export.NewJobManager(objects, urlExpiry)
+	mustStart(lifecycle.Component{
+		Name:  "数据导出",
+		Start: lifecycle.StartFunc(exportJobs.Start),
+		Stop:  lifecycle.StopFunc(exportJobs.Stop),
+	})
+	diagnosticsService := diagnostics.NewService(objects, int64(cfg.Storage.MaxDiagnosticSize)<<20, urlExpiry)
+
+	// 初始化服务状态，汇总各组件健康状态并管理维护窗口
+	statusService := status.NewService()
+	statusService.AddComponent("database", func() error { return db.Ping(gdb) })
+	statusService.AddComponent("relay", func() error {
+		if !relayServer.IsRunning() {
+			return fmt.Errorf("中继服务器未运行")
+		}
+		return nil
+	})
 
 	// 设置路由
-	router := api.SetupRouter(authService, deviceService, appService, forwardService)
+	router := api.SetupRouter(cfg, st, authService, deviceService, appService, forwardService, statusService)
 
 	// 注册信令服务路由
 	signalingServer.RegisterRoutes(router.Group("/api/v1"))
+	api.RegisterSignalingRoutes(router, authService, signalingServer)
+
+	// 注册中继管理路由
+	api.RegisterRelayRoutes(router, authService, coordinator, relayServer)
+
+	// 注册中继封禁和滥用举报路由
+	api.RegisterAbuseRoutes(router, authService, abuseService)
+
+	// 注册独立中继的注册、心跳和配对指令路由
+	coordinator.RegisterRelayAgentRoutes(router.Group("/api/v1"))
+
+	// 注册 TURN 凭据路由
+	api.RegisterTURNRoutes(router, deviceService, &cfg.TURN)
+
+	// 注册回显探测路由
+	api.RegisterProbeRoutes(router, probeServer)
+
+	// 注册服务状态和维护管理路由
+	api.RegisterStatusRoutes(router, authService, statusService)
+
+	// 注册客户端版本管理路由
+	api.RegisterClientVersionRoutes(router, authService, deviceService, &cfg.Client)
+
+	// 注册运行时日志级别路由
+	api.RegisterLogLevelRoutes(router, authService)
+
+	// 注册数据导出和设备诊断包路由，使用本地对象存储时由服务端验证签名下载链接
+	api.RegisterExportRoutes(router, authService, st, exportJobs)
+	api.RegisterDiagnosticsRoutes(router, authService, deviceService, diagnosticsService)
+	if local, ok := objects.(*objstore.LocalStore); ok {
+		api.RegisterObjectRoutes(router, local)
+	}
+
+	// 注册备份和恢复路由
+	api.RegisterBackupRoutes(router, authService, gdb, *configPath)
+
+	// 注册故障注入路由，只在使用 chaos 构建标签编译时注册
+	api.RegisterChaosRoutes(router, authService)
+
+	// 注册保存的设备筛选条件路由
+	api.RegisterDeviceFilterRoutes(router, authService, deviceService)
+
+	// 注册对等节点别名路由
+	api.RegisterAliasRoutes(router, authService, deviceService)
+
+	// 注册设备观察链接路由
+	api.RegisterObserverRoutes(router, authService, deviceService, &cfg.Auth.Observer)
+
+	// 注册设备批量操作和灰度发布路由
+	api.RegisterFleetRoutes(router, authService, deviceService, fleetManager, rolloutManager)
+
+	// 注册设备端口扫描路由
+	api.RegisterPortScanRoutes(router, authService, deviceService, portScanManager)
+
+	// 注册设备证书申请、吊销和吊销状态查询路由
+	if certService != nil {
+		api.RegisterCertificateRoutes(router, authService, deviceService, certService)
+	}
+
+	// 注册管理员运营看板路由
+	api.RegisterDashboardRoutes(router, authService, dashboard.NewService(st, relayServer))
+
+	// 注册打洞统计路由
+	api.RegisterPunchStatsRoutes(router, authService, cfg, punchMatrix)
 
 	// 创建 HTTP 服务器
 	server := &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
-		Handler: router,
+		Handler: chaos.Handler(router),
 	}
 
-	// 启动 HTTP 服务器
-	go func() {
-		log.Printf("HTTP 服务器已启动，监听地址: %s", server.Addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("启动 HTTP 服务器失败: %v", err)
-		}
-	}()
+	// 启动 HTTP 服务器，退出时最先停止，不再接受新的请求
+	mustStart(lifecycle.Component{
+		Name: "HTTP 服务器",
+		Start: func() error {
+			listener, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				return err
+			}
+			log.Printf("HTTP 服务器已启动，监听地址: %s", server.Addr)
+			go func() {
+				if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+					runner.Fail("HTTP 服务器", err)
+				}
+			}()
+			return nil
+		},
+		Stop:    server.Shutdown,
+		Timeout: 5 * time.Second,
+	})
 
-	// 等待中断信号
+	// 等待中断信号，或组件运行失败
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	exitCode := 0
+	select {
+	case <-quit:
+	case <-runner.Failed():
+		exitCode = 1
+	}
 
 	// 优雅关闭
 	log.Println("正在关闭服务...")
-
-	// 停止信令服务器
-	signalingServer.Stop()
-
-	// 停止中继服务器
-	if err := relayServer.Stop(); err != nil {
-		log.Printf("停止中继服务器失败: %v", err)
-	}
-
-	// 关闭 HTTP 服务器
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	if err := server.Shutdown(ctx); err != nil {
-		log.Fatalf("关闭 HTTP 服务器失败: %v", err)
+	if err := runner.Stop(); err != nil {
+		log.Printf("关闭服务时出错: %v", err)
+		exitCode = 1
 	}
 
 	log.Println("服务已关闭")
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/senma231/p3/common/i18n"
+	"github.com/senma231/p3/common/lifecycle"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/version"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/p2p"
+)
+
+func main() {
+	// 解析命令行参数
+	configPath := flag.String("config", "relay.yaml", "配置文件路径")
+	showVersion := flag.Bool("version", false, "显示版本信息")
+	flag.Parse()
+
+	if *showVersion {
+		fmt.Println(version.Get())
+		return
+	}
+
+	// 加载配置，独立中继只使用 relay 和 log 配置
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("加载配置失败: %v", err)
+	}
+	if err := config.ValidateRelayAgent(cfg); err != nil {
+		log.Fatalf("独立中继配置无效: %v", err)
+	}
+
+	// 设置日志语言
+	logger.SetTranslator(i18n.LogTranslator(cfg.Log.Language))
+
+	log.Println("P3 独立中继启动中...")
+	log.Printf("版本: %s", version.Get())
+	log.Printf("中继 ID: %s", cfg.Relay.Agent.ID)
+	log.Printf("主服务器: %s", cfg.Relay.Agent.ServerURL)
+
+	// 启动中继服务并向主服务器注册
+	runner := lifecycle.New(10 * time.Second)
+	agent := p2p.NewRelayAgent(cfg)
+	if err := runner.Start(lifecycle.Component{
+		Name:  "独立中继",
+		Start: agent.Start,
+		Stop:  lifecycle.StopErrFunc(agent.Stop),
+	}); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	// 等待中断信号
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("正在关闭独立中继...")
+	if err := runner.Stop(); err != nil {
+		log.Printf("关闭独立中继时出错: %v", err)
+		os.Exit(1)
+	}
+	log.Println("独立中继已关闭")
+}
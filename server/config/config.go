@@ -3,10 +3,17 @@ package config
 import (
 	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
 
+	"github.com/senma231/p3/common/cors"
+	"github.com/senma231/p3/common/i18n"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/server/sanitize"
 	"gopkg.in/yaml.v3"
 )
 
@@ -14,6 +21,9 @@ import (
 type ServerConfig struct {
 	Host string `yaml:"host"`
 	Port int    `yaml:"port"`
+	// 受信任的反向代理地址或网段，只有来自这些地址的请求才按 X-Forwarded-For 和 X-Real-IP 确定客户端地址，
+	// 为空时总是使用连接的对端地址
+	TrustedProxies []string `yaml:"trustedProxies"`
 }
 
 // DatabaseConfig 数据库配置
@@ -25,6 +35,12 @@ type DatabaseConfig struct {
 	Password string `yaml:"password"`
 	DBName   string `yaml:"dbname"`
 	SSLMode  string `yaml:"sslmode"`
+	// 只读副本的连接字符串，列表和统计等只读查询发往副本，写入和事务使用主库
+	Replicas []string `yaml:"replicas"`
+	// 副本允许的最大复制延迟（秒），延迟更大的副本暂停使用，该时间内写入过的表从主库读取
+	ReplicaMaxLag int `yaml:"replicaMaxLag"`
+	// 设备查询缓存的有效期（秒），减少心跳和信令连接对数据库的查询，0 表示不缓存
+	CacheTTL int `yaml:"cacheTTL"`
 }
 
 // RedisConfig Redis 配置
@@ -43,42 +59,323 @@ type JWTConfig struct {
 
 // P2PConfig P2P 配置
 type P2PConfig struct {
+	// 回显探测服务的 UDP 端口，客户端向 UDPPort1 发送探测请求，UDPPort2 作为备用端口发送响应
 	UDPPort1 int `yaml:"udpPort1"`
 	UDPPort2 int `yaml:"udpPort2"`
-	TCPPort  int `yaml:"tcpPort"`
+	// 回显探测服务的 TCP 端口，客户端的连通性自检据此检查 TCP 出站连通性
+	TCPPort int `yaml:"tcpPort"`
+	// 与客户端协商 WebSocket 信令的 permessage-deflate 压缩，超过阈值的帧压缩后发送
+	SignalingCompression bool `yaml:"signalingCompression"`
+	// 同一节点已有信令连接时再次连接的处理方式：takeover 由新连接接管并关闭旧连接，
+	// reject 拒绝新连接直到旧连接断开或超时
+	DuplicateNode string `yaml:"duplicateNode"`
+	// 按设备上报的打洞结果统计调整打洞判断
+	PunchStats PunchStatsConfig `yaml:"punchStats"`
+	// 下发给客户端的默认连接优先级，客户端未在本地配置 strategy.priority 时按该顺序尝试连接方式
+	CandidatePriority []string `yaml:"candidatePriority"`
+	// 下发给客户端的 STUN 服务器列表，替换客户端本地配置的列表，为空时客户端使用本地配置
+	STUNServers []string `yaml:"stunServers"`
+	// 每个节点发送信令的速率限制
+	SignalingLimits SignalingLimitsConfig `yaml:"signalingLimits"`
+}
+
+// SignalingLimitsConfig 节点发送信令的速率限制，按令牌桶计算，每条信令消耗一个令牌。
+// 超出限制的信令被丢弃，并回复错误信令告知节点
+type SignalingLimitsConfig struct {
+	Rate  float64 `yaml:"rate" json:"rate"`   // 所有类型的信令合计，单位：条/秒，0 表示不限制
+	Burst int     `yaml:"burst" json:"burst"` // 允许的突发条数，0 表示一秒的条数
+	// 按信令类型（如 connect、offer）的限制，同一条信令需要同时满足合计和所属类型的限制
+	Types map[string]SignalRateLimit `yaml:"types" json:"types"`
+}
+
+// SignalRateLimit 单个信令类型的速率限制
+type SignalRateLimit struct {
+	Rate  float64 `yaml:"rate" json:"rate"`   // 单位：条/秒，0 表示不限制
+	Burst int     `yaml:"burst" json:"burst"` // 允许的突发条数，0 表示一秒的条数
+}
+
+// PunchStatsConfig 打洞结果统计配置。设备按 NAT 类型组合汇总上报打洞的尝试和成功次数，
+// 样本足够的组合按实际成功率判断能否打洞，其余组合使用内置规则
+type PunchStatsConfig struct {
+	Adaptive       bool    `yaml:"adaptive"`       // 按统计调整打洞判断，关闭时只收集统计
+	MinSamples     int     `yaml:"minSamples"`     // 组合的尝试次数达到该值后才按统计判断
+	MinSuccessRate float64 `yaml:"minSuccessRate"` // 成功率低于该值的组合直接使用中继
 }
 
 // RelayConfig 中继配置
 type RelayConfig struct {
-	MaxBandwidth int `yaml:"maxBandwidth"` // 单位：Mbps
-	MaxClients   int `yaml:"maxClients"`
+	Host         string            `yaml:"host"`         // 中继监听地址
+	Port         int               `yaml:"port"`         // 中继监听端口
+	MaxBandwidth int               `yaml:"maxBandwidth"` // 单位：Mbps
+	MaxClients   int               `yaml:"maxClients"`   // 单个中继节点的最大会话数
+	Region       string            `yaml:"region"`       // 未上报区域的节点所属的默认区域
+	NodeRegions  map[string]string `yaml:"nodeRegions"`  // 指定节点所属区域，优先于节点上报的区域
+
+	// 单个用户所有中继会话的带宽总和限制
+	UserUploadLimit   int  `yaml:"userUploadLimit"`   // 上行，单位：Mbps，0 表示不限制
+	UserDownloadLimit int  `yaml:"userDownloadLimit"` // 下行，单位：Mbps，0 表示不限制
+	UserBurst         int  `yaml:"userBurst"`         // 允许的突发流量，单位：MB，0 表示一秒的流量
+	SharedLimits      bool `yaml:"sharedLimits"`      // 通过 Redis 在多个中继实例间共享用户带宽额度
+
+	// 客户端到中继的连接中断（如移动网络切换）后，会话保留 ResumeGrace 秒等待客户端重连恢复，0 表示不支持恢复；
+	// 每个方向最多缓存 ReplayWindow KB 对方尚未确认的数据用于重传
+	ResumeGrace  int `yaml:"resumeGrace"`
+	ReplayWindow int `yaml:"replayWindow"`
+
+	// 独立中继的会话数超过容量的 MigrateAbove% 时把超出的可恢复会话迁移到其他中继，0 表示不迁移
+	MigrateAbove int `yaml:"migrateAbove"`
+
+	// 每个设备的中继会话限制，LimitOverrides 按节点 ID 整体替换指定设备的限制
+	Limits         RelayLimits            `yaml:"limits"`
+	LimitOverrides map[string]RelayLimits `yaml:"limitOverrides"`
+
+	// 中继加密策略。中继只转发会话数据，从不终止或解密双方的加密
+	Encryption RelayEncryptionConfig `yaml:"encryption"`
+
+	// 独立中继向主服务器注册时使用的共享密钥，主服务器未设置时不接受独立中继注册
+	RegistrationSecret string           `yaml:"registrationSecret"`
+	Agent              RelayAgentConfig `yaml:"agent"`
+}
+
+// RelayLimits 中继会话的并发和速率限制，0 表示不限制
+type RelayLimits struct {
+	MaxSessions               int `yaml:"maxSessions" json:"maxSessions"`                             // 单个设备同时进行的中继会话数
+	MaxSessionsPerDestination int `yaml:"maxSessionsPerDestination" json:"maxSessionsPerDestination"` // 单个设备到同一目标节点地址和端口的会话数
+	SessionRate               int `yaml:"sessionRate" json:"sessionRate"`                             // 单个设备每分钟新建的会话数
+	SessionBandwidth          int `yaml:"sessionBandwidth" json:"sessionBandwidth"`                   // 单个会话每个方向的带宽，单位：Mbps
+}
+
+// Validate 验证中继会话限制
+func (l RelayLimits) Validate() error {
+	if l.MaxSessions < 0 || l.MaxSessionsPerDestination < 0 || l.SessionRate < 0 || l.SessionBandwidth < 0 {
+		return errors.New("中继会话限制不能小于 0")
+	}
+	return nil
+}
+
+// RelayEncryptionConfig 中继会话的端到端加密策略。客户端在中继握手中声明会话内使用端到端加密（TLS），
+// 密钥只在通信双方，中继无法解密转发的数据
+type RelayEncryptionConfig struct {
+	RequireE2E       bool `yaml:"requireE2E" json:"requireE2E"`             // 拒绝未声明端到端加密的会话，可由管理员在运行时切换
+	VerifyCiphertext bool `yaml:"verifyCiphertext" json:"verifyCiphertext"` // 检查声明端到端加密的会话首个数据块是否为 TLS 记录，不是时断开会话
+}
+
+// RelayAgentConfig 独立中继配置，只由 p3-relay 使用
+type RelayAgentConfig struct {
+	ServerURL  string `yaml:"serverUrl"`  // 主服务器地址
+	ID         string `yaml:"id"`         // 中继 ID，不能与设备节点 ID 重复
+	PublicHost string `yaml:"publicHost"` // 客户端连接中继使用的公网 IP
+	PublicPort int    `yaml:"publicPort"` // 客户端连接中继使用的端口，为 0 时使用监听端口
 }
 
 // LogConfig 日志配置
 type LogConfig struct {
-	Level  string `yaml:"level"`  // debug, info, warn, error
-	Output string `yaml:"output"` // stdout, file
-	File   string `yaml:"file"`   // 日志文件路径
+	Level    string `yaml:"level"`    // debug, info, warn, error
+	Output   string `yaml:"output"`   // stdout, file
+	File     string `yaml:"file"`     // 日志文件路径
+	Language string `yaml:"language"` // 日志语言：zh 或 en，未翻译的日志保持中文
+	// Modules 各模块的日志级别，模块为包名（如 relay）或包名/文件名（如 p2p/signaling），未设置的模块使用 Level
+	Modules map[string]string `yaml:"modules,omitempty"`
 }
 
 // TURNConfig TURN 服务器配置
 type TURNConfig struct {
-	Address    string `yaml:"address"`
-	Realm      string `yaml:"realm"`
-	AuthSecret string `yaml:"authSecret"`
+	Address       string   `yaml:"address"`
+	Realm         string   `yaml:"realm"`
+	AuthSecret    string   `yaml:"authSecret"`
+	CredentialTTL int      `yaml:"credentialTTL"` // 签发的短期凭据有效期，单位：秒
+	URIs          []string `yaml:"uris"`          // 下发给客户端的 TURN/STUN 地址，为空时按 realm 和监听端口生成
+}
+
+// NotifyConfig 通知配置
+type NotifyConfig struct {
+	WebhookTimeout int `yaml:"webhookTimeout"` // 单位：秒
+	// WebhookAllowedNetworks 允许 Webhook 访问的内网网段，默认禁止访问回环、私有和链路本地地址
+	WebhookAllowedNetworks []string   `yaml:"webhookAllowedNetworks"`
+	SMTP                   SMTPConfig `yaml:"smtp"`
+}
+
+// SMTPConfig 邮件服务器配置
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+// AlertConfig 告警配置
+type AlertConfig struct {
+	EvaluateInterval int `yaml:"evaluateInterval"` // 单位：秒
+}
+
+// ClientVersionConfig 客户端版本策略
+type ClientVersionConfig struct {
+	MinVersion         string `yaml:"minVersion"`         // 最低支持版本，低于此版本的客户端连接信令服务时被拒绝
+	RecommendedVersion string `yaml:"recommendedVersion"` // 推荐版本，低于此版本的客户端会收到升级提示
+	AllowDevBuilds     bool   `yaml:"allowDevBuilds"`     // 是否允许未上报或无法解析版本的客户端（如开发版本 dev），不允许时按低于最低或推荐版本处理
+}
+
+// PasswordHashConfig 密码哈希配置，修改后已有用户在下次登录时按新参数重新计算哈希
+type PasswordHashConfig struct {
+	Algorithm   string `yaml:"algorithm"`   // argon2id 或 bcrypt
+	Memory      uint32 `yaml:"memory"`      // Argon2id 内存成本，单位：KiB
+	Iterations  uint32 `yaml:"iterations"`  // Argon2id 迭代次数
+	Parallelism uint8  `yaml:"parallelism"` // Argon2id 并行度
+	BcryptCost  int    `yaml:"bcryptCost"`  // bcrypt 成本
+}
+
+// PasswordPolicyConfig 密码强度策略，注册和修改密码时检查
+type PasswordPolicyConfig struct {
+	MinLength      int  `yaml:"minLength"`      // 最小长度
+	MinCharClasses int  `yaml:"minCharClasses"` // 至少包含的字符类别数（大写、小写、数字、符号）
+	RejectCommon   bool `yaml:"rejectCommon"`   // 拒绝常见弱密码
+	RejectUsername bool `yaml:"rejectUsername"` // 拒绝包含用户名的密码
 }
 
+// SecurityHeadersConfig HTTP 安全响应头配置，字段为空时不设置对应的响应头
+type SecurityHeadersConfig struct {
+	ContentSecurityPolicy string `yaml:"contentSecurityPolicy"`
+	FrameOptions          string `yaml:"frameOptions"`
+	ReferrerPolicy        string `yaml:"referrerPolicy"`
+	HSTSMaxAge            int    `yaml:"hstsMaxAge"` // 单位：秒，仅在 HTTPS 请求中设置，0 表示不设置
+	HSTSIncludeSubdomains bool   `yaml:"hstsIncludeSubdomains"`
+}
+
+// EmailVerificationConfig 邮箱验证配置，启用后新注册的用户需要验证邮箱才能创建设备
+type EmailVerificationConfig struct {
+	Enabled        bool   `yaml:"enabled"`
+	LinkBaseURL    string `yaml:"linkBaseURL"`    // 验证链接的地址前缀，一般为 Web 控制台的地址
+	TokenTTL       int    `yaml:"tokenTTL"`       // 验证链接有效期，单位：小时
+	ResendInterval int    `yaml:"resendInterval"` // 重新发送验证邮件的最小间隔，单位：秒
+}
+
+// DeviceCertificateConfig 设备证书配置，启用后服务端首次启动时生成内置 CA，设备使用令牌申请证书后
+// 在信令、中继和 P2P 握手中使用证书认证，不再接受令牌
+type DeviceCertificateConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	CAFile    string `yaml:"caFile"`    // CA 证书路径，CA 证书和私钥都不存在时生成
+	CAKeyFile string `yaml:"caKeyFile"` // CA 私钥路径，只允许服务端进程读取
+	Validity  int    `yaml:"validity"`  // 设备证书有效期，单位：天，客户端在剩余三分之一时轮换
+}
+
+// SessionBindingConfig 会话绑定配置。登录时将令牌绑定到客户端（User-Agent）和大致的网络范围，
+// 刷新令牌时环境变化过大则要求重新登录并通知用户，降低令牌被盗用的风险
+type SessionBindingConfig struct {
+	Mode       string `yaml:"mode"`       // off 不检查，notify 只通知，balanced 客户端和网络都变化时要求重新登录，strict 任一变化时要求重新登录
+	IPv4Prefix int    `yaml:"ipv4Prefix"` // 视为同一网络的 IPv4 前缀长度
+	IPv6Prefix int    `yaml:"ipv6Prefix"` // 视为同一网络的 IPv6 前缀长度
+}
+
+// ClockSkewConfig 时钟偏差容忍配置。设备和用户终端的时钟可能偏离服务端，
+// 令牌和 TOTP 验证时按此容忍，设备心跳时检查偏差
+type ClockSkewConfig struct {
+	TokenLeeway  int `yaml:"tokenLeeway"`  // 验证 JWT 的过期、生效和签发时间时容忍的偏差，单位：秒
+	TOTPMaxSteps int `yaml:"totpMaxSteps"` // TOTP 验证码最多容忍的前后周期数，超过 1 时放宽标准窗口并记录审计日志
+	DeviceWarn   int `yaml:"deviceWarn"`   // 设备时钟与服务端相差超过该值时告警并记录设备事件，单位：秒
+}
+
+// SecurityConfig 安全配置
+type SecurityConfig struct {
+	PasswordHash       PasswordHashConfig      `yaml:"passwordHash"`
+	PasswordPolicy     PasswordPolicyConfig    `yaml:"passwordPolicy"`
+	Headers            SecurityHeadersConfig   `yaml:"headers"`
+	HTMLPolicy         string                  `yaml:"htmlPolicy"` // 名称、描述等字段中 HTML 特殊字符的处理方式：reject、escape 或 allow
+	EmailVerification  EmailVerificationConfig `yaml:"emailVerification"`
+	DeviceCertificates DeviceCertificateConfig `yaml:"deviceCertificates"`
+	SessionBinding     SessionBindingConfig    `yaml:"sessionBinding"`
+	ClockSkew          ClockSkewConfig         `yaml:"clockSkew"`
+}
+
+// LDAPConfig LDAP / Active Directory 认证配置。启用后没有本地账户的用户使用目录凭据登录，
+// 首次登录时创建账户，每次登录按所属组同步角色
+type LDAPConfig struct {
+	Enabled            bool              `yaml:"enabled"`
+	URL                string            `yaml:"url"`                // ldap://host:389 或 ldaps://host:636
+	StartTLS           bool              `yaml:"startTLS"`           // 在 ldap:// 连接上使用 StartTLS
+	CAFile             string            `yaml:"caFile"`             // 验证目录服务器证书的 CA，为空时使用系统证书
+	InsecureSkipVerify bool              `yaml:"insecureSkipVerify"` // 不验证目录服务器证书，仅用于测试
+	BindDN             string            `yaml:"bindDN"`             // 查找用户使用的服务账户，为空时匿名查找
+	BindPassword       string            `yaml:"bindPassword"`
+	BaseDN             string            `yaml:"baseDN"`            // 查找用户的起点
+	UserFilter         string            `yaml:"userFilter"`        // 查找用户的过滤器，%s 替换为转义后的用户名，例如 (sAMAccountName=%s)
+	UsernameAttribute  string            `yaml:"usernameAttribute"` // 作为 P3 用户名的属性，AD 一般为 sAMAccountName
+	EmailAttribute     string            `yaml:"emailAttribute"`
+	GroupAttribute     string            `yaml:"groupAttribute"` // 用户所属组的属性，值为组 DN，AD 为 memberOf
+	GroupRoles         map[string]string `yaml:"groupRoles"`     // 组 DN 到角色（admin、user）的映射，属于多个组时取权限最高的角色
+	DefaultRole        string            `yaml:"defaultRole"`    // 不属于 groupRoles 中任何组的用户的角色，为空时拒绝登录
+	PoolSize           int               `yaml:"poolSize"`       // 保持的空闲连接数
+	Timeout            int               `yaml:"timeout"`        // 连接和单次操作的超时，单位：秒
+}
+
+// AuthConfig 账户注册和外部认证配置
+type AuthConfig struct {
+	Registration  string     `yaml:"registration"`  // open 开放注册，invite 仅限邀请，closed 关闭注册
+	InviteBaseURL string     `yaml:"inviteBaseURL"` // 邀请链接的地址前缀，一般为 Web 控制台的地址，为空时只返回邀请码
+	InviteTTL     int        `yaml:"inviteTTL"`     // 邀请默认有效期，单位：小时
+	LDAP          LDAPConfig `yaml:"ldap"`
+
+	Observer ObserverConfig `yaml:"observer"`
+}
+
+// ObserverConfig 设备观察链接配置。持有链接的访客无需登录即可只读查看设备的实时状态
+type ObserverConfig struct {
+	BaseURL    string `yaml:"baseURL"`    // 观察链接的地址前缀，一般为 Web 控制台的地址，为空时只返回令牌
+	DefaultTTL int    `yaml:"defaultTTL"` // 链接默认有效期，单位：分钟
+	MaxTTL     int    `yaml:"maxTTL"`     // 链接最长有效期，单位：分钟
+	Interval   int    `yaml:"interval"`   // 实时推送设备状态的间隔，单位：秒
+}
+
+// StorageConfig 对象存储配置，诊断包、导出文件等大文件保存在对象存储中，不占用数据库
+type StorageConfig struct {
+	Driver     string `yaml:"driver"`     // local 保存在本地目录，s3 保存在 S3 或兼容 S3 协议的存储（如 MinIO）
+	Dir        string `yaml:"dir"`        // local：保存目录
+	PublicURL  string `yaml:"publicURL"`  // local：下载链接的地址前缀，一般为服务端的外部地址，为空时返回相对路径
+	SigningKey string `yaml:"signingKey"` // local：下载链接的签名密钥，为空时使用 JWT 密钥
+	URLExpiry  int    `yaml:"urlExpiry"`  // 签名下载链接的有效期，单位：秒
+	// MaxDiagnosticSize 单个诊断包的最大大小，单位：MB
+	MaxDiagnosticSize int                    `yaml:"maxDiagnosticSize"`
+	S3                S3Config               `yaml:"s3"`
+	Retention         StorageRetentionConfig `yaml:"retention"`
+}
+
+// S3Config S3 或兼容 S3 协议的对象存储配置
+type S3Config struct {
+	Endpoint        string `yaml:"endpoint"` // 例如 https://s3.us-east-1.amazonaws.com 或 http://minio:9000
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	AccessKeyID     string `yaml:"accessKeyId"`
+	SecretAccessKey string `yaml:"secretAccessKey"`
+	PathStyle       bool   `yaml:"pathStyle"` // 使用 endpoint/bucket/key 形式的地址，MinIO 一般需要开启
+}
+
+// StorageRetentionConfig 对象的保留时间，单位：小时，0 表示不自动清理
+type StorageRetentionConfig struct {
+	Exports     int `yaml:"exports"`
+	Diagnostics int `yaml:"diagnostics"`
+}
+
+// CORSConfig 跨域配置，与 Web 后端共用
+type CORSConfig = cors.Config
+
 // Config 服务端配置结构
 type Config struct {
-	Version  string         `yaml:"version"`
-	Server   ServerConfig   `yaml:"server"`
-	Database DatabaseConfig `yaml:"database"`
-	Redis    RedisConfig    `yaml:"redis"`
-	JWT      JWTConfig      `yaml:"jwt"`
-	P2P      P2PConfig      `yaml:"p2p"`
-	Relay    RelayConfig    `yaml:"relay"`
-	Log      LogConfig      `yaml:"log"`
-	TURN     TURNConfig     `yaml:"turn"`
+	Version  string              `yaml:"version"`
+	Server   ServerConfig        `yaml:"server"`
+	Database DatabaseConfig      `yaml:"database"`
+	Redis    RedisConfig         `yaml:"redis"`
+	JWT      JWTConfig           `yaml:"jwt"`
+	P2P      P2PConfig           `yaml:"p2p"`
+	Relay    RelayConfig         `yaml:"relay"`
+	Log      LogConfig           `yaml:"log"`
+	TURN     TURNConfig          `yaml:"turn"`
+	Notify   NotifyConfig        `yaml:"notify"`
+	Alert    AlertConfig         `yaml:"alert"`
+	Client   ClientVersionConfig `yaml:"client"`
+	Security SecurityConfig      `yaml:"security"`
+	CORS     CORSConfig          `yaml:"cors"`
+	Auth     AuthConfig          `yaml:"auth"`
+	Storage  StorageConfig       `yaml:"storage"`
 }
 
 // LoadConfig 从文件加载配置
@@ -129,6 +426,9 @@ func DefaultConfig() *Config {
 			Password: "postgres",
 			DBName:   "p3",
 			SSLMode:  "disable",
+
+			ReplicaMaxLag: 2,
+			CacheTTL:      5,
 		},
 		Redis: RedisConfig{
 			Host:     "localhost",
@@ -144,20 +444,141 @@ func DefaultConfig() *Config {
 			UDPPort1: 27182,
 			UDPPort2: 27183,
 			TCPPort:  27184,
+
+			SignalingCompression: true,
+			DuplicateNode:        "takeover",
+			PunchStats: PunchStatsConfig{
+				Adaptive:       true,
+				MinSamples:     50,
+				MinSuccessRate: 0.2,
+			},
+			CandidatePriority: append([]string(nil), protocol.DefaultCandidatePriority...),
+			SignalingLimits: SignalingLimitsConfig{
+				Rate:  20,
+				Burst: 100,
+				Types: map[string]SignalRateLimit{
+					string(protocol.SignalConnect):      {Rate: 2, Burst: 20},
+					string(protocol.SignalOffer):        {Rate: 5, Burst: 30},
+					string(protocol.SignalRelayRequest): {Rate: 1, Burst: 10},
+				},
+			},
 		},
 		Relay: RelayConfig{
+			Host:         "0.0.0.0",
+			Port:         27185,
 			MaxBandwidth: 10,
 			MaxClients:   100,
+			Region:       "default",
+			ResumeGrace:  30,
+			ReplayWindow: 256,
+			MigrateAbove: 90,
+			Limits: RelayLimits{
+				MaxSessions:               50,
+				MaxSessionsPerDestination: 20,
+				SessionRate:               60,
+			},
+			Encryption: RelayEncryptionConfig{
+				VerifyCiphertext: true,
+			},
 		},
 		Log: LogConfig{
-			Level:  "info",
-			Output: "stdout",
-			File:   "p3-server.log",
+			Level:    "info",
+			Output:   "stdout",
+			File:     "p3-server.log",
+			Language: i18n.DefaultLang,
 		},
 		TURN: TURNConfig{
-			Address:    "0.0.0.0:3478",
-			Realm:      "p3.example.com",
-			AuthSecret: "p3_turn_secret",
+			Address:       "0.0.0.0:3478",
+			Realm:         "p3.example.com",
+			AuthSecret:    "p3_turn_secret",
+			CredentialTTL: 3600,
+		},
+		Notify: NotifyConfig{
+			WebhookTimeout: 10,
+			SMTP: SMTPConfig{
+				Port: 587,
+			},
+		},
+		Alert: AlertConfig{
+			EvaluateInterval: 60,
+		},
+		Security: SecurityConfig{
+			PasswordHash: PasswordHashConfig{
+				Algorithm:   "argon2id",
+				Memory:      64 * 1024,
+				Iterations:  1,
+				Parallelism: 4,
+				BcryptCost:  10,
+			},
+			PasswordPolicy: PasswordPolicyConfig{
+				MinLength:      8,
+				MinCharClasses: 2,
+				RejectCommon:   true,
+				RejectUsername: true,
+			},
+			Headers: SecurityHeadersConfig{
+				ContentSecurityPolicy: "default-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'",
+				FrameOptions:          "DENY",
+				ReferrerPolicy:        "strict-origin-when-cross-origin",
+				HSTSMaxAge:            31536000,
+				HSTSIncludeSubdomains: true,
+			},
+			HTMLPolicy: string(sanitize.HTMLReject),
+			EmailVerification: EmailVerificationConfig{
+				TokenTTL:       24,
+				ResendInterval: 60,
+			},
+			DeviceCertificates: DeviceCertificateConfig{
+				Enabled:   true,
+				CAFile:    "pki/ca.pem",
+				CAKeyFile: "pki/ca-key.pem",
+				Validity:  90,
+			},
+			SessionBinding: SessionBindingConfig{
+				Mode:       "balanced",
+				IPv4Prefix: 16,
+				IPv6Prefix: 48,
+			},
+			ClockSkew: ClockSkewConfig{
+				TokenLeeway:  60,
+				TOTPMaxSteps: 10,
+				DeviceWarn:   30,
+			},
+		},
+		CORS: CORSConfig{
+			AllowedMethods: cors.DefaultMethods,
+			AllowedHeaders: cors.DefaultHeaders,
+			MaxAge:         600,
+		},
+		Auth: AuthConfig{
+			Registration: "open",
+			InviteTTL:    7 * 24,
+			LDAP: LDAPConfig{
+				UserFilter:        "(uid=%s)",
+				UsernameAttribute: "uid",
+				EmailAttribute:    "mail",
+				GroupAttribute:    "memberOf",
+				PoolSize:          4,
+				Timeout:           5,
+			},
+			Observer: ObserverConfig{
+				DefaultTTL: 60,
+				MaxTTL:     24 * 60,
+				Interval:   5,
+			},
+		},
+		Storage: StorageConfig{
+			Driver:            "local",
+			Dir:               "data/objects",
+			URLExpiry:         900,
+			MaxDiagnosticSize: 50,
+			S3: S3Config{
+				Region: "us-east-1",
+			},
+			Retention: StorageRetentionConfig{
+				Exports:     24,
+				Diagnostics: 7 * 24,
+			},
 		},
 	}
 }
@@ -212,6 +633,24 @@ func loadFromEnv(config *Config) {
 	if sslmode := os.Getenv("P3_DB_SSLMODE"); sslmode != "" {
 		config.Database.SSLMode = sslmode
 	}
+	if replicas := os.Getenv("P3_DB_REPLICAS"); replicas != "" {
+		config.Database.Replicas = nil
+		for _, replica := range strings.Split(replicas, ",") {
+			if replica = strings.TrimSpace(replica); replica != "" {
+				config.Database.Replicas = append(config.Database.Replicas, replica)
+			}
+		}
+	}
+	if maxLag := os.Getenv("P3_DB_REPLICA_MAX_LAG"); maxLag != "" {
+		if l, err := strconv.Atoi(maxLag); err == nil {
+			config.Database.ReplicaMaxLag = l
+		}
+	}
+	if cacheTTL := os.Getenv("P3_DB_CACHE_TTL"); cacheTTL != "" {
+		if t, err := strconv.Atoi(cacheTTL); err == nil {
+			config.Database.CacheTTL = t
+		}
+	}
 
 	// Redis 配置
 	if host := os.Getenv("P3_REDIS_HOST"); host != "" {
@@ -257,8 +696,40 @@ func loadFromEnv(config *Config) {
 			config.P2P.TCPPort = p
 		}
 	}
+	if compression := os.Getenv("P3_P2P_SIGNALING_COMPRESSION"); compression != "" {
+		if c, err := strconv.ParseBool(compression); err == nil {
+			config.P2P.SignalingCompression = c
+		}
+	}
+	if duplicateNode := os.Getenv("P3_P2P_DUPLICATE_NODE"); duplicateNode != "" {
+		config.P2P.DuplicateNode = duplicateNode
+	}
+	if adaptive := os.Getenv("P3_P2P_PUNCH_ADAPTIVE"); adaptive != "" {
+		if a, err := strconv.ParseBool(adaptive); err == nil {
+			config.P2P.PunchStats.Adaptive = a
+		}
+	}
+	if priority := os.Getenv("P3_P2P_CANDIDATE_PRIORITY"); priority != "" {
+		config.P2P.CandidatePriority = strings.Split(priority, ",")
+	}
+	if servers := os.Getenv("P3_P2P_STUN_SERVERS"); servers != "" {
+		config.P2P.STUNServers = strings.Split(servers, ",")
+	}
+	if rate := os.Getenv("P3_P2P_SIGNALING_RATE"); rate != "" {
+		if r, err := strconv.ParseFloat(rate, 64); err == nil {
+			config.P2P.SignalingLimits.Rate = r
+		}
+	}
 
 	// 中继配置
+	if host := os.Getenv("P3_RELAY_HOST"); host != "" {
+		config.Relay.Host = host
+	}
+	if port := os.Getenv("P3_RELAY_PORT"); port != "" {
+		if p, err := strconv.Atoi(port); err == nil {
+			config.Relay.Port = p
+		}
+	}
 	if maxBandwidth := os.Getenv("P3_RELAY_MAX_BANDWIDTH"); maxBandwidth != "" {
 		if b, err := strconv.Atoi(maxBandwidth); err == nil {
 			config.Relay.MaxBandwidth = b
@@ -269,6 +740,86 @@ func loadFromEnv(config *Config) {
 			config.Relay.MaxClients = c
 		}
 	}
+	if region := os.Getenv("P3_RELAY_REGION"); region != "" {
+		config.Relay.Region = region
+	}
+	if limit := os.Getenv("P3_RELAY_USER_UPLOAD_LIMIT"); limit != "" {
+		if l, err := strconv.Atoi(limit); err == nil {
+			config.Relay.UserUploadLimit = l
+		}
+	}
+	if limit := os.Getenv("P3_RELAY_USER_DOWNLOAD_LIMIT"); limit != "" {
+		if l, err := strconv.Atoi(limit); err == nil {
+			config.Relay.UserDownloadLimit = l
+		}
+	}
+	if burst := os.Getenv("P3_RELAY_USER_BURST"); burst != "" {
+		if b, err := strconv.Atoi(burst); err == nil {
+			config.Relay.UserBurst = b
+		}
+	}
+	if shared := os.Getenv("P3_RELAY_SHARED_LIMITS"); shared != "" {
+		if s, err := strconv.ParseBool(shared); err == nil {
+			config.Relay.SharedLimits = s
+		}
+	}
+	if grace := os.Getenv("P3_RELAY_RESUME_GRACE"); grace != "" {
+		if g, err := strconv.Atoi(grace); err == nil {
+			config.Relay.ResumeGrace = g
+		}
+	}
+	if window := os.Getenv("P3_RELAY_REPLAY_WINDOW"); window != "" {
+		if w, err := strconv.Atoi(window); err == nil {
+			config.Relay.ReplayWindow = w
+		}
+	}
+	if above := os.Getenv("P3_RELAY_MIGRATE_ABOVE"); above != "" {
+		if a, err := strconv.Atoi(above); err == nil {
+			config.Relay.MigrateAbove = a
+		}
+	}
+	if limit := os.Getenv("P3_RELAY_LIMITS_MAX_SESSIONS"); limit != "" {
+		if l, err := strconv.Atoi(limit); err == nil {
+			config.Relay.Limits.MaxSessions = l
+		}
+	}
+	if limit := os.Getenv("P3_RELAY_LIMITS_MAX_SESSIONS_PER_DESTINATION"); limit != "" {
+		if l, err := strconv.Atoi(limit); err == nil {
+			config.Relay.Limits.MaxSessionsPerDestination = l
+		}
+	}
+	if rate := os.Getenv("P3_RELAY_LIMITS_SESSION_RATE"); rate != "" {
+		if r, err := strconv.Atoi(rate); err == nil {
+			config.Relay.Limits.SessionRate = r
+		}
+	}
+	if bandwidth := os.Getenv("P3_RELAY_LIMITS_SESSION_BANDWIDTH"); bandwidth != "" {
+		if b, err := strconv.Atoi(bandwidth); err == nil {
+			config.Relay.Limits.SessionBandwidth = b
+		}
+	}
+	if require := os.Getenv("P3_RELAY_REQUIRE_E2E"); require != "" {
+		if r, err := strconv.ParseBool(require); err == nil {
+			config.Relay.Encryption.RequireE2E = r
+		}
+	}
+	if secret := os.Getenv("P3_RELAY_REGISTRATION_SECRET"); secret != "" {
+		config.Relay.RegistrationSecret = secret
+	}
+	if serverURL := os.Getenv("P3_RELAY_SERVER_URL"); serverURL != "" {
+		config.Relay.Agent.ServerURL = serverURL
+	}
+	if id := os.Getenv("P3_RELAY_ID"); id != "" {
+		config.Relay.Agent.ID = id
+	}
+	if publicHost := os.Getenv("P3_RELAY_PUBLIC_HOST"); publicHost != "" {
+		config.Relay.Agent.PublicHost = publicHost
+	}
+	if publicPort := os.Getenv("P3_RELAY_PUBLIC_PORT"); publicPort != "" {
+		if p, err := strconv.Atoi(publicPort); err == nil {
+			config.Relay.Agent.PublicPort = p
+		}
+	}
 
 	// 日志配置
 	if level := os.Getenv("P3_LOG_LEVEL"); level != "" {
@@ -280,6 +831,14 @@ func loadFromEnv(config *Config) {
 	if file := os.Getenv("P3_LOG_FILE"); file != "" {
 		config.Log.File = file
 	}
+	if language := os.Getenv("P3_LOG_LANGUAGE"); language != "" {
+		config.Log.Language = language
+	}
+	if modules := os.Getenv("P3_LOG_MODULES"); modules != "" {
+		if levels, err := logger.ParseModuleSpec(modules); err == nil {
+			config.Log.Modules = levels
+		}
+	}
 
 	// TURN 配置
 	if address := os.Getenv("P3_TURN_ADDRESS"); address != "" {
@@ -291,6 +850,154 @@ func loadFromEnv(config *Config) {
 	if authSecret := os.Getenv("P3_TURN_AUTH_SECRET"); authSecret != "" {
 		config.TURN.AuthSecret = authSecret
 	}
+	if ttl := os.Getenv("P3_TURN_CREDENTIAL_TTL"); ttl != "" {
+		if t, err := strconv.Atoi(ttl); err == nil {
+			config.TURN.CredentialTTL = t
+		}
+	}
+	if uris := os.Getenv("P3_TURN_URIS"); uris != "" {
+		config.TURN.URIs = nil
+		for _, uri := range strings.Split(uris, ",") {
+			if uri = strings.TrimSpace(uri); uri != "" {
+				config.TURN.URIs = append(config.TURN.URIs, uri)
+			}
+		}
+	}
+
+	// 通知配置
+	if host := os.Getenv("P3_SMTP_HOST"); host != "" {
+		config.Notify.SMTP.Host = host
+	}
+	if port := os.Getenv("P3_SMTP_PORT"); port != "" {
+		if p, err := strconv.Atoi(port); err == nil {
+			config.Notify.SMTP.Port = p
+		}
+	}
+	if username := os.Getenv("P3_SMTP_USERNAME"); username != "" {
+		config.Notify.SMTP.Username = username
+	}
+	if password := os.Getenv("P3_SMTP_PASSWORD"); password != "" {
+		config.Notify.SMTP.Password = password
+	}
+	if from := os.Getenv("P3_SMTP_FROM"); from != "" {
+		config.Notify.SMTP.From = from
+	}
+
+	// 告警配置
+	if interval := os.Getenv("P3_ALERT_EVALUATE_INTERVAL"); interval != "" {
+		if i, err := strconv.Atoi(interval); err == nil {
+			config.Alert.EvaluateInterval = i
+		}
+	}
+
+	// 客户端版本策略
+	if minVersion := os.Getenv("P3_CLIENT_MIN_VERSION"); minVersion != "" {
+		config.Client.MinVersion = minVersion
+	}
+	if recommended := os.Getenv("P3_CLIENT_RECOMMENDED_VERSION"); recommended != "" {
+		config.Client.RecommendedVersion = recommended
+	}
+	if allowDev := os.Getenv("P3_CLIENT_ALLOW_DEV_BUILDS"); allowDev != "" {
+		if a, err := strconv.ParseBool(allowDev); err == nil {
+			config.Client.AllowDevBuilds = a
+		}
+	}
+
+	// 安全配置
+	if algorithm := os.Getenv("P3_PASSWORD_HASH_ALGORITHM"); algorithm != "" {
+		config.Security.PasswordHash.Algorithm = algorithm
+	}
+	if minLength := os.Getenv("P3_PASSWORD_MIN_LENGTH"); minLength != "" {
+		if l, err := strconv.Atoi(minLength); err == nil {
+			config.Security.PasswordPolicy.MinLength = l
+		}
+	}
+	if verification := os.Getenv("P3_EMAIL_VERIFICATION"); verification != "" {
+		if v, err := strconv.ParseBool(verification); err == nil {
+			config.Security.EmailVerification.Enabled = v
+		}
+	}
+	if baseURL := os.Getenv("P3_EMAIL_VERIFICATION_URL"); baseURL != "" {
+		config.Security.EmailVerification.LinkBaseURL = baseURL
+	}
+	if certs := os.Getenv("P3_DEVICE_CERTIFICATES"); certs != "" {
+		if v, err := strconv.ParseBool(certs); err == nil {
+			config.Security.DeviceCertificates.Enabled = v
+		}
+	}
+	if mode := os.Getenv("P3_SESSION_BINDING"); mode != "" {
+		config.Security.SessionBinding.Mode = mode
+	}
+	if leeway := os.Getenv("P3_TOKEN_LEEWAY"); leeway != "" {
+		if v, err := strconv.Atoi(leeway); err == nil {
+			config.Security.ClockSkew.TokenLeeway = v
+		}
+	}
+	if steps := os.Getenv("P3_TOTP_MAX_STEPS"); steps != "" {
+		if v, err := strconv.Atoi(steps); err == nil {
+			config.Security.ClockSkew.TOTPMaxSteps = v
+		}
+	}
+
+	// 注册配置
+	if registration := os.Getenv("P3_REGISTRATION"); registration != "" {
+		config.Auth.Registration = registration
+	}
+	if enabled := os.Getenv("P3_LDAP_ENABLED"); enabled != "" {
+		if v, err := strconv.ParseBool(enabled); err == nil {
+			config.Auth.LDAP.Enabled = v
+		}
+	}
+	if ldapURL := os.Getenv("P3_LDAP_URL"); ldapURL != "" {
+		config.Auth.LDAP.URL = ldapURL
+	}
+	if bindDN := os.Getenv("P3_LDAP_BIND_DN"); bindDN != "" {
+		config.Auth.LDAP.BindDN = bindDN
+	}
+	if bindPassword := os.Getenv("P3_LDAP_BIND_PASSWORD"); bindPassword != "" {
+		config.Auth.LDAP.BindPassword = bindPassword
+	}
+
+	// 对象存储配置
+	if driver := os.Getenv("P3_STORAGE_DRIVER"); driver != "" {
+		config.Storage.Driver = driver
+	}
+	if dir := os.Getenv("P3_STORAGE_DIR"); dir != "" {
+		config.Storage.Dir = dir
+	}
+	if publicURL := os.Getenv("P3_STORAGE_PUBLIC_URL"); publicURL != "" {
+		config.Storage.PublicURL = publicURL
+	}
+	if endpoint := os.Getenv("P3_S3_ENDPOINT"); endpoint != "" {
+		config.Storage.S3.Endpoint = endpoint
+	}
+	if region := os.Getenv("P3_S3_REGION"); region != "" {
+		config.Storage.S3.Region = region
+	}
+	if bucket := os.Getenv("P3_S3_BUCKET"); bucket != "" {
+		config.Storage.S3.Bucket = bucket
+	}
+	if accessKey := os.Getenv("P3_S3_ACCESS_KEY_ID"); accessKey != "" {
+		config.Storage.S3.AccessKeyID = accessKey
+	}
+	if secretKey := os.Getenv("P3_S3_SECRET_ACCESS_KEY"); secretKey != "" {
+		config.Storage.S3.SecretAccessKey = secretKey
+	}
+
+	// 跨域配置
+	if origins := os.Getenv("P3_CORS_ALLOWED_ORIGINS"); origins != "" {
+		config.CORS.AllowedOrigins = nil
+		for _, origin := range strings.Split(origins, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				config.CORS.AllowedOrigins = append(config.CORS.AllowedOrigins, origin)
+			}
+		}
+	}
+	if credentials := os.Getenv("P3_CORS_ALLOW_CREDENTIALS"); credentials != "" {
+		if c, err := strconv.ParseBool(credentials); err == nil {
+			config.CORS.AllowCredentials = c
+		}
+	}
 }
 
 // validateConfig 验证配置
@@ -300,6 +1007,14 @@ func validateConfig(config *Config) error {
 		return errors.New("服务器端口无效")
 	}
 
+	for _, proxy := range config.Server.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("受信任的代理 %s 无效，应为 IP 地址或网段", proxy)
+			}
+		}
+	}
+
 	// 验证数据库配置
 	if config.Database.Driver == "" {
 		return errors.New("数据库驱动不能为空")
@@ -318,6 +1033,12 @@ func validateConfig(config *Config) error {
 			return errors.New("数据库名不能为空")
 		}
 	}
+	if len(config.Database.Replicas) > 0 && config.Database.ReplicaMaxLag <= 0 {
+		return errors.New("副本最大复制延迟必须大于 0")
+	}
+	if config.Database.CacheTTL < 0 {
+		return errors.New("设备缓存有效期不能为负数")
+	}
 
 	// 验证 JWT 配置
 	if config.JWT.Secret == "" {
@@ -337,14 +1058,65 @@ func validateConfig(config *Config) error {
 	if config.P2P.TCPPort <= 0 || config.P2P.TCPPort > 65535 {
 		return errors.New("P2P TCP 端口无效")
 	}
+	switch config.P2P.DuplicateNode {
+	case "takeover", "reject":
+	default:
+		return fmt.Errorf("不支持的重复节点连接处理方式: %s", config.P2P.DuplicateNode)
+	}
+	if config.P2P.PunchStats.MinSamples <= 0 {
+		return errors.New("打洞统计的最少样本数必须大于 0")
+	}
+	if rate := config.P2P.PunchStats.MinSuccessRate; rate < 0 || rate > 1 {
+		return errors.New("打洞统计的最低成功率必须在 0 到 1 之间")
+	}
+	if len(config.P2P.CandidatePriority) == 0 {
+		return errors.New("连接优先级不能为空")
+	}
+	if err := protocol.ValidateCandidatePriority(config.P2P.CandidatePriority); err != nil {
+		return fmt.Errorf("连接优先级无效: %w", err)
+	}
+	if err := protocol.ValidateSTUNServers(config.P2P.STUNServers); err != nil {
+		return fmt.Errorf("下发的 STUN 服务器列表无效: %w", err)
+	}
+	if limits := config.P2P.SignalingLimits; limits.Rate < 0 || limits.Burst < 0 {
+		return errors.New("信令速率限制不能小于 0")
+	}
+	for signalType, limit := range config.P2P.SignalingLimits.Types {
+		if signalType == "" {
+			return errors.New("信令速率限制的信令类型不能为空")
+		}
+		if limit.Rate < 0 || limit.Burst < 0 {
+			return fmt.Errorf("信令 %s 的速率限制不能小于 0", signalType)
+		}
+	}
 
 	// 验证中继配置
+	if config.Relay.Port <= 0 || config.Relay.Port > 65535 {
+		return errors.New("中继端口无效")
+	}
 	if config.Relay.MaxBandwidth <= 0 {
 		return errors.New("中继最大带宽无效")
 	}
 	if config.Relay.MaxClients <= 0 {
 		return errors.New("中继最大客户端数无效")
 	}
+	if config.Relay.ResumeGrace < 0 {
+		return errors.New("中继会话恢复时间不能小于 0")
+	}
+	if config.Relay.ResumeGrace > 0 && (config.Relay.ReplayWindow < 64 || config.Relay.ReplayWindow > 4096) {
+		return errors.New("中继重放窗口必须在 64 到 4096 KB 之间")
+	}
+	if config.Relay.MigrateAbove < 0 || config.Relay.MigrateAbove > 100 {
+		return errors.New("中继会话迁移阈值必须在 0 到 100 之间")
+	}
+	if err := config.Relay.Limits.Validate(); err != nil {
+		return err
+	}
+	for nodeID, limits := range config.Relay.LimitOverrides {
+		if err := limits.Validate(); err != nil {
+			return fmt.Errorf("节点 %s 的%w", nodeID, err)
+		}
+	}
 
 	// 验证日志配置
 	logLevel := strings.ToLower(config.Log.Level)
@@ -358,6 +1130,12 @@ func validateConfig(config *Config) error {
 	if logOutput == "file" && config.Log.File == "" {
 		return errors.New("日志文件路径不能为空")
 	}
+	if config.Log.Language != "" && i18n.Normalize(config.Log.Language) != config.Log.Language {
+		return fmt.Errorf("不支持的日志语言: %s，支持 %s", config.Log.Language, strings.Join(i18n.Supported(), "、"))
+	}
+	if _, err := logger.ParseModuleLevels(config.Log.Modules); err != nil {
+		return err
+	}
 
 	// 验证 TURN 配置
 	if config.TURN.Address == "" {
@@ -369,7 +1147,241 @@ func validateConfig(config *Config) error {
 	if config.TURN.AuthSecret == "" {
 		return errors.New("TURN 服务器认证密钥不能为空")
 	}
+	if config.TURN.CredentialTTL <= 0 {
+		return errors.New("TURN 凭据有效期无效")
+	}
+
+	// 验证通知配置
+	if config.Notify.WebhookTimeout <= 0 {
+		return errors.New("Webhook 超时时间无效")
+	}
+	for _, cidr := range config.Notify.WebhookAllowedNetworks {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return fmt.Errorf("Webhook 允许访问的网段 %s 无效: %w", cidr, err)
+		}
+	}
+	if config.Notify.SMTP.Host != "" {
+		if config.Notify.SMTP.Port <= 0 || config.Notify.SMTP.Port > 65535 {
+			return errors.New("SMTP 端口无效")
+		}
+		if config.Notify.SMTP.From == "" {
+			return errors.New("SMTP 发件人不能为空")
+		}
+	}
+
+	// 验证告警配置
+	if config.Alert.EvaluateInterval <= 0 {
+		return errors.New("告警评估间隔无效")
+	}
+
+	// 验证密码哈希配置
+	hash := config.Security.PasswordHash
+	switch hash.Algorithm {
+	case "argon2id":
+		if hash.Iterations < 1 || hash.Parallelism < 1 {
+			return errors.New("Argon2id 迭代次数和并行度不能小于 1")
+		}
+		// Argon2 要求内存不少于并行度的 8 倍
+		if hash.Memory < 8*uint32(hash.Parallelism) {
+			return errors.New("Argon2id 内存成本过小")
+		}
+	case "bcrypt":
+		if hash.BcryptCost < 4 || hash.BcryptCost > 31 {
+			return errors.New("bcrypt 成本无效，取值范围为 4 到 31")
+		}
+	default:
+		return fmt.Errorf("不支持的密码哈希算法: %s", hash.Algorithm)
+	}
+
+	// 验证密码强度策略
+	if config.Security.PasswordPolicy.MinLength < 0 {
+		return errors.New("密码最小长度无效")
+	}
+	if classes := config.Security.PasswordPolicy.MinCharClasses; classes < 0 || classes > 4 {
+		return errors.New("密码字符类别数无效，取值范围为 0 到 4")
+	}
+	if config.Security.Headers.HSTSMaxAge < 0 {
+		return errors.New("HSTS 有效期无效")
+	}
+	if _, err := sanitize.ParseHTMLPolicy(config.Security.HTMLPolicy); err != nil {
+		return err
+	}
+
+	// 验证邮箱验证配置
+	if verification := config.Security.EmailVerification; verification.Enabled {
+		if config.Notify.SMTP.Host == "" {
+			return errors.New("启用邮箱验证需要配置 SMTP 服务器")
+		}
+		if verification.LinkBaseURL == "" {
+			return errors.New("启用邮箱验证需要配置验证链接地址")
+		}
+		if verification.TokenTTL <= 0 {
+			return errors.New("邮箱验证链接有效期必须大于 0")
+		}
+		if verification.ResendInterval < 0 {
+			return errors.New("重新发送验证邮件的间隔无效")
+		}
+	}
+
+	// 验证设备证书配置
+	if certs := config.Security.DeviceCertificates; certs.Enabled {
+		if certs.CAFile == "" || certs.CAKeyFile == "" {
+			return errors.New("启用设备证书需要配置 CA 证书和私钥路径")
+		}
+		if certs.Validity <= 0 {
+			return errors.New("设备证书有效期必须大于 0")
+		}
+	}
+
+	// 验证会话绑定配置
+	switch binding := config.Security.SessionBinding; binding.Mode {
+	case "off":
+	case "notify", "balanced", "strict":
+		if binding.IPv4Prefix < 0 || binding.IPv4Prefix > 32 || binding.IPv6Prefix < 0 || binding.IPv6Prefix > 128 {
+			return errors.New("会话绑定的网络前缀长度无效")
+		}
+	default:
+		return fmt.Errorf("不支持的会话绑定模式: %s", binding.Mode)
+	}
+
+	// 验证时钟偏差配置
+	skew := config.Security.ClockSkew
+	if skew.TokenLeeway < 0 || skew.TokenLeeway > 3600 {
+		return errors.New("令牌时钟偏差容忍必须在 0 到 3600 秒之间")
+	}
+	if skew.TOTPMaxSteps < 1 || skew.TOTPMaxSteps > 20 {
+		return errors.New("TOTP 最多容忍的周期数必须在 1 到 20 之间")
+	}
+	if skew.DeviceWarn <= 0 {
+		return errors.New("设备时钟偏差告警阈值必须大于 0")
+	}
+
+	// 验证跨域配置
+	if err := config.CORS.Validate(); err != nil {
+		return err
+	}
+
+	// 验证注册配置
+	switch config.Auth.Registration {
+	case "open", "invite", "closed":
+	default:
+		return fmt.Errorf("不支持的注册模式: %s", config.Auth.Registration)
+	}
+	if config.Auth.InviteTTL <= 0 {
+		return errors.New("邀请有效期必须大于 0")
+	}
+	if observer := config.Auth.Observer; observer.DefaultTTL <= 0 || observer.MaxTTL < observer.DefaultTTL {
+		return errors.New("观察链接的默认有效期必须大于 0 且不超过最长有效期")
+	}
+	if config.Auth.Observer.Interval <= 0 {
+		return errors.New("观察链接的推送间隔必须大于 0")
+	}
+	if config.Auth.LDAP.Enabled {
+		if err := validateLDAP(config.Auth.LDAP); err != nil {
+			return err
+		}
+	}
 
+	// 验证对象存储配置
+	if err := validateStorage(config.Storage); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// validateStorage 验证对象存储配置
+func validateStorage(storage StorageConfig) error {
+	switch storage.Driver {
+	case "local":
+		if storage.Dir == "" {
+			return errors.New("对象存储目录不能为空")
+		}
+		if storage.PublicURL != "" {
+			if u, err := url.Parse(storage.PublicURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				return fmt.Errorf("无效的对象存储下载地址: %s", storage.PublicURL)
+			}
+		}
+	case "s3":
+		u, err := url.Parse(storage.S3.Endpoint)
+		if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+			return fmt.Errorf("无效的 S3 地址: %s", storage.S3.Endpoint)
+		}
+		if storage.S3.Bucket == "" || storage.S3.Region == "" {
+			return errors.New("S3 需要配置 bucket 和 region")
+		}
+		if storage.S3.AccessKeyID == "" || storage.S3.SecretAccessKey == "" {
+			return errors.New("S3 需要配置 accessKeyId 和 secretAccessKey")
+		}
+	default:
+		return fmt.Errorf("不支持的对象存储类型: %s", storage.Driver)
+	}
+	if storage.URLExpiry <= 0 || storage.URLExpiry > 7*24*3600 {
+		return errors.New("签名下载链接的有效期必须在 1 秒到 7 天之间")
+	}
+	if storage.MaxDiagnosticSize <= 0 {
+		return errors.New("诊断包最大大小必须大于 0")
+	}
+	if storage.Retention.Exports < 0 || storage.Retention.Diagnostics < 0 {
+		return errors.New("对象保留时间不能为负数")
+	}
+	return nil
+}
+
+// validateLDAP 验证 LDAP 认证配置
+func validateLDAP(ldap LDAPConfig) error {
+	u, err := url.Parse(ldap.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "ldap" && u.Scheme != "ldaps") {
+		return fmt.Errorf("无效的 LDAP 地址: %s", ldap.URL)
+	}
+	if ldap.StartTLS && u.Scheme == "ldaps" {
+		return errors.New("ldaps:// 地址不能同时启用 StartTLS")
+	}
+	if ldap.BaseDN == "" {
+		return errors.New("LDAP 需要配置 baseDN")
+	}
+	if strings.Count(ldap.UserFilter, "%s") != 1 {
+		return errors.New("LDAP userFilter 必须包含一个 %s")
+	}
+	if ldap.UsernameAttribute == "" {
+		return errors.New("LDAP 需要配置 usernameAttribute")
+	}
+	for group, role := range ldap.GroupRoles {
+		if role != "admin" && role != "user" {
+			return fmt.Errorf("LDAP 组 %s 的角色无效: %s", group, role)
+		}
+	}
+	if ldap.DefaultRole != "" && ldap.DefaultRole != "admin" && ldap.DefaultRole != "user" {
+		return fmt.Errorf("LDAP 默认角色无效: %s", ldap.DefaultRole)
+	}
+	if ldap.PoolSize < 0 {
+		return errors.New("LDAP 连接池大小不能为负数")
+	}
+	if ldap.Timeout <= 0 {
+		return errors.New("LDAP 超时必须大于 0")
+	}
+	return nil
+}
+
+// ValidateRelayAgent 验证独立中继需要的配置
+func ValidateRelayAgent(config *Config) error {
+	agent := config.Relay.Agent
+	u, err := url.Parse(agent.ServerURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("主服务器地址无效")
+	}
+	if agent.ID == "" {
+		return errors.New("中继 ID 不能为空")
+	}
+	if net.ParseIP(agent.PublicHost) == nil {
+		return errors.New("中继公网 IP 无效")
+	}
+	if agent.PublicPort < 0 || agent.PublicPort > 65535 {
+		return errors.New("中继公网端口无效")
+	}
+	if config.Relay.RegistrationSecret == "" {
+		return errors.New("中继注册密钥不能为空")
+	}
 	return nil
 }
 
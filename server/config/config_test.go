@@ -66,6 +66,17 @@ func TestValidateConfig(t *testing.T) {
 		t.Error("应该检测到无效的服务器端口")
 	}
 
+	// 测试受信任的代理
+	trustedProxyCfg := DefaultConfig()
+	trustedProxyCfg.Server.TrustedProxies = []string{"10.0.0.1", "192.168.0.0/16", "fd00::/8"}
+	if err := validateConfig(trustedProxyCfg); err != nil {
+		t.Errorf("验证受信任的代理失败: %v", err)
+	}
+	trustedProxyCfg.Server.TrustedProxies = []string{"proxy.example.com"}
+	if err := validateConfig(trustedProxyCfg); err == nil {
+		t.Error("应该检测到无效的受信任代理")
+	}
+
 	// 测试无效数据库驱动
 	invalidDBDriverCfg := DefaultConfig()
 	invalidDBDriverCfg.Database.Driver = ""
@@ -79,6 +90,13 @@ func TestValidateConfig(t *testing.T) {
 	if err := validateConfig(invalidJWTSecretCfg); err == nil {
 		t.Error("应该检测到无效的 JWT 密钥")
 	}
+
+	// 测试无效连接优先级
+	invalidPriorityCfg := DefaultConfig()
+	invalidPriorityCfg.P2P.CandidatePriority = []string{"direct", "quic"}
+	if err := validateConfig(invalidPriorityCfg); err == nil {
+		t.Error("应该检测到无效的连接优先级")
+	}
 }
 
 func TestGetDSN(t *testing.T) {
@@ -129,3 +147,54 @@ func TestGetDSN(t *testing.T) {
 		t.Errorf("未知驱动 DSN 错误，期望空字符串，实际 %s", dsn)
 	}
 }
+
+func TestValidatePasswordHashConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	if cfg.Security.PasswordHash.Algorithm != "argon2id" {
+		t.Errorf("默认密码哈希算法错误，期望 argon2id，实际 %s", cfg.Security.PasswordHash.Algorithm)
+	}
+
+	cfg.Security.PasswordHash.Algorithm = "md5"
+	if err := validateConfig(cfg); err == nil {
+		t.Error("不支持的密码哈希算法应该返回错误")
+	}
+
+	cfg = DefaultConfig()
+	cfg.Security.PasswordHash.Memory = 16
+	if err := validateConfig(cfg); err == nil {
+		t.Error("过小的 Argon2id 内存成本应该返回错误")
+	}
+
+	cfg = DefaultConfig()
+	cfg.Security.PasswordHash.Algorithm = "bcrypt"
+	cfg.Security.PasswordHash.BcryptCost = 40
+	if err := validateConfig(cfg); err == nil {
+		t.Error("无效的 bcrypt 成本应该返回错误")
+	}
+}
+
+func TestValidateRelayAgent(t *testing.T) {
+	cfg := DefaultConfig()
+	if err := ValidateRelayAgent(cfg); err == nil {
+		t.Error("未配置主服务器地址应该返回错误")
+	}
+
+	cfg.Relay.Agent = RelayAgentConfig{
+		ServerURL:  "https://p3.example.com",
+		ID:         "relay-1",
+		PublicHost: "203.0.113.10",
+	}
+	if err := ValidateRelayAgent(cfg); err == nil {
+		t.Error("未配置注册密钥应该返回错误")
+	}
+
+	cfg.Relay.RegistrationSecret = "secret"
+	if err := ValidateRelayAgent(cfg); err != nil {
+		t.Errorf("验证有效的独立中继配置失败: %v", err)
+	}
+
+	cfg.Relay.Agent.PublicHost = "relay.example.com"
+	if err := ValidateRelayAgent(cfg); err == nil {
+		t.Error("公网地址不是 IP 时应该返回错误")
+	}
+}
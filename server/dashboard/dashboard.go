@@ -0,0 +1,183 @@
+// Package dashboard 汇总所有租户的用户、设备、流量和连接情况，供管理员的运营看板使用。
+// 时间范围内的流量和连接成功率来自设备上报的统计增量和连接记录，中继的实时数据来自中继服务
+package dashboard
+
+import (
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// RelayStats 中继服务的实时统计，为 nil 时不返回中继的实时数据
+type RelayStats interface {
+	GetSessionCount() int
+	GetE2ESessionCount() int
+	GetTotalBytesTransferred() (uint64, uint64)
+}
+
+// DeviceCounts 设备数量
+type DeviceCounts struct {
+	Total   int64 `json:"total"`
+	Online  int64 `json:"online"`
+	Offline int64 `json:"offline"`
+}
+
+// RelaySummary 中继流量。时间范围内的流量来自设备上报的中继连接，实时数据来自服务端中继当前的会话
+type RelaySummary struct {
+	db.TrafficTotal
+	ActiveSessions      int    `json:"activeSessions"`
+	ActiveE2ESessions   int    `json:"activeE2eSessions"` // 客户端声明端到端加密的会话
+	ActiveBytesSent     uint64 `json:"activeBytesSent"`
+	ActiveBytesReceived uint64 `json:"activeBytesReceived"`
+}
+
+// PunchSummary 连接建立情况。成功率为不经过中继建立的连接占全部连接过程的比例，没有记录时为 0
+type PunchSummary struct {
+	Total       int64            `json:"total"`
+	Direct      int64            `json:"direct"` // 直接连接、UPnP 或打洞成功
+	Relayed     int64            `json:"relayed"`
+	Failed      int64            `json:"failed"`
+	SuccessRate float64          `json:"successRate"`
+	ByPath      map[string]int64 `json:"byPath"` // 各连接方式的数量，连接失败记为 failed
+}
+
+// Talker 流量最多的设备
+type Talker struct {
+	db.DeviceTraffic
+	Name   string `json:"name"`
+	NodeID string `json:"nodeId"`
+}
+
+// Overview 全局统计
+type Overview struct {
+	Since      time.Time       `json:"since"`
+	Users      int64           `json:"users"`
+	Devices    DeviceCounts    `json:"devices"`
+	Traffic    db.TrafficTotal `json:"traffic"`
+	Relay      RelaySummary    `json:"relay"`
+	Punch      PunchSummary    `json:"punch"`
+	TopTalkers []Talker        `json:"topTalkers"`
+}
+
+// Service 运营看板服务
+type Service struct {
+	store *store.Store
+	relay RelayStats
+}
+
+// NewService 创建运营看板服务，st 必须是未按租户限定范围的仓库集合
+func NewService(st *store.Store, relay RelayStats) *Service {
+	return &Service{
+		store: st,
+		relay: relay,
+	}
+}
+
+// Overview 汇总自 since 以来的全局统计，topN 为返回的流量最多的设备数
+func (s *Service) Overview(since time.Time, topN int) (*Overview, error) {
+	metrics := s.store.Metrics
+	overview := &Overview{Since: since}
+
+	users, err := metrics.CountUsers()
+	if err != nil {
+		return nil, errors.Database("统计用户失败", err)
+	}
+	overview.Users = users
+
+	statuses, err := metrics.CountDevicesByStatus()
+	if err != nil {
+		return nil, errors.Database("统计设备失败", err)
+	}
+	for status, count := range statuses {
+		overview.Devices.Total += count
+		if status == "online" {
+			overview.Devices.Online += count
+		}
+	}
+	overview.Devices.Offline = overview.Devices.Total - overview.Devices.Online
+
+	traffic, err := metrics.Traffic(since)
+	if err != nil {
+		return nil, errors.Database("统计流量失败", err)
+	}
+	overview.Traffic = *traffic
+
+	relayed, err := metrics.RelayTraffic(since)
+	if err != nil {
+		return nil, errors.Database("统计中继流量失败", err)
+	}
+	overview.Relay.TrafficTotal = *relayed
+	if s.relay != nil {
+		overview.Relay.ActiveSessions = s.relay.GetSessionCount()
+		overview.Relay.ActiveE2ESessions = s.relay.GetE2ESessionCount()
+		overview.Relay.ActiveBytesSent, overview.Relay.ActiveBytesReceived = s.relay.GetTotalBytesTransferred()
+	}
+
+	paths, err := metrics.CountTracesByPath(since)
+	if err != nil {
+		return nil, errors.Database("统计连接记录失败", err)
+	}
+	overview.Punch = punchSummary(paths)
+
+	talkers, err := s.topTalkers(since, topN)
+	if err != nil {
+		return nil, err
+	}
+	overview.TopTalkers = talkers
+	return overview, nil
+}
+
+// Tenants 汇总每个用户的设备、应用和自 since 以来的流量
+func (s *Service) Tenants(since time.Time) ([]db.TenantSummary, error) {
+	summaries, err := s.store.Metrics.TenantSummaries(since)
+	if err != nil {
+		return nil, errors.Database("统计租户失败", err)
+	}
+	return summaries, nil
+}
+
+// topTalkers 获取流量最多的设备及其名称，设备已删除时名称为空
+func (s *Service) topTalkers(since time.Time, limit int) ([]Talker, error) {
+	totals, err := s.store.Metrics.TopDevices(since, limit)
+	if err != nil {
+		return nil, errors.Database("统计设备流量失败", err)
+	}
+	talkers := make([]Talker, len(totals))
+	for i, total := range totals {
+		talkers[i].DeviceTraffic = total
+		device, err := s.store.Devices.GetByID(total.DeviceID)
+		if err != nil {
+			if store.IsNotFound(err) {
+				continue
+			}
+			return nil, errors.Database("查询设备失败", err)
+		}
+		talkers[i].Name = device.Name
+		talkers[i].NodeID = device.NodeID
+	}
+	return talkers, nil
+}
+
+// punchSummary 按最终使用的连接方式汇总连接建立情况
+func punchSummary(paths map[string]int64) PunchSummary {
+	summary := PunchSummary{ByPath: make(map[string]int64, len(paths))}
+	for path, count := range paths {
+		summary.Total += count
+		switch path {
+		case "":
+			summary.Failed += count
+			path = "failed"
+		case store.ConnectionTypeRelay:
+			summary.Relayed += count
+		default:
+			summary.Direct += count
+		}
+		summary.ByPath[path] += count
+	}
+	if summary.Total > 0 {
+		summary.SuccessRate = float64(summary.Direct) / float64(summary.Total)
+	}
+	return summary
+}
@@ -0,0 +1,100 @@
+package dashboard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// fakeRelay 固定的中继实时统计
+type fakeRelay struct{}
+
+func (fakeRelay) GetSessionCount() int { return 2 }
+
+func (fakeRelay) GetE2ESessionCount() int { return 1 }
+
+func (fakeRelay) GetTotalBytesTransferred() (uint64, uint64) { return 100, 200 }
+
+func TestOverview(t *testing.T) {
+	st := store.NewMemoryStore()
+	now := time.Now()
+	alice := &db.User{Username: "alice"}
+	bob := &db.User{Username: "bob"}
+	for _, user := range []*db.User{alice, bob} {
+		if err := st.Users.Create(user); err != nil {
+			t.Fatalf("创建用户失败: %v", err)
+		}
+	}
+	nas := &db.Device{UserID: alice.ID, Name: "nas", NodeID: "nas", Status: "online"}
+	laptop := &db.Device{UserID: alice.ID, Name: "laptop", NodeID: "laptop", Status: "offline"}
+	phone := &db.Device{UserID: bob.ID, Name: "phone", NodeID: "phone", Status: "online"}
+	for _, device := range []*db.Device{nas, laptop, phone} {
+		if err := st.Devices.Create(device); err != nil {
+			t.Fatalf("创建设备失败: %v", err)
+		}
+	}
+	if err := st.Apps.Create(&db.App{UserID: alice.ID, DeviceID: nas.ID, Name: "web", Protocol: "tcp", SrcPort: 8080}); err != nil {
+		t.Fatalf("创建应用失败: %v", err)
+	}
+
+	// 超出时间范围的流量不计入
+	destinations := []db.DestinationStats{
+		{UserID: alice.ID, DeviceID: nas.ID, AppID: 1, Protocol: "tcp", Address: "10.0.0.1:80", BytesSent: 1000, BytesReceived: 3000, Connections: 4, ReportedAt: now},
+		{UserID: alice.ID, DeviceID: nas.ID, AppID: 1, Protocol: "tcp", Address: "10.0.0.1:80", BytesSent: 9000, ReportedAt: now.Add(-48 * time.Hour)},
+	}
+	conns := []db.Connection{{SourceDeviceID: nas.ID, TargetDeviceID: phone.ID, Type: store.ConnectionTypeRelay, LastActiveAt: now, BytesSent: 50, BytesReceived: 70}}
+	if err := st.Devices.SaveReport(nas, map[string]interface{}{}, nil, conns, destinations); err != nil {
+		t.Fatalf("保存上报失败: %v", err)
+	}
+	if err := st.Devices.SaveReport(phone, map[string]interface{}{}, nil, nil, []db.DestinationStats{
+		{UserID: bob.ID, DeviceID: phone.ID, AppID: 2, Protocol: "udp", Address: "10.0.0.2:53", BytesSent: 10, BytesReceived: 20, Connections: 1, ReportedAt: now},
+	}); err != nil {
+		t.Fatalf("保存上报失败: %v", err)
+	}
+	for _, path := range []string{"holepunch", "direct", "relay", ""} {
+		if err := st.Devices.CreateTrace(&db.ConnectionTrace{DeviceID: nas.ID, PeerID: "phone", Path: path, StartedAt: now}); err != nil {
+			t.Fatalf("保存连接记录失败: %v", err)
+		}
+	}
+
+	s := NewService(st, fakeRelay{})
+	overview, err := s.Overview(now.Add(-24*time.Hour), 1)
+	if err != nil {
+		t.Fatalf("获取全局统计失败: %v", err)
+	}
+	if overview.Users != 2 {
+		t.Fatalf("用户数应为 2，实际为 %d", overview.Users)
+	}
+	if overview.Devices != (DeviceCounts{Total: 3, Online: 2, Offline: 1}) {
+		t.Fatalf("设备数错误: %+v", overview.Devices)
+	}
+	if overview.Traffic != (db.TrafficTotal{BytesSent: 1010, BytesReceived: 3020, Connections: 5}) {
+		t.Fatalf("流量合计错误: %+v", overview.Traffic)
+	}
+	if overview.Relay.BytesSent != 50 || overview.Relay.Connections != 1 || overview.Relay.ActiveSessions != 2 || overview.Relay.ActiveE2ESessions != 1 || overview.Relay.ActiveBytesReceived != 200 {
+		t.Fatalf("中继统计错误: %+v", overview.Relay)
+	}
+	if overview.Punch.Total != 4 || overview.Punch.Direct != 2 || overview.Punch.Relayed != 1 || overview.Punch.Failed != 1 || overview.Punch.SuccessRate != 0.5 {
+		t.Fatalf("连接统计错误: %+v", overview.Punch)
+	}
+	if overview.Punch.ByPath["failed"] != 1 {
+		t.Fatalf("连接失败应记为 failed: %v", overview.Punch.ByPath)
+	}
+	if len(overview.TopTalkers) != 1 || overview.TopTalkers[0].NodeID != "nas" || overview.TopTalkers[0].BytesReceived != 3000 {
+		t.Fatalf("流量最多的设备错误: %+v", overview.TopTalkers)
+	}
+
+	tenants, err := s.Tenants(now.Add(-24 * time.Hour))
+	if err != nil {
+		t.Fatalf("获取租户概况失败: %v", err)
+	}
+	if len(tenants) != 2 {
+		t.Fatalf("应返回 2 个租户，实际为 %d", len(tenants))
+	}
+	want := db.TenantSummary{UserID: alice.ID, Username: "alice", Devices: 2, OnlineDevices: 1, Apps: 1, BytesSent: 1000, BytesReceived: 3000, Connections: 4}
+	if tenants[0] != want {
+		t.Fatalf("租户概况错误: %+v", tenants[0])
+	}
+}
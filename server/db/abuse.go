@@ -0,0 +1,63 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 封禁的对象类型
+const (
+	BanNode = "node" // 节点 ID
+	BanIP   = "ip"   // IP 地址或 CIDR 网段
+	BanPort = "port" // 中继会话的目标端口
+)
+
+// 封禁的来源
+const (
+	BanSourceAdmin  = "admin"  // 管理员手动封禁
+	BanSourceReport = "report" // 管理员处理滥用举报时封禁
+	BanSourceAlert  = "alert"  // 告警规则触发的自动封禁
+)
+
+// RelayBan 中继和信令的封禁。被封禁的节点和来源地址不能连接信令服务器和中继，
+// 被封禁的节点、地址和端口不能作为中继会话的目标。ExpiresAt 为零值时永久有效
+type RelayBan struct {
+	gorm.Model
+	Kind      string    `gorm:"size:10;not null;index:idx_relay_bans_target" json:"kind"`
+	Value     string    `gorm:"size:64;not null;index:idx_relay_bans_target" json:"value"`
+	Reason    string    `gorm:"size:200" json:"reason"`
+	Source    string    `gorm:"size:20;not null" json:"source"`
+	CreatedBy uint      `json:"createdBy"` // 设置封禁的管理员，自动封禁时为 0
+	RuleID    uint      `json:"ruleId"`    // 触发自动封禁的告警规则
+	ReportID  uint      `json:"reportId"`  // 封禁时处理的滥用举报
+	ExpiresAt time.Time `gorm:"index" json:"expiresAt"`
+}
+
+// Active 封禁在指定时间是否有效
+func (b *RelayBan) Active(now time.Time) bool {
+	return b.ExpiresAt.IsZero() || now.Before(b.ExpiresAt)
+}
+
+// 滥用举报的处理状态
+const (
+	ReportOpen      = "open"      // 待处理
+	ReportResolved  = "resolved"  // 已处理
+	ReportDismissed = "dismissed" // 已驳回
+)
+
+// AbuseReport 滥用举报，指出滥用中继或信令的节点、地址或端口，由管理员处理
+type AbuseReport struct {
+	gorm.Model
+	ReporterID  uint      `gorm:"index" json:"reporterId"` // 提交举报的用户
+	NodeID      string    `gorm:"size:50;index" json:"nodeId"`
+	IP          string    `gorm:"size:64" json:"ip"`
+	Port        int       `json:"port"`
+	Category    string    `gorm:"size:20;not null" json:"category"`
+	Description string    `gorm:"size:1000" json:"description"`
+	Status      string    `gorm:"size:20;not null;default:'open';index" json:"status"`
+	Resolution  string    `gorm:"size:200" json:"resolution"` // 管理员的处理说明
+	ResolvedBy  uint      `json:"resolvedBy"`
+	ResolvedAt  time.Time `json:"resolvedAt"`
+	BanID       uint      `json:"banId"` // 处理举报时设置的封禁
+}
@@ -0,0 +1,45 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AlertRule 告警规则
+type AlertRule struct {
+	gorm.Model
+	UserID         uint      `gorm:"not null;index" json:"userId"`
+	Name           string    `gorm:"size:100;not null" json:"name"`
+	Type           string    `gorm:"size:50;not null" json:"type"`
+	DeviceID       uint      `json:"deviceId"` // 0 表示所有设备
+	Threshold      float64   `json:"threshold"`
+	Window         int       `json:"window"` // 统计窗口，单位：分钟
+	Channel        string    `gorm:"size:20;not null" json:"channel"`
+	Target         string    `gorm:"size:255;not null" json:"target"`
+	RepeatInterval int       `json:"repeatInterval"` // 重复通知间隔，单位：分钟，0 表示不重复
+	Enabled        bool      `gorm:"default:true" json:"enabled"`
+	SilencedUntil  time.Time `json:"silencedUntil"`
+	// 告警触发时自动封禁设备使用中继和信令的时长，单位：分钟，0 表示不封禁
+	BanDuration int `json:"banDuration"`
+}
+
+// AlertEvent 告警事件
+type AlertEvent struct {
+	gorm.Model
+	RuleID         uint      `gorm:"not null;index" json:"ruleId"`
+	UserID         uint      `gorm:"not null;index" json:"userId"`
+	Fingerprint    string    `gorm:"size:100;not null;index" json:"fingerprint"`
+	Status         string    `gorm:"size:20;not null" json:"status"`
+	Message        string    `gorm:"size:500" json:"message"`
+	Value          float64   `json:"value"`
+	FiredAt        time.Time `json:"firedAt"`
+	LastNotifiedAt time.Time `json:"lastNotifiedAt"`
+	ResolvedAt     time.Time `json:"resolvedAt"`
+}
+
+// 告警事件状态
+const (
+	AlertFiring   = "firing"   // 触发中
+	AlertResolved = "resolved" // 已恢复
+)
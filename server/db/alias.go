@@ -0,0 +1,11 @@
+package db
+
+import "gorm.io/gorm"
+
+// PeerAlias 用户为对等节点设置的别名，可以代替节点 ID 使用。别名在同一用户内唯一，以小写保存
+type PeerAlias struct {
+	gorm.Model
+	UserID uint   `gorm:"not null;uniqueIndex:idx_peer_aliases_user_name,where:deleted_at IS NULL" json:"userId"`
+	Name   string `gorm:"size:50;not null;uniqueIndex:idx_peer_aliases_user_name" json:"name"`
+	NodeID string `gorm:"size:50;not null;index" json:"nodeId"`
+}
@@ -0,0 +1,24 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APIKey 用户的 API 密钥，用于脚本和集成调用接口。授权范围在创建时选定，不超过用户角色的授权范围。
+// 仅保存密钥的哈希
+type APIKey struct {
+	gorm.Model
+	UserID    uint       `gorm:"not null;index" json:"userId"`
+	Name      string     `gorm:"size:100;not null" json:"name"`
+	Prefix    string     `gorm:"size:16" json:"prefix"` // 密钥的前几位，便于用户辨认
+	KeyHash   string     `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Scopes    []string   `gorm:"type:text;serializer:json" json:"scopes"`
+	ExpiresAt *time.Time `gorm:"index" json:"expiresAt"` // 为空时不过期
+}
+
+// Active 密钥在指定时间是否未过期
+func (k *APIKey) Active(now time.Time) bool {
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
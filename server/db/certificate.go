@@ -0,0 +1,31 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// 设备证书的吊销原因
+const (
+	RevokeSuperseded = "superseded" // 轮换后被新证书取代
+	RevokeCompromise = "compromise" // 用户吊销，如设备私钥泄露或设备丢失
+)
+
+// DeviceCertificate 内置 CA 为设备签发的证书，用于吊销检查。证书本身由设备保存
+type DeviceCertificate struct {
+	gorm.Model
+	DeviceID     uint       `gorm:"not null;index" json:"deviceId"`
+	NodeID       string     `gorm:"size:50;not null" json:"nodeId"`
+	Serial       string     `gorm:"size:64;not null;uniqueIndex" json:"serial"` // 十六进制序列号
+	Fingerprint  string     `gorm:"size:64;not null" json:"fingerprint"`        // 证书的 SHA-256 指纹
+	NotBefore    time.Time  `json:"notBefore"`
+	NotAfter     time.Time  `gorm:"index" json:"notAfter"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+	RevokeReason string     `gorm:"size:20" json:"revokeReason,omitempty"`
+}
+
+// Active 证书在指定时间是否未吊销且在有效期内
+func (c *DeviceCertificate) Active(now time.Time) bool {
+	return c.RevokedAt == nil && !now.Before(c.NotBefore) && now.Before(c.NotAfter)
+}
@@ -1,7 +1,10 @@
 package db
 
 import (
+	"database/sql"
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/senma231/p3/server/config"
 	"gorm.io/driver/postgres"
@@ -9,12 +12,9 @@ import (
 	"gorm.io/gorm/logger"
 )
 
-var (
-	DB *gorm.DB
-)
-
-// InitDB 初始化数据库连接
-func InitDB(cfg *config.Config) error {
+// Open 连接数据库并迁移表结构，配置了只读副本时注册副本路由。
+// 连接由调用方持有，不再使用时调用 Close 关闭
+func Open(cfg *config.Config) (*gorm.DB, error) {
 	dsn := fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
 		cfg.Database.Host,
@@ -40,42 +40,134 @@ func InitDB(cfg *config.Config) error {
 	// 连接数据库
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
 		Logger: logger.Default.LogMode(logLevel),
+		// 将唯一约束冲突等驱动错误转换为 gorm 错误
+		TranslateError: true,
 	})
 	if err != nil {
-		return fmt.Errorf("连接数据库失败: %w", err)
+		return nil, fmt.Errorf("连接数据库失败: %w", err)
 	}
 
 	// 设置连接池
 	sqlDB, err := db.DB()
 	if err != nil {
-		return fmt.Errorf("获取数据库连接池失败: %w", err)
+		return nil, fmt.Errorf("获取数据库连接池失败: %w", err)
 	}
 	sqlDB.SetMaxIdleConns(10)
 	sqlDB.SetMaxOpenConns(100)
 
+	// 邮箱验证字段新增前注册的用户视为已验证
+	backfillEmailVerified := !db.Migrator().HasColumn(&User{}, "EmailVerified")
+
+	// 自动迁移创建端口唯一索引前检查已有的重复记录
+	if err := checkDuplicatePorts(db); err != nil {
+		return nil, err
+	}
+
 	// 自动迁移表结构
 	if err := db.AutoMigrate(
 		&User{},
+		&TOTP{},
+		&Invitation{},
+		&APIKey{},
 		&Device{},
+		&DeviceStatus{},
+		&DeviceFilter{},
+		&DeviceCertificate{},
+		&ObserverLink{},
+		&PeerAlias{},
 		&App{},
 		&Forward{},
 		&Connection{},
 		&Stats{},
+		&DestinationStats{},
+		&Route{},
+		&RouteACL{},
+		&SpeedTestSchedule{},
+		&SpeedTestResult{},
+		&AlertRule{},
+		&AlertEvent{},
+		&DeviceEvent{},
+		&ConnectionTrace{},
+		&PunchStat{},
+		&RelayBan{},
+		&AbuseReport{},
 	); err != nil {
-		return fmt.Errorf("自动迁移表结构失败: %w", err)
+		return nil, fmt.Errorf("自动迁移表结构失败: %w", err)
+	}
+	if backfillEmailVerified {
+		if err := db.Model(&User{}).Where("1 = 1").Update("email_verified", true).Error; err != nil {
+			return nil, fmt.Errorf("更新已有用户的邮箱验证状态失败: %w", err)
+		}
+	}
+	if err := backfillDeviceStatuses(db); err != nil {
+		return nil, fmt.Errorf("创建设备状态快照失败: %w", err)
 	}
 
-	DB = db
-	return nil
+	// 只读查询路由到副本
+	if len(cfg.Database.Replicas) > 0 {
+		resolver, err := openReplicas(cfg, logLevel)
+		if err != nil {
+			return nil, err
+		}
+		if err := db.Use(resolver); err != nil {
+			resolver.Close()
+			return nil, fmt.Errorf("注册副本路由失败: %w", err)
+		}
+		resolver.Start()
+	}
+
+	return db, nil
+}
+
+// openReplicas 连接只读副本
+func openReplicas(cfg *config.Config, logLevel logger.LogLevel) (*ReplicaResolver, error) {
+	pools := make([]*sql.DB, 0, len(cfg.Database.Replicas))
+	for i, dsn := range cfg.Database.Replicas {
+		pool, err := openReplica(dsn, logLevel)
+		if err != nil {
+			for _, opened := range pools {
+				opened.Close()
+			}
+			return nil, fmt.Errorf("连接数据库副本 %d 失败: %w", i, err)
+		}
+		pools = append(pools, pool)
+	}
+
+	maxLag := time.Duration(cfg.Database.ReplicaMaxLag) * time.Second
+	return NewReplicaResolver(pools, maxLag), nil
+}
+
+// openReplica 连接单个只读副本并设置连接池
+func openReplica(dsn string, logLevel logger.LogLevel) (*sql.DB, error) {
+	replicaDB, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logLevel),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := replicaDB.DB()
+	if err != nil {
+		return nil, err
+	}
+	pool.SetMaxIdleConns(10)
+	pool.SetMaxOpenConns(100)
+	return pool, nil
 }
 
-// CloseDB 关闭数据库连接
-func CloseDB() error {
-	if DB == nil {
+// Close 关闭 Open 返回的数据库连接及其只读副本
+func Close(gdb *gorm.DB) error {
+	if gdb == nil {
 		return nil
 	}
 
-	sqlDB, err := DB.DB()
+	if plugin, ok := gdb.Config.Plugins[replicaPluginName]; ok {
+		if err := plugin.(*ReplicaResolver).Close(); err != nil {
+			return fmt.Errorf("关闭数据库副本失败: %w", err)
+		}
+	}
+
+	sqlDB, err := gdb.DB()
 	if err != nil {
 		return fmt.Errorf("获取数据库连接池失败: %w", err)
 	}
@@ -86,3 +178,34 @@ func CloseDB() error {
 
 	return nil
 }
+
+// Ping 检查数据库连接
+func Ping(gdb *gorm.DB) error {
+	if gdb == nil {
+		return fmt.Errorf("数据库未初始化")
+	}
+
+	sqlDB, err := gdb.DB()
+	if err != nil {
+		return fmt.Errorf("获取数据库连接池失败: %w", err)
+	}
+	return sqlDB.Ping()
+}
+
+// IsNotFound 检查错误是否为记录不存在
+func IsNotFound(err error) bool {
+	return errors.Is(err, gorm.ErrRecordNotFound)
+}
+
+// IsDuplicateKey 检查错误是否为唯一约束冲突
+func IsDuplicateKey(err error) bool {
+	return errors.Is(err, gorm.ErrDuplicatedKey)
+}
+
+// ErrRevisionConflict 记录已被其他请求修改
+var ErrRevisionConflict = errors.New("记录已被其他请求修改")
+
+// IsRevisionConflict 检查错误是否为修订号冲突
+func IsRevisionConflict(err error) bool {
+	return errors.Is(err, ErrRevisionConflict)
+}
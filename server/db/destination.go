@@ -0,0 +1,35 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DestinationStats 应用在一个上报周期内到单个目标地址的流量增量。
+// 规则的目标主机为域名时，解析出的每个地址分别记录
+type DestinationStats struct {
+	gorm.Model
+	UserID        uint   `gorm:"not null" json:"userId"`
+	DeviceID      uint   `gorm:"not null;index" json:"deviceId"`
+	AppID         uint   `gorm:"not null;index:idx_destination_app_time" json:"appId"`
+	Protocol      string `gorm:"size:10;not null" json:"protocol"`
+	Host          string `gorm:"size:255" json:"host"`            // 规则中的目标主机
+	Address       string `gorm:"size:64;not null" json:"address"` // 实际连接的 IP 和端口
+	BytesSent     uint64 `json:"bytesSent"`
+	BytesReceived uint64 `json:"bytesReceived"`
+	Connections   uint64 `json:"connections"`
+	// ReportedAt 上报时间，按时间范围汇总时使用
+	ReportedAt time.Time `gorm:"index:idx_destination_app_time" json:"reportedAt"`
+}
+
+// DestinationTotal 一段时间内到单个目标地址的流量合计
+type DestinationTotal struct {
+	Protocol      string    `json:"protocol"`
+	Host          string    `json:"host"`
+	Address       string    `json:"address"`
+	BytesSent     uint64    `json:"bytesSent"`
+	BytesReceived uint64    `json:"bytesReceived"`
+	Connections   uint64    `json:"connections"`
+	LastReported  time.Time `json:"lastReported"`
+}
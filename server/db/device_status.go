@@ -0,0 +1,140 @@
+package db
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// DeviceStatus 设备的运行状态快照。
+//
+// 心跳和批量上报频繁更新这些字段，设备数量较多时在设备表上造成大量行锁和修订号之外的写入，
+// 因此与设备清单分表保存：更新状态只写入本表，不锁设备记录；查询设备时通过 WithStatus 合并。
+// 设备表中的同名列只保留创建时的值，不再更新
+type DeviceStatus struct {
+	DeviceID   uint          `gorm:"primaryKey;autoIncrement:false" json:"deviceId"`
+	UserID     uint          `gorm:"not null;index:idx_device_status_user" json:"userId"`
+	Status     string        `gorm:"size:20;default:'offline';index:idx_device_status_user;index" json:"status"`
+	NATType    string        `gorm:"size:50" json:"natType"`
+	ExternalIP string        `gorm:"size:50" json:"externalIP"`
+	LocalIP    string        `gorm:"size:50" json:"localIP"`
+	Version    string        `gorm:"size:20" json:"version"`
+	OS         string        `gorm:"size:20" json:"os"`
+	Arch       string        `gorm:"size:20" json:"arch"`
+	Region     string        `gorm:"size:50" json:"region"`
+	LastSeenAt time.Time     `gorm:"index" json:"lastSeenAt"`
+	Resources  ResourceUsage `gorm:"embedded;embeddedPrefix:resource_" json:"resources"`
+	// 设备时钟相对服务端的偏差，单位：毫秒，由心跳测得
+	ClockSkewMs int64          `gorm:"default:0" json:"clockSkewMs"`
+	SelfTest    SelfTestResult `gorm:"embedded;embeddedPrefix:self_test_" json:"selfTest"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+}
+
+// deviceStatusColumns 属于状态快照的列
+var deviceStatusColumns = map[string]bool{
+	"status":                   true,
+	"nat_type":                 true,
+	"external_ip":              true,
+	"local_ip":                 true,
+	"version":                  true,
+	"os":                       true,
+	"arch":                     true,
+	"region":                   true,
+	"last_seen_at":             true,
+	"resource_connections":     true,
+	"resource_goroutines":      true,
+	"resource_buffer_memory":   true,
+	"resource_memory_estimate": true,
+	"resource_rejected":        true,
+	"resource_pressure":        true,
+	"clock_skew_ms":            true,
+	"self_test_tested_at":      true,
+	"self_test_udp_reachable":  true,
+	"self_test_tcp_reachable":  true,
+	"self_test_udp_latency":    true,
+	"self_test_tcp_latency":    true,
+	"self_test_mapping":        true,
+	"self_test_nat_mismatch":   true,
+}
+
+// StatusOf 返回设备当前的状态快照
+func StatusOf(device *Device) DeviceStatus {
+	return DeviceStatus{
+		DeviceID:    device.ID,
+		UserID:      device.UserID,
+		Status:      device.Status,
+		NATType:     device.NATType,
+		ExternalIP:  device.ExternalIP,
+		LocalIP:     device.LocalIP,
+		Version:     device.Version,
+		OS:          device.OS,
+		Arch:        device.Arch,
+		Region:      device.Region,
+		LastSeenAt:  device.LastSeenAt,
+		Resources:   device.Resources,
+		ClockSkewMs: device.ClockSkewMs,
+		SelfTest:    device.SelfTest,
+	}
+}
+
+// SplitDeviceUpdates 将按列名的设备更新拆分为状态快照的更新和设备清单的更新。
+// 用户 ID 同时出现在两者中，使状态快照按用户查询时与设备保持一致
+func SplitDeviceUpdates(updates map[string]interface{}) (status, inventory map[string]interface{}) {
+	status = make(map[string]interface{})
+	inventory = make(map[string]interface{})
+	for column, value := range updates {
+		switch {
+		case deviceStatusColumns[column]:
+			status[column] = value
+		case column == "user_id":
+			status[column] = value
+			inventory[column] = value
+		default:
+			inventory[column] = value
+		}
+	}
+	return status, inventory
+}
+
+var (
+	deviceSelectOnce sync.Once
+	deviceSelect     string
+)
+
+// WithStatus 查询设备时合并状态快照，状态列取自 device_statuses，没有快照的设备视为离线。
+// 与其他表关联时同名的列需要加上表名，例如 devices.user_id
+func WithStatus(tx *gorm.DB) *gorm.DB {
+	deviceSelectOnce.Do(func() {
+		s, err := schema.Parse(&Device{}, &sync.Map{}, schema.NamingStrategy{})
+		if err != nil {
+			panic(err)
+		}
+		columns := make([]string, 0, len(s.DBNames))
+		for _, name := range s.DBNames {
+			switch {
+			case name == "status":
+				columns = append(columns, "COALESCE(device_statuses.status, 'offline') AS status")
+			case deviceStatusColumns[name]:
+				columns = append(columns, "device_statuses."+name+" AS "+name)
+			default:
+				columns = append(columns, "devices."+name)
+			}
+		}
+		deviceSelect = strings.Join(columns, ", ")
+	})
+	return tx.Select(deviceSelect).Joins("LEFT JOIN device_statuses ON device_statuses.device_id = devices.id")
+}
+
+// backfillDeviceStatuses 为没有状态快照的设备按设备表中的状态创建快照，
+// 用于状态分表前创建的设备和从备份恢复的设备
+func backfillDeviceStatuses(gdb *gorm.DB) error {
+	return gdb.Exec(`INSERT INTO device_statuses (device_id, user_id, status, nat_type, external_ip, local_ip, version, os, arch, region, last_seen_at,
+		resource_connections, resource_goroutines, resource_buffer_memory, resource_memory_estimate, resource_rejected, resource_pressure, updated_at)
+	SELECT id, user_id, COALESCE(status, 'offline'), nat_type, external_ip, local_ip, version, os, arch, region, last_seen_at,
+		resource_connections, resource_goroutines, resource_buffer_memory, resource_memory_estimate, resource_rejected, resource_pressure, updated_at
+	FROM devices
+	WHERE deleted_at IS NULL AND id NOT IN (SELECT device_id FROM device_statuses)`).Error
+}
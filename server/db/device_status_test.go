@@ -0,0 +1,48 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestSplitDeviceUpdates(t *testing.T) {
+	status, inventory := SplitDeviceUpdates(map[string]interface{}{
+		"status":            "online",
+		"resource_pressure": "high",
+		"name":              "nas",
+		"user_id":           uint(2),
+	})
+	if len(status) != 3 || status["status"] != "online" || status["resource_pressure"] != "high" || status["user_id"] != uint(2) {
+		t.Fatalf("状态快照的更新错误: %v", status)
+	}
+	if len(inventory) != 2 || inventory["name"] != "nas" || inventory["user_id"] != uint(2) {
+		t.Fatalf("设备清单的更新错误: %v", inventory)
+	}
+}
+
+func TestWithStatus(t *testing.T) {
+	gdb, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	var devices []Device
+	sql := gdb.Scopes(WithStatus).Where("devices.user_id = ?", 1).Find(&devices).Statement.SQL.String()
+
+	for _, want := range []string{
+		"COALESCE(device_statuses.status, 'offline') AS status",
+		"device_statuses.resource_pressure AS resource_pressure",
+		"device_statuses.self_test_nat_mismatch AS self_test_nat_mismatch",
+		"devices.node_id",
+		"LEFT JOIN device_statuses ON device_statuses.device_id = devices.id",
+	} {
+		if !strings.Contains(sql, want) {
+			t.Errorf("查询应包含 %q: %s", want, sql)
+		}
+	}
+	if strings.Contains(sql, "devices.status") || strings.Contains(sql, "devices.last_seen_at") {
+		t.Errorf("运行状态不应取自设备表: %s", sql)
+	}
+}
@@ -0,0 +1,28 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeviceEvent 设备上报的事件，如崩溃恢复
+type DeviceEvent struct {
+	gorm.Model
+	DeviceID   uint      `gorm:"not null;index" json:"deviceId"`
+	Type       string    `gorm:"size:50;not null" json:"type"`
+	App        string    `gorm:"size:100" json:"app,omitempty"`
+	Detail     string    `gorm:"size:500" json:"detail,omitempty"`
+	OccurredAt time.Time `gorm:"index" json:"occurredAt"`
+}
+
+// EventRelayLimited 中继会话超出限制时由服务器记录的设备事件类型
+const EventRelayLimited = "relay-limited"
+
+// EventUnexpectedInbound 客户端应用的监听端口收到来源或时间不符合预期的连接时上报的设备事件类型，
+// 同一来源在一个上报周期内的连接合并为一个事件
+const EventUnexpectedInbound = "unexpected-inbound"
+
+// EventClockSkew 设备时钟与服务端的偏差超过告警阈值时由服务器记录的设备事件类型，
+// 偏差恢复到阈值以内后再次超出时重新记录
+const EventClockSkew = "clock-skew"
@@ -0,0 +1,29 @@
+package db
+
+// TrafficTotal 一段时间内的流量合计
+type TrafficTotal struct {
+	BytesSent     uint64 `json:"bytesSent"`
+	BytesReceived uint64 `json:"bytesReceived"`
+	Connections   uint64 `json:"connections"`
+}
+
+// DeviceTraffic 一段时间内单个设备的流量合计
+type DeviceTraffic struct {
+	DeviceID      uint   `json:"deviceId"`
+	UserID        uint   `json:"userId"`
+	BytesSent     uint64 `json:"bytesSent"`
+	BytesReceived uint64 `json:"bytesReceived"`
+	Connections   uint64 `json:"connections"`
+}
+
+// TenantSummary 单个租户（用户）的资源和一段时间内的流量合计
+type TenantSummary struct {
+	UserID        uint   `json:"userId"`
+	Username      string `json:"username"`
+	Devices       int64  `json:"devices"`
+	OnlineDevices int64  `json:"onlineDevices"`
+	Apps          int64  `json:"apps"`
+	BytesSent     uint64 `json:"bytesSent"`
+	BytesReceived uint64 `json:"bytesReceived"`
+	Connections   uint64 `json:"connections"`
+}
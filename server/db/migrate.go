@@ -0,0 +1,94 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// portIndex 端口唯一索引，owner 为端口所属的设备或用户列
+type portIndex struct {
+	model interface{}
+	table string
+	name  string
+	owner string
+}
+
+// portIndexes 应用和转发规则的端口唯一索引，旧版本创建的表中没有这些索引
+var portIndexes = []portIndex{
+	{model: &App{}, table: "apps", name: "idx_apps_device_port", owner: "device_id"},
+	{model: &Forward{}, table: "forwards", name: "idx_forwards_user_port", owner: "user_id"},
+}
+
+// portDuplicate 同一设备或用户上占用相同协议和源端口的未删除记录
+type portDuplicate struct {
+	Owner    uint
+	Protocol string
+	SrcPort  int
+	Count    int64
+	IDs      []uint `gorm:"-"`
+}
+
+// duplicatePorts 查询唯一索引覆盖的列上重复的未删除记录
+func duplicatePorts(gdb *gorm.DB, idx portIndex, dest *[]portDuplicate) *gorm.DB {
+	columns := idx.owner + ", protocol, src_port"
+	return gdb.Model(idx.model).
+		Select(idx.owner + " AS owner, protocol, src_port, COUNT(*) AS count").
+		Group(columns).
+		Having("COUNT(*) > 1").
+		Order(columns).
+		Find(dest)
+}
+
+// checkDuplicatePorts 在自动迁移创建端口唯一索引前检查已有数据。
+// 旧版本并发创建可能留下占用同一端口的记录，此时创建索引会失败，
+// 这里列出冲突的记录，由管理员删除或修改后重新启动，不自动删除用户的配置
+func checkDuplicatePorts(gdb *gorm.DB) error {
+	migrator := gdb.Migrator()
+	var conflicts []string
+	for _, idx := range portIndexes {
+		if !migrator.HasTable(idx.model) || migrator.HasIndex(idx.model, idx.name) {
+			continue
+		}
+
+		var dups []portDuplicate
+		if err := duplicatePorts(gdb, idx, &dups).Error; err != nil {
+			return fmt.Errorf("检查 %s 表的重复端口失败: %w", idx.table, err)
+		}
+		for i := range dups {
+			d := &dups[i]
+			if err := gdb.Model(idx.model).
+				Where(idx.owner+" = ? AND protocol = ? AND src_port = ?", d.Owner, d.Protocol, d.SrcPort).
+				Order("id").
+				Pluck("id", &d.IDs).Error; err != nil {
+				return fmt.Errorf("查询 %s 表的重复记录失败: %w", idx.table, err)
+			}
+		}
+		conflicts = append(conflicts, describeDuplicates(idx, dups)...)
+	}
+
+	if len(conflicts) > 0 {
+		return fmt.Errorf("以下记录占用了相同的端口，无法创建唯一索引，请删除或修改后重新启动: %s", strings.Join(conflicts, "; "))
+	}
+	return nil
+}
+
+// describeDuplicates 描述重复的记录，便于管理员定位
+func describeDuplicates(idx portIndex, dups []portDuplicate) []string {
+	owner := "设备"
+	if idx.owner == "user_id" {
+		owner = "用户"
+	}
+
+	descriptions := make([]string, 0, len(dups))
+	for _, d := range dups {
+		ids := make([]string, 0, len(d.IDs))
+		for _, id := range d.IDs {
+			ids = append(ids, fmt.Sprint(id))
+		}
+		descriptions = append(descriptions, fmt.Sprintf("%s 表中%s %d 的 %s/%d 有 %d 条记录 (ID: %s)",
+			idx.table, owner, d.Owner, d.Protocol, d.SrcPort, d.Count, strings.Join(ids, ", ")))
+	}
+	return descriptions
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func TestDuplicatePorts(t *testing.T) {
+	gdb, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+
+	for _, idx := range portIndexes {
+		var dups []portDuplicate
+		sql := duplicatePorts(gdb, idx, &dups).Statement.SQL.String()
+		for _, want := range []string{
+			"SELECT " + idx.owner + " AS owner, protocol, src_port, COUNT(*) AS count",
+			"FROM `" + idx.table + "`",
+			"`" + idx.table + "`.`deleted_at` IS NULL",
+			"GROUP BY " + idx.owner + ", protocol, src_port",
+			"HAVING COUNT(*) > 1",
+		} {
+			if !strings.Contains(sql, want) {
+				t.Errorf("%s 的查询缺少 %q: %s", idx.table, want, sql)
+			}
+		}
+	}
+}
+
+func TestDescribeDuplicates(t *testing.T) {
+	got := describeDuplicates(portIndexes[0], []portDuplicate{
+		{Owner: 3, Protocol: "tcp", SrcPort: 8080, Count: 2, IDs: []uint{7, 9}},
+	})
+	want := "apps 表中设备 3 的 tcp/8080 有 2 条记录 (ID: 7, 9)"
+	if len(got) != 1 || got[0] != want {
+		t.Fatalf("重复记录的描述为 %q", got)
+	}
+
+	got = describeDuplicates(portIndexes[1], []portDuplicate{
+		{Owner: 1, Protocol: "udp", SrcPort: 53, Count: 3, IDs: []uint{2, 4, 5}},
+	})
+	if len(got) != 1 || !strings.HasPrefix(got[0], "forwards 表中用户 1 的 udp/53") {
+		t.Fatalf("重复记录的描述为 %q", got)
+	}
+}
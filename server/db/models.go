@@ -1,6 +1,9 @@
 package db
 
 import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -9,15 +12,59 @@ import (
 // User 用户模型
 type User struct {
 	gorm.Model
-	Username    string    `gorm:"size:50;not null;uniqueIndex" json:"username"`
-	Password    string    `gorm:"size:100;not null" json:"-"`
-	Email       string    `gorm:"size:100;uniqueIndex" json:"email"`
-	LastLoginAt time.Time `json:"lastLoginAt"`
-	IsAdmin     bool      `gorm:"default:false" json:"isAdmin"`
-	Devices     []Device  `gorm:"foreignKey:UserID" json:"devices,omitempty"`
+	Username      string          `gorm:"size:50;not null;uniqueIndex" json:"username"`
+	Password      string          `gorm:"size:100;not null" json:"-"`
+	Email         string          `gorm:"size:100;uniqueIndex" json:"email"`
+	EmailVerified bool            `gorm:"default:false" json:"emailVerified"`
+	LastLoginAt   time.Time       `json:"lastLoginAt"`
+	IsAdmin       bool            `gorm:"default:false" json:"isAdmin"`
+	DisplayName   string          `gorm:"size:50" json:"displayName"`
+	Timezone      string          `gorm:"size:64" json:"timezone"` // IANA 时区名称，例如 Asia/Shanghai
+	Preferences   UserPreferences `gorm:"type:text" json:"preferences"`
+	AuthSource    string          `gorm:"size:20" json:"authSource,omitempty"` // 外部认证源，例如 ldap，为空表示本地账户
+	Devices       []Device        `gorm:"foreignKey:UserID" json:"devices,omitempty"`
 }
 
-// Device 设备模型
+// UserPreferences 用户界面和通知偏好，由控制台和移动端共用
+type UserPreferences struct {
+	Theme         string          `json:"theme,omitempty"`         // light、dark 或 system
+	PageSize      int             `json:"pageSize,omitempty"`      // 列表默认每页条数
+	Notifications map[string]bool `json:"notifications,omitempty"` // 按通知类型开关，未设置的类型默认开启
+}
+
+// Value 以 JSON 保存偏好。实现 driver.Valuer 而不使用 serializer 标签，
+// 按列名更新时传入的 UserPreferences 也能正确编码
+func (p UserPreferences) Value() (driver.Value, error) {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 解析 JSON 格式的偏好，空值表示未设置任何偏好
+func (p *UserPreferences) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*p = UserPreferences{}
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("无法解析用户偏好: %T", value)
+	}
+	if len(data) == 0 {
+		*p = UserPreferences{}
+		return nil
+	}
+	return json.Unmarshal(data, p)
+}
+
+// Device 设备模型。Status 至 LastSeenAt 的运行状态、Resources、ClockSkewMs 和 SelfTest 保存在状态快照 DeviceStatus 中，
+// 查询时通过 WithStatus 合并
 type Device struct {
 	gorm.Model
 	UserID     uint      `gorm:"not null" json:"userId"`
@@ -31,35 +78,116 @@ type Device struct {
 	Version    string    `gorm:"size:20" json:"version"`
 	OS         string    `gorm:"size:20" json:"os"`
 	Arch       string    `gorm:"size:20" json:"arch"`
+	Region     string    `gorm:"size:50" json:"region"`
 	LastSeenAt time.Time `json:"lastSeenAt"`
+	Revision   uint      `gorm:"not null;default:1" json:"revision"`
 	Apps       []App     `gorm:"foreignKey:DeviceID" json:"apps,omitempty"`
+	// 出口节点
+	ExitNodeAllowed   bool `gorm:"default:false" json:"exitNodeAllowed"`
+	AdvertiseExitNode bool `gorm:"default:false" json:"advertiseExitNode"`
+	// 应用配置版本号，设备的应用每次变化时递增，客户端据此增量同步
+	AppsVersion uint `gorm:"not null;default:0" json:"appsVersion"`
+	// 用户为设备设置的标签，用于筛选设备和批量操作
+	Labels Labels `gorm:"type:text" json:"labels"`
+	// 客户端最近一次上报的资源占用和压力
+	Resources ResourceUsage `gorm:"embedded;embeddedPrefix:resource_" json:"resources"`
+	// 最近一次心跳测得的设备时钟相对服务端的偏差，单位：毫秒，正数表示设备时钟偏快
+	ClockSkewMs int64 `gorm:"default:0" json:"clockSkewMs"`
+	// 客户端最近一次上报的连通性自检结果
+	SelfTest SelfTestResult `gorm:"embedded;embeddedPrefix:self_test_" json:"selfTest"`
+}
+
+// ResourceUsage 客户端的资源占用和压力。压力为 high 时有资源超过预算的 80%，
+// 为 critical 时客户端正在拒绝新的连接
+type ResourceUsage struct {
+	Connections    int    `json:"connections"`
+	Goroutines     int    `json:"goroutines"`
+	BufferMemory   int64  `json:"bufferMemory"`
+	MemoryEstimate int64  `json:"memoryEstimate"`
+	Rejected       uint64 `json:"rejected"`
+	Pressure       string `gorm:"size:20" json:"pressure"`
+}
+
+// SelfTestResult 客户端定期连通性自检的结果。延迟单位为毫秒，不可达时为 0；
+// Mapping 为自检观察到的 NAT 映射行为，NATMismatch 为 true 时与客户端检测到的 NAT 类型不一致
+type SelfTestResult struct {
+	TestedAt     time.Time `json:"testedAt"`
+	UDPReachable bool      `json:"udpReachable"`
+	TCPReachable bool      `json:"tcpReachable"`
+	UDPLatency   int64     `json:"udpLatency"`
+	TCPLatency   int64     `json:"tcpLatency"`
+	Mapping      string    `gorm:"size:30" json:"mapping"`
+	NATMismatch  bool      `json:"natMismatch"`
+}
+
+// Labels 设备标签，键为标签名，值可以为空
+type Labels map[string]string
+
+// Value 以 JSON 保存标签
+func (l Labels) Value() (driver.Value, error) {
+	if l == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(map[string]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 解析 JSON 格式的标签，空值表示没有标签
+func (l *Labels) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*l = Labels{}
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("无法解析设备标签: %T", value)
+	}
+	if len(data) == 0 {
+		*l = Labels{}
+		return nil
+	}
+	return json.Unmarshal(data, (*map[string]string)(l))
 }
 
 // App 应用模型
 type App struct {
 	gorm.Model
 	UserID      uint   `gorm:"not null" json:"userId"`
-	DeviceID    uint   `gorm:"not null" json:"deviceId"`
+	DeviceID    uint   `gorm:"not null;uniqueIndex:idx_apps_device_port,where:deleted_at IS NULL" json:"deviceId"`
 	Name        string `gorm:"size:50;not null" json:"name"`
-	Protocol    string `gorm:"size:10;not null" json:"protocol"`
-	SrcPort     int    `gorm:"not null" json:"srcPort"`
+	Protocol    string `gorm:"size:10;not null;uniqueIndex:idx_apps_device_port" json:"protocol"`
+	SrcPort     int    `gorm:"not null;uniqueIndex:idx_apps_device_port" json:"srcPort"`
 	PeerNode    string `gorm:"size:50;not null" json:"peerNode"`
 	DstPort     int    `gorm:"not null" json:"dstPort"`
 	DstHost     string `gorm:"size:50;not null" json:"dstHost"`
 	Status      string `gorm:"size:20;default:'stopped'" json:"status"`
 	Description string `gorm:"size:200" json:"description"`
+	Revision    uint   `gorm:"not null;default:1" json:"revision"`
+	// 客户端最近一次上报的健康检查结果
+	HealthDetail    string     `gorm:"size:500" json:"healthDetail,omitempty"`
+	HealthCheckedAt *time.Time `json:"healthCheckedAt,omitempty"`
+	// 最近一次变化时设备的应用配置版本号，删除时也会更新
+	ConfigVersion uint `gorm:"not null;default:0;index" json:"configVersion"`
 }
 
 // Forward 转发规则模型
 type Forward struct {
 	gorm.Model
-	UserID      uint   `gorm:"not null" json:"userId"`
-	Protocol    string `gorm:"size:10;not null" json:"protocol"`
-	SrcPort     int    `gorm:"not null" json:"srcPort"`
+	UserID      uint   `gorm:"not null;uniqueIndex:idx_forwards_user_port,where:deleted_at IS NULL" json:"userId"`
+	Protocol    string `gorm:"size:10;not null;uniqueIndex:idx_forwards_user_port" json:"protocol"`
+	SrcPort     int    `gorm:"not null;uniqueIndex:idx_forwards_user_port" json:"srcPort"`
 	DstHost     string `gorm:"size:50;not null" json:"dstHost"`
 	DstPort     int    `gorm:"not null" json:"dstPort"`
 	Description string `gorm:"size:200" json:"description"`
 	Enabled     bool   `gorm:"default:false" json:"enabled"`
+	Revision    uint   `gorm:"not null;default:1" json:"revision"`
 }
 
 // Connection 连接模型
@@ -73,6 +201,7 @@ type Connection struct {
 	LastActiveAt   time.Time `json:"lastActiveAt"`
 	BytesSent      uint64    `json:"bytesSent"`
 	BytesReceived  uint64    `json:"bytesReceived"`
+	MTU            int       `json:"mtu"` // UDP 连接协商的路径 MTU，TCP 连接为 0
 }
 
 // Stats 统计模型
@@ -109,5 +238,30 @@ type TOTP struct {
 	Enabled     bool      `gorm:"default:false" json:"enabled"`
 	Verified    bool      `gorm:"default:false" json:"verified"`
 	LastUsedAt  time.Time `json:"lastUsedAt"`
-	BackupCodes []string  `gorm:"type:text" json:"-"`
+	BackupCodes []string  `gorm:"type:text;serializer:json" json:"-"`
+	// Drift 用户终端时钟相对服务端的偏差，单位为 TOTP 周期，验证时以此为窗口中心
+	Drift int `gorm:"default:0" json:"drift"`
+	// DriftAcceptances 在标准窗口（前后各一个周期）之外接受验证码的次数，LastDriftAt 为最近一次的时间
+	DriftAcceptances int       `gorm:"default:0" json:"driftAcceptances"`
+	LastDriftAt      time.Time `json:"lastDriftAt"`
+}
+
+// Invitation 注册邀请模型，仅保存邀请码的哈希
+type Invitation struct {
+	gorm.Model
+	CodeHash  string    `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	Role      string    `gorm:"size:20;not null" json:"role"` // 通过邀请注册的用户的角色
+	MaxUses   int       `gorm:"not null" json:"maxUses"`
+	UsedCount int       `gorm:"default:0" json:"usedCount"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	CreatedBy uint      `json:"createdBy"`
+	Note      string    `gorm:"size:200" json:"note"`
+}
+
+// DeviceFilter 用户保存的设备筛选条件
+type DeviceFilter struct {
+	gorm.Model
+	UserID     uint   `gorm:"not null;uniqueIndex:idx_device_filters_user_name,where:deleted_at IS NULL" json:"userId"`
+	Name       string `gorm:"size:50;not null;uniqueIndex:idx_device_filters_user_name" json:"name"`
+	Expression string `gorm:"size:500;not null" json:"expression"`
 }
@@ -0,0 +1,23 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ObserverLink 设备的只读观察链接，持有链接的访客无需登录即可查看设备的实时状态和统计，不能操作设备。
+// 仅保存令牌的哈希
+type ObserverLink struct {
+	gorm.Model
+	UserID    uint      `gorm:"not null;index" json:"userId"`
+	DeviceID  uint      `gorm:"not null;index" json:"deviceId"`
+	TokenHash string    `gorm:"size:64;not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time `gorm:"index" json:"expiresAt"`
+	Note      string    `gorm:"size:200" json:"note"`
+}
+
+// Active 链接在指定时间是否未过期
+func (l *ObserverLink) Active(now time.Time) bool {
+	return now.Before(l.ExpiresAt)
+}
@@ -0,0 +1,14 @@
+package db
+
+import "time"
+
+// PunchStat 一组 NAT 类型组合的打洞结果累计。只按发起方和对端的 NAT 类型汇总，
+// 不记录设备、用户和地址，用于按部署环境的实际结果调整打洞判断
+type PunchStat struct {
+	ID        uint      `gorm:"primarykey" json:"-"`
+	LocalNAT  string    `gorm:"size:50;not null;uniqueIndex:idx_punch_stats_nat" json:"localNat"`
+	RemoteNAT string    `gorm:"size:50;not null;uniqueIndex:idx_punch_stats_nat" json:"remoteNat"`
+	Attempts  uint64    `gorm:"not null;default:0" json:"attempts"`
+	Successes uint64    `gorm:"not null;default:0" json:"successes"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
@@ -0,0 +1,199 @@
+package db
+
+import (
+	"database/sql"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"gorm.io/gorm"
+)
+
+// replicaCheckInterval 检查副本复制延迟的间隔
+const replicaCheckInterval = 10 * time.Second
+
+// replicaLagQuery 查询 PostgreSQL 副本的复制延迟（秒），已回放全部 WAL 或不是副本时为 0
+const replicaLagQuery = `SELECT COALESCE(CASE
+	WHEN pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn() THEN 0
+	ELSE EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())
+END, 0)`
+
+// replica 只读副本
+type replica struct {
+	pool    *sql.DB
+	healthy atomic.Bool
+}
+
+// ReplicaResolver GORM 插件，将只读查询路由到副本。
+//
+// 事务和加锁查询使用主库；查询的表在 maxLag 内写入过时也使用主库，
+// 保证写入后立即读取能看到刚写入的数据。查询不携带请求上下文，因此按表而不是按请求判断。
+// 复制延迟超过 maxLag 或无法连接的副本暂停使用，所有副本都不可用时回退到主库
+type ReplicaResolver struct {
+	replicas  []*replica
+	maxLag    time.Duration
+	next      atomic.Uint32
+	writes    map[string]time.Time // 各表最近一次写入的时间
+	lastWrite time.Time            // 最近一次无法确定表的写入，例如原生 SQL
+	now       func() time.Time
+	mu        sync.Mutex
+	stopCh    chan struct{}
+	stopOnce  sync.Once
+}
+
+// NewReplicaResolver 创建副本路由
+func NewReplicaResolver(pools []*sql.DB, maxLag time.Duration) *ReplicaResolver {
+	r := &ReplicaResolver{
+		maxLag: maxLag,
+		writes: make(map[string]time.Time),
+		now:    time.Now,
+		stopCh: make(chan struct{}),
+	}
+	for _, pool := range pools {
+		rep := &replica{pool: pool}
+		rep.healthy.Store(true)
+		r.replicas = append(r.replicas, rep)
+	}
+	return r
+}
+
+// replicaPluginName 副本路由在 GORM 中注册的插件名称
+const replicaPluginName = "p3:replicas"
+
+// Name 插件名称
+func (r *ReplicaResolver) Name() string {
+	return replicaPluginName
+}
+
+// Initialize 注册路由和记录写入的回调
+func (r *ReplicaResolver) Initialize(db *gorm.DB) error {
+	callbacks := []error{
+		db.Callback().Query().Before("gorm:query").Register("p3:replica_query", r.route),
+		db.Callback().Row().Before("gorm:row").Register("p3:replica_row", r.route),
+		db.Callback().Create().After("gorm:create").Register("p3:replica_create", r.markWrite),
+		db.Callback().Update().After("gorm:update").Register("p3:replica_update", r.markWrite),
+		db.Callback().Delete().After("gorm:delete").Register("p3:replica_delete", r.markWrite),
+		db.Callback().Raw().After("gorm:raw").Register("p3:replica_raw", r.markWrite),
+	}
+	for _, err := range callbacks {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// route 只读查询使用健康的副本
+func (r *ReplicaResolver) route(db *gorm.DB) {
+	stmt := db.Statement
+	if _, inTx := stmt.ConnPool.(gorm.TxCommitter); inTx {
+		return
+	}
+	if _, locking := stmt.Clauses["FOR"]; locking {
+		return
+	}
+	if r.recentlyWritten(stmt.Table) {
+		return
+	}
+	if pool := r.pick(); pool != nil {
+		stmt.ConnPool = pool
+	}
+}
+
+// markWrite 记录写入的表
+func (r *ReplicaResolver) markWrite(db *gorm.DB) {
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if table := db.Statement.Table; table != "" {
+		r.writes[table] = now
+	} else {
+		r.lastWrite = now
+	}
+}
+
+// recentlyWritten 判断表或无法确定表的原生 SQL 是否在 maxLag 内写入过
+func (r *ReplicaResolver) recentlyWritten(table string) bool {
+	now := r.now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if now.Sub(r.lastWrite) < r.maxLag {
+		return true
+	}
+	if table == "" {
+		// 无法确定查询的表时，任何表的近期写入都使用主库
+		for _, t := range r.writes {
+			if now.Sub(t) < r.maxLag {
+				return true
+			}
+		}
+		return false
+	}
+	t, ok := r.writes[table]
+	return ok && now.Sub(t) < r.maxLag
+}
+
+// pick 轮询选择健康的副本，没有时返回 nil
+func (r *ReplicaResolver) pick() gorm.ConnPool {
+	n := len(r.replicas)
+	start := int(r.next.Add(1))
+	for i := 0; i < n; i++ {
+		rep := r.replicas[(start+i)%n]
+		if rep.healthy.Load() {
+			return rep.pool
+		}
+	}
+	return nil
+}
+
+// Start 定期检查副本的复制延迟
+func (r *ReplicaResolver) Start() {
+	r.checkLag()
+	go func() {
+		ticker := time.NewTicker(replicaCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-r.stopCh:
+				return
+			case <-ticker.C:
+				r.checkLag()
+			}
+		}
+	}()
+}
+
+// checkLag 查询各副本的复制延迟，更新是否可用
+func (r *ReplicaResolver) checkLag() {
+	for i, rep := range r.replicas {
+		var lag float64
+		err := rep.pool.QueryRow(replicaLagQuery).Scan(&lag)
+		healthy := err == nil && time.Duration(lag*float64(time.Second)) <= r.maxLag
+
+		if was := rep.healthy.Swap(healthy); was != healthy {
+			if healthy {
+				logger.Info("数据库副本 %d 已恢复使用", i)
+			} else if err != nil {
+				logger.Warn("数据库副本 %d 不可用，暂停使用: %v", i, err)
+			} else {
+				logger.Warn("数据库副本 %d 复制延迟 %.1f 秒，暂停使用", i, lag)
+			}
+		}
+	}
+}
+
+// Close 停止检查并关闭副本连接
+func (r *ReplicaResolver) Close() error {
+	r.stopOnce.Do(func() { close(r.stopCh) })
+	var firstErr error
+	for _, rep := range r.replicas {
+		if err := rep.pool.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/utils/tests"
+)
+
+// newReplicaTestDB 创建只生成 SQL 的数据库，返回每次查询实际使用的连接
+func newReplicaTestDB(t *testing.T, r *ReplicaResolver) (*gorm.DB, *gorm.ConnPool) {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{DryRun: true})
+	if err != nil {
+		t.Fatalf("打开数据库失败: %v", err)
+	}
+	if err := db.Use(r); err != nil {
+		t.Fatalf("注册副本路由失败: %v", err)
+	}
+
+	var used gorm.ConnPool
+	if err := db.Callback().Query().Before("gorm:query").After("p3:replica_query").
+		Register("test:capture", func(tx *gorm.DB) { used = tx.Statement.ConnPool }); err != nil {
+		t.Fatalf("注册回调失败: %v", err)
+	}
+	return db, &used
+}
+
+func TestReplicaRouting(t *testing.T) {
+	replicaA, replicaB := &sql.DB{}, &sql.DB{}
+	r := NewReplicaResolver([]*sql.DB{replicaA, replicaB}, 2*time.Second)
+	now := time.Now()
+	r.now = func() time.Time { return now }
+	db, used := newReplicaTestDB(t, r)
+
+	// 只读查询轮询使用副本
+	seen := map[gorm.ConnPool]bool{}
+	for i := 0; i < 2; i++ {
+		db.Find(&[]Device{})
+		seen[*used] = true
+	}
+	if !seen[replicaA] || !seen[replicaB] {
+		t.Fatal("只读查询应轮询使用各副本")
+	}
+
+	// 写入后 maxLag 内读取该表使用主库，其他表仍使用副本
+	db.Create(&Device{NodeID: "node-a"})
+	db.Find(&[]Device{})
+	if *used == replicaA || *used == replicaB {
+		t.Fatal("刚写入的表应从主库读取")
+	}
+	db.Find(&[]App{})
+	if *used != replicaA && *used != replicaB {
+		t.Fatal("未写入的表应从副本读取")
+	}
+	now = now.Add(3 * time.Second)
+	db.Find(&[]Device{})
+	if *used != replicaA && *used != replicaB {
+		t.Fatal("超过 maxLag 后应恢复从副本读取")
+	}
+
+	// 加锁查询使用主库
+	db.Clauses(clause.Locking{Strength: "UPDATE"}).Find(&[]App{})
+	if *used == replicaA || *used == replicaB {
+		t.Fatal("加锁查询应使用主库")
+	}
+
+	// 所有副本都不可用时回退到主库
+	for _, rep := range r.replicas {
+		rep.healthy.Store(false)
+	}
+	db.Find(&[]App{})
+	if *used == replicaA || *used == replicaB {
+		t.Fatal("副本都不可用时应使用主库")
+	}
+}
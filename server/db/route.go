@@ -0,0 +1,23 @@
+package db
+
+import (
+	"gorm.io/gorm"
+)
+
+// Route 子网路由模型
+type Route struct {
+	gorm.Model
+	UserID      uint       `gorm:"not null;index" json:"userId"`
+	DeviceID    uint       `gorm:"not null;index" json:"deviceId"`
+	CIDR        string     `gorm:"size:50;not null" json:"cidr"`
+	Description string     `gorm:"size:200" json:"description"`
+	Enabled     bool       `gorm:"default:true" json:"enabled"`
+	ACLs        []RouteACL `gorm:"foreignKey:RouteID" json:"acls,omitempty"`
+}
+
+// RouteACL 路由访问控制
+type RouteACL struct {
+	gorm.Model
+	RouteID      uint `gorm:"not null;uniqueIndex:idx_route_peer" json:"routeId"`
+	PeerDeviceID uint `gorm:"not null;uniqueIndex:idx_route_peer" json:"peerDeviceId"`
+}
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SpeedTestSchedule 测速计划
+type SpeedTestSchedule struct {
+	gorm.Model
+	UserID         uint      `gorm:"not null;index" json:"userId"`
+	SourceDeviceID uint      `gorm:"not null" json:"sourceDeviceId"`
+	TargetDeviceID uint      `gorm:"not null" json:"targetDeviceId"`
+	Interval       int       `gorm:"not null" json:"interval"` // 单位：分钟
+	Duration       int       `gorm:"not null" json:"duration"` // 单位：秒
+	Enabled        bool      `gorm:"default:true" json:"enabled"`
+	MinThroughput  float64   `json:"minThroughput"` // 吞吐量告警阈值，单位：Mbps，0 表示不告警
+	MaxLatency     float64   `json:"maxLatency"`    // 延迟告警阈值，单位：毫秒，0 表示不告警
+	LastRunAt      time.Time `json:"lastRunAt"`
+	NextRunAt      time.Time `gorm:"index" json:"nextRunAt"`
+}
+
+// SpeedTestResult 测速结果
+type SpeedTestResult struct {
+	gorm.Model
+	ScheduleID     uint    `gorm:"not null;index" json:"scheduleId"`
+	UserID         uint    `gorm:"not null;index" json:"userId"`
+	SourceDeviceID uint    `gorm:"not null" json:"sourceDeviceId"`
+	TargetDeviceID uint    `gorm:"not null" json:"targetDeviceId"`
+	ConnectionType string  `gorm:"size:20" json:"connectionType"`
+	Throughput     float64 `json:"throughput"`            // 单位：Mbps
+	Latency        float64 `json:"latency"`               // 单位：毫秒
+	Jitter         float64 `json:"jitter"`                // 单位：毫秒
+	Status         string  `gorm:"size:20" json:"status"` // ok, alert, failed
+	Error          string  `gorm:"size:200" json:"error,omitempty"`
+}
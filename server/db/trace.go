@@ -0,0 +1,104 @@
+package db
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ConnectionTrace 设备上报的与对等节点的一次连接过程
+type ConnectionTrace struct {
+	gorm.Model
+	DeviceID   uint            `gorm:"not null;index" json:"deviceId"`
+	PeerID     string          `gorm:"size:50;not null;index" json:"peerId"`
+	LocalNAT   string          `gorm:"size:50" json:"localNat,omitempty"`
+	PeerNAT    string          `gorm:"size:50" json:"peerNat,omitempty"`
+	Path       string          `gorm:"size:20" json:"path,omitempty"` // 最终使用的连接方式，连接失败时为空
+	Error      string          `gorm:"size:500" json:"error,omitempty"`
+	DurationMs int64           `json:"durationMs"`
+	Attempts   ConnectionSteps `gorm:"type:text" json:"attempts"`
+	StartedAt  time.Time       `gorm:"index" json:"startedAt"`
+
+	// 本次连接使用的连接优先级及其来源（config 本地配置、server 服务端下发、default 客户端默认）
+	Priority       CandidatePriority `gorm:"size:200" json:"priority,omitempty"`
+	PrioritySource string            `gorm:"size:20" json:"prioritySource,omitempty"`
+}
+
+// ConnectionStep 连接过程中的一次尝试
+type ConnectionStep struct {
+	Method     string    `json:"method"`
+	Candidate  string    `json:"candidate,omitempty"`
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs int64     `json:"durationMs"`
+	Skipped    bool      `json:"skipped,omitempty"`
+	SkipReason string    `json:"skipReason,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// ConnectionSteps 连接尝试列表，以 JSON 保存
+type ConnectionSteps []ConnectionStep
+
+// Value 以 JSON 保存连接尝试
+func (s ConnectionSteps) Value() (driver.Value, error) {
+	if s == nil {
+		s = ConnectionSteps{}
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan 解析 JSON 格式的连接尝试
+func (s *ConnectionSteps) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+		*s = ConnectionSteps{}
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("无法解析连接尝试: %T", value)
+	}
+	if len(data) == 0 {
+		*s = ConnectionSteps{}
+		return nil
+	}
+	return json.Unmarshal(data, s)
+}
+
+// CandidatePriority 连接优先级，以逗号分隔保存
+type CandidatePriority []string
+
+// Value 以逗号分隔保存连接优先级
+func (p CandidatePriority) Value() (driver.Value, error) {
+	return strings.Join(p, ","), nil
+}
+
+// Scan 解析逗号分隔的连接优先级
+func (p *CandidatePriority) Scan(value interface{}) error {
+	var data string
+	switch v := value.(type) {
+	case nil:
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		return fmt.Errorf("无法解析连接优先级: %T", value)
+	}
+	if data == "" {
+		*p = nil
+		return nil
+	}
+	*p = strings.Split(data, ",")
+	return nil
+}
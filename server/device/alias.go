@@ -0,0 +1,156 @@
+package device
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// maxAliasesPerUser 每个用户最多设置的别名数
+const maxAliasesPerUser = 200
+
+// 别名由小写字母、数字和 . _ - 组成，以字母或数字开头
+var aliasPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// nodeIDPattern 服务端生成的节点 ID 格式，别名不能与之混淆
+var nodeIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// 解析结果的来源
+const (
+	ResolvedByAlias      = "alias"      // 用户设置的别名
+	ResolvedByNodeID     = "nodeId"     // 本身就是节点 ID
+	ResolvedByDeviceName = "deviceName" // 用户设备的名称，只有一台设备使用该名称时才能解析
+)
+
+// AliasRequest 设置别名请求
+type AliasRequest struct {
+	Name   string `json:"name" binding:"required,max=50"`
+	NodeID string `json:"nodeId" binding:"required,max=50"`
+}
+
+// PeerResolution 对等节点名称的解析结果
+type PeerResolution struct {
+	Name   string `json:"name"`
+	NodeID string `json:"nodeId"`
+	Source string `json:"source"`
+}
+
+// normalizeAlias 别名不区分大小写，统一以小写保存和查找
+func normalizeAlias(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// CreateAlias 为对等节点设置别名。别名在用户内唯一，不能是节点 ID 的格式，也不能与已有的节点 ID 相同，
+// 避免解析时产生歧义
+func (s *Service) CreateAlias(userID uint, req *AliasRequest) (*db.PeerAlias, error) {
+	name := normalizeAlias(req.Name)
+	if !aliasPattern.MatchString(name) {
+		return nil, errors.InvalidParam("别名只能包含字母、数字和 . _ -，并以字母或数字开头")
+	}
+	if nodeIDPattern.MatchString(name) {
+		return nil, errors.InvalidParam("别名不能使用节点 ID 的格式")
+	}
+	if _, err := s.devices.GetByNodeID(name); err == nil {
+		return nil, errors.Conflict("别名与已有的节点 ID 相同")
+	} else if !store.IsNotFound(err) {
+		return nil, errors.Database("查询设备失败", err)
+	}
+
+	if _, err := s.devices.GetByNodeID(req.NodeID); err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("对等节点不存在")
+		}
+		return nil, errors.Database("查询设备失败", err)
+	}
+
+	aliases, err := s.aliases.ListByUser(userID)
+	if err != nil {
+		return nil, errors.Database("查询别名失败", err)
+	}
+	if len(aliases) >= maxAliasesPerUser {
+		return nil, errors.InvalidParam("设置的别名过多")
+	}
+
+	alias := &db.PeerAlias{
+		UserID: userID,
+		Name:   name,
+		NodeID: req.NodeID,
+	}
+	if err := s.aliases.Create(alias); err != nil {
+		if store.IsDuplicate(err) {
+			return nil, errors.Conflict("别名已存在")
+		}
+		return nil, errors.Database("保存别名失败", err)
+	}
+	return alias, nil
+}
+
+// GetAliases 获取用户设置的别名
+func (s *Service) GetAliases(userID uint) ([]db.PeerAlias, error) {
+	aliases, err := s.aliases.ListByUser(userID)
+	if err != nil {
+		return nil, errors.Database("查询别名失败", err)
+	}
+	return aliases, nil
+}
+
+// DeleteAlias 删除用户设置的别名
+func (s *Service) DeleteAlias(userID uint, name string) error {
+	alias, err := s.aliases.GetByName(userID, normalizeAlias(name))
+	if err != nil {
+		if store.IsNotFound(err) {
+			return errors.NotFound("别名不存在")
+		}
+		return errors.Database("查询别名失败", err)
+	}
+	if err := s.aliases.Delete(alias.ID); err != nil {
+		return errors.Database("删除别名失败", err)
+	}
+	return nil
+}
+
+// ResolvePeer 把用户输入的对等节点名称解析为节点 ID，依次匹配用户的别名、节点 ID 和用户设备的名称。
+// 多台设备使用同一名称时返回冲突错误，需要改用节点 ID 或为其中一台设置别名
+func (s *Service) ResolvePeer(userID uint, name string) (*PeerResolution, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, errors.InvalidParam("对等节点不能为空")
+	}
+
+	alias, err := s.aliases.GetByName(userID, normalizeAlias(name))
+	if err == nil {
+		return &PeerResolution{Name: name, NodeID: alias.NodeID, Source: ResolvedByAlias}, nil
+	}
+	if !store.IsNotFound(err) {
+		return nil, errors.Database("查询别名失败", err)
+	}
+
+	if _, err := s.devices.GetByNodeID(name); err == nil {
+		return &PeerResolution{Name: name, NodeID: name, Source: ResolvedByNodeID}, nil
+	} else if !store.IsNotFound(err) {
+		return nil, errors.Database("查询设备失败", err)
+	}
+
+	devices, err := s.devices.ListByUser(userID)
+	if err != nil {
+		return nil, errors.Database("查询设备失败", err)
+	}
+	var matched []string
+	for _, device := range devices {
+		if strings.EqualFold(device.Name, name) {
+			matched = append(matched, device.NodeID)
+		}
+	}
+	switch len(matched) {
+	case 0:
+		return nil, errors.NotFound("对等节点不存在")
+	case 1:
+		return &PeerResolution{Name: name, NodeID: matched[0], Source: ResolvedByDeviceName}, nil
+	default:
+		return nil, errors.Conflict(fmt.Sprintf("有 %d 台设备名为 %s，请使用节点 ID 或设置别名", len(matched), name))
+	}
+}
@@ -0,0 +1,72 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+func TestPeerAliases(t *testing.T) {
+	st := store.NewMemoryStore()
+	svc := NewService(config.DefaultConfig(), st)
+
+	nas := "0123456789abcdef0123456789abcdef"
+	for _, dev := range []*db.Device{
+		{UserID: 1, Name: "nas", NodeID: nas},
+		{UserID: 1, Name: "laptop", NodeID: "fedcba9876543210fedcba9876543210"},
+		{UserID: 1, Name: "laptop", NodeID: "00112233445566778899aabbccddeeff"},
+	} {
+		if err := st.Devices.Create(dev); err != nil {
+			t.Fatalf("创建设备失败: %v", err)
+		}
+	}
+
+	alias, err := svc.CreateAlias(1, &AliasRequest{Name: "Home-NAS", NodeID: nas})
+	if err != nil || alias.Name != "home-nas" {
+		t.Fatalf("设置别名失败: %+v %v", alias, err)
+	}
+	if _, err := svc.CreateAlias(1, &AliasRequest{Name: "home-nas", NodeID: nas}); errors.AsError(err).Code != errors.ErrConflict {
+		t.Fatalf("同一用户的别名不能重复（不区分大小写）: %v", err)
+	}
+	if _, err := svc.CreateAlias(2, &AliasRequest{Name: "home-nas", NodeID: nas}); err != nil {
+		t.Fatalf("不同用户可以使用相同的别名: %v", err)
+	}
+	for _, name := range []string{"ffffffffffffffffffffffffffffffff", nas, "-nas", "my nas"} {
+		if _, err := svc.CreateAlias(1, &AliasRequest{Name: name, NodeID: nas}); err == nil {
+			t.Fatalf("别名 %q 应被拒绝", name)
+		}
+	}
+	if _, err := svc.CreateAlias(1, &AliasRequest{Name: "ghost", NodeID: "missing"}); err == nil {
+		t.Fatal("不存在的节点不能设置别名")
+	}
+
+	for name, want := range map[string]PeerResolution{
+		"HOME-NAS": {NodeID: nas, Source: ResolvedByAlias},
+		nas:        {NodeID: nas, Source: ResolvedByNodeID},
+		"NAS":      {NodeID: nas, Source: ResolvedByDeviceName},
+	} {
+		got, err := svc.ResolvePeer(1, name)
+		if err != nil || got.NodeID != want.NodeID || got.Source != want.Source {
+			t.Fatalf("解析 %s 结果错误: %+v %v", name, got, err)
+		}
+	}
+	if _, err := svc.ResolvePeer(1, "laptop"); errors.AsError(err).Code != errors.ErrConflict {
+		t.Fatalf("多台设备同名时应返回冲突: %v", err)
+	}
+	if _, err := svc.ResolvePeer(1, "unknown"); errors.AsError(err).Code != errors.ErrNotFound {
+		t.Fatalf("无法解析时应返回不存在: %v", err)
+	}
+
+	if err := svc.DeleteAlias(1, "HOME-NAS"); err != nil {
+		t.Fatalf("删除别名失败: %v", err)
+	}
+	if _, err := svc.ResolvePeer(1, "home-nas"); err == nil {
+		t.Fatal("删除后别名不应再被解析")
+	}
+	if got, err := svc.ResolvePeer(2, "home-nas"); err != nil || got.NodeID != nas {
+		t.Fatalf("删除别名不应影响其他用户: %+v %v", got, err)
+	}
+}
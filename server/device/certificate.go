@@ -0,0 +1,127 @@
+package device
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/signing"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// CertificateAuthenticator 验证设备证书，由内置 CA 的证书服务实现
+type CertificateAuthenticator interface {
+	// Authenticate 验证 DER 编码的设备证书并返回所属设备
+	Authenticate(der []byte) (*db.Device, *x509.Certificate, error)
+	// HasActive 检查设备是否持有有效的证书
+	HasActive(deviceID uint) (bool, error)
+}
+
+// SetCertificateAuthenticator 启用设备证书认证，未设置时只支持令牌认证
+func (s *Service) SetCertificateAuthenticator(certs CertificateAuthenticator) {
+	s.certs = certs
+}
+
+// UsesCertificate 检查请求是否使用设备证书认证
+func UsesCertificate(req *http.Request) bool {
+	return req.Header.Get(signing.HeaderCertificate) != ""
+}
+
+// AuthenticateCertificate 使用请求头中的设备证书认证设备，并使用证书公钥验证请求签名
+func (s *Service) AuthenticateCertificate(req *http.Request, verifier *signing.Verifier) (*db.Device, error) {
+	if s.certs == nil {
+		return nil, errors.Unauthorized("服务端未启用设备证书认证")
+	}
+	der, err := signing.RequestCertificate(req)
+	if err != nil {
+		return nil, errors.Unauthorized(err.Error())
+	}
+	device, cert, err := s.certs.Authenticate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	if req.Body != nil {
+		if body, err = io.ReadAll(req.Body); err != nil {
+			return nil, errors.InvalidParam("读取请求体失败")
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if err := verifier.VerifyCertificateRequest(req, cert, body); err != nil {
+		logger.Warn("设备 %s 证书签名验证失败: %v", device.NodeID, err)
+		return nil, errors.Unauthorized(err.Error())
+	}
+
+	if err := s.devices.UpdateFields(device, map[string]interface{}{
+		"status":       "online",
+		"last_seen_at": time.Now(),
+	}); err != nil {
+		logger.Warn("更新设备状态失败: %v", err)
+	}
+	return device, nil
+}
+
+// AuthenticateSignature 使用 DER 编码的设备证书认证设备，并验证证书私钥对 method 和 path 的签名，
+// 用于中继握手等 HTTP 以外的认证
+func (s *Service) AuthenticateSignature(der []byte, method, path string, timestamp int64, nonce, signature string, verifier *signing.Verifier) (*db.Device, error) {
+	if s.certs == nil {
+		return nil, errors.Unauthorized("服务端未启用设备证书认证")
+	}
+	device, cert, err := s.certs.Authenticate(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := signing.VerifyWithCertificate(cert, method, path, timestamp, nonce, nil, signature); err != nil {
+		return nil, errors.Unauthorized(err.Error())
+	}
+	if err := verifier.CheckReplay(device.NodeID, nonce, timestamp); err != nil {
+		return nil, errors.Unauthorized(err.Error())
+	}
+	return device, nil
+}
+
+// AuthenticateDevice 使用节点 ID 和令牌认证设备，认证成功后将设备标记为在线
+func (s *Service) AuthenticateDevice(nodeID, token string) (*db.Device, error) {
+	device, err := s.devices.GetByNodeID(nodeID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("设备不存在")
+		}
+		return nil, errors.Database("查询设备失败", err)
+	}
+
+	// 验证令牌
+	if subtle.ConstantTimeCompare([]byte(device.Token), []byte(token)) != 1 {
+		return nil, errors.Unauthorized("设备令牌无效")
+	}
+
+	if err := s.devices.UpdateFields(device, map[string]interface{}{
+		"status":       "online",
+		"last_seen_at": time.Now(),
+	}); err != nil {
+		logger.Warn("更新设备状态失败: %v", err)
+	}
+	return device, nil
+}
+
+// CheckTokenAllowed 设备持有有效证书时不再接受令牌认证，证书被吊销后才能重新使用令牌申请证书
+func (s *Service) CheckTokenAllowed(device *db.Device) error {
+	if s.certs == nil {
+		return nil
+	}
+	active, err := s.certs.HasActive(device.ID)
+	if err != nil {
+		return err
+	}
+	if active {
+		return errors.Unauthorized("设备已启用证书认证，不再接受令牌")
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+package device
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/store"
+)
+
+func TestAuthenticateDevice(t *testing.T) {
+	svc := NewService(config.DefaultConfig(), store.NewMemoryStore())
+	if _, err := svc.CreateDevice(1, "nas", "node-a", "token-a"); err != nil {
+		t.Fatalf("创建设备失败: %v", err)
+	}
+
+	if _, err := svc.AuthenticateDevice("node-b", "token-a"); errors.AsError(err).StatusCode() != http.StatusNotFound {
+		t.Fatalf("设备不存在时应返回 404: %v", err)
+	}
+	if _, err := svc.AuthenticateDevice("node-a", "token-b"); errors.AsError(err).StatusCode() != http.StatusUnauthorized {
+		t.Fatalf("令牌错误时应返回 401: %v", err)
+	}
+
+	device, err := svc.AuthenticateDevice("node-a", "token-a")
+	if err != nil {
+		t.Fatalf("认证设备失败: %v", err)
+	}
+	if device.Status != "online" || device.LastSeenAt.IsZero() {
+		t.Fatalf("认证后应标记为在线: %+v", device)
+	}
+
+	// 没有启用证书认证时总是接受令牌
+	if err := svc.CheckTokenAllowed(device); err != nil {
+		t.Fatalf("未启用证书认证时应接受令牌: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package device
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/db"
+)
+
+// ClockSkew 设备心跳中上报的本机时间与服务端收到心跳时间的偏差，正数表示设备时钟偏快。
+// 设备未上报时间时返回 false。偏差包含单程网络延迟，只用于发现明显的时钟错误
+func ClockSkew(clientTime, receivedAt time.Time) (time.Duration, bool) {
+	if clientTime.IsZero() {
+		return 0, false
+	}
+	return clientTime.Sub(receivedAt), true
+}
+
+// checkClockSkew 设备时钟偏差超过 security.clockSkew.deviceWarn 时告警并记录设备事件。
+// previous 为上一次心跳的偏差，偏差持续超出时不重复记录
+func (s *Service) checkClockSkew(device *db.Device, previous, skew time.Duration) {
+	threshold := time.Duration(s.config.Security.ClockSkew.DeviceWarn) * time.Second
+	if threshold <= 0 || !exceeds(skew, threshold) || exceeds(previous, threshold) {
+		return
+	}
+
+	logger.Warn("设备 %s 的时钟与服务端相差 %s，令牌和签名验证可能失败", device.NodeID, skew.Round(time.Millisecond))
+	event := db.DeviceEvent{
+		DeviceID:   device.ID,
+		Type:       db.EventClockSkew,
+		Detail:     fmt.Sprintf("设备时钟与服务端相差 %s，超过告警阈值 %s", skew.Round(time.Millisecond), threshold),
+		OccurredAt: time.Now(),
+	}
+	if err := s.devices.CreateEvents([]db.DeviceEvent{event}); err != nil {
+		logger.Warn("记录设备 %s 的时钟偏差事件失败: %v", device.NodeID, err)
+	}
+}
+
+// exceeds 偏差的绝对值是否超过阈值
+func exceeds(skew, threshold time.Duration) bool {
+	return skew > threshold || skew < -threshold
+}
@@ -1,38 +1,67 @@
 package device
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/senma231/p3/server/config"
 	"github.com/senma231/p3/server/db"
-	"gorm.io/gorm"
+	"github.com/senma231/p3/server/store"
 )
 
 // Service 设备服务
 type Service struct {
-	config *config.Config
+	config      *config.Config
+	st          *store.Store
+	devices     store.DeviceRepo
+	apps        store.AppRepo
+	connections store.ConnectionRepo
+	stats       store.StatsRepo
+	filters     store.DeviceFilterRepo
+	observers   store.ObserverLinkRepo
+	aliases     store.PeerAliasRepo
+	punches     store.PunchStatRepo
+	certs       CertificateAuthenticator
 }
 
 // NewService 创建设备服务
-func NewService(cfg *config.Config) *Service {
+func NewService(cfg *config.Config, st *store.Store) *Service {
 	return &Service{
-		config: cfg,
+		config:      cfg,
+		st:          st,
+		devices:     st.Devices,
+		apps:        st.Apps,
+		connections: st.Connections,
+		stats:       st.Stats,
+		filters:     st.Filters,
+		observers:   st.Observers,
+		aliases:     st.Aliases,
+		punches:     st.Punches,
 	}
 }
 
-// CreateDevice 创建设备
-func (s *Service) CreateDevice(userID uint, name, nodeID, token string) (*db.Device, error) {
-	// 检查节点 ID 是否已存在
-	var existingDevice db.Device
-	if err := db.DB.Where("node_id = ?", nodeID).First(&existingDevice).Error; err == nil {
-		return nil, errors.New("节点 ID 已存在")
-	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
-		return nil, fmt.Errorf("查询设备失败: %w", err)
+// ForTenant 返回只能访问 ctx 中租户数据的设备服务，其他租户的设备视为不存在
+func (s *Service) ForTenant(ctx context.Context) (*Service, error) {
+	scoped, err := s.st.ForContext(ctx)
+	if err != nil {
+		return nil, err
 	}
+	svc := *s
+	svc.devices = scoped.Devices
+	svc.apps = scoped.Apps
+	svc.connections = scoped.Connections
+	svc.stats = scoped.Stats
+	svc.filters = scoped.Filters
+	svc.observers = scoped.Observers
+	svc.aliases = scoped.Aliases
+	return &svc, nil
+}
 
-	// 创建设备
+// CreateDevice 创建设备
+func (s *Service) CreateDevice(userID uint, name, nodeID, token string) (*db.Device, error) {
+	// 创建设备，节点 ID 由唯一约束保证不重复
 	device := &db.Device{
 		UserID: userID,
 		Name:   name,
@@ -41,7 +70,10 @@ func (s *Service) CreateDevice(userID uint, name, nodeID, token string) (*db.Dev
 		Status: "offline",
 	}
 
-	if err := db.DB.Create(device).Error; err != nil {
+	if err := s.devices.Create(device); err != nil {
+		if store.IsDuplicate(err) {
+			return nil, errors.New("节点 ID 已存在")
+		}
 		return nil, fmt.Errorf("创建设备失败: %w", err)
 	}
 
@@ -50,45 +82,45 @@ func (s *Service) CreateDevice(userID uint, name, nodeID, token string) (*db.Dev
 
 // GetDeviceByID 根据 ID 获取设备
 func (s *Service) GetDeviceByID(deviceID uint) (*db.Device, error) {
-	var device db.Device
-	if err := db.DB.First(&device, deviceID).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	device, err := s.devices.GetByID(deviceID)
+	if err != nil {
+		if store.IsNotFound(err) {
 			return nil, errors.New("设备不存在")
 		}
 		return nil, fmt.Errorf("查询设备失败: %w", err)
 	}
-	return &device, nil
+	return device, nil
 }
 
 // GetDeviceByNodeID 根据节点 ID 获取设备
 func (s *Service) GetDeviceByNodeID(nodeID string) (*db.Device, error) {
-	var device db.Device
-	if err := db.DB.Where("node_id = ?", nodeID).First(&device).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
+	device, err := s.devices.GetByNodeID(nodeID)
+	if err != nil {
+		if store.IsNotFound(err) {
 			return nil, errors.New("设备不存在")
 		}
 		return nil, fmt.Errorf("查询设备失败: %w", err)
 	}
-	return &device, nil
+	return device, nil
 }
 
 // GetDevicesByUserID 获取用户的所有设备
 func (s *Service) GetDevicesByUserID(userID uint) ([]db.Device, error) {
-	var devices []db.Device
-	if err := db.DB.Where("user_id = ?", userID).Find(&devices).Error; err != nil {
+	devices, err := s.devices.ListByUser(userID)
+	if err != nil {
 		return nil, fmt.Errorf("查询设备失败: %w", err)
 	}
 	return devices, nil
 }
 
-// UpdateDevice 更新设备信息
-func (s *Service) UpdateDevice(deviceID uint, updates map[string]interface{}) (*db.Device, error) {
+// UpdateDevice 更新设备信息，revision 不为 0 时仅在修订号匹配时更新
+func (s *Service) UpdateDevice(deviceID uint, revision uint, updates map[string]interface{}) (*db.Device, error) {
 	device, err := s.GetDeviceByID(deviceID)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := db.DB.Model(device).Updates(updates).Error; err != nil {
+	if err := s.devices.Update(device, revision, updates); err != nil {
 		return nil, fmt.Errorf("更新设备失败: %w", err)
 	}
 
@@ -97,33 +129,51 @@ func (s *Service) UpdateDevice(deviceID uint, updates map[string]interface{}) (*
 
 // DeleteDevice 删除设备
 func (s *Service) DeleteDevice(deviceID uint) error {
-	if err := db.DB.Delete(&db.Device{}, deviceID).Error; err != nil {
+	if err := s.devices.Delete(deviceID); err != nil {
 		return fmt.Errorf("删除设备失败: %w", err)
 	}
 	return nil
 }
 
-// UpdateDeviceStatus 更新设备状态
-func (s *Service) UpdateDeviceStatus(nodeID, status, natType, externalIP, localIP, version, os, arch string) (*db.Device, error) {
+// DeviceStatusRequest 设备状态更新请求
+type DeviceStatusRequest struct {
+	Status     string `json:"status" binding:"required"`
+	NATType    string `json:"natType"`
+	ExternalIP string `json:"externalIP"`
+	LocalIP    string `json:"localIP"`
+	Version    string `json:"version"`
+	OS         string `json:"os"`
+	Arch       string `json:"arch"`
+	Region     string `json:"region"`
+	// 设备发送心跳时的本机时间，用于检查时钟偏差，旧版本的客户端不上报
+	ClientTime time.Time `json:"clientTime"`
+}
+
+// UpdateDeviceStatus 更新设备状态，clockSkew 为本次心跳测得的设备时钟偏差
+func (s *Service) UpdateDeviceStatus(nodeID, status, natType, externalIP, localIP, version, os, arch, region string, clockSkew time.Duration) (*db.Device, error) {
 	device, err := s.GetDeviceByNodeID(nodeID)
 	if err != nil {
 		return nil, err
 	}
+	previousSkew := time.Duration(device.ClockSkewMs) * time.Millisecond
 
 	updates := map[string]interface{}{
-		"status":      status,
-		"nat_type":    natType,
-		"external_ip": externalIP,
-		"local_ip":    localIP,
-		"version":     version,
-		"os":          os,
-		"arch":        arch,
-		"last_seen_at": time.Now(),
+		"status":        status,
+		"nat_type":      natType,
+		"external_ip":   externalIP,
+		"local_ip":      localIP,
+		"version":       version,
+		"os":            os,
+		"arch":          arch,
+		"region":        region,
+		"last_seen_at":  time.Now(),
+		"clock_skew_ms": clockSkew.Milliseconds(),
 	}
 
-	if err := db.DB.Model(device).Updates(updates).Error; err != nil {
+	if err := s.devices.UpdateFields(device, updates); err != nil {
 		return nil, fmt.Errorf("更新设备状态失败: %w", err)
 	}
+	s.checkClockSkew(device, previousSkew, clockSkew)
 
 	return device, nil
 }
@@ -138,10 +188,20 @@ func (s *Service) VerifyDeviceToken(nodeID, token string) (bool, error) {
 	return device.Token == token, nil
 }
 
+// GetDeviceStatuses 获取用户设备的状态快照，status 不为空时只返回该状态的设备。
+// 只查询状态快照表，设备较多时比获取完整的设备列表开销小
+func (s *Service) GetDeviceStatuses(userID uint, status string) ([]db.DeviceStatus, error) {
+	statuses, err := s.devices.ListStatuses(userID, status)
+	if err != nil {
+		return nil, fmt.Errorf("查询设备状态失败: %w", err)
+	}
+	return statuses, nil
+}
+
 // GetOnlineDevices 获取在线设备
 func (s *Service) GetOnlineDevices() ([]db.Device, error) {
-	var devices []db.Device
-	if err := db.DB.Where("status = ?", "online").Find(&devices).Error; err != nil {
+	devices, err := s.devices.ListByStatus("online")
+	if err != nil {
 		return nil, fmt.Errorf("查询设备失败: %w", err)
 	}
 	return devices, nil
@@ -156,25 +216,25 @@ func (s *Service) GetDeviceStats(deviceID uint) (map[string]interface{}, error)
 	}
 
 	// 获取设备的应用数量
-	var appCount int64
-	if err := db.DB.Model(&db.App{}).Where("device_id = ?", deviceID).Count(&appCount).Error; err != nil {
+	appCount, err := s.apps.CountByDevice(deviceID)
+	if err != nil {
 		return nil, fmt.Errorf("查询应用数量失败: %w", err)
 	}
 
 	// 获取设备的连接数量
-	var connectionCount int64
-	if err := db.DB.Model(&db.Connection{}).Where("source_device_id = ? OR target_device_id = ?", deviceID, deviceID).Count(&connectionCount).Error; err != nil {
+	connectionCount, err := s.connections.CountByDevice(deviceID)
+	if err != nil {
 		return nil, fmt.Errorf("查询连接数量失败: %w", err)
 	}
 
 	// 获取设备的流量统计
-	var stats db.Stats
-	if err := db.DB.Where("device_id = ?", deviceID).Order("created_at DESC").First(&stats).Error; err != nil {
-		if !errors.Is(err, gorm.ErrRecordNotFound) {
+	stats, err := s.stats.LatestByDevice(deviceID)
+	if err != nil {
+		if !store.IsNotFound(err) {
 			return nil, fmt.Errorf("查询统计信息失败: %w", err)
 		}
 		// 如果没有统计信息，使用默认值
-		stats = db.Stats{
+		stats = &db.Stats{
 			DeviceID:       deviceID,
 			BytesSent:      0,
 			BytesReceived:  0,
@@ -185,12 +245,12 @@ func (s *Service) GetDeviceStats(deviceID uint) (map[string]interface{}, error)
 
 	// 返回统计信息
 	return map[string]interface{}{
-		"device":         device,
-		"appCount":       appCount,
+		"device":          device,
+		"appCount":        appCount,
 		"connectionCount": connectionCount,
-		"bytesSent":      stats.BytesSent,
-		"bytesReceived":  stats.BytesReceived,
-		"connections":    stats.Connections,
-		"connectionTime": stats.ConnectionTime,
+		"bytesSent":       stats.BytesSent,
+		"bytesReceived":   stats.BytesReceived,
+		"connections":     stats.Connections,
+		"connectionTime":  stats.ConnectionTime,
 	}, nil
 }
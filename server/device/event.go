@@ -0,0 +1,63 @@
+package device
+
+import (
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+)
+
+// maxEventsPerReport 单次上报的最大事件数
+const maxEventsPerReport = 100
+
+// EventRequest 设备事件上报请求
+type EventRequest struct {
+	Type       string    `json:"type" binding:"required,max=50,safetext" sanitize:"text"`
+	App        string    `json:"app" binding:"max=100,safetext" sanitize:"text"`
+	Detail     string    `json:"detail" binding:"max=500,safemultiline" sanitize:"multiline"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// RecordEvents 记录设备上报的事件
+func (s *Service) RecordEvents(deviceID uint, events []EventRequest) ([]db.DeviceEvent, error) {
+	if len(events) == 0 {
+		return []db.DeviceEvent{}, nil
+	}
+	if len(events) > maxEventsPerReport {
+		return nil, errors.InvalidParam("单次上报的事件过多")
+	}
+
+	now := time.Now()
+	records := make([]db.DeviceEvent, 0, len(events))
+	for _, event := range events {
+		occurredAt := event.OccurredAt
+		if occurredAt.IsZero() || occurredAt.After(now) {
+			occurredAt = now
+		}
+		records = append(records, db.DeviceEvent{
+			DeviceID:   deviceID,
+			Type:       event.Type,
+			App:        event.App,
+			Detail:     event.Detail,
+			OccurredAt: occurredAt,
+		})
+	}
+
+	if err := s.devices.CreateEvents(records); err != nil {
+		return nil, errors.Database("保存设备事件失败", err)
+	}
+	return records, nil
+}
+
+// GetEvents 获取设备最近的事件
+func (s *Service) GetEvents(deviceID uint, limit int) ([]db.DeviceEvent, error) {
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	events, err := s.devices.ListEvents(deviceID, limit)
+	if err != nil {
+		return nil, errors.Database("查询设备事件失败", err)
+	}
+	return events, nil
+}
@@ -0,0 +1,57 @@
+package device
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/store"
+)
+
+func TestDeviceEvents(t *testing.T) {
+	svc := NewService(config.DefaultConfig(), store.NewMemoryStore())
+
+	if records, err := svc.RecordEvents(1, nil); err != nil || len(records) != 0 {
+		t.Fatalf("没有事件时不应失败: %v %v", records, err)
+	}
+	if _, err := svc.RecordEvents(1, make([]EventRequest, maxEventsPerReport+1)); errors.AsError(err).StatusCode() != http.StatusBadRequest {
+		t.Fatalf("单次上报的事件过多应返回 400: %v", err)
+	}
+
+	// 客户端崩溃恢复后上报的事件，没有时间或时间在未来时使用服务端时间
+	crashed := time.Now().Add(-time.Minute).Truncate(time.Second)
+	before := time.Now()
+	records, err := svc.RecordEvents(1, []EventRequest{
+		{Type: "crash-recovered", Detail: "上次状态更新于 " + crashed.Format(time.RFC3339), OccurredAt: crashed},
+		{Type: "app-restored", App: "web", Detail: "running=false"},
+		{Type: "app-removed", App: "old", OccurredAt: time.Now().Add(time.Hour)},
+	})
+	if err != nil || len(records) != 3 {
+		t.Fatalf("记录事件失败: %v %v", records, err)
+	}
+	if !records[0].OccurredAt.Equal(crashed) || records[0].DeviceID != 1 {
+		t.Fatalf("应保留客户端上报的时间: %+v", records[0])
+	}
+	for _, record := range records[1:] {
+		if record.OccurredAt.Before(before) || record.OccurredAt.After(time.Now()) {
+			t.Fatalf("缺少时间或时间在未来时应使用服务端时间: %+v", record)
+		}
+	}
+	if _, err := svc.RecordEvents(2, []EventRequest{{Type: "app-failed", App: "ssh"}}); err != nil {
+		t.Fatalf("记录事件失败: %v", err)
+	}
+
+	// 按发生时间倒序返回设备自己的事件
+	events, err := svc.GetEvents(1, 0)
+	if err != nil || len(events) != 3 {
+		t.Fatalf("查询事件失败: %v %v", events, err)
+	}
+	if events[2].Type != "crash-recovered" {
+		t.Fatalf("事件应按发生时间倒序: %+v", events)
+	}
+	if events, _ := svc.GetEvents(1, 2); len(events) != 2 {
+		t.Fatalf("应按 limit 返回 2 个事件: %d", len(events))
+	}
+}
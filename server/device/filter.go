@@ -0,0 +1,88 @@
+package device
+
+import (
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// maxFiltersPerUser 每个用户最多保存的筛选条件数
+const maxFiltersPerUser = 100
+
+// FilterRequest 保存筛选条件请求
+type FilterRequest struct {
+	Name       string `json:"name" binding:"required,max=50,safetext" sanitize:"text"`
+	Expression string `json:"expression" binding:"required,max=500"`
+}
+
+// CreateFilter 保存筛选条件，保存前检查条件是否有效
+func (s *Service) CreateFilter(userID uint, req *FilterRequest) (*db.DeviceFilter, error) {
+	if _, err := ParseSelector(req.Expression); err != nil {
+		return nil, err
+	}
+
+	filters, err := s.filters.ListByUser(userID)
+	if err != nil {
+		return nil, errors.Database("查询筛选条件失败", err)
+	}
+	if len(filters) >= maxFiltersPerUser {
+		return nil, errors.InvalidParam("保存的筛选条件过多")
+	}
+
+	filter := &db.DeviceFilter{
+		UserID:     userID,
+		Name:       req.Name,
+		Expression: req.Expression,
+	}
+	if err := s.filters.Create(filter); err != nil {
+		if store.IsDuplicate(err) {
+			return nil, errors.Conflict("筛选条件名称已存在")
+		}
+		return nil, errors.Database("保存筛选条件失败", err)
+	}
+	return filter, nil
+}
+
+// GetFilters 获取用户保存的筛选条件
+func (s *Service) GetFilters(userID uint) ([]db.DeviceFilter, error) {
+	filters, err := s.filters.ListByUser(userID)
+	if err != nil {
+		return nil, errors.Database("查询筛选条件失败", err)
+	}
+	return filters, nil
+}
+
+// GetFilter 获取用户保存的筛选条件
+func (s *Service) GetFilter(userID uint, filterID uint) (*db.DeviceFilter, error) {
+	filter, err := s.filters.GetByID(filterID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("筛选条件不存在")
+		}
+		return nil, errors.Database("查询筛选条件失败", err)
+	}
+	if filter.UserID != userID {
+		return nil, errors.NotFound("筛选条件不存在")
+	}
+	return filter, nil
+}
+
+// DeleteFilter 删除用户保存的筛选条件
+func (s *Service) DeleteFilter(userID uint, filterID uint) error {
+	if _, err := s.GetFilter(userID, filterID); err != nil {
+		return err
+	}
+	if err := s.filters.Delete(filterID); err != nil {
+		return errors.Database("删除筛选条件失败", err)
+	}
+	return nil
+}
+
+// SelectDevicesByFilter 获取满足已保存筛选条件的设备
+func (s *Service) SelectDevicesByFilter(userID uint, filterID uint) ([]db.Device, error) {
+	filter, err := s.GetFilter(userID, filterID)
+	if err != nil {
+		return nil, err
+	}
+	return s.SelectDevices(userID, filter.Expression)
+}
@@ -0,0 +1,224 @@
+package device
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// observerTokenSize 观察链接令牌的随机字节数
+const observerTokenSize = 24
+
+// maxObserverLinksPerDevice 每台设备同时有效的观察链接数
+const maxObserverLinksPerDevice = 20
+
+// ObserverLinkRequest 创建观察链接请求
+type ObserverLinkRequest struct {
+	ExpiresIn int    `json:"expiresIn" binding:"min=0"` // 有效期，单位：分钟，为 0 时使用默认有效期
+	Note      string `json:"note" binding:"max=200,safetext" sanitize:"text"`
+}
+
+// ObserverView 观察链接可以看到的设备实时状态和统计。不包含设备的地址、标签和应用配置
+type ObserverView struct {
+	Name            string           `json:"name"`
+	NodeID          string           `json:"nodeId"`
+	Status          string           `json:"status"`
+	NATType         string           `json:"natType"`
+	Version         string           `json:"version"`
+	OS              string           `json:"os"`
+	Arch            string           `json:"arch"`
+	Region          string           `json:"region"`
+	LastSeenAt      time.Time        `json:"lastSeenAt"`
+	Resources       db.ResourceUsage `json:"resources"`
+	ClockSkewMs     int64            `json:"clockSkewMs"`
+	AppCount        int64            `json:"appCount"`
+	ConnectionCount int64            `json:"connectionCount"`
+	BytesSent       uint64           `json:"bytesSent"`
+	BytesReceived   uint64           `json:"bytesReceived"`
+	Connections     uint64           `json:"connections"`
+	ConnectionTime  uint64           `json:"connectionTime"`
+	ExpiresAt       time.Time        `json:"expiresAt"` // 观察链接的过期时间
+	ObservedAt      time.Time        `json:"observedAt"`
+}
+
+// CreateObserverLink 为用户的设备创建只读观察链接，返回链接记录和令牌。令牌只在创建时返回，服务端仅保存其哈希
+func (s *Service) CreateObserverLink(userID, deviceID uint, req *ObserverLinkRequest) (*db.ObserverLink, string, error) {
+	if _, err := s.ownedDevice(userID, deviceID); err != nil {
+		return nil, "", err
+	}
+
+	cfg := s.config.Auth.Observer
+	ttl := req.ExpiresIn
+	if ttl == 0 {
+		ttl = cfg.DefaultTTL
+	}
+	if ttl > cfg.MaxTTL {
+		return nil, "", errors.InvalidParam(fmt.Sprintf("观察链接有效期不能超过 %d 分钟", cfg.MaxTTL))
+	}
+
+	links, err := s.observers.ListByDevice(deviceID)
+	if err != nil {
+		return nil, "", errors.Database("查询观察链接失败", err)
+	}
+	now := time.Now()
+	active := 0
+	for i := range links {
+		if links[i].Active(now) {
+			active++
+		}
+	}
+	if active >= maxObserverLinksPerDevice {
+		return nil, "", errors.InvalidParam("设备的观察链接过多")
+	}
+
+	buf := make([]byte, observerTokenSize)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, "", fmt.Errorf("生成观察链接令牌失败: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(buf)
+
+	link := &db.ObserverLink{
+		UserID:    userID,
+		DeviceID:  deviceID,
+		TokenHash: hashObserverToken(token),
+		ExpiresAt: now.Add(time.Duration(ttl) * time.Minute),
+		Note:      req.Note,
+	}
+	if err := s.observers.Create(link); err != nil {
+		return nil, "", errors.Database("创建观察链接失败", err)
+	}
+	return link, token, nil
+}
+
+// ObserverLinkURL 生成观察链接，未配置观察链接地址时返回空字符串
+func (s *Service) ObserverLinkURL(token string) string {
+	baseURL := s.config.Auth.Observer.BaseURL
+	if baseURL == "" {
+		return ""
+	}
+	return strings.TrimRight(baseURL, "/") + "/observe/" + url.PathEscape(token)
+}
+
+// GetObserverLinks 获取用户设备的观察链接，包含已过期但未删除的链接
+func (s *Service) GetObserverLinks(userID, deviceID uint) ([]db.ObserverLink, error) {
+	if _, err := s.ownedDevice(userID, deviceID); err != nil {
+		return nil, err
+	}
+	links, err := s.observers.ListByDevice(deviceID)
+	if err != nil {
+		return nil, errors.Database("查询观察链接失败", err)
+	}
+	return links, nil
+}
+
+// DeleteObserverLink 撤销观察链接，正在观察的访客在下一次推送时断开
+func (s *Service) DeleteObserverLink(userID, deviceID, linkID uint) error {
+	link, err := s.observers.GetByID(linkID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return errors.NotFound("观察链接不存在")
+		}
+		return errors.Database("查询观察链接失败", err)
+	}
+	if link.UserID != userID || link.DeviceID != deviceID {
+		return errors.NotFound("观察链接不存在")
+	}
+	if err := s.observers.Delete(linkID); err != nil {
+		return errors.Database("删除观察链接失败", err)
+	}
+	return nil
+}
+
+// AuthenticateObserver 验证观察链接令牌，链接不存在、已撤销或已过期时返回 Unauthorized
+func (s *Service) AuthenticateObserver(token string) (*db.ObserverLink, error) {
+	if token == "" {
+		return nil, errors.Unauthorized("观察链接无效或已过期")
+	}
+	link, err := s.observers.GetByTokenHash(hashObserverToken(token))
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.Unauthorized("观察链接无效或已过期")
+		}
+		return nil, errors.Database("查询观察链接失败", err)
+	}
+	if !link.Active(time.Now()) {
+		return nil, errors.Unauthorized("观察链接无效或已过期")
+	}
+	return link, nil
+}
+
+// ObserveDevice 获取观察链接对应设备当前的状态和统计
+func (s *Service) ObserveDevice(link *db.ObserverLink) (*ObserverView, error) {
+	device, err := s.ownedDevice(link.UserID, link.DeviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	appCount, err := s.apps.CountByDevice(device.ID)
+	if err != nil {
+		return nil, errors.Database("查询应用数量失败", err)
+	}
+	connectionCount, err := s.connections.CountByDevice(device.ID)
+	if err != nil {
+		return nil, errors.Database("查询连接数量失败", err)
+	}
+	stats, err := s.stats.LatestByDevice(device.ID)
+	if err != nil {
+		if !store.IsNotFound(err) {
+			return nil, errors.Database("查询统计信息失败", err)
+		}
+		stats = &db.Stats{}
+	}
+
+	return &ObserverView{
+		Name:            device.Name,
+		NodeID:          device.NodeID,
+		Status:          device.Status,
+		NATType:         device.NATType,
+		Version:         device.Version,
+		OS:              device.OS,
+		Arch:            device.Arch,
+		Region:          device.Region,
+		LastSeenAt:      device.LastSeenAt,
+		Resources:       device.Resources,
+		ClockSkewMs:     device.ClockSkewMs,
+		AppCount:        appCount,
+		ConnectionCount: connectionCount,
+		BytesSent:       stats.BytesSent,
+		BytesReceived:   stats.BytesReceived,
+		Connections:     stats.Connections,
+		ConnectionTime:  stats.ConnectionTime,
+		ExpiresAt:       link.ExpiresAt,
+		ObservedAt:      time.Now(),
+	}, nil
+}
+
+// ownedDevice 获取属于用户的设备，其他用户的设备视为不存在
+func (s *Service) ownedDevice(userID, deviceID uint) (*db.Device, error) {
+	device, err := s.devices.GetByID(deviceID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("设备不存在")
+		}
+		return nil, errors.Database("查询设备失败", err)
+	}
+	if device.UserID != userID {
+		return nil, errors.NotFound("设备不存在")
+	}
+	return device, nil
+}
+
+// hashObserverToken 计算观察链接令牌的哈希
+func hashObserverToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,71 @@
+package device
+
+import (
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+func TestObserverLinks(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Auth.Observer.BaseURL = "https://p3.example.com/"
+	st := store.NewMemoryStore()
+	svc := NewService(cfg, st)
+
+	dev := &db.Device{UserID: 1, Name: "home-nas", NodeID: "home-nas", Status: "online", ExternalIP: "203.0.113.5"}
+	if err := st.Devices.Create(dev); err != nil {
+		t.Fatalf("创建设备失败: %v", err)
+	}
+
+	if _, _, err := svc.CreateObserverLink(2, dev.ID, &ObserverLinkRequest{}); err == nil {
+		t.Fatal("不应为其他用户的设备创建观察链接")
+	}
+	if _, _, err := svc.CreateObserverLink(1, dev.ID, &ObserverLinkRequest{ExpiresIn: cfg.Auth.Observer.MaxTTL + 1}); err == nil {
+		t.Fatal("有效期超过上限时应创建失败")
+	}
+
+	link, token, err := svc.CreateObserverLink(1, dev.ID, &ObserverLinkRequest{Note: "support"})
+	if err != nil {
+		t.Fatalf("创建观察链接失败: %v", err)
+	}
+	if ttl := time.Until(link.ExpiresAt); ttl <= 59*time.Minute || ttl > time.Hour {
+		t.Fatalf("未指定有效期时应使用默认有效期: %s", ttl)
+	}
+	if url := svc.ObserverLinkURL(token); url != "https://p3.example.com/observe/"+token {
+		t.Fatalf("观察链接不正确: %s", url)
+	}
+
+	observed, err := svc.AuthenticateObserver(token)
+	if err != nil || observed.ID != link.ID {
+		t.Fatalf("令牌应通过验证: %v", err)
+	}
+	if _, err := svc.AuthenticateObserver(token + "x"); err == nil {
+		t.Fatal("错误的令牌不应通过验证")
+	}
+	view, err := svc.ObserveDevice(observed)
+	if err != nil || view.NodeID != "home-nas" || view.Status != "online" {
+		t.Fatalf("获取设备状态失败: %+v %v", view, err)
+	}
+
+	if err := svc.DeleteObserverLink(2, dev.ID, link.ID); err == nil {
+		t.Fatal("其他用户不能撤销观察链接")
+	}
+	if err := svc.DeleteObserverLink(1, dev.ID, link.ID); err != nil {
+		t.Fatalf("撤销观察链接失败: %v", err)
+	}
+	if _, err := svc.AuthenticateObserver(token); err == nil {
+		t.Fatal("撤销后令牌不应通过验证")
+	}
+
+	// 过期的链接不能使用
+	expired := &db.ObserverLink{UserID: 1, DeviceID: dev.ID, TokenHash: hashObserverToken("expired"), ExpiresAt: time.Now().Add(-time.Second)}
+	if err := st.Observers.Create(expired); err != nil {
+		t.Fatalf("创建观察链接失败: %v", err)
+	}
+	if _, err := svc.AuthenticateObserver("expired"); err == nil {
+		t.Fatal("过期的令牌不应通过验证")
+	}
+}
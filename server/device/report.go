@@ -0,0 +1,292 @@
+package device
+
+import (
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// 单次上报的最大应用、连接和目标地址数
+const (
+	maxAppsPerReport         = 100
+	maxConnectionsPerReport  = 100
+	maxDestinationsPerReport = 200
+	maxPunchesPerReport      = 50
+)
+
+// StatusReport 心跳和设备状态
+type StatusReport struct {
+	Status     string `json:"status" binding:"required,max=20,safetext" sanitize:"text"`
+	NATType    string `json:"natType" binding:"max=50,safetext" sanitize:"text"`
+	ExternalIP string `json:"externalIP" binding:"max=64,safetext" sanitize:"text"`
+	LocalIP    string `json:"localIP" binding:"max=64,safetext" sanitize:"text"`
+	Version    string `json:"version" binding:"max=50,safetext" sanitize:"text"`
+	OS         string `json:"os" binding:"max=20,safetext" sanitize:"text"`
+	Arch       string `json:"arch" binding:"max=20,safetext" sanitize:"text"`
+	Region     string `json:"region" binding:"max=50,safetext" sanitize:"text"`
+	// 设备发送上报时的本机时间，用于检查时钟偏差，旧版本的客户端不上报
+	ClientTime time.Time `json:"clientTime"`
+}
+
+// AppStatsReport 应用自启动以来的累计流量统计
+type AppStatsReport struct {
+	Name           string `json:"name" binding:"required,max=50,safetext" sanitize:"text"`
+	BytesSent      uint64 `json:"bytesSent"`
+	BytesReceived  uint64 `json:"bytesReceived"`
+	Connections    uint64 `json:"connections"`
+	ConnectionTime uint64 `json:"connectionTime"`
+}
+
+// ConnectionReport 与对等节点的连接摘要
+type ConnectionReport struct {
+	PeerID        string    `json:"peerId" binding:"required,max=50,safetext" sanitize:"text"`
+	Type          string    `json:"type" binding:"required,max=20,safetext" sanitize:"text"`
+	Status        string    `json:"status" binding:"required,max=20,safetext" sanitize:"text"`
+	EstablishedAt time.Time `json:"establishedAt"`
+	LastActiveAt  time.Time `json:"lastActiveAt"`
+	BytesSent     uint64    `json:"bytesSent"`
+	BytesReceived uint64    `json:"bytesReceived"`
+	MTU           int       `json:"mtu" binding:"min=0,max=65535"`
+}
+
+// DestinationReport 应用自上次上报以来到单个目标地址的流量增量
+type DestinationReport struct {
+	App           string `json:"app" binding:"required,max=50,safetext" sanitize:"text"`
+	Protocol      string `json:"protocol" binding:"required,oneof=tcp udp"`
+	Host          string `json:"host" binding:"max=255,safetext" sanitize:"text"`
+	Address       string `json:"address" binding:"required,max=64,safetext" sanitize:"text"`
+	BytesSent     uint64 `json:"bytesSent"`
+	BytesReceived uint64 `json:"bytesReceived"`
+	Connections   uint64 `json:"connections"`
+}
+
+// ResourceReport 客户端的资源占用和压力，旧版本客户端不上报
+type ResourceReport struct {
+	Connections    int    `json:"connections" binding:"min=0"`
+	Goroutines     int    `json:"goroutines" binding:"min=0"`
+	BufferMemory   int64  `json:"bufferMemory" binding:"min=0"`
+	MemoryEstimate int64  `json:"memoryEstimate" binding:"min=0"`
+	Rejected       uint64 `json:"rejected"`
+	Pressure       string `json:"pressure" binding:"required,oneof=normal high critical"`
+}
+
+// SelfTestReport 客户端的连通性自检结果，只在有新的自检结果时上报
+type SelfTestReport struct {
+	TestedAt     time.Time `json:"testedAt" binding:"required"`
+	UDPReachable bool      `json:"udpReachable"`
+	TCPReachable bool      `json:"tcpReachable"`
+	UDPLatency   int64     `json:"udpLatency" binding:"min=0"`
+	TCPLatency   int64     `json:"tcpLatency" binding:"min=0"`
+	Mapping      string    `json:"mapping" binding:"required,oneof=none endpoint-independent endpoint-dependent unknown"`
+	NATMismatch  bool      `json:"natMismatch"`
+}
+
+// PunchReport 自上次上报以来一组 NAT 类型组合的打洞结果，只包含双方的 NAT 类型，不包含对等节点
+type PunchReport struct {
+	LocalNAT  protocol.NATType `json:"localNat"`
+	PeerNAT   protocol.NATType `json:"peerNat"`
+	Attempts  uint64           `json:"attempts" binding:"min=1,max=1000"`
+	Successes uint64           `json:"successes" binding:"ltefield=Attempts"`
+}
+
+// ReportRequest 设备批量上报请求，一次请求包含心跳、各应用的流量统计、连接摘要、按目标地址的流量增量、资源占用、打洞结果和连通性自检结果
+type ReportRequest struct {
+	Status       StatusReport        `json:"status" binding:"required"`
+	Apps         []AppStatsReport    `json:"apps" binding:"dive"`
+	Connections  []ConnectionReport  `json:"connections" binding:"dive"`
+	Destinations []DestinationReport `json:"destinations" binding:"dive"`
+	Resources    *ResourceReport     `json:"resources"`
+	Punches      []PunchReport       `json:"punches" binding:"dive"`
+	SelfTest     *SelfTestReport     `json:"selfTest"`
+}
+
+// Report 处理设备的批量上报。设备状态、流量统计、目标地址统计和连接记录在同一事务中写入，
+// 任何一项失败时都不写入；不存在的应用和对等节点会被忽略
+func (s *Service) Report(device *db.Device, req *ReportRequest) (*db.Device, error) {
+	receivedAt := time.Now()
+	if len(req.Apps) > maxAppsPerReport {
+		return nil, errors.InvalidParam("单次上报的应用过多")
+	}
+	if len(req.Connections) > maxConnectionsPerReport {
+		return nil, errors.InvalidParam("单次上报的连接过多")
+	}
+	if len(req.Destinations) > maxDestinationsPerReport {
+		return nil, errors.InvalidParam("单次上报的目标地址过多")
+	}
+	if len(req.Punches) > maxPunchesPerReport {
+		return nil, errors.InvalidParam("单次上报的打洞结果过多")
+	}
+
+	appIDs, err := s.reportAppIDs(device, len(req.Apps)+len(req.Destinations))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	stats := s.reportStats(device, appIDs, req.Apps)
+	destinations := s.reportDestinations(device, appIDs, req.Destinations, now)
+	conns, err := s.reportConnections(device, req.Connections)
+	if err != nil {
+		return nil, err
+	}
+
+	updates := map[string]interface{}{
+		"status":       req.Status.Status,
+		"nat_type":     req.Status.NATType,
+		"external_ip":  req.Status.ExternalIP,
+		"local_ip":     req.Status.LocalIP,
+		"version":      req.Status.Version,
+		"os":           req.Status.OS,
+		"arch":         req.Status.Arch,
+		"region":       req.Status.Region,
+		"last_seen_at": now,
+	}
+	skew, _ := ClockSkew(req.Status.ClientTime, receivedAt)
+	updates["clock_skew_ms"] = skew.Milliseconds()
+	if res := req.Resources; res != nil {
+		updates["resource_connections"] = res.Connections
+		updates["resource_goroutines"] = res.Goroutines
+		updates["resource_buffer_memory"] = res.BufferMemory
+		updates["resource_memory_estimate"] = res.MemoryEstimate
+		updates["resource_rejected"] = res.Rejected
+		updates["resource_pressure"] = res.Pressure
+		if res.Pressure == "critical" && device.Resources.Pressure != "critical" {
+			logger.Warn("设备 %s 资源不足，正在拒绝新的连接 (连接 %d，估算内存 %d 字节)", device.NodeID, res.Connections, res.MemoryEstimate)
+		}
+	}
+	if st := req.SelfTest; st != nil {
+		updates["self_test_tested_at"] = st.TestedAt
+		updates["self_test_udp_reachable"] = st.UDPReachable
+		updates["self_test_tcp_reachable"] = st.TCPReachable
+		updates["self_test_udp_latency"] = st.UDPLatency
+		updates["self_test_tcp_latency"] = st.TCPLatency
+		updates["self_test_mapping"] = st.Mapping
+		updates["self_test_nat_mismatch"] = st.NATMismatch
+		if st.NATMismatch && !device.SelfTest.NATMismatch {
+			logger.Warn("设备 %s 的连通性自检观察到的映射行为 %s 与上报的 NAT 类型 %s 不一致", device.NodeID, st.Mapping, req.Status.NATType)
+		}
+	}
+
+	updated := *device
+	if err := s.devices.SaveReport(&updated, updates, stats, conns, destinations); err != nil {
+		return nil, errors.Database("保存设备上报失败", err)
+	}
+	s.checkClockSkew(&updated, time.Duration(device.ClockSkewMs)*time.Millisecond, skew)
+	s.recordPunches(req.Punches)
+	return &updated, nil
+}
+
+// reportAppIDs 按名称查找设备的应用 ID，上报中没有应用相关的记录时不查询
+func (s *Service) reportAppIDs(device *db.Device, records int) (map[string]uint, error) {
+	if records == 0 {
+		return nil, nil
+	}
+	apps, err := s.apps.ListByDevice(device.ID)
+	if err != nil {
+		return nil, errors.Database("查询应用失败", err)
+	}
+	appIDs := make(map[string]uint, len(apps))
+	for _, app := range apps {
+		appIDs[app.Name] = app.ID
+	}
+	return appIDs, nil
+}
+
+// reportStats 生成各应用和设备整体的统计记录
+func (s *Service) reportStats(device *db.Device, appIDs map[string]uint, reports []AppStatsReport) []db.Stats {
+	total := db.Stats{UserID: device.UserID, DeviceID: device.ID}
+	stats := make([]db.Stats, 0, len(reports)+1)
+	for _, report := range reports {
+		appID, ok := appIDs[report.Name]
+		if !ok {
+			continue
+		}
+		stats = append(stats, db.Stats{
+			UserID:         device.UserID,
+			DeviceID:       device.ID,
+			AppID:          appID,
+			BytesSent:      report.BytesSent,
+			BytesReceived:  report.BytesReceived,
+			Connections:    report.Connections,
+			ConnectionTime: report.ConnectionTime,
+		})
+		total.BytesSent += report.BytesSent
+		total.BytesReceived += report.BytesReceived
+		total.Connections += report.Connections
+		total.ConnectionTime += report.ConnectionTime
+	}
+	if len(stats) == 0 {
+		return nil
+	}
+	return append(stats, total)
+}
+
+// reportDestinations 生成目标地址的流量增量记录
+func (s *Service) reportDestinations(device *db.Device, appIDs map[string]uint, reports []DestinationReport, now time.Time) []db.DestinationStats {
+	destinations := make([]db.DestinationStats, 0, len(reports))
+	for _, report := range reports {
+		appID, ok := appIDs[report.App]
+		if !ok {
+			continue
+		}
+		destinations = append(destinations, db.DestinationStats{
+			UserID:        device.UserID,
+			DeviceID:      device.ID,
+			AppID:         appID,
+			Protocol:      report.Protocol,
+			Host:          report.Host,
+			Address:       report.Address,
+			BytesSent:     report.BytesSent,
+			BytesReceived: report.BytesReceived,
+			Connections:   report.Connections,
+			ReportedAt:    now,
+		})
+	}
+	return destinations
+}
+
+// recordPunches 按 NAT 类型组合累加打洞结果。NAT 类型未知的结果无法用于判断，不记录；
+// 统计与设备无关，不在上报的事务中写入，失败时只记录日志
+func (s *Service) recordPunches(reports []PunchReport) {
+	if s.punches == nil {
+		return
+	}
+	for _, report := range reports {
+		if report.LocalNAT == protocol.NATUnknown || report.PeerNAT == protocol.NATUnknown {
+			continue
+		}
+		if err := s.punches.Add(report.LocalNAT.String(), report.PeerNAT.String(), report.Attempts, report.Successes); err != nil {
+			logger.Warn("记录打洞结果统计失败: %v", err)
+			return
+		}
+	}
+}
+
+// reportConnections 生成连接记录，对等节点按节点 ID 查找
+func (s *Service) reportConnections(device *db.Device, reports []ConnectionReport) ([]db.Connection, error) {
+	conns := make([]db.Connection, 0, len(reports))
+	for _, report := range reports {
+		peer, err := s.devices.GetByNodeID(report.PeerID)
+		if err != nil {
+			if store.IsNotFound(err) {
+				continue
+			}
+			return nil, errors.Database("查询对等节点失败", err)
+		}
+		conns = append(conns, db.Connection{
+			SourceDeviceID: device.ID,
+			TargetDeviceID: peer.ID,
+			Type:           report.Type,
+			Status:         report.Status,
+			EstablishedAt:  report.EstablishedAt,
+			LastActiveAt:   report.LastActiveAt,
+			BytesSent:      report.BytesSent,
+			BytesReceived:  report.BytesReceived,
+			MTU:            report.MTU,
+		})
+	}
+	return conns, nil
+}
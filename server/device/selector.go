@@ -0,0 +1,201 @@
+package device
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+)
+
+const (
+	// maxLabels 单个设备的最大标签数
+	maxLabels = 32
+	// maxLabelValueLen 标签值的最大长度
+	maxLabelValueLen = 63
+	// maxExpressionLen 筛选条件的最大长度
+	maxExpressionLen = 500
+)
+
+// labelKeyPattern 标签名只能包含字母、数字、点、下划线和短横线
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,62}$`)
+
+// deviceFields 可用于筛选的设备字段
+var deviceFields = map[string]func(d *db.Device) string{
+	"name":    func(d *db.Device) string { return d.Name },
+	"nodeid":  func(d *db.Device) string { return d.NodeID },
+	"status":  func(d *db.Device) string { return d.Status },
+	"nattype": func(d *db.Device) string { return d.NATType },
+	"version": func(d *db.Device) string { return d.Version },
+	"os":      func(d *db.Device) string { return d.OS },
+	"arch":    func(d *db.Device) string { return d.Arch },
+	"region":  func(d *db.Device) string { return d.Region },
+}
+
+// condition 单个筛选条件
+type condition struct {
+	field   string // 设备字段，为空时按标签匹配
+	label   string // 标签名
+	value   string // 期望的值，支持 * 和 ? 通配符；为空且 exists 为 true 时只检查标签是否存在
+	exists  bool
+	negated bool
+}
+
+// Selector 设备筛选条件，所有条件都满足时匹配。
+//
+// 条件之间用 AND 或逗号分隔，每个条件的格式为：
+//   - status=offline、region!=eu：按设备字段匹配，支持 name、nodeId、status、natType、version、os、arch、region
+//   - tag=warehouse、tag!=warehouse：设备是否有名为 warehouse 的标签，不论标签值
+//   - label.site=shanghai、label.site!=shanghai：按标签值匹配，没有该标签视为不相等
+//
+// 值支持 * 和 ? 通配符，例如 name=office-*
+type Selector struct {
+	conditions []condition
+}
+
+// ParseSelector 解析筛选条件，空字符串匹配所有设备
+func ParseSelector(expr string) (*Selector, error) {
+	if len(expr) > maxExpressionLen {
+		return nil, errors.InvalidParam(fmt.Sprintf("筛选条件不能超过 %d 个字符", maxExpressionLen))
+	}
+
+	selector := &Selector{}
+	for _, term := range splitTerms(expr) {
+		cond, err := parseCondition(term)
+		if err != nil {
+			return nil, err
+		}
+		selector.conditions = append(selector.conditions, cond)
+	}
+	return selector, nil
+}
+
+// splitTerms 按 AND（不区分大小写）和逗号拆分条件
+func splitTerms(expr string) []string {
+	var terms []string
+	for _, part := range strings.Split(expr, ",") {
+		fields := strings.Fields(part)
+		start := 0
+		for i, field := range fields {
+			if strings.EqualFold(field, "AND") {
+				terms = append(terms, strings.Join(fields[start:i], " "))
+				start = i + 1
+			}
+		}
+		terms = append(terms, strings.Join(fields[start:], " "))
+	}
+
+	result := terms[:0]
+	for _, term := range terms {
+		if term != "" {
+			result = append(result, term)
+		}
+	}
+	return result
+}
+
+// parseCondition 解析单个条件
+func parseCondition(term string) (condition, error) {
+	var cond condition
+	key, value, ok := strings.Cut(term, "!=")
+	if ok {
+		cond.negated = true
+	} else if key, value, ok = strings.Cut(term, "="); !ok {
+		return cond, errors.InvalidParam(fmt.Sprintf("无效的筛选条件: %s", term))
+	}
+	key = strings.TrimSpace(key)
+	value = strings.TrimSpace(value)
+	if _, err := path.Match(value, ""); err != nil {
+		return cond, errors.InvalidParam(fmt.Sprintf("无效的筛选值: %s", value))
+	}
+
+	lower := strings.ToLower(key)
+	switch {
+	case lower == "tag":
+		if !labelKeyPattern.MatchString(value) {
+			return cond, errors.InvalidParam(fmt.Sprintf("无效的标签名: %s", value))
+		}
+		cond.label = value
+		cond.exists = true
+	case strings.HasPrefix(lower, "label."):
+		cond.label = key[len("label."):]
+		if !labelKeyPattern.MatchString(cond.label) {
+			return cond, errors.InvalidParam(fmt.Sprintf("无效的标签名: %s", cond.label))
+		}
+		cond.value = value
+	default:
+		if _, ok := deviceFields[lower]; !ok {
+			return cond, errors.InvalidParam(fmt.Sprintf("不支持的筛选字段: %s", key))
+		}
+		cond.field = lower
+		cond.value = value
+	}
+	return cond, nil
+}
+
+// Match 检查设备是否满足所有条件
+func (s *Selector) Match(device *db.Device) bool {
+	for _, cond := range s.conditions {
+		if cond.match(device) == cond.negated {
+			return false
+		}
+	}
+	return true
+}
+
+// match 检查设备是否满足条件，不考虑取反
+func (c *condition) match(device *db.Device) bool {
+	if c.field != "" {
+		return matchValue(c.value, deviceFields[c.field](device))
+	}
+	value, ok := device.Labels[c.label]
+	if c.exists || !ok {
+		return ok
+	}
+	return matchValue(c.value, value)
+}
+
+// matchValue 不区分大小写地按通配符匹配
+func matchValue(pattern, value string) bool {
+	matched, _ := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return matched
+}
+
+// ValidateLabels 检查设备标签是否有效
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > maxLabels {
+		return errors.InvalidParam(fmt.Sprintf("每个设备最多 %d 个标签", maxLabels))
+	}
+	for key, value := range labels {
+		if !labelKeyPattern.MatchString(key) {
+			return errors.InvalidParam(fmt.Sprintf("无效的标签名: %s", key))
+		}
+		if len(value) > maxLabelValueLen || strings.ContainsAny(value, "<>\"'`\r\n") {
+			return errors.InvalidParam(fmt.Sprintf("标签 %s 的值无效", key))
+		}
+	}
+	return nil
+}
+
+// SelectDevices 获取用户满足筛选条件的设备
+func (s *Service) SelectDevices(userID uint, expr string) ([]db.Device, error) {
+	selector, err := ParseSelector(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := s.devices.ListByUser(userID)
+	if err != nil {
+		return nil, errors.Database("查询设备失败", err)
+	}
+
+	selected := make([]db.Device, 0, len(devices))
+	for i := range devices {
+		if selector.Match(&devices[i]) {
+			selected = append(selected, devices[i])
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,55 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/senma231/p3/server/db"
+)
+
+func TestSelector(t *testing.T) {
+	warehouse := &db.Device{Name: "office-gw", Status: "offline", Region: "cn", Labels: db.Labels{"warehouse": "", "site": "Shanghai"}}
+	office := &db.Device{Name: "home-nas", Status: "online", Region: "cn", Labels: db.Labels{"site": "beijing"}}
+	unlabeled := &db.Device{Name: "office-pc", Status: "offline"}
+
+	tests := []struct {
+		expr string
+		want []bool
+	}{
+		{"", []bool{true, true, true}},
+		{"status=offline AND tag=warehouse", []bool{true, false, false}},
+		{"status=offline and tag!=warehouse", []bool{false, false, true}},
+		{"label.site=shanghai", []bool{true, false, false}},
+		{"label.site!=shanghai", []bool{false, true, true}},
+		{"name=office-*, region=cn", []bool{true, false, false}},
+		{"Status=OFFLINE", []bool{true, false, true}},
+	}
+	for _, tt := range tests {
+		selector, err := ParseSelector(tt.expr)
+		if err != nil {
+			t.Fatalf("解析 %q 失败: %v", tt.expr, err)
+		}
+		for i, device := range []*db.Device{warehouse, office, unlabeled} {
+			if got := selector.Match(device); got != tt.want[i] {
+				t.Errorf("%q 匹配设备 %s 应为 %t", tt.expr, device.Name, tt.want[i])
+			}
+		}
+	}
+
+	for _, expr := range []string{"status", "owner=alice", "tag=", "label.=x", "name=[a"} {
+		if _, err := ParseSelector(expr); err == nil {
+			t.Errorf("%q 应返回错误", expr)
+		}
+	}
+}
+
+func TestValidateLabels(t *testing.T) {
+	if err := ValidateLabels(map[string]string{"site": "shanghai", "warehouse": ""}); err != nil {
+		t.Fatalf("有效的标签返回错误: %v", err)
+	}
+	if err := ValidateLabels(map[string]string{"bad key": ""}); err == nil {
+		t.Fatalf("无效的标签名应返回错误")
+	}
+	if err := ValidateLabels(map[string]string{"site": "<script>"}); err == nil {
+		t.Fatalf("无效的标签值应返回错误")
+	}
+}
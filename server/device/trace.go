@@ -0,0 +1,96 @@
+package device
+
+import (
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+)
+
+// maxTraceAttempts 单条连接记录的最大尝试次数
+const maxTraceAttempts = 50
+
+// TraceAttemptRequest 连接尝试
+type TraceAttemptRequest struct {
+	Method     string    `json:"method" binding:"required,max=20,safetext" sanitize:"text"`
+	Candidate  string    `json:"candidate" binding:"max=100,safetext" sanitize:"text"`
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs int64     `json:"durationMs" binding:"min=0"`
+	Skipped    bool      `json:"skipped"`
+	SkipReason string    `json:"skipReason" binding:"max=200,safetext" sanitize:"text"`
+	Error      string    `json:"error" binding:"max=500,safemultiline" sanitize:"multiline"`
+}
+
+// TraceRequest 连接记录上报请求
+type TraceRequest struct {
+	PeerID     string                `json:"peerId" binding:"required,max=50,safetext" sanitize:"text"`
+	LocalNAT   string                `json:"localNat" binding:"max=50,safetext" sanitize:"text"`
+	PeerNAT    string                `json:"peerNat" binding:"max=50,safetext" sanitize:"text"`
+	StartedAt  time.Time             `json:"startedAt"`
+	DurationMs int64                 `json:"durationMs" binding:"min=0"`
+	Path       string                `json:"path" binding:"max=20,safetext" sanitize:"text"`
+	Error      string                `json:"error" binding:"max=500,safemultiline" sanitize:"multiline"`
+	Attempts   []TraceAttemptRequest `json:"attempts" binding:"dive"`
+
+	// 本次连接使用的连接优先级及其来源
+	Priority       []string `json:"priority" binding:"max=5,dive,max=20,safetext"`
+	PrioritySource string   `json:"prioritySource" binding:"max=20,safetext" sanitize:"text"`
+}
+
+// RecordTrace 记录设备上报的连接过程
+func (s *Service) RecordTrace(deviceID uint, req *TraceRequest) (*db.ConnectionTrace, error) {
+	if len(req.Attempts) > maxTraceAttempts {
+		return nil, errors.InvalidParam("连接尝试次数过多")
+	}
+
+	now := time.Now()
+	startedAt := req.StartedAt
+	if startedAt.IsZero() || startedAt.After(now) {
+		startedAt = now
+	}
+
+	steps := make(db.ConnectionSteps, 0, len(req.Attempts))
+	for _, attempt := range req.Attempts {
+		steps = append(steps, db.ConnectionStep{
+			Method:     attempt.Method,
+			Candidate:  attempt.Candidate,
+			StartedAt:  attempt.StartedAt,
+			DurationMs: attempt.DurationMs,
+			Skipped:    attempt.Skipped,
+			SkipReason: attempt.SkipReason,
+			Error:      attempt.Error,
+		})
+	}
+
+	trace := &db.ConnectionTrace{
+		DeviceID:   deviceID,
+		PeerID:     req.PeerID,
+		LocalNAT:   req.LocalNAT,
+		PeerNAT:    req.PeerNAT,
+		Path:       req.Path,
+		Error:      req.Error,
+		DurationMs: req.DurationMs,
+		Attempts:   steps,
+		StartedAt:  startedAt,
+
+		Priority:       db.CandidatePriority(req.Priority),
+		PrioritySource: req.PrioritySource,
+	}
+	if err := s.devices.CreateTrace(trace); err != nil {
+		return nil, errors.Database("保存连接记录失败", err)
+	}
+	return trace, nil
+}
+
+// GetTraces 获取设备最近的连接记录，peerID 不为空时只返回与该节点的记录
+func (s *Service) GetTraces(deviceID uint, peerID string, limit int) ([]db.ConnectionTrace, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 20
+	}
+
+	traces, err := s.devices.ListTraces(deviceID, peerID, limit)
+	if err != nil {
+		return nil, errors.Database("查询连接记录失败", err)
+	}
+	return traces, nil
+}
@@ -0,0 +1,79 @@
+package device
+
+import (
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/version"
+	"github.com/senma231/p3/server/config"
+)
+
+// 客户端版本状态
+const (
+	// VersionSupported 版本满足要求
+	VersionSupported = "supported"
+	// VersionOutdated 版本低于推荐版本，仍可连接
+	VersionOutdated = "outdated"
+	// VersionUnsupported 版本低于最低支持版本，拒绝连接
+	VersionUnsupported = "unsupported"
+)
+
+// CheckClientVersion 根据客户端版本策略检查版本状态。未上报或无法解析的版本（如开发版本）只在
+// 策略允许开发版本时视为满足要求，否则配置了最低版本时拒绝，只配置了推荐版本时提示升级
+func CheckClientVersion(policy *config.ClientVersionConfig, clientVersion string) string {
+	if version.Major(clientVersion) < 0 {
+		switch {
+		case policy.AllowDevBuilds:
+			return VersionSupported
+		case policy.MinVersion != "":
+			return VersionUnsupported
+		case policy.RecommendedVersion != "":
+			return VersionOutdated
+		}
+		return VersionSupported
+	}
+	if policy.MinVersion != "" && version.Compare(clientVersion, policy.MinVersion) < 0 {
+		return VersionUnsupported
+	}
+	if policy.RecommendedVersion != "" && version.Compare(clientVersion, policy.RecommendedVersion) < 0 {
+		return VersionOutdated
+	}
+	return VersionSupported
+}
+
+// ClientVersionSummary 客户端版本统计
+type ClientVersionSummary struct {
+	MinVersion         string         `json:"minVersion"`
+	RecommendedVersion string         `json:"recommendedVersion"`
+	Total              int            `json:"total"`
+	Outdated           int            `json:"outdated"`
+	Unsupported        int            `json:"unsupported"`
+	Versions           map[string]int `json:"versions"`
+}
+
+// GetClientVersionSummary 统计所有设备上报的客户端版本
+func (s *Service) GetClientVersionSummary(policy *config.ClientVersionConfig) (*ClientVersionSummary, error) {
+	versions, err := s.devices.ListVersions()
+	if err != nil {
+		return nil, errors.Database("查询设备版本失败", err)
+	}
+
+	summary := &ClientVersionSummary{
+		MinVersion:         policy.MinVersion,
+		RecommendedVersion: policy.RecommendedVersion,
+		Total:              len(versions),
+		Versions:           make(map[string]int),
+	}
+	for _, v := range versions {
+		switch CheckClientVersion(policy, v) {
+		case VersionOutdated:
+			summary.Outdated++
+		case VersionUnsupported:
+			summary.Unsupported++
+		}
+
+		if v == "" {
+			v = "unknown"
+		}
+		summary.Versions[v]++
+	}
+	return summary, nil
+}
@@ -0,0 +1,42 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/senma231/p3/server/config"
+)
+
+func TestCheckClientVersion(t *testing.T) {
+	policy := &config.ClientVersionConfig{MinVersion: "0.2.0", RecommendedVersion: "0.3.0"}
+	recommendedOnly := &config.ClientVersionConfig{RecommendedVersion: "0.3.0"}
+	allowDev := &config.ClientVersionConfig{MinVersion: "0.2.0", RecommendedVersion: "0.3.0", AllowDevBuilds: true}
+
+	tests := []struct {
+		name    string
+		policy  *config.ClientVersionConfig
+		version string
+		want    string
+	}{
+		{name: "低于最低版本", policy: policy, version: "0.1.9", want: VersionUnsupported},
+		{name: "等于最低版本", policy: policy, version: "0.2.0", want: VersionOutdated},
+		{name: "低于推荐版本", policy: policy, version: "0.2.5", want: VersionOutdated},
+		{name: "等于推荐版本", policy: policy, version: "0.3.0", want: VersionSupported},
+		{name: "高于推荐版本", policy: policy, version: "1.0.0", want: VersionSupported},
+		{name: "带 v 前缀", policy: policy, version: "v0.3.1", want: VersionSupported},
+		{name: "预发布版本低于正式版本", policy: policy, version: "0.3.0-rc.1", want: VersionOutdated},
+		{name: "按数值比较", policy: policy, version: "0.10.0", want: VersionSupported},
+		{name: "开发版本", policy: policy, version: "dev", want: VersionUnsupported},
+		{name: "未上报版本", policy: policy, version: "", want: VersionUnsupported},
+		{name: "只配置推荐版本时开发版本", policy: recommendedOnly, version: "dev", want: VersionOutdated},
+		{name: "允许开发版本", policy: allowDev, version: "dev", want: VersionSupported},
+		{name: "允许开发版本时未上报版本", policy: allowDev, version: "", want: VersionSupported},
+		{name: "允许开发版本不放宽正式版本", policy: allowDev, version: "0.1.0", want: VersionUnsupported},
+		{name: "未配置策略", policy: &config.ClientVersionConfig{}, version: "dev", want: VersionSupported},
+		{name: "未配置策略时旧版本", policy: &config.ClientVersionConfig{}, version: "0.0.1", want: VersionSupported},
+	}
+	for _, tt := range tests {
+		if got := CheckClientVersion(tt.policy, tt.version); got != tt.want {
+			t.Errorf("%s: %q 的状态为 %s, 期望 %s", tt.name, tt.version, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+// Package diagnostics 设备上传的诊断包。诊断包保存在对象存储中，按设备分目录，
+// 用户通过签名链接下载，超过保留时间后自动清理
+package diagnostics
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/objstore"
+)
+
+// KeyPrefix 诊断包在对象存储中的键前缀
+const KeyPrefix = "diagnostics/"
+
+// maxBundlesPerDevice 每台设备保留的诊断包数量，超出时删除最早的
+const maxBundlesPerDevice = 20
+
+// ContentType 诊断包的内容类型
+const ContentType = "application/gzip"
+
+// Bundle 诊断包
+type Bundle struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	UploadedAt time.Time `json:"uploadedAt"`
+}
+
+// Service 诊断包服务
+type Service struct {
+	store     objstore.Store
+	maxSize   int64
+	urlExpiry time.Duration
+	now       func() time.Time
+}
+
+// NewService 创建诊断包服务，maxSize 为单个诊断包的最大字节数
+func NewService(store objstore.Store, maxSize int64, urlExpiry time.Duration) *Service {
+	return &Service{
+		store:     store,
+		maxSize:   maxSize,
+		urlExpiry: urlExpiry,
+		now:       time.Now,
+	}
+}
+
+// deviceKey 设备诊断包的键前缀
+func deviceKey(deviceID uint) string {
+	return fmt.Sprintf("%s%d/", KeyPrefix, deviceID)
+}
+
+// Upload 保存设备上传的诊断包，size 为请求的内容长度。设备的诊断包超过数量上限时删除最早的
+func (s *Service) Upload(ctx context.Context, deviceID uint, r io.Reader, size int64) (*Bundle, error) {
+	if size <= 0 {
+		return nil, errors.InvalidParam("需要指定诊断包大小")
+	}
+	if size > s.maxSize {
+		return nil, errors.InvalidParam(fmt.Sprintf("诊断包不能超过 %d MB", s.maxSize>>20))
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, errors.Internal("生成诊断包名称失败")
+	}
+	now := s.now().UTC()
+	bundle := &Bundle{
+		Name:       now.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix) + ".tar.gz",
+		Size:       size,
+		UploadedAt: now,
+	}
+	if err := s.store.Put(ctx, deviceKey(deviceID)+bundle.Name, io.LimitReader(r, size), size, ContentType); err != nil {
+		return nil, errors.Internal(fmt.Sprintf("保存诊断包失败: %v", err))
+	}
+
+	s.prune(ctx, deviceID)
+	return bundle, nil
+}
+
+// List 列出设备的诊断包，最新的在前
+func (s *Service) List(ctx context.Context, deviceID uint) ([]Bundle, error) {
+	prefix := deviceKey(deviceID)
+	objects, err := s.store.List(ctx, prefix)
+	if err != nil {
+		return nil, errors.Internal(fmt.Sprintf("列出诊断包失败: %v", err))
+	}
+	bundles := make([]Bundle, 0, len(objects))
+	for _, obj := range objects {
+		name := strings.TrimPrefix(obj.Key, prefix)
+		if strings.Contains(name, "/") {
+			continue
+		}
+		bundles = append(bundles, Bundle{Name: name, Size: obj.Size, UploadedAt: obj.ModTime})
+	}
+	// 名称以上传时间开头，按名称倒序即按时间倒序
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].Name > bundles[j].Name })
+	return bundles, nil
+}
+
+// DownloadURL 获取设备诊断包的签名下载链接
+func (s *Service) DownloadURL(ctx context.Context, deviceID uint, nodeID, name string) (string, error) {
+	if strings.Contains(name, "/") || objstore.ValidateKey(name) != nil {
+		return "", errors.InvalidParam("无效的诊断包名称")
+	}
+	bundles, err := s.List(ctx, deviceID)
+	if err != nil {
+		return "", err
+	}
+	for _, bundle := range bundles {
+		if bundle.Name == name {
+			return s.store.SignedURL(deviceKey(deviceID)+name, nodeID+"-"+name, s.urlExpiry)
+		}
+	}
+	return "", errors.NotFound("诊断包不存在")
+}
+
+// prune 删除超出数量上限的最早的诊断包
+func (s *Service) prune(ctx context.Context, deviceID uint) {
+	bundles, err := s.List(ctx, deviceID)
+	if err != nil || len(bundles) <= maxBundlesPerDevice {
+		return
+	}
+	for _, bundle := range bundles[maxBundlesPerDevice:] {
+		s.store.Delete(ctx, deviceKey(deviceID)+bundle.Name)
+	}
+}
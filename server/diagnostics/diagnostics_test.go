@@ -0,0 +1,63 @@
+package diagnostics
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/objstore"
+)
+
+func TestUploadAndPrune(t *testing.T) {
+	ctx := context.Background()
+	store, err := objstore.NewLocalStore(t.TempDir(), "", []byte("secret"))
+	if err != nil {
+		t.Fatalf("创建本地存储失败: %v", err)
+	}
+	s := NewService(store, 1<<20, time.Minute)
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.now = func() time.Time { return now }
+
+	if _, err := s.Upload(ctx, 1, strings.NewReader("x"), 2<<20); !errors.Is(err, errors.ErrInvalidParam) {
+		t.Fatalf("超过大小上限应返回参数错误，实际 %v", err)
+	}
+
+	var first *Bundle
+	for i := 0; i < maxBundlesPerDevice+2; i++ {
+		now = now.Add(time.Minute)
+		bundle, err := s.Upload(ctx, 1, strings.NewReader("bundle"), 6)
+		if err != nil {
+			t.Fatalf("上传诊断包失败: %v", err)
+		}
+		if first == nil {
+			first = bundle
+		}
+	}
+	if _, err := s.Upload(ctx, 2, strings.NewReader("other"), 5); err != nil {
+		t.Fatalf("上传诊断包失败: %v", err)
+	}
+
+	bundles, err := s.List(ctx, 1)
+	if err != nil || len(bundles) != maxBundlesPerDevice {
+		t.Fatalf("期望保留 %d 个诊断包，实际 %d，错误 %v", maxBundlesPerDevice, len(bundles), err)
+	}
+	if bundles[0].Name <= bundles[1].Name {
+		t.Fatalf("诊断包应按时间倒序: %s, %s", bundles[0].Name, bundles[1].Name)
+	}
+
+	if _, err := s.DownloadURL(ctx, 1, "node-a", first.Name); !errors.Is(err, errors.ErrNotFound) {
+		t.Fatalf("已删除的诊断包应返回不存在，实际 %v", err)
+	}
+	if _, err := s.DownloadURL(ctx, 2, "node-b", bundles[0].Name); !errors.Is(err, errors.ErrNotFound) {
+		t.Fatalf("其他设备的诊断包应返回不存在，实际 %v", err)
+	}
+	if _, err := s.DownloadURL(ctx, 1, "node-a", "../2/x"); !errors.Is(err, errors.ErrInvalidParam) {
+		t.Fatalf("无效名称应返回参数错误，实际 %v", err)
+	}
+	link, err := s.DownloadURL(ctx, 1, "node-a", bundles[0].Name)
+	if err != nil || !strings.Contains(link, "filename=node-a-") {
+		t.Fatalf("生成下载链接失败: %s %v", link, err)
+	}
+}
@@ -0,0 +1,327 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// 导出格式
+const (
+	FormatCSV  = "csv"
+	FormatJSON = "json"
+)
+
+// flushInterval 每写入多少条记录刷新一次输出
+const flushInterval = 100
+
+// Column 导出列
+type Column struct {
+	Name  string
+	Value func(record interface{}) interface{}
+}
+
+// Resource 可导出的资源
+type Resource struct {
+	Name    string
+	Columns []Column
+	each    func(exports store.ExportRepo, userID uint, since time.Time, fn func(record interface{}) error) error
+}
+
+// Flusher 支持刷新的输出
+type Flusher interface {
+	Flush()
+}
+
+// Options 导出选项
+type Options struct {
+	Resource string
+	Format   string
+	Columns  []string
+	Since    time.Time
+}
+
+// resources 可导出的资源列表
+var resources = map[string]*Resource{
+	"devices": {
+		Name: "devices",
+		Columns: []Column{
+			{"id", func(r interface{}) interface{} { return r.(*db.Device).ID }},
+			{"name", func(r interface{}) interface{} { return r.(*db.Device).Name }},
+			{"nodeId", func(r interface{}) interface{} { return r.(*db.Device).NodeID }},
+			{"status", func(r interface{}) interface{} { return r.(*db.Device).Status }},
+			{"natType", func(r interface{}) interface{} { return r.(*db.Device).NATType }},
+			{"externalIP", func(r interface{}) interface{} { return r.(*db.Device).ExternalIP }},
+			{"localIP", func(r interface{}) interface{} { return r.(*db.Device).LocalIP }},
+			{"version", func(r interface{}) interface{} { return r.(*db.Device).Version }},
+			{"os", func(r interface{}) interface{} { return r.(*db.Device).OS }},
+			{"arch", func(r interface{}) interface{} { return r.(*db.Device).Arch }},
+			{"lastSeenAt", func(r interface{}) interface{} { return r.(*db.Device).LastSeenAt }},
+			{"createdAt", func(r interface{}) interface{} { return r.(*db.Device).CreatedAt }},
+		},
+		each: func(exports store.ExportRepo, userID uint, since time.Time, fn func(record interface{}) error) error {
+			return exports.EachDevice(userID, since, func(device *db.Device) error { return fn(device) })
+		},
+	},
+	"forwards": {
+		Name: "forwards",
+		Columns: []Column{
+			{"id", func(r interface{}) interface{} { return r.(*db.Forward).ID }},
+			{"protocol", func(r interface{}) interface{} { return r.(*db.Forward).Protocol }},
+			{"srcPort", func(r interface{}) interface{} { return r.(*db.Forward).SrcPort }},
+			{"dstHost", func(r interface{}) interface{} { return r.(*db.Forward).DstHost }},
+			{"dstPort", func(r interface{}) interface{} { return r.(*db.Forward).DstPort }},
+			{"description", func(r interface{}) interface{} { return r.(*db.Forward).Description }},
+			{"enabled", func(r interface{}) interface{} { return r.(*db.Forward).Enabled }},
+			{"createdAt", func(r interface{}) interface{} { return r.(*db.Forward).CreatedAt }},
+		},
+		each: func(exports store.ExportRepo, userID uint, since time.Time, fn func(record interface{}) error) error {
+			return exports.EachForward(userID, since, func(forward *db.Forward) error { return fn(forward) })
+		},
+	},
+	"connections": {
+		Name: "connections",
+		Columns: []Column{
+			{"id", func(r interface{}) interface{} { return r.(*db.Connection).ID }},
+			{"sourceDeviceId", func(r interface{}) interface{} { return r.(*db.Connection).SourceDeviceID }},
+			{"targetDeviceId", func(r interface{}) interface{} { return r.(*db.Connection).TargetDeviceID }},
+			{"type", func(r interface{}) interface{} { return r.(*db.Connection).Type }},
+			{"status", func(r interface{}) interface{} { return r.(*db.Connection).Status }},
+			{"establishedAt", func(r interface{}) interface{} { return r.(*db.Connection).EstablishedAt }},
+			{"lastActiveAt", func(r interface{}) interface{} { return r.(*db.Connection).LastActiveAt }},
+			{"bytesSent", func(r interface{}) interface{} { return r.(*db.Connection).BytesSent }},
+			{"bytesReceived", func(r interface{}) interface{} { return r.(*db.Connection).BytesReceived }},
+			{"mtu", func(r interface{}) interface{} { return r.(*db.Connection).MTU }},
+		},
+		each: func(exports store.ExportRepo, userID uint, since time.Time, fn func(record interface{}) error) error {
+			return exports.EachConnection(userID, since, func(conn *db.Connection) error { return fn(conn) })
+		},
+	},
+}
+
+// GetResource 获取可导出的资源
+func GetResource(name string) (*Resource, error) {
+	resource, exists := resources[name]
+	if !exists {
+		return nil, fmt.Errorf("不支持导出的资源: %s", name)
+	}
+	return resource, nil
+}
+
+// SelectColumns 根据列名选择导出列，列名为空时返回所有列
+func (r *Resource) SelectColumns(names []string) ([]Column, error) {
+	if len(names) == 0 {
+		return r.Columns, nil
+	}
+
+	columns := make([]Column, 0, len(names))
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		found := false
+		for _, column := range r.Columns {
+			if column.Name == name {
+				columns = append(columns, column)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("资源 %s 不存在列: %s", r.Name, name)
+		}
+	}
+	return columns, nil
+}
+
+// Validate 验证导出选项
+func (o *Options) Validate() error {
+	if o.Format != FormatCSV && o.Format != FormatJSON {
+		return fmt.Errorf("不支持的导出格式: %s", o.Format)
+	}
+	resource, err := GetResource(o.Resource)
+	if err != nil {
+		return err
+	}
+	_, err = resource.SelectColumns(o.Columns)
+	return err
+}
+
+// ContentType 获取导出格式对应的内容类型
+func ContentType(format string) string {
+	if format == FormatJSON {
+		return "application/json; charset=utf-8"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// Export 按行流式导出用户的资源，不在内存中缓存全部记录
+func Export(w io.Writer, exports store.ExportRepo, userID uint, opts *Options) error {
+	resource, err := GetResource(opts.Resource)
+	if err != nil {
+		return err
+	}
+	columns, err := resource.SelectColumns(opts.Columns)
+	if err != nil {
+		return err
+	}
+
+	var rw recordWriter
+	if opts.Format == FormatJSON {
+		rw = newJSONWriter(w, columns)
+	} else {
+		rw = newCSVWriter(w, columns)
+	}
+
+	if err := rw.begin(); err != nil {
+		return err
+	}
+
+	// 写入错误单独记录，与读取数据的错误区分
+	var writeErr error
+	count := 0
+	err = resource.each(exports, userID, opts.Since, func(record interface{}) error {
+		values := make([]interface{}, len(columns))
+		for i, column := range columns {
+			values[i] = column.Value(record)
+		}
+		if writeErr = rw.write(values); writeErr != nil {
+			return writeErr
+		}
+
+		// 定期刷新，让客户端尽早收到数据
+		count++
+		if count%flushInterval == 0 {
+			rw.flush()
+			if f, ok := w.(Flusher); ok {
+				f.Flush()
+			}
+		}
+		return nil
+	})
+	if writeErr != nil {
+		return writeErr
+	}
+	if err != nil {
+		return fmt.Errorf("读取导出数据失败: %w", err)
+	}
+
+	return rw.end()
+}
+
+// recordWriter 记录写入器
+type recordWriter interface {
+	begin() error
+	write(values []interface{}) error
+	flush()
+	end() error
+}
+
+// csvWriter CSV 写入器
+type csvWriter struct {
+	w       *csv.Writer
+	columns []Column
+}
+
+func newCSVWriter(w io.Writer, columns []Column) *csvWriter {
+	return &csvWriter{
+		w:       csv.NewWriter(w),
+		columns: columns,
+	}
+}
+
+func (c *csvWriter) begin() error {
+	header := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		header[i] = column.Name
+	}
+	return c.w.Write(header)
+}
+
+func (c *csvWriter) write(values []interface{}) error {
+	record := make([]string, len(values))
+	for i, value := range values {
+		record[i] = formatCSVValue(value)
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvWriter) flush() {
+	c.w.Flush()
+}
+
+func (c *csvWriter) end() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonWriter JSON 数组写入器
+type jsonWriter struct {
+	w       io.Writer
+	columns []Column
+	first   bool
+}
+
+func newJSONWriter(w io.Writer, columns []Column) *jsonWriter {
+	return &jsonWriter{
+		w:       w,
+		columns: columns,
+		first:   true,
+	}
+}
+
+func (j *jsonWriter) begin() error {
+	_, err := io.WriteString(j.w, "[")
+	return err
+}
+
+func (j *jsonWriter) write(values []interface{}) error {
+	record := make(map[string]interface{}, len(values))
+	for i, value := range values {
+		record[j.columns[i].Name] = value
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("序列化导出数据失败: %w", err)
+	}
+
+	if !j.first {
+		if _, err := io.WriteString(j.w, ",\n"); err != nil {
+			return err
+		}
+	}
+	j.first = false
+
+	_, err = j.w.Write(data)
+	return err
+}
+
+func (j *jsonWriter) flush() {}
+
+func (j *jsonWriter) end() error {
+	_, err := io.WriteString(j.w, "]\n")
+	return err
+}
+
+// formatCSVValue 格式化 CSV 单元格
+func formatCSVValue(value interface{}) string {
+	switch v := value.(type) {
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.Format(time.RFC3339)
+	case string:
+		// 防止电子表格公式注入
+		if v != "" && strings.ContainsRune("=+-@", rune(v[0])) {
+			return "'" + v
+		}
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
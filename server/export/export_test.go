@@ -0,0 +1,67 @@
+package export
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestSelectColumns(t *testing.T) {
+	resource, err := GetResource("devices")
+	if err != nil {
+		t.Fatalf("获取资源失败: %v", err)
+	}
+
+	columns, err := resource.SelectColumns(nil)
+	if err != nil || len(columns) != len(resource.Columns) {
+		t.Errorf("未指定列时应返回所有列")
+	}
+
+	columns, err = resource.SelectColumns([]string{"name", " nodeId"})
+	if err != nil {
+		t.Fatalf("选择列失败: %v", err)
+	}
+	if len(columns) != 2 || columns[0].Name != "name" || columns[1].Name != "nodeId" {
+		t.Errorf("选择的列不正确: %v", columns)
+	}
+
+	if _, err := resource.SelectColumns([]string{"token"}); err == nil {
+		t.Errorf("不存在的列应返回错误")
+	}
+
+	if _, err := GetResource("users"); err == nil {
+		t.Errorf("不支持的资源应返回错误")
+	}
+}
+
+func TestWriters(t *testing.T) {
+	columns := []Column{{Name: "a"}, {Name: "b"}}
+
+	var buf bytes.Buffer
+	w := newCSVWriter(&buf, columns)
+	w.begin()
+	w.write([]interface{}{"=cmd", 1})
+	w.write([]interface{}{time.Time{}, "x,y"})
+	w.end()
+	if want := "a,b\n'=cmd,1\n,\"x,y\"\n"; buf.String() != want {
+		t.Errorf("CSV 输出不正确: %q", buf.String())
+	}
+
+	buf.Reset()
+	j := newJSONWriter(&buf, columns)
+	j.begin()
+	j.end()
+	if buf.String() != "[]\n" {
+		t.Errorf("空 JSON 输出不正确: %q", buf.String())
+	}
+
+	buf.Reset()
+	j = newJSONWriter(&buf, columns)
+	j.begin()
+	j.write([]interface{}{"x", 1})
+	j.write([]interface{}{"y", 2})
+	j.end()
+	if want := "[{\"a\":\"x\",\"b\":1},\n{\"a\":\"y\",\"b\":2}]\n"; buf.String() != want {
+		t.Errorf("JSON 输出不正确: %q", buf.String())
+	}
+}
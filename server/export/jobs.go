@@ -0,0 +1,243 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/objstore"
+	"github.com/senma231/p3/server/store"
+)
+
+// 导出任务状态
+const (
+	JobPending   = "pending"
+	JobRunning   = "running"
+	JobCompleted = "completed"
+	JobFailed    = "failed"
+)
+
+// jobTTL 导出任务及文件的保留时间
+const jobTTL = 24 * time.Hour
+
+// KeyPrefix 导出文件在对象存储中的键前缀
+const KeyPrefix = "exports/"
+
+// Job 异步导出任务
+type Job struct {
+	ID          string    `json:"id"`
+	UserID      uint      `json:"-"`
+	Resource    string    `json:"resource"`
+	Format      string    `json:"format"`
+	Columns     []string  `json:"columns,omitempty"`
+	Status      string    `json:"status"`
+	Error       string    `json:"error,omitempty"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"createdAt"`
+	CompletedAt time.Time `json:"completedAt,omitempty"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+
+	key string
+}
+
+// JobManager 异步导出任务管理器
+type JobManager struct {
+	store     objstore.Store
+	urlExpiry time.Duration
+	jobs      map[string]*Job
+	mutex     sync.RWMutex
+	stopCh    chan struct{}
+}
+
+// NewJobManager 创建异步导出任务管理器，导出文件保存在对象存储中，通过有效期为 urlExpiry 的签名链接下载
+func NewJobManager(objects objstore.Store, urlExpiry time.Duration) *JobManager {
+	return &JobManager{
+		store:     objects,
+		urlExpiry: urlExpiry,
+		jobs:      make(map[string]*Job),
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// Start 启动过期任务清理
+func (m *JobManager) Start() {
+	go m.cleanupLoop()
+}
+
+// Stop 停止过期任务清理
+func (m *JobManager) Stop() {
+	close(m.stopCh)
+}
+
+// Submit 提交异步导出任务，导出数据从 exports 读取，调用方应传入限定为用户所属租户的仓库
+func (m *JobManager) Submit(exports store.ExportRepo, userID uint, opts *Options) (*Job, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	job := &Job{
+		ID:        id,
+		UserID:    userID,
+		Resource:  opts.Resource,
+		Format:    opts.Format,
+		Columns:   opts.Columns,
+		Status:    JobPending,
+		CreatedAt: now,
+		ExpiresAt: now.Add(jobTTL),
+		key:       KeyPrefix + id + "." + opts.Format,
+	}
+
+	m.mutex.Lock()
+	m.jobs[id] = job
+	m.mutex.Unlock()
+
+	go m.run(exports, job, opts)
+
+	return m.snapshot(job), nil
+}
+
+// GetJob 获取用户的导出任务
+func (m *JobManager) GetJob(userID uint, id string) (*Job, bool) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	job, exists := m.jobs[id]
+	if !exists || job.UserID != userID {
+		return nil, false
+	}
+	copied := *job
+	return &copied, true
+}
+
+// DownloadURL 获取已完成导出任务的签名下载链接，filename 为保存时使用的文件名
+func (m *JobManager) DownloadURL(job *Job, filename string) (string, error) {
+	return m.store.SignedURL(job.key, filename, m.urlExpiry)
+}
+
+// run 执行导出任务
+func (m *JobManager) run(exports store.ExportRepo, job *Job, opts *Options) {
+	m.setStatus(job, JobRunning, nil, 0)
+
+	size, err := m.writeFile(exports, job, opts)
+	if err != nil {
+		logger.Error("导出任务 %s 失败: %v", job.ID, err)
+		m.setStatus(job, JobFailed, err, 0)
+		return
+	}
+
+	m.setStatus(job, JobCompleted, nil, size)
+}
+
+// writeFile 将导出结果写入临时文件，完成后上传到对象存储
+func (m *JobManager) writeFile(exports store.ExportRepo, job *Job, opts *Options) (int64, error) {
+	file, err := os.CreateTemp("", "p3-export-*")
+	if err != nil {
+		return 0, fmt.Errorf("创建导出文件失败: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	w := bufio.NewWriter(file)
+	if err := Export(w, exports, job.UserID, opts); err != nil {
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		return 0, fmt.Errorf("写入导出文件失败: %w", err)
+	}
+
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, fmt.Errorf("读取导出文件失败: %w", err)
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("读取导出文件失败: %w", err)
+	}
+	if err := m.store.Put(context.Background(), job.key, file, size, ContentType(job.Format)); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
+// setStatus 更新任务状态
+func (m *JobManager) setStatus(job *Job, status string, err error, size int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job.Status = status
+	job.Size = size
+	if err != nil {
+		job.Error = err.Error()
+	}
+	if status == JobCompleted || status == JobFailed {
+		job.CompletedAt = time.Now()
+	}
+}
+
+// snapshot 获取任务的副本
+func (m *JobManager) snapshot(job *Job) *Job {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	copied := *job
+	return &copied
+}
+
+// cleanupLoop 定期清理过期任务
+func (m *JobManager) cleanupLoop() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case now := <-ticker.C:
+			m.cleanup(now)
+		}
+	}
+}
+
+// cleanup 清理过期任务及其文件。服务重启后遗留的文件由对象存储的保留时间清理
+func (m *JobManager) cleanup(now time.Time) {
+	m.mutex.Lock()
+	var expired []*Job
+	for id, job := range m.jobs {
+		if now.Before(job.ExpiresAt) || job.Status == JobRunning {
+			continue
+		}
+		expired = append(expired, job)
+		delete(m.jobs, id)
+	}
+	m.mutex.Unlock()
+
+	for _, job := range expired {
+		if job.Status != JobCompleted {
+			continue
+		}
+		if err := m.store.Delete(context.Background(), job.key); err != nil {
+			logger.Warn("删除导出文件 %s 失败: %v", job.key, err)
+		}
+	}
+}
+
+// newJobID 生成任务 ID
+func newJobID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成任务 ID 失败: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,353 @@
+package fleet
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/db"
+)
+
+// 批量操作
+const (
+	ActionRestart     = "restart"       // 重启客户端服务
+	ActionPushConfig  = "push-config"   // 立即同步应用配置
+	ActionSetLogLevel = "set-log-level" // 修改日志级别，参数 level，指定 module 时只修改该模块，此时 level 为空表示清除该模块的级别
+	ActionNATDetect   = "nat-detect"    // 重新检测 NAT 类型
+)
+
+// 任务状态
+const (
+	JobRunning   = "running"
+	JobCompleted = "completed"
+)
+
+// 单个设备的执行状态
+const (
+	TargetPending   = "pending"   // 等待下发
+	TargetSent      = "sent"      // 已下发，等待设备回报结果
+	TargetSucceeded = "succeeded" // 执行成功
+	TargetFailed    = "failed"    // 下发失败或执行失败
+	TargetTimeout   = "timeout"   // 超时未回报结果
+)
+
+const (
+	// maxTargets 单个任务的最大设备数
+	maxTargets = 1000
+	// dispatchConcurrency 同时下发的设备数
+	dispatchConcurrency = 20
+	// ackTimeout 下发后等待设备回报结果的时间
+	ackTimeout = 2 * time.Minute
+	// jobTTL 已完成任务的保留时间
+	jobTTL = 24 * time.Hour
+)
+
+// logLevels 支持的日志级别
+var logLevels = map[string]bool{
+	"debug": true,
+	"info":  true,
+	"warn":  true,
+	"error": true,
+}
+
+// Command 下发给设备的指令
+type Command struct {
+	JobID  string            `json:"jobId"`
+	Action string            `json:"action"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// SendFunc 向节点下发指令，节点离线时返回错误
+type SendFunc func(nodeID string, cmd *Command) error
+
+// Target 单个设备的执行结果
+type Target struct {
+	DeviceID  uint      `json:"deviceId"`
+	NodeID    string    `json:"nodeId"`
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// Job 批量操作任务
+type Job struct {
+	ID         string            `json:"id"`
+	UserID     uint              `json:"-"`
+	Action     string            `json:"action"`
+	Params     map[string]string `json:"params,omitempty"`
+	Status     string            `json:"status"`
+	Summary    map[string]int    `json:"summary"`
+	Targets    []Target          `json:"targets"`
+	CreatedAt  time.Time         `json:"createdAt"`
+	FinishedAt time.Time         `json:"finishedAt,omitempty"`
+}
+
+// Manager 批量操作任务管理器。
+// 指令通过信令连接异步下发，设备执行后通过 HTTP 回报结果，超时未回报的设备标记为超时
+type Manager struct {
+	send   SendFunc
+	now    func() time.Time
+	jobs   map[string]*Job
+	mutex  sync.Mutex
+	stopCh chan struct{}
+}
+
+// NewManager 创建批量操作任务管理器
+func NewManager(send SendFunc) *Manager {
+	return &Manager{
+		send:   send,
+		now:    time.Now,
+		jobs:   make(map[string]*Job),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start 启动超时检查和过期任务清理
+func (m *Manager) Start() {
+	go m.loop()
+}
+
+// Stop 停止超时检查和过期任务清理
+func (m *Manager) Stop() {
+	close(m.stopCh)
+}
+
+// ValidateCommand 检查操作和参数是否有效
+func ValidateCommand(action string, params map[string]string) error {
+	switch action {
+	case ActionRestart, ActionPushConfig, ActionNATDetect:
+		return nil
+	case ActionSetLogLevel:
+		if module := params["module"]; module != "" {
+			if params["level"] == "" {
+				return nil
+			}
+			if _, err := logger.ParseModuleLevels(map[string]string{module: params["level"]}); err != nil {
+				return errors.InvalidParam(err.Error())
+			}
+			return nil
+		}
+		if !logLevels[params["level"]] {
+			return errors.InvalidParam("无效的日志级别")
+		}
+		return nil
+	default:
+		return errors.InvalidParam("不支持的操作")
+	}
+}
+
+// Submit 提交批量操作任务，立即返回，指令在后台下发
+func (m *Manager) Submit(userID uint, action string, params map[string]string, devices []db.Device) (*Job, error) {
+	if err := ValidateCommand(action, params); err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return nil, errors.InvalidParam("请选择设备")
+	}
+	if len(devices) > maxTargets {
+		return nil, errors.InvalidParam(fmt.Sprintf("单次最多操作 %d 台设备", maxTargets))
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, errors.Internal(err.Error())
+	}
+
+	now := m.now()
+	job := &Job{
+		ID:        id,
+		UserID:    userID,
+		Action:    action,
+		Params:    params,
+		Status:    JobRunning,
+		Targets:   make([]Target, 0, len(devices)),
+		CreatedAt: now,
+	}
+	for _, device := range devices {
+		job.Targets = append(job.Targets, Target{
+			DeviceID:  device.ID,
+			NodeID:    device.NodeID,
+			Name:      device.Name,
+			Status:    TargetPending,
+			UpdatedAt: now,
+		})
+	}
+
+	m.mutex.Lock()
+	m.jobs[id] = job
+	snapshot := m.snapshotLocked(job)
+	m.mutex.Unlock()
+
+	go m.dispatch(job)
+
+	return snapshot, nil
+}
+
+// dispatch 并发下发指令
+func (m *Manager) dispatch(job *Job) {
+	cmd := &Command{JobID: job.ID, Action: job.Action, Params: job.Params}
+
+	sem := make(chan struct{}, dispatchConcurrency)
+	var wg sync.WaitGroup
+	for i := range job.Targets {
+		nodeID := job.Targets[i].NodeID
+
+		sem <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			err := m.send(nodeID, cmd)
+
+			m.mutex.Lock()
+			defer m.mutex.Unlock()
+			target := &job.Targets[i]
+			if target.Status != TargetPending {
+				// 发送期间设备已回报结果
+				return
+			}
+			if err != nil {
+				logger.Warn("向节点 %s 下发批量操作 %s 失败: %v", nodeID, job.ID, err)
+				m.setTargetLocked(job, target, TargetFailed, fmt.Sprintf("下发失败: %v", err))
+				return
+			}
+			m.setTargetLocked(job, target, TargetSent, "")
+		}(i)
+	}
+	wg.Wait()
+}
+
+// ReportResult 记录设备回报的执行结果
+func (m *Manager) ReportResult(deviceID uint, jobID string, success bool, message string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, exists := m.jobs[jobID]
+	if !exists {
+		return errors.NotFound("任务不存在")
+	}
+	for i := range job.Targets {
+		target := &job.Targets[i]
+		if target.DeviceID != deviceID {
+			continue
+		}
+		if target.Status != TargetPending && target.Status != TargetSent {
+			return errors.Conflict("已记录执行结果")
+		}
+		if success {
+			m.setTargetLocked(job, target, TargetSucceeded, "")
+		} else {
+			m.setTargetLocked(job, target, TargetFailed, message)
+		}
+		return nil
+	}
+	return errors.NotFound("任务不存在")
+}
+
+// GetJob 获取用户的批量操作任务
+func (m *Manager) GetJob(userID uint, id string) (*Job, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	job, exists := m.jobs[id]
+	if !exists || job.UserID != userID {
+		return nil, false
+	}
+	return m.snapshotLocked(job), true
+}
+
+// ListJobs 获取用户的批量操作任务，按创建时间倒序
+func (m *Manager) ListJobs(userID uint) []*Job {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	jobs := make([]*Job, 0)
+	for _, job := range m.jobs {
+		if job.UserID == userID {
+			jobs = append(jobs, m.snapshotLocked(job))
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// setTargetLocked 更新设备的执行状态，所有设备都有结果时任务完成。调用方需持有锁
+func (m *Manager) setTargetLocked(job *Job, target *Target, status, message string) {
+	now := m.now()
+	target.Status = status
+	target.Error = message
+	target.UpdatedAt = now
+
+	for _, t := range job.Targets {
+		if t.Status == TargetPending || t.Status == TargetSent {
+			return
+		}
+	}
+	job.Status = JobCompleted
+	job.FinishedAt = now
+}
+
+// snapshotLocked 获取任务的副本并统计各状态的设备数。调用方需持有锁
+func (m *Manager) snapshotLocked(job *Job) *Job {
+	copied := *job
+	copied.Targets = append([]Target(nil), job.Targets...)
+	copied.Summary = make(map[string]int)
+	for _, target := range job.Targets {
+		copied.Summary[target.Status]++
+	}
+	return &copied
+}
+
+// loop 定期检查超时和清理过期任务
+func (m *Manager) loop() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+			m.expire(m.now())
+		}
+	}
+}
+
+// expire 将超时未回报的设备标记为超时，并清理过期任务
+func (m *Manager) expire(now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for id, job := range m.jobs {
+		if job.Status == JobCompleted {
+			if now.Sub(job.FinishedAt) > jobTTL {
+				delete(m.jobs, id)
+			}
+			continue
+		}
+		for i := range job.Targets {
+			target := &job.Targets[i]
+			if target.Status == TargetSent && now.Sub(target.UpdatedAt) > ackTimeout {
+				m.setTargetLocked(job, target, TargetTimeout, "设备未在规定时间内回报结果")
+			}
+		}
+	}
+}
+
+// newJobID 生成任务 ID
+func newJobID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成任务 ID 失败: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,99 @@
+package fleet
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+)
+
+// waitDispatched 等待所有设备下发完成
+func waitDispatched(t *testing.T, m *Manager, userID uint, id string) *Job {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		job, ok := m.GetJob(userID, id)
+		if !ok {
+			t.Fatalf("任务不存在")
+		}
+		if job.Summary[TargetPending] == 0 {
+			return job
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("下发未完成")
+	return nil
+}
+
+func TestManager(t *testing.T) {
+	var mu sync.Mutex
+	sent := make(map[string]*Command)
+	m := NewManager(func(nodeID string, cmd *Command) error {
+		if nodeID == "offline" {
+			return fmt.Errorf("节点不在线")
+		}
+		mu.Lock()
+		sent[nodeID] = cmd
+		mu.Unlock()
+		return nil
+	})
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	if _, err := m.Submit(1, ActionSetLogLevel, map[string]string{"level": "verbose"}, []db.Device{{NodeID: "a"}}); err == nil {
+		t.Fatalf("无效的日志级别应返回错误")
+	}
+	if _, err := m.Submit(1, "reboot", nil, []db.Device{{NodeID: "a"}}); err == nil {
+		t.Fatalf("不支持的操作应返回错误")
+	}
+
+	var devices []db.Device
+	for i, nodeID := range []string{"a", "b", "c", "offline"} {
+		device := db.Device{NodeID: nodeID}
+		device.ID = uint(i + 1)
+		devices = append(devices, device)
+	}
+	submitted, err := m.Submit(1, ActionSetLogLevel, map[string]string{"level": "debug"}, devices)
+	if err != nil {
+		t.Fatalf("提交任务失败: %v", err)
+	}
+	if _, ok := m.GetJob(2, submitted.ID); ok {
+		t.Fatalf("其他用户不应看到任务")
+	}
+
+	job := waitDispatched(t, m, 1, submitted.ID)
+	if job.Summary[TargetSent] != 3 || job.Summary[TargetFailed] != 1 {
+		t.Fatalf("下发结果不正确: %v", job.Summary)
+	}
+	if cmd := sent["a"]; cmd == nil || cmd.JobID != submitted.ID || cmd.Params["level"] != "debug" {
+		t.Fatalf("下发的指令不正确: %+v", cmd)
+	}
+
+	if err := m.ReportResult(1, submitted.ID, true, ""); err != nil {
+		t.Fatalf("回报结果失败: %v", err)
+	}
+	if err := m.ReportResult(1, submitted.ID, true, ""); err == nil {
+		t.Fatalf("重复回报应返回错误")
+	}
+	if err := m.ReportResult(5, submitted.ID, true, ""); err == nil {
+		t.Fatalf("不属于任务的设备回报应返回错误")
+	}
+	if err := m.ReportResult(2, submitted.ID, false, "权限不足"); err != nil {
+		t.Fatalf("回报结果失败: %v", err)
+	}
+
+	// 超时未回报的设备标记为超时，任务完成
+	now = now.Add(ackTimeout + time.Second)
+	m.expire(now)
+	job, _ = m.GetJob(1, submitted.ID)
+	if job.Status != JobCompleted || job.Summary[TargetSucceeded] != 1 || job.Summary[TargetFailed] != 2 || job.Summary[TargetTimeout] != 1 {
+		t.Fatalf("任务结果不正确: %s %v", job.Status, job.Summary)
+	}
+
+	// 过期后清理
+	m.expire(now.Add(jobTTL + time.Second))
+	if jobs := m.ListJobs(1); len(jobs) != 0 {
+		t.Fatalf("过期任务应被清理: %d", len(jobs))
+	}
+}
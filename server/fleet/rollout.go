@@ -0,0 +1,506 @@
+package fleet
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/db"
+)
+
+// 灰度发布状态
+const (
+	RolloutRunning     = "running"      // 正在执行或等待下一阶段
+	RolloutHalted      = "halted"       // 失败率超过阈值或手动暂停
+	RolloutCompleted   = "completed"    // 所有阶段执行完成
+	RolloutRollingBack = "rolling-back" // 正在回滚
+	RolloutRolledBack  = "rolled-back"  // 回滚完成
+)
+
+// 阶段状态
+const (
+	StagePending = "pending"
+	StageRunning = "running"
+	StagePassed  = "passed"
+	StageFailed  = "failed"
+)
+
+const (
+	// defaultMaxFailureRate 默认的失败率阈值（百分比）
+	defaultMaxFailureRate = 10
+	// maxStages 最大阶段数
+	maxStages = 10
+	// rolloutTTL 结束的灰度发布的保留时间
+	rolloutTTL = 7 * 24 * time.Hour
+)
+
+// Step 灰度发布执行的操作
+type Step struct {
+	Action string            `json:"action" binding:"required,max=50"`
+	Params map[string]string `json:"params,omitempty"`
+}
+
+// StageSpec 阶段定义。指定设备时为命名分组，否则按累计百分比从剩余设备中选取
+type StageSpec struct {
+	Name      string `json:"name" binding:"max=50,safetext" sanitize:"text"`
+	Percent   int    `json:"percent" binding:"min=0,max=100"`
+	DeviceIDs []uint `json:"deviceIds"`
+}
+
+// RolloutSpec 灰度发布定义
+type RolloutSpec struct {
+	Step
+	// 回滚时对已执行的设备下发的操作，为空时不支持回滚
+	Rollback *Step       `json:"rollback,omitempty"`
+	Stages   []StageSpec `json:"stages" binding:"required,min=1,max=10,dive"`
+	// 阶段的失败率（失败和超时设备的百分比）超过阈值时暂停，0 使用默认值 10
+	MaxFailureRate int `json:"maxFailureRate" binding:"min=0,max=100"`
+	// 阶段完成后等待多少秒再进入下一阶段，用于观察设备运行情况
+	BakeTime int `json:"bakeTime" binding:"min=0,max=86400"`
+	// 暂停时自动回滚
+	AutoRollback bool `json:"autoRollback"`
+}
+
+// Stage 灰度发布阶段
+type Stage struct {
+	Name       string `json:"name"`
+	DeviceIDs  []uint `json:"deviceIds"`
+	Status     string `json:"status"`
+	JobID      string `json:"jobId,omitempty"`
+	Failed     int    `json:"failed"`
+	Succeeded  int    `json:"succeeded"`
+	Overridden bool   `json:"overridden,omitempty"` // 手动恢复后不再按失败率暂停
+}
+
+// Rollout 灰度发布
+type Rollout struct {
+	ID             string            `json:"id"`
+	UserID         uint              `json:"-"`
+	Action         string            `json:"action"`
+	Params         map[string]string `json:"params,omitempty"`
+	Rollback       *Step             `json:"rollback,omitempty"`
+	Status         string            `json:"status"`
+	Reason         string            `json:"reason,omitempty"`
+	Stages         []Stage           `json:"stages"`
+	CurrentStage   int               `json:"currentStage"`
+	MaxFailureRate int               `json:"maxFailureRate"`
+	BakeTime       int               `json:"bakeTime"`
+	AutoRollback   bool              `json:"autoRollback"`
+	RollbackJobID  string            `json:"rollbackJobId,omitempty"`
+	NextStageAt    time.Time         `json:"nextStageAt,omitempty"`
+	CreatedAt      time.Time         `json:"createdAt"`
+	UpdatedAt      time.Time         `json:"updatedAt"`
+
+	devices map[uint]db.Device
+}
+
+// RolloutManager 灰度发布控制器。
+// 按阶段依次向设备下发操作，每个阶段的失败率超过阈值时暂停，可以手动恢复或回滚已执行的设备
+type RolloutManager struct {
+	jobs     *Manager
+	now      func() time.Time
+	rollouts map[string]*Rollout
+	mutex    sync.Mutex
+	stopCh   chan struct{}
+}
+
+// NewRolloutManager 创建灰度发布控制器，各阶段通过 jobs 下发
+func NewRolloutManager(jobs *Manager) *RolloutManager {
+	return &RolloutManager{
+		jobs:     jobs,
+		now:      time.Now,
+		rollouts: make(map[string]*Rollout),
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start 启动灰度发布控制循环
+func (m *RolloutManager) Start() {
+	go m.loop()
+}
+
+// Stop 停止灰度发布控制循环
+func (m *RolloutManager) Stop() {
+	close(m.stopCh)
+}
+
+// Create 创建灰度发布并立即执行第一个阶段，devices 为参与发布的全部设备，各阶段的命名分组必须包含在内
+func (m *RolloutManager) Create(userID uint, spec *RolloutSpec, devices []db.Device) (*Rollout, error) {
+	if err := ValidateCommand(spec.Action, spec.Params); err != nil {
+		return nil, err
+	}
+	if spec.Rollback != nil {
+		if err := ValidateCommand(spec.Rollback.Action, spec.Rollback.Params); err != nil {
+			return nil, err
+		}
+	}
+	if len(devices) == 0 {
+		return nil, errors.InvalidParam("请选择设备")
+	}
+	if len(devices) > maxTargets {
+		return nil, errors.InvalidParam(fmt.Sprintf("单次最多操作 %d 台设备", maxTargets))
+	}
+	if len(spec.Stages) == 0 || len(spec.Stages) > maxStages {
+		return nil, errors.InvalidParam(fmt.Sprintf("阶段数必须在 1 到 %d 之间", maxStages))
+	}
+
+	stages, err := planStages(spec.Stages, devices)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newJobID()
+	if err != nil {
+		return nil, errors.Internal(err.Error())
+	}
+
+	maxFailureRate := spec.MaxFailureRate
+	if maxFailureRate == 0 {
+		maxFailureRate = defaultMaxFailureRate
+	}
+
+	now := m.now()
+	rollout := &Rollout{
+		ID:             id,
+		UserID:         userID,
+		Action:         spec.Action,
+		Params:         spec.Params,
+		Rollback:       spec.Rollback,
+		Status:         RolloutRunning,
+		Stages:         stages,
+		MaxFailureRate: maxFailureRate,
+		BakeTime:       spec.BakeTime,
+		AutoRollback:   spec.AutoRollback && spec.Rollback != nil,
+		NextStageAt:    now,
+		CreatedAt:      now,
+		UpdatedAt:      now,
+		devices:        make(map[uint]db.Device, len(devices)),
+	}
+	for _, device := range devices {
+		rollout.devices[device.ID] = device
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.rollouts[id] = rollout
+	m.advanceLocked(rollout, now)
+	return snapshotRollout(rollout), nil
+}
+
+// planStages 将阶段定义展开为各阶段的设备。
+// 命名分组直接使用指定的设备，百分比阶段从未指定分组的设备中按 ID 顺序选取，
+// 使包括之前各阶段在内的累计设备数达到总数的百分比；最后剩余的设备归入最终阶段
+func planStages(specs []StageSpec, devices []db.Device) ([]Stage, error) {
+	known := make(map[uint]bool, len(devices))
+	for _, device := range devices {
+		known[device.ID] = true
+	}
+
+	assigned := make(map[uint]bool, len(devices))
+	for _, spec := range specs {
+		for _, id := range spec.DeviceIDs {
+			if !known[id] {
+				return nil, errors.InvalidParam(fmt.Sprintf("阶段 %s 的设备 %d 不在发布范围内", spec.Name, id))
+			}
+			if assigned[id] {
+				return nil, errors.InvalidParam(fmt.Sprintf("设备 %d 属于多个阶段", id))
+			}
+			assigned[id] = true
+		}
+	}
+
+	var remaining []uint
+	for _, device := range devices {
+		if !assigned[device.ID] {
+			remaining = append(remaining, device.ID)
+		}
+	}
+	sort.Slice(remaining, func(i, j int) bool { return remaining[i] < remaining[j] })
+
+	stages := make([]Stage, 0, len(specs)+1)
+	done := 0
+	for i, spec := range specs {
+		name := spec.Name
+		if name == "" {
+			name = fmt.Sprintf("阶段 %d", i+1)
+		}
+		stage := Stage{Name: name, Status: StagePending}
+		if len(spec.DeviceIDs) > 0 {
+			stage.DeviceIDs = append([]uint(nil), spec.DeviceIDs...)
+			done += len(spec.DeviceIDs)
+		} else {
+			if spec.Percent <= 0 {
+				return nil, errors.InvalidParam(fmt.Sprintf("阶段 %s 需要指定设备或百分比", name))
+			}
+			target := (len(devices)*spec.Percent + 99) / 100
+			for done < target && len(remaining) > 0 {
+				stage.DeviceIDs = append(stage.DeviceIDs, remaining[0])
+				remaining = remaining[1:]
+				done++
+			}
+		}
+		if len(stage.DeviceIDs) == 0 {
+			continue
+		}
+		stages = append(stages, stage)
+	}
+	if len(remaining) > 0 {
+		stages = append(stages, Stage{Name: "其余设备", DeviceIDs: remaining, Status: StagePending})
+	}
+	return stages, nil
+}
+
+// GetRollout 获取用户的灰度发布
+func (m *RolloutManager) GetRollout(userID uint, id string) (*Rollout, bool) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	rollout, exists := m.rollouts[id]
+	if !exists || rollout.UserID != userID {
+		return nil, false
+	}
+	return snapshotRollout(rollout), true
+}
+
+// ListRollouts 获取用户的灰度发布，按创建时间倒序
+func (m *RolloutManager) ListRollouts(userID uint) []*Rollout {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	rollouts := make([]*Rollout, 0)
+	for _, rollout := range m.rollouts {
+		if rollout.UserID == userID {
+			rollouts = append(rollouts, snapshotRollout(rollout))
+		}
+	}
+	sort.Slice(rollouts, func(i, j int) bool {
+		return rollouts[i].CreatedAt.After(rollouts[j].CreatedAt)
+	})
+	return rollouts
+}
+
+// Halt 手动暂停灰度发布，正在执行的阶段不受影响，不再进入下一阶段
+func (m *RolloutManager) Halt(userID uint, id string) (*Rollout, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	rollout, err := m.getLocked(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if rollout.Status != RolloutRunning {
+		return nil, errors.Conflict("灰度发布未在执行")
+	}
+	m.haltLocked(rollout, "手动暂停")
+	return snapshotRollout(rollout), nil
+}
+
+// Resume 恢复暂停的灰度发布，当前阶段不再按失败率暂停
+func (m *RolloutManager) Resume(userID uint, id string) (*Rollout, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	rollout, err := m.getLocked(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if rollout.Status != RolloutHalted {
+		return nil, errors.Conflict("灰度发布未暂停")
+	}
+
+	now := m.now()
+	stage := &rollout.Stages[rollout.CurrentStage]
+	stage.Overridden = true
+	if stage.JobID != "" {
+		stage.Status = StageRunning
+	}
+	rollout.Status = RolloutRunning
+	rollout.Reason = ""
+	rollout.UpdatedAt = now
+	m.advanceLocked(rollout, now)
+	return snapshotRollout(rollout), nil
+}
+
+// StartRollback 对已下发过的设备执行回滚操作
+func (m *RolloutManager) StartRollback(userID uint, id string) (*Rollout, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	rollout, err := m.getLocked(userID, id)
+	if err != nil {
+		return nil, err
+	}
+	if rollout.Rollback == nil {
+		return nil, errors.InvalidParam("灰度发布未定义回滚操作")
+	}
+	if rollout.Status != RolloutHalted && rollout.Status != RolloutRunning && rollout.Status != RolloutCompleted {
+		return nil, errors.Conflict("灰度发布已回滚")
+	}
+	if err := m.rollbackLocked(rollout); err != nil {
+		return nil, err
+	}
+	return snapshotRollout(rollout), nil
+}
+
+// getLocked 获取用户的灰度发布。调用方需持有锁
+func (m *RolloutManager) getLocked(userID uint, id string) (*Rollout, error) {
+	rollout, exists := m.rollouts[id]
+	if !exists || rollout.UserID != userID {
+		return nil, errors.NotFound("灰度发布不存在")
+	}
+	return rollout, nil
+}
+
+// loop 定期推进灰度发布
+func (m *RolloutManager) loop() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+			m.tick(m.now())
+		}
+	}
+}
+
+// tick 推进所有灰度发布并清理过期记录
+func (m *RolloutManager) tick(now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for id, rollout := range m.rollouts {
+		switch rollout.Status {
+		case RolloutRunning:
+			m.advanceLocked(rollout, now)
+		case RolloutRollingBack:
+			if job, ok := m.jobs.GetJob(rollout.UserID, rollout.RollbackJobID); !ok || job.Status == JobCompleted {
+				rollout.Status = RolloutRolledBack
+				rollout.UpdatedAt = now
+			}
+		case RolloutCompleted, RolloutRolledBack:
+			if now.Sub(rollout.UpdatedAt) > rolloutTTL {
+				delete(m.rollouts, id)
+			}
+		}
+	}
+}
+
+// advanceLocked 检查当前阶段的执行结果，通过后在观察期结束时执行下一阶段。调用方需持有锁
+func (m *RolloutManager) advanceLocked(rollout *Rollout, now time.Time) {
+	stage := &rollout.Stages[rollout.CurrentStage]
+
+	if stage.JobID == "" {
+		if now.Before(rollout.NextStageAt) {
+			return
+		}
+		job, err := m.jobs.Submit(rollout.UserID, rollout.Action, rollout.Params, rollout.stageDevices(stage))
+		if err != nil {
+			m.haltLocked(rollout, fmt.Sprintf("下发阶段 %s 失败: %v", stage.Name, err))
+			return
+		}
+		logger.Info("灰度发布 %s 开始阶段 %s，设备数 %d", rollout.ID, stage.Name, len(stage.DeviceIDs))
+		stage.JobID = job.ID
+		stage.Status = StageRunning
+		rollout.UpdatedAt = now
+		return
+	}
+
+	job, ok := m.jobs.GetJob(rollout.UserID, stage.JobID)
+	if !ok {
+		m.haltLocked(rollout, fmt.Sprintf("阶段 %s 的任务已过期", stage.Name))
+		return
+	}
+	stage.Failed = job.Summary[TargetFailed] + job.Summary[TargetTimeout]
+	stage.Succeeded = job.Summary[TargetSucceeded]
+
+	// 失败设备数已超过阈值时不必等待其余设备
+	if !stage.Overridden && stage.Failed*100 > rollout.MaxFailureRate*len(stage.DeviceIDs) {
+		stage.Status = StageFailed
+		m.haltLocked(rollout, fmt.Sprintf("阶段 %s 失败率超过 %d%%", stage.Name, rollout.MaxFailureRate))
+		return
+	}
+	if job.Status != JobCompleted {
+		return
+	}
+
+	stage.Status = StagePassed
+	rollout.UpdatedAt = now
+	if rollout.CurrentStage == len(rollout.Stages)-1 {
+		rollout.Status = RolloutCompleted
+		logger.Info("灰度发布 %s 已完成", rollout.ID)
+		return
+	}
+	rollout.CurrentStage++
+	rollout.NextStageAt = now.Add(time.Duration(rollout.BakeTime) * time.Second)
+	if rollout.BakeTime == 0 {
+		m.advanceLocked(rollout, now)
+	}
+}
+
+// haltLocked 暂停灰度发布，配置了自动回滚时立即回滚。调用方需持有锁
+func (m *RolloutManager) haltLocked(rollout *Rollout, reason string) {
+	logger.Warn("灰度发布 %s 已暂停: %s", rollout.ID, reason)
+	rollout.Status = RolloutHalted
+	rollout.Reason = reason
+	rollout.UpdatedAt = m.now()
+
+	if rollout.AutoRollback {
+		if err := m.rollbackLocked(rollout); err != nil {
+			logger.Error("灰度发布 %s 自动回滚失败: %v", rollout.ID, err)
+		}
+	}
+}
+
+// rollbackLocked 对已开始的阶段的设备下发回滚操作。调用方需持有锁
+func (m *RolloutManager) rollbackLocked(rollout *Rollout) error {
+	var devices []db.Device
+	for i := range rollout.Stages {
+		stage := &rollout.Stages[i]
+		if stage.JobID != "" {
+			devices = append(devices, rollout.stageDevices(stage)...)
+		}
+	}
+
+	now := m.now()
+	rollout.UpdatedAt = now
+	if len(devices) == 0 {
+		rollout.Status = RolloutRolledBack
+		return nil
+	}
+
+	job, err := m.jobs.Submit(rollout.UserID, rollout.Rollback.Action, rollout.Rollback.Params, devices)
+	if err != nil {
+		return err
+	}
+	logger.Info("灰度发布 %s 开始回滚，设备数 %d", rollout.ID, len(devices))
+	rollout.Status = RolloutRollingBack
+	rollout.RollbackJobID = job.ID
+	return nil
+}
+
+// stageDevices 获取阶段的设备
+func (r *Rollout) stageDevices(stage *Stage) []db.Device {
+	devices := make([]db.Device, 0, len(stage.DeviceIDs))
+	for _, id := range stage.DeviceIDs {
+		devices = append(devices, r.devices[id])
+	}
+	return devices
+}
+
+// snapshotRollout 获取灰度发布的副本
+func snapshotRollout(rollout *Rollout) *Rollout {
+	copied := *rollout
+	copied.Stages = make([]Stage, len(rollout.Stages))
+	for i, stage := range rollout.Stages {
+		stage.DeviceIDs = append([]uint(nil), stage.DeviceIDs...)
+		copied.Stages[i] = stage
+	}
+	return &copied
+}
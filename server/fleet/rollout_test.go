@@ -0,0 +1,124 @@
+package fleet
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+)
+
+func TestPlanStages(t *testing.T) {
+	var devices []db.Device
+	for i := 1; i <= 10; i++ {
+		device := db.Device{}
+		device.ID = uint(i)
+		devices = append(devices, device)
+	}
+
+	stages, err := planStages([]StageSpec{
+		{Name: "canary", DeviceIDs: []uint{10}},
+		{Percent: 30},
+		{Percent: 60},
+	}, devices)
+	if err != nil {
+		t.Fatalf("规划阶段失败: %v", err)
+	}
+
+	// 分组 1 台，累计 30% 再取 2 台，累计 60% 再取 3 台，其余 4 台归入最终阶段
+	want := []int{1, 2, 3, 4}
+	if len(stages) != len(want) {
+		t.Fatalf("阶段数应为 %d，实际 %d", len(want), len(stages))
+	}
+	for i, n := range want {
+		if len(stages[i].DeviceIDs) != n {
+			t.Fatalf("阶段 %d 的设备数应为 %d，实际 %v", i, n, stages[i].DeviceIDs)
+		}
+	}
+	if stages[0].Name != "canary" || stages[1].DeviceIDs[0] != 1 {
+		t.Fatalf("阶段设备不正确: %+v", stages)
+	}
+
+	if _, err := planStages([]StageSpec{{DeviceIDs: []uint{11}}}, devices); err == nil {
+		t.Fatalf("不在发布范围内的设备应返回错误")
+	}
+	if _, err := planStages([]StageSpec{{Name: "empty"}}, devices); err == nil {
+		t.Fatalf("未指定设备和百分比应返回错误")
+	}
+}
+
+func TestRolloutHaltAndRollback(t *testing.T) {
+	var mu sync.Mutex
+	var sent []string
+	jobs := NewManager(func(nodeID string, cmd *Command) error {
+		mu.Lock()
+		sent = append(sent, cmd.Action+":"+nodeID)
+		mu.Unlock()
+		return nil
+	})
+	rollouts := NewRolloutManager(jobs)
+	now := time.Now()
+	rollouts.now = func() time.Time { return now }
+
+	var devices []db.Device
+	for _, nodeID := range []string{"a", "b", "c", "d"} {
+		device := db.Device{NodeID: nodeID}
+		device.ID = uint(len(devices) + 1)
+		devices = append(devices, device)
+	}
+
+	rollout, err := rollouts.Create(1, &RolloutSpec{
+		Step:     Step{Action: ActionSetLogLevel, Params: map[string]string{"level": "debug"}},
+		Rollback: &Step{Action: ActionSetLogLevel, Params: map[string]string{"level": "info"}},
+		Stages:   []StageSpec{{Percent: 50}},
+		BakeTime: 60,
+	}, devices)
+	if err != nil {
+		t.Fatalf("创建灰度发布失败: %v", err)
+	}
+	first := rollout.Stages[0]
+	waitDispatched(t, jobs, 1, first.JobID)
+
+	// 第一阶段全部成功，观察期结束后进入第二阶段
+	for _, id := range first.DeviceIDs {
+		if err := jobs.ReportResult(id, first.JobID, true, ""); err != nil {
+			t.Fatalf("回报结果失败: %v", err)
+		}
+	}
+	rollouts.tick(now)
+	rollout, _ = rollouts.GetRollout(1, rollout.ID)
+	if rollout.CurrentStage != 1 || rollout.Stages[1].JobID != "" {
+		t.Fatalf("观察期内不应执行下一阶段: %+v", rollout)
+	}
+	now = now.Add(61 * time.Second)
+	rollouts.tick(now)
+	rollout, _ = rollouts.GetRollout(1, rollout.ID)
+	second := rollout.Stages[1]
+	if second.JobID == "" {
+		t.Fatalf("观察期结束后应执行下一阶段")
+	}
+	waitDispatched(t, jobs, 1, second.JobID)
+
+	// 第二阶段一台失败，超过默认阈值后暂停
+	if err := jobs.ReportResult(second.DeviceIDs[0], second.JobID, false, "失败"); err != nil {
+		t.Fatalf("回报结果失败: %v", err)
+	}
+	rollouts.tick(now)
+	rollout, _ = rollouts.GetRollout(1, rollout.ID)
+	if rollout.Status != RolloutHalted || rollout.Stages[1].Status != StageFailed {
+		t.Fatalf("失败率超过阈值应暂停: %s %s", rollout.Status, rollout.Stages[1].Status)
+	}
+
+	// 回滚所有已下发的设备
+	rollout, err = rollouts.StartRollback(1, rollout.ID)
+	if err != nil || rollout.Status != RolloutRollingBack {
+		t.Fatalf("回滚失败: %+v %v", rollout, err)
+	}
+	job := waitDispatched(t, jobs, 1, rollout.RollbackJobID)
+	if len(job.Targets) != 4 || job.Params["level"] != "info" {
+		t.Fatalf("回滚任务不正确: %+v", job)
+	}
+	if _, err := rollouts.Resume(1, rollout.ID); err == nil {
+		t.Fatalf("回滚中不应恢复")
+	}
+}
@@ -1,27 +1,45 @@
 package forward
 
 import (
+	"context"
+
 	"github.com/senma231/p3/common/errors"
 	"github.com/senma231/p3/server/db"
-	"gorm.io/gorm"
+	"github.com/senma231/p3/server/store"
 )
 
 // Service 转发服务
 type Service struct {
+	st       *store.Store
+	forwards store.ForwardRepo
 }
 
 // NewService 创建转发服务
-func NewService() *Service {
-	return &Service{}
+func NewService(st *store.Store) *Service {
+	return &Service{
+		st:       st,
+		forwards: st.Forwards,
+	}
+}
+
+// ForTenant 返回只能访问 ctx 中租户数据的转发服务，其他租户的转发规则视为不存在
+func (s *Service) ForTenant(ctx context.Context) (*Service, error) {
+	scoped, err := s.st.ForContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	svc := *s
+	svc.forwards = scoped.Forwards
+	return &svc, nil
 }
 
 // ForwardRequest 转发请求
 type ForwardRequest struct {
 	Protocol    string `json:"protocol" binding:"required,oneof=tcp udp"`
 	SrcPort     int    `json:"srcPort" binding:"required,min=1,max=65535"`
-	DstHost     string `json:"dstHost" binding:"required"`
+	DstHost     string `json:"dstHost" binding:"required,max=50,safetext" sanitize:"text"`
 	DstPort     int    `json:"dstPort" binding:"required,min=1,max=65535"`
-	Description string `json:"description"`
+	Description string `json:"description" binding:"max=200,safemultiline" sanitize:"multiline"`
 	Enabled     bool   `json:"enabled"`
 }
 
@@ -29,43 +47,36 @@ type ForwardRequest struct {
 type ForwardUpdateRequest struct {
 	Protocol    string `json:"protocol" binding:"omitempty,oneof=tcp udp"`
 	SrcPort     int    `json:"srcPort" binding:"omitempty,min=1,max=65535"`
-	DstHost     string `json:"dstHost"`
+	DstHost     string `json:"dstHost" binding:"omitempty,max=50,safetext" sanitize:"text"`
 	DstPort     int    `json:"dstPort" binding:"omitempty,min=1,max=65535"`
-	Description string `json:"description"`
+	Description string `json:"description" binding:"max=200,safemultiline" sanitize:"multiline"`
 	Enabled     *bool  `json:"enabled"`
+	Revision    uint   `json:"revision"` // 为 0 时不检查修订号
 }
 
 // GetForwards 获取用户的所有转发规则
 func (s *Service) GetForwards(userID uint) ([]db.Forward, error) {
-	var forwards []db.Forward
-	if result := db.DB.Where("user_id = ?", userID).Find(&forwards); result.Error != nil {
-		return nil, errors.Database("查询转发规则失败", result.Error)
+	forwards, err := s.forwards.ListByUser(userID)
+	if err != nil {
+		return nil, errors.Database("查询转发规则失败", err)
 	}
 	return forwards, nil
 }
 
 // GetForward 获取转发规则详情
 func (s *Service) GetForward(userID uint, forwardID uint) (*db.Forward, error) {
-	var forward db.Forward
-	if result := db.DB.Where("id = ? AND user_id = ?", forwardID, userID).First(&forward); result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+	forward, err := s.forwards.GetForUser(userID, forwardID)
+	if err != nil {
+		if store.IsNotFound(err) {
 			return nil, errors.NotFound("转发规则不存在")
 		}
-		return nil, errors.Database("查询转发规则失败", result.Error)
+		return nil, errors.Database("查询转发规则失败", err)
 	}
-	return &forward, nil
+	return forward, nil
 }
 
 // CreateForward 创建转发规则
 func (s *Service) CreateForward(userID uint, req *ForwardRequest) (*db.Forward, error) {
-	// 检查端口是否已被使用
-	var existingForward db.Forward
-	if result := db.DB.Where("user_id = ? AND src_port = ?", userID, req.SrcPort).First(&existingForward); result.Error == nil {
-		return nil, errors.Conflict("端口已被使用")
-	} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-		return nil, errors.Database("查询转发规则失败", result.Error)
-	}
-
 	// 创建转发规则
 	forward := &db.Forward{
 		UserID:      userID,
@@ -77,8 +88,12 @@ func (s *Service) CreateForward(userID uint, req *ForwardRequest) (*db.Forward,
 		Enabled:     req.Enabled,
 	}
 
-	if result := db.DB.Create(forward); result.Error != nil {
-		return nil, errors.Database("创建转发规则失败", result.Error)
+	// 端口检查和创建由仓库原子完成
+	if err := s.forwards.Create(forward); err != nil {
+		if store.IsDuplicate(err) {
+			return nil, errors.Conflict("端口已被使用")
+		}
+		return nil, errors.Database("创建转发规则失败", err)
 	}
 
 	return forward, nil
@@ -86,12 +101,9 @@ func (s *Service) CreateForward(userID uint, req *ForwardRequest) (*db.Forward,
 
 // UpdateForward 更新转发规则
 func (s *Service) UpdateForward(userID uint, forwardID uint, req *ForwardUpdateRequest) (*db.Forward, error) {
-	var forward db.Forward
-	if result := db.DB.Where("id = ? AND user_id = ?", forwardID, userID).First(&forward); result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, errors.NotFound("转发规则不存在")
-		}
-		return nil, errors.Database("查询转发规则失败", result.Error)
+	forward, err := s.GetForward(userID, forwardID)
+	if err != nil {
+		return nil, err
 	}
 
 	// 更新转发规则信息
@@ -99,13 +111,7 @@ func (s *Service) UpdateForward(userID uint, forwardID uint, req *ForwardUpdateR
 		forward.Protocol = req.Protocol
 	}
 	if req.SrcPort > 0 {
-		// 检查端口是否已被使用
-		var existingForward db.Forward
-		if result := db.DB.Where("user_id = ? AND src_port = ? AND id != ?", userID, req.SrcPort, forwardID).First(&existingForward); result.Error == nil {
-			return nil, errors.Conflict("端口已被使用")
-		} else if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, errors.Database("查询转发规则失败", result.Error)
-		}
+		// 端口冲突由仓库的唯一约束检查
 		forward.SrcPort = req.SrcPort
 	}
 	if req.DstHost != "" {
@@ -121,26 +127,37 @@ func (s *Service) UpdateForward(userID uint, forwardID uint, req *ForwardUpdateR
 		forward.Enabled = *req.Enabled
 	}
 
-	if result := db.DB.Save(&forward); result.Error != nil {
-		return nil, errors.Database("更新转发规则失败", result.Error)
+	updates := map[string]interface{}{
+		"protocol":    forward.Protocol,
+		"src_port":    forward.SrcPort,
+		"dst_host":    forward.DstHost,
+		"dst_port":    forward.DstPort,
+		"description": forward.Description,
+		"enabled":     forward.Enabled,
+	}
+	if err := s.forwards.Update(forward, req.Revision, updates); err != nil {
+		if db.IsRevisionConflict(err) {
+			return nil, errors.VersionConflict("转发规则已被修改，请刷新后重试")
+		}
+		if store.IsDuplicate(err) {
+			return nil, errors.Conflict("端口已被使用")
+		}
+		return nil, errors.Database("更新转发规则失败", err)
 	}
 
-	return &forward, nil
+	return forward, nil
 }
 
 // DeleteForward 删除转发规则
 func (s *Service) DeleteForward(userID uint, forwardID uint) error {
-	var forward db.Forward
-	if result := db.DB.Where("id = ? AND user_id = ?", forwardID, userID).First(&forward); result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return errors.NotFound("转发规则不存在")
-		}
-		return errors.Database("查询转发规则失败", result.Error)
+	forward, err := s.GetForward(userID, forwardID)
+	if err != nil {
+		return err
 	}
 
 	// 删除转发规则
-	if result := db.DB.Delete(&forward); result.Error != nil {
-		return errors.Database("删除转发规则失败", result.Error)
+	if err := s.forwards.Delete(forward.ID); err != nil {
+		return errors.Database("删除转发规则失败", err)
 	}
 
 	return nil
@@ -148,12 +165,9 @@ func (s *Service) DeleteForward(userID uint, forwardID uint) error {
 
 // EnableForward 启用转发规则
 func (s *Service) EnableForward(userID uint, forwardID uint) (*db.Forward, error) {
-	var forward db.Forward
-	if result := db.DB.Where("id = ? AND user_id = ?", forwardID, userID).First(&forward); result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, errors.NotFound("转发规则不存在")
-		}
-		return nil, errors.Database("查询转发规则失败", result.Error)
+	forward, err := s.GetForward(userID, forwardID)
+	if err != nil {
+		return nil, err
 	}
 
 	// 检查转发规则状态
@@ -162,22 +176,18 @@ func (s *Service) EnableForward(userID uint, forwardID uint) (*db.Forward, error
 	}
 
 	// 更新转发规则状态
-	forward.Enabled = true
-	if result := db.DB.Save(&forward); result.Error != nil {
-		return nil, errors.Database("更新转发规则状态失败", result.Error)
+	if err := s.forwards.Update(forward, 0, map[string]interface{}{"enabled": true}); err != nil {
+		return nil, errors.Database("更新转发规则状态失败", err)
 	}
 
-	return &forward, nil
+	return forward, nil
 }
 
 // DisableForward 禁用转发规则
 func (s *Service) DisableForward(userID uint, forwardID uint) (*db.Forward, error) {
-	var forward db.Forward
-	if result := db.DB.Where("id = ? AND user_id = ?", forwardID, userID).First(&forward); result.Error != nil {
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil, errors.NotFound("转发规则不存在")
-		}
-		return nil, errors.Database("查询转发规则失败", result.Error)
+	forward, err := s.GetForward(userID, forwardID)
+	if err != nil {
+		return nil, err
 	}
 
 	// 检查转发规则状态
@@ -186,10 +196,9 @@ func (s *Service) DisableForward(userID uint, forwardID uint) (*db.Forward, erro
 	}
 
 	// 更新转发规则状态
-	forward.Enabled = false
-	if result := db.DB.Save(&forward); result.Error != nil {
-		return nil, errors.Database("更新转发规则状态失败", result.Error)
+	if err := s.forwards.Update(forward, 0, map[string]interface{}{"enabled": false}); err != nil {
+		return nil, errors.Database("更新转发规则状态失败", err)
 	}
 
-	return &forward, nil
+	return forward, nil
 }
@@ -0,0 +1,53 @@
+package forward
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/store"
+)
+
+func TestForwardLifecycle(t *testing.T) {
+	s := NewService(store.NewMemoryStore())
+
+	forward, err := s.CreateForward(1, &ForwardRequest{Protocol: "tcp", SrcPort: 8080, DstHost: "127.0.0.1", DstPort: 80})
+	if err != nil {
+		t.Fatalf("创建转发规则失败: %v", err)
+	}
+
+	_, err = s.CreateForward(1, &ForwardRequest{Protocol: "tcp", SrcPort: 8080, DstHost: "127.0.0.1", DstPort: 81})
+	if errors.AsError(err).StatusCode() != http.StatusConflict {
+		t.Fatalf("端口冲突应返回 409: %v", err)
+	}
+
+	if _, err := s.GetForward(2, forward.ID); errors.AsError(err).StatusCode() != http.StatusNotFound {
+		t.Fatalf("其他用户不应访问该转发规则: %v", err)
+	}
+
+	enabled, err := s.EnableForward(1, forward.ID)
+	if err != nil || !enabled.Enabled {
+		t.Fatalf("启用转发规则失败: %+v %v", enabled, err)
+	}
+	if _, err := s.EnableForward(1, forward.ID); errors.AsError(err).StatusCode() != http.StatusConflict {
+		t.Fatalf("重复启用应返回 409: %v", err)
+	}
+
+	// 使用过期的修订号更新
+	_, err = s.UpdateForward(1, forward.ID, &ForwardUpdateRequest{DstPort: 8081, Revision: forward.Revision})
+	if err == nil {
+		t.Fatal("过期的修订号应返回冲突")
+	}
+
+	updated, err := s.UpdateForward(1, forward.ID, &ForwardUpdateRequest{DstPort: 8081, Revision: enabled.Revision})
+	if err != nil || updated.DstPort != 8081 || updated.Revision != enabled.Revision+1 {
+		t.Fatalf("更新转发规则失败: %+v %v", updated, err)
+	}
+
+	if err := s.DeleteForward(1, forward.ID); err != nil {
+		t.Fatalf("删除转发规则失败: %v", err)
+	}
+	if forwards, _ := s.GetForwards(1); len(forwards) != 0 {
+		t.Fatalf("删除后不应再有转发规则: %d", len(forwards))
+	}
+}
@@ -1,9 +1,9 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -11,7 +11,9 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/lifecycle"
 	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/version"
 	"github.com/senma231/p3/server/api"
 	"github.com/senma231/p3/server/app"
 	"github.com/senma231/p3/server/auth"
@@ -19,6 +21,7 @@ import (
 	"github.com/senma231/p3/server/db"
 	"github.com/senma231/p3/server/device"
 	"github.com/senma231/p3/server/forward"
+	"gorm.io/gorm"
 )
 
 // 服务器启动时间
@@ -28,11 +31,17 @@ func main() {
 	// 解析命令行参数
 	configPath := flag.String("config", "config.yaml", "配置文件路径")
 	initDB := flag.Bool("init-db", false, "初始化数据库")
+	showVersion := flag.Bool("version", false, "显示版本信息")
 	flag.Parse()
 
+	if *showVersion {
+		fmt.Println(version.Get())
+		return
+	}
+
 	// 初始化日志
 	logger.Init(logger.InfoLevel, os.Stdout)
-	logger.Info("服务器启动中...")
+	logger.Info("服务器启动中... 版本: %s", version.Get())
 
 	// 加载配置
 	cfg, err := config.LoadConfig(*configPath)
@@ -41,14 +50,25 @@ func main() {
 	}
 	logger.Info("加载配置成功")
 
-	// 初始化数据库
-	if err := db.InitDB(cfg); err != nil {
+	// 初始化数据库，退出时在 HTTP 服务器之后关闭
+	runner := lifecycle.New(10 * time.Second)
+	var gdb *gorm.DB
+	if err := runner.Start(lifecycle.Component{
+		Name: "数据库",
+		Start: func() error {
+			var err error
+			gdb, err = db.Open(cfg)
+			return err
+		},
+		Stop: lifecycle.StopErrFunc(func() error { return db.Close(gdb) }),
+	}); err != nil {
 		logger.Fatal("初始化数据库失败: %v", err)
 	}
 	logger.Info("初始化数据库成功")
 
 	// 如果只是初始化数据库，则退出
 	if *initDB {
+		runner.Stop()
 		logger.Info("数据库初始化完成，退出")
 		return
 	}
@@ -78,29 +98,40 @@ func main() {
 		Handler: router,
 	}
 
+	// 启动 HTTP 服务器
+	if err := runner.Start(lifecycle.Component{
+		Name: "HTTP 服务器",
+		Start: func() error {
+			listener, err := net.Listen("tcp", server.Addr)
+			if err != nil {
+				return err
+			}
+			logger.Info("服务器已启动，监听地址: %s", server.Addr)
+			go func() {
+				if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+					runner.Fail("HTTP 服务器", err)
+				}
+			}()
+			return nil
+		},
+		Stop:    server.Shutdown,
+		Timeout: 5 * time.Second,
+	}); err != nil {
+		runner.Stop()
+		logger.Fatal("%v", err)
+	}
+
 	// 优雅关闭
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-
-	go func() {
-		logger.Info("服务器已启动，监听地址: %s", server.Addr)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			logger.Fatal("启动服务器失败: %v", err)
-		}
-	}()
-
-	<-quit
+	select {
+	case <-quit:
+	case <-runner.Failed():
+	}
 	logger.Info("正在关闭服务器...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	// 关闭数据库连接
-	db.CloseDB()
-
-	// 关闭 HTTP 服务器
-	if err := server.Shutdown(ctx); err != nil {
-		logger.Fatal("关闭服务器失败: %v", err)
+	if err := runner.Stop(); err != nil {
+		logger.Error("关闭服务器时出错: %v", err)
 	}
 
 	logger.Info("服务器已关闭")
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"fmt"
+	"mime"
+	"net/smtp"
+	"strings"
+
+	"github.com/senma231/p3/server/config"
+)
+
+// EmailNotifier 邮件通知发送器
+type EmailNotifier struct {
+	config *config.SMTPConfig
+}
+
+// NewEmailNotifier 创建邮件通知发送器
+func NewEmailNotifier(cfg *config.SMTPConfig) *EmailNotifier {
+	return &EmailNotifier{
+		config: cfg,
+	}
+}
+
+// Send 发送邮件通知
+func (n *EmailNotifier) Send(target string, msg *Message) error {
+	// 防止邮件头注入
+	if strings.ContainsAny(target, "\r\n") || strings.ContainsAny(msg.Subject, "\r\n") {
+		return fmt.Errorf("无效的邮件地址或主题")
+	}
+
+	addr := fmt.Sprintf("%s:%d", n.config.Host, n.config.Port)
+
+	var auth smtp.Auth
+	if n.config.Username != "" {
+		auth = smtp.PlainAuth("", n.config.Username, n.config.Password, n.config.Host)
+	}
+
+	var body strings.Builder
+	body.WriteString("From: " + n.config.From + "\r\n")
+	body.WriteString("To: " + target + "\r\n")
+	body.WriteString("Subject: " + mime.QEncoding.Encode("UTF-8", msg.Subject) + "\r\n")
+	body.WriteString("MIME-Version: 1.0\r\n")
+	body.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	body.WriteString("\r\n")
+	body.WriteString(msg.Body)
+
+	if err := smtp.SendMail(addr, auth, n.config.From, []string{target}, []byte(body.String())); err != nil {
+		return fmt.Errorf("发送邮件失败: %w", err)
+	}
+
+	return nil
+}
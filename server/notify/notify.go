@@ -0,0 +1,104 @@
+package notify
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/server/config"
+)
+
+// 通知渠道
+const (
+	ChannelWebhook = "webhook"
+	ChannelEmail   = "email"
+)
+
+// Message 通知消息
+type Message struct {
+	Subject   string                 `json:"subject"`
+	Body      string                 `json:"body"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+// Notifier 通知发送器
+type Notifier interface {
+	// Send 向目标发送通知，目标的含义由渠道决定，例如 URL 或邮箱地址
+	Send(target string, msg *Message) error
+}
+
+// Manager 通知管理器
+type Manager struct {
+	notifiers map[string]Notifier
+	mu        sync.RWMutex
+}
+
+// NewManager 创建通知管理器
+func NewManager(cfg *config.NotifyConfig) *Manager {
+	m := &Manager{
+		notifiers: make(map[string]Notifier),
+	}
+
+	// 配置加载时已验证网段格式
+	var allowed []*net.IPNet
+	for _, cidr := range cfg.WebhookAllowedNetworks {
+		if _, ipNet, err := net.ParseCIDR(cidr); err == nil {
+			allowed = append(allowed, ipNet)
+		}
+	}
+	m.Register(ChannelWebhook, NewWebhookNotifier(time.Duration(cfg.WebhookTimeout)*time.Second, allowed))
+	if cfg.SMTP.Host != "" {
+		m.Register(ChannelEmail, NewEmailNotifier(&cfg.SMTP))
+	}
+
+	return m
+}
+
+// Register 注册通知渠道
+func (m *Manager) Register(channel string, notifier Notifier) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.notifiers[channel] = notifier
+}
+
+// HasChannel 检查通知渠道是否可用
+func (m *Manager) HasChannel(channel string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	_, exists := m.notifiers[channel]
+	return exists
+}
+
+// CheckTarget 检查通知渠道是否可用，以及渠道能否发送到目标，创建和更新告警规则时调用
+func (m *Manager) CheckTarget(channel, target string) error {
+	m.mu.RLock()
+	notifier, exists := m.notifiers[channel]
+	m.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("通知渠道 %s 未配置", channel)
+	}
+	if checker, ok := notifier.(interface{ CheckTarget(string) error }); ok {
+		return checker.CheckTarget(target)
+	}
+	return nil
+}
+
+// Send 通过指定渠道发送通知
+func (m *Manager) Send(channel, target string, msg *Message) error {
+	m.mu.RLock()
+	notifier, exists := m.notifiers[channel]
+	m.mu.RUnlock()
+
+	if !exists {
+		return fmt.Errorf("通知渠道 %s 不可用", channel)
+	}
+
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+
+	return notifier.Send(target, msg)
+}
@@ -0,0 +1,117 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrWebhookAddress Webhook 地址指向不允许访问的内网地址
+var ErrWebhookAddress = errors.New("Webhook 地址不能指向本机或内网地址")
+
+// sharedAddressSpace 运营商级 NAT 使用的共享地址段
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// WebhookNotifier Webhook 通知发送器，以 JSON 格式 POST 消息。
+// 连接时检查解析后的地址，不访问回环、私有和链路本地地址，重定向和 DNS 重绑定同样受限
+type WebhookNotifier struct {
+	client  *http.Client
+	allowed []*net.IPNet
+}
+
+// NewWebhookNotifier 创建 Webhook 通知发送器，allowed 为管理员允许访问的内网网段
+func NewWebhookNotifier(timeout time.Duration, allowed []*net.IPNet) *WebhookNotifier {
+	n := &WebhookNotifier{allowed: allowed}
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: n.control,
+	}
+	n.client = &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			// 不使用代理，否则检查的是代理的地址
+			Proxy:               nil,
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			TLSHandshakeTimeout: timeout,
+			MaxIdleConns:        10,
+			IdleConnTimeout:     90 * time.Second,
+		},
+	}
+	return n
+}
+
+// Send 发送 Webhook 通知
+func (n *WebhookNotifier) Send(target string, msg *Message) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("序列化通知消息失败: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("创建 Webhook 请求失败: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "p3-server")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("发送 Webhook 失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("Webhook 返回错误状态: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// CheckTarget 检查 Webhook 地址。主机为 IP 地址或 localhost 时在创建规则时即拒绝内网地址，
+// 域名在发送时按解析结果检查
+func (n *WebhookNotifier) CheckTarget(target string) error {
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("无效的 Webhook 地址")
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return ErrWebhookAddress
+	}
+	if ip := net.ParseIP(host); ip != nil && !n.permitted(ip) {
+		return ErrWebhookAddress
+	}
+	return nil
+}
+
+// control 在建立连接前检查解析后的地址
+func (n *WebhookNotifier) control(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !n.permitted(ip) {
+		return fmt.Errorf("%w: %s", ErrWebhookAddress, host)
+	}
+	return nil
+}
+
+// permitted 检查是否允许访问地址，管理员允许的网段优先
+func (n *WebhookNotifier) permitted(ip net.IP) bool {
+	for _, ipNet := range n.allowed {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || sharedAddressSpace.Contains(ip))
+}
@@ -0,0 +1,85 @@
+package notify
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/config"
+)
+
+func TestWebhookRejectsInternalAddresses(t *testing.T) {
+	received := make(chan struct{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- struct{}{}
+	}))
+	defer server.Close()
+
+	// 默认不允许访问本机地址，重定向到本机同样被拒绝
+	n := NewWebhookNotifier(time.Second, nil)
+	if err := n.Send(server.URL, &Message{Subject: "test"}); !errors.Is(err, ErrWebhookAddress) {
+		t.Fatalf("访问本机地址应被拒绝, 实际为 %v", err)
+	}
+	select {
+	case <-received:
+		t.Fatal("被拒绝的 Webhook 不应到达服务端")
+	default:
+	}
+
+	// 管理员允许的网段可以访问
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	n = NewWebhookNotifier(time.Second, []*net.IPNet{loopback})
+	if err := n.Send(server.URL, &Message{Subject: "test"}); err != nil {
+		t.Fatalf("允许的网段应可以访问: %v", err)
+	}
+}
+
+func TestWebhookCheckTarget(t *testing.T) {
+	n := NewWebhookNotifier(time.Second, nil)
+	tests := []struct {
+		target string
+		ok     bool
+	}{
+		{target: "https://hooks.example.com/p3", ok: true},
+		{target: "http://203.0.113.5:8080/hook", ok: true},
+		{target: "http://127.0.0.1/hook"},
+		{target: "http://localhost:8080/hook"},
+		{target: "http://169.254.169.254/latest/meta-data/"},
+		{target: "http://10.0.0.1/hook"},
+		{target: "http://192.168.1.1/hook"},
+		{target: "http://100.64.0.1/hook"},
+		{target: "http://[::1]/hook"},
+		{target: "http://[fd00::1]/hook"},
+		{target: "ftp://example.com/hook"},
+	}
+	for _, tt := range tests {
+		if err := n.CheckTarget(tt.target); (err == nil) != tt.ok {
+			t.Errorf("检查 %s 返回 %v, 期望允许: %v", tt.target, err, tt.ok)
+		}
+	}
+}
+
+func TestManagerCheckTarget(t *testing.T) {
+	// 未配置 SMTP 时邮件渠道不可用
+	m := NewManager(&config.NotifyConfig{WebhookTimeout: 1})
+	if err := m.CheckTarget(ChannelEmail, "ops@example.com"); err == nil {
+		t.Error("未配置 SMTP 时应拒绝邮件渠道")
+	}
+	if err := m.CheckTarget(ChannelWebhook, "https://hooks.example.com/p3"); err != nil {
+		t.Errorf("Webhook 渠道应可用: %v", err)
+	}
+	if err := m.CheckTarget(ChannelWebhook, "http://169.254.169.254/"); err == nil {
+		t.Error("应拒绝指向链路本地地址的 Webhook")
+	}
+
+	m = NewManager(&config.NotifyConfig{
+		WebhookTimeout: 1,
+		SMTP:           config.SMTPConfig{Host: "smtp.example.com", Port: 587, From: "p3@example.com"},
+	})
+	if err := m.CheckTarget(ChannelEmail, "ops@example.com"); err != nil {
+		t.Errorf("配置 SMTP 后邮件渠道应可用: %v", err)
+	}
+}
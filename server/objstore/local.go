@@ -0,0 +1,198 @@
+package objstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LocalRoute 本地存储签名下载链接的路径前缀，由服务端的 API 处理
+const LocalRoute = "/api/v1/objects/"
+
+// tempPrefix 写入中的临时文件前缀，列出对象时跳过
+const tempPrefix = ".upload-"
+
+var (
+	// ErrURLExpired 签名下载链接已过期
+	ErrURLExpired = errors.New("下载链接已过期")
+	// ErrBadSignature 签名下载链接的签名无效
+	ErrBadSignature = errors.New("下载链接无效")
+)
+
+// LocalStore 保存在本地目录的对象存储，签名下载链接由服务端验证后返回文件
+type LocalStore struct {
+	dir       string
+	publicURL string
+	key       []byte
+}
+
+// NewLocalStore 创建本地对象存储，publicURL 为下载链接的地址前缀，为空时返回相对路径
+func NewLocalStore(dir, publicURL string, key []byte) (*LocalStore, error) {
+	if len(key) == 0 {
+		return nil, errors.New("本地对象存储需要签名密钥")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("创建对象存储目录失败: %w", err)
+	}
+	return &LocalStore{
+		dir:       dir,
+		publicURL: strings.TrimSuffix(publicURL, "/"),
+		key:       key,
+	}, nil
+}
+
+// path 对象在本地目录中的路径
+func (s *LocalStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+// Put 先写入同目录的临时文件再重命名，读取方不会看到写了一半的对象
+func (s *LocalStore) Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	path := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return fmt.Errorf("创建对象目录失败: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), tempPrefix+"*")
+	if err != nil {
+		return fmt.Errorf("创建对象文件失败: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("写入对象失败: %w", err)
+	}
+	if size >= 0 && written != size {
+		return fmt.Errorf("对象大小不符，期望 %d 字节，实际 %d 字节", size, written)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("保存对象失败: %w", err)
+	}
+	return nil
+}
+
+// Open 打开对象文件，返回的 *os.File 支持 Seek
+func (s *LocalStore) Open(ctx context.Context, key string) (io.ReadCloser, *Object, error) {
+	if err := ValidateKey(key); err != nil {
+		return nil, nil, err
+	}
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("打开对象失败: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, fmt.Errorf("读取对象失败: %w", err)
+	}
+	if info.IsDir() {
+		file.Close()
+		return nil, nil, ErrNotFound
+	}
+	return file, &Object{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Delete 删除对象文件
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	if err := ValidateKey(key); err != nil {
+		return err
+	}
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("删除对象失败: %w", err)
+	}
+	return nil
+}
+
+// List 遍历目录列出键以 prefix 开头的对象
+func (s *LocalStore) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), tempPrefix) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		objects = append(objects, Object{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return ctx.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("列出对象失败: %w", err)
+	}
+	return objects, nil
+}
+
+// SignedURL 生成由服务端验证的下载链接，签名覆盖对象键、过期时间和文件名
+func (s *LocalStore) SignedURL(key, filename string, ttl time.Duration) (string, error) {
+	if err := ValidateKey(key); err != nil {
+		return "", err
+	}
+	expires := strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	query := url.Values{
+		"expires":   {expires},
+		"signature": {s.sign(key, expires, filename)},
+	}
+	if filename != "" {
+		query.Set("filename", filename)
+	}
+	return s.publicURL + LocalRoute + key + "?" + query.Encode(), nil
+}
+
+// Verify 验证下载链接的签名和过期时间
+func (s *LocalStore) Verify(key, expires, filename, signature string, now time.Time) error {
+	expected := s.sign(key, expires, filename)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrBadSignature
+	}
+	deadline, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	if now.Unix() > deadline {
+		return ErrURLExpired
+	}
+	return nil
+}
+
+// sign 计算下载链接的签名
+func (s *LocalStore) sign(key, expires, filename string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(key + "\n" + expires + "\n" + filename))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,169 @@
+// Package objstore 对象存储。诊断包、导出文件等大文件保存在本地目录或 S3（兼容 MinIO 等），
+// 通过有效期有限的签名链接下载，按前缀的保留时间自动清理
+package objstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/config"
+)
+
+// ErrNotFound 对象不存在
+var ErrNotFound = errors.New("对象不存在")
+
+// Object 对象的元数据
+type Object struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// Store 对象存储
+type Store interface {
+	// Put 保存对象，size 为内容长度，已存在时覆盖
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Open 读取对象，不存在时返回 ErrNotFound
+	Open(ctx context.Context, key string) (io.ReadCloser, *Object, error)
+	// Delete 删除对象，不存在时不返回错误
+	Delete(ctx context.Context, key string) error
+	// List 列出键以 prefix 开头的对象
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// SignedURL 生成下载对象的签名链接，filename 为浏览器保存时使用的文件名
+	SignedURL(key, filename string, ttl time.Duration) (string, error)
+}
+
+// keyPattern 对象键的每一段只允许字母、数字和 ._-，不能以 . 开头，避免路径穿越
+var keyPattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// ValidateKey 检查对象键，键由 / 分隔的若干段组成
+func ValidateKey(key string) error {
+	if key == "" || len(key) > 512 {
+		return fmt.Errorf("无效的对象键: %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if !keyPattern.MatchString(part) {
+			return fmt.Errorf("无效的对象键: %q", key)
+		}
+	}
+	return nil
+}
+
+// New 按配置创建对象存储，local 存储未配置签名密钥时使用 defaultSigningKey
+func New(cfg config.StorageConfig, defaultSigningKey string) (Store, error) {
+	switch cfg.Driver {
+	case "local":
+		key := cfg.SigningKey
+		if key == "" {
+			key = defaultSigningKey
+		}
+		return NewLocalStore(cfg.Dir, cfg.PublicURL, []byte(key))
+	case "s3":
+		return NewS3Store(cfg.S3)
+	default:
+		return nil, fmt.Errorf("不支持的对象存储类型: %s", cfg.Driver)
+	}
+}
+
+// Rule 清理规则，键以 Prefix 开头且修改时间早于 MaxAge 之前的对象被删除
+type Rule struct {
+	Prefix string
+	MaxAge time.Duration
+}
+
+// Cleanup 按规则清理过期对象，返回删除的对象数。单个对象删除失败时继续清理其他对象
+func Cleanup(ctx context.Context, store Store, rules []Rule, now time.Time) (int, error) {
+	removed := 0
+	var firstErr error
+	for _, rule := range rules {
+		if rule.MaxAge <= 0 {
+			continue
+		}
+		objects, err := store.List(ctx, rule.Prefix)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("列出 %s 下的对象失败: %w", rule.Prefix, err)
+			}
+			continue
+		}
+		deadline := now.Add(-rule.MaxAge)
+		for _, obj := range objects {
+			if !obj.ModTime.Before(deadline) {
+				continue
+			}
+			if err := store.Delete(ctx, obj.Key); err != nil {
+				if firstErr == nil {
+					firstErr = fmt.Errorf("删除对象 %s 失败: %w", obj.Key, err)
+				}
+				continue
+			}
+			removed++
+		}
+	}
+	return removed, firstErr
+}
+
+// cleanupInterval 清理过期对象的间隔
+const cleanupInterval = time.Hour
+
+// Janitor 定期按规则清理过期对象，服务重启后遗留的对象也会被清理
+type Janitor struct {
+	store  Store
+	rules  []Rule
+	stopCh chan struct{}
+}
+
+// NewJanitor 创建过期对象清理器
+func NewJanitor(store Store, rules []Rule) *Janitor {
+	return &Janitor{
+		store:  store,
+		rules:  rules,
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start 启动时清理一次，之后每小时清理一次
+func (j *Janitor) Start() {
+	go j.loop()
+}
+
+// Stop 停止清理
+func (j *Janitor) Stop() {
+	close(j.stopCh)
+}
+
+// loop 定期清理过期对象
+func (j *Janitor) loop() {
+	ticker := time.NewTicker(cleanupInterval)
+	defer ticker.Stop()
+
+	j.cleanup(time.Now())
+	for {
+		select {
+		case <-j.stopCh:
+			return
+		case now := <-ticker.C:
+			j.cleanup(now)
+		}
+	}
+}
+
+// cleanup 清理一次过期对象
+func (j *Janitor) cleanup(now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	removed, err := Cleanup(ctx, j.store, j.rules, now)
+	if err != nil {
+		logger.Warn("清理过期对象失败: %v", err)
+	}
+	if removed > 0 {
+		logger.Info("已清理 %d 个过期对象", removed)
+	}
+}
@@ -0,0 +1,123 @@
+package objstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStore(t *testing.T) {
+	ctx := context.Background()
+	store, err := NewLocalStore(t.TempDir(), "https://p3.example.com/", []byte("secret"))
+	if err != nil {
+		t.Fatalf("创建本地存储失败: %v", err)
+	}
+
+	if err := store.Put(ctx, "exports/a.csv", strings.NewReader("id\n1\n"), 5, "text/csv"); err != nil {
+		t.Fatalf("保存对象失败: %v", err)
+	}
+	if err := store.Put(ctx, "exports/b.csv", strings.NewReader("short"), 10, "text/csv"); err == nil {
+		t.Fatal("大小不符时应返回错误")
+	}
+	if err := store.Put(ctx, "../escape", strings.NewReader("x"), 1, ""); err == nil {
+		t.Fatal("无效的对象键应返回错误")
+	}
+
+	r, obj, err := store.Open(ctx, "exports/a.csv")
+	if err != nil {
+		t.Fatalf("读取对象失败: %v", err)
+	}
+	data, _ := io.ReadAll(r)
+	r.Close()
+	if string(data) != "id\n1\n" || obj.Size != 5 {
+		t.Fatalf("对象内容错误: %q %+v", data, obj)
+	}
+
+	objects, err := store.List(ctx, "exports/")
+	if err != nil || len(objects) != 1 || objects[0].Key != "exports/a.csv" {
+		t.Fatalf("列出对象错误: %+v %v", objects, err)
+	}
+
+	if err := store.Delete(ctx, "exports/a.csv"); err != nil {
+		t.Fatalf("删除对象失败: %v", err)
+	}
+	if _, _, err := store.Open(ctx, "exports/a.csv"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("期望 ErrNotFound，实际 %v", err)
+	}
+	if err := store.Delete(ctx, "exports/a.csv"); err != nil {
+		t.Fatalf("删除不存在的对象不应返回错误: %v", err)
+	}
+}
+
+func TestLocalSignedURL(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir(), "https://p3.example.com", []byte("secret"))
+	if err != nil {
+		t.Fatalf("创建本地存储失败: %v", err)
+	}
+
+	link, err := store.SignedURL("diagnostics/1/bundle.tar.gz", "bundle.tar.gz", time.Minute)
+	if err != nil {
+		t.Fatalf("生成下载链接失败: %v", err)
+	}
+	u, _ := url.Parse(link)
+	if u.Host != "p3.example.com" || u.Path != LocalRoute+"diagnostics/1/bundle.tar.gz" {
+		t.Fatalf("下载链接错误: %s", link)
+	}
+	q := u.Query()
+	key := strings.TrimPrefix(u.Path, LocalRoute)
+
+	if err := store.Verify(key, q.Get("expires"), q.Get("filename"), q.Get("signature"), time.Now()); err != nil {
+		t.Fatalf("验证下载链接失败: %v", err)
+	}
+	if err := store.Verify("diagnostics/2/bundle.tar.gz", q.Get("expires"), q.Get("filename"), q.Get("signature"), time.Now()); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("篡改对象键应返回 ErrBadSignature，实际 %v", err)
+	}
+	if err := store.Verify(key, q.Get("expires"), "other.tar.gz", q.Get("signature"), time.Now()); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("篡改文件名应返回 ErrBadSignature，实际 %v", err)
+	}
+	if err := store.Verify(key, q.Get("expires"), q.Get("filename"), q.Get("signature"), time.Now().Add(2*time.Minute)); !errors.Is(err, ErrURLExpired) {
+		t.Fatalf("过期链接应返回 ErrURLExpired，实际 %v", err)
+	}
+}
+
+func TestCleanup(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := NewLocalStore(dir, "", []byte("secret"))
+	if err != nil {
+		t.Fatalf("创建本地存储失败: %v", err)
+	}
+
+	now := time.Now()
+	for key, age := range map[string]time.Duration{
+		"exports/old.csv":        48 * time.Hour,
+		"exports/new.csv":        time.Hour,
+		"diagnostics/1/old.gz":   48 * time.Hour,
+		"releases/v1.2.0/p3.bin": 48 * time.Hour,
+	} {
+		if err := store.Put(ctx, key, strings.NewReader("x"), 1, ""); err != nil {
+			t.Fatalf("保存对象失败: %v", err)
+		}
+		modTime := now.Add(-age)
+		os.Chtimes(filepath.Join(dir, filepath.FromSlash(key)), modTime, modTime)
+	}
+
+	removed, err := Cleanup(ctx, store, []Rule{
+		{Prefix: "exports/", MaxAge: 24 * time.Hour},
+		{Prefix: "diagnostics/", MaxAge: 24 * time.Hour},
+		{Prefix: "releases/"}, // 不清理
+	}, now)
+	if err != nil || removed != 2 {
+		t.Fatalf("期望清理 2 个对象，实际 %d，错误 %v", removed, err)
+	}
+	objects, _ := store.List(ctx, "")
+	if len(objects) != 2 {
+		t.Fatalf("剩余对象错误: %+v", objects)
+	}
+}
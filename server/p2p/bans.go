@@ -0,0 +1,139 @@
+package p2p
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/abuse"
+	"github.com/senma231/p3/server/db"
+)
+
+// RelayBannedCode 节点、地址或目标端口被封禁的错误码
+const RelayBannedCode = "RELAY_BANNED"
+
+// SetBanList 设置封禁列表，被封禁的节点和来源地址不能连接信令服务器，被封禁的目标不能分配中继
+func (s *SignalingServer) SetBanList(bans *abuse.BanList) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bans = bans
+}
+
+// banList 获取封禁列表，未设置时返回 nil
+func (s *SignalingServer) banList() *abuse.BanList {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.bans
+}
+
+// rejectBanned 节点或来源地址被封禁时返回 403 并中止请求
+func (s *SignalingServer) rejectBanned(c *gin.Context, nodeID string) bool {
+	ban := s.banList().Match(nodeID, net.ParseIP(c.ClientIP()), 0)
+	if ban == nil {
+		return false
+	}
+	logger.Warn("拒绝被封禁的节点连接信令服务器: %s (%s)", nodeID, c.ClientIP())
+	c.JSON(http.StatusForbidden, gin.H{
+		"error":     "节点已被封禁",
+		"code":      RelayBannedCode,
+		"reason":    ban.Reason,
+		"expiresAt": ban.ExpiresAt,
+	})
+	c.Abort()
+	return true
+}
+
+// relayTargetBan 检查中继请求的目标节点及其地址和端口是否被封禁
+func (s *SignalingServer) relayTargetBan(targetID string) *db.RelayBan {
+	bans := s.banList()
+	if ban := bans.Match(targetID, nil, 0); ban != nil {
+		return ban
+	}
+	peer, err := s.coordinator.GetPeerInfo(targetID)
+	if err != nil || peer.ExternalIP == nil {
+		return nil
+	}
+	return bans.MatchAddress(relayDestination(peer))
+}
+
+// DisconnectBanned 断开被封禁的节点或来源地址的信令连接，返回断开的数量。WebSocket 客户端以关闭帧告知原因后由读协程注销，
+// 长轮询客户端直接注销，之后的轮询被拒绝
+func (s *SignalingServer) DisconnectBanned() int {
+	bans := s.banList()
+	var polling []*Client
+	disconnected := 0
+
+	s.mu.RLock()
+	for _, client := range s.clients {
+		if bans.Match(client.NodeID, client.RemoteIP, 0) == nil {
+			continue
+		}
+		disconnected++
+		if client.Conn != nil {
+			client.Conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "节点已被封禁"),
+				time.Now().Add(time.Second))
+			client.Conn.Close()
+		} else {
+			polling = append(polling, client)
+		}
+	}
+	s.mu.RUnlock()
+
+	for _, client := range polling {
+		s.unregisterClient(client)
+	}
+	return disconnected
+}
+
+// SetBanList 设置封禁列表，被封禁的源节点、来源地址、目标节点和目标地址端口不能建立中继会话
+func (s *RelayServer) SetBanList(bans *abuse.BanList) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bans = bans
+}
+
+// sessionBan 检查会话的源节点、来源地址、目标节点和目标地址是否被封禁
+func (s *RelayServer) sessionBan(session *RelaySession) *db.RelayBan {
+	s.mu.RLock()
+	bans := s.bans
+	s.mu.RUnlock()
+
+	if ban := bans.Match(session.SourceID, session.SourceIP, 0); ban != nil {
+		return ban
+	}
+	if ban := bans.Match(session.TargetID, nil, 0); ban != nil {
+		return ban
+	}
+	return bans.MatchAddress(session.Destination)
+}
+
+// CloseBanned 关闭涉及被封禁对象的中继会话，返回关闭的会话数
+func (s *RelayServer) CloseBanned() int {
+	s.mu.RLock()
+	sessions := make([]*RelaySession, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		sessions = append(sessions, session)
+	}
+	s.mu.RUnlock()
+
+	closed := 0
+	for _, session := range sessions {
+		if s.sessionBan(session) != nil {
+			logger.Warn("关闭涉及被封禁对象的中继会话: %s -> %s", session.SourceID, session.TargetID)
+			s.closeSession(session)
+			closed++
+		}
+	}
+	return closed
+}
+
+// remoteIP 连接的来源 IP，不是 TCP 连接时返回 nil
+func remoteIP(conn net.Conn) net.IP {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return nil
+}
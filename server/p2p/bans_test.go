@@ -0,0 +1,134 @@
+package p2p
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/abuse"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// newTestBanList 创建包含指定封禁的封禁列表
+func newTestBanList(t *testing.T, bans ...db.RelayBan) *abuse.BanList {
+	list := abuse.NewBanList(store.NewMemoryStore().Bans)
+	for i := range bans {
+		if err := list.Add(&bans[i]); err != nil {
+			t.Fatalf("添加封禁失败: %v", err)
+		}
+	}
+	return list
+}
+
+func TestSignalingBans(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	s := NewSignalingServer(cfg, NewCoordinator(cfg, nil, nil), nil, nil)
+
+	// 没有封禁列表时不拒绝
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/ws", nil)
+	if s.rejectBanned(c, "node-a") {
+		t.Fatal("没有封禁列表时不应拒绝")
+	}
+
+	pollFrom(s.HandlePoll, "node-a", "first", http.MethodGet, "/signal/poll?wait=0")
+	pollFrom(s.HandlePoll, "node-b", "first", http.MethodGet, "/signal/poll?wait=0")
+	s.SetBanList(newTestBanList(t, db.RelayBan{Kind: db.BanNode, Value: "node-a", Reason: "滥用中继"}))
+
+	w := httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/ws", nil)
+	if !s.rejectBanned(c, "node-a") || w.Code != http.StatusForbidden || !c.IsAborted() {
+		t.Fatalf("被封禁的节点应被拒绝，实际为 %d", w.Code)
+	}
+
+	// 已连接的被封禁节点被断开，其他节点不受影响
+	if n := s.DisconnectBanned(); n != 1 {
+		t.Fatalf("断开 %d 个连接，期望 1 个", n)
+	}
+	if s.IsClientOnline("node-a") || !s.IsClientOnline("node-b") {
+		t.Fatal("只应断开被封禁的节点")
+	}
+	if s.relayTargetBan("node-a") == nil || s.relayTargetBan("node-b") != nil {
+		t.Fatal("中继请求的目标封禁检查错误")
+	}
+}
+
+func TestSignalingBanForwardedFor(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	s := NewSignalingServer(cfg, NewCoordinator(cfg, nil, nil), nil, nil)
+	s.SetBanList(newTestBanList(t, db.RelayBan{Kind: db.BanIP, Value: "192.0.2.10"}))
+
+	// 与 api.NewEngine 相同，只信任配置的代理设置的 X-Forwarded-For
+	newEngine := func(trustedProxies ...string) *gin.Engine {
+		engine := gin.New()
+		if err := engine.SetTrustedProxies(trustedProxies); err != nil {
+			t.Fatalf("设置受信任的代理失败: %v", err)
+		}
+		engine.GET("/ws", func(c *gin.Context) {
+			if !s.rejectBanned(c, "node-a") {
+				c.Status(http.StatusOK)
+			}
+		})
+		return engine
+	}
+	request := func(engine *gin.Engine, remoteAddr, forwardedFor string) int {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		engine.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// 默认不信任任何代理，伪造的请求头不能绕过封禁，也不能冒用被封禁的地址
+	engine := newEngine(cfg.Server.TrustedProxies...)
+	if code := request(engine, "192.0.2.10:40000", "198.51.100.20"); code != http.StatusForbidden {
+		t.Fatalf("伪造 X-Forwarded-For 不应绕过封禁，实际为 %d", code)
+	}
+	if code := request(engine, "198.51.100.20:40000", "192.0.2.10"); code != http.StatusOK {
+		t.Fatalf("不受信任的 X-Forwarded-For 不应影响客户端地址，实际为 %d", code)
+	}
+
+	// 来自受信任代理的请求按 X-Forwarded-For 检查
+	engine = newEngine("10.0.0.1")
+	if code := request(engine, "10.0.0.1:40000", "192.0.2.10"); code != http.StatusForbidden {
+		t.Fatalf("受信任代理转发的被封禁地址应被拒绝，实际为 %d", code)
+	}
+	if code := request(engine, "10.0.0.1:40000", "198.51.100.20"); code != http.StatusOK {
+		t.Fatalf("受信任代理转发的其他地址不应被拒绝，实际为 %d", code)
+	}
+}
+
+func TestRelaySessionBan(t *testing.T) {
+	s := NewRelayServer(config.DefaultConfig(), nil)
+	session := &RelaySession{SourceID: "node-a", SourceIP: net.ParseIP("192.0.2.10"), TargetID: "node-b", Destination: "198.51.100.1:4000"}
+	if s.sessionBan(session) != nil {
+		t.Fatal("没有封禁列表时不应拒绝")
+	}
+
+	tests := []struct {
+		ban    db.RelayBan
+		banned bool
+	}{
+		{db.RelayBan{Kind: db.BanNode, Value: "node-a"}, true},
+		{db.RelayBan{Kind: db.BanNode, Value: "node-b"}, true},
+		{db.RelayBan{Kind: db.BanNode, Value: "node-c"}, false},
+		{db.RelayBan{Kind: db.BanIP, Value: "192.0.2.0/24"}, true},
+		{db.RelayBan{Kind: db.BanIP, Value: "198.51.100.1"}, true},
+		{db.RelayBan{Kind: db.BanPort, Value: "4000"}, true},
+		{db.RelayBan{Kind: db.BanPort, Value: "4001"}, false},
+	}
+	for _, tt := range tests {
+		s.SetBanList(newTestBanList(t, tt.ban))
+		if got := s.sessionBan(session) != nil; got != tt.banned {
+			t.Errorf("封禁 %s %s 时会话被拒绝 = %t，期望 %t", tt.ban.Kind, tt.ban.Value, got, tt.banned)
+		}
+	}
+}
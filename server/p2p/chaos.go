@@ -0,0 +1,42 @@
+package p2p
+
+// KillSessions 断开 pick 选中的中继会话，用于故障注入。中继协程随后清理会话，可恢复的会话可以凭票据重连
+func (s *RelayServer) KillSessions(pick func() bool) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	killed := 0
+	for _, session := range s.sessions {
+		if pick() {
+			s.closeSession(session)
+			killed++
+		}
+	}
+	return killed
+}
+
+// KillSessions 断开 pick 选中的信令客户端，用于故障注入。WebSocket 客户端关闭连接后由读协程注销，
+// 长轮询客户端直接注销，下次轮询时重新注册
+func (s *SignalingServer) KillSessions(pick func() bool) int {
+	var polling []*Client
+	killed := 0
+
+	s.mu.RLock()
+	for _, client := range s.clients {
+		if !pick() {
+			continue
+		}
+		if client.Conn != nil {
+			client.Conn.Close()
+		} else {
+			polling = append(polling, client)
+		}
+		killed++
+	}
+	s.mu.RUnlock()
+
+	for _, client := range polling {
+		s.unregisterClient(client)
+	}
+	return killed
+}
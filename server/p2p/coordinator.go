@@ -7,134 +7,71 @@ import (
 	"sync"
 	"time"
 
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/common/signing"
 	"github.com/senma231/p3/server/config"
 	"github.com/senma231/p3/server/db"
 	"github.com/senma231/p3/server/device"
+	"github.com/senma231/p3/server/store"
 )
 
-// NATType NAT 类型
-type NATType int
-
-const (
-	NATUnknown        NATType = iota
-	NATNone                   // 无 NAT（公网 IP）
-	NATFull                   // 完全锥形 NAT（Full Cone）
-	NATRestricted             // 受限锥形 NAT（Restricted Cone）
-	NATPortRestricted         // 端口受限锥形 NAT（Port Restricted Cone）
-	NATSymmetric              // 对称型 NAT（Symmetric）
-)
-
-// String 返回 NAT 类型的字符串表示
-func (t NATType) String() string {
-	switch t {
-	case NATNone:
-		return "No NAT (Public IP)"
-	case NATFull:
-		return "Full Cone NAT"
-	case NATRestricted:
-		return "Restricted Cone NAT"
-	case NATPortRestricted:
-		return "Port Restricted Cone NAT"
-	case NATSymmetric:
-		return "Symmetric NAT"
-	default:
-		return "Unknown NAT Type"
-	}
-}
-
-// ParseNATType 解析 NAT 类型字符串
-func ParseNATType(s string) NATType {
-	switch s {
-	case "No NAT (Public IP)":
-		return NATNone
-	case "Full Cone NAT":
-		return NATFull
-	case "Restricted Cone NAT":
-		return NATRestricted
-	case "Port Restricted Cone NAT":
-		return NATPortRestricted
-	case "Symmetric NAT":
-		return NATSymmetric
-	default:
-		return NATUnknown
-	}
-}
-
 // PeerInfo 对等节点信息
 type PeerInfo struct {
 	NodeID       string
-	NATType      NATType
+	NATType      protocol.NATType
 	ExternalIP   net.IP
 	ExternalPort int
 	LocalIP      net.IP
 	LocalPort    int
+	Region       string
 	LastSeen     time.Time
 }
 
-// ConnectionType 连接类型
-type ConnectionType int
-
-const (
-	ConnectionUnknown   ConnectionType = iota
-	ConnectionDirect                   // 直接连接
-	ConnectionUPnP                     // UPnP 连接
-	ConnectionHolePunch                // 打洞连接
-	ConnectionRelay                    // 中继连接
-)
-
-// String 返回连接类型的字符串表示
-func (t ConnectionType) String() string {
-	switch t {
-	case ConnectionDirect:
-		return "Direct"
-	case ConnectionUPnP:
-		return "UPnP"
-	case ConnectionHolePunch:
-		return "Hole Punch"
-	case ConnectionRelay:
-		return "Relay"
-	default:
-		return "Unknown"
-	}
-}
-
-// ParseConnectionType 解析连接类型字符串
-func ParseConnectionType(s string) ConnectionType {
-	switch s {
-	case "Direct":
-		return ConnectionDirect
-	case "UPnP":
-		return ConnectionUPnP
-	case "Hole Punch":
-		return ConnectionHolePunch
-	case "Relay":
-		return ConnectionRelay
-	default:
-		return ConnectionUnknown
-	}
-}
-
 // Coordinator P2P 协调器
 type Coordinator struct {
 	config        *config.Config
 	deviceService *device.Service
+	connections   store.ConnectionRepo
 	peers         map[string]*PeerInfo
 	relayNodes    map[string]*PeerInfo
-	mu            sync.RWMutex
+	relayTickets  *RelayTicketStore
+	relayVerifier *signing.Verifier
+	peerRegions   map[string]string
+	peerAddrs     map[string]net.IP
+	relayAssigned map[string][]time.Time
+	// 向主服务器注册的独立中继
+	standaloneRelays map[string]*standaloneRelay
+	// 正在维护的独立中继不参与中继选择，其上的会话迁移到其他中继
+	drainingRelays map[string]bool
+	relayMigrator  func(relayID string, sessions []RelaySessionInfo) int
+	// 按 NAT 类型组合的打洞统计，为 nil 时只使用内置规则
+	punches *PunchMatrix
+	// 内置中继的加密策略，分配独立中继时随配对指令下发
+	relayEncryption *RelayEncryption
+	mu              sync.RWMutex
 }
 
-// NewCoordinator 创建 P2P 协调器
-func NewCoordinator(cfg *config.Config, deviceService *device.Service) *Coordinator {
+// NewCoordinator 创建 P2P 协调器，连接记录保存在 connections 中
+func NewCoordinator(cfg *config.Config, deviceService *device.Service, connections store.ConnectionRepo) *Coordinator {
 	return &Coordinator{
 		config:        cfg,
 		deviceService: deviceService,
+		connections:   connections,
 		peers:         make(map[string]*PeerInfo),
 		relayNodes:    make(map[string]*PeerInfo),
+		relayTickets:  NewRelayTicketStore(),
+		relayVerifier: signing.NewVerifier(signing.DefaultWindow),
+		peerRegions:   make(map[string]string),
+		peerAddrs:     make(map[string]net.IP),
+		relayAssigned: make(map[string][]time.Time),
+
+		standaloneRelays: make(map[string]*standaloneRelay),
+		drainingRelays:   make(map[string]bool),
 	}
 }
 
 // RegisterPeer 注册对等节点
-func (c *Coordinator) RegisterPeer(nodeID string, natType NATType, externalIP net.IP, externalPort int, localIP net.IP, localPort int) error {
+func (c *Coordinator) RegisterPeer(nodeID string, natType protocol.NATType, externalIP net.IP, externalPort int, localIP net.IP, localPort int) error {
 	// 验证设备是否存在
 	_, err := c.deviceService.GetDeviceByNodeID(nodeID)
 	if err != nil {
@@ -152,11 +89,12 @@ func (c *Coordinator) RegisterPeer(nodeID string, natType NATType, externalIP ne
 		ExternalPort: externalPort,
 		LocalIP:      localIP,
 		LocalPort:    localPort,
+		Region:       c.regionOf(nodeID),
 		LastSeen:     time.Now(),
 	}
 
 	// 如果是公网 IP 或完全锥形 NAT，可以作为中继节点
-	if natType == NATNone || natType == NATFull {
+	if natType == protocol.NATNone || natType == protocol.NATFull {
 		c.relayNodes[nodeID] = c.peers[nodeID]
 	}
 
@@ -170,6 +108,8 @@ func (c *Coordinator) UnregisterPeer(nodeID string) {
 
 	delete(c.peers, nodeID)
 	delete(c.relayNodes, nodeID)
+	delete(c.relayAssigned, nodeID)
+	delete(c.peerAddrs, nodeID)
 }
 
 // GetPeerInfo 获取对等节点信息
@@ -212,52 +152,87 @@ func (c *Coordinator) GetRelayNodes() []*PeerInfo {
 }
 
 // SelectRelayNode 选择中继节点
+//
+// 优先选择与两端都在同一区域的中继，其次是与任一端同区域的中继，最后跨区域回退；
+// 同一优先级内选择近期负载最低的中继，已达到容量上限的中继不参与选择。
 func (c *Coordinator) SelectRelayNode(sourceNodeID, targetNodeID string) (*PeerInfo, error) {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
+	return c.selectRelayNode(sourceNodeID, targetNodeID, "")
+}
+
+// selectRelayNode 选择中继节点，exclude 不为空时不选择该中继，用于迁移会话
+func (c *Coordinator) selectRelayNode(sourceNodeID, targetNodeID, exclude string) (*PeerInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.expireRelaysLocked(now)
 
 	// 如果没有中继节点，返回错误
 	if len(c.relayNodes) == 0 {
 		return nil, errors.New("没有可用的中继节点")
 	}
 
-	// TODO: 实现更复杂的中继节点选择算法
-	// 目前简单地选择第一个中继节点
+	sourceRegion := c.regionOf(sourceNodeID)
+	targetRegion := c.regionOf(targetNodeID)
+
+	var selected *PeerInfo
+	bestRank, bestLoad := 0, 0
 	for _, node := range c.relayNodes {
 		// 不要选择源节点或目标节点作为中继
-		if node.NodeID != sourceNodeID && node.NodeID != targetNodeID {
-			return node, nil
+		if node.NodeID == sourceNodeID || node.NodeID == targetNodeID || node.NodeID == exclude {
+			continue
 		}
+
+		load := c.relayLoad(node.NodeID, now)
+		if capacity := c.relayCapacity(node.NodeID); capacity > 0 && load >= capacity {
+			continue
+		}
+
+		rank := 2
+		if node.Region == sourceRegion && node.Region == targetRegion {
+			rank = 0
+		} else if node.Region == sourceRegion || node.Region == targetRegion {
+			rank = 1
+		}
+
+		if selected == nil || rank < bestRank || (rank == bestRank && load < bestLoad) {
+			selected, bestRank, bestLoad = node, rank, load
+		}
+	}
+
+	if selected == nil {
+		return nil, errors.New("没有合适的中继节点")
 	}
 
-	return nil, errors.New("没有合适的中继节点")
+	c.relayAssigned[selected.NodeID] = append(c.relayAssigned[selected.NodeID], now)
+	return selected, nil
 }
 
 // DetermineConnectionType 确定连接类型
-func (c *Coordinator) DetermineConnectionType(sourceNodeID, targetNodeID string) (ConnectionType, error) {
+func (c *Coordinator) DetermineConnectionType(sourceNodeID, targetNodeID string) (protocol.ConnectionType, error) {
+	// 如果两个节点在同一个 NAT 之后，优先使用局域网地址连接，避免经过 NAT 回环
+	if c.SameNAT(sourceNodeID, targetNodeID) {
+		return protocol.ConnectionLAN, nil
+	}
+
 	sourcePeer, err := c.GetPeerInfo(sourceNodeID)
 	if err != nil {
-		return ConnectionUnknown, err
+		return protocol.ConnectionUnknown, err
 	}
 
 	targetPeer, err := c.GetPeerInfo(targetNodeID)
 	if err != nil {
-		return ConnectionUnknown, err
-	}
-
-	// 如果两个节点都在同一个局域网，可以直接连接
-	if sourcePeer.LocalIP.Equal(targetPeer.LocalIP) {
-		return ConnectionDirect, nil
+		return protocol.ConnectionUnknown, err
 	}
 
 	// 如果目标节点是公网 IP，可以直接连接
-	if targetPeer.NATType == NATNone {
-		return ConnectionDirect, nil
+	if targetPeer.NATType == protocol.NATNone {
+		return protocol.ConnectionDirect, nil
 	}
 
 	// 如果源节点是公网 IP，可以直接连接
-	if sourcePeer.NATType == NATNone {
-		return ConnectionDirect, nil
+	if sourcePeer.NATType == protocol.NATNone {
+		return protocol.ConnectionDirect, nil
 	}
 
 	// 如果目标节点支持 UPnP，可以使用 UPnP 连接
@@ -265,26 +240,51 @@ func (c *Coordinator) DetermineConnectionType(sourceNodeID, targetNodeID string)
 
 	// 根据 NAT 类型确定是否可以打洞
 	if c.canHolePunch(sourcePeer.NATType, targetPeer.NATType) {
-		return ConnectionHolePunch, nil
+		return protocol.ConnectionHolePunch, nil
 	}
 
 	// 如果无法打洞，使用中继连接
-	return ConnectionRelay, nil
+	return protocol.ConnectionRelay, nil
 }
 
-// canHolePunch 判断两个 NAT 类型是否可以打洞
-func (c *Coordinator) canHolePunch(sourceNAT, targetNAT NATType) bool {
-	// 如果任一节点是对称型 NAT，无法打洞
-	if sourceNAT == NATSymmetric && targetNAT == NATSymmetric {
-		return false
+// canHolePunch 判断两个 NAT 类型是否可以打洞。设置了打洞统计时，样本足够的组合按实际成功率判断
+func (c *Coordinator) canHolePunch(sourceNAT, targetNAT protocol.NATType) bool {
+	c.mu.RLock()
+	punches := c.punches
+	c.mu.RUnlock()
+
+	if punches != nil {
+		if holePunch, ok := punches.Decide(sourceNAT, targetNAT); ok {
+			return holePunch
+		}
 	}
+	return builtinHolePunch(sourceNAT, targetNAT)
+}
 
-	// 其他情况可以尝试打洞
-	return true
+// SetPunchMatrix 设置打洞统计，之后按部署环境的实际打洞结果判断连接类型
+func (c *Coordinator) SetPunchMatrix(m *PunchMatrix) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.punches = m
+}
+
+// SetRelayEncryption 设置中继加密策略，管理员切换后新分配到独立中继的会话也按该策略检查
+func (c *Coordinator) SetRelayEncryption(e *RelayEncryption) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.relayEncryption = e
+}
+
+// relayRequireE2E 是否要求中继会话使用端到端加密
+func (c *Coordinator) relayRequireE2E() bool {
+	c.mu.RLock()
+	e := c.relayEncryption
+	c.mu.RUnlock()
+	return e != nil && e.RequireE2E()
 }
 
 // RecordConnection 记录连接
-func (c *Coordinator) RecordConnection(sourceDeviceID, targetDeviceID uint, connectionType ConnectionType) error {
+func (c *Coordinator) RecordConnection(sourceDeviceID, targetDeviceID uint, connectionType protocol.ConnectionType) error {
 	// 创建连接记录
 	connection := &db.Connection{
 		SourceDeviceID: sourceDeviceID,
@@ -295,7 +295,7 @@ func (c *Coordinator) RecordConnection(sourceDeviceID, targetDeviceID uint, conn
 		LastActiveAt:   time.Now(),
 	}
 
-	if err := db.DB.Create(connection).Error; err != nil {
+	if err := c.connections.Create(connection); err != nil {
 		return fmt.Errorf("创建连接记录失败: %w", err)
 	}
 
@@ -304,8 +304,8 @@ func (c *Coordinator) RecordConnection(sourceDeviceID, targetDeviceID uint, conn
 
 // UpdateConnectionStats 更新连接统计信息
 func (c *Coordinator) UpdateConnectionStats(connectionID uint, bytesSent, bytesReceived uint64) error {
-	var connection db.Connection
-	if err := db.DB.First(&connection, connectionID).Error; err != nil {
+	connection, err := c.connections.GetByID(connectionID)
+	if err != nil {
 		return fmt.Errorf("查询连接失败: %w", err)
 	}
 
@@ -315,7 +315,7 @@ func (c *Coordinator) UpdateConnectionStats(connectionID uint, bytesSent, bytesR
 		"last_active_at": time.Now(),
 	}
 
-	if err := db.DB.Model(&connection).Updates(updates).Error; err != nil {
+	if err := c.connections.UpdateFields(connection, updates); err != nil {
 		return fmt.Errorf("更新连接统计信息失败: %w", err)
 	}
 
@@ -324,12 +324,12 @@ func (c *Coordinator) UpdateConnectionStats(connectionID uint, bytesSent, bytesR
 
 // CloseConnection 关闭连接
 func (c *Coordinator) CloseConnection(connectionID uint) error {
-	var connection db.Connection
-	if err := db.DB.First(&connection, connectionID).Error; err != nil {
+	connection, err := c.connections.GetByID(connectionID)
+	if err != nil {
 		return fmt.Errorf("查询连接失败: %w", err)
 	}
 
-	if err := db.DB.Model(&connection).Update("status", "closed").Error; err != nil {
+	if err := c.connections.UpdateFields(connection, map[string]interface{}{"status": "closed"}); err != nil {
 		return fmt.Errorf("更新连接状态失败: %w", err)
 	}
 
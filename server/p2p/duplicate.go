@@ -0,0 +1,66 @@
+package p2p
+
+import (
+	"errors"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+)
+
+// 同一节点已有信令连接时再次连接的处理方式，对应 p2p.duplicateNode 配置
+const (
+	// DuplicateNodeTakeover 新连接接管旧连接，旧连接收到 superseded 信令后关闭
+	DuplicateNodeTakeover = "takeover"
+	// DuplicateNodeReject 旧连接断开或超时前拒绝新连接
+	DuplicateNodeReject = "reject"
+)
+
+var (
+	// errSignalingStopped 信令服务器已停止，不再接受新的客户端
+	errSignalingStopped = errors.New("信令服务器已停止")
+	// errNodeConnected 节点已有信令连接，按配置拒绝新连接
+	errNodeConnected = errors.New("节点已连接")
+)
+
+// sameInstance 两个连接是否来自同一客户端进程，即客户端切换传输方式。
+// 旧版本的客户端不上报实例 ID，无法区分时视为不同的客户端
+func sameInstance(a, b *Client) bool {
+	return a.Instance != "" && a.Instance == b.Instance
+}
+
+// rejectsDuplicate 是否按配置拒绝节点的新连接 client，old 为节点现有的连接
+func (s *SignalingServer) rejectsDuplicate(old, client *Client) bool {
+	return s.config.P2P.DuplicateNode == DuplicateNodeReject && !sameInstance(old, client)
+}
+
+// duplicateRejected 节点已有连接且按配置拒绝来自 instance 的新连接时返回 true，
+// 用于在升级 WebSocket 之前以 HTTP 状态码拒绝
+func (s *SignalingServer) duplicateRejected(nodeID, instance string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	old, exists := s.clients[nodeID]
+	return exists && s.rejectsDuplicate(old, &Client{NodeID: nodeID, Instance: instance})
+}
+
+// supersede 由同一节点的新连接 client 接管旧连接 old，调用方需持有锁。
+// 旧连接未发送的信令按原优先级转给新连接；不是同一客户端切换传输方式时通知旧连接已被替换。
+// 旧连接的发送队列随后关闭，WebSocket 由写协程发送完通知后关闭，长轮询在本次轮询返回通知
+func (s *SignalingServer) supersede(old, client *Client) {
+	if moved := old.Send.MoveTo(client.Send); moved > 0 {
+		logger.Info("节点 %s 的 %d 条待发送信令已转给新连接", client.NodeID, moved)
+	}
+	if !sameInstance(old, client) {
+		logger.Warn("节点 %s 的信令连接 (%s) 被新连接 (%s) 接管", old.NodeID, old.Transport, client.Transport)
+		s.sendSignal(old, &protocol.Signal{
+			Type:       protocol.SignalSuperseded,
+			SenderID:   "server",
+			ReceiverID: old.NodeID,
+			Payload: map[string]interface{}{
+				"transport": client.Transport,
+			},
+			Timestamp: time.Now(),
+		})
+	}
+	old.Send.Close()
+}
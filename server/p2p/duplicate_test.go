@@ -0,0 +1,122 @@
+package p2p
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/server/config"
+)
+
+// pollFrom 以用户 1 的节点身份、指定的客户端实例调用长轮询接口
+func pollFrom(handler gin.HandlerFunc, nodeID, instance, method, target string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, nil)
+	c.Request.Header.Set("X-Node-Instance", instance)
+	c.Set("nodeID", nodeID)
+	c.Set("deviceID", uint(1))
+	c.Set("userID", uint(1))
+	handler(c)
+	return w
+}
+
+// pollSignals 解析长轮询响应中的信令类型
+func pollSignals(t *testing.T, w *httptest.ResponseRecorder) []protocol.SignalType {
+	t.Helper()
+	var resp struct {
+		Signals []protocol.Signal `json:"signals"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("解析轮询响应失败: %v %s", err, w.Body.String())
+	}
+	types := make([]protocol.SignalType, len(resp.Signals))
+	for i, signal := range resp.Signals {
+		types[i] = signal.Type
+	}
+	return types
+}
+
+func TestDuplicateNodeTakeover(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	s := NewSignalingServer(cfg, NewCoordinator(cfg, nil, nil), nil, nil)
+
+	pollFrom(s.HandlePoll, "node-a", "first", http.MethodGet, "/signal/poll?wait=0")
+	old := s.clients["node-a"]
+	if err := s.SendToNode("node-a", &protocol.Signal{Type: protocol.SignalOffer}); err != nil {
+		t.Fatalf("发送信令失败: %v", err)
+	}
+
+	// 另一个客户端进程接管，旧连接未发送的信令转给新连接
+	w := pollFrom(s.HandlePoll, "node-a", "second", http.MethodGet, "/signal/poll?wait=0")
+	if types := pollSignals(t, w); len(types) != 2 || types[0] != protocol.SignalOffer || types[1] != protocol.SignalPing {
+		t.Fatalf("新连接应收到转移的信令和欢迎消息: %v", types)
+	}
+	current := s.clients["node-a"]
+	if current == old || current.Instance != "second" {
+		t.Fatal("新连接应替换旧连接")
+	}
+
+	// 旧连接收到被替换的通知后关闭
+	select {
+	case <-old.Send.Done():
+	default:
+		t.Fatal("旧连接的发送队列应已关闭")
+	}
+	data, ok := old.Send.Pop()
+	signal, err := protocol.ParseSignal(data)
+	if !ok || err != nil || signal.Type != protocol.SignalSuperseded {
+		t.Fatalf("旧连接应收到 superseded 信令: %s", data)
+	}
+	if payload := signal.Payload.(map[string]interface{}); payload["transport"] != TransportPoll {
+		t.Fatalf("通知应包含新连接的传输方式: %v", payload)
+	}
+
+	// 旧客户端断开不影响新连接
+	pollFrom(s.HandlePollClose, "node-a", "first", http.MethodDelete, "/signal/poll")
+	if !s.IsClientOnline("node-a") {
+		t.Fatal("旧客户端断开后节点应保持在线")
+	}
+
+	// 同一客户端切换传输方式时不发送通知
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/ws", nil)
+	ws := &Client{NodeID: "node-a", Instance: "second", Transport: TransportWebSocket, Send: protocol.NewSendQueue(0)}
+	if err := s.registerClient(c, ws); err != nil {
+		t.Fatalf("切换传输方式失败: %v", err)
+	}
+	if data, ok := current.Send.Pop(); ok {
+		t.Fatalf("切换传输方式时不应通知旧连接: %s", data)
+	}
+}
+
+func TestDuplicateNodeReject(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	cfg.P2P.DuplicateNode = DuplicateNodeReject
+	s := NewSignalingServer(cfg, NewCoordinator(cfg, nil, nil), nil, nil)
+
+	pollFrom(s.HandlePoll, "node-a", "first", http.MethodGet, "/signal/poll?wait=0")
+	if w := pollFrom(s.HandlePoll, "node-a", "second", http.MethodGet, "/signal/poll?wait=0"); w.Code != http.StatusConflict {
+		t.Fatalf("节点已连接时应拒绝其他客户端，实际为 %d", w.Code)
+	}
+	if !s.duplicateRejected("node-a", "second") || s.duplicateRejected("node-a", "first") {
+		t.Fatal("只应拒绝其他客户端进程的 WebSocket 连接")
+	}
+	if w := pollFrom(s.HandlePoll, "node-a", "first", http.MethodGet, "/signal/poll?wait=0"); w.Code != http.StatusOK {
+		t.Fatalf("已连接的客户端继续轮询不受影响，实际为 %d", w.Code)
+	}
+	if s.clients["node-a"].Instance != "first" {
+		t.Fatal("被拒绝的连接不应替换现有连接")
+	}
+
+	// 旧连接断开后接受新连接
+	pollFrom(s.HandlePollClose, "node-a", "first", http.MethodDelete, "/signal/poll")
+	if w := pollFrom(s.HandlePoll, "node-a", "second", http.MethodGet, "/signal/poll?wait=0"); w.Code != http.StatusOK {
+		t.Fatalf("旧连接断开后应接受新连接，实际为 %d", w.Code)
+	}
+}
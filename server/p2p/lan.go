@@ -0,0 +1,79 @@
+package p2p
+
+import (
+	"net"
+	"strconv"
+)
+
+// maxLANCandidates 转发给对端的局域网候选地址数上限
+const maxLANCandidates = 8
+
+// SetPeerAddress 设置节点信令连接的来源地址，来源地址相同的节点视为在同一个 NAT 之后
+func (c *Coordinator) SetPeerAddress(nodeID string, ip net.IP) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ip == nil {
+		delete(c.peerAddrs, nodeID)
+	} else {
+		c.peerAddrs[nodeID] = ip
+	}
+}
+
+// SameNAT 判断两个节点是否在同一个 NAT 之后。优先比较信令连接的来源地址，
+// 其次比较节点上报的外部地址。结果只是推测，例如经过同一个运营商级 NAT 的节点也会判断为相同，
+// 客户端需要验证局域网地址确实可以连接
+func (c *Coordinator) SameNAT(sourceNodeID, targetNodeID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	source, target := c.externalAddrOf(sourceNodeID), c.externalAddrOf(targetNodeID)
+	return source != nil && target != nil && source.Equal(target)
+}
+
+// externalAddrOf 获取节点的外部地址，调用方需持有锁
+func (c *Coordinator) externalAddrOf(nodeID string) net.IP {
+	if ip, ok := c.peerAddrs[nodeID]; ok {
+		return ip
+	}
+	if peer, ok := c.peers[nodeID]; ok {
+		return peer.ExternalIP
+	}
+	return nil
+}
+
+// lanCandidates 从连接请求中取出局域网候选地址，只保留私有地址，避免对端被引导去连接任意主机
+func lanCandidates(payload interface{}) []string {
+	fields, ok := payload.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	values, ok := fields["localCandidates"].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	candidates := make([]string, 0, maxLANCandidates)
+	for _, v := range values {
+		if len(candidates) == maxLANCandidates {
+			break
+		}
+		addr, ok := v.(string)
+		if !ok {
+			continue
+		}
+		host, portStr, err := net.SplitHostPort(addr)
+		if err != nil {
+			continue
+		}
+		port, err := strconv.Atoi(portStr)
+		if err != nil || port <= 0 || port > 65535 {
+			continue
+		}
+		if ip := net.ParseIP(host); ip == nil || !ip.IsPrivate() {
+			continue
+		}
+		candidates = append(candidates, addr)
+	}
+	return candidates
+}
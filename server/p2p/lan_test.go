@@ -0,0 +1,52 @@
+package p2p
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/server/config"
+)
+
+func TestSameNAT(t *testing.T) {
+	c := NewCoordinator(&config.Config{}, nil, nil)
+	c.SetPeerAddress("a", net.ParseIP("203.0.113.7"))
+	c.SetPeerAddress("b", net.ParseIP("203.0.113.7"))
+	c.SetPeerAddress("c", net.ParseIP("198.51.100.2"))
+
+	if !c.SameNAT("a", "b") || c.SameNAT("a", "c") || c.SameNAT("a", "d") {
+		t.Fatalf("同一 NAT 判断不正确")
+	}
+	connType, err := c.DetermineConnectionType("a", "b")
+	if err != nil || connType != protocol.ConnectionLAN {
+		t.Fatalf("同一 NAT 之后的节点应使用局域网连接: %s %v", connType, err)
+	}
+
+	// 节点离线后不再参与判断
+	c.SetPeerAddress("b", nil)
+	if c.SameNAT("a", "b") {
+		t.Fatalf("离线节点不应判断为同一 NAT")
+	}
+}
+
+func TestLANCandidates(t *testing.T) {
+	payload := map[string]interface{}{
+		"localCandidates": []interface{}{
+			"192.168.1.10:27184",
+			"10.0.0.5:27184",
+			"8.8.8.8:27184",
+			"127.0.0.1:27184",
+			"192.168.1.10:0",
+			"192.168.1.10",
+			42,
+		},
+	}
+	want := []string{"192.168.1.10:27184", "10.0.0.5:27184"}
+	if got := lanCandidates(payload); !reflect.DeepEqual(got, want) {
+		t.Fatalf("候选地址应为 %v，实际 %v", want, got)
+	}
+	if got := lanCandidates("invalid"); got != nil {
+		t.Fatalf("无效的负载应返回空列表: %v", got)
+	}
+}
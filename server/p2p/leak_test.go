@@ -0,0 +1,148 @@
+package p2p
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/senma231/p3/server/config"
+	"go.uber.org/goleak"
+)
+
+// echoServer 启动回显服务器，返回监听地址
+func echoServer(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestRelayServerStopLeak(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	echo := echoServer(t)
+	defer echo.Close()
+
+	cfg := config.DefaultConfig()
+	cfg.Relay.Host = "127.0.0.1"
+	cfg.Relay.Port = 0
+	cfg.Relay.ResumeGrace = 0
+	store := NewRelayPairingStore()
+	s := NewRelayServer(cfg, store)
+
+	// 停止后可以重新启动
+	for round := 0; round < 2; round++ {
+		if err := s.Start(); err != nil {
+			t.Fatalf("启动中继服务器失败: %v", err)
+		}
+		addr := s.listener.Addr().String()
+
+		store.Add(RelayPairing{
+			Ticket:    "ticket",
+			NodeID:    "node-a",
+			PeerID:    "node-b",
+			PeerHost:  "127.0.0.1",
+			PeerPort:  echo.Addr().(*net.TCPAddr).Port,
+			ExpiresAt: time.Now().Add(time.Minute),
+		})
+
+		// 已建立的会话
+		session, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("连接中继失败: %v", err)
+		}
+		defer session.Close()
+		session.Write([]byte("RELAY node-b TICKET ticket"))
+		buf := make([]byte, 64)
+		if n, err := session.Read(buf); err != nil || string(buf[:n]) != "OK" {
+			t.Fatalf("中继握手失败: %q %v", buf[:n], err)
+		}
+		session.Write([]byte("ping"))
+		if n, err := io.ReadFull(session, buf[:4]); err != nil || string(buf[:n]) != "ping" {
+			t.Fatalf("中继数据错误: %q %v", buf[:n], err)
+		}
+
+		// 握手未完成的连接
+		pending, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatalf("连接中继失败: %v", err)
+		}
+		defer pending.Close()
+
+		stopped := make(chan struct{})
+		go func() {
+			s.Stop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(3 * time.Second):
+			t.Fatal("停止中继服务器超时")
+		}
+
+		session.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := session.Read(buf); err == nil {
+			t.Fatal("停止后会话应被关闭")
+		}
+	}
+}
+
+func TestSignalingServerStopLeak(t *testing.T) {
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	s := NewSignalingServer(cfg, NewCoordinator(cfg, nil, nil), nil, nil)
+	s.Start()
+
+	router := gin.New()
+	router.GET("/ws", func(c *gin.Context) {
+		c.Set("deviceID", uint(1))
+		c.Set("nodeID", c.Query("node"))
+		s.HandleWebSocket(c)
+	})
+	server := httptest.NewServer(router)
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?node="
+
+	for _, node := range []string{"node-a", "node-b"} {
+		conn, _, err := websocket.DefaultDialer.Dial(url+node, nil)
+		if err != nil {
+			t.Fatalf("连接信令服务器失败: %v", err)
+		}
+		defer conn.Close()
+		if _, _, err := conn.ReadMessage(); err != nil {
+			t.Fatalf("读取欢迎消息失败: %v", err)
+		}
+	}
+
+	s.Stop()
+	if s.GetClientCount() != 0 {
+		t.Fatal("停止后不应保留客户端")
+	}
+
+	// 停止后拒绝新的连接
+	if _, resp, err := websocket.DefaultDialer.Dial(url+"node-c", nil); err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("停止后应拒绝连接: %v", err)
+	}
+	server.CloseClientConnections()
+}
@@ -0,0 +1,177 @@
+package p2p
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+const (
+	// defaultPollWait 长轮询默认等待时间
+	defaultPollWait = 25 * time.Second
+	// maxPollWait 长轮询最长等待时间，低于常见代理的空闲超时
+	maxPollWait = 30 * time.Second
+	// pollClientTimeout 长轮询客户端超过该时间未轮询时视为离线
+	pollClientTimeout = 90 * time.Second
+	// maxPollBatch 单次轮询返回的最大信令数
+	maxPollBatch = 100
+	// maxPollSendBody 单次发送的请求体大小上限
+	maxPollSendBody = 64 * 1024
+)
+
+// HandlePoll 处理长轮询。节点未连接时注册为长轮询客户端，
+// 之后等待发往该节点的信令，有信令或超时后返回，wait 参数指定等待秒数
+func (s *SignalingServer) HandlePoll(c *gin.Context) {
+	wait, ok := pollWait(c)
+	if !ok {
+		return
+	}
+
+	client, err := s.pollClient(c)
+	if err == errNodeConnected {
+		c.JSON(http.StatusConflict, gin.H{"error": "节点已连接"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "信令服务器已停止"})
+		return
+	}
+	client.LastActive = time.Now()
+
+	// 已有排队的信令时立即返回，否则等待第一条信令
+	signals := drainSignals(client, []json.RawMessage{})
+	if len(signals) == 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-client.Send.Ready():
+			signals = drainSignals(client, signals)
+		case <-client.Send.Done():
+			// 连接被替换时返回关闭前排队的通知
+			signals = drainSignals(client, signals)
+		case <-timer.C:
+		case <-c.Request.Context().Done():
+			return
+		}
+	}
+
+	client.LastActive = time.Now()
+	c.JSON(http.StatusOK, gin.H{
+		"signals": signals,
+	})
+}
+
+// pollWait 解析长轮询的等待时间，参数无效时返回错误响应
+func pollWait(c *gin.Context) (time.Duration, bool) {
+	v := c.Query("wait")
+	if v == "" {
+		return defaultPollWait, true
+	}
+	seconds, err := strconv.Atoi(v)
+	if err != nil || seconds < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的等待时间"})
+		return 0, false
+	}
+	wait := time.Duration(seconds) * time.Second
+	if wait > maxPollWait {
+		wait = maxPollWait
+	}
+	return wait, true
+}
+
+// drainSignals 按优先级取出已排队的信令，最多 maxPollBatch 条
+func drainSignals(client *Client, signals []json.RawMessage) []json.RawMessage {
+	for len(signals) < maxPollBatch {
+		data, ok := client.Send.Pop()
+		if !ok {
+			break
+		}
+		signals = append(signals, data)
+	}
+	return signals
+}
+
+// HandlePollSend 处理长轮询客户端发送的信令，语义与通过 WebSocket 发送相同
+func (s *SignalingServer) HandlePollSend(c *gin.Context) {
+	nodeID := c.GetString("nodeID")
+	s.mu.RLock()
+	client, exists := s.clients[nodeID]
+	s.mu.RUnlock()
+	if !exists || client.Instance != c.GetHeader("X-Node-Instance") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "节点未连接，请先轮询"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxPollSendBody)
+	var req struct {
+		Signals []protocol.Signal `json:"signals"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "无效的信令消息"})
+		return
+	}
+	if len(req.Signals) > maxPollBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "单次发送的信令过多"})
+		return
+	}
+
+	for i := range req.Signals {
+		signal := &req.Signals[i]
+		signal.SenderID = client.NodeID
+		signal.Timestamp = time.Now()
+		s.handleSignal(client, signal)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"accepted": len(req.Signals),
+	})
+}
+
+// HandlePollClose 长轮询客户端主动断开
+func (s *SignalingServer) HandlePollClose(c *gin.Context) {
+	nodeID := c.GetString("nodeID")
+	s.mu.RLock()
+	client, exists := s.clients[nodeID]
+	s.mu.RUnlock()
+	// 已被其他客户端接管的连接不影响新连接
+	if exists && client.Transport == TransportPoll && client.Instance == c.GetHeader("X-Node-Instance") {
+		s.unregisterClient(client)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "已断开",
+	})
+}
+
+// pollClient 获取节点的长轮询客户端，不存在时注册。节点已通过 WebSocket 连接，
+// 或者长轮询客户端来自其他客户端进程时，按 registerClient 的规则接管或拒绝
+func (s *SignalingServer) pollClient(c *gin.Context) (*Client, error) {
+	nodeID := c.GetString("nodeID")
+	instance := c.GetHeader("X-Node-Instance")
+	s.mu.RLock()
+	client, exists := s.clients[nodeID]
+	s.mu.RUnlock()
+	if exists && client.Transport == TransportPoll && client.Instance == instance {
+		return client, nil
+	}
+
+	client = &Client{
+		NodeID:     nodeID,
+		DeviceID:   c.GetUint("deviceID"),
+		UserID:     c.GetUint("userID"),
+		Transport:  TransportPoll,
+		Instance:   instance,
+		Send:       protocol.NewSendQueue(protocol.DefaultQueueCapacity),
+		LastActive: time.Now(),
+	}
+	if err := s.registerClient(c, client); err != nil {
+		return nil, err
+	}
+	return client, nil
+}
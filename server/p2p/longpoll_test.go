@@ -0,0 +1,73 @@
+package p2p
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/server/config"
+)
+
+// pollRequest 以用户 1 的节点身份调用长轮询接口
+func pollRequest(handler gin.HandlerFunc, nodeID, method, target, body string) *httptest.ResponseRecorder {
+	return pollRequestAs(handler, 1, nodeID, method, target, body)
+}
+
+// pollRequestAs 以指定用户的节点身份调用长轮询接口
+func pollRequestAs(handler gin.HandlerFunc, userID uint, nodeID, method, target, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(method, target, strings.NewReader(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set("nodeID", nodeID)
+	c.Set("deviceID", uint(1))
+	c.Set("userID", userID)
+	handler(c)
+	return w
+}
+
+func TestLongPoll(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	s := NewSignalingServer(cfg, NewCoordinator(cfg, nil, nil), nil, nil)
+
+	// 发送前需要先轮询注册
+	if w := pollRequest(s.HandlePollSend, "node-a", http.MethodPost, "/signal/send", `{"signals":[]}`); w.Code != http.StatusNotFound {
+		t.Fatalf("未轮询的节点发送信令应返回 404，实际为 %d", w.Code)
+	}
+
+	// 第一次轮询注册客户端并收到欢迎消息
+	var resp struct {
+		Signals []protocol.Signal `json:"signals"`
+	}
+	w := pollRequest(s.HandlePoll, "node-a", http.MethodGet, "/signal/poll?wait=0", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Signals) != 1 || resp.Signals[0].Type != protocol.SignalPing {
+		t.Fatalf("第一次轮询应收到欢迎消息: %s", w.Body.String())
+	}
+	if !s.IsClientOnline("node-a") {
+		t.Fatal("轮询后节点应在线")
+	}
+	pollRequest(s.HandlePoll, "node-b", http.MethodGet, "/signal/poll?wait=0", "")
+
+	// 通过长轮询发送的信令与 WebSocket 相同，转发给接收者
+	w = pollRequest(s.HandlePollSend, "node-a", http.MethodPost, "/signal/send",
+		`{"signals":[{"type":"offer","receiverId":"node-b","payload":{"sdp":"v=0"}}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("发送信令失败: %d %s", w.Code, w.Body.String())
+	}
+	w = pollRequest(s.HandlePoll, "node-b", http.MethodGet, "/signal/poll?wait=1", "")
+	resp.Signals = nil
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Signals) != 1 ||
+		resp.Signals[0].Type != protocol.SignalOffer || resp.Signals[0].SenderID != "node-a" {
+		t.Fatalf("接收者应收到转发的信令: %s", w.Body.String())
+	}
+
+	pollRequest(s.HandlePollClose, "node-a", http.MethodDelete, "/signal/poll", "")
+	if s.IsClientOnline("node-a") {
+		t.Fatal("断开后节点应离线")
+	}
+}
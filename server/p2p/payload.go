@@ -0,0 +1,30 @@
+package p2p
+
+import (
+	"math"
+	"net"
+)
+
+// maxNATTypeLength 转发的 NAT 类型字符串长度上限
+const maxNATTypeLength = 64
+
+// peerAddress 从连接请求中取出转发给接收者的发起方地址。
+// 负载来自客户端，只转发格式有效的字段，避免将任意内容转发给其他节点
+func peerAddress(payload interface{}) map[string]interface{} {
+	address := make(map[string]interface{})
+	fields, ok := payload.(map[string]interface{})
+	if !ok {
+		return address
+	}
+
+	if natType, ok := fields["natType"].(string); ok && len(natType) <= maxNATTypeLength {
+		address["natType"] = natType
+	}
+	if ip, ok := fields["externalIP"].(string); ok && net.ParseIP(ip) != nil {
+		address["externalIP"] = ip
+	}
+	if port, ok := fields["externalPort"].(float64); ok && port == math.Trunc(port) && port > 0 && port <= 65535 {
+		address["externalPort"] = port
+	}
+	return address
+}
@@ -0,0 +1,72 @@
+package p2p
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+func TestPeerAddress(t *testing.T) {
+	address := peerAddress(map[string]interface{}{
+		"natType":      "Symmetric NAT",
+		"externalIP":   "203.0.113.7",
+		"externalPort": float64(40000),
+		"extra":        "dropped",
+	})
+	if len(address) != 3 || address["externalPort"] != float64(40000) {
+		t.Fatalf("转发的地址不正确: %v", address)
+	}
+
+	address = peerAddress(map[string]interface{}{
+		"natType":      map[string]interface{}{"nested": true},
+		"externalIP":   "example.com",
+		"externalPort": 1.5,
+	})
+	if len(address) != 0 {
+		t.Fatalf("无效字段不应转发: %v", address)
+	}
+}
+
+func FuzzConnectPayload(f *testing.F) {
+	f.Add([]byte(`{"type":"connect","receiverId":"node-b","payload":{"natType":"Full Cone NAT","externalIP":"203.0.113.7","externalPort":40000,"localCandidates":["192.168.1.5:27184","8.8.8.8:53"]}}`))
+	f.Add([]byte(`{"type":"connect","payload":{"localCandidates":[1,null,"[fd00::1]:1"]}}`))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var signal protocol.Signal
+		if err := json.Unmarshal(data, &signal); err != nil {
+			return
+		}
+
+		for key, v := range peerAddress(signal.Payload) {
+			switch key {
+			case "natType":
+				if s, ok := v.(string); !ok || len(s) > maxNATTypeLength {
+					t.Fatalf("无效的 NAT 类型: %v", v)
+				}
+			case "externalIP":
+				if s, ok := v.(string); !ok || net.ParseIP(s) == nil {
+					t.Fatalf("无效的外部地址: %v", v)
+				}
+			case "externalPort":
+				if port, ok := v.(float64); !ok || port < 1 || port > 65535 {
+					t.Fatalf("无效的外部端口: %v", v)
+				}
+			default:
+				t.Fatalf("不应转发字段 %s", key)
+			}
+		}
+
+		candidates := lanCandidates(signal.Payload)
+		if len(candidates) > maxLANCandidates {
+			t.Fatalf("候选地址过多: %d", len(candidates))
+		}
+		for _, addr := range candidates {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil || !net.ParseIP(host).IsPrivate() {
+				t.Fatalf("无效的候选地址: %q", addr)
+			}
+		}
+	})
+}
@@ -0,0 +1,175 @@
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/server/chaos"
+)
+
+// maxSubscriptions 每个节点最多订阅的节点数
+const maxSubscriptions = 256
+
+// presenceRequest 订阅和取消订阅的 payload
+type presenceRequest struct {
+	NodeIDs []string `json:"nodeIds"`
+}
+
+// subscriptions 节点之间的在线状态订阅关系，按节点 ID 记录，客户端切换传输方式时保留
+type subscriptions struct {
+	watching map[string]map[string]bool // 订阅者 -> 被订阅的节点
+	watchers map[string]map[string]bool // 被订阅的节点 -> 订阅者
+	mu       sync.Mutex
+}
+
+func newSubscriptions() *subscriptions {
+	return &subscriptions{
+		watching: make(map[string]map[string]bool),
+		watchers: make(map[string]map[string]bool),
+	}
+}
+
+// add 添加订阅，超过上限时返回错误
+func (s *subscriptions) add(subscriber string, nodeIDs []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watching := s.watching[subscriber]
+	if watching == nil {
+		watching = make(map[string]bool)
+		s.watching[subscriber] = watching
+	}
+	for _, nodeID := range nodeIDs {
+		if watching[nodeID] {
+			continue
+		}
+		if len(watching) >= maxSubscriptions {
+			return fmt.Errorf("最多订阅 %d 个节点", maxSubscriptions)
+		}
+		watching[nodeID] = true
+		if s.watchers[nodeID] == nil {
+			s.watchers[nodeID] = make(map[string]bool)
+		}
+		s.watchers[nodeID][subscriber] = true
+	}
+	return nil
+}
+
+// remove 取消订阅，nodeIDs 为空时取消该订阅者的全部订阅
+func (s *subscriptions) remove(subscriber string, nodeIDs []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	watching := s.watching[subscriber]
+	if len(nodeIDs) == 0 {
+		for nodeID := range watching {
+			nodeIDs = append(nodeIDs, nodeID)
+		}
+	}
+	for _, nodeID := range nodeIDs {
+		delete(watching, nodeID)
+		delete(s.watchers[nodeID], subscriber)
+		if len(s.watchers[nodeID]) == 0 {
+			delete(s.watchers, nodeID)
+		}
+	}
+	if len(watching) == 0 {
+		delete(s.watching, subscriber)
+	}
+}
+
+// subscribers 订阅了节点的订阅者
+func (s *subscriptions) subscribers(nodeID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	subscribers := make([]string, 0, len(s.watchers[nodeID]))
+	for subscriber := range s.watchers[nodeID] {
+		subscribers = append(subscribers, subscriber)
+	}
+	return subscribers
+}
+
+// handlePresenceSignal 处理订阅和取消订阅。订阅后立即推送各节点的当前状态
+func (s *SignalingServer) handlePresenceSignal(client *Client, signal *protocol.Signal) {
+	var req presenceRequest
+	data, _ := json.Marshal(signal.Payload)
+	if err := json.Unmarshal(data, &req); err != nil {
+		s.sendSignal(client, &protocol.Signal{
+			Type:       protocol.SignalError,
+			SenderID:   "server",
+			ReceiverID: client.NodeID,
+			Payload:    "无效的订阅请求",
+			Timestamp:  time.Now(),
+		})
+		return
+	}
+
+	if signal.Type == protocol.SignalUnsubscribe {
+		s.presence.remove(client.NodeID, req.NodeIDs)
+		return
+	}
+
+	if err := s.presence.add(client.NodeID, req.NodeIDs); err != nil {
+		s.sendSignal(client, &protocol.Signal{
+			Type:       protocol.SignalError,
+			SenderID:   "server",
+			ReceiverID: client.NodeID,
+			Payload:    err.Error(),
+			Timestamp:  time.Now(),
+		})
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, nodeID := range req.NodeIDs {
+		target, online := s.clients[nodeID]
+		s.sendPresence(client, nodeID, online && target.UserID == client.UserID)
+	}
+}
+
+// notifyPresence 通知订阅者节点上线或离线，只通知同一用户的节点
+func (s *SignalingServer) notifyPresence(node *Client, online bool) {
+	subscribers := s.presence.subscribers(node.NodeID)
+	if len(subscribers) == 0 {
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, subscriber := range subscribers {
+		if client, exists := s.clients[subscriber]; exists && client.UserID == node.UserID {
+			s.sendPresence(client, node.NodeID, online)
+		}
+	}
+}
+
+// sendPresence 向订阅者推送节点的在线状态，发送队列已满时丢弃，客户端重连后重新订阅。调用方需持有读锁
+func (s *SignalingServer) sendPresence(client *Client, nodeID string, online bool) {
+	if chaos.DropSignal() {
+		return
+	}
+	data, err := json.Marshal(&protocol.Signal{
+		Type:       protocol.SignalPresence,
+		SenderID:   "server",
+		ReceiverID: client.NodeID,
+		Payload: map[string]interface{}{
+			"nodeId": nodeID,
+			"online": online,
+		},
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		logger.Error("序列化信令消息失败: %v", err)
+		return
+	}
+
+	if !client.Send.Push(protocol.SignalPriority(protocol.SignalPresence), data) {
+		logger.Warn("节点 %s 的发送队列已满，丢弃在线状态通知", client.NodeID)
+	}
+}
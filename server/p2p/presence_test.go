@@ -0,0 +1,64 @@
+package p2p
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/server/config"
+)
+
+func TestPresence(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	s := NewSignalingServer(cfg, NewCoordinator(cfg, nil, nil), nil, nil)
+
+	// presence 取出 node-a 收到的在线状态，按节点 ID 记录
+	presence := func() map[string]bool {
+		var resp struct {
+			Signals []protocol.Signal `json:"signals"`
+		}
+		w := pollRequest(s.HandlePoll, "node-a", http.MethodGet, "/signal/poll?wait=0", "")
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("解析轮询响应失败: %v", err)
+		}
+		states := make(map[string]bool)
+		for _, signal := range resp.Signals {
+			if signal.Type == protocol.SignalPresence {
+				payload := signal.Payload.(map[string]interface{})
+				states[payload["nodeId"].(string)] = payload["online"].(bool)
+			}
+		}
+		return states
+	}
+
+	pollRequest(s.HandlePoll, "node-a", http.MethodGet, "/signal/poll?wait=0", "")
+	pollRequestAs(s.HandlePoll, 2, "node-c", http.MethodGet, "/signal/poll?wait=0", "")
+	pollRequest(s.HandlePollSend, "node-a", http.MethodPost, "/signal/send",
+		`{"signals":[{"type":"subscribe","payload":{"nodeIds":["node-b","node-c"]}}]}`)
+
+	// 订阅后立即收到当前状态，其他用户的节点始终显示离线
+	if states := presence(); len(states) != 2 || states["node-b"] || states["node-c"] {
+		t.Fatalf("订阅后应收到两个节点离线: %v", states)
+	}
+
+	pollRequest(s.HandlePoll, "node-b", http.MethodGet, "/signal/poll?wait=0", "")
+	if states := presence(); !states["node-b"] {
+		t.Fatalf("node-b 上线后应收到通知: %v", states)
+	}
+
+	pollRequest(s.HandlePollClose, "node-b", http.MethodDelete, "/signal/poll", "")
+	if states := presence(); len(states) != 1 || states["node-b"] {
+		t.Fatalf("node-b 离线后应收到通知: %v", states)
+	}
+
+	// 取消订阅后不再通知
+	pollRequest(s.HandlePollSend, "node-a", http.MethodPost, "/signal/send",
+		`{"signals":[{"type":"unsubscribe","payload":{"nodeIds":["node-b"]}}]}`)
+	pollRequest(s.HandlePoll, "node-b", http.MethodGet, "/signal/poll?wait=0", "")
+	if states := presence(); len(states) != 0 {
+		t.Fatalf("取消订阅后不应收到通知: %v", states)
+	}
+}
@@ -0,0 +1,134 @@
+package p2p
+
+import (
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// punchMatrixRefresh 打洞统计的缓存时间，判断连接类型时最多每隔该时间从数据库重新加载一次
+const punchMatrixRefresh = time.Minute
+
+// 打洞判断的依据
+const (
+	// PunchSourceStats 组合的样本足够，按实际成功率判断
+	PunchSourceStats = "stats"
+	// PunchSourceBuiltin 样本不足或未启用按统计判断，使用内置规则
+	PunchSourceBuiltin = "builtin"
+)
+
+// PunchCell 一组 NAT 类型组合的打洞统计和当前的判断。
+// 统计按发起方和对端的 NAT 类型分别记录，判断时合并两个方向
+type PunchCell struct {
+	db.PunchStat
+	SuccessRate float64 `json:"successRate"`
+	HolePunch   bool    `json:"holePunch"` // 当前是否尝试打洞，否则直接使用中继
+	Source      string  `json:"source"`    // 判断依据，见 PunchSourceStats 和 PunchSourceBuiltin
+}
+
+// PunchMatrix 按部署环境中设备上报的打洞结果判断 NAT 类型组合能否打洞
+type PunchMatrix struct {
+	config   config.PunchStatsConfig
+	repo     store.PunchStatRepo
+	cells    map[[2]string]db.PunchStat
+	loadedAt time.Time
+	mu       sync.Mutex
+}
+
+// NewPunchMatrix 创建打洞统计矩阵
+func NewPunchMatrix(cfg config.PunchStatsConfig, repo store.PunchStatRepo) *PunchMatrix {
+	return &PunchMatrix{
+		config: cfg,
+		repo:   repo,
+	}
+}
+
+// Decide 按统计判断两个 NAT 类型之间能否打洞，组合的样本不足或未启用按统计判断时 ok 为 false
+func (m *PunchMatrix) Decide(a, b protocol.NATType) (holePunch, ok bool) {
+	if !m.config.Adaptive {
+		return false, false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if time.Since(m.loadedAt) >= punchMatrixRefresh {
+		if _, err := m.loadLocked(); err != nil {
+			logger.Warn("加载打洞统计失败，继续使用上次的统计: %v", err)
+		}
+	}
+	attempts, successes := m.pairLocked(a.String(), b.String())
+	return m.decide(attempts, successes)
+}
+
+// Matrix 获取所有 NAT 类型组合的打洞统计和当前的判断
+func (m *PunchMatrix) Matrix() ([]PunchCell, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats, err := m.loadLocked()
+	if err != nil {
+		return nil, err
+	}
+
+	cells := make([]PunchCell, 0, len(stats))
+	for _, stat := range stats {
+		cell := PunchCell{PunchStat: stat, Source: PunchSourceBuiltin}
+		if stat.Attempts > 0 {
+			cell.SuccessRate = float64(stat.Successes) / float64(stat.Attempts)
+		}
+		holePunch, ok := m.decide(m.pairLocked(stat.LocalNAT, stat.RemoteNAT))
+		if ok {
+			cell.HolePunch, cell.Source = holePunch, PunchSourceStats
+		} else {
+			cell.HolePunch = builtinHolePunch(protocol.ParseNATType(stat.LocalNAT), protocol.ParseNATType(stat.RemoteNAT))
+		}
+		cells = append(cells, cell)
+	}
+	return cells, nil
+}
+
+// loadLocked 从数据库重新加载统计，调用方需持有锁
+func (m *PunchMatrix) loadLocked() ([]db.PunchStat, error) {
+	m.loadedAt = time.Now()
+	stats, err := m.repo.List()
+	if err != nil {
+		return nil, err
+	}
+	cells := make(map[[2]string]db.PunchStat, len(stats))
+	for _, stat := range stats {
+		cells[[2]string{stat.LocalNAT, stat.RemoteNAT}] = stat
+	}
+	m.cells = cells
+	return stats, nil
+}
+
+// pairLocked 合并两个方向的尝试和成功次数，调用方需持有锁
+func (m *PunchMatrix) pairLocked(a, b string) (attempts, successes uint64) {
+	forward := m.cells[[2]string{a, b}]
+	attempts, successes = forward.Attempts, forward.Successes
+	if a != b {
+		reverse := m.cells[[2]string{b, a}]
+		attempts += reverse.Attempts
+		successes += reverse.Successes
+	}
+	return attempts, successes
+}
+
+// decide 按尝试和成功次数判断能否打洞，样本不足时 ok 为 false
+func (m *PunchMatrix) decide(attempts, successes uint64) (holePunch, ok bool) {
+	if !m.config.Adaptive || attempts < uint64(m.config.MinSamples) {
+		return false, false
+	}
+	return float64(successes)/float64(attempts) >= m.config.MinSuccessRate, true
+}
+
+// builtinHolePunch 内置的打洞规则：双方都是对称型 NAT 时无法打洞，其他情况可以尝试
+func builtinHolePunch(sourceNAT, targetNAT protocol.NATType) bool {
+	return !(sourceNAT == protocol.NATSymmetric && targetNAT == protocol.NATSymmetric)
+}
@@ -0,0 +1,58 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/store"
+)
+
+func TestPunchMatrix(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.P2P.PunchStats.MinSamples = 10
+	st := store.NewMemoryStore()
+	c := NewCoordinator(cfg, nil, nil)
+	c.SetPunchMatrix(NewPunchMatrix(cfg.P2P.PunchStats, st.Punches))
+
+	symmetric, cone := protocol.NATSymmetric, protocol.NATPortRestricted
+
+	// 样本不足时使用内置规则
+	st.Punches.Add(symmetric.String(), symmetric.String(), 5, 5)
+	if c.canHolePunch(symmetric, symmetric) || !c.canHolePunch(symmetric, cone) {
+		t.Fatal("样本不足时应使用内置规则")
+	}
+
+	// 两个方向的统计合并判断，成功率低于阈值时使用中继
+	st.Punches.Add(symmetric.String(), cone.String(), 6, 1)
+	st.Punches.Add(cone.String(), symmetric.String(), 6, 0)
+	st.Punches.Add(symmetric.String(), symmetric.String(), 5, 4)
+	matrix := NewPunchMatrix(cfg.P2P.PunchStats, st.Punches)
+	c.SetPunchMatrix(matrix)
+	if c.canHolePunch(symmetric, cone) || c.canHolePunch(cone, symmetric) {
+		t.Fatal("成功率过低的组合不应打洞")
+	}
+	if !c.canHolePunch(symmetric, symmetric) {
+		t.Fatal("实际能打洞的组合应尝试打洞")
+	}
+
+	cells, err := matrix.Matrix()
+	if err != nil || len(cells) != 3 {
+		t.Fatalf("获取打洞统计失败: %v %+v", err, cells)
+	}
+	for _, cell := range cells {
+		if cell.Source != PunchSourceStats {
+			t.Fatalf("样本足够的组合应按统计判断: %+v", cell)
+		}
+		if cell.LocalNAT == symmetric.String() && cell.RemoteNAT == symmetric.String() && (!cell.HolePunch || cell.SuccessRate != 0.9) {
+			t.Fatalf("统计为 %+v", cell)
+		}
+	}
+
+	// 关闭按统计判断后只使用内置规则
+	cfg.P2P.PunchStats.Adaptive = false
+	c.SetPunchMatrix(NewPunchMatrix(cfg.P2P.PunchStats, st.Punches))
+	if !c.canHolePunch(symmetric, cone) || c.canHolePunch(symmetric, symmetric) {
+		t.Fatal("未启用按统计判断时应使用内置规则")
+	}
+}
@@ -1,48 +1,87 @@
 package p2p
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/resume"
+	"github.com/senma231/p3/common/stats"
+	"github.com/senma231/p3/server/abuse"
+	"github.com/senma231/p3/server/chaos"
 	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/shaping"
 )
 
 // RelaySession 中继会话
 type RelaySession struct {
-	ID            string
-	SourceID      string
-	TargetID      string
-	SourceConn    net.Conn
-	TargetConn    net.Conn
-	BytesSent     uint64
-	BytesReceived uint64
-	CreatedAt     time.Time
-	LastActiveAt  time.Time
-	mu            sync.Mutex
+	ID             string
+	SourceID       string
+	TargetID       string
+	Destination    string // 目标节点的地址和端口
+	SourceDeviceID uint
+	UserID         uint
+	SourceIP       net.IP // 源节点连接的来源地址
+	SourceConn     net.Conn
+	TargetConn     net.Conn
+	BytesSent      uint64
+	BytesReceived  uint64
+	CreatedAt      time.Time
+	LastActiveAt   time.Time
+	ThrottledAt    time.Time // 最近一次发送限速通知的时间
+	BandwidthLimit int       // 会话每个方向的带宽限制，单位：Mbps，0 表示不限制
+	E2E            bool      // 源节点在握手中声明会话内使用端到端加密，由客户端自行声明，中继无法验证
+	bandwidth      *shaping.LocalStore
+	// 可恢复会话的票据，SourceConn 为可恢复的会话，源节点连接中断后凭票据重连
+	ResumeTicket string
+	// 源节点已把会话迁移到其他中继，目标节点方向的数据转发完后关闭会话
+	migrating bool
+	mu        sync.Mutex
+}
+
+// RelayAuthority 验证中继握手并查询目标节点。主服务器内置的中继使用协调器，
+// 独立中继使用主服务器下发的配对指令
+type RelayAuthority interface {
+	AuthenticateRelay(handshake *RelayHandshake) (*db.Device, error)
+	GetPeerInfo(nodeID string) (*PeerInfo, error)
 }
 
 // RelayServer 中继服务器
 type RelayServer struct {
-	config     *config.Config
-	coordinator *Coordinator
-	sessions   map[string]*RelaySession
-	listener   net.Listener
-	running    bool
-	mu         sync.RWMutex
-	stopCh     chan struct{}
+	config           *config.Config
+	coordinator      RelayAuthority
+	limiter          *shaping.Limiter
+	quota            *relayQuota
+	encryption       *RelayEncryption
+	throttleNotifier func(nodeID string, notice *RelayThrottleNotice)
+	bans             *abuse.BanList // 受 mu 保护，为 nil 时不检查封禁
+	sessions         map[string]*RelaySession
+	resumable        map[string]*RelaySession // 按会话票据索引的可恢复会话
+	listener         net.Listener
+	running          bool
+	mu               sync.RWMutex
+	// cancel 在 Stop 时取消所有协程的上下文，wg 等待协程全部退出
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 // NewRelayServer 创建中继服务器
-func NewRelayServer(cfg *config.Config, coordinator *Coordinator) *RelayServer {
+func NewRelayServer(cfg *config.Config, coordinator RelayAuthority) *RelayServer {
 	return &RelayServer{
-		config:     cfg,
+		config:      cfg,
 		coordinator: coordinator,
-		sessions:   make(map[string]*RelaySession),
-		stopCh:     make(chan struct{}),
+		limiter:     newUserLimiter(cfg),
+		quota:       newRelayQuota(cfg),
+		encryption:  NewRelayEncryption(cfg.Relay.Encryption),
+		sessions:    make(map[string]*RelaySession),
+		resumable:   make(map[string]*RelaySession),
 	}
 }
 
@@ -63,99 +102,148 @@ func (s *RelayServer) Start() error {
 	}
 	s.listener = listener
 
+	// 每次启动使用新的上下文，停止后可以重新启动
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+
 	s.running = true
 	logger.Info("中继服务器已启动，监听地址: %s", addr)
 
-	// 启动接收协程
-	go s.acceptLoop()
-
-	// 启动清理协程
-	go s.cleanupLoop()
+	// 启动接收协程和清理协程
+	s.wg.Add(2)
+	go s.acceptLoop(ctx, listener)
+	go s.cleanupLoop(ctx)
 
 	return nil
 }
 
-// Stop 停止中继服务器
+// Stop 停止中继服务器，关闭所有会话并等待所有协程退出
 func (s *RelayServer) Stop() error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
 	if !s.running {
+		s.mu.Unlock()
 		return nil
 	}
 
+	// 取消上下文，握手中的连接和限速等待随之结束
+	s.cancel()
+
 	// 关闭监听器
 	if s.listener != nil {
 		s.listener.Close()
 	}
 
-	// 发送停止信号
-	close(s.stopCh)
-
 	// 关闭所有会话
 	for _, session := range s.sessions {
 		s.closeSession(session)
 	}
 
 	s.running = false
+	s.mu.Unlock()
+
+	// 中继协程退出时需要获取锁，等待前先释放
+	s.wg.Wait()
 	logger.Info("中继服务器已停止")
 	return nil
 }
 
 // acceptLoop 接受连接循环
-func (s *RelayServer) acceptLoop() {
+func (s *RelayServer) acceptLoop(ctx context.Context, listener net.Listener) {
+	defer s.wg.Done()
+
 	for {
 		// 接受连接
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			select {
-			case <-s.stopCh:
+			case <-ctx.Done():
 				return
 			default:
 				logger.Error("接受连接失败: %v", err)
-				time.Sleep(time.Second)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
 				continue
 			}
 		}
 
 		// 处理连接
-		go s.handleConnection(conn)
+		s.wg.Add(1)
+		go s.handleConnection(ctx, conn)
 	}
 }
 
 // handleConnection 处理连接
-func (s *RelayServer) handleConnection(conn net.Conn) {
-	defer conn.Close()
+func (s *RelayServer) handleConnection(ctx context.Context, conn net.Conn) {
+	defer s.wg.Done()
+
+	// 会话建立后连接由中继协程关闭
+	established := false
+	defer func() {
+		if !established {
+			conn.Close()
+		}
+	}()
+
+	// 服务器停止时中断握手
+	stopWatch := closeOnDone(ctx, conn)
+	defer stopWatch()
 
 	// 设置超时
 	conn.SetDeadline(time.Now().Add(10 * time.Second))
 
-	// 读取请求
-	buffer := make([]byte, 1024)
+	// 读取请求，使用证书认证的握手包含设备证书
+	buffer := make([]byte, maxRelayHandshake)
 	n, err := conn.Read(buffer)
 	if err != nil {
 		logger.Error("读取请求失败: %v", err)
 		return
 	}
 
-	// 解析请求
-	request := string(buffer[:n])
-	if len(request) < 7 || request[:6] != "RELAY " {
-		logger.Error("无效的请求: %s", request)
-		conn.Write([]byte("ERROR: Invalid request"))
+	// 恢复中断的会话
+	if request := string(buffer[:n]); strings.HasPrefix(request, relayResume+" ") {
+		established = s.resumeSession(conn, request)
 		return
 	}
 
-	// 提取目标节点 ID
-	targetID := request[6:]
-	if targetID == "" {
-		logger.Error("目标节点 ID 为空")
-		conn.Write([]byte("ERROR: Empty target ID"))
+	// 源节点已迁移到其他中继，结束旧会话
+	if request := string(buffer[:n]); strings.HasPrefix(request, relayMigrated+" ") {
+		s.finishMigration(ctx, conn, request)
 		return
 	}
 
-	// 获取源节点 ID（通过认证信息）
-	sourceID := "unknown" // 实际应该从认证信息中获取
+	// 解析请求
+	handshake, err := ParseRelayHandshake(string(buffer[:n]))
+	if err != nil {
+		logger.Error("无效的中继请求: %v", err)
+		if errors.Is(err, ErrRelayAuthRequired) {
+			conn.Write([]byte("ERROR: Authentication required"))
+		} else {
+			conn.Write([]byte("ERROR: Invalid request"))
+		}
+		return
+	}
+	targetID := handshake.TargetID
+
+	// 验证设备令牌或中继票据，确定源节点
+	sourceDevice, err := s.coordinator.AuthenticateRelay(handshake)
+	if err == nil {
+		// 按加密策略拒绝未声明端到端加密的会话，独立中继还按配对指令中主服务器的策略检查
+		err = s.encryption.admit(handshake)
+	}
+	if errors.Is(err, ErrRelayE2ERequired) {
+		logger.Warn("拒绝未声明端到端加密的中继会话: %s -> %s", conn.RemoteAddr(), targetID)
+		conn.Write([]byte("ERROR: End-to-end encryption required"))
+		return
+	}
+	if err != nil {
+		logger.Warn("中继认证失败: %s -> %s: %v", conn.RemoteAddr(), targetID, err)
+		conn.Write([]byte("ERROR: Authentication failed"))
+		return
+	}
+	sourceID := sourceDevice.NodeID
 
 	// 检查目标节点是否在线
 	targetPeer, err := s.coordinator.GetPeerInfo(targetID)
@@ -165,47 +253,97 @@ func (s *RelayServer) handleConnection(conn net.Conn) {
 		return
 	}
 
+	// 创建会话
+	sessionID := fmt.Sprintf("%s-%s-%d", sourceID, targetID, time.Now().UnixNano())
+	session := &RelaySession{
+		ID:             sessionID,
+		SourceID:       sourceID,
+		TargetID:       targetID,
+		Destination:    relayDestination(targetPeer),
+		SourceDeviceID: sourceDevice.ID,
+		UserID:         sourceDevice.UserID,
+		SourceIP:       remoteIP(conn),
+		SourceConn:     conn,
+		E2E:            handshake.E2E,
+		CreatedAt:      time.Now(),
+		LastActiveAt:   time.Now(),
+	}
+
+	// 被封禁的节点、来源地址和目标不能使用中继
+	if ban := s.sessionBan(session); ban != nil {
+		logger.Warn("拒绝涉及被封禁对象的中继会话: %s (%s) -> %s: %s", sourceID, conn.RemoteAddr(), targetID, ban.Reason)
+		conn.Write([]byte("ERROR: Banned"))
+		return
+	}
+
+	// 检查设备的并发会话数和新建会话速率
+	if err := s.admitSession(session); err != nil {
+		conn.Write([]byte("ERROR: Too many sessions"))
+		return
+	}
+	defer func() {
+		if !established {
+			s.quota.release(session)
+		}
+	}()
+
 	// 连接到目标节点
-	targetAddr := fmt.Sprintf("%s:%d", targetPeer.ExternalIP.String(), targetPeer.ExternalPort)
-	targetConn, err := net.DialTimeout("tcp", targetAddr, 5*time.Second)
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	targetConn, err := dialer.DialContext(ctx, "tcp", session.Destination)
 	if err != nil {
 		logger.Error("连接目标节点失败: %v", err)
 		conn.Write([]byte("ERROR: Failed to connect to target node"))
 		return
 	}
+	session.TargetConn = targetConn
 
-	// 创建会话
-	sessionID := fmt.Sprintf("%s-%s-%d", sourceID, targetID, time.Now().UnixNano())
-	session := &RelaySession{
-		ID:            sessionID,
-		SourceID:      sourceID,
-		TargetID:      targetID,
-		SourceConn:    conn,
-		TargetConn:    targetConn,
-		CreatedAt:     time.Now(),
-		LastActiveAt:  time.Now(),
+	// 发送成功响应。请求可恢复的会话时响应以换行结尾，并附带会话票据和等待恢复的时间
+	response := "OK"
+	if handshake.Resumable {
+		response, err = s.makeResumable(session)
+		if err != nil {
+			logger.Error("创建可恢复会话失败: %v", err)
+			targetConn.Close()
+			conn.Write([]byte("ERROR: Internal error"))
+			return
+		}
 	}
 
-	// 添加会话
+	// 添加会话。服务器已停止时不再添加，Stop 只关闭已添加的会话
 	s.mu.Lock()
+	if ctx.Err() != nil {
+		s.mu.Unlock()
+		targetConn.Close()
+		return
+	}
 	s.sessions[sessionID] = session
+	if session.ResumeTicket != "" {
+		s.resumable[session.ResumeTicket] = session
+	}
+	// 在锁内登记中继协程，保证 Stop 等待时已计入
+	s.wg.Add(1)
 	s.mu.Unlock()
 
-	// 发送成功响应
-	conn.Write([]byte("OK"))
+	conn.Write([]byte(response))
 
 	// 清除超时
 	conn.SetDeadline(time.Time{})
 	targetConn.SetDeadline(time.Time{})
+	if src, ok := session.SourceConn.(*resume.Session); ok {
+		src.Attach(conn, 0)
+	}
+	established = true
 
 	// 启动中继
-	go s.relay(session)
+	go s.relay(ctx, session)
 
-	logger.Info("中继会话已创建: %s -> %s", sourceID, targetID)
+	logger.Info("中继会话已创建: %s -> %s (设备 %d, 用户 %d)", sourceID, targetID, sourceDevice.ID, sourceDevice.UserID)
 }
 
 // relay 中继数据
-func (s *RelayServer) relay(session *RelaySession) {
+func (s *RelayServer) relay(ctx context.Context, session *RelaySession) {
+	defer s.wg.Done()
+
 	// 创建同步组
 	var wg sync.WaitGroup
 	wg.Add(2)
@@ -213,13 +351,17 @@ func (s *RelayServer) relay(session *RelaySession) {
 	// 源 -> 目标
 	go func() {
 		defer wg.Done()
-		s.copyData(session, session.TargetConn, session.SourceConn)
+		s.copyData(ctx, session, session.TargetConn, session.SourceConn)
 	}()
 
-	// 目标 -> 源
+	// 目标 -> 源。会话已迁移时目标连接由 finishMigration 关闭，随后关闭源连接，
+	// 可恢复会话先发送完剩余数据再通知源节点结束
 	go func() {
 		defer wg.Done()
-		s.copyData(session, session.SourceConn, session.TargetConn)
+		s.copyData(ctx, session, session.SourceConn, session.TargetConn)
+		if session.isMigrating() {
+			session.SourceConn.Close()
+		}
 	}()
 
 	// 等待两个方向的数据传输完成
@@ -228,15 +370,20 @@ func (s *RelayServer) relay(session *RelaySession) {
 	// 关闭会话
 	s.mu.Lock()
 	delete(s.sessions, session.ID)
+	if session.ResumeTicket != "" {
+		delete(s.resumable, session.ResumeTicket)
+	}
 	s.mu.Unlock()
 
 	s.closeSession(session)
+	s.quota.release(session)
 	logger.Info("中继会话已关闭: %s -> %s", session.SourceID, session.TargetID)
 }
 
 // copyData 复制数据
-func (s *RelayServer) copyData(session *RelaySession, dst, src net.Conn) {
+func (s *RelayServer) copyData(ctx context.Context, session *RelaySession, dst, src net.Conn) {
 	buffer := make([]byte, 4096)
+	verify := s.encryption.verify(session)
 	for {
 		// 读取数据
 		n, err := src.Read(buffer)
@@ -247,6 +394,26 @@ func (s *RelayServer) copyData(session *RelaySession, dst, src net.Conn) {
 			break
 		}
 
+		// 声明端到端加密的会话只转发密文，首个数据块不是 TLS 记录时断开
+		if verify {
+			verify = false
+			if !isTLSRecord(buffer[:n]) {
+				logger.Warn("中继会话 %s 声明了端到端加密，但转发的数据不是 TLS 记录，断开会话", session.ID)
+				s.closeSession(session)
+				break
+			}
+		}
+
+		// 按用户带宽限制等待
+		if !s.throttle(ctx, session, src == session.SourceConn, n) {
+			break
+		}
+
+		// 故障注入时延迟写入
+		if !chaos.DelayRelayWrite(ctx) {
+			break
+		}
+
 		// 写入数据
 		_, err = dst.Write(buffer[:n])
 		if err != nil {
@@ -257,9 +424,9 @@ func (s *RelayServer) copyData(session *RelaySession, dst, src net.Conn) {
 		// 更新统计信息
 		session.mu.Lock()
 		if src == session.SourceConn {
-			session.BytesSent += uint64(n)
+			session.BytesSent = stats.Add(session.BytesSent, uint64(n))
 		} else {
-			session.BytesReceived += uint64(n)
+			session.BytesReceived = stats.Add(session.BytesReceived, uint64(n))
 		}
 		session.LastActiveAt = time.Now()
 		session.mu.Unlock()
@@ -276,17 +443,33 @@ func (s *RelayServer) closeSession(session *RelaySession) {
 	}
 }
 
+// closeOnDone 在上下文取消时关闭连接，中断阻塞的读写。返回的函数停止监视
+func closeOnDone(ctx context.Context, conn io.Closer) func() {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
 // cleanupLoop 清理循环
-func (s *RelayServer) cleanupLoop() {
+func (s *RelayServer) cleanupLoop(ctx context.Context) {
+	defer s.wg.Done()
+
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-s.stopCh:
+		case <-ctx.Done():
 			return
 		case <-ticker.C:
 			s.cleanupInactiveSessions()
+			s.quota.cleanup()
 		}
 	}
 }
@@ -306,10 +489,20 @@ func (s *RelayServer) cleanupInactiveSessions() {
 			logger.Info("清理不活跃的会话: %s", id)
 			s.closeSession(session)
 			delete(s.sessions, id)
+			if session.ResumeTicket != "" {
+				delete(s.resumable, session.ResumeTicket)
+			}
 		}
 	}
 }
 
+// IsRunning 检查中继服务器是否正在运行
+func (s *RelayServer) IsRunning() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.running
+}
+
 // GetSessionCount 获取会话数量
 func (s *RelayServer) GetSessionCount() int {
 	s.mu.RLock()
@@ -325,8 +518,8 @@ func (s *RelayServer) GetTotalBytesTransferred() (uint64, uint64) {
 	var totalSent, totalReceived uint64
 	for _, session := range s.sessions {
 		session.mu.Lock()
-		totalSent += session.BytesSent
-		totalReceived += session.BytesReceived
+		totalSent = stats.Add(totalSent, session.BytesSent)
+		totalReceived = stats.Add(totalReceived, session.BytesReceived)
 		session.mu.Unlock()
 	}
 
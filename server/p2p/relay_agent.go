@@ -0,0 +1,325 @@
+package p2p
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
+	"gorm.io/gorm"
+)
+
+const (
+	// relayPairingWait 客户端先于配对指令到达时等待指令的最长时间
+	relayPairingWait = 5 * time.Second
+	// relayAgentRetry 请求主服务器失败后的重试间隔
+	relayAgentRetry = 5 * time.Second
+)
+
+// RelayPairingStore 独立中继收到的配对指令，按票据验证客户端
+type RelayPairingStore struct {
+	pairings map[string]*RelayPairing
+	peers    map[string]*PeerInfo // 最近验证的票据对应的对端地址
+	changed  chan struct{}        // 收到新指令时关闭并替换
+	mu       sync.Mutex
+}
+
+// NewRelayPairingStore 创建配对指令存储
+func NewRelayPairingStore() *RelayPairingStore {
+	return &RelayPairingStore{
+		pairings: make(map[string]*RelayPairing),
+		peers:    make(map[string]*PeerInfo),
+		changed:  make(chan struct{}),
+	}
+}
+
+// Add 添加配对指令
+func (s *RelayPairingStore) Add(pairing RelayPairing) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for ticket, p := range s.pairings {
+		if now.After(p.ExpiresAt) {
+			delete(s.pairings, ticket)
+		}
+	}
+	for nodeID, peer := range s.peers {
+		if now.Sub(peer.LastSeen) > relayTicketTTL {
+			delete(s.peers, nodeID)
+		}
+	}
+
+	s.pairings[pairing.Ticket] = &pairing
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// AuthenticateRelay 按配对指令验证一次性票据，独立中继不支持设备令牌认证
+func (s *RelayPairingStore) AuthenticateRelay(handshake *RelayHandshake) (*db.Device, error) {
+	if handshake.AuthType != relayAuthTicket {
+		return nil, ErrRelayAuthFailed
+	}
+
+	pairing := s.redeem(handshake.Ticket)
+	if pairing == nil || time.Now().After(pairing.ExpiresAt) || pairing.PeerID != handshake.TargetID {
+		return nil, ErrRelayAuthFailed
+	}
+	if pairing.RequireE2E && !handshake.E2E {
+		return nil, ErrRelayE2ERequired
+	}
+
+	s.mu.Lock()
+	s.peers[pairing.PeerID] = &PeerInfo{
+		NodeID:       pairing.PeerID,
+		ExternalIP:   net.ParseIP(pairing.PeerHost),
+		ExternalPort: pairing.PeerPort,
+		LastSeen:     time.Now(),
+	}
+	s.mu.Unlock()
+
+	return &db.Device{
+		Model:  gorm.Model{ID: pairing.DeviceID},
+		UserID: pairing.UserID,
+		NodeID: pairing.NodeID,
+	}, nil
+}
+
+// redeem 取出票据对应的配对指令，指令尚未到达时最多等待 relayPairingWait
+func (s *RelayPairingStore) redeem(ticket string) *RelayPairing {
+	timer := time.NewTimer(relayPairingWait)
+	defer timer.Stop()
+
+	for {
+		s.mu.Lock()
+		pairing, ok := s.pairings[ticket]
+		if ok {
+			delete(s.pairings, ticket)
+			s.mu.Unlock()
+			return pairing
+		}
+		changed := s.changed
+		s.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return nil
+		}
+	}
+}
+
+// GetPeerInfo 获取票据验证时记录的对端地址
+func (s *RelayPairingStore) GetPeerInfo(nodeID string) (*PeerInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	peer, ok := s.peers[nodeID]
+	if !ok || peer.ExternalIP == nil {
+		return nil, errors.New("对等节点不存在")
+	}
+	return peer, nil
+}
+
+// RelayAgent 独立中继：运行中继服务，向主服务器注册并定期上报容量和健康状态，
+// 通过长轮询接收主服务器下发的配对指令
+type RelayAgent struct {
+	config   *config.Config
+	server   *RelayServer
+	pairings *RelayPairingStore
+	client   *http.Client
+	ctx      context.Context
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// NewRelayAgent 创建独立中继
+func NewRelayAgent(cfg *config.Config) *RelayAgent {
+	pairings := NewRelayPairingStore()
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RelayAgent{
+		config:   cfg,
+		server:   NewRelayServer(cfg, pairings),
+		pairings: pairings,
+		client:   &http.Client{Timeout: maxPollWait + 10*time.Second},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start 启动中继服务并向主服务器注册，注册失败时在心跳中重试
+func (a *RelayAgent) Start() error {
+	if err := a.server.Start(); err != nil {
+		return err
+	}
+
+	if err := a.register(); err != nil {
+		logger.Warn("向主服务器注册中继失败，稍后重试: %v", err)
+	}
+
+	a.wg.Add(2)
+	go a.heartbeatLoop()
+	go a.pairingLoop()
+	return nil
+}
+
+// Stop 从主服务器注销并停止中继服务
+func (a *RelayAgent) Stop() error {
+	a.cancel()
+	a.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	query := url.Values{"relayId": {a.config.Relay.Agent.ID}}
+	if err := a.do(ctx, http.MethodDelete, "/register?"+query.Encode(), nil, nil); err != nil {
+		logger.Warn("从主服务器注销中继失败: %v", err)
+	}
+
+	return a.server.Stop()
+}
+
+// registration 当前的注册信息
+func (a *RelayAgent) registration() *RelayRegistration {
+	agent := a.config.Relay.Agent
+	port := agent.PublicPort
+	if port == 0 {
+		port = a.config.Relay.Port
+	}
+	sent, received := a.server.GetTotalBytesTransferred()
+
+	return &RelayRegistration{
+		RelayID:       agent.ID,
+		Host:          agent.PublicHost,
+		Port:          port,
+		Region:        a.config.Relay.Region,
+		Capacity:      a.config.Relay.MaxClients,
+		Bandwidth:     a.config.Relay.MaxBandwidth,
+		Sessions:      a.server.GetSessionCount(),
+		BytesSent:     sent,
+		BytesReceived: received,
+		Healthy:       a.server.IsRunning(),
+		// 主服务器据此在维护或负载过高时迁移会话
+		ActiveSessions: a.server.ActiveSessions(),
+	}
+}
+
+// register 向主服务器注册
+func (a *RelayAgent) register() error {
+	if err := a.do(a.ctx, http.MethodPost, "/register", a.registration(), nil); err != nil {
+		return err
+	}
+	logger.Info("已向主服务器注册中继: %s", a.config.Relay.Agent.ID)
+	return nil
+}
+
+// heartbeatLoop 定期上报负载和健康状态，主服务器未注册该中继时重新注册
+func (a *RelayAgent) heartbeatLoop() {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(relayHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-a.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := a.do(a.ctx, http.MethodPost, "/heartbeat", a.registration(), nil)
+		if errors.Is(err, ErrRelayNotRegistered) {
+			err = a.register()
+		}
+		if err != nil && a.ctx.Err() == nil {
+			logger.Warn("上报中继心跳失败: %v", err)
+		}
+	}
+}
+
+// pairingLoop 长轮询接收配对指令
+func (a *RelayAgent) pairingLoop() {
+	defer a.wg.Done()
+
+	query := url.Values{
+		"relayId": {a.config.Relay.Agent.ID},
+		"wait":    {fmt.Sprint(int(defaultPollWait / time.Second))},
+	}
+	for a.ctx.Err() == nil {
+		var resp struct {
+			Pairings []RelayPairing `json:"pairings"`
+		}
+		if err := a.do(a.ctx, http.MethodGet, "/pairings?"+query.Encode(), nil, &resp); err != nil {
+			if a.ctx.Err() != nil {
+				return
+			}
+			if !errors.Is(err, ErrRelayNotRegistered) {
+				logger.Warn("获取中继配对指令失败: %v", err)
+			}
+			select {
+			case <-a.ctx.Done():
+				return
+			case <-time.After(relayAgentRetry):
+			}
+			continue
+		}
+
+		for _, pairing := range resp.Pairings {
+			a.pairings.Add(pairing)
+		}
+	}
+}
+
+// do 请求主服务器的独立中继接口，404 表示中继未注册
+func (a *RelayAgent) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	endpoint := strings.TrimRight(a.config.Relay.Agent.ServerURL, "/") + "/api/v1/relay/agent" + path
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(relaySecretHeader, a.config.Relay.RegistrationSecret)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrRelayNotRegistered
+	}
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&errResp)
+		return fmt.Errorf("主服务器返回 %d: %s", resp.StatusCode, errResp.Error)
+	}
+
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+)
+
+// relayTicketTTL 中继票据有效期
+const relayTicketTTL = 30 * time.Second
+
+// maxRelayHandshake 中继握手请求的最大长度
+const maxRelayHandshake = 4096
+
+// 中继握手认证方式。设备令牌不在中继握手中明文发送，节点使用信令服务器签发的一次性票据或设备证书
+const (
+	relayAuthTicket = "TICKET"
+	relayAuthCert   = "CERT"
+)
+
+// RelayCertificateMethod 使用设备证书进行中继握手时签名内容中的方法，路径为目标节点 ID
+const RelayCertificateMethod = "RELAY"
+
+// 会话恢复相关的握手标记
+const (
+	relayResumable = "RESUMABLE"
+	relayResume    = "RESUME"
+	relayMigrated  = "MIGRATED"
+)
+
+// relayE2E 握手标记，客户端声明双方在会话内使用端到端加密，中继只转发密文，无法验证声明是否属实
+const relayE2E = "E2E"
+
+var (
+	// ErrRelayAuthRequired 中继握手缺少认证信息
+	ErrRelayAuthRequired = errors.New("中继握手缺少认证信息")
+	// ErrRelayAuthFailed 中继认证失败
+	ErrRelayAuthFailed = errors.New("中继认证失败")
+)
+
+// RelayHandshake 中继握手请求
+//
+// 支持两种格式，末尾带 RESUMABLE 时请求可恢复的会话，带 E2E 时声明会话内使用端到端加密，两者顺序不限：
+//
+//	RELAY <targetID> TICKET <ticket> [E2E] [RESUMABLE]
+//	RELAY <targetID> CERT <certificate> <timestamp> <nonce> <signature> [E2E] [RESUMABLE]
+//
+// CERT 方式中 certificate 为 DER 编码设备证书的 Base64，signature 为证书私钥对
+// RelayCertificateMethod、目标节点 ID、时间戳和随机数的签名
+type RelayHandshake struct {
+	TargetID    string
+	AuthType    string
+	Ticket      string
+	Certificate []byte
+	Timestamp   int64
+	Nonce       string
+	Signature   string
+	Resumable   bool
+	E2E         bool
+}
+
+// ParseRelayHandshake 解析中继握手请求
+func ParseRelayHandshake(request string) (*RelayHandshake, error) {
+	fields := strings.Fields(request)
+	if len(fields) < 2 || fields[0] != "RELAY" {
+		return nil, fmt.Errorf("无效的中继请求")
+	}
+
+	handshake := &RelayHandshake{TargetID: fields[1]}
+flags:
+	for len(fields) > 2 {
+		switch fields[len(fields)-1] {
+		case relayResumable:
+			handshake.Resumable = true
+		case relayE2E:
+			handshake.E2E = true
+		default:
+			break flags
+		}
+		fields = fields[:len(fields)-1]
+	}
+	if len(fields) < 3 {
+		return nil, ErrRelayAuthRequired
+	}
+
+	handshake.AuthType = fields[2]
+	switch handshake.AuthType {
+	case relayAuthTicket:
+		if len(fields) != 4 {
+			return nil, ErrRelayAuthRequired
+		}
+		handshake.Ticket = fields[3]
+	case relayAuthCert:
+		if len(fields) != 7 {
+			return nil, ErrRelayAuthRequired
+		}
+		cert, err := base64.StdEncoding.DecodeString(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("无效的设备证书")
+		}
+		timestamp, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("无效的时间戳: %s", fields[4])
+		}
+		handshake.Certificate = cert
+		handshake.Timestamp = timestamp
+		handshake.Nonce = fields[5]
+		handshake.Signature = fields[6]
+	default:
+		return nil, fmt.Errorf("不支持的中继认证方式: %s", handshake.AuthType)
+	}
+
+	return handshake, nil
+}
+
+// ParseRelayResume 解析恢复中继会话的请求：
+//
+//	RESUME <sessionTicket> <received>
+//
+// received 为客户端已从中继接收的字节数
+func ParseRelayResume(request string) (ticket string, received uint64, err error) {
+	fields := strings.Fields(request)
+	if len(fields) != 3 || fields[0] != relayResume {
+		return "", 0, fmt.Errorf("无效的会话恢复请求")
+	}
+	received, err = strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("无效的接收位置: %s", fields[2])
+	}
+	return fields[1], received, nil
+}
+
+// ParseRelayMigrated 解析源节点把会话迁移到新中继后结束旧会话的请求：
+//
+//	MIGRATED <sessionTicket> <sent>
+//
+// sent 为客户端通过旧会话发送的总字节数
+func ParseRelayMigrated(request string) (ticket string, sent uint64, err error) {
+	fields := strings.Fields(request)
+	if len(fields) != 3 || fields[0] != relayMigrated {
+		return "", 0, fmt.Errorf("无效的会话迁移请求")
+	}
+	sent, err = strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("无效的发送位置: %s", fields[2])
+	}
+	return fields[1], sent, nil
+}
+
+// relayTicket 一次性中继票据
+type relayTicket struct {
+	nodeID    string
+	peerID    string
+	expiresAt time.Time
+}
+
+// RelayTicketStore 一次性中继票据存储
+type RelayTicketStore struct {
+	tickets map[string]*relayTicket
+	mu      sync.Mutex
+}
+
+// NewRelayTicketStore 创建中继票据存储
+func NewRelayTicketStore() *RelayTicketStore {
+	return &RelayTicketStore{
+		tickets: make(map[string]*relayTicket),
+	}
+}
+
+// Issue 为节点签发连接指定对端的一次性票据
+func (s *RelayTicketStore) Issue(nodeID, peerID string) (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成中继票据失败: %w", err)
+	}
+	ticket := hex.EncodeToString(buf)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.purgeLocked(time.Now())
+	s.tickets[ticket] = &relayTicket{
+		nodeID:    nodeID,
+		peerID:    peerID,
+		expiresAt: time.Now().Add(relayTicketTTL),
+	}
+
+	return ticket, nil
+}
+
+// Redeem 兑换票据，返回持有者节点 ID；票据只能使用一次
+func (s *RelayTicketStore) Redeem(ticket, peerID string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, exists := s.tickets[ticket]
+	if !exists {
+		return "", ErrRelayAuthFailed
+	}
+	delete(s.tickets, ticket)
+
+	if time.Now().After(t.expiresAt) || t.peerID != peerID {
+		return "", ErrRelayAuthFailed
+	}
+
+	return t.nodeID, nil
+}
+
+// purgeLocked 清理过期票据，调用方需持有锁
+func (s *RelayTicketStore) purgeLocked(now time.Time) {
+	for ticket, t := range s.tickets {
+		if now.After(t.expiresAt) {
+			delete(s.tickets, ticket)
+		}
+	}
+}
+
+// IssueRelayTicket 为节点签发连接对端的一次性中继票据
+func (c *Coordinator) IssueRelayTicket(nodeID, peerID string) (string, error) {
+	return c.relayTickets.Issue(nodeID, peerID)
+}
+
+// AuthenticateRelay 验证中继握手并返回发起方设备
+func (c *Coordinator) AuthenticateRelay(handshake *RelayHandshake) (*db.Device, error) {
+	if handshake.AuthType == relayAuthCert {
+		device, err := c.deviceService.AuthenticateSignature(handshake.Certificate, RelayCertificateMethod,
+			handshake.TargetID, handshake.Timestamp, handshake.Nonce, handshake.Signature, c.relayVerifier)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrRelayAuthFailed, err)
+		}
+		return device, nil
+	}
+
+	if handshake.AuthType != relayAuthTicket {
+		return nil, ErrRelayAuthFailed
+	}
+	nodeID, err := c.relayTickets.Redeem(handshake.Ticket, handshake.TargetID)
+	if err != nil {
+		return nil, err
+	}
+
+	device, err := c.deviceService.GetDeviceByNodeID(nodeID)
+	if err != nil {
+		return nil, ErrRelayAuthFailed
+	}
+
+	return device, nil
+}
@@ -0,0 +1,113 @@
+package p2p
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseRelayHandshake(t *testing.T) {
+	tests := map[string]*RelayHandshake{
+		"RELAY node-b TICKET abcd RESUMABLE":     {TargetID: "node-b", AuthType: relayAuthTicket, Ticket: "abcd", Resumable: true},
+		"RELAY node-b TICKET abcd E2E RESUMABLE": {TargetID: "node-b", AuthType: relayAuthTicket, Ticket: "abcd", Resumable: true, E2E: true},
+		"RELAY node-b TICKET abcd RESUMABLE E2E": {TargetID: "node-b", AuthType: relayAuthTicket, Ticket: "abcd", Resumable: true, E2E: true},
+		"RELAY node-b CERT AQID 1700000000 n1 sig": {TargetID: "node-b", AuthType: relayAuthCert, Certificate: []byte{1, 2, 3},
+			Timestamp: 1700000000, Nonce: "n1", Signature: "sig"},
+	}
+	for request, want := range tests {
+		got, err := ParseRelayHandshake(request)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: 解析结果为 %+v, %v", request, got, err)
+		}
+	}
+
+	for _, request := range []string{"RELAY node-b RESUMABLE", "RELAY node-b E2E", "RELAY node-b TICKET RESUMABLE", "RESUME abcd 0",
+		"RELAY node-b CERT AQID 1700000000 n1", "RELAY node-b CERT !!! 1700000000 n1 sig",
+		// 不再接受明文发送设备令牌的握手
+		"RELAY node-b TOKEN node-a secret", "RELAY node-b TOKEN node-a secret RESUMABLE"} {
+		if _, err := ParseRelayHandshake(request); err == nil {
+			t.Errorf("%q: 应解析失败", request)
+		}
+	}
+}
+
+func TestParseRelayResume(t *testing.T) {
+	ticket, received, err := ParseRelayResume("RESUME abcd 1024\n")
+	if err != nil || ticket != "abcd" || received != 1024 {
+		t.Fatalf("解析结果为 %q %d %v", ticket, received, err)
+	}
+
+	for _, request := range []string{"RESUME abcd", "RESUME abcd -1", "RESUME abcd 1 2", "RELAY abcd 1"} {
+		if _, _, err := ParseRelayResume(request); err == nil {
+			t.Errorf("%q: 应解析失败", request)
+		}
+	}
+}
+
+func TestParseRelayMigrated(t *testing.T) {
+	ticket, sent, err := ParseRelayMigrated("MIGRATED abcd 2048\n")
+	if err != nil || ticket != "abcd" || sent != 2048 {
+		t.Fatalf("解析结果为 %q %d %v", ticket, sent, err)
+	}
+
+	for _, request := range []string{"MIGRATED abcd", "MIGRATED abcd -1", "RESUME abcd 1"} {
+		if _, _, err := ParseRelayMigrated(request); err == nil {
+			t.Errorf("%q: 应解析失败", request)
+		}
+	}
+}
+
+func TestRelayTicketStore(t *testing.T) {
+	s := NewRelayTicketStore()
+
+	ticket, err := s.Issue("node-a", "node-b")
+	if err != nil {
+		t.Fatalf("签发票据失败: %v", err)
+	}
+	other, _ := s.Issue("node-a", "node-b")
+	if other == ticket {
+		t.Fatal("每次签发的票据应不同")
+	}
+
+	// 票据只能使用一次
+	if nodeID, err := s.Redeem(ticket, "node-b"); err != nil || nodeID != "node-a" {
+		t.Fatalf("兑换票据返回 %q, %v", nodeID, err)
+	}
+	if _, err := s.Redeem(ticket, "node-b"); !errors.Is(err, ErrRelayAuthFailed) {
+		t.Fatalf("重复使用票据应失败, 实际为 %v", err)
+	}
+
+	// 目标不符时拒绝，票据同时作废
+	if _, err := s.Redeem(other, "node-c"); !errors.Is(err, ErrRelayAuthFailed) {
+		t.Fatalf("目标不符时应失败, 实际为 %v", err)
+	}
+	if _, err := s.Redeem(other, "node-b"); !errors.Is(err, ErrRelayAuthFailed) {
+		t.Fatalf("目标不符后票据应作废, 实际为 %v", err)
+	}
+
+	// 过期的票据不能兑换，签发新票据时清理
+	expired, _ := s.Issue("node-a", "node-b")
+	s.mu.Lock()
+	s.tickets[expired].expiresAt = time.Now().Add(-time.Second)
+	s.mu.Unlock()
+	if _, err := s.Redeem(expired, "node-b"); !errors.Is(err, ErrRelayAuthFailed) {
+		t.Fatalf("过期票据应失败, 实际为 %v", err)
+	}
+
+	stale, _ := s.Issue("node-a", "node-b")
+	s.mu.Lock()
+	s.tickets[stale].expiresAt = time.Now().Add(-time.Second)
+	s.mu.Unlock()
+	s.Issue("node-a", "node-b")
+	s.mu.Lock()
+	_, exists := s.tickets[stale]
+	s.mu.Unlock()
+	if exists {
+		t.Fatal("签发票据时应清理过期票据")
+	}
+
+	if _, err := s.Redeem("unknown", "node-b"); !errors.Is(err, ErrRelayAuthFailed) {
+		t.Fatalf("未知票据应失败, 实际为 %v", err)
+	}
+}
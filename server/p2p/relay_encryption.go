@@ -0,0 +1,124 @@
+package p2p
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"github.com/senma231/p3/server/config"
+)
+
+// ErrRelayE2ERequired 按中继加密策略拒绝未声明端到端加密的会话
+var ErrRelayE2ERequired = errors.New("中继要求端到端加密")
+
+// RelayEncryption 中继会话的端到端加密策略。中继只转发会话数据，不持有双方的密钥，
+// 策略只决定是否接受未加密的会话以及是否检查声明加密的会话确实在传输密文。
+// 主服务器内置的中继按该策略检查，分配到独立中继的会话由配对指令携带 requireE2E
+type RelayEncryption struct {
+	requireE2E       atomic.Bool
+	verifyCiphertext bool
+}
+
+// NewRelayEncryption 按配置创建中继加密策略
+func NewRelayEncryption(cfg config.RelayEncryptionConfig) *RelayEncryption {
+	e := &RelayEncryption{verifyCiphertext: cfg.VerifyCiphertext}
+	e.requireE2E.Store(cfg.RequireE2E)
+	return e
+}
+
+// RequireE2E 是否拒绝未声明端到端加密的会话
+func (e *RelayEncryption) RequireE2E() bool {
+	return e.requireE2E.Load()
+}
+
+// SetRequireE2E 在运行时切换是否拒绝未声明端到端加密的会话，只影响之后建立的会话
+func (e *RelayEncryption) SetRequireE2E(require bool) {
+	e.requireE2E.Store(require)
+}
+
+// Config 当前的加密策略
+func (e *RelayEncryption) Config() config.RelayEncryptionConfig {
+	return config.RelayEncryptionConfig{
+		RequireE2E:       e.RequireE2E(),
+		VerifyCiphertext: e.verifyCiphertext,
+	}
+}
+
+// admit 检查握手是否满足加密策略
+func (e *RelayEncryption) admit(handshake *RelayHandshake) error {
+	if e.RequireE2E() && !handshake.E2E {
+		return ErrRelayE2ERequired
+	}
+	return nil
+}
+
+// verify 是否需要检查会话首个数据块为 TLS 记录
+func (e *RelayEncryption) verify(session *RelaySession) bool {
+	return e.verifyCiphertext && session.E2E
+}
+
+// isTLSRecord 数据是否以 TLS 记录头开始：内容类型为 change_cipher_spec、alert、handshake 或
+// application_data，版本主号为 3。用于发现声明端到端加密、实际却在传输明文的会话
+func isTLSRecord(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
+	return data[0] >= 20 && data[0] <= 23 && data[1] == 3
+}
+
+// RelaySessionStats 中继会话的统计，E2E 为客户端在握手中自行声明的端到端加密，中继无法验证双方的密钥。
+// 启用 verifyCiphertext 时，首个数据块不是 TLS 记录的加密会话已被断开，不会出现在统计中
+type RelaySessionStats struct {
+	ID            string    `json:"id"`
+	SourceID      string    `json:"sourceId"`
+	TargetID      string    `json:"targetId"`
+	UserID        uint      `json:"userId"`
+	E2E           bool      `json:"e2e"`
+	BytesSent     uint64    `json:"bytesSent"`
+	BytesReceived uint64    `json:"bytesReceived"`
+	CreatedAt     time.Time `json:"createdAt"`
+	LastActiveAt  time.Time `json:"lastActiveAt"`
+}
+
+// Encryption 中继的加密策略
+func (s *RelayServer) Encryption() *RelayEncryption {
+	return s.encryption
+}
+
+// SessionStats 获取当前所有会话的统计
+func (s *RelayServer) SessionStats() []RelaySessionStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := make([]RelaySessionStats, 0, len(s.sessions))
+	for _, session := range s.sessions {
+		session.mu.Lock()
+		sessions = append(sessions, RelaySessionStats{
+			ID:            session.ID,
+			SourceID:      session.SourceID,
+			TargetID:      session.TargetID,
+			UserID:        session.UserID,
+			E2E:           session.E2E,
+			BytesSent:     session.BytesSent,
+			BytesReceived: session.BytesReceived,
+			CreatedAt:     session.CreatedAt,
+			LastActiveAt:  session.LastActiveAt,
+		})
+		session.mu.Unlock()
+	}
+	return sessions
+}
+
+// GetE2ESessionCount 获取客户端声明端到端加密的会话数量，声明由客户端自行给出，中继无法验证
+func (s *RelayServer) GetE2ESessionCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, session := range s.sessions {
+		if session.E2E {
+			count++
+		}
+	}
+	return count
+}
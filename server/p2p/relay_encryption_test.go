@@ -0,0 +1,39 @@
+package p2p
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/senma231/p3/server/config"
+)
+
+func TestRelayEncryption(t *testing.T) {
+	e := NewRelayEncryption(config.RelayEncryptionConfig{VerifyCiphertext: true})
+	if err := e.admit(&RelayHandshake{}); err != nil {
+		t.Fatalf("未要求端到端加密时应接受会话: %v", err)
+	}
+
+	e.SetRequireE2E(true)
+	if err := e.admit(&RelayHandshake{}); !errors.Is(err, ErrRelayE2ERequired) {
+		t.Fatalf("要求端到端加密时应拒绝未声明加密的会话: %v", err)
+	}
+	if err := e.admit(&RelayHandshake{E2E: true}); err != nil {
+		t.Fatalf("应接受声明加密的会话: %v", err)
+	}
+	if !e.verify(&RelaySession{E2E: true}) || e.verify(&RelaySession{}) {
+		t.Fatal("只应检查声明加密的会话")
+	}
+
+	tests := map[string]bool{
+		"\x16\x03\x01\x02\x00": true,  // ClientHello
+		"\x17\x03\x03\x00\x10": true,  // application_data
+		"GET / HTTP/1.1\r\n":   false, // 明文 HTTP
+		"SSH-2.0-OpenSSH":      false,
+		"\x16":                 false,
+	}
+	for data, want := range tests {
+		if got := isTLSRecord([]byte(data)); got != want {
+			t.Errorf("%q: 结果为 %v", data, got)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package p2p
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/shaping"
+)
+
+// RelayLimitedCode 中继会话超出限制的错误码
+const RelayLimitedCode = "RELAY_LIMITED"
+
+// 超出的限制类型
+const (
+	RelayLimitSessions    = "sessions"    // 设备并发会话数
+	RelayLimitDestination = "destination" // 设备到同一目标地址的并发会话数
+	RelayLimitRate        = "rate"        // 设备每分钟新建会话数
+	RelayLimitBandwidth   = "bandwidth"   // 单个会话的带宽
+)
+
+// limitEventInterval 同一设备同一类限制上报事件的最小间隔，避免滥用的设备刷屏
+const limitEventInterval = time.Minute
+
+// RelayLimitEvent 中继会话超出限制的事件，用于通知源节点和记录设备事件
+type RelayLimitEvent struct {
+	Code        string    `json:"code"`
+	Limit       string    `json:"limit"`
+	Value       int       `json:"value"` // 超出的限制值，会话数、每分钟会话数或 Mbps
+	NodeID      string    `json:"nodeId"`
+	DeviceID    uint      `json:"deviceId"`
+	UserID      uint      `json:"userId"`
+	TargetID    string    `json:"targetId"`
+	Destination string    `json:"destination,omitempty"`
+	OccurredAt  time.Time `json:"occurredAt"`
+}
+
+// RelayLimitOverride 管理员为指定设备设置的限制
+type RelayLimitOverride struct {
+	NodeID string             `json:"nodeId"`
+	Limits config.RelayLimits `json:"limits"`
+}
+
+// relayQuota 按设备统计中继会话，判断是否超出限制
+type relayQuota struct {
+	defaults     config.RelayLimits
+	overrides    map[string]config.RelayLimits
+	active       map[string]int         // 按源节点统计的并发会话数
+	destinations map[string]int         // 按源节点和目标地址统计的并发会话数
+	starts       map[string][]time.Time // 源节点最近一分钟新建会话的时间
+	limitedAt    map[string]time.Time   // 最近一次上报限制事件的时间
+	handler      func(event *RelayLimitEvent)
+	mu           sync.Mutex
+}
+
+// newRelayQuota 根据中继配置创建会话配额
+func newRelayQuota(cfg *config.Config) *relayQuota {
+	q := &relayQuota{
+		defaults:     cfg.Relay.Limits,
+		overrides:    make(map[string]config.RelayLimits),
+		active:       make(map[string]int),
+		destinations: make(map[string]int),
+		starts:       make(map[string][]time.Time),
+		limitedAt:    make(map[string]time.Time),
+	}
+	for nodeID, limits := range cfg.Relay.LimitOverrides {
+		q.overrides[nodeID] = limits
+	}
+	return q
+}
+
+// limits 获取设备生效的限制
+func (q *relayQuota) limits(nodeID string) config.RelayLimits {
+	if limits, ok := q.overrides[nodeID]; ok {
+		return limits
+	}
+	return q.defaults
+}
+
+// destinationKey 源节点到目标地址的统计键
+func destinationKey(nodeID, destination string) string {
+	return nodeID + " " + destination
+}
+
+// admit 检查会话是否超出限制，未超出时占用配额并返回空字符串，会话结束后需调用 release；
+// 超出时返回超出的限制类型，需要上报时同时返回限制事件
+func (q *relayQuota) admit(session *RelaySession) (string, *RelayLimitEvent) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	nodeID := session.SourceID
+	key := destinationKey(nodeID, session.Destination)
+	limits := q.limits(nodeID)
+	now := time.Now()
+
+	// 丢弃一分钟之前的新建记录
+	starts := q.starts[nodeID]
+	for len(starts) > 0 && now.Sub(starts[0]) >= time.Minute {
+		starts = starts[1:]
+	}
+	q.starts[nodeID] = starts
+
+	var limit string
+	var value int
+	switch {
+	case limits.MaxSessions > 0 && q.active[nodeID] >= limits.MaxSessions:
+		limit, value = RelayLimitSessions, limits.MaxSessions
+	case limits.MaxSessionsPerDestination > 0 && q.destinations[key] >= limits.MaxSessionsPerDestination:
+		limit, value = RelayLimitDestination, limits.MaxSessionsPerDestination
+	case limits.SessionRate > 0 && len(starts) >= limits.SessionRate:
+		limit, value = RelayLimitRate, limits.SessionRate
+	}
+	if limit != "" {
+		return limit, q.event(session, limit, value, now)
+	}
+
+	q.active[nodeID]++
+	q.destinations[key]++
+	q.starts[nodeID] = append(starts, now)
+	session.BandwidthLimit = limits.SessionBandwidth
+	if limits.SessionBandwidth > 0 {
+		session.bandwidth = shaping.NewLocalStore()
+	}
+	return "", nil
+}
+
+// release 释放会话占用的配额
+func (q *relayQuota) release(session *RelaySession) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	nodeID := session.SourceID
+	if q.active[nodeID]--; q.active[nodeID] <= 0 {
+		delete(q.active, nodeID)
+	}
+	key := destinationKey(nodeID, session.Destination)
+	if q.destinations[key]--; q.destinations[key] <= 0 {
+		delete(q.destinations, key)
+	}
+}
+
+// event 创建限制事件，同一设备同一类限制在 limitEventInterval 内只返回一次，调用方需持有锁
+func (q *relayQuota) event(session *RelaySession, limit string, value int, now time.Time) *RelayLimitEvent {
+	key := session.SourceID + " " + limit
+	if now.Sub(q.limitedAt[key]) < limitEventInterval {
+		return nil
+	}
+	q.limitedAt[key] = now
+
+	return &RelayLimitEvent{
+		Code:        RelayLimitedCode,
+		Limit:       limit,
+		Value:       value,
+		NodeID:      session.SourceID,
+		DeviceID:    session.SourceDeviceID,
+		UserID:      session.UserID,
+		TargetID:    session.TargetID,
+		Destination: session.Destination,
+		OccurredAt:  now,
+	}
+}
+
+// bandwidthEvent 创建会话超出带宽限制的事件
+func (q *relayQuota) bandwidthEvent(session *RelaySession) *RelayLimitEvent {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.event(session, RelayLimitBandwidth, session.BandwidthLimit, time.Now())
+}
+
+// cleanup 清理过期的新建记录和事件时间
+func (q *relayQuota) cleanup() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	for nodeID, starts := range q.starts {
+		if len(starts) == 0 || now.Sub(starts[len(starts)-1]) >= time.Minute {
+			delete(q.starts, nodeID)
+		}
+	}
+	for key, limitedAt := range q.limitedAt {
+		if now.Sub(limitedAt) >= limitEventInterval {
+			delete(q.limitedAt, key)
+		}
+	}
+}
+
+// SetLimitHandler 设置会话超出限制时的处理函数，通常用于通知源节点和记录设备事件
+func (s *RelayServer) SetLimitHandler(handler func(event *RelayLimitEvent)) {
+	s.quota.mu.Lock()
+	defer s.quota.mu.Unlock()
+	s.quota.handler = handler
+}
+
+// reportLimited 记录并上报限制事件
+func (s *RelayServer) reportLimited(event *RelayLimitEvent) {
+	if event == nil {
+		return
+	}
+	logger.Warn("设备 %s 的中继会话超出限制 (%s %d): -> %s", event.NodeID, event.Limit, event.Value, event.TargetID)
+
+	s.quota.mu.Lock()
+	handler := s.quota.handler
+	s.quota.mu.Unlock()
+	if handler != nil {
+		handler(event)
+	}
+}
+
+// admitSession 检查会话是否超出限制，超出时上报事件并返回错误
+func (s *RelayServer) admitSession(session *RelaySession) error {
+	limit, event := s.quota.admit(session)
+	if limit == "" {
+		return nil
+	}
+	s.reportLimited(event)
+	return fmt.Errorf("超出中继会话限制: %s", limit)
+}
+
+// GetLimits 获取默认限制和管理员为各设备设置的限制
+func (s *RelayServer) GetLimits() (config.RelayLimits, []RelayLimitOverride) {
+	s.quota.mu.Lock()
+	defer s.quota.mu.Unlock()
+
+	overrides := make([]RelayLimitOverride, 0, len(s.quota.overrides))
+	for nodeID, limits := range s.quota.overrides {
+		overrides = append(overrides, RelayLimitOverride{NodeID: nodeID, Limits: limits})
+	}
+	sort.Slice(overrides, func(i, j int) bool { return overrides[i].NodeID < overrides[j].NodeID })
+	return s.quota.defaults, overrides
+}
+
+// SetLimitOverride 为指定设备设置限制，整体替换默认限制，对之后新建的会话生效
+func (s *RelayServer) SetLimitOverride(nodeID string, limits config.RelayLimits) error {
+	if err := limits.Validate(); err != nil {
+		return err
+	}
+
+	s.quota.mu.Lock()
+	defer s.quota.mu.Unlock()
+	s.quota.overrides[nodeID] = limits
+	logger.Info("设备 %s 的中继会话限制已设置: %+v", nodeID, limits)
+	return nil
+}
+
+// DeleteLimitOverride 删除指定设备的限制，恢复使用默认限制
+func (s *RelayServer) DeleteLimitOverride(nodeID string) bool {
+	s.quota.mu.Lock()
+	defer s.quota.mu.Unlock()
+
+	if _, ok := s.quota.overrides[nodeID]; !ok {
+		return false
+	}
+	delete(s.quota.overrides, nodeID)
+	logger.Info("设备 %s 的中继会话限制已恢复为默认值", nodeID)
+	return true
+}
+
+// relayDestination 目标节点的地址和端口
+func relayDestination(peer *PeerInfo) string {
+	return net.JoinHostPort(peer.ExternalIP.String(), strconv.Itoa(peer.ExternalPort))
+}
@@ -0,0 +1,81 @@
+package p2p
+
+import (
+	"testing"
+
+	"github.com/senma231/p3/server/config"
+)
+
+func TestRelayQuota(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Relay.Limits = config.RelayLimits{MaxSessions: 3, MaxSessionsPerDestination: 2, SessionRate: 4}
+	cfg.Relay.LimitOverrides = map[string]config.RelayLimits{"node-vip": {SessionBandwidth: 10}}
+	q := newRelayQuota(cfg)
+
+	session := func(nodeID, destination string) *RelaySession {
+		return &RelaySession{SourceID: nodeID, TargetID: "node-b", Destination: destination}
+	}
+
+	// 同一目标地址最多 2 个会话
+	a1, a2 := session("node-a", "10.0.0.1:1000"), session("node-a", "10.0.0.1:1000")
+	for _, s := range []*RelaySession{a1, a2} {
+		if limit, _ := q.admit(s); limit != "" {
+			t.Fatalf("会话被拒绝: %s", limit)
+		}
+	}
+	limit, event := q.admit(session("node-a", "10.0.0.1:1000"))
+	if limit != RelayLimitDestination || event == nil || event.Value != 2 {
+		t.Fatalf("应超出目标地址限制，结果为 %q %+v", limit, event)
+	}
+	// 同类限制在间隔内只上报一次
+	if limit, event := q.admit(session("node-a", "10.0.0.1:1000")); limit != RelayLimitDestination || event != nil {
+		t.Fatalf("重复的限制事件: %q %+v", limit, event)
+	}
+
+	// 设备最多 3 个并发会话
+	a3 := session("node-a", "10.0.0.2:1000")
+	if limit, _ := q.admit(a3); limit != "" {
+		t.Fatalf("会话被拒绝: %s", limit)
+	}
+	if limit, _ := q.admit(session("node-a", "10.0.0.3:1000")); limit != RelayLimitSessions {
+		t.Fatalf("应超出并发会话限制，结果为 %q", limit)
+	}
+
+	// 会话结束后释放配额，但一分钟内新建会话数仍受限
+	q.release(a1)
+	q.release(a2)
+	if limit, _ := q.admit(session("node-a", "10.0.0.1:1000")); limit != "" {
+		t.Fatalf("释放后会话被拒绝: %s", limit)
+	}
+	if limit, _ := q.admit(session("node-a", "10.0.0.1:1000")); limit != RelayLimitRate {
+		t.Fatalf("应超出新建速率限制，结果为 %q", limit)
+	}
+
+	// 管理员设置的限制整体替换默认限制
+	vip := session("node-vip", "10.0.0.1:1000")
+	for i := 0; i < 10; i++ {
+		if limit, _ := q.admit(session("node-vip", "10.0.0.1:1000")); limit != "" {
+			t.Fatalf("会话被拒绝: %s", limit)
+		}
+	}
+	if limit, _ := q.admit(vip); limit != "" || vip.BandwidthLimit != 10 || vip.bandwidth == nil {
+		t.Fatalf("会话带宽限制未生效: %q %d", limit, vip.BandwidthLimit)
+	}
+}
+
+func TestRelayLimitOverride(t *testing.T) {
+	s := NewRelayServer(config.DefaultConfig(), nil)
+
+	if err := s.SetLimitOverride("node-a", config.RelayLimits{MaxSessions: -1}); err == nil {
+		t.Fatal("负数限制应设置失败")
+	}
+	if err := s.SetLimitOverride("node-a", config.RelayLimits{MaxSessions: 5}); err != nil {
+		t.Fatalf("设置限制失败: %v", err)
+	}
+	if _, overrides := s.GetLimits(); len(overrides) != 1 || overrides[0].NodeID != "node-a" || overrides[0].Limits.MaxSessions != 5 {
+		t.Fatalf("设备限制为 %+v", overrides)
+	}
+	if !s.DeleteLimitOverride("node-a") || s.DeleteLimitOverride("node-a") {
+		t.Fatal("删除设备限制的结果错误")
+	}
+}
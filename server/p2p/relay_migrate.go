@@ -0,0 +1,258 @@
+package p2p
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+)
+
+const (
+	// relayMigrationRetry 同一会话再次要求迁移的最小间隔，源节点未完成迁移时在此之后重试
+	relayMigrationRetry = time.Minute
+	// relayMigrationDrain 旧中继等待源节点已发送的数据转发到目标节点的最长时间
+	relayMigrationDrain = 5 * time.Second
+)
+
+// RelaySessionInfo 中继上可以迁移的会话，只有可恢复的会话支持迁移
+type RelaySessionInfo struct {
+	ID       string `json:"id"`
+	SourceID string `json:"sourceId"`
+	TargetID string `json:"targetId"`
+}
+
+// RelayMigrateNotice 通知源节点把会话迁移到新的中继，RelayTicket 为连接新中继的一次性票据
+type RelayMigrateNotice struct {
+	SessionID   string `json:"sessionId"`
+	FromRelayID string `json:"fromRelayId"`
+	RelayID     string `json:"relayId"`
+	RelayHost   string `json:"relayHost"`
+	RelayPort   int    `json:"relayPort"`
+	RelayTicket string `json:"relayTicket"`
+	TargetID    string `json:"targetId"`
+}
+
+// isMigrating 检查源节点是否已把会话迁移到其他中继
+func (session *RelaySession) isMigrating() bool {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+	return session.migrating
+}
+
+// ActiveSessions 获取可以迁移的会话
+func (s *RelayServer) ActiveSessions() []RelaySessionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := make([]RelaySessionInfo, 0, len(s.resumable))
+	for _, session := range s.resumable {
+		if session.isMigrating() {
+			continue
+		}
+		sessions = append(sessions, RelaySessionInfo{
+			ID:       session.ID,
+			SourceID: session.SourceID,
+			TargetID: session.TargetID,
+		})
+	}
+	return sessions
+}
+
+// finishMigration 源节点已通过新中继连接目标节点后结束旧会话，响应 OK。
+//
+// 先等待源节点通过旧会话发送的 sent 字节转发到目标节点，再关闭目标连接；
+// 中继协程随后关闭源连接，可恢复会话发送完已从目标节点读取的数据后通知源节点结束，
+// 源节点读完旧会话后改从新中继读取
+func (s *RelayServer) finishMigration(ctx context.Context, conn net.Conn, request string) {
+	ticket, sent, err := ParseRelayMigrated(request)
+	if err != nil {
+		logger.Error("无效的会话迁移请求: %v", err)
+		conn.Write([]byte("ERROR: Invalid request"))
+		return
+	}
+
+	s.mu.RLock()
+	session := s.resumable[ticket]
+	s.mu.RUnlock()
+	if session == nil {
+		logger.Warn("结束迁移的中继会话失败: %s 的会话不存在或已过期", conn.RemoteAddr())
+		conn.Write([]byte("ERROR: Session not found"))
+		return
+	}
+
+	session.mu.Lock()
+	session.migrating = true
+	session.mu.Unlock()
+	conn.Write([]byte("OK\n"))
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	deadline := time.Now().Add(relayMigrationDrain)
+	for time.Now().Before(deadline) {
+		session.mu.Lock()
+		forwarded := session.BytesSent >= sent
+		session.mu.Unlock()
+		if forwarded {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+
+	session.TargetConn.Close()
+	logger.Info("中继会话已迁移: %s -> %s", session.SourceID, session.TargetID)
+}
+
+// SetRelayMigrator 设置迁移中继会话的函数，返回已通知迁移的会话数，通常由信令服务器通知源节点
+func (c *Coordinator) SetRelayMigrator(migrator func(relayID string, sessions []RelaySessionInfo) int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.relayMigrator = migrator
+}
+
+// DrainRelay 开始维护独立中继：中继不再参与中继选择，其上可恢复的会话迁移到其他中继，
+// 之后的心跳中继续迁移尚未完成迁移的会话。返回已通知迁移的会话数
+func (c *Coordinator) DrainRelay(relayID string) (int, error) {
+	c.mu.Lock()
+	relay, ok := c.standaloneRelays[relayID]
+	if !ok {
+		c.mu.Unlock()
+		return 0, ErrRelayNotRegistered
+	}
+	c.drainingRelays[relayID] = true
+	c.updateRelayLocked(relayID, relay)
+	sessions := c.sessionsToMigrateLocked(relayID, relay, time.Now())
+	c.mu.Unlock()
+
+	logger.Info("独立中继开始维护: %s，迁移 %d 个会话", relayID, len(sessions))
+	return c.migrateRelaySessions(relayID, sessions), nil
+}
+
+// UndrainRelay 结束维护独立中继，中继重新参与中继选择
+func (c *Coordinator) UndrainRelay(relayID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	relay, ok := c.standaloneRelays[relayID]
+	if !ok {
+		return ErrRelayNotRegistered
+	}
+	delete(c.drainingRelays, relayID)
+	c.updateRelayLocked(relayID, relay)
+	logger.Info("独立中继结束维护: %s", relayID)
+	return nil
+}
+
+// IsRelayDraining 检查独立中继是否正在维护
+func (c *Coordinator) IsRelayDraining(relayID string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.drainingRelays[relayID]
+}
+
+// sessionsToMigrateLocked 选出需要迁移的会话：维护中的中继迁移所有会话，会话数超过迁移阈值时迁移超出的部分。
+// 最近已要求迁移的会话不重复选择。调用方需持有写锁
+func (c *Coordinator) sessionsToMigrateLocked(relayID string, relay *standaloneRelay, now time.Time) []RelaySessionInfo {
+	active := relay.registration.ActiveSessions
+
+	// 已结束的会话不再记录
+	current := make(map[string]bool, len(active))
+	for _, session := range active {
+		current[session.ID] = true
+	}
+	for id := range relay.migrations {
+		if !current[id] {
+			delete(relay.migrations, id)
+		}
+	}
+
+	limit := 0
+	if c.drainingRelays[relayID] {
+		limit = len(active)
+	} else if above := c.config.Relay.MigrateAbove; above > 0 {
+		threshold := c.relayCapacity(relayID) * above / 100
+		if excess := relay.registration.Sessions - threshold; threshold > 0 && excess > 0 {
+			limit = excess
+		}
+	}
+
+	var sessions []RelaySessionInfo
+	for _, session := range active {
+		if len(sessions) >= limit {
+			break
+		}
+		if requested, ok := relay.migrations[session.ID]; ok && now.Sub(requested) < relayMigrationRetry {
+			continue
+		}
+		relay.migrations[session.ID] = now
+		sessions = append(sessions, session)
+	}
+	return sessions
+}
+
+// migrateRelaySessions 调用迁移函数迁移会话，未设置迁移函数时不迁移
+func (c *Coordinator) migrateRelaySessions(relayID string, sessions []RelaySessionInfo) int {
+	c.mu.RLock()
+	migrator := c.relayMigrator
+	c.mu.RUnlock()
+
+	if migrator == nil || len(sessions) == 0 {
+		return 0
+	}
+	return migrator(relayID, sessions)
+}
+
+// MigrateRelaySessions 为中继 relayID 上的会话选择新的中继并通知源节点迁移，返回已通知的会话数
+func (s *SignalingServer) MigrateRelaySessions(relayID string, sessions []RelaySessionInfo) int {
+	migrated := 0
+	for _, session := range sessions {
+		if err := s.migrateRelaySession(relayID, session); err != nil {
+			logger.Warn("迁移中继会话 %s 失败: %v", session.ID, err)
+			continue
+		}
+		migrated++
+	}
+	return migrated
+}
+
+// migrateRelaySession 选择新的中继，为源节点签发票据并下发配对指令后通知源节点迁移。
+// 目标节点的连接由新中继建立，无需通知目标节点
+func (s *SignalingServer) migrateRelaySession(relayID string, session RelaySessionInfo) error {
+	relayNode, err := s.coordinator.selectRelayNode(session.SourceID, session.TargetID, relayID)
+	if err != nil {
+		return fmt.Errorf("选择中继节点失败: %w", err)
+	}
+
+	ticket, err := s.coordinator.IssueRelayTicket(session.SourceID, session.TargetID)
+	if err != nil {
+		return fmt.Errorf("签发中继票据失败: %w", err)
+	}
+	if err := s.coordinator.PairRelay(relayNode.NodeID, session.SourceID, session.TargetID, ticket, ""); err != nil {
+		return fmt.Errorf("下发中继配对指令失败: %w", err)
+	}
+
+	notice := &RelayMigrateNotice{
+		SessionID:   session.ID,
+		FromRelayID: relayID,
+		RelayID:     relayNode.NodeID,
+		RelayHost:   relayNode.ExternalIP.String(),
+		RelayPort:   relayNode.ExternalPort,
+		RelayTicket: ticket,
+		TargetID:    session.TargetID,
+	}
+	if err := s.SendToNode(session.SourceID, &protocol.Signal{
+		Type:    protocol.SignalRelayMigrate,
+		Payload: notice,
+	}); err != nil {
+		return err
+	}
+
+	logger.Info("已通知 %s 把中继会话从 %s 迁移到 %s", session.SourceID, relayID, relayNode.NodeID)
+	return nil
+}
@@ -0,0 +1,67 @@
+package p2p
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/senma231/p3/server/config"
+)
+
+func TestRelaySessionMigration(t *testing.T) {
+	cfg := config.DefaultConfig()
+	cfg.Relay.MigrateAbove = 90
+	c := NewCoordinator(cfg, nil, nil)
+
+	var migrated []string
+	c.SetRelayMigrator(func(relayID string, sessions []RelaySessionInfo) int {
+		for _, session := range sessions {
+			migrated = append(migrated, session.ID)
+		}
+		return len(sessions)
+	})
+
+	active := []RelaySessionInfo{{ID: "s1"}, {ID: "s2"}, {ID: "s3"}}
+	for _, id := range []string{"relay-1", "relay-2"} {
+		if err := c.RegisterRelay(&RelayRegistration{RelayID: id, Host: "203.0.113.10", Port: 27185, Capacity: 10, Healthy: true}); err != nil {
+			t.Fatalf("注册独立中继失败: %v", err)
+		}
+	}
+
+	// 会话数超过容量的 90% 时迁移超出的部分
+	heartbeat := &RelayRegistration{RelayID: "relay-1", Capacity: 10, Sessions: 10, Healthy: true, ActiveSessions: active}
+	if err := c.RelayHeartbeat(heartbeat); err != nil {
+		t.Fatalf("上报心跳失败: %v", err)
+	}
+	if len(migrated) != 1 || migrated[0] != "s1" {
+		t.Fatalf("应迁移一个会话，实际为 %v", migrated)
+	}
+
+	// 已要求迁移的会话不重复迁移
+	if err := c.RelayHeartbeat(heartbeat); err != nil {
+		t.Fatalf("上报心跳失败: %v", err)
+	}
+	if len(migrated) != 2 || migrated[1] != "s2" {
+		t.Fatalf("应迁移下一个会话，实际为 %v", migrated)
+	}
+
+	// 维护中的中继迁移所有剩余会话，且不参与中继选择
+	if n, err := c.DrainRelay("relay-1"); err != nil || n != 1 {
+		t.Fatalf("开始维护中继: %d %v", n, err)
+	}
+	if c.IsRelayDraining("relay-1") != true {
+		t.Fatal("中继应处于维护状态")
+	}
+	for i := 0; i < 3; i++ {
+		relay, err := c.SelectRelayNode("node-a", "node-b")
+		if err != nil || relay.NodeID != "relay-2" {
+			t.Fatalf("维护中的中继不应被选择: %v %v", relay, err)
+		}
+	}
+
+	if err := c.UndrainRelay("relay-1"); err != nil {
+		t.Fatalf("结束维护中继失败: %v", err)
+	}
+	if _, err := c.DrainRelay("relay-3"); !errors.Is(err, ErrRelayNotRegistered) {
+		t.Fatalf("维护未注册的中继应返回 ErrRelayNotRegistered，实际为 %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package p2p
+
+import (
+	"sort"
+	"time"
+)
+
+// relayAssignmentWindow 统计中继负载的时间窗口，窗口内的分配视为仍在使用
+const relayAssignmentWindow = 10 * time.Minute
+
+// RelayPoolStats 区域中继池统计
+type RelayPoolStats struct {
+	Region      string  `json:"region"`
+	Relays      int     `json:"relays"`
+	Capacity    int     `json:"capacity"`
+	Assigned    int     `json:"assigned"`
+	Utilization float64 `json:"utilization"`
+}
+
+// SetPeerRegion 设置节点上报的区域
+func (c *Coordinator) SetPeerRegion(nodeID, region string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if region == "" {
+		delete(c.peerRegions, nodeID)
+	} else {
+		c.peerRegions[nodeID] = region
+	}
+
+	if peer, ok := c.peers[nodeID]; ok {
+		peer.Region = c.regionOf(nodeID)
+	}
+}
+
+// regionOf 获取节点所属区域：配置指定的区域优先，其次是节点上报的区域，最后使用默认区域。
+// 调用方需持有锁
+func (c *Coordinator) regionOf(nodeID string) string {
+	if region, ok := c.config.Relay.NodeRegions[nodeID]; ok && region != "" {
+		return region
+	}
+	if region, ok := c.peerRegions[nodeID]; ok {
+		return region
+	}
+	return c.config.Relay.Region
+}
+
+// relayLoad 获取中继节点在统计窗口内的分配数，并清理过期记录。调用方需持有写锁
+func (c *Coordinator) relayLoad(nodeID string, now time.Time) int {
+	assigned := c.relayAssigned[nodeID]
+	i := 0
+	for i < len(assigned) && now.Sub(assigned[i]) > relayAssignmentWindow {
+		i++
+	}
+	if i > 0 {
+		assigned = assigned[i:]
+		c.relayAssigned[nodeID] = assigned
+	}
+	return len(assigned)
+}
+
+// RelayPoolStats 按区域统计中继池的节点数、容量和负载
+func (c *Coordinator) RelayPoolStats() []RelayPoolStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	c.expireRelaysLocked(now)
+	pools := make(map[string]*RelayPoolStats)
+	for _, node := range c.relayNodes {
+		pool, ok := pools[node.Region]
+		if !ok {
+			pool = &RelayPoolStats{Region: node.Region}
+			pools[node.Region] = pool
+		}
+		pool.Relays++
+		pool.Capacity += c.relayCapacity(node.NodeID)
+		pool.Assigned += c.relayLoad(node.NodeID, now)
+	}
+
+	stats := make([]RelayPoolStats, 0, len(pools))
+	for _, pool := range pools {
+		if pool.Capacity > 0 {
+			pool.Utilization = float64(pool.Assigned) / float64(pool.Capacity)
+		}
+		stats = append(stats, *pool)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Region < stats[j].Region
+	})
+	return stats
+}
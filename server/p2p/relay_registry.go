@@ -0,0 +1,365 @@
+package p2p
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+)
+
+const (
+	// relayHeartbeatInterval 独立中继上报心跳的间隔
+	relayHeartbeatInterval = 15 * time.Second
+	// relayHeartbeatTimeout 超过该时间未上报心跳的独立中继视为离线
+	relayHeartbeatTimeout = 3 * relayHeartbeatInterval
+	// relayPairingQueue 每个独立中继待下发的配对指令数上限
+	relayPairingQueue = 256
+	// relaySecretHeader 独立中继携带注册密钥的请求头
+	relaySecretHeader = "X-Relay-Secret"
+)
+
+// ErrRelayNotRegistered 独立中继未注册或已过期，需要重新注册
+var ErrRelayNotRegistered = errors.New("中继未注册")
+
+// RelayRegistration 独立中继的注册和心跳请求，上报地址、容量和健康状态
+type RelayRegistration struct {
+	RelayID       string `json:"relayId" binding:"required"`
+	Host          string `json:"host" binding:"required"`
+	Port          int    `json:"port" binding:"required"`
+	Region        string `json:"region"`
+	Capacity      int    `json:"capacity"`  // 最大会话数
+	Bandwidth     int    `json:"bandwidth"` // 单位：Mbps
+	Sessions      int    `json:"sessions"`
+	BytesSent     uint64 `json:"bytesSent"`
+	BytesReceived uint64 `json:"bytesReceived"`
+	Healthy       bool   `json:"healthy"`
+	// 可以迁移的会话，主服务器维护或负载过高时据此迁移会话
+	ActiveSessions []RelaySessionInfo `json:"activeSessions,omitempty"`
+}
+
+// RelayPairing 下发给独立中继的配对指令，独立中继据此验证客户端的一次性票据
+type RelayPairing struct {
+	Ticket    string    `json:"ticket"`
+	NodeID    string    `json:"nodeId"` // 票据持有者
+	DeviceID  uint      `json:"deviceId"`
+	UserID    uint      `json:"userId"`
+	PeerID    string    `json:"peerId"`
+	PeerHost  string    `json:"peerHost"`
+	PeerPort  int       `json:"peerPort"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	// 主服务器要求端到端加密，独立中继拒绝未声明端到端加密的握手
+	RequireE2E bool `json:"requireE2E,omitempty"`
+}
+
+// standaloneRelay 已注册的独立中继
+type standaloneRelay struct {
+	registration RelayRegistration
+	peer         *PeerInfo
+	pairings     chan RelayPairing
+	migrations   map[string]time.Time // 按会话 ID 记录最近一次要求迁移的时间
+}
+
+// RegisterRelay 注册独立中继，已注册时更新上报的信息
+func (c *Coordinator) RegisterRelay(reg *RelayRegistration) error {
+	ip := net.ParseIP(reg.Host)
+	if ip == nil {
+		return fmt.Errorf("无效的中继地址: %s", reg.Host)
+	}
+	if reg.Port <= 0 || reg.Port > 65535 {
+		return fmt.Errorf("无效的中继端口: %d", reg.Port)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.peers[reg.RelayID]; ok {
+		return fmt.Errorf("中继 ID 与在线节点重复: %s", reg.RelayID)
+	}
+
+	relay, ok := c.standaloneRelays[reg.RelayID]
+	if !ok {
+		relay = &standaloneRelay{
+			pairings:   make(chan RelayPairing, relayPairingQueue),
+			migrations: make(map[string]time.Time),
+		}
+		c.standaloneRelays[reg.RelayID] = relay
+		logger.Info("独立中继已注册: %s (%s:%d)", reg.RelayID, reg.Host, reg.Port)
+	}
+
+	region := reg.Region
+	if region == "" {
+		region = c.config.Relay.Region
+	}
+	relay.registration = *reg
+	relay.peer = &PeerInfo{
+		NodeID:       reg.RelayID,
+		NATType:      protocol.NATNone,
+		ExternalIP:   ip,
+		ExternalPort: reg.Port,
+		Region:       region,
+		LastSeen:     time.Now(),
+	}
+	c.updateRelayLocked(reg.RelayID, relay)
+	return nil
+}
+
+// RelayHeartbeat 更新独立中继上报的负载和健康状态。中继正在维护或负载超过迁移阈值时迁移其上的会话
+func (c *Coordinator) RelayHeartbeat(reg *RelayRegistration) error {
+	c.mu.Lock()
+	relay, ok := c.standaloneRelays[reg.RelayID]
+	if !ok {
+		c.mu.Unlock()
+		return ErrRelayNotRegistered
+	}
+
+	// 地址和区域只在注册时更新
+	relay.registration.Capacity = reg.Capacity
+	relay.registration.Bandwidth = reg.Bandwidth
+	relay.registration.Sessions = reg.Sessions
+	relay.registration.BytesSent = reg.BytesSent
+	relay.registration.BytesReceived = reg.BytesReceived
+	relay.registration.Healthy = reg.Healthy
+	relay.registration.ActiveSessions = reg.ActiveSessions
+	relay.peer.LastSeen = time.Now()
+	c.updateRelayLocked(reg.RelayID, relay)
+	sessions := c.sessionsToMigrateLocked(reg.RelayID, relay, time.Now())
+	c.mu.Unlock()
+
+	if len(sessions) > 0 {
+		c.migrateRelaySessions(reg.RelayID, sessions)
+	}
+	return nil
+}
+
+// UnregisterRelay 注销独立中继
+func (c *Coordinator) UnregisterRelay(relayID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeRelayLocked(relayID)
+}
+
+// updateRelayLocked 只有健康且不在维护中的独立中继参与中继选择。调用方需持有写锁
+func (c *Coordinator) updateRelayLocked(relayID string, relay *standaloneRelay) {
+	if relay.registration.Healthy && !c.drainingRelays[relayID] {
+		c.relayNodes[relayID] = relay.peer
+	} else {
+		delete(c.relayNodes, relayID)
+	}
+}
+
+// removeRelayLocked 移除独立中继，调用方需持有写锁
+func (c *Coordinator) removeRelayLocked(relayID string) {
+	relay, ok := c.standaloneRelays[relayID]
+	if !ok {
+		return
+	}
+	delete(c.standaloneRelays, relayID)
+	delete(c.relayNodes, relayID)
+	delete(c.relayAssigned, relayID)
+	delete(c.drainingRelays, relayID)
+	close(relay.pairings)
+	logger.Info("独立中继已注销: %s", relayID)
+}
+
+// expireRelaysLocked 移除超时未上报心跳的独立中继，调用方需持有写锁
+func (c *Coordinator) expireRelaysLocked(now time.Time) {
+	for relayID, relay := range c.standaloneRelays {
+		if now.Sub(relay.peer.LastSeen) > relayHeartbeatTimeout {
+			logger.Warn("独立中继心跳超时: %s", relayID)
+			c.removeRelayLocked(relayID)
+		}
+	}
+}
+
+// relayCapacity 中继节点的最大会话数：独立中继使用上报的容量，其他中继使用配置。调用方需持有锁
+func (c *Coordinator) relayCapacity(nodeID string) int {
+	if relay, ok := c.standaloneRelays[nodeID]; ok && relay.registration.Capacity > 0 {
+		return relay.registration.Capacity
+	}
+	return c.config.Relay.MaxClients
+}
+
+// PairRelay 选中独立中继时下发双方的配对指令，其他中继无需处理。
+// 迁移会话时只有源节点连接新的中继，targetTicket 为空
+func (c *Coordinator) PairRelay(relayID, sourceID, targetID, sourceTicket, targetTicket string) error {
+	c.mu.RLock()
+	relay, ok := c.standaloneRelays[relayID]
+	source, sourceOnline := c.peers[sourceID]
+	target, targetOnline := c.peers[targetID]
+	c.mu.RUnlock()
+
+	if !ok {
+		return nil
+	}
+	if !sourceOnline || !targetOnline {
+		return errors.New("对等节点不存在")
+	}
+
+	sourcePairing, err := c.relayPairing(sourceTicket, sourceID, target)
+	if err != nil {
+		return err
+	}
+	pairings := []RelayPairing{sourcePairing}
+	if targetTicket != "" {
+		targetPairing, err := c.relayPairing(targetTicket, targetID, source)
+		if err != nil {
+			return err
+		}
+		pairings = append(pairings, targetPairing)
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// 注销时会关闭通道，需确认中继仍然注册
+	if c.standaloneRelays[relayID] != relay {
+		return ErrRelayNotRegistered
+	}
+	for _, pairing := range pairings {
+		select {
+		case relay.pairings <- pairing:
+		default:
+			return fmt.Errorf("中继 %s 的配对指令队列已满", relayID)
+		}
+	}
+	return nil
+}
+
+// relayPairing 创建票据持有者连接对端的配对指令
+func (c *Coordinator) relayPairing(ticket, nodeID string, peer *PeerInfo) (RelayPairing, error) {
+	device, err := c.deviceService.GetDeviceByNodeID(nodeID)
+	if err != nil {
+		return RelayPairing{}, err
+	}
+	return RelayPairing{
+		Ticket:    ticket,
+		NodeID:    nodeID,
+		DeviceID:  device.ID,
+		UserID:    device.UserID,
+		PeerID:    peer.NodeID,
+		PeerHost:  peer.ExternalIP.String(),
+		PeerPort:  peer.ExternalPort,
+		ExpiresAt: time.Now().Add(relayTicketTTL),
+
+		RequireE2E: c.relayRequireE2E(),
+	}, nil
+}
+
+// RegisterRelayAgentRoutes 注册独立中继使用的路由
+func (c *Coordinator) RegisterRelayAgentRoutes(router *gin.RouterGroup) {
+	agent := router.Group("/relay/agent", c.relaySecretMiddleware())
+	{
+		agent.POST("/register", c.HandleRelayRegister)
+		agent.POST("/heartbeat", c.HandleRelayHeartbeat)
+		agent.GET("/pairings", c.HandleRelayPairings)
+		agent.DELETE("/register", c.HandleRelayUnregister)
+	}
+}
+
+// relaySecretMiddleware 验证独立中继的注册密钥，未配置密钥时拒绝所有请求
+func (c *Coordinator) relaySecretMiddleware() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		secret := ctx.GetHeader(relaySecretHeader)
+		if c.config.Relay.RegistrationSecret == "" ||
+			subtle.ConstantTimeCompare([]byte(secret), []byte(c.config.Relay.RegistrationSecret)) != 1 {
+			ctx.JSON(http.StatusUnauthorized, gin.H{"error": "中继注册密钥无效"})
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
+// HandleRelayRegister 处理独立中继注册
+func (c *Coordinator) HandleRelayRegister(ctx *gin.Context) {
+	var reg RelayRegistration
+	if err := ctx.ShouldBindJSON(&reg); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	if err := c.RegisterRelay(&reg); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"heartbeatInterval": int(relayHeartbeatInterval / time.Second),
+	})
+}
+
+// HandleRelayHeartbeat 处理独立中继心跳，未注册时返回 404，中继需要重新注册
+func (c *Coordinator) HandleRelayHeartbeat(ctx *gin.Context) {
+	var reg RelayRegistration
+	if err := ctx.ShouldBindJSON(&reg); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "无效的请求参数"})
+		return
+	}
+	if err := c.RelayHeartbeat(&reg); err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// HandleRelayPairings 长轮询获取配对指令，有指令或超时后返回，wait 参数指定等待秒数
+func (c *Coordinator) HandleRelayPairings(ctx *gin.Context) {
+	wait, ok := pollWait(ctx)
+	if !ok {
+		return
+	}
+
+	c.mu.RLock()
+	relay, registered := c.standaloneRelays[ctx.Query("relayId")]
+	c.mu.RUnlock()
+	if !registered {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": ErrRelayNotRegistered.Error()})
+		return
+	}
+
+	pairings := drainPairings(relay.pairings, []RelayPairing{})
+	if len(pairings) == 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case pairing, ok := <-relay.pairings:
+			if ok {
+				pairings = drainPairings(relay.pairings, append(pairings, pairing))
+			}
+		case <-timer.C:
+		case <-ctx.Request.Context().Done():
+			return
+		}
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"pairings": pairings,
+	})
+}
+
+// HandleRelayUnregister 处理独立中继注销
+func (c *Coordinator) HandleRelayUnregister(ctx *gin.Context) {
+	c.UnregisterRelay(ctx.Query("relayId"))
+	ctx.JSON(http.StatusOK, gin.H{"success": true})
+}
+
+// drainPairings 取出已排队的配对指令，最多 maxPollBatch 条
+func drainPairings(ch chan RelayPairing, pairings []RelayPairing) []RelayPairing {
+	for len(pairings) < maxPollBatch {
+		select {
+		case pairing, ok := <-ch:
+			if !ok {
+				return pairings
+			}
+			pairings = append(pairings, pairing)
+		default:
+			return pairings
+		}
+	}
+	return pairings
+}
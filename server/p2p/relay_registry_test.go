@@ -0,0 +1,108 @@
+package p2p
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/server/config"
+)
+
+func TestStandaloneRelayRegistration(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	cfg.Relay.RegistrationSecret = "secret"
+	c := NewCoordinator(cfg, nil, nil)
+	router := gin.New()
+	c.RegisterRelayAgentRoutes(router.Group("/api/v1"))
+
+	register := func(secret string) int {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/relay/agent/register",
+			strings.NewReader(`{"relayId":"relay-1","host":"203.0.113.10","port":27185,"capacity":1,"healthy":true}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set(relaySecretHeader, secret)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+	if code := register("wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("密钥错误时应返回 401，实际为 %d", code)
+	}
+	if code := register("secret"); code != http.StatusOK {
+		t.Fatalf("注册独立中继失败: %d", code)
+	}
+
+	// 注册后参与中继选择，按上报的容量限制分配
+	relay, err := c.SelectRelayNode("node-a", "node-b")
+	if err != nil || relay.NodeID != "relay-1" || relay.ExternalPort != 27185 {
+		t.Fatalf("应选择独立中继: %v %v", relay, err)
+	}
+	if _, err := c.SelectRelayNode("node-a", "node-b"); err == nil {
+		t.Fatal("独立中继达到容量上限后不应再被选择")
+	}
+
+	// 不健康的中继不参与选择
+	if err := c.RelayHeartbeat(&RelayRegistration{RelayID: "relay-1", Capacity: 10}); err != nil {
+		t.Fatalf("上报心跳失败: %v", err)
+	}
+	if len(c.GetRelayNodes()) != 0 {
+		t.Fatal("不健康的独立中继不应参与选择")
+	}
+
+	if err := c.RelayHeartbeat(&RelayRegistration{RelayID: "relay-2"}); !errors.Is(err, ErrRelayNotRegistered) {
+		t.Fatalf("未注册的中继上报心跳应返回 ErrRelayNotRegistered，实际为 %v", err)
+	}
+
+	// 心跳超时后移除
+	c.mu.Lock()
+	c.standaloneRelays["relay-1"].peer.LastSeen = time.Now().Add(-2 * relayHeartbeatTimeout)
+	c.expireRelaysLocked(time.Now())
+	_, exists := c.standaloneRelays["relay-1"]
+	c.mu.Unlock()
+	if exists {
+		t.Fatal("心跳超时的独立中继应被移除")
+	}
+}
+
+func TestRelayPairingStore(t *testing.T) {
+	s := NewRelayPairingStore()
+	pairing := RelayPairing{
+		Ticket:    "ticket-1",
+		NodeID:    "node-a",
+		DeviceID:  3,
+		UserID:    7,
+		PeerID:    "node-b",
+		PeerHost:  "198.51.100.2",
+		PeerPort:  40000,
+		ExpiresAt: time.Now().Add(relayTicketTTL),
+	}
+
+	// 客户端可能先于配对指令到达
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.Add(pairing)
+	}()
+	device, err := s.AuthenticateRelay(&RelayHandshake{TargetID: "node-b", AuthType: relayAuthTicket, Ticket: "ticket-1"})
+	if err != nil || device.ID != 3 || device.UserID != 7 || device.NodeID != "node-a" {
+		t.Fatalf("按配对指令验证票据失败: %v %v", device, err)
+	}
+	peer, err := s.GetPeerInfo("node-b")
+	if err != nil || peer.ExternalIP.String() != "198.51.100.2" || peer.ExternalPort != 40000 {
+		t.Fatalf("应记录对端地址: %v %v", peer, err)
+	}
+
+	// 票据与目标不符时拒绝，并且票据只能使用一次
+	pairing.Ticket = "ticket-2"
+	s.Add(pairing)
+	if _, err := s.AuthenticateRelay(&RelayHandshake{TargetID: "node-c", AuthType: relayAuthTicket, Ticket: "ticket-2"}); err == nil {
+		t.Fatal("目标不符时应拒绝")
+	}
+
+	if _, err := s.AuthenticateRelay(&RelayHandshake{TargetID: "node-b", AuthType: relayAuthCert, Certificate: []byte{1}}); err == nil {
+		t.Fatal("独立中继只接受票据认证")
+	}
+}
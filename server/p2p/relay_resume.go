@@ -0,0 +1,74 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/resume"
+)
+
+// makeResumable 将会话的源连接改为可恢复的会话，返回握手响应：
+//
+//	OK RESUMABLE <sessionTicket> <graceSeconds>
+//
+// 未开启会话恢复时响应 OK。两种响应都以换行结尾，客户端据此区分响应和之后的数据
+func (s *RelayServer) makeResumable(session *RelaySession) (string, error) {
+	grace := s.config.Relay.ResumeGrace
+	if grace <= 0 {
+		return "OK\n", nil
+	}
+
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成会话票据失败: %w", err)
+	}
+	session.ResumeTicket = hex.EncodeToString(buf)
+	session.SourceConn = resume.New(resume.Config{
+		Window: s.config.Relay.ReplayWindow << 10,
+		Grace:  time.Duration(grace) * time.Second,
+		OnDisconnect: func(err error) {
+			logger.Info("中继会话 %s 的源节点连接中断，等待恢复: %v", session.ID, err)
+		},
+	})
+	return fmt.Sprintf("OK %s %s %d\n", relayResumable, session.ResumeTicket, grace), nil
+}
+
+// resumeSession 源节点凭会话票据重连后恢复会话，响应中继已接收的字节数：
+//
+//	OK <received>
+//
+// 返回连接是否已交给会话
+func (s *RelayServer) resumeSession(conn net.Conn, request string) bool {
+	ticket, received, err := ParseRelayResume(request)
+	if err != nil {
+		logger.Error("无效的会话恢复请求: %v", err)
+		conn.Write([]byte("ERROR: Invalid request"))
+		return false
+	}
+
+	s.mu.RLock()
+	session := s.resumable[ticket]
+	s.mu.RUnlock()
+	if session == nil {
+		logger.Warn("恢复中继会话失败: %s 的会话不存在或已过期", conn.RemoteAddr())
+		conn.Write([]byte("ERROR: Session not found"))
+		return false
+	}
+	src := session.SourceConn.(*resume.Session)
+
+	if _, err := fmt.Fprintf(conn, "OK %d\n", src.Received()); err != nil {
+		return false
+	}
+	conn.SetDeadline(time.Time{})
+	if err := src.Attach(conn, received); err != nil {
+		logger.Warn("恢复中继会话 %s 失败: %v", session.ID, err)
+		return false
+	}
+
+	logger.Info("中继会话已恢复: %s -> %s (%s)", session.SourceID, session.TargetID, conn.RemoteAddr())
+	return true
+}
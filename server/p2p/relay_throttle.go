@@ -0,0 +1,139 @@
+package p2p
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/shaping"
+)
+
+// RelayThrottledCode 中继限速通知的错误码
+const RelayThrottledCode = "RELAY_THROTTLED"
+
+// throttleNoticeInterval 同一会话发送限速通知的最小间隔
+const throttleNoticeInterval = 10 * time.Second
+
+// RelayThrottleNotice 中继限速通知，语义与 HTTP 429 相同
+type RelayThrottleNotice struct {
+	Code       string `json:"code"`
+	Status     int    `json:"status"`
+	SessionID  string `json:"sessionId"`
+	TargetID   string `json:"targetId"`
+	Direction  string `json:"direction"`
+	LimitMbps  int    `json:"limitMbps"`
+	RetryAfter int64  `json:"retryAfter"` // 单位：毫秒
+}
+
+// newUserLimiter 根据中继配置创建用户带宽限速器
+func newUserLimiter(cfg *config.Config) *shaping.Limiter {
+	limits := shaping.Limits{
+		Upload:   mbpsToBytes(cfg.Relay.UserUploadLimit),
+		Download: mbpsToBytes(cfg.Relay.UserDownloadLimit),
+		Burst:    int64(cfg.Relay.UserBurst) * 1024 * 1024,
+	}
+
+	var store shaping.Store
+	if cfg.Relay.SharedLimits {
+		store = shaping.NewRedisStore(cfg.Redis.Host, cfg.Redis.Port, cfg.Redis.Password, cfg.Redis.DB)
+	}
+	return shaping.NewLimiter(store, limits)
+}
+
+// mbpsToBytes 将 Mbps 转换为字节/秒
+func mbpsToBytes(mbps int) int64 {
+	if mbps <= 0 {
+		return 0
+	}
+	return int64(mbps) * 1000 * 1000 / 8
+}
+
+// SetThrottleNotifier 设置限速通知的发送函数，通常通过信令通知会话的源节点
+func (s *RelayServer) SetThrottleNotifier(notifier func(nodeID string, notice *RelayThrottleNotice)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.throttleNotifier = notifier
+}
+
+// throttle 按会话所属用户和会话自身的带宽限制等待，upload 表示源节点发往目标节点的流量。
+// 服务器停止时返回 false
+func (s *RelayServer) throttle(ctx context.Context, session *RelaySession, upload bool, n int) bool {
+	if !s.limiter.Enabled() && session.bandwidth == nil {
+		return true
+	}
+
+	dir := shaping.Download
+	if upload {
+		dir = shaping.Upload
+	}
+
+	var wait time.Duration
+	if s.limiter.Enabled() {
+		wait = s.limiter.Reserve(session.UserID, dir, n)
+	}
+	// 会话限制比用户限制更严格时按会话限制等待
+	sessionLimited := false
+	if session.bandwidth != nil {
+		rate := mbpsToBytes(session.BandwidthLimit)
+		if w, _ := session.bandwidth.Reserve(string(dir), int64(n), rate, rate); w > wait {
+			wait, sessionLimited = w, true
+		}
+	}
+	if wait <= 0 {
+		return true
+	}
+
+	s.notifyThrottled(session, dir, wait, sessionLimited)
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// notifyThrottled 通知会话的源节点已被限速，同一会话在 throttleNoticeInterval 内只通知一次。
+// sessionLimited 表示超出的是会话自身的带宽限制，同时上报限制事件
+func (s *RelayServer) notifyThrottled(session *RelaySession, dir shaping.Direction, wait time.Duration, sessionLimited bool) {
+	session.mu.Lock()
+	if time.Since(session.ThrottledAt) < throttleNoticeInterval {
+		session.mu.Unlock()
+		return
+	}
+	session.ThrottledAt = time.Now()
+	session.mu.Unlock()
+
+	s.mu.RLock()
+	notifier := s.throttleNotifier
+	s.mu.RUnlock()
+
+	var limit int
+	if sessionLimited {
+		limit = session.BandwidthLimit
+		s.reportLimited(s.quota.bandwidthEvent(session))
+	} else {
+		limit = s.config.Relay.UserDownloadLimit
+		if dir == shaping.Upload {
+			limit = s.config.Relay.UserUploadLimit
+		}
+		logger.Info("用户 %d 的中继流量超出限制 (%s %d Mbps): %s -> %s", session.UserID, dir, limit, session.SourceID, session.TargetID)
+	}
+
+	if notifier == nil {
+		return
+	}
+	notifier(session.SourceID, &RelayThrottleNotice{
+		Code:       RelayThrottledCode,
+		Status:     http.StatusTooManyRequests,
+		SessionID:  session.ID,
+		TargetID:   session.TargetID,
+		Direction:  string(dir),
+		LimitMbps:  limit,
+		RetryAfter: wait.Milliseconds(),
+	})
+}
@@ -0,0 +1,242 @@
+package p2p
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/server/config"
+)
+
+// SignalRateLimitedCode 信令超出速率限制的错误码
+const SignalRateLimitedCode = "SIGNAL_RATE_LIMITED"
+
+// SignalLimitAll 超出的是所有类型合计的限制，否则为信令类型
+const SignalLimitAll = "all"
+
+// signalNoticeInterval 同一节点两次限流错误信令的最小间隔，避免回复本身放大流量
+const signalNoticeInterval = time.Second
+
+// signalQuotaIdle 超过该时间没有发送信令的节点的令牌桶被清理，之后重新发送时桶是满的，效果相同
+const signalQuotaIdle = 5 * time.Minute
+
+// maxDropNodes 统计中列出的丢弃信令最多的节点数
+const maxDropNodes = 20
+
+// SignalThrottle 信令被丢弃时回复节点的错误信令内容
+type SignalThrottle struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Type       string `json:"type"`       // 被丢弃的信令类型
+	Limit      string `json:"limit"`      // 超出的限制，all 或信令类型
+	RetryAfter int64  `json:"retryAfter"` // 建议的等待时间，单位：毫秒
+	Dropped    uint64 `json:"dropped"`    // 该节点累计被丢弃的信令数
+}
+
+// NodeSignalDrops 单个节点被丢弃的信令统计
+type NodeSignalDrops struct {
+	NodeID        string    `json:"nodeId"`
+	Dropped       uint64    `json:"dropped"`
+	LastDroppedAt time.Time `json:"lastDroppedAt"`
+}
+
+// SignalLimitStats 信令速率限制和丢弃统计，Nodes 为最近发送过信令的节点中丢弃最多的节点
+type SignalLimitStats struct {
+	Limits  config.SignalingLimitsConfig `json:"limits"`
+	Dropped uint64                       `json:"dropped"`
+	ByType  map[string]uint64            `json:"byType"`
+	Nodes   []NodeSignalDrops            `json:"nodes"`
+}
+
+// tokenBucket 令牌桶，每条信令消耗一个令牌
+type tokenBucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// burstOf 限制的突发条数，未设置时为一秒的条数，至少为 1
+func burstOf(rate float64, burst int) float64 {
+	if burst > 0 {
+		return float64(burst)
+	}
+	return math.Max(1, math.Ceil(rate))
+}
+
+// refill 按经过的时间补充令牌，新建的桶是满的。返回令牌不足一个时需要等待的时间，rate 为 0 时不限制
+func (b *tokenBucket) refill(rate float64, burst int, now time.Time) time.Duration {
+	if rate <= 0 {
+		return 0
+	}
+	capacity := burstOf(rate, burst)
+	if b.updatedAt.IsZero() {
+		b.tokens = capacity
+	} else if elapsed := now.Sub(b.updatedAt).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(capacity, b.tokens+elapsed*rate)
+	}
+	b.updatedAt = now
+
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// nodeSignalQuota 单个节点的令牌桶和丢弃统计
+type nodeSignalQuota struct {
+	all        tokenBucket
+	types      map[protocol.SignalType]*tokenBucket
+	dropped    uint64
+	droppedAt  time.Time
+	notifiedAt time.Time
+	lastSeen   time.Time
+}
+
+// signalQuota 按节点限制发送信令的速率。令牌桶按节点 ID 保存，重新连接不会重置额度
+type signalQuota struct {
+	limits  config.SignalingLimitsConfig
+	nodes   map[string]*nodeSignalQuota
+	dropped uint64
+	byType  map[protocol.SignalType]uint64
+	mu      sync.Mutex
+}
+
+// newSignalQuota 根据配置创建信令配额
+func newSignalQuota(limits config.SignalingLimitsConfig) *signalQuota {
+	return &signalQuota{
+		limits: limits,
+		nodes:  make(map[string]*nodeSignalQuota),
+		byType: make(map[protocol.SignalType]uint64),
+	}
+}
+
+// allow 检查节点能否发送该类型的信令，需要同时满足合计和所属类型的限制，满足时各消耗一个令牌。
+// 超出限制时记入丢弃统计并返回回复节点的错误信令内容，距上次回复不足 signalNoticeInterval 时 notice 为 nil
+func (q *signalQuota) allow(nodeID string, signalType protocol.SignalType, now time.Time) (ok bool, notice *SignalThrottle) {
+	typeLimit, hasTypeLimit := q.limits.Types[string(signalType)]
+	if q.limits.Rate <= 0 && (!hasTypeLimit || typeLimit.Rate <= 0) {
+		return true, nil
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	node, exists := q.nodes[nodeID]
+	if !exists {
+		node = &nodeSignalQuota{types: make(map[protocol.SignalType]*tokenBucket)}
+		q.nodes[nodeID] = node
+	}
+	node.lastSeen = now
+
+	limit := ""
+	wait := node.all.refill(q.limits.Rate, q.limits.Burst, now)
+	if wait > 0 {
+		limit = SignalLimitAll
+	}
+	var typeBucket *tokenBucket
+	if hasTypeLimit && typeLimit.Rate > 0 {
+		if typeBucket = node.types[signalType]; typeBucket == nil {
+			typeBucket = &tokenBucket{}
+			node.types[signalType] = typeBucket
+		}
+		if typeWait := typeBucket.refill(typeLimit.Rate, typeLimit.Burst, now); typeWait > wait {
+			wait, limit = typeWait, string(signalType)
+		}
+	}
+
+	if limit == "" {
+		if q.limits.Rate > 0 {
+			node.all.tokens--
+		}
+		if typeBucket != nil {
+			typeBucket.tokens--
+		}
+		return true, nil
+	}
+
+	q.dropped++
+	q.byType[signalType]++
+	node.dropped++
+	node.droppedAt = now
+	if now.Sub(node.notifiedAt) < signalNoticeInterval {
+		return false, nil
+	}
+	node.notifiedAt = now
+	return false, &SignalThrottle{
+		Code:       SignalRateLimitedCode,
+		Message:    "信令发送过于频繁，已被丢弃",
+		Type:       string(signalType),
+		Limit:      limit,
+		RetryAfter: int64(math.Ceil(float64(wait) / float64(time.Millisecond))),
+		Dropped:    node.dropped,
+	}
+}
+
+// prune 清理长时间没有发送信令的节点
+func (q *signalQuota) prune(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for nodeID, node := range q.nodes {
+		if now.Sub(node.lastSeen) > signalQuotaIdle {
+			delete(q.nodes, nodeID)
+		}
+	}
+}
+
+// stats 汇总速率限制和丢弃统计
+func (q *signalQuota) stats() SignalLimitStats {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stats := SignalLimitStats{
+		Limits:  q.limits,
+		Dropped: q.dropped,
+		ByType:  make(map[string]uint64, len(q.byType)),
+		Nodes:   []NodeSignalDrops{},
+	}
+	for signalType, dropped := range q.byType {
+		stats.ByType[string(signalType)] = dropped
+	}
+	for nodeID, node := range q.nodes {
+		if node.dropped > 0 {
+			stats.Nodes = append(stats.Nodes, NodeSignalDrops{NodeID: nodeID, Dropped: node.dropped, LastDroppedAt: node.droppedAt})
+		}
+	}
+	sort.Slice(stats.Nodes, func(i, j int) bool {
+		if stats.Nodes[i].Dropped != stats.Nodes[j].Dropped {
+			return stats.Nodes[i].Dropped > stats.Nodes[j].Dropped
+		}
+		return stats.Nodes[i].NodeID < stats.Nodes[j].NodeID
+	})
+	if len(stats.Nodes) > maxDropNodes {
+		stats.Nodes = stats.Nodes[:maxDropNodes]
+	}
+	return stats
+}
+
+// allowSignal 检查客户端发送的信令是否超出速率限制，超出时丢弃并回复错误信令
+func (s *SignalingServer) allowSignal(client *Client, signal *protocol.Signal) bool {
+	ok, notice := s.quota.allow(client.NodeID, signal.Type, time.Now())
+	if ok {
+		return true
+	}
+	if notice != nil {
+		logger.Warn("节点 %s 发送信令过于频繁，丢弃 %s 信令（超出 %s 限制，累计丢弃 %d 条）",
+			client.NodeID, signal.Type, notice.Limit, notice.Dropped)
+		s.sendSignal(client, &protocol.Signal{
+			Type:       protocol.SignalError,
+			SenderID:   "server",
+			ReceiverID: client.NodeID,
+			Payload:    notice,
+			Timestamp:  time.Now(),
+		})
+	}
+	return false
+}
+
+// SignalLimitStats 获取信令速率限制和被丢弃的信令统计
+func (s *SignalingServer) SignalLimitStats() SignalLimitStats {
+	return s.quota.stats()
+}
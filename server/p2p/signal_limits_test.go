@@ -0,0 +1,116 @@
+package p2p
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/server/config"
+)
+
+func TestSignalQuota(t *testing.T) {
+	q := newSignalQuota(config.SignalingLimitsConfig{
+		Rate:  10,
+		Burst: 3,
+		Types: map[string]config.SignalRateLimit{"connect": {Rate: 1}},
+	})
+	now := time.Now()
+
+	// 按类型的限制未设置突发条数时为一秒的条数
+	if ok, _ := q.allow("node-a", protocol.SignalConnect, now); !ok {
+		t.Fatal("第一条 connect 信令应允许")
+	}
+	ok, notice := q.allow("node-a", protocol.SignalConnect, now)
+	if ok || notice == nil || notice.Limit != "connect" || notice.RetryAfter != 1000 {
+		t.Fatalf("超出 connect 限制时应丢弃并回复, notice = %+v", notice)
+	}
+
+	// 被丢弃的信令不消耗合计额度，合计限制对其他类型生效
+	for i := 0; i < 2; i++ {
+		if ok, _ := q.allow("node-a", protocol.SignalOffer, now); !ok {
+			t.Fatalf("第 %d 条 offer 信令应允许", i+1)
+		}
+	}
+	ok, notice = q.allow("node-a", protocol.SignalOffer, now)
+	if ok || notice != nil {
+		t.Fatalf("一秒内只回复一次限流错误, notice = %+v", notice)
+	}
+
+	// 其他节点的额度独立
+	if ok, _ := q.allow("node-b", protocol.SignalOffer, now); !ok {
+		t.Fatal("其他节点不应受影响")
+	}
+
+	// 令牌按时间补充
+	now = now.Add(time.Second)
+	if ok, _ := q.allow("node-a", protocol.SignalConnect, now); !ok {
+		t.Fatal("补充令牌后应允许")
+	}
+	if ok, notice := q.allow("node-a", protocol.SignalConnect, now); ok || notice == nil || notice.Dropped != 3 {
+		t.Fatalf("超过通知间隔后应再次回复, notice = %+v", notice)
+	}
+
+	stats := q.stats()
+	if stats.Dropped != 3 || stats.ByType["connect"] != 2 || stats.ByType["offer"] != 1 {
+		t.Fatalf("丢弃统计 = %+v", stats)
+	}
+	if len(stats.Nodes) != 1 || stats.Nodes[0].NodeID != "node-a" || stats.Nodes[0].Dropped != 3 {
+		t.Fatalf("节点丢弃统计 = %+v", stats.Nodes)
+	}
+
+	q.prune(now.Add(signalQuotaIdle + time.Second))
+	if len(q.stats().Nodes) != 0 || q.stats().Dropped != 3 {
+		t.Fatal("清理空闲节点后应保留累计统计")
+	}
+
+	// 未配置限制时不限制
+	unlimited := newSignalQuota(config.SignalingLimitsConfig{})
+	for i := 0; i < 1000; i++ {
+		if ok, _ := unlimited.allow("node-a", protocol.SignalOffer, now); !ok {
+			t.Fatal("未配置限制时不应丢弃")
+		}
+	}
+}
+
+func TestSignalRateLimited(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	cfg.P2P.SignalingLimits = config.SignalingLimitsConfig{
+		Types: map[string]config.SignalRateLimit{"offer": {Rate: 1, Burst: 2}},
+	}
+	s := NewSignalingServer(cfg, NewCoordinator(cfg, nil, nil), nil, nil)
+	pollRequest(s.HandlePoll, "node-a", http.MethodGet, "/signal/poll?wait=0", "")
+	pollRequest(s.HandlePoll, "node-b", http.MethodGet, "/signal/poll?wait=0", "")
+
+	offer := `{"type":"offer","receiverId":"node-b","payload":{"sdp":"v=0"}}`
+	w := pollRequest(s.HandlePollSend, "node-a", http.MethodPost, "/signal/send",
+		`{"signals":[`+offer+`,`+offer+`,`+offer+`,`+offer+`]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("发送信令失败: %d %s", w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Signals []protocol.Signal `json:"signals"`
+	}
+	w = pollRequest(s.HandlePoll, "node-b", http.MethodGet, "/signal/poll?wait=0", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Signals) != 2 {
+		t.Fatalf("接收者应只收到未超出限制的 2 条信令: %s", w.Body.String())
+	}
+
+	// 发送者收到一条限流错误信令
+	resp.Signals = nil
+	w = pollRequest(s.HandlePoll, "node-a", http.MethodGet, "/signal/poll?wait=0", "")
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || len(resp.Signals) != 1 || resp.Signals[0].Type != protocol.SignalError {
+		t.Fatalf("发送者应收到一条限流错误信令: %s", w.Body.String())
+	}
+	if payload, ok := resp.Signals[0].Payload.(map[string]interface{}); !ok || payload["code"] != SignalRateLimitedCode || payload["type"] != "offer" {
+		t.Fatalf("限流错误信令 = %+v", resp.Signals[0].Payload)
+	}
+
+	if stats := s.SignalLimitStats(); stats.Dropped != 2 || stats.ByType["offer"] != 2 {
+		t.Fatalf("丢弃统计 = %+v", stats)
+	}
+}
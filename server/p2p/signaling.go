@@ -1,8 +1,11 @@
 package p2p
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"sync"
 	"time"
@@ -11,42 +14,33 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/senma231/p3/common/errors"
 	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/common/signing"
+	"github.com/senma231/p3/server/abuse"
 	"github.com/senma231/p3/server/auth"
+	"github.com/senma231/p3/server/chaos"
 	"github.com/senma231/p3/server/config"
+	"github.com/senma231/p3/server/db"
 	"github.com/senma231/p3/server/device"
 )
 
-// SignalType 信令类型
-type SignalType string
-
+// 信令传输方式
 const (
-	SignalOffer           SignalType = "offer"
-	SignalAnswer          SignalType = "answer"
-	SignalICECandidate    SignalType = "ice-candidate"
-	SignalConnect         SignalType = "connect"
-	SignalDisconnect      SignalType = "disconnect"
-	SignalPing            SignalType = "ping"
-	SignalPong            SignalType = "pong"
-	SignalRelayRequest    SignalType = "relay-request"
-	SignalRelayResponse   SignalType = "relay-response"
-	SignalError           SignalType = "error"
+	TransportWebSocket = "websocket"
+	TransportPoll      = "poll"
 )
 
-// Signal 信令消息
-type Signal struct {
-	Type      SignalType  `json:"type"`
-	SenderID  string      `json:"senderId"`
-	ReceiverID string     `json:"receiverId,omitempty"`
-	Payload   interface{} `json:"payload,omitempty"`
-	Timestamp time.Time   `json:"timestamp"`
-}
-
-// Client WebSocket 客户端
+// Client 信令客户端，通过 WebSocket 或 HTTPS 长轮询连接
 type Client struct {
 	NodeID     string
 	DeviceID   uint
-	Conn       *websocket.Conn
-	Send       chan []byte
+	UserID     uint
+	Transport  string
+	Instance   string          // 客户端进程的实例 ID，同一进程切换传输方式时不变，旧版本的客户端为空
+	RemoteIP   net.IP          // 连接的来源地址
+	Conn       *websocket.Conn // 使用长轮询时为 nil
+	Binary     bool            // 协商了 MessagePack 子协议，信令以二进制帧发送
+	Send       *protocol.SendQueue // 按优先级发送的信令队列
 	LastActive time.Time
 }
 
@@ -57,50 +51,68 @@ type SignalingServer struct {
 	authService    *auth.Service
 	deviceService  *device.Service
 	clients        map[string]*Client
+	presence       *subscriptions
+	stunServers    []string // 下发给客户端的 STUN 服务器列表，受 mu 保护
+	bans           *abuse.BanList // 受 mu 保护，为 nil 时不检查封禁
+	quota          *signalQuota   // 按节点限制发送信令的速率
 	upgrader       websocket.Upgrader
 	mu             sync.RWMutex
-	stopCh         chan struct{}
+	// ctx 在 Stop 时取消，wg 等待清理协程和 WebSocket 读写协程退出
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
 }
 
 // NewSignalingServer 创建信令服务器
 func NewSignalingServer(cfg *config.Config, coordinator *Coordinator, authService *auth.Service, deviceService *device.Service) *SignalingServer {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &SignalingServer{
 		config:         cfg,
 		coordinator:    coordinator,
 		authService:    authService,
 		deviceService:  deviceService,
 		clients:        make(map[string]*Client),
+		presence:       newSubscriptions(),
+		stunServers:    append([]string(nil), cfg.P2P.STUNServers...),
+		quota:          newSignalQuota(cfg.P2P.SignalingLimits),
 		upgrader: websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
+			ReadBufferSize:    1024,
+			WriteBufferSize:   1024,
+			Subprotocols:      []string{protocol.SubprotocolMsgpack, protocol.SubprotocolJSON},
+			EnableCompression: cfg.P2P.SignalingCompression,
 			CheckOrigin: func(r *http.Request) bool {
 				return true // 允许所有来源
 			},
 		},
-		stopCh: make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
 	}
 }
 
 // Start 启动信令服务器
 func (s *SignalingServer) Start() {
 	// 启动清理协程
+	s.wg.Add(1)
 	go s.cleanupLoop()
 	logger.Info("信令服务器已启动")
 }
 
-// Stop 停止信令服务器
+// Stop 停止信令服务器，断开所有客户端并等待协程退出。停止后不再接受新的客户端
 func (s *SignalingServer) Stop() {
-	close(s.stopCh)
-	
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	
-	// 关闭所有客户端连接
-	for _, client := range s.clients {
-		client.Conn.Close()
-		close(client.Send)
+	s.cancel()
+
+	// 关闭所有客户端连接。客户端从列表中移除，读协程退出时不会再次关闭发送队列
+	for nodeID, client := range s.clients {
+		if client.Conn != nil {
+			client.Conn.Close()
+		}
+		client.Send.Close()
+		delete(s.clients, nodeID)
 	}
-	
+	s.mu.Unlock()
+
+	s.wg.Wait()
 	logger.Info("信令服务器已停止")
 }
 
@@ -120,6 +132,16 @@ func (s *SignalingServer) HandleWebSocket(c *gin.Context) {
 		return
 	}
 
+	if s.ctx.Err() != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "信令服务器已停止"})
+		return
+	}
+	instance := c.GetHeader("X-Node-Instance")
+	if s.duplicateRejected(nodeID.(string), instance) {
+		c.JSON(http.StatusConflict, gin.H{"error": "节点已连接"})
+		return
+	}
+
 	// 升级 HTTP 连接为 WebSocket
 	conn, err := s.upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
@@ -131,34 +153,109 @@ func (s *SignalingServer) HandleWebSocket(c *gin.Context) {
 	client := &Client{
 		NodeID:     nodeID.(string),
 		DeviceID:   deviceID.(uint),
+		UserID:     c.GetUint("userID"),
+		Transport:  TransportWebSocket,
+		Instance:   instance,
 		Conn:       conn,
-		Send:       make(chan []byte, 256),
+		Binary:     conn.Subprotocol() == protocol.SubprotocolMsgpack,
+		Send:       protocol.NewSendQueue(protocol.DefaultQueueCapacity),
 		LastActive: time.Now(),
 	}
 
-	// 注册客户端
+	// 启动读写协程
+	if err := s.registerClient(c, client); err != nil {
+		// 升级后才发现重复连接时以关闭帧告知原因
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()),
+			time.Now().Add(time.Second))
+		conn.Close()
+		return
+	}
+	go s.readPump(client)
+	go s.writePump(client)
+}
+
+// registerClient 注册客户端并发送欢迎消息。同一节点已有连接时按 p2p.duplicateNode 配置由新连接接管，
+// 或者拒绝新连接并返回 errNodeConnected；同一客户端在 WebSocket 和长轮询之间切换时总是接管，
+// 不需要等待旧连接超时。服务器已停止时返回 errSignalingStopped
+func (s *SignalingServer) registerClient(c *gin.Context, client *Client) error {
+	client.RemoteIP = net.ParseIP(c.ClientIP())
 	s.mu.Lock()
+	if s.ctx.Err() != nil {
+		s.mu.Unlock()
+		return errSignalingStopped
+	}
+	old, exists := s.clients[client.NodeID]
+	if exists && s.rejectsDuplicate(old, client) {
+		s.mu.Unlock()
+		logger.Warn("节点 %s 已有信令连接 (%s)，拒绝新连接 (%s)", client.NodeID, old.Transport, client.Transport)
+		return errNodeConnected
+	}
+	// WebSocket 客户端的读写协程在锁内计数，保证 Stop 等待时已计入
+	if client.Conn != nil {
+		s.wg.Add(2)
+	}
+	if exists {
+		s.supersede(old, client)
+	}
 	s.clients[client.NodeID] = client
 	s.mu.Unlock()
 
-	logger.Info("WebSocket 客户端已连接: %s", client.NodeID)
+	// 切换传输方式不改变在线状态
+	if !exists {
+		s.notifyPresence(client, true)
+	}
 
-	// 启动读写协程
-	go s.readPump(client)
-	go s.writePump(client)
+	// 记录节点区域，优先使用连接时上报的区域，其次是心跳中保存的区域
+	region := c.GetHeader("X-Node-Region")
+	if region == "" {
+		if dev, ok := c.Get("device"); ok {
+			if d, ok := dev.(*db.Device); ok {
+				region = d.Region
+			}
+		}
+	}
+	s.coordinator.SetPeerRegion(client.NodeID, region)
+	s.coordinator.SetPeerAddress(client.NodeID, client.RemoteIP)
+
+	logger.Info("信令客户端已连接: %s (%s)", client.NodeID, client.Transport)
 
-	// 发送欢迎消息
-	welcomeSignal := Signal{
-		Type:      SignalPing,
+	// 发送欢迎消息，附带默认的连接优先级，客户端未在本地配置时使用；
+	// 配置了下发的 STUN 服务器时一并附带
+	welcome := map[string]interface{}{
+		"candidatePriority": s.config.P2P.CandidatePriority,
+	}
+	if servers := s.STUNServers(); len(servers) > 0 {
+		welcome["stunServers"] = servers
+	}
+	welcomeSignal := protocol.Signal{
+		Type:      protocol.SignalPing,
 		SenderID:  "server",
+		Payload:   welcome,
 		Timestamp: time.Now(),
 	}
 	data, _ := json.Marshal(welcomeSignal)
-	client.Send <- data
+	enqueue(client, welcomeSignal.Type, data)
+
+	// 提示版本低于推荐版本的客户端升级
+	if c.GetString("versionStatus") == device.VersionOutdated {
+		s.sendSignal(client, &protocol.Signal{
+			Type:       protocol.SignalUpgradeRecommended,
+			SenderID:   "server",
+			ReceiverID: client.NodeID,
+			Payload: map[string]interface{}{
+				"currentVersion":     c.GetString("clientVersion"),
+				"recommendedVersion": s.config.Client.RecommendedVersion,
+				"minVersion":         s.config.Client.MinVersion,
+			},
+			Timestamp: time.Now(),
+		})
+	}
+	return nil
 }
 
 // readPump 从 WebSocket 读取数据
 func (s *SignalingServer) readPump(client *Client) {
+	defer s.wg.Done()
 	defer func() {
 		s.unregisterClient(client)
 		client.Conn.Close()
@@ -173,7 +270,7 @@ func (s *SignalingServer) readPump(client *Client) {
 	})
 
 	for {
-		_, message, err := client.Conn.ReadMessage()
+		messageType, message, err := client.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				logger.Error("WebSocket 读取错误: %v", err)
@@ -181,24 +278,36 @@ func (s *SignalingServer) readPump(client *Client) {
 			break
 		}
 
-		// 解析信令消息
-		var signal Signal
-		if err := json.Unmarshal(message, &signal); err != nil {
-			logger.Error("解析信令消息失败: %v", err)
-			continue
+		messages := [][]byte{message}
+		if messageType == websocket.BinaryMessage {
+			if messages, err = protocol.DecodeMsgpack(message); err != nil {
+				logger.Error("解析信令消息失败: %v", err)
+				continue
+			}
 		}
 
-		// 设置发送者 ID
-		signal.SenderID = client.NodeID
-		signal.Timestamp = time.Now()
+		for _, message := range messages {
+			// 解析信令消息
+			signal, err := protocol.ParseSignal(message)
+			if err != nil {
+				logger.Error("解析信令消息失败: %v", err)
+				continue
+			}
+
+			// 设置发送者 ID
+			signal.SenderID = client.NodeID
+			signal.Timestamp = time.Now()
 
-		// 处理信令消息
-		s.handleSignal(client, &signal)
+			// 处理信令消息
+			s.handleSignal(client, signal)
+		}
 	}
 }
 
 // writePump 向 WebSocket 写入数据
 func (s *SignalingServer) writePump(client *Client) {
+	defer s.wg.Done()
+
 	ticker := time.NewTicker(30 * time.Second)
 	defer func() {
 		ticker.Stop()
@@ -207,28 +316,25 @@ func (s *SignalingServer) writePump(client *Client) {
 
 	for {
 		select {
-		case message, ok := <-client.Send:
+		case <-client.Send.Done():
+			// 队列已关闭，先发送关闭前排队的消息，例如连接被替换的通知
 			client.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
-			if !ok {
-				// 通道已关闭
-				client.Conn.WriteMessage(websocket.CloseMessage, []byte{})
-				return
-			}
-
-			w, err := client.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				return
+			if messages := popAll(client); len(messages) > 0 {
+				if err := writeFrame(client, messages); err != nil {
+					return
+				}
 			}
-			w.Write(message)
-
-			// 添加队列中的消息
-			n := len(client.Send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-client.Send)
+			client.Conn.WriteMessage(websocket.CloseMessage, []byte{})
+			return
+		case <-client.Send.Ready():
+			// 按优先级取出队列中的消息，合并为一帧发送
+			messages := popAll(client)
+			if len(messages) == 0 {
+				continue
 			}
 
-			if err := w.Close(); err != nil {
+			client.Conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := writeFrame(client, messages); err != nil {
 				return
 			}
 		case <-ticker.C:
@@ -240,39 +346,83 @@ func (s *SignalingServer) writePump(client *Client) {
 	}
 }
 
+// popAll 按优先级取出队列中的全部消息
+func popAll(client *Client) [][]byte {
+	var messages [][]byte
+	for {
+		message, ok := client.Send.Pop()
+		if !ok {
+			return messages
+		}
+		messages = append(messages, message)
+	}
+}
+
+// writeFrame 将多条信令合并为一帧写入。协商了 MessagePack 的客户端使用二进制帧，否则使用换行分隔的文本帧；
+// 协商了压缩时只压缩超过阈值的帧
+func writeFrame(client *Client, messages [][]byte) error {
+	frameType := websocket.TextMessage
+	var frame []byte
+	if client.Binary {
+		frameType = websocket.BinaryMessage
+		for _, message := range messages {
+			encoded, err := protocol.EncodeMsgpack(message)
+			if err != nil {
+				logger.Error("编码信令消息失败: %v", err)
+				continue
+			}
+			frame = append(frame, encoded...)
+		}
+	} else {
+		frame = bytes.Join(messages, []byte{'\n'})
+	}
+
+	client.Conn.EnableWriteCompression(len(frame) >= protocol.CompressionThreshold)
+	return client.Conn.WriteMessage(frameType, frame)
+}
+
 // handleSignal 处理信令消息
-func (s *SignalingServer) handleSignal(client *Client, signal *Signal) {
+func (s *SignalingServer) handleSignal(client *Client, signal *protocol.Signal) {
 	// 更新最后活动时间
 	client.LastActive = time.Now()
 
+	// 超出速率限制的信令直接丢弃
+	if !s.allowSignal(client, signal) {
+		return
+	}
+
 	// 处理不同类型的信令
 	switch signal.Type {
-	case SignalPing:
+	case protocol.SignalPing:
 		// 回复 pong
-		pongSignal := Signal{
-			Type:      SignalPong,
+		pongSignal := protocol.Signal{
+			Type:      protocol.SignalPong,
 			SenderID:  "server",
 			ReceiverID: client.NodeID,
 			Timestamp: time.Now(),
 		}
 		s.sendSignal(client, &pongSignal)
 
-	case SignalConnect:
+	case protocol.SignalConnect:
 		// 处理连接请求
 		s.handleConnectSignal(client, signal)
 
-	case SignalOffer, SignalAnswer, SignalICECandidate:
+	case protocol.SignalOffer, protocol.SignalAnswer, protocol.SignalICECandidate:
 		// 转发给接收者
 		s.forwardSignal(signal)
 
-	case SignalRelayRequest:
+	case protocol.SignalRelayRequest:
 		// 处理中继请求
 		s.handleRelayRequest(client, signal)
 
+	case protocol.SignalSubscribe, protocol.SignalUnsubscribe:
+		// 订阅节点的在线状态
+		s.handlePresenceSignal(client, signal)
+
 	default:
 		// 未知信令类型
-		errorSignal := Signal{
-			Type:      SignalError,
+		errorSignal := protocol.Signal{
+			Type:      protocol.SignalError,
 			SenderID:  "server",
 			ReceiverID: client.NodeID,
 			Payload:   "未知的信令类型",
@@ -283,11 +433,11 @@ func (s *SignalingServer) handleSignal(client *Client, signal *Signal) {
 }
 
 // handleConnectSignal 处理连接请求
-func (s *SignalingServer) handleConnectSignal(client *Client, signal *Signal) {
+func (s *SignalingServer) handleConnectSignal(client *Client, signal *protocol.Signal) {
 	// 检查接收者是否存在
 	if signal.ReceiverID == "" {
-		errorSignal := Signal{
-			Type:      SignalError,
+		errorSignal := protocol.Signal{
+			Type:      protocol.SignalError,
 			SenderID:  "server",
 			ReceiverID: client.NodeID,
 			Payload:   "接收者 ID 不能为空",
@@ -303,8 +453,8 @@ func (s *SignalingServer) handleConnectSignal(client *Client, signal *Signal) {
 	s.mu.RUnlock()
 
 	if !exists {
-		errorSignal := Signal{
-			Type:      SignalError,
+		errorSignal := protocol.Signal{
+			Type:      protocol.SignalError,
 			SenderID:  "server",
 			ReceiverID: client.NodeID,
 			Payload:   "接收者不在线",
@@ -317,8 +467,8 @@ func (s *SignalingServer) handleConnectSignal(client *Client, signal *Signal) {
 	// 确定连接类型
 	connectionType, err := s.coordinator.DetermineConnectionType(client.NodeID, signal.ReceiverID)
 	if err != nil {
-		errorSignal := Signal{
-			Type:      SignalError,
+		errorSignal := protocol.Signal{
+			Type:      protocol.SignalError,
 			SenderID:  "server",
 			ReceiverID: client.NodeID,
 			Payload:   fmt.Sprintf("确定连接类型失败: %v", err),
@@ -329,8 +479,8 @@ func (s *SignalingServer) handleConnectSignal(client *Client, signal *Signal) {
 	}
 
 	// 创建连接响应
-	connectResponse := Signal{
-		Type:      SignalConnect,
+	connectResponse := protocol.Signal{
+		Type:      protocol.SignalConnect,
 		SenderID:  "server",
 		ReceiverID: client.NodeID,
 		Payload: map[string]interface{}{
@@ -341,21 +491,25 @@ func (s *SignalingServer) handleConnectSignal(client *Client, signal *Signal) {
 	}
 	s.sendSignal(client, &connectResponse)
 
-	// 转发连接请求给接收者
-	forwardSignal := *signal
-	forwardSignal.Payload = map[string]interface{}{
-		"connectionType": connectionType.String(),
-		"sourceId":       client.NodeID,
+	// 转发连接请求给接收者，附带发起方的地址；双方在同一个 NAT 之后时附带发起方的局域网候选地址，
+	// 接收者优先连接局域网地址
+	forwardPayload := peerAddress(signal.Payload)
+	forwardPayload["connectionType"] = connectionType.String()
+	forwardPayload["sourceId"] = client.NodeID
+	if connectionType == protocol.ConnectionLAN {
+		forwardPayload["localCandidates"] = lanCandidates(signal.Payload)
 	}
+	forwardSignal := *signal
+	forwardSignal.Payload = forwardPayload
 	s.forwardSignal(&forwardSignal)
 }
 
 // handleRelayRequest 处理中继请求
-func (s *SignalingServer) handleRelayRequest(client *Client, signal *Signal) {
+func (s *SignalingServer) handleRelayRequest(client *Client, signal *protocol.Signal) {
 	// 检查接收者是否存在
 	if signal.ReceiverID == "" {
-		errorSignal := Signal{
-			Type:      SignalError,
+		errorSignal := protocol.Signal{
+			Type:      protocol.SignalError,
 			SenderID:  "server",
 			ReceiverID: client.NodeID,
 			Payload:   "接收者 ID 不能为空",
@@ -365,11 +519,24 @@ func (s *SignalingServer) handleRelayRequest(client *Client, signal *Signal) {
 		return
 	}
 
+	// 目标节点及其地址和端口被封禁时不分配中继，独立中继没有封禁列表，只能在这里检查
+	if ban := s.relayTargetBan(signal.ReceiverID); ban != nil {
+		logger.Warn("拒绝到被封禁目标的中继请求: %s -> %s", client.NodeID, signal.ReceiverID)
+		s.sendSignal(client, &protocol.Signal{
+			Type:       protocol.SignalError,
+			SenderID:   "server",
+			ReceiverID: client.NodeID,
+			Payload:    map[string]interface{}{"code": RelayBannedCode, "message": "目标节点已被封禁，无法使用中继"},
+			Timestamp:  time.Now(),
+		})
+		return
+	}
+
 	// 选择中继节点
 	relayNode, err := s.coordinator.SelectRelayNode(client.NodeID, signal.ReceiverID)
 	if err != nil {
-		errorSignal := Signal{
-			Type:      SignalError,
+		errorSignal := protocol.Signal{
+			Type:      protocol.SignalError,
 			SenderID:  "server",
 			ReceiverID: client.NodeID,
 			Payload:   fmt.Sprintf("选择中继节点失败: %v", err),
@@ -379,16 +546,35 @@ func (s *SignalingServer) handleRelayRequest(client *Client, signal *Signal) {
 		return
 	}
 
+	// 为双方签发一次性中继票据
+	sourceTicket, err := s.coordinator.IssueRelayTicket(client.NodeID, signal.ReceiverID)
+	if err != nil {
+		logger.Error("签发中继票据失败: %v", err)
+		return
+	}
+	targetTicket, err := s.coordinator.IssueRelayTicket(signal.ReceiverID, client.NodeID)
+	if err != nil {
+		logger.Error("签发中继票据失败: %v", err)
+		return
+	}
+
+	// 独立中继需要收到配对指令才能验证票据
+	if err := s.coordinator.PairRelay(relayNode.NodeID, client.NodeID, signal.ReceiverID, sourceTicket, targetTicket); err != nil {
+		logger.Error("下发中继配对指令失败: %v", err)
+		return
+	}
+
 	// 创建中继响应
-	relayResponse := Signal{
-		Type:      SignalRelayResponse,
+	relayResponse := protocol.Signal{
+		Type:      protocol.SignalRelayResponse,
 		SenderID:  "server",
 		ReceiverID: client.NodeID,
 		Payload: map[string]interface{}{
-			"relayId":   relayNode.NodeID,
-			"relayHost": relayNode.ExternalIP.String(),
-			"relayPort": relayNode.ExternalPort,
-			"targetId":  signal.ReceiverID,
+			"relayId":     relayNode.NodeID,
+			"relayHost":   relayNode.ExternalIP.String(),
+			"relayPort":   relayNode.ExternalPort,
+			"relayTicket": sourceTicket,
+			"targetId":    signal.ReceiverID,
 		},
 		Timestamp: time.Now(),
 	}
@@ -396,18 +582,19 @@ func (s *SignalingServer) handleRelayRequest(client *Client, signal *Signal) {
 
 	// 转发中继请求给接收者
 	forwardSignal := *signal
-	forwardSignal.Type = SignalRelayResponse
+	forwardSignal.Type = protocol.SignalRelayResponse
 	forwardSignal.Payload = map[string]interface{}{
-		"relayId":   relayNode.NodeID,
-		"relayHost": relayNode.ExternalIP.String(),
-		"relayPort": relayNode.ExternalPort,
-		"sourceId":  client.NodeID,
+		"relayId":     relayNode.NodeID,
+		"relayHost":   relayNode.ExternalIP.String(),
+		"relayPort":   relayNode.ExternalPort,
+		"relayTicket": targetTicket,
+		"sourceId":    client.NodeID,
 	}
 	s.forwardSignal(&forwardSignal)
 }
 
 // forwardSignal 转发信令消息
-func (s *SignalingServer) forwardSignal(signal *Signal) {
+func (s *SignalingServer) forwardSignal(signal *protocol.Signal) {
 	if signal.ReceiverID == "" {
 		logger.Error("转发信令失败: 接收者 ID 为空")
 		return
@@ -421,6 +608,9 @@ func (s *SignalingServer) forwardSignal(signal *Signal) {
 		logger.Error("转发信令失败: 接收者 %s 不在线", signal.ReceiverID)
 		return
 	}
+	if chaos.DropSignal() {
+		return
+	}
 
 	data, err := json.Marshal(signal)
 	if err != nil {
@@ -428,40 +618,101 @@ func (s *SignalingServer) forwardSignal(signal *Signal) {
 		return
 	}
 
-	receiver.Send <- data
+	enqueue(receiver, signal.Type, data)
+}
+
+// SendToNode 向指定节点发送信令消息
+func (s *SignalingServer) SendToNode(nodeID string, signal *protocol.Signal) error {
+	s.mu.RLock()
+	client, exists := s.clients[nodeID]
+	s.mu.RUnlock()
+
+	if !exists {
+		return errors.ServiceUnavailable(fmt.Sprintf("节点 %s 不在线", nodeID))
+	}
+
+	if signal.SenderID == "" {
+		signal.SenderID = "server"
+	}
+	signal.ReceiverID = nodeID
+	if signal.Timestamp.IsZero() {
+		signal.Timestamp = time.Now()
+	}
+
+	s.sendSignal(client, signal)
+	return nil
 }
 
 // sendSignal 发送信令消息
-func (s *SignalingServer) sendSignal(client *Client, signal *Signal) {
+func (s *SignalingServer) sendSignal(client *Client, signal *protocol.Signal) {
+	if chaos.DropSignal() {
+		return
+	}
 	data, err := json.Marshal(signal)
 	if err != nil {
 		logger.Error("序列化信令消息失败: %v", err)
 		return
 	}
 
-	client.Send <- data
+	enqueue(client, signal.Type, data)
+}
+
+// enqueue 按信令类型的优先级放入客户端的发送队列，队列已满或连接已关闭时丢弃
+func enqueue(client *Client, signalType protocol.SignalType, data []byte) bool {
+	if !client.Send.Push(protocol.SignalPriority(signalType), data) {
+		logger.Warn("节点 %s 的发送队列已满或连接已关闭，丢弃 %s 信令", client.NodeID, signalType)
+		return false
+	}
+	return true
+}
+
+// QueueStats 汇总当前所有连接的信令发送队列各优先级的统计
+func (s *SignalingServer) QueueStats() []protocol.QueueStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var total []protocol.QueueStats
+	for _, client := range s.clients {
+		total = protocol.MergeQueueStats(total, client.Send.Stats())
+	}
+	return protocol.MergeQueueStats(total, nil)
 }
 
 // unregisterClient 注销客户端
 func (s *SignalingServer) unregisterClient(client *Client) {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if _, exists := s.clients[client.NodeID]; exists {
+	// 已被同一节点的新连接替换时不再处理
+	current, exists := s.clients[client.NodeID]
+	removed := exists && current == client
+	if removed {
 		delete(s.clients, client.NodeID)
-		close(client.Send)
-		logger.Info("WebSocket 客户端已断开连接: %s", client.NodeID)
+		client.Send.Close()
+		logger.Info("信令客户端已断开连接: %s (%s)", client.NodeID, client.Transport)
+	}
+	s.mu.Unlock()
+
+	if removed {
+		s.clientGone(client)
 	}
 }
 
+// clientGone 节点离线后通知订阅者并清理它的订阅
+func (s *SignalingServer) clientGone(client *Client) {
+	s.presence.remove(client.NodeID, nil)
+	s.coordinator.SetPeerAddress(client.NodeID, nil)
+	s.notifyPresence(client, false)
+}
+
 // cleanupLoop 清理循环
 func (s *SignalingServer) cleanupLoop() {
+	defer s.wg.Done()
+
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-s.stopCh:
+		case <-s.ctx.Done():
 			return
 		case <-ticker.C:
 			s.cleanupInactiveClients()
@@ -471,16 +722,32 @@ func (s *SignalingServer) cleanupLoop() {
 
 // cleanupInactiveClients 清理不活跃的客户端
 func (s *SignalingServer) cleanupInactiveClients() {
+	var removed []*Client
+	defer func() {
+		for _, client := range removed {
+			s.clientGone(client)
+		}
+	}()
+
+	now := time.Now()
+	s.quota.prune(now)
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	now := time.Now()
 	for nodeID, client := range s.clients {
-		if now.Sub(client.LastActive) > 5*time.Minute {
+		timeout := 5 * time.Minute
+		if client.Transport == TransportPoll {
+			timeout = pollClientTimeout
+		}
+		if now.Sub(client.LastActive) > timeout {
 			logger.Info("清理不活跃的客户端: %s", nodeID)
-			client.Conn.Close()
-			close(client.Send)
+			if client.Conn != nil {
+				client.Conn.Close()
+			}
+			client.Send.Close()
 			delete(s.clients, nodeID)
+			removed = append(removed, client)
 		}
 	}
 }
@@ -503,23 +770,44 @@ func (s *SignalingServer) IsClientOnline(nodeID string) bool {
 // RegisterRoutes 注册路由
 func (s *SignalingServer) RegisterRoutes(router *gin.RouterGroup) {
 	router.GET("/ws", s.authMiddleware(), s.HandleWebSocket)
+
+	// WebSocket 被拦截时的 HTTPS 长轮询
+	router.GET("/signal/poll", s.authMiddleware(), s.HandlePoll)
+	router.POST("/signal/send", s.authMiddleware(), s.HandlePollSend)
+	router.DELETE("/signal/poll", s.authMiddleware(), s.HandlePollClose)
 }
 
 // authMiddleware 认证中间件
 func (s *SignalingServer) authMiddleware() gin.HandlerFunc {
+	certVerifier := signing.NewVerifier(signing.DefaultWindow)
+
 	return func(c *gin.Context) {
-		// 从请求头获取节点 ID 和令牌
-		nodeID := c.GetHeader("X-Node-ID")
-		token := c.GetHeader("X-Node-Token")
+		usesCertificate := device.UsesCertificate(c.Request)
+
+		var (
+			device *db.Device
+			err    error
+		)
+		if usesCertificate {
+			// 使用设备证书认证，连接请求由证书私钥签名
+			device, err = s.deviceService.AuthenticateCertificate(c.Request, certVerifier)
+		} else {
+			// 从请求头获取节点 ID 和令牌
+			nodeID := c.GetHeader("X-Node-ID")
+			token := c.GetHeader("X-Node-Token")
+
+			if nodeID == "" || token == "" {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "未提供节点 ID 或令牌"})
+				c.Abort()
+				return
+			}
 
-		if nodeID == "" || token == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "未提供节点 ID 或令牌"})
-			c.Abort()
-			return
+			// 认证设备，持有有效证书的设备不再接受令牌
+			device, err = s.deviceService.AuthenticateDevice(nodeID, token)
+			if err == nil {
+				err = s.deviceService.CheckTokenAllowed(device)
+			}
 		}
-
-		// 认证设备
-		device, err := s.deviceService.AuthenticateDevice(nodeID, token)
 		if err != nil {
 			errObj := errors.AsError(err)
 			c.JSON(errObj.StatusCode(), gin.H{"error": errObj.Error()})
@@ -533,6 +821,41 @@ func (s *SignalingServer) authMiddleware() gin.HandlerFunc {
 		c.Set("nodeID", device.NodeID)
 		c.Set("userID", device.UserID)
 
+		// 被封禁的节点和来源地址不能连接
+		if s.rejectBanned(c, device.NodeID) {
+			return
+		}
+
+		// 检查客户端版本，优先使用连接时上报的版本，其次是心跳中保存的版本
+		clientVersion := c.GetHeader("X-Node-Version")
+		if clientVersion == "" {
+			clientVersion = device.Version
+		}
+		if !s.checkClientVersion(c, clientVersion) {
+			c.Abort()
+			return
+		}
+
 		c.Next()
 	}
 }
+
+// checkClientVersion 根据客户端版本策略检查版本，低于最低支持版本时返回 426 并要求升级
+func (s *SignalingServer) checkClientVersion(c *gin.Context, clientVersion string) bool {
+	policy := &s.config.Client
+	status := device.CheckClientVersion(policy, clientVersion)
+	c.Set("clientVersion", clientVersion)
+	c.Set("versionStatus", status)
+
+	if status == device.VersionUnsupported {
+		logger.Warn("拒绝版本过低的客户端: %s (版本 %s，最低 %s)", c.GetHeader("X-Node-ID"), clientVersion, policy.MinVersion)
+		c.JSON(http.StatusUpgradeRequired, gin.H{
+			"error":           "客户端版本过低，请升级",
+			"upgradeRequired": true,
+			"currentVersion":  clientVersion,
+			"minVersion":      policy.MinVersion,
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,44 @@
+package p2p
+
+import (
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+)
+
+// STUNServers 获取下发给客户端的 STUN 服务器列表
+func (s *SignalingServer) STUNServers() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.stunServers...)
+}
+
+// SetSTUNServers 更新下发给客户端的 STUN 服务器列表并推送给所有在线节点，返回推送的节点数。
+// 列表为空时客户端恢复使用本地配置。更新只在内存中生效，服务重启后恢复为配置文件中的列表
+func (s *SignalingServer) SetSTUNServers(servers []string) (int, error) {
+	if err := protocol.ValidateSTUNServers(servers); err != nil {
+		return 0, err
+	}
+	servers = append([]string{}, servers...)
+
+	s.mu.Lock()
+	s.stunServers = servers
+	clients := make([]*Client, 0, len(s.clients))
+	for _, client := range s.clients {
+		clients = append(clients, client)
+	}
+	s.mu.Unlock()
+
+	for _, client := range clients {
+		s.sendSignal(client, &protocol.Signal{
+			Type:       protocol.SignalSTUNServers,
+			SenderID:   "server",
+			ReceiverID: client.NodeID,
+			Payload:    map[string]interface{}{"servers": servers},
+			Timestamp:  time.Now(),
+		})
+	}
+	logger.Info("STUN 服务器列表已更新为 %v，已推送给 %d 个节点", servers, len(clients))
+	return len(clients), nil
+}
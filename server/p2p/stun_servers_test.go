@@ -0,0 +1,75 @@
+package p2p
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/protocol"
+	"github.com/senma231/p3/server/config"
+)
+
+func TestSTUNServers(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cfg := config.DefaultConfig()
+	cfg.P2P.STUNServers = []string{"stun.example.com:3478"}
+	s := NewSignalingServer(cfg, NewCoordinator(cfg, nil, nil), nil, nil)
+
+	// poll 取出 node-a 收到的信令
+	poll := func() []protocol.Signal {
+		var resp struct {
+			Signals []protocol.Signal `json:"signals"`
+		}
+		w := pollRequest(s.HandlePoll, "node-a", http.MethodGet, "/signal/poll?wait=0", "")
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("解析轮询响应失败: %v", err)
+		}
+		return resp.Signals
+	}
+
+	// 欢迎消息附带配置的 STUN 服务器
+	var welcome map[string]interface{}
+	for _, signal := range poll() {
+		if signal.Type == protocol.SignalPing {
+			welcome = signal.Payload.(map[string]interface{})
+		}
+	}
+	if servers, ok := welcome["stunServers"].([]interface{}); !ok || len(servers) != 1 || servers[0] != "stun.example.com:3478" {
+		t.Fatalf("欢迎消息应附带 STUN 服务器: %v", welcome)
+	}
+
+	if _, err := s.SetSTUNServers([]string{"stun.example.com"}); err == nil {
+		t.Fatal("缺少端口的 STUN 服务器应被拒绝")
+	}
+	if servers := s.STUNServers(); len(servers) != 1 {
+		t.Fatalf("无效的列表不应替换原列表: %v", servers)
+	}
+
+	notified, err := s.SetSTUNServers([]string{"stun1.example.com:3478", "stun2.example.com:3478"})
+	if err != nil || notified != 1 {
+		t.Fatalf("更新 STUN 服务器失败: notified=%d err=%v", notified, err)
+	}
+	var pushed []interface{}
+	for _, signal := range poll() {
+		if signal.Type == protocol.SignalSTUNServers {
+			pushed = signal.Payload.(map[string]interface{})["servers"].([]interface{})
+		}
+	}
+	if len(pushed) != 2 || pushed[0] != "stun1.example.com:3478" {
+		t.Fatalf("在线节点应收到新的 STUN 服务器列表: %v", pushed)
+	}
+
+	// 清空列表时推送空列表，客户端恢复本地配置
+	if _, err := s.SetSTUNServers(nil); err != nil {
+		t.Fatalf("清空 STUN 服务器失败: %v", err)
+	}
+	for _, signal := range poll() {
+		if signal.Type == protocol.SignalSTUNServers {
+			pushed = signal.Payload.(map[string]interface{})["servers"].([]interface{})
+		}
+	}
+	if len(pushed) != 0 {
+		t.Fatalf("清空后应推送空列表: %v", pushed)
+	}
+}
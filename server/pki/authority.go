@@ -0,0 +1,236 @@
+// Package pki 服务端内置的证书颁发机构。CA 在服务端首次启动时生成，为设备签发以节点 ID
+// 为通用名的证书；设备使用证书私钥签名的请求和握手代替令牌认证，证书可吊销和轮换
+package pki
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// caValidity CA 证书的有效期
+const caValidity = 10 * 365 * 24 * time.Hour
+
+// clockSkew 签发的证书生效时间提前量，容忍设备与服务端的时钟偏差
+const clockSkew = 5 * time.Minute
+
+// Authority 证书颁发机构
+type Authority struct {
+	cert    *x509.Certificate
+	key     crypto.Signer
+	certPEM []byte
+	roots   *x509.CertPool
+}
+
+// LoadOrCreateAuthority 加载 CA 证书和私钥，文件不存在时生成新的 CA 并保存，私钥文件只允许所有者读写
+func LoadOrCreateAuthority(certFile, keyFile string) (*Authority, error) {
+	certPEM, certErr := os.ReadFile(certFile)
+	keyPEM, keyErr := os.ReadFile(keyFile)
+	if os.IsNotExist(certErr) && os.IsNotExist(keyErr) {
+		return createAuthority(certFile, keyFile)
+	}
+	if certErr != nil {
+		return nil, fmt.Errorf("读取 CA 证书失败: %w", certErr)
+	}
+	if keyErr != nil {
+		return nil, fmt.Errorf("读取 CA 私钥失败: %w", keyErr)
+	}
+	return ParseAuthority(certPEM, keyPEM)
+}
+
+// createAuthority 生成 CA 并保存到文件
+func createAuthority(certFile, keyFile string) (*Authority, error) {
+	a, keyPEM, err := NewAuthority()
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{filepath.Dir(certFile), filepath.Dir(keyFile)} {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return nil, fmt.Errorf("创建 CA 目录失败: %w", err)
+		}
+	}
+	if err := os.WriteFile(keyFile, keyPEM, 0o600); err != nil {
+		return nil, fmt.Errorf("保存 CA 私钥失败: %w", err)
+	}
+	if err := os.WriteFile(certFile, a.certPEM, 0o644); err != nil {
+		return nil, fmt.Errorf("保存 CA 证书失败: %w", err)
+	}
+	return a, nil
+}
+
+// NewAuthority 生成新的 CA，返回 PEM 编码的私钥用于保存
+func NewAuthority() (*Authority, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, fmt.Errorf("生成 CA 私钥失败: %w", err)
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "P3 Device CA", Organization: []string{"P3"}},
+		NotBefore:             now.Add(-clockSkew),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("创建 CA 证书失败: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("编码 CA 私钥失败: %w", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	a, err := ParseAuthority(certPEM, keyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, keyPEM, nil
+}
+
+// ParseAuthority 解析 PEM 编码的 CA 证书和 EC 私钥
+func ParseAuthority(certPEM, keyPEM []byte) (*Authority, error) {
+	certBlock, _ := pem.Decode(certPEM)
+	if certBlock == nil || certBlock.Type != "CERTIFICATE" {
+		return nil, errors.New("无效的 CA 证书")
+	}
+	cert, err := x509.ParseCertificate(certBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析 CA 证书失败: %w", err)
+	}
+	if !cert.IsCA {
+		return nil, errors.New("证书不是 CA 证书")
+	}
+
+	keyBlock, _ := pem.Decode(keyPEM)
+	if keyBlock == nil {
+		return nil, errors.New("无效的 CA 私钥")
+	}
+	key, err := x509.ParseECPrivateKey(keyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("解析 CA 私钥失败: %w", err)
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		return nil, errors.New("CA 私钥与证书不匹配")
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	return &Authority{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(certBlock),
+		roots:   roots,
+	}, nil
+}
+
+// CertificatePEM 返回 PEM 编码的 CA 证书，设备用于验证对端的证书
+func (a *Authority) CertificatePEM() []byte {
+	return a.certPEM
+}
+
+// Issue 按证书签名请求为节点签发证书。请求中的主题被忽略，通用名固定为节点 ID，
+// 证书同时用于客户端和服务端认证，以便 P2P 连接的双方互相验证
+func (a *Authority) Issue(csrDER []byte, nodeID string, validity time.Duration) (*x509.Certificate, error) {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, fmt.Errorf("解析证书签名请求失败: %w", err)
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("证书签名请求的签名无效: %w", err)
+	}
+	if _, ok := csr.PublicKey.(*ecdsa.PublicKey); !ok {
+		return nil, errors.New("设备证书只支持 ECDSA 密钥")
+	}
+	serial, err := newSerial()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	notAfter := now.Add(validity)
+	if notAfter.After(a.cert.NotAfter) {
+		notAfter = a.cert.NotAfter
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: nodeID},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, a.cert, csr.PublicKey, a.key)
+	if err != nil {
+		return nil, fmt.Errorf("签发设备证书失败: %w", err)
+	}
+	return x509.ParseCertificate(der)
+}
+
+// Verify 验证证书由本 CA 签发且在有效期内，吊销状态由调用方检查
+func (a *Authority) Verify(der []byte) (*x509.Certificate, error) {
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, fmt.Errorf("解析设备证书失败: %w", err)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:     a.roots,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return nil, fmt.Errorf("设备证书无效: %w", err)
+	}
+	return cert, nil
+}
+
+// RevocationList 创建 DER 编码的证书吊销列表，有效至 nextUpdate
+func (a *Authority) RevocationList(revoked []pkix.RevokedCertificate, now, nextUpdate time.Time) ([]byte, error) {
+	return x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		RevokedCertificates: revoked,
+		Number:              big.NewInt(now.Unix()),
+		ThisUpdate:          now,
+		NextUpdate:          nextUpdate,
+	}, a.cert, a.key)
+}
+
+// SerialString 返回证书序列号的十六进制表示
+func SerialString(cert *x509.Certificate) string {
+	return hex.EncodeToString(cert.SerialNumber.Bytes())
+}
+
+// Fingerprint 返回证书的 SHA-256 指纹
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// newSerial 生成 128 位随机序列号
+func newSerial() (*big.Int, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("生成证书序列号失败: %w", err)
+	}
+	return serial, nil
+}
@@ -0,0 +1,242 @@
+package pki
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// crlValidity 证书吊销列表的有效期，吊销证书后立即重新生成
+const crlValidity = time.Hour
+
+// 证书状态
+const (
+	StatusGood    = "good"
+	StatusRevoked = "revoked"
+	StatusExpired = "expired"
+	StatusUnknown = "unknown"
+)
+
+// IssuedCertificate 签发给设备的证书
+type IssuedCertificate struct {
+	Certificate string    `json:"certificate"` // PEM 编码的设备证书
+	CA          string    `json:"ca"`          // PEM 编码的 CA 证书
+	Serial      string    `json:"serial"`
+	NotAfter    time.Time `json:"notAfter"`
+}
+
+// CertificateStatus 证书的吊销状态，类似 OCSP 响应
+type CertificateStatus struct {
+	Serial       string     `json:"serial"`
+	Status       string     `json:"status"`
+	NotAfter     *time.Time `json:"notAfter,omitempty"`
+	RevokedAt    *time.Time `json:"revokedAt,omitempty"`
+	RevokeReason string     `json:"revokeReason,omitempty"`
+	CheckedAt    time.Time  `json:"checkedAt"`
+}
+
+// Service 设备证书服务
+type Service struct {
+	ca       *Authority
+	certs    store.CertificateRepo
+	devices  store.DeviceRepo
+	validity time.Duration
+
+	// 缓存的证书吊销列表，吊销证书时清空
+	crl        []byte
+	crlExpires time.Time
+	mu         sync.Mutex
+}
+
+// NewService 创建设备证书服务，validity 为签发的设备证书有效期
+func NewService(ca *Authority, st *store.Store, validity time.Duration) *Service {
+	return &Service{
+		ca:       ca,
+		certs:    st.Certs,
+		devices:  st.Devices,
+		validity: validity,
+	}
+}
+
+// Authority 返回证书颁发机构
+func (s *Service) Authority() *Authority {
+	return s.ca
+}
+
+// Enroll 按证书签名请求为设备签发证书。设备已有的证书在新证书签发后被吊销，
+// 轮换时设备使用当前证书认证请求新证书
+func (s *Service) Enroll(device *db.Device, csrDER []byte) (*IssuedCertificate, error) {
+	cert, err := s.ca.Issue(csrDER, device.NodeID, s.validity)
+	if err != nil {
+		return nil, errors.InvalidParam(err.Error())
+	}
+
+	record := &db.DeviceCertificate{
+		DeviceID:    device.ID,
+		NodeID:      device.NodeID,
+		Serial:      SerialString(cert),
+		Fingerprint: Fingerprint(cert),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+	}
+	if err := s.certs.Create(record); err != nil {
+		return nil, errors.Database("保存设备证书失败", err)
+	}
+	if _, err := s.revoke(device.ID, record.Serial, db.RevokeSuperseded); err != nil {
+		return nil, err
+	}
+
+	return &IssuedCertificate{
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})),
+		CA:          string(s.ca.CertificatePEM()),
+		Serial:      record.Serial,
+		NotAfter:    record.NotAfter,
+	}, nil
+}
+
+// Authenticate 验证 DER 编码的设备证书由本 CA 签发、未过期且未吊销，返回证书所属的设备
+func (s *Service) Authenticate(der []byte) (*db.Device, *x509.Certificate, error) {
+	cert, err := s.ca.Verify(der)
+	if err != nil {
+		return nil, nil, errors.Unauthorized(err.Error())
+	}
+
+	record, err := s.certs.GetBySerial(SerialString(cert))
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, nil, errors.Unauthorized("设备证书未登记")
+		}
+		return nil, nil, errors.Database("查询设备证书失败", err)
+	}
+	if record.RevokedAt != nil {
+		return nil, nil, errors.Unauthorized("设备证书已吊销")
+	}
+	if record.NodeID != cert.Subject.CommonName {
+		return nil, nil, errors.Unauthorized("设备证书与节点不匹配")
+	}
+
+	device, err := s.devices.GetByID(record.DeviceID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, nil, errors.Unauthorized("设备不存在")
+		}
+		return nil, nil, errors.Database("查询设备失败", err)
+	}
+	if device.NodeID != record.NodeID {
+		return nil, nil, errors.Unauthorized("设备证书与节点不匹配")
+	}
+	return device, cert, nil
+}
+
+// HasActive 检查设备是否持有有效的证书，持有证书的设备不再接受令牌认证
+func (s *Service) HasActive(deviceID uint) (bool, error) {
+	certs, err := s.certs.ListByDevice(deviceID)
+	if err != nil {
+		return false, errors.Database("查询设备证书失败", err)
+	}
+	now := time.Now()
+	for i := range certs {
+		if certs[i].Active(now) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// List 获取设备的证书
+func (s *Service) List(deviceID uint) ([]db.DeviceCertificate, error) {
+	certs, err := s.certs.ListByDevice(deviceID)
+	if err != nil {
+		return nil, errors.Database("查询设备证书失败", err)
+	}
+	return certs, nil
+}
+
+// Revoke 吊销设备所有的证书，吊销后设备可以重新使用令牌申请证书
+func (s *Service) Revoke(deviceID uint) (int64, error) {
+	return s.revoke(deviceID, "", db.RevokeCompromise)
+}
+
+// revoke 吊销设备除 except 外的证书并使缓存的吊销列表失效
+func (s *Service) revoke(deviceID uint, except, reason string) (int64, error) {
+	revoked, err := s.certs.RevokeByDevice(deviceID, except, reason, time.Now())
+	if err != nil {
+		return 0, errors.Database("吊销设备证书失败", err)
+	}
+	if revoked > 0 {
+		s.mu.Lock()
+		s.crl = nil
+		s.mu.Unlock()
+	}
+	return revoked, nil
+}
+
+// Status 查询证书的吊销状态
+func (s *Service) Status(serial string) (*CertificateStatus, error) {
+	now := time.Now()
+	status := &CertificateStatus{Serial: serial, Status: StatusUnknown, CheckedAt: now}
+
+	record, err := s.certs.GetBySerial(serial)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return status, nil
+		}
+		return nil, errors.Database("查询设备证书失败", err)
+	}
+
+	status.NotAfter = &record.NotAfter
+	switch {
+	case record.RevokedAt != nil:
+		status.Status = StatusRevoked
+		status.RevokedAt = record.RevokedAt
+		status.RevokeReason = record.RevokeReason
+	case !now.Before(record.NotAfter):
+		status.Status = StatusExpired
+	default:
+		status.Status = StatusGood
+	}
+	return status, nil
+}
+
+// RevocationList 返回 DER 编码的证书吊销列表，只包含尚未过期的已吊销证书
+func (s *Service) RevocationList() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if s.crl != nil && now.Before(s.crlExpires) {
+		return s.crl, nil
+	}
+
+	records, err := s.certs.ListRevoked(now)
+	if err != nil {
+		return nil, errors.Database("查询已吊销的设备证书失败", err)
+	}
+	revoked := make([]pkix.RevokedCertificate, 0, len(records))
+	for _, record := range records {
+		serial, ok := new(big.Int).SetString(record.Serial, 16)
+		if !ok {
+			continue
+		}
+		revoked = append(revoked, pkix.RevokedCertificate{
+			SerialNumber:   serial,
+			RevocationTime: *record.RevokedAt,
+		})
+	}
+
+	crl, err := s.ca.RevocationList(revoked, now, now.Add(crlValidity))
+	if err != nil {
+		return nil, fmt.Errorf("生成证书吊销列表失败: %w", err)
+	}
+	s.crl = crl
+	s.crlExpires = now.Add(crlValidity / 2)
+	return crl, nil
+}
@@ -0,0 +1,139 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+func newCSR(t *testing.T) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("生成密钥失败: %v", err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+	if err != nil {
+		t.Fatalf("创建证书签名请求失败: %v", err)
+	}
+	return csr
+}
+
+func certDER(t *testing.T, issued *IssuedCertificate) []byte {
+	block, _ := pem.Decode([]byte(issued.Certificate))
+	if block == nil {
+		t.Fatal("无效的证书")
+	}
+	return block.Bytes
+}
+
+func TestLoadOrCreateAuthority(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "private", "ca-key.pem")
+
+	created, err := LoadOrCreateAuthority(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("生成 CA 失败: %v", err)
+	}
+	info, err := os.Stat(keyFile)
+	if err != nil || info.Mode().Perm() != 0o600 {
+		t.Fatalf("CA 私钥文件权限错误: %v %v", info, err)
+	}
+
+	loaded, err := LoadOrCreateAuthority(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("加载 CA 失败: %v", err)
+	}
+	if !bytes.Equal(created.CertificatePEM(), loaded.CertificatePEM()) {
+		t.Fatal("再次启动时应加载已有的 CA")
+	}
+
+	if err := os.Remove(keyFile); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadOrCreateAuthority(certFile, keyFile); err == nil {
+		t.Fatal("缺少 CA 私钥时不应生成新的 CA")
+	}
+}
+
+func TestEnrollAndRevoke(t *testing.T) {
+	ca, _, err := NewAuthority()
+	if err != nil {
+		t.Fatalf("生成 CA 失败: %v", err)
+	}
+	st := store.NewMemoryStore()
+	device := &db.Device{UserID: 1, Name: "nas", NodeID: "node-1", Token: "token"}
+	if err := st.Devices.Create(device); err != nil {
+		t.Fatal(err)
+	}
+	s := NewService(ca, st, 24*time.Hour)
+
+	first, err := s.Enroll(device, newCSR(t))
+	if err != nil {
+		t.Fatalf("签发证书失败: %v", err)
+	}
+	got, cert, err := s.Authenticate(certDER(t, first))
+	if err != nil || got.ID != device.ID || cert.Subject.CommonName != "node-1" {
+		t.Fatalf("证书认证失败: %v", err)
+	}
+	if active, _ := s.HasActive(device.ID); !active {
+		t.Fatal("签发后设备应持有有效证书")
+	}
+
+	// 轮换后旧证书被吊销
+	second, err := s.Enroll(device, newCSR(t))
+	if err != nil {
+		t.Fatalf("轮换证书失败: %v", err)
+	}
+	if _, _, err := s.Authenticate(certDER(t, first)); err == nil {
+		t.Fatal("轮换后旧证书不应通过认证")
+	}
+	if _, _, err := s.Authenticate(certDER(t, second)); err != nil {
+		t.Fatalf("新证书认证失败: %v", err)
+	}
+	status, _ := s.Status(first.Serial)
+	if status.Status != StatusRevoked || status.RevokeReason != db.RevokeSuperseded {
+		t.Fatalf("旧证书状态错误: %+v", status)
+	}
+	if status, _ := s.Status("00"); status.Status != StatusUnknown {
+		t.Fatalf("未知证书状态错误: %+v", status)
+	}
+
+	// 其他 CA 签发的证书
+	other, _, _ := NewAuthority()
+	forged, err := other.Issue(newCSR(t), "node-1", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := s.Authenticate(forged.Raw); err == nil {
+		t.Fatal("其他 CA 签发的证书不应通过认证")
+	}
+
+	if n, err := s.Revoke(device.ID); err != nil || n != 1 {
+		t.Fatalf("吊销证书失败: %d %v", n, err)
+	}
+	if active, _ := s.HasActive(device.ID); active {
+		t.Fatal("吊销后设备不应持有有效证书")
+	}
+
+	der, err := s.RevocationList()
+	if err != nil {
+		t.Fatalf("生成吊销列表失败: %v", err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil || crl.CheckSignatureFrom(ca.cert) != nil {
+		t.Fatalf("吊销列表无效: %v", err)
+	}
+	if len(crl.RevokedCertificates) != 2 {
+		t.Fatalf("吊销列表应包含 2 个证书，实际 %d", len(crl.RevokedCertificates))
+	}
+}
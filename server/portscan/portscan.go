@@ -0,0 +1,308 @@
+// Package portscan 由控制台发起的设备端口扫描。设备只扫描本机或局域网中的目标，
+// 用于确认转发的目标服务是否在监听，区分"隧道正常但服务未启动"和连接问题
+package portscan
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/db"
+)
+
+// 扫描状态
+const (
+	StatusRunning   = "running"   // 已下发，等待设备回报结果
+	StatusCompleted = "completed" // 设备已回报结果
+	StatusFailed    = "failed"    // 设备扫描失败
+	StatusTimeout   = "timeout"   // 超时未回报结果
+)
+
+const (
+	// MaxPorts 单次扫描的最大端口数
+	MaxPorts = 1024
+	// MaxScansPerHour 每台设备每小时最多发起的扫描次数
+	MaxScansPerHour = 10
+	// resultTimeout 下发后等待设备回报结果的时间
+	resultTimeout = 2 * time.Minute
+	// scanTTL 扫描结果的保留时间
+	scanTTL = 24 * time.Hour
+)
+
+// Task 下发给设备的扫描任务
+type Task struct {
+	ScanID    string `json:"scanId"`
+	Host      string `json:"host"`
+	PortStart int    `json:"portStart"`
+	PortEnd   int    `json:"portEnd"`
+}
+
+// SendFunc 向节点下发扫描任务，节点离线时返回错误
+type SendFunc func(nodeID string, task *Task) error
+
+// Request 发起扫描的请求，Host 为空时扫描设备本机
+type Request struct {
+	Host      string `json:"host" binding:"max=100"`
+	PortStart int    `json:"portStart" binding:"required,min=1,max=65535"`
+	PortEnd   int    `json:"portEnd" binding:"omitempty,min=1,max=65535"`
+}
+
+// Result 设备回报的扫描结果
+type Result struct {
+	Open    []int  `json:"open"`
+	Scanned int    `json:"scanned"`
+	Error   string `json:"error" binding:"max=500" sanitize:"text"`
+}
+
+// Scan 端口扫描
+type Scan struct {
+	ID          string    `json:"id"`
+	UserID      uint      `json:"-"`
+	DeviceID    uint      `json:"deviceId"`
+	Host        string    `json:"host"`
+	PortStart   int       `json:"portStart"`
+	PortEnd     int       `json:"portEnd"`
+	Status      string    `json:"status"`
+	Open        []int     `json:"open"`
+	Scanned     int       `json:"scanned"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+	CompletedAt time.Time `json:"completedAt,omitempty"`
+}
+
+// Manager 端口扫描管理器。扫描任务通过信令下发，设备扫描后通过 HTTP 回报结果，
+// 每台设备同时只能进行一次扫描，并限制每小时的扫描次数
+type Manager struct {
+	send   SendFunc
+	now    func() time.Time
+	scans  map[string]*Scan
+	mutex  sync.Mutex
+	stopCh chan struct{}
+}
+
+// NewManager 创建端口扫描管理器
+func NewManager(send SendFunc) *Manager {
+	return &Manager{
+		send:   send,
+		now:    time.Now,
+		scans:  make(map[string]*Scan),
+		stopCh: make(chan struct{}),
+	}
+}
+
+// Start 启动超时检查和过期结果清理
+func (m *Manager) Start() {
+	go m.loop()
+}
+
+// Stop 停止超时检查和过期结果清理
+func (m *Manager) Stop() {
+	close(m.stopCh)
+}
+
+// ValidateTarget 检查扫描目标，只允许 localhost、回环地址和私有地址，不解析其他域名
+func ValidateTarget(host string) error {
+	if host == "" || strings.EqualFold(host, "localhost") {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errors.InvalidParam("扫描目标必须是 localhost 或 IP 地址")
+	}
+	if !ip.IsLoopback() && !ip.IsPrivate() {
+		return errors.InvalidParam("只能扫描设备本机或局域网中的地址")
+	}
+	return nil
+}
+
+// Submit 向设备下发扫描任务，设备离线时返回错误
+func (m *Manager) Submit(userID uint, device *db.Device, req *Request) (*Scan, error) {
+	host := req.Host
+	if host == "" {
+		host = "localhost"
+	}
+	if err := ValidateTarget(host); err != nil {
+		return nil, err
+	}
+	portEnd := req.PortEnd
+	if portEnd == 0 {
+		portEnd = req.PortStart
+	}
+	if portEnd < req.PortStart {
+		return nil, errors.InvalidParam("结束端口不能小于起始端口")
+	}
+	if portEnd-req.PortStart+1 > MaxPorts {
+		return nil, errors.InvalidParam(fmt.Sprintf("单次最多扫描 %d 个端口", MaxPorts))
+	}
+
+	id, err := newScanID()
+	if err != nil {
+		return nil, errors.Internal(err.Error())
+	}
+
+	now := m.now()
+	scan := &Scan{
+		ID:        id,
+		UserID:    userID,
+		DeviceID:  device.ID,
+		Host:      host,
+		PortStart: req.PortStart,
+		PortEnd:   portEnd,
+		Status:    StatusRunning,
+		Open:      []int{},
+		CreatedAt: now,
+	}
+
+	m.mutex.Lock()
+	recent := 0
+	for _, s := range m.scans {
+		if s.DeviceID != device.ID {
+			continue
+		}
+		if s.Status == StatusRunning {
+			m.mutex.Unlock()
+			return nil, errors.Conflict("设备正在扫描端口")
+		}
+		if now.Sub(s.CreatedAt) < time.Hour {
+			recent++
+		}
+	}
+	if recent >= MaxScansPerHour {
+		m.mutex.Unlock()
+		return nil, errors.TooManyRequests(fmt.Sprintf("每台设备每小时最多扫描 %d 次", MaxScansPerHour))
+	}
+	m.scans[id] = scan
+	m.mutex.Unlock()
+
+	task := &Task{ScanID: id, Host: host, PortStart: scan.PortStart, PortEnd: scan.PortEnd}
+	if err := m.send(device.NodeID, task); err != nil {
+		m.mutex.Lock()
+		delete(m.scans, id)
+		m.mutex.Unlock()
+		logger.Warn("向节点 %s 下发端口扫描失败: %v", device.NodeID, err)
+		return nil, errors.Conflict("设备不在线")
+	}
+
+	logger.Info("设备 %d 开始扫描 %s:%d-%d", device.ID, host, scan.PortStart, scan.PortEnd)
+	return m.Get(userID, id)
+}
+
+// ReportResult 记录设备回报的扫描结果
+func (m *Manager) ReportResult(deviceID uint, scanID string, result *Result) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	scan, exists := m.scans[scanID]
+	if !exists || scan.DeviceID != deviceID {
+		return errors.NotFound("扫描不存在")
+	}
+	if scan.Status != StatusRunning {
+		return errors.Conflict("已记录扫描结果")
+	}
+
+	// 只保留扫描范围内的端口
+	open := make([]int, 0, len(result.Open))
+	for _, port := range result.Open {
+		if port >= scan.PortStart && port <= scan.PortEnd {
+			open = append(open, port)
+		}
+	}
+	sort.Ints(open)
+
+	scan.Open = open
+	scan.Scanned = result.Scanned
+	scan.Error = result.Error
+	scan.Status = StatusCompleted
+	if result.Error != "" {
+		scan.Status = StatusFailed
+	}
+	scan.CompletedAt = m.now()
+	return nil
+}
+
+// Get 获取用户的扫描
+func (m *Manager) Get(userID uint, id string) (*Scan, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	scan, exists := m.scans[id]
+	if !exists || scan.UserID != userID {
+		return nil, errors.NotFound("扫描不存在")
+	}
+	return snapshot(scan), nil
+}
+
+// List 获取设备的扫描，按创建时间倒序
+func (m *Manager) List(userID, deviceID uint) []*Scan {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	scans := make([]*Scan, 0)
+	for _, scan := range m.scans {
+		if scan.UserID == userID && scan.DeviceID == deviceID {
+			scans = append(scans, snapshot(scan))
+		}
+	}
+	sort.Slice(scans, func(i, j int) bool {
+		return scans[i].CreatedAt.After(scans[j].CreatedAt)
+	})
+	return scans
+}
+
+// snapshot 获取扫描的副本
+func snapshot(scan *Scan) *Scan {
+	copied := *scan
+	copied.Open = append([]int{}, scan.Open...)
+	return &copied
+}
+
+// loop 定期检查超时和清理过期结果
+func (m *Manager) loop() {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stopCh:
+			return
+		case <-ticker.C:
+			m.expire(m.now())
+		}
+	}
+}
+
+// expire 将超时未回报的扫描标记为超时，并清理过期结果
+func (m *Manager) expire(now time.Time) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	for id, scan := range m.scans {
+		if scan.Status == StatusRunning {
+			if now.Sub(scan.CreatedAt) > resultTimeout {
+				scan.Status = StatusTimeout
+				scan.Error = "设备未在规定时间内回报结果"
+				scan.CompletedAt = now
+			}
+			continue
+		}
+		if now.Sub(scan.CompletedAt) > scanTTL {
+			delete(m.scans, id)
+		}
+	}
+}
+
+// newScanID 生成扫描 ID
+func newScanID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("生成扫描 ID 失败: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,116 @@
+package portscan
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+)
+
+func TestValidateTarget(t *testing.T) {
+	for _, host := range []string{"", "localhost", "127.0.0.1", "::1", "192.168.1.10", "10.0.0.2", "fd00::1"} {
+		if err := ValidateTarget(host); err != nil {
+			t.Fatalf("%s 应允许扫描: %v", host, err)
+		}
+	}
+	for _, host := range []string{"8.8.8.8", "example.com", "2001:db8::1"} {
+		if err := ValidateTarget(host); err == nil {
+			t.Fatalf("%s 不应允许扫描", host)
+		}
+	}
+}
+
+func TestManager(t *testing.T) {
+	var sent *Task
+	m := NewManager(func(nodeID string, task *Task) error {
+		if nodeID == "offline" {
+			return fmt.Errorf("节点不在线")
+		}
+		sent = task
+		return nil
+	})
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	device := &db.Device{NodeID: "a"}
+	device.ID = 1
+
+	if _, err := m.Submit(1, device, &Request{PortStart: 1, PortEnd: MaxPorts + 1}); err == nil {
+		t.Fatalf("超过端口数上限应返回错误")
+	}
+	if _, err := m.Submit(1, device, &Request{Host: "8.8.8.8", PortStart: 80}); err == nil {
+		t.Fatalf("公网地址应返回错误")
+	}
+	offline := &db.Device{NodeID: "offline"}
+	offline.ID = 2
+	if _, err := m.Submit(1, offline, &Request{PortStart: 80}); err == nil {
+		t.Fatalf("设备离线时应返回错误")
+	}
+	if scans := m.List(1, offline.ID); len(scans) != 0 {
+		t.Fatalf("下发失败的扫描不应保留")
+	}
+
+	scan, err := m.Submit(1, device, &Request{PortStart: 8000, PortEnd: 8010})
+	if err != nil {
+		t.Fatalf("发起扫描失败: %v", err)
+	}
+	if sent == nil || sent.ScanID != scan.ID || sent.Host != "localhost" || sent.PortEnd != 8010 {
+		t.Fatalf("下发的任务为 %+v", sent)
+	}
+
+	// 同一设备同时只能进行一次扫描
+	if _, err := m.Submit(1, device, &Request{PortStart: 22}); err == nil {
+		t.Fatalf("扫描进行中应返回错误")
+	}
+
+	// 扫描范围外的端口被忽略
+	if err := m.ReportResult(1, scan.ID, &Result{Open: []int{8080, 8005, 22}, Scanned: 11}); err != nil {
+		t.Fatalf("回报结果失败: %v", err)
+	}
+	got, _ := m.Get(1, scan.ID)
+	if got.Status != StatusCompleted || len(got.Open) != 1 || got.Open[0] != 8005 {
+		t.Fatalf("扫描结果为 %+v", got)
+	}
+	if err := m.ReportResult(1, scan.ID, &Result{}); err == nil {
+		t.Fatalf("重复回报应返回错误")
+	}
+	if _, err := m.Get(2, scan.ID); err == nil {
+		t.Fatalf("其他用户不应看到扫描")
+	}
+
+	// 每小时的扫描次数有上限
+	for i := 1; i < MaxScansPerHour; i++ {
+		s, err := m.Submit(1, device, &Request{PortStart: 22})
+		if err != nil {
+			t.Fatalf("第 %d 次扫描失败: %v", i+1, err)
+		}
+		m.ReportResult(1, s.ID, &Result{Scanned: 1})
+	}
+	if _, err := m.Submit(1, device, &Request{PortStart: 22}); err == nil {
+		t.Fatalf("超过每小时扫描次数应返回错误")
+	}
+}
+
+func TestManagerExpire(t *testing.T) {
+	m := NewManager(func(string, *Task) error { return nil })
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	device := &db.Device{NodeID: "a"}
+	device.ID = 1
+	scan, err := m.Submit(1, device, &Request{PortStart: 80})
+	if err != nil {
+		t.Fatalf("发起扫描失败: %v", err)
+	}
+
+	m.expire(now.Add(resultTimeout + time.Second))
+	if got, _ := m.Get(1, scan.ID); got.Status != StatusTimeout {
+		t.Fatalf("超时未回报的扫描状态为 %s", got.Status)
+	}
+
+	m.expire(now.Add(resultTimeout + scanTTL + 2*time.Second))
+	if _, err := m.Get(1, scan.ID); err == nil {
+		t.Fatalf("过期的扫描应被清理")
+	}
+}
@@ -0,0 +1,243 @@
+package probe
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/common/protocol"
+)
+
+// maxPacketSize 探测请求的最大长度，超过的请求不处理
+const maxPacketSize = 1500
+
+// tcpProbeTimeout TCP 探测连接的最长时间，客户端需要在此时间内发送请求
+const tcpProbeTimeout = 5 * time.Second
+
+// Server 回显探测服务。客户端向主端口发送探测请求，服务端回复看到的来源地址，
+// 请求设置 alt 时同时从备用端口回复。客户端用同一个套接字探测多个地址，比较映射地址判断 NAT 的映射行为，
+// 能否收到备用端口的回复判断 NAT 的过滤行为。TCP 端口回复同样的响应，客户端据此检查 TCP 出站连通性和往返延迟
+type Server struct {
+	host    string
+	port    int
+	altPort int
+	tcpPort int
+
+	conn     *net.UDPConn
+	altConn  *net.UDPConn
+	listener net.Listener
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+}
+
+// NewServer 创建回显探测服务，altPort 为 0 时不从备用端口回复，tcpPort 为 0 时不监听 TCP
+func NewServer(host string, port, altPort, tcpPort int) *Server {
+	return &Server{
+		host:    host,
+		port:    port,
+		altPort: altPort,
+		tcpPort: tcpPort,
+	}
+}
+
+// Start 启动回显探测服务，监听成功后在后台处理请求
+func (s *Server) Start() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn != nil {
+		return fmt.Errorf("回显探测服务已在运行")
+	}
+
+	conn, err := s.listen(s.port)
+	if err != nil {
+		return err
+	}
+	if s.altPort > 0 {
+		altConn, err := s.listen(s.altPort)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		s.altConn = altConn
+	}
+	if s.tcpPort > 0 {
+		listener, err := net.Listen("tcp", net.JoinHostPort(s.host, strconv.Itoa(s.tcpPort)))
+		if err != nil {
+			conn.Close()
+			if s.altConn != nil {
+				s.altConn.Close()
+				s.altConn = nil
+			}
+			return fmt.Errorf("监听 TCP 失败: %w", err)
+		}
+		s.listener = listener
+	}
+	s.conn = conn
+	logger.Info("回显探测服务已启动，UDP 端口: %d，备用端口: %d，TCP 端口: %d", s.port, s.altPort, s.tcpPort)
+
+	s.wg.Add(1)
+	go s.serve(conn, s.altConn)
+	if s.altConn != nil {
+		// 备用端口只发送响应，读取并丢弃收到的数据
+		s.wg.Add(1)
+		go s.drain(s.altConn)
+	}
+	if s.listener != nil {
+		s.wg.Add(1)
+		go s.serveTCP(s.listener)
+	}
+	return nil
+}
+
+// listen 监听 UDP 端口
+func (s *Server) listen(port int) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", net.JoinHostPort(s.host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("解析地址失败: %w", err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("监听 UDP 失败: %w", err)
+	}
+	return conn, nil
+}
+
+// Stop 停止回显探测服务
+func (s *Server) Stop() {
+	s.mu.Lock()
+	if s.conn == nil {
+		s.mu.Unlock()
+		return
+	}
+	s.conn.Close()
+	if s.altConn != nil {
+		s.altConn.Close()
+	}
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.conn, s.altConn, s.listener = nil, nil, nil
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	logger.Info("回显探测服务已停止")
+}
+
+// Info 返回客户端使用的探测端口，address 为服务端看到的 HTTP 请求来源地址
+func (s *Server) Info(address string) *protocol.ProbeInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info := &protocol.ProbeInfo{Address: address}
+	if s.conn != nil {
+		info.UDPPort = s.conn.LocalAddr().(*net.UDPAddr).Port
+	}
+	if s.altConn != nil {
+		info.UDPAltPort = s.altConn.LocalAddr().(*net.UDPAddr).Port
+	}
+	if s.listener != nil {
+		info.TCPPort = s.listener.Addr().(*net.TCPAddr).Port
+	}
+	return info
+}
+
+// serve 处理探测请求，连接关闭后返回
+func (s *Server) serve(conn, altConn *net.UDPConn) {
+	defer s.wg.Done()
+
+	buffer := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFromUDP(buffer)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Warn("读取探测请求失败: %v", err)
+			continue
+		}
+		s.handle(conn, altConn, addr, buffer[:n])
+	}
+}
+
+// handle 回复探测请求，过短或无法解析的请求直接丢弃
+func (s *Server) handle(conn, altConn *net.UDPConn, addr *net.UDPAddr, data []byte) {
+	if len(data) < protocol.ProbeMinRequestSize {
+		return
+	}
+	var req protocol.ProbeRequest
+	if err := json.Unmarshal(data, &req); err != nil || len(req.ID) > protocol.ProbeMaxIDLength {
+		return
+	}
+
+	reply := func(c *net.UDPConn) {
+		resp, _ := json.Marshal(&protocol.ProbeResponse{
+			ID:      req.ID,
+			Address: addr.String(),
+			Port:    c.LocalAddr().(*net.UDPAddr).Port,
+		})
+		if _, err := c.WriteToUDP(resp, addr); err != nil {
+			logger.Debug("回复探测请求失败: %v", err)
+		}
+	}
+	reply(conn)
+	if req.Alt && altConn != nil {
+		reply(altConn)
+	}
+}
+
+// drain 丢弃备用端口收到的数据，连接关闭后返回
+func (s *Server) drain(conn *net.UDPConn) {
+	defer s.wg.Done()
+
+	buffer := make([]byte, maxPacketSize)
+	for {
+		if _, _, err := conn.ReadFromUDP(buffer); err != nil && errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+// serveTCP 接受 TCP 探测连接，监听器关闭后返回
+func (s *Server) serveTCP(listener net.Listener) {
+	defer s.wg.Done()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logger.Warn("接受探测连接失败: %v", err)
+			continue
+		}
+		go s.handleTCP(conn)
+	}
+}
+
+// handleTCP 读取一行探测请求，回复看到的来源地址后关闭连接
+func (s *Server) handleTCP(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(tcpProbeTimeout))
+
+	line, err := bufio.NewReaderSize(conn, maxPacketSize).ReadSlice('\n')
+	if err != nil {
+		return
+	}
+	var req protocol.ProbeRequest
+	if err := json.Unmarshal(line, &req); err != nil || len(req.ID) > protocol.ProbeMaxIDLength {
+		return
+	}
+	resp, _ := json.Marshal(&protocol.ProbeResponse{
+		ID:      req.ID,
+		Address: conn.RemoteAddr().String(),
+		Port:    conn.LocalAddr().(*net.TCPAddr).Port,
+	})
+	conn.Write(append(resp, '\n'))
+}
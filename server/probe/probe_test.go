@@ -0,0 +1,139 @@
+package probe
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/common/protocol"
+)
+
+func TestProbeServer(t *testing.T) {
+	s := NewServer("127.0.0.1", 0, 0, 0)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动回显探测服务失败: %v", err)
+	}
+	defer s.Stop()
+	info := s.Info("203.0.113.1")
+	if info.UDPPort == 0 || info.UDPAltPort != 0 || info.TCPPort != 0 || info.Address != "203.0.113.1" {
+		t.Fatalf("探测服务信息 = %+v", info)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	server := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: info.UDPPort}
+
+	// 过短的请求不回复
+	if _, err := conn.WriteToUDP([]byte(`{"id":"short"}`), server); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := protocol.MarshalProbeRequest(&protocol.ProbeRequest{ID: "probe-1", Alt: true})
+	if _, err := conn.WriteToUDP(data, server); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, maxPacketSize)
+	n, _, err := conn.ReadFromUDP(buffer)
+	if err != nil {
+		t.Fatalf("没有收到探测响应: %v", err)
+	}
+	var resp protocol.ProbeResponse
+	if err := json.Unmarshal(buffer[:n], &resp); err != nil {
+		t.Fatalf("解析探测响应失败: %v", err)
+	}
+	if resp.ID != "probe-1" || resp.Address != conn.LocalAddr().String() || resp.Port != info.UDPPort {
+		t.Fatalf("探测响应 = %+v，本地地址 %s", resp, conn.LocalAddr())
+	}
+}
+
+func TestProbeServerAltPort(t *testing.T) {
+	// 备用端口为 0 时不监听，先取一个空闲端口
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	s := NewServer("127.0.0.1", 0, port, 0)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动回显探测服务失败: %v", err)
+	}
+	defer s.Stop()
+	info := s.Info("")
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	data, _ := protocol.MarshalProbeRequest(&protocol.ProbeRequest{ID: "probe-2", Alt: true})
+	if _, err := client.WriteToUDP(data, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: info.UDPPort}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 主端口和备用端口各回复一次
+	ports := make(map[int]bool)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buffer := make([]byte, maxPacketSize)
+	for len(ports) < 2 {
+		n, from, err := client.ReadFromUDP(buffer)
+		if err != nil {
+			t.Fatalf("没有收到全部探测响应: %v, %v", ports, err)
+		}
+		var resp protocol.ProbeResponse
+		if err := json.Unmarshal(buffer[:n], &resp); err != nil || resp.Port != from.Port {
+			t.Fatalf("探测响应 = %+v，来源 %s", resp, from)
+		}
+		ports[from.Port] = true
+	}
+	if !ports[info.UDPPort] || !ports[port] {
+		t.Fatalf("回复端口 = %v", ports)
+	}
+}
+
+func TestProbeServerTCP(t *testing.T) {
+	// TCP 端口为 0 时不监听，先取一个空闲端口
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	s := NewServer("127.0.0.1", 0, 0, port)
+	if err := s.Start(); err != nil {
+		t.Fatalf("启动回显探测服务失败: %v", err)
+	}
+	defer s.Stop()
+	if info := s.Info(""); info.TCPPort != port {
+		t.Fatalf("探测服务信息 = %+v", info)
+	}
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("连接 TCP 探测端口失败: %v", err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte(`{"id":"probe-3"}` + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatalf("没有收到探测响应: %v", err)
+	}
+	var resp protocol.ProbeResponse
+	if err := json.Unmarshal(line, &resp); err != nil {
+		t.Fatalf("解析探测响应失败: %v", err)
+	}
+	if resp.ID != "probe-3" || resp.Address != conn.LocalAddr().String() || resp.Port != port {
+		t.Fatalf("探测响应 = %+v，本地地址 %s", resp, conn.LocalAddr())
+	}
+}
@@ -3,6 +3,8 @@ package relay
 import (
 	"sync"
 	"time"
+
+	"github.com/senma231/p3/common/stats"
 )
 
 // BandwidthManager 带宽管理器
@@ -22,9 +24,7 @@ type BandwidthManager struct {
 type UsageCounter struct {
 	bytesTotal   int64
 	bytesPerSec  int64
-	lastUpdated  time.Time
-	windowSize   time.Duration
-	bytesInWindow int64
+	meter        *stats.Meter
 	mu           sync.Mutex
 }
 
@@ -60,8 +60,7 @@ func (bm *BandwidthManager) GetNodeUsage(nodeID string) *UsageCounter {
 	counter, exists := bm.nodeUsage[nodeID]
 	if !exists {
 		counter = &UsageCounter{
-			lastUpdated: time.Now(),
-			windowSize:  time.Second,
+			meter: stats.NewMeter(time.Second),
 		}
 		bm.nodeUsage[nodeID] = counter
 	}
@@ -77,8 +76,7 @@ func (bm *BandwidthManager) GetSessionUsage(sessionID string) *UsageCounter {
 	counter, exists := bm.sessionUsage[sessionID]
 	if !exists {
 		counter = &UsageCounter{
-			lastUpdated: time.Now(),
-			windowSize:  time.Second,
+			meter: stats.NewMeter(time.Second),
 		}
 		bm.sessionUsage[sessionID] = counter
 	}
@@ -91,31 +89,23 @@ func (uc *UsageCounter) AddBytes(bytes int64) {
 	uc.mu.Lock()
 	defer uc.mu.Unlock()
 	
-	now := time.Now()
-	elapsed := now.Sub(uc.lastUpdated)
+	if bytes <= 0 {
+		return
+	}
 	
 	// 更新总字节数
-	uc.bytesTotal += bytes
-	
-	// 更新窗口内字节数
-	if elapsed >= uc.windowSize {
-		// 如果已经过了一个窗口，重置窗口内字节数
-		uc.bytesInWindow = bytes
-		uc.bytesPerSec = bytes
-	} else {
-		// 否则累加窗口内字节数
-		uc.bytesInWindow += bytes
-		// 计算每秒字节数
-		uc.bytesPerSec = int64(float64(uc.bytesInWindow) / elapsed.Seconds())
-	}
+	uc.bytesTotal = stats.AddInt64(uc.bytesTotal, bytes)
 	
-	uc.lastUpdated = now
+	// 更新每秒字节数，窗口按单调时钟划分
+	uc.meter.Add(uint64(bytes))
+	uc.bytesPerSec = int64(uc.meter.Rate())
 }
 
 // GetBytesPerSec 获取每秒字节数
 func (uc *UsageCounter) GetBytesPerSec() int64 {
 	uc.mu.Lock()
 	defer uc.mu.Unlock()
+	uc.bytesPerSec = int64(uc.meter.Rate())
 	return uc.bytesPerSec
 }
 
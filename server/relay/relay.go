@@ -8,8 +8,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/senma231/p3/common/stats"
 	"github.com/senma231/p3/server/config"
 	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
 )
 
 // Session 中转会话
@@ -57,22 +59,24 @@ func (s *Session) UpdateStats(bytesSent, bytesReceived uint64) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	s.BytesSent += bytesSent
-	s.BytesReceived += bytesReceived
+	s.BytesSent = stats.Add(s.BytesSent, bytesSent)
+	s.BytesReceived = stats.Add(s.BytesReceived, bytesReceived)
 	s.LastActiveTime = time.Now()
 }
 
 // Service 中转服务
 type Service struct {
 	config   *config.Config
+	stats    store.StatsRepo
 	sessions map[string]*Session
 	mu       sync.RWMutex
 }
 
-// NewService 创建中转服务
-func NewService(cfg *config.Config) *Service {
+// NewService 创建中转服务，会话结束时的流量统计写入 stats
+func NewService(cfg *config.Config, stats store.StatsRepo) *Service {
 	return &Service{
 		config:   cfg,
+		stats:    stats,
 		sessions: make(map[string]*Session),
 	}
 }
@@ -191,16 +195,19 @@ func (s *Service) RecordRelayStats(sessionID string, sourceDeviceID, targetDevic
 		return err
 	}
 
+	// 按单调时钟计算会话时长，系统时间被修改时不会出现负数
+	connectionTime := stats.Seconds(stats.Since(session.StartTime))
+
 	// 记录源设备统计信息
 	sourceStats := &db.Stats{
 		DeviceID:       sourceDeviceID,
 		BytesSent:      session.BytesSent,
 		BytesReceived:  session.BytesReceived,
 		Connections:    1,
-		ConnectionTime: uint64(time.Since(session.StartTime).Seconds()),
+		ConnectionTime: connectionTime,
 	}
 
-	if err := db.DB.Create(sourceStats).Error; err != nil {
+	if err := s.stats.Create(sourceStats); err != nil {
 		return fmt.Errorf("记录源设备统计信息失败: %w", err)
 	}
 
@@ -210,10 +217,10 @@ func (s *Service) RecordRelayStats(sessionID string, sourceDeviceID, targetDevic
 		BytesSent:      session.BytesReceived,
 		BytesReceived:  session.BytesSent,
 		Connections:    1,
-		ConnectionTime: uint64(time.Since(session.StartTime).Seconds()),
+		ConnectionTime: connectionTime,
 	}
 
-	if err := db.DB.Create(targetStats).Error; err != nil {
+	if err := s.stats.Create(targetStats); err != nil {
 		return fmt.Errorf("记录目标设备统计信息失败: %w", err)
 	}
 
@@ -0,0 +1,62 @@
+package relay
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	// stunMagicCookie STUN 魔术字
+	stunMagicCookie = 0x2112A442
+	// stunHeaderSize STUN 消息头长度
+	stunHeaderSize = 20
+	// stunAttrXorMappedAddress XOR-MAPPED-ADDRESS 属性
+	stunAttrXorMappedAddress = 0x0020
+)
+
+// isSTUNBindingRequest 检查是否为 STUN Binding 请求
+func isSTUNBindingRequest(data []byte) bool {
+	return len(data) >= stunHeaderSize &&
+		binary.BigEndian.Uint16(data[0:2]) == turnBindingRequest &&
+		binary.BigEndian.Uint32(data[4:8]) == stunMagicCookie
+}
+
+// buildBindingResponse 构造带 XOR-MAPPED-ADDRESS 的 Binding 成功响应
+func buildBindingResponse(transactionID []byte, addr *net.UDPAddr) []byte {
+	// 地址族及 XOR 后的 IP
+	family := byte(0x01)
+	ip := addr.IP.To4()
+	if ip == nil {
+		family = 0x02
+		ip = addr.IP.To16()
+	}
+
+	// IPv4 使用魔术字异或，IPv6 使用魔术字和事务 ID 异或
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint32(key[0:4], stunMagicCookie)
+	copy(key[4:], transactionID)
+	xorIP := make([]byte, len(ip))
+	for i := range ip {
+		xorIP[i] = ip[i] ^ key[i]
+	}
+
+	attrLen := 4 + len(xorIP)
+	msg := make([]byte, stunHeaderSize+4+attrLen)
+
+	// 消息头
+	binary.BigEndian.PutUint16(msg[0:2], turnBindingResponse)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(4+attrLen))
+	binary.BigEndian.PutUint32(msg[4:8], stunMagicCookie)
+	copy(msg[8:20], transactionID)
+
+	// XOR-MAPPED-ADDRESS 属性
+	attr := msg[stunHeaderSize:]
+	binary.BigEndian.PutUint16(attr[0:2], stunAttrXorMappedAddress)
+	binary.BigEndian.PutUint16(attr[2:4], uint16(attrLen))
+	attr[4] = 0
+	attr[5] = family
+	binary.BigEndian.PutUint16(attr[6:8], uint16(addr.Port)^uint16(stunMagicCookie>>16))
+	copy(attr[8:], xorIP)
+
+	return msg
+}
@@ -0,0 +1,74 @@
+package relay
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+)
+
+func TestBuildBindingResponse(t *testing.T) {
+	transactionID := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+
+	tests := []struct {
+		name   string
+		addr   *net.UDPAddr
+		family byte
+	}{
+		{"IPv4", &net.UDPAddr{IP: net.ParseIP("203.0.113.10"), Port: 54321}, 0x01},
+		{"IPv6", &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 3478}, 0x02},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := buildBindingResponse(transactionID, tt.addr)
+
+			if binary.BigEndian.Uint16(msg[0:2]) != turnBindingResponse {
+				t.Fatalf("消息类型错误: %04x", binary.BigEndian.Uint16(msg[0:2]))
+			}
+			if int(binary.BigEndian.Uint16(msg[2:4])) != len(msg)-stunHeaderSize {
+				t.Fatalf("消息长度错误: %d", binary.BigEndian.Uint16(msg[2:4]))
+			}
+
+			attr := msg[stunHeaderSize:]
+			if binary.BigEndian.Uint16(attr[0:2]) != stunAttrXorMappedAddress {
+				t.Fatalf("属性类型错误: %04x", binary.BigEndian.Uint16(attr[0:2]))
+			}
+			if attr[5] != tt.family {
+				t.Fatalf("地址族错误: %d", attr[5])
+			}
+
+			port := binary.BigEndian.Uint16(attr[6:8]) ^ uint16(stunMagicCookie>>16)
+			if int(port) != tt.addr.Port {
+				t.Errorf("端口错误，期望 %d，实际 %d", tt.addr.Port, port)
+			}
+
+			key := make([]byte, 16)
+			binary.BigEndian.PutUint32(key[0:4], stunMagicCookie)
+			copy(key[4:], transactionID)
+			ip := make(net.IP, len(attr)-8)
+			for i := range ip {
+				ip[i] = attr[8+i] ^ key[i]
+			}
+			if !ip.Equal(tt.addr.IP) {
+				t.Errorf("IP 错误，期望 %s，实际 %s", tt.addr.IP, ip)
+			}
+		})
+	}
+}
+
+func TestIsSTUNBindingRequest(t *testing.T) {
+	req := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(req[0:2], turnBindingRequest)
+	binary.BigEndian.PutUint32(req[4:8], stunMagicCookie)
+	if !isSTUNBindingRequest(req) {
+		t.Error("应识别为 Binding 请求")
+	}
+
+	binary.BigEndian.PutUint32(req[4:8], 0)
+	if isSTUNBindingRequest(req) {
+		t.Error("缺少魔术字时不应识别为 Binding 请求")
+	}
+	if isSTUNBindingRequest(req[:10]) {
+		t.Error("过短的消息不应识别为 Binding 请求")
+	}
+}
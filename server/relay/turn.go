@@ -2,10 +2,12 @@ package relay
 
 import (
 	"bytes"
+	"crypto/rand"
 	"encoding/binary"
 	"errors"
 	"fmt"
 	"net"
+	"sync"
 	"time"
 )
 
@@ -31,10 +33,13 @@ type TURNServer struct {
 	realm       string
 	authSecret  string
 	allocations map[string]*Allocation
+	conn        *net.UDPConn
+	mu          sync.Mutex
 }
 
 // Allocation 分配
 type Allocation struct {
+	nodeID       string // 凭据所属的节点
 	fiveTuple    string
 	relayAddr    *net.UDPAddr
 	permissions  map[string]time.Time
@@ -53,7 +58,7 @@ func NewTURNServer(addr, realm, authSecret string) *TURNServer {
 	}
 }
 
-// Start 启动 TURN 服务器
+// Start 启动 TURN 服务器，监听成功后在后台处理请求
 func (s *TURNServer) Start() error {
 	// 解析地址
 	udpAddr, err := net.ResolveUDPAddr("udp", s.addr)
@@ -66,21 +71,48 @@ func (s *TURNServer) Start() error {
 	if err != nil {
 		return fmt.Errorf("监听 UDP 失败: %w", err)
 	}
-	defer conn.Close()
+	s.mu.Lock()
+	s.conn = conn
+	s.mu.Unlock()
 
 	fmt.Printf("TURN 服务器已启动，监听地址: %s\n", s.addr)
 
-	// 处理请求
+	go s.serve(conn)
+	return nil
+}
+
+// Stop 停止 TURN 服务器
+func (s *TURNServer) Stop() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// serve 处理请求，连接关闭后返回
+func (s *TURNServer) serve(conn *net.UDPConn) {
 	buffer := make([]byte, 1500)
 	for {
 		n, addr, err := conn.ReadFromUDP(buffer)
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			fmt.Printf("读取 UDP 失败: %v\n", err)
 			continue
 		}
 
+		// 复制数据，避免缓冲区被下一次读取覆盖
+		data := make([]byte, n)
+		copy(data, buffer[:n])
+
 		// 处理 TURN 消息
-		go s.handleTURNMessage(conn, addr, buffer[:n])
+		go s.handleTURNMessage(conn, addr, data)
 	}
 }
 
@@ -114,53 +146,26 @@ func (s *TURNServer) handleTURNMessage(conn *net.UDPConn, addr *net.UDPAddr, dat
 	}
 }
 
-// handleBindingRequest 处理 Binding 请求
+// handleBindingRequest 处理 Binding 请求，作为内置 STUN 服务器响应客户端的地址探测
 func (s *TURNServer) handleBindingRequest(conn *net.UDPConn, addr *net.UDPAddr, data []byte) {
-	// 提取事务 ID
-	transactionID := data[8:20]
-
-	// 创建响应
-	response := new(bytes.Buffer)
-	
-	// 写入消息类型
-	binary.Write(response, binary.BigEndian, uint16(turnBindingResponse))
-	
-	// 写入消息长度（暂时为0）
-	binary.Write(response, binary.BigEndian, uint16(8))
-	
-	// 写入魔术字
-	binary.Write(response, binary.BigEndian, uint32(0x2112A442))
-	
-	// 写入事务 ID
-	response.Write(transactionID)
-	
-	// 写入 XOR-MAPPED-ADDRESS 属性
-	binary.Write(response, binary.BigEndian, uint16(0x0020)) // 属性类型
-	binary.Write(response, binary.BigEndian, uint16(8))      // 属性长度
-	response.WriteByte(0)                                    // 保留
-	response.WriteByte(0x01)                                 // IPv4
-	
-	// 异或端口
-	port := uint16(addr.Port)
-	port ^= 0x2112 // 魔术字的前 16 位
-	binary.Write(response, binary.BigEndian, port)
-	
-	// 异或 IP
-	ip := addr.IP.To4()
-	xorIP := make([]byte, 4)
-	for i := 0; i < 4; i++ {
-		xorIP[i] = ip[i] ^ 0x21
+	if !isSTUNBindingRequest(data) {
+		return
 	}
-	response.Write(xorIP)
-	
-	// 发送响应
-	conn.WriteToUDP(response.Bytes(), addr)
+
+	// 返回客户端的映射地址
+	conn.WriteToUDP(buildBindingResponse(data[8:20], addr), addr)
 }
 
 // handleAllocateRequest 处理 Allocate 请求
 func (s *TURNServer) handleAllocateRequest(conn *net.UDPConn, addr *net.UDPAddr, data []byte) {
-	// 这里简化实现，实际应该解析请求属性并验证认证
-	
+	// 校验短期凭据，失败时返回 401 或 438 并携带新的 nonce
+	now := time.Now()
+	nodeID, key, authErr := s.authenticate(data, now)
+	if authErr != nil {
+		conn.WriteToUDP(s.buildAuthErrorResponse(turnAllocateErrorResponse, data[8:20], authErr, now), addr)
+		return
+	}
+
 	// 创建分配
 	fiveTuple := addr.String()
 	
@@ -183,6 +188,7 @@ func (s *TURNServer) handleAllocateRequest(conn *net.UDPConn, addr *net.UDPAddr,
 	
 	// 创建分配
 	allocation := &Allocation{
+		nodeID:       nodeID,
 		fiveTuple:    fiveTuple,
 		relayAddr:    relayAddr,
 		permissions:  make(map[string]time.Time),
@@ -236,8 +242,8 @@ func (s *TURNServer) handleAllocateRequest(conn *net.UDPConn, addr *net.UDPAddr,
 	binary.Write(response, binary.BigEndian, uint16(4))      // 属性长度
 	binary.Write(response, binary.BigEndian, uint32(600))    // 10分钟
 	
-	// 发送响应
-	conn.WriteToUDP(response.Bytes(), addr)
+	// 发送响应，使用请求的凭据签名
+	conn.WriteToUDP(appendMessageIntegrity(response.Bytes(), key), addr)
 	
 	// 启动中继
 	go s.relay(conn, relayConn, allocation)
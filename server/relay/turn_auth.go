@@ -0,0 +1,174 @@
+package relay
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+const (
+	// STUN 属性类型
+	stunAttrUsername         = 0x0006
+	stunAttrMessageIntegrity = 0x0008
+	stunAttrErrorCode        = 0x0009
+	stunAttrRealm            = 0x0014
+	stunAttrNonce            = 0x0015
+
+	// turnAllocateErrorResponse Allocate 错误响应
+	turnAllocateErrorResponse = 0x0113
+
+	// messageIntegritySize MESSAGE-INTEGRITY 属性的总长度（属性头 + HMAC-SHA1）
+	messageIntegritySize = 4 + sha1.Size
+
+	// turnNonceWindow nonce 的有效时间窗口，上一个窗口的 nonce 仍然有效
+	turnNonceWindow = 10 * time.Minute
+)
+
+// turnAuthError TURN 认证失败时返回的错误码
+type turnAuthError struct {
+	code   int
+	reason string
+}
+
+var (
+	errTURNUnauthorized = &turnAuthError{code: 401, reason: "Unauthorized"}
+	errTURNStaleNonce   = &turnAuthError{code: 438, reason: "Stale Nonce"}
+)
+
+// stunAttributes 解析 STUN 消息的属性，返回属性值和 MESSAGE-INTEGRITY 属性在消息中的偏移，
+// 没有 MESSAGE-INTEGRITY 时偏移为 -1。MESSAGE-INTEGRITY 之后的属性不参与认证，直接忽略
+func stunAttributes(msg []byte) (map[uint16][]byte, int) {
+	attrs := make(map[uint16][]byte)
+	if len(msg) < stunHeaderSize {
+		return attrs, -1
+	}
+
+	end := stunHeaderSize + int(binary.BigEndian.Uint16(msg[2:4]))
+	if end > len(msg) {
+		end = len(msg)
+	}
+	for offset := stunHeaderSize; offset+4 <= end; {
+		attrType := binary.BigEndian.Uint16(msg[offset : offset+2])
+		attrLen := int(binary.BigEndian.Uint16(msg[offset+2 : offset+4]))
+		if offset+4+attrLen > end {
+			break
+		}
+		if attrType == stunAttrMessageIntegrity {
+			if attrLen != sha1.Size {
+				break
+			}
+			return attrs, offset
+		}
+		attrs[attrType] = msg[offset+4 : offset+4+attrLen]
+		// 属性按 4 字节对齐
+		offset += 4 + (attrLen+3)&^3
+	}
+	return attrs, -1
+}
+
+// longTermKey 计算长期凭据机制的密钥 MD5(username:realm:password)
+func longTermKey(username, realm, password string) []byte {
+	sum := md5.Sum([]byte(username + ":" + realm + ":" + password))
+	return sum[:]
+}
+
+// messageIntegrity 计算 MESSAGE-INTEGRITY，覆盖该属性之前的消息，消息头长度按包含该属性计算
+func messageIntegrity(msg []byte, key []byte) []byte {
+	header := make([]byte, stunHeaderSize)
+	copy(header, msg[:stunHeaderSize])
+	binary.BigEndian.PutUint16(header[2:4], uint16(len(msg)-stunHeaderSize+messageIntegritySize))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(header)
+	mac.Write(msg[stunHeaderSize:])
+	return mac.Sum(nil)
+}
+
+// appendMessageIntegrity 在消息末尾追加 MESSAGE-INTEGRITY 属性并更新消息长度
+func appendMessageIntegrity(msg []byte, key []byte) []byte {
+	sum := messageIntegrity(msg, key)
+	attr := make([]byte, 4, messageIntegritySize)
+	binary.BigEndian.PutUint16(attr[0:2], stunAttrMessageIntegrity)
+	binary.BigEndian.PutUint16(attr[2:4], sha1.Size)
+	msg = append(msg, append(attr, sum...)...)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)-stunHeaderSize))
+	return msg
+}
+
+// appendSTUNAttribute 追加属性，按 4 字节对齐填充
+func appendSTUNAttribute(msg []byte, attrType uint16, value []byte) []byte {
+	attr := make([]byte, 4, 4+len(value)+3)
+	binary.BigEndian.PutUint16(attr[0:2], attrType)
+	binary.BigEndian.PutUint16(attr[2:4], uint16(len(value)))
+	attr = append(attr, value...)
+	for len(attr)%4 != 0 {
+		attr = append(attr, 0)
+	}
+	msg = append(msg, attr...)
+	binary.BigEndian.PutUint16(msg[2:4], uint16(len(msg)-stunHeaderSize))
+	return msg
+}
+
+// nonce 生成当前时间窗口的 nonce，由共享密钥派生，服务端无需保存
+func (s *TURNServer) nonce(now time.Time) string {
+	return s.nonceForWindow(now.Unix() / int64(turnNonceWindow/time.Second))
+}
+
+// nonceForWindow 生成指定时间窗口的 nonce
+func (s *TURNServer) nonceForWindow(window int64) string {
+	mac := hmac.New(sha1.New, []byte(s.authSecret))
+	mac.Write([]byte("nonce:" + strconv.FormatInt(window, 10)))
+	return hex.EncodeToString(mac.Sum(nil))[:24]
+}
+
+// validNonce 检查 nonce 是否属于当前或上一个时间窗口
+func (s *TURNServer) validNonce(nonce string, now time.Time) bool {
+	window := now.Unix() / int64(turnNonceWindow/time.Second)
+	return hmac.Equal([]byte(nonce), []byte(s.nonceForWindow(window))) ||
+		hmac.Equal([]byte(nonce), []byte(s.nonceForWindow(window-1)))
+}
+
+// authenticate 按长期凭据机制校验请求，凭据为 MintTURNCredentials 签发的短期凭据。
+// 成功时返回凭据所属的节点 ID 和用于响应签名的密钥
+func (s *TURNServer) authenticate(msg []byte, now time.Time) (string, []byte, *turnAuthError) {
+	attrs, integrityOffset := stunAttributes(msg)
+	username, hasUsername := attrs[stunAttrUsername]
+	nonce, hasNonce := attrs[stunAttrNonce]
+	if integrityOffset < 0 || !hasUsername || !hasNonce {
+		return "", nil, errTURNUnauthorized
+	}
+	if !s.validNonce(string(nonce), now) {
+		return "", nil, errTURNStaleNonce
+	}
+
+	// 密码由共享密钥派生，这里只校验用户名格式和有效期，密码是否正确由 MESSAGE-INTEGRITY 校验
+	password := turnPassword(s.authSecret, string(username))
+	nodeID, err := VerifyTURNCredentials(s.authSecret, string(username), password, now)
+	if err != nil {
+		return "", nil, errTURNUnauthorized
+	}
+
+	key := longTermKey(string(username), s.realm, password)
+	expected := messageIntegrity(msg[:integrityOffset], key)
+	if !hmac.Equal(expected, msg[integrityOffset+4:integrityOffset+messageIntegritySize]) {
+		return "", nil, errTURNUnauthorized
+	}
+	return nodeID, key, nil
+}
+
+// buildAuthErrorResponse 构造认证失败的错误响应，携带 REALM 和新的 NONCE 供客户端重试
+func (s *TURNServer) buildAuthErrorResponse(messageType uint16, transactionID []byte, authErr *turnAuthError, now time.Time) []byte {
+	msg := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(msg[0:2], messageType)
+	binary.BigEndian.PutUint32(msg[4:8], stunMagicCookie)
+	copy(msg[8:20], transactionID)
+
+	errorCode := []byte{0, 0, byte(authErr.code / 100), byte(authErr.code % 100)}
+	msg = appendSTUNAttribute(msg, stunAttrErrorCode, append(errorCode, authErr.reason...))
+	msg = appendSTUNAttribute(msg, stunAttrRealm, []byte(s.realm))
+	return appendSTUNAttribute(msg, stunAttrNonce, []byte(s.nonce(now)))
+}
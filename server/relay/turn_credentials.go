@@ -0,0 +1,78 @@
+package relay
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrTURNCredentialsInvalid TURN 凭据格式错误或密码不匹配
+	ErrTURNCredentialsInvalid = errors.New("TURN 凭据无效")
+	// ErrTURNCredentialsExpired TURN 凭据已过期
+	ErrTURNCredentialsExpired = errors.New("TURN 凭据已过期")
+)
+
+// TURNCredentials 短期 TURN 凭据，客户端将其加入 ICE 配置
+type TURNCredentials struct {
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	TTL      int      `json:"ttl"` // 单位：秒
+	URIs     []string `json:"uris"`
+}
+
+// MintTURNCredentials 按 TURN REST API 约定为节点签发短期凭据。
+// 用户名为 "过期时间戳:节点 ID"，密码为使用 authSecret 对用户名计算的 HMAC-SHA1 的 base64 编码，
+// TURN 服务器只需共享密钥即可校验，无需查询数据库
+func MintTURNCredentials(authSecret, nodeID string, now time.Time, ttl time.Duration) (username, password string) {
+	username = strconv.FormatInt(now.Add(ttl).Unix(), 10) + ":" + nodeID
+	return username, turnPassword(authSecret, username)
+}
+
+// VerifyTURNCredentials 校验 TURN 凭据，返回凭据所属的节点 ID
+func VerifyTURNCredentials(authSecret, username, password string, now time.Time) (string, error) {
+	expPart, nodeID, ok := strings.Cut(username, ":")
+	if !ok || nodeID == "" {
+		return "", ErrTURNCredentialsInvalid
+	}
+	expiresAt, err := strconv.ParseInt(expPart, 10, 64)
+	if err != nil {
+		return "", ErrTURNCredentialsInvalid
+	}
+	if !hmac.Equal([]byte(password), []byte(turnPassword(authSecret, username))) {
+		return "", ErrTURNCredentialsInvalid
+	}
+	if !now.Before(time.Unix(expiresAt, 0)) {
+		return "", ErrTURNCredentialsExpired
+	}
+	return nodeID, nil
+}
+
+// TURNURIs 获取客户端连接 TURN 服务器使用的地址。未配置 uris 时使用 realm 作为主机名，
+// 端口取自 TURN 服务器的监听地址
+func TURNURIs(uris []string, address, realm string) []string {
+	if len(uris) > 0 {
+		return uris
+	}
+	port := "3478"
+	if _, p, err := net.SplitHostPort(address); err == nil && p != "" {
+		port = p
+	}
+	host := net.JoinHostPort(realm, port)
+	return []string{
+		"turn:" + host + "?transport=udp",
+		"stun:" + host,
+	}
+}
+
+// turnPassword 计算 TURN 凭据的密码
+func turnPassword(authSecret, username string) string {
+	mac := hmac.New(sha1.New, []byte(authSecret))
+	mac.Write([]byte(username))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,107 @@
+package relay
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestTURNCredentials(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	username, password := MintTURNCredentials("secret", "node-a", now, time.Hour)
+
+	if username != "1700003600:node-a" {
+		t.Fatalf("用户名错误: %s", username)
+	}
+
+	nodeID, err := VerifyTURNCredentials("secret", username, password, now.Add(59*time.Minute))
+	if err != nil {
+		t.Fatalf("校验凭据失败: %v", err)
+	}
+	if nodeID != "node-a" {
+		t.Fatalf("节点 ID 错误: %s", nodeID)
+	}
+
+	if _, err := VerifyTURNCredentials("secret", username, password, now.Add(time.Hour)); err != ErrTURNCredentialsExpired {
+		t.Fatalf("过期凭据应被拒绝: %v", err)
+	}
+	if _, err := VerifyTURNCredentials("other", username, password, now); err != ErrTURNCredentialsInvalid {
+		t.Fatalf("使用其他密钥签发的凭据应被拒绝: %v", err)
+	}
+	// 修改用户名中的节点或过期时间后密码不再匹配
+	if _, err := VerifyTURNCredentials("secret", "1700003600:node-b", password, now); err != ErrTURNCredentialsInvalid {
+		t.Fatalf("其他节点不能使用该凭据: %v", err)
+	}
+	if _, err := VerifyTURNCredentials("secret", "1800000000:node-a", password, now); err != ErrTURNCredentialsInvalid {
+		t.Fatalf("延长有效期的凭据应被拒绝: %v", err)
+	}
+}
+
+func TestTURNURIs(t *testing.T) {
+	uris := TURNURIs(nil, "0.0.0.0:3479", "turn.example.com")
+	if len(uris) != 2 || uris[0] != "turn:turn.example.com:3479?transport=udp" || uris[1] != "stun:turn.example.com:3479" {
+		t.Fatalf("生成的地址错误: %v", uris)
+	}
+
+	configured := []string{"turns:turn.example.com:443?transport=tcp"}
+	if uris := TURNURIs(configured, "0.0.0.0:3478", "turn.example.com"); len(uris) != 1 || uris[0] != configured[0] {
+		t.Fatalf("应使用配置的地址: %v", uris)
+	}
+}
+
+// buildAllocateRequest 构造带长期凭据的 Allocate 请求
+func buildAllocateRequest(username, realm, password, nonce string) []byte {
+	msg := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(msg[0:2], turnAllocateRequest)
+	binary.BigEndian.PutUint32(msg[4:8], stunMagicCookie)
+	copy(msg[8:20], []byte("transaction1"))
+
+	msg = appendSTUNAttribute(msg, stunAttrUsername, []byte(username))
+	msg = appendSTUNAttribute(msg, stunAttrRealm, []byte(realm))
+	msg = appendSTUNAttribute(msg, stunAttrNonce, []byte(nonce))
+	return appendMessageIntegrity(msg, longTermKey(username, realm, password))
+}
+
+func TestTURNServerAuthenticate(t *testing.T) {
+	server := NewTURNServer("127.0.0.1:0", "p3.example.com", "secret")
+	now := time.Unix(1700000000, 0)
+	username, password := MintTURNCredentials("secret", "node-a", now, time.Hour)
+	nonce := server.nonce(now)
+
+	nodeID, key, authErr := server.authenticate(buildAllocateRequest(username, "p3.example.com", password, nonce), now)
+	if authErr != nil {
+		t.Fatalf("认证失败: %d %s", authErr.code, authErr.reason)
+	}
+	if nodeID != "node-a" || key == nil {
+		t.Fatalf("认证结果错误: %s", nodeID)
+	}
+
+	// 上一个时间窗口的 nonce 仍然有效，更早的 nonce 返回 438
+	if _, _, authErr := server.authenticate(buildAllocateRequest(username, "p3.example.com", password, nonce), now.Add(turnNonceWindow)); authErr != nil {
+		t.Fatalf("上一个窗口的 nonce 应有效: %v", authErr)
+	}
+	if _, _, authErr := server.authenticate(buildAllocateRequest(username, "p3.example.com", password, nonce), now.Add(2*turnNonceWindow)); authErr != errTURNStaleNonce {
+		t.Fatalf("过期的 nonce 应返回 438: %v", authErr)
+	}
+
+	// 错误的密码和缺少凭据的请求返回 401
+	if _, _, authErr := server.authenticate(buildAllocateRequest(username, "p3.example.com", "wrong", nonce), now); authErr != errTURNUnauthorized {
+		t.Fatalf("错误的密码应被拒绝: %v", authErr)
+	}
+	unauthenticated := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(unauthenticated[0:2], turnAllocateRequest)
+	binary.BigEndian.PutUint32(unauthenticated[4:8], stunMagicCookie)
+	if _, _, authErr := server.authenticate(unauthenticated, now); authErr != errTURNUnauthorized {
+		t.Fatalf("缺少凭据的请求应被拒绝: %v", authErr)
+	}
+
+	// 错误响应携带 REALM 和 NONCE
+	resp := server.buildAuthErrorResponse(turnAllocateErrorResponse, unauthenticated[8:20], errTURNUnauthorized, now)
+	attrs, _ := stunAttributes(resp)
+	if string(attrs[stunAttrRealm]) != "p3.example.com" || string(attrs[stunAttrNonce]) != nonce {
+		t.Fatalf("错误响应缺少 REALM 或 NONCE")
+	}
+	if code := attrs[stunAttrErrorCode]; len(code) < 4 || int(code[2])*100+int(code[3]) != 401 {
+		t.Fatalf("错误码错误: %v", code)
+	}
+}
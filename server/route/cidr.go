@@ -0,0 +1,38 @@
+package route
+
+import (
+	"fmt"
+	"net"
+)
+
+// ParseCIDR 解析并规范化 CIDR，主机位会被清零
+func ParseCIDR(cidr string) (*net.IPNet, error) {
+	_, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("无效的 CIDR: %s", cidr)
+	}
+
+	// IPv4 统一使用 4 字节表示
+	if ip4 := ipNet.IP.To4(); ip4 != nil {
+		ipNet.IP = ip4
+		ones, _ := ipNet.Mask.Size()
+		ipNet.Mask = net.CIDRMask(ones, 32)
+	}
+
+	return ipNet, nil
+}
+
+// Overlaps 检查两个网段是否重叠
+func Overlaps(a, b *net.IPNet) bool {
+	// 地址族不同不会重叠
+	if len(a.IP) != len(b.IP) {
+		return false
+	}
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// isDefaultRoute 检查是否为默认路由
+func isDefaultRoute(ipNet *net.IPNet) bool {
+	ones, _ := ipNet.Mask.Size()
+	return ones == 0
+}
@@ -0,0 +1,59 @@
+package route
+
+import (
+	"testing"
+)
+
+func TestParseCIDR(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"192.168.1.0/24", "192.168.1.0/24", false},
+		{"192.168.1.10/24", "192.168.1.0/24", false},
+		{"10.0.0.0/8", "10.0.0.0/8", false},
+		{"fd00::1/64", "fd00::/64", false},
+		{"192.168.1.0", "", true},
+		{"invalid", "", true},
+	}
+
+	for _, tt := range tests {
+		ipNet, err := ParseCIDR(tt.input)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseCIDR(%q) 应该返回错误", tt.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseCIDR(%q) 返回错误: %v", tt.input, err)
+			continue
+		}
+		if ipNet.String() != tt.want {
+			t.Errorf("ParseCIDR(%q) = %s, 期望 %s", tt.input, ipNet.String(), tt.want)
+		}
+	}
+}
+
+func TestOverlaps(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{"192.168.1.0/24", "192.168.1.0/24", true},
+		{"192.168.0.0/16", "192.168.1.0/24", true},
+		{"192.168.1.0/24", "192.168.0.0/16", true},
+		{"192.168.1.0/24", "192.168.2.0/24", false},
+		{"10.0.0.0/8", "172.16.0.0/12", false},
+		{"10.0.0.0/8", "fd00::/64", false},
+	}
+
+	for _, tt := range tests {
+		a, _ := ParseCIDR(tt.a)
+		b, _ := ParseCIDR(tt.b)
+		if got := Overlaps(a, b); got != tt.want {
+			t.Errorf("Overlaps(%s, %s) = %v, 期望 %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package route
+
+import (
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// ExitNode 出口节点信息
+type ExitNode struct {
+	DeviceID uint   `json:"deviceId"`
+	NodeID   string `json:"nodeId"`
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+}
+
+// SetExitNodeAllowed 设置设备是否允许作为出口节点
+func (s *Service) SetExitNodeAllowed(userID uint, deviceID uint, allowed bool) (*db.Device, error) {
+	device, err := s.getDevice(userID, deviceID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 取消授权时同时撤销通告
+	advertise := device.AdvertiseExitNode && allowed
+	if err := s.devices.UpdateFields(device, map[string]interface{}{
+		"exit_node_allowed":   allowed,
+		"advertise_exit_node": advertise,
+	}); err != nil {
+		return nil, errors.Database("更新设备失败", err)
+	}
+	device.ExitNodeAllowed = allowed
+	device.AdvertiseExitNode = advertise
+
+	return device, nil
+}
+
+// SetExitNodeAdvertised 设置设备是否通告为出口节点
+func (s *Service) SetExitNodeAdvertised(deviceID uint, advertise bool) error {
+	device, err := s.devices.GetByID(deviceID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return errors.NotFound("设备不存在")
+		}
+		return errors.Database("查询设备失败", err)
+	}
+
+	// 检查设备是否被允许作为出口节点
+	if advertise && !device.ExitNodeAllowed {
+		return errors.Forbidden("设备未被允许作为出口节点")
+	}
+
+	if err := s.devices.UpdateFields(device, map[string]interface{}{"advertise_exit_node": advertise}); err != nil {
+		return errors.Database("更新设备失败", err)
+	}
+
+	return nil
+}
+
+// GetExitNodes 获取设备可以使用的出口节点，状态取自设备的状态快照
+func (s *Service) GetExitNodes(userID uint, deviceID uint) ([]ExitNode, error) {
+	devices, err := s.devices.ListByUser(userID)
+	if err != nil {
+		return nil, errors.Database("查询出口节点失败", err)
+	}
+
+	nodes := make([]ExitNode, 0, len(devices))
+	for _, device := range devices {
+		if device.ID == deviceID || !device.ExitNodeAllowed || !device.AdvertiseExitNode {
+			continue
+		}
+		nodes = append(nodes, ExitNode{
+			DeviceID: device.ID,
+			NodeID:   device.NodeID,
+			Name:     device.Name,
+			Status:   device.Status,
+		})
+	}
+
+	return nodes, nil
+}
+
+// AuthorizeExitNodeClient 检查节点是否可以通过出口节点转发流量
+func (s *Service) AuthorizeExitNodeClient(exitDeviceID uint, clientNodeID string) error {
+	exitDevice, err := s.devices.GetByID(exitDeviceID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return errors.NotFound("设备不存在")
+		}
+		return errors.Database("查询设备失败", err)
+	}
+	if !exitDevice.ExitNodeAllowed || !exitDevice.AdvertiseExitNode {
+		return errors.Forbidden("设备未作为出口节点")
+	}
+
+	client, err := s.devices.GetByNodeID(clientNodeID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return errors.NotFound("设备不存在")
+		}
+		return errors.Database("查询设备失败", err)
+	}
+
+	// 只允许同一用户的设备使用出口节点
+	if client.UserID != exitDevice.UserID {
+		return errors.Forbidden("无权使用该出口节点")
+	}
+
+	return nil
+}
@@ -0,0 +1,386 @@
+package route
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// Service 子网路由服务
+type Service struct {
+	st      *store.Store
+	routes  store.RouteRepo
+	devices store.DeviceRepo
+}
+
+// NewService 创建子网路由服务
+func NewService(st *store.Store) *Service {
+	return &Service{
+		st:      st,
+		routes:  st.Routes,
+		devices: st.Devices,
+	}
+}
+
+// ForTenant 返回只能访问 ctx 中租户数据的子网路由服务，其他租户的路由和设备视为不存在
+func (s *Service) ForTenant(ctx context.Context) (*Service, error) {
+	scoped, err := s.st.ForContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	svc := *s
+	svc.routes = scoped.Routes
+	svc.devices = scoped.Devices
+	return &svc, nil
+}
+
+// RouteRequest 路由通告请求
+type RouteRequest struct {
+	DeviceID    uint   `json:"deviceId" binding:"required"`
+	CIDR        string `json:"cidr" binding:"required"`
+	Description string `json:"description" binding:"max=200,safemultiline" sanitize:"multiline"`
+}
+
+// RouteUpdateRequest 路由更新请求
+type RouteUpdateRequest struct {
+	CIDR        string `json:"cidr"`
+	Description string `json:"description" binding:"max=200,safemultiline" sanitize:"multiline"`
+	Enabled     *bool  `json:"enabled"`
+}
+
+// RouteACLRequest 路由访问控制请求
+type RouteACLRequest struct {
+	PeerDeviceIDs []uint `json:"peerDeviceIds"`
+}
+
+// PeerRoute 对等节点可访问的路由
+type PeerRoute struct {
+	RouteID uint   `json:"routeId"`
+	CIDR    string `json:"cidr"`
+	NodeID  string `json:"nodeId"`
+}
+
+// GetRoutes 获取用户的所有路由
+func (s *Service) GetRoutes(userID uint) ([]db.Route, error) {
+	routes, err := s.routes.ListByUser(userID)
+	if err != nil {
+		return nil, errors.Database("查询路由失败", err)
+	}
+	return routes, nil
+}
+
+// GetRoute 获取路由详情
+func (s *Service) GetRoute(userID uint, routeID uint) (*db.Route, error) {
+	route, err := s.routes.GetByID(routeID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("路由不存在")
+		}
+		return nil, errors.Database("查询路由失败", err)
+	}
+	if route.UserID != userID {
+		return nil, errors.NotFound("路由不存在")
+	}
+	return route, nil
+}
+
+// AdvertiseRoute 通告子网路由
+func (s *Service) AdvertiseRoute(userID uint, req *RouteRequest) (*db.Route, error) {
+	// 检查设备是否属于当前用户
+	if err := s.checkDevice(userID, req.DeviceID); err != nil {
+		return nil, err
+	}
+
+	// 解析并规范化 CIDR
+	cidr, err := normalizeRouteCIDR(req.CIDR)
+	if err != nil {
+		return nil, err
+	}
+
+	// 检查与已通告路由的冲突
+	if err := s.checkConflict(userID, 0, cidr); err != nil {
+		return nil, err
+	}
+
+	route := &db.Route{
+		UserID:      userID,
+		DeviceID:    req.DeviceID,
+		CIDR:        cidr,
+		Description: req.Description,
+		Enabled:     true,
+	}
+
+	if err := s.routes.Create(route); err != nil {
+		return nil, errors.Database("创建路由失败", err)
+	}
+
+	return route, nil
+}
+
+// UpdateRoute 更新路由
+func (s *Service) UpdateRoute(userID uint, routeID uint, req *RouteUpdateRequest) (*db.Route, error) {
+	route, err := s.GetRoute(userID, routeID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.CIDR != "" {
+		cidr, err := normalizeRouteCIDR(req.CIDR)
+		if err != nil {
+			return nil, err
+		}
+		route.CIDR = cidr
+	}
+	if req.Description != "" {
+		route.Description = req.Description
+	}
+	if req.Enabled != nil {
+		route.Enabled = *req.Enabled
+	}
+
+	// 启用的路由需要重新检查冲突
+	if route.Enabled {
+		if err := s.checkConflict(userID, route.ID, route.CIDR); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.routes.Save(route); err != nil {
+		return nil, errors.Database("更新路由失败", err)
+	}
+
+	return route, nil
+}
+
+// DeleteRoute 删除路由
+func (s *Service) DeleteRoute(userID uint, routeID uint) error {
+	route, err := s.GetRoute(userID, routeID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.routes.Delete(route.ID); err != nil {
+		return errors.Database("删除路由失败", err)
+	}
+	return nil
+}
+
+// SetRouteACL 设置路由访问控制，列表为空表示允许用户的所有设备访问
+func (s *Service) SetRouteACL(userID uint, routeID uint, req *RouteACLRequest) (*db.Route, error) {
+	route, err := s.GetRoute(userID, routeID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 检查对等设备是否属于当前用户
+	for _, peerID := range req.PeerDeviceIDs {
+		if peerID == route.DeviceID {
+			return nil, errors.InvalidParam("不能将通告设备自身加入访问控制")
+		}
+		if err := s.checkDevice(userID, peerID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.routes.SetACL(route.ID, req.PeerDeviceIDs); err != nil {
+		return nil, errors.Database("更新路由访问控制失败", err)
+	}
+
+	return s.GetRoute(userID, routeID)
+}
+
+// GetRoutesForDevice 获取设备可以访问的路由
+func (s *Service) GetRoutesForDevice(userID uint, deviceID uint) ([]PeerRoute, error) {
+	routes, err := s.routes.ListByUser(userID)
+	if err != nil {
+		return nil, errors.Database("查询路由失败", err)
+	}
+
+	// 查询通告设备的节点 ID
+	devices, err := s.devices.ListByUser(userID)
+	if err != nil {
+		return nil, errors.Database("查询设备失败", err)
+	}
+	nodeIDs := make(map[uint]string, len(devices))
+	for _, device := range devices {
+		nodeIDs[device.ID] = device.NodeID
+	}
+
+	// 过滤访问控制列表
+	allowed := make([]PeerRoute, 0, len(routes))
+	for _, route := range routes {
+		if !route.Enabled || route.DeviceID == deviceID {
+			continue
+		}
+		nodeID, ok := nodeIDs[route.DeviceID]
+		if !ok || !routeAllows(&route, deviceID) {
+			continue
+		}
+		allowed = append(allowed, PeerRoute{
+			RouteID: route.ID,
+			CIDR:    route.CIDR,
+			NodeID:  nodeID,
+		})
+	}
+
+	return allowed, nil
+}
+
+// GetRoutesForClient 获取对等节点可以经通告设备访问的网段，通告节点在转发流量前检查
+func (s *Service) GetRoutesForClient(advertiserDeviceID uint, clientNodeID string) ([]string, error) {
+	advertiser, err := s.devices.GetByID(advertiserDeviceID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("设备不存在")
+		}
+		return nil, errors.Database("查询设备失败", err)
+	}
+
+	client, err := s.devices.GetByNodeID(clientNodeID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("设备不存在")
+		}
+		return nil, errors.Database("查询设备失败", err)
+	}
+
+	// 只允许同一用户的设备经通告设备访问子网
+	if client.UserID != advertiser.UserID || client.ID == advertiser.ID {
+		return nil, errors.Forbidden("无权访问该设备通告的路由")
+	}
+
+	routes, err := s.routes.ListByDevice(advertiser.ID)
+	if err != nil {
+		return nil, errors.Database("查询路由失败", err)
+	}
+
+	cidrs := make([]string, 0, len(routes))
+	for _, route := range routes {
+		if route.Enabled && routeAllows(&route, client.ID) {
+			cidrs = append(cidrs, route.CIDR)
+		}
+	}
+	return cidrs, nil
+}
+
+// SyncDeviceRoutes 同步设备通告的路由，未在列表中的路由将被删除
+func (s *Service) SyncDeviceRoutes(userID uint, deviceID uint, cidrs []string) ([]db.Route, error) {
+	// 规范化并去重
+	wanted := make(map[string]bool, len(cidrs))
+	for _, c := range cidrs {
+		cidr, err := normalizeRouteCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		wanted[cidr] = true
+	}
+
+	existing, err := s.routes.ListByDevice(deviceID)
+	if err != nil {
+		return nil, errors.Database("查询路由失败", err)
+	}
+
+	// 删除不再通告的路由
+	for _, route := range existing {
+		if wanted[route.CIDR] {
+			delete(wanted, route.CIDR)
+			continue
+		}
+		if err := s.DeleteRoute(userID, route.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	// 创建新通告的路由
+	for cidr := range wanted {
+		if _, err := s.AdvertiseRoute(userID, &RouteRequest{
+			DeviceID: deviceID,
+			CIDR:     cidr,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	routes, err := s.routes.ListByDevice(deviceID)
+	if err != nil {
+		return nil, errors.Database("查询路由失败", err)
+	}
+	return routes, nil
+}
+
+// checkDevice 检查设备是否属于用户
+func (s *Service) checkDevice(userID uint, deviceID uint) error {
+	_, err := s.getDevice(userID, deviceID)
+	return err
+}
+
+// getDevice 获取属于用户的设备
+func (s *Service) getDevice(userID uint, deviceID uint) (*db.Device, error) {
+	device, err := s.devices.GetByID(deviceID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("设备不存在")
+		}
+		return nil, errors.Database("查询设备失败", err)
+	}
+	if device.UserID != userID {
+		return nil, errors.NotFound("设备不存在")
+	}
+	return device, nil
+}
+
+// checkConflict 检查网段是否与用户已启用的路由重叠
+func (s *Service) checkConflict(userID uint, excludeID uint, cidr string) error {
+	ipNet, err := ParseCIDR(cidr)
+	if err != nil {
+		return errors.InvalidParam(err.Error())
+	}
+
+	routes, err := s.routes.ListByUser(userID)
+	if err != nil {
+		return errors.Database("查询路由失败", err)
+	}
+
+	for _, route := range routes {
+		if !route.Enabled || route.ID == excludeID {
+			continue
+		}
+		other, err := ParseCIDR(route.CIDR)
+		if err != nil {
+			continue
+		}
+		if Overlaps(ipNet, other) {
+			return errors.Conflict(fmt.Sprintf("网段 %s 与设备 %d 已通告的网段 %s 冲突", cidr, route.DeviceID, route.CIDR))
+		}
+	}
+
+	return nil
+}
+
+// normalizeRouteCIDR 解析路由网段并返回规范形式
+func normalizeRouteCIDR(cidr string) (string, error) {
+	ipNet, err := ParseCIDR(cidr)
+	if err != nil {
+		return "", errors.InvalidParam(err.Error())
+	}
+	if isDefaultRoute(ipNet) {
+		return "", errors.InvalidParam("不允许通告默认路由，请使用出口节点")
+	}
+	return ipNet.String(), nil
+}
+
+// routeAllows 检查路由访问控制是否允许设备访问
+func routeAllows(route *db.Route, deviceID uint) bool {
+	if len(route.ACLs) == 0 {
+		return true
+	}
+	for _, acl := range route.ACLs {
+		if acl.PeerDeviceID == deviceID {
+			return true
+		}
+	}
+	return false
+}
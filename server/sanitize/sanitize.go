@@ -0,0 +1,204 @@
+// Package sanitize 校验和清理用户提交的字符串字段，例如设备名称、应用描述和邮箱。
+//
+// 请求结构体通过 binding 标签 safetext、safemultiline 在绑定时拒绝非法输入，
+// 绑定成功后调用 Struct 按 sanitize 标签清理字段（去除首尾空白，按 HTML 策略转义）再交给服务保存
+package sanitize
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// HTMLPolicy 字段中 HTML 特殊字符的处理策略
+type HTMLPolicy string
+
+const (
+	// HTMLReject 拒绝包含 < 或 > 的输入
+	HTMLReject HTMLPolicy = "reject"
+	// HTMLEscape 保存前转义 HTML 特殊字符
+	HTMLEscape HTMLPolicy = "escape"
+	// HTMLAllow 原样保存，由前端负责转义
+	HTMLAllow HTMLPolicy = "allow"
+)
+
+var (
+	// ErrInvalidUTF8 字符串不是有效的 UTF-8
+	ErrInvalidUTF8 = errors.New("包含无效的 UTF-8 字符")
+	// ErrControlChar 字符串包含控制字符
+	ErrControlChar = errors.New("包含控制字符")
+	// ErrHTML 字符串包含 HTML 标签
+	ErrHTML = errors.New("不能包含 < 或 >")
+)
+
+// policy 当前的 HTML 策略，服务启动时通过 SetHTMLPolicy 设置
+var policy = HTMLReject
+
+// ParseHTMLPolicy 解析 HTML 策略名称，为空时使用 HTMLReject
+func ParseHTMLPolicy(name string) (HTMLPolicy, error) {
+	switch p := HTMLPolicy(name); p {
+	case "":
+		return HTMLReject, nil
+	case HTMLReject, HTMLEscape, HTMLAllow:
+		return p, nil
+	default:
+		return "", fmt.Errorf("不支持的 HTML 策略: %s", name)
+	}
+}
+
+// SetHTMLPolicy 设置 HTML 策略，应在处理请求前调用
+func SetHTMLPolicy(p HTMLPolicy) {
+	policy = p
+}
+
+// FieldError 字段未通过校验
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+// Error 返回错误信息
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("字段 %s %v", e.Field, e.Err)
+}
+
+// Unwrap 解包错误
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// check 检查字符串是否为有效的 UTF-8、是否包含控制字符，以及是否符合 HTML 策略。
+// multiline 为 true 时允许换行和制表符
+func check(s string, multiline bool) error {
+	if !utf8.ValidString(s) {
+		return ErrInvalidUTF8
+	}
+	for _, r := range s {
+		if multiline && (r == '\n' || r == '\r' || r == '\t') {
+			continue
+		}
+		// 同时拒绝零宽字符和双向控制符等不可见的格式字符
+		if unicode.IsControl(r) || unicode.Is(unicode.Cf, r) {
+			return ErrControlChar
+		}
+	}
+	if policy == HTMLReject && strings.ContainsAny(s, "<>") {
+		return ErrHTML
+	}
+	return nil
+}
+
+// clean 校验并清理字符串
+func clean(s string, multiline bool) (string, error) {
+	s = strings.TrimSpace(s)
+	if err := check(s, multiline); err != nil {
+		return "", err
+	}
+	if multiline {
+		s = strings.ReplaceAll(s, "\r\n", "\n")
+	}
+	if policy == HTMLEscape {
+		s = html.EscapeString(s)
+	}
+	return s, nil
+}
+
+// Text 校验并清理单行文本，例如名称
+func Text(s string) (string, error) {
+	return clean(s, false)
+}
+
+// Multiline 校验并清理多行文本，例如描述
+func Multiline(s string) (string, error) {
+	return clean(s, true)
+}
+
+// Struct 按 sanitize 标签清理结构体中的字符串字段，v 必须为结构体指针。
+// 标签值为 text 或 multiline，嵌套的结构体和结构体切片会递归处理，
+// 错误中的字段名取自 json 标签
+func Struct(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("sanitize: 需要结构体指针，实际为 %T", v)
+	}
+	return walk(rv.Elem(), "")
+}
+
+// walk 递归清理结构体字段
+func walk(rv reflect.Value, prefix string) error {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := prefix + jsonName(field)
+		value := rv.Field(i)
+		if value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				continue
+			}
+			value = value.Elem()
+		}
+
+		switch value.Kind() {
+		case reflect.String:
+			kind := field.Tag.Get("sanitize")
+			if kind == "" {
+				continue
+			}
+			cleaned, err := clean(value.String(), kind == "multiline")
+			if err != nil {
+				return &FieldError{Field: name, Err: err}
+			}
+			value.SetString(cleaned)
+		case reflect.Struct:
+			if err := walk(value, name+"."); err != nil {
+				return err
+			}
+		case reflect.Slice:
+			for j := 0; j < value.Len(); j++ {
+				elem := value.Index(j)
+				if elem.Kind() == reflect.Ptr {
+					elem = elem.Elem()
+				}
+				if elem.Kind() != reflect.Struct {
+					break
+				}
+				if err := walk(elem, fmt.Sprintf("%s[%d].", name, j)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonName 获取字段的 JSON 名称
+func jsonName(field reflect.StructField) string {
+	if tag := field.Tag.Get("json"); tag != "" {
+		if name := strings.Split(tag, ",")[0]; name != "" && name != "-" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// RegisterValidators 注册 safetext 和 safemultiline 校验标签
+func RegisterValidators(v *validator.Validate) error {
+	if err := v.RegisterValidation("safetext", func(fl validator.FieldLevel) bool {
+		return check(strings.TrimSpace(fl.Field().String()), false) == nil
+	}); err != nil {
+		return err
+	}
+	return v.RegisterValidation("safemultiline", func(fl validator.FieldLevel) bool {
+		return check(strings.TrimSpace(fl.Field().String()), true) == nil
+	})
+}
@@ -0,0 +1,89 @@
+package sanitize
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	defer SetHTMLPolicy(HTMLReject)
+
+	tests := []struct {
+		name    string
+		policy  HTMLPolicy
+		input   string
+		want    string
+		wantErr error
+	}{
+		{name: "去除首尾空白", policy: HTMLReject, input: "  office-nas ", want: "office-nas"},
+		{name: "中文", policy: HTMLReject, input: "办公室 NAS", want: "办公室 NAS"},
+		{name: "控制字符", policy: HTMLReject, input: "nas\x00", wantErr: ErrControlChar},
+		{name: "换行", policy: HTMLReject, input: "nas\nbox", wantErr: ErrControlChar},
+		{name: "零宽字符", policy: HTMLReject, input: "na\u200bs", wantErr: ErrControlChar},
+		{name: "无效 UTF-8", policy: HTMLReject, input: "nas\xff", wantErr: ErrInvalidUTF8},
+		{name: "拒绝 HTML", policy: HTMLReject, input: "<script>alert(1)</script>", wantErr: ErrHTML},
+		{name: "转义 HTML", policy: HTMLEscape, input: `<img src="x">`, want: "&lt;img src=&#34;x&#34;&gt;"},
+		{name: "允许 HTML", policy: HTMLAllow, input: "<b>nas</b>", want: "<b>nas</b>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetHTMLPolicy(tt.policy)
+			got, err := Text(tt.input)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("期望错误 %v，实际 %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("期望 %q，实际 %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMultiline(t *testing.T) {
+	got, err := Multiline("第一行\r\n\t第二行\n")
+	if err != nil {
+		t.Fatalf("清理多行文本失败: %v", err)
+	}
+	if got != "第一行\n\t第二行" {
+		t.Errorf("清理结果错误: %q", got)
+	}
+
+	if _, err := Multiline("描述\x1b[31m"); !errors.Is(err, ErrControlChar) {
+		t.Errorf("期望控制字符错误，实际 %v", err)
+	}
+}
+
+func TestStruct(t *testing.T) {
+	type item struct {
+		Detail string `json:"detail" sanitize:"multiline"`
+	}
+	type request struct {
+		Name        string  `json:"name" sanitize:"text"`
+		Description *string `json:"description" sanitize:"multiline"`
+		Token       string  `json:"token"`
+		Items       []item  `json:"items"`
+	}
+
+	desc := "  说明  "
+	req := &request{Name: " nas ", Description: &desc, Token: " raw ", Items: []item{{Detail: "ok"}}}
+	if err := Struct(req); err != nil {
+		t.Fatalf("清理结构体失败: %v", err)
+	}
+	if req.Name != "nas" || *req.Description != "说明" {
+		t.Errorf("字段未清理: %+v", req)
+	}
+	if req.Token != " raw " {
+		t.Error("没有 sanitize 标签的字段不应修改")
+	}
+
+	req.Items = append(req.Items, item{Detail: "<svg onload=alert(1)>"})
+	var fieldErr *FieldError
+	if err := Struct(req); !errors.As(err, &fieldErr) || fieldErr.Field != "items[1].detail" {
+		t.Fatalf("期望 items[1].detail 字段错误，实际 %v", err)
+	}
+
+	if err := Struct(*req); err == nil {
+		t.Error("非指针参数应该返回错误")
+	}
+}
@@ -0,0 +1,194 @@
+package shaping
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// reserveScript 在 Redis 中原子地补充并扣除令牌，使用 Redis 服务器时间，避免各中继实例的时钟偏差。
+// 返回透支部分需要等待的毫秒数
+const reserveScript = `
+local n = tonumber(ARGV[1])
+local rate = tonumber(ARGV[2])
+local burst = tonumber(ARGV[3])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+if now > ts then
+  tokens = math.min(burst, tokens + (now - ts) * rate / 1000)
+  ts = now
+end
+tokens = tokens - n
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', ts)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 60000)
+if tokens >= 0 then
+  return 0
+end
+return math.ceil(-tokens * 1000 / rate)
+`
+
+// RedisStore 基于 Redis 的令牌桶存储，多个中继实例共享同一用户的带宽额度
+type RedisStore struct {
+	addr     string
+	password string
+	db       int
+	timeout  time.Duration
+	conn     net.Conn
+	reader   *bufio.Reader
+	mu       sync.Mutex
+}
+
+// NewRedisStore 创建 Redis 令牌桶存储，连接在首次使用时建立
+func NewRedisStore(host string, port int, password string, db int) *RedisStore {
+	return &RedisStore{
+		addr:     net.JoinHostPort(host, strconv.Itoa(port)),
+		password: password,
+		db:       db,
+		timeout:  2 * time.Second,
+	}
+}
+
+// Reserve 从桶中扣除 n 个令牌
+func (s *RedisStore) Reserve(key string, n, rate, burst int64) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reply, err := s.do("EVAL", reserveScript, "1", key,
+		strconv.FormatInt(n, 10), strconv.FormatInt(rate, 10), strconv.FormatInt(burst, 10))
+	if err != nil {
+		return 0, err
+	}
+
+	ms, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("Redis 返回了意外的结果: %v", reply)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// Close 关闭 Redis 连接
+func (s *RedisStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// do 发送命令并读取响应，连接出错时关闭连接，下次调用时重新建立。调用方需持有锁
+func (s *RedisStore) do(args ...string) (interface{}, error) {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := s.roundTrip(args...)
+	if err != nil {
+		var redisErr redisError
+		if !errors.As(err, &redisErr) {
+			s.conn.Close()
+			s.conn = nil
+		}
+		return nil, err
+	}
+	return reply, nil
+}
+
+// connect 建立连接并完成认证和选库
+func (s *RedisStore) connect() error {
+	conn, err := net.DialTimeout("tcp", s.addr, s.timeout)
+	if err != nil {
+		return fmt.Errorf("连接 Redis 失败: %w", err)
+	}
+	s.conn = conn
+	s.reader = bufio.NewReader(conn)
+
+	if s.password != "" {
+		if _, err := s.roundTrip("AUTH", s.password); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("Redis 认证失败: %w", err)
+		}
+	}
+	if s.db != 0 {
+		if _, err := s.roundTrip("SELECT", strconv.Itoa(s.db)); err != nil {
+			s.conn.Close()
+			s.conn = nil
+			return fmt.Errorf("选择 Redis 数据库失败: %w", err)
+		}
+	}
+	return nil
+}
+
+// roundTrip 按 RESP 协议发送命令并读取一个响应
+func (s *RedisStore) roundTrip(args ...string) (interface{}, error) {
+	s.conn.SetDeadline(time.Now().Add(s.timeout))
+
+	var cmd strings.Builder
+	fmt.Fprintf(&cmd, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&cmd, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := s.conn.Write([]byte(cmd.String())); err != nil {
+		return nil, fmt.Errorf("发送 Redis 命令失败: %w", err)
+	}
+
+	return readReply(s.reader)
+}
+
+// redisError Redis 返回的错误响应，连接本身仍可继续使用
+type redisError string
+
+func (e redisError) Error() string {
+	return "Redis 错误: " + string(e)
+}
+
+// readReply 读取一个 RESP 响应，只支持令牌桶需要的简单字符串、错误、整数和批量字符串
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, fmt.Errorf("读取 Redis 响应失败: %w", err)
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("无效的 Redis 响应")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("无效的 Redis 响应: %s", line)
+		}
+		if size < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, fmt.Errorf("读取 Redis 响应失败: %w", err)
+		}
+		return string(buf[:size]), nil
+	default:
+		return nil, fmt.Errorf("不支持的 Redis 响应: %s", line)
+	}
+}
@@ -0,0 +1,162 @@
+package shaping
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+)
+
+// Direction 流量方向
+type Direction string
+
+const (
+	// Upload 用户设备发往目标节点的流量
+	Upload Direction = "upload"
+	// Download 目标节点发回用户设备的流量
+	Download Direction = "download"
+)
+
+// reserveChunk 每次向存储预留的令牌数，减少跨实例协调的请求次数
+const reserveChunk = 64 * 1024
+
+// Store 令牌桶存储，Reserve 从桶中扣除 n 个令牌（允许透支），返回透支部分需要等待的时间
+type Store interface {
+	Reserve(key string, n, rate, burst int64) (time.Duration, error)
+}
+
+// Limits 单个用户的带宽限制，单位：字节/秒，0 表示不限制
+type Limits struct {
+	Upload   int64
+	Download int64
+	Burst    int64 // 突发流量，0 表示按一秒的速率计算
+}
+
+// Limiter 按用户汇总所有中继会话的上下行流量，超出限制时返回需要等待的时间
+type Limiter struct {
+	store    Store
+	fallback *LocalStore
+	limits   Limits
+	credits  map[string]int64
+	mu       sync.Mutex
+}
+
+// NewLimiter 创建限速器，store 为空时使用本地令牌桶
+func NewLimiter(store Store, limits Limits) *Limiter {
+	fallback := NewLocalStore()
+	if store == nil {
+		store = fallback
+	}
+	return &Limiter{
+		store:    store,
+		fallback: fallback,
+		limits:   limits,
+		credits:  make(map[string]int64),
+	}
+}
+
+// Enabled 检查是否配置了任意方向的限制
+func (l *Limiter) Enabled() bool {
+	return l != nil && (l.limits.Upload > 0 || l.limits.Download > 0)
+}
+
+// Rate 获取指定方向的限制速率
+func (l *Limiter) Rate(dir Direction) int64 {
+	if l == nil {
+		return 0
+	}
+	if dir == Upload {
+		return l.limits.Upload
+	}
+	return l.limits.Download
+}
+
+// Reserve 为用户指定方向的 n 字节流量预留令牌，返回发送前需要等待的时间
+func (l *Limiter) Reserve(userID uint, dir Direction, n int) time.Duration {
+	rate := l.Rate(dir)
+	if rate <= 0 || n <= 0 {
+		return 0
+	}
+
+	burst := l.limits.Burst
+	if burst <= 0 {
+		burst = rate
+	}
+
+	key := fmt.Sprintf("p3:relay:bw:%d:%s", userID, dir)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// 先使用上次预留的余量
+	if credit := l.credits[key]; credit >= int64(n) {
+		l.credits[key] = credit - int64(n)
+		return 0
+	}
+
+	chunk := int64(reserveChunk)
+	if chunk > burst {
+		chunk = burst
+	}
+	if chunk < int64(n) {
+		chunk = int64(n)
+	}
+
+	wait, err := l.store.Reserve(key, chunk, rate, burst)
+	if err != nil {
+		// 协调存储不可用时退化为单实例限速，避免中继流量失去控制
+		logger.Warn("带宽令牌桶存储不可用，使用本地令牌桶: %v", err)
+		wait, _ = l.fallback.Reserve(key, chunk, rate, burst)
+	}
+
+	l.credits[key] += chunk - int64(n)
+	return wait
+}
+
+// LocalStore 进程内令牌桶存储，只在单个中继实例内生效
+type LocalStore struct {
+	buckets map[string]*bucket
+	mu      sync.Mutex
+}
+
+// bucket 令牌桶
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// NewLocalStore 创建进程内令牌桶存储
+func NewLocalStore() *LocalStore {
+	return &LocalStore{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Reserve 从桶中扣除 n 个令牌
+func (s *LocalStore) Reserve(key string, n, rate, burst int64) (time.Duration, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), updatedAt: now}
+		s.buckets[key] = b
+	}
+
+	// 按经过的时间补充令牌，不超过突发上限
+	if elapsed := now.Sub(b.updatedAt).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * float64(rate)
+		if b.tokens > float64(burst) {
+			b.tokens = float64(burst)
+		}
+		b.updatedAt = now
+	}
+
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0, nil
+	}
+	return time.Duration(-b.tokens / float64(rate) * float64(time.Second)), nil
+}
@@ -0,0 +1,77 @@
+package shaping
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalStoreReserve(t *testing.T) {
+	store := NewLocalStore()
+
+	// 突发额度内不需要等待
+	wait, err := store.Reserve("user", 1000, 1000, 1000)
+	if err != nil || wait != 0 {
+		t.Fatalf("突发额度内应立即放行，wait=%v err=%v", wait, err)
+	}
+
+	// 透支 500 个令牌，按 1000/秒 需要等待约 500ms
+	wait, _ = store.Reserve("user", 500, 1000, 1000)
+	if wait < 450*time.Millisecond || wait > 500*time.Millisecond {
+		t.Fatalf("透支后等待时间不正确: %v", wait)
+	}
+
+	// 不同用户互不影响
+	if wait, _ := store.Reserve("other", 1000, 1000, 1000); wait != 0 {
+		t.Fatalf("其他用户不应受影响: %v", wait)
+	}
+}
+
+func TestLimiterReserve(t *testing.T) {
+	limiter := NewLimiter(nil, Limits{Upload: 100 * 1024})
+	if !limiter.Enabled() {
+		t.Fatal("配置了上行限制时应启用")
+	}
+
+	// 下行未限制
+	if wait := limiter.Reserve(1, Download, 1<<20); wait != 0 {
+		t.Fatalf("未限制的方向不应等待: %v", wait)
+	}
+
+	// 上行突发额度为一秒的流量，用完后需要等待
+	var total time.Duration
+	for i := 0; i < 50; i++ {
+		total += limiter.Reserve(1, Upload, 4096)
+	}
+	if total == 0 {
+		t.Fatal("超出突发额度后应等待")
+	}
+
+	// 用户之间互不影响
+	if wait := limiter.Reserve(2, Upload, 4096); wait != 0 {
+		t.Fatalf("其他用户不应受影响: %v", wait)
+	}
+
+	var disabled *Limiter
+	if disabled.Enabled() || disabled.Reserve(1, Upload, 4096) != 0 {
+		t.Fatal("空限速器不应限制")
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader(":250\r\n+OK\r\n$5\r\nhello\r\n-ERR wrong\r\n"))
+
+	if reply, err := readReply(r); err != nil || reply != int64(250) {
+		t.Fatalf("整数响应解析错误: %v %v", reply, err)
+	}
+	if reply, err := readReply(r); err != nil || reply != "OK" {
+		t.Fatalf("简单字符串响应解析错误: %v %v", reply, err)
+	}
+	if reply, err := readReply(r); err != nil || reply != "hello" {
+		t.Fatalf("批量字符串响应解析错误: %v %v", reply, err)
+	}
+	if _, err := readReply(r); err == nil {
+		t.Fatal("错误响应应返回错误")
+	}
+}
@@ -0,0 +1,120 @@
+package speedtest
+
+import (
+	"time"
+
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/db"
+)
+
+// 测速角色
+const (
+	RoleClient = "client" // 发起测速并上报结果
+	RoleServer = "server" // 接收测速流量
+)
+
+// Task 下发给设备的测速任务
+type Task struct {
+	ScheduleID uint   `json:"scheduleId"`
+	Role       string `json:"role"`
+	PeerNodeID string `json:"peerNodeId"`
+	Duration   int    `json:"duration"`
+}
+
+// DispatchFunc 向节点下发测速任务
+type DispatchFunc func(nodeID string, task *Task) error
+
+// Scheduler 测速调度器
+type Scheduler struct {
+	service  *Service
+	dispatch DispatchFunc
+	interval time.Duration
+	stopCh   chan struct{}
+}
+
+// NewScheduler 创建测速调度器
+func NewScheduler(service *Service, dispatch DispatchFunc) *Scheduler {
+	return &Scheduler{
+		service:  service,
+		dispatch: dispatch,
+		interval: time.Minute,
+		stopCh:   make(chan struct{}),
+	}
+}
+
+// Start 启动测速调度器
+func (s *Scheduler) Start() {
+	go s.loop()
+	logger.Info("测速调度器已启动")
+}
+
+// Stop 停止测速调度器
+func (s *Scheduler) Stop() {
+	close(s.stopCh)
+	logger.Info("测速调度器已停止")
+}
+
+// loop 调度循环
+func (s *Scheduler) loop() {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stopCh:
+			return
+		case now := <-ticker.C:
+			s.runDue(now)
+		}
+	}
+}
+
+// runDue 执行到期的测速计划
+func (s *Scheduler) runDue(now time.Time) {
+	schedules, err := s.service.DueSchedules(now)
+	if err != nil {
+		logger.Error("查询到期测速计划失败: %v", err)
+		return
+	}
+
+	for i := range schedules {
+		schedule := &schedules[i]
+		if err := s.run(schedule); err != nil {
+			logger.Warn("执行测速计划 %d 失败: %v", schedule.ID, err)
+		}
+
+		// 无论成功与否都推迟到下一个周期，避免离线设备被反复调度
+		if err := s.service.MarkRun(schedule, now); err != nil {
+			logger.Error("更新测速计划 %d 失败: %v", schedule.ID, err)
+		}
+	}
+}
+
+// run 向源设备和目标设备下发测速任务
+func (s *Scheduler) run(schedule *db.SpeedTestSchedule) error {
+	source, err := s.service.devices.GetByID(schedule.SourceDeviceID)
+	if err != nil {
+		return err
+	}
+	target, err := s.service.devices.GetByID(schedule.TargetDeviceID)
+	if err != nil {
+		return err
+	}
+
+	// 先通知目标设备准备接收测速流量
+	if err := s.dispatch(target.NodeID, &Task{
+		ScheduleID: schedule.ID,
+		Role:       RoleServer,
+		PeerNodeID: source.NodeID,
+		Duration:   schedule.Duration,
+	}); err != nil {
+		return err
+	}
+
+	return s.dispatch(source.NodeID, &Task{
+		ScheduleID: schedule.ID,
+		Role:       RoleClient,
+		PeerNodeID: target.NodeID,
+		Duration:   schedule.Duration,
+	})
+}
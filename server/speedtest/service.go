@@ -0,0 +1,313 @@
+package speedtest
+
+import (
+	"context"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+	"github.com/senma231/p3/common/logger"
+	"github.com/senma231/p3/server/db"
+	"github.com/senma231/p3/server/store"
+)
+
+// 测速结果状态
+const (
+	StatusOK     = "ok"
+	StatusAlert  = "alert"
+	StatusFailed = "failed"
+)
+
+// 测速计划限制
+const (
+	MinInterval     = 5    // 最小间隔，单位：分钟
+	MaxDuration     = 60   // 最大测速时长，单位：秒
+	DefaultDuration = 10   // 默认测速时长，单位：秒
+	MaxResults      = 1000 // 单次查询的最大结果数
+)
+
+// Service 测速服务
+type Service struct {
+	st         *store.Store
+	speedTests store.SpeedTestRepo
+	devices    store.DeviceRepo
+}
+
+// NewService 创建测速服务
+func NewService(st *store.Store) *Service {
+	return &Service{
+		st:         st,
+		speedTests: st.SpeedTests,
+		devices:    st.Devices,
+	}
+}
+
+// ForTenant 返回只能访问 ctx 中租户数据的测速服务，其他租户的测速计划和设备视为不存在
+func (s *Service) ForTenant(ctx context.Context) (*Service, error) {
+	scoped, err := s.st.ForContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	svc := *s
+	svc.speedTests = scoped.SpeedTests
+	svc.devices = scoped.Devices
+	return &svc, nil
+}
+
+// ScheduleRequest 测速计划请求
+type ScheduleRequest struct {
+	SourceDeviceID uint    `json:"sourceDeviceId" binding:"required"`
+	TargetDeviceID uint    `json:"targetDeviceId" binding:"required"`
+	Interval       int     `json:"interval" binding:"required"`
+	Duration       int     `json:"duration"`
+	MinThroughput  float64 `json:"minThroughput"`
+	MaxLatency     float64 `json:"maxLatency"`
+}
+
+// ScheduleUpdateRequest 测速计划更新请求
+type ScheduleUpdateRequest struct {
+	Interval      int      `json:"interval"`
+	Duration      int      `json:"duration"`
+	Enabled       *bool    `json:"enabled"`
+	MinThroughput *float64 `json:"minThroughput"`
+	MaxLatency    *float64 `json:"maxLatency"`
+}
+
+// ResultRequest 测速结果上报请求
+type ResultRequest struct {
+	ScheduleID     uint    `json:"scheduleId" binding:"required"`
+	ConnectionType string  `json:"connectionType"`
+	Throughput     float64 `json:"throughput"`
+	Latency        float64 `json:"latency"`
+	Jitter         float64 `json:"jitter"`
+	Error          string  `json:"error"`
+}
+
+// GetSchedules 获取用户的所有测速计划
+func (s *Service) GetSchedules(userID uint) ([]db.SpeedTestSchedule, error) {
+	schedules, err := s.speedTests.ListSchedules(userID)
+	if err != nil {
+		return nil, errors.Database("查询测速计划失败", err)
+	}
+	return schedules, nil
+}
+
+// GetSchedule 获取测速计划详情
+func (s *Service) GetSchedule(userID uint, scheduleID uint) (*db.SpeedTestSchedule, error) {
+	schedule, err := s.speedTests.GetSchedule(scheduleID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("测速计划不存在")
+		}
+		return nil, errors.Database("查询测速计划失败", err)
+	}
+	if schedule.UserID != userID {
+		return nil, errors.NotFound("测速计划不存在")
+	}
+	return schedule, nil
+}
+
+// CreateSchedule 创建测速计划
+func (s *Service) CreateSchedule(userID uint, req *ScheduleRequest) (*db.SpeedTestSchedule, error) {
+	if req.SourceDeviceID == req.TargetDeviceID {
+		return nil, errors.InvalidParam("源设备和目标设备不能相同")
+	}
+
+	// 检查设备是否属于当前用户
+	for _, deviceID := range []uint{req.SourceDeviceID, req.TargetDeviceID} {
+		if err := s.checkDevice(userID, deviceID); err != nil {
+			return nil, err
+		}
+	}
+
+	duration := req.Duration
+	if duration == 0 {
+		duration = DefaultDuration
+	}
+	if err := validateSchedule(req.Interval, duration); err != nil {
+		return nil, err
+	}
+
+	schedule := &db.SpeedTestSchedule{
+		UserID:         userID,
+		SourceDeviceID: req.SourceDeviceID,
+		TargetDeviceID: req.TargetDeviceID,
+		Interval:       req.Interval,
+		Duration:       duration,
+		Enabled:        true,
+		MinThroughput:  req.MinThroughput,
+		MaxLatency:     req.MaxLatency,
+		NextRunAt:      time.Now(),
+	}
+
+	if err := s.speedTests.CreateSchedule(schedule); err != nil {
+		return nil, errors.Database("创建测速计划失败", err)
+	}
+
+	return schedule, nil
+}
+
+// UpdateSchedule 更新测速计划
+func (s *Service) UpdateSchedule(userID uint, scheduleID uint, req *ScheduleUpdateRequest) (*db.SpeedTestSchedule, error) {
+	schedule, err := s.GetSchedule(userID, scheduleID)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.Interval != 0 {
+		schedule.Interval = req.Interval
+	}
+	if req.Duration != 0 {
+		schedule.Duration = req.Duration
+	}
+	if req.Enabled != nil {
+		schedule.Enabled = *req.Enabled
+	}
+	if req.MinThroughput != nil {
+		schedule.MinThroughput = *req.MinThroughput
+	}
+	if req.MaxLatency != nil {
+		schedule.MaxLatency = *req.MaxLatency
+	}
+
+	if err := validateSchedule(schedule.Interval, schedule.Duration); err != nil {
+		return nil, err
+	}
+
+	if err := s.speedTests.SaveSchedule(schedule); err != nil {
+		return nil, errors.Database("更新测速计划失败", err)
+	}
+
+	return schedule, nil
+}
+
+// DeleteSchedule 删除测速计划
+func (s *Service) DeleteSchedule(userID uint, scheduleID uint) error {
+	schedule, err := s.GetSchedule(userID, scheduleID)
+	if err != nil {
+		return err
+	}
+
+	if err := s.speedTests.DeleteSchedule(schedule.ID); err != nil {
+		return errors.Database("删除测速计划失败", err)
+	}
+
+	return nil
+}
+
+// GetResults 获取测速计划的结果，按时间升序返回
+func (s *Service) GetResults(userID uint, scheduleID uint, since time.Time) ([]db.SpeedTestResult, error) {
+	if _, err := s.GetSchedule(userID, scheduleID); err != nil {
+		return nil, err
+	}
+
+	results, err := s.speedTests.ListResults(scheduleID, since, MaxResults)
+	if err != nil {
+		return nil, errors.Database("查询测速结果失败", err)
+	}
+
+	// 反转为升序，便于绘制图表
+	for i, j := 0, len(results)-1; i < j; i, j = i+1, j-1 {
+		results[i], results[j] = results[j], results[i]
+	}
+
+	return results, nil
+}
+
+// RecordResult 记录设备上报的测速结果
+func (s *Service) RecordResult(deviceID uint, req *ResultRequest) (*db.SpeedTestResult, error) {
+	schedule, err := s.speedTests.GetSchedule(req.ScheduleID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return nil, errors.NotFound("测速计划不存在")
+		}
+		return nil, errors.Database("查询测速计划失败", err)
+	}
+
+	// 只有源设备可以上报结果
+	if schedule.SourceDeviceID != deviceID {
+		return nil, errors.Forbidden("无权上报该测速计划的结果")
+	}
+
+	result := &db.SpeedTestResult{
+		ScheduleID:     schedule.ID,
+		UserID:         schedule.UserID,
+		SourceDeviceID: schedule.SourceDeviceID,
+		TargetDeviceID: schedule.TargetDeviceID,
+		ConnectionType: req.ConnectionType,
+		Throughput:     req.Throughput,
+		Latency:        req.Latency,
+		Jitter:         req.Jitter,
+		Error:          req.Error,
+	}
+	result.Status = evaluate(schedule, result)
+
+	if err := s.speedTests.CreateResult(result); err != nil {
+		return nil, errors.Database("保存测速结果失败", err)
+	}
+
+	if result.Status == StatusAlert {
+		logger.Warn("测速计划 %d 超出告警阈值: 吞吐量 %.2f Mbps, 延迟 %.2f ms", schedule.ID, result.Throughput, result.Latency)
+	}
+
+	return result, nil
+}
+
+// DueSchedules 获取到期的测速计划
+func (s *Service) DueSchedules(now time.Time) ([]db.SpeedTestSchedule, error) {
+	schedules, err := s.speedTests.ListDue(now)
+	if err != nil {
+		return nil, errors.Database("查询测速计划失败", err)
+	}
+	return schedules, nil
+}
+
+// MarkRun 记录测速计划的执行时间
+func (s *Service) MarkRun(schedule *db.SpeedTestSchedule, now time.Time) error {
+	if err := s.speedTests.UpdateScheduleFields(schedule, map[string]interface{}{
+		"last_run_at": now,
+		"next_run_at": now.Add(time.Duration(schedule.Interval) * time.Minute),
+	}); err != nil {
+		return errors.Database("更新测速计划失败", err)
+	}
+	return nil
+}
+
+// checkDevice 检查设备是否属于用户
+func (s *Service) checkDevice(userID uint, deviceID uint) error {
+	device, err := s.devices.GetByID(deviceID)
+	if err != nil {
+		if store.IsNotFound(err) {
+			return errors.NotFound("设备不存在")
+		}
+		return errors.Database("查询设备失败", err)
+	}
+	if device.UserID != userID {
+		return errors.NotFound("设备不存在")
+	}
+	return nil
+}
+
+// validateSchedule 验证测速计划参数
+func validateSchedule(interval, duration int) error {
+	if interval < MinInterval {
+		return errors.InvalidParam("测速间隔不能小于 5 分钟")
+	}
+	if duration <= 0 || duration > MaxDuration {
+		return errors.InvalidParam("测速时长必须在 1 到 60 秒之间")
+	}
+	return nil
+}
+
+// evaluate 根据告警阈值评估测速结果
+func evaluate(schedule *db.SpeedTestSchedule, result *db.SpeedTestResult) string {
+	if result.Error != "" {
+		return StatusFailed
+	}
+	if schedule.MinThroughput > 0 && result.Throughput < schedule.MinThroughput {
+		return StatusAlert
+	}
+	if schedule.MaxLatency > 0 && result.Latency > schedule.MaxLatency {
+		return StatusAlert
+	}
+	return StatusOK
+}
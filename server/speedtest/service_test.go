@@ -0,0 +1,42 @@
+package speedtest
+
+import (
+	"testing"
+
+	"github.com/senma231/p3/server/db"
+)
+
+func TestValidateSchedule(t *testing.T) {
+	if err := validateSchedule(MinInterval, DefaultDuration); err != nil {
+		t.Errorf("有效的测速计划验证失败: %v", err)
+	}
+	if err := validateSchedule(MinInterval-1, DefaultDuration); err == nil {
+		t.Error("测速间隔过小应该验证失败")
+	}
+	if err := validateSchedule(MinInterval, MaxDuration+1); err == nil {
+		t.Error("测速时长过长应该验证失败")
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	schedule := &db.SpeedTestSchedule{
+		MinThroughput: 10,
+		MaxLatency:    100,
+	}
+
+	tests := []struct {
+		result *db.SpeedTestResult
+		want   string
+	}{
+		{&db.SpeedTestResult{Throughput: 50, Latency: 20}, StatusOK},
+		{&db.SpeedTestResult{Throughput: 5, Latency: 20}, StatusAlert},
+		{&db.SpeedTestResult{Throughput: 50, Latency: 200}, StatusAlert},
+		{&db.SpeedTestResult{Error: "连接失败"}, StatusFailed},
+	}
+
+	for i, tt := range tests {
+		if got := evaluate(schedule, tt.result); got != tt.want {
+			t.Errorf("用例 %d: evaluate() = %s, 期望 %s", i, got, tt.want)
+		}
+	}
+}
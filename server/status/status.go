@@ -0,0 +1,150 @@
+// Package status 汇总服务端各组件的健康状态，并管理计划维护窗口。
+// 维护窗口保存在内存中，服务重启后需要重新设置
+package status
+
+import (
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/common/errors"
+)
+
+// 组件和服务整体状态
+const (
+	StateOperational = "operational" // 正常
+	StateDegraded    = "degraded"    // 部分组件异常
+	StateDown        = "down"        // 组件异常
+	StateMaintenance = "maintenance" // 维护中
+)
+
+// Window 计划维护窗口
+type Window struct {
+	Start   time.Time `json:"start" binding:"required"`
+	End     time.Time `json:"end" binding:"required"`
+	Message string    `json:"message" binding:"max=500,safetext" sanitize:"text"`
+}
+
+// Active 维护窗口在指定时间是否生效
+func (w *Window) Active(now time.Time) bool {
+	return !now.Before(w.Start) && now.Before(w.End)
+}
+
+// Component 组件状态
+type Component struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+}
+
+// Summary 服务状态汇总
+type Summary struct {
+	Status      string      `json:"status"`
+	Components  []Component `json:"components"`
+	Maintenance *Window     `json:"maintenance,omitempty"` // 进行中或计划中的维护
+	UpdatedAt   time.Time   `json:"updatedAt"`
+}
+
+// check 组件健康检查
+type check struct {
+	name  string
+	check func() error
+}
+
+// Service 状态服务
+type Service struct {
+	checks []check
+	window *Window
+	now    func() time.Time
+	mu     sync.RWMutex
+}
+
+// NewService 创建状态服务
+func NewService() *Service {
+	return &Service{now: time.Now}
+}
+
+// AddComponent 添加组件，check 返回错误时组件视为异常
+func (s *Service) AddComponent(name string, checkFunc func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checks = append(s.checks, check{name: name, check: checkFunc})
+}
+
+// ScheduleMaintenance 设置维护窗口，替换已有的窗口
+func (s *Service) ScheduleMaintenance(window Window) error {
+	if !window.End.After(window.Start) {
+		return errors.InvalidParam("维护结束时间必须晚于开始时间")
+	}
+	if !window.End.After(s.now()) {
+		return errors.InvalidParam("维护结束时间必须晚于当前时间")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window = &window
+	return nil
+}
+
+// CancelMaintenance 取消维护窗口
+func (s *Service) CancelMaintenance() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.window = nil
+}
+
+// Maintenance 获取进行中或计划中的维护窗口，没有时返回 nil
+func (s *Service) Maintenance() *Window {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.window != nil && !s.now().Before(s.window.End) {
+		s.window = nil
+	}
+	if s.window == nil {
+		return nil
+	}
+	window := *s.window
+	return &window
+}
+
+// InMaintenance 获取正在生效的维护窗口
+func (s *Service) InMaintenance() (*Window, bool) {
+	window := s.Maintenance()
+	if window == nil || !window.Active(s.now()) {
+		return nil, false
+	}
+	return window, true
+}
+
+// Summary 检查各组件并汇总服务状态
+func (s *Service) Summary() *Summary {
+	s.mu.RLock()
+	checks := append([]check(nil), s.checks...)
+	s.mu.RUnlock()
+
+	summary := &Summary{
+		Status:      StateOperational,
+		Components:  make([]Component, 0, len(checks)),
+		Maintenance: s.Maintenance(),
+		UpdatedAt:   s.now(),
+	}
+
+	down := 0
+	for _, c := range checks {
+		component := Component{Name: c.name, Status: StateOperational}
+		if err := c.check(); err != nil {
+			component.Status = StateDown
+			down++
+		}
+		summary.Components = append(summary.Components, component)
+	}
+
+	switch {
+	case summary.Maintenance != nil && summary.Maintenance.Active(summary.UpdatedAt):
+		summary.Status = StateMaintenance
+	case down > 0 && down == len(checks):
+		summary.Status = StateDown
+	case down > 0:
+		summary.Status = StateDegraded
+	}
+	return summary
+}
@@ -0,0 +1,60 @@
+package status
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMaintenanceWindow(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewService()
+	s.now = func() time.Time { return now }
+
+	if err := s.ScheduleMaintenance(Window{Start: now, End: now.Add(-time.Minute)}); err == nil {
+		t.Fatal("结束时间早于开始时间应该返回错误")
+	}
+	if err := s.ScheduleMaintenance(Window{Start: now.Add(-2 * time.Hour), End: now.Add(-time.Hour)}); err == nil {
+		t.Fatal("已结束的维护窗口应该返回错误")
+	}
+
+	// 计划中的维护不影响请求
+	window := Window{Start: now.Add(time.Hour), End: now.Add(2 * time.Hour), Message: "升级数据库"}
+	if err := s.ScheduleMaintenance(window); err != nil {
+		t.Fatalf("设置维护窗口失败: %v", err)
+	}
+	if _, active := s.InMaintenance(); active {
+		t.Fatal("维护开始前不应处于维护状态")
+	}
+	if w := s.Maintenance(); w == nil || w.Message != "升级数据库" {
+		t.Fatalf("应返回计划中的维护窗口: %v", w)
+	}
+
+	now = now.Add(90 * time.Minute)
+	if _, active := s.InMaintenance(); !active {
+		t.Fatal("维护窗口内应处于维护状态")
+	}
+	if summary := s.Summary(); summary.Status != StateMaintenance {
+		t.Fatalf("维护期间整体状态应为 %s，实际为 %s", StateMaintenance, summary.Status)
+	}
+
+	// 维护结束后自动清除
+	now = now.Add(time.Hour)
+	if s.Maintenance() != nil {
+		t.Fatal("维护结束后不应返回维护窗口")
+	}
+}
+
+func TestSummary(t *testing.T) {
+	s := NewService()
+	s.AddComponent("database", func() error { return nil })
+	if summary := s.Summary(); summary.Status != StateOperational || len(summary.Components) != 1 {
+		t.Fatalf("组件正常时整体状态应为 %s: %+v", StateOperational, summary)
+	}
+
+	s.AddComponent("relay", func() error { return errors.New("未运行") })
+	summary := s.Summary()
+	if summary.Status != StateDegraded || summary.Components[1].Status != StateDown {
+		t.Fatalf("部分组件异常时整体状态应为 %s: %+v", StateDegraded, summary)
+	}
+}
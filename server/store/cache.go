@@ -0,0 +1,134 @@
+package store
+
+import (
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+)
+
+// cachedDevice 缓存的设备及过期时间
+type cachedDevice struct {
+	device    db.Device
+	expiresAt time.Time
+}
+
+// cachedDeviceRepo 缓存按 ID 和节点 ID 查询设备的结果。
+//
+// 心跳、信令连接和中继认证每次都按节点 ID 查询设备，设备数量较多时这是数据库的主要读负载。
+// 通过本仓库更新设备时用更新后的记录刷新缓存，删除时移除，因此重新生成令牌和状态变化立即生效；
+// 多个服务端实例时其他实例的缓存最多在 ttl 后过期
+type cachedDeviceRepo struct {
+	DeviceRepo
+	ttl     time.Duration
+	now     func() time.Time
+	mu      sync.Mutex
+	byID    map[uint]*cachedDevice
+	byNode  map[string]*cachedDevice
+	version uint64 // 每次写入递增，避免并发查询把写入前读到的旧记录放回缓存
+}
+
+// NewCachedDeviceRepo 为设备仓库添加缓存，ttl 为缓存有效期
+func NewCachedDeviceRepo(repo DeviceRepo, ttl time.Duration) DeviceRepo {
+	return &cachedDeviceRepo{
+		DeviceRepo: repo,
+		ttl:        ttl,
+		now:        time.Now,
+		byID:       make(map[uint]*cachedDevice),
+		byNode:     make(map[string]*cachedDevice),
+	}
+}
+
+func (r *cachedDeviceRepo) GetByID(id uint) (*db.Device, error) {
+	r.mu.Lock()
+	entry := r.byID[id]
+	r.mu.Unlock()
+	if device, ok := r.fresh(entry); ok {
+		return device, nil
+	}
+	return r.load(func() (*db.Device, error) { return r.DeviceRepo.GetByID(id) })
+}
+
+func (r *cachedDeviceRepo) GetByNodeID(nodeID string) (*db.Device, error) {
+	r.mu.Lock()
+	entry := r.byNode[nodeID]
+	r.mu.Unlock()
+	if device, ok := r.fresh(entry); ok {
+		return device, nil
+	}
+	return r.load(func() (*db.Device, error) { return r.DeviceRepo.GetByNodeID(nodeID) })
+}
+
+func (r *cachedDeviceRepo) Update(device *db.Device, revision uint, updates map[string]interface{}) error {
+	return r.write(device, r.DeviceRepo.Update(device, revision, updates))
+}
+
+func (r *cachedDeviceRepo) UpdateFields(device *db.Device, updates map[string]interface{}) error {
+	return r.write(device, r.DeviceRepo.UpdateFields(device, updates))
+}
+
+func (r *cachedDeviceRepo) SaveReport(device *db.Device, updates map[string]interface{}, stats []db.Stats, conns []db.Connection, destinations []db.DestinationStats) error {
+	return r.write(device, r.DeviceRepo.SaveReport(device, updates, stats, conns, destinations))
+}
+
+func (r *cachedDeviceRepo) Delete(id uint) error {
+	err := r.DeviceRepo.Delete(id)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.version++
+	if entry, ok := r.byID[id]; ok {
+		delete(r.byNode, entry.device.NodeID)
+		delete(r.byID, id)
+	}
+	return err
+}
+
+// fresh 返回未过期的缓存副本，调用方修改返回的设备不影响缓存
+func (r *cachedDeviceRepo) fresh(entry *cachedDevice) (*db.Device, bool) {
+	if entry == nil || !r.now().Before(entry.expiresAt) {
+		return nil, false
+	}
+	device := entry.device
+	return &device, true
+}
+
+// load 查询数据库并缓存结果，查询期间有写入时不缓存
+func (r *cachedDeviceRepo) load(query func() (*db.Device, error)) (*db.Device, error) {
+	r.mu.Lock()
+	version := r.version
+	r.mu.Unlock()
+
+	device, err := query()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.version == version {
+		r.putLocked(device)
+	}
+	return device, nil
+}
+
+// write 写入后用重新加载的记录刷新缓存，写入失败时移除缓存，下次查询从数据库读取
+func (r *cachedDeviceRepo) write(device *db.Device, err error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.version++
+	if entry, ok := r.byID[device.ID]; ok {
+		delete(r.byNode, entry.device.NodeID)
+		delete(r.byID, device.ID)
+	}
+	if err == nil {
+		r.putLocked(device)
+	}
+	return err
+}
+
+func (r *cachedDeviceRepo) putLocked(device *db.Device) {
+	entry := &cachedDevice{device: *device, expiresAt: r.now().Add(r.ttl)}
+	r.byID[device.ID] = entry
+	r.byNode[device.NodeID] = entry
+}
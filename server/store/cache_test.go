@@ -0,0 +1,69 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+)
+
+// countingDeviceRepo 统计按节点 ID 查询数据库的次数
+type countingDeviceRepo struct {
+	DeviceRepo
+	queries int
+}
+
+func (r *countingDeviceRepo) GetByNodeID(nodeID string) (*db.Device, error) {
+	r.queries++
+	return r.DeviceRepo.GetByNodeID(nodeID)
+}
+
+func TestCachedDeviceRepo(t *testing.T) {
+	inner := &countingDeviceRepo{DeviceRepo: NewMemoryStore().Devices}
+	repo := NewCachedDeviceRepo(inner, 5*time.Second).(*cachedDeviceRepo)
+	now := time.Now()
+	repo.now = func() time.Time { return now }
+
+	device := &db.Device{UserID: 1, NodeID: "node-a", Token: "old"}
+	if err := repo.Create(device); err != nil {
+		t.Fatalf("创建设备失败: %v", err)
+	}
+
+	// 有效期内重复查询只访问一次数据库，修改返回值不影响缓存
+	for i := 0; i < 3; i++ {
+		found, err := repo.GetByNodeID("node-a")
+		if err != nil || found.Token != "old" {
+			t.Fatalf("查询设备失败: %+v %v", found, err)
+		}
+		found.Token = "modified"
+	}
+	if inner.queries != 1 {
+		t.Fatalf("有效期内应只查询一次数据库，实际 %d 次", inner.queries)
+	}
+
+	// 重新生成令牌后立即生效，不需要再次查询数据库
+	if err := repo.Update(device, 0, map[string]interface{}{"token": "new"}); err != nil {
+		t.Fatalf("更新设备失败: %v", err)
+	}
+	found, _ := repo.GetByNodeID("node-a")
+	if found.Token != "new" || inner.queries != 1 {
+		t.Fatalf("更新后应使用新令牌: %+v，查询 %d 次", found, inner.queries)
+	}
+
+	// 过期后重新查询
+	now = now.Add(6 * time.Second)
+	if _, err := repo.GetByNodeID("node-a"); err != nil || inner.queries != 2 {
+		t.Fatalf("缓存过期后应重新查询数据库: %v，查询 %d 次", err, inner.queries)
+	}
+
+	// 删除后不再返回缓存的设备
+	if err := repo.Delete(device.ID); err != nil {
+		t.Fatalf("删除设备失败: %v", err)
+	}
+	if _, err := repo.GetByNodeID("node-a"); !IsNotFound(err) {
+		t.Fatalf("删除后应返回 ErrNotFound: %v", err)
+	}
+	if _, err := repo.GetByID(device.ID); !IsNotFound(err) {
+		t.Fatalf("删除后应返回 ErrNotFound: %v", err)
+	}
+}
@@ -0,0 +1,1355 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// NewGormStore 创建基于 GORM 的仓库集合
+func NewGormStore(gdb *gorm.DB) *Store {
+	return &Store{
+		Users:       &gormUserRepo{db: gdb},
+		TOTPs:       &gormTOTPRepo{db: gdb},
+		Invitations: &gormInvitationRepo{db: gdb},
+		APIKeys:     &gormAPIKeyRepo{db: gdb},
+		Devices:     &gormDeviceRepo{db: gdb},
+		Filters:     &gormDeviceFilterRepo{db: gdb},
+		Certs:       &gormCertificateRepo{db: gdb},
+		Observers:   &gormObserverLinkRepo{db: gdb},
+		Aliases:     &gormPeerAliasRepo{db: gdb},
+		Apps:        &gormAppRepo{db: gdb},
+		Forwards:    &gormForwardRepo{db: gdb},
+		Connections: &gormConnectionRepo{db: gdb},
+		Stats:       &gormStatsRepo{db: gdb},
+		Alerts:      &gormAlertRepo{db: gdb},
+		Routes:      &gormRouteRepo{db: gdb},
+		SpeedTests:  &gormSpeedTestRepo{db: gdb},
+		Exports:     &gormExportRepo{db: gdb},
+		Metrics:     &gormMetricsRepo{db: gdb},
+		Punches:     &gormPunchStatRepo{db: gdb},
+		Bans:        &gormBanRepo{db: gdb},
+		Reports:     &gormAbuseReportRepo{db: gdb},
+	}
+}
+
+// translate 将 GORM 错误转换为仓库错误
+func translate(err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, gorm.ErrRecordNotFound), errors.Is(err, sql.ErrNoRows):
+		return ErrNotFound
+	case errors.Is(err, gorm.ErrDuplicatedKey):
+		return ErrDuplicate
+	default:
+		return err
+	}
+}
+
+// updateWithRevision 在修订号匹配时更新记录并递增修订号，更新成功后重新加载 model
+func updateWithRevision(gdb *gorm.DB, model interface{}, revision uint, updates map[string]interface{}) error {
+	updates["revision"] = gorm.Expr("revision + 1")
+
+	query := gdb.Model(model)
+	if revision != 0 {
+		query = query.Where("revision = ?", revision)
+	}
+
+	result := query.Updates(updates)
+	if result.Error != nil {
+		return translate(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrRevisionConflict
+	}
+
+	return translate(gdb.First(model).Error)
+}
+
+// updateFields 更新字段并重新加载 model
+func updateFields(gdb *gorm.DB, model interface{}, updates map[string]interface{}) error {
+	if err := gdb.Model(model).Updates(updates).Error; err != nil {
+		return translate(err)
+	}
+	return translate(gdb.First(model).Error)
+}
+
+// gormUserRepo 基于 GORM 的用户仓库
+type gormUserRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormUserRepo) Create(user *db.User) error {
+	return translate(r.db.Create(user).Error)
+}
+
+func (r *gormUserRepo) GetByID(id uint) (*db.User, error) {
+	var user db.User
+	if err := r.db.First(&user, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepo) GetByUsername(username string) (*db.User, error) {
+	var user db.User
+	if err := r.db.Where("username = ?", username).First(&user).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepo) GetByEmail(email string) (*db.User, error) {
+	var user db.User
+	if err := r.db.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &user, nil
+}
+
+func (r *gormUserRepo) UpdateFields(user *db.User, updates map[string]interface{}) error {
+	return updateFields(r.db, user, updates)
+}
+
+func (r *gormUserRepo) Delete(id uint) error {
+	return translate(r.db.Delete(&db.User{}, id).Error)
+}
+
+// gormTOTPRepo 基于 GORM 的双因素认证仓库
+type gormTOTPRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormTOTPRepo) Create(totp *db.TOTP) error {
+	return translate(r.db.Create(totp).Error)
+}
+
+func (r *gormTOTPRepo) GetByUser(userID uint) (*db.TOTP, error) {
+	var totp db.TOTP
+	if err := r.db.Where("user_id = ?", userID).First(&totp).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &totp, nil
+}
+
+func (r *gormTOTPRepo) UpdateFields(totp *db.TOTP, updates map[string]interface{}) error {
+	return updateFields(r.db, totp, updates)
+}
+
+func (r *gormTOTPRepo) Delete(id uint) error {
+	return translate(r.db.Delete(&db.TOTP{}, id).Error)
+}
+
+// gormInvitationRepo 基于 GORM 的注册邀请仓库
+type gormInvitationRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormInvitationRepo) Create(inv *db.Invitation) error {
+	return translate(r.db.Create(inv).Error)
+}
+
+func (r *gormInvitationRepo) GetByCodeHash(codeHash string) (*db.Invitation, error) {
+	var inv db.Invitation
+	if err := r.db.Where("code_hash = ?", codeHash).First(&inv).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &inv, nil
+}
+
+func (r *gormInvitationRepo) List() ([]db.Invitation, error) {
+	var invitations []db.Invitation
+	if err := r.db.Order("created_at DESC").Find(&invitations).Error; err != nil {
+		return nil, translate(err)
+	}
+	return invitations, nil
+}
+
+func (r *gormInvitationRepo) Redeem(inv *db.Invitation) error {
+	// 在同一条语句中检查并递增，避免并发注册超出使用次数
+	result := r.db.Model(inv).
+		Where("used_count < max_uses").
+		UpdateColumn("used_count", gorm.Expr("used_count + 1"))
+	if result.Error != nil {
+		return translate(result.Error)
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return translate(r.db.First(inv).Error)
+}
+
+func (r *gormInvitationRepo) Delete(id uint) error {
+	return translate(r.db.Delete(&db.Invitation{}, id).Error)
+}
+
+// gormAPIKeyRepo 基于 GORM 的 API 密钥仓库
+type gormAPIKeyRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormAPIKeyRepo) Create(key *db.APIKey) error {
+	return translate(r.db.Create(key).Error)
+}
+
+func (r *gormAPIKeyRepo) GetByKeyHash(keyHash string) (*db.APIKey, error) {
+	var key db.APIKey
+	if err := r.db.Where("key_hash = ?", keyHash).First(&key).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &key, nil
+}
+
+func (r *gormAPIKeyRepo) GetByID(id uint) (*db.APIKey, error) {
+	var key db.APIKey
+	if err := r.db.First(&key, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &key, nil
+}
+
+func (r *gormAPIKeyRepo) ListByUser(userID uint) ([]db.APIKey, error) {
+	var keys []db.APIKey
+	if err := r.db.Where("user_id = ?", userID).Order("id DESC").Find(&keys).Error; err != nil {
+		return nil, translate(err)
+	}
+	return keys, nil
+}
+
+func (r *gormAPIKeyRepo) Delete(id uint) error {
+	return translate(r.db.Delete(&db.APIKey{}, id).Error)
+}
+
+// gormDeviceFilterRepo 基于 GORM 的设备筛选条件仓库
+type gormDeviceFilterRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormDeviceFilterRepo) Create(filter *db.DeviceFilter) error {
+	return translate(r.db.Create(filter).Error)
+}
+
+func (r *gormDeviceFilterRepo) GetByID(id uint) (*db.DeviceFilter, error) {
+	var filter db.DeviceFilter
+	if err := r.db.First(&filter, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &filter, nil
+}
+
+func (r *gormDeviceFilterRepo) ListByUser(userID uint) ([]db.DeviceFilter, error) {
+	var filters []db.DeviceFilter
+	if err := r.db.Where("user_id = ?", userID).Order("name").Find(&filters).Error; err != nil {
+		return nil, translate(err)
+	}
+	return filters, nil
+}
+
+func (r *gormDeviceFilterRepo) Delete(id uint) error {
+	return translate(r.db.Delete(&db.DeviceFilter{}, id).Error)
+}
+
+// gormCertificateRepo 基于 GORM 的设备证书仓库
+type gormCertificateRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormCertificateRepo) Create(cert *db.DeviceCertificate) error {
+	return translate(r.db.Create(cert).Error)
+}
+
+func (r *gormCertificateRepo) GetBySerial(serial string) (*db.DeviceCertificate, error) {
+	var cert db.DeviceCertificate
+	if err := r.db.Where("serial = ?", serial).First(&cert).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &cert, nil
+}
+
+func (r *gormCertificateRepo) ListByDevice(deviceID uint) ([]db.DeviceCertificate, error) {
+	var certs []db.DeviceCertificate
+	if err := r.db.Where("device_id = ?", deviceID).Order("id DESC").Find(&certs).Error; err != nil {
+		return nil, translate(err)
+	}
+	return certs, nil
+}
+
+func (r *gormCertificateRepo) ListRevoked(after time.Time) ([]db.DeviceCertificate, error) {
+	var certs []db.DeviceCertificate
+	err := r.db.Where("revoked_at IS NOT NULL AND not_after > ?", after).Order("revoked_at").Find(&certs).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	return certs, nil
+}
+
+func (r *gormCertificateRepo) RevokeByDevice(deviceID uint, except, reason string, at time.Time) (int64, error) {
+	result := r.db.Model(&db.DeviceCertificate{}).
+		Where("device_id = ? AND serial <> ? AND revoked_at IS NULL", deviceID, except).
+		Updates(map[string]interface{}{"revoked_at": at, "revoke_reason": reason})
+	return result.RowsAffected, translate(result.Error)
+}
+
+// gormObserverLinkRepo 基于 GORM 的设备观察链接仓库
+type gormObserverLinkRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormObserverLinkRepo) Create(link *db.ObserverLink) error {
+	return translate(r.db.Create(link).Error)
+}
+
+func (r *gormObserverLinkRepo) GetByTokenHash(tokenHash string) (*db.ObserverLink, error) {
+	var link db.ObserverLink
+	if err := r.db.Where("token_hash = ?", tokenHash).First(&link).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &link, nil
+}
+
+func (r *gormObserverLinkRepo) GetByID(id uint) (*db.ObserverLink, error) {
+	var link db.ObserverLink
+	if err := r.db.First(&link, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &link, nil
+}
+
+func (r *gormObserverLinkRepo) ListByDevice(deviceID uint) ([]db.ObserverLink, error) {
+	var links []db.ObserverLink
+	if err := r.db.Where("device_id = ?", deviceID).Order("id DESC").Find(&links).Error; err != nil {
+		return nil, translate(err)
+	}
+	return links, nil
+}
+
+func (r *gormObserverLinkRepo) Delete(id uint) error {
+	return translate(r.db.Delete(&db.ObserverLink{}, id).Error)
+}
+
+// gormPeerAliasRepo 基于 GORM 的对等节点别名仓库
+type gormPeerAliasRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormPeerAliasRepo) Create(alias *db.PeerAlias) error {
+	return translate(r.db.Create(alias).Error)
+}
+
+func (r *gormPeerAliasRepo) GetByName(userID uint, name string) (*db.PeerAlias, error) {
+	var alias db.PeerAlias
+	if err := r.db.Where("user_id = ? AND name = ?", userID, name).First(&alias).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &alias, nil
+}
+
+func (r *gormPeerAliasRepo) ListByUser(userID uint) ([]db.PeerAlias, error) {
+	var aliases []db.PeerAlias
+	if err := r.db.Where("user_id = ?", userID).Order("name").Find(&aliases).Error; err != nil {
+		return nil, translate(err)
+	}
+	return aliases, nil
+}
+
+func (r *gormPeerAliasRepo) Delete(id uint) error {
+	return translate(r.db.Delete(&db.PeerAlias{}, id).Error)
+}
+
+// gormDeviceRepo 基于 GORM 的设备仓库
+type gormDeviceRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormDeviceRepo) Create(device *db.Device) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(device).Error; err != nil {
+			return translate(err)
+		}
+		status := db.StatusOf(device)
+		return translate(tx.Create(&status).Error)
+	})
+}
+
+func (r *gormDeviceRepo) GetByID(id uint) (*db.Device, error) {
+	var device db.Device
+	if err := r.db.Scopes(db.WithStatus).First(&device, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &device, nil
+}
+
+func (r *gormDeviceRepo) GetByNodeID(nodeID string) (*db.Device, error) {
+	var device db.Device
+	if err := r.db.Scopes(db.WithStatus).Where("devices.node_id = ?", nodeID).First(&device).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &device, nil
+}
+
+func (r *gormDeviceRepo) ListByUser(userID uint) ([]db.Device, error) {
+	var devices []db.Device
+	if err := r.db.Scopes(db.WithStatus).Where("devices.user_id = ?", userID).Find(&devices).Error; err != nil {
+		return nil, translate(err)
+	}
+	return devices, nil
+}
+
+func (r *gormDeviceRepo) ListByStatus(status string) ([]db.Device, error) {
+	var devices []db.Device
+	if err := r.db.Scopes(db.WithStatus).Where("device_statuses.status = ?", status).Find(&devices).Error; err != nil {
+		return nil, translate(err)
+	}
+	return devices, nil
+}
+
+func (r *gormDeviceRepo) ListVersions() ([]string, error) {
+	var versions []string
+	if err := r.db.Model(&db.DeviceStatus{}).Pluck("version", &versions).Error; err != nil {
+		return nil, translate(err)
+	}
+	return versions, nil
+}
+
+func (r *gormDeviceRepo) ListStatuses(userID uint, status string) ([]db.DeviceStatus, error) {
+	query := r.db.Where("user_id = ?", userID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	statuses := []db.DeviceStatus{}
+	if err := query.Order("device_id").Find(&statuses).Error; err != nil {
+		return nil, translate(err)
+	}
+	return statuses, nil
+}
+
+func (r *gormDeviceRepo) Update(device *db.Device, revision uint, updates map[string]interface{}) error {
+	status, inventory := db.SplitDeviceUpdates(updates)
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := saveDeviceStatus(tx, device, status); err != nil {
+			return err
+		}
+		if err := updateWithRevision(tx, device, revision, inventory); err != nil {
+			return err
+		}
+		return reloadDevice(tx, device)
+	})
+}
+
+// UpdateFields 只更新运行状态时只写入状态快照，不锁设备记录
+func (r *gormDeviceRepo) UpdateFields(device *db.Device, updates map[string]interface{}) error {
+	status, inventory := db.SplitDeviceUpdates(updates)
+	if len(inventory) == 0 {
+		if err := saveDeviceStatus(r.db, device, status); err != nil {
+			return err
+		}
+		return reloadDevice(r.db, device)
+	}
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := saveDeviceStatus(tx, device, status); err != nil {
+			return err
+		}
+		if err := tx.Model(device).Updates(inventory).Error; err != nil {
+			return translate(err)
+		}
+		return reloadDevice(tx, device)
+	})
+}
+
+func (r *gormDeviceRepo) SaveReport(device *db.Device, updates map[string]interface{}, stats []db.Stats, conns []db.Connection, destinations []db.DestinationStats) error {
+	status, inventory := db.SplitDeviceUpdates(updates)
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := saveDeviceStatus(tx, device, status); err != nil {
+			return err
+		}
+		if len(inventory) > 0 {
+			if err := tx.Model(device).Updates(inventory).Error; err != nil {
+				return translate(err)
+			}
+		}
+		if len(stats) > 0 {
+			if err := tx.Create(&stats).Error; err != nil {
+				return translate(err)
+			}
+		}
+		if len(destinations) > 0 {
+			if err := tx.Create(&destinations).Error; err != nil {
+				return translate(err)
+			}
+		}
+		for i := range conns {
+			conn := &conns[i]
+			var existing db.Connection
+			err := tx.Where("source_device_id = ? AND target_device_id = ? AND type = ?", conn.SourceDeviceID, conn.TargetDeviceID, conn.Type).
+				First(&existing).Error
+			switch {
+			case err == nil:
+				conn.Model = existing.Model
+				err = tx.Save(conn).Error
+			case errors.Is(err, gorm.ErrRecordNotFound):
+				err = tx.Create(conn).Error
+			}
+			if err != nil {
+				return translate(err)
+			}
+		}
+		return reloadDevice(tx, device)
+	})
+}
+
+func (r *gormDeviceRepo) Delete(id uint) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("device_id = ?", id).Delete(&db.DeviceStatus{}).Error; err != nil {
+			return translate(err)
+		}
+		return translate(tx.Delete(&db.Device{}, id).Error)
+	})
+}
+
+// saveDeviceStatus 按列名更新设备的状态快照，快照不存在时以设备当前的状态创建
+func saveDeviceStatus(tx *gorm.DB, device *db.Device, updates map[string]interface{}) error {
+	if len(updates) == 0 {
+		return nil
+	}
+	status := db.StatusOf(device)
+	if err := applyUpdates(&status, updates); err != nil {
+		return err
+	}
+	status.UpdatedAt = time.Now()
+
+	columns := make([]string, 0, len(updates)+1)
+	for column := range updates {
+		columns = append(columns, column)
+	}
+	columns = append(columns, "updated_at")
+	return translate(tx.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "device_id"}},
+		DoUpdates: clause.AssignmentColumns(columns),
+	}).Create(&status).Error)
+}
+
+// reloadDevice 重新加载设备及其状态快照
+func reloadDevice(tx *gorm.DB, device *db.Device) error {
+	return translate(tx.Scopes(db.WithStatus).First(device, device.ID).Error)
+}
+
+func (r *gormDeviceRepo) CreateEvents(events []db.DeviceEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+	return translate(r.db.Create(&events).Error)
+}
+
+func (r *gormDeviceRepo) ListEvents(deviceID uint, limit int) ([]db.DeviceEvent, error) {
+	var events []db.DeviceEvent
+	if err := r.db.Where("device_id = ?", deviceID).Order("occurred_at DESC").Limit(limit).Find(&events).Error; err != nil {
+		return nil, translate(err)
+	}
+	return events, nil
+}
+
+func (r *gormDeviceRepo) CountEvents(deviceID uint, eventType string, since time.Time) (int64, error) {
+	var count int64
+	if err := r.db.Model(&db.DeviceEvent{}).
+		Where("device_id = ? AND type = ? AND occurred_at >= ?", deviceID, eventType, since).
+		Count(&count).Error; err != nil {
+		return 0, translate(err)
+	}
+	return count, nil
+}
+
+func (r *gormDeviceRepo) CreateTrace(trace *db.ConnectionTrace) error {
+	return translate(r.db.Create(trace).Error)
+}
+
+func (r *gormDeviceRepo) ListTraces(deviceID uint, peerID string, limit int) ([]db.ConnectionTrace, error) {
+	query := r.db.Where("device_id = ?", deviceID)
+	if peerID != "" {
+		query = query.Where("peer_id = ?", peerID)
+	}
+	var traces []db.ConnectionTrace
+	if err := query.Order("started_at DESC").Limit(limit).Find(&traces).Error; err != nil {
+		return nil, translate(err)
+	}
+	return traces, nil
+}
+
+// gormAppRepo 基于 GORM 的应用仓库
+type gormAppRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormAppRepo) Create(app *db.App) error {
+	// 端口检查和创建在同一事务中完成，并发创建由唯一索引兜底
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&db.App{}).Where("device_id = ? AND src_port = ? AND protocol = ?", app.DeviceID, app.SrcPort, app.Protocol).Count(&count).Error; err != nil {
+			return translate(err)
+		}
+		if count > 0 {
+			return ErrDuplicate
+		}
+		version, err := bumpAppsVersion(tx, app.DeviceID)
+		if err != nil {
+			return err
+		}
+		app.ConfigVersion = version
+		return translate(tx.Create(app).Error)
+	})
+}
+
+func (r *gormAppRepo) GetByID(id uint) (*db.App, error) {
+	var app db.App
+	if err := r.db.First(&app, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &app, nil
+}
+
+func (r *gormAppRepo) ListByUser(userID uint) ([]db.App, error) {
+	var apps []db.App
+	if err := r.db.Where("user_id = ?", userID).Find(&apps).Error; err != nil {
+		return nil, translate(err)
+	}
+	return apps, nil
+}
+
+func (r *gormAppRepo) ListByDevice(deviceID uint) ([]db.App, error) {
+	var apps []db.App
+	if err := r.db.Where("device_id = ?", deviceID).Find(&apps).Error; err != nil {
+		return nil, translate(err)
+	}
+	return apps, nil
+}
+
+func (r *gormAppRepo) CountByDevice(deviceID uint) (int64, error) {
+	var count int64
+	if err := r.db.Model(&db.App{}).Where("device_id = ?", deviceID).Count(&count).Error; err != nil {
+		return 0, translate(err)
+	}
+	return count, nil
+}
+
+func (r *gormAppRepo) Update(app *db.App, revision uint, updates map[string]interface{}) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		version, err := bumpAppsVersion(tx, app.DeviceID)
+		if err != nil {
+			return err
+		}
+		updates["config_version"] = version
+		return updateWithRevision(tx, app, revision, updates)
+	})
+}
+
+func (r *gormAppRepo) UpdateFields(app *db.App, updates map[string]interface{}) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		version, err := bumpAppsVersion(tx, app.DeviceID)
+		if err != nil {
+			return err
+		}
+		updates["config_version"] = version
+		return updateFields(tx, app, updates)
+	})
+}
+
+func (r *gormAppRepo) Delete(id uint) error {
+	// 软删除前记录版本号，增量同步时作为已删除的应用返回
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var app db.App
+		if err := tx.First(&app, id).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return translate(err)
+		}
+		version, err := bumpAppsVersion(tx, app.DeviceID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&app).UpdateColumn("config_version", version).Error; err != nil {
+			return translate(err)
+		}
+		return translate(tx.Delete(&app).Error)
+	})
+}
+
+func (r *gormAppRepo) ListChanges(deviceID, since uint) (uint, []db.App, []db.App, error) {
+	var version uint
+	if err := r.db.Model(&db.Device{}).Where("id = ?", deviceID).Select("apps_version").Row().Scan(&version); err != nil {
+		return 0, nil, nil, translate(err)
+	}
+
+	var apps []db.App
+	if err := r.db.Unscoped().Where("device_id = ? AND config_version > ?", deviceID, since).Order("id").Find(&apps).Error; err != nil {
+		return 0, nil, nil, translate(err)
+	}
+	changed, removed := []db.App{}, []db.App{}
+	for _, app := range apps {
+		if app.DeletedAt.Valid {
+			removed = append(removed, app)
+		} else {
+			changed = append(changed, app)
+		}
+	}
+	return version, changed, removed, nil
+}
+
+// bumpAppsVersion 递增设备的应用配置版本号并返回新的版本号，行锁保证同一设备的版本号依次递增
+func bumpAppsVersion(tx *gorm.DB, deviceID uint) (uint, error) {
+	if err := tx.Model(&db.Device{}).Where("id = ?", deviceID).UpdateColumn("apps_version", gorm.Expr("apps_version + 1")).Error; err != nil {
+		return 0, translate(err)
+	}
+	var version uint
+	if err := tx.Model(&db.Device{}).Where("id = ?", deviceID).Select("apps_version").Row().Scan(&version); err != nil {
+		return 0, translate(err)
+	}
+	return version, nil
+}
+
+// gormForwardRepo 基于 GORM 的转发规则仓库
+type gormForwardRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormForwardRepo) Create(forward *db.Forward) error {
+	// 端口检查和创建在同一事务中完成，并发创建由唯一索引兜底
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&db.Forward{}).Where("user_id = ? AND src_port = ? AND protocol = ?", forward.UserID, forward.SrcPort, forward.Protocol).Count(&count).Error; err != nil {
+			return translate(err)
+		}
+		if count > 0 {
+			return ErrDuplicate
+		}
+		return translate(tx.Create(forward).Error)
+	})
+}
+
+func (r *gormForwardRepo) GetForUser(userID, id uint) (*db.Forward, error) {
+	var forward db.Forward
+	if err := r.db.Where("id = ? AND user_id = ?", id, userID).First(&forward).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &forward, nil
+}
+
+func (r *gormForwardRepo) ListByUser(userID uint) ([]db.Forward, error) {
+	var forwards []db.Forward
+	if err := r.db.Where("user_id = ?", userID).Find(&forwards).Error; err != nil {
+		return nil, translate(err)
+	}
+	return forwards, nil
+}
+
+func (r *gormForwardRepo) Update(forward *db.Forward, revision uint, updates map[string]interface{}) error {
+	return updateWithRevision(r.db, forward, revision, updates)
+}
+
+func (r *gormForwardRepo) Delete(id uint) error {
+	return translate(r.db.Delete(&db.Forward{}, id).Error)
+}
+
+// gormConnectionRepo 基于 GORM 的连接记录仓库
+type gormConnectionRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormConnectionRepo) Create(conn *db.Connection) error {
+	return translate(r.db.Create(conn).Error)
+}
+
+func (r *gormConnectionRepo) GetByID(id uint) (*db.Connection, error) {
+	var conn db.Connection
+	if err := r.db.First(&conn, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &conn, nil
+}
+
+func (r *gormConnectionRepo) UpdateFields(conn *db.Connection, updates map[string]interface{}) error {
+	return updateFields(r.db, conn, updates)
+}
+
+func (r *gormConnectionRepo) CountByDevice(deviceID uint) (int64, error) {
+	var count int64
+	if err := r.db.Model(&db.Connection{}).Where("source_device_id = ? OR target_device_id = ?", deviceID, deviceID).Count(&count).Error; err != nil {
+		return 0, translate(err)
+	}
+	return count, nil
+}
+
+func (r *gormConnectionRepo) CountByType(connType string, deviceIDs []uint, since time.Time) (int64, error) {
+	var count int64
+	if err := r.db.Model(&db.Connection{}).
+		Where("type = ? AND source_device_id IN ? AND established_at >= ?", connType, deviceIDs, since).
+		Count(&count).Error; err != nil {
+		return 0, translate(err)
+	}
+	return count, nil
+}
+
+func (r *gormConnectionRepo) TrafficByType(connType string, deviceIDs []uint, since time.Time) (uint64, error) {
+	var total struct {
+		Bytes uint64
+	}
+	if err := r.db.Model(&db.Connection{}).
+		Select("COALESCE(SUM(bytes_sent + bytes_received), 0) AS bytes").
+		Where("type = ? AND source_device_id IN ? AND established_at >= ?", connType, deviceIDs, since).
+		Scan(&total).Error; err != nil {
+		return 0, translate(err)
+	}
+	return total.Bytes, nil
+}
+
+// gormStatsRepo 基于 GORM 的流量统计仓库
+type gormStatsRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormStatsRepo) Create(stats *db.Stats) error {
+	return translate(r.db.Create(stats).Error)
+}
+
+func (r *gormStatsRepo) LatestByDevice(deviceID uint) (*db.Stats, error) {
+	var stats db.Stats
+	if err := r.db.Where("device_id = ? AND app_id = 0 AND forward_id = 0", deviceID).Order("created_at DESC").First(&stats).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &stats, nil
+}
+
+func (r *gormStatsRepo) LatestByApp(appID uint) (*db.Stats, error) {
+	var stats db.Stats
+	if err := r.db.Where("app_id = ?", appID).Order("created_at DESC").First(&stats).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &stats, nil
+}
+
+func (r *gormStatsRepo) TopDestinations(appID uint, since time.Time, limit int, orderBy string) ([]db.DestinationTotal, error) {
+	order := "SUM(bytes_sent) + SUM(bytes_received) DESC"
+	if orderBy == OrderByConnections {
+		order = "SUM(connections) DESC"
+	}
+	totals := []db.DestinationTotal{}
+	err := r.db.Model(&db.DestinationStats{}).
+		Select("protocol, host, address, SUM(bytes_sent) AS bytes_sent, SUM(bytes_received) AS bytes_received, SUM(connections) AS connections").
+		Where("app_id = ? AND reported_at >= ?", appID, since).
+		Group("protocol, host, address").
+		Order(order).
+		Limit(limit).
+		Scan(&totals).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	return totals, nil
+}
+
+// gormAlertRepo 基于 GORM 的告警仓库
+type gormAlertRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormAlertRepo) CreateRule(rule *db.AlertRule) error {
+	return translate(r.db.Create(rule).Error)
+}
+
+func (r *gormAlertRepo) GetRule(id uint) (*db.AlertRule, error) {
+	var rule db.AlertRule
+	if err := r.db.First(&rule, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &rule, nil
+}
+
+func (r *gormAlertRepo) ListRules(userID uint) ([]db.AlertRule, error) {
+	var rules []db.AlertRule
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&rules).Error; err != nil {
+		return nil, translate(err)
+	}
+	return rules, nil
+}
+
+func (r *gormAlertRepo) ListEnabledRules() ([]db.AlertRule, error) {
+	var rules []db.AlertRule
+	if err := r.db.Where("enabled = ?", true).Order("id").Find(&rules).Error; err != nil {
+		return nil, translate(err)
+	}
+	return rules, nil
+}
+
+func (r *gormAlertRepo) SaveRule(rule *db.AlertRule) error {
+	return translate(r.db.Save(rule).Error)
+}
+
+func (r *gormAlertRepo) DeleteRule(id uint) error {
+	return translate(r.db.Delete(&db.AlertRule{}, id).Error)
+}
+
+func (r *gormAlertRepo) ListFiringEvents(ruleID uint) ([]db.AlertEvent, error) {
+	var events []db.AlertEvent
+	if err := r.db.Where("rule_id = ? AND status = ?", ruleID, db.AlertFiring).Find(&events).Error; err != nil {
+		return nil, translate(err)
+	}
+	return events, nil
+}
+
+func (r *gormAlertRepo) SaveEvent(event *db.AlertEvent) error {
+	return translate(r.db.Save(event).Error)
+}
+
+func (r *gormAlertRepo) ListEvents(userID uint, status string, limit int) ([]db.AlertEvent, error) {
+	query := r.db.Where("user_id = ?", userID)
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	var events []db.AlertEvent
+	if err := query.Order("fired_at DESC").Limit(limit).Find(&events).Error; err != nil {
+		return nil, translate(err)
+	}
+	return events, nil
+}
+
+// gormRouteRepo 基于 GORM 的子网路由仓库
+type gormRouteRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormRouteRepo) Create(route *db.Route) error {
+	return translate(r.db.Create(route).Error)
+}
+
+func (r *gormRouteRepo) GetByID(id uint) (*db.Route, error) {
+	var route db.Route
+	if err := r.db.Preload("ACLs").First(&route, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &route, nil
+}
+
+func (r *gormRouteRepo) ListByUser(userID uint) ([]db.Route, error) {
+	var routes []db.Route
+	if err := r.db.Preload("ACLs").Where("user_id = ?", userID).Order("id").Find(&routes).Error; err != nil {
+		return nil, translate(err)
+	}
+	return routes, nil
+}
+
+func (r *gormRouteRepo) ListByDevice(deviceID uint) ([]db.Route, error) {
+	var routes []db.Route
+	if err := r.db.Preload("ACLs").Where("device_id = ?", deviceID).Order("id").Find(&routes).Error; err != nil {
+		return nil, translate(err)
+	}
+	return routes, nil
+}
+
+func (r *gormRouteRepo) Save(route *db.Route) error {
+	return translate(r.db.Omit("ACLs").Save(route).Error)
+}
+
+func (r *gormRouteRepo) Delete(id uint) error {
+	return translate(r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("route_id = ?", id).Delete(&db.RouteACL{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&db.Route{}, id).Error
+	}))
+}
+
+func (r *gormRouteRepo) SetACL(routeID uint, peerDeviceIDs []uint) error {
+	return translate(r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Unscoped().Where("route_id = ?", routeID).Delete(&db.RouteACL{}).Error; err != nil {
+			return err
+		}
+		for _, peerID := range peerDeviceIDs {
+			if err := tx.Create(&db.RouteACL{RouteID: routeID, PeerDeviceID: peerID}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	}))
+}
+
+// gormSpeedTestRepo 基于 GORM 的测速仓库
+type gormSpeedTestRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormSpeedTestRepo) CreateSchedule(schedule *db.SpeedTestSchedule) error {
+	return translate(r.db.Create(schedule).Error)
+}
+
+func (r *gormSpeedTestRepo) GetSchedule(id uint) (*db.SpeedTestSchedule, error) {
+	var schedule db.SpeedTestSchedule
+	if err := r.db.First(&schedule, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &schedule, nil
+}
+
+func (r *gormSpeedTestRepo) ListSchedules(userID uint) ([]db.SpeedTestSchedule, error) {
+	var schedules []db.SpeedTestSchedule
+	if err := r.db.Where("user_id = ?", userID).Order("id").Find(&schedules).Error; err != nil {
+		return nil, translate(err)
+	}
+	return schedules, nil
+}
+
+func (r *gormSpeedTestRepo) ListDue(now time.Time) ([]db.SpeedTestSchedule, error) {
+	var schedules []db.SpeedTestSchedule
+	if err := r.db.Where("enabled = ? AND next_run_at <= ?", true, now).Order("id").Find(&schedules).Error; err != nil {
+		return nil, translate(err)
+	}
+	return schedules, nil
+}
+
+func (r *gormSpeedTestRepo) SaveSchedule(schedule *db.SpeedTestSchedule) error {
+	return translate(r.db.Save(schedule).Error)
+}
+
+func (r *gormSpeedTestRepo) UpdateScheduleFields(schedule *db.SpeedTestSchedule, updates map[string]interface{}) error {
+	return updateFields(r.db, schedule, updates)
+}
+
+func (r *gormSpeedTestRepo) DeleteSchedule(id uint) error {
+	return translate(r.db.Delete(&db.SpeedTestSchedule{}, id).Error)
+}
+
+func (r *gormSpeedTestRepo) CreateResult(result *db.SpeedTestResult) error {
+	return translate(r.db.Create(result).Error)
+}
+
+func (r *gormSpeedTestRepo) ListResults(scheduleID uint, since time.Time, limit int) ([]db.SpeedTestResult, error) {
+	var results []db.SpeedTestResult
+	if err := r.db.Where("schedule_id = ? AND created_at >= ?", scheduleID, since).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&results).Error; err != nil {
+		return nil, translate(err)
+	}
+	return results, nil
+}
+
+// gormExportRepo 基于 GORM 的数据导出仓库
+type gormExportRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormExportRepo) EachDevice(userID uint, since time.Time, fn func(device *db.Device) error) error {
+	query := r.db.Model(&db.Device{}).Scopes(db.WithStatus).
+		Where("devices.user_id = ? AND devices.created_at >= ?", userID, since).
+		Order("devices.id")
+	return r.each(query, func(rows *sql.Rows) error {
+		var device db.Device
+		if err := r.db.ScanRows(rows, &device); err != nil {
+			return err
+		}
+		return fn(&device)
+	})
+}
+
+func (r *gormExportRepo) EachForward(userID uint, since time.Time, fn func(forward *db.Forward) error) error {
+	query := r.db.Model(&db.Forward{}).Where("user_id = ? AND created_at >= ?", userID, since).Order("id")
+	return r.each(query, func(rows *sql.Rows) error {
+		var forward db.Forward
+		if err := r.db.ScanRows(rows, &forward); err != nil {
+			return err
+		}
+		return fn(&forward)
+	})
+}
+
+func (r *gormExportRepo) EachConnection(userID uint, since time.Time, fn func(conn *db.Connection) error) error {
+	devices := r.db.Model(&db.Device{}).Select("id").Where("user_id = ?", userID)
+	query := r.db.Model(&db.Connection{}).
+		Where("(source_device_id IN (?) OR target_device_id IN (?)) AND established_at >= ?", devices, devices, since).
+		Order("id")
+	return r.each(query, func(rows *sql.Rows) error {
+		var conn db.Connection
+		if err := r.db.ScanRows(rows, &conn); err != nil {
+			return err
+		}
+		return fn(&conn)
+	})
+}
+
+// each 逐行读取查询结果
+func (r *gormExportRepo) each(query *gorm.DB, scan func(rows *sql.Rows) error) error {
+	rows, err := query.Rows()
+	if err != nil {
+		return translate(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// gormMetricsRepo 基于 GORM 的全局统计仓库
+type gormMetricsRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormMetricsRepo) CountUsers() (int64, error) {
+	var count int64
+	if err := r.db.Model(&db.User{}).Count(&count).Error; err != nil {
+		return 0, translate(err)
+	}
+	return count, nil
+}
+
+func (r *gormMetricsRepo) CountDevicesByStatus() (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := r.db.Model(&db.DeviceStatus{}).Select("status, COUNT(*) AS count").Group("status").Scan(&rows).Error; err != nil {
+		return nil, translate(err)
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Status] = row.Count
+	}
+	return counts, nil
+}
+
+func (r *gormMetricsRepo) Traffic(since time.Time) (*db.TrafficTotal, error) {
+	var total db.TrafficTotal
+	err := r.db.Model(&db.DestinationStats{}).
+		Select("COALESCE(SUM(bytes_sent), 0) AS bytes_sent, COALESCE(SUM(bytes_received), 0) AS bytes_received, COALESCE(SUM(connections), 0) AS connections").
+		Where("reported_at >= ?", since).
+		Scan(&total).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &total, nil
+}
+
+func (r *gormMetricsRepo) RelayTraffic(since time.Time) (*db.TrafficTotal, error) {
+	var total db.TrafficTotal
+	err := r.db.Model(&db.Connection{}).
+		Select("COALESCE(SUM(bytes_sent), 0) AS bytes_sent, COALESCE(SUM(bytes_received), 0) AS bytes_received, COUNT(*) AS connections").
+		Where("type = ? AND last_active_at >= ?", ConnectionTypeRelay, since).
+		Scan(&total).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	return &total, nil
+}
+
+func (r *gormMetricsRepo) CountTracesByPath(since time.Time) (map[string]int64, error) {
+	var rows []struct {
+		Path  string
+		Count int64
+	}
+	err := r.db.Model(&db.ConnectionTrace{}).
+		Select("path, COUNT(*) AS count").
+		Where("started_at >= ?", since).
+		Group("path").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	counts := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		counts[row.Path] += row.Count
+	}
+	return counts, nil
+}
+
+func (r *gormMetricsRepo) TopDevices(since time.Time, limit int) ([]db.DeviceTraffic, error) {
+	totals := []db.DeviceTraffic{}
+	err := r.db.Model(&db.DestinationStats{}).
+		Select("device_id, user_id, SUM(bytes_sent) AS bytes_sent, SUM(bytes_received) AS bytes_received, SUM(connections) AS connections").
+		Where("reported_at >= ?", since).
+		Group("device_id, user_id").
+		Order("SUM(bytes_sent) + SUM(bytes_received) DESC").
+		Limit(limit).
+		Scan(&totals).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+	return totals, nil
+}
+
+func (r *gormMetricsRepo) TenantSummaries(since time.Time) ([]db.TenantSummary, error) {
+	var users []db.User
+	if err := r.db.Select("id, username").Order("id").Find(&users).Error; err != nil {
+		return nil, translate(err)
+	}
+
+	var devices []struct {
+		UserID uint
+		Status string
+		Count  int64
+	}
+	if err := r.db.Model(&db.DeviceStatus{}).Select("user_id, status, COUNT(*) AS count").Group("user_id, status").Scan(&devices).Error; err != nil {
+		return nil, translate(err)
+	}
+	var apps []struct {
+		UserID uint
+		Count  int64
+	}
+	if err := r.db.Model(&db.App{}).Select("user_id, COUNT(*) AS count").Group("user_id").Scan(&apps).Error; err != nil {
+		return nil, translate(err)
+	}
+	var traffic []struct {
+		UserID        uint
+		BytesSent     uint64
+		BytesReceived uint64
+		Connections   uint64
+	}
+	err := r.db.Model(&db.DestinationStats{}).
+		Select("user_id, SUM(bytes_sent) AS bytes_sent, SUM(bytes_received) AS bytes_received, SUM(connections) AS connections").
+		Where("reported_at >= ?", since).
+		Group("user_id").
+		Scan(&traffic).Error
+	if err != nil {
+		return nil, translate(err)
+	}
+
+	summaries := make([]db.TenantSummary, len(users))
+	index := make(map[uint]*db.TenantSummary, len(users))
+	for i, user := range users {
+		summaries[i] = db.TenantSummary{UserID: user.ID, Username: user.Username}
+		index[user.ID] = &summaries[i]
+	}
+	for _, row := range devices {
+		if summary, ok := index[row.UserID]; ok {
+			summary.Devices += row.Count
+			if row.Status == "online" {
+				summary.OnlineDevices += row.Count
+			}
+		}
+	}
+	for _, row := range apps {
+		if summary, ok := index[row.UserID]; ok {
+			summary.Apps = row.Count
+		}
+	}
+	for _, row := range traffic {
+		if summary, ok := index[row.UserID]; ok {
+			summary.BytesSent = row.BytesSent
+			summary.BytesReceived = row.BytesReceived
+			summary.Connections = row.Connections
+		}
+	}
+	return summaries, nil
+}
+
+// gormPunchStatRepo 基于 GORM 的打洞结果统计仓库
+type gormPunchStatRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormPunchStatRepo) Add(localNAT, remoteNAT string, attempts, successes uint64) error {
+	stat := db.PunchStat{
+		LocalNAT:  localNAT,
+		RemoteNAT: remoteNAT,
+		Attempts:  attempts,
+		Successes: successes,
+		UpdatedAt: time.Now(),
+	}
+	return translate(r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "local_nat"}, {Name: "remote_nat"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"attempts":   gorm.Expr("punch_stats.attempts + ?", attempts),
+			"successes":  gorm.Expr("punch_stats.successes + ?", successes),
+			"updated_at": stat.UpdatedAt,
+		}),
+	}).Create(&stat).Error)
+}
+
+func (r *gormPunchStatRepo) List() ([]db.PunchStat, error) {
+	var stats []db.PunchStat
+	if err := r.db.Order("local_nat, remote_nat").Find(&stats).Error; err != nil {
+		return nil, translate(err)
+	}
+	return stats, nil
+}
+
+// gormBanRepo 基于 GORM 的封禁仓库
+type gormBanRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormBanRepo) Create(ban *db.RelayBan) error {
+	return translate(r.db.Create(ban).Error)
+}
+
+func (r *gormBanRepo) GetByID(id uint) (*db.RelayBan, error) {
+	var ban db.RelayBan
+	if err := r.db.First(&ban, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &ban, nil
+}
+
+func (r *gormBanRepo) List(activeAt time.Time) ([]db.RelayBan, error) {
+	query := r.db.Order("id DESC")
+	if !activeAt.IsZero() {
+		query = query.Where("expires_at IS NULL OR expires_at = ? OR expires_at > ?", time.Time{}, activeAt)
+	}
+	var bans []db.RelayBan
+	if err := query.Find(&bans).Error; err != nil {
+		return nil, translate(err)
+	}
+	return bans, nil
+}
+
+func (r *gormBanRepo) Delete(id uint) error {
+	return translate(r.db.Delete(&db.RelayBan{}, id).Error)
+}
+
+// gormAbuseReportRepo 基于 GORM 的滥用举报仓库
+type gormAbuseReportRepo struct {
+	db *gorm.DB
+}
+
+func (r *gormAbuseReportRepo) Create(report *db.AbuseReport) error {
+	return translate(r.db.Create(report).Error)
+}
+
+func (r *gormAbuseReportRepo) GetByID(id uint) (*db.AbuseReport, error) {
+	var report db.AbuseReport
+	if err := r.db.First(&report, id).Error; err != nil {
+		return nil, translate(err)
+	}
+	return &report, nil
+}
+
+func (r *gormAbuseReportRepo) List(status string, limit int) ([]db.AbuseReport, error) {
+	query := r.db.Order("id DESC")
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if limit > 0 {
+		query = query.Limit(limit)
+	}
+	var reports []db.AbuseReport
+	if err := query.Find(&reports).Error; err != nil {
+		return nil, translate(err)
+	}
+	return reports, nil
+}
+
+func (r *gormAbuseReportRepo) CountByNode(nodeID string, since time.Time) (int64, error) {
+	var count int64
+	if err := r.db.Model(&db.AbuseReport{}).Where("node_id = ? AND status <> ? AND created_at >= ?", nodeID, db.ReportDismissed, since).Count(&count).Error; err != nil {
+		return 0, translate(err)
+	}
+	return count, nil
+}
+
+func (r *gormAbuseReportRepo) UpdateFields(report *db.AbuseReport, updates map[string]interface{}) error {
+	return updateFields(r.db, report, updates)
+}
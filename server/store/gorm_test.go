@@ -0,0 +1,36 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// uniqueViolation 模拟数据库驱动返回的唯一约束冲突
+type uniqueViolation struct {
+	Code string
+}
+
+func (e *uniqueViolation) Error() string {
+	return "duplicate key value violates unique constraint"
+}
+
+func TestTranslate(t *testing.T) {
+	// 并发创建时两个事务的端口检查都会通过，后插入的记录违反唯一索引，
+	// 驱动错误经 TranslateError 转换后应返回 ErrDuplicate，由服务转换为端口冲突
+	err := postgres.Dialector{}.Translate(&uniqueViolation{Code: "23505"})
+	if !IsDuplicate(translate(fmt.Errorf("创建失败: %w", err))) {
+		t.Fatalf("唯一约束冲突应转换为 ErrDuplicate: %v", err)
+	}
+
+	if !IsNotFound(translate(gorm.ErrRecordNotFound)) {
+		t.Fatal("记录不存在应转换为 ErrNotFound")
+	}
+	other := errors.New("连接已断开")
+	if translate(other) != other {
+		t.Fatal("其他错误应原样返回")
+	}
+}
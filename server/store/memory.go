@@ -0,0 +1,1935 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// NewMemoryStore 创建内存仓库集合，数据不会持久化，用于单元测试和演示环境
+func NewMemoryStore() *Store {
+	m := &memoryDB{
+		users:       make(map[uint]db.User),
+		totps:       make(map[uint]db.TOTP),
+		invitations: make(map[uint]db.Invitation),
+		apiKeys:     make(map[uint]db.APIKey),
+		devices:     make(map[uint]db.Device),
+		filters:     make(map[uint]db.DeviceFilter),
+		certs:       make(map[uint]db.DeviceCertificate),
+		observers:   make(map[uint]db.ObserverLink),
+		aliases:     make(map[uint]db.PeerAlias),
+		bans:        make(map[uint]db.RelayBan),
+		reports:     make(map[uint]db.AbuseReport),
+		apps:        make(map[uint]db.App),
+		forwards:    make(map[uint]db.Forward),
+		connections: make(map[uint]db.Connection),
+		alertRules:  make(map[uint]db.AlertRule),
+		alertEvents: make(map[uint]db.AlertEvent),
+		routes:      make(map[uint]db.Route),
+		schedules:   make(map[uint]db.SpeedTestSchedule),
+		appVersions: make(map[uint]uint),
+	}
+	return &Store{
+		Users:       &memoryUserRepo{m},
+		TOTPs:       &memoryTOTPRepo{m},
+		Invitations: &memoryInvitationRepo{m},
+		APIKeys:     &memoryAPIKeyRepo{m},
+		Devices:     &memoryDeviceRepo{m},
+		Filters:     &memoryDeviceFilterRepo{m},
+		Certs:       &memoryCertificateRepo{m},
+		Observers:   &memoryObserverLinkRepo{m},
+		Aliases:     &memoryPeerAliasRepo{m},
+		Apps:        &memoryAppRepo{m},
+		Forwards:    &memoryForwardRepo{m},
+		Connections: &memoryConnectionRepo{m},
+		Stats:       &memoryStatsRepo{m},
+		Alerts:      &memoryAlertRepo{m},
+		Routes:      &memoryRouteRepo{m},
+		SpeedTests:  &memorySpeedTestRepo{m},
+		Exports:     &memoryExportRepo{m},
+		Metrics:     &memoryMetricsRepo{m},
+		Punches:     &memoryPunchStatRepo{m},
+		Bans:        &memoryBanRepo{m},
+		Reports:     &memoryAbuseReportRepo{m},
+	}
+}
+
+// memoryDB 内存数据，所有仓库共享同一把锁。仓库保存和返回的都是副本
+type memoryDB struct {
+	users        map[uint]db.User
+	totps        map[uint]db.TOTP
+	invitations  map[uint]db.Invitation
+	apiKeys      map[uint]db.APIKey
+	devices      map[uint]db.Device
+	filters      map[uint]db.DeviceFilter
+	certs        map[uint]db.DeviceCertificate
+	observers    map[uint]db.ObserverLink
+	aliases      map[uint]db.PeerAlias
+	bans         map[uint]db.RelayBan
+	reports      map[uint]db.AbuseReport
+	apps         map[uint]db.App
+	forwards     map[uint]db.Forward
+	connections  map[uint]db.Connection
+	alertRules   map[uint]db.AlertRule
+	alertEvents  map[uint]db.AlertEvent
+	routes       map[uint]db.Route
+	schedules    map[uint]db.SpeedTestSchedule
+	results      []db.SpeedTestResult
+	events       []db.DeviceEvent
+	traces       []db.ConnectionTrace
+	stats        []db.Stats
+	destinations []db.DestinationStats
+	punches      []db.PunchStat
+	deletedApps  []db.App      // 已删除的应用，增量同步时返回
+	appVersions  map[uint]uint // 各设备的应用配置版本号
+	nextID       uint
+	mu           sync.Mutex
+}
+
+// newModel 分配 ID 并设置创建时间。调用方需持有锁
+func (m *memoryDB) newModel(model *gorm.Model) {
+	m.nextID++
+	now := time.Now()
+	model.ID = m.nextID
+	model.CreatedAt = now
+	model.UpdatedAt = now
+}
+
+// schemaCache 解析模型结构的缓存
+var schemaCache = &sync.Map{}
+
+// applyUpdates 按列名将 updates 写入 model，与 GORM 的 Updates 使用相同的列名映射
+func applyUpdates(model interface{}, updates map[string]interface{}) error {
+	s, err := schema.Parse(model, schemaCache, schema.NamingStrategy{})
+	if err != nil {
+		return err
+	}
+
+	value := reflect.ValueOf(model).Elem()
+	for column, v := range updates {
+		// 修订号等 SQL 表达式由仓库自行处理
+		if _, ok := v.(clause.Expression); ok {
+			continue
+		}
+		field := s.LookUpField(column)
+		if field == nil {
+			return fmt.Errorf("未知的字段: %s", column)
+		}
+		if err := field.Set(context.Background(), value, v); err != nil {
+			return fmt.Errorf("设置字段 %s 失败: %w", column, err)
+		}
+	}
+	return nil
+}
+
+// memoryUserRepo 内存用户仓库
+type memoryUserRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryUserRepo) Create(user *db.User) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, u := range r.m.users {
+		if u.Username == user.Username || (user.Email != "" && u.Email == user.Email) {
+			return ErrDuplicate
+		}
+	}
+	r.m.newModel(&user.Model)
+	r.m.users[user.ID] = *user
+	return nil
+}
+
+func (r *memoryUserRepo) GetByID(id uint) (*db.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	user, ok := r.m.users[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &user, nil
+}
+
+func (r *memoryUserRepo) GetByUsername(username string) (*db.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, user := range r.m.users {
+		if user.Username == username {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryUserRepo) GetByEmail(email string) (*db.User, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, user := range r.m.users {
+		if user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryUserRepo) UpdateFields(user *db.User, updates map[string]interface{}) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	current, ok := r.m.users[user.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := applyUpdates(&current, updates); err != nil {
+		return err
+	}
+	for id, u := range r.m.users {
+		if id != current.ID && (u.Username == current.Username || (current.Email != "" && u.Email == current.Email)) {
+			return ErrDuplicate
+		}
+	}
+	current.UpdatedAt = time.Now()
+	r.m.users[current.ID] = current
+	*user = current
+	return nil
+}
+
+func (r *memoryUserRepo) Delete(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	delete(r.m.users, id)
+	return nil
+}
+
+// memoryTOTPRepo 内存双因素认证仓库
+type memoryTOTPRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryTOTPRepo) Create(totp *db.TOTP) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, t := range r.m.totps {
+		if t.UserID == totp.UserID {
+			return ErrDuplicate
+		}
+	}
+	r.m.newModel(&totp.Model)
+	r.m.totps[totp.ID] = *totp
+	return nil
+}
+
+func (r *memoryTOTPRepo) GetByUser(userID uint) (*db.TOTP, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, totp := range r.m.totps {
+		if totp.UserID == userID {
+			return &totp, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryTOTPRepo) UpdateFields(totp *db.TOTP, updates map[string]interface{}) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	current, ok := r.m.totps[totp.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := applyUpdates(&current, updates); err != nil {
+		return err
+	}
+	current.UpdatedAt = time.Now()
+	r.m.totps[current.ID] = current
+	*totp = current
+	return nil
+}
+
+func (r *memoryTOTPRepo) Delete(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	delete(r.m.totps, id)
+	return nil
+}
+
+// memoryAPIKeyRepo 内存 API 密钥仓库
+type memoryAPIKeyRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryAPIKeyRepo) Create(key *db.APIKey) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, k := range r.m.apiKeys {
+		if k.KeyHash == key.KeyHash {
+			return ErrDuplicate
+		}
+	}
+	r.m.newModel(&key.Model)
+	r.m.apiKeys[key.ID] = *key
+	return nil
+}
+
+func (r *memoryAPIKeyRepo) GetByKeyHash(keyHash string) (*db.APIKey, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, k := range r.m.apiKeys {
+		if k.KeyHash == keyHash {
+			return &k, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryAPIKeyRepo) GetByID(id uint) (*db.APIKey, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	key, ok := r.m.apiKeys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &key, nil
+}
+
+func (r *memoryAPIKeyRepo) ListByUser(userID uint) ([]db.APIKey, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	keys := make([]db.APIKey, 0)
+	for _, k := range r.m.apiKeys {
+		if k.UserID == userID {
+			keys = append(keys, k)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID > keys[j].ID })
+	return keys, nil
+}
+
+func (r *memoryAPIKeyRepo) Delete(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	delete(r.m.apiKeys, id)
+	return nil
+}
+
+// memoryDeviceFilterRepo 内存设备筛选条件仓库
+type memoryDeviceFilterRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryDeviceFilterRepo) Create(filter *db.DeviceFilter) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, f := range r.m.filters {
+		if f.UserID == filter.UserID && f.Name == filter.Name {
+			return ErrDuplicate
+		}
+	}
+	r.m.newModel(&filter.Model)
+	r.m.filters[filter.ID] = *filter
+	return nil
+}
+
+func (r *memoryDeviceFilterRepo) GetByID(id uint) (*db.DeviceFilter, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	filter, ok := r.m.filters[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &filter, nil
+}
+
+func (r *memoryDeviceFilterRepo) ListByUser(userID uint) ([]db.DeviceFilter, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	filters := make([]db.DeviceFilter, 0)
+	for _, filter := range r.m.filters {
+		if filter.UserID == userID {
+			filters = append(filters, filter)
+		}
+	}
+	sort.Slice(filters, func(i, j int) bool { return filters[i].Name < filters[j].Name })
+	return filters, nil
+}
+
+func (r *memoryDeviceFilterRepo) Delete(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	delete(r.m.filters, id)
+	return nil
+}
+
+// memoryCertificateRepo 内存设备证书仓库
+type memoryCertificateRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryCertificateRepo) Create(cert *db.DeviceCertificate) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, c := range r.m.certs {
+		if c.Serial == cert.Serial {
+			return ErrDuplicate
+		}
+	}
+	r.m.newModel(&cert.Model)
+	r.m.certs[cert.ID] = *cert
+	return nil
+}
+
+func (r *memoryCertificateRepo) GetBySerial(serial string) (*db.DeviceCertificate, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, c := range r.m.certs {
+		if c.Serial == serial {
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryCertificateRepo) ListByDevice(deviceID uint) ([]db.DeviceCertificate, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	certs := make([]db.DeviceCertificate, 0)
+	for _, c := range r.m.certs {
+		if c.DeviceID == deviceID {
+			certs = append(certs, c)
+		}
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].ID > certs[j].ID })
+	return certs, nil
+}
+
+func (r *memoryCertificateRepo) ListRevoked(after time.Time) ([]db.DeviceCertificate, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	certs := make([]db.DeviceCertificate, 0)
+	for _, c := range r.m.certs {
+		if c.RevokedAt != nil && c.NotAfter.After(after) {
+			certs = append(certs, c)
+		}
+	}
+	sort.Slice(certs, func(i, j int) bool { return certs[i].RevokedAt.Before(*certs[j].RevokedAt) })
+	return certs, nil
+}
+
+func (r *memoryCertificateRepo) RevokeByDevice(deviceID uint, except, reason string, at time.Time) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var revoked int64
+	for id, c := range r.m.certs {
+		if c.DeviceID != deviceID || c.Serial == except || c.RevokedAt != nil {
+			continue
+		}
+		revokedAt := at
+		c.RevokedAt = &revokedAt
+		c.RevokeReason = reason
+		c.UpdatedAt = at
+		r.m.certs[id] = c
+		revoked++
+	}
+	return revoked, nil
+}
+
+// memoryObserverLinkRepo 内存设备观察链接仓库
+type memoryObserverLinkRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryObserverLinkRepo) Create(link *db.ObserverLink) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, l := range r.m.observers {
+		if l.TokenHash == link.TokenHash {
+			return ErrDuplicate
+		}
+	}
+	r.m.newModel(&link.Model)
+	r.m.observers[link.ID] = *link
+	return nil
+}
+
+func (r *memoryObserverLinkRepo) GetByTokenHash(tokenHash string) (*db.ObserverLink, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, l := range r.m.observers {
+		if l.TokenHash == tokenHash {
+			return &l, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryObserverLinkRepo) GetByID(id uint) (*db.ObserverLink, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	link, ok := r.m.observers[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &link, nil
+}
+
+func (r *memoryObserverLinkRepo) ListByDevice(deviceID uint) ([]db.ObserverLink, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	links := make([]db.ObserverLink, 0)
+	for _, l := range r.m.observers {
+		if l.DeviceID == deviceID {
+			links = append(links, l)
+		}
+	}
+	sort.Slice(links, func(i, j int) bool { return links[i].ID > links[j].ID })
+	return links, nil
+}
+
+func (r *memoryObserverLinkRepo) Delete(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	delete(r.m.observers, id)
+	return nil
+}
+
+// memoryPeerAliasRepo 内存对等节点别名仓库
+type memoryPeerAliasRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryPeerAliasRepo) Create(alias *db.PeerAlias) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, a := range r.m.aliases {
+		if a.UserID == alias.UserID && a.Name == alias.Name {
+			return ErrDuplicate
+		}
+	}
+	r.m.newModel(&alias.Model)
+	r.m.aliases[alias.ID] = *alias
+	return nil
+}
+
+func (r *memoryPeerAliasRepo) GetByName(userID uint, name string) (*db.PeerAlias, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, a := range r.m.aliases {
+		if a.UserID == userID && a.Name == name {
+			return &a, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryPeerAliasRepo) ListByUser(userID uint) ([]db.PeerAlias, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	aliases := make([]db.PeerAlias, 0)
+	for _, a := range r.m.aliases {
+		if a.UserID == userID {
+			aliases = append(aliases, a)
+		}
+	}
+	sort.Slice(aliases, func(i, j int) bool { return aliases[i].Name < aliases[j].Name })
+	return aliases, nil
+}
+
+func (r *memoryPeerAliasRepo) Delete(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	delete(r.m.aliases, id)
+	return nil
+}
+
+// memoryInvitationRepo 内存注册邀请仓库
+type memoryInvitationRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryInvitationRepo) Create(inv *db.Invitation) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, i := range r.m.invitations {
+		if i.CodeHash == inv.CodeHash {
+			return ErrDuplicate
+		}
+	}
+	r.m.newModel(&inv.Model)
+	r.m.invitations[inv.ID] = *inv
+	return nil
+}
+
+func (r *memoryInvitationRepo) GetByCodeHash(codeHash string) (*db.Invitation, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, inv := range r.m.invitations {
+		if inv.CodeHash == codeHash {
+			return &inv, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryInvitationRepo) List() ([]db.Invitation, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	invitations := make([]db.Invitation, 0, len(r.m.invitations))
+	for _, inv := range r.m.invitations {
+		invitations = append(invitations, inv)
+	}
+	sort.Slice(invitations, func(i, j int) bool { return invitations[i].ID > invitations[j].ID })
+	return invitations, nil
+}
+
+func (r *memoryInvitationRepo) Redeem(inv *db.Invitation) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	current, ok := r.m.invitations[inv.ID]
+	if !ok || current.UsedCount >= current.MaxUses {
+		return ErrNotFound
+	}
+	current.UsedCount++
+	current.UpdatedAt = time.Now()
+	r.m.invitations[current.ID] = current
+	*inv = current
+	return nil
+}
+
+func (r *memoryInvitationRepo) Delete(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	delete(r.m.invitations, id)
+	return nil
+}
+
+// memoryDeviceRepo 内存设备仓库
+type memoryDeviceRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryDeviceRepo) Create(device *db.Device) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, d := range r.m.devices {
+		if d.NodeID == device.NodeID {
+			return ErrDuplicate
+		}
+	}
+	r.m.newModel(&device.Model)
+	if device.Revision == 0 {
+		device.Revision = 1
+	}
+	r.m.devices[device.ID] = *device
+	return nil
+}
+
+func (r *memoryDeviceRepo) GetByID(id uint) (*db.Device, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	device, ok := r.m.devices[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &device, nil
+}
+
+func (r *memoryDeviceRepo) GetByNodeID(nodeID string) (*db.Device, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for _, device := range r.m.devices {
+		if device.NodeID == nodeID {
+			return &device, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (r *memoryDeviceRepo) ListByUser(userID uint) ([]db.Device, error) {
+	return r.list(func(d *db.Device) bool { return d.UserID == userID }), nil
+}
+
+func (r *memoryDeviceRepo) ListByStatus(status string) ([]db.Device, error) {
+	return r.list(func(d *db.Device) bool { return d.Status == status }), nil
+}
+
+func (r *memoryDeviceRepo) ListVersions() ([]string, error) {
+	devices := r.list(func(*db.Device) bool { return true })
+	versions := make([]string, 0, len(devices))
+	for _, device := range devices {
+		versions = append(versions, device.Version)
+	}
+	return versions, nil
+}
+
+func (r *memoryDeviceRepo) ListStatuses(userID uint, status string) ([]db.DeviceStatus, error) {
+	devices := r.list(func(d *db.Device) bool { return d.UserID == userID && (status == "" || d.Status == status) })
+	statuses := make([]db.DeviceStatus, 0, len(devices))
+	for i := range devices {
+		statuses = append(statuses, db.StatusOf(&devices[i]))
+	}
+	return statuses, nil
+}
+
+// list 按 ID 顺序筛选设备
+func (r *memoryDeviceRepo) list(match func(*db.Device) bool) []db.Device {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	devices := []db.Device{}
+	for _, device := range r.m.devices {
+		if match(&device) {
+			devices = append(devices, device)
+		}
+	}
+	sort.Slice(devices, func(i, j int) bool { return devices[i].ID < devices[j].ID })
+	return devices
+}
+
+func (r *memoryDeviceRepo) Update(device *db.Device, revision uint, updates map[string]interface{}) error {
+	return r.update(device, revision, true, updates)
+}
+
+func (r *memoryDeviceRepo) UpdateFields(device *db.Device, updates map[string]interface{}) error {
+	return r.update(device, 0, false, updates)
+}
+
+func (r *memoryDeviceRepo) update(device *db.Device, revision uint, bump bool, updates map[string]interface{}) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+	return r.updateLocked(device, revision, bump, updates)
+}
+
+// updateLocked 更新设备，调用方需持有锁
+func (r *memoryDeviceRepo) updateLocked(device *db.Device, revision uint, bump bool, updates map[string]interface{}) error {
+	current, ok := r.m.devices[device.ID]
+	if !ok || (revision != 0 && current.Revision != revision) {
+		return ErrRevisionConflict
+	}
+	if err := applyUpdates(&current, updates); err != nil {
+		return err
+	}
+	for id, d := range r.m.devices {
+		if id != current.ID && d.NodeID == current.NodeID {
+			return ErrDuplicate
+		}
+	}
+	if bump {
+		current.Revision++
+	}
+	// 只更新运行状态时与数据库实现一致，不改变设备的更新时间
+	if _, inventory := db.SplitDeviceUpdates(updates); len(inventory) > 0 || bump {
+		current.UpdatedAt = time.Now()
+	}
+	r.m.devices[current.ID] = current
+	*device = current
+	return nil
+}
+
+func (r *memoryDeviceRepo) SaveReport(device *db.Device, updates map[string]interface{}, stats []db.Stats, conns []db.Connection, destinations []db.DestinationStats) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	// 设备更新失败时不写入统计和连接记录
+	if err := r.updateLocked(device, 0, false, updates); err != nil {
+		return err
+	}
+
+	for i := range stats {
+		r.m.newModel(&stats[i].Model)
+		r.m.stats = append(r.m.stats, stats[i])
+	}
+	for i := range destinations {
+		r.m.newModel(&destinations[i].Model)
+		r.m.destinations = append(r.m.destinations, destinations[i])
+	}
+	for i := range conns {
+		conn := &conns[i]
+		for _, existing := range r.m.connections {
+			if existing.SourceDeviceID == conn.SourceDeviceID && existing.TargetDeviceID == conn.TargetDeviceID && existing.Type == conn.Type {
+				conn.Model = existing.Model
+				conn.UpdatedAt = time.Now()
+				break
+			}
+		}
+		if conn.ID == 0 {
+			r.m.newModel(&conn.Model)
+		}
+		r.m.connections[conn.ID] = *conn
+	}
+	return nil
+}
+
+func (r *memoryDeviceRepo) Delete(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	delete(r.m.devices, id)
+	return nil
+}
+
+func (r *memoryDeviceRepo) CreateEvents(events []db.DeviceEvent) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	for i := range events {
+		r.m.newModel(&events[i].Model)
+		r.m.events = append(r.m.events, events[i])
+	}
+	return nil
+}
+
+func (r *memoryDeviceRepo) ListEvents(deviceID uint, limit int) ([]db.DeviceEvent, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	events := []db.DeviceEvent{}
+	for _, event := range r.m.events {
+		if event.DeviceID == deviceID {
+			events = append(events, event)
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].OccurredAt.After(events[j].OccurredAt) })
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+func (r *memoryDeviceRepo) CountEvents(deviceID uint, eventType string, since time.Time) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var count int64
+	for _, event := range r.m.events {
+		if event.DeviceID == deviceID && event.Type == eventType && !event.OccurredAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memoryDeviceRepo) CreateTrace(trace *db.ConnectionTrace) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.m.newModel(&trace.Model)
+	r.m.traces = append(r.m.traces, *trace)
+	return nil
+}
+
+func (r *memoryDeviceRepo) ListTraces(deviceID uint, peerID string, limit int) ([]db.ConnectionTrace, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	traces := []db.ConnectionTrace{}
+	for _, trace := range r.m.traces {
+		if trace.DeviceID == deviceID && (peerID == "" || trace.PeerID == peerID) {
+			traces = append(traces, trace)
+		}
+	}
+	sort.SliceStable(traces, func(i, j int) bool { return traces[i].StartedAt.After(traces[j].StartedAt) })
+	if limit > 0 && len(traces) > limit {
+		traces = traces[:limit]
+	}
+	return traces, nil
+}
+
+// memoryAppRepo 内存应用仓库
+type memoryAppRepo struct {
+	m *memoryDB
+}
+
+// portInUse 检查设备上的协议和源端口是否已被其他应用占用。调用方需持有锁
+func (r *memoryAppRepo) portInUse(app *db.App) bool {
+	for id, a := range r.m.apps {
+		if id != app.ID && a.DeviceID == app.DeviceID && a.Protocol == app.Protocol && a.SrcPort == app.SrcPort {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *memoryAppRepo) Create(app *db.App) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if r.portInUse(app) {
+		return ErrDuplicate
+	}
+	r.m.newModel(&app.Model)
+	if app.Revision == 0 {
+		app.Revision = 1
+	}
+	app.ConfigVersion = r.bumpVersion(app.DeviceID)
+	r.m.apps[app.ID] = *app
+	return nil
+}
+
+func (r *memoryAppRepo) GetByID(id uint) (*db.App, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	app, ok := r.m.apps[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &app, nil
+}
+
+func (r *memoryAppRepo) ListByUser(userID uint) ([]db.App, error) {
+	return r.list(func(a *db.App) bool { return a.UserID == userID }), nil
+}
+
+func (r *memoryAppRepo) ListByDevice(deviceID uint) ([]db.App, error) {
+	return r.list(func(a *db.App) bool { return a.DeviceID == deviceID }), nil
+}
+
+func (r *memoryAppRepo) CountByDevice(deviceID uint) (int64, error) {
+	return int64(len(r.list(func(a *db.App) bool { return a.DeviceID == deviceID }))), nil
+}
+
+// list 按 ID 顺序筛选应用
+func (r *memoryAppRepo) list(match func(*db.App) bool) []db.App {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	apps := []db.App{}
+	for _, app := range r.m.apps {
+		if match(&app) {
+			apps = append(apps, app)
+		}
+	}
+	sort.Slice(apps, func(i, j int) bool { return apps[i].ID < apps[j].ID })
+	return apps
+}
+
+func (r *memoryAppRepo) Update(app *db.App, revision uint, updates map[string]interface{}) error {
+	return r.update(app, revision, true, updates)
+}
+
+func (r *memoryAppRepo) UpdateFields(app *db.App, updates map[string]interface{}) error {
+	return r.update(app, 0, false, updates)
+}
+
+func (r *memoryAppRepo) update(app *db.App, revision uint, bump bool, updates map[string]interface{}) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	current, ok := r.m.apps[app.ID]
+	if !ok || (revision != 0 && current.Revision != revision) {
+		return ErrRevisionConflict
+	}
+	if err := applyUpdates(&current, updates); err != nil {
+		return err
+	}
+	if r.portInUse(&current) {
+		return ErrDuplicate
+	}
+	if bump {
+		current.Revision++
+	}
+	current.ConfigVersion = r.bumpVersion(current.DeviceID)
+	current.UpdatedAt = time.Now()
+	r.m.apps[current.ID] = current
+	*app = current
+	return nil
+}
+
+func (r *memoryAppRepo) Delete(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	app, ok := r.m.apps[id]
+	if !ok {
+		return nil
+	}
+	app.ConfigVersion = r.bumpVersion(app.DeviceID)
+	app.DeletedAt = gorm.DeletedAt{Time: time.Now(), Valid: true}
+	r.m.deletedApps = append(r.m.deletedApps, app)
+	delete(r.m.apps, id)
+	return nil
+}
+
+func (r *memoryAppRepo) ListChanges(deviceID, since uint) (uint, []db.App, []db.App, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	changed, removed := []db.App{}, []db.App{}
+	for _, app := range r.m.apps {
+		if app.DeviceID == deviceID && app.ConfigVersion > since {
+			changed = append(changed, app)
+		}
+	}
+	for _, app := range r.m.deletedApps {
+		if app.DeviceID == deviceID && app.ConfigVersion > since {
+			removed = append(removed, app)
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].ID < changed[j].ID })
+	return r.m.appVersions[deviceID], changed, removed, nil
+}
+
+// bumpVersion 递增设备的应用配置版本号并返回新的版本号。调用方需持有锁
+func (r *memoryAppRepo) bumpVersion(deviceID uint) uint {
+	r.m.appVersions[deviceID]++
+	return r.m.appVersions[deviceID]
+}
+
+// memoryForwardRepo 内存转发规则仓库
+type memoryForwardRepo struct {
+	m *memoryDB
+}
+
+// portInUse 检查用户的协议和源端口是否已被其他转发规则占用。调用方需持有锁
+func (r *memoryForwardRepo) portInUse(forward *db.Forward) bool {
+	for id, f := range r.m.forwards {
+		if id != forward.ID && f.UserID == forward.UserID && f.Protocol == forward.Protocol && f.SrcPort == forward.SrcPort {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *memoryForwardRepo) Create(forward *db.Forward) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if r.portInUse(forward) {
+		return ErrDuplicate
+	}
+	r.m.newModel(&forward.Model)
+	if forward.Revision == 0 {
+		forward.Revision = 1
+	}
+	r.m.forwards[forward.ID] = *forward
+	return nil
+}
+
+func (r *memoryForwardRepo) GetForUser(userID, id uint) (*db.Forward, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	forward, ok := r.m.forwards[id]
+	if !ok || forward.UserID != userID {
+		return nil, ErrNotFound
+	}
+	return &forward, nil
+}
+
+func (r *memoryForwardRepo) ListByUser(userID uint) ([]db.Forward, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	forwards := []db.Forward{}
+	for _, forward := range r.m.forwards {
+		if forward.UserID == userID {
+			forwards = append(forwards, forward)
+		}
+	}
+	sort.Slice(forwards, func(i, j int) bool { return forwards[i].ID < forwards[j].ID })
+	return forwards, nil
+}
+
+func (r *memoryForwardRepo) Update(forward *db.Forward, revision uint, updates map[string]interface{}) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	current, ok := r.m.forwards[forward.ID]
+	if !ok || (revision != 0 && current.Revision != revision) {
+		return ErrRevisionConflict
+	}
+	if err := applyUpdates(&current, updates); err != nil {
+		return err
+	}
+	if r.portInUse(&current) {
+		return ErrDuplicate
+	}
+	current.Revision++
+	current.UpdatedAt = time.Now()
+	r.m.forwards[current.ID] = current
+	*forward = current
+	return nil
+}
+
+func (r *memoryForwardRepo) Delete(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	delete(r.m.forwards, id)
+	return nil
+}
+
+// memoryConnectionRepo 内存连接记录仓库
+type memoryConnectionRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryConnectionRepo) Create(conn *db.Connection) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.m.newModel(&conn.Model)
+	r.m.connections[conn.ID] = *conn
+	return nil
+}
+
+func (r *memoryConnectionRepo) GetByID(id uint) (*db.Connection, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	conn, ok := r.m.connections[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &conn, nil
+}
+
+func (r *memoryConnectionRepo) UpdateFields(conn *db.Connection, updates map[string]interface{}) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	current, ok := r.m.connections[conn.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := applyUpdates(&current, updates); err != nil {
+		return err
+	}
+	current.UpdatedAt = time.Now()
+	r.m.connections[current.ID] = current
+	*conn = current
+	return nil
+}
+
+func (r *memoryConnectionRepo) CountByType(connType string, deviceIDs []uint, since time.Time) (int64, error) {
+	var count int64
+	r.each(connType, deviceIDs, since, func(*db.Connection) { count++ })
+	return count, nil
+}
+
+func (r *memoryConnectionRepo) TrafficByType(connType string, deviceIDs []uint, since time.Time) (uint64, error) {
+	var total uint64
+	r.each(connType, deviceIDs, since, func(conn *db.Connection) { total += conn.BytesSent + conn.BytesReceived })
+	return total, nil
+}
+
+// each 对 deviceIDs 中的设备作为源、自 since 以来建立的 connType 类型的连接调用 fn
+func (r *memoryConnectionRepo) each(connType string, deviceIDs []uint, since time.Time, fn func(*db.Connection)) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	devices := make(map[uint]bool, len(deviceIDs))
+	for _, id := range deviceIDs {
+		devices[id] = true
+	}
+	for _, conn := range r.m.connections {
+		if conn.Type == connType && devices[conn.SourceDeviceID] && !conn.EstablishedAt.Before(since) {
+			fn(&conn)
+		}
+	}
+}
+
+func (r *memoryConnectionRepo) CountByDevice(deviceID uint) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var count int64
+	for _, conn := range r.m.connections {
+		if conn.SourceDeviceID == deviceID || conn.TargetDeviceID == deviceID {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// memoryStatsRepo 内存流量统计仓库
+type memoryStatsRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryStatsRepo) Create(stats *db.Stats) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.m.newModel(&stats.Model)
+	r.m.stats = append(r.m.stats, *stats)
+	return nil
+}
+
+func (r *memoryStatsRepo) LatestByDevice(deviceID uint) (*db.Stats, error) {
+	return r.latest(func(s *db.Stats) bool { return s.DeviceID == deviceID && s.AppID == 0 && s.ForwardID == 0 })
+}
+
+func (r *memoryStatsRepo) LatestByApp(appID uint) (*db.Stats, error) {
+	return r.latest(func(s *db.Stats) bool { return s.AppID == appID })
+}
+
+func (r *memoryStatsRepo) TopDestinations(appID uint, since time.Time, limit int, orderBy string) ([]db.DestinationTotal, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	totals := []db.DestinationTotal{}
+	index := make(map[[3]string]int)
+	for _, d := range r.m.destinations {
+		if d.AppID != appID || d.ReportedAt.Before(since) {
+			continue
+		}
+		key := [3]string{d.Protocol, d.Host, d.Address}
+		i, ok := index[key]
+		if !ok {
+			i = len(totals)
+			index[key] = i
+			totals = append(totals, db.DestinationTotal{Protocol: d.Protocol, Host: d.Host, Address: d.Address})
+		}
+		totals[i].BytesSent += d.BytesSent
+		totals[i].BytesReceived += d.BytesReceived
+		totals[i].Connections += d.Connections
+	}
+
+	sort.SliceStable(totals, func(i, j int) bool {
+		if orderBy == OrderByConnections {
+			return totals[i].Connections > totals[j].Connections
+		}
+		return totals[i].BytesSent+totals[i].BytesReceived > totals[j].BytesSent+totals[j].BytesReceived
+	})
+	if limit > 0 && len(totals) > limit {
+		totals = totals[:limit]
+	}
+	return totals, nil
+}
+
+// latest 获取最新的匹配记录
+func (r *memoryStatsRepo) latest(match func(*db.Stats) bool) (*db.Stats, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var latest *db.Stats
+	for i := range r.m.stats {
+		if match(&r.m.stats[i]) && (latest == nil || r.m.stats[i].CreatedAt.After(latest.CreatedAt)) {
+			latest = &r.m.stats[i]
+		}
+	}
+	if latest == nil {
+		return nil, ErrNotFound
+	}
+	stats := *latest
+	return &stats, nil
+}
+
+// memoryAlertRepo 内存告警仓库
+type memoryAlertRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryAlertRepo) CreateRule(rule *db.AlertRule) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.m.newModel(&rule.Model)
+	r.m.alertRules[rule.ID] = *rule
+	return nil
+}
+
+func (r *memoryAlertRepo) GetRule(id uint) (*db.AlertRule, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	rule, ok := r.m.alertRules[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &rule, nil
+}
+
+func (r *memoryAlertRepo) ListRules(userID uint) ([]db.AlertRule, error) {
+	return r.listRules(func(rule *db.AlertRule) bool { return rule.UserID == userID }), nil
+}
+
+func (r *memoryAlertRepo) ListEnabledRules() ([]db.AlertRule, error) {
+	return r.listRules(func(rule *db.AlertRule) bool { return rule.Enabled }), nil
+}
+
+// listRules 按 ID 顺序筛选告警规则
+func (r *memoryAlertRepo) listRules(match func(*db.AlertRule) bool) []db.AlertRule {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	rules := []db.AlertRule{}
+	for _, rule := range r.m.alertRules {
+		if match(&rule) {
+			rules = append(rules, rule)
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+	return rules
+}
+
+func (r *memoryAlertRepo) SaveRule(rule *db.AlertRule) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.alertRules[rule.ID]; !ok {
+		return ErrNotFound
+	}
+	rule.UpdatedAt = time.Now()
+	r.m.alertRules[rule.ID] = *rule
+	return nil
+}
+
+func (r *memoryAlertRepo) DeleteRule(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	delete(r.m.alertRules, id)
+	return nil
+}
+
+func (r *memoryAlertRepo) ListFiringEvents(ruleID uint) ([]db.AlertEvent, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	events := []db.AlertEvent{}
+	for _, event := range r.m.alertEvents {
+		if event.RuleID == ruleID && event.Status == db.AlertFiring {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].ID < events[j].ID })
+	return events, nil
+}
+
+func (r *memoryAlertRepo) SaveEvent(event *db.AlertEvent) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if event.ID == 0 {
+		r.m.newModel(&event.Model)
+	} else {
+		event.UpdatedAt = time.Now()
+	}
+	r.m.alertEvents[event.ID] = *event
+	return nil
+}
+
+func (r *memoryAlertRepo) ListEvents(userID uint, status string, limit int) ([]db.AlertEvent, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	events := []db.AlertEvent{}
+	for _, event := range r.m.alertEvents {
+		if event.UserID == userID && (status == "" || event.Status == status) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].FiredAt.After(events[j].FiredAt) })
+	if limit > 0 && len(events) > limit {
+		events = events[:limit]
+	}
+	return events, nil
+}
+
+// memoryRouteRepo 内存子网路由仓库，访问控制列表保存在路由中
+type memoryRouteRepo struct {
+	m *memoryDB
+}
+
+// copyRoute 复制路由及其访问控制列表，调用方修改返回的路由不影响仓库中的数据
+func copyRoute(route db.Route) db.Route {
+	route.ACLs = append([]db.RouteACL(nil), route.ACLs...)
+	return route
+}
+
+func (r *memoryRouteRepo) Create(route *db.Route) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.m.newModel(&route.Model)
+	r.m.routes[route.ID] = copyRoute(*route)
+	return nil
+}
+
+func (r *memoryRouteRepo) GetByID(id uint) (*db.Route, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	route, ok := r.m.routes[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	route = copyRoute(route)
+	return &route, nil
+}
+
+func (r *memoryRouteRepo) ListByUser(userID uint) ([]db.Route, error) {
+	return r.list(func(route *db.Route) bool { return route.UserID == userID }), nil
+}
+
+func (r *memoryRouteRepo) ListByDevice(deviceID uint) ([]db.Route, error) {
+	return r.list(func(route *db.Route) bool { return route.DeviceID == deviceID }), nil
+}
+
+// list 按 ID 顺序筛选路由
+func (r *memoryRouteRepo) list(match func(*db.Route) bool) []db.Route {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	routes := []db.Route{}
+	for _, route := range r.m.routes {
+		if match(&route) {
+			routes = append(routes, copyRoute(route))
+		}
+	}
+	sort.Slice(routes, func(i, j int) bool { return routes[i].ID < routes[j].ID })
+	return routes
+}
+
+func (r *memoryRouteRepo) Save(route *db.Route) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	current, ok := r.m.routes[route.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	saved := copyRoute(*route)
+	saved.ACLs = current.ACLs
+	saved.UpdatedAt = time.Now()
+	r.m.routes[route.ID] = saved
+	route.UpdatedAt = saved.UpdatedAt
+	return nil
+}
+
+func (r *memoryRouteRepo) Delete(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	delete(r.m.routes, id)
+	return nil
+}
+
+func (r *memoryRouteRepo) SetACL(routeID uint, peerDeviceIDs []uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	route, ok := r.m.routes[routeID]
+	if !ok {
+		return ErrNotFound
+	}
+	acls := make([]db.RouteACL, 0, len(peerDeviceIDs))
+	for _, peerID := range peerDeviceIDs {
+		acl := db.RouteACL{RouteID: routeID, PeerDeviceID: peerID}
+		r.m.newModel(&acl.Model)
+		acls = append(acls, acl)
+	}
+	route.ACLs = acls
+	r.m.routes[routeID] = route
+	return nil
+}
+
+// memorySpeedTestRepo 内存测速仓库
+type memorySpeedTestRepo struct {
+	m *memoryDB
+}
+
+func (r *memorySpeedTestRepo) CreateSchedule(schedule *db.SpeedTestSchedule) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.m.newModel(&schedule.Model)
+	r.m.schedules[schedule.ID] = *schedule
+	return nil
+}
+
+func (r *memorySpeedTestRepo) GetSchedule(id uint) (*db.SpeedTestSchedule, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	schedule, ok := r.m.schedules[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &schedule, nil
+}
+
+func (r *memorySpeedTestRepo) ListSchedules(userID uint) ([]db.SpeedTestSchedule, error) {
+	return r.list(func(s *db.SpeedTestSchedule) bool { return s.UserID == userID }), nil
+}
+
+func (r *memorySpeedTestRepo) ListDue(now time.Time) ([]db.SpeedTestSchedule, error) {
+	return r.list(func(s *db.SpeedTestSchedule) bool { return s.Enabled && !s.NextRunAt.After(now) }), nil
+}
+
+// list 按 ID 顺序筛选测速计划
+func (r *memorySpeedTestRepo) list(match func(*db.SpeedTestSchedule) bool) []db.SpeedTestSchedule {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	schedules := []db.SpeedTestSchedule{}
+	for _, schedule := range r.m.schedules {
+		if match(&schedule) {
+			schedules = append(schedules, schedule)
+		}
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].ID < schedules[j].ID })
+	return schedules
+}
+
+func (r *memorySpeedTestRepo) SaveSchedule(schedule *db.SpeedTestSchedule) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	if _, ok := r.m.schedules[schedule.ID]; !ok {
+		return ErrNotFound
+	}
+	schedule.UpdatedAt = time.Now()
+	r.m.schedules[schedule.ID] = *schedule
+	return nil
+}
+
+func (r *memorySpeedTestRepo) UpdateScheduleFields(schedule *db.SpeedTestSchedule, updates map[string]interface{}) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	current, ok := r.m.schedules[schedule.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := applyUpdates(&current, updates); err != nil {
+		return err
+	}
+	current.UpdatedAt = time.Now()
+	r.m.schedules[current.ID] = current
+	*schedule = current
+	return nil
+}
+
+func (r *memorySpeedTestRepo) DeleteSchedule(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	delete(r.m.schedules, id)
+	return nil
+}
+
+func (r *memorySpeedTestRepo) CreateResult(result *db.SpeedTestResult) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.m.newModel(&result.Model)
+	r.m.results = append(r.m.results, *result)
+	return nil
+}
+
+func (r *memorySpeedTestRepo) ListResults(scheduleID uint, since time.Time, limit int) ([]db.SpeedTestResult, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	results := []db.SpeedTestResult{}
+	for i := len(r.m.results) - 1; i >= 0; i-- {
+		result := r.m.results[i]
+		if result.ScheduleID == scheduleID && !result.CreatedAt.Before(since) {
+			results = append(results, result)
+		}
+		if limit > 0 && len(results) == limit {
+			break
+		}
+	}
+	return results, nil
+}
+
+// memoryExportRepo 内存数据导出仓库，先复制匹配的记录，调用 fn 时不持有锁
+type memoryExportRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryExportRepo) EachDevice(userID uint, since time.Time, fn func(device *db.Device) error) error {
+	devices := (&memoryDeviceRepo{r.m}).list(func(d *db.Device) bool {
+		return d.UserID == userID && !d.CreatedAt.Before(since)
+	})
+	for i := range devices {
+		if err := fn(&devices[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *memoryExportRepo) EachForward(userID uint, since time.Time, fn func(forward *db.Forward) error) error {
+	forwards, _ := (&memoryForwardRepo{r.m}).ListByUser(userID)
+	for i := range forwards {
+		if forwards[i].CreatedAt.Before(since) {
+			continue
+		}
+		if err := fn(&forwards[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *memoryExportRepo) EachConnection(userID uint, since time.Time, fn func(conn *db.Connection) error) error {
+	r.m.mu.Lock()
+	conns := []db.Connection{}
+	for _, conn := range r.m.connections {
+		source, target := r.m.devices[conn.SourceDeviceID], r.m.devices[conn.TargetDeviceID]
+		if (source.UserID == userID || target.UserID == userID) && !conn.EstablishedAt.Before(since) {
+			conns = append(conns, conn)
+		}
+	}
+	r.m.mu.Unlock()
+
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	for i := range conns {
+		if err := fn(&conns[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memoryMetricsRepo 内存全局统计仓库
+type memoryMetricsRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryMetricsRepo) CountUsers() (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	return int64(len(r.m.users)), nil
+}
+
+func (r *memoryMetricsRepo) CountDevicesByStatus() (map[string]int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	counts := make(map[string]int64)
+	for _, device := range r.m.devices {
+		counts[device.Status]++
+	}
+	return counts, nil
+}
+
+func (r *memoryMetricsRepo) Traffic(since time.Time) (*db.TrafficTotal, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var total db.TrafficTotal
+	for _, d := range r.m.destinations {
+		if d.ReportedAt.Before(since) {
+			continue
+		}
+		total.BytesSent += d.BytesSent
+		total.BytesReceived += d.BytesReceived
+		total.Connections += d.Connections
+	}
+	return &total, nil
+}
+
+func (r *memoryMetricsRepo) RelayTraffic(since time.Time) (*db.TrafficTotal, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var total db.TrafficTotal
+	for _, conn := range r.m.connections {
+		if conn.Type != ConnectionTypeRelay || conn.LastActiveAt.Before(since) {
+			continue
+		}
+		total.BytesSent += conn.BytesSent
+		total.BytesReceived += conn.BytesReceived
+		total.Connections++
+	}
+	return &total, nil
+}
+
+func (r *memoryMetricsRepo) CountTracesByPath(since time.Time) (map[string]int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	counts := make(map[string]int64)
+	for _, trace := range r.m.traces {
+		if !trace.StartedAt.Before(since) {
+			counts[trace.Path]++
+		}
+	}
+	return counts, nil
+}
+
+func (r *memoryMetricsRepo) TopDevices(since time.Time, limit int) ([]db.DeviceTraffic, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	totals := []db.DeviceTraffic{}
+	index := make(map[uint]int)
+	for _, d := range r.m.destinations {
+		if d.ReportedAt.Before(since) {
+			continue
+		}
+		i, ok := index[d.DeviceID]
+		if !ok {
+			i = len(totals)
+			index[d.DeviceID] = i
+			totals = append(totals, db.DeviceTraffic{DeviceID: d.DeviceID, UserID: d.UserID})
+		}
+		totals[i].BytesSent += d.BytesSent
+		totals[i].BytesReceived += d.BytesReceived
+		totals[i].Connections += d.Connections
+	}
+
+	sort.SliceStable(totals, func(i, j int) bool {
+		return totals[i].BytesSent+totals[i].BytesReceived > totals[j].BytesSent+totals[j].BytesReceived
+	})
+	if limit > 0 && len(totals) > limit {
+		totals = totals[:limit]
+	}
+	return totals, nil
+}
+
+func (r *memoryMetricsRepo) TenantSummaries(since time.Time) ([]db.TenantSummary, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	summaries := make([]db.TenantSummary, 0, len(r.m.users))
+	for _, user := range r.m.users {
+		summaries = append(summaries, db.TenantSummary{UserID: user.ID, Username: user.Username})
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].UserID < summaries[j].UserID })
+	index := make(map[uint]*db.TenantSummary, len(summaries))
+	for i := range summaries {
+		index[summaries[i].UserID] = &summaries[i]
+	}
+
+	for _, device := range r.m.devices {
+		if summary, ok := index[device.UserID]; ok {
+			summary.Devices++
+			if device.Status == "online" {
+				summary.OnlineDevices++
+			}
+		}
+	}
+	for _, app := range r.m.apps {
+		if summary, ok := index[app.UserID]; ok {
+			summary.Apps++
+		}
+	}
+	for _, d := range r.m.destinations {
+		if summary, ok := index[d.UserID]; ok && !d.ReportedAt.Before(since) {
+			summary.BytesSent += d.BytesSent
+			summary.BytesReceived += d.BytesReceived
+			summary.Connections += d.Connections
+		}
+	}
+	return summaries, nil
+}
+
+// memoryPunchStatRepo 内存打洞结果统计仓库
+type memoryPunchStatRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryPunchStatRepo) Add(localNAT, remoteNAT string, attempts, successes uint64) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	now := time.Now()
+	for i := range r.m.punches {
+		stat := &r.m.punches[i]
+		if stat.LocalNAT == localNAT && stat.RemoteNAT == remoteNAT {
+			stat.Attempts += attempts
+			stat.Successes += successes
+			stat.UpdatedAt = now
+			return nil
+		}
+	}
+	r.m.nextID++
+	r.m.punches = append(r.m.punches, db.PunchStat{
+		ID:        r.m.nextID,
+		LocalNAT:  localNAT,
+		RemoteNAT: remoteNAT,
+		Attempts:  attempts,
+		Successes: successes,
+		UpdatedAt: now,
+	})
+	return nil
+}
+
+func (r *memoryPunchStatRepo) List() ([]db.PunchStat, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	stats := append([]db.PunchStat(nil), r.m.punches...)
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].LocalNAT != stats[j].LocalNAT {
+			return stats[i].LocalNAT < stats[j].LocalNAT
+		}
+		return stats[i].RemoteNAT < stats[j].RemoteNAT
+	})
+	return stats, nil
+}
+
+// memoryBanRepo 内存封禁仓库
+type memoryBanRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryBanRepo) Create(ban *db.RelayBan) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.m.newModel(&ban.Model)
+	r.m.bans[ban.ID] = *ban
+	return nil
+}
+
+func (r *memoryBanRepo) GetByID(id uint) (*db.RelayBan, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	ban, ok := r.m.bans[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &ban, nil
+}
+
+func (r *memoryBanRepo) List(activeAt time.Time) ([]db.RelayBan, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	bans := make([]db.RelayBan, 0)
+	for _, ban := range r.m.bans {
+		if activeAt.IsZero() || ban.Active(activeAt) {
+			bans = append(bans, ban)
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].ID > bans[j].ID })
+	return bans, nil
+}
+
+func (r *memoryBanRepo) Delete(id uint) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	delete(r.m.bans, id)
+	return nil
+}
+
+// memoryAbuseReportRepo 内存滥用举报仓库
+type memoryAbuseReportRepo struct {
+	m *memoryDB
+}
+
+func (r *memoryAbuseReportRepo) Create(report *db.AbuseReport) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	r.m.newModel(&report.Model)
+	r.m.reports[report.ID] = *report
+	return nil
+}
+
+func (r *memoryAbuseReportRepo) GetByID(id uint) (*db.AbuseReport, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	report, ok := r.m.reports[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &report, nil
+}
+
+func (r *memoryAbuseReportRepo) List(status string, limit int) ([]db.AbuseReport, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	reports := make([]db.AbuseReport, 0)
+	for _, report := range r.m.reports {
+		if status == "" || report.Status == status {
+			reports = append(reports, report)
+		}
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].ID > reports[j].ID })
+	if limit > 0 && len(reports) > limit {
+		reports = reports[:limit]
+	}
+	return reports, nil
+}
+
+func (r *memoryAbuseReportRepo) CountByNode(nodeID string, since time.Time) (int64, error) {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	var count int64
+	for _, report := range r.m.reports {
+		if report.NodeID == nodeID && report.Status != db.ReportDismissed && !report.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *memoryAbuseReportRepo) UpdateFields(report *db.AbuseReport, updates map[string]interface{}) error {
+	r.m.mu.Lock()
+	defer r.m.mu.Unlock()
+
+	current, ok := r.m.reports[report.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := applyUpdates(&current, updates); err != nil {
+		return err
+	}
+	current.UpdatedAt = time.Now()
+	r.m.reports[current.ID] = current
+	*report = current
+	return nil
+}
@@ -0,0 +1,251 @@
+package store
+
+import (
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+)
+
+func TestMemoryDeviceRepo(t *testing.T) {
+	st := NewMemoryStore()
+
+	device := &db.Device{UserID: 1, Name: "nas", NodeID: "node-a"}
+	if err := st.Devices.Create(device); err != nil {
+		t.Fatalf("创建设备失败: %v", err)
+	}
+	if device.ID == 0 || device.Revision != 1 {
+		t.Fatalf("创建后应分配 ID 和初始修订号: %+v", device)
+	}
+
+	if err := st.Devices.Create(&db.Device{UserID: 2, NodeID: "node-a"}); !IsDuplicate(err) {
+		t.Fatalf("重复的节点 ID 应返回 ErrDuplicate: %v", err)
+	}
+
+	// 修订号匹配时更新并递增修订号
+	if err := st.Devices.Update(device, 1, map[string]interface{}{"name": "backup"}); err != nil {
+		t.Fatalf("更新设备失败: %v", err)
+	}
+	if device.Name != "backup" || device.Revision != 2 {
+		t.Fatalf("更新后字段或修订号不正确: %+v", device)
+	}
+
+	// 过期的修订号
+	if err := st.Devices.Update(device, 1, map[string]interface{}{"name": "stale"}); !db.IsRevisionConflict(err) {
+		t.Fatalf("过期的修订号应返回冲突: %v", err)
+	}
+
+	// 状态更新不递增修订号
+	if err := st.Devices.UpdateFields(device, map[string]interface{}{"status": "online"}); err != nil {
+		t.Fatalf("更新状态失败: %v", err)
+	}
+	if device.Status != "online" || device.Revision != 2 {
+		t.Fatalf("状态更新不应递增修订号: %+v", device)
+	}
+
+	found, err := st.Devices.GetByNodeID("node-a")
+	if err != nil || found.Name != "backup" {
+		t.Fatalf("按节点 ID 查询失败: %+v %v", found, err)
+	}
+
+	// 返回的是副本，修改不影响仓库中的数据
+	found.Name = "changed"
+	if again, _ := st.Devices.GetByID(device.ID); again.Name != "backup" {
+		t.Fatalf("仓库数据不应被调用方修改: %s", again.Name)
+	}
+
+	if err := st.Devices.Delete(device.ID); err != nil {
+		t.Fatalf("删除设备失败: %v", err)
+	}
+	if _, err := st.Devices.GetByID(device.ID); !IsNotFound(err) {
+		t.Fatalf("删除后应返回 ErrNotFound: %v", err)
+	}
+}
+
+func TestMemoryDeviceStatuses(t *testing.T) {
+	st := NewMemoryStore()
+
+	nas := &db.Device{UserID: 1, NodeID: "nas", Status: "offline"}
+	laptop := &db.Device{UserID: 1, NodeID: "laptop", Status: "offline"}
+	other := &db.Device{UserID: 2, NodeID: "other", Status: "online"}
+	for _, device := range []*db.Device{nas, laptop, other} {
+		if err := st.Devices.Create(device); err != nil {
+			t.Fatalf("创建设备失败: %v", err)
+		}
+	}
+
+	// 只更新运行状态时不改变设备的更新时间
+	updatedAt := nas.UpdatedAt
+	if err := st.Devices.UpdateFields(nas, map[string]interface{}{"status": "online", "version": "1.2.0"}); err != nil {
+		t.Fatalf("更新状态失败: %v", err)
+	}
+	if !nas.UpdatedAt.Equal(updatedAt) {
+		t.Fatalf("只更新运行状态不应改变设备的更新时间: %v -> %v", updatedAt, nas.UpdatedAt)
+	}
+
+	statuses, err := st.Devices.ListStatuses(1, "online")
+	if err != nil {
+		t.Fatalf("获取状态快照失败: %v", err)
+	}
+	if len(statuses) != 1 || statuses[0].DeviceID != nas.ID || statuses[0].Version != "1.2.0" {
+		t.Fatalf("应只返回用户在线设备的状态快照: %+v", statuses)
+	}
+	if statuses, _ := st.Devices.ListStatuses(1, ""); len(statuses) != 2 {
+		t.Fatalf("不指定状态时应返回用户的所有设备: %+v", statuses)
+	}
+	if statuses, _ := st.ForTenant(2).Devices.ListStatuses(1, ""); len(statuses) != 0 {
+		t.Fatalf("其他租户的状态快照不应可见: %+v", statuses)
+	}
+}
+
+func TestMemoryPortConflicts(t *testing.T) {
+	st := NewMemoryStore()
+
+	app := &db.App{UserID: 1, DeviceID: 1, Protocol: "tcp", SrcPort: 8080}
+	if err := st.Apps.Create(app); err != nil {
+		t.Fatalf("创建应用失败: %v", err)
+	}
+	if err := st.Apps.Create(&db.App{UserID: 1, DeviceID: 1, Protocol: "tcp", SrcPort: 8080}); !IsDuplicate(err) {
+		t.Fatalf("同一设备的端口冲突应返回 ErrDuplicate: %v", err)
+	}
+	if err := st.Apps.Create(&db.App{UserID: 1, DeviceID: 1, Protocol: "udp", SrcPort: 8080}); err != nil {
+		t.Fatalf("不同协议不应冲突: %v", err)
+	}
+
+	other := &db.App{UserID: 1, DeviceID: 1, Protocol: "tcp", SrcPort: 9090}
+	st.Apps.Create(other)
+	if err := st.Apps.Update(other, 0, map[string]interface{}{"src_port": 8080}); !IsDuplicate(err) {
+		t.Fatalf("更新为已占用的端口应返回 ErrDuplicate: %v", err)
+	}
+
+	forward := &db.Forward{UserID: 1, Protocol: "tcp", SrcPort: 2222}
+	if err := st.Forwards.Create(forward); err != nil {
+		t.Fatalf("创建转发规则失败: %v", err)
+	}
+	if err := st.Forwards.Create(&db.Forward{UserID: 1, Protocol: "tcp", SrcPort: 2222}); !IsDuplicate(err) {
+		t.Fatalf("同一用户的端口冲突应返回 ErrDuplicate: %v", err)
+	}
+	if _, err := st.Forwards.GetForUser(2, forward.ID); !IsNotFound(err) {
+		t.Fatalf("不应获取其他用户的转发规则: %v", err)
+	}
+}
+
+func TestMemoryConnectionTraces(t *testing.T) {
+	st := NewMemoryStore()
+
+	base := time.Now()
+	for i, peerID := range []string{"node-b", "node-c", "node-b"} {
+		trace := &db.ConnectionTrace{
+			DeviceID:  1,
+			PeerID:    peerID,
+			Path:      "relay",
+			Attempts:  db.ConnectionSteps{{Method: "holepunch", Error: "打洞超时"}},
+			StartedAt: base.Add(time.Duration(i) * time.Minute),
+		}
+		if err := st.Devices.CreateTrace(trace); err != nil {
+			t.Fatalf("保存连接记录失败: %v", err)
+		}
+	}
+
+	traces, err := st.Devices.ListTraces(1, "node-b", 10)
+	if err != nil {
+		t.Fatalf("查询连接记录失败: %v", err)
+	}
+	if len(traces) != 2 || !traces[0].StartedAt.After(traces[1].StartedAt) {
+		t.Fatalf("应按开始时间倒序返回与 node-b 的记录: %+v", traces)
+	}
+
+	if traces, _ := st.Devices.ListTraces(1, "", 1); len(traces) != 1 || traces[0].PeerID != "node-b" {
+		t.Fatalf("不过滤节点时应返回最近的记录: %+v", traces)
+	}
+	if traces, _ := st.Devices.ListTraces(2, "", 10); len(traces) != 0 {
+		t.Fatalf("其他设备不应有连接记录: %+v", traces)
+	}
+}
+
+func TestMemoryDeviceSaveReport(t *testing.T) {
+	st := NewMemoryStore()
+
+	device := &db.Device{UserID: 1, NodeID: "node-a", Status: "offline"}
+	if err := st.Devices.Create(device); err != nil {
+		t.Fatalf("创建设备失败: %v", err)
+	}
+
+	report := func(bytesSent uint64) error {
+		return st.Devices.SaveReport(device,
+			map[string]interface{}{"status": "online"},
+			[]db.Stats{
+				{UserID: 1, DeviceID: device.ID, AppID: 5, BytesSent: bytesSent},
+				{UserID: 1, DeviceID: device.ID, BytesSent: bytesSent},
+			},
+			[]db.Connection{{SourceDeviceID: device.ID, TargetDeviceID: 9, Type: "p2p", Status: "connected", BytesSent: bytesSent}},
+			nil,
+		)
+	}
+	for _, n := range []uint64{100, 200} {
+		if err := report(n); err != nil {
+			t.Fatalf("保存上报失败: %v", err)
+		}
+	}
+	if device.Status != "online" {
+		t.Fatalf("应更新设备状态: %+v", device)
+	}
+
+	// 资源占用保存在设备的内嵌字段中
+	if err := st.Devices.SaveReport(device, map[string]interface{}{"resource_pressure": "critical", "resource_rejected": uint64(3)}, nil, nil, nil); err != nil {
+		t.Fatalf("保存资源占用失败: %v", err)
+	}
+	if device.Resources.Pressure != "critical" || device.Resources.Rejected != 3 {
+		t.Fatalf("资源占用为 %+v", device.Resources)
+	}
+
+	// 设备整体统计不包含应用的记录
+	stats, err := st.Stats.LatestByDevice(device.ID)
+	if err != nil || stats.AppID != 0 || stats.BytesSent != 200 {
+		t.Fatalf("设备最新统计错误: %+v %v", stats, err)
+	}
+
+	// 同一对等节点和类型的连接记录只保留一条
+	if count, _ := st.Connections.CountByDevice(device.ID); count != 1 {
+		t.Fatalf("连接记录应按对等节点更新，实际 %d 条", count)
+	}
+
+	// 设备更新失败时不写入统计
+	if err := st.Devices.SaveReport(device, map[string]interface{}{"unknown": 1}, []db.Stats{{DeviceID: device.ID, BytesSent: 300}}, nil, nil); err == nil {
+		t.Fatal("未知字段应返回错误")
+	}
+	if stats, _ := st.Stats.LatestByDevice(device.ID); stats.BytesSent != 200 {
+		t.Fatalf("更新失败时不应写入统计: %+v", stats)
+	}
+}
+
+func TestMemoryTopDestinations(t *testing.T) {
+	st := NewMemoryStore()
+
+	device := &db.Device{UserID: 1, NodeID: "node-a"}
+	if err := st.Devices.Create(device); err != nil {
+		t.Fatalf("创建设备失败: %v", err)
+	}
+
+	now := time.Now()
+	destinations := []db.DestinationStats{
+		{AppID: 5, Protocol: "tcp", Host: "web.internal", Address: "10.0.0.1:80", BytesSent: 100, Connections: 1, ReportedAt: now},
+		{AppID: 5, Protocol: "tcp", Host: "web.internal", Address: "10.0.0.2:80", BytesSent: 50, Connections: 3, ReportedAt: now},
+		{AppID: 5, Protocol: "tcp", Host: "web.internal", Address: "10.0.0.2:80", BytesReceived: 10, Connections: 1, ReportedAt: now},
+		// 时间范围之外和其他应用的记录不参与合计
+		{AppID: 5, Protocol: "tcp", Host: "web.internal", Address: "10.0.0.3:80", BytesSent: 1000, ReportedAt: now.Add(-2 * time.Hour)},
+		{AppID: 6, Protocol: "udp", Host: "dns", Address: "10.0.0.4:53", BytesSent: 1000, ReportedAt: now},
+	}
+	if err := st.Devices.SaveReport(device, map[string]interface{}{"status": "online"}, nil, nil, destinations); err != nil {
+		t.Fatalf("保存上报失败: %v", err)
+	}
+
+	totals, err := st.Stats.TopDestinations(5, now.Add(-time.Hour), 10, OrderByBytes)
+	if err != nil || len(totals) != 2 || totals[0].Address != "10.0.0.1:80" || totals[1].BytesSent != 50 || totals[1].Connections != 4 {
+		t.Fatalf("按流量合计错误: %+v %v", totals, err)
+	}
+	totals, _ = st.Stats.TopDestinations(5, now.Add(-time.Hour), 1, OrderByConnections)
+	if len(totals) != 1 || totals[0].Address != "10.0.0.2:80" {
+		t.Fatalf("按连接数合计错误: %+v", totals)
+	}
+}
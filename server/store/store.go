@@ -0,0 +1,344 @@
+// Package store 定义服务端持久化的仓库接口，服务通过接口访问数据，
+// 不直接依赖具体的数据库实现，便于替换存储后端和在单元测试中使用内存实现
+package store
+
+import (
+	"errors"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+)
+
+var (
+	// ErrNotFound 记录不存在
+	ErrNotFound = errors.New("记录不存在")
+	// ErrDuplicate 违反唯一约束，例如节点 ID 或端口已被占用
+	ErrDuplicate = errors.New("记录已存在")
+	// ErrRevisionConflict 记录已被其他请求修改，与 db.ErrRevisionConflict 相同，可使用 db.IsRevisionConflict 判断
+	ErrRevisionConflict = db.ErrRevisionConflict
+)
+
+// IsNotFound 检查错误是否为记录不存在
+func IsNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// IsDuplicate 检查错误是否为唯一约束冲突
+func IsDuplicate(err error) bool {
+	return errors.Is(err, ErrDuplicate)
+}
+
+// UserRepo 用户仓库
+type UserRepo interface {
+	Create(user *db.User) error
+	GetByID(id uint) (*db.User, error)
+	GetByUsername(username string) (*db.User, error)
+	GetByEmail(email string) (*db.User, error)
+	// UpdateFields 按列名更新字段，更新后重新加载 user
+	UpdateFields(user *db.User, updates map[string]interface{}) error
+	Delete(id uint) error
+}
+
+// DeviceRepo 设备仓库
+type DeviceRepo interface {
+	// Create 创建设备，节点 ID 已存在时返回 ErrDuplicate
+	Create(device *db.Device) error
+	GetByID(id uint) (*db.Device, error)
+	GetByNodeID(nodeID string) (*db.Device, error)
+	ListByUser(userID uint) ([]db.Device, error)
+	ListByStatus(status string) ([]db.Device, error)
+	// ListVersions 获取所有设备上报的版本号
+	ListVersions() ([]string, error)
+	// ListStatuses 按设备 ID 排序获取用户设备的状态快照，status 不为空时只返回该状态的设备
+	ListStatuses(userID uint, status string) ([]db.DeviceStatus, error)
+	// Update 在修订号匹配时更新设备并递增修订号，revision 为 0 时不检查修订号。
+	// 运行状态字段写入状态快照，见 db.DeviceStatus
+	Update(device *db.Device, revision uint, updates map[string]interface{}) error
+	// UpdateFields 更新字段但不递增修订号，用于心跳等运行状态
+	UpdateFields(device *db.Device, updates map[string]interface{}) error
+	// SaveReport 在同一事务中更新设备的运行状态字段、写入流量统计和目标地址统计并更新连接记录，
+	// 更新后重新加载 device。连接记录按源设备、目标设备和类型更新，不存在时创建
+	SaveReport(device *db.Device, updates map[string]interface{}, stats []db.Stats, conns []db.Connection, destinations []db.DestinationStats) error
+	Delete(id uint) error
+	CreateEvents(events []db.DeviceEvent) error
+	// ListEvents 按发生时间倒序获取设备最近的事件
+	ListEvents(deviceID uint, limit int) ([]db.DeviceEvent, error)
+	// CountEvents 统计设备自 since 以来指定类型的事件数
+	CountEvents(deviceID uint, eventType string, since time.Time) (int64, error)
+	CreateTrace(trace *db.ConnectionTrace) error
+	// ListTraces 按开始时间倒序获取设备最近的连接记录，peerID 为空时不按对等节点过滤
+	ListTraces(deviceID uint, peerID string, limit int) ([]db.ConnectionTrace, error)
+}
+
+// AppRepo 应用仓库
+type AppRepo interface {
+	// Create 创建应用，同一设备上协议和源端口已被占用时返回 ErrDuplicate
+	Create(app *db.App) error
+	GetByID(id uint) (*db.App, error)
+	ListByUser(userID uint) ([]db.App, error)
+	ListByDevice(deviceID uint) ([]db.App, error)
+	CountByDevice(deviceID uint) (int64, error)
+	// Update 在修订号匹配时更新应用并递增修订号，端口冲突时返回 ErrDuplicate
+	Update(app *db.App, revision uint, updates map[string]interface{}) error
+	// UpdateFields 更新字段但不递增修订号，用于启停等运行状态
+	UpdateFields(app *db.App, updates map[string]interface{}) error
+	Delete(id uint) error
+	// ListChanges 获取设备当前的应用配置版本号，以及版本号 since 之后变化和删除的应用。
+	// 创建、更新和删除应用都会递增所属设备的应用配置版本号
+	ListChanges(deviceID, since uint) (version uint, changed []db.App, removed []db.App, err error)
+}
+
+// ForwardRepo 转发规则仓库
+type ForwardRepo interface {
+	// Create 创建转发规则，同一用户的协议和源端口已被占用时返回 ErrDuplicate
+	Create(forward *db.Forward) error
+	// GetForUser 获取属于指定用户的转发规则
+	GetForUser(userID, id uint) (*db.Forward, error)
+	ListByUser(userID uint) ([]db.Forward, error)
+	// Update 在修订号匹配时更新转发规则并递增修订号，端口冲突时返回 ErrDuplicate
+	Update(forward *db.Forward, revision uint, updates map[string]interface{}) error
+	Delete(id uint) error
+}
+
+// ConnectionRepo 连接记录仓库
+type ConnectionRepo interface {
+	Create(conn *db.Connection) error
+	GetByID(id uint) (*db.Connection, error)
+	// UpdateFields 按列名更新字段，更新后重新加载 conn
+	UpdateFields(conn *db.Connection, updates map[string]interface{}) error
+	// CountByDevice 统计设备作为源或目标的连接数
+	CountByDevice(deviceID uint) (int64, error)
+	// CountByType 统计 deviceIDs 中的设备作为源、自 since 以来建立的 connType 类型的连接数
+	CountByType(connType string, deviceIDs []uint, since time.Time) (int64, error)
+	// TrafficByType 合计 deviceIDs 中的设备作为源、自 since 以来建立的 connType 类型的连接的流量
+	TrafficByType(connType string, deviceIDs []uint, since time.Time) (uint64, error)
+}
+
+// StatsRepo 流量统计仓库
+type StatsRepo interface {
+	Create(stats *db.Stats) error
+	// LatestByDevice 获取设备整体（不属于某个应用或转发规则）最新的统计记录，没有记录时返回 ErrNotFound
+	LatestByDevice(deviceID uint) (*db.Stats, error)
+	// LatestByApp 获取应用最新的统计记录，没有记录时返回 ErrNotFound
+	LatestByApp(appID uint) (*db.Stats, error)
+	// TopDestinations 合计应用自 since 以来到各目标地址的流量，按 orderBy 降序返回前 limit 个
+	TopDestinations(appID uint, since time.Time, limit int, orderBy string) ([]db.DestinationTotal, error)
+}
+
+// 目标地址统计的排序方式
+const (
+	OrderByBytes       = "bytes"
+	OrderByConnections = "connections"
+)
+
+// MetricsRepo 全局统计仓库，汇总所有租户的数据，供管理员查看整体运行情况。
+// 时间范围内的流量来自目标地址统计的上报增量
+type MetricsRepo interface {
+	CountUsers() (int64, error)
+	// CountDevicesByStatus 按状态统计设备数
+	CountDevicesByStatus() (map[string]int64, error)
+	// Traffic 合计所有设备自 since 以来上报的流量
+	Traffic(since time.Time) (*db.TrafficTotal, error)
+	// RelayTraffic 合计自 since 以来有活动的中继连接的流量，Connections 为连接数
+	RelayTraffic(since time.Time) (*db.TrafficTotal, error)
+	// CountTracesByPath 按最终使用的连接方式统计自 since 以来开始的连接过程，连接失败的键为空字符串
+	CountTracesByPath(since time.Time) (map[string]int64, error)
+	// TopDevices 合计各设备自 since 以来的流量，按总流量降序返回前 limit 个
+	TopDevices(since time.Time, limit int) ([]db.DeviceTraffic, error)
+	// TenantSummaries 汇总每个用户的设备数、在线设备数、应用数和自 since 以来的流量，按用户 ID 排序
+	TenantSummaries(since time.Time) ([]db.TenantSummary, error)
+}
+
+// PunchStatRepo 打洞结果统计仓库，按 NAT 类型组合汇总所有设备上报的结果，不属于任何租户
+type PunchStatRepo interface {
+	// Add 累加一组 NAT 类型组合的尝试和成功次数，组合不存在时创建
+	Add(localNAT, remoteNAT string, attempts, successes uint64) error
+	// List 按发起方和对端的 NAT 类型排序获取所有组合的统计
+	List() ([]db.PunchStat, error)
+}
+
+// BanRepo 中继和信令的封禁仓库，封禁对所有用户生效，不属于任何租户
+type BanRepo interface {
+	Create(ban *db.RelayBan) error
+	GetByID(id uint) (*db.RelayBan, error)
+	// List 按创建时间倒序获取封禁，activeAt 非零时只返回在该时间有效的封禁
+	List(activeAt time.Time) ([]db.RelayBan, error)
+	Delete(id uint) error
+}
+
+// AbuseReportRepo 滥用举报仓库，举报由管理员处理，不属于任何租户
+type AbuseReportRepo interface {
+	Create(report *db.AbuseReport) error
+	GetByID(id uint) (*db.AbuseReport, error)
+	// List 按提交时间倒序获取举报，status 为空时返回所有状态，limit 为 0 时不限制数量
+	List(status string, limit int) ([]db.AbuseReport, error)
+	// CountByNode 统计 since 之后针对节点的举报数，已驳回的举报不计入
+	CountByNode(nodeID string, since time.Time) (int64, error)
+	// UpdateFields 按列名更新字段，更新后重新加载 report
+	UpdateFields(report *db.AbuseReport, updates map[string]interface{}) error
+}
+
+// ConnectionTypeRelay 经过中继的连接记录的类型
+const ConnectionTypeRelay = "relay"
+
+// TOTPRepo 双因素认证仓库
+type TOTPRepo interface {
+	Create(totp *db.TOTP) error
+	// GetByUser 获取用户的 TOTP 记录，没有记录时返回 ErrNotFound
+	GetByUser(userID uint) (*db.TOTP, error)
+	// UpdateFields 按列名更新字段，更新后重新加载 totp
+	UpdateFields(totp *db.TOTP, updates map[string]interface{}) error
+	Delete(id uint) error
+}
+
+// InvitationRepo 注册邀请仓库
+type InvitationRepo interface {
+	Create(inv *db.Invitation) error
+	// GetByCodeHash 根据邀请码哈希获取邀请，没有记录时返回 ErrNotFound
+	GetByCodeHash(codeHash string) (*db.Invitation, error)
+	// List 按创建时间倒序获取所有邀请
+	List() ([]db.Invitation, error)
+	// Redeem 在使用次数未达上限时递增使用次数，更新后重新加载 inv，已用完时返回 ErrNotFound
+	Redeem(inv *db.Invitation) error
+	Delete(id uint) error
+}
+
+// APIKeyRepo API 密钥仓库
+type APIKeyRepo interface {
+	Create(key *db.APIKey) error
+	// GetByKeyHash 根据密钥哈希获取密钥，没有记录时返回 ErrNotFound
+	GetByKeyHash(keyHash string) (*db.APIKey, error)
+	GetByID(id uint) (*db.APIKey, error)
+	// ListByUser 按创建时间倒序获取用户的密钥
+	ListByUser(userID uint) ([]db.APIKey, error)
+	Delete(id uint) error
+}
+
+// DeviceFilterRepo 保存的设备筛选条件仓库
+type DeviceFilterRepo interface {
+	// Create 创建筛选条件，同一用户的名称已存在时返回 ErrDuplicate
+	Create(filter *db.DeviceFilter) error
+	GetByID(id uint) (*db.DeviceFilter, error)
+	// ListByUser 按名称排序获取用户的筛选条件
+	ListByUser(userID uint) ([]db.DeviceFilter, error)
+	Delete(id uint) error
+}
+
+// CertificateRepo 设备证书仓库
+type CertificateRepo interface {
+	// Create 记录签发的证书，序列号已存在时返回 ErrDuplicate
+	Create(cert *db.DeviceCertificate) error
+	GetBySerial(serial string) (*db.DeviceCertificate, error)
+	// ListByDevice 按签发时间倒序获取设备的证书
+	ListByDevice(deviceID uint) ([]db.DeviceCertificate, error)
+	// ListRevoked 获取已吊销且在 after 之后过期的证书，按吊销时间排序
+	ListRevoked(after time.Time) ([]db.DeviceCertificate, error)
+	// RevokeByDevice 吊销设备除序列号 except 外所有未吊销的证书，返回吊销的数量
+	RevokeByDevice(deviceID uint, except, reason string, at time.Time) (int64, error)
+}
+
+// ObserverLinkRepo 设备观察链接仓库
+type ObserverLinkRepo interface {
+	Create(link *db.ObserverLink) error
+	// GetByTokenHash 根据令牌哈希获取链接，没有记录时返回 ErrNotFound
+	GetByTokenHash(tokenHash string) (*db.ObserverLink, error)
+	GetByID(id uint) (*db.ObserverLink, error)
+	// ListByDevice 按创建时间倒序获取设备的链接
+	ListByDevice(deviceID uint) ([]db.ObserverLink, error)
+	Delete(id uint) error
+}
+
+// PeerAliasRepo 对等节点别名仓库
+type PeerAliasRepo interface {
+	// Create 创建别名，同一用户的别名已存在时返回 ErrDuplicate
+	Create(alias *db.PeerAlias) error
+	// GetByName 获取用户的别名，没有记录时返回 ErrNotFound
+	GetByName(userID uint, name string) (*db.PeerAlias, error)
+	// ListByUser 按名称排序获取用户的别名
+	ListByUser(userID uint) ([]db.PeerAlias, error)
+	Delete(id uint) error
+}
+
+// AlertRepo 告警规则和告警事件仓库
+type AlertRepo interface {
+	CreateRule(rule *db.AlertRule) error
+	GetRule(id uint) (*db.AlertRule, error)
+	ListRules(userID uint) ([]db.AlertRule, error)
+	// ListEnabledRules 获取所有用户启用的告警规则，供告警规则引擎评估
+	ListEnabledRules() ([]db.AlertRule, error)
+	// SaveRule 保存告警规则的所有字段
+	SaveRule(rule *db.AlertRule) error
+	DeleteRule(id uint) error
+	// ListFiringEvents 获取告警规则正在触发的事件
+	ListFiringEvents(ruleID uint) ([]db.AlertEvent, error)
+	// SaveEvent 保存告警事件，ID 为 0 时创建
+	SaveEvent(event *db.AlertEvent) error
+	// ListEvents 按触发时间倒序获取用户的告警事件，status 为空时返回所有状态
+	ListEvents(userID uint, status string, limit int) ([]db.AlertEvent, error)
+}
+
+// RouteRepo 子网路由仓库，查询到的路由包含访问控制列表
+type RouteRepo interface {
+	Create(route *db.Route) error
+	GetByID(id uint) (*db.Route, error)
+	ListByUser(userID uint) ([]db.Route, error)
+	ListByDevice(deviceID uint) ([]db.Route, error)
+	// Save 保存路由的字段，不修改访问控制列表
+	Save(route *db.Route) error
+	// Delete 在同一事务中删除路由及其访问控制列表
+	Delete(id uint) error
+	// SetACL 在同一事务中将路由的访问控制列表替换为 peerDeviceIDs
+	SetACL(routeID uint, peerDeviceIDs []uint) error
+}
+
+// SpeedTestRepo 测速计划和测速结果仓库
+type SpeedTestRepo interface {
+	CreateSchedule(schedule *db.SpeedTestSchedule) error
+	GetSchedule(id uint) (*db.SpeedTestSchedule, error)
+	ListSchedules(userID uint) ([]db.SpeedTestSchedule, error)
+	// ListDue 获取所有用户已启用且在 now 之前到期的测速计划
+	ListDue(now time.Time) ([]db.SpeedTestSchedule, error)
+	// SaveSchedule 保存测速计划的所有字段
+	SaveSchedule(schedule *db.SpeedTestSchedule) error
+	// UpdateScheduleFields 按列名更新字段，更新后重新加载 schedule
+	UpdateScheduleFields(schedule *db.SpeedTestSchedule, updates map[string]interface{}) error
+	DeleteSchedule(id uint) error
+	CreateResult(result *db.SpeedTestResult) error
+	// ListResults 按创建时间倒序获取测速计划自 since 以来的结果，最多 limit 条
+	ListResults(scheduleID uint, since time.Time, limit int) ([]db.SpeedTestResult, error)
+}
+
+// ExportRepo 数据导出仓库，按 ID 顺序逐条读取用户自 since 以来创建的记录并调用 fn，
+// 不在内存中缓存全部结果。fn 返回错误时停止读取并返回该错误
+type ExportRepo interface {
+	// EachDevice 读取用户的设备，运行状态取自设备的状态快照
+	EachDevice(userID uint, since time.Time, fn func(device *db.Device) error) error
+	EachForward(userID uint, since time.Time, fn func(forward *db.Forward) error) error
+	// EachConnection 读取用户的设备作为源或目标、自 since 以来建立的连接
+	EachConnection(userID uint, since time.Time, fn func(conn *db.Connection) error) error
+}
+
+// Store 服务端持久化的仓库集合
+type Store struct {
+	Users       UserRepo
+	TOTPs       TOTPRepo
+	Invitations InvitationRepo
+	APIKeys     APIKeyRepo
+	Devices     DeviceRepo
+	Filters     DeviceFilterRepo
+	Certs       CertificateRepo
+	Observers   ObserverLinkRepo
+	Aliases     PeerAliasRepo
+	Apps        AppRepo
+	Forwards    ForwardRepo
+	Connections ConnectionRepo
+	Stats       StatsRepo
+	Alerts      AlertRepo
+	Routes      RouteRepo
+	SpeedTests  SpeedTestRepo
+	Exports     ExportRepo
+	Metrics     MetricsRepo
+	Punches     PunchStatRepo
+	Bans        BanRepo
+	Reports     AbuseReportRepo
+}
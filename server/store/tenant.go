@@ -0,0 +1,966 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+)
+
+// ErrNoTenant 上下文中没有租户，通常是请求未经过用户认证中间件
+var ErrNoTenant = errors.New("未确定租户")
+
+// tenantKey 租户在 context 中的键
+type tenantKey struct{}
+
+// WithTenant 在 context 中记录当前请求所属的租户。
+// 组织和团队上线前，租户即拥有资源的用户，tenantID 为用户 ID
+func WithTenant(ctx context.Context, tenantID uint) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenantID)
+}
+
+// TenantFromContext 获取 context 中的租户
+func TenantFromContext(ctx context.Context) (uint, bool) {
+	tenantID, ok := ctx.Value(tenantKey{}).(uint)
+	return tenantID, ok && tenantID != 0
+}
+
+// ForContext 返回按 context 中的租户限定范围的仓库集合，context 中没有租户时返回 ErrNoTenant
+func (s *Store) ForContext(ctx context.Context) (*Store, error) {
+	tenantID, ok := TenantFromContext(ctx)
+	if !ok {
+		return nil, ErrNoTenant
+	}
+	return s.ForTenant(tenantID), nil
+}
+
+// ForTenant 返回只能访问租户 tenantID 数据的仓库集合。
+//
+// 其他租户的记录对返回的仓库不可见：查询返回 ErrNotFound 或不出现在列表中，更新和删除返回 ErrNotFound，
+// 创建时记录的 UserID 不属于该租户同样返回 ErrNotFound。用户、双因素认证、邀请、API 密钥、全局统计、打洞统计、封禁和滥用举报不是租户拥有的数据，
+// 返回的集合中这些仓库为 nil，需要时使用未限定范围的仓库集合。
+//
+// 各仓库逐个实现接口方法而不嵌入原仓库，接口新增方法时必须在这里补上租户过滤才能编译通过
+func (s *Store) ForTenant(tenantID uint) *Store {
+	t := &tenantScope{id: tenantID, devices: s.Devices, apps: s.Apps}
+	return &Store{
+		Devices:     &tenantDeviceRepo{t},
+		Filters:     &tenantDeviceFilterRepo{t, s.Filters},
+		Certs:       &tenantCertificateRepo{t, s.Certs},
+		Observers:   &tenantObserverLinkRepo{t, s.Observers},
+		Aliases:     &tenantPeerAliasRepo{t, s.Aliases},
+		Apps:        &tenantAppRepo{t},
+		Forwards:    &tenantForwardRepo{t, s.Forwards},
+		Connections: &tenantConnectionRepo{t, s.Connections},
+		Stats:       &tenantStatsRepo{t, s.Stats},
+		Alerts:      &tenantAlertRepo{t, s.Alerts},
+		Routes:      &tenantRouteRepo{t, s.Routes},
+		SpeedTests:  &tenantSpeedTestRepo{t, s.SpeedTests},
+		Exports:     &tenantExportRepo{t, s.Exports},
+	}
+}
+
+// tenantScope 租户及检查归属所需的原仓库
+type tenantScope struct {
+	id      uint
+	devices DeviceRepo
+	apps    AppRepo
+}
+
+// device 获取属于租户的设备，其他租户的设备返回 ErrNotFound
+func (t *tenantScope) device(id uint) (*db.Device, error) {
+	device, err := t.devices.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if device.UserID != t.id {
+		return nil, ErrNotFound
+	}
+	return device, nil
+}
+
+// app 获取属于租户的应用，其他租户的应用返回 ErrNotFound
+func (t *tenantScope) app(id uint) (*db.App, error) {
+	app, err := t.apps.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if app.UserID != t.id {
+		return nil, ErrNotFound
+	}
+	return app, nil
+}
+
+// owns 检查记录的 UserID 是否属于租户
+func (t *tenantScope) owns(userID uint) error {
+	if userID != t.id {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ownedDevices 过滤出属于租户的设备 ID
+func (t *tenantScope) ownedDevices(deviceIDs []uint) ([]uint, error) {
+	owned := make([]uint, 0, len(deviceIDs))
+	for _, id := range deviceIDs {
+		if _, err := t.device(id); err != nil {
+			if IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		owned = append(owned, id)
+	}
+	return owned, nil
+}
+
+// tenantDeviceRepo 限定租户的设备仓库
+type tenantDeviceRepo struct {
+	t *tenantScope
+}
+
+func (r *tenantDeviceRepo) Create(device *db.Device) error {
+	if err := r.t.owns(device.UserID); err != nil {
+		return err
+	}
+	return r.t.devices.Create(device)
+}
+
+func (r *tenantDeviceRepo) GetByID(id uint) (*db.Device, error) {
+	return r.t.device(id)
+}
+
+func (r *tenantDeviceRepo) GetByNodeID(nodeID string) (*db.Device, error) {
+	device, err := r.t.devices.GetByNodeID(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	if device.UserID != r.t.id {
+		return nil, ErrNotFound
+	}
+	return device, nil
+}
+
+func (r *tenantDeviceRepo) ListByUser(userID uint) ([]db.Device, error) {
+	if userID != r.t.id {
+		return []db.Device{}, nil
+	}
+	return r.t.devices.ListByUser(userID)
+}
+
+func (r *tenantDeviceRepo) ListByStatus(status string) ([]db.Device, error) {
+	devices, err := r.t.devices.ListByStatus(status)
+	if err != nil {
+		return nil, err
+	}
+	owned := devices[:0]
+	for _, device := range devices {
+		if device.UserID == r.t.id {
+			owned = append(owned, device)
+		}
+	}
+	return owned, nil
+}
+
+func (r *tenantDeviceRepo) ListVersions() ([]string, error) {
+	devices, err := r.t.devices.ListByUser(r.t.id)
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]string, 0, len(devices))
+	for _, device := range devices {
+		if device.Version != "" {
+			versions = append(versions, device.Version)
+		}
+	}
+	return versions, nil
+}
+
+func (r *tenantDeviceRepo) ListStatuses(userID uint, status string) ([]db.DeviceStatus, error) {
+	if userID != r.t.id {
+		return []db.DeviceStatus{}, nil
+	}
+	return r.t.devices.ListStatuses(userID, status)
+}
+
+func (r *tenantDeviceRepo) Update(device *db.Device, revision uint, updates map[string]interface{}) error {
+	if _, err := r.t.device(device.ID); err != nil {
+		return err
+	}
+	return r.t.devices.Update(device, revision, updates)
+}
+
+func (r *tenantDeviceRepo) UpdateFields(device *db.Device, updates map[string]interface{}) error {
+	if _, err := r.t.device(device.ID); err != nil {
+		return err
+	}
+	return r.t.devices.UpdateFields(device, updates)
+}
+
+func (r *tenantDeviceRepo) SaveReport(device *db.Device, updates map[string]interface{}, stats []db.Stats, conns []db.Connection, destinations []db.DestinationStats) error {
+	if _, err := r.t.device(device.ID); err != nil {
+		return err
+	}
+	for _, s := range stats {
+		if err := r.t.owns(s.UserID); err != nil {
+			return err
+		}
+	}
+	for _, conn := range conns {
+		if conn.SourceDeviceID != device.ID {
+			return ErrNotFound
+		}
+	}
+	for _, d := range destinations {
+		if err := r.t.owns(d.UserID); err != nil {
+			return err
+		}
+	}
+	return r.t.devices.SaveReport(device, updates, stats, conns, destinations)
+}
+
+func (r *tenantDeviceRepo) Delete(id uint) error {
+	if _, err := r.t.device(id); err != nil {
+		return err
+	}
+	return r.t.devices.Delete(id)
+}
+
+func (r *tenantDeviceRepo) CreateEvents(events []db.DeviceEvent) error {
+	checked := make(map[uint]bool)
+	for _, event := range events {
+		if checked[event.DeviceID] {
+			continue
+		}
+		if _, err := r.t.device(event.DeviceID); err != nil {
+			return err
+		}
+		checked[event.DeviceID] = true
+	}
+	return r.t.devices.CreateEvents(events)
+}
+
+func (r *tenantDeviceRepo) ListEvents(deviceID uint, limit int) ([]db.DeviceEvent, error) {
+	if _, err := r.t.device(deviceID); err != nil {
+		return nil, err
+	}
+	return r.t.devices.ListEvents(deviceID, limit)
+}
+
+func (r *tenantDeviceRepo) CountEvents(deviceID uint, eventType string, since time.Time) (int64, error) {
+	if _, err := r.t.device(deviceID); err != nil {
+		return 0, err
+	}
+	return r.t.devices.CountEvents(deviceID, eventType, since)
+}
+
+func (r *tenantDeviceRepo) CreateTrace(trace *db.ConnectionTrace) error {
+	if _, err := r.t.device(trace.DeviceID); err != nil {
+		return err
+	}
+	return r.t.devices.CreateTrace(trace)
+}
+
+func (r *tenantDeviceRepo) ListTraces(deviceID uint, peerID string, limit int) ([]db.ConnectionTrace, error) {
+	if _, err := r.t.device(deviceID); err != nil {
+		return nil, err
+	}
+	return r.t.devices.ListTraces(deviceID, peerID, limit)
+}
+
+// tenantAppRepo 限定租户的应用仓库
+type tenantAppRepo struct {
+	t *tenantScope
+}
+
+func (r *tenantAppRepo) Create(app *db.App) error {
+	if err := r.t.owns(app.UserID); err != nil {
+		return err
+	}
+	// 应用只能创建在租户自己的设备上
+	if _, err := r.t.device(app.DeviceID); err != nil {
+		return err
+	}
+	return r.t.apps.Create(app)
+}
+
+func (r *tenantAppRepo) GetByID(id uint) (*db.App, error) {
+	return r.t.app(id)
+}
+
+func (r *tenantAppRepo) ListByUser(userID uint) ([]db.App, error) {
+	if userID != r.t.id {
+		return []db.App{}, nil
+	}
+	return r.t.apps.ListByUser(userID)
+}
+
+func (r *tenantAppRepo) ListByDevice(deviceID uint) ([]db.App, error) {
+	if _, err := r.t.device(deviceID); err != nil {
+		if IsNotFound(err) {
+			return []db.App{}, nil
+		}
+		return nil, err
+	}
+	return r.t.apps.ListByDevice(deviceID)
+}
+
+func (r *tenantAppRepo) CountByDevice(deviceID uint) (int64, error) {
+	if _, err := r.t.device(deviceID); err != nil {
+		if IsNotFound(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return r.t.apps.CountByDevice(deviceID)
+}
+
+func (r *tenantAppRepo) Update(app *db.App, revision uint, updates map[string]interface{}) error {
+	if _, err := r.t.app(app.ID); err != nil {
+		return err
+	}
+	return r.t.apps.Update(app, revision, updates)
+}
+
+func (r *tenantAppRepo) UpdateFields(app *db.App, updates map[string]interface{}) error {
+	if _, err := r.t.app(app.ID); err != nil {
+		return err
+	}
+	return r.t.apps.UpdateFields(app, updates)
+}
+
+func (r *tenantAppRepo) Delete(id uint) error {
+	if _, err := r.t.app(id); err != nil {
+		return err
+	}
+	return r.t.apps.Delete(id)
+}
+
+func (r *tenantAppRepo) ListChanges(deviceID, since uint) (uint, []db.App, []db.App, error) {
+	if _, err := r.t.device(deviceID); err != nil {
+		return 0, nil, nil, err
+	}
+	return r.t.apps.ListChanges(deviceID, since)
+}
+
+// tenantForwardRepo 限定租户的转发规则仓库
+type tenantForwardRepo struct {
+	t    *tenantScope
+	repo ForwardRepo
+}
+
+func (r *tenantForwardRepo) Create(forward *db.Forward) error {
+	if err := r.t.owns(forward.UserID); err != nil {
+		return err
+	}
+	return r.repo.Create(forward)
+}
+
+func (r *tenantForwardRepo) GetForUser(userID, id uint) (*db.Forward, error) {
+	if userID != r.t.id {
+		return nil, ErrNotFound
+	}
+	return r.repo.GetForUser(userID, id)
+}
+
+func (r *tenantForwardRepo) ListByUser(userID uint) ([]db.Forward, error) {
+	if userID != r.t.id {
+		return []db.Forward{}, nil
+	}
+	return r.repo.ListByUser(userID)
+}
+
+func (r *tenantForwardRepo) Update(forward *db.Forward, revision uint, updates map[string]interface{}) error {
+	if _, err := r.repo.GetForUser(r.t.id, forward.ID); err != nil {
+		return err
+	}
+	return r.repo.Update(forward, revision, updates)
+}
+
+func (r *tenantForwardRepo) Delete(id uint) error {
+	if _, err := r.repo.GetForUser(r.t.id, id); err != nil {
+		return err
+	}
+	return r.repo.Delete(id)
+}
+
+// tenantDeviceFilterRepo 限定租户的设备筛选条件仓库
+type tenantDeviceFilterRepo struct {
+	t    *tenantScope
+	repo DeviceFilterRepo
+}
+
+func (r *tenantDeviceFilterRepo) Create(filter *db.DeviceFilter) error {
+	if err := r.t.owns(filter.UserID); err != nil {
+		return err
+	}
+	return r.repo.Create(filter)
+}
+
+func (r *tenantDeviceFilterRepo) GetByID(id uint) (*db.DeviceFilter, error) {
+	filter, err := r.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if filter.UserID != r.t.id {
+		return nil, ErrNotFound
+	}
+	return filter, nil
+}
+
+func (r *tenantDeviceFilterRepo) ListByUser(userID uint) ([]db.DeviceFilter, error) {
+	if userID != r.t.id {
+		return []db.DeviceFilter{}, nil
+	}
+	return r.repo.ListByUser(userID)
+}
+
+func (r *tenantDeviceFilterRepo) Delete(id uint) error {
+	if _, err := r.GetByID(id); err != nil {
+		return err
+	}
+	return r.repo.Delete(id)
+}
+
+// tenantCertificateRepo 限定租户的设备证书仓库
+type tenantCertificateRepo struct {
+	t    *tenantScope
+	repo CertificateRepo
+}
+
+func (r *tenantCertificateRepo) Create(cert *db.DeviceCertificate) error {
+	if _, err := r.t.device(cert.DeviceID); err != nil {
+		return err
+	}
+	return r.repo.Create(cert)
+}
+
+func (r *tenantCertificateRepo) GetBySerial(serial string) (*db.DeviceCertificate, error) {
+	cert, err := r.repo.GetBySerial(serial)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.t.device(cert.DeviceID); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+func (r *tenantCertificateRepo) ListByDevice(deviceID uint) ([]db.DeviceCertificate, error) {
+	if _, err := r.t.device(deviceID); err != nil {
+		return nil, err
+	}
+	return r.repo.ListByDevice(deviceID)
+}
+
+func (r *tenantCertificateRepo) ListRevoked(after time.Time) ([]db.DeviceCertificate, error) {
+	certs, err := r.repo.ListRevoked(after)
+	if err != nil {
+		return nil, err
+	}
+	// 吊销列表包含所有租户的证书，逐个设备检查归属并缓存结果
+	owned := make(map[uint]bool)
+	result := certs[:0]
+	for _, cert := range certs {
+		ok, checked := owned[cert.DeviceID]
+		if !checked {
+			_, err := r.t.device(cert.DeviceID)
+			if err != nil && !IsNotFound(err) {
+				return nil, err
+			}
+			ok = err == nil
+			owned[cert.DeviceID] = ok
+		}
+		if ok {
+			result = append(result, cert)
+		}
+	}
+	return result, nil
+}
+
+func (r *tenantCertificateRepo) RevokeByDevice(deviceID uint, except, reason string, at time.Time) (int64, error) {
+	if _, err := r.t.device(deviceID); err != nil {
+		return 0, err
+	}
+	return r.repo.RevokeByDevice(deviceID, except, reason, at)
+}
+
+// tenantConnectionRepo 限定租户的连接记录仓库，连接记录归属于源设备的租户
+type tenantConnectionRepo struct {
+	t    *tenantScope
+	repo ConnectionRepo
+}
+
+func (r *tenantConnectionRepo) Create(conn *db.Connection) error {
+	if _, err := r.t.device(conn.SourceDeviceID); err != nil {
+		return err
+	}
+	return r.repo.Create(conn)
+}
+
+func (r *tenantConnectionRepo) GetByID(id uint) (*db.Connection, error) {
+	conn, err := r.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.t.device(conn.SourceDeviceID); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (r *tenantConnectionRepo) UpdateFields(conn *db.Connection, updates map[string]interface{}) error {
+	if _, err := r.GetByID(conn.ID); err != nil {
+		return err
+	}
+	return r.repo.UpdateFields(conn, updates)
+}
+
+func (r *tenantConnectionRepo) CountByDevice(deviceID uint) (int64, error) {
+	if _, err := r.t.device(deviceID); err != nil {
+		return 0, err
+	}
+	return r.repo.CountByDevice(deviceID)
+}
+
+func (r *tenantConnectionRepo) CountByType(connType string, deviceIDs []uint, since time.Time) (int64, error) {
+	owned, err := r.t.ownedDevices(deviceIDs)
+	if err != nil || len(owned) == 0 {
+		return 0, err
+	}
+	return r.repo.CountByType(connType, owned, since)
+}
+
+func (r *tenantConnectionRepo) TrafficByType(connType string, deviceIDs []uint, since time.Time) (uint64, error) {
+	owned, err := r.t.ownedDevices(deviceIDs)
+	if err != nil || len(owned) == 0 {
+		return 0, err
+	}
+	return r.repo.TrafficByType(connType, owned, since)
+}
+
+// tenantStatsRepo 限定租户的流量统计仓库
+type tenantStatsRepo struct {
+	t    *tenantScope
+	repo StatsRepo
+}
+
+func (r *tenantStatsRepo) Create(stats *db.Stats) error {
+	if _, err := r.t.device(stats.DeviceID); err != nil {
+		return err
+	}
+	return r.repo.Create(stats)
+}
+
+func (r *tenantStatsRepo) LatestByDevice(deviceID uint) (*db.Stats, error) {
+	if _, err := r.t.device(deviceID); err != nil {
+		return nil, err
+	}
+	return r.repo.LatestByDevice(deviceID)
+}
+
+func (r *tenantStatsRepo) LatestByApp(appID uint) (*db.Stats, error) {
+	if _, err := r.t.app(appID); err != nil {
+		return nil, err
+	}
+	return r.repo.LatestByApp(appID)
+}
+
+func (r *tenantStatsRepo) TopDestinations(appID uint, since time.Time, limit int, orderBy string) ([]db.DestinationTotal, error) {
+	if _, err := r.t.app(appID); err != nil {
+		return nil, err
+	}
+	return r.repo.TopDestinations(appID, since, limit, orderBy)
+}
+
+// tenantObserverLinkRepo 限定租户的设备观察链接仓库
+type tenantObserverLinkRepo struct {
+	t    *tenantScope
+	repo ObserverLinkRepo
+}
+
+func (r *tenantObserverLinkRepo) Create(link *db.ObserverLink) error {
+	if err := r.t.owns(link.UserID); err != nil {
+		return err
+	}
+	if _, err := r.t.device(link.DeviceID); err != nil {
+		return err
+	}
+	return r.repo.Create(link)
+}
+
+func (r *tenantObserverLinkRepo) GetByTokenHash(tokenHash string) (*db.ObserverLink, error) {
+	link, err := r.repo.GetByTokenHash(tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if link.UserID != r.t.id {
+		return nil, ErrNotFound
+	}
+	return link, nil
+}
+
+func (r *tenantObserverLinkRepo) GetByID(id uint) (*db.ObserverLink, error) {
+	link, err := r.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if link.UserID != r.t.id {
+		return nil, ErrNotFound
+	}
+	return link, nil
+}
+
+func (r *tenantObserverLinkRepo) ListByDevice(deviceID uint) ([]db.ObserverLink, error) {
+	if _, err := r.t.device(deviceID); err != nil {
+		return nil, err
+	}
+	return r.repo.ListByDevice(deviceID)
+}
+
+func (r *tenantObserverLinkRepo) Delete(id uint) error {
+	if _, err := r.GetByID(id); err != nil {
+		return err
+	}
+	return r.repo.Delete(id)
+}
+
+// tenantPeerAliasRepo 限定租户的对等节点别名仓库
+type tenantPeerAliasRepo struct {
+	t    *tenantScope
+	repo PeerAliasRepo
+}
+
+func (r *tenantPeerAliasRepo) Create(alias *db.PeerAlias) error {
+	if err := r.t.owns(alias.UserID); err != nil {
+		return err
+	}
+	return r.repo.Create(alias)
+}
+
+func (r *tenantPeerAliasRepo) GetByName(userID uint, name string) (*db.PeerAlias, error) {
+	if err := r.t.owns(userID); err != nil {
+		return nil, err
+	}
+	return r.repo.GetByName(userID, name)
+}
+
+func (r *tenantPeerAliasRepo) ListByUser(userID uint) ([]db.PeerAlias, error) {
+	if userID != r.t.id {
+		return []db.PeerAlias{}, nil
+	}
+	return r.repo.ListByUser(userID)
+}
+
+func (r *tenantPeerAliasRepo) Delete(id uint) error {
+	aliases, err := r.repo.ListByUser(r.t.id)
+	if err != nil {
+		return err
+	}
+	for _, alias := range aliases {
+		if alias.ID == id {
+			return r.repo.Delete(id)
+		}
+	}
+	return ErrNotFound
+}
+
+// tenantAlertRepo 限定租户的告警仓库
+type tenantAlertRepo struct {
+	t    *tenantScope
+	repo AlertRepo
+}
+
+func (r *tenantAlertRepo) CreateRule(rule *db.AlertRule) error {
+	if err := r.t.owns(rule.UserID); err != nil {
+		return err
+	}
+	// 指定设备的规则只能指定租户自己的设备
+	if rule.DeviceID != 0 {
+		if _, err := r.t.device(rule.DeviceID); err != nil {
+			return err
+		}
+	}
+	return r.repo.CreateRule(rule)
+}
+
+func (r *tenantAlertRepo) GetRule(id uint) (*db.AlertRule, error) {
+	rule, err := r.repo.GetRule(id)
+	if err != nil {
+		return nil, err
+	}
+	if rule.UserID != r.t.id {
+		return nil, ErrNotFound
+	}
+	return rule, nil
+}
+
+func (r *tenantAlertRepo) ListRules(userID uint) ([]db.AlertRule, error) {
+	if userID != r.t.id {
+		return []db.AlertRule{}, nil
+	}
+	return r.repo.ListRules(userID)
+}
+
+func (r *tenantAlertRepo) ListEnabledRules() ([]db.AlertRule, error) {
+	rules, err := r.repo.ListEnabledRules()
+	if err != nil {
+		return nil, err
+	}
+	owned := rules[:0]
+	for _, rule := range rules {
+		if rule.UserID == r.t.id {
+			owned = append(owned, rule)
+		}
+	}
+	return owned, nil
+}
+
+func (r *tenantAlertRepo) SaveRule(rule *db.AlertRule) error {
+	if _, err := r.GetRule(rule.ID); err != nil {
+		return err
+	}
+	if err := r.t.owns(rule.UserID); err != nil {
+		return err
+	}
+	return r.repo.SaveRule(rule)
+}
+
+func (r *tenantAlertRepo) DeleteRule(id uint) error {
+	if _, err := r.GetRule(id); err != nil {
+		return err
+	}
+	return r.repo.DeleteRule(id)
+}
+
+func (r *tenantAlertRepo) ListFiringEvents(ruleID uint) ([]db.AlertEvent, error) {
+	if _, err := r.GetRule(ruleID); err != nil {
+		return nil, err
+	}
+	return r.repo.ListFiringEvents(ruleID)
+}
+
+func (r *tenantAlertRepo) SaveEvent(event *db.AlertEvent) error {
+	if err := r.t.owns(event.UserID); err != nil {
+		return err
+	}
+	if _, err := r.GetRule(event.RuleID); err != nil {
+		return err
+	}
+	return r.repo.SaveEvent(event)
+}
+
+func (r *tenantAlertRepo) ListEvents(userID uint, status string, limit int) ([]db.AlertEvent, error) {
+	if userID != r.t.id {
+		return []db.AlertEvent{}, nil
+	}
+	return r.repo.ListEvents(userID, status, limit)
+}
+
+// tenantRouteRepo 限定租户的子网路由仓库
+type tenantRouteRepo struct {
+	t    *tenantScope
+	repo RouteRepo
+}
+
+func (r *tenantRouteRepo) Create(route *db.Route) error {
+	if err := r.t.owns(route.UserID); err != nil {
+		return err
+	}
+	// 路由只能由租户自己的设备通告
+	if _, err := r.t.device(route.DeviceID); err != nil {
+		return err
+	}
+	return r.repo.Create(route)
+}
+
+func (r *tenantRouteRepo) GetByID(id uint) (*db.Route, error) {
+	route, err := r.repo.GetByID(id)
+	if err != nil {
+		return nil, err
+	}
+	if route.UserID != r.t.id {
+		return nil, ErrNotFound
+	}
+	return route, nil
+}
+
+func (r *tenantRouteRepo) ListByUser(userID uint) ([]db.Route, error) {
+	if userID != r.t.id {
+		return []db.Route{}, nil
+	}
+	return r.repo.ListByUser(userID)
+}
+
+func (r *tenantRouteRepo) ListByDevice(deviceID uint) ([]db.Route, error) {
+	if _, err := r.t.device(deviceID); err != nil {
+		if IsNotFound(err) {
+			return []db.Route{}, nil
+		}
+		return nil, err
+	}
+	return r.repo.ListByDevice(deviceID)
+}
+
+func (r *tenantRouteRepo) Save(route *db.Route) error {
+	if _, err := r.GetByID(route.ID); err != nil {
+		return err
+	}
+	if err := r.t.owns(route.UserID); err != nil {
+		return err
+	}
+	if _, err := r.t.device(route.DeviceID); err != nil {
+		return err
+	}
+	return r.repo.Save(route)
+}
+
+func (r *tenantRouteRepo) Delete(id uint) error {
+	if _, err := r.GetByID(id); err != nil {
+		return err
+	}
+	return r.repo.Delete(id)
+}
+
+func (r *tenantRouteRepo) SetACL(routeID uint, peerDeviceIDs []uint) error {
+	if _, err := r.GetByID(routeID); err != nil {
+		return err
+	}
+	// 访问控制列表只能包含租户自己的设备
+	for _, peerID := range peerDeviceIDs {
+		if _, err := r.t.device(peerID); err != nil {
+			return err
+		}
+	}
+	return r.repo.SetACL(routeID, peerDeviceIDs)
+}
+
+// tenantSpeedTestRepo 限定租户的测速仓库
+type tenantSpeedTestRepo struct {
+	t    *tenantScope
+	repo SpeedTestRepo
+}
+
+// checkDevices 检查测速计划的源设备和目标设备是否属于租户
+func (r *tenantSpeedTestRepo) checkDevices(schedule *db.SpeedTestSchedule) error {
+	for _, deviceID := range []uint{schedule.SourceDeviceID, schedule.TargetDeviceID} {
+		if _, err := r.t.device(deviceID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *tenantSpeedTestRepo) CreateSchedule(schedule *db.SpeedTestSchedule) error {
+	if err := r.t.owns(schedule.UserID); err != nil {
+		return err
+	}
+	if err := r.checkDevices(schedule); err != nil {
+		return err
+	}
+	return r.repo.CreateSchedule(schedule)
+}
+
+func (r *tenantSpeedTestRepo) GetSchedule(id uint) (*db.SpeedTestSchedule, error) {
+	schedule, err := r.repo.GetSchedule(id)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.UserID != r.t.id {
+		return nil, ErrNotFound
+	}
+	return schedule, nil
+}
+
+func (r *tenantSpeedTestRepo) ListSchedules(userID uint) ([]db.SpeedTestSchedule, error) {
+	if userID != r.t.id {
+		return []db.SpeedTestSchedule{}, nil
+	}
+	return r.repo.ListSchedules(userID)
+}
+
+func (r *tenantSpeedTestRepo) ListDue(now time.Time) ([]db.SpeedTestSchedule, error) {
+	schedules, err := r.repo.ListDue(now)
+	if err != nil {
+		return nil, err
+	}
+	owned := schedules[:0]
+	for _, schedule := range schedules {
+		if schedule.UserID == r.t.id {
+			owned = append(owned, schedule)
+		}
+	}
+	return owned, nil
+}
+
+func (r *tenantSpeedTestRepo) SaveSchedule(schedule *db.SpeedTestSchedule) error {
+	if _, err := r.GetSchedule(schedule.ID); err != nil {
+		return err
+	}
+	if err := r.t.owns(schedule.UserID); err != nil {
+		return err
+	}
+	if err := r.checkDevices(schedule); err != nil {
+		return err
+	}
+	return r.repo.SaveSchedule(schedule)
+}
+
+func (r *tenantSpeedTestRepo) UpdateScheduleFields(schedule *db.SpeedTestSchedule, updates map[string]interface{}) error {
+	if _, err := r.GetSchedule(schedule.ID); err != nil {
+		return err
+	}
+	return r.repo.UpdateScheduleFields(schedule, updates)
+}
+
+func (r *tenantSpeedTestRepo) DeleteSchedule(id uint) error {
+	if _, err := r.GetSchedule(id); err != nil {
+		return err
+	}
+	return r.repo.DeleteSchedule(id)
+}
+
+func (r *tenantSpeedTestRepo) CreateResult(result *db.SpeedTestResult) error {
+	if err := r.t.owns(result.UserID); err != nil {
+		return err
+	}
+	if _, err := r.GetSchedule(result.ScheduleID); err != nil {
+		return err
+	}
+	return r.repo.CreateResult(result)
+}
+
+func (r *tenantSpeedTestRepo) ListResults(scheduleID uint, since time.Time, limit int) ([]db.SpeedTestResult, error) {
+	if _, err := r.GetSchedule(scheduleID); err != nil {
+		return nil, err
+	}
+	return r.repo.ListResults(scheduleID, since, limit)
+}
+
+// tenantExportRepo 限定租户的数据导出仓库
+type tenantExportRepo struct {
+	t    *tenantScope
+	repo ExportRepo
+}
+
+func (r *tenantExportRepo) EachDevice(userID uint, since time.Time, fn func(device *db.Device) error) error {
+	if err := r.t.owns(userID); err != nil {
+		return err
+	}
+	return r.repo.EachDevice(userID, since, fn)
+}
+
+func (r *tenantExportRepo) EachForward(userID uint, since time.Time, fn func(forward *db.Forward) error) error {
+	if err := r.t.owns(userID); err != nil {
+		return err
+	}
+	return r.repo.EachForward(userID, since, fn)
+}
+
+func (r *tenantExportRepo) EachConnection(userID uint, since time.Time, fn func(conn *db.Connection) error) error {
+	if err := r.t.owns(userID); err != nil {
+		return err
+	}
+	return r.repo.EachConnection(userID, since, fn)
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/senma231/p3/server/db"
+)
+
+func TestTenantScope(t *testing.T) {
+	st := NewMemoryStore()
+
+	mine := &db.Device{UserID: 1, Name: "nas", NodeID: "node-a"}
+	theirs := &db.Device{UserID: 2, Name: "router", NodeID: "node-b"}
+	for _, device := range []*db.Device{mine, theirs} {
+		if err := st.Devices.Create(device); err != nil {
+			t.Fatalf("创建设备失败: %v", err)
+		}
+	}
+	theirApp := &db.App{UserID: 2, DeviceID: theirs.ID, Name: "web", Protocol: "tcp", SrcPort: 8080}
+	if err := st.Apps.Create(theirApp); err != nil {
+		t.Fatalf("创建应用失败: %v", err)
+	}
+	theirForward := &db.Forward{UserID: 2, Protocol: "tcp", SrcPort: 2222}
+	if err := st.Forwards.Create(theirForward); err != nil {
+		t.Fatalf("创建转发规则失败: %v", err)
+	}
+	theirFilter := &db.DeviceFilter{UserID: 2, Name: "online", Expression: "status=online"}
+	if err := st.Filters.Create(theirFilter); err != nil {
+		t.Fatalf("创建筛选条件失败: %v", err)
+	}
+
+	scoped := st.ForTenant(1)
+
+	// 自己的数据可见
+	if device, err := scoped.Devices.GetByID(mine.ID); err != nil || device.ID != mine.ID {
+		t.Fatalf("应能读取自己的设备: %+v %v", device, err)
+	}
+	if devices, _ := scoped.Devices.ListByStatus("offline"); len(devices) != 0 {
+		t.Fatalf("未设置状态的设备不应匹配: %+v", devices)
+	}
+
+	// 其他租户的数据视为不存在
+	if _, err := scoped.Devices.GetByID(theirs.ID); !IsNotFound(err) {
+		t.Fatalf("读取其他租户的设备应返回 ErrNotFound: %v", err)
+	}
+	if _, err := scoped.Devices.GetByNodeID("node-b"); !IsNotFound(err) {
+		t.Fatalf("按节点 ID 读取其他租户的设备应返回 ErrNotFound: %v", err)
+	}
+	if devices, _ := scoped.Devices.ListByUser(2); len(devices) != 0 {
+		t.Fatalf("不应列出其他租户的设备: %+v", devices)
+	}
+	if _, err := scoped.Apps.GetByID(theirApp.ID); !IsNotFound(err) {
+		t.Fatalf("读取其他租户的应用应返回 ErrNotFound: %v", err)
+	}
+	if apps, _ := scoped.Apps.ListByDevice(theirs.ID); len(apps) != 0 {
+		t.Fatalf("不应列出其他租户设备上的应用: %+v", apps)
+	}
+	if _, err := scoped.Forwards.GetForUser(2, theirForward.ID); !IsNotFound(err) {
+		t.Fatalf("读取其他租户的转发规则应返回 ErrNotFound: %v", err)
+	}
+	if _, err := scoped.Filters.GetByID(theirFilter.ID); !IsNotFound(err) {
+		t.Fatalf("读取其他租户的筛选条件应返回 ErrNotFound: %v", err)
+	}
+	if _, err := scoped.Devices.ListEvents(theirs.ID, 10); !IsNotFound(err) {
+		t.Fatalf("读取其他租户设备的事件应返回 ErrNotFound: %v", err)
+	}
+	if _, err := scoped.Stats.TopDestinations(theirApp.ID, time.Time{}, 10, OrderByBytes); !IsNotFound(err) {
+		t.Fatalf("读取其他租户应用的统计应返回 ErrNotFound: %v", err)
+	}
+
+	// 不能修改或删除其他租户的数据
+	if err := scoped.Devices.Update(theirs, 0, map[string]interface{}{"name": "taken"}); !IsNotFound(err) {
+		t.Fatalf("修改其他租户的设备应返回 ErrNotFound: %v", err)
+	}
+	if err := scoped.Apps.Delete(theirApp.ID); !IsNotFound(err) {
+		t.Fatalf("删除其他租户的应用应返回 ErrNotFound: %v", err)
+	}
+	if err := scoped.Forwards.Delete(theirForward.ID); !IsNotFound(err) {
+		t.Fatalf("删除其他租户的转发规则应返回 ErrNotFound: %v", err)
+	}
+	if device, err := st.Devices.GetByID(theirs.ID); err != nil || device.Name != "router" {
+		t.Fatalf("其他租户的设备不应被修改: %+v %v", device, err)
+	}
+
+	// 不能为其他租户创建数据，也不能在其他租户的设备上创建应用
+	if err := scoped.Devices.Create(&db.Device{UserID: 2, NodeID: "node-c"}); !IsNotFound(err) {
+		t.Fatalf("为其他租户创建设备应返回 ErrNotFound: %v", err)
+	}
+	if err := scoped.Apps.Create(&db.App{UserID: 1, DeviceID: theirs.ID, Protocol: "tcp", SrcPort: 9090}); !IsNotFound(err) {
+		t.Fatalf("在其他租户的设备上创建应用应返回 ErrNotFound: %v", err)
+	}
+}
+
+func TestTenantFromContext(t *testing.T) {
+	st := NewMemoryStore()
+
+	if _, err := st.ForContext(context.Background()); err != ErrNoTenant {
+		t.Fatalf("没有租户时应返回 ErrNoTenant: %v", err)
+	}
+	if _, err := st.ForContext(WithTenant(context.Background(), 0)); err != ErrNoTenant {
+		t.Fatalf("租户为 0 时应返回 ErrNoTenant: %v", err)
+	}
+	if _, err := st.ForContext(WithTenant(context.Background(), 1)); err != nil {
+		t.Fatalf("获取租户仓库失败: %v", err)
+	}
+}
+
+// TestTenantScopeCoversStore 检查 Store 新增的仓库都已限定租户，或明确列为不属于租户的数据
+func TestTenantScopeCoversStore(t *testing.T) {
+	global := map[string]bool{"Users": true, "TOTPs": true, "Invitations": true, "APIKeys": true, "Metrics": true, "Punches": true, "Bans": true, "Reports": true}
+
+	st := NewMemoryStore()
+	scoped := reflect.ValueOf(st.ForTenant(1)).Elem()
+	unscoped := reflect.ValueOf(st).Elem()
+	for i := 0; i < scoped.NumField(); i++ {
+		name := scoped.Type().Field(i).Name
+		field := scoped.Field(i)
+		if global[name] {
+			if !field.IsNil() {
+				t.Errorf("%s 不属于租户，限定范围的仓库集合中应为 nil", name)
+			}
+			continue
+		}
+		if field.IsNil() {
+			t.Errorf("%s 未限定租户", name)
+			continue
+		}
+		if field.Elem().Type() == unscoped.Field(i).Elem().Type() {
+			t.Errorf("%s 直接使用了未限定租户的仓库", name)
+		}
+	}
+}
@@ -4,16 +4,36 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"strings"
 
 	"github.com/gin-gonic/gin"
+	"github.com/senma231/p3/common/cors"
 )
 
 func main() {
 	// 解析命令行参数
 	port := flag.Int("port", 8080, "API 服务端口")
 	mode := flag.String("mode", "debug", "运行模式 (debug, release)")
+	corsOrigins := flag.String("cors-origins", "", "允许跨域访问的来源，多个来源用逗号分隔")
+	corsCredentials := flag.Bool("cors-credentials", false, "允许跨域请求携带 Cookie 等凭据")
+	corsMaxAge := flag.Int("cors-max-age", 600, "跨域预检结果缓存时间（秒）")
 	flag.Parse()
 
+	// 创建跨域处理
+	corsConfig := cors.Config{
+		MaxAge:           *corsMaxAge,
+		AllowCredentials: *corsCredentials,
+	}
+	for _, origin := range strings.Split(*corsOrigins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			corsConfig.AllowedOrigins = append(corsConfig.AllowedOrigins, origin)
+		}
+	}
+	corsHandler, err := cors.New(corsConfig)
+	if err != nil {
+		log.Fatalf("跨域配置无效: %v", err)
+	}
+
 	// 设置 Gin 模式
 	if *mode == "release" {
 		gin.SetMode(gin.ReleaseMode)
@@ -24,16 +44,10 @@ func main() {
 
 	// 跨域中间件
 	r.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
-
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
+		if corsHandler.Handle(c.Writer, c.Request) {
+			c.Abort()
 			return
 		}
-
 		c.Next()
 	})
 
@@ -1,11 +1,13 @@
 import React, { useEffect, useState } from 'react';
 import { useParams, useNavigate } from 'react-router-dom';
-import { Card, Descriptions, Button, Tabs, Statistic, Row, Col, Tag, Space, message } from 'antd';
+import { Card, Descriptions, Button, Tabs, Statistic, Row, Col, Tag, Space, Modal, Form, Input, Select, InputNumber, message } from 'antd';
 import { ArrowLeftOutlined, ReloadOutlined, EditOutlined, PlayCircleOutlined, PauseCircleOutlined } from '@ant-design/icons';
 import axios from 'axios';
 import { API_URL } from '../config';
+import { updateWithRevision } from '../utils/revision';
 
 const { TabPane } = Tabs;
+const { Option } = Select;
 
 interface App {
   id: string;
@@ -18,6 +20,7 @@ interface App {
   status: 'running' | 'stopped' | 'error';
   description: string;
   deviceId: string;
+  revision: number;
 }
 
 interface Stats {
@@ -33,6 +36,8 @@ const AppDetail: React.FC = () => {
   const [app, setApp] = useState<App | null>(null);
   const [stats, setStats] = useState<Stats | null>(null);
   const [loading, setLoading] = useState(false);
+  const [editVisible, setEditVisible] = useState(false);
+  const [form] = Form.useForm();
 
   const fetchApp = async () => {
     if (!id) return;
@@ -100,6 +105,21 @@ const AppDetail: React.FC = () => {
     }
   };
 
+  const handleUpdateApp = async (values: any) => {
+    if (!app) return;
+
+    try {
+      const { data, conflict } = await updateWithRevision<App>(`${API_URL}/apps/${id}`, app, values);
+      setApp(data);
+      // 冲突时保留编辑框，用户基于最新内容确认后重新提交
+      if (conflict) return;
+      message.success('更新应用成功');
+      setEditVisible(false);
+    } catch (error: any) {
+      message.error(error.response?.data?.error || '更新应用失败');
+    }
+  };
+
   const formatBytes = (bytes: number) => {
     if (bytes === 0) return '0 B';
     const k = 1024;
@@ -173,7 +193,18 @@ const AppDetail: React.FC = () => {
             )}
             <Button 
               icon={<EditOutlined />} 
-              onClick={() => message.info('编辑功能尚未实现')}
+              onClick={() => {
+                form.setFieldsValue({
+                  name: app.name,
+                  protocol: app.protocol,
+                  srcPort: app.srcPort,
+                  peerNode: app.peerNode,
+                  dstPort: app.dstPort,
+                  dstHost: app.dstHost,
+                  description: app.description,
+                });
+                setEditVisible(true);
+              }}
             >
               编辑
             </Button>
@@ -252,6 +283,83 @@ const AppDetail: React.FC = () => {
           </TabPane>
         </Tabs>
       </Card>
+
+      <Modal
+        title="编辑应用"
+        visible={editVisible}
+        onCancel={() => setEditVisible(false)}
+        footer={null}
+      >
+        <Form
+          form={form}
+          layout="vertical"
+          onFinish={handleUpdateApp}
+        >
+          <Form.Item
+            name="name"
+            label="应用名称"
+            rules={[{ required: true, message: '请输入应用名称' }]}
+            extra={`当前值：${app.name}`}
+          >
+            <Input placeholder="请输入应用名称" />
+          </Form.Item>
+          <Form.Item
+            name="protocol"
+            label="协议"
+            rules={[{ required: true, message: '请选择协议' }]}
+            extra={`当前值：${app.protocol.toUpperCase()}`}
+          >
+            <Select placeholder="请选择协议">
+              <Option value="tcp">TCP</Option>
+              <Option value="udp">UDP</Option>
+            </Select>
+          </Form.Item>
+          <Form.Item
+            name="srcPort"
+            label="本地端口"
+            rules={[{ required: true, message: '请输入本地端口' }]}
+            extra={`当前值：${app.srcPort}`}
+          >
+            <InputNumber min={1} max={65535} style={{ width: '100%' }} placeholder="请输入本地端口" />
+          </Form.Item>
+          <Form.Item
+            name="peerNode"
+            label="目标节点"
+            rules={[{ required: true, message: '请输入目标节点' }]}
+            extra={`当前值：${app.peerNode}`}
+          >
+            <Input placeholder="请输入目标节点" />
+          </Form.Item>
+          <Form.Item
+            name="dstPort"
+            label="目标端口"
+            rules={[{ required: true, message: '请输入目标端口' }]}
+            extra={`当前值：${app.dstPort}`}
+          >
+            <InputNumber min={1} max={65535} style={{ width: '100%' }} placeholder="请输入目标端口" />
+          </Form.Item>
+          <Form.Item
+            name="dstHost"
+            label="目标主机"
+            rules={[{ required: true, message: '请输入目标主机' }]}
+            extra={`当前值：${app.dstHost}`}
+          >
+            <Input placeholder="请输入目标主机，如 localhost 或 192.168.1.5" />
+          </Form.Item>
+          <Form.Item
+            name="description"
+            label="描述"
+            extra={`当前值：${app.description || '无'}`}
+          >
+            <Input.TextArea placeholder="请输入应用描述" />
+          </Form.Item>
+          <Form.Item>
+            <Button type="primary" htmlType="submit" block>
+              保存
+            </Button>
+          </Form.Item>
+        </Form>
+      </Modal>
     </div>
   );
 };
@@ -38,6 +38,15 @@ interface SystemStatus {
   };
 }
 
+interface ClientVersionSummary {
+  minVersion: string;
+  recommendedVersion: string;
+  total: number;
+  outdated: number;
+  unsupported: number;
+  versions: Record<string, number>;
+}
+
 const Dashboard: React.FC = () => {
   const dispatch = useDispatch();
   const navigate = useNavigate();
@@ -45,6 +54,7 @@ const Dashboard: React.FC = () => {
   const { apps } = useSelector((state: RootState) => state.app);
   const { forwards } = useSelector((state: RootState) => state.forward);
   const [status, setStatus] = useState<SystemStatus | null>(null);
+  const [clientVersions, setClientVersions] = useState<ClientVersionSummary | null>(null);
   const [loading, setLoading] = useState(false);
 
   const fetchData = async () => {
@@ -64,6 +74,17 @@ const Dashboard: React.FC = () => {
     } finally {
       setLoading(false);
     }
+
+    // 客户端版本统计仅管理员可见，无权限时不显示
+    try {
+      const token = localStorage.getItem('token');
+      const response = await axios.get(`${API_URL}/clients/versions`, {
+        headers: { Authorization: `Bearer ${token}` }
+      });
+      setClientVersions(response.data);
+    } catch (error: any) {
+      setClientVersions(null);
+    }
   };
 
   useEffect(() => {
@@ -259,6 +280,17 @@ const Dashboard: React.FC = () => {
             <p><strong>运行时间：</strong> {status ? formatTime(status.uptime) : '未知'}</p>
             <p><strong>总发送流量：</strong> {status ? formatBytes(status.traffic.sent) : '0 B'}</p>
             <p><strong>总接收流量：</strong> {status ? formatBytes(status.traffic.received) : '0 B'}</p>
+            {clientVersions && (
+              <p>
+                <strong>过旧客户端：</strong>{' '}
+                <Tag color={clientVersions.unsupported > 0 ? 'error' : 'default'}>
+                  {`${clientVersions.unsupported} 个低于最低版本 ${clientVersions.minVersion || '-'}`}
+                </Tag>
+                <Tag color={clientVersions.outdated > 0 ? 'warning' : 'default'}>
+                  {`${clientVersions.outdated} 个低于推荐版本 ${clientVersions.recommendedVersion || '-'}`}
+                </Tag>
+              </p>
+            )}
           </Card>
         </Col>
         <Col span={12}>
@@ -1,9 +1,10 @@
 import React, { useEffect, useState } from 'react';
 import { useParams, useNavigate } from 'react-router-dom';
-import { Card, Descriptions, Button, Tabs, Table, Tag, Space, Statistic, Row, Col, message } from 'antd';
+import { Card, Descriptions, Button, Tabs, Table, Tag, Space, Statistic, Row, Col, Modal, Form, Input, message } from 'antd';
 import { ArrowLeftOutlined, ReloadOutlined, EditOutlined, PlusOutlined } from '@ant-design/icons';
 import axios from 'axios';
 import { API_URL } from '../config';
+import { updateWithRevision } from '../utils/revision';
 
 const { TabPane } = Tabs;
 
@@ -19,6 +20,7 @@ interface Device {
   os: string;
   arch: string;
   lastSeenAt: string;
+  revision: number;
 }
 
 interface App {
@@ -48,6 +50,8 @@ const DeviceDetail: React.FC = () => {
   const [stats, setStats] = useState<Stats | null>(null);
   const [loading, setLoading] = useState(false);
   const [appsLoading, setAppsLoading] = useState(false);
+  const [editVisible, setEditVisible] = useState(false);
+  const [form] = Form.useForm();
 
   const fetchDevice = async () => {
     if (!id) return;
@@ -103,6 +107,21 @@ const DeviceDetail: React.FC = () => {
     fetchStats();
   }, [id]);
 
+  const handleUpdateDevice = async (values: any) => {
+    if (!device) return;
+
+    try {
+      const { data, conflict } = await updateWithRevision<Device>(`${API_URL}/devices/${id}`, device, values);
+      setDevice(data);
+      // 冲突时保留编辑框，用户基于最新内容确认后重新提交
+      if (conflict) return;
+      message.success('更新设备成功');
+      setEditVisible(false);
+    } catch (error: any) {
+      message.error(error.response?.data?.error || '更新设备失败');
+    }
+  };
+
   const formatBytes = (bytes: number) => {
     if (bytes === 0) return '0 B';
     const k = 1024;
@@ -217,7 +236,10 @@ const DeviceDetail: React.FC = () => {
             <Button 
               type="primary" 
               icon={<EditOutlined />} 
-              onClick={() => message.info('编辑功能尚未实现')}
+              onClick={() => {
+                form.setFieldsValue({ name: device.name });
+                setEditVisible(true);
+              }}
             >
               编辑
             </Button>
@@ -305,6 +327,33 @@ const DeviceDetail: React.FC = () => {
           </TabPane>
         </Tabs>
       </Card>
+
+      <Modal
+        title="编辑设备"
+        visible={editVisible}
+        onCancel={() => setEditVisible(false)}
+        footer={null}
+      >
+        <Form
+          form={form}
+          layout="vertical"
+          onFinish={handleUpdateDevice}
+        >
+          <Form.Item
+            name="name"
+            label="设备名称"
+            rules={[{ required: true, message: '请输入设备名称' }]}
+            extra={`当前名称：${device.name}`}
+          >
+            <Input placeholder="请输入设备名称" />
+          </Form.Item>
+          <Form.Item>
+            <Button type="primary" htmlType="submit" block>
+              保存
+            </Button>
+          </Form.Item>
+        </Form>
+      </Modal>
     </div>
   );
 };
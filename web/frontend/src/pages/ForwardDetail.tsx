@@ -1,12 +1,14 @@
 import React, { useEffect, useState } from 'react';
 import { useParams, useNavigate } from 'react-router-dom';
-import { Card, Descriptions, Button, Tabs, Statistic, Row, Col, Tag, Space, message } from 'antd';
+import { Card, Descriptions, Button, Tabs, Statistic, Row, Col, Tag, Space, Modal, Form, Input, Select, InputNumber, message } from 'antd';
 import { ArrowLeftOutlined, ReloadOutlined, EditOutlined, PlayCircleOutlined, PauseCircleOutlined } from '@ant-design/icons';
 import axios from 'axios';
 import { API_URL } from '../config';
 import ReactECharts from 'echarts-for-react';
+import { updateWithRevision } from '../utils/revision';
 
 const { TabPane } = Tabs;
+const { Option } = Select;
 
 interface Forward {
   id: string;
@@ -18,6 +20,7 @@ interface Forward {
   enabled: boolean;
   createdAt: string;
   updatedAt: string;
+  revision: number;
   stats: {
     bytesSent: number;
     bytesReceived: number;
@@ -31,6 +34,8 @@ const ForwardDetail: React.FC = () => {
   const navigate = useNavigate();
   const [forward, setForward] = useState<Forward | null>(null);
   const [loading, setLoading] = useState(false);
+  const [editVisible, setEditVisible] = useState(false);
+  const [form] = Form.useForm();
 
   const fetchForward = async () => {
     if (!id) return;
@@ -83,6 +88,22 @@ const ForwardDetail: React.FC = () => {
     }
   };
 
+  const handleUpdateForward = async (values: any) => {
+    if (!forward) return;
+
+    try {
+      const { data, conflict } = await updateWithRevision<Forward>(`${API_URL}/forwards/${id}`, forward, values);
+      // 更新接口不返回统计信息，保留当前的统计
+      setForward({ ...data, stats: data.stats || forward.stats });
+      // 冲突时保留编辑框，用户基于最新内容确认后重新提交
+      if (conflict) return;
+      message.success('更新转发规则成功');
+      setEditVisible(false);
+    } catch (error: any) {
+      message.error(error.response?.data?.error || '更新转发规则失败');
+    }
+  };
+
   const formatBytes = (bytes: number) => {
     if (bytes === 0) return '0 B';
     const k = 1024;
@@ -172,7 +193,16 @@ const ForwardDetail: React.FC = () => {
             )}
             <Button 
               icon={<EditOutlined />} 
-              onClick={() => message.info('编辑功能尚未实现')}
+              onClick={() => {
+                form.setFieldsValue({
+                  protocol: forward.protocol,
+                  srcPort: forward.srcPort,
+                  dstHost: forward.dstHost,
+                  dstPort: forward.dstPort,
+                  description: forward.description,
+                });
+                setEditVisible(true);
+              }}
             >
               编辑
             </Button>
@@ -252,6 +282,67 @@ const ForwardDetail: React.FC = () => {
           </TabPane>
         </Tabs>
       </Card>
+
+      <Modal
+        title="编辑转发规则"
+        visible={editVisible}
+        onCancel={() => setEditVisible(false)}
+        footer={null}
+      >
+        <Form
+          form={form}
+          layout="vertical"
+          onFinish={handleUpdateForward}
+        >
+          <Form.Item
+            name="protocol"
+            label="协议"
+            rules={[{ required: true, message: '请选择协议' }]}
+            extra={`当前值：${forward.protocol.toUpperCase()}`}
+          >
+            <Select placeholder="请选择协议">
+              <Option value="tcp">TCP</Option>
+              <Option value="udp">UDP</Option>
+            </Select>
+          </Form.Item>
+          <Form.Item
+            name="srcPort"
+            label="源端口"
+            rules={[{ required: true, message: '请输入源端口' }]}
+            extra={`当前值：${forward.srcPort}`}
+          >
+            <InputNumber min={1} max={65535} style={{ width: '100%' }} placeholder="请输入源端口" />
+          </Form.Item>
+          <Form.Item
+            name="dstHost"
+            label="目标主机"
+            rules={[{ required: true, message: '请输入目标主机' }]}
+            extra={`当前值：${forward.dstHost}`}
+          >
+            <Input placeholder="请输入目标主机，如 localhost 或 192.168.1.5" />
+          </Form.Item>
+          <Form.Item
+            name="dstPort"
+            label="目标端口"
+            rules={[{ required: true, message: '请输入目标端口' }]}
+            extra={`当前值：${forward.dstPort}`}
+          >
+            <InputNumber min={1} max={65535} style={{ width: '100%' }} placeholder="请输入目标端口" />
+          </Form.Item>
+          <Form.Item
+            name="description"
+            label="描述"
+            extra={`当前值：${forward.description || '无'}`}
+          >
+            <Input.TextArea placeholder="请输入转发规则描述" />
+          </Form.Item>
+          <Form.Item>
+            <Button type="primary" htmlType="submit" block>
+              保存
+            </Button>
+          </Form.Item>
+        </Form>
+      </Modal>
     </div>
   );
 };
@@ -0,0 +1,37 @@
+import axios from 'axios';
+import { message } from 'antd';
+
+// 带修订号的资源
+export interface Revisioned {
+  revision: number;
+}
+
+export interface RevisionedUpdateResult<T> {
+  data: T;
+  conflict: boolean;
+}
+
+// 携带修订号更新资源，资源已被他人修改时返回服务器上的最新内容
+export async function updateWithRevision<T extends Revisioned>(
+  url: string,
+  resource: T,
+  values: Partial<T>
+): Promise<RevisionedUpdateResult<T>> {
+  const token = localStorage.getItem('token');
+  try {
+    const response = await axios.put(url, values, {
+      headers: {
+        Authorization: `Bearer ${token}`,
+        'If-Match': `"${resource.revision}"`,
+      },
+    });
+    return { data: response.data, conflict: false };
+  } catch (error: any) {
+    // 端口冲突等其他 409 错误不包含 current 字段
+    if (error.response?.status === 409 && error.response.data?.current) {
+      message.warning('该资源已被其他用户修改，已加载最新内容，请确认后重新提交');
+      return { data: error.response.data.current, conflict: true };
+    }
+    throw error;
+  }
+}